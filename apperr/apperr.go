@@ -0,0 +1,68 @@
+// Package apperr has the kinds of errors shared by the layers of the app, so storage can tell what went wrong,
+// and the web handlers and the job runner can act on it, without matching error messages or database error codes.
+//
+// Check for a kind with errors.Is, which works through wrapping with fmt.Errorf and %w.
+// The web handlers respond to each kind with its own status code, and the job runner retries jobs
+// that failed because something was unavailable, and dead-letters the ones that failed on invalid data.
+package apperr
+
+import (
+	"errors"
+)
+
+// Kinds of errors.
+var (
+	// NotFound is for things that don't exist.
+	NotFound = errors.New("not found")
+	// Conflict is for changes that clash with the current state, like a duplicate, or a change to something
+	// that changed in the meantime.
+	Conflict = errors.New("conflict")
+	// Invalid is for input or data that can't be used, which trying again doesn't fix.
+	Invalid = errors.New("invalid")
+	// Unavailable is for something that can't be reached or is overloaded right now, like the database,
+	// which trying again later may fix.
+	Unavailable = errors.New("unavailable")
+	// Forbidden is for things that aren't allowed.
+	Forbidden = errors.New("forbidden")
+)
+
+// kinds in the order KindOf checks them.
+var kinds = []error{NotFound, Conflict, Invalid, Unavailable, Forbidden}
+
+// Error of a kind, caused by Err. Its message is the one of Err.
+// errors.Is matches both the kind and Err, and errors.As finds the types in Err.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is the kind of the error target.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Wrap err as the kind. A nil err stays nil.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf err, which is the kind it is or wraps, or nil if it has none.
+func KindOf(err error) error {
+	for _, kind := range kinds {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	return nil
+}
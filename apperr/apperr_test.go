@@ -0,0 +1,58 @@
+package apperr_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+)
+
+func TestWrap(t *testing.T) {
+	t.Run("matches the kind and the cause through wrapping", func(t *testing.T) {
+		is := is.New(t)
+
+		cause := &fs.PathError{Op: "open", Path: "nope", Err: errors.New("oh no")}
+		err := fmt.Errorf("error doing the thing: %w", apperr.Wrap(apperr.Unavailable, cause))
+		is.Equal("error doing the thing: open nope: oh no", err.Error())
+		is.True(errors.Is(err, apperr.Unavailable))
+		is.True(!errors.Is(err, apperr.NotFound))
+		is.True(errors.Is(err, cause))
+
+		var pathErr *fs.PathError
+		is.True(errors.As(err, &pathErr))
+		is.Equal("nope", pathErr.Path)
+
+		var appErr *apperr.Error
+		is.True(errors.As(err, &appErr))
+		is.Equal(apperr.Unavailable, appErr.Kind)
+	})
+
+	t.Run("keeps nil errors nil", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(apperr.Wrap(apperr.Invalid, nil))
+	})
+}
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"a kind itself", apperr.NotFound, apperr.NotFound},
+		{"a wrapped kind", fmt.Errorf("error getting: %w", apperr.Conflict), apperr.Conflict},
+		{"a wrapped error of a kind", fmt.Errorf("error getting: %w", apperr.Wrap(apperr.Forbidden, errors.New("nope"))), apperr.Forbidden},
+		{"an error without a kind", errors.New("oh no"), nil},
+		{"no error", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.kind, apperr.KindOf(test.err))
+		})
+	}
+}
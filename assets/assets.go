@@ -0,0 +1,111 @@
+// Package assets has the static files of the app, like the stylesheet and favicon, embedded in the binary.
+//
+// Each file gets a URL with a hash of its content in the name, such as /static/app.1a2b3c4d.css,
+// so browsers can cache it forever and still get the new version right after a deploy.
+package assets
+
+import (
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+//go:embed static
+var embedded embed.FS
+
+// PathPrefix of the asset URLs.
+const PathPrefix = "/static/"
+
+// Assets with their content hashes, computed once in New.
+type Assets struct {
+	files  map[string]file
+	hashed map[string]string
+}
+
+type file struct {
+	content    []byte
+	hash       string
+	hashedName string
+}
+
+// New Assets from all files in fsys.
+func New(fsys fs.FS) (*Assets, error) {
+	a := &Assets{files: map[string]file{}, hashed: map[string]string{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		hash := fmt.Sprintf("%x", sum[:4])
+		hashedName := hashName(name, hash)
+		a.files[name] = file{content: content, hash: hash, hashedName: hashedName}
+		a.hashed[hashedName] = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading assets: %w", err)
+	}
+	return a, nil
+}
+
+// hashName puts the hash before the extension, like "app.css" to "app.1a2b3c4d.css".
+func hashName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// URL of the asset with the given name, with its content hash.
+// Names without an asset get the URL without a hash, which is not found.
+func (a *Assets) URL(name string) string {
+	if f, ok := a.files[name]; ok {
+		return PathPrefix + f.hashedName
+	}
+	return PathPrefix + name
+}
+
+// Get the asset with the hashed name from URL, returning its content and hash.
+func (a *Assets) Get(hashedName string) ([]byte, string, bool) {
+	name, ok := a.hashed[hashedName]
+	if !ok {
+		return nil, "", false
+	}
+	f := a.files[name]
+	return f.content, f.hash, true
+}
+
+// Has an asset with the name, without a hash.
+func (a *Assets) Has(name string) bool {
+	_, ok := a.files[name]
+	return ok
+}
+
+var defaultAssets = mustNewEmbedded()
+
+func mustNewEmbedded() *Assets {
+	fsys, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	a, err := New(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Default Assets, embedded from the static directory.
+func Default() *Assets {
+	return defaultAssets
+}
+
+// URL of the default asset with the given name. See Assets.URL.
+func URL(name string) string {
+	return defaultAssets.URL(name)
+}
@@ -0,0 +1,59 @@
+package assets_test
+
+import (
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+
+	"canvas/assets"
+)
+
+func TestAssets_URL(t *testing.T) {
+	t.Run("puts the content hash before the extension", func(t *testing.T) {
+		is := is.New(t)
+
+		a, err := assets.New(fstest.MapFS{
+			"app.css":      {Data: []byte("body {}")},
+			"img/logo.svg": {Data: []byte("<svg></svg>")},
+			"LICENSE":      {Data: []byte("MIT")},
+		})
+		is.NoErr(err)
+
+		is.True(regexp.MustCompile(`^/static/app\.[0-9a-f]{8}\.css$`).MatchString(a.URL("app.css")))
+		is.True(regexp.MustCompile(`^/static/img/logo\.[0-9a-f]{8}\.svg$`).MatchString(a.URL("img/logo.svg")))
+		is.True(regexp.MustCompile(`^/static/LICENSE\.[0-9a-f]{8}$`).MatchString(a.URL("LICENSE")))
+	})
+
+	t.Run("keeps the hash for the same content, and changes it for different content", func(t *testing.T) {
+		is := is.New(t)
+
+		a1, err := assets.New(fstest.MapFS{"app.css": {Data: []byte("body {}")}})
+		is.NoErr(err)
+		a2, err := assets.New(fstest.MapFS{"app.css": {Data: []byte("body {}")}})
+		is.NoErr(err)
+		a3, err := assets.New(fstest.MapFS{"app.css": {Data: []byte("body { color: red; }")}})
+		is.NoErr(err)
+
+		is.Equal(a1.URL("app.css"), a2.URL("app.css"))
+		is.True(a1.URL("app.css") != a3.URL("app.css"))
+	})
+
+	t.Run("returns the path without hash for unknown names", func(t *testing.T) {
+		is := is.New(t)
+
+		a, err := assets.New(fstest.MapFS{})
+		is.NoErr(err)
+		is.Equal("/static/doesnotexist.css", a.URL("doesnotexist.css"))
+	})
+
+	t.Run("has the embedded assets by default", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, name := range []string{"app.css", "app.js", "favicon.ico", "favicon.svg"} {
+			is.True(assets.Default().Has(name))
+			is.Equal(assets.Default().URL(name), assets.URL(name))
+		}
+	})
+}
@@ -0,0 +1,18 @@
+// Remove flash messages a while after the page loads, or when their close button is clicked.
+document.querySelectorAll("[data-flash]").forEach(function (el) {
+  function dismiss() {
+    el.classList.add("dismissed");
+    setTimeout(function () { el.remove(); }, 300);
+  }
+  el.querySelector("button").addEventListener("click", dismiss);
+  setTimeout(dismiss, 8000);
+});
+
+// Ask before submitting forms with a data-confirm message, like for destructive admin actions.
+document.querySelectorAll("form[data-confirm]").forEach(function (form) {
+  form.addEventListener("submit", function (e) {
+    if (!window.confirm(form.dataset.confirm)) {
+      e.preventDefault();
+    }
+  });
+});
@@ -0,0 +1,93 @@
+// Package build has information about the running build, so it's clear exactly what's deployed.
+//
+// The version, commit, and date are injected at build time with -ldflags, such as:
+//
+//	go build -ldflags="-X 'canvas/build.version=v1.2.3' -X 'canvas/build.commit=abc123' -X 'canvas/build.date=2022-12-10T12:00:00Z'"
+//
+// Values that weren't injected fall back to what the Go toolchain recorded in the binary.
+package build
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Injected with -ldflags.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+const unknown = "unknown"
+
+// Info about a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"goVersion"`
+}
+
+var (
+	info     Info
+	infoOnce sync.Once
+)
+
+// Get the Info of the running binary. It's only read once.
+func Get() Info {
+	infoOnce.Do(func() {
+		bi, _ := debug.ReadBuildInfo()
+		info = NewInfo(version, commit, date, bi)
+	})
+	return info
+}
+
+// NewInfo from the values injected with -ldflags. Empty values fall back to the module version and
+// version control settings in bi, and then to "unknown". bi can be nil, such as when it couldn't be read.
+func NewInfo(version, commit, date string, bi *debug.BuildInfo) Info {
+	i := Info{Version: version, Commit: commit, Date: date, GoVersion: runtime.Version()}
+	if bi != nil {
+		if i.Version == "" {
+			i.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && i.Commit == "":
+				i.Commit = s.Value
+			case s.Key == "vcs.time" && i.Date == "":
+				i.Date = s.Value
+			}
+		}
+		if bi.GoVersion != "" {
+			i.GoVersion = bi.GoVersion
+		}
+	}
+
+	for _, v := range []*string{&i.Version, &i.Commit, &i.Date} {
+		if *v == "" {
+			*v = unknown
+		}
+	}
+	return i
+}
+
+// MarshalLogObject satisfies zapcore.ObjectMarshaler, for logging with zap.Object.
+func (i Info) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("version", i.Version)
+	enc.AddString("commit", i.Commit)
+	enc.AddString("date", i.Date)
+	enc.AddString("goVersion", i.GoVersion)
+	return nil
+}
+
+// ShortCommit is the first eight characters of the commit, like from git rev-parse --short=8.
+func (i Info) ShortCommit() string {
+	if len(i.Commit) > 8 {
+		return i.Commit[:8]
+	}
+	return i.Commit
+}
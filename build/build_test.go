@@ -0,0 +1,44 @@
+package build_test
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/build"
+)
+
+func TestNewInfo(t *testing.T) {
+	bi := &debug.BuildInfo{
+		GoVersion: "go1.18.9",
+		Main:      debug.Module{Version: "(devel)"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2022-12-10T12:00:00Z"},
+		},
+	}
+
+	t.Run("uses the injected values", func(t *testing.T) {
+		is := is.New(t)
+
+		i := build.NewInfo("v1.2.3", "abc123", "2022-12-11T12:00:00Z", bi)
+		is.Equal(build.Info{Version: "v1.2.3", Commit: "abc123", Date: "2022-12-11T12:00:00Z", GoVersion: "go1.18.9"}, i)
+	})
+
+	t.Run("falls back to the build info from the toolchain", func(t *testing.T) {
+		is := is.New(t)
+
+		i := build.NewInfo("", "", "", bi)
+		is.Equal(build.Info{Version: "(devel)", Commit: "0123456789abcdef", Date: "2022-12-10T12:00:00Z", GoVersion: "go1.18.9"}, i)
+		is.Equal("01234567", i.ShortCommit())
+	})
+
+	t.Run("is unknown without injected values or build info", func(t *testing.T) {
+		is := is.New(t)
+
+		i := build.NewInfo("", "", "", nil)
+		is.Equal(build.Info{Version: "unknown", Commit: "unknown", Date: "unknown", GoVersion: runtime.Version()}, i)
+	})
+}
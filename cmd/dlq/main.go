@@ -0,0 +1,123 @@
+// Package main is a tool for working with the dead-letter queue.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/maragudk/env"
+	"go.uber.org/zap"
+
+	"canvas/config"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+func main() {
+	os.Exit(start())
+}
+
+func start() int {
+	dotenv, dotenvErr := config.LoadDotenv("")
+
+	logEnv := env.GetStringOrDefault("LOG_ENV", "development")
+	log, err := createLogger(logEnv)
+	if err != nil {
+		fmt.Println("Error setting up the logger:", err)
+		return 1
+	}
+	if dotenvErr != nil {
+		log.Error("Error loading dotenv files", zap.Error(dotenvErr))
+		return 1
+	}
+	if len(dotenv.Files) > 0 {
+		log.Info("Loaded dotenv files", zap.Strings("paths", dotenv.Files))
+	}
+
+	if len(os.Args) < 2 || os.Args[1] != "replay" {
+		log.Warn("Usage: dlq replay [-job name] [-min-age duration] [-max-age duration] [-where field=value] [-limit n]")
+		return 1
+	}
+
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	job := flags.String("job", "", "replay only messages for this job")
+	minAge := flags.Duration("min-age", 0, "replay only messages sent at least this long ago")
+	maxAge := flags.Duration("max-age", 0, "replay only messages sent at most this long ago")
+	where := flags.String("where", "", "replay only messages with this field value, as field=value")
+	limit := flags.Int("limit", 0, "replay at most this many messages")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		return 1
+	}
+
+	var match func(m model.Message) bool
+	if *where != "" {
+		field, value, ok := strings.Cut(*where, "=")
+		if !ok {
+			log.Error("Invalid -where, must be field=value", zap.String("where", *where))
+			return 1
+		}
+		match = func(m model.Message) bool {
+			return m[field] == value
+		}
+	}
+
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Error("Error creating AWS config", zap.Error(err))
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	endpointURL := env.GetStringOrDefault("SQS_ENDPOINT_URL", "")
+	result, err := messaging.ReplayDLQ(ctx, messaging.ReplayDLQOptions{
+		DeadLetterQueue: messaging.NewQueue(messaging.NewQueueOptions{
+			Config:      awsConfig,
+			EndpointURL: endpointURL,
+			Log:         log,
+			Name:        env.GetStringOrDefault("DEAD_LETTER_QUEUE_NAME", "jobs-dead-letter"),
+			WaitTime:    time.Second,
+		}),
+		Job:    *job,
+		Limit:  *limit,
+		Log:    log,
+		Match:  match,
+		MaxAge: *maxAge,
+		MinAge: *minAge,
+		Queue: messaging.NewQueue(messaging.NewQueueOptions{
+			Config:      awsConfig,
+			EndpointURL: endpointURL,
+			Log:         log,
+			Name:        env.GetStringOrDefault("QUEUE_NAME", "jobs"),
+		}),
+	})
+	if err != nil {
+		log.Error("Error replaying dead-letter queue", zap.Error(err))
+		return 1
+	}
+
+	fmt.Printf("Replayed %v, skipped %v, failed %v\n", result.Replayed, result.Skipped, result.Failed)
+	if result.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func createLogger(env string) (*zap.Logger, error) {
+	switch env {
+	case "production":
+		return zap.NewProduction()
+	case "development":
+		return zap.NewDevelopment()
+	default:
+		return zap.NewNop(), nil
+	}
+}
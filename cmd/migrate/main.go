@@ -9,6 +9,7 @@ import (
 	"github.com/maragudk/migrate"
 	"go.uber.org/zap"
 
+	"canvas/config"
 	"canvas/storage"
 )
 
@@ -17,7 +18,7 @@ func main() {
 }
 
 func start() int {
-	_ = env.Load()
+	dotenv, dotenvErr := config.LoadDotenv("")
 
 	logEnv := env.GetStringOrDefault("LOG_ENV", "development")
 	log, err := createLogger(logEnv)
@@ -25,6 +26,13 @@ func start() int {
 		fmt.Println("Error setting up the logger:", err)
 		return 1
 	}
+	if dotenvErr != nil {
+		log.Error("Error loading dotenv files", zap.Error(dotenvErr))
+		return 1
+	}
+	if len(dotenv.Files) > 0 {
+		log.Info("Loaded dotenv files", zap.Strings("paths", dotenv.Files))
+	}
 
 	if len(os.Args) < 2 {
 		log.Warn("Usage: migrate up|down|to")
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithylogging "github.com/aws/smithy-go/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/time/rate"
+
+	"canvas/build"
+	"canvas/config"
+	"canvas/email"
+	"canvas/errorreport"
+	"canvas/flags"
+	"canvas/handlers"
+	"canvas/i18n"
+	"canvas/jobs"
+	"canvas/logging"
+	"canvas/messaging"
+	"canvas/metrics"
+	"canvas/secrets"
+	"canvas/server"
+	"canvas/storage"
+	"canvas/tracing"
+	"canvas/webhook"
+)
+
+// app has the setup that the commands share: the configuration, and the logger with its level.
+type app struct {
+	config config.Config
+	log    *zap.Logger
+	level  zap.AtomicLevel
+	// loaded is how long loading the configuration and setting up the logger took, the first phase of startup.
+	loaded time.Duration
+}
+
+// setup the app by loading the configuration, checking it with validate, and creating the logger.
+// Problems are logged together, and make the exit code non-zero.
+func setup(validate func(config.Config) error) (*app, int) {
+	start := time.Now()
+	cfg, dotenv, err := loadConfig()
+	if err == nil {
+		err = validate(cfg)
+	}
+	if err != nil {
+		reportConfigError(cfg.Log.Env, err)
+		return nil, exitError
+	}
+
+	log, level, err := createLogger(cfg.Log, logFields(cfg)...)
+	if err != nil {
+		fmt.Println("Error setting up logger:", err)
+		return nil, exitError
+	}
+	// For code logging outside of a request or job, with logging.FromContext.
+	logging.SetGlobal(log)
+
+	log.Info("Build info", zap.Object("build", build.Get()))
+	if len(dotenv.Files) > 0 {
+		log.Info("Loaded dotenv files", zap.Strings("paths", dotenv.Files))
+	}
+	for _, path := range dotenv.Files {
+		if keys := dotenv.SensitiveKeys[path]; len(keys) > 0 {
+			log.Warn("Secrets in dotenv file, which is usually committed, belong in .env.local or the environment",
+				zap.String("path", path), zap.Strings("keys", keys))
+		}
+	}
+	if cfg.File() != "" {
+		log.Info("Read configuration file", zap.String("path", cfg.File()))
+	}
+	if len(cfg.UnknownKeys()) > 0 {
+		log.Warn("Unknown keys in configuration file are ignored", zap.String("path", cfg.File()),
+			zap.Strings("keys", cfg.UnknownKeys()))
+	}
+	log.Info("Configuration", settingsField(cfg.Settings()))
+
+	return &app{config: cfg, log: log, level: level, loaded: time.Since(start)}, exitOK
+}
+
+// loadConfig from the dotenv files, the configuration file, and the environment, with references to secrets resolved.
+// It also returns the dotenv files that were loaded, for logging once there's a logger.
+func loadConfig() (config.Config, config.Dotenv, error) {
+	dotenv, err := config.LoadDotenv("")
+	if err != nil {
+		return config.Load(), dotenv, err
+	}
+
+	cfg := config.Load()
+	if cfg.HasSecretReferences() {
+		if err := resolveSecrets(&cfg); err != nil {
+			return cfg, dotenv, err
+		}
+	}
+	return cfg, dotenv, nil
+}
+
+// reportConfigError as one structured error with all the problems, since the logger from the configuration
+// can't be trusted yet. It's JSON for LOG_ENV=production, and for people otherwise, even for LOG_ENV=nop.
+func reportConfigError(env string, err error) {
+	create := zap.NewDevelopment
+	if strings.EqualFold(env, "production") {
+		create = zap.NewProduction
+	}
+	log, logErr := create(zap.AddStacktrace(zapcore.FatalLevel))
+	if logErr != nil {
+		fmt.Println("Invalid configuration:", err)
+		return
+	}
+	defer func() {
+		_ = log.Sync()
+	}()
+	logConfigError(log, err)
+}
+
+func logConfigError(log *zap.Logger, err error) {
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		log.Error("Invalid configuration", zap.Strings("problems", verr.Problems))
+		return
+	}
+	log.Error("Error reading configuration", zap.Error(err))
+}
+
+// resolveSecrets referenced in the configuration, from AWS Secrets Manager and SSM Parameter Store.
+// The AWS config is set up like for the commands, but without logging, because the logger isn't set up yet.
+func resolveSecrets(cfg *config.Config) error {
+	awsConfig, err := loadAWSConfig(zap.NewNop(), cfg.AWS)
+	if err != nil {
+		return fmt.Errorf("error creating AWS config for resolving secrets: %w", err)
+	}
+	return cfg.ResolveSecrets(context.Background(), config.SecretStores{
+		SecretsManager: secrets.NewSecretsManager(awsConfig, cfg.Secrets.EndpointURL),
+		ParameterStore: secrets.NewParameterStore(awsConfig, cfg.Secrets.EndpointURL),
+	})
+}
+
+// close the app, flushing the logs.
+func (a *app) close() {
+	_ = a.log.Sync()
+}
+
+// logLevel for changing the level of the logger at runtime.
+func (a *app) logLevel() *handlers.LogLevel {
+	return handlers.NewLogLevel(a.level, a.log, handlers.LogLevelOptions{
+		RevertAfter: a.config.Log.LevelRevertAfter,
+	})
+}
+
+// signalContext is done on SIGTERM or SIGINT.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+}
+
+// createLogger for the log environment in c, which is production, development, or nop, in any case, writing to stderr.
+// The level in c overrides the default level of the environment, like "debug" or "WARN", if it's not empty.
+// Invalid values are errors, so a typo in the configuration doesn't quietly change what's logged.
+// The returned atomic level changes the level of the logger at runtime. The fields are on every entry.
+func createLogger(c config.Log, fields ...zap.Field) (*zap.Logger, zap.AtomicLevel, error) {
+	return newLogger(c, zapcore.Lock(os.Stderr), fields...)
+}
+
+// newLogger like createLogger, writing to out.
+// In production, entries are JSON lines with ISO8601 timestamps, sampled like in c.
+// In both production and development, only entries from Error up have stacktraces.
+func newLogger(c config.Log, out zapcore.WriteSyncer, fields ...zap.Field) (*zap.Logger, zap.AtomicLevel, error) {
+	env := strings.ToLower(c.Env)
+	level := zap.NewAtomicLevel()
+	var encoder zapcore.Encoder
+	opts := []zap.Option{zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel), zap.ErrorOutput(out)}
+	switch env {
+	case "production":
+		ec := zap.NewProductionEncoderConfig()
+		ec.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(ec)
+	case "development":
+		level.SetLevel(zapcore.DebugLevel)
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+		opts = append(opts, zap.Development())
+	case "nop":
+	default:
+		return nil, level, fmt.Errorf("invalid log environment %q, must be production, development, or nop", c.Env)
+	}
+
+	if c.Level != "" {
+		l, err := zapcore.ParseLevel(strings.ToLower(c.Level))
+		if err != nil {
+			return nil, level, fmt.Errorf("invalid log level %q, must be debug, info, warn, error, dpanic, panic, or fatal", c.Level)
+		}
+		level.SetLevel(l)
+	}
+
+	if env == "nop" {
+		return zap.NewNop(), level, nil
+	}
+	core := zapcore.NewCore(encoder, out, level)
+	if env == "production" && c.SampleInitial > 0 {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, c.SampleInitial, c.SampleThereafter)
+	}
+	log := zap.New(core, opts...).With(fields...)
+	log.Info("Set up logger", zap.String("logEnv", env), zap.Stringer("logLevel", level))
+	return log, level, nil
+}
+
+// logFields on every entry: the service name, the environment errors are reported in if it's set, and the version.
+func logFields(c config.Config) []zap.Field {
+	fields := []zap.Field{zap.String("service", c.Tracing.ServiceName)}
+	if c.Sentry.Environment != "" {
+		fields = append(fields, zap.String("env", c.Sentry.Environment))
+	}
+	return append(fields, zap.String("version", build.Get().Version))
+}
+
+// settingsField with each setting by key, with its value and source, like {"server.port": {"value": 8080, "source": "env"}}.
+func settingsField(settings []config.Setting) zap.Field {
+	return zap.Object("settings", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		for _, s := range settings {
+			s := s
+			err := enc.AddObject(s.Key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+				enc.AddString("source", string(s.Source))
+				return enc.AddReflected("value", s.Value)
+			}))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// logger for the component, like "server" or "storage", in the component field of its entries, to filter by.
+func (a *app) logger(component string) *zap.Logger {
+	return a.log.With(zap.String("component", component))
+}
+
+// awsConfig from the default sources.
+// Endpoints for local development are set per client, like the queues in createQueue.
+func (a *app) awsConfig() (aws.Config, error) {
+	return loadAWSConfig(a.log, a.config.AWS)
+}
+
+// loadAWSConfig from the default chain of the SDK, with the region and credentials in c winning over it.
+// A missing region is an error here, instead of on the first call to AWS.
+func loadAWSConfig(log *zap.Logger, c config.AWS) (aws.Config, error) {
+	opts := append(awsLoadOptions(c), awsconfig.WithLogger(createAWSLogAdapter(log)))
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return awsConfig, err
+	}
+	if awsConfig.Region == "" {
+		return awsConfig, errors.New("no AWS region, set AWS_REGION or a region in the AWS_PROFILE profile")
+	}
+	return awsConfig, nil
+}
+
+// awsLoadOptions for awsconfig.LoadDefaultConfig, for the settings in c that are set.
+func awsLoadOptions(c config.AWS) []func(*awsconfig.LoadOptions) error {
+	var opts []func(*awsconfig.LoadOptions) error
+	if c.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(c.Profile))
+	}
+	if c.AccessKeyID != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken)))
+	}
+	return opts
+}
+
+// stsClient has the sts.Client method used by awsIdentity, so it can be faked in tests.
+type stsClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// newSTSClient from the AWS config, with the endpoint URL overriding the one of the region if it's not empty.
+func newSTSClient(awsConfig aws.Config, endpointURL string) *sts.Client {
+	return sts.NewFromConfig(awsConfig, func(o *sts.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})
+}
+
+// awsIdentity that the credentials are for, like "account 123456789012, arn:aws:sts::123456789012:assumed-role/canvas/x",
+// which doesn't have the credentials themselves.
+func awsIdentity(ctx context.Context, client stsClient) (string, error) {
+	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("account %v, %v", aws.ToString(output.Account), aws.ToString(output.Arn)), nil
+}
+
+func createAWSLogAdapter(log *zap.Logger) smithylogging.LoggerFunc {
+	return func(classification smithylogging.Classification, format string, v ...interface{}) {
+		switch classification {
+		case smithylogging.Debug:
+			log.Sugar().Debugf(format, v...)
+		case smithylogging.Warn:
+			log.Sugar().Warnf(format, v...)
+		}
+	}
+}
+
+// connectDatabase from the configuration.
+func (a *app) connectDatabase() (*storage.Database, error) {
+	db := a.database(nil)
+	if err := db.Connect(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// openDatabase from the configuration, without connecting, for the startup phases to wait for.
+// Its query metrics are registered in registry.
+func (a *app) openDatabase(registry *prometheus.Registry) (*storage.Database, error) {
+	db := a.database(registry)
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// database from the configuration, which isn't opened yet, with its query metrics in registry, if it's not nil.
+func (a *app) database(registry *prometheus.Registry) *storage.Database {
+	c := a.config.Database
+	return storage.NewDatabase(storage.NewDatabaseOptions{
+		Host:                  c.Host,
+		Port:                  c.Port,
+		User:                  c.User,
+		Password:              c.Password,
+		Name:                  c.Name,
+		MaxOpenConnections:    c.MaxOpenConnections,
+		MaxIdleConnections:    c.MaxIdleConnections,
+		ConnectionMaxLifetime: c.ConnectionMaxLifetime,
+		Log:                   a.logger("storage"),
+		Metrics:               registry,
+	})
+}
+
+// queues for jobs and dead letters, which setupQueues sets up.
+func (a *app) queues(awsConfig aws.Config) (queue, deadLetterQueue *messaging.Queue) {
+	c := a.config.Queue
+	log := a.logger("messaging")
+	queue = createQueue(log, awsConfig, c.EndpointURL, c.QueueSettings, c.Name)
+	deadLetterQueue = createQueue(log, awsConfig, c.EndpointURL, c.DeadLetter, c.DeadLetterName)
+	return queue, deadLetterQueue
+}
+
+// eventsQueue the outbox relay publishes domain events to, with the settings of the job queue,
+// or nil if EVENTS_QUEUE_NAME isn't set.
+func (a *app) eventsQueue(awsConfig aws.Config) *messaging.Queue {
+	c := a.config.Queue
+	if c.EventsName == "" {
+		return nil
+	}
+	return createQueue(a.logger("messaging"), awsConfig, c.EndpointURL, c.QueueSettings, c.EventsName)
+}
+
+// setupQueues with setupQueue, in order.
+func (a *app) setupQueues(ctx context.Context, queues ...*messaging.Queue) error {
+	for _, q := range queues {
+		if err := setupQueue(ctx, a.logger("messaging"), q, a.config.Queue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createQueue(log *zap.Logger, awsConfig aws.Config, endpointURL string, c config.QueueSettings, name string) *messaging.Queue {
+	return messaging.NewQueue(messaging.NewQueueOptions{
+		AdaptiveRetry:          c.AdaptiveRetry,
+		Config:                 awsConfig,
+		EndpointURL:            endpointURL,
+		Log:                    log,
+		MaxRetries:             c.MaxRetries,
+		MessageRetentionPeriod: c.MessageRetentionPeriod,
+		Name:                   name,
+		ReceiveWaitTime:        c.ReceiveWaitTime,
+		SendTimeout:            c.SendTimeout,
+		VisibilityTimeout:      c.VisibilityTimeout,
+		WaitTime:               c.WaitTime,
+	})
+}
+
+// setupQueue by waiting for it to be ready for up to QUEUE_READY_TIMEOUT, creating it if it doesn't exist
+// and QUEUE_ENSURE is set, then setting its attributes if QUEUE_ENSURE is set, and checking the attributes for drift.
+// Drift is logged, and is an error if QUEUE_STRICT_ATTRIBUTES is set.
+// Without QUEUE_ENSURE, a queue that doesn't exist is an error saying so, instead of failing the drift check.
+func setupQueue(ctx context.Context, log *zap.Logger, q *messaging.Queue, c config.Queue) error {
+	if err := q.WaitReady(ctx, messaging.WaitReadyOptions{Create: c.Ensure, Timeout: c.ReadyTimeout}); err != nil {
+		return missingQueueError(err, c.Ensure)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if c.Ensure {
+		if err := q.EnsureQueue(ctx); err != nil {
+			return err
+		}
+	}
+
+	drift, err := q.CheckAttributeDrift(ctx)
+	if err != nil {
+		return missingQueueError(err, c.Ensure)
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+
+	log.Warn("Queue attributes differ from configuration", zap.Stringer("drift", drift))
+	if c.StrictAttributes {
+		return fmt.Errorf("queue attributes differ from configuration: %v", drift)
+	}
+	return nil
+}
+
+// missingQueueError says to create the queue or set QUEUE_ENSURE, if err is because the queue doesn't exist
+// and QUEUE_ENSURE isn't set. Other errors are returned as they are.
+func missingQueueError(err error, ensure bool) error {
+	if !ensure && errors.Is(err, messaging.ErrQueueNotFound) {
+		return fmt.Errorf("queue doesn't exist, create it or set QUEUE_ENSURE to create it on startup: %w", err)
+	}
+	return err
+}
+
+// healthMonitor of the database.
+func (a *app) healthMonitor(db *storage.Database) *storage.HealthMonitor {
+	return storage.NewHealthMonitor(storage.NewHealthMonitorOptions{
+		DB:       db,
+		Interval: a.config.Database.HealthInterval,
+		Log:      a.logger("storage"),
+	})
+}
+
+// errorReporter from the Sentry configuration, which is nil, and reports nothing, without SENTRY_DSN.
+func (a *app) errorReporter() (*errorreport.Reporter, error) {
+	c := a.config.Sentry
+	if c.DSN == "" {
+		a.log.Info("Not reporting errors, because SENTRY_DSN isn't set")
+	}
+	return errorreport.NewReporter(errorreport.NewReporterOptions{
+		Burst:       c.Burst,
+		DSN:         c.DSN,
+		Environment: c.Environment,
+		Log:         a.log,
+		Release:     build.Get().Version,
+		SampleEvery: c.SampleEvery,
+	})
+}
+
+// flags from the FEATURE_X environment variables and the file in FLAGS_FILE, logged with their states.
+func (a *app) flags() (*flags.Static, error) {
+	f, err := flags.NewStatic(flags.NewStaticOptions{
+		Environ: os.Environ(),
+		File:    a.config.Flags.File,
+		Log:     a.log,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var on []string
+	for _, flag := range f.Flags() {
+		if flag.Percentage > 0 {
+			on = append(on, fmt.Sprintf("%v=%v%%", flag.Name, flag.Percentage))
+		}
+	}
+	a.log.Info("Feature flags", zap.Strings("on", on))
+	return f, nil
+}
+
+// acmeOptions from ACME_HOSTS and the other ACME settings, or nil without hosts, for plain HTTP.
+// An ACME_CACHE of "db" keeps the certificates in the database, and anything else is a directory.
+func (a *app) acmeOptions(db *storage.Database) *server.ACMEOptions {
+	c := a.config.Server
+	if len(c.ACMEHosts) == 0 {
+		return nil
+	}
+	var cache autocert.Cache = autocert.DirCache(c.ACMECache)
+	if c.ACMECache == "db" {
+		cache = db.ACMECache()
+	}
+	a.log.Info("Serving HTTPS with ACME certificates", zap.Strings("hosts", c.ACMEHosts),
+		zap.String("cache", c.ACMECache))
+	return &server.ACMEOptions{
+		Cache:        cache,
+		DirectoryURL: c.ACMEDirectoryURL,
+		Email:        c.ACMEEmail,
+		Hosts:        c.ACMEHosts,
+		HTTPPort:     c.ACMEHTTPPort,
+	}
+}
+
+// metricsEmitter for METRICS_BACKEND=cloudwatch, which emits the metrics in registry to CloudWatch,
+// or nil for the prometheus backend, which only has them at /metrics. It needs to be started, and flushed on shutdown.
+// The Embedded Metric Format documents are written to a logger of their own, at info level and without sampling,
+// since the documents of a flush all have the same message, and dropping any loses their metrics.
+func (a *app) metricsEmitter(awsConfig aws.Config, registry *prometheus.Registry) (*metrics.Emitter, error) {
+	c := a.config.Metrics
+	if c.Backend != "cloudwatch" {
+		return nil, nil
+	}
+
+	opts := metrics.NewEmitterOptions{
+		Gatherer:  registry,
+		Interval:  c.Interval,
+		Log:       a.logger("metrics"),
+		Namespace: c.Namespace,
+	}
+	if c.PutMetricData {
+		a.log.Info("Putting metrics in CloudWatch", zap.String("namespace", c.Namespace))
+		opts.CloudWatch = metrics.NewCloudWatch(awsConfig, c.EndpointURL)
+		return metrics.NewEmitter(opts), nil
+	}
+
+	emfLog, _, err := createLogger(config.Log{Env: a.config.Log.Env}, logFields(a.config)...)
+	if err != nil {
+		return nil, err
+	}
+	a.log.Info("Writing metrics to the log in the CloudWatch Embedded Metric Format", zap.String("namespace", c.Namespace))
+	opts.EMFLog = emfLog.With(zap.String("component", "metrics"))
+	return metrics.NewEmitter(opts), nil
+}
+
+// tracing from the OpenTelemetry configuration, which is nil, and traces nothing, without an OTLP endpoint.
+func (a *app) tracing() *tracing.Provider {
+	c := a.config.Tracing
+	endpoint := c.TracesURL()
+	if endpoint == "" {
+		a.log.Info("Not tracing, because OTEL_EXPORTER_OTLP_ENDPOINT isn't set")
+		return nil
+	}
+
+	// OTEL_SERVICE_NAME and the build version win over the same keys in OTEL_RESOURCE_ATTRIBUTES.
+	values := c.ResourceAttributeValues()
+	values[string(semconv.ServiceNameKey)] = c.ServiceName
+	values[string(semconv.ServiceVersionKey)] = build.Get().Version
+	// The environment is the one errors are reported in, unless OTEL_RESOURCE_ATTRIBUTES has its own.
+	if _, ok := values[string(semconv.DeploymentEnvironmentKey)]; !ok && a.config.Sentry.Environment != "" {
+		values[string(semconv.DeploymentEnvironmentKey)] = a.config.Sentry.Environment
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var resource []attribute.KeyValue
+	for _, k := range keys {
+		resource = append(resource, attribute.String(k, values[k]))
+	}
+
+	a.log.Info("Exporting traces", zap.String("endpoint", endpoint))
+	return tracing.NewProvider(tracing.NewProviderOptions{
+		Exporter: tracing.NewOTLPExporter(tracing.NewOTLPExporterOptions{
+			Endpoint: endpoint,
+			Headers:  c.HeaderValues(),
+			Timeout:  c.Timeout,
+		}),
+		Log:      a.log,
+		Resource: resource,
+	})
+}
+
+// emailSender for EMAIL_BACKEND, behind the suppression list in db, so every email goes through it.
+// Emails that aren't suppressed are limited to the SES quota, if quota isn't nil. Sends are counted in registry.
+func (a *app) emailSender(awsConfig aws.Config, db *storage.Database, quota *email.QuotaManager, registry *prometheus.Registry) email.Sender {
+	backend := a.emailBackend(awsConfig)
+	if quota != nil {
+		backend = quota.Limit(backend)
+	}
+	return email.CountSends(email.NewSuppressionGate(backend, db), registry)
+}
+
+// sesQuota for keeping sends within the SES sending quota with SES_QUOTA, or nil for other backends,
+// which don't have a quota to read. It needs to be started to read the quota.
+func (a *app) sesQuota(awsConfig aws.Config, registry *prometheus.Registry) *email.QuotaManager {
+	c := a.config.Email
+	if c.Backend != "ses" || !c.SESQuota {
+		return nil
+	}
+	return email.NewQuotaManager(email.NewQuotaManagerOptions{
+		Config:         awsConfig,
+		EndpointURL:    c.SESEndpointURL,
+		Interval:       c.SESQuotaInterval,
+		Log:            a.logger("email"),
+		Metrics:        registry,
+		RatePercent:    c.SESQuotaRatePercent,
+		ReservePercent: c.SESQuotaReservePercent,
+	})
+}
+
+// emailBackend for EMAIL_BACKEND, which sends emails with SES or to an SMTP server, or only logs them.
+func (a *app) emailBackend(awsConfig aws.Config) email.Sender {
+	c := a.config.Email
+	log := a.logger("email")
+	switch c.Backend {
+	case "ses":
+		return email.NewSESSender(email.NewSESSenderOptions{
+			Config:           awsConfig,
+			ConfigurationSet: c.SESConfigurationSet,
+			EndpointURL:      c.SESEndpointURL,
+			From:             c.From,
+			FromARN:          c.SESFromARN,
+			Log:              log,
+		})
+	case "smtp":
+		return email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:     c.From,
+			Host:     c.SMTPHost,
+			Log:      log,
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			Port:     c.SMTPPort,
+			Security: email.SMTPSecurity(c.SMTPSecurity),
+			Timeout:  c.SMTPTimeout,
+		})
+	default:
+		log.Info("Logging emails instead of sending them")
+		return email.NewLogSender(log)
+	}
+}
+
+// jobRunnerOptions for app.jobRunner.
+type jobRunnerOptions struct {
+	Catalog         *i18n.Catalog
+	Database        *storage.Database
+	DeadLetterQueue *messaging.Queue
+	EmailSender     email.Sender
+	ErrorReporter   *errorreport.Reporter
+	Flags           flags.Provider
+	Health          *storage.HealthMonitor
+	Metrics         *prometheus.Registry
+	Queue           *messaging.Queue
+	// Quota pauses sending newsletter issues while the SES sending quota is almost used up, if it's not nil.
+	Quota   *email.QuotaManager
+	Tracing *tracing.Provider
+}
+
+// jobRunner with all the jobs registered, for the job queue worker.
+func (a *app) jobRunner(opts jobRunnerOptions) *jobs.Runner {
+	c := a.config
+	db := opts.Database
+	// Without a tracking secret, newsletter issue emails have no open tracking pixel or tracked links.
+	trackingSecret := []byte(c.Server.TrackingSecret)
+	log := a.logger("jobs")
+	retry := jobs.RetryPolicy{
+		Attempts: c.Email.SendRetryAttempts,
+		Delay:    c.Email.SendRetryDelay,
+		Budget:   c.Email.SendRetryBudget,
+	}
+	// A nil *email.QuotaManager in the interface of the options wouldn't be nil.
+	var quota interface{ CheckHeadroom() error }
+	if opts.Quota != nil {
+		quota = opts.Quota
+	}
+
+	r := jobs.NewRunner(jobs.NewRunnerOptions{
+		DeadLetterQueue: opts.DeadLetterQueue,
+		ErrorReporter:   opts.ErrorReporter,
+		Health:          opts.Health,
+		Limit:           c.Queue.JobLimit,
+		Log:             log,
+		Metrics:         opts.Metrics,
+		Queue:           opts.Queue,
+		ShutdownTimeout: c.Worker.ShutdownTimeout,
+		SummaryInterval: c.Worker.SummaryInterval,
+		Tracing:         opts.Tracing,
+	})
+	jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+		BaseURL:         c.Server.BaseURL,
+		Catalog:         opts.Catalog,
+		From:            c.Email.From,
+		Log:             log,
+		PhysicalAddress: c.Email.PhysicalAddress,
+		Retry:           retry,
+		Sender:          opts.EmailSender,
+		SendLog:         db,
+	})
+	jobs.SendWelcomeEmail(r, jobs.SendWelcomeEmailOptions{
+		BaseURL:           c.Server.BaseURL,
+		Catalog:           opts.Catalog,
+		From:              c.Email.From,
+		Log:               log,
+		PhysicalAddress:   c.Email.PhysicalAddress,
+		Retry:             retry,
+		Sender:            opts.EmailSender,
+		Store:             db,
+		Throttle:          db,
+		UnsubscribeMailto: c.Email.UnsubscribeMailto,
+		UnsubscribeSecret: []byte(c.Server.UnsubscribeSecret),
+	})
+	jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+		Log:   log,
+		Queue: opts.Queue,
+		Quota: quota,
+		Store: db,
+	})
+	jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+		BaseURL:           c.Server.BaseURL,
+		Catalog:           opts.Catalog,
+		Flags:             opts.Flags,
+		From:              c.Email.From,
+		Limiter:           rate.NewLimiter(rate.Limit(c.Email.RateLimit), 1),
+		Log:               log,
+		PhysicalAddress:   c.Email.PhysicalAddress,
+		Quota:             quota,
+		Retry:             retry,
+		Sender:            opts.EmailSender,
+		Store:             db,
+		TrackingSecret:    trackingSecret,
+		UnsubscribeMailto: c.Email.UnsubscribeMailto,
+		UnsubscribeSecret: []byte(c.Server.UnsubscribeSecret),
+	})
+	jobs.RecordEmailOpen(r, jobs.RecordEmailOpenOptions{
+		Log:   log,
+		Store: db,
+	})
+	jobs.RecordEmailClick(r, jobs.RecordEmailClickOptions{
+		Log:   log,
+		Store: db,
+	})
+	jobs.SendScheduledNewsletters(r, jobs.SendScheduledNewslettersOptions{
+		Grace: c.Schedule.NewsletterGrace,
+		Log:   log,
+		Store: db,
+	})
+	jobs.DeliverWebhook(r, jobs.DeliverWebhookOptions{
+		Attempts: c.Webhook.Attempts,
+		Client: webhook.NewClient(webhook.NewClientOptions{
+			AllowPrivateNetworks: c.Webhook.AllowPrivateNetworks,
+			Timeout:              c.Webhook.Timeout,
+		}),
+		DisableAfter: c.Webhook.DisableAfter,
+		Log:          log,
+		Store:        db,
+	})
+	jobs.ImportSubscribers(r, jobs.ImportSubscribersOptions{
+		Log:   log,
+		Store: db,
+	})
+	jobs.DeleteOldEmailSends(r, jobs.DeleteOldEmailSendsOptions{
+		Log:       log,
+		Retention: c.Email.SendLogRetention,
+		Store:     db,
+	})
+	jobs.DeleteExpiredThrottles(r, jobs.DeleteExpiredThrottlesOptions{
+		Log:   log,
+		Store: db,
+	})
+	return r
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/config"
+	"canvas/messaging"
+)
+
+func TestCreateLogger(t *testing.T) {
+	tests := []struct {
+		env, level string
+		// expected is the lowest level that's logged.
+		expected zapcore.Level
+	}{
+		{env: "production", expected: zapcore.InfoLevel},
+		{env: "development", expected: zapcore.DebugLevel},
+		{env: "PRODUCTION", level: "debug", expected: zapcore.DebugLevel},
+		{env: "Development", level: "WARN", expected: zapcore.WarnLevel},
+		{env: "production", level: "Error", expected: zapcore.ErrorLevel},
+	}
+	for _, test := range tests {
+		t.Run(test.env+" "+test.level, func(t *testing.T) {
+			is := is.New(t)
+
+			log, _, err := createLogger(config.Log{Env: test.env, Level: test.level})
+			is.NoErr(err)
+			is.True(log.Core().Enabled(test.expected))
+			if test.expected > zapcore.DebugLevel {
+				is.True(!log.Core().Enabled(test.expected - 1))
+			}
+		})
+	}
+
+	t.Run("changes the level of the logger with the atomic level", func(t *testing.T) {
+		is := is.New(t)
+
+		log, level, err := createLogger(config.Log{Env: "production"})
+		is.NoErr(err)
+		level.SetLevel(zapcore.DebugLevel)
+		is.True(log.Core().Enabled(zapcore.DebugLevel))
+	})
+
+	t.Run("logs nothing with nop", func(t *testing.T) {
+		is := is.New(t)
+
+		log, _, err := createLogger(config.Log{Env: "Nop", Level: "debug"})
+		is.NoErr(err)
+		is.True(!log.Core().Enabled(zapcore.FatalLevel))
+	})
+
+	t.Run("errors on invalid environments and levels", func(t *testing.T) {
+		is := is.New(t)
+
+		_, _, err := createLogger(config.Log{Env: "staging"})
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), `invalid log environment "staging"`))
+
+		_, _, err = createLogger(config.Log{Env: "production", Level: "verbose"})
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), `invalid log level "verbose"`))
+
+		_, _, err = createLogger(config.Log{Env: "nop", Level: "verbose"})
+		is.True(err != nil)
+	})
+}
+
+// decodeLogs from the JSON lines in b.
+func decodeLogs(t *testing.T, b *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	d := json.NewDecoder(b)
+	for d.More() {
+		var entry map[string]interface{}
+		if err := d.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestNewLogger(t *testing.T) {
+	t.Run("logs JSON lines in production, with ISO8601 timestamps and the fields on every entry", func(t *testing.T) {
+		is := is.New(t)
+
+		var b bytes.Buffer
+		log, _, err := newLogger(config.Log{Env: "production"}, zapcore.AddSync(&b),
+			zap.String("service", "canvas"), zap.String("env", "staging"), zap.String("version", "v1.2.3"))
+		is.NoErr(err)
+		a := &app{log: log}
+		a.logger("storage").Info("Hi", zap.String("requestID", "abc"))
+
+		entries := decodeLogs(t, &b)
+		is.Equal(2, len(entries))
+		is.Equal("Set up logger", entries[0]["msg"])
+		entry := entries[1]
+		_, err = time.Parse("2006-01-02T15:04:05.000Z0700", entry["ts"].(string))
+		is.NoErr(err)
+		for _, k := range []string{"caller", "ts"} {
+			_, ok := entry[k]
+			is.True(ok)
+			delete(entry, k)
+		}
+		is.Equal(map[string]interface{}{
+			"level":     "info",
+			"msg":       "Hi",
+			"service":   "canvas",
+			"env":       "staging",
+			"version":   "v1.2.3",
+			"component": "storage",
+			"requestID": "abc",
+		}, entry)
+	})
+
+	t.Run("has stacktraces only from error up", func(t *testing.T) {
+		is := is.New(t)
+
+		var b bytes.Buffer
+		log, _, err := newLogger(config.Log{Env: "production"}, zapcore.AddSync(&b))
+		is.NoErr(err)
+		log.Warn("Careful")
+		log.Error("Oh no")
+
+		entries := decodeLogs(t, &b)
+		is.Equal(3, len(entries))
+		_, ok := entries[1]["stacktrace"]
+		is.True(!ok)
+		_, ok = entries[2]["stacktrace"]
+		is.True(ok)
+	})
+
+	t.Run("samples bursts of the same entry in production", func(t *testing.T) {
+		is := is.New(t)
+
+		var b bytes.Buffer
+		log, _, err := newLogger(config.Log{Env: "production", SampleInitial: 3, SampleThereafter: 10}, zapcore.AddSync(&b))
+		is.NoErr(err)
+		for i := 0; i < 25; i++ {
+			log.Error("Oh no")
+		}
+		log.Error("Something else")
+
+		var messages []interface{}
+		for _, entry := range decodeLogs(t, &b)[1:] {
+			messages = append(messages, entry["msg"])
+		}
+		// The first 3, then the 13th and the 23rd.
+		is.Equal([]interface{}{"Oh no", "Oh no", "Oh no", "Oh no", "Oh no", "Something else"}, messages)
+	})
+
+	t.Run("doesn't sample without a sample initial", func(t *testing.T) {
+		is := is.New(t)
+
+		var b bytes.Buffer
+		log, _, err := newLogger(config.Log{Env: "production"}, zapcore.AddSync(&b))
+		is.NoErr(err)
+		for i := 0; i < 25; i++ {
+			log.Error("Oh no")
+		}
+		is.Equal(26, len(decodeLogs(t, &b)))
+	})
+}
+
+func TestSettingsField(t *testing.T) {
+	t.Run("logs each setting with its value and source", func(t *testing.T) {
+		is := is.New(t)
+
+		var b bytes.Buffer
+		log, _, err := newLogger(config.Log{Env: "production"}, zapcore.AddSync(&b))
+		is.NoErr(err)
+		log.Info("Configuration", settingsField([]config.Setting{
+			{Key: "server.port", Value: 8081, Source: config.SourceEnv},
+			{Key: "database.password", Value: "[redacted]", Source: config.SourceSecretsManager},
+		}))
+
+		entries := decodeLogs(t, &b)
+		is.Equal(map[string]interface{}{
+			"server.port":       map[string]interface{}{"value": float64(8081), "source": "env"},
+			"database.password": map[string]interface{}{"value": "[redacted]", "source": "secrets manager"},
+		}, entries[1]["settings"])
+	})
+}
+
+func TestLogConfigError(t *testing.T) {
+	t.Run("logs one error with all the problems", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.DebugLevel)
+		err := config.Config{}.ValidateDatabase()
+		logConfigError(zap.New(core), err)
+
+		is.Equal(1, logs.Len())
+		entry := logs.All()[0]
+		is.Equal(zapcore.ErrorLevel, entry.Level)
+		is.Equal("Invalid configuration", entry.Message)
+		var verr *config.ValidationError
+		is.True(errors.As(err, &verr))
+		problems := entry.ContextMap()["problems"].([]interface{})
+		is.True(len(problems) > 1)
+		for i, p := range problems {
+			is.Equal(verr.Problems[i], p)
+		}
+	})
+
+	t.Run("logs other errors with the error", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.DebugLevel)
+		logConfigError(zap.New(core), errors.New("oh no"))
+
+		is.Equal(1, logs.Len())
+		is.Equal("Error reading configuration", logs.All()[0].Message)
+		is.Equal("oh no", logs.All()[0].ContextMap()["error"])
+	})
+}
+
+func TestAWSLoadOptions(t *testing.T) {
+	load := func(c config.AWS) awsconfig.LoadOptions {
+		var o awsconfig.LoadOptions
+		for _, opt := range awsLoadOptions(c) {
+			if err := opt(&o); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return o
+	}
+
+	t.Run("has no options without settings, for the default chain", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(0, len(awsLoadOptions(config.AWS{})))
+	})
+
+	t.Run("sets the region, profile, and static credentials", func(t *testing.T) {
+		is := is.New(t)
+
+		o := load(config.AWS{
+			Region:          "eu-west-1",
+			Profile:         "canvas",
+			AccessKeyID:     "id",
+			SecretAccessKey: "secret",
+			SessionToken:    "token",
+		})
+		is.Equal("eu-west-1", o.Region)
+		is.Equal("canvas", o.SharedConfigProfile)
+
+		creds, err := o.Credentials.Retrieve(context.Background())
+		is.NoErr(err)
+		is.Equal("id", creds.AccessKeyID)
+		is.Equal("secret", creds.SecretAccessKey)
+		is.Equal("token", creds.SessionToken)
+	})
+
+	t.Run("errors on a missing region", func(t *testing.T) {
+		is := is.New(t)
+
+		dir := t.TempDir()
+		t.Setenv("AWS_REGION", "")
+		t.Setenv("AWS_DEFAULT_REGION", "")
+		t.Setenv("AWS_PROFILE", "")
+		t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+		_, err := loadAWSConfig(zap.NewNop(), config.AWS{})
+		is.True(err != nil)
+		is.Equal("no AWS region, set AWS_REGION or a region in the AWS_PROFILE profile", err.Error())
+
+		awsConfig, err := loadAWSConfig(zap.NewNop(), config.AWS{Region: "eu-west-1"})
+		is.NoErr(err)
+		is.Equal("eu-west-1", awsConfig.Region)
+	})
+}
+
+type stsClientMock struct {
+	err error
+}
+
+func (c stsClientMock) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String("123456789012"),
+		Arn:     aws.String("arn:aws:sts::123456789012:assumed-role/canvas/i-1"),
+		UserId:  aws.String("AROAEXAMPLE:i-1"),
+	}, nil
+}
+
+func TestAWSIdentity(t *testing.T) {
+	t.Run("has the account and ARN of the credentials", func(t *testing.T) {
+		is := is.New(t)
+
+		identity, err := awsIdentity(context.Background(), stsClientMock{})
+		is.NoErr(err)
+		is.Equal("account 123456789012, arn:aws:sts::123456789012:assumed-role/canvas/i-1", identity)
+	})
+
+	t.Run("errors if the identity can't be checked", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := awsIdentity(context.Background(), stsClientMock{err: errors.New("expired token")})
+		is.True(err != nil)
+	})
+}
+
+// missingQueueClient is an SQS client for an endpoint without queues. Only GetQueueUrl can be called.
+type missingQueueClient struct {
+	*sqs.Client
+}
+
+func (c missingQueueClient) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	return nil, &types.QueueDoesNotExist{Message: aws.String("The specified queue does not exist.")}
+}
+
+func TestSetupQueue(t *testing.T) {
+	t.Run("says to set QUEUE_ENSURE if the queue doesn't exist", func(t *testing.T) {
+		is := is.New(t)
+
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: missingQueueClient{}, Name: "jobs", VisibilityTimeout: 30 * time.Second})
+		err := setupQueue(context.Background(), zap.NewNop(), q, config.Queue{ReadyTimeout: time.Millisecond})
+		is.True(errors.Is(err, messaging.ErrQueueNotFound))
+		is.True(strings.Contains(err.Error(), "set QUEUE_ENSURE"))
+	})
+}
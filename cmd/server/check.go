@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/config"
+)
+
+// checkCommand validates the configuration for serve without starting anything, like in CI before a deployment.
+func checkCommand(args []string) int {
+	return check(args, os.Stdout, os.Stderr)
+}
+
+// check the configuration, and with -probe, that the database and queues can be reached, who the AWS
+// credentials are for, and that the email backend can send from EMAIL_FROM.
+// The report goes to out, as text or with -json as JSON, and usage problems to errOut.
+// Probes only read, so they're safe to run against production.
+func check(args []string, out, errOut io.Writer) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(errOut, "Usage: server check [-probe] [-timeout 5s] [-json]")
+		fs.PrintDefaults()
+	}
+	probe := fs.Bool("probe", false, "Also check that the database and queues can be reached, the AWS identity, and the email sending identity.")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for each probe.")
+	asJSON := fs.Bool("json", false, "Print the report as JSON.")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		if fs.NArg() > 0 {
+			fs.Usage()
+		}
+		return exitUsage
+	}
+
+	var r checkReport
+	cfg, _, err := loadConfig()
+	if err == nil {
+		err = validateServe(cfg)
+	}
+	if err != nil {
+		r.Problems = configProblems(err)
+	}
+
+	if *probe && len(r.Problems) == 0 {
+		probes, err := checkProbes(cfg)
+		if err != nil {
+			r.Problems = append(r.Problems, err.Error())
+		} else {
+			r.Probed = true
+			r.Probes = runProbes(context.Background(), *timeout, probes)
+		}
+	}
+
+	if *asJSON {
+		err = printCheckJSON(out, r)
+	} else {
+		err = printCheckText(out, r, *probe)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(errOut, "Error printing report:", err)
+		return exitError
+	}
+	return r.exitCode()
+}
+
+// configProblems in the error from loading or validating the configuration.
+func configProblems(err error) []string {
+	var verr *config.ValidationError
+	if errors.As(err, &verr) {
+		return verr.Problems
+	}
+	return []string{err.Error()}
+}
+
+// probe of something the app needs to reach, which must not change anything.
+// It can return a detail for the report, like who the AWS credentials are for.
+type probe struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}
+
+// checkProbes for the database and both queues of the configuration, the AWS identity,
+// and the email sending identity, unless the email backend only logs emails.
+func checkProbes(cfg config.Config) ([]probe, error) {
+	log := zap.NewNop()
+	awsConfig, err := loadAWSConfig(log, cfg.AWS)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS config: %w", err)
+	}
+
+	c := cfg.Queue
+	queue := createQueue(log, awsConfig, c.EndpointURL, c.QueueSettings, c.Name)
+	deadLetterQueue := createQueue(log, awsConfig, c.EndpointURL, c.DeadLetter, c.DeadLetterName)
+
+	probes := []probe{
+		{name: "database", run: func(ctx context.Context) (string, error) {
+			a := &app{config: cfg, log: log}
+			db, err := a.connectDatabase()
+			if err != nil {
+				return "", err
+			}
+			defer func() {
+				_ = db.Close()
+			}()
+			return "", db.Ping(ctx)
+		}},
+		{name: "queue " + c.Name, run: func(ctx context.Context) (string, error) {
+			_, err := queue.URL(ctx)
+			return "", err
+		}},
+		{name: "dead letter queue " + c.DeadLetterName, run: func(ctx context.Context) (string, error) {
+			_, err := deadLetterQueue.URL(ctx)
+			return "", err
+		}},
+		{name: "aws identity", run: func(ctx context.Context) (string, error) {
+			return awsIdentity(ctx, newSTSClient(awsConfig, cfg.AWS.STSEndpointURL))
+		}},
+	}
+	a := &app{config: cfg, log: log}
+	if v, ok := a.emailBackend(awsConfig).(identityVerifier); ok {
+		probes = append(probes, probe{name: "email identity", run: func(ctx context.Context) (string, error) {
+			if c, ok := v.(interface{ Close() }); ok {
+				defer c.Close()
+			}
+			return v.VerifyIdentity(ctx)
+		}})
+	}
+	return probes, nil
+}
+
+// probeResult of running a probe.
+type probeResult struct {
+	Name     string
+	Detail   string
+	Err      error
+	Duration time.Duration
+}
+
+// runProbes in order, each with the timeout.
+// A probe that doesn't return in time fails, even if it doesn't respect its context.
+func runProbes(ctx context.Context, timeout time.Duration, probes []probe) []probeResult {
+	var results []probeResult
+	for _, p := range probes {
+		start := time.Now()
+		detail, err := runProbe(ctx, timeout, p)
+		results = append(results, probeResult{Name: p.name, Detail: detail, Err: err, Duration: time.Since(start)})
+	}
+	return results
+}
+
+func runProbe(ctx context.Context, timeout time.Duration, p probe) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		detail string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		detail, err := p.run(ctx)
+		done <- result{detail: detail, err: err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("timed out after %v", timeout)
+		}
+		return r.detail, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// checkReport of the check command.
+type checkReport struct {
+	// Problems with the configuration, from loading it or validating it.
+	Problems []string
+	// Probed is whether the probes ran, which they don't if the configuration is invalid.
+	Probed bool
+	Probes []probeResult
+}
+
+// exitCode for the report: exitError for an invalid configuration, exitProbe for failed probes, and exitOK otherwise.
+func (r checkReport) exitCode() int {
+	if len(r.Problems) > 0 {
+		return exitError
+	}
+	for _, p := range r.Probes {
+		if p.Err != nil {
+			return exitProbe
+		}
+	}
+	return exitOK
+}
+
+// printCheckText for people, noting skipped probes if they were asked for.
+func printCheckText(out io.Writer, r checkReport, probe bool) error {
+	w := &errWriter{w: out}
+	if len(r.Problems) > 0 {
+		w.printf("Configuration is invalid:\n")
+		for _, p := range r.Problems {
+			w.printf("  - %v\n", p)
+		}
+	} else {
+		w.printf("Configuration is valid.\n")
+	}
+
+	switch {
+	case r.Probed:
+		w.printf("Probes:\n")
+		for _, p := range r.Probes {
+			if p.Err != nil {
+				w.printf("  - %v failed after %v: %v\n", p.Name, p.Duration.Round(time.Millisecond), p.Err)
+				continue
+			}
+			if p.Detail != "" {
+				w.printf("  - %v ok in %v: %v\n", p.Name, p.Duration.Round(time.Millisecond), p.Detail)
+				continue
+			}
+			w.printf("  - %v ok in %v\n", p.Name, p.Duration.Round(time.Millisecond))
+		}
+	case probe:
+		w.printf("Probes skipped, because the configuration is invalid.\n")
+	}
+	return w.err
+}
+
+type checkJSON struct {
+	OK       bool        `json:"ok"`
+	Problems []string    `json:"problems"`
+	Probes   []probeJSON `json:"probes,omitempty"`
+}
+
+type probeJSON struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"durationMs"`
+}
+
+// printCheckJSON for CI, with an empty list of problems if there are none.
+func printCheckJSON(out io.Writer, r checkReport) error {
+	res := checkJSON{OK: r.exitCode() == exitOK, Problems: r.Problems}
+	if res.Problems == nil {
+		res.Problems = []string{}
+	}
+	for _, p := range r.Probes {
+		pj := probeJSON{Name: p.Name, OK: p.Err == nil, Detail: p.Detail, DurationMS: p.Duration.Milliseconds()}
+		if p.Err != nil {
+			pj.Error = p.Err.Error()
+		}
+		res.Probes = append(res.Probes, pj)
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+// errWriter remembers the first error writing, so printing can be checked once at the end.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, a ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, a...)
+}
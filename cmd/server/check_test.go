@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// setValidEnv with the variables that serve requires.
+func setValidEnv(t *testing.T) {
+	t.Helper()
+	for k, v := range map[string]string{
+		"DB_USER":            "canvas",
+		"DB_PASSWORD":        "123",
+		"DB_NAME":            "canvas",
+		"UNSUBSCRIBE_SECRET": "unsubscribe",
+		"SIGNUP_FORM_SECRET": "form",
+		"SESSION_SECRET":     "session",
+	} {
+		t.Setenv(k, v)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	t.Run("exits with ok and doesn't probe without -probe", func(t *testing.T) {
+		is := is.New(t)
+
+		setValidEnv(t)
+		var out bytes.Buffer
+		is.Equal(exitOK, check(nil, &out, &out))
+		is.Equal("Configuration is valid.\n", out.String())
+	})
+
+	t.Run("exits with an error and reports every problem for an invalid configuration, without probing", func(t *testing.T) {
+		is := is.New(t)
+
+		setValidEnv(t)
+		t.Setenv("DB_USER", "")
+		t.Setenv("PORT", "eighty")
+		var out bytes.Buffer
+		is.Equal(exitError, check([]string{"-probe", "-json"}, &out, &out))
+
+		var res checkJSON
+		is.NoErr(json.Unmarshal(out.Bytes(), &res))
+		is.True(!res.OK)
+		is.Equal(2, len(res.Problems))
+		is.True(strings.HasPrefix(res.Problems[0], "PORT "))
+		is.Equal("DB_USER must be set", res.Problems[1])
+		is.Equal(0, len(res.Probes))
+	})
+
+	t.Run("exits with the probe code if a probe fails", func(t *testing.T) {
+		is := is.New(t)
+
+		setValidEnv(t)
+		t.Setenv("DB_HOST", "127.0.0.1")
+		t.Setenv("DB_PORT", "1")
+		t.Setenv("SQS_ENDPOINT_URL", "http://127.0.0.1:1")
+		t.Setenv("AWS_REGION", "eu-west-1")
+		t.Setenv("AWS_ACCESS_KEY_ID", "id")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		t.Setenv("EMAIL_BACKEND", "smtp")
+		t.Setenv("SMTP_HOST", "127.0.0.1")
+		t.Setenv("SMTP_PORT", "1")
+		t.Setenv("SMTP_SECURITY", "none")
+		var out bytes.Buffer
+		is.Equal(exitProbe, check([]string{"-probe", "-timeout", "200ms"}, &out, &out))
+		is.True(strings.HasPrefix(out.String(), "Configuration is valid.\nProbes:\n  - database failed after "))
+		is.True(strings.Contains(out.String(), "\n  - email identity failed after "))
+	})
+
+	t.Run("exits with usage for unknown flags and arguments", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		is.Equal(exitUsage, check([]string{"-nope"}, &out, &out))
+		is.Equal(exitUsage, check([]string{"now"}, &out, &out))
+		is.True(strings.Contains(out.String(), "Usage: server check"))
+	})
+}
+
+func TestRunProbes(t *testing.T) {
+	t.Run("runs each probe in order, with its result", func(t *testing.T) {
+		is := is.New(t)
+
+		var ran []string
+		results := runProbes(context.Background(), time.Second, []probe{
+			{name: "a", run: func(ctx context.Context) (string, error) { ran = append(ran, "a"); return "", nil }},
+			{name: "b", run: func(ctx context.Context) (string, error) { ran = append(ran, "b"); return "", errors.New("oh no") }},
+		})
+		is.Equal([]string{"a", "b"}, ran)
+		is.Equal(2, len(results))
+		is.Equal("a", results[0].Name)
+		is.NoErr(results[0].Err)
+		is.Equal("b", results[1].Name)
+		is.Equal("oh no", results[1].Err.Error())
+	})
+
+	t.Run("fails probes that time out, even if they ignore the context", func(t *testing.T) {
+		is := is.New(t)
+
+		release := make(chan struct{})
+		defer close(release)
+		results := runProbes(context.Background(), 10*time.Millisecond, []probe{
+			{name: "ignores", run: func(ctx context.Context) (string, error) { <-release; return "", nil }},
+			{name: "respects", run: func(ctx context.Context) (string, error) { <-ctx.Done(); return "", ctx.Err() }},
+		})
+		for _, r := range results {
+			is.Equal("timed out after 10ms", r.Err.Error())
+			is.True(r.Duration < time.Second)
+		}
+	})
+}
+
+func TestCheckReport(t *testing.T) {
+	ok := checkReport{Probed: true, Probes: []probeResult{
+		{Name: "database", Duration: 12 * time.Millisecond},
+		{Name: "queue jobs", Duration: 3400 * time.Microsecond},
+		{Name: "aws identity", Detail: "account 123456789012, arn:aws:iam::123456789012:user/canvas", Duration: 40 * time.Millisecond},
+	}}
+	failed := checkReport{Probed: true, Probes: []probeResult{
+		{Name: "database", Duration: 12 * time.Millisecond},
+		{Name: "queue jobs", Err: errors.New("timed out after 5s"), Duration: 5 * time.Second},
+		{Name: "aws identity", Detail: "account 123456789012, arn:aws:iam::123456789012:user/canvas", Duration: 40 * time.Millisecond},
+	}}
+	invalid := checkReport{Problems: []string{"DB_USER must be set", "SESSION_SECRET must be set"}}
+
+	t.Run("has an exit code for each failure class", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(exitOK, checkReport{}.exitCode())
+		is.Equal(exitOK, ok.exitCode())
+		is.Equal(exitProbe, failed.exitCode())
+		is.Equal(exitError, invalid.exitCode())
+	})
+
+	t.Run("prints for people", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		is.NoErr(printCheckText(&out, failed, true))
+		is.Equal(`Configuration is valid.
+Probes:
+  - database ok in 12ms
+  - queue jobs failed after 5s: timed out after 5s
+  - aws identity ok in 40ms: account 123456789012, arn:aws:iam::123456789012:user/canvas
+`, out.String())
+
+		out.Reset()
+		is.NoErr(printCheckText(&out, invalid, true))
+		is.Equal(`Configuration is invalid:
+  - DB_USER must be set
+  - SESSION_SECRET must be set
+Probes skipped, because the configuration is invalid.
+`, out.String())
+	})
+
+	t.Run("prints JSON", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		is.NoErr(printCheckJSON(&out, ok))
+		is.Equal(`{
+  "ok": true,
+  "problems": [],
+  "probes": [
+    {
+      "name": "database",
+      "ok": true,
+      "durationMs": 12
+    },
+    {
+      "name": "queue jobs",
+      "ok": true,
+      "durationMs": 3
+    },
+    {
+      "name": "aws identity",
+      "ok": true,
+      "detail": "account 123456789012, arn:aws:iam::123456789012:user/canvas",
+      "durationMs": 40
+    }
+  ]
+}
+`, out.String())
+
+		out.Reset()
+		is.NoErr(printCheckJSON(&out, failed))
+		is.True(strings.Contains(out.String(), `"ok": false,`))
+		is.True(strings.Contains(out.String(), `"error": "timed out after 5s",`))
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"canvas/handlers"
+	"canvas/jobs"
+)
+
+// maxGoroutineDump is the most bytes of goroutine stacks logged on SIGQUIT, so a dump of a process
+// with very many goroutines doesn't flood the logs.
+const maxGoroutineDump = 1 << 20
+
+// diagnostics for the signals that don't stop the app: SIGQUIT logs the stacks of all goroutines
+// instead of exiting like it would by default, SIGUSR1 logs the stats, and SIGUSR2 toggles debug logging.
+type diagnostics struct {
+	log *zap.Logger
+	// logLevel to toggle on SIGUSR2. Without it, SIGUSR2 is ignored.
+	logLevel *handlers.LogLevel
+	// stats logged on SIGUSR1, like of the database connection pool.
+	stats []func() zap.Field
+	// maxDump in bytes. Defaults to maxGoroutineDump.
+	maxDump int
+}
+
+// handleSignals until ctx is done.
+func (d diagnostics) handleSignals(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(c)
+	for {
+		select {
+		case s := <-c:
+			d.handle(s)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle the signal.
+func (d diagnostics) handle(s os.Signal) {
+	switch s {
+	case syscall.SIGQUIT:
+		d.dumpGoroutines()
+	case syscall.SIGUSR1:
+		fields := make([]zap.Field, 0, len(d.stats))
+		for _, f := range d.stats {
+			fields = append(fields, f())
+		}
+		d.log.Info("Stats", fields...)
+	case syscall.SIGUSR2:
+		if d.logLevel != nil {
+			d.logLevel.ToggleDebug()
+		}
+	}
+}
+
+// dumpGoroutines logs the stacks of all goroutines, truncated to maxDump bytes, marking that with truncated.
+func (d diagnostics) dumpGoroutines() {
+	limit := d.maxDump
+	if limit <= 0 {
+		limit = maxGoroutineDump
+	}
+	size := 64 * 1024
+	if size > limit {
+		size = limit
+	}
+	var stacks []byte
+	truncated := false
+	for {
+		buf := make([]byte, size)
+		n := runtime.Stack(buf, true)
+		if n < size {
+			stacks = buf[:n]
+			break
+		}
+		if size == limit {
+			stacks, truncated = buf, true
+			break
+		}
+		if size *= 2; size > limit {
+			size = limit
+		}
+	}
+	d.log.Info("Goroutine dump", zap.Int("goroutines", runtime.NumGoroutine()), zap.Bool("truncated", truncated),
+		zap.ByteString("stacks", stacks))
+}
+
+// databaseStats of the connection pool of db.
+func databaseStats(db interface{ Stats() sql.DBStats }) func() zap.Field {
+	return func() zap.Field {
+		s := db.Stats()
+		return zap.Object("database", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt("maxOpen", s.MaxOpenConnections)
+			enc.AddInt("open", s.OpenConnections)
+			enc.AddInt("inUse", s.InUse)
+			enc.AddInt("idle", s.Idle)
+			enc.AddInt64("waitCount", s.WaitCount)
+			enc.AddDuration("waitDuration", s.WaitDuration)
+			return nil
+		}))
+	}
+}
+
+// runnerStats of the job queue consumer.
+func runnerStats(r interface{ State() jobs.RunnerState }) func() zap.Field {
+	return func() zap.Field {
+		s := r.State()
+		return zap.Object("worker", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddInt("limit", s.Limit)
+			enc.AddInt("running", s.Running)
+			enc.AddBool("paused", s.Paused)
+			return nil
+		}))
+	}
+}
+
+// serverStats of the requests being handled.
+func serverStats(s interface{ InFlight() int }) func() zap.Field {
+	return func() zap.Field {
+		return zap.Int("requestsInFlight", s.InFlight())
+	}
+}
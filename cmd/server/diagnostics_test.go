@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+	"canvas/jobs"
+)
+
+type poolMock struct{}
+
+func (p poolMock) Stats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 2, Idle: 1, WaitCount: 4, WaitDuration: time.Second}
+}
+
+type inFlightMock int
+
+func (i inFlightMock) InFlight() int {
+	return int(i)
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Run("dumps the stacks of all goroutines on SIGQUIT", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		d := diagnostics{log: zap.New(core)}
+		d.handle(syscall.SIGQUIT)
+
+		is.Equal(1, logs.Len())
+		entry := logs.All()[0]
+		is.Equal("Goroutine dump", entry.Message)
+		fields := entry.ContextMap()
+		is.Equal(false, fields["truncated"])
+		is.True(fields["goroutines"].(int64) > 0)
+		stacks := fields["stacks"].(string)
+		is.True(strings.HasPrefix(stacks, "goroutine "))
+		is.True(strings.Contains(stacks, "TestDiagnostics"))
+	})
+
+	t.Run("truncates the dump to the size cap", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		d := diagnostics{log: zap.New(core), maxDump: 100}
+		d.handle(syscall.SIGQUIT)
+
+		fields := logs.All()[0].ContextMap()
+		is.Equal(true, fields["truncated"])
+		is.Equal(100, len(fields["stacks"].(string)))
+	})
+
+	t.Run("logs the pool, worker, and request stats on SIGUSR1", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Limit: 5})
+		d := diagnostics{log: zap.New(core), stats: []func() zap.Field{
+			serverStats(inFlightMock(7)),
+			databaseStats(poolMock{}),
+			runnerStats(r),
+		}}
+		d.handle(syscall.SIGUSR1)
+
+		is.Equal(1, logs.Len())
+		entry := logs.All()[0]
+		is.Equal("Stats", entry.Message)
+		fields := entry.ContextMap()
+		is.Equal(int64(7), fields["requestsInFlight"])
+		is.Equal(map[string]interface{}{
+			"maxOpen": 10, "open": 3, "inUse": 2, "idle": 1, "waitCount": int64(4), "waitDuration": time.Second,
+		}, fields["database"])
+		is.Equal(map[string]interface{}{"limit": 5, "running": 0, "paused": false}, fields["worker"])
+	})
+
+	t.Run("toggles debug logging on SIGUSR2", func(t *testing.T) {
+		is := is.New(t)
+
+		level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+		d := diagnostics{log: zap.NewNop(), logLevel: handlers.NewLogLevel(level, nil, handlers.LogLevelOptions{})}
+		d.handle(syscall.SIGUSR2)
+		is.Equal(zapcore.DebugLevel, level.Level())
+		d.handle(syscall.SIGUSR2)
+		is.Equal(zapcore.InfoLevel, level.Level())
+	})
+
+	t.Run("ignores SIGUSR2 without a log level", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		d := diagnostics{log: zap.New(core)}
+		d.handle(syscall.SIGUSR2)
+		is.New(t).Equal(0, logs.Len())
+	})
+}
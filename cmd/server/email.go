@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"canvas/config"
+	"canvas/email"
+	"canvas/i18n"
+	"canvas/model"
+)
+
+// sendLogger records test sends in the send log, satisfied by *storage.Database.
+type sendLogger interface {
+	RecordEmailSend(ctx context.Context, s model.EmailSend) error
+}
+
+var emailUsage = `Usage: server email preview -template <name> [-locale <locale>] [-format html|text] [-out <dir>]
+       server email test-send -template <name> -to <address> [-locale <locale>]
+
+Templates: ` + strings.Join(email.SampleTemplates, ", ")
+
+// emailFlags of the email subcommands.
+type emailFlags struct {
+	format   string
+	locale   string
+	out      string
+	template string
+	to       model.Email
+}
+
+// emailCommand renders a sample email with preview, or sends it to an address with test-send,
+// so templates can be worked on without the web UI. Both render with email.SampleEmail, which uses the same
+// functions as the emails that are sent. Preview only needs the email configuration, and test-send the database too,
+// for recording the send as a test.
+func emailCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "preview" && args[0] != "test-send") {
+		fmt.Fprintln(os.Stderr, emailUsage)
+		return exitUsage
+	}
+	f, ok := parseEmailFlags(args[0], args[1:], os.Stderr)
+	if !ok {
+		return exitUsage
+	}
+
+	validate := config.Config.ValidateEmail
+	if args[0] == "test-send" {
+		validate = func(c config.Config) error {
+			if err := c.ValidateEmail(); err != nil {
+				return err
+			}
+			return c.ValidateDatabase()
+		}
+	}
+	a, code := setup(validate)
+	if a == nil {
+		return code
+	}
+	defer a.close()
+
+	catalog, err := i18n.New(i18n.Embedded(), a.log)
+	if err != nil {
+		a.log.Info("Error loading translations", zap.Error(err))
+		return exitError
+	}
+	c := a.config
+	m, err := email.SampleEmail(f.template, catalog.Translator(f.locale), c.Email.From, c.Email.PhysicalAddress, f.to, c.Server.BaseURL)
+	if err != nil {
+		a.log.Info("Error rendering email", zap.Error(err))
+		return exitError
+	}
+
+	if args[0] == "preview" {
+		if err := writeEmailPreview(f, m, os.Stdout); err != nil {
+			a.log.Info("Error writing email preview", zap.Error(err))
+			return exitError
+		}
+		return exitOK
+	}
+
+	awsConfig, err := a.awsConfig()
+	if err != nil {
+		a.log.Info("Error creating AWS config", zap.Error(err))
+		return exitError
+	}
+	db, err := a.connectDatabase()
+	if err != nil {
+		a.log.Info("Error connecting to database", zap.Error(err))
+		return exitError
+	}
+	if err := sendTestEmail(context.Background(), a.emailSender(awsConfig, db, nil, nil), db, f.template, m); err != nil {
+		a.log.Info("Error sending test email", zap.Error(err))
+		return exitError
+	}
+	a.log.Info("Sent test email", zap.String("template", f.template), zap.Stringer("to", f.to))
+	return exitOK
+}
+
+// parseEmailFlags of the email subcommand with the name, printing usage problems to errOut.
+// The template must be one of email.SampleTemplates, and test-send needs a valid -to address.
+func parseEmailFlags(name string, args []string, errOut io.Writer) (emailFlags, bool) {
+	var f emailFlags
+	fs := flag.NewFlagSet("email "+name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(errOut, emailUsage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&f.template, "template", "", "Name of the email template.")
+	fs.StringVar(&f.locale, "locale", i18n.DefaultLocale, "Locale to render the email in.")
+	var to string
+	if name == "preview" {
+		fs.StringVar(&f.format, "format", "html", "Part of the email to write to stdout, html or text.")
+		fs.StringVar(&f.out, "out", "", "Directory to write both parts to, as <template>.html and <template>.txt, instead of stdout.")
+	} else {
+		fs.StringVar(&to, "to", "", "Address to send the test email to.")
+	}
+	if err := fs.Parse(args); err != nil {
+		return f, false
+	}
+	f.to = model.Email(to)
+
+	var problems []string
+	if fs.NArg() > 0 {
+		problems = append(problems, fmt.Sprintf("unexpected arguments %v", strings.Join(fs.Args(), " ")))
+	}
+	if !isSampleTemplate(f.template) {
+		problems = append(problems, fmt.Sprintf("-template must be one of %v, not %q", strings.Join(email.SampleTemplates, ", "), f.template))
+	}
+	if name == "preview" && f.format != "html" && f.format != "text" {
+		problems = append(problems, fmt.Sprintf("-format must be html or text, not %q", f.format))
+	}
+	if name == "test-send" && !f.to.IsValid() {
+		problems = append(problems, fmt.Sprintf("-to must be an email address, not %q", to))
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			_, _ = fmt.Fprintln(errOut, p)
+		}
+		fs.Usage()
+		return f, false
+	}
+	return f, true
+}
+
+func isSampleTemplate(name string) bool {
+	for _, t := range email.SampleTemplates {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEmailPreview of the message to out in the format of the flags, or with -out, both parts to files
+// in that directory, which is created if it doesn't exist. The paths of the files are printed to out.
+func writeEmailPreview(f emailFlags, m email.Message, out io.Writer) error {
+	if f.out == "" {
+		part := m.HTML
+		if f.format == "text" {
+			part = m.Text
+		}
+		_, err := io.WriteString(out, part)
+		return err
+	}
+
+	if err := os.MkdirAll(f.out, 0755); err != nil {
+		return err
+	}
+	for _, file := range []struct{ ext, part string }{{".html", m.HTML}, {".txt", m.Text}} {
+		path := filepath.Join(f.out, f.template+file.ext)
+		if err := os.WriteFile(path, []byte(file.part), 0644); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(out, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendTestEmail of the template with the sender, with [Test] before the subject. Like test emails from the admin,
+// it's recorded in the send log as a test, so it's left out of send stats and doesn't count as sent.
+// Test emails to suppressed addresses aren't sent either, and are recorded as skipped, returning email.ErrSuppressed.
+func sendTestEmail(ctx context.Context, sender email.Sender, store sendLogger, template string, m email.Message) error {
+	m.Subject = "[Test] " + m.Subject
+
+	send := model.EmailSend{Email: m.To, Type: template + "_test_email", Test: true}
+	var err error
+	send.ProviderMessageID, err = sender.Send(ctx, m)
+	switch {
+	case errors.Is(err, email.ErrSuppressed):
+		send.Status = model.EmailSendStatusSkipped
+	case err != nil:
+		send.Status = model.EmailSendStatusFailed
+		send.Error = err.Error()
+	default:
+		send.Status = model.EmailSendStatusSent
+	}
+	if recordErr := store.RecordEmailSend(ctx, send); recordErr != nil && err == nil {
+		err = fmt.Errorf("error recording test email send: %w", recordErr)
+	}
+	return err
+}
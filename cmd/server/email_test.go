@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/model"
+)
+
+func TestParseEmailFlags(t *testing.T) {
+	t.Run("parses the preview flags, with the defaults", func(t *testing.T) {
+		is := is.New(t)
+
+		f, ok := parseEmailFlags("preview", []string{"-template", "welcome", "-out", "previews"}, &bytes.Buffer{})
+		is.True(ok)
+		is.Equal(emailFlags{format: "html", locale: "en", out: "previews", template: "welcome"}, f)
+	})
+
+	t.Run("rejects an unknown template, and lists the known ones", func(t *testing.T) {
+		is := is.New(t)
+
+		var errOut bytes.Buffer
+		_, ok := parseEmailFlags("preview", []string{"-template", "confirm"}, &errOut)
+		is.True(!ok)
+		is.True(strings.Contains(errOut.String(), `-template must be one of confirmation, newsletter, welcome, not "confirm"`))
+	})
+
+	t.Run("requires a template", func(t *testing.T) {
+		is := is.New(t)
+
+		var errOut bytes.Buffer
+		_, ok := parseEmailFlags("preview", nil, &errOut)
+		is.True(!ok)
+		is.True(strings.Contains(errOut.String(), `not ""`))
+	})
+
+	t.Run("requires a valid to address for test-send", func(t *testing.T) {
+		is := is.New(t)
+
+		var errOut bytes.Buffer
+		_, ok := parseEmailFlags("test-send", []string{"-template", "confirmation", "-to", "nope"}, &errOut)
+		is.True(!ok)
+		is.True(strings.Contains(errOut.String(), `-to must be an email address, not "nope"`))
+
+		f, ok := parseEmailFlags("test-send", []string{"-template", "confirmation", "-to", "me@example.com"}, &bytes.Buffer{})
+		is.True(ok)
+		is.Equal(model.Email("me@example.com"), f.to)
+	})
+
+	t.Run("rejects an unknown format and extra arguments", func(t *testing.T) {
+		is := is.New(t)
+
+		var errOut bytes.Buffer
+		_, ok := parseEmailFlags("preview", []string{"-template", "welcome", "-format", "pdf", "extra"}, &errOut)
+		is.True(!ok)
+		is.True(strings.Contains(errOut.String(), `-format must be html or text, not "pdf"`))
+		is.True(strings.Contains(errOut.String(), "unexpected arguments extra"))
+	})
+}
+
+func TestWriteEmailPreview(t *testing.T) {
+	m := email.Message{Subject: "Hi", HTML: "<p>Hi</p>", Text: "Hi\n"}
+
+	t.Run("writes both parts to files in the out directory, creating it, and prints their paths", func(t *testing.T) {
+		is := is.New(t)
+
+		dir := filepath.Join(t.TempDir(), "previews")
+		var out bytes.Buffer
+		err := writeEmailPreview(emailFlags{out: dir, template: "welcome"}, m, &out)
+		is.NoErr(err)
+
+		html, err := os.ReadFile(filepath.Join(dir, "welcome.html"))
+		is.NoErr(err)
+		is.Equal("<p>Hi</p>", string(html))
+		text, err := os.ReadFile(filepath.Join(dir, "welcome.txt"))
+		is.NoErr(err)
+		is.Equal("Hi\n", string(text))
+		is.Equal(filepath.Join(dir, "welcome.html")+"\n"+filepath.Join(dir, "welcome.txt")+"\n", out.String())
+	})
+
+	t.Run("writes the part in the format to out without a directory", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		is.NoErr(writeEmailPreview(emailFlags{format: "text", template: "welcome"}, m, &out))
+		is.Equal("Hi\n", out.String())
+
+		out.Reset()
+		is.NoErr(writeEmailPreview(emailFlags{format: "html", template: "welcome"}, m, &out))
+		is.Equal("<p>Hi</p>", out.String())
+	})
+}
+
+type testSenderMock struct {
+	err      error
+	messages []email.Message
+}
+
+func (s *testSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	s.messages = append(s.messages, m)
+	return "abc", s.err
+}
+
+type sendLoggerMock struct {
+	sends []model.EmailSend
+}
+
+func (s *sendLoggerMock) RecordEmailSend(ctx context.Context, send model.EmailSend) error {
+	s.sends = append(s.sends, send)
+	return nil
+}
+
+func TestSendTestEmail(t *testing.T) {
+	t.Run("sends the email marked as a test, and records it as a test send", func(t *testing.T) {
+		is := is.New(t)
+
+		sender := &testSenderMock{}
+		store := &sendLoggerMock{}
+		err := sendTestEmail(context.Background(), sender, store, "confirmation", email.Message{To: "me@example.com", Subject: "Confirm"})
+		is.NoErr(err)
+		is.Equal(1, len(sender.messages))
+		is.Equal("[Test] Confirm", sender.messages[0].Subject)
+		is.Equal([]model.EmailSend{{
+			Email: "me@example.com", Type: "confirmation_test_email", ProviderMessageID: "abc",
+			Status: model.EmailSendStatusSent, Test: true,
+		}}, store.sends)
+	})
+
+	t.Run("records a failed send, and returns the error", func(t *testing.T) {
+		is := is.New(t)
+
+		store := &sendLoggerMock{}
+		err := sendTestEmail(context.Background(), &testSenderMock{err: errors.New("oh no")}, store, "welcome",
+			email.Message{To: "me@example.com"})
+		is.True(err != nil)
+		is.Equal(1, len(store.sends))
+		is.Equal(model.EmailSendStatusFailed, store.sends[0].Status)
+		is.True(store.sends[0].Test)
+	})
+
+	t.Run("records a send to a suppressed address as skipped, and returns ErrSuppressed", func(t *testing.T) {
+		is := is.New(t)
+
+		store := &sendLoggerMock{}
+		err := sendTestEmail(context.Background(), &testSenderMock{err: email.ErrSuppressed}, store, "welcome",
+			email.Message{To: "me@example.com"})
+		is.True(errors.Is(err, email.ErrSuppressed))
+		is.Equal(1, len(store.sends))
+		is.Equal(model.EmailSendStatusSkipped, store.sends[0].Status)
+		is.Equal("", store.sends[0].Error)
+	})
+}
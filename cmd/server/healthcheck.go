@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// healthcheckCommand checks that the server running in the same container is ready, for Docker HEALTHCHECK and ECS,
+// because the image has no curl.
+func healthcheckCommand(args []string) int {
+	return healthcheck(args, os.Getenv, os.Stderr)
+}
+
+// healthcheck gets /ready on localhost, at PORT, or at WORKER_PORT with -worker, and is exitOK only for a 200.
+// Otherwise, why the server isn't ready goes to errOut, like the dependency that failed.
+// It only reads those variables with getenv, without the rest of the configuration, so it's fast and has no secrets.
+func healthcheck(args []string, getenv func(string) string, errOut io.Writer) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(errOut, "Usage: server healthcheck [-timeout 2s] [-worker]")
+		fs.PrintDefaults()
+	}
+	timeout := fs.Duration("timeout", 2*time.Second, "Timeout for the request.")
+	worker := fs.Bool("worker", false, "Check the internal server of the worker command, at WORKER_PORT.")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		if fs.NArg() > 0 {
+			fs.Usage()
+		}
+		return exitUsage
+	}
+
+	// The defaults are the ones of the configuration.
+	name, port := "PORT", 8080
+	if *worker {
+		name, port = "WORKER_PORT", 8090
+	}
+	if v := getenv(name); v != "" {
+		var err error
+		if port, err = strconv.Atoi(v); err != nil {
+			_, _ = fmt.Fprintf(errOut, "%v must be a number, not %q\n", name, v)
+			return exitError
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	url := "http://" + net.JoinHostPort("localhost", strconv.Itoa(port)) + "/ready"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		_, _ = fmt.Fprintln(errOut, "Error creating request:", err)
+		return exitError
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		_, _ = fmt.Fprintln(errOut, "Not ready:", err)
+		return exitError
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode == http.StatusOK {
+		return exitOK
+	}
+
+	var body struct {
+		Status     string
+		Dependency string
+		Error      string
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&body); err != nil || body.Status == "" {
+		_, _ = fmt.Fprintln(errOut, "Not ready, got status", res.StatusCode)
+		return exitError
+	}
+	switch {
+	case body.Dependency != "":
+		_, _ = fmt.Fprintf(errOut, "Not ready, %v is %v: %v\n", body.Dependency, body.Status, body.Error)
+	default:
+		_, _ = fmt.Fprintf(errOut, "Not ready, %v\n", body.Status)
+	}
+	return exitError
+}
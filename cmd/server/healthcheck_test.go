@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+type pingerMock struct {
+	err error
+}
+
+func (p *pingerMock) Ping(ctx context.Context) error {
+	return p.err
+}
+
+// newReadyServer with the readiness route, and the environment for healthcheck to reach it at PORT and WORKER_PORT.
+func newReadyServer(t *testing.T, rd *handlers.Readiness, p *pingerMock) func(string) string {
+	t.Helper()
+	mux := chi.NewMux()
+	handlers.Ready(mux, rd, p)
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(name string) string {
+		if name == "PORT" || name == "WORKER_PORT" {
+			return u.Port()
+		}
+		return ""
+	}
+}
+
+func TestHealthcheck(t *testing.T) {
+	t.Run("exits with 0 if the server is ready", func(t *testing.T) {
+		is := is.New(t)
+
+		rd := &handlers.Readiness{}
+		rd.SetReady()
+		getenv := newReadyServer(t, rd, &pingerMock{})
+
+		var errOut bytes.Buffer
+		is.Equal(exitOK, healthcheck(nil, getenv, &errOut))
+		is.Equal("", errOut.String())
+		is.Equal(exitOK, healthcheck([]string{"-worker"}, getenv, &errOut))
+	})
+
+	t.Run("exits with 1 and prints the failing dependency if the server isn't ready", func(t *testing.T) {
+		is := is.New(t)
+
+		rd := &handlers.Readiness{}
+		rd.SetReady()
+		getenv := newReadyServer(t, rd, &pingerMock{err: errors.New("connection refused")})
+
+		var errOut bytes.Buffer
+		is.Equal(exitError, healthcheck(nil, getenv, &errOut))
+		is.Equal("Not ready, database is unavailable: connection refused\n", errOut.String())
+	})
+
+	t.Run("exits with 1 while the server is starting up", func(t *testing.T) {
+		is := is.New(t)
+
+		getenv := newReadyServer(t, &handlers.Readiness{}, &pingerMock{})
+
+		var errOut bytes.Buffer
+		is.Equal(exitError, healthcheck([]string{"-timeout", "1s"}, getenv, &errOut))
+		is.Equal("Not ready, starting\n", errOut.String())
+	})
+
+	t.Run("exits with 1 if nothing is listening", func(t *testing.T) {
+		is := is.New(t)
+
+		s := httptest.NewServer(chi.NewMux())
+		u, err := url.Parse(s.URL)
+		is.NoErr(err)
+		s.Close()
+
+		var errOut bytes.Buffer
+		code := healthcheck(nil, func(string) string { return u.Port() }, &errOut)
+		is.Equal(exitError, code)
+		is.True(bytes.HasPrefix(errOut.Bytes(), []byte("Not ready: ")))
+	})
+
+	t.Run("exits with 2 on unknown flags and arguments", func(t *testing.T) {
+		is := is.New(t)
+
+		var errOut bytes.Buffer
+		is.Equal(exitUsage, healthcheck([]string{"-nope"}, func(string) string { return "" }, &errOut))
+		is.Equal(exitUsage, healthcheck([]string{"now"}, func(string) string { return "" }, &errOut))
+	})
+}
@@ -1,172 +1,84 @@
-// Package main is the entry point to the server. It reads configuration, sets up logging and error handling,
-// handles signals from the OS, and starts and stops the server.
+// Package main is the entry point to the app. It has subcommands to run the server, run only the job queue worker,
+// migrate the database, check the configuration, check that a running server is ready, preview and test-send emails,
+// and print the version, sharing the setup of configuration, logging, AWS, and the database.
 package main
 
 import (
-	"canvas/messaging"
-	"canvas/server"
-	"canvas/storage"
-	"context"
 	"fmt"
+	"io"
 	"os"
-	"os/signal"
-	"strconv"
-	"syscall"
-	"time"
-
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/aws/smithy-go/logging"
-	"github.com/maragudk/env"
-	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
 )
 
 func main() {
-	os.Exit(start())
+	os.Exit(dispatch(os.Args[1:], commands, os.Stderr))
 }
 
-func start() int {
-	_ = env.Load()
-
-	log, err := createLogger("development")
-
-	if err != nil {
-		fmt.Println("Error setting up logger: ", err)
-		return 1
-	}
-
-	defer func() {
-		_ = log.Sync()
-	}()
-
-	host := env.GetStringOrDefault("HOST", "localhost")
-	port := env.GetIntOrDefault("PORT", 8080)
-
-	awsConfig, err := config.LoadDefaultConfig(context.Background(),
-		config.WithLogger(createAWSLogAdapter(log)),
-		config.WithEndpointResolver(createAWSEndpointResolver()),
-	)
-	if err != nil {
-		log.Info("Error creating AWS config", zap.Error(err))
-		return 1
-	}
-
-	s := server.New(server.Options{
-		Database: createDatabase(log),
-		Host:     host,
-		Log:      log,
-		Port:     port,
-		Queue:    createQueue(log, awsConfig),
-	})
-
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
-	defer stop()
-	eg, ctx := errgroup.WithContext(ctx)
-
-	eg.Go(func() error {
-		if err := s.Start(); err != nil {
-			log.Info("Error starting server", zap.Error(err))
-			return err
-		}
-		return nil
-	})
-
-	<-ctx.Done()
-
-	eg.Go(func() error {
-		if err := s.Stop(); err != nil {
-			log.Info("Error stopping server", zap.Error(err))
-			return err
-		}
-		return nil
-	})
-
-	if err := eg.Wait(); err != nil {
-		return 1
-	}
-
-	return 0
-}
-
-func createLogger(env string) (*zap.Logger, error) {
-	switch env {
-	case "production":
-		return zap.NewProduction()
-	case "development":
-		return zap.NewDevelopment()
-	default:
-		return zap.NewNop(), nil
-	}
-}
+// Exit codes of the commands.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	// exitPending is for migrate status with pending migrations.
+	exitPending = 3
+	// exitForced is for a shutdown that timed out, or was cut short by another signal.
+	exitForced = 4
+	// exitProbe is for check with a probe that failed.
+	exitProbe = 5
+)
 
-func createAWSLogAdapter(log *zap.Logger) logging.LoggerFunc {
-	return func(classification logging.Classification, format string, v ...interface{}) {
-		switch classification {
-		case logging.Debug:
-			log.Sugar().Debugf(format, v...)
-		case logging.Warn:
-			log.Sugar().Warnf(format, v...)
-		}
-	}
+// command gets the arguments after its name, and returns the exit code.
+type command func(args []string) int
+
+var commands = map[string]command{
+	"check":       checkCommand,
+	"email":       emailCommand,
+	"healthcheck": healthcheckCommand,
+	"migrate":     migrateCommand,
+	"serve":       serveCommand,
+	"version":     versionCommand,
+	"worker":      workerCommand,
 }
 
-// createAWSEndpointResolver used for local development endpoints.
-// See https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk/endpoints/
-func createAWSEndpointResolver() aws.EndpointResolverFunc {
-	sqsEndpointURL := env.GetStringOrDefault("SQS_ENDPOINT_URL", "")
-
-	return func(service, region string) (aws.Endpoint, error) {
-		if sqsEndpointURL != "" && service == sqs.ServiceID {
-			return aws.Endpoint{
-				URL: sqsEndpointURL,
-			}, nil
-		}
-		// Fallback to default endpoint
-		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+const usage = `Usage: server [command]
+
+Commands:
+  serve       Run the HTTP server, and the job queue worker unless SERVER_RUN_WORKER is false. The default.
+              It waits for the database and queues first, unless -skip-wait is given, and with MIGRATE_ON_START,
+              migrates the database. Otherwise, it doesn't start with pending migrations. With EMAIL_VERIFY_IDENTITY,
+              it checks that emails can be sent from EMAIL_FROM.
+  worker      Run only the job queue worker, with an internal server for the health check and metrics.
+              Also with -skip-wait.
+  migrate     Migrate the database with up, down, or to <version>, or show pending migrations with status.
+              The status exit code is 3 if there are pending migrations.
+  check       Validate the configuration without starting anything, and with -probe, reach the database and queues,
+              and check the email sending identity.
+              Use -json for a JSON report. The exit code is 1 for invalid configuration, and 5 for failed probes.
+  email       Render a sample email with preview, to stdout or with -out to .html and .txt files, or send one
+              with test-send -to <address>, through EMAIL_BACKEND. Test sends are recorded as tests in the send log.
+  healthcheck Check that the server on PORT is ready, or the worker on WORKER_PORT with -worker, for container health checks.
+              The exit code is 1 if it isn't, with the reason on stderr.
+  version     Print the build info. Also -version and --version.
+`
+
+// dispatch to the command named by the first argument, with the rest of the arguments.
+// Without arguments, it's serve, like before there were commands.
+// The -version and --version flags are the version command, which doesn't need any configuration.
+// Unknown commands print the usage to out.
+func dispatch(args []string, commands map[string]command, out io.Writer) int {
+	if len(args) == 0 {
+		args = []string{"serve"}
 	}
-}
-
-func getStringOrDefault(name, defaultV string) string {
-	v, ok := os.LookupEnv(name)
-	if !ok {
-		return defaultV
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(out, usage)
+		return exitOK
+	case "-version", "--version":
+		args = []string{"version"}
 	}
-	return v
-}
-
-func getIntOrDefault(name string, defaultV int) int {
-	v, ok := os.LookupEnv(name)
+	c, ok := commands[args[0]]
 	if !ok {
-		return defaultV
-	}
-	vAsInt, err := strconv.Atoi(v)
-	if err != nil {
-		return defaultV
+		_, _ = fmt.Fprintf(out, "Unknown command %q.\n\n%v", args[0], usage)
+		return exitUsage
 	}
-	return vAsInt
-}
-
-func createDatabase(log *zap.Logger) *storage.Database {
-	return storage.NewDatabase(storage.NewDatabaseOptions{
-		Host:                  env.GetStringOrDefault("DB_HOST", "localhost"),
-		Port:                  env.GetIntOrDefault("DB_PORT", 5432),
-		User:                  env.GetStringOrDefault("DB_USER", ""),
-		Password:              env.GetStringOrDefault("DB_PASSWORD", ""),
-		Name:                  env.GetStringOrDefault("DB_NAME", ""),
-		MaxOpenConnections:    env.GetIntOrDefault("DB_MAX_OPEN_CONNECTIONS", 10),
-		MaxIdleConnections:    env.GetIntOrDefault("DB_MAX_IDLE_CONNECTIONS", 10),
-		ConnectionMaxLifetime: env.GetDurationOrDefault("DB_CONNECTION_MAX_LIFETIME", time.Hour),
-		Log:                   log,
-	})
-}
-
-func createQueue(log *zap.Logger, awsConfig aws.Config) *messaging.Queue {
-	return messaging.NewQueue(messaging.NewQueueOptions{
-		Config:   awsConfig,
-		Log:      log,
-		Name:     env.GetStringOrDefault("QUEUE_NAME", "jobs"),
-		WaitTime: env.GetDurationOrDefault("QUEUE_WAIT_TIME", 20*time.Second),
-	})
+	return c(args[1:])
 }
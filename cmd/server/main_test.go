@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestDispatch(t *testing.T) {
+	// newCommands that record the command and arguments they were called with.
+	newCommands := func(called *[]string) map[string]command {
+		commands := map[string]command{}
+		for _, name := range []string{"serve", "migrate", "version"} {
+			name := name
+			commands[name] = func(args []string) int {
+				*called = append(append(*called, name), args...)
+				return 7
+			}
+		}
+		return commands
+	}
+
+	t.Run("runs the named command with the rest of the arguments, and returns its exit code", func(t *testing.T) {
+		is := is.New(t)
+
+		var called []string
+		var out bytes.Buffer
+		code := dispatch([]string{"migrate", "to", "1-a"}, newCommands(&called), &out)
+		is.Equal(7, code)
+		is.Equal([]string{"migrate", "to", "1-a"}, called)
+		is.Equal("", out.String())
+	})
+
+	t.Run("serves without arguments", func(t *testing.T) {
+		is := is.New(t)
+
+		var called []string
+		code := dispatch(nil, newCommands(&called), &bytes.Buffer{})
+		is.Equal(7, code)
+		is.Equal([]string{"serve"}, called)
+	})
+
+	t.Run("runs only the version command for the version flags", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, flag := range []string{"-version", "--version"} {
+			var called []string
+			code := dispatch([]string{flag, "serve"}, newCommands(&called), &bytes.Buffer{})
+			is.Equal(7, code)
+			is.Equal([]string{"version"}, called)
+		}
+	})
+
+	t.Run("prints the usage for unknown commands", func(t *testing.T) {
+		is := is.New(t)
+
+		var called []string
+		var out bytes.Buffer
+		code := dispatch([]string{"migrat"}, newCommands(&called), &out)
+		is.Equal(exitUsage, code)
+		is.Equal(0, len(called))
+		is.True(strings.HasPrefix(out.String(), `Unknown command "migrat".`))
+		is.True(strings.Contains(out.String(), "Usage: server [command]"))
+	})
+
+	t.Run("prints the usage for help", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		code := dispatch([]string{"-h"}, newCommands(new([]string)), &out)
+		is.Equal(exitOK, code)
+		is.True(strings.HasPrefix(out.String(), "Usage: server [command]"))
+	})
+
+	t.Run("has all the commands in the usage", func(t *testing.T) {
+		is := is.New(t)
+
+		for name := range commands {
+			is.True(strings.Contains(usage, "\n  "+name+" "))
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"go.uber.org/zap"
+
+	"canvas/config"
+	"canvas/storage"
+)
+
+// migrator of the database schema, satisfied by *storage.Database.
+type migrator interface {
+	MigrationVersion(ctx context.Context) (string, error)
+	MigrateUp(ctx context.Context, fsys fs.FS) error
+	MigrateDown(ctx context.Context, fsys fs.FS) error
+	MigrateTo(ctx context.Context, fsys fs.FS, version string) error
+}
+
+// lockingMigrator is a migrator that can hold an advisory lock while migrating, satisfied by *storage.Database.
+type lockingMigrator interface {
+	migrator
+	WithAdvisoryLock(ctx context.Context, key string, f func(ctx context.Context) error) error
+}
+
+// migrationsLockKey of the advisory lock held while migrating on start.
+const migrationsLockKey = "migrations"
+
+const migrateUsage = "Usage: server migrate up|down|to <version>|status"
+
+// migrateCommand migrates the database with the migrations embedded in the binary, or shows which are pending.
+// It only needs the database configuration, not the secrets of the server.
+func migrateCommand(args []string) int {
+	if !validMigrateArgs(args) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return exitUsage
+	}
+
+	a, code := setup(config.Config.ValidateDatabase)
+	if a == nil {
+		return code
+	}
+	defer a.close()
+
+	db, err := a.connectDatabase()
+	if err != nil {
+		a.log.Info("Error connecting to database", zap.Error(err))
+		return exitError
+	}
+
+	return runMigrate(context.Background(), db, storage.Migrations(), args, os.Stdout, a.log)
+}
+
+func validMigrateArgs(args []string) bool {
+	switch {
+	case len(args) == 1:
+		return args[0] == "up" || args[0] == "down" || args[0] == "status"
+	case len(args) == 2:
+		return args[0] == "to"
+	default:
+		return false
+	}
+}
+
+// runMigrate with the arguments, which validMigrateArgs has checked.
+func runMigrate(ctx context.Context, m migrator, fsys fs.FS, args []string, out io.Writer, log *zap.Logger) int {
+	var err error
+	switch args[0] {
+	case "up":
+		err = m.MigrateUp(ctx, fsys)
+	case "down":
+		err = m.MigrateDown(ctx, fsys)
+	case "to":
+		err = m.MigrateTo(ctx, fsys, args[1])
+	case "status":
+		return migrationStatus(ctx, m, fsys, out, log)
+	}
+	if err != nil {
+		log.Info("Error migrating", zap.Error(err))
+		return exitError
+	}
+
+	version, err := m.MigrationVersion(ctx)
+	if err != nil {
+		log.Info("Error getting migration version", zap.Error(err))
+		return exitError
+	}
+	log.Info("Migrated", zap.String("version", version))
+	return exitOK
+}
+
+// migrationStatus prints the current version and the pending migrations.
+// The exit code is exitPending if there are any, so a deploy can check for them before starting the server.
+func migrationStatus(ctx context.Context, m migrator, fsys fs.FS, out io.Writer, log *zap.Logger) int {
+	version, err := m.MigrationVersion(ctx)
+	if err != nil {
+		log.Info("Error getting migration version", zap.Error(err))
+		return exitError
+	}
+	pending, err := storage.PendingMigrations(fsys, version)
+	if err != nil {
+		log.Info("Error reading migrations", zap.Error(err))
+		return exitError
+	}
+
+	if version == "" {
+		version = "none"
+	}
+	_, _ = fmt.Fprintln(out, "Current version:", version)
+	if len(pending) == 0 {
+		_, _ = fmt.Fprintln(out, "No pending migrations.")
+		return exitOK
+	}
+	_, _ = fmt.Fprintln(out, "Pending migrations:")
+	for _, p := range pending {
+		_, _ = fmt.Fprintln(out, "  -", p)
+	}
+	return exitPending
+}
+
+// migrateOnStart migrates the database up to the latest version in fsys while holding the migrations lock,
+// logging the versions it applied. Replicas that start at the same time wait for the lock,
+// and then have nothing left to apply.
+func migrateOnStart(ctx context.Context, m lockingMigrator, fsys fs.FS, log *zap.Logger) error {
+	return m.WithAdvisoryLock(ctx, migrationsLockKey, func(ctx context.Context) error {
+		version, err := m.MigrationVersion(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting migration version: %w", err)
+		}
+		pending, err := storage.PendingMigrations(fsys, version)
+		if err != nil {
+			return fmt.Errorf("error reading migrations: %w", err)
+		}
+		if len(pending) == 0 {
+			log.Info("No pending migrations", zap.String("version", version))
+			return nil
+		}
+
+		if err := m.MigrateUp(ctx, fsys); err != nil {
+			return fmt.Errorf("error migrating: %w", err)
+		}
+		log.Info("Migrated", zap.Strings("applied", pending), zap.String("version", pending[len(pending)-1]))
+		return nil
+	})
+}
+
+// checkSchema of the database is at the latest version in fsys, for starting without MIGRATE_ON_START.
+// If it's not, this version of the app expects tables the database doesn't have yet, and retrying won't fix that.
+func checkSchema(ctx context.Context, m migrator, fsys fs.FS) error {
+	version, err := m.MigrationVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting migration version: %w", err)
+	}
+	pending, err := storage.PendingMigrations(fsys, version)
+	if err != nil {
+		return permanentError{fmt.Errorf("error reading migrations: %w", err)}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if version == "" {
+		version = "none"
+	}
+	return permanentError{fmt.Errorf("database schema is at version %v, but this version of the app needs %v: "+
+		"run the migrate command first, or set MIGRATE_ON_START=true", version, pending[len(pending)-1])}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type migratorMock struct {
+	version string
+	err     error
+	called  []string
+}
+
+func (m *migratorMock) MigrationVersion(ctx context.Context) (string, error) {
+	return m.version, m.err
+}
+
+func (m *migratorMock) MigrateUp(ctx context.Context, fsys fs.FS) error {
+	m.called = append(m.called, "up")
+	m.version = "2-b"
+	return m.err
+}
+
+func (m *migratorMock) MigrateDown(ctx context.Context, fsys fs.FS) error {
+	m.called = append(m.called, "down")
+	m.version = ""
+	return m.err
+}
+
+func (m *migratorMock) MigrateTo(ctx context.Context, fsys fs.FS, version string) error {
+	m.called = append(m.called, "to "+version)
+	m.version = version
+	return m.err
+}
+
+func TestRunMigrate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1-a.up.sql":   {},
+		"1-a.down.sql": {},
+		"2-b.up.sql":   {},
+		"2-b.down.sql": {},
+	}
+
+	t.Run("status exits with OK and no pending migrations at the latest version", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		code := runMigrate(context.Background(), &migratorMock{version: "2-b"}, fsys, []string{"status"}, &out, zap.NewNop())
+		is.Equal(exitOK, code)
+		is.Equal("Current version: 2-b\nNo pending migrations.\n", out.String())
+	})
+
+	t.Run("status exits with pending and lists the pending migrations", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		code := runMigrate(context.Background(), &migratorMock{version: "1-a"}, fsys, []string{"status"}, &out, zap.NewNop())
+		is.Equal(exitPending, code)
+		is.Equal("Current version: 1-a\nPending migrations:\n  - 2-b\n", out.String())
+	})
+
+	t.Run("status lists all migrations for a database that hasn't been migrated", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		code := runMigrate(context.Background(), &migratorMock{}, fsys, []string{"status"}, &out, zap.NewNop())
+		is.Equal(exitPending, code)
+		is.Equal("Current version: none\nPending migrations:\n  - 1-a\n  - 2-b\n", out.String())
+	})
+
+	t.Run("status exits with an error if the version can't be read", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		m := &migratorMock{err: errors.New("connection refused")}
+		code := runMigrate(context.Background(), m, fsys, []string{"status"}, &out, zap.NewNop())
+		is.Equal(exitError, code)
+		is.Equal("", out.String())
+	})
+
+	t.Run("migrates up, down, and to a version", func(t *testing.T) {
+		is := is.New(t)
+
+		m := &migratorMock{}
+		for _, args := range [][]string{{"up"}, {"down"}, {"to", "1-a"}} {
+			code := runMigrate(context.Background(), m, fsys, args, &bytes.Buffer{}, zap.NewNop())
+			is.Equal(exitOK, code)
+		}
+		is.Equal([]string{"up", "down", "to 1-a"}, m.called)
+	})
+
+	t.Run("exits with an error if migrating fails", func(t *testing.T) {
+		is := is.New(t)
+
+		m := &migratorMock{err: errors.New("syntax error")}
+		code := runMigrate(context.Background(), m, fsys, []string{"up"}, &bytes.Buffer{}, zap.NewNop())
+		is.Equal(exitError, code)
+	})
+}
+
+func TestMigrateCommand(t *testing.T) {
+	t.Run("exits with usage for invalid arguments before connecting", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, args := range [][]string{nil, {"sideways"}, {"to"}, {"up", "now"}} {
+			is.Equal(exitUsage, migrateCommand(args))
+		}
+	})
+}
+
+// sharedDatabaseMock is a database that replicas migrate at the same time, with the advisory lock as a mutex.
+type sharedDatabaseMock struct {
+	lock sync.Mutex
+	// waiting gets a value when a caller starts waiting for the lock.
+	waiting chan struct{}
+	// migrating blocks MigrateUp until it's closed, if it's not nil.
+	migrating chan struct{}
+
+	mu      sync.Mutex
+	version string
+	ups     int
+}
+
+func (d *sharedDatabaseMock) WithAdvisoryLock(ctx context.Context, key string, f func(ctx context.Context) error) error {
+	d.waiting <- struct{}{}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return f(ctx)
+}
+
+func (d *sharedDatabaseMock) MigrationVersion(ctx context.Context) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.version, nil
+}
+
+func (d *sharedDatabaseMock) MigrateUp(ctx context.Context, fsys fs.FS) error {
+	if d.migrating != nil {
+		<-d.migrating
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ups++
+	d.version = "2-b"
+	return nil
+}
+
+func (d *sharedDatabaseMock) MigrateDown(ctx context.Context, fsys fs.FS) error {
+	return nil
+}
+
+func (d *sharedDatabaseMock) MigrateTo(ctx context.Context, fsys fs.FS, version string) error {
+	return nil
+}
+
+func TestMigrateOnStart(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1-a.up.sql":   {},
+		"1-a.down.sql": {},
+		"2-b.up.sql":   {},
+		"2-b.down.sql": {},
+	}
+
+	t.Run("migrates once with two starters, the second waiting for the first", func(t *testing.T) {
+		is := is.New(t)
+
+		d := &sharedDatabaseMock{waiting: make(chan struct{}, 2), migrating: make(chan struct{})}
+		core, logs := observer.New(zapcore.InfoLevel)
+		log := zap.New(core)
+
+		errs := make(chan error, 2)
+		go func() {
+			errs <- migrateOnStart(context.Background(), d, fsys, log)
+		}()
+		<-d.waiting
+		go func() {
+			errs <- migrateOnStart(context.Background(), d, fsys, log)
+		}()
+		<-d.waiting
+
+		// Both are started, and the first holds the lock until it's done migrating.
+		close(d.migrating)
+		is.NoErr(<-errs)
+		is.NoErr(<-errs)
+
+		is.Equal(1, d.ups)
+		is.Equal("2-b", d.version)
+		migrated := logs.FilterMessage("Migrated").All()
+		is.Equal(1, len(migrated))
+		is.Equal([]interface{}{"1-a", "2-b"}, migrated[0].ContextMap()["applied"])
+		is.Equal(1, logs.FilterMessage("No pending migrations").Len())
+	})
+
+	t.Run("doesn't migrate at the latest version", func(t *testing.T) {
+		is := is.New(t)
+
+		d := &sharedDatabaseMock{waiting: make(chan struct{}, 1), version: "2-b"}
+		is.NoErr(migrateOnStart(context.Background(), d, fsys, zap.NewNop()))
+		is.Equal(0, d.ups)
+	})
+}
+
+func TestCheckSchema(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1-a.up.sql":   {},
+		"1-a.down.sql": {},
+		"2-b.up.sql":   {},
+		"2-b.down.sql": {},
+	}
+
+	t.Run("is fine at the latest version, or later", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(checkSchema(context.Background(), &migratorMock{version: "2-b"}, fsys))
+		is.NoErr(checkSchema(context.Background(), &migratorMock{version: "3-c"}, fsys))
+	})
+
+	t.Run("errors permanently with pending migrations, saying how to migrate", func(t *testing.T) {
+		is := is.New(t)
+
+		err := checkSchema(context.Background(), &migratorMock{version: "1-a"}, fsys)
+		is.True(err != nil)
+		is.Equal("database schema is at version 1-a, but this version of the app needs 2-b: "+
+			"run the migrate command first, or set MIGRATE_ON_START=true", err.Error())
+		var permanent permanentError
+		is.True(errors.As(err, &permanent))
+	})
+
+	t.Run("retries errors getting the version", func(t *testing.T) {
+		is := is.New(t)
+
+		err := checkSchema(context.Background(), &migratorMock{err: errors.New("connection refused")}, fsys)
+		is.True(err != nil)
+		var permanent permanentError
+		is.True(!errors.As(err, &permanent))
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// scheduleStore records the runs of schedules and enqueues their jobs, like storage.Database through the outbox.
+type scheduleStore interface {
+	GetScheduleLastRun(ctx context.Context, name string) (time.Time, error)
+	FireSchedule(ctx context.Context, name string, at time.Time, m model.Message) (bool, error)
+}
+
+// scheduler for the jobs in SCHEDULES, enqueueing them through store, logged with their next runs.
+// An invalid cron expression is an error, so startup fails instead of the job quietly never running.
+func (a *app) scheduler(store scheduleStore) (*messaging.Scheduler, error) {
+	c := a.config.Schedule
+	var schedules []messaging.Schedule
+	for _, j := range c.ScheduledJobs() {
+		schedules = append(schedules, messaging.Schedule{
+			Name:       j.Name,
+			Expression: j.Expression,
+			Message:    model.Message{"job": j.Job},
+		})
+	}
+	s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+		Interval:    c.Interval,
+		Log:         a.logger("scheduler"),
+		RunMisfired: c.RunMisfired,
+		Schedules:   schedules,
+		Store:       store,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	next := s.NextRuns()
+	registered := make([]string, 0, len(next))
+	for name, at := range next {
+		registered = append(registered, fmt.Sprintf("%v next at %v", name, at.Format(time.RFC3339)))
+	}
+	sort.Strings(registered)
+	a.log.Info("Scheduled jobs", zap.Strings("schedules", registered))
+	return s, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/config"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// queueScheduleStore records the last runs in memory, and enqueues the jobs on a queue right away,
+// like the database does through the outbox and the relay.
+type queueScheduleStore struct {
+	mutex    sync.Mutex
+	lastRuns map[string]time.Time
+	queue    *messaging.MemoryQueue
+}
+
+func (s *queueScheduleStore) GetScheduleLastRun(ctx context.Context, name string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastRuns[name], nil
+}
+
+func (s *queueScheduleStore) FireSchedule(ctx context.Context, name string, at time.Time, m model.Message) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.lastRuns[name].Before(at) {
+		return false, nil
+	}
+	s.lastRuns[name] = at
+	return true, s.queue.Send(ctx, m)
+}
+
+func TestApp_Scheduler(t *testing.T) {
+	newApp := func(log *zap.Logger, jobs ...string) *app {
+		return &app{log: log, config: config.Config{Schedule: config.Schedule{Jobs: jobs, Interval: 10 * time.Millisecond}}}
+	}
+
+	t.Run("enqueues scheduled jobs when they're due, until stopped", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		store := &queueScheduleStore{lastRuns: map[string]time.Time{}, queue: queue}
+
+		s, err := newApp(zap.New(core), "ping=@every 1s=ping", "digest=0 8 * * 1=weekly_digest").scheduler(store)
+		is.NoErr(err)
+		is.Equal(1, logs.FilterMessage("Scheduled jobs").Len())
+		registered := logs.FilterMessage("Scheduled jobs").All()[0].ContextMap()["schedules"].([]interface{})
+		is.Equal(2, len(registered))
+
+		step := startAll("scheduler", s.Start)
+		deadline := time.Now().Add(3 * time.Second)
+		for queue.Len() == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		is.NoErr(step.stop(context.Background()))
+
+		rm, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.True(rm != nil)
+		is.Equal("ping", rm.Message["job"])
+	})
+
+	t.Run("errors on an invalid cron expression", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := newApp(zap.NewNop(), "digest=0 8 * *=weekly_digest").scheduler(&queueScheduleStore{})
+		is.True(err != nil)
+	})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"canvas/config"
+	"canvas/disposable"
+	"canvas/handlers"
+	"canvas/i18n"
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/server"
+	"canvas/sessions"
+	"canvas/views"
+)
+
+// serveCommand runs the HTTP server and the outbox relay, and the job queue worker if SERVER_RUN_WORKER is set,
+// until SIGTERM or SIGINT. With WORKER_ONLY, it's the worker command instead.
+//
+// Startup is in phases: loading the configuration, connecting to the database and migrating it with MIGRATE_ON_START,
+// or else checking that it's migrated, setting up the queues, and starting the HTTP server, which is only ready
+// for traffic at /ready after that. With -skip-wait, the HTTP server starts first, and the other phases are tried once,
+// so the app can start in degraded mode.
+func serveCommand(args []string) int {
+	skipWait, ok := parseStartupFlags("serve", args)
+	if !ok {
+		return exitUsage
+	}
+
+	a, code := setup(validateServe)
+	if a == nil {
+		return code
+	}
+	defer a.close()
+
+	if a.config.Worker.Only {
+		a.log.Info("Running only the job queue worker, because WORKER_ONLY is set")
+		return a.worker(skipWait)
+	}
+
+	log := a.log
+	cfg := a.config
+
+	logLevel := a.logLevel()
+
+	awsConfig, err := a.awsConfig()
+	if err != nil {
+		log.Info("Error creating AWS config", zap.Error(err))
+		return exitError
+	}
+
+	registry := prometheus.NewRegistry()
+
+	catalog, err := i18n.New(i18n.Embedded(), log)
+	if err != nil {
+		log.Info("Error loading translations", zap.Error(err))
+		return exitError
+	}
+
+	// The embedded translations are parsed above either way, so broken ones stop the app from starting.
+	var viewsCatalog i18n.Loader = catalog
+	if cfg.Server.ViewsDev {
+		log.Info("Reloading translations from disk on every request")
+		viewsCatalog = i18n.NewReloader(os.DirFS("i18n/locales"), log)
+		views.StrictNonces = true
+	}
+
+	db, err := a.openDatabase(registry)
+	if err != nil {
+		log.Info("Error opening database", zap.Error(err))
+		return exitError
+	}
+	queue, deadLetterQueue := a.queues(awsConfig)
+	eventsQueue := a.eventsQueue(awsConfig)
+	quota := a.sesQuota(awsConfig, registry)
+	emailSender := a.emailSender(awsConfig, db, quota, registry)
+	emailPolicy, disposableDomains := a.signupEmailPolicy(registry)
+
+	health := a.healthMonitor(db)
+
+	errorReporter, err := a.errorReporter()
+	if err != nil {
+		log.Info("Error setting up error reporting", zap.Error(err))
+		return exitError
+	}
+	tracingProvider := a.tracing()
+
+	emitter, err := a.metricsEmitter(awsConfig, registry)
+	if err != nil {
+		log.Info("Error setting up metrics", zap.Error(err))
+		return exitError
+	}
+
+	featureFlags, err := a.flags()
+	if err != nil {
+		log.Info("Error loading feature flags", zap.Error(err))
+		return exitError
+	}
+
+	sessionManager := sessions.NewManager(sessions.NewManagerOptions{
+		Lifetime: cfg.Server.SessionLifetime,
+		Log:      a.logger("server"),
+		Secret:   []byte(cfg.Server.SessionSecret),
+		Store:    db,
+	})
+
+	baseURL := cfg.Server.BaseURL
+
+	// Link previews need an absolute image URL, so a path is on the base URL.
+	siteImage := cfg.Server.SiteImageURL
+	if strings.HasPrefix(siteImage, "/") {
+		siteImage = strings.TrimSuffix(baseURL, "/") + siteImage
+	}
+	views.SiteMeta = views.PageMetaProps{
+		Description: cfg.Server.SiteDescription,
+		Image:       siteImage,
+		TwitterCard: views.TwitterCard(cfg.Server.SiteTwitterCard),
+	}
+
+	scheduler, err := a.scheduler(db)
+	if err != nil {
+		log.Info("Error setting up scheduled jobs", zap.Error(err))
+		return exitError
+	}
+
+	var loadShed *handlers.LoadShedOptions
+	if cfg.Server.LoadShedAfter > 0 {
+		loadShed = &handlers.LoadShedOptions{After: cfg.Server.LoadShedAfter, Stats: db}
+	}
+
+	readiness := &handlers.Readiness{}
+	s := server.New(server.Options{
+		ACME:                        a.acmeOptions(db),
+		AccessLogQuietSampleEvery:   cfg.Server.AccessLogQuietSampleEvery,
+		AdminPasswordHash:           []byte(cfg.Server.AdminPasswordHash),
+		BaseURL:                     baseURL,
+		Catalog:                     viewsCatalog,
+		CORSAllowedOrigins:          cfg.Server.CORSAllowedOrigins,
+		Database:                    db,
+		DegradedCacheTTL:            cfg.Server.DegradedCacheTTL,
+		EmbedPartnerOrigins:         cfg.Server.EmbedPartnerOrigins,
+		EmailFrom:                   cfg.Email.From,
+		EmailPhysicalAddress:        cfg.Email.PhysicalAddress,
+		EmailSender:                 emailSender,
+		ErrorReporter:               errorReporter,
+		Flags:                       featureFlags,
+		Health:                      health,
+		Host:                        cfg.Server.Host,
+		LoadShed:                    loadShed,
+		Log:                         a.logger("server"),
+		LogLevel:                    logLevel,
+		Metrics:                     registry,
+		NewsletterLocation:          cfg.Schedule.NewsletterLocation(),
+		Port:                        cfg.Server.Port,
+		Queue:                       queue,
+		Readiness:                   readiness,
+		RobotsDisallowAll:           cfg.Server.RobotsDisallowAll,
+		Scheduler:                   scheduler,
+		SESTransientBounceThreshold: cfg.Server.SESTransientBounceThreshold,
+		SESTransientBounceWindow:    cfg.Server.SESTransientBounceWindow,
+		Sessions:                    sessionManager,
+		TrustedProxies:              cfg.Server.TrustedProxyNetworks(),
+		TwoStepConfirm:              cfg.Server.TwoStepConfirm,
+		SignupCaptcha:               createCaptchaVerifier(cfg.Signup),
+		SignupCaptchaFailOpen:       cfg.Signup.CaptchaFailOpen,
+		SignupEmailPolicy:           emailPolicy,
+		SignupFormSecret:            []byte(cfg.Signup.FormSecret),
+		SignupMaxFormAge:            cfg.Signup.MaxFormAge,
+		SignupMinFillTime:           cfg.Signup.MinFillTime,
+		SignupThrottleDatabase:      cfg.Signup.ThrottleDatabase,
+		Tracing:                     tracingProvider,
+		TrackingSecret:              []byte(cfg.Server.TrackingSecret),
+		UnsubscribeSecret:           []byte(cfg.Server.UnsubscribeSecret),
+		WebhookAllowPrivateNetworks: cfg.Webhook.AllowPrivateNetworks,
+	})
+
+	relayOptions := messaging.NewRelayOptions{
+		Log:       a.logger("messaging"),
+		Outbox:    db,
+		Queue:     queue,
+		Retention: cfg.Queue.OutboxRetention,
+	}
+	queues := []*messaging.Queue{queue, deadLetterQueue}
+	if eventsQueue != nil {
+		relayOptions.Events = eventsQueue
+		queues = append(queues, eventsQueue)
+	}
+	relay := messaging.NewRelay(relayOptions)
+
+	var runner *jobs.Runner
+	if cfg.Server.RunWorker {
+		runner = a.jobRunner(jobRunnerOptions{
+			Catalog:         catalog,
+			Database:        db,
+			DeadLetterQueue: deadLetterQueue,
+			EmailSender:     emailSender,
+			ErrorReporter:   errorReporter,
+			Flags:           featureFlags,
+			Health:          health,
+			Metrics:         registry,
+			Queue:           queue,
+			Quota:           quota,
+			Tracing:         tracingProvider,
+		})
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	// Diagnostics are handled from the start, so a startup that hangs can be looked into too.
+	d := diagnostics{log: log, logLevel: logLevel, stats: []func() zap.Field{serverStats(s), databaseStats(db)}}
+	if runner != nil {
+		d.stats = append(d.stats, runnerStats(runner))
+	}
+	eg.Go(func() error {
+		d.handleSignals(ctx)
+		return nil
+	})
+
+	serverPhase := startupPhase{name: "server", run: func(context.Context) error {
+		eg.Go(func() error {
+			if err := s.Start(); err != nil {
+				log.Info("Error starting server", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+		return nil
+	}}
+	phases := a.dependencyPhases(db, cfg.Database.MigrateOnStart, queues...)
+	phases = append(phases, a.emailIdentityPhases(awsConfig)...)
+	if skipWait {
+		phases = append([]startupPhase{serverPhase}, phases...)
+	} else {
+		phases = append(phases, serverPhase)
+	}
+	if err := a.startUp(ctx, readiness, !skipWait, phases...); err != nil {
+		log.Info("Error starting up", zap.Error(err))
+		return exitError
+	}
+
+	// The rest is stopped in order on shutdown: the server and the scheduler first, so no new work comes in,
+	// then the worker, with the relay, sessions, and health monitor it may need while draining after it,
+	// then the metrics, error reports, and traces from all of those, and the database last.
+	steps := []shutdownStep{{name: "server", stop: s.Stop}, startAll("scheduler", scheduler.Start)}
+
+	if runner != nil {
+		steps = append(steps, startAll("worker", runner.Start))
+	} else {
+		log.Info("Not running the job queue worker, run it with the worker command")
+	}
+
+	steps = append(steps, startAll("relay, sessions, and health monitor", relay.Start, sessionManager.Start, health.Start))
+	if quota != nil {
+		steps = append(steps, startAll("email quota", quota.Start))
+	}
+	if disposableDomains != nil {
+		steps = append(steps, startAll("disposable email domains", disposableDomains.Start))
+	}
+	if emitter != nil {
+		steps = append(steps, emitMetrics(emitter))
+	}
+	if errorReporter != nil {
+		steps = append(steps, flushErrorReports(errorReporter, cfg.Sentry.FlushTimeout))
+	}
+	if tracingProvider != nil {
+		steps = append(steps, shutdownStep{name: "traces", stop: tracingProvider.Shutdown})
+	}
+	steps = append(steps, shutdownStep{name: "database", stop: func(context.Context) error {
+		return db.Close()
+	}})
+
+	<-ctx.Done()
+	force, stopForce := forceOnSignal()
+	defer stopForce()
+	stop()
+
+	code = shutdown(log, cfg.Server.ShutdownTimeout, force, steps...)
+	if code == exitForced {
+		return code
+	}
+	if err := eg.Wait(); err != nil {
+		return exitError
+	}
+	return code
+}
+
+// validateServe for what serve runs, which is only the worker with WORKER_ONLY.
+func validateServe(c config.Config) error {
+	if c.Worker.Only {
+		return c.ValidateWorker()
+	}
+	return c.Validate()
+}
+
+// createCaptchaVerifier for the provider, "hcaptcha" or "turnstile", which config.Config.Validate has checked.
+// Without a provider, there's no captcha, and nil is returned.
+func createCaptchaVerifier(c config.Signup) handlers.CaptchaVerifier {
+	opts := handlers.CaptchaSiteVerifierOptions{
+		Secret:  c.CaptchaSecret,
+		SiteKey: c.CaptchaSiteKey,
+		Timeout: c.CaptchaTimeout,
+	}
+	switch c.CaptchaProvider {
+	case "hcaptcha":
+		return handlers.NewHCaptchaVerifier(opts)
+	case "turnstile":
+		return handlers.NewTurnstileVerifier(opts)
+	default:
+		return nil
+	}
+}
+
+// signupEmailPolicy with SIGNUP_EMAIL_POLICY, with the checker of its disposable domains, which needs to be started
+// to refresh them from SIGNUP_DISPOSABLE_DOMAINS_URL. Without the policy, it's off, and the checker is nil.
+func (a *app) signupEmailPolicy(registry *prometheus.Registry) (model.EmailPolicy, *disposable.Checker) {
+	c := a.config.Signup
+	if !c.EmailPolicy {
+		return model.EmailPolicy{}, nil
+	}
+	checker := disposable.NewChecker(disposable.NewCheckerOptions{
+		Interval: c.DisposableDomainsInterval,
+		Log:      a.logger("signup"),
+		Metrics:  registry,
+		URL:      c.DisposableDomainsURL,
+	})
+	return model.EmailPolicy{Enabled: true, DedupDomains: c.EmailDedupDomains, Disposable: checker}, checker
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/errorreport"
+	"canvas/metrics"
+)
+
+// shutdownStep of the teardown, which stops something and waits for it until ctx is done.
+type shutdownStep struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// shutdown with the steps in order, all within the timeout, logging how long each step took.
+// A step that fails is logged, and the rest still run, but the exit code is exitError.
+// If the timeout passes, or a signal comes on force, like a second Ctrl-C, shutdown returns exitForced right away,
+// without waiting for the step that's running or running the rest.
+func shutdown(log *zap.Logger, timeout time.Duration, force <-chan os.Signal, steps ...shutdownStep) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Info("Shutting down", zap.Duration("timeout", timeout))
+	start := time.Now()
+	code := exitOK
+	for _, step := range steps {
+		stepStart := time.Now()
+		done := make(chan error, 1)
+		go func(step shutdownStep) {
+			done <- step.stop(ctx)
+		}(step)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Info("Error shutting down", zap.String("step", step.name), zap.Error(err))
+				code = exitError
+				continue
+			}
+			log.Info("Shut down", zap.String("step", step.name), zap.Duration("duration", time.Since(stepStart)))
+		case <-ctx.Done():
+			log.Error("Shutdown timed out, exiting anyway", zap.String("step", step.name), zap.Duration("timeout", timeout))
+			return exitForced
+		case s := <-force:
+			log.Warn("Got another signal while shutting down, exiting right away", zap.String("step", step.name),
+				zap.Stringer("signal", s))
+			return exitForced
+		}
+	}
+	log.Info("Shut down everything", zap.Duration("duration", time.Since(start)))
+	return code
+}
+
+// startAll runs the functions in goroutines, returning the step that stops them by cancelling their context,
+// and waits for them to return.
+func startAll(name string, fs ...func(ctx context.Context)) shutdownStep {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, f := range fs {
+		wg.Add(1)
+		go func(f func(ctx context.Context)) {
+			defer wg.Done()
+			f(ctx)
+		}(f)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	return shutdownStep{name: name, stop: func(stepCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-stepCtx.Done():
+			return stepCtx.Err()
+		}
+	}}
+}
+
+// flushErrorReports is the step that sends the error reports left, within its own timeout,
+// so a slow error reporting service doesn't use up the time for the rest of the shutdown.
+// Reports that aren't sent in time are lost, but that's not a shutdown error.
+func flushErrorReports(rep *errorreport.Reporter, timeout time.Duration) shutdownStep {
+	return shutdownStep{name: "error reports", stop: func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		_ = rep.Flush(ctx)
+		return nil
+	}}
+}
+
+// emitMetrics starts emitting the metrics on their interval, returning the step that stops it and flushes the metrics
+// that changed since the last time, so what happened while shutting down the steps before it is emitted too.
+func emitMetrics(e *metrics.Emitter) shutdownStep {
+	loop := startAll("metrics", e.Start)
+	return shutdownStep{name: "metrics", stop: func(ctx context.Context) error {
+		if err := loop.stop(ctx); err != nil {
+			return err
+		}
+		return e.Flush(ctx)
+	}}
+}
+
+// forceOnSignal gets SIGTERM and SIGINT on the returned channel, for forcing shutdown after the first signal.
+// Call stop when shutdown is done.
+func forceOnSignal() (force <-chan os.Signal, stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	return c, func() {
+		signal.Stop(c)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/metrics"
+)
+
+// recorder of the order of shutdown steps.
+type recorder struct {
+	lock  sync.Mutex
+	steps []string
+}
+
+func (r *recorder) step(name string, err error) shutdownStep {
+	return shutdownStep{name: name, stop: func(ctx context.Context) error {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.steps = append(r.steps, name)
+		return err
+	}}
+}
+
+func (r *recorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.steps...)
+}
+
+// blocking step until ctx is done.
+func blocking(name string) shutdownStep {
+	return shutdownStep{name: name, stop: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("runs the steps in order, and logs how long each took", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zap.InfoLevel)
+		var r recorder
+		code := shutdown(zap.New(core), time.Second, nil, r.step("server", nil), r.step("worker", nil), r.step("database", nil))
+		is.Equal(exitOK, code)
+		is.Equal([]string{"server", "worker", "database"}, r.get())
+
+		var logged []string
+		for _, e := range logs.FilterMessage("Shut down").All() {
+			logged = append(logged, e.ContextMap()["step"].(string))
+			_, ok := e.ContextMap()["duration"]
+			is.True(ok)
+		}
+		is.Equal([]string{"server", "worker", "database"}, logged)
+	})
+
+	t.Run("runs the rest of the steps after one fails, and exits with an error", func(t *testing.T) {
+		is := is.New(t)
+
+		var r recorder
+		code := shutdown(zap.NewNop(), time.Second, nil, r.step("server", errors.New("oh no")), r.step("database", nil))
+		is.Equal(exitError, code)
+		is.Equal([]string{"server", "database"}, r.get())
+	})
+
+	t.Run("exits forced without the rest of the steps if the timeout passes", func(t *testing.T) {
+		is := is.New(t)
+
+		var r recorder
+		code := shutdown(zap.NewNop(), 10*time.Millisecond, nil, r.step("server", nil), blocking("worker"), r.step("database", nil))
+		is.Equal(exitForced, code)
+		is.Equal([]string{"server"}, r.get())
+	})
+
+	t.Run("exits forced right away on another signal", func(t *testing.T) {
+		is := is.New(t)
+
+		force := make(chan os.Signal, 1)
+		force <- syscall.SIGINT
+		var r recorder
+		before := time.Now()
+		code := shutdown(zap.NewNop(), time.Minute, force, blocking("worker"), r.step("database", nil))
+		is.Equal(exitForced, code)
+		is.True(time.Since(before) < time.Second)
+		is.Equal(0, len(r.get()))
+	})
+}
+
+func TestStartAll(t *testing.T) {
+	t.Run("runs the functions until the step stops them, and waits for them to return", func(t *testing.T) {
+		is := is.New(t)
+
+		var lock sync.Mutex
+		var stopped []string
+		f := func(name string) func(ctx context.Context) {
+			return func(ctx context.Context) {
+				<-ctx.Done()
+				time.Sleep(10 * time.Millisecond)
+				lock.Lock()
+				defer lock.Unlock()
+				stopped = append(stopped, name)
+			}
+		}
+
+		step := startAll("background", f("a"), f("b"))
+		is.NoErr(step.stop(context.Background()))
+		is.Equal(2, len(stopped))
+	})
+
+	t.Run("gives up waiting when the step's context is done", func(t *testing.T) {
+		is := is.New(t)
+
+		release := make(chan struct{})
+		defer close(release)
+		step := startAll("stuck", func(ctx context.Context) {
+			<-release
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		is.Equal(context.DeadlineExceeded, step.stop(ctx))
+	})
+}
+
+func TestEmitMetrics(t *testing.T) {
+	t.Run("flushes the metrics that changed since they were last emitted when the step stops", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_http_requests_total"})
+		registry.MustRegister(requests)
+		core, logs := observer.New(zap.InfoLevel)
+		e := metrics.NewEmitter(metrics.NewEmitterOptions{
+			Dimensions: map[string][]string{"app_http_requests_total": nil},
+			EMFLog:     zap.New(core),
+			Gatherer:   registry,
+			Interval:   time.Hour,
+		})
+
+		step := emitMetrics(e)
+		requests.Add(3)
+		is.Equal(0, logs.Len())
+
+		is.NoErr(step.stop(context.Background()))
+		entries := logs.FilterMessage("Metrics").All()
+		is.Equal(1, len(entries))
+		is.Equal(float64(3), entries[0].ContextMap()["app_http_requests_total"])
+	})
+}
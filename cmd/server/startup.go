@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/messaging"
+	"canvas/storage"
+)
+
+// parseStartupFlags of the serve and worker commands, which only have -skip-wait, printing the usage if they're wrong.
+func parseStartupFlags(name string, args []string) (skipWait, ok bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: server %v [-skip-wait]\n", name)
+		fs.PrintDefaults()
+	}
+	skip := fs.Bool("skip-wait", false,
+		"Start without waiting for the database and queues, trying each once, for starting in degraded mode.")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		if fs.NArg() > 0 {
+			fs.Usage()
+		}
+		return false, false
+	}
+	return *skip, true
+}
+
+// startupPhase of the serve and worker commands, like connecting to the database, which runs after the one before it.
+type startupPhase struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// permanentError of a startup phase, which retrying won't fix, so startup stops at it right away.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// startUp with the phases in order, logging how long each took, and marking ready at the end.
+// If wait is true, each phase is retried until it succeeds or ctx is done, and the first one that fails stops startup,
+// so ready stays not ready. Otherwise, like with -skip-wait, each phase is tried once, and startup goes on without it.
+func startUp(ctx context.Context, log *zap.Logger, ready *handlers.Readiness, wait bool, phases ...startupPhase) error {
+	start := time.Now()
+	for _, p := range phases {
+		phaseStart := time.Now()
+		run := p.run
+		if wait {
+			run = retrying(log, p.name, run)
+		}
+		if err := run(ctx); err != nil {
+			if wait {
+				return fmt.Errorf("error starting up %v: %w", p.name, err)
+			}
+			log.Warn("Error starting up, continuing without it", zap.String("phase", p.name), zap.Error(err))
+			continue
+		}
+		log.Info("Started up", zap.String("phase", p.name), zap.Duration("duration", time.Since(phaseStart)))
+	}
+	ready.SetReady()
+	log.Info("Ready", zap.Duration("duration", time.Since(start)))
+	return nil
+}
+
+// startUp with the phases after the configuration, which setup has loaded, within STARTUP_TIMEOUT,
+// and until ctx is done, like on SIGTERM.
+func (a *app) startUp(ctx context.Context, ready *handlers.Readiness, wait bool, phases ...startupPhase) error {
+	ctx, cancel := context.WithTimeout(ctx, a.config.Server.StartupTimeout)
+	defer cancel()
+
+	a.log.Info("Started up", zap.String("phase", "configuration"), zap.Duration("duration", a.loaded))
+	return startUp(ctx, a.log, ready, wait, phases...)
+}
+
+// retrying f until it succeeds, returns a permanentError, or ctx is done, waiting from a tenth of a second up to five seconds between attempts,
+// so dependencies that are started at the same time as the app have time to come up.
+func retrying(log *zap.Logger, name string, f func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		delay := 100 * time.Millisecond
+		for attempt := 1; ; attempt++ {
+			err := f(ctx)
+			var permanent permanentError
+			if err == nil || ctx.Err() != nil || errors.As(err, &permanent) {
+				return err
+			}
+			log.Info("Error starting up, retrying", zap.String("phase", name), zap.Int("attempt", attempt),
+				zap.Duration("delay", delay), zap.Error(err))
+
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			case <-t.C:
+			}
+			if delay *= 2; delay > 5*time.Second {
+				delay = 5 * time.Second
+			}
+		}
+	}
+}
+
+// dependencyPhases for the database and queues: connecting to the database, migrating it if migrate is true,
+// or else checking that it's migrated, and setting up the queues.
+func (a *app) dependencyPhases(db *storage.Database, migrate bool, queues ...*messaging.Queue) []startupPhase {
+	phases := []startupPhase{{name: "database", run: db.Ping}}
+	if migrate {
+		phases = append(phases, startupPhase{name: "migrations", run: func(ctx context.Context) error {
+			return migrateOnStart(ctx, db, storage.Migrations(), a.logger("storage"))
+		}})
+	} else {
+		phases = append(phases, startupPhase{name: "schema", run: func(ctx context.Context) error {
+			return checkSchema(ctx, db, storage.Migrations())
+		}})
+	}
+	return append(phases, startupPhase{name: "queues", run: func(ctx context.Context) error {
+		return a.setupQueues(ctx, queues...)
+	}})
+}
+
+// identityVerifier is an email backend that can check that it can send from EMAIL_FROM, like *email.SESSender.
+type identityVerifier interface {
+	VerifyIdentity(ctx context.Context) (string, error)
+}
+
+// emailIdentityPhases with a phase checking that the email backend can send from EMAIL_FROM, for EMAIL_VERIFY_IDENTITY.
+// There's none if it's off, or the backend only logs emails.
+func (a *app) emailIdentityPhases(awsConfig aws.Config) []startupPhase {
+	if a.config.Email.VerifyIdentity == "off" {
+		return nil
+	}
+	v, ok := a.emailBackend(awsConfig).(identityVerifier)
+	if !ok {
+		return nil
+	}
+	return []startupPhase{emailIdentityPhase(a.logger("email"), a.config.Email.VerifyIdentity == "strict", v)}
+}
+
+// emailIdentityPhase checking the sending identity with v, which only logs a warning if it can't be verified,
+// unless strict is true. Then startup stops, right away if retrying won't help, like for an unverified identity.
+func emailIdentityPhase(log *zap.Logger, strict bool, v identityVerifier) startupPhase {
+	return startupPhase{name: "email identity", run: func(ctx context.Context) error {
+		detail, err := v.VerifyIdentity(ctx)
+		// The backend is only for checking, so a connection to an SMTP server isn't kept open.
+		if c, ok := v.(interface{ Close() }); ok {
+			c.Close()
+		}
+		switch {
+		case err == nil:
+			log.Info("Verified email sending identity", zap.String("detail", detail))
+			return nil
+		case !strict:
+			log.Warn("Error verifying email sending identity, emails may not be sent", zap.Error(err))
+			return nil
+		case messaging.IsRetryable(err):
+			return err
+		default:
+			return permanentError{err}
+		}
+	}}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+	"canvas/messaging"
+)
+
+func TestStartUp(t *testing.T) {
+	t.Run("isn't ready until the dependency phase is done, and logs the duration of each phase", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		ready := &handlers.Readiness{}
+		release := make(chan struct{})
+		started := make(chan struct{})
+		var order []string
+		phases := []startupPhase{
+			{name: "database", run: func(ctx context.Context) error {
+				close(started)
+				<-release
+				order = append(order, "database")
+				return nil
+			}},
+			{name: "server", run: func(ctx context.Context) error {
+				order = append(order, "server")
+				return nil
+			}},
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- startUp(context.Background(), zap.New(core), ready, true, phases...)
+		}()
+
+		waitFor(t, started, "database phase to start")
+		is.True(!ready.Ready())
+		close(release)
+		is.NoErr(<-done)
+		is.True(ready.Ready())
+		is.Equal([]string{"database", "server"}, order)
+
+		startedUp := logs.FilterMessage("Started up").All()
+		is.Equal(2, len(startedUp))
+		is.Equal("database", startedUp[0].ContextMap()["phase"])
+		_, ok := startedUp[0].ContextMap()["duration"]
+		is.True(ok)
+		is.Equal(1, logs.FilterMessage("Ready").Len())
+	})
+
+	t.Run("retries a phase until it succeeds", func(t *testing.T) {
+		is := is.New(t)
+
+		ready := &handlers.Readiness{}
+		attempts := 0
+		err := startUp(context.Background(), zap.NewNop(), ready, true, startupPhase{name: "database",
+			run: func(ctx context.Context) error {
+				attempts++
+				if attempts < 3 {
+					return errors.New("connection refused")
+				}
+				return nil
+			}})
+		is.NoErr(err)
+		is.Equal(3, attempts)
+		is.True(ready.Ready())
+	})
+
+	t.Run("stops right away at a phase with a permanent error", func(t *testing.T) {
+		is := is.New(t)
+
+		ready := &handlers.Readiness{}
+		attempts := 0
+		err := startUp(context.Background(), zap.NewNop(), ready, true, startupPhase{name: "schema",
+			run: func(ctx context.Context) error {
+				attempts++
+				return permanentError{errors.New("pending migrations")}
+			}})
+		is.True(err != nil)
+		is.Equal("error starting up schema: pending migrations", err.Error())
+		is.Equal(1, attempts)
+		is.True(!ready.Ready())
+	})
+
+	t.Run("stops at a phase that doesn't succeed before ctx is done, and stays not ready", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		ready := &handlers.Readiness{}
+		var ran bool
+		err := startUp(ctx, zap.NewNop(), ready, true,
+			startupPhase{name: "database", run: func(ctx context.Context) error {
+				return errors.New("connection refused")
+			}},
+			startupPhase{name: "server", run: func(ctx context.Context) error {
+				ran = true
+				return nil
+			}})
+		is.True(err != nil)
+		is.Equal("error starting up database: connection refused", err.Error())
+		is.True(!ran)
+		is.True(!ready.Ready())
+	})
+
+	t.Run("tries each phase once without waiting, and is ready anyway", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		ready := &handlers.Readiness{}
+		attempts := 0
+		var ran bool
+		err := startUp(context.Background(), zap.New(core), ready, false,
+			startupPhase{name: "server", run: func(ctx context.Context) error {
+				ran = true
+				return nil
+			}},
+			startupPhase{name: "database", run: func(ctx context.Context) error {
+				attempts++
+				return errors.New("connection refused")
+			}})
+		is.NoErr(err)
+		is.True(ran)
+		is.Equal(1, attempts)
+		is.True(ready.Ready())
+		is.Equal(1, logs.FilterMessage("Error starting up, continuing without it").Len())
+	})
+}
+
+// identityVerifierMock returns the detail and err, and records whether it was closed afterwards.
+type identityVerifierMock struct {
+	detail string
+	err    error
+	closed bool
+}
+
+func (v *identityVerifierMock) VerifyIdentity(ctx context.Context) (string, error) {
+	return v.detail, v.err
+}
+
+func (v *identityVerifierMock) Close() {
+	v.closed = true
+}
+
+func TestEmailIdentityPhase(t *testing.T) {
+	t.Run("logs the verified identity, and closes the backend", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		v := &identityVerifierMock{detail: "identity example.com is verified, with DKIM"}
+		err := emailIdentityPhase(zap.New(core), true, v).run(context.Background())
+		is.NoErr(err)
+		is.True(v.closed)
+		verified := logs.FilterMessage("Verified email sending identity").All()
+		is.Equal(1, len(verified))
+		is.Equal("identity example.com is verified, with DKIM", verified[0].ContextMap()["detail"])
+	})
+
+	t.Run("only logs a warning if the identity can't be verified, unless strict", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		v := &identityVerifierMock{err: errors.New("SES identity example.com isn't verified for sending")}
+		err := emailIdentityPhase(zap.New(core), false, v).run(context.Background())
+		is.NoErr(err)
+		warnings := logs.FilterMessage("Error verifying email sending identity, emails may not be sent").All()
+		is.Equal(1, len(warnings))
+		is.Equal(zapcore.WarnLevel, warnings[0].Level)
+	})
+
+	t.Run("stops startup right away if strict and the identity isn't verified", func(t *testing.T) {
+		is := is.New(t)
+
+		v := &identityVerifierMock{err: errors.New("SES identity example.com isn't verified for sending")}
+		err := startUp(context.Background(), zap.NewNop(), &handlers.Readiness{}, true, emailIdentityPhase(zap.NewNop(), true, v))
+		is.True(err != nil)
+		is.Equal("error starting up email identity: SES identity example.com isn't verified for sending", err.Error())
+	})
+
+	t.Run("retries if strict and the backend can't be reached", func(t *testing.T) {
+		is := is.New(t)
+
+		v := &identityVerifierMock{err: messaging.Retryable(errors.New("connection refused"), time.Second)}
+		err := emailIdentityPhase(zap.NewNop(), true, v).run(context.Background())
+		var permanent permanentError
+		is.True(err != nil)
+		is.True(!errors.As(err, &permanent))
+	})
+}
+
+func TestParseStartupFlags(t *testing.T) {
+	t.Run("parses -skip-wait and --skip-wait, and nothing else", func(t *testing.T) {
+		is := is.New(t)
+
+		skipWait, ok := parseStartupFlags("serve", nil)
+		is.True(ok)
+		is.True(!skipWait)
+
+		for _, arg := range []string{"-skip-wait", "--skip-wait"} {
+			skipWait, ok = parseStartupFlags("serve", []string{arg})
+			is.True(ok)
+			is.True(skipWait)
+		}
+
+		_, ok = parseStartupFlags("serve", []string{"now"})
+		is.True(!ok)
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"canvas/build"
+)
+
+// versionCommand prints the build info.
+func versionCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: server version")
+		return exitUsage
+	}
+	printVersion(os.Stdout, build.Get())
+	return exitOK
+}
+
+func printVersion(out io.Writer, i build.Info) {
+	_, _ = fmt.Fprintf(out, "canvas %v (commit %v, built %v, %v)\n", i.Version, i.Commit, i.Date, i.GoVersion)
+}
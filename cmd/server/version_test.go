@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/build"
+)
+
+func TestVersionCommand(t *testing.T) {
+	t.Run("prints the version, commit, build date, and Go version", func(t *testing.T) {
+		is := is.New(t)
+
+		var out bytes.Buffer
+		printVersion(&out, build.Info{Version: "v1.2.3", Commit: "abc123", Date: "2022-12-10T12:00:00Z", GoVersion: "go1.18"})
+		is.Equal("canvas v1.2.3 (commit abc123, built 2022-12-10T12:00:00Z, go1.18)\n", out.String())
+	})
+
+	t.Run("works without any configuration", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("CONFIG_FILE", "does-not-exist.yaml")
+		t.Setenv("PORT", "not a port")
+		is.Equal(exitOK, dispatch([]string{"--version"}, commands, &bytes.Buffer{}))
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"canvas/config"
+	"canvas/email"
+	"canvas/errorreport"
+	"canvas/handlers"
+	"canvas/i18n"
+	"canvas/jobs"
+	"canvas/metrics"
+	"canvas/server"
+	"canvas/storage"
+	"canvas/tracing"
+)
+
+// workerCommand runs only the job queue worker, until SIGTERM or SIGINT.
+// It's for scaling the worker separately from the server, with SERVER_RUN_WORKER=false for serve.
+func workerCommand(args []string) int {
+	skipWait, ok := parseStartupFlags("worker", args)
+	if !ok {
+		return exitUsage
+	}
+
+	a, code := setup(config.Config.ValidateWorker)
+	if a == nil {
+		return code
+	}
+	defer a.close()
+
+	return a.worker(skipWait)
+}
+
+// worker sets up and runs the job queue worker with all jobs, and an internal server for probes and metrics
+// instead of the web app. It waits for the database and queues first, unless skipWait is true.
+func (a *app) worker(skipWait bool) int {
+	log := a.log
+
+	awsConfig, err := a.awsConfig()
+	if err != nil {
+		log.Info("Error creating AWS config", zap.Error(err))
+		return exitError
+	}
+
+	registry := prometheus.NewRegistry()
+
+	catalog, err := i18n.New(i18n.Embedded(), log)
+	if err != nil {
+		log.Info("Error loading translations", zap.Error(err))
+		return exitError
+	}
+
+	db, err := a.openDatabase(registry)
+	if err != nil {
+		log.Info("Error opening database", zap.Error(err))
+		return exitError
+	}
+	queue, deadLetterQueue := a.queues(awsConfig)
+	quota := a.sesQuota(awsConfig, registry)
+	emailSender := a.emailSender(awsConfig, db, quota, registry)
+
+	ctx, stop := signalContext()
+	phases := append(a.dependencyPhases(db, false, queue, deadLetterQueue), a.emailIdentityPhases(awsConfig)...)
+	err = a.startUp(ctx, nil, !skipWait, phases...)
+	stop()
+	if err != nil {
+		log.Info("Error starting up", zap.Error(err))
+		return exitError
+	}
+
+	health := a.healthMonitor(db)
+
+	errorReporter, err := a.errorReporter()
+	if err != nil {
+		log.Info("Error setting up error reporting", zap.Error(err))
+		return exitError
+	}
+	tracingProvider := a.tracing()
+
+	emitter, err := a.metricsEmitter(awsConfig, registry)
+	if err != nil {
+		log.Info("Error setting up metrics", zap.Error(err))
+		return exitError
+	}
+
+	featureFlags, err := a.flags()
+	if err != nil {
+		log.Info("Error loading feature flags", zap.Error(err))
+		return exitError
+	}
+
+	return runWorker(workerOptions{
+		Database:          db,
+		ErrorReporter:     errorReporter,
+		ErrorFlushTimeout: a.config.Sentry.FlushTimeout,
+		Health:            health,
+		Internal: server.NewInternal(server.InternalOptions{
+			Database: db,
+			Host:     a.config.Worker.Host,
+			Log:      a.logger("server"),
+			Metrics:  registry,
+			Port:     a.config.Worker.Port,
+		}),
+		Log:      log,
+		LogLevel: a.logLevel(),
+		Metrics:  emitter,
+		Runner: a.jobRunner(jobRunnerOptions{
+			Catalog:         catalog,
+			Database:        db,
+			DeadLetterQueue: deadLetterQueue,
+			EmailSender:     emailSender,
+			ErrorReporter:   errorReporter,
+			Flags:           featureFlags,
+			Health:          health,
+			Metrics:         registry,
+			Queue:           queue,
+			Quota:           quota,
+			Tracing:         tracingProvider,
+		}),
+		Quota:           quota,
+		ShutdownTimeout: a.config.Server.ShutdownTimeout,
+		Tracing:         tracingProvider,
+	})
+}
+
+// workerOptions for runWorker. Only Runner is required.
+type workerOptions struct {
+	// Database is closed last on shutdown, and its pool stats are logged on SIGUSR1.
+	Database interface {
+		Close() error
+		Stats() sql.DBStats
+	}
+	// ErrorReporter is flushed within ErrorFlushTimeout on shutdown, before the database is closed.
+	ErrorReporter     *errorreport.Reporter
+	ErrorFlushTimeout time.Duration
+	Health            *storage.HealthMonitor
+	Internal          *server.Internal
+	Log               *zap.Logger
+	LogLevel          *handlers.LogLevel
+	// Metrics are emitted until the internal server has stopped, and flushed then.
+	Metrics *metrics.Emitter
+	// Quota of SES, read until the runner has stopped.
+	Quota  *email.QuotaManager
+	Runner *jobs.Runner
+	// ShutdownTimeout for all of shutting down. Defaults to a minute.
+	ShutdownTimeout time.Duration
+	// Tracing is shut down after the error reports, exporting the spans left within the shutdown timeout.
+	Tracing *tracing.Provider
+}
+
+// runWorker until SIGTERM or SIGINT. The worker then drains the running jobs within the shutdown timeout
+// of the runner, then the health monitor, the email quota, and the internal server stop, so probes and metrics work until the end,
+// then the metrics, error reports, and spans are flushed, and the database is closed last.
+func runWorker(opts workerOptions) int {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = time.Minute
+	}
+	log := opts.Log
+
+	ctx, stop := signalContext()
+	defer stop()
+	eg, ctx := errgroup.WithContext(ctx)
+
+	d := diagnostics{log: log, logLevel: opts.LogLevel, stats: []func() zap.Field{runnerStats(opts.Runner)}}
+	if opts.Database != nil {
+		d.stats = append(d.stats, databaseStats(opts.Database))
+	}
+	eg.Go(func() error {
+		d.handleSignals(ctx)
+		return nil
+	})
+
+	if opts.Internal != nil {
+		eg.Go(func() error {
+			if err := opts.Internal.Start(); err != nil {
+				log.Info("Error starting internal server", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+
+	steps := []shutdownStep{startAll("worker", opts.Runner.Start)}
+	if opts.Health != nil {
+		steps = append(steps, startAll("health monitor", opts.Health.Start))
+	}
+	if opts.Quota != nil {
+		steps = append(steps, startAll("email quota", opts.Quota.Start))
+	}
+	if opts.Internal != nil {
+		steps = append(steps, shutdownStep{name: "internal server", stop: opts.Internal.Stop})
+	}
+	if opts.Metrics != nil {
+		steps = append(steps, emitMetrics(opts.Metrics))
+	}
+	if opts.ErrorReporter != nil {
+		steps = append(steps, flushErrorReports(opts.ErrorReporter, opts.ErrorFlushTimeout))
+	}
+	if opts.Tracing != nil {
+		steps = append(steps, shutdownStep{name: "traces", stop: opts.Tracing.Shutdown})
+	}
+	if opts.Database != nil {
+		steps = append(steps, shutdownStep{name: "database", stop: func(context.Context) error {
+			return opts.Database.Close()
+		}})
+	}
+
+	<-ctx.Done()
+	force, stopForce := forceOnSignal()
+	defer stopForce()
+	stop()
+
+	code := shutdown(log, opts.ShutdownTimeout, force, steps...)
+	if code == exitForced {
+		return code
+	}
+	if err := eg.Wait(); err != nil {
+		return exitError
+	}
+	return code
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/server"
+)
+
+// databaseMock records whether it was closed, and what had finished by then.
+type databaseMock struct {
+	closed         chan struct{}
+	finished       func() bool
+	finishedBefore bool
+}
+
+func (d *databaseMock) Stats() sql.DBStats {
+	return sql.DBStats{}
+}
+
+func (d *databaseMock) Close() error {
+	d.finishedBefore = d.finished()
+	close(d.closed)
+	return nil
+}
+
+func TestRunWorker(t *testing.T) {
+	t.Run("processes jobs, and drains the running job and exits cleanly on SIGTERM", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue, ShutdownTimeout: 5 * time.Second})
+
+		ran := make(chan struct{})
+		r.Register("quick", func(ctx context.Context, m model.Message) error {
+			close(ran)
+			return nil
+		})
+		started := make(chan struct{})
+		release := make(chan struct{})
+		var finished int32
+		r.Register("slow", func(ctx context.Context, m model.Message) error {
+			close(started)
+			<-release
+			atomic.StoreInt32(&finished, 1)
+			return ctx.Err()
+		})
+
+		db := &databaseMock{closed: make(chan struct{}), finished: func() bool {
+			return atomic.LoadInt32(&finished) == 1 && queue.Len() == 0
+		}}
+
+		code := make(chan int, 1)
+		go func() {
+			code <- runWorker(workerOptions{
+				Database: db,
+				Internal: server.NewInternal(server.InternalOptions{Host: "localhost", Port: 0}),
+				Runner:   r,
+			})
+		}()
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "quick"}))
+		waitFor(t, ran, "quick job to run")
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "slow"}))
+		waitFor(t, started, "slow job to start")
+
+		is.NoErr(syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+
+		// The worker waits for the slow job instead of exiting.
+		select {
+		case <-code:
+			t.Fatal("worker exited before the running job finished")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		select {
+		case c := <-code:
+			is.Equal(exitOK, c)
+		case <-time.After(5 * time.Second):
+			t.Fatal("worker did not exit")
+		}
+
+		is.Equal(0, queue.Len())
+		// The database is closed last, after the job finished and deleted its message.
+		<-db.closed
+		is.True(db.finishedBefore)
+	})
+}
+
+func waitFor(t *testing.T, c <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-c:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for", what)
+	}
+}
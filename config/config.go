@@ -0,0 +1,1514 @@
+// Package config has the configuration of the server, read at startup and validated,
+// so misconfigurations stop the server before it starts instead of surfacing at first use.
+//
+// Each setting comes from, in order of precedence:
+//
+//  1. Its environment variable, named in the comment of its field. Commands can set variables that
+//     aren't set from .env files first, with LoadDotenv.
+//  2. The YAML configuration file, at the path in CONFIG_FILE, or canvas.yaml if it exists.
+//     Keys are the snake case field names in their section, like server.base_url. See Config.
+//  3. The default.
+//
+// Settings are layered field by field, so a file can set some fields of a section,
+// the environment can override some of those, and the defaults fill in the rest.
+// A common setup is a checked-in file, with only the secrets in the environment.
+//
+// String settings can also be references to secrets in AWS Secrets Manager, like aws-sm://prod/db-password,
+// or SSM Parameter Store, like aws-ssm:///canvas/db-password, which Config.ResolveSecrets replaces with their values.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultFile is read if it exists and CONFIG_FILE isn't set.
+const defaultFile = "canvas.yaml"
+
+// minTrackingSecretLength of TRACKING_SECRET, since click tracking tokens are only as safe from forging as it is.
+const minTrackingSecretLength = 32
+
+// Config of the server. Each field is read from the environment variable in its comment,
+// or from the configuration file at the key in its yaml tag.
+// Fields with secrets are tagged secret:"true", so Settings never has their values.
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Signup   Signup   `yaml:"signup"`
+	Database Database `yaml:"database"`
+	Queue    Queue    `yaml:"queue"`
+	Worker   Worker   `yaml:"worker"`
+	Schedule Schedule `yaml:"schedule"`
+	Email    Email    `yaml:"email"`
+	Webhook  Webhook  `yaml:"webhook"`
+	Flags    Flags    `yaml:"flags"`
+	Log      Log      `yaml:"log"`
+	Secrets  Secrets  `yaml:"secrets"`
+	AWS      AWS      `yaml:"aws"`
+	Sentry   Sentry   `yaml:"sentry"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Metrics  Metrics  `yaml:"metrics"`
+
+	// file that was read, if any.
+	file string
+	// unknownKeys in the file, for warnings.
+	unknownKeys []string
+	// problems reading the file and the environment, like values that aren't numbers, reported by Validate.
+	problems []string
+	// sources of the settings by key, for the ones that aren't defaults.
+	sources map[string]Source
+}
+
+// Server configuration.
+type Server struct {
+	// AccessLogQuietSampleEvery is ACCESS_LOG_QUIET_SAMPLE_EVERY, to log every nth request for probes and static assets
+	// at info level anyway. By default, they're only logged at debug level, unless they fail with a server error.
+	AccessLogQuietSampleEvery int `yaml:"access_log_quiet_sample_every"`
+	// Host is HOST, and Port is PORT.
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// BaseURL is BASE_URL, like "https://example.com", for absolute URLs such as in emails and the sitemap.
+	BaseURL string `yaml:"base_url"`
+	// AdminPasswordHash is ADMIN_PASSWORD_HASH. Nobody can log in to the admin pages without it.
+	AdminPasswordHash string `yaml:"admin_password_hash" secret:"true"`
+	// CORSAllowedOrigins is CORS_ALLOWED_ORIGINS, and EmbedPartnerOrigins is EMBED_PARTNER_ORIGINS, both comma-separated.
+	CORSAllowedOrigins  []string `yaml:"cors_allowed_origins"`
+	EmbedPartnerOrigins []string `yaml:"embed_partner_origins"`
+	// DegradedCacheTTL is DEGRADED_CACHE_TTL, how long a copy of the front page is kept, to show it while
+	// the database is unhealthy. Zero turns it off.
+	DegradedCacheTTL time.Duration `yaml:"degraded_cache_ttl"`
+	// LoadShedAfter is LOAD_SHED_AFTER, how long the database connection pool must be saturated before requests
+	// get 503 Service Unavailable, instead of waiting for a connection. Zero turns load shedding off.
+	LoadShedAfter time.Duration `yaml:"load_shed_after"`
+	// RobotsDisallowAll is ROBOTS_DISALLOW_ALL.
+	RobotsDisallowAll bool `yaml:"robots_disallow_all"`
+	// RunWorker is SERVER_RUN_WORKER, whether the serve command also runs the job queue worker, which it does by default.
+	// Turn it off to run the worker separately with the worker command.
+	RunWorker bool `yaml:"run_worker"`
+	// SESTransientBounceThreshold is SES_TRANSIENT_BOUNCE_THRESHOLD, and SESTransientBounceWindow is SES_TRANSIENT_BOUNCE_WINDOW,
+	// how many transient bounces within how long suppress an address.
+	SESTransientBounceThreshold int           `yaml:"ses_transient_bounce_threshold"`
+	SESTransientBounceWindow    time.Duration `yaml:"ses_transient_bounce_window"`
+	// ShutdownTimeout is SHUTDOWN_TIMEOUT, how long stopping the server and the worker can take in all,
+	// before the process exits anyway.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// StartupTimeout is STARTUP_TIMEOUT, how long the serve command retries connecting to the database
+	// and setting up the queues before it gives up.
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+	// SessionSecret is SESSION_SECRET, and SessionLifetime is SESSION_LIFETIME.
+	SessionSecret   string        `yaml:"session_secret" secret:"true"`
+	SessionLifetime time.Duration `yaml:"session_lifetime"`
+	// SiteDescription is SITE_DESCRIPTION, SiteImageURL is SITE_IMAGE_URL, and SiteTwitterCard is SITE_TWITTER_CARD,
+	// the defaults for link previews. The image URL can be a path on the base URL.
+	SiteDescription string `yaml:"site_description"`
+	SiteImageURL    string `yaml:"site_image_url"`
+	SiteTwitterCard string `yaml:"site_twitter_card"`
+	// TrustedProxies is TRUSTED_PROXIES, comma-separated CIDRs like 10.0.0.0/8 of the load balancers and reverse proxies
+	// in front of the app. Only for requests from them is the client IP address taken from X-Forwarded-For.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// TrackingSecret is TRACKING_SECRET, of at least minTrackingSecretLength bytes. Without it, newsletter issue emails
+	// have no open and click tracking, and the tracking routes aren't served.
+	TrackingSecret string `yaml:"tracking_secret" secret:"true"`
+	// TwoStepConfirm is NEWSLETTER_TWO_STEP_CONFIRM.
+	TwoStepConfirm bool `yaml:"two_step_confirm"`
+	// UnsubscribeSecret is UNSUBSCRIBE_SECRET.
+	UnsubscribeSecret string `yaml:"unsubscribe_secret" secret:"true"`
+	// ViewsDev is VIEWS_DEV, which reloads translations from disk on every request, for development.
+	ViewsDev bool `yaml:"views_dev"`
+	// ACMEHosts is ACME_HOSTS, comma-separated, which serves HTTPS on PORT with certificates for the hosts
+	// requested and renewed automatically over ACME, like from Let's Encrypt. Certificates for other hosts are never
+	// requested. ACMECache is ACME_CACHE, "db" to keep the certificates in the database, so all instances share them,
+	// or a directory. ACMEHTTPPort is ACME_HTTP_PORT, of the listener for the challenges, which redirects to HTTPS.
+	// ACMEEmail is ACME_EMAIL, of the account, and ACMEDirectoryURL is ACME_DIRECTORY_URL, like the one of
+	// the Let's Encrypt staging environment.
+	ACMEHosts        []string `yaml:"acme_hosts"`
+	ACMECache        string   `yaml:"acme_cache"`
+	ACMEHTTPPort     int      `yaml:"acme_http_port"`
+	ACMEEmail        string   `yaml:"acme_email"`
+	ACMEDirectoryURL string   `yaml:"acme_directory_url"`
+}
+
+// TrustedProxyNetworks of TrustedProxies, without the ones that aren't networks, which Validate reports.
+func (s Server) TrustedProxyNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range s.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// Signup configuration for the newsletter signup form.
+type Signup struct {
+	// FormSecret is SIGNUP_FORM_SECRET, MinFillTime is SIGNUP_MIN_FILL_TIME, MaxFormAge is SIGNUP_MAX_FORM_AGE,
+	// and ThrottleDatabase is SIGNUP_THROTTLE_DATABASE. Expired throttle windows in the database are deleted
+	// by the throttles_cleanup job, which is run by scheduling it in SCHEDULES, like throttles-cleanup=0 * * * *=throttles_cleanup.
+	FormSecret       string        `yaml:"form_secret" secret:"true"`
+	MinFillTime      time.Duration `yaml:"min_fill_time"`
+	MaxFormAge       time.Duration `yaml:"max_form_age"`
+	ThrottleDatabase bool          `yaml:"throttle_database"`
+	// CaptchaProvider is CAPTCHA_PROVIDER, "hcaptcha" or "turnstile", or empty for no captcha.
+	// The others are CAPTCHA_SECRET, CAPTCHA_SITE_KEY, CAPTCHA_TIMEOUT, and CAPTCHA_FAIL_OPEN.
+	CaptchaProvider string        `yaml:"captcha_provider"`
+	CaptchaSecret   string        `yaml:"captcha_secret" secret:"true"`
+	CaptchaSiteKey  string        `yaml:"captcha_site_key"`
+	CaptchaTimeout  time.Duration `yaml:"captcha_timeout"`
+	CaptchaFailOpen bool          `yaml:"captcha_fail_open"`
+	// EmailPolicy is SIGNUP_EMAIL_POLICY, to normalize signed up addresses, and reject disposable ones.
+	// EmailDedupDomains is SIGNUP_EMAIL_DEDUP_DOMAINS, of providers ignoring dots and plus tags in addresses.
+	EmailPolicy       bool     `yaml:"email_policy"`
+	EmailDedupDomains []string `yaml:"email_dedup_domains"`
+	// DisposableDomainsURL is SIGNUP_DISPOSABLE_DOMAINS_URL, of a list of disposable email domains that replaces
+	// the embedded one, refreshed every SIGNUP_DISPOSABLE_DOMAINS_INTERVAL.
+	DisposableDomainsURL      string        `yaml:"disposable_domains_url"`
+	DisposableDomainsInterval time.Duration `yaml:"disposable_domains_interval"`
+}
+
+// Database configuration. The fields are read from DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME,
+// DB_MAX_OPEN_CONNECTIONS, DB_MAX_IDLE_CONNECTIONS, DB_CONNECTION_MAX_LIFETIME, and DB_HEALTH_INTERVAL.
+type Database struct {
+	Host                  string        `yaml:"host"`
+	Port                  int           `yaml:"port"`
+	User                  string        `yaml:"user"`
+	Password              string        `yaml:"password" secret:"true"`
+	Name                  string        `yaml:"name"`
+	MaxOpenConnections    int           `yaml:"max_open_connections"`
+	MaxIdleConnections    int           `yaml:"max_idle_connections"`
+	ConnectionMaxLifetime time.Duration `yaml:"connection_max_lifetime"`
+	HealthInterval        time.Duration `yaml:"health_interval"`
+	// MigrateOnStart is MIGRATE_ON_START, for the serve command to migrate the database up before starting,
+	// with replicas taking turns. Without it, the serve and worker commands don't start with pending migrations.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+}
+
+// Queue configuration for the job queue and its dead letter queue, and the events queue.
+type Queue struct {
+	// Name is QUEUE_NAME, and DeadLetterName is DEAD_LETTER_QUEUE_NAME.
+	Name           string `yaml:"name"`
+	DeadLetterName string `yaml:"dead_letter_name"`
+	// EventsName is EVENTS_QUEUE_NAME, of the queue the outbox relay publishes domain events to, for other systems,
+	// with the settings of the job queue. Without it, events only go to the job queue.
+	EventsName string `yaml:"events_name"`
+	// EndpointURL is SQS_ENDPOINT_URL, for local development.
+	EndpointURL string `yaml:"endpoint_url"`
+	// QueueSettings of the job queue, and of the dead letter queue where DeadLetter doesn't set them.
+	QueueSettings `yaml:",inline"`
+	// DeadLetter settings of the dead letter queue. Only the file can set them, under queue.dead_letter,
+	// and the rest are the settings of the job queue.
+	DeadLetter QueueSettings `yaml:"dead_letter"`
+	// The others are read from QUEUE_ENSURE, QUEUE_STRICT_ATTRIBUTES, JOB_LIMIT, and OUTBOX_RETENTION.
+	Ensure           bool          `yaml:"ensure"`
+	StrictAttributes bool          `yaml:"strict_attributes"`
+	JobLimit         int           `yaml:"job_limit"`
+	OutboxRetention  time.Duration `yaml:"outbox_retention"`
+	// ReadyTimeout is QUEUE_READY_TIMEOUT, how long startup waits for each queue to be reachable and to exist,
+	// like while a local SQS is still starting, before giving up.
+	ReadyTimeout time.Duration `yaml:"ready_timeout"`
+}
+
+// QueueSettings for each queue. The fields are read from QUEUE_ADAPTIVE_RETRY, QUEUE_MAX_RETRIES,
+// QUEUE_MESSAGE_RETENTION_PERIOD, QUEUE_RECEIVE_WAIT_TIME, QUEUE_SEND_TIMEOUT, QUEUE_VISIBILITY_TIMEOUT,
+// and QUEUE_WAIT_TIME.
+type QueueSettings struct {
+	AdaptiveRetry          bool          `yaml:"adaptive_retry"`
+	MaxRetries             int           `yaml:"max_retries"`
+	MessageRetentionPeriod time.Duration `yaml:"message_retention_period"`
+	ReceiveWaitTime        time.Duration `yaml:"receive_wait_time"`
+	SendTimeout            time.Duration `yaml:"send_timeout"`
+	VisibilityTimeout      time.Duration `yaml:"visibility_timeout"`
+	WaitTime               time.Duration `yaml:"wait_time"`
+}
+
+// Worker configuration for the job queue worker.
+type Worker struct {
+	// Only is WORKER_ONLY, which makes the serve command run only the worker, like the worker command.
+	Only bool `yaml:"only"`
+	// Host is WORKER_HOST, and Port is WORKER_PORT, of the internal server with the health check and metrics
+	// when running only the worker.
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// ShutdownTimeout is WORKER_SHUTDOWN_TIMEOUT, how long running jobs get to finish when the worker stops.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// SummaryInterval is WORKER_SUMMARY_INTERVAL, how often the worker logs a summary of the messages it processed,
+	// for environments without Prometheus. Zero turns the summary off.
+	SummaryInterval time.Duration `yaml:"summary_interval"`
+}
+
+// Schedule configuration for recurring jobs, which the serve command enqueues when they're due.
+type Schedule struct {
+	// Jobs is SCHEDULES, semicolon-separated, since cron expressions can have commas. Each is like
+	// name=expression=job, like "weekly-digest=0 8 * * 1=weekly_digest", with a standard five-field cron expression
+	// in UTC, and the name of the job to enqueue.
+	Jobs []string `yaml:"jobs"`
+	// Interval is SCHEDULE_INTERVAL, how often schedules are checked for being due.
+	Interval time.Duration `yaml:"interval"`
+	// RunMisfired is SCHEDULE_RUN_MISFIRED, which runs a schedule once at startup if runs were missed while down.
+	RunMisfired bool `yaml:"run_misfired"`
+	// NewsletterTimezone is SCHEDULE_NEWSLETTER_TIMEZONE, the IANA time zone of the newsletter, like Europe/Berlin,
+	// which the times of scheduled issues are entered and shown in on the admin pages. Defaults to UTC.
+	// Scheduled issues are sent by the scheduled_newsletter_sends job, which is run by scheduling it in SCHEDULES,
+	// like newsletter-schedules=* * * * *=scheduled_newsletter_sends.
+	NewsletterTimezone string `yaml:"newsletter_timezone"`
+	// NewsletterGrace is SCHEDULE_NEWSLETTER_GRACE, how long after its time a scheduled issue is still sent,
+	// such as after downtime. Issues missed by more than that are unscheduled instead.
+	NewsletterGrace time.Duration `yaml:"newsletter_grace"`
+}
+
+// NewsletterLocation of NewsletterTimezone, or UTC if it's empty or not a time zone, which Validate reports.
+func (s Schedule) NewsletterLocation() *time.Location {
+	loc, err := time.LoadLocation(s.NewsletterTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// ScheduledJob in Schedule.Jobs.
+type ScheduledJob struct {
+	Name       string
+	Expression string
+	Job        string
+}
+
+// ScheduledJobs in Jobs, without the ones that aren't like name=expression=job, which Validate reports.
+func (s Schedule) ScheduledJobs() []ScheduledJob {
+	var jobs []ScheduledJob
+	for _, entry := range s.Jobs {
+		if j, ok := parseScheduledJob(entry); ok {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// parseScheduledJob like name=expression=job.
+func parseScheduledJob(entry string) (ScheduledJob, bool) {
+	parts := strings.Split(entry, "=")
+	if len(parts) != 3 {
+		return ScheduledJob{}, false
+	}
+	j := ScheduledJob{
+		Name:       strings.TrimSpace(parts[0]),
+		Expression: strings.TrimSpace(parts[1]),
+		Job:        strings.TrimSpace(parts[2]),
+	}
+	return j, j.Name != "" && j.Expression != "" && j.Job != ""
+}
+
+// Email configuration.
+type Email struct {
+	// From is EMAIL_FROM, the sender address of all emails.
+	From string `yaml:"from"`
+	// PhysicalAddress is EMAIL_PHYSICAL_ADDRESS, the postal address in the footer of all emails,
+	// which anti-spam laws like CAN-SPAM require.
+	PhysicalAddress string `yaml:"physical_address"`
+	// UnsubscribeMailto is EMAIL_UNSUBSCRIBE_MAILTO, an address for unsubscribing by email, which newsletter issue
+	// and welcome emails have in their List-Unsubscribe header besides the one-click link, if it's set.
+	// The app doesn't read the mailbox, so unsubscribing by email needs processing the mailbox some other way.
+	UnsubscribeMailto string `yaml:"unsubscribe_mailto"`
+	// RateLimit is EMAIL_RATE_LIMIT, the most newsletter issue emails sent per second.
+	RateLimit int `yaml:"rate_limit"`
+	// Backend is EMAIL_BACKEND, "log" to only log emails, for development, "ses" to send them with Amazon SES,
+	// or "smtp" to send them to an SMTP server, like Postmark, Mailgun, or a local MailHog.
+	Backend string `yaml:"backend"`
+	// SESConfigurationSet is SES_CONFIGURATION_SET, of the emails sent with SES, like for bounce and complaint events.
+	// SESFromARN is SES_FROM_ARN, of the identity authorized to send from EMAIL_FROM, if it's in another account.
+	SESConfigurationSet string `yaml:"ses_configuration_set"`
+	SESFromARN          string `yaml:"ses_from_arn"`
+	// SESEndpointURL is SES_ENDPOINT_URL, for local development.
+	SESEndpointURL string `yaml:"ses_endpoint_url"`
+	// SESQuota is SES_QUOTA, whether sends with SES are kept within the sending quota of the account, read from SES
+	// every SES_QUOTA_INTERVAL, which needs the ses:GetAccount permission. Sends are limited to SES_QUOTA_RATE_PERCENT
+	// of the maximum send rate, and newsletter issues pause while less than SES_QUOTA_RESERVE_PERCENT of the daily
+	// quota is left. See email.QuotaManager.
+	SESQuota               bool          `yaml:"ses_quota"`
+	SESQuotaInterval       time.Duration `yaml:"ses_quota_interval"`
+	SESQuotaRatePercent    int           `yaml:"ses_quota_rate_percent"`
+	SESQuotaReservePercent int           `yaml:"ses_quota_reserve_percent"`
+	// SMTPHost is SMTP_HOST, SMTPPort is SMTP_PORT, SMTPUsername is SMTP_USERNAME, and SMTPPassword is SMTP_PASSWORD,
+	// of the SMTP server. Without a username, there's no authentication.
+	SMTPHost     string `yaml:"smtp_host"`
+	SMTPPort     int    `yaml:"smtp_port"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password" secret:"true"`
+	// SMTPSecurity is SMTP_SECURITY, "starttls", "tls" for implicit TLS, like on port 465,
+	// or "none", only for local servers like MailHog.
+	SMTPSecurity string `yaml:"smtp_security"`
+	// SMTPTimeout is SMTP_TIMEOUT, of connecting, and of sending each email.
+	SMTPTimeout time.Duration `yaml:"smtp_timeout"`
+	// SendLogRetention is EMAIL_SEND_LOG_RETENTION, how long sends are kept in the send log. Older ones are deleted
+	// by the email_sends_cleanup job, which is run by scheduling it in SCHEDULES, like
+	// send-log-cleanup=0 3 * * *=email_sends_cleanup.
+	SendLogRetention time.Duration `yaml:"send_log_retention"`
+	// SendRetryAttempts is EMAIL_SEND_RETRY_ATTEMPTS, SendRetryDelay is EMAIL_SEND_RETRY_DELAY,
+	// and SendRetryBudget is EMAIL_SEND_RETRY_BUDGET, of retrying sends within the job when the email provider
+	// fails in a way that passes, before leaving it to the queue. See jobs.RetryPolicy.
+	SendRetryAttempts int           `yaml:"send_retry_attempts"`
+	SendRetryDelay    time.Duration `yaml:"send_retry_delay"`
+	SendRetryBudget   time.Duration `yaml:"send_retry_budget"`
+	// VerifyIdentity is EMAIL_VERIFY_IDENTITY, whether the serve and worker commands check at startup that the
+	// email backend can send from EMAIL_FROM: "off", "warn" to log a warning if it can't, or "strict" to not start.
+	// With SES, that's the identity of the address or its domain being verified, with DKIM, and with SMTP,
+	// the server taking the address after authenticating.
+	VerifyIdentity string `yaml:"verify_identity"`
+}
+
+// Webhook configuration for delivering domain events to the webhook endpoints set up on the admin pages.
+type Webhook struct {
+	// AllowPrivateNetworks is WEBHOOK_ALLOW_PRIVATE_NETWORKS, which allows endpoints in private networks,
+	// like localhost, for development. Otherwise, they're blocked, so endpoints can't reach the services next to the app.
+	// It can't be used with LOG_ENV=production.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+	// Timeout is WEBHOOK_TIMEOUT, of each delivery attempt, including reading the response.
+	Timeout time.Duration `yaml:"timeout"`
+	// Attempts is WEBHOOK_ATTEMPTS, how many times a delivery is attempted at most, with backoff,
+	// and DisableAfter is WEBHOOK_DISABLE_AFTER, how many deliveries in a row can fail before the endpoint is disabled.
+	Attempts     int `yaml:"attempts"`
+	DisableAfter int `yaml:"disable_after"`
+}
+
+// Flags configuration for feature flags, which are otherwise off unless FEATURE_X variables turn them on,
+// like FEATURE_ARCHIVE=true.
+type Flags struct {
+	// File is FLAGS_FILE, a JSON file of percentage rollouts by flag name, like {"tracking-pixel": 25}.
+	File string `yaml:"file"`
+}
+
+// Log configuration.
+type Log struct {
+	// Env is LOG_ENV, "production", "development", or "nop", in any case.
+	Env string `yaml:"env"`
+	// Level is LOG_LEVEL, which overrides the default level of the environment if it's not empty.
+	Level string `yaml:"level"`
+	// LevelRevertAfter is LOG_LEVEL_REVERT_AFTER, how long a level changed at runtime lasts.
+	LevelRevertAfter time.Duration `yaml:"level_revert_after"`
+	// SampleInitial is LOG_SAMPLE_INITIAL, and SampleThereafter is LOG_SAMPLE_THEREAFTER: in production, of the entries
+	// with the same message and level in each second, the first SampleInitial are logged, and then one in every
+	// SampleThereafter, so a burst of identical errors doesn't flood the logs. A SampleInitial of 0 logs everything.
+	SampleInitial    int `yaml:"sample_initial"`
+	SampleThereafter int `yaml:"sample_thereafter"`
+}
+
+// Secrets configuration for resolving references to secrets with ResolveSecrets.
+// These settings can't be references themselves.
+type Secrets struct {
+	// EndpointURL is SECRETS_ENDPOINT_URL, of both Secrets Manager and Parameter Store, for local development.
+	EndpointURL string `yaml:"endpoint_url"`
+	// Timeout is SECRETS_TIMEOUT, for resolving all references.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AWS configuration of the region and credentials for the SDK, which otherwise come from its default chain,
+// like the environment, the shared config files, and the instance role.
+type AWS struct {
+	// Region is AWS_REGION, like eu-west-1. Without it, it must be in the profile.
+	Region string `yaml:"region"`
+	// Profile is AWS_PROFILE, of the shared config and credentials files, like in ~/.aws/config.
+	Profile string `yaml:"profile"`
+	// AccessKeyID is AWS_ACCESS_KEY_ID, SecretAccessKey is AWS_SECRET_ACCESS_KEY, and SessionToken is AWS_SESSION_TOKEN,
+	// static credentials for local development, like against a local SQS. They win over the profile.
+	// They're used to resolve references to secrets, so they can't be references themselves.
+	AccessKeyID     string `yaml:"access_key_id" secret:"true"`
+	SecretAccessKey string `yaml:"secret_access_key" secret:"true"`
+	SessionToken    string `yaml:"session_token" secret:"true"`
+	// STSEndpointURL is AWS_STS_ENDPOINT_URL, for checking the identity of the credentials in local development.
+	STSEndpointURL string `yaml:"sts_endpoint_url"`
+}
+
+// Sentry configuration for reporting panics and unexpected errors to Sentry, or a compatible service.
+type Sentry struct {
+	// DSN is SENTRY_DSN, like https://key@o1.ingest.sentry.io/2. Without it, nothing is reported.
+	DSN string `yaml:"dsn" secret:"true"`
+	// Environment is SENTRY_ENVIRONMENT, like "production", to tell events from different deployments apart.
+	Environment string `yaml:"environment"`
+	// Burst is SENTRY_BURST, and SampleEvery is SENTRY_SAMPLE_EVERY: of each class of errors, the first Burst
+	// in a minute are reported, and then one in every SampleEvery.
+	Burst       int `yaml:"burst"`
+	SampleEvery int `yaml:"sample_every"`
+	// FlushTimeout is SENTRY_FLUSH_TIMEOUT, how long sending the reports left at shutdown can take.
+	FlushTimeout time.Duration `yaml:"flush_timeout"`
+}
+
+// Tracing configuration for exporting OpenTelemetry traces to a collector, from the variables the OpenTelemetry SDKs use.
+type Tracing struct {
+	// Endpoint is OTEL_EXPORTER_OTLP_ENDPOINT, the base URL of the collector, like http://localhost:4318,
+	// for traces at /v1/traces. TracesEndpoint is OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, the full URL for traces,
+	// which overrides it. Without either, nothing is traced.
+	Endpoint       string `yaml:"endpoint"`
+	TracesEndpoint string `yaml:"traces_endpoint"`
+	// Headers is OTEL_EXPORTER_OTLP_HEADERS, comma-separated key=value pairs for each export, like for authentication.
+	Headers []string `yaml:"headers" secret:"true"`
+	// Protocol is OTEL_EXPORTER_OTLP_PROTOCOL, which can only be http/json.
+	Protocol string `yaml:"protocol"`
+	// Timeout is OTEL_EXPORTER_OTLP_TIMEOUT, of each export. Like for the SDKs, the variable is in milliseconds.
+	Timeout time.Duration `yaml:"timeout"`
+	// ServiceName is OTEL_SERVICE_NAME, and ResourceAttributes is OTEL_RESOURCE_ATTRIBUTES,
+	// comma-separated key=value pairs for all spans, like deployment.environment=production.
+	ServiceName        string   `yaml:"service_name"`
+	ResourceAttributes []string `yaml:"resource_attributes"`
+}
+
+// Metrics configuration for emitting metrics to CloudWatch, for where there's no Prometheus to scrape /metrics.
+// Metrics are at /metrics either way.
+type Metrics struct {
+	// Backend is METRICS_BACKEND, "prometheus" to only have them at /metrics, or "cloudwatch" to also emit them
+	// to CloudWatch every METRICS_INTERVAL and at shutdown, in the METRICS_NAMESPACE namespace.
+	// They're emitted as JSON lines in the Embedded Metric Format in the log, which CloudWatch Logs turns into metrics,
+	// so the log needs LOG_ENV=production to be JSON. See metrics.Emitter.
+	Backend   string        `yaml:"backend"`
+	Namespace string        `yaml:"namespace"`
+	Interval  time.Duration `yaml:"interval"`
+	// PutMetricData is METRICS_PUT_METRIC_DATA, whether metrics are put with the CloudWatch API instead,
+	// for where the log doesn't go to CloudWatch Logs. It needs the cloudwatch:PutMetricData permission.
+	PutMetricData bool `yaml:"put_metric_data"`
+	// EndpointURL is METRICS_ENDPOINT_URL, of the CloudWatch API, for local development.
+	EndpointURL string `yaml:"endpoint_url"`
+}
+
+// TracesURL that traces are exported to, or empty if tracing is off.
+func (t Tracing) TracesURL() string {
+	if t.TracesEndpoint != "" {
+		return t.TracesEndpoint
+	}
+	if t.Endpoint != "" {
+		return strings.TrimSuffix(t.Endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// HeaderValues of Headers by key.
+func (t Tracing) HeaderValues() map[string]string {
+	return parsePairs(t.Headers)
+}
+
+// ResourceAttributeValues of ResourceAttributes by key.
+func (t Tracing) ResourceAttributeValues() map[string]string {
+	return parsePairs(t.ResourceAttributes)
+}
+
+// parsePairs like key=value, with percent-encoded values like in the W3C baggage format the OpenTelemetry SDKs use.
+// Pairs that can't be parsed are skipped, and reported by Validate.
+func parsePairs(pairs []string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(strings.TrimSpace(v)); err == nil {
+			values[k] = unescaped
+		}
+	}
+	return values
+}
+
+// defaults for all settings.
+func defaults() Config {
+	return Config{
+		Server: Server{
+			Host:                        "localhost",
+			Port:                        8080,
+			BaseURL:                     "http://localhost:8080",
+			DegradedCacheTTL:            time.Minute,
+			LoadShedAfter:               500 * time.Millisecond,
+			RunWorker:                   true,
+			SESTransientBounceThreshold: 3,
+			SESTransientBounceWindow:    7 * 24 * time.Hour,
+			ShutdownTimeout:             45 * time.Second,
+			StartupTimeout:              time.Minute,
+			SessionLifetime:             24 * time.Hour,
+			ACMEHTTPPort:                80,
+		},
+		Signup: Signup{
+			MinFillTime:               2 * time.Second,
+			MaxFormAge:                24 * time.Hour,
+			CaptchaTimeout:            5 * time.Second,
+			EmailDedupDomains:         []string{"gmail.com", "googlemail.com"},
+			DisposableDomainsInterval: 24 * time.Hour,
+		},
+		Database: Database{
+			Host:                  "localhost",
+			Port:                  5432,
+			MaxOpenConnections:    10,
+			MaxIdleConnections:    10,
+			ConnectionMaxLifetime: time.Hour,
+			HealthInterval:        5 * time.Second,
+		},
+		Queue: Queue{
+			Name:           "jobs",
+			DeadLetterName: "jobs-dead-letter",
+			QueueSettings: QueueSettings{
+				MaxRetries:             5,
+				MessageRetentionPeriod: 4 * 24 * time.Hour,
+				ReceiveWaitTime:        20 * time.Second,
+				SendTimeout:            10 * time.Second,
+				VisibilityTimeout:      30 * time.Second,
+				WaitTime:               20 * time.Second,
+			},
+			JobLimit:        10,
+			OutboxRetention: 7 * 24 * time.Hour,
+			ReadyTimeout:    30 * time.Second,
+		},
+		Worker: Worker{
+			Host:            "localhost",
+			Port:            8090,
+			ShutdownTimeout: 30 * time.Second,
+			SummaryInterval: 5 * time.Minute,
+		},
+		Schedule: Schedule{
+			Interval:           10 * time.Second,
+			NewsletterTimezone: "UTC",
+			NewsletterGrace:    6 * time.Hour,
+		},
+		Email: Email{
+			From:                   "canvas@example.com",
+			PhysicalAddress:        "canvas, 1 Example Street, 12345 Example City",
+			RateLimit:              10,
+			Backend:                "log",
+			SESQuota:               true,
+			SESQuotaInterval:       time.Minute,
+			SESQuotaRatePercent:    80,
+			SESQuotaReservePercent: 10,
+			SMTPPort:               587,
+			SMTPSecurity:           "starttls",
+			SMTPTimeout:            10 * time.Second,
+			SendLogRetention:       180 * 24 * time.Hour,
+			SendRetryAttempts:      3,
+			SendRetryDelay:         500 * time.Millisecond,
+			SendRetryBudget:        10 * time.Second,
+			VerifyIdentity:         "off",
+		},
+		Webhook: Webhook{
+			Timeout:      10 * time.Second,
+			Attempts:     8,
+			DisableAfter: 5,
+		},
+		Log: Log{
+			Env:              "development",
+			LevelRevertAfter: 30 * time.Minute,
+			SampleInitial:    10,
+			SampleThereafter: 100,
+		},
+		Secrets: Secrets{
+			Timeout: 10 * time.Second,
+		},
+		Sentry: Sentry{
+			Burst:        10,
+			SampleEvery:  10,
+			FlushTimeout: 2 * time.Second,
+		},
+		Tracing: Tracing{
+			Protocol:    "http/json",
+			Timeout:     10 * time.Second,
+			ServiceName: "canvas",
+		},
+		Metrics: Metrics{
+			Backend:   "prometheus",
+			Namespace: "canvas",
+			Interval:  time.Minute,
+		},
+	}
+}
+
+// Load the configuration from the defaults, the configuration file, and the environment, in order of precedence.
+// Values that can't be read, like a PORT that isn't a number, and a CONFIG_FILE that doesn't exist,
+// are reported by Validate.
+func Load() Config {
+	c := defaults()
+
+	path, explicit := os.LookupEnv("CONFIG_FILE")
+	if !explicit {
+		path = defaultFile
+	}
+	deadLetter := c.loadFile(path, explicit)
+
+	l := loader{problems: c.problems}
+	s := &c.Server
+	l.int(&s.AccessLogQuietSampleEvery, "ACCESS_LOG_QUIET_SAMPLE_EVERY")
+	l.string(&s.Host, "HOST")
+	l.int(&s.Port, "PORT")
+	l.string(&s.BaseURL, "BASE_URL")
+	l.string(&s.AdminPasswordHash, "ADMIN_PASSWORD_HASH")
+	l.list(&s.CORSAllowedOrigins, "CORS_ALLOWED_ORIGINS")
+	l.list(&s.EmbedPartnerOrigins, "EMBED_PARTNER_ORIGINS")
+	l.duration(&s.DegradedCacheTTL, "DEGRADED_CACHE_TTL")
+	l.duration(&s.LoadShedAfter, "LOAD_SHED_AFTER")
+	l.bool(&s.RobotsDisallowAll, "ROBOTS_DISALLOW_ALL")
+	l.bool(&s.RunWorker, "SERVER_RUN_WORKER")
+	l.int(&s.SESTransientBounceThreshold, "SES_TRANSIENT_BOUNCE_THRESHOLD")
+	l.duration(&s.SESTransientBounceWindow, "SES_TRANSIENT_BOUNCE_WINDOW")
+	l.duration(&s.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+	l.duration(&s.StartupTimeout, "STARTUP_TIMEOUT")
+	l.string(&s.SessionSecret, "SESSION_SECRET")
+	l.duration(&s.SessionLifetime, "SESSION_LIFETIME")
+	l.string(&s.SiteDescription, "SITE_DESCRIPTION")
+	l.string(&s.SiteImageURL, "SITE_IMAGE_URL")
+	l.string(&s.SiteTwitterCard, "SITE_TWITTER_CARD")
+	l.string(&s.TrackingSecret, "TRACKING_SECRET")
+	l.list(&s.TrustedProxies, "TRUSTED_PROXIES")
+	l.bool(&s.TwoStepConfirm, "NEWSLETTER_TWO_STEP_CONFIRM")
+	l.string(&s.UnsubscribeSecret, "UNSUBSCRIBE_SECRET")
+	l.list(&s.ACMEHosts, "ACME_HOSTS")
+	l.string(&s.ACMECache, "ACME_CACHE")
+	l.int(&s.ACMEHTTPPort, "ACME_HTTP_PORT")
+	l.string(&s.ACMEEmail, "ACME_EMAIL")
+	l.string(&s.ACMEDirectoryURL, "ACME_DIRECTORY_URL")
+	l.bool(&s.ViewsDev, "VIEWS_DEV")
+
+	su := &c.Signup
+	l.string(&su.FormSecret, "SIGNUP_FORM_SECRET")
+	l.duration(&su.MinFillTime, "SIGNUP_MIN_FILL_TIME")
+	l.duration(&su.MaxFormAge, "SIGNUP_MAX_FORM_AGE")
+	l.bool(&su.ThrottleDatabase, "SIGNUP_THROTTLE_DATABASE")
+	l.string(&su.CaptchaProvider, "CAPTCHA_PROVIDER")
+	l.string(&su.CaptchaSecret, "CAPTCHA_SECRET")
+	l.string(&su.CaptchaSiteKey, "CAPTCHA_SITE_KEY")
+	l.duration(&su.CaptchaTimeout, "CAPTCHA_TIMEOUT")
+	l.bool(&su.CaptchaFailOpen, "CAPTCHA_FAIL_OPEN")
+	l.bool(&su.EmailPolicy, "SIGNUP_EMAIL_POLICY")
+	l.list(&su.EmailDedupDomains, "SIGNUP_EMAIL_DEDUP_DOMAINS")
+	l.string(&su.DisposableDomainsURL, "SIGNUP_DISPOSABLE_DOMAINS_URL")
+	l.duration(&su.DisposableDomainsInterval, "SIGNUP_DISPOSABLE_DOMAINS_INTERVAL")
+
+	d := &c.Database
+	l.string(&d.Host, "DB_HOST")
+	l.int(&d.Port, "DB_PORT")
+	l.string(&d.User, "DB_USER")
+	l.string(&d.Password, "DB_PASSWORD")
+	l.string(&d.Name, "DB_NAME")
+	l.int(&d.MaxOpenConnections, "DB_MAX_OPEN_CONNECTIONS")
+	l.int(&d.MaxIdleConnections, "DB_MAX_IDLE_CONNECTIONS")
+	l.duration(&d.ConnectionMaxLifetime, "DB_CONNECTION_MAX_LIFETIME")
+	l.duration(&d.HealthInterval, "DB_HEALTH_INTERVAL")
+	l.bool(&d.MigrateOnStart, "MIGRATE_ON_START")
+
+	q := &c.Queue
+	l.string(&q.Name, "QUEUE_NAME")
+	l.string(&q.DeadLetterName, "DEAD_LETTER_QUEUE_NAME")
+	l.string(&q.EventsName, "EVENTS_QUEUE_NAME")
+	l.string(&q.EndpointURL, "SQS_ENDPOINT_URL")
+	l.bool(&q.AdaptiveRetry, "QUEUE_ADAPTIVE_RETRY")
+	l.int(&q.MaxRetries, "QUEUE_MAX_RETRIES")
+	l.duration(&q.MessageRetentionPeriod, "QUEUE_MESSAGE_RETENTION_PERIOD")
+	l.duration(&q.ReceiveWaitTime, "QUEUE_RECEIVE_WAIT_TIME")
+	l.duration(&q.SendTimeout, "QUEUE_SEND_TIMEOUT")
+	l.duration(&q.VisibilityTimeout, "QUEUE_VISIBILITY_TIMEOUT")
+	l.duration(&q.WaitTime, "QUEUE_WAIT_TIME")
+	l.bool(&q.Ensure, "QUEUE_ENSURE")
+	l.bool(&q.StrictAttributes, "QUEUE_STRICT_ATTRIBUTES")
+	l.int(&q.JobLimit, "JOB_LIMIT")
+	l.duration(&q.OutboxRetention, "OUTBOX_RETENTION")
+	l.duration(&q.ReadyTimeout, "QUEUE_READY_TIMEOUT")
+
+	// The dead letter queue has the settings of the job queue, after the environment, and its own from the file.
+	q.DeadLetter = q.QueueSettings
+	if deadLetter != nil {
+		if err := deadLetter.Decode(&q.DeadLetter); err != nil {
+			l.problems = append(l.problems, fileProblem(path, err)...)
+		}
+	}
+
+	w := &c.Worker
+	l.bool(&w.Only, "WORKER_ONLY")
+	l.string(&w.Host, "WORKER_HOST")
+	l.int(&w.Port, "WORKER_PORT")
+	l.duration(&w.ShutdownTimeout, "WORKER_SHUTDOWN_TIMEOUT")
+	l.duration(&w.SummaryInterval, "WORKER_SUMMARY_INTERVAL")
+
+	sch := &c.Schedule
+	l.separated(&sch.Jobs, "SCHEDULES", ";")
+	l.duration(&sch.Interval, "SCHEDULE_INTERVAL")
+	l.bool(&sch.RunMisfired, "SCHEDULE_RUN_MISFIRED")
+	l.string(&sch.NewsletterTimezone, "SCHEDULE_NEWSLETTER_TIMEZONE")
+	l.duration(&sch.NewsletterGrace, "SCHEDULE_NEWSLETTER_GRACE")
+
+	e := &c.Email
+	l.string(&e.From, "EMAIL_FROM")
+	l.string(&e.PhysicalAddress, "EMAIL_PHYSICAL_ADDRESS")
+	l.string(&e.UnsubscribeMailto, "EMAIL_UNSUBSCRIBE_MAILTO")
+	l.int(&e.RateLimit, "EMAIL_RATE_LIMIT")
+	l.string(&e.Backend, "EMAIL_BACKEND")
+	l.string(&e.SESConfigurationSet, "SES_CONFIGURATION_SET")
+	l.string(&e.SESFromARN, "SES_FROM_ARN")
+	l.string(&e.SESEndpointURL, "SES_ENDPOINT_URL")
+	l.bool(&e.SESQuota, "SES_QUOTA")
+	l.duration(&e.SESQuotaInterval, "SES_QUOTA_INTERVAL")
+	l.int(&e.SESQuotaRatePercent, "SES_QUOTA_RATE_PERCENT")
+	l.int(&e.SESQuotaReservePercent, "SES_QUOTA_RESERVE_PERCENT")
+	l.string(&e.SMTPHost, "SMTP_HOST")
+	l.int(&e.SMTPPort, "SMTP_PORT")
+	l.string(&e.SMTPUsername, "SMTP_USERNAME")
+	l.string(&e.SMTPPassword, "SMTP_PASSWORD")
+	l.string(&e.SMTPSecurity, "SMTP_SECURITY")
+	l.duration(&e.SMTPTimeout, "SMTP_TIMEOUT")
+	l.duration(&e.SendLogRetention, "EMAIL_SEND_LOG_RETENTION")
+	l.int(&e.SendRetryAttempts, "EMAIL_SEND_RETRY_ATTEMPTS")
+	l.duration(&e.SendRetryDelay, "EMAIL_SEND_RETRY_DELAY")
+	l.duration(&e.SendRetryBudget, "EMAIL_SEND_RETRY_BUDGET")
+	l.string(&e.VerifyIdentity, "EMAIL_VERIFY_IDENTITY")
+
+	wh := &c.Webhook
+	l.bool(&wh.AllowPrivateNetworks, "WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	l.duration(&wh.Timeout, "WEBHOOK_TIMEOUT")
+	l.int(&wh.Attempts, "WEBHOOK_ATTEMPTS")
+	l.int(&wh.DisableAfter, "WEBHOOK_DISABLE_AFTER")
+
+	l.string(&c.Flags.File, "FLAGS_FILE")
+
+	lg := &c.Log
+	l.string(&lg.Env, "LOG_ENV")
+	l.string(&lg.Level, "LOG_LEVEL")
+	l.duration(&lg.LevelRevertAfter, "LOG_LEVEL_REVERT_AFTER")
+	l.int(&lg.SampleInitial, "LOG_SAMPLE_INITIAL")
+	l.int(&lg.SampleThereafter, "LOG_SAMPLE_THEREAFTER")
+
+	sc := &c.Secrets
+	l.string(&sc.EndpointURL, "SECRETS_ENDPOINT_URL")
+	l.duration(&sc.Timeout, "SECRETS_TIMEOUT")
+
+	aw := &c.AWS
+	l.string(&aw.Region, "AWS_REGION")
+	l.string(&aw.Profile, "AWS_PROFILE")
+	l.string(&aw.AccessKeyID, "AWS_ACCESS_KEY_ID")
+	l.string(&aw.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	l.string(&aw.SessionToken, "AWS_SESSION_TOKEN")
+	l.string(&aw.STSEndpointURL, "AWS_STS_ENDPOINT_URL")
+
+	se := &c.Sentry
+	l.string(&se.DSN, "SENTRY_DSN")
+	l.string(&se.Environment, "SENTRY_ENVIRONMENT")
+	l.int(&se.Burst, "SENTRY_BURST")
+	l.int(&se.SampleEvery, "SENTRY_SAMPLE_EVERY")
+	l.duration(&se.FlushTimeout, "SENTRY_FLUSH_TIMEOUT")
+
+	t := &c.Tracing
+	l.string(&t.Endpoint, "OTEL_EXPORTER_OTLP_ENDPOINT")
+	l.string(&t.TracesEndpoint, "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	l.list(&t.Headers, "OTEL_EXPORTER_OTLP_HEADERS")
+	l.string(&t.Protocol, "OTEL_EXPORTER_OTLP_PROTOCOL")
+	l.milliseconds(&t.Timeout, "OTEL_EXPORTER_OTLP_TIMEOUT")
+	l.string(&t.ServiceName, "OTEL_SERVICE_NAME")
+	l.list(&t.ResourceAttributes, "OTEL_RESOURCE_ATTRIBUTES")
+
+	m := &c.Metrics
+	l.string(&m.Backend, "METRICS_BACKEND")
+	l.string(&m.Namespace, "METRICS_NAMESPACE")
+	l.duration(&m.Interval, "METRICS_INTERVAL")
+	l.bool(&m.PutMetricData, "METRICS_PUT_METRIC_DATA")
+	l.string(&m.EndpointURL, "METRICS_ENDPOINT_URL")
+
+	c.problems = l.problems
+	c.setSources(l.fromEnv)
+	return c
+}
+
+// File that the configuration was read from, or empty if there was none.
+func (c Config) File() string {
+	return c.file
+}
+
+// UnknownKeys in the configuration file, like "server.prot", sorted. They're ignored, but probably typos.
+func (c Config) UnknownKeys() []string {
+	return c.unknownKeys
+}
+
+// loadFile at path into c, if it exists. It must exist if it's explicit.
+// It returns the node of the dead letter queue settings if there is one, to apply after the environment.
+func (c *Config) loadFile(path string, explicit bool) *yaml.Node {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		c.problems = append(c.problems, fmt.Sprintf("CONFIG_FILE %v can't be read: %v", path, err))
+		return nil
+	}
+	c.file = path
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		c.problems = append(c.problems, fileProblem(path, err)...)
+		return nil
+	}
+	// An empty file has no document.
+	if len(root.Content) == 0 {
+		return nil
+	}
+	doc := root.Content[0]
+	if err := doc.Decode(c); err != nil {
+		c.problems = append(c.problems, fileProblem(path, err)...)
+	}
+	c.sources = map[string]Source{}
+	c.unknownKeys = unknownKeys(doc, reflect.TypeOf(Config{}), "", func(key string) {
+		c.sources[key] = SourceFile
+	})
+	sort.Strings(c.unknownKeys)
+	return child(child(doc, "queue"), "dead_letter")
+}
+
+// fileProblem for the error decoding the file at path, with one problem per field for type errors.
+func fileProblem(path string, err error) []string {
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) {
+		var problems []string
+		for _, e := range terr.Errors {
+			problems = append(problems, fmt.Sprintf("CONFIG_FILE %v: %v", path, e))
+		}
+		return problems
+	}
+	return []string{fmt.Sprintf("CONFIG_FILE %v: %v", path, err)}
+}
+
+// child of the mapping node n with the key, or nil if there isn't one.
+func child(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// unknownKeys in the mapping node n that aren't yaml tags of the struct type t, prefixed with the path of n.
+// Keys of inline structs count as keys of t. The keys of settings that are known are passed to known.
+func unknownKeys(n *yaml.Node, t reflect.Type, prefix string, known func(key string)) []string {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	fields := map[string]reflect.Type{}
+	addFields(fields, t)
+
+	var unknown []string
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i].Value, n.Content[i+1]
+		ft, ok := fields[key]
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		if ft.Kind() == reflect.Struct && ft != reflect.TypeOf(time.Duration(0)) {
+			unknown = append(unknown, unknownKeys(value, ft, prefix+key+".", known)...)
+			continue
+		}
+		known(prefix + key)
+	}
+	return unknown
+}
+
+// addFields of the struct type t to fields by yaml key, including the fields of inline structs.
+func addFields(fields map[string]reflect.Type, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("yaml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if tag == ",inline" {
+			addFields(fields, f.Type)
+			continue
+		}
+		fields[tag] = f.Type
+	}
+}
+
+// ValidationError for a configuration with problems, with all of them.
+type ValidationError struct {
+	// Problems, each starting with the name of the environment variable, like "PORT must be between 1 and 65535".
+	// Secrets are only ever named, never quoted.
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate the configuration for serve, returning a *ValidationError with all problems, or nil if there are none.
+// It's everything, since serve runs the web app and, unless SERVER_RUN_WORKER is false, the job queue worker.
+func (c Config) Validate() error {
+	v := validator{problems: append([]string(nil), c.problems...)}
+	c.validateJobs(&v)
+	c.validateWeb(&v)
+	c.validateSchedule(&v)
+	c.validateDatabase(&v)
+	c.validateLog(&v)
+	// Development views reload translations from disk and fail on page errors, which production mustn't do.
+	if c.Server.ViewsDev && strings.EqualFold(c.Log.Env, "production") {
+		v.add("VIEWS_DEV can't be used with LOG_ENV=production")
+	}
+	c.validateAWS(&v)
+	c.validateSecretReferences(&v)
+	return v.err()
+}
+
+// ValidateWorker like Validate, but only what the job queue worker needs, for the worker command and WORKER_ONLY.
+// The secrets of the web app, like SESSION_SECRET, aren't required.
+func (c Config) ValidateWorker() error {
+	v := validator{problems: append([]string(nil), c.problems...)}
+	c.validateJobs(&v)
+	c.validateDatabase(&v)
+	c.validateLog(&v)
+	c.validateAWS(&v)
+	c.validateSecretReferences(&v)
+	return v.err()
+}
+
+// ValidateDatabase like Validate, but only the database, log, and AWS configuration, and the problems reading it.
+// It's for commands that only need the database, like migrate, so they don't need the secrets of the server.
+func (c Config) ValidateDatabase() error {
+	v := validator{problems: append([]string(nil), c.problems...)}
+	c.validateDatabase(&v)
+	c.validateLog(&v)
+	c.validateAWS(&v)
+	c.validateSecretReferences(&v)
+	return v.err()
+}
+
+// ValidateEmail like Validate, but only the email, BASE_URL, log, and AWS configuration, and the problems reading it.
+// It's for the email command, which renders and sends sample emails without the rest of the app.
+func (c Config) ValidateEmail() error {
+	v := validator{problems: append([]string(nil), c.problems...)}
+	c.validateEmail(&v)
+	v.absoluteURL("BASE_URL", c.Server.BaseURL)
+	c.validateLog(&v)
+	c.validateAWS(&v)
+	c.validateSecretReferences(&v)
+	return v.err()
+}
+
+// validateJobs checks the settings of the job queue worker, and of the emails the jobs send.
+func (c Config) validateJobs(v *validator) {
+	v.required("UNSUBSCRIBE_SECRET", c.Server.UnsubscribeSecret)
+	if c.Server.TrackingSecret != "" {
+		v.minLength("TRACKING_SECRET", c.Server.TrackingSecret, minTrackingSecretLength)
+	}
+
+	v.absoluteURL("BASE_URL", c.Server.BaseURL)
+	if c.Server.BaseURL != "" {
+		if u, err := url.Parse(c.Server.BaseURL); err == nil && (u.RawQuery != "" || u.Fragment != "") {
+			v.add("BASE_URL must not have a query or fragment")
+		}
+	}
+	if c.Queue.EndpointURL != "" {
+		v.absoluteURL("SQS_ENDPOINT_URL", c.Queue.EndpointURL)
+	}
+	if c.Secrets.EndpointURL != "" {
+		v.absoluteURL("SECRETS_ENDPOINT_URL", c.Secrets.EndpointURL)
+	}
+
+	v.required("QUEUE_NAME", c.Queue.Name)
+	v.required("DEAD_LETTER_QUEUE_NAME", c.Queue.DeadLetterName)
+	if c.Queue.Name != "" && c.Queue.Name == c.Queue.DeadLetterName {
+		v.add("QUEUE_NAME and DEAD_LETTER_QUEUE_NAME must be different")
+	}
+	if c.Queue.EventsName != "" && (c.Queue.EventsName == c.Queue.Name || c.Queue.EventsName == c.Queue.DeadLetterName) {
+		v.add("EVENTS_QUEUE_NAME must be different from QUEUE_NAME and DEAD_LETTER_QUEUE_NAME")
+	}
+	if c.Queue.MaxRetries < 0 {
+		v.add("QUEUE_MAX_RETRIES must not be negative")
+	}
+	if c.Queue.DeadLetter.MaxRetries < 0 {
+		v.add("queue.dead_letter.max_retries must not be negative")
+	}
+	v.positive("JOB_LIMIT", c.Queue.JobLimit)
+	if c.Queue.ReadyTimeout <= 0 {
+		v.add("QUEUE_READY_TIMEOUT must be positive")
+	}
+
+	v.port("WORKER_PORT", c.Worker.Port)
+	if c.Worker.ShutdownTimeout < 0 {
+		v.add("WORKER_SHUTDOWN_TIMEOUT must not be negative")
+	}
+	if c.Worker.SummaryInterval < 0 {
+		v.add("WORKER_SUMMARY_INTERVAL must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		v.add("SHUTDOWN_TIMEOUT must be positive")
+	} else if c.Worker.ShutdownTimeout >= c.Server.ShutdownTimeout {
+		// Otherwise, draining jobs would use up all the time for shutting down, and the process would be forced to exit.
+		v.add("WORKER_SHUTDOWN_TIMEOUT must be less than SHUTDOWN_TIMEOUT")
+	}
+	if c.Server.StartupTimeout <= 0 {
+		v.add("STARTUP_TIMEOUT must be positive")
+	}
+
+	c.validateEmail(v)
+	c.validateWebhook(v)
+	c.validateSentry(v)
+	c.validateTracing(v)
+	c.validateMetrics(v)
+}
+
+// validateWebhook checks the settings of delivering to webhook endpoints.
+func (c Config) validateWebhook(v *validator) {
+	if c.Webhook.Timeout <= 0 {
+		v.add("WEBHOOK_TIMEOUT must be positive")
+	}
+	v.positive("WEBHOOK_ATTEMPTS", c.Webhook.Attempts)
+	v.positive("WEBHOOK_DISABLE_AFTER", c.Webhook.DisableAfter)
+	if c.Webhook.AllowPrivateNetworks && strings.EqualFold(c.Log.Env, "production") {
+		v.add("WEBHOOK_ALLOW_PRIVATE_NETWORKS can't be used with LOG_ENV=production")
+	}
+}
+
+// validateEmail checks the settings of sending emails, and of the links in them.
+func (c Config) validateEmail(v *validator) {
+	if _, err := mail.ParseAddress(c.Email.From); err != nil {
+		v.add(fmt.Sprintf("EMAIL_FROM must be an email address, not %q", c.Email.From))
+	}
+	v.required("EMAIL_PHYSICAL_ADDRESS", c.Email.PhysicalAddress)
+	if c.Email.UnsubscribeMailto != "" {
+		if a, err := mail.ParseAddress(c.Email.UnsubscribeMailto); err != nil || a.Address != c.Email.UnsubscribeMailto {
+			v.add(fmt.Sprintf("EMAIL_UNSUBSCRIBE_MAILTO must be an email address without a name, not %q", c.Email.UnsubscribeMailto))
+		}
+	}
+	v.positive("EMAIL_RATE_LIMIT", c.Email.RateLimit)
+	v.oneOf("EMAIL_BACKEND", c.Email.Backend, "log", "ses", "smtp")
+	if c.Email.SendLogRetention <= 0 {
+		v.add("EMAIL_SEND_LOG_RETENTION must be positive")
+	}
+	v.positive("EMAIL_SEND_RETRY_ATTEMPTS", c.Email.SendRetryAttempts)
+	if c.Email.SendRetryDelay <= 0 {
+		v.add("EMAIL_SEND_RETRY_DELAY must be positive")
+	}
+	if c.Email.SendRetryBudget <= 0 {
+		v.add("EMAIL_SEND_RETRY_BUDGET must be positive")
+	} else if c.Queue.VisibilityTimeout > 0 && c.Email.SendRetryBudget >= c.Queue.VisibilityTimeout {
+		// Otherwise, the message would be received again while the job is still retrying, and the email sent twice.
+		v.add("EMAIL_SEND_RETRY_BUDGET must be less than QUEUE_VISIBILITY_TIMEOUT")
+	}
+	v.oneOf("EMAIL_VERIFY_IDENTITY", c.Email.VerifyIdentity, "off", "warn", "strict")
+	if c.Email.SESEndpointURL != "" {
+		v.absoluteURL("SES_ENDPOINT_URL", c.Email.SESEndpointURL)
+	}
+	if c.Email.Backend == "ses" && c.Email.SESQuota {
+		if c.Email.SESQuotaInterval <= 0 {
+			v.add("SES_QUOTA_INTERVAL must be positive")
+		}
+		if p := c.Email.SESQuotaRatePercent; p < 1 || p > 100 {
+			v.add(fmt.Sprintf("SES_QUOTA_RATE_PERCENT must be from 1 to 100, not %v", p))
+		}
+		if p := c.Email.SESQuotaReservePercent; p < 1 || p > 99 {
+			v.add(fmt.Sprintf("SES_QUOTA_RESERVE_PERCENT must be from 1 to 99, not %v", p))
+		}
+	}
+	if c.Email.Backend == "smtp" {
+		v.required("SMTP_HOST", c.Email.SMTPHost)
+		v.port("SMTP_PORT", c.Email.SMTPPort)
+		v.oneOf("SMTP_SECURITY", c.Email.SMTPSecurity, "starttls", "tls", "none")
+		if c.Email.SMTPTimeout <= 0 {
+			v.add("SMTP_TIMEOUT must be positive")
+		}
+	}
+}
+
+// validateSentry checks the error reporting settings, which both the web app and the worker use.
+// validateAWS checks that static credentials are complete, and not references to secrets.
+func (c Config) validateAWS(v *validator) {
+	a := c.AWS
+	if (a.AccessKeyID == "") != (a.SecretAccessKey == "") {
+		v.add("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+	}
+	if a.SessionToken != "" && a.AccessKeyID == "" {
+		v.add("AWS_SESSION_TOKEN requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	credentials := []struct{ name, value string }{
+		{"AWS_ACCESS_KEY_ID", a.AccessKeyID}, {"AWS_SECRET_ACCESS_KEY", a.SecretAccessKey}, {"AWS_SESSION_TOKEN", a.SessionToken},
+	}
+	for _, cred := range credentials {
+		if isSecretReference(cred.value) {
+			v.add(cred.name + " must not be a reference to a secret, because it's needed to resolve them")
+		}
+	}
+	if a.STSEndpointURL != "" {
+		v.absoluteURL("AWS_STS_ENDPOINT_URL", a.STSEndpointURL)
+	}
+}
+
+func (c Config) validateSentry(v *validator) {
+	// The DSN has a key, so it's not quoted, and references are reported by validateSecretReferences.
+	if dsn := c.Sentry.DSN; dsn != "" && !isSecretReference(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User.Username() == "" || u.Host == "" ||
+			strings.Trim(u.Path, "/") == "" {
+			v.add("SENTRY_DSN must be like https://key@o1.ingest.sentry.io/2")
+		}
+	}
+	v.positive("SENTRY_BURST", c.Sentry.Burst)
+	v.positive("SENTRY_SAMPLE_EVERY", c.Sentry.SampleEvery)
+	if c.Sentry.FlushTimeout < 0 {
+		v.add("SENTRY_FLUSH_TIMEOUT must not be negative")
+	}
+}
+
+// validateSchedule checks that the scheduled jobs are like name=expression=job, with valid cron expressions
+// and unique names, so a typo stops startup instead of a job quietly never running,
+// and that the newsletter time zone is one.
+func (c Config) validateSchedule(v *validator) {
+	names := map[string]bool{}
+	for _, entry := range c.Schedule.Jobs {
+		j, ok := parseScheduledJob(entry)
+		if !ok {
+			v.add(fmt.Sprintf("SCHEDULES must be like name=expression=job, not %q", entry))
+			continue
+		}
+		if _, err := cron.ParseStandard(j.Expression); err != nil {
+			v.add(fmt.Sprintf("SCHEDULES has an invalid cron expression %q for %v: %v", j.Expression, j.Name, err))
+		}
+		if names[j.Name] {
+			v.add(fmt.Sprintf("SCHEDULES has %v more than once", j.Name))
+		}
+		names[j.Name] = true
+	}
+	if c.Schedule.Interval <= 0 {
+		v.add("SCHEDULE_INTERVAL must be positive")
+	}
+	if _, err := time.LoadLocation(c.Schedule.NewsletterTimezone); err != nil {
+		v.add(fmt.Sprintf("SCHEDULE_NEWSLETTER_TIMEZONE must be a time zone like Europe/Berlin, not %q", c.Schedule.NewsletterTimezone))
+	}
+	if c.Schedule.NewsletterGrace <= 0 {
+		v.add("SCHEDULE_NEWSLETTER_GRACE must be positive")
+	}
+}
+
+// validateWeb checks the settings of the web app.
+func (c Config) validateWeb(v *validator) {
+	v.required("SIGNUP_FORM_SECRET", c.Signup.FormSecret)
+	v.required("SESSION_SECRET", c.Server.SessionSecret)
+
+	v.port("PORT", c.Server.Port)
+
+	if c.Server.SiteImageURL != "" && !strings.HasPrefix(c.Server.SiteImageURL, "/") {
+		v.absoluteURL("SITE_IMAGE_URL", c.Server.SiteImageURL)
+	}
+	v.origins("CORS_ALLOWED_ORIGINS", c.Server.CORSAllowedOrigins)
+	v.origins("EMBED_PARTNER_ORIGINS", c.Server.EmbedPartnerOrigins)
+	v.cidrs("TRUSTED_PROXIES", c.Server.TrustedProxies)
+
+	v.oneOf("SITE_TWITTER_CARD", c.Server.SiteTwitterCard, "", "summary", "summary_large_image")
+
+	if c.Server.LoadShedAfter < 0 {
+		v.add("LOAD_SHED_AFTER must not be negative")
+	}
+	if c.Server.DegradedCacheTTL < 0 {
+		v.add("DEGRADED_CACHE_TTL must not be negative")
+	}
+	if c.Server.AccessLogQuietSampleEvery < 0 {
+		v.add("ACCESS_LOG_QUIET_SAMPLE_EVERY must not be negative")
+	}
+
+	if len(c.Server.ACMEHosts) > 0 {
+		// Without a cache, certificates would be requested again on every start, which soon hits the rate limits.
+		v.required("ACME_CACHE", c.Server.ACMECache)
+		v.port("ACME_HTTP_PORT", c.Server.ACMEHTTPPort)
+		if c.Server.ACMEHTTPPort == c.Server.Port {
+			v.add("ACME_HTTP_PORT must not be the same as PORT")
+		}
+		if c.Server.ACMEDirectoryURL != "" {
+			v.absoluteURL("ACME_DIRECTORY_URL", c.Server.ACMEDirectoryURL)
+		}
+	}
+
+	switch c.Signup.CaptchaProvider {
+	case "":
+		if c.Signup.CaptchaFailOpen {
+			v.add("CAPTCHA_FAIL_OPEN requires CAPTCHA_PROVIDER")
+		}
+	case "hcaptcha", "turnstile":
+		v.required("CAPTCHA_SECRET", c.Signup.CaptchaSecret)
+		v.required("CAPTCHA_SITE_KEY", c.Signup.CaptchaSiteKey)
+	default:
+		v.add(fmt.Sprintf("CAPTCHA_PROVIDER must be hcaptcha or turnstile, not %q", c.Signup.CaptchaProvider))
+	}
+
+	if c.Signup.DisposableDomainsURL != "" {
+		if !c.Signup.EmailPolicy {
+			v.add("SIGNUP_DISPOSABLE_DOMAINS_URL requires SIGNUP_EMAIL_POLICY")
+		}
+		v.absoluteURL("SIGNUP_DISPOSABLE_DOMAINS_URL", c.Signup.DisposableDomainsURL)
+		if c.Signup.DisposableDomainsInterval <= 0 {
+			v.add("SIGNUP_DISPOSABLE_DOMAINS_INTERVAL must be positive")
+		}
+	}
+}
+
+// validateDatabase checks the connection settings, which have no defaults for the credentials and name,
+// so a missing one stops startup instead of failing the first query with an authentication error.
+func (c Config) validateDatabase(v *validator) {
+	v.required("DB_HOST", c.Database.Host)
+	v.port("DB_PORT", c.Database.Port)
+	v.required("DB_USER", c.Database.User)
+	v.required("DB_PASSWORD", c.Database.Password)
+	v.required("DB_NAME", c.Database.Name)
+	v.positive("DB_MAX_OPEN_CONNECTIONS", c.Database.MaxOpenConnections)
+	if c.Database.MaxIdleConnections > c.Database.MaxOpenConnections {
+		v.add("DB_MAX_IDLE_CONNECTIONS must not be more than DB_MAX_OPEN_CONNECTIONS")
+	}
+}
+
+func (c Config) validateLog(v *validator) {
+	v.oneOf("LOG_ENV", strings.ToLower(c.Log.Env), "production", "development", "nop")
+	if c.Log.Level != "" {
+		if _, err := zapcore.ParseLevel(strings.ToLower(c.Log.Level)); err != nil {
+			v.add(fmt.Sprintf("LOG_LEVEL must be debug, info, warn, error, dpanic, panic, or fatal, not %q", c.Log.Level))
+		}
+	}
+	if c.Log.SampleInitial < 0 {
+		v.add("LOG_SAMPLE_INITIAL must not be negative")
+	}
+	if c.Log.SampleInitial > 0 {
+		v.positive("LOG_SAMPLE_THEREAFTER", c.Log.SampleThereafter)
+	}
+}
+
+// validateTracing checks the trace export settings, which both the web app and the worker use.
+func (c Config) validateTracing(v *validator) {
+	t := c.Tracing
+	if t.Endpoint != "" {
+		v.absoluteURL("OTEL_EXPORTER_OTLP_ENDPOINT", t.Endpoint)
+	}
+	if t.TracesEndpoint != "" {
+		v.absoluteURL("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", t.TracesEndpoint)
+	}
+	v.oneOf("OTEL_EXPORTER_OTLP_PROTOCOL", t.Protocol, "http/json")
+	if t.Timeout <= 0 {
+		v.add("OTEL_EXPORTER_OTLP_TIMEOUT must be positive")
+	}
+	v.required("OTEL_SERVICE_NAME", t.ServiceName)
+	// Headers can have secrets, so they're not quoted.
+	v.pairs("OTEL_EXPORTER_OTLP_HEADERS", t.Headers)
+	v.pairs("OTEL_RESOURCE_ATTRIBUTES", t.ResourceAttributes)
+}
+
+// validateMetrics checks the metrics settings, which both the web app and the worker use.
+// Namespaces starting with AWS/ are for the metrics of AWS services.
+func (c Config) validateMetrics(v *validator) {
+	m := c.Metrics
+	v.oneOf("METRICS_BACKEND", m.Backend, "prometheus", "cloudwatch")
+	if m.Backend != "cloudwatch" {
+		return
+	}
+	v.required("METRICS_NAMESPACE", m.Namespace)
+	if strings.HasPrefix(m.Namespace, "AWS/") {
+		v.add("METRICS_NAMESPACE must not start with AWS/")
+	}
+	if m.Interval < time.Second {
+		v.add("METRICS_INTERVAL must be at least a second")
+	}
+	if m.EndpointURL != "" {
+		v.absoluteURL("METRICS_ENDPOINT_URL", m.EndpointURL)
+	}
+}
+
+// loader reads environment variables, recording the ones that can't be parsed.
+type loader struct {
+	problems []string
+	// fromEnv has the pointers to the fields that were set from the environment, for sources.
+	fromEnv map[interface{}]bool
+}
+
+// lookup the environment variable with the name for the field at p, recording that it's from the environment.
+func (l *loader) lookup(p interface{}, name string) (string, bool) {
+	v, ok := os.LookupEnv(name)
+	if ok {
+		if l.fromEnv == nil {
+			l.fromEnv = map[interface{}]bool{}
+		}
+		l.fromEnv[p] = true
+	}
+	return v, ok
+}
+
+func (l *loader) string(p *string, name string) {
+	if v, ok := l.lookup(p, name); ok {
+		*p = v
+	}
+}
+
+func (l *loader) int(p *int, name string) {
+	v, ok := l.lookup(p, name)
+	if !ok {
+		return
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%v must be a whole number, not %q", name, v))
+		return
+	}
+	*p = i
+}
+
+func (l *loader) bool(p *bool, name string) {
+	v, ok := l.lookup(p, name)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%v must be true or false, not %q", name, v))
+		return
+	}
+	*p = b
+}
+
+func (l *loader) duration(p *time.Duration, name string) {
+	v, ok := l.lookup(p, name)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%v must be a duration like 30s or 5m, not %q", name, v))
+		return
+	}
+	*p = d
+}
+
+// milliseconds as a whole number, like the OpenTelemetry SDKs read timeouts.
+func (l *loader) milliseconds(p *time.Duration, name string) {
+	v, ok := l.lookup(p, name)
+	if !ok {
+		return
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil {
+		l.problems = append(l.problems, fmt.Sprintf("%v must be a whole number of milliseconds, not %q", name, v))
+		return
+	}
+	*p = time.Duration(ms) * time.Millisecond
+}
+
+// list of comma-separated values, without surrounding space and empty values.
+func (l *loader) list(p *[]string, name string) {
+	l.separated(p, name, ",")
+}
+
+// separated values like list, but separated by sep.
+func (l *loader) separated(p *[]string, name, sep string) {
+	s, ok := l.lookup(p, name)
+	if !ok {
+		return
+	}
+	var values []string
+	for _, v := range strings.Split(s, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	*p = values
+}
+
+// validator collects problems with the configuration.
+type validator struct {
+	problems []string
+}
+
+// err with the problems, or nil if there are none.
+func (v *validator) err() error {
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *validator) add(problem string) {
+	v.problems = append(v.problems, problem)
+}
+
+func (v *validator) required(name, value string) {
+	if value == "" {
+		v.add(name + " must be set")
+	}
+}
+
+// minLength of the value in bytes, which is never quoted, since it's for secrets.
+func (v *validator) minLength(name, value string, n int) {
+	if len(value) < n {
+		v.add(fmt.Sprintf("%v must be at least %v characters", name, n))
+	}
+}
+
+func (v *validator) port(name string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(fmt.Sprintf("%v must be between 1 and 65535, not %v", name, port))
+	}
+}
+
+func (v *validator) positive(name string, i int) {
+	if i < 1 {
+		v.add(fmt.Sprintf("%v must be at least 1, not %v", name, i))
+	}
+}
+
+func (v *validator) absoluteURL(name, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add(fmt.Sprintf("%v must be an absolute http or https URL, not %q", name, value))
+	}
+}
+
+// origins are each "*" or a scheme and host with an optional port, like "https://example.com".
+func (v *validator) origins(name string, origins []string) {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+			v.add(fmt.Sprintf("%v must be origins like https://example.com, not %q", name, origin))
+		}
+	}
+}
+
+// cidrs are each a network in CIDR notation, like 10.0.0.0/8.
+func (v *validator) cidrs(name string, cidrs []string) {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			v.add(fmt.Sprintf("%v must be networks like 10.0.0.0/8, not %q", name, cidr))
+		}
+	}
+}
+
+// pairs are each like key=value, with a percent-encoded value.
+func (v *validator) pairs(name string, pairs []string) {
+	for _, pair := range pairs {
+		k, val, ok := strings.Cut(pair, "=")
+		if _, err := url.PathUnescape(strings.TrimSpace(val)); !ok || strings.TrimSpace(k) == "" || err != nil {
+			v.add(fmt.Sprintf("%v must be comma-separated key=value pairs", name))
+			return
+		}
+	}
+}
+
+func (v *validator) oneOf(name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var quoted []string
+	for _, a := range allowed {
+		if a != "" {
+			quoted = append(quoted, a)
+		}
+	}
+	v.add(fmt.Sprintf("%v must be one of %v, not %q", name, strings.Join(quoted, ", "), value))
+}
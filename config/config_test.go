@@ -0,0 +1,499 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/config"
+)
+
+// validConfig is the default configuration with the required secrets.
+func validConfig() config.Config {
+	return validSecrets(config.Load())
+}
+
+// validSecrets set in c, and the database settings without defaults.
+func validSecrets(c config.Config) config.Config {
+	c.Database.User = "canvas"
+	c.Database.Password = "123"
+	c.Database.Name = "canvas"
+	c.Server.UnsubscribeSecret = "unsubscribe"
+	c.Server.SessionSecret = "session"
+	c.Signup.FormSecret = "form"
+	return c
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Run("is valid with the defaults and the required secrets", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(validConfig().Validate())
+	})
+
+	tests := []struct {
+		name     string
+		change   func(c *config.Config)
+		expected string
+	}{
+		{"requires the unsubscribe secret", func(c *config.Config) { c.Server.UnsubscribeSecret = "" }, "UNSUBSCRIBE_SECRET must be set"},
+		{"requires the signup form secret", func(c *config.Config) { c.Signup.FormSecret = "" }, "SIGNUP_FORM_SECRET must be set"},
+		{"requires the session secret", func(c *config.Config) { c.Server.SessionSecret = "" }, "SESSION_SECRET must be set"},
+		{"requires a long tracking secret", func(c *config.Config) { c.Server.TrackingSecret = "tracking" }, "TRACKING_SECRET must be at least 32 characters"},
+		{"checks the port range", func(c *config.Config) { c.Server.Port = 65536 }, "PORT must be between 1 and 65535, not 65536"},
+		{"checks the database port range", func(c *config.Config) { c.Database.Port = 0 }, "DB_PORT must be between 1 and 65535, not 0"},
+		{"requires an absolute base URL", func(c *config.Config) { c.Server.BaseURL = "example.com" }, `BASE_URL must be an absolute http or https URL, not "example.com"`},
+		{"requires a base URL without a query", func(c *config.Config) { c.Server.BaseURL = "https://example.com?a=b" }, "BASE_URL must not have a query or fragment"},
+		{"requires an absolute SQS endpoint URL", func(c *config.Config) { c.Queue.EndpointURL = "localhost:9324" }, "SQS_ENDPOINT_URL must be an absolute http or https URL"},
+		{"requires an absolute SES endpoint URL", func(c *config.Config) { c.Email.SESEndpointURL = "localhost:4566" }, "SES_ENDPOINT_URL must be an absolute http or https URL"},
+		{"requires an absolute secrets endpoint URL", func(c *config.Config) { c.Secrets.EndpointURL = "localhost:4566" }, "SECRETS_ENDPOINT_URL must be an absolute http or https URL"},
+		{"requires an absolute site image URL or path", func(c *config.Config) { c.Server.SiteImageURL = "og.png" }, "SITE_IMAGE_URL must be an absolute http or https URL"},
+		{"requires CORS origins without paths", func(c *config.Config) { c.Server.CORSAllowedOrigins = []string{"https://example.com/"} }, `CORS_ALLOWED_ORIGINS must be origins like https://example.com, not "https://example.com/"`},
+		{"requires trusted proxies in CIDR notation", func(c *config.Config) { c.Server.TrustedProxies = []string{"10.0.0.1"} }, `TRUSTED_PROXIES must be networks like 10.0.0.0/8, not "10.0.0.1"`},
+		{"requires partner origins with a scheme", func(c *config.Config) { c.Server.EmbedPartnerOrigins = []string{"partner.example.com"} }, "EMBED_PARTNER_ORIGINS must be origins"},
+		{"checks the Twitter card type", func(c *config.Config) { c.Server.SiteTwitterCard = "large" }, `SITE_TWITTER_CARD must be one of summary, summary_large_image, not "large"`},
+		{"checks the captcha provider", func(c *config.Config) { c.Signup.CaptchaProvider = "recaptcha" }, `CAPTCHA_PROVIDER must be hcaptcha or turnstile, not "recaptcha"`},
+		{"requires the captcha secret with a provider", func(c *config.Config) { c.Signup.CaptchaProvider = "hcaptcha"; c.Signup.CaptchaSiteKey = "key" }, "CAPTCHA_SECRET must be set"},
+		{"requires the captcha site key with a provider", func(c *config.Config) { c.Signup.CaptchaProvider = "turnstile"; c.Signup.CaptchaSecret = "secret" }, "CAPTCHA_SITE_KEY must be set"},
+		{"requires a captcha provider to fail open", func(c *config.Config) { c.Signup.CaptchaFailOpen = true }, "CAPTCHA_FAIL_OPEN requires CAPTCHA_PROVIDER"},
+		{"requires the email policy for a disposable domains URL", func(c *config.Config) { c.Signup.DisposableDomainsURL = "https://example.com/domains.txt" }, "SIGNUP_DISPOSABLE_DOMAINS_URL requires SIGNUP_EMAIL_POLICY"},
+		{"requires an absolute disposable domains URL", func(c *config.Config) { c.Signup.EmailPolicy = true; c.Signup.DisposableDomainsURL = "domains.txt" }, `SIGNUP_DISPOSABLE_DOMAINS_URL must be an absolute http or https URL, not "domains.txt"`},
+		{"requires database connections", func(c *config.Config) { c.Database.MaxOpenConnections = 0; c.Database.MaxIdleConnections = 0 }, "DB_MAX_OPEN_CONNECTIONS must be at least 1, not 0"},
+		{"requires no more idle than open database connections", func(c *config.Config) { c.Database.MaxIdleConnections = 11 }, "DB_MAX_IDLE_CONNECTIONS must not be more than DB_MAX_OPEN_CONNECTIONS"},
+		{"requires a queue name", func(c *config.Config) { c.Queue.Name = "" }, "QUEUE_NAME must be set"},
+		{"requires a dead letter queue name", func(c *config.Config) { c.Queue.DeadLetterName = "" }, "DEAD_LETTER_QUEUE_NAME must be set"},
+		{"requires different queue names", func(c *config.Config) { c.Queue.DeadLetterName = "jobs" }, "QUEUE_NAME and DEAD_LETTER_QUEUE_NAME must be different"},
+		{"requires an events queue name different from the others", func(c *config.Config) { c.Queue.EventsName = "jobs-dead-letter" }, "EVENTS_QUEUE_NAME must be different from QUEUE_NAME and DEAD_LETTER_QUEUE_NAME"},
+		{"requires non-negative queue retries", func(c *config.Config) { c.Queue.MaxRetries = -1 }, "QUEUE_MAX_RETRIES must not be negative"},
+		{"requires a job limit", func(c *config.Config) { c.Queue.JobLimit = 0 }, "JOB_LIMIT must be at least 1, not 0"},
+		{"requires a queue ready timeout", func(c *config.Config) { c.Queue.ReadyTimeout = 0 }, "QUEUE_READY_TIMEOUT must be positive"},
+		{"requires both parts of static AWS credentials", func(c *config.Config) { c.AWS.AccessKeyID = "id" }, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together"},
+		{"requires static AWS credentials for a session token", func(c *config.Config) { c.AWS.SessionToken = "token" }, "AWS_SESSION_TOKEN requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"},
+		{"requires AWS credentials that aren't references", func(c *config.Config) { c.AWS.AccessKeyID = "id"; c.AWS.SecretAccessKey = "aws-sm://prod/aws" }, "AWS_SECRET_ACCESS_KEY must not be a reference to a secret, because it's needed to resolve them"},
+		{"requires an absolute STS endpoint URL", func(c *config.Config) { c.AWS.STSEndpointURL = "localhost:4566" }, "AWS_STS_ENDPOINT_URL must be an absolute http or https URL, not \"localhost:4566\""},
+		{"requires an ACME cache for ACME hosts", func(c *config.Config) { c.Server.ACMEHosts = []string{"example.com"} }, "ACME_CACHE must be set"},
+		{"requires an ACME HTTP port other than the port", func(c *config.Config) {
+			c.Server.ACMEHosts = []string{"example.com"}
+			c.Server.ACMECache = "db"
+			c.Server.ACMEHTTPPort = 8080
+		}, "ACME_HTTP_PORT must not be the same as PORT"},
+		{"requires scheduled jobs like name=expression=job", func(c *config.Config) { c.Schedule.Jobs = []string{"digest=0 8 * * 1"} }, `SCHEDULES must be like name=expression=job, not "digest=0 8 * * 1"`},
+		{"requires valid cron expressions of scheduled jobs", func(c *config.Config) { c.Schedule.Jobs = []string{"digest=0 8 * *=digest"} }, `SCHEDULES has an invalid cron expression "0 8 * *" for digest`},
+		{"requires unique names of scheduled jobs", func(c *config.Config) { c.Schedule.Jobs = []string{"digest=@daily=a", "digest=@hourly=b"} }, "SCHEDULES has digest more than once"},
+		{"requires a schedule interval", func(c *config.Config) { c.Schedule.Interval = 0 }, "SCHEDULE_INTERVAL must be positive"},
+		{"requires a valid newsletter time zone", func(c *config.Config) { c.Schedule.NewsletterTimezone = "Europe/Nowhere" }, `SCHEDULE_NEWSLETTER_TIMEZONE must be a time zone like Europe/Berlin, not "Europe/Nowhere"`},
+		{"requires a newsletter grace", func(c *config.Config) { c.Schedule.NewsletterGrace = 0 }, "SCHEDULE_NEWSLETTER_GRACE must be positive"},
+		{"requires a webhook timeout", func(c *config.Config) { c.Webhook.Timeout = 0 }, "WEBHOOK_TIMEOUT must be positive"},
+		{"requires webhook attempts", func(c *config.Config) { c.Webhook.Attempts = 0 }, "WEBHOOK_ATTEMPTS must be at least 1, not 0"},
+		{"requires webhook failures before disabling", func(c *config.Config) { c.Webhook.DisableAfter = 0 }, "WEBHOOK_DISABLE_AFTER must be at least 1, not 0"},
+		{"doesn't allow webhooks to private networks in production", func(c *config.Config) { c.Webhook.AllowPrivateNetworks = true; c.Log.Env = "production" }, "WEBHOOK_ALLOW_PRIVATE_NETWORKS can't be used with LOG_ENV=production"},
+		{"checks the worker port range", func(c *config.Config) { c.Worker.Port = 0 }, "WORKER_PORT must be between 1 and 65535, not 0"},
+		{"requires a non-negative worker shutdown timeout", func(c *config.Config) { c.Worker.ShutdownTimeout = -time.Second }, "WORKER_SHUTDOWN_TIMEOUT must not be negative"},
+		{"requires a non-negative worker summary interval", func(c *config.Config) { c.Worker.SummaryInterval = -time.Second }, "WORKER_SUMMARY_INTERVAL must not be negative"},
+		{"requires a shutdown timeout", func(c *config.Config) { c.Server.ShutdownTimeout = 0 }, "SHUTDOWN_TIMEOUT must be positive"},
+		{"requires a non-negative load shedding delay", func(c *config.Config) { c.Server.LoadShedAfter = -time.Second }, "LOAD_SHED_AFTER must not be negative"},
+		{"requires a non-negative degraded cache TTL", func(c *config.Config) { c.Server.DegradedCacheTTL = -time.Second }, "DEGRADED_CACHE_TTL must not be negative"},
+		{"requires a non-negative access log sample rate", func(c *config.Config) { c.Server.AccessLogQuietSampleEvery = -1 }, "ACCESS_LOG_QUIET_SAMPLE_EVERY must not be negative"},
+		{"requires a startup timeout", func(c *config.Config) { c.Server.StartupTimeout = 0 }, "STARTUP_TIMEOUT must be positive"},
+		{"requires a worker shutdown timeout within the shutdown timeout", func(c *config.Config) { c.Worker.ShutdownTimeout = time.Minute }, "WORKER_SHUTDOWN_TIMEOUT must be less than SHUTDOWN_TIMEOUT"},
+		{"requires an email from address", func(c *config.Config) { c.Email.From = "canvas" }, `EMAIL_FROM must be an email address, not "canvas"`},
+		{"requires a physical address for emails", func(c *config.Config) { c.Email.PhysicalAddress = "" }, "EMAIL_PHYSICAL_ADDRESS must be set"},
+		{"requires an unsubscribe mailto address without a name", func(c *config.Config) { c.Email.UnsubscribeMailto = "Unsubscribe <unsubscribe@example.com>" }, `EMAIL_UNSUBSCRIBE_MAILTO must be an email address without a name, not "Unsubscribe <unsubscribe@example.com>"`},
+		{"requires an email rate limit", func(c *config.Config) { c.Email.RateLimit = 0 }, "EMAIL_RATE_LIMIT must be at least 1, not 0"},
+		{"requires a send log retention", func(c *config.Config) { c.Email.SendLogRetention = 0 }, "EMAIL_SEND_LOG_RETENTION must be positive"},
+		{"requires email send retry attempts", func(c *config.Config) { c.Email.SendRetryAttempts = 0 }, "EMAIL_SEND_RETRY_ATTEMPTS must be at least 1, not 0"},
+		{"requires an email send retry delay", func(c *config.Config) { c.Email.SendRetryDelay = 0 }, "EMAIL_SEND_RETRY_DELAY must be positive"},
+		{"requires an email send retry budget within the visibility timeout", func(c *config.Config) { c.Email.SendRetryBudget = 30 * time.Second }, "EMAIL_SEND_RETRY_BUDGET must be less than QUEUE_VISIBILITY_TIMEOUT"},
+		{"checks the email identity verification", func(c *config.Config) { c.Email.VerifyIdentity = "yes" }, `EMAIL_VERIFY_IDENTITY must be one of off, warn, strict, not "yes"`},
+		{"checks the email backend", func(c *config.Config) { c.Email.Backend = "sendmail" }, `EMAIL_BACKEND must be one of log, ses, smtp, not "sendmail"`},
+		{"checks the SES quota rate percentage", func(c *config.Config) {
+			c.Email.Backend = "ses"
+			c.Email.SESQuotaRatePercent = 120
+		}, "SES_QUOTA_RATE_PERCENT must be from 1 to 100, not 120"},
+		{"checks the SES quota reserve percentage", func(c *config.Config) {
+			c.Email.Backend = "ses"
+			c.Email.SESQuotaReservePercent = 0
+		}, "SES_QUOTA_RESERVE_PERCENT must be from 1 to 99, not 0"},
+		{"requires an SMTP host for the SMTP backend", func(c *config.Config) { c.Email.Backend = "smtp" }, "SMTP_HOST must be set"},
+		{"checks the SMTP security", func(c *config.Config) {
+			c.Email.Backend = "smtp"
+			c.Email.SMTPHost = "smtp.example.com"
+			c.Email.SMTPSecurity = "ssl"
+		}, `SMTP_SECURITY must be one of starttls, tls, none, not "ssl"`},
+		{"requires a Sentry DSN with a key and project", func(c *config.Config) { c.Sentry.DSN = "https://o1.ingest.sentry.io/2" }, "SENTRY_DSN must be like https://key@o1.ingest.sentry.io/2"},
+		{"requires a Sentry burst", func(c *config.Config) { c.Sentry.Burst = 0 }, "SENTRY_BURST must be at least 1, not 0"},
+		{"requires a non-negative Sentry flush timeout", func(c *config.Config) { c.Sentry.FlushTimeout = -time.Second }, "SENTRY_FLUSH_TIMEOUT must not be negative"},
+		{"requires an absolute OTLP endpoint", func(c *config.Config) { c.Tracing.Endpoint = "localhost:4318" }, "OTEL_EXPORTER_OTLP_ENDPOINT must be an absolute http or https URL"},
+		{"only supports the OTLP JSON protocol", func(c *config.Config) { c.Tracing.Protocol = "grpc" }, `OTEL_EXPORTER_OTLP_PROTOCOL must be one of http/json, not "grpc"`},
+		{"requires OTLP headers as pairs, without quoting them", func(c *config.Config) { c.Tracing.Headers = []string{"secret"} }, "OTEL_EXPORTER_OTLP_HEADERS must be comma-separated key=value pairs"},
+		{"checks the metrics backend", func(c *config.Config) { c.Metrics.Backend = "statsd" }, `METRICS_BACKEND must be one of prometheus, cloudwatch, not "statsd"`},
+		{"doesn't allow the namespaces of AWS services for metrics", func(c *config.Config) {
+			c.Metrics.Backend = "cloudwatch"
+			c.Metrics.Namespace = "AWS/RDS"
+		}, "METRICS_NAMESPACE must not start with AWS/"},
+		{"requires a metrics interval of at least a second", func(c *config.Config) {
+			c.Metrics.Backend = "cloudwatch"
+			c.Metrics.Interval = 500 * time.Millisecond
+		}, "METRICS_INTERVAL must be at least a second"},
+		{"checks the log environment", func(c *config.Config) { c.Log.Env = "staging" }, `LOG_ENV must be one of production, development, nop, not "staging"`},
+		{"checks the log level", func(c *config.Config) { c.Log.Level = "verbose" }, `LOG_LEVEL must be debug, info, warn, error, dpanic, panic, or fatal, not "verbose"`},
+		{"checks the log sampling", func(c *config.Config) { c.Log.SampleThereafter = 0 }, "LOG_SAMPLE_THEREAFTER must be at least 1, not 0"},
+		{"doesn't allow development views in production", func(c *config.Config) { c.Server.ViewsDev = true; c.Log.Env = "Production" }, "VIEWS_DEV can't be used with LOG_ENV=production"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c := validConfig()
+			test.change(&c)
+			err := c.Validate()
+			var verr *config.ValidationError
+			is.True(errors.As(err, &verr))
+			is.Equal(1, len(verr.Problems))
+			is.True(strings.HasPrefix(verr.Problems[0], test.expected))
+		})
+	}
+
+	t.Run("accepts valid optional values", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Server.SiteImageURL = "/static/og.png"
+		c.Server.SiteTwitterCard = "summary_large_image"
+		c.Server.CORSAllowedOrigins = []string{"https://example.com", "http://localhost:3000"}
+		c.Server.EmbedPartnerOrigins = []string{"*"}
+		c.Signup.CaptchaProvider = "hcaptcha"
+		c.Signup.CaptchaSecret = "secret"
+		c.Signup.CaptchaSiteKey = "key"
+		c.Signup.CaptchaFailOpen = true
+		c.Queue.EndpointURL = "http://localhost:9324"
+		c.Sentry.DSN = "http://key@localhost:9000/sentry/2"
+		c.Email.From = "Canvas <canvas@example.com>"
+		c.Email.UnsubscribeMailto = "unsubscribe@example.com"
+		c.Email.VerifyIdentity = "strict"
+		c.Log.Env = "NOP"
+		c.Log.Level = "Debug"
+		is.NoErr(c.Validate())
+	})
+
+	t.Run("reports every missing required variable, and nothing optional", func(t *testing.T) {
+		is := is.New(t)
+
+		var verr *config.ValidationError
+		is.True(errors.As(config.Load().Validate(), &verr))
+		is.Equal([]string{
+			"UNSUBSCRIBE_SECRET must be set",
+			"SIGNUP_FORM_SECRET must be set",
+			"SESSION_SECRET must be set",
+			"DB_USER must be set",
+			"DB_PASSWORD must be set",
+			"DB_NAME must be set",
+		}, verr.Problems)
+	})
+
+	t.Run("names secrets without their values", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("DB_PASSWORD", "hunter2")
+		t.Setenv("DB_PORT", "five")
+		t.Setenv("SESSION_SECRET", "hunter3")
+		t.Setenv("SENTRY_DSN", "https://hunter4@o1.ingest.sentry.io/")
+		err := config.Load().Validate()
+		is.True(err != nil)
+		is.True(!strings.Contains(err.Error(), "hunter"))
+	})
+
+	t.Run("returns all problems at once", func(t *testing.T) {
+		is := is.New(t)
+
+		err := config.Config{}.Validate()
+		var verr *config.ValidationError
+		is.True(errors.As(err, &verr))
+		is.True(len(verr.Problems) > 10)
+		is.True(strings.HasPrefix(err.Error(), "invalid configuration: UNSUBSCRIBE_SECRET must be set; "))
+	})
+}
+
+func TestConfig_ValidateWorker(t *testing.T) {
+	t.Run("doesn't require the secrets of the web app", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Server.SessionSecret = ""
+		c.Signup.FormSecret = ""
+		c.Server.Port = 0
+		is.NoErr(c.ValidateWorker())
+	})
+
+	t.Run("reports every missing required variable of the worker, and nothing optional", func(t *testing.T) {
+		is := is.New(t)
+
+		c := config.Load()
+		c.Queue.Name = ""
+		c.Queue.DeadLetterName = ""
+		var verr *config.ValidationError
+		is.True(errors.As(c.ValidateWorker(), &verr))
+		is.Equal([]string{
+			"UNSUBSCRIBE_SECRET must be set",
+			"QUEUE_NAME must be set",
+			"DEAD_LETTER_QUEUE_NAME must be set",
+			"DB_USER must be set",
+			"DB_PASSWORD must be set",
+			"DB_NAME must be set",
+		}, verr.Problems)
+	})
+}
+
+func TestConfig_ValidateDatabase(t *testing.T) {
+	t.Run("doesn't require the secrets of the server", func(t *testing.T) {
+		is := is.New(t)
+
+		c := config.Load()
+		c.Database.User = "canvas"
+		c.Database.Password = "123"
+		c.Database.Name = "canvas"
+		is.NoErr(c.ValidateDatabase())
+	})
+
+	t.Run("checks the database and log configuration", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Database.Port = 0
+		c.Log.Env = "staging"
+		c.Server.Port = 0
+		var verr *config.ValidationError
+		is.True(errors.As(c.ValidateDatabase(), &verr))
+		is.Equal([]string{
+			"DB_PORT must be between 1 and 65535, not 0",
+			`LOG_ENV must be one of production, development, nop, not "staging"`,
+		}, verr.Problems)
+	})
+
+	t.Run("reports values that can't be read", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("DB_PORT", "five")
+
+		is.True(config.Load().ValidateDatabase() != nil)
+	})
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("reads the environment, with lists split on commas", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("PORT", "9090")
+		t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com,")
+		t.Setenv("NEWSLETTER_TWO_STEP_CONFIRM", "true")
+		t.Setenv("QUEUE_WAIT_TIME", "5s")
+		t.Setenv("WORKER_ONLY", "true")
+		t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.7/32")
+
+		c := config.Load()
+		is.Equal(9090, c.Server.Port)
+		is.Equal([]string{"https://a.example.com", "https://b.example.com"}, c.Server.CORSAllowedOrigins)
+		is.True(c.Server.TwoStepConfirm)
+		is.Equal(2, len(c.Server.TrustedProxyNetworks()))
+		is.Equal("192.0.2.7/32", c.Server.TrustedProxyNetworks()[1].String())
+		is.Equal("5s", c.Queue.WaitTime.String())
+		is.Equal("jobs", c.Queue.Name)
+		is.True(c.Server.RunWorker)
+		is.True(c.Worker.Only)
+		is.Equal(8090, c.Worker.Port)
+	})
+
+	t.Run("reads the OpenTelemetry variables like the SDKs do", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318/")
+		t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Basic%20abc,x-tenant=canvas")
+		t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "2500")
+		t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=staging")
+
+		c := config.Load()
+		is.Equal("http://collector:4318/v1/traces", c.Tracing.TracesURL())
+		is.Equal(map[string]string{"Authorization": "Basic abc", "x-tenant": "canvas"}, c.Tracing.HeaderValues())
+		is.Equal(2500*time.Millisecond, c.Tracing.Timeout)
+		is.Equal("canvas", c.Tracing.ServiceName)
+		is.Equal(map[string]string{"deployment.environment": "staging"}, c.Tracing.ResourceAttributeValues())
+
+		t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "https://traces.example.com/otlp")
+		is.Equal("https://traces.example.com/otlp", config.Load().Tracing.TracesURL())
+	})
+
+	t.Run("reports values that can't be read instead of using the default", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("PORT", "80S0")
+		t.Setenv("VIEWS_DEV", "yes please")
+		t.Setenv("SESSION_LIFETIME", "1 day")
+
+		c := validSecrets(config.Load())
+		var verr *config.ValidationError
+		is.True(errors.As(c.Validate(), &verr))
+		is.Equal([]string{
+			`PORT must be a whole number, not "80S0"`,
+			`SESSION_LIFETIME must be a duration like 30s or 5m, not "1 day"`,
+			`VIEWS_DEV must be true or false, not "yes please"`,
+		}, verr.Problems)
+	})
+}
+
+// writeConfigFile with the content in a temporary directory, and point CONFIG_FILE to it.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "canvas.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func TestLoad_File(t *testing.T) {
+	t.Run("layers the environment over the file over the defaults, field by field", func(t *testing.T) {
+		is := is.New(t)
+
+		path := writeConfigFile(t, `
+server:
+  port: 9090
+  base_url: https://file.example.com
+  cors_allowed_origins:
+    - https://a.example.com
+database:
+  host: db.example.com
+  max_open_connections: 20
+queue:
+  wait_time: 5s
+  job_limit: 3
+log:
+  env: production
+`)
+		t.Setenv("BASE_URL", "https://env.example.com")
+		t.Setenv("DB_MAX_OPEN_CONNECTIONS", "30")
+
+		c := config.Load()
+		is.Equal(path, c.File())
+		is.Equal(9090, c.Server.Port)
+		is.Equal("https://env.example.com", c.Server.BaseURL)
+		is.Equal("localhost", c.Server.Host)
+		is.Equal([]string{"https://a.example.com"}, c.Server.CORSAllowedOrigins)
+		is.Equal("db.example.com", c.Database.Host)
+		is.Equal(30, c.Database.MaxOpenConnections)
+		is.Equal(5432, c.Database.Port)
+		is.Equal(5*time.Second, c.Queue.WaitTime)
+		is.Equal(3, c.Queue.JobLimit)
+		is.Equal("jobs", c.Queue.Name)
+		is.Equal("production", c.Log.Env)
+		is.Equal(0, len(c.UnknownKeys()))
+	})
+
+	t.Run("reads partial and empty files", func(t *testing.T) {
+		is := is.New(t)
+
+		writeConfigFile(t, "email:\n  from: news@example.com\n")
+		c := config.Load()
+		is.Equal("news@example.com", c.Email.From)
+		is.Equal(10, c.Email.RateLimit)
+		is.Equal(8080, c.Server.Port)
+
+		writeConfigFile(t, "")
+		c = config.Load()
+		is.Equal("canvas@example.com", c.Email.From)
+	})
+
+	t.Run("has per-queue settings for the dead letter queue, defaulting to the job queue's", func(t *testing.T) {
+		is := is.New(t)
+
+		writeConfigFile(t, `
+queue:
+  visibility_timeout: 1m
+  message_retention_period: 96h
+  dead_letter:
+    message_retention_period: 336h
+`)
+		t.Setenv("QUEUE_MAX_RETRIES", "7")
+
+		c := config.Load()
+		is.Equal(time.Minute, c.Queue.VisibilityTimeout)
+		is.Equal(96*time.Hour, c.Queue.MessageRetentionPeriod)
+		is.Equal(336*time.Hour, c.Queue.DeadLetter.MessageRetentionPeriod)
+		is.Equal(time.Minute, c.Queue.DeadLetter.VisibilityTimeout)
+		is.Equal(7, c.Queue.MaxRetries)
+		is.Equal(7, c.Queue.DeadLetter.MaxRetries)
+	})
+
+	t.Run("reports unknown keys, including in sections", func(t *testing.T) {
+		is := is.New(t)
+
+		writeConfigFile(t, `
+server:
+  prot: 9090
+  port: 9091
+queue:
+  dead_letter:
+    wait: 1s
+nope: true
+`)
+		c := config.Load()
+		is.Equal([]string{"nope", "queue.dead_letter.wait", "server.prot"}, c.UnknownKeys())
+		is.Equal(9091, c.Server.Port)
+	})
+
+	t.Run("reports values of the wrong type and invalid YAML", func(t *testing.T) {
+		is := is.New(t)
+
+		writeConfigFile(t, "server:\n  port: eighty\ndatabase:\n  health_interval: soon\n")
+		c := validSecrets(config.Load())
+		var verr *config.ValidationError
+		is.True(errors.As(c.Validate(), &verr))
+		is.Equal(2, len(verr.Problems))
+		is.True(strings.Contains(verr.Problems[0], "eighty"))
+		is.True(strings.Contains(verr.Problems[1], "soon"))
+
+		writeConfigFile(t, "server: [")
+		is.True(config.Load().Validate() != nil)
+	})
+
+	t.Run("requires a file set in CONFIG_FILE to exist", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "nope.yaml"))
+		c := validSecrets(config.Load())
+		var verr *config.ValidationError
+		is.True(errors.As(c.Validate(), &verr))
+		is.Equal(1, len(verr.Problems))
+		is.True(strings.HasPrefix(verr.Problems[0], "CONFIG_FILE "))
+	})
+}
+
+func TestConfig_ValidateEmail(t *testing.T) {
+	t.Run("doesn't require the secrets of the server or the database", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(config.Load().ValidateEmail())
+	})
+
+	t.Run("checks the email, base URL, and log configuration", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Email.From = "nope"
+		c.Server.BaseURL = "/relative"
+		c.Log.Env = "staging"
+		c.Database.Port = 0
+		var verr *config.ValidationError
+		is.True(errors.As(c.ValidateEmail(), &verr))
+		is.Equal([]string{
+			`EMAIL_FROM must be an email address, not "nope"`,
+			`BASE_URL must be an absolute http or https URL, not "/relative"`,
+			`LOG_ENV must be one of production, development, nop, not "staging"`,
+		}, verr.Problems)
+	})
+}
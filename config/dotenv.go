@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sensitiveMarkers in the names of environment variables with secrets, like SESSION_SECRET and SENTRY_DSN.
+var sensitiveMarkers = []string{"SECRET", "PASSWORD", "TOKEN", "DSN", "PRIVATE_KEY", "ACCESS_KEY"}
+
+// Dotenv files loaded into the environment by LoadDotenv.
+type Dotenv struct {
+	// Files loaded, in order. Files that don't exist aren't in it.
+	Files []string
+	// SensitiveKeys by file, sorted, for the loaded files other than .env.local that set keys that look like secrets.
+	// Those files are usually committed, so the secrets probably were too.
+	SensitiveKeys map[string][]string
+}
+
+// LoadDotenv loads .env, then .env.$APP_ENV, then .env.local from dir into the environment, if they exist.
+// Later files override earlier ones, but variables that were already set in the environment always win.
+// APP_ENV comes from the environment, or from .env if it's not set there.
+//
+// Files are lines of KEY=value, with empty lines and lines starting with # ignored.
+// The value is everything after the first equal sign, without quotes removed.
+func LoadDotenv(dir string) (Dotenv, error) {
+	d := Dotenv{SensitiveKeys: map[string][]string{}}
+	values := map[string]string{}
+
+	base := filepath.Join(dir, ".env")
+	if err := d.load(base, values); err != nil {
+		return d, err
+	}
+
+	appEnv, ok := os.LookupEnv("APP_ENV")
+	if !ok {
+		appEnv = values["APP_ENV"]
+	}
+	if appEnv != "" && appEnv != "local" {
+		if strings.ContainsAny(appEnv, `/\`) || strings.HasPrefix(appEnv, ".") {
+			return d, fmt.Errorf("APP_ENV %q must be a name, like production", appEnv)
+		}
+		if err := d.load(base+"."+appEnv, values); err != nil {
+			return d, err
+		}
+	}
+	if err := d.load(base+".local", values); err != nil {
+		return d, err
+	}
+
+	for k, v := range values {
+		if _, ok := os.LookupEnv(k); ok {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return d, fmt.Errorf("error setting %v: %w", k, err)
+		}
+	}
+	return d, nil
+}
+
+// load the file at path into values, if it exists, recording it and its sensitive keys in d.
+func (d *Dotenv) load(path string, values map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error reading %v: %w", path, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	local := filepath.Base(path) == ".env.local"
+	var sensitive []string
+	s := bufio.NewScanner(f)
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return fmt.Errorf("%v:%v must be like KEY=value", path, i)
+		}
+		values[k] = v
+		if !local && isSensitive(k, v) {
+			sensitive = append(sensitive, k)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("error reading %v: %w", path, err)
+	}
+
+	d.Files = append(d.Files, path)
+	if len(sensitive) > 0 {
+		sort.Strings(sensitive)
+		d.SensitiveKeys[path] = sensitive
+	}
+	return nil
+}
+
+// isSensitive if the key looks like it's for a secret, and the value is one, not empty or a reference to a secret store.
+func isSensitive(key, value string) bool {
+	if value == "" || strings.HasPrefix(value, secretsManagerPrefix) || strings.HasPrefix(value, parameterStorePrefix) {
+		return false
+	}
+	key = strings.ToUpper(key)
+	for _, m := range sensitiveMarkers {
+		if strings.Contains(key, m) {
+			return true
+		}
+	}
+	return false
+}
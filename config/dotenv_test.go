@@ -0,0 +1,155 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/config"
+)
+
+// writeDotenv files in a new directory, by name, and returns the directory.
+func writeDotenv(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// unsetenv the keys for the test, and restore them after, since LoadDotenv sets them.
+func unsetenv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		t.Setenv(k, "")
+		if err := os.Unsetenv(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadDotenv(t *testing.T) {
+	t.Run("later files override earlier ones, and the environment overrides all", func(t *testing.T) {
+		files := map[string]string{
+			".env":         "APP_ENV=staging\nBASE=base\nSTAGING=base\nLOCAL=base\nENV=base\n",
+			".env.staging": "STAGING=staging\nLOCAL=staging\nENV=staging\n",
+			".env.local":   "LOCAL=local\nENV=local\n",
+		}
+		tests := []struct {
+			key      string
+			env      string
+			expected string
+		}{
+			{key: "BASE", expected: "base"},
+			{key: "STAGING", expected: "staging"},
+			{key: "LOCAL", expected: "local"},
+			{key: "ENV", env: "env", expected: "env"},
+		}
+
+		for _, test := range tests {
+			t.Run(test.key, func(t *testing.T) {
+				is := is.New(t)
+
+				unsetenv(t, "APP_ENV", "BASE", "STAGING", "LOCAL", "ENV")
+				if test.env != "" {
+					t.Setenv(test.key, test.env)
+				}
+				dir := writeDotenv(t, files)
+
+				d, err := config.LoadDotenv(dir)
+				is.NoErr(err)
+				is.Equal(test.expected, os.Getenv(test.key))
+				is.Equal([]string{
+					filepath.Join(dir, ".env"), filepath.Join(dir, ".env.staging"), filepath.Join(dir, ".env.local"),
+				}, d.Files)
+			})
+		}
+	})
+
+	t.Run("uses APP_ENV from the environment over the one in .env", func(t *testing.T) {
+		is := is.New(t)
+
+		unsetenv(t, "VALUE")
+		t.Setenv("APP_ENV", "production")
+		dir := writeDotenv(t, map[string]string{
+			".env":            "APP_ENV=staging\n",
+			".env.staging":    "VALUE=staging\n",
+			".env.production": "VALUE=production\n",
+		})
+
+		d, err := config.LoadDotenv(dir)
+		is.NoErr(err)
+		is.Equal("production", os.Getenv("VALUE"))
+		is.Equal([]string{filepath.Join(dir, ".env"), filepath.Join(dir, ".env.production")}, d.Files)
+	})
+
+	t.Run("skips files that don't exist", func(t *testing.T) {
+		is := is.New(t)
+
+		unsetenv(t, "APP_ENV", "VALUE")
+		dir := writeDotenv(t, map[string]string{".env.local": "VALUE=local\n"})
+
+		d, err := config.LoadDotenv(dir)
+		is.NoErr(err)
+		is.Equal("local", os.Getenv("VALUE"))
+		is.Equal([]string{filepath.Join(dir, ".env.local")}, d.Files)
+
+		d, err = config.LoadDotenv(t.TempDir())
+		is.NoErr(err)
+		is.Equal(0, len(d.Files))
+	})
+
+	t.Run("ignores empty lines and comments, and keeps everything after the first equal sign", func(t *testing.T) {
+		is := is.New(t)
+
+		unsetenv(t, "APP_ENV", "DATABASE_URL")
+		dir := writeDotenv(t, map[string]string{".env": "# The database\n\nDATABASE_URL=postgres://x?a=b\n"})
+
+		_, err := config.LoadDotenv(dir)
+		is.NoErr(err)
+		is.Equal("postgres://x?a=b", os.Getenv("DATABASE_URL"))
+	})
+
+	t.Run("errors on lines without an equal sign", func(t *testing.T) {
+		is := is.New(t)
+
+		unsetenv(t, "APP_ENV")
+		dir := writeDotenv(t, map[string]string{".env": "VALUE=1\nnope\n"})
+
+		_, err := config.LoadDotenv(dir)
+		is.True(err != nil)
+		is.Equal(filepath.Join(dir, ".env")+":2 must be like KEY=value", err.Error())
+	})
+
+	t.Run("errors on an APP_ENV that isn't a name", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv("APP_ENV", "../prod")
+		_, err := config.LoadDotenv(t.TempDir())
+		is.True(err != nil)
+	})
+
+	t.Run("reports secrets in files other than .env.local", func(t *testing.T) {
+		is := is.New(t)
+
+		unsetenv(t, "APP_ENV", "SESSION_SECRET", "DB_PASSWORD", "SENTRY_DSN", "DB_HOST", "TRACKING_SECRET")
+		t.Setenv("APP_ENV", "production")
+		dir := writeDotenv(t, map[string]string{
+			".env":            "SESSION_SECRET=abc\nDB_HOST=localhost\nDB_PASSWORD=aws-sm://prod/db-password\nSENTRY_DSN=\n",
+			".env.production": "TRACKING_SECRET=def\n",
+			".env.local":      "DB_PASSWORD=123\nSENTRY_DSN=https://key@sentry.example.com/1\n",
+		})
+
+		d, err := config.LoadDotenv(dir)
+		is.NoErr(err)
+		is.Equal(map[string][]string{
+			filepath.Join(dir, ".env"):            {"SESSION_SECRET"},
+			filepath.Join(dir, ".env.production"): {"TRACKING_SECRET"},
+		}, d.SensitiveKeys)
+	})
+}
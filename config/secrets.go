@@ -0,0 +1,189 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Prefixes of references to secrets, resolved by ResolveSecrets.
+const (
+	secretsManagerPrefix = "aws-sm://"
+	parameterStorePrefix = "aws-ssm://"
+)
+
+// SecretGetter gets the value of a secret by name.
+type SecretGetter interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// SecretStores for ResolveSecrets. References to a store that's nil can't be resolved.
+type SecretStores struct {
+	// SecretsManager for references like aws-sm://name, with the name or ARN of a secret in AWS Secrets Manager.
+	SecretsManager SecretGetter
+	// ParameterStore for references like aws-ssm:///path, with the name of a parameter in SSM Parameter Store.
+	ParameterStore SecretGetter
+}
+
+// secretReference in a string setting.
+type secretReference struct {
+	// key of the setting in the file, like database.password.
+	key   string
+	value *string
+}
+
+// HasSecretReferences is true if any string setting is a reference to a secret, for ResolveSecrets.
+func (c *Config) HasSecretReferences() bool {
+	return len(c.secretReferences()) > 0
+}
+
+// ResolveSecrets given as references in string settings, like a DB_PASSWORD of aws-sm://prod/db-password,
+// replacing the references with their values. Any string setting, from the file or the environment,
+// can be a reference, except those of Secrets.
+// Each reference is only resolved once, even if more settings have it, and all within Secrets.Timeout.
+// Problems are returned as a *ValidationError, naming the setting and the reference, but never a value.
+func (c *Config) ResolveSecrets(ctx context.Context, stores SecretStores) error {
+	refs := c.secretReferences()
+	if len(refs) == 0 {
+		return nil
+	}
+
+	if c.Secrets.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Secrets.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		value   string
+		problem string
+	}
+	cache := map[string]result{}
+
+	v := validator{}
+	for _, ref := range refs {
+		reference := *ref.value
+		r, ok := cache[reference]
+		if !ok {
+			value, err := resolveSecret(ctx, reference, stores)
+			if err != nil {
+				r.problem = fmt.Sprintf("%v can't be resolved: %v", reference, err)
+			}
+			r.value = value
+			cache[reference] = r
+		}
+		if r.problem != "" {
+			v.add(ref.key + ": " + r.problem)
+			continue
+		}
+		*ref.value = r.value
+		c.setSecretSource(ref.key, reference)
+	}
+	return v.err()
+}
+
+// resolveSecret from the store of the reference.
+func resolveSecret(ctx context.Context, reference string, stores SecretStores) (string, error) {
+	var store SecretGetter
+	var name string
+	switch {
+	case strings.HasPrefix(reference, secretsManagerPrefix):
+		store, name = stores.SecretsManager, strings.TrimPrefix(reference, secretsManagerPrefix)
+		if name == "" {
+			return "", errors.New("the reference must be like aws-sm://name")
+		}
+	case strings.HasPrefix(reference, parameterStorePrefix):
+		store, name = stores.ParameterStore, strings.TrimPrefix(reference, parameterStorePrefix)
+		if len(name) < 2 || name[0] != '/' {
+			return "", errors.New("the reference must be like aws-ssm:///path")
+		}
+	}
+	if store == nil {
+		return "", errors.New("there's no store for the reference")
+	}
+
+	value, err := store.GetSecret(ctx, name)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return "", errors.New("timed out, see SECRETS_TIMEOUT")
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+// isSecretReference is true for values with the prefix of a reference.
+func isSecretReference(v string) bool {
+	return strings.HasPrefix(v, secretsManagerPrefix) || strings.HasPrefix(v, parameterStorePrefix)
+}
+
+// secretReferences in the string settings, sorted by key.
+func (c *Config) secretReferences() []secretReference {
+	var refs []secretReference
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if !f.IsExported() || f.Name == "Secrets" || f.Name == "AWS" {
+			continue
+		}
+		refs = append(refs, findSecretReferences(v.Field(i), yamlKey(f))...)
+	}
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].key < refs[j].key
+	})
+	return refs
+}
+
+// findSecretReferences in the string fields, and elements of string slices, of the struct v.
+func findSecretReferences(v reflect.Value, prefix string) []secretReference {
+	var refs []secretReference
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		key := yamlKey(f)
+		if key != "" {
+			key = prefix + "." + key
+		} else {
+			key = prefix
+		}
+
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Struct:
+			refs = append(refs, findSecretReferences(fv, key)...)
+		case reflect.String:
+			if isSecretReference(fv.String()) {
+				refs = append(refs, secretReference{key: key, value: fv.Addr().Interface().(*string)})
+			}
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			for j := 0; j < fv.Len(); j++ {
+				if isSecretReference(fv.Index(j).String()) {
+					refs = append(refs, secretReference{key: fmt.Sprintf("%v[%v]", key, j),
+						value: fv.Index(j).Addr().Interface().(*string)})
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// yamlKey of the field from its yaml tag, which is empty for inline fields.
+func yamlKey(f reflect.StructField) string {
+	tag := f.Tag.Get("yaml")
+	if strings.Contains(tag, ",inline") {
+		return ""
+	}
+	return strings.Split(tag, ",")[0]
+}
+
+// validateSecretReferences that are left, because they weren't resolved.
+func (c Config) validateSecretReferences(v *validator) {
+	for _, ref := range c.secretReferences() {
+		v.add(fmt.Sprintf("%v is a reference to a secret, %v, which wasn't resolved", ref.key, *ref.value))
+	}
+}
@@ -0,0 +1,156 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/config"
+)
+
+// secretStoreMock has secrets by name, and counts the calls for each.
+type secretStoreMock struct {
+	secrets map[string]string
+	calls   map[string]int
+	wait    time.Duration
+}
+
+func newSecretStoreMock(secrets map[string]string) *secretStoreMock {
+	return &secretStoreMock{secrets: secrets, calls: map[string]int{}}
+}
+
+func (s *secretStoreMock) GetSecret(ctx context.Context, name string) (string, error) {
+	s.calls[name]++
+	if s.wait > 0 {
+		select {
+		case <-time.After(s.wait):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	v, ok := s.secrets[name]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+func TestConfig_ResolveSecrets(t *testing.T) {
+	t.Run("replaces references from both stores, and leaves plain values", func(t *testing.T) {
+		is := is.New(t)
+
+		sm := newSecretStoreMock(map[string]string{"prod/db-password": "hunter2", "prod/session": "s3ssion"})
+		ssm := newSecretStoreMock(map[string]string{"/canvas/unsubscribe": "uns3cret"})
+
+		c := validConfig()
+		c.Database.User = "canvas"
+		c.Database.Password = "aws-sm://prod/db-password"
+		c.Server.SessionSecret = "aws-sm://prod/session"
+		c.Server.UnsubscribeSecret = "aws-ssm:///canvas/unsubscribe"
+		c.Server.CORSAllowedOrigins = []string{"https://example.com"}
+		is.True(c.HasSecretReferences())
+
+		is.NoErr(c.ResolveSecrets(context.Background(), config.SecretStores{SecretsManager: sm, ParameterStore: ssm}))
+		is.Equal("canvas", c.Database.User)
+		is.Equal("hunter2", c.Database.Password)
+		is.Equal("s3ssion", c.Server.SessionSecret)
+		is.Equal("uns3cret", c.Server.UnsubscribeSecret)
+		is.Equal([]string{"https://example.com"}, c.Server.CORSAllowedOrigins)
+		is.True(!c.HasSecretReferences())
+		is.NoErr(c.Validate())
+	})
+
+	t.Run("resolves each reference once", func(t *testing.T) {
+		is := is.New(t)
+
+		sm := newSecretStoreMock(map[string]string{"prod/shared": "sh4red"})
+
+		c := validConfig()
+		c.Server.SessionSecret = "aws-sm://prod/shared"
+		c.Signup.FormSecret = "aws-sm://prod/shared"
+		is.NoErr(c.ResolveSecrets(context.Background(), config.SecretStores{SecretsManager: sm}))
+		is.Equal("sh4red", c.Server.SessionSecret)
+		is.Equal("sh4red", c.Signup.FormSecret)
+		is.Equal(1, sm.calls["prod/shared"])
+	})
+
+	t.Run("resolves references in lists", func(t *testing.T) {
+		is := is.New(t)
+
+		ssm := newSecretStoreMock(map[string]string{"/canvas/partner": "https://partner.example.com"})
+
+		c := validConfig()
+		c.Server.EmbedPartnerOrigins = []string{"https://a.example.com", "aws-ssm:///canvas/partner"}
+		is.NoErr(c.ResolveSecrets(context.Background(), config.SecretStores{ParameterStore: ssm}))
+		is.Equal([]string{"https://a.example.com", "https://partner.example.com"}, c.Server.EmbedPartnerOrigins)
+	})
+
+	t.Run("reports missing secrets and malformed references by setting, without values", func(t *testing.T) {
+		is := is.New(t)
+
+		sm := newSecretStoreMock(map[string]string{"prod/session": "s3ssion"})
+		ssm := newSecretStoreMock(nil)
+
+		c := validConfig()
+		c.Database.Password = "aws-sm://prod/nope"
+		c.Server.SessionSecret = "aws-sm://prod/session"
+		c.Server.TrackingSecret = "aws-ssm://canvas/tracking"
+		c.Signup.CaptchaSecret = "aws-sm://"
+		err := c.ResolveSecrets(context.Background(), config.SecretStores{SecretsManager: sm, ParameterStore: ssm})
+		var verr *config.ValidationError
+		is.True(errors.As(err, &verr))
+		is.Equal([]string{
+			"database.password: aws-sm://prod/nope can't be resolved: not found",
+			"server.tracking_secret: aws-ssm://canvas/tracking can't be resolved: the reference must be like aws-ssm:///path",
+			"signup.captcha_secret: aws-sm:// can't be resolved: the reference must be like aws-sm://name",
+		}, verr.Problems)
+		is.True(!strings.Contains(err.Error(), "s3ssion"))
+		is.Equal(0, len(ssm.calls))
+	})
+
+	t.Run("reports references to a store that's missing", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Database.Password = "aws-ssm:///canvas/db"
+		err := c.ResolveSecrets(context.Background(), config.SecretStores{})
+		is.Equal("invalid configuration: database.password: aws-ssm:///canvas/db can't be resolved: there's no store for the reference", err.Error())
+	})
+
+	t.Run("times out after the secrets timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		sm := newSecretStoreMock(map[string]string{"prod/db": "hunter2"})
+		sm.wait = time.Second
+
+		c := validConfig()
+		c.Secrets.Timeout = 10 * time.Millisecond
+		c.Database.Password = "aws-sm://prod/db"
+		err := c.ResolveSecrets(context.Background(), config.SecretStores{SecretsManager: sm})
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "database.password: aws-sm://prod/db can't be resolved: timed out"))
+	})
+
+	t.Run("does nothing without references", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		is.True(!c.HasSecretReferences())
+		is.NoErr(c.ResolveSecrets(context.Background(), config.SecretStores{}))
+	})
+
+	t.Run("is invalid with references that weren't resolved", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Database.Password = "aws-sm://prod/db"
+		var verr *config.ValidationError
+		is.True(errors.As(c.Validate(), &verr))
+		is.Equal([]string{"database.password is a reference to a secret, aws-sm://prod/db, which wasn't resolved"}, verr.Problems)
+		is.True(c.ValidateDatabase() != nil)
+	})
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Source of a setting.
+type Source string
+
+// Sources of settings, in order of precedence, with the stores of resolved references to secrets last.
+const (
+	SourceDefault        Source = "default"
+	SourceFile           Source = "file"
+	SourceEnv            Source = "env"
+	SourceSecretsManager Source = "secrets manager"
+	SourceParameterStore Source = "parameter store"
+)
+
+// redacted is the value of secret settings that are set.
+const redacted = "[redacted]"
+
+// Setting in the configuration.
+type Setting struct {
+	// Key of the setting in the file, like server.port.
+	Key string
+	// Value of the setting, with durations as strings like 30s. For fields tagged secret, it's "[redacted]",
+	// or empty if the secret isn't set.
+	Value  interface{}
+	Source Source
+}
+
+// Settings that are in effect, in the order of the fields of Config, with the source of each.
+func (c Config) Settings() []Setting {
+	var settings []Setting
+	eachSetting(reflect.ValueOf(c), "", func(key string, f reflect.StructField, v reflect.Value) {
+		s := Setting{Key: key, Value: v.Interface(), Source: c.Source(key)}
+		switch {
+		case f.Tag.Get("secret") == "true":
+			s.Value = ""
+			if v.Len() > 0 {
+				s.Value = redacted
+			}
+		case v.Type() == reflect.TypeOf(time.Duration(0)):
+			s.Value = v.Interface().(time.Duration).String()
+		}
+		settings = append(settings, s)
+	})
+	return settings
+}
+
+// Source of the setting with the key, like server.port.
+func (c Config) Source(key string) Source {
+	if s, ok := c.sources[key]; ok {
+		return s
+	}
+	return SourceDefault
+}
+
+// setSources of the settings from the environment, which override those from the file.
+// The dead letter queue settings that aren't in the file come from where those of the job queue do.
+func (c *Config) setSources(fromEnv map[interface{}]bool) {
+	if c.sources == nil {
+		c.sources = map[string]Source{}
+	}
+	eachSetting(reflect.ValueOf(c).Elem(), "", func(key string, f reflect.StructField, v reflect.Value) {
+		if fromEnv[v.Addr().Interface()] {
+			c.sources[key] = SourceEnv
+		}
+		if name := strings.TrimPrefix(key, "queue.dead_letter."); name != key && c.sources[key] != SourceFile {
+			if s, ok := c.sources["queue."+name]; ok {
+				c.sources[key] = s
+			}
+		}
+	})
+}
+
+// setSecretSource of the setting with the key, for the secret the reference was resolved from.
+// The key of an element of a list, like tracing.headers[0], is the key of the list.
+func (c *Config) setSecretSource(key, reference string) {
+	if c.sources == nil {
+		c.sources = map[string]Source{}
+	}
+	if i := strings.Index(key, "["); i >= 0 {
+		key = key[:i]
+	}
+	c.sources[key] = SourceSecretsManager
+	if strings.HasPrefix(reference, parameterStorePrefix) {
+		c.sources[key] = SourceParameterStore
+	}
+}
+
+// eachSetting in the struct v, calling f with its key, prefixed with the key of v, its struct field, and its value.
+// Settings are the exported fields that aren't structs, and durations.
+func eachSetting(v reflect.Value, prefix string, f func(key string, field reflect.StructField, v reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := yamlKey(field)
+		switch {
+		case key == "":
+			key = prefix
+		case prefix != "":
+			key = prefix + "." + key
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			eachSetting(fv, key, f)
+			continue
+		}
+		f(key, field, fv)
+	}
+}
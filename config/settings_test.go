@@ -0,0 +1,114 @@
+package config_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/config"
+)
+
+// settings by key.
+func settings(c config.Config) map[string]config.Setting {
+	m := map[string]config.Setting{}
+	for _, s := range c.Settings() {
+		m[s.Key] = s
+	}
+	return m
+}
+
+func TestConfig_Settings(t *testing.T) {
+	t.Run("has every setting, with secrets redacted", func(t *testing.T) {
+		is := is.New(t)
+
+		c := validConfig()
+		c.Tracing.Headers = []string{"authorization=Bearer abc"}
+		s := settings(c)
+
+		is.Equal("canvas", s["database.user"].Value)
+		is.Equal(5432, s["database.port"].Value)
+		is.Equal("1h0m0s", s["database.connection_max_lifetime"].Value)
+		is.Equal(true, s["server.run_worker"].Value)
+		is.Equal("[redacted]", s["database.password"].Value)
+		is.Equal("[redacted]", s["server.session_secret"].Value)
+		is.Equal("[redacted]", s["server.unsubscribe_secret"].Value)
+		is.Equal("[redacted]", s["signup.form_secret"].Value)
+		is.Equal("[redacted]", s["tracing.headers"].Value)
+		is.Equal("", s["sentry.dsn"].Value)
+		is.Equal(5, s["queue.dead_letter.max_retries"].Value)
+
+		for _, setting := range c.Settings() {
+			if v, ok := setting.Value.(string); ok {
+				is.True(!strings.Contains(v, "Bearer"))
+				is.True(v != "123" && v != "session" && v != "form" && v != "unsubscribe")
+			}
+		}
+	})
+
+	t.Run("has every field with a secret tagged secret", func(t *testing.T) {
+		var check func(typ reflect.Type)
+		check = func(typ reflect.Type) {
+			for i := 0; i < typ.NumField(); i++ {
+				f := typ.Field(i)
+				if !f.IsExported() {
+					continue
+				}
+				if f.Type.Kind() == reflect.Struct {
+					check(f.Type)
+					continue
+				}
+				for _, m := range []string{"Secret", "Password", "DSN", "Token", "Headers"} {
+					if strings.Contains(f.Name, m) && f.Tag.Get("secret") != "true" {
+						t.Errorf(`%v.%v looks like it has a secret, but isn't tagged secret:"true"`, typ.Name(), f.Name)
+					}
+				}
+			}
+		}
+		check(reflect.TypeOf(config.Config{}))
+	})
+
+	t.Run("has the source of each setting", func(t *testing.T) {
+		is := is.New(t)
+
+		writeConfigFile(t, "server:\n  port: 8081\n  host: example.com\nqueue:\n  max_retries: 3\n  wait_time: 10s\n"+
+			"  dead_letter:\n    wait_time: 5s\n")
+		t.Setenv("HOST", "0.0.0.0")
+		t.Setenv("QUEUE_MAX_RETRIES", "4")
+		t.Setenv("OTEL_EXPORTER_OTLP_TIMEOUT", "2000")
+		t.Setenv("DB_PASSWORD", "aws-sm://prod/db-password")
+		t.Setenv("SESSION_SECRET", "aws-ssm:///canvas/session")
+
+		c := config.Load()
+		sm := newSecretStoreMock(map[string]string{"prod/db-password": "hunter2"})
+		ssm := newSecretStoreMock(map[string]string{"/canvas/session": "s3ssion"})
+		is.NoErr(c.ResolveSecrets(context.Background(), config.SecretStores{SecretsManager: sm, ParameterStore: ssm}))
+
+		tests := map[string]config.Source{
+			"server.port":                      config.SourceFile,
+			"server.host":                      config.SourceEnv,
+			"server.base_url":                  config.SourceDefault,
+			"queue.max_retries":                config.SourceEnv,
+			"queue.wait_time":                  config.SourceFile,
+			"queue.visibility_timeout":         config.SourceDefault,
+			"queue.dead_letter.max_retries":    config.SourceEnv,
+			"queue.dead_letter.wait_time":      config.SourceFile,
+			"queue.dead_letter.send_timeout":   config.SourceDefault,
+			"tracing.timeout":                  config.SourceEnv,
+			"database.password":                config.SourceSecretsManager,
+			"server.session_secret":            config.SourceParameterStore,
+			"database.connection_max_lifetime": config.SourceDefault,
+		}
+		s := settings(c)
+		for key, expected := range tests {
+			is.Equal(expected, c.Source(key))
+			is.Equal(expected, s[key].Source)
+		}
+		is.Equal("2s", s["tracing.timeout"].Value)
+		is.Equal("[redacted]", s["database.password"].Value)
+		is.Equal(5*time.Second, c.Queue.DeadLetter.WaitTime)
+	})
+}
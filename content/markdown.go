@@ -0,0 +1,48 @@
+// Package content renders newsletter issues written in Markdown to HTML that's safe to show,
+// both on the web and in emails.
+package content
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.Strikethrough, extension.Table, extension.Linkify),
+)
+
+var policy = newPolicy()
+
+// newPolicy which only allows the elements and attributes Markdown renders to, with http, https, and mailto URLs.
+// Everything else is removed, like script and style elements, event handler and style attributes,
+// and javascript URLs. Links to other sites open in a new tab, with rel="noopener".
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote",
+		"em", "strong", "del", "code", "pre", "ul", "ol", "li",
+		"table", "thead", "tbody", "tr", "th", "td")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-zA-Z0-9_+-]+$`)).OnElements("code")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href", "title").OnElements("a")
+	p.AllowAttrs("src", "alt", "title").OnElements("img")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}
+
+// MarkdownToHTML renders the Markdown source to sanitized HTML.
+// Raw HTML in the source is removed, not escaped, like goldmark does by default.
+func MarkdownToHTML(source string) string {
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(source), &b); err != nil {
+		// Rendering to a buffer doesn't fail, but if it does, the source is still safe to show as text.
+		return "<p>" + html.EscapeString(source) + "</p>"
+	}
+	return policy.Sanitize(b.String())
+}
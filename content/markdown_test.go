@@ -0,0 +1,116 @@
+package content_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"golang.org/x/net/html"
+
+	"canvas/content"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	t.Run("renders paragraphs, emphasis, and lists", func(t *testing.T) {
+		is := is.New(t)
+
+		out := content.MarkdownToHTML("# Hi\n\nIt's **big** & *new*.\n\n1. One\n2. Two\n\n- A\n\n> Quote")
+		is.Equal("<h1>Hi</h1>\n<p>It&#39;s <strong>big</strong> &amp; <em>new</em>.</p>\n"+
+			"<ol>\n<li>One</li>\n<li>Two</li>\n</ol>\n<ul>\n<li>A</li>\n</ul>\n<blockquote>\n<p>Quote</p>\n</blockquote>\n", out)
+	})
+
+	t.Run("renders code blocks with the language class, escaping the code", func(t *testing.T) {
+		is := is.New(t)
+
+		out := content.MarkdownToHTML("```go\nfmt.Println(\"<hi>\")\n```")
+		is.Equal("<pre><code class=\"language-go\">fmt.Println(&#34;&lt;hi&gt;&#34;)\n</code></pre>\n", out)
+	})
+
+	t.Run("renders links to other sites with rel noopener", func(t *testing.T) {
+		is := is.New(t)
+
+		out := content.MarkdownToHTML("[Example](https://example.com \"Title\") <https://example.org> [Mail](mailto:me@example.com)")
+		is.True(strings.Contains(out, `<a href="https://example.com" title="Title" target="_blank" rel="noopener">Example</a>`))
+		is.True(strings.Contains(out, `<a href="https://example.org" target="_blank" rel="noopener">https://example.org</a>`))
+		is.True(strings.Contains(out, `<a href="mailto:me@example.com">Mail</a>`))
+	})
+
+	t.Run("renders images", func(t *testing.T) {
+		is := is.New(t)
+
+		out := content.MarkdownToHTML(`![A cat](https://example.com/cat.png "Cat")`)
+		is.Equal(`<p><img src="https://example.com/cat.png" alt="A cat" title="Cat"></p>`+"\n", out)
+	})
+
+	t.Run("removes relative links, since they don't work in emails", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal("<p>Archive</p>\n", content.MarkdownToHTML("[Archive](/archive)"))
+	})
+
+	xssTests := []struct {
+		name     string
+		markdown string
+	}{
+		{"script element", "<script>alert(1)</script>"},
+		{"inline script element", "Hi <script>alert(1)</script> there"},
+		{"style element", "<style>body { display: none; }</style>"},
+		{"style attribute", `<p style="background: url(javascript:alert(1))">Hi</p>`},
+		{"event attribute on raw HTML", `<img src="x" onerror="alert(1)">`},
+		{"event attribute in link title", `[x](https://example.com "\" onmouseover=\"alert(1)")`},
+		{"event attribute in image URL", `![x](https://example.com/x.png" onerror="alert(1))`},
+		{"javascript link", "[click](javascript:alert(1))"},
+		{"javascript link with mixed case", "[click](JaVaScRiPt:alert(1))"},
+		{"javascript link with entities", "[click](&#106;avascript:alert(1))"},
+		{"javascript autolink", "<javascript:alert(1)>"},
+		{"javascript image", "![x](javascript:alert(1))"},
+		{"data URL link", "[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)"},
+		{"vbscript link", "[click](vbscript:msgbox(1))"},
+		{"iframe", `<iframe src="https://example.com"></iframe>`},
+		{"svg with onload", `<svg onload="alert(1)"></svg>`},
+		{"html in link text", "[<img src=x onerror=alert(1)>](https://example.com)"},
+		{"reference link", "[click][1]\n\n[1]: javascript:alert(1)"},
+		{"form", `<form action="https://example.com"><input name="password"></form>`},
+	}
+
+	t.Run("neutralizes XSS payloads", func(t *testing.T) {
+		for _, test := range xssTests {
+			t.Run(test.name, func(t *testing.T) {
+				requireSafeHTML(t, content.MarkdownToHTML(test.markdown))
+			})
+		}
+	})
+}
+
+var (
+	safeElements = map[string]bool{"p": true, "a": true, "img": true, "em": true, "strong": true, "code": true, "pre": true}
+	safeURL      = regexp.MustCompile(`^(https?|mailto):`)
+)
+
+// requireSafeHTML parses the HTML like a browser, and fails the test if it has anything that can run scripts:
+// elements other than simple formatting, event handler or style attributes, or URLs with other schemes.
+func requireSafeHTML(t *testing.T, s string) {
+	t.Helper()
+
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if !safeElements[tok.Data] {
+				t.Fatalf("%q has element %v", s, tok.Data)
+			}
+			for _, a := range tok.Attr {
+				if strings.HasPrefix(a.Key, "on") || a.Key == "style" {
+					t.Fatalf("%q has attribute %v", s, a.Key)
+				}
+				if (a.Key == "href" || a.Key == "src") && !safeURL.MatchString(a.Val) {
+					t.Fatalf("%q has URL %v", s, a.Val)
+				}
+			}
+		}
+	}
+}
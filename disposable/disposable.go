@@ -0,0 +1,228 @@
+// Package disposable tells whether email domains are of disposable email providers, with throwaway addresses.
+package disposable
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+//go:embed domains.txt
+var embeddedDomains []byte
+
+// maxListSize is how big a downloaded list can be.
+const maxListSize = 10 * 1024 * 1024
+
+// Checker of domains against a list of disposable email providers. It starts with the list embedded in the binary,
+// which Refresh replaces with the one at the URL, and Start does periodically. The downloaded list is cached,
+// and only downloaded again if it changed, with conditional requests. If a refresh fails, the list from before is kept.
+type Checker struct {
+	client   *http.Client
+	interval time.Duration
+	log      *zap.Logger
+	url      string
+
+	mutex        sync.RWMutex
+	domains      map[string]bool
+	etag         string
+	lastModified string
+
+	domainsGauge prometheus.Gauge
+	refreshes    *prometheus.CounterVec
+}
+
+// NewCheckerOptions for NewChecker.
+type NewCheckerOptions struct {
+	// Client for downloading the list. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Interval between refreshes in Start. Defaults to 24 hours.
+	Interval time.Duration
+	Log      *zap.Logger
+	Metrics  *prometheus.Registry
+	// URL of a list to refresh the embedded one from, with one domain per line, and comments starting with #.
+	// Without it, the embedded list is used.
+	URL string
+}
+
+// NewChecker with the embedded list.
+// If no logger is provided, logs are discarded. If no metrics registry is provided, metrics are not exposed.
+func NewChecker(opts NewCheckerOptions) *Checker {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 24 * time.Hour
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+
+	domainsGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_disposable_domains",
+		Help: "Number of domains in the list of disposable email providers.",
+	})
+	refreshes := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_disposable_domains_refreshes_total",
+		Help: "Number of refreshes of the list of disposable email providers, by result.",
+	}, []string{"result"})
+	opts.Metrics.MustRegister(domainsGauge, refreshes)
+
+	domains, err := parse(bytes.NewReader(embeddedDomains))
+	if err != nil {
+		panic(err)
+	}
+	domainsGauge.Set(float64(len(domains)))
+
+	return &Checker{
+		client:       opts.Client,
+		domains:      domains,
+		domainsGauge: domainsGauge,
+		interval:     opts.Interval,
+		log:          opts.Log,
+		refreshes:    refreshes,
+		url:          opts.URL,
+	}
+}
+
+// IsDisposable domain, if it or a domain it's a subdomain of is in the list.
+func (c *Checker) IsDisposable(domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for domain != "" {
+		if c.domains[domain] {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return false
+}
+
+// Start refreshing the list, right away and then every interval, blocking until ctx is cancelled.
+// Errors refreshing it are logged, and the list from before is kept. Without a URL, it returns right away.
+func (c *Checker) Start(ctx context.Context) {
+	if c.url == "" {
+		return
+	}
+	for {
+		if err := c.Refresh(ctx); err != nil && ctx.Err() == nil {
+			c.log.Info("Error refreshing list of disposable email domains", zap.Error(err))
+		}
+
+		t := time.NewTimer(c.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Refresh the list from the URL now. It's only replaced if the download has a list that isn't empty,
+// and is kept as it is if the list at the URL hasn't changed since it was downloaded.
+func (c *Checker) Refresh(ctx context.Context) error {
+	result, err := c.refresh(ctx)
+	if err != nil {
+		result = "error"
+	}
+	c.refreshes.WithLabelValues(result).Inc()
+	return err
+}
+
+// refresh the list, returning what happened for the metrics.
+func (c *Checker) refresh(ctx context.Context) (string, error) {
+	if c.url == "" {
+		return "", errors.New("no URL to refresh the list of disposable email domains from")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	c.mutex.RLock()
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	if c.lastModified != "" {
+		req.Header.Set("If-Modified-Since", c.lastModified)
+	}
+	c.mutex.RUnlock()
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error downloading list: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return "not_modified", nil
+	default:
+		return "", fmt.Errorf("error downloading list, got status %v", res.StatusCode)
+	}
+
+	domains, err := parse(io.LimitReader(res.Body, maxListSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.domains = domains
+	c.etag = res.Header.Get("ETag")
+	c.lastModified = res.Header.Get("Last-Modified")
+	c.domainsGauge.Set(float64(len(domains)))
+	return "updated", nil
+}
+
+// parse the list, with one domain per line, and comments starting with #.
+// A list without domains is an error, so a broken download doesn't let every address through.
+func parse(r io.Reader) (map[string]bool, error) {
+	domains := map[string]bool{}
+	size := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		size += len(s.Bytes()) + 1
+		if size > maxListSize {
+			return nil, fmt.Errorf("list is bigger than %v MiB", maxListSize/1024/1024)
+		}
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.ToLower(strings.TrimSpace(line)); line != "" {
+			domains[line] = true
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("error reading list: %w", err)
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("list has no domains")
+	}
+	return domains, nil
+}
@@ -0,0 +1,206 @@
+package disposable_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"canvas/disposable"
+)
+
+// listServer serves the list with an ETag, and 304 Not Modified for conditional requests with it,
+// or the status code if it's set.
+type listServer struct {
+	mutex       sync.Mutex
+	list        string
+	etag        string
+	code        int
+	requests    int
+	conditional int
+}
+
+func (s *listServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests++
+	if s.code != 0 {
+		w.WriteHeader(s.code)
+		return
+	}
+	if r.Header.Get("If-None-Match") == s.etag {
+		s.conditional++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", s.etag)
+	_, _ = w.Write([]byte(s.list))
+}
+
+func (s *listServer) set(list, etag string, code int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.list, s.etag, s.code = list, etag, code
+}
+
+func TestChecker_IsDisposable(t *testing.T) {
+	c := disposable.NewChecker(disposable.NewCheckerOptions{})
+
+	tests := []struct {
+		domain     string
+		disposable bool
+	}{
+		{"mailinator.com", true},
+		{"Mailinator.COM", true},
+		{"mailinator.com.", true},
+		{"eu.mailinator.com", true},
+		{"yopmail.fr", true},
+		{"gmail.com", false},
+		{"example.com", false},
+		{"notmailinator.com", false},
+		{"com", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		t.Run("checks "+test.domain+" against the embedded list", func(t *testing.T) {
+			is := is.New(t)
+
+			is.Equal(test.disposable, c.IsDisposable(test.domain))
+		})
+	}
+}
+
+func TestChecker_Refresh(t *testing.T) {
+	newChecker := func(t *testing.T, list, etag string) (*disposable.Checker, *listServer, *prometheus.Registry) {
+		t.Helper()
+		s := &listServer{list: list, etag: etag}
+		server := httptest.NewServer(s)
+		t.Cleanup(server.Close)
+		registry := prometheus.NewRegistry()
+		c := disposable.NewChecker(disposable.NewCheckerOptions{Metrics: registry, URL: server.URL})
+		return c, s, registry
+	}
+
+	t.Run("replaces the list with the one at the URL", func(t *testing.T) {
+		is := is.New(t)
+
+		c, _, registry := newChecker(t, "# Comment\nthrowaway.example\n\n  Burner.Example # too\n", `"1"`)
+		is.NoErr(c.Refresh(context.Background()))
+
+		is.True(c.IsDisposable("throwaway.example"))
+		is.True(c.IsDisposable("burner.example"))
+		is.True(!c.IsDisposable("mailinator.com"))
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_disposable_domains Number of domains in the list of disposable email providers.
+# TYPE app_disposable_domains gauge
+app_disposable_domains 2
+`), "app_disposable_domains")
+		is.NoErr(err)
+	})
+
+	t.Run("doesn't download the list again if it hasn't changed", func(t *testing.T) {
+		is := is.New(t)
+
+		c, s, _ := newChecker(t, "throwaway.example\n", `"1"`)
+		is.NoErr(c.Refresh(context.Background()))
+		is.NoErr(c.Refresh(context.Background()))
+		is.Equal(2, s.requests)
+		is.Equal(1, s.conditional)
+		is.True(c.IsDisposable("throwaway.example"))
+
+		s.set("burner.example\n", `"2"`, 0)
+		is.NoErr(c.Refresh(context.Background()))
+		is.True(c.IsDisposable("burner.example"))
+		is.True(!c.IsDisposable("throwaway.example"))
+	})
+
+	failures := []struct {
+		name string
+		list string
+		code int
+	}{
+		{"an error status", "", http.StatusInternalServerError},
+		{"a list without domains", "# Nothing here\n\n", 0},
+		{"a list that's too big", strings.Repeat("a", 10*1024*1024+1), 0},
+	}
+	for _, test := range failures {
+		t.Run("keeps the old list after "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c, s, registry := newChecker(t, "throwaway.example\n", `"1"`)
+			is.NoErr(c.Refresh(context.Background()))
+
+			s.set(test.list, `"2"`, test.code)
+			is.True(c.Refresh(context.Background()) != nil)
+			is.True(c.IsDisposable("throwaway.example"))
+
+			err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_disposable_domains_refreshes_total Number of refreshes of the list of disposable email providers, by result.
+# TYPE app_disposable_domains_refreshes_total counter
+app_disposable_domains_refreshes_total{result="error"} 1
+app_disposable_domains_refreshes_total{result="updated"} 1
+`), "app_disposable_domains_refreshes_total")
+			is.NoErr(err)
+		})
+	}
+
+	t.Run("keeps the embedded list if the URL can't be reached", func(t *testing.T) {
+		is := is.New(t)
+
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		c := disposable.NewChecker(disposable.NewCheckerOptions{URL: server.URL})
+		is.True(c.Refresh(context.Background()) != nil)
+		is.True(c.IsDisposable("mailinator.com"))
+	})
+
+	t.Run("errors without a URL", func(t *testing.T) {
+		is := is.New(t)
+
+		c := disposable.NewChecker(disposable.NewCheckerOptions{})
+		is.True(c.Refresh(context.Background()) != nil)
+	})
+}
+
+func TestChecker_Start(t *testing.T) {
+	t.Run("refreshes the list until cancelled", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &listServer{list: "throwaway.example\n", etag: `"1"`}
+		server := httptest.NewServer(s)
+		defer server.Close()
+		c := disposable.NewChecker(disposable.NewCheckerOptions{Interval: time.Millisecond, URL: server.URL})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			c.Start(ctx)
+			close(done)
+		}()
+		is.True(eventually(func() bool { return c.IsDisposable("throwaway.example") }))
+		cancel()
+		<-done
+	})
+
+	t.Run("returns right away without a URL", func(t *testing.T) {
+		c := disposable.NewChecker(disposable.NewCheckerOptions{})
+		c.Start(context.Background())
+	})
+}
+
+func eventually(f func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if f() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
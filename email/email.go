@@ -0,0 +1,183 @@
+// Package email has the email messages the app sends, and senders to send them with.
+package email
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"canvas/i18n"
+	"canvas/model"
+)
+
+// Message to send by email. Text is the plain-text alternative to HTML.
+type Message struct {
+	From    string
+	To      model.Email
+	Subject string
+	HTML    string
+	Text    string
+	Headers map[string]string
+}
+
+// Sender of email messages. Send returns the message ID given by the email provider.
+type Sender interface {
+	Send(ctx context.Context, m Message) (string, error)
+}
+
+// ConfirmationEmail with a link to confirm the newsletter signup of the given address, translated by t.
+// The link points to /newsletter/confirm under baseURL. The footer has the physical address.
+func ConfirmationEmail(t *i18n.Translator, from, address string, to model.Email, baseURL, token string) (Message, error) {
+	confirmURL, err := appURL(baseURL, "/newsletter/confirm")
+	if err != nil {
+		return Message{}, err
+	}
+	confirmURL.RawQuery = url.Values{"token": {token}}.Encode()
+
+	m := Message{From: from, To: to, Subject: t.T("email.confirm.subject")}
+	m.HTML, m.Text, err = renderTemplate("confirm", t, templateData{
+		Address:    address,
+		BaseURL:    baseURL,
+		ConfirmURL: confirmURL.String(),
+		Subject:    m.Subject,
+		To:         to,
+	})
+	return m, err
+}
+
+// WelcomeEmail for a subscriber that just confirmed their signup, translated by t, with a link to the latest
+// newsletter issue in the archive under baseURL, if there is one. Like NewsletterEmail, it has unsubscribe links
+// signed with unsubscribeSecret, and the physical address in the footer.
+func WelcomeEmail(t *i18n.Translator, from, address string, to model.Email, latest *model.Newsletter, baseURL string, unsubscribeSecret []byte) (Message, error) {
+	return welcomeEmail(t, from, address, to, latest, baseURL, CreateUnsubscribeToken(unsubscribeSecret, to))
+}
+
+// welcomeEmail is the template for WelcomeEmail, with the unsubscribe token in the links.
+func welcomeEmail(t *i18n.Translator, from, address string, to model.Email, latest *model.Newsletter, baseURL, unsubscribeToken string) (Message, error) {
+	var issueURL string
+	if latest != nil {
+		u, err := appURL(baseURL, "/archive/"+latest.Slug)
+		if err != nil {
+			return Message{}, err
+		}
+		issueURL = u.String()
+	}
+
+	unsubscribeURL, headers, err := unsubscribeLinks(baseURL, unsubscribeToken)
+	if err != nil {
+		return Message{}, err
+	}
+
+	m := Message{From: from, To: to, Subject: t.T("email.welcome.subject"), Headers: headers}
+	m.HTML, m.Text, err = renderTemplate("welcome", t, templateData{
+		Address:        address,
+		BaseURL:        baseURL,
+		IssueURL:       issueURL,
+		Subject:        m.Subject,
+		To:             to,
+		UnsubscribeURL: unsubscribeURL,
+	})
+	return m, err
+}
+
+// NewsletterEmail with the newsletter issue for the given address.
+// The body is Markdown, rendered as sanitized HTML in the newsletter template, and the text part is derived from it.
+// It links to the unsubscribe page under baseURL, and has List-Unsubscribe and List-Unsubscribe-Post headers
+// for one-click unsubscribe in mail clients, as described in RFC 8058. The links are signed with unsubscribeSecret.
+// As bulk mail, it also has the Precedence: bulk header, and a List-Id header for the host of baseURL,
+// like <newsletter.example.com>, so mail clients can filter the newsletter. Confirmation emails have none of these.
+// The text around the newsletter content is translated by t, and the footer has the physical address.
+func NewsletterEmail(t *i18n.Translator, from, address string, to model.Email, n model.Newsletter, baseURL string, unsubscribeSecret []byte) (Message, error) {
+	return newsletterEmail(t, from, address, to, n, baseURL, CreateUnsubscribeToken(unsubscribeSecret, to))
+}
+
+// PreviewSubscriber is the sample subscriber that newsletter email previews are for.
+const PreviewSubscriber model.Email = "subscriber@example.com"
+
+// PreviewUnsubscribeToken is the dummy token in the unsubscribe links of previews. It's never valid.
+const PreviewUnsubscribeToken = "preview"
+
+// NewsletterPreviewEmail is the NewsletterEmail for PreviewSubscriber, with PreviewUnsubscribeToken
+// in the unsubscribe links, so the preview is exactly what's sent except for the token.
+func NewsletterPreviewEmail(t *i18n.Translator, from, address string, n model.Newsletter, baseURL string) (Message, error) {
+	return newsletterEmail(t, from, address, PreviewSubscriber, n, baseURL, PreviewUnsubscribeToken)
+}
+
+// newsletterEmail is the template for NewsletterEmail and NewsletterPreviewEmail,
+// with the unsubscribe token in the links.
+func newsletterEmail(t *i18n.Translator, from, address string, to model.Email, n model.Newsletter, baseURL, unsubscribeToken string) (Message, error) {
+	unsubscribeURL, headers, err := unsubscribeLinks(baseURL, unsubscribeToken)
+	if err != nil {
+		return Message{}, err
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return Message{}, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	headers["List-Id"] = "<newsletter." + u.Hostname() + ">"
+	headers["Precedence"] = "bulk"
+
+	m := Message{From: from, To: to, Subject: n.Title, Headers: headers}
+	m.HTML, m.Text, err = renderTemplate("newsletter", t, templateData{
+		Address:        address,
+		BaseURL:        baseURL,
+		Content:        template.HTML(n.BodyHTML()),
+		Subject:        n.Title,
+		Title:          n.Title,
+		To:             to,
+		UnsubscribeURL: unsubscribeURL,
+	})
+	return m, err
+}
+
+// unsubscribeLinks with the token, to the unsubscribe page under baseURL, and in the List-Unsubscribe and
+// List-Unsubscribe-Post headers for one-click unsubscribe.
+func unsubscribeLinks(baseURL, token string) (string, map[string]string, error) {
+	unsubscribeURL, err := appURL(baseURL, "/newsletter/unsubscribe")
+	if err != nil {
+		return "", nil, err
+	}
+	unsubscribeURL.RawQuery = url.Values{"token": {token}}.Encode()
+	oneClickURL := *unsubscribeURL
+	oneClickURL.Path += "/one-click"
+
+	return unsubscribeURL.String(), map[string]string{
+		"List-Unsubscribe":      "<" + oneClickURL.String() + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, nil
+}
+
+// appURL for the path under baseURL, which must be absolute.
+func appURL(baseURL, path string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL %q must be absolute", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	return u, nil
+}
+
+// LogSender logs messages instead of sending them, for development.
+type LogSender struct {
+	log *zap.Logger
+}
+
+// NewLogSender which logs messages at Info level.
+func NewLogSender(log *zap.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+// Send the message to the log.
+func (s *LogSender) Send(ctx context.Context, m Message) (string, error) {
+	s.log.Info("Sending email", zap.String("from", m.From), zap.Stringer("to", m.To),
+		zap.String("subject", m.Subject), zap.Any("headers", m.Headers), zap.String("text", m.Text))
+	return "", nil
+}
@@ -0,0 +1,212 @@
+package email_test
+
+import (
+	"context"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/i18n"
+	"canvas/model"
+)
+
+var english = i18n.Default().Translator(i18n.DefaultLocale)
+
+const address = "canvas, 1 Example Street, 12345 Example City"
+
+func TestConfirmationEmail(t *testing.T) {
+	t.Run("links to the confirmation page with the token in both bodies", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.ConfirmationEmail(english, "canvas@example.com", address, "me@example.com", "https://example.com", "abc&123")
+		is.NoErr(err)
+		is.Equal("canvas@example.com", m.From)
+		is.Equal("me@example.com", m.To.String())
+		is.True(strings.Contains(m.HTML, `href="https://example.com/newsletter/confirm?token=abc%26123"`))
+		is.True(strings.Contains(m.Text, "https://example.com/newsletter/confirm?token=abc%26123"))
+		is.Equal("Confirm your subscription to the canvas newsletter", m.Subject)
+	})
+
+	t.Run("is in the locale of the translator", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.ConfirmationEmail(i18n.Default().Translator("fr"), "canvas@example.com", address, "me@example.com", "https://example.com", "123")
+		is.NoErr(err)
+		is.Equal("Confirmez votre abonnement à la newsletter canvas", m.Subject)
+		is.True(strings.Contains(m.Text, "Bonjour !"))
+		is.True(strings.Contains(m.HTML, `<html lang="fr">`))
+		is.True(strings.Contains(m.HTML, `href="https://example.com/newsletter/confirm?token=123"`))
+	})
+
+	t.Run("has the recipient and the physical address in the footer, but no unsubscribe link", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.ConfirmationEmail(english, "canvas@example.com", address, "me@example.com", "https://example.com", "123")
+		is.NoErr(err)
+		is.True(strings.Contains(m.Text, "This email was sent to me@example.com.\n\n"+address+"\n"))
+		is.True(!strings.Contains(m.HTML, "unsubscribe"))
+		is.Equal(0, len(m.Headers))
+	})
+
+	t.Run("errors without a physical address", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.ConfirmationEmail(english, "canvas@example.com", "", "me@example.com", "https://example.com", "123")
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "missing Address"))
+	})
+
+	t.Run("errors on a base URL that isn't absolute", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.ConfirmationEmail(english, "canvas@example.com", address, "me@example.com", "/relative", "123")
+		is.True(err != nil)
+	})
+}
+
+func TestWelcomeEmail(t *testing.T) {
+	t.Run("links to the latest issue, and has unsubscribe links", func(t *testing.T) {
+		is := is.New(t)
+
+		secret := []byte("secret")
+		m, err := email.WelcomeEmail(english, "canvas@example.com", address, "me@example.com", &model.Newsletter{Slug: "issue-1"},
+			"https://example.com", secret)
+		is.NoErr(err)
+		is.Equal("Welcome to the canvas newsletter", m.Subject)
+		is.True(strings.Contains(m.HTML, `href="https://example.com/archive/issue-1"`))
+		is.True(strings.Contains(m.Text, "Read the latest issue (https://example.com/archive/issue-1)"))
+
+		token := email.CreateUnsubscribeToken(secret, "me@example.com")
+		is.True(strings.Contains(m.HTML, `href="https://example.com/newsletter/unsubscribe?token=`+token+`"`))
+		is.Equal("<https://example.com/newsletter/unsubscribe/one-click?token="+token+">", m.Headers["List-Unsubscribe"])
+	})
+
+	t.Run("leaves out the link without an issue", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.WelcomeEmail(english, "canvas@example.com", address, "me@example.com", nil, "https://example.com", nil)
+		is.NoErr(err)
+		is.True(!strings.Contains(m.HTML, "/archive/"))
+	})
+}
+
+func TestNewsletterEmail(t *testing.T) {
+	t.Run("renders the escaped title and the Markdown body without raw HTML", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com", model.Newsletter{
+			ID:    1,
+			Title: "Issue <1>",
+			Body:  "Hello.\n\nIt's *big* <b>news</b>.",
+		}, "https://example.com", []byte("secret"))
+		is.NoErr(err)
+		is.Equal("Issue <1>", m.Subject)
+		is.True(regexp.MustCompile(`<h1 style="[^"]*">Issue &lt;1&gt;</h1>\n<p style="[^"]*">Hello.</p>\n<p style="[^"]*">It&#39;s <em>big</em> news.</p>`).
+			MatchString(m.HTML))
+		is.True(strings.Contains(m.Text, "Issue <1>\n\nHello.\n\nIt's big news.\n"))
+	})
+
+	t.Run("neutralizes XSS payloads in the Markdown body", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com", model.Newsletter{
+			ID:    1,
+			Title: "Issue 1",
+			Body:  "<script>alert(1)</script>\n\n[click](javascript:alert(1)) ![x](https://example.com/x.png\" onerror=\"alert(1))",
+		}, "https://example.com", []byte("secret"))
+		is.NoErr(err)
+		is.True(!strings.Contains(m.HTML, "<script"))
+		is.True(!strings.Contains(m.HTML, "javascript:"))
+		is.True(!regexp.MustCompile(`<[^>]*\son\w+=`).MatchString(m.HTML))
+	})
+
+	t.Run("links to the unsubscribe page and has one-click unsubscribe headers with a signed token", func(t *testing.T) {
+		is := is.New(t)
+
+		secret := []byte("secret")
+		m, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com", model.Newsletter{ID: 1, Title: "Issue 1"},
+			"https://example.com/", secret)
+		is.NoErr(err)
+
+		token := email.CreateUnsubscribeToken(secret, "me@example.com")
+		is.True(strings.Contains(m.HTML, `href="https://example.com/newsletter/unsubscribe?token=`+token+`"`))
+		is.True(strings.Contains(m.Text, "https://example.com/newsletter/unsubscribe?token="+token))
+		is.Equal("<https://example.com/newsletter/unsubscribe/one-click?token="+token+">", m.Headers["List-Unsubscribe"])
+		is.Equal("List-Unsubscribe=One-Click", m.Headers["List-Unsubscribe-Post"])
+	})
+
+	t.Run("errors without a title", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com", model.Newsletter{ID: 1}, "https://example.com", nil)
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "missing Title"))
+	})
+
+	t.Run("errors on a base URL that isn't absolute", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com", model.Newsletter{ID: 1, Title: "Issue 1"}, "/relative", nil)
+		is.True(err != nil)
+	})
+}
+
+func TestEmailHeaders(t *testing.T) {
+	secret := []byte("secret")
+	token := email.CreateUnsubscribeToken(secret, "me@example.com")
+
+	confirmation, err := email.ConfirmationEmail(english, "canvas@example.com", address, "me@example.com", "https://example.com", "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	welcome, err := email.WelcomeEmail(english, "canvas@example.com", address, "me@example.com", nil, "https://example.com", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newsletter, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com",
+		model.Newsletter{ID: 1, Title: "Issue 1"}, "https://example.com:8080", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		m           email.Message
+		unsubscribe string
+		listID      string
+		precedence  string
+	}{
+		{"confirmation has no list or bulk headers", confirmation, "", "", ""},
+		{"welcome has unsubscribe headers, but isn't bulk", welcome,
+			"<https://example.com/newsletter/unsubscribe/one-click?token=" + token + ">", "", ""},
+		{"newsletter has unsubscribe headers and is bulk, with a list ID of the host", newsletter,
+			"<https://example.com:8080/newsletter/unsubscribe/one-click?token=" + token + ">", "<newsletter.example.com>", "bulk"},
+		{"newsletter with a mailto has both unsubscribe links", email.WithUnsubscribeMailto(newsletter, "unsubscribe@example.com", token),
+			"<mailto:unsubscribe@example.com?subject=unsubscribe%20" + token + ">, <https://example.com:8080/newsletter/unsubscribe/one-click?token=" + token + ">",
+			"<newsletter.example.com>", "bulk"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			client := &sesClientMock{}
+			_, err := email.NewSESSender(email.NewSESSenderOptions{Client: client}).Send(context.Background(), test.m)
+			is.NoErr(err)
+			raw, err := mail.ReadMessage(strings.NewReader(string(client.input.Content.Raw.Data)))
+			is.NoErr(err)
+
+			is.Equal(test.unsubscribe, raw.Header.Get("List-Unsubscribe"))
+			if test.unsubscribe != "" {
+				is.Equal("List-Unsubscribe=One-Click", raw.Header.Get("List-Unsubscribe-Post"))
+			} else {
+				is.Equal("", raw.Header.Get("List-Unsubscribe-Post"))
+			}
+			is.Equal(test.listID, raw.Header.Get("List-Id"))
+			is.Equal(test.precedence, raw.Header.Get("Precedence"))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package email
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CountSends of the sender in registry, by result: sent, suppressed by a SuppressionGate, or failed.
+// If no registry is provided, the counts are not exposed.
+func CountSends(s Sender, registry *prometheus.Registry) Sender {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+	sends := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_email_sends_total",
+		Help: "Number of emails sent, by result: sent, suppressed, or failed.",
+	}, []string{"result"})
+	registry.MustRegister(sends)
+	return &countingSender{sender: s, sends: sends}
+}
+
+// countingSender counts the results of the sends of its sender.
+type countingSender struct {
+	sender Sender
+	sends  *prometheus.CounterVec
+}
+
+// Send the message with the sender, counting the result.
+func (s *countingSender) Send(ctx context.Context, m Message) (string, error) {
+	id, err := s.sender.Send(ctx, m)
+	result := "sent"
+	switch {
+	case errors.Is(err, ErrSuppressed):
+		result = "suppressed"
+	case err != nil:
+		result = "failed"
+	}
+	s.sends.WithLabelValues(result).Inc()
+	return id, err
+}
@@ -0,0 +1,44 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"canvas/email"
+	"canvas/model"
+)
+
+func TestCountSends(t *testing.T) {
+	t.Run("counts sends by result", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		list := &suppressionListMock{suppressed: map[model.Email]bool{"suppressed@example.com": true}}
+		sent := email.CountSends(email.NewSuppressionGate(&senderMock{}, list), registry)
+
+		id, err := sent.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.NoErr(err)
+		is.Equal("abc", id)
+		_, err = sent.Send(context.Background(), email.Message{To: "suppressed@example.com"})
+		is.True(errors.Is(err, email.ErrSuppressed))
+
+		list.err = errors.New("oh no")
+		_, err = sent.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.True(err != nil)
+
+		err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_email_sends_total Number of emails sent, by result: sent, suppressed, or failed.
+# TYPE app_email_sends_total counter
+app_email_sends_total{result="failed"} 1
+app_email_sends_total{result="sent"} 1
+app_email_sends_total{result="suppressed"} 1
+`), "app_email_sends_total")
+		is.NoErr(err)
+	})
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// rawMessage of m in MIME, with the text and HTML bodies as alternatives, and the headers of m.
+func rawMessage(m Message) ([]byte, error) {
+	header := textproto.MIMEHeader{}
+	header.Set("From", m.From)
+	header.Set("To", m.To.String())
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("MIME-Version", "1.0")
+	for k, v := range m.Headers {
+		// Line breaks would end the header, and let the value add headers of its own.
+		if strings.ContainsAny(k, "\r\n:") || strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid email header %q", k)
+		}
+		header.Set(k, v)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	header.Set("Content-Type", "multipart/alternative; boundary="+w.Boundary())
+	// The last part is the preferred one, so HTML comes after text.
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(k + ": " + header.Get(k) + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(body.Bytes())
+	return b.Bytes(), nil
+}
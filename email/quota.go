@@ -0,0 +1,255 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"canvas/messaging"
+)
+
+// ErrQuotaPaused is returned by QuotaManager.CheckHeadroom while bulk sending is paused,
+// because little of the daily sending quota is left.
+var ErrQuotaPaused = errors.New("bulk sending is paused, because the daily sending quota is almost used up")
+
+// sesAccountClient has the sesv2.Client method used by QuotaManager, so it can be faked in tests.
+type sesAccountClient interface {
+	GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error)
+}
+
+// QuotaManager keeps sending within the SES sending quota of the account, which has a maximum send rate,
+// and a maximum number of emails sent in the last 24 hours.
+// Senders from Limit wait for a token bucket at a percentage of the maximum rate, and bulk sending,
+// like of newsletter issues, checks CheckHeadroom to pause while the daily quota is almost used up.
+// The rest of the daily quota is kept for the other emails, like confirmations, which aren't paused.
+// The quota is read with Refresh, which Start does periodically. Until it's read, sending isn't limited.
+type QuotaManager struct {
+	client         sesAccountClient
+	interval       time.Duration
+	limiter        *rate.Limiter
+	log            *zap.Logger
+	mutex          sync.Mutex
+	quota          Quota
+	paused         bool
+	ratePercent    int
+	reservePercent int
+
+	maxGauge       prometheus.Gauge
+	pausedGauge    prometheus.Gauge
+	rateGauge      prometheus.Gauge
+	remainingGauge prometheus.Gauge
+}
+
+// Quota of the account, as SES reports it, with the emails sent since counted in.
+type Quota struct {
+	// Max24HourSend is the most emails that can be sent in 24 hours, and SentLast24Hours how many were.
+	Max24HourSend   float64
+	SentLast24Hours float64
+	// MaxSendRate is the most emails that can be sent per second.
+	MaxSendRate float64
+}
+
+// Remaining emails that can be sent in the 24 hours, which is never negative.
+func (q Quota) Remaining() float64 {
+	if q.SentLast24Hours >= q.Max24HourSend {
+		return 0
+	}
+	return q.Max24HourSend - q.SentLast24Hours
+}
+
+// NewQuotaManagerOptions for NewQuotaManager.
+type NewQuotaManagerOptions struct {
+	// Client overrides the SES client created from Config, such as with a fake in tests.
+	Client sesAccountClient
+	Config aws.Config
+	// EndpointURL overrides the default SES endpoint of the region, such as for local development, if not empty.
+	EndpointURL string
+	// Interval between reading the quota in Start. Defaults to one minute.
+	Interval time.Duration
+	Log      *zap.Logger
+	Metrics  *prometheus.Registry
+	// RatePercent of the maximum send rate that sends are limited to, so other senders in the account
+	// have some of it. Defaults to 80.
+	RatePercent int
+	// ReservePercent of the daily quota that bulk sending leaves for the other emails. Defaults to 10.
+	ReservePercent int
+}
+
+// NewQuotaManager with the given options.
+// If no logger is provided, logs are discarded. If no metrics registry is provided, metrics are not exposed.
+func NewQuotaManager(opts NewQuotaManagerOptions) *QuotaManager {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.Client == nil {
+		opts.Client = newSESClient(opts.Config, opts.EndpointURL)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.RatePercent <= 0 {
+		opts.RatePercent = 80
+	}
+	if opts.ReservePercent <= 0 {
+		opts.ReservePercent = 10
+	}
+
+	maxGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_email_quota_max_24h",
+		Help: "Most emails the email provider allows sending in 24 hours.",
+	})
+	remainingGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_email_quota_remaining",
+		Help: "Emails that can still be sent within the 24 hour quota of the email provider.",
+	})
+	rateGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_email_quota_send_rate",
+		Help: "Emails per second that sends are limited to, from the maximum send rate of the email provider.",
+	})
+	pausedGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_email_quota_paused",
+		Help: "Whether bulk sending is paused because the 24 hour quota is almost used up.",
+	})
+	opts.Metrics.MustRegister(maxGauge, remainingGauge, rateGauge, pausedGauge)
+
+	return &QuotaManager{
+		client:         opts.Client,
+		interval:       opts.Interval,
+		limiter:        rate.NewLimiter(rate.Inf, 1),
+		log:            opts.Log,
+		ratePercent:    opts.RatePercent,
+		reservePercent: opts.ReservePercent,
+		maxGauge:       maxGauge,
+		pausedGauge:    pausedGauge,
+		rateGauge:      rateGauge,
+		remainingGauge: remainingGauge,
+	}
+}
+
+// Start reading the quota, right away and then every interval, blocking until ctx is cancelled.
+// Errors reading it are logged, and the quota read before is kept.
+func (q *QuotaManager) Start(ctx context.Context) {
+	for {
+		if err := q.Refresh(ctx); err != nil && ctx.Err() == nil {
+			q.log.Info("Error reading SES sending quota", zap.Error(err))
+		}
+
+		t := time.NewTimer(q.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Refresh the quota from SES now, updating the send rate limit, and pausing or resuming bulk sending.
+func (q *QuotaManager) Refresh(ctx context.Context) error {
+	output, err := q.client.GetAccount(ctx, &sesv2.GetAccountInput{})
+	if err != nil {
+		return fmt.Errorf("error getting SES account: %w", err)
+	}
+	if output.SendQuota == nil {
+		return errors.New("SES account has no send quota")
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.quota = Quota{
+		Max24HourSend:   output.SendQuota.Max24HourSend,
+		SentLast24Hours: output.SendQuota.SentLast24Hours,
+		MaxSendRate:     output.SendQuota.MaxSendRate,
+	}
+	// A rate of zero is for an account that can't send, which pausing takes care of for bulk sending,
+	// and SES rejecting the emails for the rest.
+	if limit := q.quota.MaxSendRate * float64(q.ratePercent) / 100; limit > 0 {
+		q.limiter.SetLimit(rate.Limit(limit))
+		q.rateGauge.Set(limit)
+	}
+	q.maxGauge.Set(q.quota.Max24HourSend)
+	q.update()
+	return nil
+}
+
+// Quota as it was last read, with the emails sent since counted in.
+func (q *QuotaManager) Quota() Quota {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.quota
+}
+
+// CheckHeadroom for bulk sending, returning ErrQuotaPaused as a messaging.RetryableError while little
+// of the daily quota is left, so the job is tried again after the quota has had time to free up.
+func (q *QuotaManager) CheckHeadroom() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.paused {
+		return messaging.Retryable(ErrQuotaPaused, quotaDelay)
+	}
+	return nil
+}
+
+// Limit the sender to the send rate, and count what it sends against the daily quota,
+// so the headroom is up to date between reading the quota.
+func (q *QuotaManager) Limit(s Sender) Sender {
+	return &quotaSender{quota: q, sender: s}
+}
+
+// sent an email, which counts against the daily quota right away.
+func (q *QuotaManager) sent() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.quota.SentLast24Hours++
+	q.update()
+}
+
+// update the remaining quota and the pause from the quota, logging when bulk sending pauses and resumes.
+// The mutex must be held.
+func (q *QuotaManager) update() {
+	remaining := q.quota.Remaining()
+	q.remainingGauge.Set(remaining)
+
+	reserve := q.quota.Max24HourSend * float64(q.reservePercent) / 100
+	paused := remaining < reserve || q.quota.Max24HourSend <= 0
+	switch {
+	case paused && !q.paused:
+		q.log.Warn("Pausing bulk sending, the daily sending quota is almost used up",
+			zap.Float64("remaining", remaining), zap.Float64("max24HourSend", q.quota.Max24HourSend))
+		q.pausedGauge.Set(1)
+	case !paused && q.paused:
+		q.log.Info("Resuming bulk sending", zap.Float64("remaining", remaining))
+		q.pausedGauge.Set(0)
+	}
+	q.paused = paused
+}
+
+// quotaSender waits for the send rate of the quota before sending.
+type quotaSender struct {
+	quota  *QuotaManager
+	sender Sender
+}
+
+// Send the message after waiting for the send rate, or until ctx is done.
+func (s *quotaSender) Send(ctx context.Context, m Message) (string, error) {
+	if err := s.quota.limiter.Wait(ctx); err != nil {
+		return "", err
+	}
+	id, err := s.sender.Send(ctx, m)
+	if err == nil {
+		s.quota.sent()
+	}
+	return id, err
+}
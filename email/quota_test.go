@@ -0,0 +1,170 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/email"
+	"canvas/messaging"
+)
+
+// sesAccountClientMock returns the quota in GetAccount, or fails with err if it's set.
+type sesAccountClientMock struct {
+	quota types.SendQuota
+	err   error
+}
+
+func (c *sesAccountClientMock) GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	quota := c.quota
+	return &sesv2.GetAccountOutput{SendQuota: &quota}, nil
+}
+
+func TestQuotaManager(t *testing.T) {
+	t.Run("doesn't limit or pause before the quota is read", func(t *testing.T) {
+		is := is.New(t)
+
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: &sesAccountClientMock{}})
+		is.NoErr(q.CheckHeadroom())
+
+		sender := &senderMock{}
+		s := q.Limit(sender)
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			_, err := s.Send(context.Background(), email.Message{To: "me@example.com"})
+			is.NoErr(err)
+		}
+		is.True(time.Since(start) < 50*time.Millisecond)
+		is.Equal(10, len(sender.messages))
+	})
+
+	t.Run("reads the quota from the default endpoint of the region without an endpoint URL, and the endpoint URL with it", func(t *testing.T) {
+		is := is.New(t)
+
+		var urls []string
+		config := newRecordingConfig(&urls, `{"SendQuota":{"Max24HourSend":1000,"MaxSendRate":25,"SentLast24Hours":10}}`)
+		for _, endpointURL := range []string{"", "http://localhost:4566"} {
+			q := email.NewQuotaManager(email.NewQuotaManagerOptions{Config: config, EndpointURL: endpointURL})
+			is.NoErr(q.Refresh(context.Background()))
+			is.Equal(float64(990), q.Quota().Remaining())
+		}
+		is.Equal([]string{
+			"https://email.eu-west-1.amazonaws.com/v2/email/account",
+			"http://localhost:4566/v2/email/account",
+		}, urls)
+	})
+
+	t.Run("limits sends to the percentage of the maximum send rate", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 1000, MaxSendRate: 25}}
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client, RatePercent: 80})
+		is.NoErr(q.Refresh(context.Background()))
+
+		// At 20 per second, the sends after the first wait 50ms each.
+		s := q.Limit(&senderMock{})
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			_, err := s.Send(context.Background(), email.Message{To: "me@example.com"})
+			is.NoErr(err)
+		}
+		is.True(time.Since(start) >= 190*time.Millisecond)
+	})
+
+	t.Run("pauses when the reserve of the daily quota is reached by sends, and resumes when it frees up", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		registry := prometheus.NewRegistry()
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 100, MaxSendRate: 1000, SentLast24Hours: 88}}
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client, Log: zap.New(core), Metrics: registry, ReservePercent: 10})
+		is.NoErr(q.Refresh(context.Background()))
+		is.NoErr(q.CheckHeadroom())
+
+		s := q.Limit(&senderMock{})
+		for i := 0; i < 3; i++ {
+			_, err := s.Send(context.Background(), email.Message{To: "me@example.com"})
+			is.NoErr(err)
+		}
+		is.Equal(float64(9), q.Quota().Remaining())
+
+		err := q.CheckHeadroom()
+		is.True(errors.Is(err, email.ErrQuotaPaused))
+		is.True(messaging.IsRetryable(err))
+		warnings := logs.FilterMessage("Pausing bulk sending, the daily sending quota is almost used up").All()
+		is.Equal(1, len(warnings))
+		is.Equal(zapcore.WarnLevel, warnings[0].Level)
+
+		err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_email_quota_paused Whether bulk sending is paused because the 24 hour quota is almost used up.
+# TYPE app_email_quota_paused gauge
+app_email_quota_paused 1
+# HELP app_email_quota_remaining Emails that can still be sent within the 24 hour quota of the email provider.
+# TYPE app_email_quota_remaining gauge
+app_email_quota_remaining 9
+`), "app_email_quota_paused", "app_email_quota_remaining")
+		is.NoErr(err)
+
+		// Emails sent more than 24 hours ago no longer count.
+		client.quota.SentLast24Hours = 50
+		is.NoErr(q.Refresh(context.Background()))
+		is.NoErr(q.CheckHeadroom())
+		is.Equal(1, logs.FilterMessage("Resuming bulk sending").Len())
+	})
+
+	t.Run("pauses an account that can't send", func(t *testing.T) {
+		is := is.New(t)
+
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: &sesAccountClientMock{quota: types.SendQuota{}}})
+		is.NoErr(q.Refresh(context.Background()))
+		is.True(errors.Is(q.CheckHeadroom(), email.ErrQuotaPaused))
+	})
+
+	t.Run("keeps the quota read before if reading it fails", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 100, MaxSendRate: 10, SentLast24Hours: 95}}
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client})
+		is.NoErr(q.Refresh(context.Background()))
+
+		client.err = errors.New("oh no")
+		is.True(q.Refresh(context.Background()) != nil)
+		is.Equal(float64(5), q.Quota().Remaining())
+		is.True(errors.Is(q.CheckHeadroom(), email.ErrQuotaPaused))
+	})
+
+	t.Run("doesn't count sends that fail", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 100, MaxSendRate: 1000}}
+		q := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client})
+		is.NoErr(q.Refresh(context.Background()))
+
+		s := q.Limit(&failingSenderMock{err: errors.New("oh no")})
+		_, err := s.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.True(err != nil)
+		is.Equal(float64(100), q.Quota().Remaining())
+	})
+}
+
+type failingSenderMock struct {
+	err error
+}
+
+func (s *failingSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	return "", s.err
+}
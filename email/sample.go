@@ -0,0 +1,50 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"canvas/i18n"
+	"canvas/model"
+)
+
+// SampleTemplates are the names of the emails that SampleEmail renders, in order.
+var SampleTemplates = []string{"confirmation", "newsletter", "welcome"}
+
+// ErrUnknownTemplate is returned by SampleEmail for a name that isn't one of SampleTemplates.
+var ErrUnknownTemplate = errors.New("unknown template")
+
+// sampleToken is the dummy token in the confirmation links of samples. Like PreviewUnsubscribeToken, it's never valid.
+const sampleToken = "sample"
+
+// sampleNewsletter is the issue in the newsletter and welcome samples, with a bit of every kind of content.
+var sampleNewsletter = model.Newsletter{
+	ID:    1,
+	Title: "A sample issue",
+	Slug:  "a-sample-issue",
+	Body: "Hi there! This is a **sample issue**, with a bit of every kind of content.\n\n" +
+		"## A heading\n\n" +
+		"A paragraph with [a link](https://example.com) and some `code`.\n\n" +
+		"- A list item\n- Another list item\n\n" +
+		"> A quote.\n",
+}
+
+// SampleEmail with the name, which is one of SampleTemplates, to the address with sample merge data.
+// It's rendered by the same functions as the emails that are sent, for PreviewSubscriber if to is empty,
+// so previews and test sends can't look different. The confirmation and unsubscribe links have dummy tokens.
+func SampleEmail(name string, t *i18n.Translator, from, address string, to model.Email, baseURL string) (Message, error) {
+	if to == "" {
+		to = PreviewSubscriber
+	}
+	switch name {
+	case "confirmation":
+		return ConfirmationEmail(t, from, address, to, baseURL, sampleToken)
+	case "newsletter":
+		return newsletterEmail(t, from, address, to, sampleNewsletter, baseURL, PreviewUnsubscribeToken)
+	case "welcome":
+		return welcomeEmail(t, from, address, to, &sampleNewsletter, baseURL, PreviewUnsubscribeToken)
+	default:
+		return Message{}, fmt.Errorf("%w %q, must be one of %v", ErrUnknownTemplate, name, strings.Join(SampleTemplates, ", "))
+	}
+}
@@ -0,0 +1,45 @@
+package email_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+)
+
+func TestSampleEmail(t *testing.T) {
+	for _, name := range email.SampleTemplates {
+		t.Run("renders "+name+" to the preview subscriber, with both parts and a dummy token", func(t *testing.T) {
+			is := is.New(t)
+
+			m, err := email.SampleEmail(name, english, "canvas@example.com", address, "", "https://example.com")
+			is.NoErr(err)
+			is.Equal(email.PreviewSubscriber, m.To)
+			is.True(m.Subject != "")
+			is.True(strings.Contains(m.HTML, "</html>"))
+			is.True(strings.Contains(m.Text, address))
+			is.True(strings.Contains(m.HTML, "token=sample") || strings.Contains(m.HTML, "token="+email.PreviewUnsubscribeToken))
+		})
+	}
+
+	t.Run("renders the confirmation exactly like the real one", func(t *testing.T) {
+		is := is.New(t)
+
+		sample, err := email.SampleEmail("confirmation", english, "canvas@example.com", address, "me@example.com", "https://example.com")
+		is.NoErr(err)
+		real, err := email.ConfirmationEmail(english, "canvas@example.com", address, "me@example.com", "https://example.com", "sample")
+		is.NoErr(err)
+		is.Equal(real, sample)
+	})
+
+	t.Run("errors on an unknown template, with the names of the known ones", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.SampleEmail("confirm", english, "canvas@example.com", address, "", "https://example.com")
+		is.True(errors.Is(err, email.ErrUnknownTemplate))
+		is.True(strings.Contains(err.Error(), "confirmation, newsletter, welcome"))
+	})
+}
@@ -0,0 +1,111 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+)
+
+// contractMessage is sent in the contract tests, with a subject that must be encoded, a long line
+// that quoted-printable must wrap, and the headers of bulk mail with one-click unsubscribe.
+var contractMessage = email.Message{
+	To:      "me@example.com",
+	Subject: "Ünïcode and a long subject",
+	HTML:    `<p>Hi there, this is a long line of HTML to check that the quoted-printable encoding wraps lines that are over 76 characters.</p>`,
+	Text:    "Hi there = you",
+	Headers: map[string]string{
+		"List-Id":               "<newsletter.example.com>",
+		"List-Unsubscribe":      "<mailto:unsubscribe@example.com?subject=unsubscribe%20abc>, <https://example.com/newsletter/unsubscribe/one-click?token=abc>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		"Precedence":            "bulk",
+	},
+}
+
+// testSenderContract runs the tests that every email.Sender must pass, so they behave the same.
+// newSender gets a sender with canvas@example.com as its default from address,
+// and a function that returns the raw MIME of the last message it sent.
+func testSenderContract(t *testing.T, newSender func(t *testing.T) (email.Sender, func() []byte)) {
+	t.Run("sends both bodies and the headers, and returns the message ID", func(t *testing.T) {
+		is := is.New(t)
+
+		s, received := newSender(t)
+		id, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.True(id != "")
+
+		m, err := mail.ReadMessage(strings.NewReader(string(received())))
+		is.NoErr(err)
+		is.Equal("canvas@example.com", m.Header.Get("From"))
+		is.Equal("me@example.com", m.Header.Get("To"))
+		subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+		is.NoErr(err)
+		is.Equal("Ünïcode and a long subject", subject)
+		for k, v := range contractMessage.Headers {
+			is.Equal(v, m.Header.Get(k))
+		}
+
+		parts := parts(t, m)
+		is.Equal(contractMessage.Text, parts["text/plain; charset=utf-8"])
+		is.Equal(contractMessage.HTML, parts["text/html; charset=utf-8"])
+	})
+
+	t.Run("uses the from address of the message over the default", func(t *testing.T) {
+		is := is.New(t)
+
+		s, received := newSender(t)
+		m := contractMessage
+		m.From = "Newsletter <newsletter@example.com>"
+		_, err := s.Send(context.Background(), m)
+		is.NoErr(err)
+
+		raw, err := mail.ReadMessage(strings.NewReader(string(received())))
+		is.NoErr(err)
+		is.Equal("Newsletter <newsletter@example.com>", raw.Header.Get("From"))
+	})
+
+	t.Run("doesn't send headers with line breaks", func(t *testing.T) {
+		is := is.New(t)
+
+		s, received := newSender(t)
+		m := contractMessage
+		m.Headers = map[string]string{"X-Campaign": "a\r\nBcc: everyone@example.com"}
+		_, err := s.Send(context.Background(), m)
+		is.True(err != nil)
+		is.True(received() == nil)
+	})
+}
+
+// parts of the multipart body of the message, by content type.
+func parts(t *testing.T, m *mail.Message) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type is %q, %v", m.Header.Get("Content-Type"), err)
+	}
+	parts := map[string]string{}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The quoted-printable encoding is decoded by the reader.
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[p.Header.Get("Content-Type")] = strings.ReplaceAll(string(b), "\r\n", "\n")
+	}
+}
@@ -0,0 +1,190 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+
+	"canvas/messaging"
+)
+
+// Delays before retrying a message that the email provider didn't take, by why.
+const (
+	// throttledDelay is for going over the sending rate, which is per second.
+	throttledDelay = 5 * time.Second
+	// quotaDelay is for going over the sending quota, which is over the last 24 hours.
+	quotaDelay = 15 * time.Minute
+	// unavailableDelay is for the provider not answering, or answering with a server error.
+	unavailableDelay = 30 * time.Second
+)
+
+// sesClient has the sesv2.Client methods used by SESSender, so it can be faked in tests.
+type sesClient interface {
+	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
+	GetEmailIdentity(ctx context.Context, params *sesv2.GetEmailIdentityInput, optFns ...func(*sesv2.Options)) (*sesv2.GetEmailIdentityOutput, error)
+}
+
+// newSESClient from the AWS config, with the endpoint URL overriding the one of the region if it's not empty.
+func newSESClient(config aws.Config, endpointURL string) *sesv2.Client {
+	return sesv2.NewFromConfig(config, func(o *sesv2.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})
+}
+
+// SESSender sends messages with Amazon SES.
+type SESSender struct {
+	client           sesClient
+	configurationSet string
+	from             string
+	fromARN          string
+	log              *zap.Logger
+}
+
+// NewSESSenderOptions for NewSESSender.
+type NewSESSenderOptions struct {
+	// Client overrides the SES client created from Config, such as with a fake in tests.
+	Client sesClient
+	Config aws.Config
+	// ConfigurationSet of the messages, like for sending bounce and complaint events to SNS. It's optional.
+	ConfigurationSet string
+	// EndpointURL overrides the default SES endpoint of the region, such as for local development, if not empty.
+	EndpointURL string
+	// From address of messages that don't have one.
+	From string
+	// FromARN of the identity that's authorized to send from the From addresses, for sending authorization.
+	// It's optional, and only needed when the identity is in another account.
+	FromARN string
+	Log     *zap.Logger
+}
+
+// NewSESSender with the given options.
+// If no logger is provided, logs are discarded.
+func NewSESSender(opts NewSESSenderOptions) *SESSender {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Client == nil {
+		opts.Client = newSESClient(opts.Config, opts.EndpointURL)
+	}
+	return &SESSender{
+		client:           opts.Client,
+		configurationSet: opts.ConfigurationSet,
+		from:             opts.From,
+		fromARN:          opts.FromARN,
+		log:              opts.Log,
+	}
+}
+
+// Send the message with SES, returning the message ID that SES gives it.
+// It's sent as raw MIME, since that's what can have both bodies and the headers, like List-Unsubscribe.
+// Being throttled, going over the sending quota, and SES being unavailable are messaging.RetryableError,
+// so the job runner tries again later.
+func (s *SESSender) Send(ctx context.Context, m Message) (string, error) {
+	if m.From == "" {
+		m.From = s.from
+	}
+	raw, err := rawMessage(m)
+	if err != nil {
+		return "", err
+	}
+
+	input := &sesv2.SendEmailInput{
+		Content:          &types.EmailContent{Raw: &types.RawMessage{Data: raw}},
+		Destination:      &types.Destination{ToAddresses: []string{m.To.String()}},
+		FromEmailAddress: aws.String(m.From),
+	}
+	if s.configurationSet != "" {
+		input.ConfigurationSetName = aws.String(s.configurationSet)
+	}
+	if s.fromARN != "" {
+		input.FromEmailAddressIdentityArn = aws.String(s.fromARN)
+	}
+
+	output, err := s.client.SendEmail(ctx, input)
+	if err != nil {
+		return "", classifySESError(fmt.Errorf("error sending email with SES: %w", err))
+	}
+	id := aws.ToString(output.MessageId)
+	s.log.Debug("Sent email", zap.String("messageID", id), zap.String("subject", m.Subject))
+	return id, nil
+}
+
+// VerifyIdentity checks that SES can send from the From address, returning which identity it sends as.
+// That's the identity of the address, or else of its domain, which must be verified for sending, and have DKIM
+// signing on, with the DKIM records found. Errors reaching SES are classified like in Send.
+// With FromARN, the identity is in another account, which can't be looked up, so it's not checked.
+func (s *SESSender) VerifyIdentity(ctx context.Context) (string, error) {
+	if s.fromARN != "" {
+		return fmt.Sprintf("not checked, since the identity %v is in another account", s.fromARN), nil
+	}
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return "", fmt.Errorf("invalid from address %q: %w", s.from, err)
+	}
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+
+	// The address is looked up first, since it's an identity of its own if it's verified by itself.
+	identity := from.Address
+	output, err := s.client.GetEmailIdentity(ctx, &sesv2.GetEmailIdentityInput{EmailIdentity: aws.String(identity)})
+	var notFound *types.NotFoundException
+	if errors.As(err, &notFound) {
+		identity = domain
+		output, err = s.client.GetEmailIdentity(ctx, &sesv2.GetEmailIdentityInput{EmailIdentity: aws.String(identity)})
+	}
+	if errors.As(err, &notFound) {
+		return "", fmt.Errorf("neither %v nor %v is an SES identity", from.Address, domain)
+	}
+	if err != nil {
+		return "", classifySESError(fmt.Errorf("error getting SES identity %v: %w", identity, err))
+	}
+
+	if !output.VerifiedForSendingStatus {
+		return "", fmt.Errorf("SES identity %v isn't verified for sending, its status is %v", identity, output.VerificationStatus)
+	}
+	dkim := output.DkimAttributes
+	switch {
+	case dkim == nil || !dkim.SigningEnabled:
+		return "", fmt.Errorf("SES identity %v is verified, but doesn't sign with DKIM", identity)
+	case dkim.Status != types.DkimStatusSuccess:
+		return "", fmt.Errorf("SES identity %v is verified, but its DKIM status is %v", identity, dkim.Status)
+	}
+	return fmt.Sprintf("identity %v is verified, with DKIM", identity), nil
+}
+
+// classifySESError as a messaging.RetryableError if trying again later can work, and as it is otherwise.
+func classifySESError(err error) error {
+	var tooMany *types.TooManyRequestsException
+	var limit *types.LimitExceededException
+	var internal *types.InternalServiceErrorException
+	var sendErr *smithyhttp.RequestSendError
+	var responseErr *awshttp.ResponseError
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &tooMany):
+		return messaging.Retryable(err, throttledDelay)
+	case errors.As(err, &apiErr) && (apiErr.ErrorCode() == "Throttling" || apiErr.ErrorCode() == "ThrottlingException"):
+		// The sending rate is enforced with the generic throttling error, not a modelled one.
+		return messaging.Retryable(err, throttledDelay)
+	case errors.As(err, &limit):
+		return messaging.Retryable(err, quotaDelay)
+	case errors.As(err, &internal), errors.As(err, &sendErr):
+		return messaging.Retryable(err, unavailableDelay)
+	case errors.As(err, &responseErr) && responseErr.HTTPStatusCode() >= 500:
+		return messaging.Retryable(err, unavailableDelay)
+	default:
+		return err
+	}
+}
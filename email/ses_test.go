@@ -0,0 +1,238 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/messaging"
+)
+
+// sesClientMock records the input of SendEmail, and fails with err if it's set.
+// GetEmailIdentity returns the identities by name, and a NotFoundException for others.
+type sesClientMock struct {
+	input      *sesv2.SendEmailInput
+	err        error
+	identities map[string]*sesv2.GetEmailIdentityOutput
+}
+
+func (c *sesClientMock) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	c.input = params
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &sesv2.SendEmailOutput{MessageId: aws.String("0100018abc")}, nil
+}
+
+func (c *sesClientMock) GetEmailIdentity(ctx context.Context, params *sesv2.GetEmailIdentityInput, optFns ...func(*sesv2.Options)) (*sesv2.GetEmailIdentityOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if output, ok := c.identities[*params.EmailIdentity]; ok {
+		return output, nil
+	}
+	return nil, &types.NotFoundException{Message: aws.String("Email identity " + *params.EmailIdentity + " does not exist.")}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newRecordingConfig with an HTTP client recording the URLs of requests, and responding to them with the body.
+func newRecordingConfig(urls *[]string, body string) aws.Config {
+	return aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			*urls = append(*urls, r.URL.String())
+			res := httptest.NewRecorder()
+			_, _ = res.WriteString(body)
+			return res.Result(), nil
+		})},
+	}
+}
+
+func TestSESSender_Send(t *testing.T) {
+	testSenderContract(t, func(t *testing.T) (email.Sender, func() []byte) {
+		client := &sesClientMock{}
+		s := email.NewSESSender(email.NewSESSenderOptions{Client: client, From: "canvas@example.com"})
+		return s, func() []byte {
+			if client.input == nil {
+				return nil
+			}
+			return client.input.Content.Raw.Data
+		}
+	})
+
+	t.Run("uses the default endpoint of the region without an endpoint URL, and the endpoint URL with it", func(t *testing.T) {
+		is := is.New(t)
+
+		var urls []string
+		config := newRecordingConfig(&urls, `{"MessageId":"0100018abc"}`)
+		for _, endpointURL := range []string{"", "http://localhost:4566"} {
+			s := email.NewSESSender(email.NewSESSenderOptions{Config: config, EndpointURL: endpointURL, From: "canvas@example.com"})
+			_, err := s.Send(context.Background(), contractMessage)
+			is.NoErr(err)
+		}
+		is.Equal([]string{
+			"https://email.eu-west-1.amazonaws.com/v2/email/outbound-emails",
+			"http://localhost:4566/v2/email/outbound-emails",
+		}, urls)
+	})
+
+	t.Run("sends from the identity, with the configuration set, and returns the SES message ID", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesClientMock{}
+		s := email.NewSESSender(email.NewSESSenderOptions{
+			Client:           client,
+			ConfigurationSet: "canvas",
+			From:             "Canvas <canvas@example.com>",
+			FromARN:          "arn:aws:ses:eu-west-1:123456789012:identity/example.com",
+		})
+		id, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.Equal("0100018abc", id)
+
+		in := client.input
+		is.Equal("Canvas <canvas@example.com>", *in.FromEmailAddress)
+		is.Equal("arn:aws:ses:eu-west-1:123456789012:identity/example.com", *in.FromEmailAddressIdentityArn)
+		is.Equal("canvas", *in.ConfigurationSetName)
+		is.Equal([]string{"me@example.com"}, in.Destination.ToAddresses)
+	})
+
+	t.Run("leaves out what's not configured", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesClientMock{}
+		_, err := email.NewSESSender(email.NewSESSenderOptions{Client: client}).Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.True(client.input.ConfigurationSetName == nil)
+		is.True(client.input.FromEmailAddressIdentityArn == nil)
+	})
+
+	t.Run("maps throttling, quota, and availability errors to retryable errors", func(t *testing.T) {
+		tests := map[string]struct {
+			err       error
+			retryable bool
+		}{
+			"too many requests": {&types.TooManyRequestsException{Message: aws.String("slow down")}, true},
+			"sending rate":      {&smithy.GenericAPIError{Code: "Throttling", Message: "Maximum sending rate exceeded."}, true},
+			"sending quota":     {&types.LimitExceededException{Message: aws.String("Daily message quota exceeded.")}, true},
+			"internal error":    {&types.InternalServiceErrorException{}, true},
+			"unreachable":       {&smithyhttp.RequestSendError{Err: errors.New("connection refused")}, true},
+			"rejected":          {&types.MessageRejected{Message: aws.String("Email address is not verified.")}, false},
+			"not verified":      {&types.MailFromDomainNotVerifiedException{}, false},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+
+				s := email.NewSESSender(email.NewSESSenderOptions{Client: &sesClientMock{err: test.err}})
+				_, err := s.Send(context.Background(), contractMessage)
+				is.True(errors.Is(err, test.err))
+				is.Equal(test.retryable, messaging.IsRetryable(err))
+
+				var retryableErr *messaging.RetryableError
+				if errors.As(err, &retryableErr) {
+					is.True(retryableErr.Delay > 0)
+				}
+			})
+		}
+	})
+}
+
+func TestSESSender_VerifyIdentity(t *testing.T) {
+	verified := func(dkim types.DkimStatus) *sesv2.GetEmailIdentityOutput {
+		return &sesv2.GetEmailIdentityOutput{
+			DkimAttributes:           &types.DkimAttributes{SigningEnabled: true, Status: dkim},
+			VerificationStatus:       types.VerificationStatusSuccess,
+			VerifiedForSendingStatus: true,
+		}
+	}
+
+	t.Run("reports the address or its domain as the identity, if it's verified with DKIM", func(t *testing.T) {
+		tests := map[string]struct {
+			identity string
+			detail   string
+		}{
+			"address": {"canvas@example.com", "identity canvas@example.com is verified, with DKIM"},
+			"domain":  {"example.com", "identity example.com is verified, with DKIM"},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+
+				client := &sesClientMock{identities: map[string]*sesv2.GetEmailIdentityOutput{test.identity: verified(types.DkimStatusSuccess)}}
+				s := email.NewSESSender(email.NewSESSenderOptions{Client: client, From: "Canvas <canvas@example.com>"})
+				detail, err := s.VerifyIdentity(context.Background())
+				is.NoErr(err)
+				is.Equal(test.detail, detail)
+			})
+		}
+	})
+
+	t.Run("errors if the identity isn't verified, doesn't have DKIM, or doesn't exist", func(t *testing.T) {
+		tests := map[string]struct {
+			identity *sesv2.GetEmailIdentityOutput
+			err      string
+		}{
+			"unverified": {&sesv2.GetEmailIdentityOutput{VerificationStatus: types.VerificationStatusPending},
+				"SES identity example.com isn't verified for sending, its status is PENDING"},
+			"without DKIM": {&sesv2.GetEmailIdentityOutput{VerifiedForSendingStatus: true, DkimAttributes: &types.DkimAttributes{}},
+				"SES identity example.com is verified, but doesn't sign with DKIM"},
+			"DKIM records not found": {verified(types.DkimStatusFailed),
+				"SES identity example.com is verified, but its DKIM status is FAILED"},
+			"missing": {nil, "neither canvas@example.com nor example.com is an SES identity"},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+
+				client := &sesClientMock{identities: map[string]*sesv2.GetEmailIdentityOutput{}}
+				if test.identity != nil {
+					client.identities["example.com"] = test.identity
+				}
+				s := email.NewSESSender(email.NewSESSenderOptions{Client: client, From: "canvas@example.com"})
+				_, err := s.VerifyIdentity(context.Background())
+				is.True(err != nil)
+				is.Equal(test.err, err.Error())
+				is.True(!messaging.IsRetryable(err))
+			})
+		}
+	})
+
+	t.Run("returns a retryable error if SES can't be reached", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesClientMock{err: &smithyhttp.RequestSendError{Err: errors.New("connection refused")}}
+		s := email.NewSESSender(email.NewSESSenderOptions{Client: client, From: "canvas@example.com"})
+		_, err := s.VerifyIdentity(context.Background())
+		is.True(err != nil)
+		is.True(messaging.IsRetryable(err))
+	})
+
+	t.Run("doesn't check an identity in another account", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sesClientMock{err: errors.New("don't call me")}
+		s := email.NewSESSender(email.NewSESSenderOptions{Client: client, From: "canvas@example.com",
+			FromARN: "arn:aws:ses:eu-west-1:123456789012:identity/example.com"})
+		detail, err := s.VerifyIdentity(context.Background())
+		is.NoErr(err)
+		is.Equal("not checked, since the identity arn:aws:ses:eu-west-1:123456789012:identity/example.com is in another account", detail)
+	})
+}
@@ -0,0 +1,349 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/messaging"
+)
+
+// SMTPSecurity of the connection to the SMTP server.
+type SMTPSecurity string
+
+const (
+	// SMTPStartTLS connects in plain text, and upgrades to TLS with STARTTLS before anything else.
+	// Servers that don't support it are an error, so nothing is sent in plain text by mistake.
+	SMTPStartTLS SMTPSecurity = "starttls"
+	// SMTPTLS connects with TLS right away, like on port 465.
+	SMTPTLS SMTPSecurity = "tls"
+	// SMTPNone never uses TLS, only for local servers like MailHog.
+	SMTPNone SMTPSecurity = "none"
+)
+
+// SMTPSender sends messages to an SMTP server, like Postmark, Mailgun, or MailHog in development.
+// It keeps its connection open between messages, so a batch of them is sent over one connection,
+// and connects again if the server closed it.
+type SMTPSender struct {
+	addr        string
+	auth        smtp.Auth
+	client      *smtp.Client
+	conn        net.Conn
+	from        string
+	host        string
+	idle        *time.Timer
+	idleTimeout time.Duration
+	log         *zap.Logger
+	mutex       sync.Mutex
+	security    SMTPSecurity
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+}
+
+// NewSMTPSenderOptions for NewSMTPSender.
+type NewSMTPSenderOptions struct {
+	// From address of messages that don't have one.
+	From string
+	Host string
+	// IdleTimeout after which an unused connection is closed. Defaults to 30 seconds.
+	IdleTimeout time.Duration
+	Log         *zap.Logger
+	// Username and Password for PLAIN authentication, which is only used over TLS, or to localhost.
+	// Without a username, there's no authentication.
+	Username string
+	Password string
+	// Port of the server. Defaults to 587.
+	Port     int
+	Security SMTPSecurity
+	// Timeout of connecting, and of sending each message. Defaults to 10 seconds.
+	Timeout time.Duration
+	// TLSConfig overrides the TLS configuration for Host, such as with a test certificate authority in tests.
+	TLSConfig *tls.Config
+}
+
+// NewSMTPSender with the given options. It doesn't connect until the first message is sent.
+// If no logger is provided, logs are discarded.
+func NewSMTPSender(opts NewSMTPSenderOptions) *SMTPSender {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Port == 0 {
+		opts.Port = 587
+	}
+	if opts.Security == "" {
+		opts.Security = SMTPStartTLS
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{ServerName: opts.Host, MinVersion: tls.VersionTLS12}
+	}
+	var auth smtp.Auth
+	if opts.Username != "" {
+		auth = smtp.PlainAuth("", opts.Username, opts.Password, opts.Host)
+	}
+	return &SMTPSender{
+		addr:        net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port)),
+		auth:        auth,
+		from:        opts.From,
+		host:        opts.Host,
+		idleTimeout: opts.IdleTimeout,
+		log:         opts.Log,
+		security:    opts.Security,
+		timeout:     opts.Timeout,
+		tlsConfig:   opts.TLSConfig,
+	}
+}
+
+// Send the message, returning the Message-ID header it's sent with.
+// Temporary failures, like the server being unreachable, timing out, or answering with a 4xx code,
+// are messaging.RetryableError, so the job runner tries again later.
+func (s *SMTPSender) Send(ctx context.Context, m Message) (string, error) {
+	if m.From == "" {
+		m.From = s.from
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid from address %q: %w", m.From, err)
+	}
+	id, err := messageID(from.Address)
+	if err != nil {
+		return "", err
+	}
+	headers := map[string]string{
+		"Date":       time.Now().Format(time.RFC1123Z),
+		"Message-ID": id,
+	}
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	m.Headers = headers
+	raw, err := rawMessage(m)
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.send(ctx, from.Address, m.To.String(), raw); err != nil {
+		// The connection is in an unknown state after an error, so the next message gets a new one.
+		s.disconnect()
+		return "", classifySMTPError(fmt.Errorf("error sending email with SMTP to %v: %w", s.addr, err))
+	}
+	s.log.Debug("Sent email", zap.String("messageID", id), zap.String("subject", m.Subject))
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.idle = time.AfterFunc(s.idleTimeout, s.closeIdle)
+	return id, nil
+}
+
+// VerifyIdentity checks that the server takes messages from the From address, by connecting with TLS
+// and authentication as configured, and starting a message from the address, which is reset before any
+// recipient, so nothing is sent. It returns the server address and the address for the report.
+// Errors are classified like in Send.
+func (s *SMTPSender) VerifyIdentity(ctx context.Context) (string, error) {
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return "", fmt.Errorf("invalid from address %q: %w", s.from, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.verify(ctx, from.Address); err != nil {
+		s.disconnect()
+		return "", classifySMTPError(fmt.Errorf("error checking that SMTP server %v takes %v: %w", s.addr, from.Address, err))
+	}
+
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.idle = time.AfterFunc(s.idleTimeout, s.closeIdle)
+	return fmt.Sprintf("%v takes %v, with %v", s.addr, from.Address, s.security), nil
+}
+
+// verify the server takes the address as the sender, connecting first if there's no connection.
+func (s *SMTPSender) verify(ctx context.Context, from string) error {
+	if err := s.connected(ctx); err != nil {
+		return err
+	}
+	if err := s.client.Noop(); err != nil {
+		return err
+	}
+	if err := s.client.Mail(from); err != nil {
+		return err
+	}
+	return s.client.Reset()
+}
+
+// send the raw message over the connection, connecting first if there isn't one.
+func (s *SMTPSender) send(ctx context.Context, from, to string, raw []byte) error {
+	if err := s.connected(ctx); err != nil {
+		return err
+	}
+
+	c := s.client
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// connected makes sure there's a connection, with a deadline of the timeout or ctx, whichever is first,
+// reusing the one there is if it still works.
+func (s *SMTPSender) connected(ctx context.Context) error {
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	// A connection that's been open a while can have been closed by the server, which the reset finds out.
+	if s.client != nil {
+		if err := s.conn.SetDeadline(deadline); err != nil || s.client.Reset() != nil {
+			s.disconnect()
+		}
+	}
+	if s.client == nil {
+		return s.connect(ctx, deadline)
+	}
+	return nil
+}
+
+// connect to the server, with TLS and authentication as configured.
+func (s *SMTPSender) connect(ctx context.Context, deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if s.security == SMTPTLS {
+		conn = tls.Client(conn, s.tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if s.security == SMTPStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			_ = c.Close()
+			return errors.New("server doesn't support STARTTLS")
+		}
+		if err := c.StartTLS(s.tlsConfig); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err := c.Auth(s.auth); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+	s.client = c
+	s.conn = conn
+	return nil
+}
+
+// disconnect from the server without saying goodbye, since the connection may not work anymore.
+func (s *SMTPSender) disconnect() {
+	if s.client != nil {
+		_ = s.client.Close()
+	}
+	s.client = nil
+	s.conn = nil
+}
+
+// closeIdle connection, saying goodbye with QUIT.
+func (s *SMTPSender) closeIdle() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client == nil {
+		return
+	}
+	_ = s.conn.SetDeadline(time.Now().Add(s.timeout))
+	if err := s.client.Quit(); err != nil {
+		s.log.Debug("Error closing idle SMTP connection", zap.Error(err))
+	}
+	s.disconnect()
+}
+
+// Close the connection, if there is one.
+func (s *SMTPSender) Close() {
+	s.mutex.Lock()
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.mutex.Unlock()
+	s.closeIdle()
+}
+
+// classifySMTPError as a messaging.RetryableError if trying again later can work, and as it is otherwise.
+// Those are network errors, like the server being unreachable or timing out, and replies with 4xx codes,
+// which SMTP has for temporary failures, like being rate-limited. The server hanging up is a network error too.
+func classifySMTPError(err error) error {
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) {
+		if protocolErr.Code >= 400 && protocolErr.Code < 500 {
+			return messaging.Retryable(err, throttledDelay)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) {
+		return messaging.Retryable(err, unavailableDelay)
+	}
+	return err
+}
+
+// messageID for a message from the address, unique because of its random part.
+func messageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">", nil
+}
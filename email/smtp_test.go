@@ -0,0 +1,486 @@
+package email_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/integrationtest"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// smtpServer is a fake SMTP server, which records the connections, authentication, and messages it gets.
+// Recipients at full.example.com are rejected temporarily, and at rejected.example.com permanently,
+// and senders at unverified.example.com are rejected.
+type smtpServer struct {
+	listener net.Listener
+	// implicitTLS of the connections, instead of STARTTLS.
+	implicitTLS bool
+	// startTLS is advertised if set.
+	startTLS  bool
+	tlsConfig *tls.Config
+
+	mutex       sync.Mutex
+	auth        []string
+	conns       []net.Conn
+	connections int
+	messages    [][]byte
+	quits       int
+	// secure is whether each message was received over TLS.
+	secure []bool
+}
+
+func newSMTPServer(t *testing.T, s *smtpServer) (*smtpServer, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.implicitTLS {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+	s.listener = l
+	go s.serve()
+	t.Cleanup(func() {
+		_ = l.Close()
+		s.hangUp()
+	})
+	return s, l.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.connections++
+		s.conns = append(s.conns, conn)
+		s.mutex.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// hangUp on all connections, like a server that closes idle ones.
+func (s *smtpServer) hangUp() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	secure := s.implicitTLS
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			_ = tp.PrintfLine("250-localhost")
+			if s.startTLS && !secure {
+				_ = tp.PrintfLine("250-STARTTLS")
+			}
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			secure = true
+		case "AUTH":
+			_, credentials, _ := strings.Cut(arg, " ")
+			b, _ := base64.StdEncoding.DecodeString(credentials)
+			s.mutex.Lock()
+			s.auth = append(s.auth, string(b))
+			s.mutex.Unlock()
+			_ = tp.PrintfLine("235 Authenticated")
+		case "RCPT":
+			switch {
+			case strings.Contains(arg, "@full.example.com"):
+				_ = tp.PrintfLine("452 Mailbox full")
+			case strings.Contains(arg, "@rejected.example.com"):
+				_ = tp.PrintfLine("550 No such user")
+			default:
+				_ = tp.PrintfLine("250 OK")
+			}
+		case "DATA":
+			_ = tp.PrintfLine("354 Go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mutex.Lock()
+			s.messages = append(s.messages, b)
+			s.secure = append(s.secure, secure)
+			s.mutex.Unlock()
+			_ = tp.PrintfLine("250 Queued")
+		case "MAIL":
+			if strings.Contains(arg, "@unverified.example.com") {
+				_ = tp.PrintfLine("550 Sender signature not confirmed")
+				continue
+			}
+			_ = tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			_ = tp.PrintfLine("250 OK")
+		case "QUIT":
+			s.mutex.Lock()
+			s.quits++
+			s.mutex.Unlock()
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("500 Unknown command")
+		}
+	}
+}
+
+// lastMessage received by the server, or nil if there's none.
+func (s *smtpServer) lastMessage() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.messages) == 0 {
+		return nil
+	}
+	return s.messages[len(s.messages)-1]
+}
+
+// testCertificates for 127.0.0.1, as the server and the client TLS configuration that trusts it.
+func testCertificates(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	testSenderContract(t, func(t *testing.T) (email.Sender, func() []byte) {
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:     "canvas@example.com",
+			Host:     "127.0.0.1",
+			Port:     port,
+			Security: email.SMTPNone,
+		})
+		t.Cleanup(s.Close)
+		return s, srv.lastMessage
+	})
+
+	t.Run("sends with the Message-ID header that it returns, and a date", func(t *testing.T) {
+		is := is.New(t)
+
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@example.com", Host: "127.0.0.1", Port: port, Security: email.SMTPNone})
+		defer s.Close()
+
+		id, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.True(strings.HasPrefix(id, "<") && strings.HasSuffix(id, "@example.com>"))
+
+		m, err := mail.ReadMessage(strings.NewReader(string(srv.lastMessage())))
+		is.NoErr(err)
+		is.Equal(id, m.Header.Get("Message-ID"))
+		_, err = m.Header.Date()
+		is.NoErr(err)
+	})
+
+	t.Run("reuses the connection, and connects again after the server closed it", func(t *testing.T) {
+		is := is.New(t)
+
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@example.com", Host: "127.0.0.1", Port: port, Security: email.SMTPNone})
+		defer s.Close()
+
+		for i := 0; i < 3; i++ {
+			_, err := s.Send(context.Background(), contractMessage)
+			is.NoErr(err)
+		}
+		is.Equal(1, srv.connections)
+
+		srv.hangUp()
+		_, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.Equal(2, srv.connections)
+		is.Equal(4, len(srv.messages))
+	})
+
+	t.Run("closes the connection with QUIT after the idle timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:        "canvas@example.com",
+			Host:        "127.0.0.1",
+			IdleTimeout: 10 * time.Millisecond,
+			Port:        port,
+			Security:    email.SMTPNone,
+		})
+
+		_, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			srv.mutex.Lock()
+			quits := srv.quits
+			srv.mutex.Unlock()
+			if quits == 1 {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("connection not closed")
+	})
+
+	t.Run("upgrades with STARTTLS before authenticating", func(t *testing.T) {
+		is := is.New(t)
+
+		serverTLS, clientTLS := testCertificates(t)
+		srv, port := newSMTPServer(t, &smtpServer{startTLS: true, tlsConfig: serverTLS})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:      "canvas@example.com",
+			Host:      "127.0.0.1",
+			Username:  "canvas",
+			Password:  "123",
+			Port:      port,
+			Security:  email.SMTPStartTLS,
+			TLSConfig: clientTLS,
+		})
+		defer s.Close()
+
+		_, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.Equal([]string{"\x00canvas\x00123"}, srv.auth)
+		is.Equal([]bool{true}, srv.secure)
+	})
+
+	t.Run("doesn't send if the server doesn't support STARTTLS", func(t *testing.T) {
+		is := is.New(t)
+
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@example.com", Host: "127.0.0.1", Port: port})
+		defer s.Close()
+
+		_, err := s.Send(context.Background(), contractMessage)
+		is.True(err != nil)
+		is.True(!messaging.IsRetryable(err))
+		is.True(srv.lastMessage() == nil)
+	})
+
+	t.Run("sends over implicit TLS", func(t *testing.T) {
+		is := is.New(t)
+
+		serverTLS, clientTLS := testCertificates(t)
+		srv, port := newSMTPServer(t, &smtpServer{implicitTLS: true, tlsConfig: serverTLS})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:      "canvas@example.com",
+			Host:      "127.0.0.1",
+			Port:      port,
+			Security:  email.SMTPTLS,
+			TLSConfig: clientTLS,
+		})
+		defer s.Close()
+
+		_, err := s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.Equal([]bool{true}, srv.secure)
+	})
+
+	t.Run("maps temporary failures to retryable errors", func(t *testing.T) {
+		_, port := newSMTPServer(t, &smtpServer{})
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closedPort := l.Addr().(*net.TCPAddr).Port
+		_ = l.Close()
+
+		tests := map[string]struct {
+			to        string
+			port      int
+			retryable bool
+		}{
+			"mailbox full": {"me@full.example.com", port, true},
+			"unreachable":  {"me@example.com", closedPort, true},
+			"rejected":     {"me@rejected.example.com", port, false},
+		}
+		for name, test := range tests {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+
+				s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@example.com", Host: "127.0.0.1", Port: test.port, Security: email.SMTPNone})
+				defer s.Close()
+
+				m := contractMessage
+				m.To = model.Email(test.to)
+				_, err := s.Send(context.Background(), m)
+				is.True(err != nil)
+				is.Equal(test.retryable, messaging.IsRetryable(err))
+			})
+		}
+	})
+}
+
+// mailHogMessages of the MailHog in docker-compose.yaml for tests.
+type mailHogMessages struct {
+	Items []struct {
+		Raw struct {
+			Data string
+		}
+	}
+}
+
+func TestSMTPSender_VerifyIdentity(t *testing.T) {
+	t.Run("reports the server takes the address after authenticating, without sending anything", func(t *testing.T) {
+		is := is.New(t)
+
+		srv, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "Canvas <canvas@example.com>", Host: "127.0.0.1", Port: port,
+			Security: email.SMTPNone, Username: "canvas", Password: "123"})
+		defer s.Close()
+
+		detail, err := s.VerifyIdentity(context.Background())
+		is.NoErr(err)
+		is.Equal(fmt.Sprintf("127.0.0.1:%v takes canvas@example.com, with none", port), detail)
+		is.Equal([]string{"\x00canvas\x00123"}, srv.auth)
+		is.Equal(0, len(srv.messages))
+
+		// The connection is reused for sending afterwards.
+		_, err = s.Send(context.Background(), contractMessage)
+		is.NoErr(err)
+		is.Equal(1, srv.connections)
+	})
+
+	t.Run("errors if the server rejects the address", func(t *testing.T) {
+		is := is.New(t)
+
+		_, port := newSMTPServer(t, &smtpServer{})
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@unverified.example.com", Host: "127.0.0.1", Port: port,
+			Security: email.SMTPNone})
+		defer s.Close()
+
+		_, err := s.VerifyIdentity(context.Background())
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), "Sender signature not confirmed"))
+		is.True(!messaging.IsRetryable(err))
+	})
+
+	t.Run("returns a retryable error if the server can't be reached", func(t *testing.T) {
+		is := is.New(t)
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		is.NoErr(err)
+		port := l.Addr().(*net.TCPAddr).Port
+		_ = l.Close()
+
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{From: "canvas@example.com", Host: "127.0.0.1", Port: port,
+			Security: email.SMTPNone, Timeout: time.Second})
+		defer s.Close()
+
+		_, err = s.VerifyIdentity(context.Background())
+		is.True(err != nil)
+		is.True(messaging.IsRetryable(err))
+	})
+}
+
+func TestSMTPSender_MailHog(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	const api = "http://localhost:8026/api"
+
+	testSenderContract(t, func(t *testing.T) (email.Sender, func() []byte) {
+		req, err := http.NewRequest(http.MethodDelete, api+"/v1/messages", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+
+		s := email.NewSMTPSender(email.NewSMTPSenderOptions{
+			From:     "canvas@example.com",
+			Host:     "localhost",
+			Port:     1026,
+			Security: email.SMTPNone,
+		})
+		t.Cleanup(s.Close)
+
+		return s, func() []byte {
+			res, err := http.Get(api + "/v2/messages")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = res.Body.Close()
+			}()
+			var messages mailHogMessages
+			if err := json.NewDecoder(res.Body).Decode(&messages); err != nil {
+				t.Fatal(err)
+			}
+			if len(messages.Items) == 0 {
+				return nil
+			}
+			return []byte(messages.Items[0].Raw.Data)
+		}
+	})
+}
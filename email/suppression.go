@@ -0,0 +1,44 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"canvas/model"
+)
+
+// ErrSuppressed is returned by SuppressionGate for messages to addresses on the suppression list.
+// Nothing was sent, so callers record the send as skipped instead of failed, and don't retry it.
+var ErrSuppressed = errors.New("address is suppressed")
+
+// suppressionList is satisfied by *storage.Database.
+type suppressionList interface {
+	IsSuppressed(ctx context.Context, email model.Email) (bool, error)
+}
+
+// SuppressionGate is a Sender that checks the suppression list before every message, so no send path
+// can go around it. It's checked right before sending, so a suppression added during a newsletter send
+// stops the messages after it.
+type SuppressionGate struct {
+	list   suppressionList
+	sender Sender
+}
+
+// NewSuppressionGate in front of the sender, checking the list.
+func NewSuppressionGate(sender Sender, list suppressionList) *SuppressionGate {
+	return &SuppressionGate{list: list, sender: sender}
+}
+
+// Send the message with the sender, unless the address is suppressed, which returns ErrSuppressed.
+// Errors checking the list are returned as they are, so the message can be retried.
+func (g *SuppressionGate) Send(ctx context.Context, m Message) (string, error) {
+	suppressed, err := g.list.IsSuppressed(ctx, m.To)
+	if err != nil {
+		return "", fmt.Errorf("error checking suppression list: %w", err)
+	}
+	if suppressed {
+		return "", ErrSuppressed
+	}
+	return g.sender.Send(ctx, m)
+}
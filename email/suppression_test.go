@@ -0,0 +1,79 @@
+package email_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/model"
+)
+
+type senderMock struct {
+	messages []email.Message
+}
+
+func (s *senderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	s.messages = append(s.messages, m)
+	return "abc", nil
+}
+
+type suppressionListMock struct {
+	err        error
+	suppressed map[model.Email]bool
+}
+
+func (l *suppressionListMock) IsSuppressed(ctx context.Context, email model.Email) (bool, error) {
+	return l.suppressed[email], l.err
+}
+
+func TestSuppressionGate_Send(t *testing.T) {
+	t.Run("sends to addresses that aren't suppressed", func(t *testing.T) {
+		is := is.New(t)
+
+		sender := &senderMock{}
+		g := email.NewSuppressionGate(sender, &suppressionListMock{})
+		id, err := g.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.NoErr(err)
+		is.Equal("abc", id)
+		is.Equal(1, len(sender.messages))
+	})
+
+	t.Run("returns ErrSuppressed without sending to suppressed addresses", func(t *testing.T) {
+		is := is.New(t)
+
+		sender := &senderMock{}
+		g := email.NewSuppressionGate(sender, &suppressionListMock{suppressed: map[model.Email]bool{"me@example.com": true}})
+		_, err := g.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.True(errors.Is(err, email.ErrSuppressed))
+		is.Equal(0, len(sender.messages))
+	})
+
+	t.Run("checks the list before every message", func(t *testing.T) {
+		is := is.New(t)
+
+		sender := &senderMock{}
+		list := &suppressionListMock{suppressed: map[model.Email]bool{}}
+		g := email.NewSuppressionGate(sender, list)
+		_, err := g.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.NoErr(err)
+
+		list.suppressed["me@example.com"] = true
+		_, err = g.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.True(errors.Is(err, email.ErrSuppressed))
+		is.Equal(1, len(sender.messages))
+	})
+
+	t.Run("returns errors checking the list, without sending", func(t *testing.T) {
+		is := is.New(t)
+
+		sender := &senderMock{}
+		g := email.NewSuppressionGate(sender, &suppressionListMock{err: errors.New("oh no")})
+		_, err := g.Send(context.Background(), email.Message{To: "me@example.com"})
+		is.True(err != nil)
+		is.True(!errors.Is(err, email.ErrSuppressed))
+		is.Equal(0, len(sender.messages))
+	})
+}
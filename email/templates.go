@@ -0,0 +1,396 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"canvas/i18n"
+	"canvas/model"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// templateData has the merge fields of all email templates. Each template only uses some of them,
+// and the ones it needs must be set, or it fails to render.
+type templateData struct {
+	// Address is the physical address in the footer.
+	Address string
+	BaseURL string
+	// ConfirmURL of the confirmation email.
+	ConfirmURL string
+	// Content of the newsletter issue, which must already be sanitized.
+	Content template.HTML
+	// IssueURL of the latest newsletter issue in the welcome email. It's optional, since there may be none yet.
+	IssueURL string
+	Locale   string
+	Subject  string
+	// Title of the newsletter issue.
+	Title          string
+	To             model.Email
+	UnsubscribeURL string
+}
+
+// templates by name, each with the shared layout. They're parsed once, and cloned for each render,
+// so the translation function can be for the locale of the email.
+var templates = map[string]*template.Template{}
+
+// styles inlined into every email, from templates/styles.css.
+var styles []styleRule
+
+func init() {
+	funcs := template.FuncMap{
+		"required": required,
+		"t":        func(key string, args ...any) string { return key },
+	}
+	for _, name := range []string{"confirm", "newsletter", "welcome"} {
+		templates[name] = template.Must(template.New(name).Funcs(funcs).ParseFS(templateFiles, "templates/layout.html", "templates/"+name+".html"))
+	}
+
+	css, err := templateFiles.ReadFile("templates/styles.css")
+	if err != nil {
+		panic(err)
+	}
+	styles = parseStyles(string(css))
+}
+
+// required merge field, which is an error if it's the zero value, like an empty string.
+// Otherwise, it returns the value, so it can be used in place of it.
+func required(name string, v any) (any, error) {
+	if v == nil || reflect.ValueOf(v).IsZero() {
+		return nil, fmt.Errorf("missing %v", name)
+	}
+	return v, nil
+}
+
+// renderTemplate with the name, translated by t, returning the HTML part with the styles inlined,
+// and the plain-text part derived from it. The merge fields are escaped, except for data.Content.
+func renderTemplate(name string, t *i18n.Translator, data templateData) (string, string, error) {
+	tmpl, err := templates[name].Clone()
+	if err != nil {
+		return "", "", err
+	}
+	tmpl.Funcs(template.FuncMap{"t": t.T})
+	data.Locale = t.Locale()
+
+	var b bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&b, "layout", data); err != nil {
+		return "", "", fmt.Errorf("error executing %v template: %w", name, err)
+	}
+	htmlPart, err := inlineStyles(b.String())
+	if err != nil {
+		return "", "", fmt.Errorf("error inlining styles of %v template: %w", name, err)
+	}
+	textPart, err := htmlToText(htmlPart)
+	if err != nil {
+		return "", "", fmt.Errorf("error deriving text of %v template: %w", name, err)
+	}
+	return htmlPart, textPart, nil
+}
+
+// styleRule of the stylesheet, for elements matching the selector.
+type styleRule struct {
+	// selector of simple selectors like "a", ".footer", or "p.note", for the element and then its ancestors,
+	// from the last one in the source, like "a" and then ".footer" for ".footer a".
+	selector     []simpleSelector
+	declarations string
+}
+
+type simpleSelector struct {
+	tag   string
+	class string
+}
+
+// parseStyles of a simple stylesheet, with comments, and rules with type, class, and descendant selectors only.
+func parseStyles(css string) []styleRule {
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(css[start:], "*/")
+		if end < 0 {
+			css = css[:start]
+			break
+		}
+		css = css[:start] + css[start+end+2:]
+	}
+
+	var rules []styleRule
+	for _, block := range strings.Split(css, "}") {
+		selectors, declarations, ok := strings.Cut(block, "{")
+		if !ok {
+			continue
+		}
+		declarations = strings.TrimSpace(declarations)
+		for _, selector := range strings.Split(selectors, ",") {
+			var r styleRule
+			parts := strings.Fields(selector)
+			for i := len(parts) - 1; i >= 0; i-- {
+				tag, class, _ := strings.Cut(parts[i], ".")
+				r.selector = append(r.selector, simpleSelector{tag: tag, class: class})
+			}
+			if len(r.selector) > 0 {
+				r.declarations = declarations
+				rules = append(rules, r)
+			}
+		}
+	}
+	return rules
+}
+
+// element in the stack of open elements while inlining styles.
+type element struct {
+	tag     string
+	classes []string
+}
+
+func (s simpleSelector) matches(e element) bool {
+	if s.tag != "" && s.tag != e.tag {
+		return false
+	}
+	if s.class == "" {
+		return true
+	}
+	for _, c := range e.classes {
+		if c == s.class {
+			return true
+		}
+	}
+	return false
+}
+
+// matches the element, with its ancestors from the closest one out.
+func (r styleRule) matches(e element, ancestors []element) bool {
+	if !r.selector[0].matches(e) {
+		return false
+	}
+	rest := r.selector[1:]
+	for i := len(ancestors) - 1; i >= 0 && len(rest) > 0; i-- {
+		if rest[0].matches(ancestors[i]) {
+			rest = rest[1:]
+		}
+	}
+	return len(rest) == 0
+}
+
+// inlineStyles into the style attributes of the elements in the HTML, in the order of the stylesheet,
+// so later rules override earlier ones, and the style attributes already there override them all.
+// Everything but the styled start tags is left exactly as it is.
+func inlineStyles(s string) (string, error) {
+	var b strings.Builder
+	var open []element
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return b.String(), nil
+			}
+			return "", z.Err()
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].tag == string(name) {
+					open = open[:i]
+					break
+				}
+			}
+			b.Write(z.Raw())
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+		default:
+			b.Write(z.Raw())
+			continue
+		}
+
+		raw := string(z.Raw())
+		token := z.Token()
+		e := element{tag: token.Data}
+		styleIndex := -1
+		for i, a := range token.Attr {
+			switch a.Key {
+			case "class":
+				e.classes = strings.Fields(a.Val)
+			case "style":
+				styleIndex = i
+			}
+		}
+
+		var declarations []string
+		for _, r := range styles {
+			if r.matches(e, open) {
+				declarations = append(declarations, r.declarations)
+			}
+		}
+		if tt == html.StartTagToken && !isVoid(token.DataAtom) {
+			open = append(open, e)
+		}
+		if len(declarations) == 0 {
+			b.WriteString(raw)
+			continue
+		}
+
+		style := strings.Join(declarations, " ")
+		if styleIndex >= 0 {
+			token.Attr[styleIndex].Val = style + " " + token.Attr[styleIndex].Val
+		} else {
+			token.Attr = append(token.Attr, html.Attribute{Key: "style", Val: style})
+		}
+		b.WriteString(token.String())
+	}
+}
+
+// isVoid elements, which have no end tag.
+func isVoid(a atom.Atom) bool {
+	switch a {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img, atom.Input, atom.Link, atom.Meta,
+		atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+	return false
+}
+
+// htmlToText derives the plain-text part from the HTML part. Blocks become paragraphs, list items are bulleted,
+// and links have their URL after the link text, unless it's the same. The head and images are left out.
+func htmlToText(s string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return "", err
+	}
+	var w textWriter
+	w.node(doc)
+	return w.b.String() + "\n", nil
+}
+
+// textWriter writes text from HTML nodes, collapsing whitespace and keeping track of pending line breaks,
+// so there's never more than one empty line between blocks, and none at the start.
+type textWriter struct {
+	b     strings.Builder
+	lines int
+	space bool
+}
+
+// breakLines before the next text, n = 1 for a new line and n = 2 for a new paragraph.
+func (w *textWriter) breakLines(n int) {
+	if w.b.Len() > 0 && n > w.lines {
+		w.lines = n
+	}
+	w.space = false
+}
+
+// write text with its whitespace collapsed.
+func (w *textWriter) write(s string) {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	if strings.TrimLeft(s, " \t\r\n\f") != s {
+		w.space = true
+	}
+	for _, word := range words {
+		w.writeRaw(word)
+		w.space = true
+	}
+	w.space = strings.TrimRight(s, " \t\r\n\f") != s
+}
+
+// writeRaw text as it is, after any pending line breaks or space.
+func (w *textWriter) writeRaw(s string) {
+	switch {
+	case w.lines > 0:
+		w.b.WriteString(strings.Repeat("\n", w.lines))
+		w.lines = 0
+	case w.space && w.b.Len() > 0:
+		w.b.WriteString(" ")
+	}
+	w.space = false
+	w.b.WriteString(s)
+}
+
+func (w *textWriter) node(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.write(n.Data)
+		return
+	case html.ElementNode:
+	default:
+		w.children(n)
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Img, atom.Script, atom.Style:
+	case atom.Br:
+		w.breakLines(1)
+	case atom.Li:
+		w.breakLines(1)
+		w.writeRaw("- ")
+		w.children(n)
+		w.breakLines(1)
+	case atom.Tr:
+		w.breakLines(1)
+		w.children(n)
+		w.breakLines(1)
+	case atom.Pre:
+		w.breakLines(2)
+		var b strings.Builder
+		collectText(&b, n)
+		w.writeRaw(strings.TrimRight(b.String(), "\n"))
+		w.breakLines(2)
+	case atom.A:
+		start := w.b.Len()
+		w.children(n)
+		text := strings.TrimSpace(w.b.String()[start:])
+		href := attr(n, "href")
+		if href != "" && href != text && strings.TrimPrefix(href, "mailto:") != text {
+			w.space = true
+			w.writeRaw("(" + href + ")")
+		}
+	case atom.P, atom.Div, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Ul, atom.Ol, atom.Blockquote,
+		atom.Table, atom.Hr:
+		w.breakLines(2)
+		w.children(n)
+		w.breakLines(2)
+	default:
+		w.children(n)
+	}
+}
+
+func (w *textWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.node(c)
+	}
+}
+
+// collectText of the node and its descendants, as it is.
+func collectText(b *strings.Builder, n *html.Node) {
+	if n.Type == html.TextNode {
+		b.WriteString(n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		collectText(b, c)
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
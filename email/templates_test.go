@@ -0,0 +1,85 @@
+package email_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/views/viewstest"
+)
+
+// matchGolden of both parts of the message with testdata/name.html and testdata/name.txt.
+func matchGolden(t *testing.T, name string, m email.Message) {
+	t.Helper()
+
+	viewstest.MatchGoldenHTML(t, name, m.HTML)
+	viewstest.MatchFile(t, "testdata/"+name+".txt", m.Text)
+}
+
+func TestTemplates(t *testing.T) {
+	issue := model.Newsletter{
+		ID:    1,
+		Slug:  "issue-1",
+		Title: "Issue 1",
+		Body: "Hello, *you*.\n\n## Links\n\n- [The archive](https://example.com/archive)\n- https://example.com\n\n" +
+			"Some code:\n\n```\nfmt.Println(\"hi\")\n```",
+	}
+	secret := []byte("secret")
+
+	for _, locale := range i18n.Default().Locales() {
+		tr := i18n.Default().Translator(locale)
+
+		t.Run("confirm in "+locale, func(t *testing.T) {
+			m, err := email.ConfirmationEmail(tr, "canvas@example.com", address, "me@example.com", "https://example.com", "123")
+			if err != nil {
+				t.Fatal(err)
+			}
+			matchGolden(t, "confirm-"+locale, m)
+		})
+
+		t.Run("welcome in "+locale, func(t *testing.T) {
+			m, err := email.WelcomeEmail(tr, "canvas@example.com", address, "me@example.com", &issue, "https://example.com", secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matchGolden(t, "welcome-"+locale, m)
+		})
+
+		t.Run("newsletter in "+locale, func(t *testing.T) {
+			m, err := email.NewsletterEmail(tr, "canvas@example.com", address, "me@example.com", issue, "https://example.com", secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matchGolden(t, "newsletter-"+locale, m)
+		})
+	}
+
+	t.Run("escapes the merge fields", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.NewsletterEmail(english, "canvas@example.com", `<b>Street</b> "1"`, `"><script>alert(1)</script>@example.com`,
+			model.Newsletter{ID: 1, Title: `</title><script>alert(1)</script>`}, "https://example.com", secret)
+		is.NoErr(err)
+		is.True(!strings.Contains(m.HTML, "<script"))
+		is.True(!strings.Contains(m.HTML, "<b>"))
+		is.True(strings.Contains(m.HTML, "&lt;/title&gt;&lt;script&gt;"))
+		is.True(strings.Contains(m.Text, `<b>Street</b> "1"`))
+	})
+
+	t.Run("inlines the styles, and keeps the ones already there", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.NewsletterEmail(english, "canvas@example.com", address, "me@example.com",
+			model.Newsletter{ID: 1, Title: "Issue 1", Body: "[A link](https://example.com)"}, "https://example.com", secret)
+		is.NoErr(err)
+		is.True(!strings.Contains(m.HTML, "<style"))
+		is.True(strings.Contains(m.HTML, `<body style="margin: 0;`))
+		is.True(strings.Contains(m.HTML, `style="color: #4f46e5;">A link</a>`))
+		// Links in the footer get both the general and the footer style, in that order.
+		is.True(strings.Contains(m.HTML, `style="color: #4f46e5; color: #71717a;">Unsubscribe</a>`))
+	})
+}
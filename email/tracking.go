@@ -0,0 +1,198 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidOpenToken is returned for open tracking tokens that are malformed or have a bad signature.
+	ErrInvalidOpenToken = errors.New("invalid open token")
+	// ErrInvalidClickToken is returned for click tracking tokens that are malformed or have a bad signature.
+	ErrInvalidClickToken = errors.New("invalid click token")
+	// ErrInvalidClickDestination is returned for click tracking destinations that aren't absolute http or https URLs.
+	ErrInvalidClickDestination = errors.New("invalid click destination")
+)
+
+// CreateOpenToken for the open tracking pixel of the newsletter issue sent to the subscriber,
+// signed with secret so opens can't be forged. The token is URL-safe and has the form <ids>.<signature>,
+// both base64-encoded.
+func CreateOpenToken(secret []byte, newsletterID, subscriberID int64) string {
+	ids := strconv.FormatInt(newsletterID, 10) + ":" + strconv.FormatInt(subscriberID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(ids)) + "." + base64.RawURLEncoding.EncodeToString(signOpen(secret, ids))
+}
+
+// VerifyOpenToken created with CreateOpenToken, returning the newsletter and subscriber IDs it's for.
+// Returns ErrInvalidOpenToken if the token is malformed or has been tampered with, and for every token without a secret,
+// since anyone could sign those.
+func VerifyOpenToken(secret []byte, token string) (newsletterID, subscriberID int64, err error) {
+	if len(secret) == 0 {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	encodedIDs, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	idsBytes, err := base64.RawURLEncoding.DecodeString(encodedIDs)
+	if err != nil {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	ids := string(idsBytes)
+	if !hmac.Equal(signatureBytes, signOpen(secret, ids)) {
+		return 0, 0, ErrInvalidOpenToken
+	}
+
+	newsletter, subscriber, ok := strings.Cut(ids, ":")
+	if !ok {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	if newsletterID, err = strconv.ParseInt(newsletter, 10, 64); err != nil {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	if subscriberID, err = strconv.ParseInt(subscriber, 10, 64); err != nil {
+		return 0, 0, ErrInvalidOpenToken
+	}
+	return newsletterID, subscriberID, nil
+}
+
+// signOpen with a purpose prefix, like signUnsubscribe.
+func signOpen(secret []byte, ids string) []byte {
+	return signTracking(secret, "open:"+ids)
+}
+
+// CreateClickToken for a tracked link to the destination in the newsletter issue sent to the subscriber,
+// signed with secret so the destination can't be changed to redirect anywhere else.
+// Returns ErrInvalidClickDestination if the destination isn't an absolute http or https URL.
+// The token is URL-safe and has the form <ids and destination>.<signature>, both base64-encoded.
+func CreateClickToken(secret []byte, newsletterID, subscriberID int64, destination string) (string, error) {
+	if !isClickDestination(destination) {
+		return "", ErrInvalidClickDestination
+	}
+	payload := strconv.FormatInt(newsletterID, 10) + ":" + strconv.FormatInt(subscriberID, 10) + ":" + destination
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signTracking(secret, "click:"+payload)), nil
+}
+
+// VerifyClickToken created with CreateClickToken, returning the newsletter and subscriber IDs and the destination it's for.
+// Returns ErrInvalidClickToken if the token is malformed or has been tampered with, and for every token without a secret,
+// since anyone could sign those and redirect anywhere.
+func VerifyClickToken(secret []byte, token string) (newsletterID, subscriberID int64, destination string, err error) {
+	if len(secret) == 0 {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	encodedPayload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	payload := string(payloadBytes)
+	if !hmac.Equal(signatureBytes, signTracking(secret, "click:"+payload)) {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+
+	newsletter, rest, ok := strings.Cut(payload, ":")
+	if !ok {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	subscriber, destination, ok := strings.Cut(rest, ":")
+	if !ok || !isClickDestination(destination) {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	if newsletterID, err = strconv.ParseInt(newsletter, 10, 64); err != nil {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	if subscriberID, err = strconv.ParseInt(subscriber, 10, 64); err != nil {
+		return 0, 0, "", ErrInvalidClickToken
+	}
+	return newsletterID, subscriberID, destination, nil
+}
+
+// isClickDestination is true for absolute http and https URLs, so tracked links can't be javascript: URLs and the like.
+func isClickDestination(destination string) bool {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func signTracking(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// linkPattern matches the href of links in HTML, which is always double-quoted in the sanitized newsletter HTML.
+var linkPattern = regexp.MustCompile(`(<a\s[^>]*?href=")([^"]*)(")`)
+
+// WithClickTracking rewrites the http and https links in the HTML part of the message to go through
+// /t/click/<token> under baseURL, with tokens for the newsletter issue and subscriber signed with secret.
+// Links to the unsubscribe page aren't rewritten, so unsubscribing never depends on tracking.
+// Other links, like mailto: links, and the text part are left as is.
+func WithClickTracking(m Message, baseURL string, secret []byte, newsletterID, subscriberID int64) (Message, error) {
+	clickURL, err := url.Parse(baseURL)
+	if err != nil {
+		return Message{}, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	if clickURL.Scheme == "" || clickURL.Host == "" {
+		return Message{}, fmt.Errorf("base URL %q must be absolute", baseURL)
+	}
+	basePath := strings.TrimSuffix(clickURL.Path, "/")
+	unsubscribePath := basePath + "/newsletter/unsubscribe"
+
+	m.HTML = linkPattern.ReplaceAllStringFunc(m.HTML, func(link string) string {
+		parts := linkPattern.FindStringSubmatch(link)
+		destination := html.UnescapeString(parts[2])
+		if u, err := url.Parse(destination); err == nil && u.Host == clickURL.Host && strings.HasPrefix(u.Path, unsubscribePath) {
+			return link
+		}
+		token, err := CreateClickToken(secret, newsletterID, subscriberID, destination)
+		if err != nil {
+			return link
+		}
+		tracked := *clickURL
+		tracked.Path = basePath + "/t/click/" + token
+		return parts[1] + html.EscapeString(tracked.String()) + parts[3]
+	})
+	return m, nil
+}
+
+// WithOpenTracking adds the open tracking pixel with the token to the end of the body of the HTML part of the message.
+// The pixel is at /t/open/<token>.gif under baseURL. The text part can't track opens, so it's left as is.
+func WithOpenTracking(m Message, baseURL, token string) (Message, error) {
+	pixelURL, err := url.Parse(baseURL)
+	if err != nil {
+		return Message{}, fmt.Errorf("error parsing base URL: %w", err)
+	}
+	if pixelURL.Scheme == "" || pixelURL.Host == "" {
+		return Message{}, fmt.Errorf("base URL %q must be absolute", baseURL)
+	}
+	pixelURL.Path = strings.TrimSuffix(pixelURL.Path, "/") + "/t/open/" + token + ".gif"
+
+	pixel := `<img src="` + html.EscapeString(pixelURL.String()) + `" width="1" height="1" alt="" style="display:block;border:0">`
+	if i := strings.LastIndex(m.HTML, "</body>"); i >= 0 {
+		m.HTML = m.HTML[:i] + pixel + m.HTML[i:]
+	} else {
+		m.HTML += pixel
+	}
+	return m, nil
+}
@@ -0,0 +1,182 @@
+package email_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+)
+
+func TestVerifyOpenToken(t *testing.T) {
+	secret := []byte("secret")
+
+	t.Run("returns the newsletter and subscriber IDs of a token created with the same secret", func(t *testing.T) {
+		is := is.New(t)
+
+		token := email.CreateOpenToken(secret, 12, 345)
+		newsletterID, subscriberID, err := email.VerifyOpenToken(secret, token)
+		is.NoErr(err)
+		is.Equal(int64(12), newsletterID)
+		is.Equal(int64(345), subscriberID)
+	})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"rejects a token signed with another secret", email.CreateOpenToken([]byte("other"), 12, 345)},
+		// "12:346" with the signature of "12:345".
+		{"rejects a token for another subscriber", "MTI6MzQ2." + signature(email.CreateOpenToken(secret, 12, 345))},
+		{"rejects a token with a tampered signature", tamper(email.CreateOpenToken(secret, 12, 345))},
+		{"rejects a token without a signature", "MTI6MzQ1"},
+		{"rejects a token that isn't base64", "not base64.not base64"},
+		{"rejects an empty token", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, _, err := email.VerifyOpenToken(secret, test.token)
+			is.True(errors.Is(err, email.ErrInvalidOpenToken))
+		})
+	}
+
+	t.Run("rejects every token without a secret, even one signed without it", func(t *testing.T) {
+		is := is.New(t)
+
+		_, _, err := email.VerifyOpenToken(nil, email.CreateOpenToken(nil, 12, 345))
+		is.True(errors.Is(err, email.ErrInvalidOpenToken))
+	})
+}
+
+func TestWithOpenTracking(t *testing.T) {
+	t.Run("adds the pixel to the end of the HTML, but not the text", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.WithOpenTracking(email.Message{HTML: "<p>Hi</p>", Text: "Hi"}, "https://example.com/", "abc.def")
+		is.NoErr(err)
+		is.Equal(`<p>Hi</p><img src="https://example.com/t/open/abc.def.gif" width="1" height="1" alt="" style="display:block;border:0">`, m.HTML)
+		is.Equal("Hi", m.Text)
+	})
+
+	t.Run("adds the pixel to the end of the body of an HTML document", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.WithOpenTracking(email.Message{HTML: "<html><body><p>Hi</p></body></html>"}, "https://example.com/", "abc.def")
+		is.NoErr(err)
+		is.Equal(`<html><body><p>Hi</p><img src="https://example.com/t/open/abc.def.gif" width="1" height="1" alt="" style="display:block;border:0"></body></html>`, m.HTML)
+	})
+
+	t.Run("errors on a relative base URL", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.WithOpenTracking(email.Message{}, "/", "abc.def")
+		is.True(err != nil)
+	})
+}
+
+// tamper with the last character of the token.
+func tamper(token string) string {
+	last := byte('A')
+	if token[len(token)-1] == last {
+		last = 'B'
+	}
+	return token[:len(token)-1] + string(last)
+}
+
+func TestCreateClickToken(t *testing.T) {
+	secret := []byte("secret")
+
+	t.Run("returns the newsletter and subscriber IDs and destination of a verified token", func(t *testing.T) {
+		is := is.New(t)
+
+		token, err := email.CreateClickToken(secret, 12, 345, "https://example.com/a:b?c=d")
+		is.NoErr(err)
+		newsletterID, subscriberID, destination, err := email.VerifyClickToken(secret, token)
+		is.NoErr(err)
+		is.Equal(int64(12), newsletterID)
+		is.Equal(int64(345), subscriberID)
+		is.Equal("https://example.com/a:b?c=d", destination)
+	})
+
+	for _, destination := range []string{"javascript:alert(1)", "data:text/html,hi", "mailto:me@example.com", "//example.com", "/relative", ""} {
+		t.Run("rejects the destination "+destination, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := email.CreateClickToken(secret, 12, 345, destination)
+			is.True(errors.Is(err, email.ErrInvalidClickDestination))
+		})
+	}
+}
+
+func TestVerifyClickToken(t *testing.T) {
+	secret := []byte("secret")
+	token, err := email.CreateClickToken(secret, 12, 345, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := email.CreateClickToken([]byte("other"), 12, 345, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"rejects a token signed with another secret", otherToken},
+		// "12:345:https://evil.example.com" with the signature of the real token.
+		{"rejects a token for another destination", "MTI6MzQ1Omh0dHBzOi8vZXZpbC5leGFtcGxlLmNvbQ." + signature(token)},
+		{"rejects a token with a tampered signature", tamper(token)},
+		{"rejects a token without a signature", "MTI6MzQ1Omh0dHBzOi8vZXhhbXBsZS5jb20"},
+		{"rejects a token that isn't base64", "not base64.not base64"},
+		{"rejects an empty token", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, _, _, err := email.VerifyClickToken(secret, test.token)
+			is.True(errors.Is(err, email.ErrInvalidClickToken))
+		})
+	}
+
+	t.Run("rejects every token without a secret, even one signed without it", func(t *testing.T) {
+		is := is.New(t)
+
+		token, err := email.CreateClickToken(nil, 12, 345, "https://evil.example.com")
+		is.NoErr(err)
+		_, _, _, err = email.VerifyClickToken(nil, token)
+		is.True(errors.Is(err, email.ErrInvalidClickToken))
+	})
+}
+
+func TestWithClickTracking(t *testing.T) {
+	secret := []byte("secret")
+
+	t.Run("rewrites http and https links, but not unsubscribe or other links, or the text", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := email.WithClickTracking(email.Message{
+			HTML: `<p><a href="https://example.org/?a=1&amp;b=2">One</a> <a href="mailto:me@example.com">Two</a></p>` +
+				`<p><a href="https://example.com/newsletter/unsubscribe?token=abc">Unsubscribe</a></p>`,
+			Text: "https://example.org/?a=1&b=2",
+		}, "https://example.com", secret, 12, 345)
+		is.NoErr(err)
+
+		token, err := email.CreateClickToken(secret, 12, 345, "https://example.org/?a=1&b=2")
+		is.NoErr(err)
+		is.Equal(`<p><a href="https://example.com/t/click/`+token+`">One</a> <a href="mailto:me@example.com">Two</a></p>`+
+			`<p><a href="https://example.com/newsletter/unsubscribe?token=abc">Unsubscribe</a></p>`, m.HTML)
+		is.Equal("https://example.org/?a=1&b=2", m.Text)
+	})
+
+	t.Run("errors on a relative base URL", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := email.WithClickTracking(email.Message{}, "/", secret, 12, 345)
+		is.True(err != nil)
+	})
+}
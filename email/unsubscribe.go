@@ -0,0 +1,74 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"canvas/model"
+)
+
+// ErrInvalidUnsubscribeToken is returned for unsubscribe tokens that are malformed or have a bad signature.
+var ErrInvalidUnsubscribeToken = errors.New("invalid unsubscribe token")
+
+// CreateUnsubscribeToken for the email address, signed with secret so it can't be forged.
+// The token is URL-safe and has the form <address>.<signature>, both base64-encoded.
+func CreateUnsubscribeToken(secret []byte, to model.Email) string {
+	address := base64.RawURLEncoding.EncodeToString([]byte(to))
+	signature := base64.RawURLEncoding.EncodeToString(signUnsubscribe(secret, to))
+	return address + "." + signature
+}
+
+// VerifyUnsubscribeToken created with CreateUnsubscribeToken, returning the email address it's for.
+// Returns ErrInvalidUnsubscribeToken if the token is malformed or has been tampered with.
+func VerifyUnsubscribeToken(secret []byte, token string) (model.Email, error) {
+	address, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidUnsubscribeToken
+	}
+
+	addressBytes, err := base64.RawURLEncoding.DecodeString(address)
+	if err != nil {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", ErrInvalidUnsubscribeToken
+	}
+
+	to := model.Email(addressBytes)
+	if !hmac.Equal(signatureBytes, signUnsubscribe(secret, to)) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	return to, nil
+}
+
+// signUnsubscribe with a purpose prefix, so the signature can't be reused for anything else signed with the same secret.
+func signUnsubscribe(secret []byte, to model.Email) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("unsubscribe:" + to))
+	return mac.Sum(nil)
+}
+
+// WithUnsubscribeMailto adds a mailto link to the address to the List-Unsubscribe header of the message,
+// before its https link, for mail clients that only unsubscribe by email. The subject of the email has the token,
+// like "unsubscribe <token>", so the mailbox can verify it with VerifyUnsubscribeToken.
+// Messages without a List-Unsubscribe header are returned as they are.
+func WithUnsubscribeMailto(m Message, address, token string) Message {
+	existing, ok := m.Headers["List-Unsubscribe"]
+	if !ok {
+		return m
+	}
+	mailto := url.URL{Scheme: "mailto", Opaque: address, RawQuery: "subject=" + url.PathEscape("unsubscribe "+token)}
+
+	headers := make(map[string]string, len(m.Headers))
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers["List-Unsubscribe"] = "<" + mailto.String() + ">, " + existing
+	m.Headers = headers
+	return m
+}
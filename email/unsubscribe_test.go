@@ -0,0 +1,70 @@
+package email_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+)
+
+func TestVerifyUnsubscribeToken(t *testing.T) {
+	secret := []byte("secret")
+
+	t.Run("returns the email address of a token created with the same secret", func(t *testing.T) {
+		is := is.New(t)
+
+		token := email.CreateUnsubscribeToken(secret, "me@example.com")
+		to, err := email.VerifyUnsubscribeToken(secret, token)
+		is.NoErr(err)
+		is.Equal("me@example.com", to.String())
+	})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"rejects a token signed with another secret", email.CreateUnsubscribeToken([]byte("other"), "me@example.com")},
+		{"rejects a token for another address", "eW91QGV4YW1wbGUuY29t." + signature(email.CreateUnsubscribeToken(secret, "me@example.com"))},
+		{"rejects a token without a signature", "bWVAZXhhbXBsZS5jb20"},
+		{"rejects a token that isn't base64", "not base64.not base64"},
+		{"rejects an empty token", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := email.VerifyUnsubscribeToken(secret, test.token)
+			is.True(errors.Is(err, email.ErrInvalidUnsubscribeToken))
+		})
+	}
+}
+
+func signature(token string) string {
+	for i := range token {
+		if token[i] == '.' {
+			return token[i+1:]
+		}
+	}
+	return ""
+}
+
+func TestWithUnsubscribeMailto(t *testing.T) {
+	t.Run("adds the mailto link with the token before the one-click link, without changing the original", func(t *testing.T) {
+		is := is.New(t)
+
+		m := email.Message{Headers: map[string]string{"List-Unsubscribe": "<https://example.com/newsletter/unsubscribe/one-click?token=abc.def>"}}
+		withMailto := email.WithUnsubscribeMailto(m, "unsubscribe@example.com", "abc.def")
+		is.Equal("<mailto:unsubscribe@example.com?subject=unsubscribe%20abc.def>, <https://example.com/newsletter/unsubscribe/one-click?token=abc.def>",
+			withMailto.Headers["List-Unsubscribe"])
+		is.Equal("<https://example.com/newsletter/unsubscribe/one-click?token=abc.def>", m.Headers["List-Unsubscribe"])
+	})
+
+	t.Run("leaves messages without unsubscribe headers as they are", func(t *testing.T) {
+		is := is.New(t)
+
+		m := email.WithUnsubscribeMailto(email.Message{}, "unsubscribe@example.com", "abc.def")
+		is.Equal(0, len(m.Headers))
+	})
+}
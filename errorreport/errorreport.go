@@ -0,0 +1,284 @@
+// Package errorreport sends errors and panics to Sentry, or a service compatible with its store API, like GlitchTip.
+//
+// A nil *Reporter is a no-op, for when there's no DSN, and reports on it don't allocate,
+// so reporting can be left in hot paths.
+package errorreport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.uber.org/zap"
+)
+
+// Event for Sentry, in the format of its store API.
+type Event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Exception   Exceptions        `json:"exception"`
+	Request     *Request          `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+// Exceptions of an Event, from the innermost wrapped error to the outermost, like Sentry expects for chains.
+type Exceptions struct {
+	Values []Exception `json:"values"`
+}
+
+// Exception in an Event, with the Go type of the error and its message.
+type Exception struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Request in an Event, with only the path of the URL and no headers or body,
+// so it doesn't have tokens from query strings, cookies, or form values.
+type Request struct {
+	URL    string `json:"url"`
+	Method string `json:"method"`
+}
+
+// PanicError is a recovered panic, to report like an error.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// Transport sends events. Tests can use their own to see what's reported.
+type Transport interface {
+	SendEvent(ctx context.Context, e Event) error
+}
+
+// Reporter of errors, which sends events in the background with its Transport.
+// Events of the same class, which is the route or job and the type of the innermost error,
+// are sampled after a burst each minute, so a failure on a busy route doesn't flood the service.
+type Reporter struct {
+	burst       int
+	classes     map[string]*class
+	environment string
+	events      chan Event
+	log         *zap.Logger
+	lock        sync.Mutex
+	now         func() time.Time
+	pending     sync.WaitGroup
+	release     string
+	sampleEvery int
+	transport   Transport
+}
+
+// class of events, counted in the current minute.
+type class struct {
+	window time.Time
+	count  int
+}
+
+// NewReporterOptions for NewReporter.
+type NewReporterOptions struct {
+	// Burst of events of a class sent each minute before sampling starts. Defaults to 10.
+	Burst int
+	// DSN of the Sentry project, like https://key@o1.ingest.sentry.io/2. Without it, NewReporter returns nil.
+	DSN         string
+	Environment string
+	Log         *zap.Logger
+	// Now is for sampling windows and event timestamps. Defaults to time.Now.
+	Now     func() time.Time
+	Release string
+	// SampleEvery is one in how many events of a class are sent after the burst. Defaults to 10.
+	SampleEvery int
+	// Transport overrides the HTTP transport to the store API of the DSN.
+	Transport Transport
+}
+
+// queueSize of events waiting to be sent. Events reported when it's full are dropped.
+const queueSize = 100
+
+// NewReporter for the DSN, or nil if there's no DSN, which is a no-op.
+// It sends events in a goroutine until the process exits, so call Flush before that.
+func NewReporter(opts NewReporterOptions) (*Reporter, error) {
+	if opts.DSN == "" {
+		return nil, nil
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Burst <= 0 {
+		opts.Burst = 10
+	}
+	if opts.SampleEvery <= 0 {
+		opts.SampleEvery = 10
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Transport == nil {
+		t, err := NewHTTPTransport(opts.DSN, http.DefaultClient)
+		if err != nil {
+			return nil, err
+		}
+		opts.Transport = t
+	}
+
+	r := &Reporter{
+		burst:       opts.Burst,
+		classes:     map[string]*class{},
+		environment: opts.Environment,
+		events:      make(chan Event, queueSize),
+		log:         opts.Log,
+		now:         opts.Now,
+		release:     opts.Release,
+		sampleEvery: opts.SampleEvery,
+		transport:   opts.Transport,
+	}
+	go r.send()
+	return r, nil
+}
+
+// ReportRequest error, with the request ID, route, and method of the request, and the reference shown to the user.
+func (r *Reporter) ReportRequest(req *http.Request, reference string, err error) {
+	if r == nil {
+		return
+	}
+	route := req.URL.Path
+	if rc := chi.RouteContext(req.Context()); rc != nil && rc.RoutePattern() != "" {
+		route = rc.RoutePattern()
+	}
+	e := r.newEvent(err)
+	e.Request = &Request{URL: req.URL.Path, Method: req.Method}
+	e.Tags["route"] = route
+	e.Tags["method"] = req.Method
+	setTag(e.Tags, "requestID", middleware.GetReqID(req.Context()))
+	setTag(e.Tags, "reference", reference)
+	r.report(req.Method+" "+route, e)
+}
+
+// ReportJob error, with the job name and message ID, and the ID of the request that sent the message, if any.
+func (r *Reporter) ReportJob(ctx context.Context, job, messageID string, err error) {
+	if r == nil {
+		return
+	}
+	e := r.newEvent(err)
+	e.Tags["job"] = job
+	setTag(e.Tags, "messageID", messageID)
+	setTag(e.Tags, "requestID", middleware.GetReqID(ctx))
+	r.report("job "+job, e)
+}
+
+// Flush events waiting to be sent, until ctx is done.
+func (r *Reporter) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Reporter) newEvent(err error) Event {
+	e := Event{
+		EventID:     newEventID(),
+		Timestamp:   r.now().UTC(),
+		Level:       "error",
+		Platform:    "go",
+		Release:     r.release,
+		Environment: r.environment,
+		Tags:        map[string]string{},
+	}
+	var p *PanicError
+	if errors.As(err, &p) {
+		e.Level = "fatal"
+		e.Extra = map[string]string{"stack": string(p.Stack)}
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		e.Exception.Values = append([]Exception{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}, e.Exception.Values...)
+	}
+	return e
+}
+
+// report the event, if it's sampled, and there's room in the queue.
+func (r *Reporter) report(source string, e Event) {
+	innermost := "<nil>"
+	if len(e.Exception.Values) > 0 {
+		innermost = e.Exception.Values[0].Type
+	}
+	if !r.sample(source + " " + innermost) {
+		return
+	}
+	r.pending.Add(1)
+	select {
+	case r.events <- e:
+	default:
+		r.pending.Done()
+		r.log.Info("Dropped error report, too many waiting to be sent", zap.String("eventID", e.EventID))
+	}
+}
+
+// sample the event of the class: the first burst in each minute, then one in every sampleEvery.
+func (r *Reporter) sample(name string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	window := r.now().Truncate(time.Minute)
+	c, ok := r.classes[name]
+	if !ok || !c.window.Equal(window) {
+		// All classes are forgotten when there are many, so the map doesn't grow forever.
+		if !ok && len(r.classes) >= 1000 {
+			r.classes = map[string]*class{}
+		}
+		c = &class{window: window}
+		r.classes[name] = c
+	}
+	c.count++
+	return c.count <= r.burst || (c.count-r.burst)%r.sampleEvery == 0
+}
+
+// send events from the queue with the transport.
+func (r *Reporter) send() {
+	for e := range r.events {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := r.transport.SendEvent(ctx, e); err != nil {
+			r.log.Info("Error sending error report", zap.Error(err), zap.String("eventID", e.EventID))
+		}
+		cancel()
+		r.pending.Done()
+	}
+}
+
+func setTag(tags map[string]string, k, v string) {
+	if v != "" {
+		tags[k] = v
+	}
+}
+
+// newEventID of 32 hex characters, like a UUID without dashes.
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000000000000000000000000000"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,255 @@
+package errorreport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+
+	"canvas/errorreport"
+)
+
+// transportMock records the events sent.
+type transportMock struct {
+	lock   sync.Mutex
+	events []errorreport.Event
+}
+
+func (t *transportMock) SendEvent(ctx context.Context, e errorreport.Event) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, e)
+	return nil
+}
+
+func (t *transportMock) Events() []errorreport.Event {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.events
+}
+
+func newReporter(t *testing.T, now func() time.Time) (*errorreport.Reporter, *transportMock) {
+	t.Helper()
+	transport := &transportMock{}
+	r, err := errorreport.NewReporter(errorreport.NewReporterOptions{
+		DSN:         "https://key@sentry.example.com/1",
+		Environment: "test",
+		Now:         now,
+		Release:     "v1.2.3",
+		Transport:   transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, transport
+}
+
+func flush(t *testing.T, r *errorreport.Reporter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReporter_ReportRequest(t *testing.T) {
+	t.Run("reports the error with the request ID, route, method, reference, and release", func(t *testing.T) {
+		is := is.New(t)
+
+		r, transport := newReporter(t, nil)
+
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID)
+		mux.Get("/newsletters/{id}", func(w http.ResponseWriter, req *http.Request) {
+			r.ReportRequest(req, "ABC234", fmt.Errorf("error getting newsletter: %w", errors.New("oh no")))
+		})
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/newsletters/1?token=secret", nil))
+		flush(t, r)
+
+		events := transport.Events()
+		is.Equal(1, len(events))
+		e := events[0]
+		is.Equal(32, len(e.EventID))
+		is.Equal("error", e.Level)
+		is.Equal("go", e.Platform)
+		is.Equal("v1.2.3", e.Release)
+		is.Equal("test", e.Environment)
+		is.Equal("/newsletters/{id}", e.Tags["route"])
+		is.Equal("GET", e.Tags["method"])
+		is.Equal("ABC234", e.Tags["reference"])
+		is.True(e.Tags["requestID"] != "")
+		is.Equal(&errorreport.Request{URL: "/newsletters/1", Method: "GET"}, e.Request)
+		is.Equal([]errorreport.Exception{
+			{Type: "*errors.errorString", Value: "oh no"},
+			{Type: "*fmt.wrapError", Value: "error getting newsletter: oh no"},
+		}, e.Exception.Values)
+	})
+
+	t.Run("reports panics as fatal, with the stack", func(t *testing.T) {
+		is := is.New(t)
+
+		r, transport := newReporter(t, nil)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.ReportRequest(req, "", &errorreport.PanicError{Value: "oh no", Stack: []byte("goroutine 1")})
+		flush(t, r)
+
+		e := transport.Events()[0]
+		is.Equal("fatal", e.Level)
+		is.Equal("goroutine 1", e.Extra["stack"])
+		is.Equal("panic: oh no", e.Exception.Values[0].Value)
+		_, ok := e.Tags["reference"]
+		is.True(!ok)
+	})
+}
+
+func TestReporter_ReportJob(t *testing.T) {
+	t.Run("reports the error with the job name, message ID, and request ID", func(t *testing.T) {
+		is := is.New(t)
+
+		r, transport := newReporter(t, nil)
+		ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+		r.ReportJob(ctx, "confirmation_email", "msg-1", errors.New("oh no"))
+		flush(t, r)
+
+		e := transport.Events()[0]
+		is.Equal(map[string]string{"job": "confirmation_email", "messageID": "msg-1", "requestID": "req-1"}, e.Tags)
+		is.True(e.Request == nil)
+	})
+}
+
+func TestReporter_sampling(t *testing.T) {
+	t.Run("sends a burst of each class per minute, then one in every ten", func(t *testing.T) {
+		is := is.New(t)
+
+		now := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+		r, transport := newReporter(t, func() time.Time { return now })
+		ctx := context.Background()
+
+		for i := 0; i < 30; i++ {
+			r.ReportJob(ctx, "a", "", errors.New("oh no"))
+		}
+		// Another class still gets its burst.
+		r.ReportJob(ctx, "b", "", errors.New("oh no"))
+		flush(t, r)
+		is.Equal(10+2+1, len(transport.Events()))
+
+		now = now.Add(time.Minute)
+		r.ReportJob(ctx, "a", "", errors.New("oh no"))
+		flush(t, r)
+		is.Equal(10+2+1+1, len(transport.Events()))
+	})
+}
+
+func TestReporter_nil(t *testing.T) {
+	t.Run("is returned without a DSN, and doesn't allocate when reporting", func(t *testing.T) {
+		is := is.New(t)
+
+		r, err := errorreport.NewReporter(errorreport.NewReporterOptions{})
+		is.NoErr(err)
+		is.True(r == nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		ctx := context.Background()
+		err = errors.New("oh no")
+		allocs := testing.AllocsPerRun(100, func() {
+			r.ReportRequest(req, "ABC234", err)
+			r.ReportJob(ctx, "job", "msg", err)
+		})
+		is.Equal(0.0, allocs)
+		is.NoErr(r.Flush(ctx))
+	})
+}
+
+func TestReporter_Flush(t *testing.T) {
+	t.Run("returns the context error if events aren't sent in time", func(t *testing.T) {
+		is := is.New(t)
+
+		release := make(chan struct{})
+		defer close(release)
+		r, err := errorreport.NewReporter(errorreport.NewReporterOptions{
+			DSN: "https://key@sentry.example.com/1",
+			Transport: transportFunc(func(ctx context.Context, e errorreport.Event) error {
+				<-release
+				return nil
+			}),
+		})
+		is.NoErr(err)
+		r.ReportJob(context.Background(), "job", "", errors.New("oh no"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		is.True(errors.Is(r.Flush(ctx), context.DeadlineExceeded))
+	})
+}
+
+type transportFunc func(ctx context.Context, e errorreport.Event) error
+
+func (f transportFunc) SendEvent(ctx context.Context, e errorreport.Event) error {
+	return f(ctx, e)
+}
+
+func TestHTTPTransport(t *testing.T) {
+	t.Run("posts the event to the store API of the project with the key", func(t *testing.T) {
+		is := is.New(t)
+
+		var e errorreport.Event
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			is.Equal(http.MethodPost, r.Method)
+			is.Equal("/sentry/api/42/store/", r.URL.Path)
+			is.Equal("Sentry sentry_version=7, sentry_client=canvas/1.0, sentry_key=public", r.Header.Get("X-Sentry-Auth"))
+			is.NoErr(json.NewDecoder(r.Body).Decode(&e))
+		}))
+		defer srv.Close()
+
+		transport, err := errorreport.NewHTTPTransport("http://public@"+srv.Listener.Addr().String()+"/sentry/42", srv.Client())
+		is.NoErr(err)
+		is.NoErr(transport.SendEvent(context.Background(), errorreport.Event{EventID: "abc", Level: "error"}))
+		is.Equal("abc", e.EventID)
+	})
+
+	t.Run("errors on error responses", func(t *testing.T) {
+		is := is.New(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer srv.Close()
+
+		transport, err := errorreport.NewHTTPTransport("http://public@"+srv.Listener.Addr().String()+"/1", srv.Client())
+		is.NoErr(err)
+		is.True(transport.SendEvent(context.Background(), errorreport.Event{}) != nil)
+	})
+}
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn, endpoint, key string
+	}{
+		{"https://abc@o1.ingest.sentry.io/2", "https://o1.ingest.sentry.io/api/2/store/", "abc"},
+		{"http://abc@localhost:9000/sentry/2/", "http://localhost:9000/sentry/api/2/store/", "abc"},
+		{"https://o1.ingest.sentry.io/2", "", ""},
+		{"https://abc@o1.ingest.sentry.io/", "", ""},
+		{"ftp://abc@example.com/2", "", ""},
+		{"nope", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.dsn, func(t *testing.T) {
+			is := is.New(t)
+
+			endpoint, key, err := errorreport.ParseDSN(test.dsn)
+			is.Equal(test.endpoint, endpoint)
+			is.Equal(test.key, key)
+			is.Equal(test.endpoint == "", err != nil)
+		})
+	}
+}
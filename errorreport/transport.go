@@ -0,0 +1,72 @@
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPTransport sends events to the store API of a Sentry project.
+type HTTPTransport struct {
+	client   *http.Client
+	endpoint string
+	key      string
+}
+
+// NewHTTPTransport for the project of the DSN, like https://key@o1.ingest.sentry.io/2.
+// Errors don't have the DSN, since its key is a credential.
+func NewHTTPTransport(dsn string, client *http.Client) (*HTTPTransport, error) {
+	endpoint, key, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPTransport{client: client, endpoint: endpoint, key: key}, nil
+}
+
+// ParseDSN into the URL of the store API of its project, and its public key.
+func ParseDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User == nil {
+		return "", "", fmt.Errorf("DSN must be like https://key@sentry.example.com/1")
+	}
+	key = u.User.Username()
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	project := path[i+1:]
+	if key == "" || project == "" {
+		return "", "", fmt.Errorf("DSN must have a key and a project, like https://key@sentry.example.com/1")
+	}
+	return fmt.Sprintf("%v://%v%v/api/%v/store/", u.Scheme, u.Host, path[:i], project), key, nil
+}
+
+// SendEvent to the store API.
+func (t *HTTPTransport) SendEvent(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=canvas/1.0, sentry_key="+t.key)
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("error reporting service responded with status %v", res.StatusCode)
+	}
+	return nil
+}
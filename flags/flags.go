@@ -0,0 +1,234 @@
+// Package flags has feature flags, for shipping features dark and turning them on per environment,
+// or for a percentage of subscribers.
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Names of the feature flags.
+const (
+	// Archive of published newsletter issues, at /archive.
+	Archive = "archive"
+	// TrackingPixel and tracked links in newsletter issue emails.
+	TrackingPixel = "tracking-pixel"
+)
+
+// known flags, which are off unless the environment or the file turns them on.
+var known = []string{Archive, TrackingPixel}
+
+// envPrefix of the environment variables of flags, like FEATURE_ARCHIVE.
+const envPrefix = "FEATURE_"
+
+// Provider of the states of feature flags.
+type Provider interface {
+	// Enabled is true if the flag with the name is on for the subject, like the email address of a subscriber.
+	// The subject can be empty, which only flags that are on for everyone are on for. Unknown flags are off.
+	Enabled(name, subject string) bool
+	// Flags that are known or have a state, sorted by name.
+	Flags() []Flag
+}
+
+// Source of the state of a flag.
+type Source string
+
+// Sources of states. Known flags that nothing turns on are off by default.
+const (
+	SourceDefault Source = "default"
+	SourceEnv     Source = "env"
+	SourceFile    Source = "file"
+)
+
+// Flag and its state.
+type Flag struct {
+	Name string
+	// Percentage of subjects the flag is on for, from 0 for off to 100 for on.
+	Percentage int
+	Source     Source
+}
+
+// On for everyone.
+func (f Flag) On() bool {
+	return f.Percentage >= 100
+}
+
+// enabled for the subject. Rollouts to a percentage are only on for the subjects in their bucket,
+// so subjects without one are left out.
+func (f Flag) enabled(subject string) bool {
+	switch {
+	case f.On():
+		return true
+	case f.Percentage <= 0 || subject == "":
+		return false
+	default:
+		return Bucket(f.Name, subject) < f.Percentage
+	}
+}
+
+// Bucket of the subject for the flag with the name, from 0 to 99. It's the same every time for the same flag
+// and subject, so a subject stays in a rollout as its percentage goes up. Each flag buckets subjects differently,
+// so the same subjects aren't always first.
+func Bucket(name, subject string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
+
+// NewStaticOptions for NewStatic.
+type NewStaticOptions struct {
+	// Environ with variables like os.Environ. Variables like FEATURE_ARCHIVE=true turn the flags fully on or off,
+	// with the names in upper case and dashes as underscores, like FEATURE_TRACKING_PIXEL for tracking-pixel.
+	Environ []string
+	// File with percentage rollouts by flag name, as JSON like {"tracking-pixel": 25}, if it's not empty.
+	// The environment wins over the file.
+	File string
+	Log  *zap.Logger
+}
+
+// Static flags, which are read once, from the environment and a file.
+type Static struct {
+	flags map[string]Flag
+	log   *zap.Logger
+	lock  sync.Mutex
+	// unknown flags that have been asked for, which are logged once.
+	unknown map[string]bool
+}
+
+// NewStatic flags from the environment and the file in opts. Values that can't be read are errors,
+// so a typo doesn't quietly leave a feature off.
+func NewStatic(opts NewStaticOptions) (*Static, error) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	s := &Static{flags: map[string]Flag{}, log: opts.Log, unknown: map[string]bool{}}
+	for _, name := range known {
+		s.flags[name] = Flag{Name: name, Source: SourceDefault}
+	}
+
+	if opts.File != "" {
+		if err := s.loadFile(opts.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range opts.Environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, envPrefix) || k == envPrefix {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v must be true or false, not %q", k, v)
+		}
+		name := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(k, envPrefix)), "_", "-")
+		f := Flag{Name: name, Source: SourceEnv}
+		if on {
+			f.Percentage = 100
+		}
+		s.flags[name] = f
+	}
+	return s, nil
+}
+
+// loadFile of percentage rollouts.
+func (s *Static) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("feature flags file %v doesn't exist", path)
+		}
+		return fmt.Errorf("error reading feature flags file %v: %w", path, err)
+	}
+	var percentages map[string]int
+	if err := json.Unmarshal(b, &percentages); err != nil {
+		return fmt.Errorf("feature flags file %v must be JSON like {\"archive\": 25}: %w", path, err)
+	}
+	for name, p := range percentages {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("feature flag %v in %v must be a percentage from 0 to 100, not %v", name, path, p)
+		}
+		s.flags[name] = Flag{Name: name, Percentage: p, Source: SourceFile}
+	}
+	return nil
+}
+
+// Enabled satisfies Provider. The first time an unknown flag is asked for, it's logged, since it's probably a typo.
+func (s *Static) Enabled(name, subject string) bool {
+	f, ok := s.flags[name]
+	if !ok {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if !s.unknown[name] {
+			s.unknown[name] = true
+			s.log.Warn("Unknown feature flag is off", zap.String("name", name))
+		}
+		return false
+	}
+	return f.enabled(subject)
+}
+
+// Flags satisfies Provider.
+func (s *Static) Flags() []Flag {
+	flags := make([]Flag, 0, len(s.flags))
+	for _, f := range s.flags {
+		flags = append(flags, f)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags
+}
+
+type contextKey int
+
+const (
+	providerKey contextKey = iota
+	subjectKey
+)
+
+// WithProvider of flags in the context, for Enabled.
+func WithProvider(ctx context.Context, p Provider) context.Context {
+	return context.WithValue(ctx, providerKey, p)
+}
+
+// WithSubject in the context that flags are checked for with Enabled, like the email address of a subscriber.
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+// Checker of whether a flag is on.
+type Checker func(name string) bool
+
+// FromContext gets the Checker of flags for the provider and subject in the context,
+// or nil if there's no provider.
+func FromContext(ctx context.Context) Checker {
+	p, ok := ctx.Value(providerKey).(Provider)
+	if !ok || p == nil {
+		return nil
+	}
+	subject, _ := ctx.Value(subjectKey).(string)
+	return func(name string) bool {
+		return p.Enabled(name, subject)
+	}
+}
+
+// Enabled is true if the flag with the name is on for the provider and subject in the context.
+// Without a provider, all flags are off.
+func Enabled(ctx context.Context, name string) bool {
+	c := FromContext(ctx)
+	return c != nil && c(name)
+}
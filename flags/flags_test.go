@@ -0,0 +1,177 @@
+package flags_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/flags"
+)
+
+// writeFlagsFile with the content, returning its path.
+func writeFlagsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "flags.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBucket(t *testing.T) {
+	t.Run("is the same every time for the same flag and subject", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(flags.Bucket("archive", "me@example.com"), flags.Bucket("archive", "me@example.com"))
+		// Pinned, so changing the hash, which would move subjects in and out of rollouts, fails.
+		is.Equal(60, flags.Bucket("tracking-pixel", "me@example.com"))
+	})
+
+	t.Run("is from 0 to 99, and spreads subjects out", func(t *testing.T) {
+		is := is.New(t)
+
+		var under50 int
+		for i := 0; i < 1000; i++ {
+			b := flags.Bucket("archive", fmt.Sprintf("%v@example.com", i))
+			is.True(b >= 0 && b < 100)
+			if b < 50 {
+				under50++
+			}
+		}
+		is.True(under50 > 400 && under50 < 600)
+	})
+}
+
+func TestStatic(t *testing.T) {
+	t.Run("has known flags off by default", func(t *testing.T) {
+		is := is.New(t)
+
+		f, err := flags.NewStatic(flags.NewStaticOptions{})
+		is.NoErr(err)
+		is.True(!f.Enabled(flags.Archive, ""))
+		is.True(!f.Enabled(flags.TrackingPixel, "me@example.com"))
+		is.Equal([]flags.Flag{
+			{Name: flags.Archive, Source: flags.SourceDefault},
+			{Name: flags.TrackingPixel, Source: flags.SourceDefault},
+		}, f.Flags())
+	})
+
+	t.Run("turns flags on and off from the environment, which wins over the file", func(t *testing.T) {
+		is := is.New(t)
+
+		f, err := flags.NewStatic(flags.NewStaticOptions{
+			Environ: []string{"FEATURE_ARCHIVE=true", "FEATURE_TRACKING_PIXEL=false", "HOME=/root"},
+			File:    writeFlagsFile(t, `{"tracking-pixel": 100, "archive": 0}`),
+		})
+		is.NoErr(err)
+		is.True(f.Enabled(flags.Archive, ""))
+		is.True(!f.Enabled(flags.TrackingPixel, "me@example.com"))
+		is.Equal([]flags.Flag{
+			{Name: flags.Archive, Percentage: 100, Source: flags.SourceEnv},
+			{Name: flags.TrackingPixel, Source: flags.SourceEnv},
+		}, f.Flags())
+	})
+
+	t.Run("rolls out to the subjects in the percentage, and keeps them in as it goes up", func(t *testing.T) {
+		is := is.New(t)
+
+		var previous map[string]bool
+		for _, p := range []int{10, 25, 50, 100} {
+			f, err := flags.NewStatic(flags.NewStaticOptions{
+				File: writeFlagsFile(t, fmt.Sprintf(`{"tracking-pixel": %v}`, p)),
+			})
+			is.NoErr(err)
+
+			enabled := map[string]bool{}
+			for i := 0; i < 100; i++ {
+				subject := fmt.Sprintf("%v@example.com", i)
+				enabled[subject] = f.Enabled(flags.TrackingPixel, subject)
+				is.Equal(flags.Bucket(flags.TrackingPixel, subject) < p, enabled[subject])
+			}
+			for subject, on := range previous {
+				if on {
+					is.True(enabled[subject])
+				}
+			}
+			previous = enabled
+		}
+	})
+
+	t.Run("is off for no subject unless rolled out to everyone", func(t *testing.T) {
+		is := is.New(t)
+
+		f, err := flags.NewStatic(flags.NewStaticOptions{File: writeFlagsFile(t, `{"tracking-pixel": 99}`)})
+		is.NoErr(err)
+		is.True(!f.Enabled(flags.TrackingPixel, ""))
+	})
+
+	t.Run("logs unknown flags once, and has them off", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		f, err := flags.NewStatic(flags.NewStaticOptions{Log: zap.New(core)})
+		is.NoErr(err)
+		is.True(!f.Enabled("archiv", ""))
+		is.True(!f.Enabled("archiv", "me@example.com"))
+		is.Equal(1, logs.FilterMessage("Unknown feature flag is off").Len())
+		is.Equal("archiv", logs.All()[0].ContextMap()["name"])
+	})
+
+	t.Run("errors on values that aren't booleans or percentages, and on missing files", func(t *testing.T) {
+		tests := map[string]flags.NewStaticOptions{
+			"env not a boolean":  {Environ: []string{"FEATURE_ARCHIVE=yes please"}},
+			"file not JSON":      {File: writeFlagsFile(t, `archive: 10`)},
+			"file over 100":      {File: writeFlagsFile(t, `{"archive": 101}`)},
+			"file negative":      {File: writeFlagsFile(t, `{"archive": -1}`)},
+			"file doesn't exist": {File: filepath.Join(t.TempDir(), "flags.json")},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				is := is.New(t)
+				_, err := flags.NewStatic(opts)
+				is.True(err != nil)
+			})
+		}
+	})
+}
+
+func TestEnabled(t *testing.T) {
+	t.Run("is off without a provider in the context", func(t *testing.T) {
+		is := is.New(t)
+
+		is.True(!flags.Enabled(context.Background(), flags.Archive))
+		is.True(flags.FromContext(context.Background()) == nil)
+	})
+
+	t.Run("checks the provider for the subject in the context", func(t *testing.T) {
+		is := is.New(t)
+
+		f, err := flags.NewStatic(flags.NewStaticOptions{
+			Environ: []string{"FEATURE_ARCHIVE=true"},
+			File:    writeFlagsFile(t, `{"tracking-pixel": 50}`),
+		})
+		is.NoErr(err)
+		ctx := flags.WithProvider(context.Background(), f)
+		is.True(flags.Enabled(ctx, flags.Archive))
+
+		// Find subjects in and out of the rollout.
+		var in, out string
+		for i := 0; in == "" || out == ""; i++ {
+			subject := fmt.Sprintf("%v@example.com", i)
+			if flags.Bucket(flags.TrackingPixel, subject) < 50 {
+				in = subject
+			} else {
+				out = subject
+			}
+		}
+		is.True(flags.Enabled(flags.WithSubject(ctx, in), flags.TrackingPixel))
+		is.True(!flags.Enabled(flags.WithSubject(ctx, out), flags.TrackingPixel))
+	})
+}
@@ -0,0 +1,174 @@
+// Package form parses submitted HTML forms into typed values, and collects errors per field to show next to them.
+package form
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+)
+
+// Form being parsed. Use the typed getters to read fields, then Valid to check whether any of them had errors.
+// The first error for a field wins, so check required fields first.
+type Form struct {
+	values url.Values
+	state  State
+}
+
+// New Form from the submitted values.
+func New(values url.Values) *Form {
+	return &Form{
+		values: values,
+		state:  State{Values: map[string]string{}, Errors: map[string]string{}},
+	}
+}
+
+// Parse the form in the request body and query string of r.
+func Parse(r *http.Request) (*Form, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return New(r.Form), nil
+}
+
+// Required fields get an error if they're empty.
+func (f *Form) Required(names ...string) {
+	for _, name := range names {
+		if f.String(name) == "" {
+			f.AddError(name, "Please fill this in.")
+		}
+	}
+}
+
+// String value of the field, with surrounding whitespace trimmed.
+func (f *Form) String(name string) string {
+	v := strings.TrimSpace(f.values.Get(name))
+	f.state.Values[name] = v
+	return v
+}
+
+// Email value of the field. It gets an error if it's not a valid email address.
+func (f *Form) Email(name string) model.Email {
+	e := model.Email(f.String(name))
+	if !e.IsValid() {
+		f.AddError(name, "That doesn't look like an email address. Please check it and try again.")
+	}
+	return e
+}
+
+// Int value of the field. It's zero if the field is empty, and gets an error if it's not a whole number.
+func (f *Form) Int(name string) int {
+	v := f.String(name)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		f.AddError(name, "Please enter a whole number.")
+	}
+	return i
+}
+
+// Bool value of the field, such as a checkbox. A missing field is false, and "on" from checked checkboxes is true.
+func (f *Form) Bool(name string) bool {
+	v := f.String(name)
+	switch v {
+	case "":
+		return false
+	case "on":
+		return true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		f.AddError(name, "Please choose yes or no.")
+	}
+	return b
+}
+
+// AddError for the field, unless it already has one. Use it for checks the typed getters don't do.
+func (f *Form) AddError(name, message string) {
+	if _, ok := f.state.Errors[name]; !ok {
+		f.state.Errors[name] = message
+	}
+}
+
+// Valid if no fields have errors.
+func (f *Form) Valid() bool {
+	return len(f.state.Errors) == 0
+}
+
+// Err is a *ValidationError with the field errors if the form isn't valid, and nil otherwise.
+func (f *Form) Err() error {
+	if f.Valid() {
+		return nil
+	}
+	return &ValidationError{Errors: f.state.Errors}
+}
+
+// ValidationError for a form with invalid fields, with the error message for each field.
+type ValidationError struct {
+	Errors map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "invalid fields: " + strings.Join(names, ", ")
+}
+
+// State of the form, for re-rendering it with the submitted values and errors.
+func (f *Form) State() *State {
+	return &f.state
+}
+
+// State of a submitted form: the values of the fields read, and the errors for each field.
+// A nil State is an empty form, so views can take an optional *State.
+type State struct {
+	Values map[string]string
+	Errors map[string]string
+}
+
+// Value of the field, or the empty string.
+func (s *State) Value(name string) string {
+	if s == nil {
+		return ""
+	}
+	return s.Values[name]
+}
+
+// Error for the field, or the empty string.
+func (s *State) Error(name string) string {
+	if s == nil {
+		return ""
+	}
+	return s.Errors[name]
+}
+
+// Input attributes for the field: the escaped submitted value, and if the field has an error,
+// aria-invalid and aria-describedby pointing to the element from FieldError.
+func (s *State) Input(name string) g.Node {
+	value := s.Value(name)
+	return g.Group([]g.Node{
+		g.If(value != "", Value(value)),
+		g.If(s.Error(name) != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", errorID(name))})),
+	})
+}
+
+// FieldError for the field as an escaped paragraph, or nothing if the field has no error.
+func (s *State) FieldError(name string) g.Node {
+	message := s.Error(name)
+	return g.If(message != "", P(ID(errorID(name)), Class("text-sm text-red-600"), g.Text(message)))
+}
+
+func errorID(name string) string {
+	return name + "-error"
+}
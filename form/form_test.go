@@ -0,0 +1,128 @@
+package form_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+	"github.com/matryer/is"
+
+	"canvas/form"
+)
+
+func TestForm(t *testing.T) {
+	t.Run("parses typed fields", func(t *testing.T) {
+		is := is.New(t)
+
+		f := form.New(url.Values{"name": {" Me "}, "email": {"me@example.com"}, "age": {"42"}, "news": {"on"}})
+		is.Equal("Me", f.String("name"))
+		is.Equal("me@example.com", f.Email("email").String())
+		is.Equal(42, f.Int("age"))
+		is.True(f.Bool("news"))
+		is.True(!f.Bool("missing"))
+		is.True(f.Valid())
+	})
+
+	t.Run("collects an error for each invalid field, keeping the first", func(t *testing.T) {
+		is := is.New(t)
+
+		f := form.New(url.Values{"email": {"notanemail"}, "age": {"old"}, "news": {"maybe"}})
+		f.Required("name")
+		f.String("name")
+		f.Email("email")
+		f.Int("age")
+		f.Bool("news")
+		f.AddError("email", "Something else.")
+
+		is.True(!f.Valid())
+		s := f.State()
+		is.Equal("Please fill this in.", s.Error("name"))
+		is.Equal("That doesn't look like an email address. Please check it and try again.", s.Error("email"))
+		is.Equal("Please enter a whole number.", s.Error("age"))
+		is.Equal("Please choose yes or no.", s.Error("news"))
+		is.Equal("notanemail", s.Value("email"))
+	})
+
+	t.Run("has a validation error with the field errors only if invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		f := form.New(url.Values{"email": {"me@example.com"}})
+		f.Required("name", "age")
+		f.Email("email")
+		err := f.Err()
+		var validationErr *form.ValidationError
+		is.True(errors.As(err, &validationErr))
+		is.Equal(map[string]string{"name": "Please fill this in.", "age": "Please fill this in."}, validationErr.Errors)
+		is.Equal("invalid fields: age, name", err.Error())
+
+		f = form.New(url.Values{"email": {"me@example.com"}})
+		f.Email("email")
+		is.NoErr(f.Err())
+	})
+
+	t.Run("a nil state is an empty form", func(t *testing.T) {
+		is := is.New(t)
+
+		var s *form.State
+		is.Equal("", s.Value("email"))
+		is.Equal("", s.Error("email"))
+		is.Equal(`<input>`, render(Input(s.Input("email"))))
+		is.Equal(``, render(s.FieldError("email")))
+	})
+}
+
+func TestState(t *testing.T) {
+	page := func(s *form.State) string {
+		return render(FormEl(
+			Input(Name("email"), s.Input("email")),
+			s.FieldError("email"),
+			Input(Name("age"), s.Input("age")),
+			s.FieldError("age"),
+		))
+	}
+
+	t.Run("renders multiple field errors next to their fields, with the submitted values escaped", func(t *testing.T) {
+		is := is.New(t)
+
+		f := form.New(url.Values{"email": {`"><script>alert(1)</script>`}, "age": {"<b>old</b>"}})
+		f.Email("email")
+		f.Int("age")
+
+		html := page(f.State())
+		is.True(!strings.Contains(html, "<script>"))
+		is.True(!strings.Contains(html, "<b>"))
+		is.True(strings.Contains(html, `<input name="email" value="&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;" aria-invalid="true" aria-describedby="email-error">`))
+		is.True(strings.Contains(html, `<p id="email-error" class="text-sm text-red-600">That doesn&#39;t look like an email address.`))
+		is.True(strings.Contains(html, `<input name="age" value="&lt;b&gt;old&lt;/b&gt;" aria-invalid="true" aria-describedby="age-error">`))
+		is.True(strings.Contains(html, `<p id="age-error" class="text-sm text-red-600">Please enter a whole number.</p>`))
+	})
+
+	t.Run("clears the errors when the form is submitted again with valid values", func(t *testing.T) {
+		is := is.New(t)
+
+		f := form.New(url.Values{"email": {"notanemail"}, "age": {"old"}})
+		f.Email("email")
+		f.Int("age")
+		is.True(!f.Valid())
+
+		f = form.New(url.Values{"email": {"me@example.com"}, "age": {"42"}})
+		f.Email("email")
+		f.Int("age")
+		is.True(f.Valid())
+
+		html := page(f.State())
+		is.Equal(`<form><input name="email" value="me@example.com"><input name="age" value="42"></form>`, html)
+	})
+}
+
+func render(n g.Node) string {
+	var b strings.Builder
+	if n == nil {
+		return ""
+	}
+	_ = n.Render(&b)
+	return b.String()
+}
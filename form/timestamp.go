@@ -0,0 +1,45 @@
+package form
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTimestamp is returned for form timestamps that are malformed or have a bad signature.
+var ErrInvalidTimestamp = errors.New("invalid form timestamp")
+
+// CreateTimestamp of when a form is rendered, signed with secret, for a hidden field.
+// When the form is submitted, VerifyTimestamp tells how long it took to fill it out.
+func CreateTimestamp(secret []byte, t time.Time) string {
+	unix := strconv.FormatInt(t.Unix(), 10)
+	return unix + "." + base64.RawURLEncoding.EncodeToString(signTimestamp(secret, unix))
+}
+
+// VerifyTimestamp created with CreateTimestamp, returning the time it was created with.
+// Returns ErrInvalidTimestamp if the timestamp is malformed or has been tampered with.
+func VerifyTimestamp(secret []byte, timestamp string) (time.Time, error) {
+	unix, signature, ok := strings.Cut(timestamp, ".")
+	if !ok {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(signatureBytes, signTimestamp(secret, unix)) {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidTimestamp
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func signTimestamp(secret []byte, unix string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("form-timestamp:" + unix))
+	return mac.Sum(nil)
+}
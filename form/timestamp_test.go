@@ -0,0 +1,51 @@
+package form_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/form"
+)
+
+func TestVerifyTimestamp(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+
+	t.Run("returns the time of a timestamp created with the same secret", func(t *testing.T) {
+		is := is.New(t)
+
+		ts, err := form.VerifyTimestamp(secret, form.CreateTimestamp(secret, now))
+		is.NoErr(err)
+		is.True(ts.Equal(now))
+	})
+
+	tests := []struct {
+		name      string
+		timestamp string
+	}{
+		{"rejects a timestamp signed with another secret", form.CreateTimestamp([]byte("other"), now)},
+		{"rejects a timestamp with a changed time", "1670670000." + signatureOf(form.CreateTimestamp(secret, now))},
+		{"rejects a timestamp without a signature", "1670673600"},
+		{"rejects an empty timestamp", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := form.VerifyTimestamp(secret, test.timestamp)
+			is.True(errors.Is(err, form.ErrInvalidTimestamp))
+		})
+	}
+}
+
+func signatureOf(timestamp string) string {
+	for i := range timestamp {
+		if timestamp[i] == '.' {
+			return timestamp[i+1:]
+		}
+	}
+	return ""
+}
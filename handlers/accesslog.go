@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"canvas/assets"
+)
+
+// DefaultQuietPaths and DefaultQuietPrefixes of AccessLogOptions are the probes, metrics, version, and static assets,
+// which are requested all the time, by machines and for every page.
+var (
+	DefaultQuietPaths    = []string{"/health", "/ready", "/metrics", "/version", "/favicon.ico", "/robots.txt"}
+	DefaultQuietPrefixes = []string{assets.PathPrefix}
+)
+
+// AccessLogOptions for AccessLog.
+type AccessLogOptions struct {
+	Log     *zap.Logger
+	Metrics *prometheus.Registry
+	// QuietPaths are logged at debug level, unless the response is a server error. Defaults to DefaultQuietPaths.
+	// Set it to an empty slice to log all paths at info level.
+	QuietPaths []string
+	// QuietPrefixes are like QuietPaths, for all paths starting with them. Defaults to DefaultQuietPrefixes.
+	QuietPrefixes []string
+	// QuietSampleEvery logs every nth quiet request at info level anyway, starting with the first,
+	// so there's still a trace of them in production logs. Zero logs them all at debug level.
+	QuietSampleEvery int
+}
+
+// AccessLog is middleware logging each request after it's handled, with the status, size, and duration,
+// with the logger of the request from RequestLog. It must come after RequestLog, and before Recover,
+// so the error page of a panic is logged with its status.
+// Requests to the quiet paths in opts are logged at debug level, or sampled, unless the response is a server error,
+// and counted in the app_http_requests_total metric like all others.
+func AccessLog(opts AccessLogOptions) func(next http.Handler) http.Handler {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.QuietPaths == nil {
+		opts.QuietPaths = DefaultQuietPaths
+	}
+	if opts.QuietPrefixes == nil {
+		opts.QuietPrefixes = DefaultQuietPrefixes
+	}
+
+	quietPaths := map[string]bool{}
+	for _, p := range opts.QuietPaths {
+		quietPaths[p] = true
+	}
+	isQuiet := func(path string) bool {
+		if quietPaths[path] {
+			return true
+		}
+		for _, prefix := range opts.QuietPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_http_requests_total",
+		Help: "Number of HTTP requests handled, by method and status code.",
+	}, []string{"method", "code"})
+	opts.Metrics.MustRegister(requests)
+
+	// quietCount of quiet requests, for sampling them.
+	var quietCount uint64
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			// Handlers that write nothing respond with 200.
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			requests.WithLabelValues(r.Method, strconv.Itoa(status)).Inc()
+
+			level := zapcore.InfoLevel
+			if status < 500 && isQuiet(r.URL.Path) {
+				n := atomic.AddUint64(&quietCount, 1)
+				if opts.QuietSampleEvery <= 0 || (n-1)%uint64(opts.QuietSampleEvery) != 0 {
+					level = zapcore.DebugLevel
+				}
+			}
+			if ce := requestLog(r.Context(), opts.Log).Check(level, "Handled request"); ce != nil {
+				ce.Write(
+					zap.Int("status", status),
+					zap.Int("bytes", ww.BytesWritten()),
+					zap.Duration("duration", time.Since(start)),
+				)
+			}
+		})
+	}
+}
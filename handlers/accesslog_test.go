@@ -0,0 +1,134 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+)
+
+func TestAccessLog(t *testing.T) {
+	newMux := func(opts handlers.AccessLogOptions) (chi.Router, *observer.ObservedLogs) {
+		core, logs := observer.New(zapcore.DebugLevel)
+		opts.Log = zap.New(core)
+		mux := chi.NewMux()
+		mux.Use(handlers.AccessLog(opts))
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("hi"))
+		})
+		mux.Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+		mux.Get("/version", func(w http.ResponseWriter, r *http.Request) {})
+		mux.Get("/ready", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		mux.Get("/static/*", func(w http.ResponseWriter, r *http.Request) {})
+		return mux, logs
+	}
+
+	get := func(mux chi.Router, target string) {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	levels := func(logs *observer.ObservedLogs) []zapcore.Level {
+		var levels []zapcore.Level
+		for _, e := range logs.All() {
+			levels = append(levels, e.Level)
+		}
+		return levels
+	}
+
+	t.Run("logs requests at info level with the status and size", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, logs := newMux(handlers.AccessLogOptions{})
+		get(mux, "/")
+
+		is.Equal(1, logs.Len())
+		e := logs.All()[0]
+		is.Equal(zapcore.InfoLevel, e.Level)
+		is.Equal("Handled request", e.Message)
+		is.Equal(int64(http.StatusOK), e.ContextMap()["status"])
+		is.Equal(int64(2), e.ContextMap()["bytes"])
+	})
+
+	t.Run("logs probes and static assets at debug level by default", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, logs := newMux(handlers.AccessLogOptions{})
+		get(mux, "/health")
+		get(mux, "/version")
+		get(mux, "/favicon.ico")
+		get(mux, "/static/app.css")
+
+		is.Equal([]zapcore.Level{zapcore.DebugLevel, zapcore.DebugLevel, zapcore.DebugLevel, zapcore.DebugLevel}, levels(logs))
+	})
+
+	t.Run("logs quiet paths at info level on server errors", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, logs := newMux(handlers.AccessLogOptions{})
+		get(mux, "/ready")
+
+		is.Equal([]zapcore.Level{zapcore.InfoLevel}, levels(logs))
+		is.Equal(int64(http.StatusServiceUnavailable), logs.All()[0].ContextMap()["status"])
+	})
+
+	t.Run("logs every nth quiet request at info level, starting with the first", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, logs := newMux(handlers.AccessLogOptions{QuietSampleEvery: 3})
+		for i := 0; i < 7; i++ {
+			get(mux, "/health")
+			// Other requests don't count towards the sampling.
+			get(mux, "/")
+		}
+
+		var quiet []zapcore.Level
+		for _, e := range logs.All() {
+			if e.ContextMap()["bytes"] == int64(0) {
+				quiet = append(quiet, e.Level)
+			}
+		}
+		info, debug := zapcore.InfoLevel, zapcore.DebugLevel
+		is.Equal([]zapcore.Level{info, debug, debug, info, debug, debug, info}, quiet)
+	})
+
+	t.Run("logs everything at info level with no quiet paths", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, logs := newMux(handlers.AccessLogOptions{QuietPaths: []string{}, QuietPrefixes: []string{}})
+		get(mux, "/health")
+		get(mux, "/static/app.css")
+
+		is.Equal([]zapcore.Level{zapcore.InfoLevel, zapcore.InfoLevel}, levels(logs))
+	})
+
+	t.Run("counts all requests, quiet or not", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		mux, _ := newMux(handlers.AccessLogOptions{Metrics: registry})
+		get(mux, "/")
+		get(mux, "/health")
+		get(mux, "/health")
+		get(mux, "/ready")
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_http_requests_total Number of HTTP requests handled, by method and status code.
+# TYPE app_http_requests_total counter
+app_http_requests_total{code="200",method="GET"} 3
+app_http_requests_total{code="503",method="GET"} 1
+`), "app_http_requests_total")
+		is.NoErr(err)
+	})
+}
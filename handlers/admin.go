@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/throttle"
+	"canvas/views"
+)
+
+// adminSessionKey in the session of a logged-in admin, with the time the login expires.
+const adminSessionKey = "adminUntil"
+
+// isAdmin if the session in ctx has an admin login that hasn't expired.
+func isAdmin(ctx context.Context) bool {
+	until, ok := sessions.Get[time.Time](ctx, adminSessionKey)
+	return ok && time.Now().Before(until)
+}
+
+// AdminAuth is middleware requiring an admin login in the session, from the sessions.Manager middleware.
+// Without one, it redirects to the login page, which sends the admin back to the requested URL after logging in.
+// With one, the logger of the request from RequestLog marks it as by the admin.
+func AdminAuth(log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isAdmin(r.Context()) {
+				next.ServeHTTP(w, withLogFields(r, zap.Bool("admin", true)))
+				return
+			}
+			http.Redirect(w, r, "/admin/login?"+url.Values{"redirect": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+		})
+	}
+}
+
+// AdminLoginOptions for AdminLogin.
+type AdminLoginOptions struct {
+	// PasswordHash is the bcrypt hash of the admin password. Without it, nobody can log in.
+	PasswordHash []byte
+	// SessionLifetime is how long a login lasts. Defaults to 12 hours.
+	SessionLifetime time.Duration
+	// Throttle counts login attempts per IP address. Defaults to a throttle.MemoryStore.
+	Throttle throttler
+}
+
+const (
+	maxAdminLoginAttempts       = 10
+	adminLoginAttemptsWindow    = 15 * time.Minute
+	defaultAdminSessionLifetime = 12 * time.Hour
+)
+
+// AdminLogin shows the login form at /login and logs the admin in with the password, on a router mounted at /admin.
+// Login attempts are limited per IP address, and failed ones are logged with the IP address.
+// The login is kept in the session from the sessions.Manager middleware, which gets a new ID on login,
+// so a session cookie planted before login is never logged in.
+func AdminLogin(mux chi.Router, log *zap.Logger, opts AdminLoginOptions) {
+	if opts.SessionLifetime == 0 {
+		opts.SessionLifetime = defaultAdminSessionLifetime
+	}
+	if opts.Throttle == nil {
+		opts.Throttle = throttle.NewMemoryStore(throttle.NewMemoryStoreOptions{})
+	}
+
+	mux.Get("/login", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		return render(w, http.StatusOK,
+			views.AdminLoginPage(CSRFToken(r), safeRedirect(r.URL.Query().Get("redirect")), "", sessions.ConsumeFlashes(r.Context())))
+	}))
+
+	mux.Post("/login", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		if err := r.ParseForm(); err != nil {
+			return render(w, http.StatusBadRequest, views.AdminLoginPage(CSRFToken(r), "", "", nil))
+		}
+		redirect := safeRedirect(r.PostForm.Get("redirect"))
+		ip := clientIP(r)
+
+		throttled, err := opts.Throttle.Throttle(r.Context(), "admin-login:"+ip, maxAdminLoginAttempts, adminLoginAttemptsWindow)
+		if err != nil {
+			requestLog(r.Context(), log).Info("Error throttling admin login", zap.Error(err))
+		}
+		if throttled {
+			requestLog(r.Context(), log).Info("Too many admin login attempts", zap.String("ip", ip))
+			w.Header().Set("Retry-After", strconv.Itoa(int(adminLoginAttemptsWindow.Seconds())))
+			return render(w, http.StatusTooManyRequests,
+				views.AdminLoginPage(CSRFToken(r), redirect, "Too many login attempts. Please try again later.", nil))
+		}
+
+		if len(opts.PasswordHash) == 0 ||
+			bcrypt.CompareHashAndPassword(opts.PasswordHash, []byte(r.PostForm.Get("password"))) != nil {
+			requestLog(r.Context(), log).Info("Failed admin login", zap.String("ip", ip))
+			return render(w, http.StatusUnauthorized,
+				views.AdminLoginPage(CSRFToken(r), redirect, "That password isn't right. Please try again.", nil))
+		}
+
+		if err := sessions.Regenerate(r.Context()); err != nil {
+			return fmt.Errorf("error regenerating session: %w", err)
+		}
+		if err := sessions.Set(r.Context(), adminSessionKey, time.Now().Add(opts.SessionLifetime)); err != nil {
+			return fmt.Errorf("error logging admin in to session: %w", err)
+		}
+		if _, err := RotateCSRFToken(w); err != nil {
+			requestLog(r.Context(), log).Info("Error rotating CSRF token", zap.Error(err))
+		}
+
+		requestLog(r.Context(), log).Info("Admin logged in", zap.String("ip", ip))
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return nil
+	}))
+}
+
+// AdminLogout at /logout deletes the admin login from the session and sends the admin to the login page,
+// on a router mounted at /admin. The session gets a new ID, so the old cookie isn't logged in anymore.
+func AdminLogout(mux chi.Router, log *zap.Logger) {
+	mux.Post("/logout", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		if err := sessions.Delete(r.Context(), adminSessionKey); err != nil {
+			return fmt.Errorf("error logging admin out of session: %w", err)
+		}
+		if err := sessions.Regenerate(r.Context()); err != nil {
+			return fmt.Errorf("error regenerating session: %w", err)
+		}
+		_ = sessions.AddFlash(r.Context(), sessions.FlashInfo, "You're logged out.")
+		http.Redirect(w, r, "/admin/login", http.StatusFound)
+		return nil
+	}))
+}
+
+// safeRedirect is the redirect if it's a path on this site, and the subscriber list otherwise,
+// so the login form can't be used to send the admin somewhere else.
+func safeRedirect(redirect string) string {
+	if !isLocalPath(redirect) {
+		return "/admin/subscribers"
+	}
+	return redirect
+}
+
+type subscriberLister interface {
+	ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error)
+	SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error)
+}
+
+// adminSubscribersPageSizes the admin can pick how many subscribers are shown per page from. The first is the default.
+var adminSubscribersPageSizes = []int{50, 100, 200}
+
+// minSubscriberSearchLength in characters, because shorter searches match most of the list anyway.
+const minSubscriberSearchLength = 3
+
+// AdminSubscribers shows a page of subscribers at /subscribers, on a router mounted at /admin,
+// filtered by the status query parameter.
+// Pages are linked with opaque cursors in the after and before query parameters, which keep the status filter
+// and the page size.
+// With the q query parameter, it shows the subscribers with it in their email address instead, up to a page of them
+// and without paging, also filtered by the status.
+func AdminSubscribers(mux chi.Router, s subscriberLister, log *zap.Logger) {
+	mux.Get("/subscribers", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+
+		status := parseSubscriberStatus(query.Get("status"))
+		search := strings.TrimSpace(query.Get("q"))
+		pageSize := views.ParsePageSize(query, adminSubscribersPageSizes)
+
+		exportURL := "/admin/subscribers/export"
+		if status != "" {
+			exportURL += "?" + url.Values{"status": {string(status)}}.Encode()
+		}
+
+		props := views.AdminSubscribersProps{
+			CSRFToken: CSRFToken(r),
+			Flashes:   sessions.ConsumeFlashes(r.Context()),
+			Status:    status,
+			StatusURL: func(status model.SubscriberStatus) string {
+				v := url.Values{}
+				if status != "" {
+					v.Set("status", string(status))
+				}
+				if search != "" {
+					v.Set("q", search)
+				}
+				if len(v) == 0 {
+					return "/admin/subscribers"
+				}
+				return "/admin/subscribers?" + v.Encode()
+			},
+			Search:     search,
+			CurrentURL: r.URL.RequestURI(),
+			ExportURL:  exportURL,
+		}
+
+		if search != "" {
+			if utf8.RuneCountInString(search) < minSubscriberSearchLength {
+				props.SearchError = fmt.Sprintf("Please search for at least %v characters.", minSubscriberSearchLength)
+				return render(w, http.StatusOK, views.AdminSubscribers(props))
+			}
+
+			subscribers, err := s.SearchSubscribers(r.Context(), storage.SearchSubscribersOptions{
+				Limit:  pageSize + 1,
+				Query:  search,
+				Status: status,
+			})
+			if err != nil {
+				return fmt.Errorf("error searching subscribers: %w", err)
+			}
+			// The extra subscriber tells whether there are more matches than shown.
+			if len(subscribers) > pageSize {
+				subscribers = subscribers[:pageSize]
+				props.SearchCapped = true
+			}
+			props.Subscribers = subscribers
+			return render(w, http.StatusOK, views.AdminSubscribers(props))
+		}
+
+		after := decodeCursor(query.Get(views.PageAfterParam))
+		before := decodeCursor(query.Get(views.PageBeforeParam))
+		backwards := after == "" && before != ""
+
+		subscribers, err := s.ListSubscribers(r.Context(), storage.ListSubscribersOptions{
+			After:  after,
+			Before: before,
+			Limit:  pageSize + 1,
+			Status: status,
+		})
+		if err != nil {
+			return fmt.Errorf("error listing subscribers: %w", err)
+		}
+
+		// The extra subscriber tells whether there's another page in the direction we're paging.
+		more := len(subscribers) > pageSize
+		hasPrevious, hasNext := after != "", more
+		if backwards {
+			hasPrevious, hasNext = more, true
+			if more {
+				subscribers = subscribers[1:]
+			}
+		} else if more {
+			subscribers = subscribers[:pageSize]
+		}
+		props.Subscribers = subscribers
+
+		pageQuery := url.Values{}
+		if status != "" {
+			pageQuery.Set("status", string(status))
+		}
+		if pageSize != adminSubscribersPageSizes[0] {
+			pageQuery.Set(views.PageSizeParam, strconv.Itoa(pageSize))
+		}
+		props.Pagination = views.PaginationProps{
+			Path:      "/admin/subscribers",
+			Query:     pageQuery,
+			PageSize:  pageSize,
+			PageSizes: adminSubscribersPageSizes,
+		}
+		if len(subscribers) > 0 {
+			if hasPrevious {
+				props.Pagination.PreviousCursor = encodeCursor(subscribers[0].Email)
+			}
+			if hasNext {
+				props.Pagination.NextCursor = encodeCursor(subscribers[len(subscribers)-1].Email)
+			}
+		}
+		return render(w, http.StatusOK, views.AdminSubscribers(props))
+	}))
+}
+
+type subscriberGetter interface {
+	GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error)
+	ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error)
+	ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error)
+}
+
+// adminSubscriberSendsLimit is how many of the newest emails to a subscriber are shown.
+const adminSubscriberSendsLimit = 100
+
+// AdminSubscriber shows the subscriber with the id at /subscribers/{id}, on a router mounted at /admin,
+// with their tags, and the newest emails sent to their address from the send log. Unknown and deleted subscribers
+// are not found.
+func AdminSubscriber(mux chi.Router, s subscriberGetter, log *zap.Logger) {
+	mux.Get("/subscribers/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid subscriber ID: %w", storage.ErrNotFound)
+		}
+		subscriber, err := s.GetSubscriber(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("error getting subscriber: %w", err)
+		}
+		if subscriber == nil {
+			return fmt.Errorf("no subscriber with ID %v: %w", id, storage.ErrNotFound)
+		}
+
+		sends, err := s.ListEmailSends(r.Context(), storage.ListEmailSendsOptions{
+			Email: subscriber.Email,
+			Limit: adminSubscriberSendsLimit + 1,
+		})
+		if err != nil {
+			return fmt.Errorf("error listing email sends: %w", err)
+		}
+		tags, err := s.ListSubscriberTags(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("error listing subscriber tags: %w", err)
+		}
+		props := views.AdminSubscriberProps{
+			CSRFToken:  CSRFToken(r),
+			Flashes:    sessions.ConsumeFlashes(r.Context()),
+			Subscriber: *subscriber,
+			Tags:       tags,
+		}
+		// The extra send tells whether there are more than shown.
+		if len(sends) > adminSubscriberSendsLimit {
+			sends = sends[:adminSubscriberSendsLimit]
+			props.SendsCapped = true
+		}
+		props.Sends = sends
+		return render(w, http.StatusOK, views.AdminSubscriber(props))
+	}))
+}
+
+type subscriberChanger interface {
+	DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+}
+
+// AdminSubscriberActions on a router mounted at /admin, for the forms in the subscriber list:
+// DELETE /subscribers/{id} deletes the subscriber, POST /subscribers/{id}/confirm confirms them,
+// POST /subscribers/{id}/unsubscribe unsubscribes them, and POST /subscribers/{id}/clear-complaint clears
+// their spam complaint, so they can sign up again. Each is recorded in the audit log.
+// The forms have the version field with the subscriber's Updated time in microseconds, and the action isn't done
+// if the subscriber changed or was deleted since, which is shown as an error flash instead.
+// Afterwards, the admin is sent back to the redirect field, like the page of the list they were on.
+func AdminSubscriberActions(mux chi.Router, s subscriberChanger, log *zap.Logger) {
+	type change func(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+
+	action := func(name string, change change, done string) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			if err := r.ParseForm(); err != nil {
+				return fmt.Errorf("error parsing form: %w", err)
+			}
+			redirect := safeRedirect(r.PostForm.Get("redirect"))
+
+			id, idErr := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			version, versionErr := strconv.ParseInt(r.PostForm.Get("version"), 10, 64)
+			var email model.Email
+			err := storage.ErrConflict
+			if idErr == nil && versionErr == nil {
+				email, err = change(r.Context(), id, time.UnixMicro(version).UTC(), storage.AuditActorAdmin)
+			}
+			switch {
+			case errors.Is(err, storage.ErrConflict):
+				requestLog(r.Context(), log).Info("Conflict changing subscriber", zap.String("action", name), zap.String("id", chi.URLParam(r, "id")))
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError,
+					"That subscriber was changed or deleted in the meantime, so nothing was done. Please check the list and try again.")
+			case err != nil:
+				return fmt.Errorf("error changing subscriber with %v: %w", name, err)
+			default:
+				requestLog(r.Context(), log).Info("Changed subscriber", zap.String("action", name), zap.Int64("id", id))
+				_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, fmt.Sprintf(done, email))
+			}
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return nil
+		})
+	}
+
+	mux.Delete("/subscribers/{id}", action("delete", s.DeleteSubscriber, "Deleted %v."))
+	mux.Post("/subscribers/{id}/confirm", action("confirm", s.ConfirmSubscriber, "Confirmed %v."))
+	mux.Post("/subscribers/{id}/unsubscribe", action("unsubscribe", s.UnsubscribeSubscriber, "Unsubscribed %v."))
+	mux.Post("/subscribers/{id}/clear-complaint", action("clear complaint", s.ClearComplaint,
+		"Cleared the complaint of %v. They can sign up again."))
+}
+
+// parseSubscriberStatus from the status query parameter, or empty for all if it's not a status.
+func parseSubscriberStatus(status string) model.SubscriberStatus {
+	switch s := model.SubscriberStatus(status); s {
+	case model.SubscriberStatusPending, model.SubscriberStatusConfirmed, model.SubscriberStatusUnsubscribed,
+		model.SubscriberStatusBounced, model.SubscriberStatusComplained:
+		return s
+	default:
+		return ""
+	}
+}
+
+func encodeCursor(e model.Email) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(e))
+}
+
+// decodeCursor from encodeCursor, or the empty email address if it's not valid.
+func decodeCursor(cursor string) model.Email {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ""
+	}
+	return model.Email(b)
+}
@@ -0,0 +1,874 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+// subscriberListerMock lists subscribers like the database, from subscribers sorted by email.
+type subscriberListerMock struct {
+	err         error
+	searches    []storage.SearchSubscribersOptions
+	subscribers []model.Subscriber
+}
+
+func (s *subscriberListerMock) ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var matching []model.Subscriber
+	for _, sub := range s.subscribers {
+		if opts.Status != "" && sub.Status() != opts.Status {
+			continue
+		}
+		if sub.Email <= opts.After || (opts.Before != "" && sub.Email >= opts.Before) {
+			continue
+		}
+		matching = append(matching, sub)
+	}
+	if opts.After == "" && opts.Before != "" && len(matching) > opts.Limit {
+		return matching[len(matching)-opts.Limit:], nil
+	}
+	if len(matching) > opts.Limit {
+		return matching[:opts.Limit], nil
+	}
+	return matching, nil
+}
+
+// SearchSubscribers by matching the query literally, like the database with its wildcards escaped.
+func (s *subscriberListerMock) SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error) {
+	s.searches = append(s.searches, opts)
+	if s.err != nil {
+		return nil, s.err
+	}
+	var matching []model.Subscriber
+	for _, sub := range s.subscribers {
+		if opts.Status != "" && sub.Status() != opts.Status {
+			continue
+		}
+		if strings.Contains(strings.ToLower(sub.Email.String()), strings.ToLower(opts.Query)) {
+			matching = append(matching, sub)
+		}
+	}
+	if len(matching) > opts.Limit {
+		return matching[:opts.Limit], nil
+	}
+	return matching, nil
+}
+
+func newSubscriberListerMock(count int) *subscriberListerMock {
+	created := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	confirmed := created.Add(24 * time.Hour)
+	s := &subscriberListerMock{}
+	for i := 0; i < count; i++ {
+		sub := model.Subscriber{ID: int64(i), Email: model.Email(fmt.Sprintf("me%03d@example.com", i)), Active: true, Created: created}
+		if i%2 == 0 {
+			sub.Confirmed = true
+			sub.ConfirmedAt = &confirmed
+		}
+		s.subscribers = append(s.subscribers, sub)
+	}
+	return s
+}
+
+var linkMatcher = regexp.MustCompile(`<a href="([^"]*)" rel="(prev|next)">`)
+
+// pageLinks in the body, by rel.
+func pageLinks(body string) map[string]string {
+	links := map[string]string{}
+	for _, match := range linkMatcher.FindAllStringSubmatch(body, -1) {
+		links[match[2]] = html.UnescapeString(match[1])
+	}
+	return links
+}
+
+// newSessionManager with sessions in memory.
+func newSessionManager() *sessions.Manager {
+	return sessions.NewManager(sessions.NewManagerOptions{
+		Secret: []byte("secret"),
+		Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+	})
+}
+
+// logInAdmin through AdminLogin with the sessions of m, returning the session cookie for requests to pages
+// behind AdminAuth. The login lasts for the lifetime, or the default if it's zero.
+func logInAdmin(t *testing.T, m *sessions.Manager, lifetime time.Duration) *http.Cookie {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := chi.NewMux()
+	mux.Use(m.Middleware)
+	mux.Route("/admin", func(r chi.Router) {
+		handlers.AdminLogin(r, zap.NewNop(), handlers.AdminLoginOptions{PasswordHash: hash, SessionLifetime: lifetime})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader("password=correct+horse"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	if c := sessionCookie(res); c != nil {
+		return c
+	}
+	t.Fatal("no session cookie after logging in, status", res.Code)
+	return nil
+}
+
+// sessionCookie set by the response, if any.
+func sessionCookie(res *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range res.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestAdminSubscribers(t *testing.T) {
+	m := newSessionManager()
+	session := logInAdmin(t, m, 0)
+
+	newMux := func(s *subscriberListerMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(m.Middleware)
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			handlers.AdminSubscribers(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	get := func(mux chi.Router, target string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+
+	t.Run("renders a row for each subscriber", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := get(newMux(newSubscriberListerMock(2)), "/admin/subscribers")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `<tr><td class="py-2"><a href="/admin/subscribers/0" class="text-indigo-600 hover:text-indigo-900">me000@example.com</a></td><td class="py-2">confirmed</td><td class="py-2">2022-12-10</td><td class="py-2">2022-12-11</td>`))
+		is.True(strings.Contains(body, `<tr><td class="py-2"><a href="/admin/subscribers/1" class="text-indigo-600 hover:text-indigo-900">me001@example.com</a></td><td class="py-2">pending</td><td class="py-2">2022-12-10</td><td class="py-2"></td>`))
+		is.Equal(map[string]string{}, pageLinks(body))
+	})
+
+	t.Run("renders the actions that apply to each subscriber, with their version and the current URL", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSubscriberListerMock(2)
+		s.subscribers[1].Updated = time.Date(2022, 12, 10, 12, 0, 0, 1000, time.UTC)
+
+		_, body := get(newMux(s), "/admin/subscribers?status=pending")
+		is.True(strings.Contains(body, `<form action="/admin/subscribers/1/confirm" method="post" class="inline">`))
+		is.True(strings.Contains(body, `<form action="/admin/subscribers/1/unsubscribe" method="post" class="inline" data-confirm="Unsubscribe me001@example.com?`))
+		is.True(strings.Contains(body, `<form action="/admin/subscribers/1" method="post" class="inline" data-confirm="Delete me001@example.com?`))
+		is.True(strings.Contains(body, `<input type="hidden" name="_method" value="DELETE">`))
+		is.True(strings.Contains(body, `<input type="hidden" name="version" value="1670673600000001">`))
+		is.True(strings.Contains(body, `<input type="hidden" name="redirect" value="/admin/subscribers?status=pending">`))
+
+		_, body = get(newMux(s), "/admin/subscribers?status=confirmed")
+		is.True(!strings.Contains(body, "/confirm"))
+		is.True(strings.Contains(body, `action="/admin/subscribers/0/unsubscribe"`))
+	})
+
+	t.Run("renders an empty state without subscribers", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := get(newMux(newSubscriberListerMock(0)), "/admin/subscribers")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "No subscribers here yet."))
+		is.True(!strings.Contains(body, "<table"))
+	})
+
+	t.Run("filters by status", func(t *testing.T) {
+		is := is.New(t)
+
+		_, body := get(newMux(newSubscriberListerMock(2)), "/admin/subscribers?status=pending")
+		is.True(!strings.Contains(body, "me000@example.com"))
+		is.True(strings.Contains(body, "me001@example.com"))
+	})
+
+	t.Run("pages forward and back, keeping the status filter", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSubscriberListerMock(220))
+
+		// There are 110 confirmed subscribers, so three pages of 50, 50, and 10.
+		_, body := get(mux, "/admin/subscribers?status=confirmed")
+		is.True(strings.Contains(body, "me000@example.com"))
+		is.True(strings.Contains(body, "me098@example.com"))
+		is.True(!strings.Contains(body, "me100@example.com"))
+		links := pageLinks(body)
+		is.Equal("", links["prev"])
+		is.True(strings.Contains(links["next"], "status=confirmed"))
+
+		_, body = get(mux, links["next"])
+		is.True(strings.Contains(body, "me100@example.com"))
+		is.True(strings.Contains(body, "me198@example.com"))
+		is.True(!strings.Contains(body, "me098@example.com"))
+		links = pageLinks(body)
+		is.True(strings.Contains(links["prev"], "status=confirmed"))
+
+		_, body = get(mux, links["next"])
+		is.True(strings.Contains(body, "me200@example.com"))
+		is.True(strings.Contains(body, "me218@example.com"))
+		links = pageLinks(body)
+		is.Equal("", links["next"])
+
+		_, body = get(mux, links["prev"])
+		is.True(strings.Contains(body, "me100@example.com"))
+		is.True(strings.Contains(body, "me198@example.com"))
+		is.True(!strings.Contains(body, "me200@example.com"))
+		is.True(!strings.Contains(body, "me001@example.com"))
+
+		_, body = get(mux, pageLinks(body)["prev"])
+		is.True(strings.Contains(body, "me000@example.com"))
+		links = pageLinks(body)
+		is.Equal("", links["prev"])
+		is.True(links["next"] != "")
+	})
+
+	t.Run("pages with the picked page size, and the default for sizes that aren't allowed", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSubscriberListerMock(220))
+
+		_, body := get(mux, "/admin/subscribers?status=confirmed&size=100")
+		is.True(strings.Contains(body, "me198@example.com"))
+		is.True(!strings.Contains(body, "me200@example.com"))
+		is.True(strings.Contains(body, `<option value="100" selected>100</option>`))
+		next := pageLinks(body)["next"]
+		is.True(strings.Contains(next, "size=100"))
+		is.True(strings.Contains(next, "status=confirmed"))
+
+		_, body = get(mux, next)
+		is.True(strings.Contains(body, "me200@example.com"))
+		is.True(strings.Contains(body, "me218@example.com"))
+
+		_, body = get(mux, "/admin/subscribers?status=confirmed&size=100000")
+		is.True(strings.Contains(body, "me098@example.com"))
+		is.True(!strings.Contains(body, "me100@example.com"))
+		is.True(!strings.Contains(pageLinks(body)["next"], "size="))
+	})
+
+	t.Run("searches by email, keeping the status filter in the tabs and the form", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSubscriberListerMock(12)
+		code, body := get(newMux(s), "/admin/subscribers?status=pending&q=ME00")
+		is.Equal(http.StatusOK, code)
+		is.Equal([]storage.SearchSubscribersOptions{{Limit: 51, Query: "ME00", Status: model.SubscriberStatusPending}}, s.searches)
+		for _, email := range []string{"me001@example.com", "me003@example.com", "me009@example.com"} {
+			is.True(strings.Contains(body, email))
+		}
+		is.True(!strings.Contains(body, "me000@example.com"))
+		is.True(!strings.Contains(body, "me011@example.com"))
+		is.True(strings.Contains(body, `<input type="hidden" name="status" value="pending">`))
+		is.True(strings.Contains(body, `<input type="search" name="q" id="search" value="ME00"`))
+		is.True(strings.Contains(body, `href="/admin/subscribers?q=ME00&amp;status=confirmed"`))
+		is.True(strings.Contains(body, `href="/admin/subscribers?q=ME00"`))
+		is.Equal(map[string]string{}, pageLinks(body))
+	})
+
+	t.Run("passes wildcards in the search on literally, and escapes them in the page", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSubscriberListerMock(2)
+		s.subscribers = append(s.subscribers, model.Subscriber{ID: 2, Email: "a_b%c@example.com", Active: true})
+		_, body := get(newMux(s), "/admin/subscribers?q="+url.QueryEscape(`_b%c`))
+		is.Equal(`_b%c`, s.searches[0].Query)
+		is.True(strings.Contains(body, "a_b%c@example.com"))
+		is.True(!strings.Contains(body, "me000@example.com"))
+
+		_, body = get(newMux(s), "/admin/subscribers?q="+url.QueryEscape(`<b>%_`))
+		is.True(strings.Contains(body, "No subscribers match “&lt;b&gt;%_”."))
+		is.True(!strings.Contains(body, "<b>"))
+	})
+
+	t.Run("shows a notice if there are more matches than shown", func(t *testing.T) {
+		is := is.New(t)
+
+		_, body := get(newMux(newSubscriberListerMock(60)), "/admin/subscribers?q=example")
+		is.True(strings.Contains(body, "Showing the first 50 matches."))
+		is.Equal(50, strings.Count(body, "@example.com</a></td>"))
+
+		_, body = get(newMux(newSubscriberListerMock(50)), "/admin/subscribers?q=example")
+		is.True(!strings.Contains(body, "Showing the first"))
+	})
+
+	t.Run("asks for a longer search without searching", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSubscriberListerMock(2)
+		code, body := get(newMux(s), "/admin/subscribers?q=+me+")
+		is.Equal(http.StatusOK, code)
+		is.Equal(0, len(s.searches))
+		is.True(strings.Contains(body, "Please search for at least 3 characters."))
+		is.True(strings.Contains(body, `aria-invalid="true"`))
+		is.True(!strings.Contains(body, "<table"))
+		is.True(!strings.Contains(body, "No subscribers"))
+	})
+
+	t.Run("renders an error page if listing fails", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := get(newMux(&subscriberListerMock{err: errors.New("oh no")}), "/admin/subscribers")
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
+	})
+
+	t.Run("redirects to the login page without a valid session", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSubscriberListerMock(2))
+
+		code, header, body := makeGetRequest(mux, "/admin/subscribers?status=pending")
+		is.Equal(http.StatusFound, code)
+		is.Equal("/admin/login?redirect=%2Fadmin%2Fsubscribers%3Fstatus%3Dpending", header.Get("Location"))
+		is.True(!strings.Contains(body, "me000@example.com"))
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/subscribers", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "wrong"})
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusFound, res.Code)
+	})
+}
+
+type subscriberGetterMock struct {
+	err         error
+	subscribers []model.Subscriber
+	sends       []model.EmailSend
+	sendsOpts   storage.ListEmailSendsOptions
+	tags        []model.Tag
+}
+
+func (s *subscriberGetterMock) GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error) {
+	for _, sub := range s.subscribers {
+		if sub.ID == id {
+			return &sub, s.err
+		}
+	}
+	return nil, s.err
+}
+
+func (s *subscriberGetterMock) ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error) {
+	s.sendsOpts = opts
+	if len(s.sends) > opts.Limit {
+		return s.sends[:opts.Limit], s.err
+	}
+	return s.sends, s.err
+}
+
+func (s *subscriberGetterMock) ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error) {
+	return s.tags, s.err
+}
+
+func TestAdminSubscriber(t *testing.T) {
+	newStore := func() *subscriberGetterMock {
+		created := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+		return &subscriberGetterMock{
+			subscribers: []model.Subscriber{{ID: 3, Email: "me@example.com", Active: true, Locale: "en", Created: created}},
+			sends: []model.EmailSend{
+				{ID: 2, Email: "me@example.com", Type: "newsletter", NewsletterID: 7, ProviderMessageID: "0100018506b2c3d4-5e6f",
+					Status: model.EmailSendStatusSent, Delivery: model.EmailDeliveryBounced, Error: "permanent bounce", Created: created.Add(time.Hour)},
+				{ID: 1, Email: "me@example.com", Type: "confirmation", Status: model.EmailSendStatusFailed, Error: "oh no", Test: true,
+					Created: created},
+			},
+		}
+	}
+
+	newMux := func(s *subscriberGetterMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminSubscriber(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	t.Run("shows the subscriber and the emails sent to them, newest first", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		code, _, body := makeGetRequest(newMux(s), "/admin/subscribers/3")
+		is.Equal(http.StatusOK, code)
+		is.Equal(storage.ListEmailSendsOptions{Email: "me@example.com", Limit: 101}, s.sendsOpts)
+		is.True(strings.Contains(body, "me@example.com"))
+		is.True(strings.Contains(body, "pending"))
+		is.True(strings.Index(body, `id="send-2"`) < strings.Index(body, `id="send-1"`))
+		is.True(strings.Contains(body, `href="/admin/newsletters/7/preview"`))
+		is.True(strings.Contains(body, "bounced"))
+		is.True(strings.Contains(body, "permanent bounce"))
+		is.True(strings.Contains(body, `title="0100018506b2c3d4-5e6f"`))
+		is.True(strings.Contains(body, "(test)"))
+		is.True(!strings.Contains(body, `id="sends-capped"`))
+		is.True(strings.Contains(body, "No tags."))
+	})
+
+	t.Run("shows the subscriber's tags with forms for removing them", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		s.tags = []model.Tag{"api", "beta"}
+		_, _, body := makeGetRequest(newMux(s), "/admin/subscribers/3")
+		is.True(strings.Contains(body, `id="tag-api"`))
+		is.True(strings.Contains(body, `action="/admin/subscribers/3/tags/beta"`))
+		is.True(strings.Contains(body, `<form action="/admin/subscribers/3/tags" method="post"`))
+		is.True(!strings.Contains(body, "No tags."))
+	})
+
+	t.Run("says when there are more emails than shown", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		for i := 0; i < 100; i++ {
+			s.sends = append(s.sends, model.EmailSend{ID: int64(i + 10), Type: "welcome", Status: model.EmailSendStatusSent})
+		}
+		_, _, body := makeGetRequest(newMux(s), "/admin/subscribers/3")
+		is.True(strings.Contains(body, `id="sends-capped"`))
+	})
+
+	t.Run("returns 404 for an unknown or invalid ID, without listing sends", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		mux := newMux(s)
+		for _, target := range []string{"/admin/subscribers/4", "/admin/subscribers/nope"} {
+			code, _, _ := makeGetRequest(mux, target)
+			is.Equal(http.StatusNotFound, code)
+		}
+		is.Equal(storage.ListEmailSendsOptions{}, s.sendsOpts)
+	})
+
+	t.Run("returns 500 if listing sends fails", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		s.err = errors.New("oh no")
+		code, _, _ := makeGetRequest(newMux(s), "/admin/subscribers/3")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
+
+// subscriberChangerMock changes subscribers by ID, if they're at the version.
+type subscriberChangerMock struct {
+	err         error
+	subscribers map[int64]model.Subscriber
+	actions     []string
+}
+
+func (s *subscriberChangerMock) change(action string, id int64, version time.Time, actor string) (model.Email, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	sub, ok := s.subscribers[id]
+	if !ok || !sub.Updated.Equal(version) {
+		return "", storage.ErrConflict
+	}
+	s.actions = append(s.actions, fmt.Sprintf("%v %v by %v", action, id, actor))
+	sub.Updated = sub.Updated.Add(time.Second)
+	s.subscribers[id] = sub
+	return sub.Email, nil
+}
+
+func (s *subscriberChangerMock) DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.change("delete", id, version, actor)
+}
+
+func (s *subscriberChangerMock) ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.change("confirm", id, version, actor)
+}
+
+func (s *subscriberChangerMock) UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.change("unsubscribe", id, version, actor)
+}
+
+func (s *subscriberChangerMock) ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.change("clear complaint", id, version, actor)
+}
+
+var flashMatcher = regexp.MustCompile(`data-flash="(\w+)"[^>]*><span>([^<]*)</span>`)
+
+func TestAdminSubscriberActions(t *testing.T) {
+	version := time.Date(2022, 12, 10, 12, 0, 0, 1000, time.UTC)
+
+	m := newSessionManager()
+	session := logInAdmin(t, m, 0)
+
+	newMux := func(s *subscriberChangerMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			handlers.AdminSubscribers(r, newSubscriberListerMock(0), zap.NewNop())
+			handlers.AdminSubscriberActions(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	newChangerMock := func() *subscriberChangerMock {
+		return &subscriberChangerMock{subscribers: map[int64]model.Subscriber{
+			1: {ID: 1, Email: "me@example.com", Active: true, Updated: version},
+		}}
+	}
+
+	// post the form to the target, and return the redirect location and the flash on the page redirected to.
+	post := func(mux chi.Router, target, body string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header = createFormHeader()
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		location := res.Header().Get("Location")
+		if location == "" {
+			return res.Code, "", ""
+		}
+
+		req = httptest.NewRequest(http.MethodGet, location, nil)
+		req.AddCookie(session)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res2 := httptest.NewRecorder()
+		mux.ServeHTTP(res2, req)
+		flash := flashMatcher.FindStringSubmatch(res2.Body.String())
+		if flash == nil {
+			return res.Code, location, ""
+		}
+		return res.Code, location, flash[1] + ": " + html.UnescapeString(flash[2])
+	}
+
+	form := func(v url.Values) string {
+		v.Set("version", "1670673600000001")
+		v.Set("redirect", "/admin/subscribers?status=pending")
+		return v.Encode()
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		action string
+		flash  string
+	}{
+		{"deletes", "/admin/subscribers/1", form(url.Values{"_method": {"DELETE"}}), "delete 1 by admin", "success: Deleted me@example.com."},
+		{"confirms", "/admin/subscribers/1/confirm", form(url.Values{}), "confirm 1 by admin", "success: Confirmed me@example.com."},
+		{"unsubscribes", "/admin/subscribers/1/unsubscribe", form(url.Values{}), "unsubscribe 1 by admin", "success: Unsubscribed me@example.com."},
+		{"clears the complaint of", "/admin/subscribers/1/clear-complaint", form(url.Values{}), "clear complaint 1 by admin",
+			"success: Cleared the complaint of me@example.com. They can sign up again."},
+	}
+	for _, test := range tests {
+		t.Run(test.name+" the subscriber, and redirects back with a flash", func(t *testing.T) {
+			is := is.New(t)
+
+			s := newChangerMock()
+			code, location, flash := post(newMux(s), test.target, test.body)
+			is.Equal(http.StatusSeeOther, code)
+			is.Equal("/admin/subscribers?status=pending", location)
+			is.Equal(test.flash, flash)
+			is.Equal([]string{test.action}, s.actions)
+		})
+	}
+
+	t.Run("shows a conflict flash for a stale version, an unknown subscriber, or an invalid ID", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newChangerMock()
+		mux := newMux(s)
+		_, _, flash := post(mux, "/admin/subscribers/1/confirm", form(url.Values{}))
+		is.Equal("success: Confirmed me@example.com.", flash)
+
+		// The version is stale after the first change.
+		for _, target := range []string{"/admin/subscribers/1/unsubscribe", "/admin/subscribers/2/confirm", "/admin/subscribers/nope/confirm"} {
+			code, location, flash := post(mux, target, form(url.Values{}))
+			is.Equal(http.StatusSeeOther, code)
+			is.Equal("/admin/subscribers?status=pending", location)
+			is.True(strings.HasPrefix(flash, "error: That subscriber was changed or deleted in the meantime"))
+		}
+		is.Equal(1, len(s.actions))
+	})
+
+	t.Run("renders an error page if changing fails for another reason", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newChangerMock()
+		s.err = errors.New("oh no")
+		code, _, _ := post(newMux(s), "/admin/subscribers/1/unsubscribe", form(url.Values{}))
+		is.Equal(http.StatusInternalServerError, code)
+	})
+
+	t.Run("redirects to the subscriber list instead of other sites", func(t *testing.T) {
+		is := is.New(t)
+
+		_, location, _ := post(newMux(newChangerMock()), "/admin/subscribers/1/confirm",
+			url.Values{"version": {"1670673600000001"}, "redirect": {"https://example.com"}}.Encode())
+		is.Equal("/admin/subscribers", location)
+	})
+}
+
+func TestAdminAuth(t *testing.T) {
+	newMux := func(m *sessions.Manager) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(m.Middleware)
+		mux.Group(func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			r.Get("/admin/secret", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("secret"))
+			})
+		})
+		return mux
+	}
+
+	getWithSession := func(mux chi.Router, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/admin/secret", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("lets a session with an admin login through", func(t *testing.T) {
+		is := is.New(t)
+
+		m := newSessionManager()
+		res := getWithSession(newMux(m), logInAdmin(t, m, 0))
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("secret", res.Body.String())
+	})
+
+	t.Run("redirects to the login page without an admin login", func(t *testing.T) {
+		is := is.New(t)
+
+		res := getWithSession(newMux(newSessionManager()), nil)
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/admin/login?redirect=%2Fadmin%2Fsecret", res.Header().Get("Location"))
+	})
+
+	t.Run("redirects to the login page with an expired login", func(t *testing.T) {
+		is := is.New(t)
+
+		m := newSessionManager()
+		res := getWithSession(newMux(m), logInAdmin(t, m, time.Nanosecond))
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/admin/login?redirect=%2Fadmin%2Fsecret", res.Header().Get("Location"))
+	})
+
+	t.Run("redirects to the login page with the session of another manager", func(t *testing.T) {
+		is := is.New(t)
+
+		other := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("other secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		res := getWithSession(newMux(newSessionManager()), logInAdmin(t, other, 0))
+		is.Equal(http.StatusFound, res.Code)
+	})
+}
+
+// throttlerMock throttles after the limit, counting per key.
+type throttlerMock struct {
+	counts map[string]int
+}
+
+func (t *throttlerMock) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	t.counts[key]++
+	return t.counts[key] > limit, nil
+}
+
+func TestAdminLogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newMux := func(m *sessions.Manager, log *zap.Logger) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(m.Middleware)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminLogin(r, log, handlers.AdminLoginOptions{
+				PasswordHash: hash,
+				Throttle:     &throttlerMock{counts: map[string]int{}},
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(handlers.AdminAuth(zap.NewNop()))
+				r.Get("/subscribers", func(w http.ResponseWriter, r *http.Request) {
+					_, _ = w.Write([]byte("subscribers"))
+				})
+			})
+		})
+		return mux
+	}
+
+	login := func(mux chi.Router, password, redirect string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		body := url.Values{"password": {password}, "redirect": {redirect}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/login", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// isLoggedIn if the admin pages can be seen with the session cookie.
+	isLoggedIn := func(mux chi.Router, c *http.Cookie) bool {
+		req := httptest.NewRequest(http.MethodGet, "/admin/subscribers", nil)
+		req.AddCookie(c)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code == http.StatusOK
+	}
+
+	t.Run("renders the login form with the redirect", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makeGetRequest(newMux(newSessionManager(), zap.NewNop()), "/admin/login?redirect=%2Fadmin%2Fsubscribers%3Fstatus%3Dpending")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `<input type="password" name="password"`))
+		is.True(strings.Contains(body, `<input type="hidden" name="redirect" value="/admin/subscribers?status=pending">`))
+	})
+
+	t.Run("logs in to a secure session cookie and redirects back to the original URL", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSessionManager(), zap.NewNop())
+		res := login(mux, "correct horse", "/admin/subscribers?status=pending")
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/admin/subscribers?status=pending", res.Header().Get("Location"))
+
+		c := sessionCookie(res)
+		is.True(c != nil)
+		is.True(c.Secure)
+		is.True(c.HttpOnly)
+		is.Equal(http.SameSiteLaxMode, c.SameSite)
+		is.True(isLoggedIn(mux, c))
+	})
+
+	t.Run("redirects to the subscriber list instead of other sites", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, redirect := range []string{"", "https://example.com", "//example.com", "/\\example.com"} {
+			res := login(newMux(newSessionManager(), zap.NewNop()), "correct horse", redirect)
+			is.Equal(http.StatusFound, res.Code)
+			is.Equal("/admin/subscribers", res.Header().Get("Location"))
+		}
+	})
+
+	t.Run("regenerates the session on login, so a planted session isn't logged in", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSessionManager(), zap.NewNop())
+		mux.Get("/plant", func(w http.ResponseWriter, r *http.Request) {
+			_ = sessions.Set(r.Context(), "locale", "da")
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/plant", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		planted := sessionCookie(res)
+		is.True(planted != nil)
+
+		res = login(mux, "correct horse", "", planted)
+		is.Equal(http.StatusFound, res.Code)
+		c := sessionCookie(res)
+		is.True(c != nil)
+		is.True(c.Value != planted.Value)
+		is.True(isLoggedIn(mux, c))
+		is.True(!isLoggedIn(mux, planted))
+	})
+
+	t.Run("responds with 401 and logs the IP address on a wrong password", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zap.InfoLevel)
+		res := login(newMux(newSessionManager(), zap.New(core)), "wrong", "/admin/subscribers")
+		is.Equal(http.StatusUnauthorized, res.Code)
+		is.True(strings.Contains(res.Body.String(), "That password isn&#39;t right."))
+		is.True(sessionCookie(res) == nil)
+
+		failed := logs.FilterMessage("Failed admin login").All()
+		is.Equal(1, len(failed))
+		is.Equal("192.0.2.1", failed[0].ContextMap()["ip"])
+	})
+
+	t.Run("rate-limits login attempts per IP address", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newSessionManager(), zap.NewNop())
+		for i := 0; i < 10; i++ {
+			res := login(mux, "wrong", "")
+			is.Equal(http.StatusUnauthorized, res.Code)
+		}
+
+		res := login(mux, "correct horse", "")
+		is.Equal(http.StatusTooManyRequests, res.Code)
+		is.True(res.Header().Get("Retry-After") != "")
+		is.True(sessionCookie(res) == nil)
+	})
+}
+
+func TestAdminLogout(t *testing.T) {
+	t.Run("logs out of the session, and redirects to the login page", func(t *testing.T) {
+		is := is.New(t)
+
+		m := newSessionManager()
+		session := logInAdmin(t, m, 0)
+		mux := chi.NewMux()
+		mux.Use(m.Middleware)
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			handlers.AdminLogout(r, zap.NewNop())
+			r.Get("/secret", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("secret"))
+			})
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/logout", nil)
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/admin/login", res.Header().Get("Location"))
+
+		// The session has a new ID, with the flash, and neither it nor the old one is logged in.
+		c := sessionCookie(res)
+		is.True(c != nil)
+		is.True(c.Value != session.Value)
+		for _, c := range []*http.Cookie{session, c} {
+			req := httptest.NewRequest(http.MethodGet, "/admin/secret", nil)
+			req.AddCookie(c)
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+			is.Equal(http.StatusFound, res.Code)
+		}
+	})
+}
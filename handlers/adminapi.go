@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/apperr"
+	"canvas/model"
+	"canvas/storage"
+	"canvas/views"
+)
+
+// apiSubscriber is the JSON representation of a subscriber in the admin API.
+type apiSubscriber struct {
+	ID          int64                  `json:"id"`
+	Email       model.Email            `json:"email" openapi:"format=email"`
+	Status      model.SubscriberStatus `json:"status" openapi:"enum=pending|confirmed|unsubscribed|bounced|complained"`
+	Locale      string                 `json:"locale,omitempty"`
+	Source      string                 `json:"source,omitempty"`
+	ConfirmedAt *time.Time             `json:"confirmedAt,omitempty"`
+	Created     time.Time              `json:"created"`
+	Updated     time.Time              `json:"updated"`
+	// Version for changing the subscriber, which is the Updated time in microseconds, like in the admin pages.
+	Version int64 `json:"version" doc:"The version to send when changing the subscriber."`
+}
+
+func newAPISubscriber(s model.Subscriber) apiSubscriber {
+	return apiSubscriber{
+		ID:          s.ID,
+		Email:       s.Email,
+		Status:      s.Status(),
+		Locale:      s.Locale,
+		Source:      s.Source,
+		ConfirmedAt: s.ConfirmedAt,
+		Created:     s.Created,
+		Updated:     s.Updated,
+		Version:     s.Updated.UnixMicro(),
+	}
+}
+
+type apiSubscribersResponse struct {
+	Subscribers []apiSubscriber `json:"subscribers"`
+	// Next is the cursor for the next page in the after query parameter, or empty on the last page.
+	Next string `json:"next,omitempty" doc:"The cursor of the next page, for the after query parameter. There's none on the last page."`
+}
+
+// apiSubscriberChangeRequest is the JSON body of the admin API requests changing a subscriber.
+type apiSubscriberChangeRequest struct {
+	Version int64 `json:"version" doc:"The version of the subscriber the change is for. It's not done if the subscriber changed since."`
+}
+
+// apiNewsletterSendRequest is the JSON body of the admin API request sending a newsletter issue.
+type apiNewsletterSendRequest struct {
+	Force   bool          `json:"force,omitempty" doc:"Whether to send the issue again, if it's been sent already."`
+	Segment model.Segment `json:"segment,omitempty" doc:"Segment of the subscribers to send the issue to, as tags with and, or, and parentheses, like \"api and (beta or early)\". Everyone, if it's empty."`
+}
+
+type apiSubscriberStore interface {
+	ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error)
+	GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error)
+}
+
+// adminAPIMaxPageSize is how many subscribers can be listed at once with the admin API, which is also the default.
+const adminAPIMaxPageSize = 1000
+
+// AdminAPISubscribers on a router mounted at /api/admin, for reading subscribers with the admin API.
+// GET /subscribers lists a page of subscribers, filtered by the status query parameter, with up to limit of them,
+// and the cursor of the next page for the after query parameter. GET /subscribers/{id} gets one of them.
+func AdminAPISubscribers(mux chi.Router, s apiSubscriberStore, log *zap.Logger) {
+	mux.Get("/subscribers", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+		limit := adminAPIMaxPageSize
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > adminAPIMaxPageSize {
+				return fmt.Errorf("invalid limit %q: %w", v, apperr.Invalid)
+			}
+			limit = n
+		}
+
+		subscribers, err := s.ListSubscribers(r.Context(), storage.ListSubscribersOptions{
+			After:  decodeCursor(query.Get(views.PageAfterParam)),
+			Limit:  limit + 1,
+			Status: parseSubscriberStatus(query.Get("status")),
+		})
+		if err != nil {
+			return fmt.Errorf("error listing subscribers: %w", err)
+		}
+
+		res := apiSubscribersResponse{Subscribers: []apiSubscriber{}}
+		// The extra subscriber tells whether there's a next page.
+		if len(subscribers) > limit {
+			subscribers = subscribers[:limit]
+			res.Next = encodeCursor(subscribers[len(subscribers)-1].Email)
+		}
+		for _, subscriber := range subscribers {
+			res.Subscribers = append(res.Subscribers, newAPISubscriber(subscriber))
+		}
+		writeJSON(w, http.StatusOK, res)
+		return nil
+	}))
+
+	mux.Get("/subscribers/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid subscriber ID: %w", storage.ErrNotFound)
+		}
+		subscriber, err := s.GetSubscriber(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("error getting subscriber: %w", err)
+		}
+		if subscriber == nil {
+			return fmt.Errorf("no subscriber with ID %v: %w", id, storage.ErrNotFound)
+		}
+		writeJSON(w, http.StatusOK, newAPISubscriber(*subscriber))
+		return nil
+	}))
+}
+
+// AdminAPISubscriberActions on a router mounted at /api/admin, for changing subscribers with the admin API,
+// like AdminSubscriberActions does for the admin pages: DELETE /subscribers/{id}, POST /subscribers/{id}/confirm,
+// POST /subscribers/{id}/unsubscribe, and POST /subscribers/{id}/clear-complaint.
+// The JSON body has the version of the subscriber, and the change isn't done if it changed or was deleted since,
+// with 409 Conflict. Each is recorded in the audit log with the API token as the actor.
+func AdminAPISubscriberActions(mux chi.Router, s subscriberChanger, log *zap.Logger) {
+	type change func(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+
+	action := func(name, status string, change change) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			var req apiSubscriberChangeRequest
+			if err := decodeAPIRequest(w, r, &req); err != nil {
+				return err
+			}
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid subscriber ID: %w", storage.ErrNotFound)
+			}
+			t, _ := apiToken(r.Context())
+			if _, err := change(r.Context(), id, time.UnixMicro(req.Version).UTC(), storage.APITokenActor(t)); err != nil {
+				return fmt.Errorf("error changing subscriber with %v: %w", name, err)
+			}
+			requestLog(r.Context(), log).Info("Changed subscriber", zap.String("action", name), zap.Int64("id", id))
+			writeJSON(w, http.StatusOK, statusResponse{Status: status})
+			return nil
+		})
+	}
+
+	mux.Delete("/subscribers/{id}", action("delete", "deleted", s.DeleteSubscriber))
+	mux.Post("/subscribers/{id}/confirm", action("confirm", "confirmed", s.ConfirmSubscriber))
+	mux.Post("/subscribers/{id}/unsubscribe", action("unsubscribe", "unsubscribed", s.UnsubscribeSubscriber))
+	mux.Post("/subscribers/{id}/clear-complaint", action("clear complaint", "cleared", s.ClearComplaint))
+}
+
+// AdminAPINewsletterSend on a router mounted at /api/admin, for sending a newsletter issue with the admin API,
+// like AdminNewsletterSend does for the admin pages. POST /newsletters/{id}/send queues the send, answering
+// 202 Accepted. The JSON body can have force set to true to send an issue again, and a segment to send it to only
+// the subscribers in it. An issue without a title or body can't be sent, and neither can one with an invalid segment,
+// with 422 Unprocessable Entity, or one being sent already, or already sent without force, with 409 Conflict.
+func AdminAPINewsletterSend(mux chi.Router, s newsletterSendStore, log *zap.Logger) {
+	mux.Post("/newsletters/{id}/send", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		var req apiNewsletterSendRequest
+		if err := decodeAPIRequest(w, r, &req); err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid newsletter ID: %w", storage.ErrNotFound)
+		}
+		n, err := s.GetNewsletter(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("error getting newsletter: %w", err)
+		}
+		if n == nil {
+			return fmt.Errorf("no newsletter with ID %v: %w", id, storage.ErrNotFound)
+		}
+
+		if reason := unpublishableReason(*n); reason != "" {
+			writeProblem(w, problem{Status: http.StatusUnprocessableEntity, Detail: "This issue can't be sent, because " + reason + "."})
+			return nil
+		}
+
+		if _, err := req.Segment.Parse(); err != nil {
+			writeProblem(w, problem{Status: http.StatusUnprocessableEntity, Detail: "The segment isn't valid: " + err.Error() + ".",
+				Errors: map[string]string{"body.segment": err.Error()}})
+			return nil
+		}
+
+		err = s.QueueNewsletterSend(r.Context(), n.ID, req.Force, req.Segment)
+		switch {
+		case errors.Is(err, storage.ErrSendInProgress):
+			writeProblem(w, problem{Status: http.StatusConflict, Detail: "This issue is being sent already."})
+		case errors.Is(err, storage.ErrAlreadySent):
+			writeProblem(w, problem{Status: http.StatusConflict, Detail: "This issue has been sent already. Set force to send it again."})
+		case err != nil:
+			return fmt.Errorf("error queueing newsletter send: %w", err)
+		default:
+			requestLog(r.Context(), log).Info("Queued newsletter send", zap.Int64("newsletterID", n.ID), zap.Bool("force", req.Force),
+				zap.Stringer("segment", req.Segment.Normalize()))
+			writeJSON(w, http.StatusAccepted, statusResponse{Status: "queued"})
+		}
+		return nil
+	}))
+}
+
+// decodeAPIRequest body as JSON into v. An empty body leaves v as it is, and anything else that isn't JSON is invalid.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v any) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error decoding request body: %v: %w", err, apperr.Invalid)
+	}
+	return nil
+}
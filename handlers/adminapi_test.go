@@ -0,0 +1,243 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+type apiSubscriberStoreMock struct {
+	subscriberListerMock
+}
+
+func (s *apiSubscriberStoreMock) GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error) {
+	for _, sub := range s.subscribers {
+		if sub.ID == id {
+			return &sub, nil
+		}
+	}
+	return nil, nil
+}
+
+// newAPIMux with the admin API routes of register under /api/admin, authenticated with the token "canvas_secretapi",
+// which has all scopes.
+func newAPIMux(register func(r chi.Router)) chi.Router {
+	tokens := &apiTokenStoreMock{now: time.Now()}
+	_, _, _ = tokens.CreateAPIToken(context.Background(), "api", model.APIScopes, nil, storage.AuditActorAdmin)
+	mux := chi.NewMux()
+	mux.Route("/api/admin", func(r chi.Router) {
+		r.Use(handlers.APITokenAuth(tokens, zap.NewNop()))
+		register(r)
+	})
+	return mux
+}
+
+func makeAPIRequest(mux chi.Router, method, target, body string) (int, string) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer canvas_secretapi")
+	req.Header.Set("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	mux.ServeHTTP(res, req)
+	return res.Code, res.Body.String()
+}
+
+func TestAdminAPISubscribers(t *testing.T) {
+	created := time.Date(2022, 12, 10, 12, 0, 0, 1000, time.UTC)
+	s := &apiSubscriberStoreMock{subscriberListerMock{subscribers: []model.Subscriber{
+		{ID: 1, Email: "a@example.com", Active: true, Confirmed: true, Created: created, Updated: created},
+		{ID: 2, Email: "b@example.com", Active: true, Created: created, Updated: created},
+		{ID: 3, Email: "c@example.com", Active: true, Confirmed: true, Created: created, Updated: created},
+	}}}
+	mux := newAPIMux(func(r chi.Router) {
+		handlers.AdminAPISubscribers(r, s, zap.NewNop())
+	})
+
+	type page struct {
+		Subscribers []struct {
+			ID      int64
+			Email   string
+			Status  string
+			Version int64
+		}
+		Next string
+	}
+
+	t.Run("lists pages of subscribers", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers?limit=2", "")
+		is.Equal(http.StatusOK, code)
+		var p page
+		is.NoErr(json.Unmarshal([]byte(body), &p))
+		is.Equal(2, len(p.Subscribers))
+		is.Equal("a@example.com", p.Subscribers[0].Email)
+		is.Equal("confirmed", p.Subscribers[0].Status)
+		is.Equal(created.UnixMicro(), p.Subscribers[0].Version)
+		is.True(p.Next != "")
+
+		code, body = makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers?limit=2&after="+p.Next, "")
+		is.Equal(http.StatusOK, code)
+		p = page{}
+		is.NoErr(json.Unmarshal([]byte(body), &p))
+		is.Equal(1, len(p.Subscribers))
+		is.Equal("c@example.com", p.Subscribers[0].Email)
+		is.Equal("", p.Next)
+	})
+
+	t.Run("filters by status", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers?status=pending", "")
+		is.Equal(http.StatusOK, code)
+		var p page
+		is.NoErr(json.Unmarshal([]byte(body), &p))
+		is.Equal(1, len(p.Subscribers))
+		is.Equal("b@example.com", p.Subscribers[0].Email)
+	})
+
+	t.Run("rejects an invalid limit", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _ := makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers?limit=1001", "")
+		is.Equal(http.StatusUnprocessableEntity, code)
+	})
+
+	t.Run("gets a subscriber", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers/2", "")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `"email":"b@example.com"`))
+
+		code, _ = makeAPIRequest(mux, http.MethodGet, "/api/admin/subscribers/4", "")
+		is.Equal(http.StatusNotFound, code)
+	})
+}
+
+func TestAdminAPISubscriberActions(t *testing.T) {
+	version := time.Date(2022, 12, 10, 12, 0, 0, 1000, time.UTC)
+
+	t.Run("changes the subscriber with the API token as the actor", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberChangerMock{subscribers: map[int64]model.Subscriber{1: {ID: 1, Updated: version}}}
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPISubscriberActions(r, s, zap.NewNop())
+		})
+		code, body := makeAPIRequest(mux, http.MethodPost, "/api/admin/subscribers/1/confirm",
+			fmt.Sprintf(`{"version":%v}`, version.UnixMicro()))
+		is.Equal(http.StatusOK, code)
+		is.Equal(`{"status":"confirmed"}`, strings.TrimSpace(body))
+		is.Equal([]string{"confirm 1 by api_token/1"}, s.actions)
+	})
+
+	t.Run("doesn't change a subscriber changed since", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberChangerMock{subscribers: map[int64]model.Subscriber{1: {ID: 1, Updated: version}}}
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPISubscriberActions(r, s, zap.NewNop())
+		})
+		code, _ := makeAPIRequest(mux, http.MethodDelete, "/api/admin/subscribers/1", `{"version":1}`)
+		is.Equal(http.StatusConflict, code)
+		is.Equal(0, len(s.actions))
+	})
+
+	t.Run("rejects a body that isn't JSON", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberChangerMock{subscribers: map[int64]model.Subscriber{1: {ID: 1, Updated: version}}}
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPISubscriberActions(r, s, zap.NewNop())
+		})
+		code, _ := makeAPIRequest(mux, http.MethodPost, "/api/admin/subscribers/1/unsubscribe", "version=1")
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.Equal(0, len(s.actions))
+	})
+}
+
+func TestAdminAPINewsletterSend(t *testing.T) {
+	t.Run("queues the send", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPINewsletterSend(r, s, zap.NewNop())
+		})
+		code, body := makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", "")
+		is.Equal(http.StatusAccepted, code)
+		is.Equal(`{"status":"queued"}`, strings.TrimSpace(body))
+		is.True(s.send != nil)
+	})
+
+	t.Run("doesn't send an issue again without force", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.send = &model.NewsletterSend{NewsletterID: 1, State: model.NewsletterSendStateCompleted}
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPINewsletterSend(r, s, zap.NewNop())
+		})
+		code, _ := makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", "")
+		is.Equal(http.StatusConflict, code)
+
+		code, _ = makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", `{"force":true}`)
+		is.Equal(http.StatusAccepted, code)
+	})
+
+	t.Run("doesn't send an issue without a body", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.newsletter.Body = ""
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPINewsletterSend(r, s, zap.NewNop())
+		})
+		code, _ := makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", "")
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.True(s.send == nil)
+
+		code, _ = makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/2/send", "")
+		is.Equal(http.StatusNotFound, code)
+	})
+
+	t.Run("queues the send to the segment", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPINewsletterSend(r, s, zap.NewNop())
+		})
+		code, _ := makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", `{"segment":"api and beta"}`)
+		is.Equal(http.StatusAccepted, code)
+		is.Equal(model.Segment("api and beta"), s.send.Segment)
+		is.Equal(1, s.send.Total)
+	})
+
+	t.Run("doesn't send to an invalid segment", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := newAPIMux(func(r chi.Router) {
+			handlers.AdminAPINewsletterSend(r, s, zap.NewNop())
+		})
+		code, body := makeAPIRequest(mux, http.MethodPost, "/api/admin/newsletters/1/send", `{"segment":"api or"}`)
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.True(strings.Contains(body, `"body.segment":"expected a tag or (, but the segment ends, at character 7"`))
+		is.True(s.send == nil)
+	})
+}
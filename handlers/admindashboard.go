@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/views"
+)
+
+type dashboardStore interface {
+	SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error)
+	SendStats(ctx context.Context, days int) (model.SendStats, error)
+}
+
+type queueDepther interface {
+	Depth(ctx context.Context) (int, error)
+}
+
+type nextRunner interface {
+	NextRuns() map[string]time.Time
+}
+
+// AdminDashboardOptions for AdminDashboard.
+type AdminDashboardOptions struct {
+	// Queue of jobs, to show how many are waiting. Without it, the queue section is unavailable.
+	Queue queueDepther
+	// Scheduler of recurring jobs, to show when they run next. Without it, there's no schedules section.
+	Scheduler nextRunner
+	// Timeout for getting all the stats. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+const (
+	dashboardDays           = 30
+	defaultDashboardTimeout = 5 * time.Second
+)
+
+// AdminDashboard shows subscriber, sending, and queue stats for the last 30 days at /, on a router mounted at /admin.
+// The stats come from independent sources, so they're fetched concurrently, within a shared timeout.
+// A source that fails or doesn't make it in time is logged and shown as unavailable, and the rest of the page renders.
+func AdminDashboard(mux chi.Router, s dashboardStore, log *zap.Logger, opts AdminDashboardOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultDashboardTimeout
+	}
+
+	mux.Get("/", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout)
+		defer cancel()
+
+		subscribers := fetch(ctx, func(ctx context.Context) (model.SubscriberStats, error) {
+			return s.SubscriberStats(ctx, dashboardDays)
+		})
+		sends := fetch(ctx, func(ctx context.Context) (model.SendStats, error) {
+			return s.SendStats(ctx, dashboardDays)
+		})
+		queue := fetch(ctx, func(ctx context.Context) (int, error) {
+			if opts.Queue == nil {
+				return 0, errNoQueue
+			}
+			return opts.Queue.Depth(ctx)
+		})
+
+		props := views.AdminDashboardProps{
+			CSRFToken: CSRFToken(r),
+			Days:      dashboardDays,
+			Flashes:   sessions.ConsumeFlashes(r.Context()),
+		}
+		if opts.Scheduler != nil {
+			props.NextRuns = opts.Scheduler.NextRuns()
+		}
+		if v, err := await(ctx, subscribers); err != nil {
+			requestLog(r.Context(), log).Info("Error getting subscriber stats", zap.Error(err))
+		} else {
+			props.Subscribers = &v
+		}
+		if v, err := await(ctx, sends); err != nil {
+			requestLog(r.Context(), log).Info("Error getting send stats", zap.Error(err))
+		} else {
+			props.Sends = &v
+		}
+		if v, err := await(ctx, queue); err != nil {
+			requestLog(r.Context(), log).Info("Error getting queue depth", zap.Error(err))
+		} else {
+			props.QueueDepth = &v
+		}
+		return render(w, http.StatusOK, views.AdminDashboard(props))
+	}))
+}
+
+var errNoQueue = errors.New("no queue")
+
+// result of a fetch.
+type result[T any] struct {
+	v   T
+	err error
+}
+
+// fetch with f in a goroutine, for await.
+func fetch[T any](ctx context.Context, f func(ctx context.Context) (T, error)) <-chan result[T] {
+	c := make(chan result[T], 1)
+	go func() {
+		v, err := f(ctx)
+		c <- result[T]{v: v, err: err}
+	}()
+	return c
+}
+
+// await the result of a fetch, or ctx.Err() if ctx is done first, even if the fetch doesn't respect ctx.
+// A result that's already there is returned even if ctx is done, since it made it in time.
+func await[T any](ctx context.Context, c <-chan result[T]) (T, error) {
+	select {
+	case r := <-c:
+		return r.v, r.err
+	default:
+	}
+	select {
+	case r := <-c:
+		return r.v, r.err
+	case <-ctx.Done():
+		var v T
+		return v, ctx.Err()
+	}
+}
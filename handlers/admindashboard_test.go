@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+)
+
+type dashboardStoreMock struct {
+	subscribersErr error
+	sendsErr       error
+	// block SendStats until it's closed, ignoring the context, like a stuck query.
+	block chan struct{}
+}
+
+func (s *dashboardStoreMock) SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error) {
+	return model.SubscriberStats{
+		ByStatus:      map[model.SubscriberStatus]int{model.SubscriberStatusConfirmed: 3, model.SubscriberStatusPending: 1},
+		SignupsPerDay: make([]int, days),
+		Signups:       4,
+		Confirmations: 3,
+	}, s.subscribersErr
+}
+
+func (s *dashboardStoreMock) SendStats(ctx context.Context, days int) (model.SendStats, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	return model.SendStats{Sent: 200, Failed: 1, Bounced: 2, Complained: 1}, s.sendsErr
+}
+
+type queueDepthMock int
+
+func (q queueDepthMock) Depth(ctx context.Context) (int, error) {
+	return int(q), nil
+}
+
+type nextRunsMock map[string]time.Time
+
+func (n nextRunsMock) NextRuns() map[string]time.Time {
+	return n
+}
+
+var sectionMatcher = regexp.MustCompile(`<section id="(\w+)"[^>]*>(.*?)</section>`)
+
+// sections of the dashboard in the body, by ID.
+func sections(body string) map[string]string {
+	sections := map[string]string{}
+	for _, match := range sectionMatcher.FindAllStringSubmatch(body, -1) {
+		sections[match[1]] = match[2]
+	}
+	return sections
+}
+
+func TestAdminDashboard(t *testing.T) {
+	get := func(s *dashboardStoreMock, opts handlers.AdminDashboardOptions) (int, string) {
+		mux := chi.NewMux()
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminDashboard(r, s, zap.NewNop(), opts)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+
+	t.Run("renders the stats of each section", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := get(&dashboardStoreMock{}, handlers.AdminDashboardOptions{Queue: queueDepthMock(7)})
+		is.Equal(http.StatusOK, code)
+		sections := sections(body)
+		is.True(strings.Contains(sections["subscribers"], `<dt class="text-sm text-gray-500">Total</dt><dd class="text-2xl font-semibold">4</dd>`))
+		is.True(strings.Contains(sections["subscribers"], `75.0%`))
+		is.True(strings.Contains(sections["subscribers"], `<polyline`))
+		is.True(strings.Contains(sections["sending"], `<dt class="text-sm text-gray-500">Bounce rate</dt><dd class="text-2xl font-semibold">1.0%</dd>`))
+		is.True(strings.Contains(sections["sending"], `0.5%`))
+		is.True(strings.Contains(sections["queue"], `<dd class="text-2xl font-semibold">7</dd>`))
+		is.True(!strings.Contains(body, "Unavailable"))
+	})
+
+	t.Run("renders the sections that are available if others fail", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body := get(&dashboardStoreMock{sendsErr: errors.New("oh no")}, handlers.AdminDashboardOptions{})
+		is.Equal(http.StatusOK, code)
+		sections := sections(body)
+		is.True(strings.Contains(sections["subscribers"], "Signups per day"))
+		is.True(strings.Contains(sections["sending"], "Unavailable right now."))
+		is.True(strings.Contains(sections["queue"], "Unavailable right now."))
+	})
+
+	t.Run("renders within the timeout, even if a source doesn't respect it", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &dashboardStoreMock{block: make(chan struct{})}
+		defer close(s.block)
+
+		start := time.Now()
+		code, body := get(s, handlers.AdminDashboardOptions{Queue: queueDepthMock(7), Timeout: 50 * time.Millisecond})
+		is.True(time.Since(start) < time.Second)
+		is.Equal(http.StatusOK, code)
+		sections := sections(body)
+		is.True(strings.Contains(sections["sending"], "Unavailable right now."))
+		is.True(strings.Contains(sections["queue"], "7"))
+	})
+
+	t.Run("renders the next runs of the scheduled jobs, if there's a scheduler", func(t *testing.T) {
+		is := is.New(t)
+
+		_, body := get(&dashboardStoreMock{}, handlers.AdminDashboardOptions{Scheduler: nextRunsMock{
+			"weekly-digest": time.Date(2022, 10, 17, 8, 0, 0, 0, time.UTC),
+			"cleanup":       time.Date(2022, 10, 14, 3, 0, 0, 0, time.UTC),
+		}})
+		schedules := sections(body)["schedules"]
+		is.True(regexp.MustCompile(`<tr id="schedule-cleanup">.*?>2022-10-14 03:00 UTC</td></tr><tr id="schedule-weekly-digest">.*?>2022-10-17 08:00 UTC</td>`).MatchString(schedules))
+
+		_, body = get(&dashboardStoreMock{}, handlers.AdminDashboardOptions{Scheduler: nextRunsMock{}})
+		is.True(strings.Contains(sections(body)["schedules"], "No scheduled jobs."))
+
+		_, body = get(&dashboardStoreMock{}, handlers.AdminDashboardOptions{})
+		_, ok := sections(body)["schedules"]
+		is.True(!ok)
+	})
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type subscriberExporter interface {
+	CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error)
+	ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error
+	RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error
+}
+
+// AdminSubscriberExportOptions for AdminSubscriberExport.
+type AdminSubscriberExportOptions struct {
+	// MaxRows is the most subscribers that can be exported at once. Defaults to 100,000.
+	MaxRows int
+	// Now is for the date in the file name. Defaults to time.Now.
+	Now func() time.Time
+}
+
+const (
+	defaultMaxSubscriberExportRows = 100_000
+	// subscriberExportFlushRows is how many rows are written between flushes, so the download shows progress.
+	subscriberExportFlushRows = 1000
+)
+
+// AdminSubscriberExport downloads the subscribers as CSV at /subscribers/export, on a router mounted at /admin,
+// filtered by the status query parameter like the subscriber list.
+// The rows are streamed as they're read from the database, and each export is recorded in the audit log.
+// Lists with more than MaxRows subscribers aren't exported, and the admin is sent back to the list with an error flash.
+// If the admin cancels the download, the database query is cancelled with it.
+func AdminSubscriberExport(mux chi.Router, s subscriberExporter, log *zap.Logger, opts AdminSubscriberExportOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.MaxRows == 0 {
+		opts.MaxRows = defaultMaxSubscriberExportRows
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	mux.Get("/subscribers/export", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		status := parseSubscriberStatus(r.URL.Query().Get("status"))
+		filter := string(status)
+		if filter == "" {
+			filter = "all"
+		}
+
+		count, err := s.CountSubscribers(r.Context(), status)
+		if err != nil {
+			return fmt.Errorf("error counting subscribers: %w", err)
+		}
+		if count > opts.MaxRows {
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, fmt.Sprintf("There are %v subscribers to export, "+
+				"more than the limit of %v. Please filter the list to export fewer.", count, opts.MaxRows))
+			redirect := "/admin/subscribers"
+			if status != "" {
+				redirect += "?status=" + string(status)
+			}
+			http.Redirect(w, r, redirect, http.StatusFound)
+			return nil
+		}
+
+		if err := s.RecordAuditEvent(r.Context(), storage.AuditActorAdmin, "subscriber.export", "subscribers",
+			map[string]string{"status": filter, "count": strconv.Itoa(count)}); err != nil {
+			return fmt.Errorf("error recording export audit event: %w", err)
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="subscribers-%v-%v.csv"`, filter, opts.Now().UTC().Format("2006-01-02")))
+
+		cw := csv.NewWriter(w)
+		flush := func() error {
+			cw.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return cw.Error()
+		}
+
+		if err := cw.Write([]string{"email", "status", "locale", "signed_up", "confirmed_at"}); err != nil {
+			return err
+		}
+		var rows int
+		err = s.ExportSubscribers(r.Context(), status, opts.MaxRows, func(sub model.Subscriber) error {
+			confirmedAt := ""
+			if sub.ConfirmedAt != nil {
+				confirmedAt = sub.ConfirmedAt.UTC().Format(time.RFC3339)
+			}
+			if err := cw.Write([]string{csvCell(sub.Email.String()), string(sub.Status()), csvCell(sub.Locale),
+				sub.Created.UTC().Format(time.RFC3339), confirmedAt}); err != nil {
+				return err
+			}
+			rows++
+			if rows%subscriberExportFlushRows == 0 {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil && r.Context().Err() != nil {
+			requestLog(r.Context(), log).Info("Subscriber export cancelled", zap.String("status", filter), zap.Int("rows", rows))
+			return nil
+		}
+		if err != nil {
+			// So the error page isn't downloaded, if it's not too late for it.
+			w.Header().Del("Content-Disposition")
+			return fmt.Errorf("error exporting subscribers: %w", err)
+		}
+
+		requestLog(r.Context(), log).Info("Exported subscribers", zap.String("status", filter), zap.Int("rows", rows))
+		return nil
+	}))
+}
+
+// csvCell of the value, prefixed with a quote if it starts with a character spreadsheets read as a formula,
+// so exported values like "=HYPERLINK(...)" are shown as text instead of run when the file is opened.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
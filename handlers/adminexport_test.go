@@ -0,0 +1,198 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+)
+
+// subscriberExporterMock exports count generated subscribers, checking the context before each like a database cursor.
+type subscriberExporterMock struct {
+	count int
+	// emails of the subscribers, instead of generated ones, if not empty.
+	emails   []model.Email
+	err      error
+	exported int
+	events   []string
+}
+
+func (s *subscriberExporterMock) CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error) {
+	return s.count, nil
+}
+
+func (s *subscriberExporterMock) ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error {
+	if s.err != nil {
+		return s.err
+	}
+	created := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < s.count && i < limit; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sub := model.Subscriber{Email: model.Email(fmt.Sprintf("me%06d@example.com", i)), Active: true, Confirmed: true,
+			ConfirmedAt: &created, Locale: "en", Created: created}
+		if i < len(s.emails) {
+			sub.Email = s.emails[i]
+		}
+		if err := f(sub); err != nil {
+			return err
+		}
+		s.exported++
+	}
+	return nil
+}
+
+func (s *subscriberExporterMock) RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error {
+	s.events = append(s.events, fmt.Sprintf("%v %v %v status=%v count=%v", actor, action, target, details["status"], details["count"]))
+	return nil
+}
+
+// flushRecorder records the body at each flush, and calls onFlush after it.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []int
+	onFlush func()
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes = append(f.flushes, f.Body.Len())
+	f.ResponseRecorder.Flush()
+	if f.onFlush != nil {
+		f.onFlush()
+	}
+}
+
+func TestAdminSubscriberExport(t *testing.T) {
+	now := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+
+	m := newSessionManager()
+	session := logInAdmin(t, m, 0)
+
+	newMux := func(s *subscriberExporterMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(m.Middleware)
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			handlers.AdminSubscriberExport(r, s, zap.NewNop(), handlers.AdminSubscriberExportOptions{
+				MaxRows: 5000,
+				Now:     func() time.Time { return now },
+			})
+		})
+		return mux
+	}
+
+	get := func(ctx context.Context, mux chi.Router, target string, onFlush func()) *flushRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+		req.AddCookie(session)
+		res := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), onFlush: onFlush}
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("downloads the subscribers as CSV with the filter and date in the file name, and records the export", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberExporterMock{count: 2}
+		res := get(context.Background(), newMux(s), "/admin/subscribers/export?status=confirmed", nil)
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("text/csv; charset=utf-8", res.Header().Get("Content-Type"))
+		is.Equal(`attachment; filename="subscribers-confirmed-2022-12-24.csv"`, res.Header().Get("Content-Disposition"))
+		is.Equal("", res.Header().Get("Content-Length"))
+
+		records, err := csv.NewReader(res.Body).ReadAll()
+		is.NoErr(err)
+		is.Equal([][]string{
+			{"email", "status", "locale", "signed_up", "confirmed_at"},
+			{"me000000@example.com", "confirmed", "en", "2022-12-10T12:00:00Z", "2022-12-10T12:00:00Z"},
+			{"me000001@example.com", "confirmed", "en", "2022-12-10T12:00:00Z", "2022-12-10T12:00:00Z"},
+		}, records)
+
+		is.Equal([]string{"admin subscriber.export subscribers status=confirmed count=2"}, s.events)
+	})
+
+	t.Run("prefixes values that spreadsheets would read as formulas with a quote", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberExporterMock{count: 5, emails: []model.Email{
+			`=HYPERLINK("https://example.com")@example.com`, "+1@example.com", "-1@example.com", "@me@example.com", "me=1@example.com",
+		}}
+		res := get(context.Background(), newMux(s), "/admin/subscribers/export", nil)
+		is.Equal(http.StatusOK, res.Code)
+
+		records, err := csv.NewReader(res.Body).ReadAll()
+		is.NoErr(err)
+		var emails []string
+		for _, record := range records[1:] {
+			emails = append(emails, record[0])
+		}
+		is.Equal([]string{
+			`'=HYPERLINK("https://example.com")@example.com`, "'+1@example.com", "'-1@example.com", "'@me@example.com", "me=1@example.com",
+		}, emails)
+	})
+
+	t.Run("names the file after all subscribers without a filter", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(context.Background(), newMux(&subscriberExporterMock{}), "/admin/subscribers/export?status=nope", nil)
+		is.Equal(`attachment; filename="subscribers-all-2022-12-24.csv"`, res.Header().Get("Content-Disposition"))
+	})
+
+	t.Run("streams large lists in chunks", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberExporterMock{count: 4500}
+		res := get(context.Background(), newMux(s), "/admin/subscribers/export", nil)
+		is.Equal(http.StatusOK, res.Code)
+		// A flush every thousand rows, and one at the end.
+		is.Equal(5, len(res.flushes))
+		for i := 1; i < len(res.flushes); i++ {
+			is.True(res.flushes[i] > res.flushes[i-1])
+		}
+		is.Equal(4501, strings.Count(res.Body.String(), "\n"))
+	})
+
+	t.Run("redirects back to the list with an error flash for more subscribers than the cap", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberExporterMock{count: 5001}
+		res := get(context.Background(), newMux(s), "/admin/subscribers/export?status=pending", nil)
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/admin/subscribers?status=pending", res.Header().Get("Location"))
+		is.Equal(0, s.exported)
+		is.Equal(0, len(s.events))
+	})
+
+	t.Run("stops exporting when the client disconnects", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s := &subscriberExporterMock{count: 4500}
+		res := get(ctx, newMux(s), "/admin/subscribers/export", cancel)
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal(1, len(res.flushes))
+		is.Equal(1000, s.exported)
+	})
+
+	t.Run("renders an error page if exporting fails before anything is sent", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberExporterMock{count: 1, err: errors.New("oh no")}
+		res := get(context.Background(), newMux(s), "/admin/subscribers/export", nil)
+		is.Equal(http.StatusInternalServerError, res.Code)
+		is.Equal("", res.Header().Get("Content-Disposition"))
+	})
+}
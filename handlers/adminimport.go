@@ -0,0 +1,443 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type subscriberImporter interface {
+	CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+		next func() (model.SubscriberImportRow, error)) (int64, error)
+	GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error)
+	ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error)
+	ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error)
+	ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error
+	QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error
+	DeleteSubscriberImport(ctx context.Context, id int64, actor string) error
+}
+
+// AdminSubscriberImportOptions for AdminSubscriberImport.
+type AdminSubscriberImportOptions struct {
+	// Catalog with the locales subscribers can be imported with. Defaults to i18n.Default.
+	Catalog i18n.Loader
+	// EmailPolicy normalizes the imported addresses like the signed up ones, and blocks the disposable ones the same way.
+	EmailPolicy model.EmailPolicy
+	// MaxBytes of an uploaded file. Defaults to 20 MB.
+	MaxBytes int64
+	// MaxRows of an uploaded file, without the header. Defaults to 100,000, like exports.
+	MaxRows int
+}
+
+const (
+	defaultMaxSubscriberImportBytes = 20 << 20
+	// subscriberImportPreviewRows is how many rows of an uploaded file the preview shows.
+	subscriberImportPreviewRows = 10
+	// subscriberImportListLimit is how many of the latest imports the import page lists.
+	subscriberImportListLimit = 20
+)
+
+// AdminSubscriberImport of subscribers from CSV files, like the export of a Mailchimp audience, on a router mounted
+// at /admin. GET /subscribers/import shows the upload form and the latest imports.
+// POST /subscribers/import uploads a file in the file field of a multipart form. It's streamed into the database
+// as it's read, with the columns for the email address, locale, and tags detected from the header,
+// and rows that aren't valid marked as failed. The admin is then sent to GET /subscribers/import/{id},
+// which previews the first rows, and POST /subscribers/import/{id} queues the import job with what to do
+// with the duplicates, skip or update. While the job runs, the page shows its progress.
+// GET /subscribers/import/{id}/errors.csv downloads the failed rows with their errors,
+// and DELETE /subscribers/import/{id} deletes an import that isn't in progress, but not the subscribers it imported.
+// Files over MaxBytes or MaxRows, that aren't CSV, or that don't have a column for the email addresses, aren't uploaded.
+func AdminSubscriberImport(mux chi.Router, s subscriberImporter, log *zap.Logger, opts AdminSubscriberImportOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Catalog == nil {
+		opts.Catalog = i18n.Default()
+	}
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = defaultMaxSubscriberImportBytes
+	}
+	if opts.MaxRows == 0 {
+		opts.MaxRows = defaultMaxSubscriberExportRows
+	}
+
+	page := func(w http.ResponseWriter, r *http.Request, code int, uploadError string) error {
+		imports, err := s.ListSubscriberImports(r.Context(), subscriberImportListLimit)
+		if err != nil {
+			return fmt.Errorf("error listing subscriber imports: %w", err)
+		}
+		return render(w, code, views.AdminSubscriberImports(views.AdminSubscriberImportsProps{
+			CSRFToken:   CSRFToken(r),
+			Flashes:     sessions.ConsumeFlashes(r.Context()),
+			Imports:     imports,
+			MaxBytes:    opts.MaxBytes,
+			MaxRows:     opts.MaxRows,
+			UploadError: uploadError,
+		}))
+	}
+
+	mux.Get("/subscribers/import", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		return page(w, r, http.StatusOK, "")
+	}))
+
+	mux.Post("/subscribers/import", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return page(w, r, http.StatusBadRequest, "Please pick a CSV file to upload.")
+		}
+		var file io.Reader
+		var filename string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				return page(w, r, http.StatusBadRequest, "Please pick a CSV file to upload.")
+			}
+			if p.FormName() == "file" && p.FileName() != "" {
+				file, filename = p, p.FileName()
+				break
+			}
+		}
+
+		catalog, err := opts.Catalog.Load()
+		if err != nil {
+			return fmt.Errorf("error loading translations: %w", err)
+		}
+		tooBig := importError(fmt.Sprintf("The file is bigger than the limit of %v. Please split it into smaller files.",
+			views.FileSize(opts.MaxBytes)))
+		ir, err := newImportReader(&limitedReader{r: file, n: opts.MaxBytes, err: tooBig}, catalog, opts)
+		var importErr importError
+		if errors.As(err, &importErr) {
+			return page(w, r, http.StatusUnprocessableEntity, importErr.Error())
+		}
+		if err != nil {
+			return fmt.Errorf("error reading subscriber import: %w", err)
+		}
+
+		id, err := s.CreateSubscriberImport(r.Context(), filename, ir.columns, ir.next)
+		if errors.As(err, &importErr) {
+			return page(w, r, http.StatusUnprocessableEntity, importErr.Error())
+		}
+		if err != nil {
+			return fmt.Errorf("error creating subscriber import: %w", err)
+		}
+		requestLog(r.Context(), log).Info("Uploaded subscriber import", zap.Int64("id", id), zap.Int("rows", ir.rows))
+		http.Redirect(w, r, fmt.Sprintf("/admin/subscribers/import/%v", id), http.StatusSeeOther)
+		return nil
+	}))
+
+	get := func(r *http.Request) (model.SubscriberImport, error) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return model.SubscriberImport{}, fmt.Errorf("invalid subscriber import ID: %w", storage.ErrNotFound)
+		}
+		i, err := s.GetSubscriberImport(r.Context(), id)
+		if err != nil {
+			return model.SubscriberImport{}, fmt.Errorf("error getting subscriber import: %w", err)
+		}
+		if i == nil {
+			return model.SubscriberImport{}, fmt.Errorf("no subscriber import with ID %v: %w", id, storage.ErrNotFound)
+		}
+		return *i, nil
+	}
+
+	mux.Get("/subscribers/import/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		i, err := get(r)
+		if err != nil {
+			return err
+		}
+		var preview []model.SubscriberImportRow
+		if i.State == model.SubscriberImportStateUploaded {
+			if preview, err = s.ListSubscriberImportRows(r.Context(), i.ID, subscriberImportPreviewRows); err != nil {
+				return fmt.Errorf("error listing subscriber import rows: %w", err)
+			}
+		}
+		return render(w, http.StatusOK, views.AdminSubscriberImport(views.AdminSubscriberImportProps{
+			CSRFToken: CSRFToken(r),
+			Flashes:   sessions.ConsumeFlashes(r.Context()),
+			Import:    i,
+			Preview:   preview,
+		}))
+	}))
+
+	mux.Get("/subscribers/import/{id}/errors.csv", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		i, err := get(r)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="import-%v-errors.csv"`, i.ID))
+
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"line", "email", "locale", "tags", "error"}); err != nil {
+			return err
+		}
+		err = s.ExportSubscriberImportErrors(r.Context(), i.ID, func(row model.SubscriberImportRow) error {
+			tags := make([]string, len(row.Tags))
+			for j, t := range row.Tags {
+				tags[j] = t.String()
+			}
+			return cw.Write([]string{strconv.Itoa(row.Line), csvCell(row.Email.String()), csvCell(row.Locale),
+				csvCell(strings.Join(tags, ",")), csvCell(row.Error)})
+		})
+		if err == nil {
+			cw.Flush()
+			err = cw.Error()
+		}
+		if err != nil {
+			w.Header().Del("Content-Disposition")
+			return fmt.Errorf("error exporting subscriber import errors: %w", err)
+		}
+		return nil
+	}))
+
+	// change the import with the ID in the path, flashing the result on its page, or on the list if it's gone.
+	change := func(fn func(r *http.Request, i model.SubscriberImport) (string, error)) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid subscriber import ID: %w", storage.ErrNotFound)
+			}
+			i, err := s.GetSubscriberImport(r.Context(), id)
+			if err != nil {
+				return fmt.Errorf("error getting subscriber import: %w", err)
+			}
+			var success string
+			if i == nil {
+				err = storage.ErrNotFound
+			} else {
+				success, err = fn(r, *i)
+			}
+
+			redirect := fmt.Sprintf("/admin/subscribers/import/%v", id)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, "That import was deleted in the meantime, so nothing was done.")
+				redirect = "/admin/subscribers/import"
+			case errors.Is(err, storage.ErrImportInProgress):
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, "The import is in progress, so nothing was done.")
+			case errors.Is(err, storage.ErrAlreadyImported):
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, "The file has been imported already, so nothing was done.")
+			case err != nil:
+				return fmt.Errorf("error changing subscriber import: %w", err)
+			default:
+				_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, success)
+				if r.Method == http.MethodDelete {
+					redirect = "/admin/subscribers/import"
+				}
+			}
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return nil
+		})
+	}
+
+	mux.Post("/subscribers/import/{id}", change(func(r *http.Request, i model.SubscriberImport) (string, error) {
+		duplicates := model.SubscriberImportDuplicates(r.PostFormValue("duplicates"))
+		if duplicates == "" {
+			duplicates = model.SubscriberImportDuplicatesSkip
+		}
+		if err := s.QueueSubscriberImport(r.Context(), i.ID, duplicates, storage.AuditActorAdmin); err != nil {
+			return "", err
+		}
+		requestLog(r.Context(), log).Info("Queued subscriber import", zap.Int64("id", i.ID), zap.String("duplicates", string(duplicates)))
+		if i.State == model.SubscriberImportStateFailed {
+			return "Resuming the import.", nil
+		}
+		return fmt.Sprintf("Importing %v rows.", i.Total), nil
+	}))
+
+	mux.Delete("/subscribers/import/{id}", change(func(r *http.Request, i model.SubscriberImport) (string, error) {
+		if err := s.DeleteSubscriberImport(r.Context(), i.ID, storage.AuditActorAdmin); err != nil {
+			return "", err
+		}
+		requestLog(r.Context(), log).Info("Deleted subscriber import", zap.Int64("id", i.ID))
+		return fmt.Sprintf("Deleted the import of %v. The subscribers it imported stay.", i.Filename), nil
+	}))
+}
+
+// importError is a problem with an uploaded file that stops it from being uploaded, shown to the admin as it is.
+type importError string
+
+func (e importError) Error() string {
+	return string(e)
+}
+
+// limitedReader returns err after reading more than n bytes, unlike io.LimitReader, which just ends.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+// importReader reads the rows of an uploaded CSV file after its header, with the fields from the columns detected
+// in the header, and an error for the rows that aren't valid.
+type importReader struct {
+	catalog *i18n.Catalog
+	columns model.SubscriberImportColumns
+	cr      *csv.Reader
+	header  []string
+	// email, locale, and tags are the indexes of their columns, or -1 for the ones the file doesn't have.
+	email, locale, tags int
+	opts                AdminSubscriberImportOptions
+	rows                int
+}
+
+// newImportReader of the CSV file in r, which skips a byte order mark at the start, like Excel writes,
+// reads the header, and detects the columns from it. The headers are matched case-insensitively,
+// and with hyphens and underscores as spaces, like the "Email Address" and "TAGS" of Mailchimp exports.
+// Other columns are ignored. Returns an importError if the header isn't valid,
+// or doesn't have exactly one column for the email addresses.
+func newImportReader(r io.Reader, catalog *i18n.Catalog, opts AdminSubscriberImportOptions) (*importReader, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(3); err == nil && string(b) == "\xef\xbb\xbf" {
+		_, _ = br.Discard(3)
+	}
+	ir := &importReader{catalog: catalog, cr: csv.NewReader(br), email: -1, locale: -1, tags: -1, opts: opts}
+	ir.cr.ReuseRecord = true
+
+	header, err := ir.cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, importError("The file is empty.")
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	ir.header = append([]string{}, header...)
+
+	fields := []struct {
+		name    string
+		headers []string
+		index   *int
+		column  *string
+	}{
+		{"email addresses", []string{"email", "email address", "e mail", "e mail address"}, &ir.email, &ir.columns.Email},
+		{"locales", []string{"locale", "language"}, &ir.locale, &ir.columns.Locale},
+		{"tags", []string{"tags"}, &ir.tags, &ir.columns.Tags},
+	}
+	for i, h := range ir.header {
+		name := strings.NewReplacer("-", " ", "_", " ").Replace(strings.ToLower(strings.TrimSpace(h)))
+		for _, f := range fields {
+			for _, candidate := range f.headers {
+				if name != candidate {
+					continue
+				}
+				if *f.index >= 0 {
+					return nil, importError(fmt.Sprintf("The file has more than one column for the %v, %v and %v. "+
+						"Please keep one of them.", f.name, *f.column, h))
+				}
+				*f.index, *f.column = i, h
+			}
+		}
+	}
+	if ir.email < 0 {
+		return nil, importError(fmt.Sprintf("The file needs a column with the email addresses, like Email Address, "+
+			"but its columns are %v.", strings.Join(ir.header, ", ")))
+	}
+	return ir, nil
+}
+
+// next row of the file, or io.EOF after the last one. Returns an importError for files with more than MaxRows rows,
+// or that aren't valid CSV. A row with a different number of fields than the header is a row with an error,
+// since the rest of the file can still be read.
+func (ir *importReader) next() (model.SubscriberImportRow, error) {
+	record, err := ir.cr.Read()
+	if errors.Is(err, io.EOF) {
+		return model.SubscriberImportRow{}, io.EOF
+	}
+	if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+		return model.SubscriberImportRow{}, csvError(err)
+	}
+	line, _ := ir.cr.FieldPos(0)
+	ir.rows++
+	if ir.rows > ir.opts.MaxRows {
+		return model.SubscriberImportRow{}, importError(fmt.Sprintf("The file has more than the limit of %v rows. "+
+			"Please split it into smaller files.", ir.opts.MaxRows))
+	}
+
+	row := model.SubscriberImportRow{Line: line}
+	if errors.Is(err, csv.ErrFieldCount) {
+		row.Error = fmt.Sprintf("The row has a different number of fields than the header, %v instead of %v.",
+			len(record), len(ir.header))
+		if ir.email < len(record) {
+			row.Email = ir.opts.EmailPolicy.Normalize(model.Email(strings.TrimSpace(record[ir.email])))
+		}
+		return row, nil
+	}
+
+	row.Email = ir.opts.EmailPolicy.Normalize(model.Email(strings.TrimSpace(record[ir.email])))
+	if ir.locale >= 0 {
+		row.Locale = strings.ToLower(strings.TrimSpace(record[ir.locale]))
+	}
+	var tagsErr string
+	if ir.tags >= 0 {
+		row.Tags, tagsErr = parseImportTags(record[ir.tags])
+	}
+
+	switch {
+	case row.Email == "":
+		row.Error = "The email address is missing."
+	case !row.Email.IsValid():
+		row.Error = fmt.Sprintf("%v isn't a valid email address.", row.Email)
+	case ir.opts.EmailPolicy.IsDisposable(row.Email):
+		row.Error = fmt.Sprintf("%v is at a disposable email provider.", row.Email)
+	case row.Locale != "" && !ir.catalog.Has(row.Locale):
+		row.Error = fmt.Sprintf("The locale %v isn't supported. The supported locales are %v.", row.Locale,
+			strings.Join(ir.catalog.Locales(), ", "))
+	case tagsErr != "":
+		row.Error = tagsErr
+	}
+	return row, nil
+}
+
+// parseImportTags separated by commas, like "api, beta", which can be quoted, like the "TAGS" of Mailchimp exports,
+// which are like "api","beta". Tags are lowercased, and each is only returned once.
+// Returns the error for the row if a tag isn't valid.
+func parseImportTags(s string) ([]model.Tag, string) {
+	var tags []model.Tag
+	seen := map[model.Tag]bool{}
+	for _, t := range strings.Split(s, ",") {
+		tag := model.Tag(strings.ToLower(strings.Trim(strings.TrimSpace(t), `"`)))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !tag.IsValid() {
+			return nil, fmt.Sprintf("%q isn't a valid tag. "+
+				"Tags are up to 50 lowercase letters, digits, hyphens, and underscores, and can't be and or or.", tag)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, ""
+}
+
+// csvError for an error reading the CSV file, which is an importError unless it's from reading the upload.
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importError(fmt.Sprintf("The file isn't valid CSV: %v.", parseErr.Error()))
+	}
+	return err
+}
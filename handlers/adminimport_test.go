@@ -0,0 +1,440 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type subscriberImporterMock struct {
+	imports []model.SubscriberImport
+	rows    map[int64][]model.SubscriberImportRow
+}
+
+func (s *subscriberImporterMock) CreateSubscriberImport(ctx context.Context, filename string,
+	columns model.SubscriberImportColumns, next func() (model.SubscriberImportRow, error)) (int64, error) {
+	i := model.SubscriberImport{ID: int64(len(s.imports) + 1), Filename: filename, State: model.SubscriberImportStateUploaded,
+		Duplicates: model.SubscriberImportDuplicatesSkip, Columns: columns, Created: time.Now()}
+	var rows []model.SubscriberImportRow
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if row.Error != "" {
+			row.Result = model.SubscriberImportResultFailed
+			i.Failed++
+		}
+		rows = append(rows, row)
+	}
+	i.Total = len(rows)
+	s.imports = append(s.imports, i)
+	if s.rows == nil {
+		s.rows = map[int64][]model.SubscriberImportRow{}
+	}
+	s.rows[i.ID] = rows
+	return i.ID, nil
+}
+
+func (s *subscriberImporterMock) GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error) {
+	for _, i := range s.imports {
+		if i.ID == id {
+			return &i, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *subscriberImporterMock) ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error) {
+	return s.imports, nil
+}
+
+func (s *subscriberImporterMock) ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error) {
+	rows := s.rows[id]
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+func (s *subscriberImporterMock) ExportSubscriberImportErrors(ctx context.Context, id int64,
+	f func(model.SubscriberImportRow) error) error {
+	for _, row := range s.rows[id] {
+		if row.Result == model.SubscriberImportResultFailed {
+			if err := f(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *subscriberImporterMock) QueueSubscriberImport(ctx context.Context, id int64,
+	duplicates model.SubscriberImportDuplicates, actor string) error {
+	for j := range s.imports {
+		i := &s.imports[j]
+		if i.ID != id || actor != storage.AuditActorAdmin {
+			continue
+		}
+		if i.InProgress() {
+			return storage.ErrImportInProgress
+		}
+		if i.State == model.SubscriberImportStateCompleted {
+			return storage.ErrAlreadyImported
+		}
+		i.State, i.Duplicates = model.SubscriberImportStateQueued, duplicates
+		return nil
+	}
+	return storage.ErrNotFound
+}
+
+func (s *subscriberImporterMock) DeleteSubscriberImport(ctx context.Context, id int64, actor string) error {
+	for j, i := range s.imports {
+		if i.ID == id && actor == storage.AuditActorAdmin {
+			if i.InProgress() {
+				return storage.ErrImportInProgress
+			}
+			s.imports = append(s.imports[:j], s.imports[j+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func TestAdminSubscriberImport(t *testing.T) {
+	newMux := func(s *subscriberImporterMock, opts handlers.AdminSubscriberImportOptions) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminSubscriberImport(r, s, zap.NewNop(), opts)
+		})
+		return mux
+	}
+
+	upload := func(mux chi.Router, file string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		fw, err := w.CreateFormFile("file", "subscribers.csv")
+		if err != nil {
+			panic(err)
+		}
+		_, _ = io.WriteString(fw, file)
+		_ = w.Close()
+		req := httptest.NewRequest(http.MethodPost, "/admin/subscribers/import", &body)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	post := func(mux chi.Router, target string, values url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// flash on the page the response redirects to.
+	flash := func(mux chi.Router, res *httptest.ResponseRecorder) string {
+		req := httptest.NewRequest(http.MethodGet, res.Header().Get("Location"), nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res2 := httptest.NewRecorder()
+		mux.ServeHTTP(res2, req)
+		match := flashMatcher.FindStringSubmatch(res2.Body.String())
+		if match == nil {
+			return ""
+		}
+		return match[1] + ": " + html.UnescapeString(match[2])
+	}
+
+	// uploaded import, with the given state.
+	uploaded := func(state model.SubscriberImportState) *subscriberImporterMock {
+		return &subscriberImporterMock{
+			imports: []model.SubscriberImport{{ID: 1, Filename: "subscribers.csv", State: state,
+				Duplicates: model.SubscriberImportDuplicatesSkip, Columns: model.SubscriberImportColumns{Email: "Email"},
+				Total: 3, Failed: 1, Created: time.Now()}},
+			rows: map[int64][]model.SubscriberImportRow{1: {
+				{Line: 2, Email: "me@example.com"},
+				{Line: 3, Email: "nope", Result: model.SubscriberImportResultFailed, Error: "nope isn't a valid email address."},
+				{Line: 4, Email: "you@example.com", Tags: []model.Tag{"api", "beta"}},
+			}},
+		}
+	}
+
+	t.Run("uploads a Mailchimp export with a byte order mark, detecting the columns, and redirects to the preview", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImporterMock{}
+		mux := newMux(s, handlers.AdminSubscriberImportOptions{EmailPolicy: model.EmailPolicy{Enabled: true}})
+		res := upload(mux, "\xef\xbb\xbfEmail Address,First Name,Language,TAGS\r\n"+
+			"Me@Example.com,Me,,\r\n"+
+			"you@example.com,You,de,\"\"\"API\"\",\"\"beta\"\"\"\r\n")
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/subscribers/import/1", res.Header().Get("Location"))
+
+		is.Equal(1, len(s.imports))
+		i := s.imports[0]
+		is.Equal("subscribers.csv", i.Filename)
+		is.Equal(model.SubscriberImportColumns{Email: "Email Address", Locale: "Language", Tags: "TAGS"}, i.Columns)
+		is.Equal([]model.SubscriberImportRow{
+			{Line: 2, Email: "me@example.com"},
+			{Line: 3, Email: "you@example.com", Locale: "de", Tags: []model.Tag{"api", "beta"}},
+		}, s.rows[1])
+	})
+
+	t.Run("uploads a file with only an email column, and with LF line endings", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImporterMock{}
+		res := upload(newMux(s, handlers.AdminSubscriberImportOptions{}), "e-mail\nme@example.com\n")
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal(model.SubscriberImportColumns{Email: "e-mail"}, s.imports[0].Columns)
+		is.Equal([]model.SubscriberImportRow{{Line: 2, Email: "me@example.com"}}, s.rows[1])
+	})
+
+	t.Run("marks rows that can't be imported with their errors, and reads the rest", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImporterMock{}
+		res := upload(newMux(s, handlers.AdminSubscriberImportOptions{}), "email,locale,tags\n"+
+			"me@example.com,en,api\n"+
+			"nope,,\n"+
+			",,\n"+
+			"you@example.com,xx,\n"+
+			"them@example.com,,Beta!\n"+
+			"us@example.com\n"+
+			"we@example.com,en,\"api, beta, api\"\n")
+		is.Equal(http.StatusSeeOther, res.Code)
+
+		rows := s.rows[1]
+		is.Equal(7, len(rows))
+		is.Equal("", rows[0].Error)
+		is.Equal("nope isn't a valid email address.", rows[1].Error)
+		is.Equal("The email address is missing.", rows[2].Error)
+		is.Equal("The locale xx isn't supported. The supported locales are en, de, fr.", rows[3].Error)
+		is.True(strings.HasPrefix(rows[4].Error, `"beta!" isn't a valid tag.`))
+		is.Equal("The row has a different number of fields than the header, 1 instead of 3.", rows[5].Error)
+		is.Equal(model.Email("us@example.com"), rows[5].Email)
+		is.Equal(7, rows[5].Line)
+		is.Equal("", rows[6].Error)
+		is.Equal([]model.Tag{"api", "beta"}, rows[6].Tags)
+		is.Equal(5, s.imports[0].Failed)
+	})
+
+	t.Run("marks disposable addresses as failed with the email policy", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImporterMock{}
+		policy := model.EmailPolicy{Enabled: true, Disposable: disposableCheckerMock{"mailinator.com": true}}
+		res := upload(newMux(s, handlers.AdminSubscriberImportOptions{EmailPolicy: policy}), "email\nme@mailinator.com\n")
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("me@mailinator.com is at a disposable email provider.", s.rows[1][0].Error)
+	})
+
+	invalid := []struct {
+		name  string
+		file  string
+		opts  handlers.AdminSubscriberImportOptions
+		error string
+	}{
+		{"an empty file", "", handlers.AdminSubscriberImportOptions{}, "The file is empty."},
+		{"a file with only a byte order mark", "\xef\xbb\xbf", handlers.AdminSubscriberImportOptions{}, "The file is empty."},
+		{"a file without an email column", "Name,Phone\nMe,123\n", handlers.AdminSubscriberImportOptions{},
+			"The file needs a column with the email addresses, like Email Address, but its columns are Name, Phone."},
+		{"a file with two email columns", "Email,Email Address\nme@example.com,you@example.com\n",
+			handlers.AdminSubscriberImportOptions{},
+			"The file has more than one column for the email addresses, Email and Email Address. Please keep one of them."},
+		{"a header that isn't valid CSV", "email,\"locale\n", handlers.AdminSubscriberImportOptions{},
+			"The file isn't valid CSV: "},
+		{"a row that isn't valid CSV", "email\nme@example.com\nyou\"@example.com\n", handlers.AdminSubscriberImportOptions{},
+			"The file isn't valid CSV: parse error on line 3, column 4"},
+		{"a file with more rows than the limit", "email\na@example.com\nb@example.com\nc@example.com\n",
+			handlers.AdminSubscriberImportOptions{MaxRows: 2},
+			"The file has more than the limit of 2 rows. Please split it into smaller files."},
+		{"a file bigger than the limit", "email\n" + strings.Repeat("me@example.com\n", 100),
+			handlers.AdminSubscriberImportOptions{MaxBytes: 1 << 10},
+			"The file is bigger than the limit of 1 KB. Please split it into smaller files."},
+	}
+	for _, test := range invalid {
+		t.Run("doesn't upload "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := &subscriberImporterMock{}
+			res := upload(newMux(s, test.opts), test.file)
+			is.Equal(http.StatusUnprocessableEntity, res.Code)
+			body := html.UnescapeString(res.Body.String())
+			is.True(strings.Contains(body, `id="upload-error"`))
+			is.True(strings.Contains(body, test.error))
+			is.Equal(0, len(s.imports))
+		})
+	}
+
+	t.Run("asks for a file if there's none in the form", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImporterMock{}
+		res := post(newMux(s, handlers.AdminSubscriberImportOptions{}), "/admin/subscribers/import", url.Values{})
+		is.Equal(http.StatusBadRequest, res.Code)
+		is.True(strings.Contains(res.Body.String(), "Please pick a CSV file to upload."))
+	})
+
+	t.Run("shows the upload form with the limits and the latest imports", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(uploaded(model.SubscriberImportStateCompleted), handlers.AdminSubscriberImportOptions{})
+		code, _, body := makeGetRequest(mux, "/admin/subscribers/import")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `enctype="multipart/form-data"`))
+		is.True(strings.Contains(body, "Files can be up to 20 MB, with up to 100000 rows."))
+		is.True(strings.Contains(body, `id="import-1"`))
+	})
+
+	t.Run("previews the first rows of an uploaded file with their errors and the columns", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(uploaded(model.SubscriberImportStateUploaded), handlers.AdminSubscriberImportOptions{})
+		code, _, body := makeGetRequest(mux, "/admin/subscribers/import/1")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `id="import-preview"`))
+		is.True(strings.Contains(body, "me@example.com"))
+		is.True(strings.Contains(body, "nope isn&#39;t a valid email address."))
+		is.True(strings.Contains(body, "api beta"))
+		is.True(strings.Contains(body, "Import 2 rows"))
+		is.True(strings.Contains(body, `href="/admin/subscribers/import/1/errors.csv"`))
+		is.True(!strings.Contains(body, `http-equiv="refresh"`))
+	})
+
+	t.Run("shows the progress of an import in progress, reloading the page", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(uploaded(model.SubscriberImportStateImporting), handlers.AdminSubscriberImportOptions{})
+		code, _, body := makeGetRequest(mux, "/admin/subscribers/import/1")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `id="import-progress"`))
+		is.True(strings.Contains(body, `http-equiv="refresh"`))
+		is.True(!strings.Contains(body, `id="import-preview"`))
+	})
+
+	t.Run("responds with not found for an unknown import", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&subscriberImporterMock{}, handlers.AdminSubscriberImportOptions{})
+		for _, target := range []string{"/admin/subscribers/import/1", "/admin/subscribers/import/nope",
+			"/admin/subscribers/import/1/errors.csv"} {
+			code, _, _ := makeGetRequest(mux, target)
+			is.Equal(http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("downloads the rows that can't be imported with their errors", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(uploaded(model.SubscriberImportStateCompleted), handlers.AdminSubscriberImportOptions{})
+		code, header, body := makeGetRequest(mux, "/admin/subscribers/import/1/errors.csv")
+		is.Equal(http.StatusOK, code)
+		is.Equal("text/csv; charset=utf-8", header.Get("Content-Type"))
+		is.Equal(`attachment; filename="import-1-errors.csv"`, header.Get("Content-Disposition"))
+		is.Equal("line,email,locale,tags,error\n3,nope,,,nope isn't a valid email address.\n", body)
+	})
+
+	t.Run("prefixes values in the errors that spreadsheets would read as formulas with a quote", func(t *testing.T) {
+		is := is.New(t)
+
+		s := uploaded(model.SubscriberImportStateCompleted)
+		s.rows[1][1] = model.SubscriberImportRow{Line: 3, Email: "=1+1", Locale: "@en", Result: model.SubscriberImportResultFailed,
+			Error: "=1+1 isn't a valid email address."}
+		mux := newMux(s, handlers.AdminSubscriberImportOptions{})
+		_, _, body := makeGetRequest(mux, "/admin/subscribers/import/1/errors.csv")
+		is.Equal("line,email,locale,tags,error\n3,'=1+1,'@en,,'=1+1 isn't a valid email address.\n", body)
+	})
+
+	t.Run("queues the import with what to do with the duplicates, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := uploaded(model.SubscriberImportStateUploaded)
+		mux := newMux(s, handlers.AdminSubscriberImportOptions{})
+		res := post(mux, "/admin/subscribers/import/1", url.Values{"duplicates": {"update"}})
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/subscribers/import/1", res.Header().Get("Location"))
+		is.Equal(model.SubscriberImportStateQueued, s.imports[0].State)
+		is.Equal(model.SubscriberImportDuplicatesUpdate, s.imports[0].Duplicates)
+		is.Equal("success: Importing 3 rows.", flash(mux, res))
+	})
+
+	t.Run("resumes a failed import, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := uploaded(model.SubscriberImportStateFailed)
+		mux := newMux(s, handlers.AdminSubscriberImportOptions{})
+		res := post(mux, "/admin/subscribers/import/1", url.Values{})
+		is.Equal(model.SubscriberImportStateQueued, s.imports[0].State)
+		is.Equal(model.SubscriberImportDuplicatesSkip, s.imports[0].Duplicates)
+		is.Equal("success: Resuming the import.", flash(mux, res))
+	})
+
+	t.Run("doesn't queue an import that's in progress or imported already", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(uploaded(model.SubscriberImportStateImporting), handlers.AdminSubscriberImportOptions{})
+		res := post(mux, "/admin/subscribers/import/1", url.Values{})
+		is.Equal("error: The import is in progress, so nothing was done.", flash(mux, res))
+
+		mux = newMux(uploaded(model.SubscriberImportStateCompleted), handlers.AdminSubscriberImportOptions{})
+		res = post(mux, "/admin/subscribers/import/1", url.Values{})
+		is.Equal("error: The file has been imported already, so nothing was done.", flash(mux, res))
+	})
+
+	t.Run("deletes an import, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := uploaded(model.SubscriberImportStateCompleted)
+		mux := newMux(s, handlers.AdminSubscriberImportOptions{})
+		res := post(mux, "/admin/subscribers/import/1", url.Values{"_method": {http.MethodDelete}})
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/subscribers/import", res.Header().Get("Location"))
+		is.Equal(0, len(s.imports))
+		is.Equal("success: Deleted the import of subscribers.csv. The subscribers it imported stay.", flash(mux, res))
+	})
+
+	t.Run("says so if the import was deleted already", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&subscriberImporterMock{}, handlers.AdminSubscriberImportOptions{})
+		for _, res := range []*httptest.ResponseRecorder{
+			post(mux, "/admin/subscribers/import/1", url.Values{"_method": {http.MethodDelete}}),
+			post(mux, "/admin/subscribers/import/1", url.Values{}),
+		} {
+			is.Equal(http.StatusSeeOther, res.Code)
+			is.Equal("/admin/subscribers/import", res.Header().Get("Location"))
+			is.Equal("error: That import was deleted in the meantime, so nothing was done.", flash(mux, res))
+		}
+	})
+}
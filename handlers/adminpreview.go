@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"canvas/email"
+	"canvas/form"
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type newsletterPreviewStore interface {
+	GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error)
+	RecordEmailSend(ctx context.Context, s model.EmailSend) error
+}
+
+// AdminNewsletterPreviewOptions for AdminNewsletterPreview.
+type AdminNewsletterPreviewOptions struct {
+	// BaseURL of the app for the links in the email, like "https://example.com".
+	BaseURL string
+	// From address of the email.
+	From string
+	// PhysicalAddress in the footer of the email.
+	PhysicalAddress string
+	// Sender for test emails.
+	Sender email.Sender
+}
+
+// AdminNewsletterPreview shows the newsletter issue email exactly as it's sent, at /newsletters/{id}/preview
+// on a router mounted at /admin, for the sample subscriber email.PreviewSubscriber and with a dummy unsubscribe token.
+// The HTML part is shown by default, and the text part with the query parameter format=text.
+// A warning banner above the preview has a form for sending a test email to any address.
+// Test emails are recorded in the send log as tests, so they don't count in send stats or as sent.
+func AdminNewsletterPreview(mux chi.Router, s newsletterPreviewStore, log *zap.Logger, opts AdminNewsletterPreviewOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+
+	getPreview := func(r *http.Request) (int64, email.Message, error) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return 0, email.Message{}, fmt.Errorf("invalid newsletter ID: %w", storage.ErrNotFound)
+		}
+		n, err := s.GetNewsletter(r.Context(), id)
+		if err != nil {
+			return 0, email.Message{}, fmt.Errorf("error getting newsletter: %w", err)
+		}
+		if n == nil {
+			return 0, email.Message{}, fmt.Errorf("no newsletter with ID %v: %w", id, storage.ErrNotFound)
+		}
+		m, err := email.NewsletterPreviewEmail(i18n.FromContext(r.Context()), opts.From, opts.PhysicalAddress, *n, opts.BaseURL)
+		if err != nil {
+			return 0, email.Message{}, fmt.Errorf("error rendering newsletter email: %w", err)
+		}
+		return id, m, nil
+	}
+
+	mux.Get("/newsletters/{id}/preview", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, m, err := getPreview(r)
+		if err != nil {
+			return err
+		}
+		previewURL := fmt.Sprintf("/admin/newsletters/%v/preview", id)
+
+		// The email HTML is shown as is, so make sure it can't run scripts, even though it's sanitized.
+		w.Header().Set("Content-Security-Policy", "script-src 'none'")
+		w.Header().Set("Content-Disposition", "inline")
+
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = fmt.Fprintf(w, "PREVIEW. The unsubscribe links have a dummy token, and this notice isn't in the email.\n"+
+				"From: %v\nTo: %v\nSubject: %v\n\n", m.From, m.To, m.Subject)
+			_, _ = w.Write([]byte(m.Text))
+			return nil
+		}
+
+		var banner bytes.Buffer
+		err = views.EmailPreviewBanner(views.EmailPreviewBannerProps{
+			CSRFToken:    CSRFToken(r),
+			Flashes:      sessions.ConsumeFlashes(r.Context()),
+			From:         m.From,
+			HTMLURL:      previewURL,
+			IssueSendURL: fmt.Sprintf("/admin/newsletters/%v/send", id),
+			SendURL:      previewURL + "/send",
+			Subject:      m.Subject,
+			TextURL:      previewURL + "?format=text",
+			To:           m.To.String(),
+		}).Render(&banner)
+		if err != nil {
+			return fmt.Errorf("error rendering preview banner: %w", err)
+		}
+
+		// The banner goes at the start of the body, so the email is still a valid document around it.
+		i := bodyStart(m.HTML)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(m.HTML[:i] + banner.String() + m.HTML[i:]))
+		return nil
+	}))
+
+	mux.Post("/newsletters/{id}/preview/send", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		to := f.Email("email")
+		if err := f.Err(); err != nil {
+			return err
+		}
+
+		id, m, err := getPreview(r)
+		if err != nil {
+			return err
+		}
+		m.To = to
+		m.Subject = "[Test] " + m.Subject
+
+		send := model.EmailSend{Email: to, Type: "newsletter_issue_test_email", NewsletterID: id, Test: true}
+		send.ProviderMessageID, err = opts.Sender.Send(r.Context(), m)
+		switch {
+		case errors.Is(err, email.ErrSuppressed):
+			send.Status = model.EmailSendStatusSkipped
+		case err != nil:
+			send.Status = model.EmailSendStatusFailed
+			send.Error = err.Error()
+		default:
+			send.Status = model.EmailSendStatusSent
+		}
+		if err := s.RecordEmailSend(r.Context(), send); err != nil {
+			requestLog(r.Context(), log).Info("Error recording email send", zap.Error(err))
+		}
+		previewURL := fmt.Sprintf("/admin/newsletters/%v/preview", id)
+		if errors.Is(err, email.ErrSuppressed) {
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "Test email not sent, because "+to.String()+" is on the suppression list.")
+			http.Redirect(w, r, previewURL, http.StatusFound)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error sending test email: %w", err)
+		}
+
+		requestLog(r.Context(), log).Info("Sent test email", zap.Int64("newsletterID", id), zap.Stringer("to", to))
+		_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Test email sent to "+to.String()+".")
+		http.Redirect(w, r, previewURL, http.StatusFound)
+		return nil
+	}))
+}
+
+// bodyStart is the offset in the HTML document just after its body start tag, in any case and with any attributes.
+// Without one, it's just after the doctype, if there is one, so the document still starts with it.
+func bodyStart(doc string) int {
+	z := html.NewTokenizer(strings.NewReader(doc))
+	offset, afterDoctype := 0, 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return afterDoctype
+		}
+		offset += len(z.Raw())
+		switch {
+		case tt == html.DoctypeToken:
+			afterDoctype = offset
+		case tt == html.StartTagToken && z.Token().DataAtom == atom.Body:
+			return offset
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/handlers"
+	"canvas/i18n"
+	"canvas/model"
+)
+
+type newsletterPreviewStoreMock struct {
+	newsletter *model.Newsletter
+	sends      []model.EmailSend
+}
+
+func (s *newsletterPreviewStoreMock) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	if s.newsletter == nil || s.newsletter.ID != id {
+		return nil, nil
+	}
+	return s.newsletter, nil
+}
+
+func (s *newsletterPreviewStoreMock) RecordEmailSend(ctx context.Context, send model.EmailSend) error {
+	s.sends = append(s.sends, send)
+	return nil
+}
+
+type previewSenderMock struct {
+	err      error
+	messages []email.Message
+}
+
+func (s *previewSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	s.messages = append(s.messages, m)
+	return "abc123", nil
+}
+
+func TestAdminNewsletterPreview(t *testing.T) {
+	newsletter := &model.Newsletter{ID: 1, Title: "Hello", Body: "Hi **you**."}
+	secret := []byte("secret")
+
+	setup := func() (chi.Router, *newsletterPreviewStoreMock, *previewSenderMock) {
+		mux := chi.NewMux()
+		s := &newsletterPreviewStoreMock{newsletter: newsletter}
+		sender := &previewSenderMock{}
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminNewsletterPreview(r, s, nil, handlers.AdminNewsletterPreviewOptions{
+				BaseURL:         "https://example.com",
+				From:            "canvas@example.com",
+				PhysicalAddress: "canvas, 1 Example Street",
+				Sender:          sender,
+			})
+		})
+		return mux, s, sender
+	}
+
+	// sent is the real email for the preview subscriber, with the dummy token instead of the signed one.
+	sent := func(t *testing.T) email.Message {
+		t.Helper()
+		m, err := email.NewsletterEmail(i18n.Default().Translator(i18n.DefaultLocale), "canvas@example.com", "canvas, 1 Example Street", email.PreviewSubscriber, *newsletter, "https://example.com", secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := url.QueryEscape(email.CreateUnsubscribeToken(secret, email.PreviewSubscriber))
+		m.HTML = strings.ReplaceAll(m.HTML, token, email.PreviewUnsubscribeToken)
+		m.Text = strings.ReplaceAll(m.Text, token, email.PreviewUnsubscribeToken)
+		return m
+	}
+
+	t.Run("shows the HTML email as sent, with a banner at the start of the body", func(t *testing.T) {
+		is := is.New(t)
+		mux, _, _ := setup()
+
+		code, headers, body := makeGetRequest(mux, "/admin/newsletters/1/preview")
+		is.Equal(http.StatusOK, code)
+		is.Equal("text/html; charset=utf-8", headers.Get("Content-Type"))
+		is.Equal("inline", headers.Get("Content-Disposition"))
+		is.Equal("script-src 'none'", headers.Get("Content-Security-Policy"))
+
+		start, end, found := strings.Cut(sent(t).HTML, "\n<div class=\"container\"")
+		is.True(found)
+		is.True(strings.HasPrefix(strings.ToLower(body), "<!doctype html>"))
+		is.True(strings.HasPrefix(body, start))
+		is.True(strings.HasSuffix(body, "\n<div class=\"container\""+end))
+		banner := strings.TrimSuffix(strings.TrimPrefix(body, start), "\n<div class=\"container\""+end)
+		is.True(strings.HasPrefix(banner, `<div id="email-preview-banner"`))
+		is.True(strings.Contains(banner, "subscriber@example.com"))
+		is.True(strings.Contains(banner, `action="/admin/newsletters/1/preview/send"`))
+	})
+
+	t.Run("shows the text email as sent, after a banner", func(t *testing.T) {
+		is := is.New(t)
+		mux, _, _ := setup()
+
+		code, headers, body := makeGetRequest(mux, "/admin/newsletters/1/preview?format=text")
+		is.Equal(http.StatusOK, code)
+		is.Equal("text/plain; charset=utf-8", headers.Get("Content-Type"))
+
+		banner, preview, found := strings.Cut(body, "\n\n")
+		is.True(found)
+		is.True(strings.HasPrefix(banner, "PREVIEW."))
+		is.True(strings.Contains(banner, "Subject: Hello"))
+		is.Equal(sent(t).Text, preview)
+	})
+
+	t.Run("returns 404 for an unknown or invalid newsletter ID", func(t *testing.T) {
+		is := is.New(t)
+		mux, _, _ := setup()
+
+		code, _, _ := makeGetRequest(mux, "/admin/newsletters/2/preview")
+		is.Equal(http.StatusNotFound, code)
+
+		code, _, _ = makeGetRequest(mux, "/admin/newsletters/abc/preview")
+		is.Equal(http.StatusNotFound, code)
+	})
+
+	t.Run("sends a test email and records it as a test", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sender := setup()
+
+		code, headers, _ := makePostRequest(mux, "/admin/newsletters/1/preview/send", createFormHeader(),
+			strings.NewReader("email=me%40example.com"))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/admin/newsletters/1/preview", headers.Get("Location"))
+
+		is.Equal(1, len(sender.messages))
+		m := sender.messages[0]
+		is.Equal(model.Email("me@example.com"), m.To)
+		is.Equal("[Test] Hello", m.Subject)
+		is.Equal(sent(t).HTML, m.HTML)
+
+		is.Equal(1, len(s.sends))
+		is.Equal(model.EmailSend{
+			Email:             "me@example.com",
+			Type:              "newsletter_issue_test_email",
+			NewsletterID:      1,
+			ProviderMessageID: "abc123",
+			Status:            model.EmailSendStatusSent,
+			Test:              true,
+		}, s.sends[0])
+	})
+
+	t.Run("records a failed test email and errors", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sender := setup()
+		sender.err = errors.New("oh no")
+
+		code, _, _ := makePostRequest(mux, "/admin/newsletters/1/preview/send", createFormHeader(),
+			strings.NewReader("email=me%40example.com"))
+		is.Equal(http.StatusInternalServerError, code)
+
+		is.Equal(1, len(s.sends))
+		is.Equal(model.EmailSendStatusFailed, s.sends[0].Status)
+		is.Equal("oh no", s.sends[0].Error)
+		is.True(s.sends[0].Test)
+	})
+
+	t.Run("records a test email to a suppressed address as skipped, and shows an error", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sender := setup()
+		sender.err = email.ErrSuppressed
+
+		code, headers, _ := makePostRequest(mux, "/admin/newsletters/1/preview/send", createFormHeader(),
+			strings.NewReader("email=me%40example.com"))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/admin/newsletters/1/preview", headers.Get("Location"))
+
+		is.Equal(1, len(s.sends))
+		is.Equal(model.EmailSendStatusSkipped, s.sends[0].Status)
+		is.Equal("", s.sends[0].Error)
+	})
+
+	t.Run("returns 422 for an invalid email address", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sender := setup()
+
+		code, _, _ := makePostRequest(mux, "/admin/newsletters/1/preview/send", createFormHeader(),
+			strings.NewReader("email=notanemail"))
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.Equal(0, len(sender.messages))
+		is.Equal(0, len(s.sends))
+	})
+}
+
+func TestBodyStart(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		at   string
+	}{
+		{"is after the body start tag", `<!doctype html><html><head><title>Hi</title></head><body style="margin: 0">Hi</body></html>`, "Hi</body></html>"},
+		{"is after an upper case body start tag", `<!DOCTYPE html><HTML><BODY>Hi</BODY></HTML>`, "Hi</BODY></HTML>"},
+		{"ignores body in comments and attributes", `<!doctype html><!-- <body> --><meta content="<body>"><body>Hi</body>`, "Hi</body>"},
+		{"is after the doctype without a body", `<!doctype html><p>Hi</p>`, "<p>Hi</p>"},
+		{"is at the start without a doctype or body", `<p>Hi</p>`, "<p>Hi</p>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.at, test.doc[handlers.BodyStart(test.doc):])
+		})
+	}
+}
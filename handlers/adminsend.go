@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type newsletterSendStore interface {
+	GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error)
+	GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error)
+	QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error
+	ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error
+	UnscheduleNewsletter(ctx context.Context, id int64) error
+	ListTags(ctx context.Context) ([]model.TagCount, error)
+	CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error)
+}
+
+// AdminNewsletterSendOptions for AdminNewsletterSend.
+type AdminNewsletterSendOptions struct {
+	// Location is the time zone of the newsletter, which the times of schedules are entered and shown in.
+	// Defaults to UTC.
+	Location *time.Location
+	// Now is for checking that schedules are in the future. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// scheduleInputLayout of the time in the schedule form, which is what datetime-local inputs submit.
+const scheduleInputLayout = "2006-01-02T15:04"
+
+// AdminNewsletterSend on a router mounted at /admin, for sending a newsletter issue to the confirmed subscribers.
+// GET /newsletters/{id}/send shows the progress of the send, and reloads itself while it's in progress.
+// With a segment in the query string, it shows how many confirmed subscribers are in it, and the form sends to them.
+// POST /newsletters/{id}/send queues the send to the segment field, or everyone if it's empty, if the issue has
+// a title and a body. Sending an issue again after it's been sent needs the force field set to true,
+// a send in progress can't be queued again, and the segment must be valid, which are shown as error flashes.
+// POST /newsletters/{id}/schedule schedules the issue to be published and sent to the segment field at the time
+// in the at field, in the time zone of the newsletter, which must be in the future. An issue with a send can't be
+// scheduled anymore. DELETE /newsletters/{id}/schedule unschedules it again.
+// Either way, the admin is sent back to the progress page.
+func AdminNewsletterSend(mux chi.Router, s newsletterSendStore, log *zap.Logger, opts AdminNewsletterSendOptions) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	getNewsletter := func(r *http.Request) (*model.Newsletter, error) {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid newsletter ID: %w", storage.ErrNotFound)
+		}
+		n, err := s.GetNewsletter(r.Context(), id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting newsletter: %w", err)
+		}
+		if n == nil {
+			return nil, fmt.Errorf("no newsletter with ID %v: %w", id, storage.ErrNotFound)
+		}
+		return n, nil
+	}
+
+	mux.Get("/newsletters/{id}/send", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		n, err := getNewsletter(r)
+		if err != nil {
+			return err
+		}
+		send, err := s.GetNewsletterSend(r.Context(), n.ID)
+		if err != nil {
+			return fmt.Errorf("error getting newsletter send: %w", err)
+		}
+		tags, err := s.ListTags(r.Context())
+		if err != nil {
+			return fmt.Errorf("error listing tags: %w", err)
+		}
+
+		segment := model.Segment(r.URL.Query().Get("segment")).Normalize()
+		if !r.URL.Query().Has("segment") && n.ScheduledAt != nil {
+			segment = n.ScheduledSegment
+		}
+		var count int
+		var segmentError string
+		if _, err := segment.Parse(); err != nil {
+			segmentError = "The segment isn't valid: " + err.Error() + "."
+		} else if count, err = s.CountSubscribersInSegment(r.Context(), segment, model.SubscriberStatusConfirmed); err != nil {
+			return fmt.Errorf("error counting subscribers in segment: %w", err)
+		}
+
+		return render(w, http.StatusOK, views.AdminNewsletterSend(views.AdminNewsletterSendProps{
+			CSRFToken:    CSRFToken(r),
+			Flashes:      sessions.ConsumeFlashes(r.Context()),
+			Location:     opts.Location,
+			Newsletter:   *n,
+			PreviewURL:   fmt.Sprintf("/admin/newsletters/%v/preview", n.ID),
+			Segment:      segment,
+			SegmentCount: count,
+			SegmentError: segmentError,
+			ScheduleURL:  fmt.Sprintf("/admin/newsletters/%v/schedule", n.ID),
+			Send:         send,
+			SendURL:      fmt.Sprintf("/admin/newsletters/%v/send", n.ID),
+			Tags:         tags,
+		}))
+	}))
+
+	mux.Post("/newsletters/{id}/send", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		if err := r.ParseForm(); err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		force := r.PostForm.Get("force") == "true"
+		segment := model.Segment(r.PostForm.Get("segment")).Normalize()
+
+		n, err := getNewsletter(r)
+		if err != nil {
+			return err
+		}
+		sendURL := fmt.Sprintf("/admin/newsletters/%v/send", n.ID)
+
+		if problem := unpublishableReason(*n); problem != "" {
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "This issue can't be sent, because "+problem+".")
+			http.Redirect(w, r, sendURL, http.StatusSeeOther)
+			return nil
+		}
+
+		if _, err := segment.Parse(); err != nil {
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "This issue can't be sent, because the segment isn't valid: "+
+				err.Error()+".")
+			http.Redirect(w, r, sendURL+"?segment="+url.QueryEscape(segment.String()), http.StatusSeeOther)
+			return nil
+		}
+
+		err = s.QueueNewsletterSend(r.Context(), n.ID, force, segment)
+		switch {
+		case errors.Is(err, storage.ErrSendInProgress):
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "This issue is being sent already.")
+		case errors.Is(err, storage.ErrAlreadySent):
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError,
+				"This issue has been sent already. To send it to everyone again, tick the box and send it again.")
+		case err != nil:
+			return fmt.Errorf("error queueing newsletter send: %w", err)
+		default:
+			requestLog(r.Context(), log).Info("Queued newsletter send", zap.Int64("newsletterID", n.ID), zap.Bool("force", force),
+				zap.Stringer("segment", segment))
+			_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Sending "+n.Title+".")
+		}
+		http.Redirect(w, r, sendURL, http.StatusSeeOther)
+		return nil
+	}))
+
+	mux.Post("/newsletters/{id}/schedule", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		if err := r.ParseForm(); err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		segment := model.Segment(r.PostForm.Get("segment")).Normalize()
+
+		n, err := getNewsletter(r)
+		if err != nil {
+			return err
+		}
+		redirect := fmt.Sprintf("/admin/newsletters/%v/send", n.ID)
+		if segment != "" {
+			redirect += "?segment=" + url.QueryEscape(segment.String())
+		}
+
+		problem := unpublishableReason(*n)
+		at, err := time.ParseInLocation(scheduleInputLayout, strings.TrimSpace(r.PostForm.Get("at")), opts.Location)
+		switch {
+		case problem != "":
+		case err != nil:
+			problem = "the time isn't valid. Enter it like 2023-01-09T09:00"
+		case !at.After(opts.Now()):
+			problem = "the time isn't in the future"
+		}
+		if _, err := segment.Parse(); problem == "" && err != nil {
+			problem = "the segment isn't valid: " + err.Error()
+		}
+		if problem != "" {
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "This issue can't be scheduled, because "+problem+".")
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return nil
+		}
+
+		err = s.ScheduleNewsletter(r.Context(), n.ID, at, segment)
+		switch {
+		case errors.Is(err, storage.ErrNotEditable):
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "This issue is being sent or has been sent already, so it can't be scheduled.")
+		case err != nil:
+			return fmt.Errorf("error scheduling newsletter: %w", err)
+		default:
+			requestLog(r.Context(), log).Info("Scheduled newsletter", zap.Int64("newsletterID", n.ID), zap.Time("at", at),
+				zap.Stringer("segment", segment))
+			_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Scheduled "+n.Title+" for "+
+				views.ScheduleTime(at, opts.Location)+".")
+		}
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return nil
+	}))
+
+	mux.Delete("/newsletters/{id}/schedule", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		n, err := getNewsletter(r)
+		if err != nil {
+			return err
+		}
+		if err := s.UnscheduleNewsletter(r.Context(), n.ID); err != nil {
+			return fmt.Errorf("error unscheduling newsletter: %w", err)
+		}
+		requestLog(r.Context(), log).Info("Unscheduled newsletter", zap.Int64("newsletterID", n.ID))
+		_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Unscheduled "+n.Title+".")
+		http.Redirect(w, r, fmt.Sprintf("/admin/newsletters/%v/send", n.ID), http.StatusSeeOther)
+		return nil
+	}))
+}
+
+// unpublishableReason is why the newsletter issue can't go out, or empty if it can.
+func unpublishableReason(n model.Newsletter) string {
+	switch {
+	case strings.TrimSpace(n.Title) == "":
+		return "it has no title"
+	case strings.TrimSpace(n.Body) == "":
+		return "it has no body"
+	default:
+		return ""
+	}
+}
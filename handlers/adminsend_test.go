@@ -0,0 +1,637 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/apperr"
+	"canvas/email"
+	"canvas/handlers"
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+// newsletterSendStoreMock keeps the newsletter send and the send log in memory, like the database would,
+// for both the handler and the jobs. Queueing a send puts the fan-out job right on the queue, like the outbox relay would.
+type newsletterSendStoreMock struct {
+	mutex       sync.Mutex
+	newsletter  model.Newsletter
+	subscribers []model.Subscriber
+	tags        map[int64][]model.Tag
+	queue       *messaging.MemoryQueue
+	send        *model.NewsletterSend
+	lastEmail   model.Email
+	failed      int
+	fannedOut   bool
+	sends       []model.EmailSend
+	// since is the index of the first entry in sends of the current send.
+	since int
+	// err is returned by QueueNewsletterSend, if set.
+	err error
+}
+
+func newNewsletterSendStoreMock(queue *messaging.MemoryQueue) *newsletterSendStoreMock {
+	s := &newsletterSendStoreMock{
+		newsletter: model.Newsletter{ID: 1, Title: "Issue 1", Body: "Hello."},
+		tags:       map[int64][]model.Tag{1: {"api", "beta"}, 2: {"api"}},
+		queue:      queue,
+	}
+	for i := 0; i < 3; i++ {
+		s.subscribers = append(s.subscribers, model.Subscriber{
+			ID: int64(i + 1), Email: model.Email(fmt.Sprintf("me%v@example.com", i)), Confirmed: true, Active: true,
+		})
+	}
+	return s
+}
+
+func (s *newsletterSendStoreMock) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if id != s.newsletter.ID {
+		return nil, nil
+	}
+	n := s.newsletter
+	return &n, nil
+}
+
+func (s *newsletterSendStoreMock) GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.send == nil {
+		return nil, nil
+	}
+	send := *s.send
+	sent := map[model.Email]bool{}
+	failed := map[model.Email]bool{}
+	for _, e := range s.sends[s.since:] {
+		if e.Status == model.EmailSendStatusSent {
+			sent[e.Email] = true
+		} else {
+			failed[e.Email] = true
+		}
+	}
+	for e := range failed {
+		if !sent[e] {
+			send.Failed++
+		}
+	}
+	send.Sent = len(sent)
+	return &send, nil
+}
+
+func (s *newsletterSendStoreMock) QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if s.send != nil {
+		switch {
+		case s.send.InProgress():
+			return storage.ErrSendInProgress
+		case s.send.State == model.NewsletterSendStateCompleted && !force:
+			return storage.ErrAlreadySent
+		}
+	}
+	subscribers, err := s.inSegment(segment)
+	if err != nil {
+		return err
+	}
+	s.send = &model.NewsletterSend{NewsletterID: newsletterID, State: model.NewsletterSendStateQueued, Segment: segment,
+		Total: len(subscribers)}
+	s.newsletter.ScheduledAt, s.newsletter.ScheduledSegment = nil, ""
+	s.lastEmail, s.failed, s.fannedOut, s.since = "", 0, false, len(s.sends)
+
+	m, err := messaging.NewMessage(model.NewsletterIssueSendRequested{NewsletterID: strconv.FormatInt(newsletterID, 10)})
+	if err != nil {
+		return err
+	}
+	return s.queue.Send(ctx, m)
+}
+
+func (s *newsletterSendStoreMock) ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.send != nil {
+		return storage.ErrNotEditable
+	}
+	s.newsletter.ScheduledAt, s.newsletter.ScheduledSegment = &at, segment
+	return nil
+}
+
+func (s *newsletterSendStoreMock) UnscheduleNewsletter(ctx context.Context, id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.newsletter.ScheduledAt, s.newsletter.ScheduledSegment = nil, ""
+	return nil
+}
+
+func (s *newsletterSendStoreMock) ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	inSegment, err := s.inSegment(opts.Segment)
+	if err != nil {
+		return nil, err
+	}
+	var subscribers []model.Subscriber
+	for _, sub := range inSegment {
+		if sub.Email > opts.After && len(subscribers) < opts.Limit {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	return subscribers, nil
+}
+
+func (s *newsletterSendStoreMock) ListTags(ctx context.Context) ([]model.TagCount, error) {
+	return []model.TagCount{{Tag: "api", Count: 2}, {Tag: "beta", Count: 1}}, nil
+}
+
+func (s *newsletterSendStoreMock) CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	subscribers, err := s.inSegment(segment)
+	return len(subscribers), err
+}
+
+// inSegment are the subscribers in the segment. The mutex must be held.
+func (s *newsletterSendStoreMock) inSegment(segment model.Segment) ([]model.Subscriber, error) {
+	e, err := segment.Parse()
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Invalid, err)
+	}
+	var subscribers []model.Subscriber
+	for _, sub := range s.subscribers {
+		if e == nil || e.Matches(s.tags[sub.ID]) {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	return subscribers, nil
+}
+
+func (s *newsletterSendStoreMock) GetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64) (model.Email, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastEmail, nil
+}
+
+func (s *newsletterSendStoreMock) SetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64, lastEmail model.Email, enqueued, enqueueFailed int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastEmail = lastEmail
+	s.send.Enqueued += enqueued
+	s.failed += enqueueFailed
+	return nil
+}
+
+func (s *newsletterSendStoreMock) StartNewsletterSend(ctx context.Context, newsletterID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.send.State == model.NewsletterSendStateQueued {
+		s.send.State = model.NewsletterSendStateSending
+	}
+	return nil
+}
+
+func (s *newsletterSendStoreMock) FinishNewsletterFanOut(ctx context.Context, newsletterID int64) error {
+	s.mutex.Lock()
+	s.fannedOut = true
+	s.mutex.Unlock()
+	return s.CompleteNewsletterSendIfDone(ctx, newsletterID)
+}
+
+func (s *newsletterSendStoreMock) CompleteNewsletterSendIfDone(ctx context.Context, newsletterID int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.send.State != model.NewsletterSendStateSending || !s.fannedOut {
+		return nil
+	}
+	emails := map[model.Email]bool{}
+	for _, e := range s.sends[s.since:] {
+		emails[e.Email] = true
+	}
+	if len(emails) >= s.send.Enqueued+s.failed {
+		s.send.State = model.NewsletterSendStateCompleted
+	}
+	return nil
+}
+
+func (s *newsletterSendStoreMock) FailNewsletterSend(ctx context.Context, newsletterID int64, reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.send.State = model.NewsletterSendStateFailed
+	s.send.Error = reason
+	return nil
+}
+
+func (s *newsletterSendStoreMock) HasSentNewsletter(ctx context.Context, newsletterID int64, email model.Email) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, e := range s.sends[s.since:] {
+		if e.Email == email && e.Status == model.EmailSendStatusSent {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *newsletterSendStoreMock) IsSuppressed(ctx context.Context, email model.Email) (bool, error) {
+	return false, nil
+}
+
+func (s *newsletterSendStoreMock) RecordEmailSend(ctx context.Context, e model.EmailSend) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sends = append(s.sends, e)
+	return nil
+}
+
+// blockingSenderMock sends emails once it's released, so the send can be seen in progress.
+type blockingSenderMock struct {
+	release chan struct{}
+	mutex   sync.Mutex
+	sent    []model.Email
+}
+
+func (s *blockingSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sent = append(s.sent, m.To)
+	return "abc123", nil
+}
+
+var sendStatMatcher = regexp.MustCompile(`<dt[^>]*>([^<]*)</dt><dd[^>]*>([^<]*)</dd>`)
+
+func TestAdminNewsletterSend(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday afternoon in Berlin.
+	now := time.Date(2023, 1, 6, 15, 0, 0, 0, time.UTC)
+
+	setup := func(s *newsletterSendStoreMock) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminNewsletterSend(r, s, zap.NewNop(), handlers.AdminNewsletterSendOptions{
+				Location: berlin,
+				Now:      func() time.Time { return now },
+			})
+		})
+		return mux
+	}
+
+	// get the page, returning its body and the stats of the send on it, like "State".
+	get := func(t *testing.T, mux chi.Router, target string, cookies []*http.Cookie) (int, string, map[string]string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		body, _ := io.ReadAll(res.Body)
+		stats := map[string]string{}
+		for _, match := range sendStatMatcher.FindAllStringSubmatch(string(body), -1) {
+			stats[match[1]] = match[2]
+		}
+		return res.Code, string(body), stats
+	}
+
+	// post the form, returning the redirect location and the flash on the page redirected to.
+	post := func(t *testing.T, mux chi.Router, target, body string) (int, string, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		location := res.Header().Get("Location")
+		if location == "" {
+			return res.Code, "", ""
+		}
+		_, page, _ := get(t, mux, location, res.Result().Cookies())
+		flash := flashMatcher.FindStringSubmatch(page)
+		if flash == nil {
+			return res.Code, location, ""
+		}
+		return res.Code, location, flash[1] + ": " + html.UnescapeString(flash[2])
+	}
+
+	// waitFor the send to be in the state on the page, returning the stats on it.
+	waitFor := func(t *testing.T, mux chi.Router, state model.NewsletterSendState) map[string]string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, _, stats := get(t, mux, "/admin/newsletters/1/send", nil)
+			if stats["State"] == string(state) {
+				return stats
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("send is %v, not %v", stats["State"], state)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("sends the issue through the queue, showing its progress, and requires force to send it again", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		s := newNewsletterSendStoreMock(queue)
+		sender := &blockingSenderMock{release: make(chan struct{})}
+		mux := setup(s)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue})
+		jobs.FanOutNewsletterIssue(runner, jobs.FanOutNewsletterIssueOptions{Queue: queue, Store: s})
+		jobs.SendNewsletterIssueEmail(runner, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          sender,
+			Store:           s,
+		})
+
+		code, body, _ := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "This issue hasn&#39;t been sent."))
+
+		code, location, flash := post(t, mux, "/admin/newsletters/1/send", "")
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/newsletters/1/send", location)
+		is.Equal("success: Sending Issue 1.", flash)
+
+		_, body, stats := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.Equal("queued", stats["State"])
+		is.Equal("3", stats["Total"])
+		is.True(strings.Contains(body, `<meta http-equiv="refresh" content="5">`))
+		is.True(!strings.Contains(body, "<form action=\"/admin/newsletters/1/send\""))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runner.Start(ctx)
+
+		stats = waitFor(t, mux, model.NewsletterSendStateSending)
+		is.Equal("0", stats["Sent"])
+
+		_, _, flash = post(t, mux, "/admin/newsletters/1/send", "force=true")
+		is.Equal("error: This issue is being sent already.", flash)
+
+		close(sender.release)
+		stats = waitFor(t, mux, model.NewsletterSendStateCompleted)
+		is.Equal("3", stats["Sent"])
+		is.Equal("0", stats["Failed"])
+		is.Equal("3", stats["Total"])
+		_, body, _ = get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(!strings.Contains(body, "http-equiv"))
+		is.True(strings.Contains(body, `name="force"`))
+
+		_, _, flash = post(t, mux, "/admin/newsletters/1/send", "")
+		is.True(strings.HasPrefix(flash, "error: This issue has been sent already."))
+
+		_, _, flash = post(t, mux, "/admin/newsletters/1/send", "force=true")
+		is.Equal("success: Sending Issue 1.", flash)
+		waitFor(t, mux, model.NewsletterSendStateCompleted)
+
+		sender.mutex.Lock()
+		defer sender.mutex.Unlock()
+		is.Equal(6, len(sender.sent))
+	})
+
+	t.Run("sends the issue only to the subscribers in the segment", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		s := newNewsletterSendStoreMock(queue)
+		sender := &blockingSenderMock{release: make(chan struct{})}
+		close(sender.release)
+		mux := setup(s)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue})
+		jobs.FanOutNewsletterIssue(runner, jobs.FanOutNewsletterIssueOptions{Queue: queue, Store: s})
+		jobs.SendNewsletterIssueEmail(runner, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          sender,
+			Store:           s,
+		})
+
+		_, body, _ := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(strings.Contains(body, "Without a segment, the issue goes to all 3 confirmed subscribers."))
+		is.True(strings.Contains(body, "<code>api</code> (2)"))
+
+		_, body, _ = get(t, mux, "/admin/newsletters/1/send?segment=API+and+(beta)", nil)
+		is.True(strings.Contains(body, `value="api and beta"`))
+		is.True(strings.Contains(body, "Confirmed subscribers in the segment: 1."))
+		is.True(strings.Contains(body, `<input type="hidden" name="segment" value="api and beta">`))
+
+		_, _, flash := post(t, mux, "/admin/newsletters/1/send", "segment=api")
+		is.Equal("success: Sending Issue 1.", flash)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runner.Start(ctx)
+
+		stats := waitFor(t, mux, model.NewsletterSendStateCompleted)
+		is.Equal("2", stats["Sent"])
+		is.Equal("2", stats["Total"])
+		_, body, _ = get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(strings.Contains(body, "To the subscribers in the segment <code>api</code>."))
+
+		sender.mutex.Lock()
+		defer sender.mutex.Unlock()
+		sort.Slice(sender.sent, func(i, j int) bool { return sender.sent[i] < sender.sent[j] })
+		is.Equal([]model.Email{"me0@example.com", "me1@example.com"}, sender.sent)
+	})
+
+	t.Run("shows an invalid segment with its error, and doesn't send to it", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := setup(s)
+
+		_, body, _ := get(t, mux, "/admin/newsletters/1/send?segment=api+and", nil)
+		is.True(strings.Contains(body, `id="segment-error"`))
+		is.True(strings.Contains(body, "The segment isn&#39;t valid: expected a tag or (, but the segment ends, at character 8."))
+		is.True(!strings.Contains(body, "Send to"))
+
+		code, location, flash := post(t, mux, "/admin/newsletters/1/send", "segment=api+or+(beta")
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/newsletters/1/send?segment=api+or+%28beta", location)
+		is.Equal("error: This issue can't be sent, because the segment isn't valid: expected ) for the ( at character 8, at character 13.", flash)
+		is.True(s.send == nil)
+	})
+
+	t.Run("doesn't send an issue without a title or body", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.newsletter.Body = " "
+		_, _, flash := post(t, setup(s), "/admin/newsletters/1/send", "")
+		is.Equal("error: This issue can't be sent, because it has no body.", flash)
+		is.True(s.send == nil)
+	})
+
+	t.Run("shows a failed send with its error", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.send = &model.NewsletterSend{NewsletterID: 1, State: model.NewsletterSendStateFailed, Error: "oh no", Total: 3}
+		_, body, stats := get(t, setup(s), "/admin/newsletters/1/send", nil)
+		is.Equal("failed", stats["State"])
+		is.True(strings.Contains(body, "Sending failed: oh no"))
+		is.True(strings.Contains(body, "Resume sending"))
+	})
+
+	t.Run("responds with not found for an unknown newsletter", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := setup(newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond)))
+		code, _, _ := get(t, mux, "/admin/newsletters/2/send", nil)
+		is.Equal(http.StatusNotFound, code)
+		code, _, _ = post(t, mux, "/admin/newsletters/abc/send", "")
+		is.Equal(http.StatusNotFound, code)
+	})
+
+	t.Run("schedules the issue in the time zone of the newsletter, and unschedules it", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := setup(s)
+
+		_, body, _ := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(strings.Contains(body, `<form action="/admin/newsletters/1/schedule" method="post"`))
+		is.True(strings.Contains(body, "Time, in Europe/Berlin"))
+
+		code, location, flash := post(t, mux, "/admin/newsletters/1/schedule", "at=2023-01-09T09:00&segment=API")
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/newsletters/1/send?segment=api", location)
+		is.Equal("success: Scheduled Issue 1 for Mon 9 Jan 2023 09:00 CET.", flash)
+		is.Equal(time.Date(2023, 1, 9, 8, 0, 0, 0, time.UTC), s.newsletter.ScheduledAt.UTC())
+		is.Equal(model.Segment("api"), s.newsletter.ScheduledSegment)
+
+		_, body, _ = get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(strings.Contains(body, "This issue is scheduled, and hasn&#39;t been sent."))
+		is.True(strings.Contains(body, "Scheduled to be published and sent to the subscribers in the segment <code>api</code> on Mon 9 Jan 2023 09:00 CET."))
+		is.True(strings.Contains(body, `value="2023-01-09T09:00"`))
+		is.True(strings.Contains(body, `value="api"`))
+
+		// In summer, it's CEST.
+		_, _, flash = post(t, mux, "/admin/newsletters/1/schedule", "at=2023-07-03T09:00")
+		is.Equal("success: Scheduled Issue 1 for Mon 3 Jul 2023 09:00 CEST.", flash)
+		is.Equal(time.Date(2023, 7, 3, 7, 0, 0, 0, time.UTC), s.newsletter.ScheduledAt.UTC())
+
+		code, location, flash = post(t, mux, "/admin/newsletters/1/schedule", "_method=DELETE")
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/newsletters/1/send", location)
+		is.Equal("success: Unscheduled Issue 1.", flash)
+		is.True(s.newsletter.ScheduledAt == nil)
+	})
+
+	t.Run("clears the schedule when the issue is sent before it's due", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		mux := setup(s)
+
+		_, _, flash := post(t, mux, "/admin/newsletters/1/schedule", "at=2023-01-09T09:00")
+		is.True(strings.HasPrefix(flash, "success: "))
+		_, _, flash = post(t, mux, "/admin/newsletters/1/send", "")
+		is.Equal("success: Sending Issue 1.", flash)
+		is.True(s.newsletter.ScheduledAt == nil)
+
+		_, body, _ := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(!strings.Contains(body, `id="schedule"`))
+	})
+
+	invalidSchedules := []struct {
+		body string
+		err  string
+	}{
+		{"at=", "the time isn't valid. Enter it like 2023-01-09T09:00"},
+		{"at=9.1.2023+9:00", "the time isn't valid. Enter it like 2023-01-09T09:00"},
+		{"at=2023-01-06T15:59", "the time isn't in the future"},
+		{"at=2023-01-06T16:00", "the time isn't in the future"},
+		{"at=2023-01-09T09:00&segment=api+and", "the segment isn't valid: expected a tag or (, but the segment ends, at character 8"},
+	}
+	for _, test := range invalidSchedules {
+		t.Run("doesn't schedule the issue for "+test.body, func(t *testing.T) {
+			is := is.New(t)
+
+			s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+			_, _, flash := post(t, setup(s), "/admin/newsletters/1/schedule", test.body)
+			is.Equal("error: This issue can't be scheduled, because "+test.err+".", flash)
+			is.True(s.newsletter.ScheduledAt == nil)
+		})
+	}
+
+	t.Run("schedules the issue a minute into the future", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		_, _, flash := post(t, setup(s), "/admin/newsletters/1/schedule", "at=2023-01-06T16:01")
+		is.Equal("success: Scheduled Issue 1 for Fri 6 Jan 2023 16:01 CET.", flash)
+	})
+
+	t.Run("doesn't schedule an issue without a title", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.newsletter.Title = ""
+		_, _, flash := post(t, setup(s), "/admin/newsletters/1/schedule", "at=2023-01-09T09:00")
+		is.Equal("error: This issue can't be scheduled, because it has no title.", flash)
+		is.True(s.newsletter.ScheduledAt == nil)
+	})
+
+	t.Run("doesn't schedule an issue that's being sent or has been sent, and has no form for it", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.send = &model.NewsletterSend{NewsletterID: 1, State: model.NewsletterSendStateCompleted, Total: 3}
+		mux := setup(s)
+
+		_, body, _ := get(t, mux, "/admin/newsletters/1/send", nil)
+		is.True(!strings.Contains(body, `id="schedule"`))
+
+		_, _, flash := post(t, mux, "/admin/newsletters/1/schedule", "at=2023-01-09T09:00")
+		is.Equal("error: This issue is being sent or has been sent already, so it can't be scheduled.", flash)
+		is.True(s.newsletter.ScheduledAt == nil)
+	})
+
+	t.Run("renders an error page if queueing fails", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		s.err = errors.New("oh no")
+		code, _, _ := post(t, setup(s), "/admin/newsletters/1/send", "")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
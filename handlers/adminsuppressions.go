@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/form"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type suppressionStore interface {
+	AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error
+	ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error)
+	RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error)
+}
+
+// adminSuppressionsLimit is how many of the newest suppressions are shown.
+const adminSuppressionsLimit = 200
+
+// AdminSuppressions on a router mounted at /admin, for the suppression list that every email is checked against.
+// GET /suppressions shows the newest suppressions, or with the email query parameter, only the one of that address.
+// POST /suppressions adds the address in the email field for the manual reason, and DELETE /suppressions/{id}
+// removes the suppression, so the address is sent emails again. Both are recorded in the audit log,
+// and send the admin back to the list.
+func AdminSuppressions(mux chi.Router, s suppressionStore, log *zap.Logger) {
+	mux.Get("/suppressions", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		props := views.AdminSuppressionsProps{
+			CSRFToken: CSRFToken(r),
+			Flashes:   sessions.ConsumeFlashes(r.Context()),
+			Email:     strings.TrimSpace(r.URL.Query().Get("email")),
+		}
+		if props.Email != "" && !model.Email(props.Email).IsValid() {
+			props.EmailError = "That doesn't look like an email address. Please check it and try again."
+			return render(w, http.StatusOK, views.AdminSuppressions(props))
+		}
+
+		suppressions, err := s.ListSuppressions(r.Context(), storage.ListSuppressionsOptions{
+			Email: model.Email(props.Email),
+			Limit: adminSuppressionsLimit + 1,
+		})
+		if err != nil {
+			return fmt.Errorf("error listing suppressions: %w", err)
+		}
+		// The extra suppression tells whether there are more than shown.
+		if len(suppressions) > adminSuppressionsLimit {
+			suppressions = suppressions[:adminSuppressionsLimit]
+			props.Capped = true
+		}
+		props.Suppressions = suppressions
+		return render(w, http.StatusOK, views.AdminSuppressions(props))
+	}))
+
+	mux.Post("/suppressions", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		email := f.Email("email")
+		if err := f.Err(); err != nil {
+			return err
+		}
+
+		if err := s.AddSuppression(r.Context(), email, model.SuppressionReasonManual, storage.AuditActorAdmin); err != nil {
+			return fmt.Errorf("error adding suppression: %w", err)
+		}
+		requestLog(r.Context(), log).Info("Added suppression")
+		_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, fmt.Sprintf("Suppressed %v. They won't be sent any emails.", email))
+		http.Redirect(w, r, "/admin/suppressions", http.StatusSeeOther)
+		return nil
+	}))
+
+	mux.Delete("/suppressions/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err == nil {
+			_, err = s.RemoveSuppression(r.Context(), id, storage.AuditActorAdmin)
+		} else {
+			err = storage.ErrNotFound
+		}
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "That suppression was removed in the meantime, so nothing was done.")
+		case err != nil:
+			return fmt.Errorf("error removing suppression: %w", err)
+		default:
+			requestLog(r.Context(), log).Info("Removed suppression", zap.Int64("id", id))
+			_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Removed the suppression. The address will be sent emails again.")
+		}
+		http.Redirect(w, r, "/admin/suppressions", http.StatusSeeOther)
+		return nil
+	}))
+}
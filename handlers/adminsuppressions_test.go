@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type suppressionStoreMock struct {
+	err          error
+	added        []string
+	listOpts     storage.ListSuppressionsOptions
+	removed      []int64
+	suppressions []model.Suppression
+}
+
+func (s *suppressionStoreMock) AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error {
+	s.added = append(s.added, email.String()+" "+string(reason)+" by "+source)
+	return s.err
+}
+
+func (s *suppressionStoreMock) ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error) {
+	s.listOpts = opts
+	if len(s.suppressions) > opts.Limit {
+		return s.suppressions[:opts.Limit], s.err
+	}
+	return s.suppressions, s.err
+}
+
+func (s *suppressionStoreMock) RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error) {
+	if s.err != nil {
+		return model.Suppression{}, s.err
+	}
+	for i, sup := range s.suppressions {
+		if sup.ID == id && actor == storage.AuditActorAdmin {
+			s.suppressions = append(s.suppressions[:i], s.suppressions[i+1:]...)
+			s.removed = append(s.removed, id)
+			return sup, nil
+		}
+	}
+	return model.Suppression{}, storage.ErrNotFound
+}
+
+func TestAdminSuppressions(t *testing.T) {
+	newStore := func() *suppressionStoreMock {
+		return &suppressionStoreMock{suppressions: []model.Suppression{
+			{ID: 2, EmailHash: "f0e4c2f76c58916ec258f246851bea091d14d4247a2fc3e18694461b1816e13b", Reason: model.SuppressionReasonComplained,
+				Source: "email_provider", Created: time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)},
+			{ID: 1, EmailHash: "abc", Reason: model.SuppressionReasonManual, Source: "admin",
+				Created: time.Date(2022, 12, 9, 12, 0, 0, 0, time.UTC)},
+		}}
+	}
+
+	newMux := func(s *suppressionStoreMock) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminSuppressions(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	// post the form to the target, and return the redirect location and the flash on the page redirected to.
+	post := func(mux chi.Router, target, body string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		location := res.Header().Get("Location")
+		if location == "" {
+			return res.Code, "", ""
+		}
+
+		req = httptest.NewRequest(http.MethodGet, location, nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res2 := httptest.NewRecorder()
+		mux.ServeHTTP(res2, req)
+		flash := flashMatcher.FindStringSubmatch(res2.Body.String())
+		if flash == nil {
+			return res.Code, location, ""
+		}
+		return res.Code, location, flash[1] + ": " + html.UnescapeString(flash[2])
+	}
+
+	t.Run("shows the newest suppressions, with a remove button that asks first", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		code, _, body := makeGetRequest(newMux(s), "/admin/suppressions")
+		is.Equal(http.StatusOK, code)
+		is.Equal(storage.ListSuppressionsOptions{Limit: 201}, s.listOpts)
+		is.True(strings.Index(body, `id="suppression-2"`) < strings.Index(body, `id="suppression-1"`))
+		is.True(strings.Contains(body, "f0e4c2f76c58…"))
+		is.True(strings.Contains(body, "complained"))
+		is.True(strings.Contains(body, "email_provider"))
+		is.True(strings.Contains(body, `action="/admin/suppressions/2"`))
+		is.True(strings.Contains(body, `data-confirm="Remove this suppression?`))
+		is.True(strings.Contains(body, `<input type="hidden" name="_method" value="DELETE">`))
+		is.True(!strings.Contains(body, `id="suppressions-capped"`))
+	})
+
+	t.Run("finds the suppression of an address", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &suppressionStoreMock{}
+		code, _, body := makeGetRequest(newMux(s), "/admin/suppressions?email=me%40example.com")
+		is.Equal(http.StatusOK, code)
+		is.Equal(model.Email("me@example.com"), s.listOpts.Email)
+		is.True(strings.Contains(html.UnescapeString(body), "me@example.com isn't suppressed."))
+	})
+
+	t.Run("shows an error for an invalid address, without looking it up", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &suppressionStoreMock{}
+		code, _, body := makeGetRequest(newMux(s), "/admin/suppressions?email=nope")
+		is.Equal(http.StatusOK, code)
+		is.Equal(storage.ListSuppressionsOptions{}, s.listOpts)
+		is.True(strings.Contains(body, `id="email-error"`))
+	})
+
+	t.Run("says when there are more suppressions than shown", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &suppressionStoreMock{}
+		for i := 0; i < 201; i++ {
+			s.suppressions = append(s.suppressions, model.Suppression{ID: int64(i + 1), EmailHash: "abc", Reason: model.SuppressionReasonBounced})
+		}
+		_, _, body := makeGetRequest(newMux(s), "/admin/suppressions")
+		is.True(strings.Contains(body, `id="suppressions-capped"`))
+		is.True(!strings.Contains(body, `id="suppression-201"`))
+	})
+
+	t.Run("adds a manual suppression by the admin, and redirects back with a flash", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		code, location, flash := post(newMux(s), "/admin/suppressions", url.Values{"email": {"me@example.com"}}.Encode())
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/suppressions", location)
+		is.Equal("success: Suppressed me@example.com. They won't be sent any emails.", flash)
+		is.Equal([]string{"me@example.com manual by admin"}, s.added)
+	})
+
+	t.Run("returns 422 for adding an invalid address", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		code, _, _ := post(newMux(s), "/admin/suppressions", url.Values{"email": {"nope"}}.Encode())
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.Equal(0, len(s.added))
+	})
+
+	t.Run("removes a suppression by the admin, and redirects back with a flash", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		code, location, flash := post(newMux(s), "/admin/suppressions/2", url.Values{"_method": {"DELETE"}}.Encode())
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/suppressions", location)
+		is.Equal("success: Removed the suppression. The address will be sent emails again.", flash)
+		is.Equal([]int64{2}, s.removed)
+	})
+
+	t.Run("shows an error flash for an unknown or invalid ID", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		mux := newMux(s)
+		for _, target := range []string{"/admin/suppressions/3", "/admin/suppressions/nope"} {
+			code, location, flash := post(mux, target, url.Values{"_method": {"DELETE"}}.Encode())
+			is.Equal(http.StatusSeeOther, code)
+			is.Equal("/admin/suppressions", location)
+			is.Equal("error: That suppression was removed in the meantime, so nothing was done.", flash)
+		}
+		is.Equal(0, len(s.removed))
+	})
+
+	t.Run("renders an error page if removing fails for another reason", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		s.err = errors.New("oh no")
+		code, _, _ := post(newMux(s), "/admin/suppressions/2", url.Values{"_method": {"DELETE"}}.Encode())
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type subscriberTagger interface {
+	AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+	RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+}
+
+// AdminSubscriberTags on a router mounted at /admin, for the tag forms on the page of a subscriber:
+// POST /subscribers/{id}/tags adds the tag in the tag field, and DELETE /subscribers/{id}/tags/{tag} removes the tag.
+// Tags are lowercased, and each change is recorded in the audit log. An invalid tag, and a subscriber that's been
+// deleted in the meantime, are shown as error flashes. Afterwards, the admin is sent back to the subscriber's page,
+// or the list of subscribers if they're deleted.
+func AdminSubscriberTags(mux chi.Router, s subscriberTagger, log *zap.Logger) {
+	type change func(ctx context.Context, id int64, tag model.Tag, actor string) error
+
+	action := func(name string, change change, tagFrom func(r *http.Request) string, done string) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			if err := r.ParseForm(); err != nil {
+				return fmt.Errorf("error parsing form: %w", err)
+			}
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid subscriber ID: %w", storage.ErrNotFound)
+			}
+			redirect := fmt.Sprintf("/admin/subscribers/%v", id)
+
+			tag := model.Tag(strings.ToLower(strings.TrimSpace(tagFrom(r))))
+			if !tag.IsValid() {
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, fmt.Sprintf("%q isn't a valid tag. "+
+					"Tags are up to 50 lowercase letters, digits, hyphens, and underscores, and can't be and or or.", tag))
+				http.Redirect(w, r, redirect, http.StatusSeeOther)
+				return nil
+			}
+
+			err = change(r.Context(), id, tag, storage.AuditActorAdmin)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, "That subscriber was deleted in the meantime, so nothing was done.")
+				redirect = "/admin/subscribers"
+			case err != nil:
+				return fmt.Errorf("error changing subscriber with %v: %w", name, err)
+			default:
+				requestLog(r.Context(), log).Info("Changed subscriber tags", zap.String("action", name), zap.Int64("id", id),
+					zap.Stringer("tag", tag))
+				_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, fmt.Sprintf(done, tag))
+			}
+			http.Redirect(w, r, redirect, http.StatusSeeOther)
+			return nil
+		})
+	}
+
+	mux.Post("/subscribers/{id}/tags", action("tag", s.AddSubscriberTag, func(r *http.Request) string {
+		return r.PostForm.Get("tag")
+	}, "Tagged with %v."))
+	mux.Delete("/subscribers/{id}/tags/{tag}", action("untag", s.RemoveSubscriberTag, func(r *http.Request) string {
+		return chi.URLParam(r, "tag")
+	}, "Removed the tag %v."))
+}
@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+// subscriberTaggerMock records the tag changes of the subscribers with the IDs.
+type subscriberTaggerMock struct {
+	err     error
+	ids     map[int64]bool
+	changes []string
+}
+
+func (s *subscriberTaggerMock) change(action string, id int64, tag model.Tag, actor string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if !s.ids[id] {
+		return storage.ErrNotFound
+	}
+	s.changes = append(s.changes, fmt.Sprintf("%v %v %v by %v", action, id, tag, actor))
+	return nil
+}
+
+func (s *subscriberTaggerMock) AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	return s.change("tag", id, tag, actor)
+}
+
+func (s *subscriberTaggerMock) RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	return s.change("untag", id, tag, actor)
+}
+
+func TestAdminSubscriberTags(t *testing.T) {
+	newMux := func(s *subscriberTaggerMock) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminSubscribers(r, newSubscriberListerMock(0), zap.NewNop())
+			handlers.AdminSubscriber(r, &subscriberGetterMock{subscribers: []model.Subscriber{{ID: 3, Email: "me@example.com"}}},
+				zap.NewNop())
+			handlers.AdminSubscriberTags(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	// post the form to the target, and return the redirect location and the flash on the page redirected to.
+	post := func(mux chi.Router, target, body string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		location := res.Header().Get("Location")
+		if location == "" {
+			return res.Code, "", ""
+		}
+
+		req = httptest.NewRequest(http.MethodGet, location, nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res2 := httptest.NewRecorder()
+		mux.ServeHTTP(res2, req)
+		flash := flashMatcher.FindStringSubmatch(res2.Body.String())
+		if flash == nil {
+			return res.Code, location, ""
+		}
+		return res.Code, location, flash[1] + ": " + html.UnescapeString(flash[2])
+	}
+
+	newTaggerMock := func() *subscriberTaggerMock {
+		return &subscriberTaggerMock{ids: map[int64]bool{3: true}}
+	}
+
+	t.Run("adds the tag, lowercased, and redirects back to the subscriber with a flash", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newTaggerMock()
+		code, location, flash := post(newMux(s), "/admin/subscribers/3/tags", url.Values{"tag": {" Beta "}}.Encode())
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/subscribers/3", location)
+		is.Equal("success: Tagged with beta.", flash)
+		is.Equal([]string{"tag 3 beta by admin"}, s.changes)
+	})
+
+	t.Run("removes the tag in the path", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newTaggerMock()
+		code, location, flash := post(newMux(s), "/admin/subscribers/3/tags/beta", url.Values{"_method": {"DELETE"}}.Encode())
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/subscribers/3", location)
+		is.Equal("success: Removed the tag beta.", flash)
+		is.Equal([]string{"untag 3 beta by admin"}, s.changes)
+	})
+
+	invalid := []string{"", "beta test", "and", "-beta", strings.Repeat("a", 51)}
+	for _, tag := range invalid {
+		t.Run(fmt.Sprintf("shows an error flash for the invalid tag %q", tag), func(t *testing.T) {
+			is := is.New(t)
+
+			s := newTaggerMock()
+			code, location, flash := post(newMux(s), "/admin/subscribers/3/tags", url.Values{"tag": {tag}}.Encode())
+			is.Equal(http.StatusSeeOther, code)
+			is.Equal("/admin/subscribers/3", location)
+			is.True(strings.HasPrefix(flash, "error: "))
+			is.True(strings.Contains(flash, "isn't a valid tag."))
+			is.Equal(0, len(s.changes))
+		})
+	}
+
+	t.Run("shows an error flash on the list for a deleted subscriber", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newTaggerMock()
+		code, location, flash := post(newMux(s), "/admin/subscribers/4/tags", url.Values{"tag": {"beta"}}.Encode())
+		is.Equal(http.StatusSeeOther, code)
+		is.Equal("/admin/subscribers", location)
+		is.Equal("error: That subscriber was deleted in the meantime, so nothing was done.", flash)
+	})
+
+	t.Run("responds with not found for an invalid ID", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, _ := post(newMux(newTaggerMock()), "/admin/subscribers/nope/tags", url.Values{"tag": {"beta"}}.Encode())
+		is.Equal(http.StatusNotFound, code)
+	})
+
+	t.Run("renders an error page if changing the tags fails for another reason", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newTaggerMock()
+		s.err = errors.New("oh no")
+		code, _, _ := post(newMux(s), "/admin/subscribers/3/tags", url.Values{"tag": {"beta"}}.Encode())
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
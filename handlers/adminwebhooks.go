@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/form"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+	"canvas/webhook"
+)
+
+// webhookDeliveryLogLimit is how many of the latest deliveries to an endpoint its page shows.
+const webhookDeliveryLogLimit = 50
+
+type webhookEndpointStore interface {
+	CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error)
+	GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error)
+}
+
+// AdminWebhooksOptions for AdminWebhooks.
+type AdminWebhooksOptions struct {
+	// AllowPrivateNetworks allows endpoint URLs in private networks, like localhost, as the delivery job does then.
+	AllowPrivateNetworks bool
+}
+
+// AdminWebhooks on a router mounted at /admin, for the endpoints that get the domain events POSTed to them.
+// GET /webhooks shows the endpoints. POST /webhooks creates one with the url and the event types ticked,
+// and a new secret for verifying the signatures, which is only shown in the response, like for API tokens.
+// GET /webhooks/{id} shows the endpoint and the log of its latest deliveries. POST /webhooks/{id}/enable
+// enables a disabled endpoint again, and DELETE /webhooks/{id} deletes it with its deliveries.
+// Changes are recorded in the audit log.
+func AdminWebhooks(mux chi.Router, s webhookEndpointStore, log *zap.Logger, opts AdminWebhooksOptions) {
+	list := func(r *http.Request, props views.AdminWebhooksProps) (views.AdminWebhooksProps, error) {
+		endpoints, err := s.ListWebhookEndpoints(r.Context())
+		if err != nil {
+			return props, fmt.Errorf("error listing webhook endpoints: %w", err)
+		}
+		props.CSRFToken = CSRFToken(r)
+		props.Endpoints = endpoints
+		return props, nil
+	}
+
+	mux.Get("/webhooks", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		props, err := list(r, views.AdminWebhooksProps{Flashes: sessions.ConsumeFlashes(r.Context())})
+		if err != nil {
+			return err
+		}
+		return render(w, http.StatusOK, views.AdminWebhooks(props))
+	}))
+
+	mux.Post("/webhooks", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		f.Required("url")
+		url := f.String("url")
+		if url != "" {
+			if err := webhook.ValidateURL(url, opts.AllowPrivateNetworks); err != nil {
+				f.AddError("url", fmt.Sprintf("The URL %v.", err))
+			}
+		}
+		var eventTypes []string
+		for _, eventType := range model.WebhookEventTypes {
+			if f.Bool(eventType) {
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+		if len(eventTypes) == 0 {
+			f.AddError("events", "Please pick at least one event.")
+		}
+		if err := f.Err(); err != nil {
+			return err
+		}
+
+		created, err := s.CreateWebhookEndpoint(r.Context(), url, eventTypes, storage.AuditActorAdmin)
+		if err != nil {
+			return fmt.Errorf("error creating webhook endpoint: %w", err)
+		}
+		requestLog(r.Context(), log).Info("Created webhook endpoint", zap.Int64("id", created.ID))
+
+		props, err := list(r, views.AdminWebhooksProps{Created: &created})
+		if err != nil {
+			return err
+		}
+		// The secret mustn't be kept anywhere, like in the back-forward cache.
+		w.Header().Set("Cache-Control", "no-store")
+		return render(w, http.StatusCreated, views.AdminWebhooks(props))
+	}))
+
+	mux.Get("/webhooks/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid webhook endpoint ID: %w", storage.ErrNotFound)
+		}
+		e, err := s.GetWebhookEndpoint(r.Context(), id)
+		if err != nil {
+			return fmt.Errorf("error getting webhook endpoint: %w", err)
+		}
+		if e == nil {
+			return fmt.Errorf("no webhook endpoint with ID %v: %w", id, storage.ErrNotFound)
+		}
+		deliveries, err := s.ListWebhookDeliveries(r.Context(), id, webhookDeliveryLogLimit)
+		if err != nil {
+			return fmt.Errorf("error listing webhook deliveries: %w", err)
+		}
+		return render(w, http.StatusOK, views.AdminWebhook(views.AdminWebhookProps{
+			CSRFToken:  CSRFToken(r),
+			Deliveries: deliveries,
+			Endpoint:   *e,
+			Flashes:    sessions.ConsumeFlashes(r.Context()),
+		}))
+	}))
+
+	// change the endpoint with the ID in the path, flashing success on the page of the endpoint,
+	// and sending the admin back to the list if the endpoint was deleted in the meantime.
+	change := func(action string, fn func(ctx context.Context, id int64) error, success string, redirect func(id int64) string) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+			if err == nil {
+				err = fn(r.Context(), id)
+			} else {
+				err = storage.ErrNotFound
+			}
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				_ = sessions.AddFlash(r.Context(), sessions.FlashError, "That endpoint was deleted in the meantime, so nothing was done.")
+				http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+				return nil
+			case err != nil:
+				return fmt.Errorf("error changing webhook endpoint: %w", err)
+			}
+			requestLog(r.Context(), log).Info(action, zap.Int64("id", id))
+			_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, success)
+			http.Redirect(w, r, redirect(id), http.StatusSeeOther)
+			return nil
+		})
+	}
+
+	mux.Post("/webhooks/{id}/enable", change("Enabled webhook endpoint", func(ctx context.Context, id int64) error {
+		return s.EnableWebhookEndpoint(ctx, id, storage.AuditActorAdmin)
+	}, "Enabled the endpoint again. It gets the events from now on.", func(id int64) string {
+		return fmt.Sprintf("/admin/webhooks/%v", id)
+	}))
+
+	mux.Delete("/webhooks/{id}", change("Deleted webhook endpoint", func(ctx context.Context, id int64) error {
+		return s.DeleteWebhookEndpoint(ctx, id, storage.AuditActorAdmin)
+	}, "Deleted the endpoint. It doesn't get events anymore.", func(int64) string {
+		return "/admin/webhooks"
+	}))
+}
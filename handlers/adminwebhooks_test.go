@@ -0,0 +1,234 @@
+package handlers_test
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type webhookEndpointStoreMock struct {
+	endpoints  []model.WebhookEndpoint
+	deliveries []model.WebhookDelivery
+}
+
+func (s *webhookEndpointStoreMock) CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string,
+	actor string) (model.WebhookEndpoint, error) {
+	e := model.WebhookEndpoint{ID: int64(len(s.endpoints) + 1), URL: url, Secret: "whsec_secret", EventTypes: eventTypes,
+		Enabled: true, Created: time.Now()}
+	s.endpoints = append(s.endpoints, e)
+	return e, nil
+}
+
+func (s *webhookEndpointStoreMock) ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	return s.endpoints, nil
+}
+
+func (s *webhookEndpointStoreMock) GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error) {
+	for _, e := range s.endpoints {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *webhookEndpointStoreMock) DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	for i, e := range s.endpoints {
+		if e.ID == id && actor == storage.AuditActorAdmin {
+			s.endpoints = append(s.endpoints[:i], s.endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *webhookEndpointStoreMock) EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	for i := range s.endpoints {
+		if e := &s.endpoints[i]; e.ID == id && actor == storage.AuditActorAdmin {
+			e.Enabled, e.ConsecutiveFailures, e.DisabledReason = true, 0, ""
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (s *webhookEndpointStoreMock) ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error) {
+	return s.deliveries, nil
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	newMux := func(s *webhookEndpointStoreMock, opts handlers.AdminWebhooksOptions) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminWebhooks(r, s, zap.NewNop(), opts)
+		})
+		return mux
+	}
+
+	post := func(mux chi.Router, target string, values url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	// flash on the page the response redirects to.
+	flash := func(mux chi.Router, res *httptest.ResponseRecorder) string {
+		req := httptest.NewRequest(http.MethodGet, res.Header().Get("Location"), nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res2 := httptest.NewRecorder()
+		mux.ServeHTTP(res2, req)
+		match := flashMatcher.FindStringSubmatch(res2.Body.String())
+		if match == nil {
+			return ""
+		}
+		return match[1] + ": " + html.UnescapeString(match[2])
+	}
+
+	disabled := func() *webhookEndpointStoreMock {
+		return &webhookEndpointStoreMock{endpoints: []model.WebhookEndpoint{{ID: 1, URL: "https://example.com/webhooks",
+			EventTypes: []string{model.EventSubscriberConfirmed}, ConsecutiveFailures: 5,
+			DisabledReason: "5 deliveries in a row failed.", Created: time.Now()}}}
+	}
+
+	t.Run("creates an endpoint for the events ticked, showing its secret only once", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &webhookEndpointStoreMock{}
+		mux := newMux(s, handlers.AdminWebhooksOptions{})
+		res := post(mux, "/admin/webhooks", url.Values{
+			"url": {"https://crm.example.com/webhooks"}, model.EventSubscriberConfirmed: {"on"}, model.EventSubscriberUnsubscribed: {"on"},
+		})
+		is.Equal(http.StatusCreated, res.Code)
+		is.Equal("no-store", res.Header().Get("Cache-Control"))
+		body := res.Body.String()
+		is.True(strings.Contains(body, `id="webhook-secret"`))
+		is.True(strings.Contains(body, "whsec_secret"))
+
+		is.Equal(1, len(s.endpoints))
+		is.Equal("https://crm.example.com/webhooks", s.endpoints[0].URL)
+		is.Equal([]string{model.EventSubscriberConfirmed, model.EventSubscriberUnsubscribed}, s.endpoints[0].EventTypes)
+
+		code, _, body := makeGetRequest(mux, "/admin/webhooks")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `id="webhook-1"`))
+		is.True(!strings.Contains(body, "whsec_secret"))
+	})
+
+	invalid := []url.Values{
+		{"url": {"https://crm.example.com/webhooks"}},
+		{"url": {""}, model.EventSubscriberConfirmed: {"on"}},
+		{"url": {"ftp://crm.example.com/webhooks"}, model.EventSubscriberConfirmed: {"on"}},
+		{"url": {"http://localhost:8080/webhooks"}, model.EventSubscriberConfirmed: {"on"}},
+		{"url": {"http://169.254.169.254/latest/meta-data"}, model.EventSubscriberConfirmed: {"on"}},
+	}
+	for _, values := range invalid {
+		t.Run("doesn't create an endpoint for "+values.Encode(), func(t *testing.T) {
+			is := is.New(t)
+
+			s := &webhookEndpointStoreMock{}
+			res := post(newMux(s, handlers.AdminWebhooksOptions{}), "/admin/webhooks", values)
+			is.Equal(http.StatusUnprocessableEntity, res.Code)
+			is.Equal(0, len(s.endpoints))
+		})
+	}
+
+	t.Run("creates an endpoint in a private network if allowed", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &webhookEndpointStoreMock{}
+		res := post(newMux(s, handlers.AdminWebhooksOptions{AllowPrivateNetworks: true}), "/admin/webhooks",
+			url.Values{"url": {"http://localhost:8080/webhooks"}, model.EventSubscriberConfirmed: {"on"}})
+		is.Equal(http.StatusCreated, res.Code)
+		is.Equal(1, len(s.endpoints))
+	})
+
+	t.Run("shows the endpoint with its delivery log", func(t *testing.T) {
+		is := is.New(t)
+
+		s := disabled()
+		s.deliveries = []model.WebhookDelivery{{ID: 7, EndpointID: 1, EventType: model.EventSubscriberConfirmed,
+			State: model.WebhookDeliveryStateFailed, Attempts: 2, Created: time.Now(), AttemptLog: []model.WebhookDeliveryAttempt{
+				{DeliveryID: 7, StatusCode: 503, Duration: 120 * time.Millisecond, Created: time.Now()},
+				{DeliveryID: 7, Error: "context deadline exceeded", Duration: 10 * time.Second, Created: time.Now()},
+			}}}
+		code, _, body := makeGetRequest(newMux(s, handlers.AdminWebhooksOptions{}), "/admin/webhooks/1")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `id="webhook-disabled"`))
+		is.True(strings.Contains(body, "5 deliveries in a row failed."))
+		is.True(strings.Contains(body, `id="webhook-delivery-7"`))
+		is.True(strings.Contains(body, "503 in 120ms"))
+		is.True(strings.Contains(body, "context deadline exceeded in 10s"))
+		is.True(!strings.Contains(body, "whsec_secret"))
+	})
+
+	t.Run("responds with not found for an unknown endpoint", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&webhookEndpointStoreMock{}, handlers.AdminWebhooksOptions{})
+		code, _, _ := makeGetRequest(mux, "/admin/webhooks/1")
+		is.Equal(http.StatusNotFound, code)
+		code, _, _ = makeGetRequest(mux, "/admin/webhooks/nope")
+		is.Equal(http.StatusNotFound, code)
+	})
+
+	t.Run("enables a disabled endpoint again, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := disabled()
+		mux := newMux(s, handlers.AdminWebhooksOptions{})
+		res := post(mux, "/admin/webhooks/1/enable", url.Values{})
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/webhooks/1", res.Header().Get("Location"))
+		is.True(s.endpoints[0].Enabled)
+		is.Equal("success: Enabled the endpoint again. It gets the events from now on.", flash(mux, res))
+	})
+
+	t.Run("deletes an endpoint, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := disabled()
+		mux := newMux(s, handlers.AdminWebhooksOptions{})
+		res := post(mux, "/admin/webhooks/1", url.Values{"_method": {http.MethodDelete}})
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/webhooks", res.Header().Get("Location"))
+		is.Equal(0, len(s.endpoints))
+		is.Equal("success: Deleted the endpoint. It doesn't get events anymore.", flash(mux, res))
+	})
+
+	t.Run("says so if the endpoint was deleted already", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&webhookEndpointStoreMock{}, handlers.AdminWebhooksOptions{})
+		for _, res := range []*httptest.ResponseRecorder{
+			post(mux, "/admin/webhooks/1", url.Values{"_method": {http.MethodDelete}}),
+			post(mux, "/admin/webhooks/1/enable", url.Values{}),
+		} {
+			is.Equal(http.StatusSeeOther, res.Code)
+			is.Equal("/admin/webhooks", res.Header().Get("Location"))
+			is.Equal("error: That endpoint was deleted in the meantime, so nothing was done.", flash(mux, res))
+		}
+	})
+}
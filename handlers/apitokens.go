@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/form"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type apiTokenContextKey struct{}
+
+type apiTokenAuthenticator interface {
+	AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error)
+}
+
+// APITokenAuth is middleware requiring an API token in the Authorization header, as "Bearer <token>".
+// Without a valid one, it responds with 401 Unauthorized. With one, the logger of the request from RequestLog
+// has the ID of the token, and RequireAPIScope can check its scopes.
+// Responses on its routes are always JSON, including the errors from HandleErrors, so the request is made to ask for it.
+func APITokenAuth(s apiTokenAuthenticator, log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Accept", "application/json")
+
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="canvas"`)
+				writeProblem(w, problem{Status: http.StatusUnauthorized, Detail: "An API token is required."})
+				return
+			}
+
+			t, err := s.AuthenticateAPIToken(r.Context(), strings.TrimSpace(token))
+			if err != nil {
+				respondInternalError(w, r, log, fmt.Errorf("error authenticating API token: %w", err))
+				return
+			}
+			if t == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="canvas", error="invalid_token"`)
+				writeProblem(w, problem{Status: http.StatusUnauthorized, Detail: "The API token is invalid, expired, or revoked."})
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), apiTokenContextKey{}, *t))
+			next.ServeHTTP(w, withLogFields(r, zap.Int64("apiTokenID", t.ID)))
+		})
+	}
+}
+
+// RequireAPIScope is middleware after APITokenAuth, responding with 403 Forbidden if the API token doesn't have the scope.
+func RequireAPIScope(scope model.APIScope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := apiToken(r.Context()); !ok || !t.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="canvas", error="insufficient_scope", scope="%v"`, scope))
+				writeProblem(w, problem{Status: http.StatusForbidden, Detail: fmt.Sprintf("The API token doesn't have the %v scope.", scope)})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiToken the request was authenticated with by APITokenAuth.
+func apiToken(ctx context.Context) (model.APIToken, bool) {
+	t, ok := ctx.Value(apiTokenContextKey{}).(model.APIToken)
+	return t, ok
+}
+
+type apiTokenStore interface {
+	CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time, actor string) (model.APIToken, string, error)
+	ListAPITokens(ctx context.Context) ([]model.APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error)
+}
+
+// AdminAPITokens on a router mounted at /admin, for the tokens of the admin API under /api/admin.
+// GET /api-tokens shows the tokens. POST /api-tokens creates one with the name, the scopes ticked,
+// and the expires field with the number of days it lasts, or 0 for never. The secret token is only shown
+// in the response, since only its hash is stored. DELETE /api-tokens/{id} revokes the token,
+// and sends the admin back to the list. Both are recorded in the audit log.
+func AdminAPITokens(mux chi.Router, s apiTokenStore, log *zap.Logger) {
+	list := func(r *http.Request, props views.AdminAPITokensProps) (views.AdminAPITokensProps, error) {
+		tokens, err := s.ListAPITokens(r.Context())
+		if err != nil {
+			return props, fmt.Errorf("error listing API tokens: %w", err)
+		}
+		props.CSRFToken = CSRFToken(r)
+		props.Tokens = tokens
+		return props, nil
+	}
+
+	mux.Get("/api-tokens", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		props, err := list(r, views.AdminAPITokensProps{Flashes: sessions.ConsumeFlashes(r.Context())})
+		if err != nil {
+			return err
+		}
+		return render(w, http.StatusOK, views.AdminAPITokens(props))
+	}))
+
+	mux.Post("/api-tokens", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			return fmt.Errorf("error parsing form: %w", err)
+		}
+		f.Required("name")
+		name := f.String("name")
+		days := f.Int("expires")
+		if days < 0 {
+			f.AddError("expires", "Please enter a number of days, or 0 for never.")
+		}
+		var scopes []model.APIScope
+		for _, scope := range model.APIScopes {
+			if f.Bool(string(scope)) {
+				scopes = append(scopes, scope)
+			}
+		}
+		if len(scopes) == 0 {
+			f.AddError("scopes", "Please pick at least one scope.")
+		}
+		if err := f.Err(); err != nil {
+			return err
+		}
+
+		var expiresAt *time.Time
+		if days > 0 {
+			t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &t
+		}
+		created, token, err := s.CreateAPIToken(r.Context(), name, scopes, expiresAt, storage.AuditActorAdmin)
+		if err != nil {
+			return fmt.Errorf("error creating API token: %w", err)
+		}
+		requestLog(r.Context(), log).Info("Created API token", zap.Int64("id", created.ID))
+
+		props, err := list(r, views.AdminAPITokensProps{Created: &created, Secret: token})
+		if err != nil {
+			return err
+		}
+		// The secret mustn't be kept anywhere, like in the back-forward cache.
+		w.Header().Set("Cache-Control", "no-store")
+		return render(w, http.StatusCreated, views.AdminAPITokens(props))
+	}))
+
+	mux.Delete("/api-tokens/{id}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		var revoked model.APIToken
+		if err == nil {
+			revoked, err = s.RevokeAPIToken(r.Context(), id, storage.AuditActorAdmin)
+		} else {
+			err = storage.ErrNotFound
+		}
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			_ = sessions.AddFlash(r.Context(), sessions.FlashError, "That token was revoked in the meantime, so nothing was done.")
+		case err != nil:
+			return fmt.Errorf("error revoking API token: %w", err)
+		default:
+			requestLog(r.Context(), log).Info("Revoked API token", zap.Int64("id", id))
+			_ = sessions.AddFlash(r.Context(), sessions.FlashSuccess, fmt.Sprintf("Revoked %v. It can't be used anymore.", revoked.Name))
+		}
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return nil
+	}))
+}
@@ -0,0 +1,263 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+type apiTokenStoreMock struct {
+	tokens  []model.APIToken
+	secrets map[string]int64
+	now     time.Time
+}
+
+func (s *apiTokenStoreMock) CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time,
+	actor string) (model.APIToken, string, error) {
+	if s.secrets == nil {
+		s.secrets = map[string]int64{}
+	}
+	t := model.APIToken{ID: int64(len(s.tokens) + 1), Name: name, Scopes: scopes, ExpiresAt: expiresAt, Created: time.Now()}
+	s.tokens = append([]model.APIToken{t}, s.tokens...)
+	secret := "canvas_secret" + name
+	s.secrets[secret] = t.ID
+	return t, secret, nil
+}
+
+func (s *apiTokenStoreMock) ListAPITokens(ctx context.Context) ([]model.APIToken, error) {
+	return s.tokens, nil
+}
+
+func (s *apiTokenStoreMock) RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error) {
+	for i, t := range s.tokens {
+		if t.ID == id && actor == storage.AuditActorAdmin {
+			s.tokens = append(s.tokens[:i], s.tokens[i+1:]...)
+			return t, nil
+		}
+	}
+	return model.APIToken{}, storage.ErrNotFound
+}
+
+func (s *apiTokenStoreMock) AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error) {
+	id, ok := s.secrets[token]
+	if !ok {
+		return nil, nil
+	}
+	for _, t := range s.tokens {
+		if t.ID == id && (t.ExpiresAt == nil || t.ExpiresAt.After(s.now)) {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	newMux := func(s *apiTokenStoreMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(handlers.APITokenAuth(s, zap.NewNop()))
+		mux.With(handlers.RequireAPIScope(model.APIScopeReadSubscribers)).Get("/subscribers", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		return mux
+	}
+
+	newStore := func() *apiTokenStoreMock {
+		s := &apiTokenStoreMock{now: time.Now()}
+		_, _, _ = s.CreateAPIToken(context.Background(), "reader", []model.APIScope{model.APIScopeReadSubscribers}, nil, storage.AuditActorAdmin)
+		_, _, _ = s.CreateAPIToken(context.Background(), "sender", []model.APIScope{model.APIScopeSendNewsletter}, nil, storage.AuditActorAdmin)
+		return s
+	}
+
+	get := func(mux chi.Router, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/subscribers", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("lets requests with a token with the scope through", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux(newStore()), "Bearer canvas_secretreader")
+		is.Equal(http.StatusOK, res.Code)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		code          int
+		challenge     string
+	}{
+		{"requires a token", "", http.StatusUnauthorized, `Bearer realm="canvas"`},
+		{"requires a bearer token", "Basic canvas_secretreader", http.StatusUnauthorized, `Bearer realm="canvas"`},
+		{"rejects an invalid token", "Bearer canvas_nope", http.StatusUnauthorized, `Bearer realm="canvas", error="invalid_token"`},
+		{"rejects a token without the scope", "Bearer canvas_secretsender", http.StatusForbidden,
+			`Bearer realm="canvas", error="insufficient_scope", scope="read:subscribers"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name+", with a JSON problem", func(t *testing.T) {
+			is := is.New(t)
+
+			res := get(newMux(newStore()), test.authorization)
+			is.Equal(test.code, res.Code)
+			is.Equal(test.challenge, res.Header().Get("WWW-Authenticate"))
+			is.Equal("application/problem+json", res.Header().Get("Content-Type"))
+			var p struct{ Status int }
+			is.NoErr(json.Unmarshal(res.Body.Bytes(), &p))
+			is.Equal(test.code, p.Status)
+		})
+	}
+
+	t.Run("rejects an expired token", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		expiresAt := time.Now().Add(time.Hour)
+		_, token, _ := s.CreateAPIToken(context.Background(), "temporary", []model.APIScope{model.APIScopeReadSubscribers},
+			&expiresAt, storage.AuditActorAdmin)
+		mux := newMux(s)
+		is.Equal(http.StatusOK, get(mux, "Bearer "+token).Code)
+
+		s.now = expiresAt
+		is.Equal(http.StatusUnauthorized, get(mux, "Bearer "+token).Code)
+	})
+
+	t.Run("rejects a revoked token", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newStore()
+		mux := newMux(s)
+		is.Equal(http.StatusOK, get(mux, "Bearer canvas_secretreader").Code)
+
+		_, err := s.RevokeAPIToken(context.Background(), 1, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal(http.StatusUnauthorized, get(mux, "Bearer canvas_secretreader").Code)
+	})
+}
+
+func TestAdminAPITokens(t *testing.T) {
+	newMux := func(s *apiTokenStoreMock) chi.Router {
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware, handlers.MethodOverride)
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminAPITokens(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	post := func(mux chi.Router, target string, values url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(values.Encode()))
+		req.Header = createFormHeader()
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("creates a token, showing its secret only once", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &apiTokenStoreMock{}
+		mux := newMux(s)
+		res := post(mux, "/admin/api-tokens", url.Values{
+			"name": {"Reports"}, "read:subscribers": {"on"}, "expires": {"30"},
+		})
+		is.Equal(http.StatusCreated, res.Code)
+		is.Equal("no-store", res.Header().Get("Cache-Control"))
+		body := html.UnescapeString(res.Body.String())
+		is.True(strings.Contains(body, `id="api-token-secret"`))
+		is.True(strings.Contains(body, "canvas_secretReports"))
+		is.True(strings.Contains(body, "it won't be shown again"))
+
+		is.Equal(1, len(s.tokens))
+		is.Equal([]model.APIScope{model.APIScopeReadSubscribers}, s.tokens[0].Scopes)
+		is.True(s.tokens[0].ExpiresAt != nil)
+		is.True(s.tokens[0].ExpiresAt.After(time.Now().Add(29 * 24 * time.Hour)))
+
+		code, _, body := makeGetRequest(mux, "/admin/api-tokens")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `id="api-token-1"`))
+		is.True(!strings.Contains(body, `id="api-token-secret"`))
+		is.True(!strings.Contains(body, "canvas_secretReports"))
+	})
+
+	t.Run("creates a token that never expires", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &apiTokenStoreMock{}
+		res := post(newMux(s), "/admin/api-tokens", url.Values{"name": {"Sender"}, "send:newsletter": {"on"}, "expires": {"0"}})
+		is.Equal(http.StatusCreated, res.Code)
+		is.Equal(1, len(s.tokens))
+		is.True(s.tokens[0].ExpiresAt == nil)
+	})
+
+	t.Run("requires a name and a scope", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &apiTokenStoreMock{}
+		res := post(newMux(s), "/admin/api-tokens", url.Values{"name": {""}, "expires": {"30"}})
+		is.Equal(http.StatusUnprocessableEntity, res.Code)
+		is.Equal(0, len(s.tokens))
+	})
+
+	t.Run("revokes a token, and says so", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &apiTokenStoreMock{}
+		_, _, _ = s.CreateAPIToken(context.Background(), "Reports", []model.APIScope{model.APIScopeReadSubscribers}, nil, storage.AuditActorAdmin)
+		mux := newMux(s)
+		res := post(mux, "/admin/api-tokens/1", url.Values{"_method": {http.MethodDelete}})
+		is.Equal(http.StatusSeeOther, res.Code)
+		is.Equal("/admin/api-tokens", res.Header().Get("Location"))
+		is.Equal(0, len(s.tokens))
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/api-tokens", nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		flash := flashMatcher.FindStringSubmatch(res.Body.String())
+		is.True(flash != nil)
+		is.Equal("success: Revoked Reports. It can't be used anymore.", flash[1]+": "+html.UnescapeString(flash[2]))
+	})
+
+	t.Run("says so if the token was revoked already", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&apiTokenStoreMock{})
+		res := post(mux, "/admin/api-tokens/1", url.Values{"_method": {http.MethodDelete}})
+		is.Equal(http.StatusSeeOther, res.Code)
+
+		req := httptest.NewRequest(http.MethodGet, "/admin/api-tokens", nil)
+		for _, c := range res.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		flash := flashMatcher.FindStringSubmatch(res.Body.String())
+		is.True(flash != nil)
+		is.Equal("error", flash[1])
+	})
+}
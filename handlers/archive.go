@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/storage"
+	"canvas/views"
+)
+
+type archiveStore interface {
+	publishedNewsletterLister
+	GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error)
+}
+
+// archivePageSize is how many issues are shown per archive page.
+const archivePageSize = 10
+
+// Archive of published newsletter issues, at /archive with pages in the page query parameter,
+// and each issue at /archive/{slug}. Drafts and issues set to be published in the future are not found,
+// also when linked to by slug, and so are pages after the last one.
+// The baseURL is for the canonical URLs of the issues, like "https://example.com", in their meta for link previews.
+func Archive(mux chi.Router, s archiveStore, log *zap.Logger, baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	mux.Get("/archive", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		page := 1
+		if v := r.URL.Query().Get("page"); v != "" {
+			var err error
+			if page, err = strconv.Atoi(v); err != nil || page < 1 {
+				return fmt.Errorf("invalid archive page %q: %w", v, storage.ErrNotFound)
+			}
+		}
+
+		// The extra issue tells whether there's a next page.
+		newsletters, err := s.ListPublishedNewsletters(r.Context(), storage.ListPublishedNewslettersOptions{
+			Limit:  archivePageSize + 1,
+			Offset: (page - 1) * archivePageSize,
+		})
+		if err != nil {
+			return fmt.Errorf("error listing published newsletters: %w", err)
+		}
+		if len(newsletters) == 0 && page > 1 {
+			return fmt.Errorf("archive page %v after the last one: %w", page, storage.ErrNotFound)
+		}
+
+		pageURL := func(page int) string {
+			if page == 1 {
+				return "/archive"
+			}
+			return "/archive?page=" + strconv.Itoa(page)
+		}
+
+		props := views.ArchivePageProps{Newsletters: newsletters}
+		if page > 1 {
+			props.PreviousURL = pageURL(page - 1)
+		}
+		if len(newsletters) > archivePageSize {
+			props.Newsletters = newsletters[:archivePageSize]
+			props.NextURL = pageURL(page + 1)
+		}
+		return render(w, http.StatusOK, views.ArchivePage(props))
+	}))
+
+	mux.Get("/archive/{slug}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		n, err := s.GetPublishedNewsletter(r.Context(), chi.URLParam(r, "slug"))
+		if err != nil {
+			return fmt.Errorf("error getting published newsletter: %w", err)
+		}
+		return render(w, http.StatusOK, views.ArchiveIssuePage(*n, views.PageMetaProps{
+			CanonicalURL:  archiveURL(baseURL, *n),
+			Description:   n.Excerpt(200),
+			PublishedTime: *n.PublishedAt,
+			Title:         n.Title,
+		}))
+	}))
+}
@@ -0,0 +1,176 @@
+package handlers_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// archiveStoreMock has published newsletters, newest first, and drafts that must never be shown.
+type archiveStoreMock struct {
+	published []model.Newsletter
+	drafts    []model.Newsletter
+}
+
+func (s *archiveStoreMock) ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	newsletters := s.published
+	if opts.Offset >= len(newsletters) {
+		return nil, nil
+	}
+	newsletters = newsletters[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(newsletters) {
+		newsletters = newsletters[:opts.Limit]
+	}
+	return newsletters, nil
+}
+
+func (s *archiveStoreMock) GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error) {
+	for _, n := range s.published {
+		if n.Slug == slug {
+			return &n, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func newArchiveStoreMock(count int) *archiveStoreMock {
+	s := &archiveStoreMock{}
+	published := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	for i := count; i > 0; i-- {
+		publishedAt := published.Add(time.Duration(i) * time.Hour)
+		s.published = append(s.published, model.Newsletter{
+			ID:          int64(i),
+			Title:       fmt.Sprintf("Issue %v", i),
+			Slug:        fmt.Sprintf("issue-%v", i),
+			Body:        fmt.Sprintf("This is issue %v.\n\nBye.", i),
+			PublishedAt: &publishedAt,
+		})
+	}
+	s.drafts = []model.Newsletter{{ID: 100, Title: "Secret draft", Slug: "secret-draft", Body: "Not yet."}}
+	return s
+}
+
+func TestArchive(t *testing.T) {
+	newMux := func(s *archiveStoreMock) chi.Router {
+		mux := chi.NewMux()
+		handlers.Archive(mux, s, nil, "https://example.com/")
+		return mux
+	}
+
+	t.Run("lists published issues on the first page with a link to the next", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makeGetRequest(newMux(newArchiveStoreMock(11)), "/archive")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `href="/archive/issue-11"`))
+		is.True(strings.Contains(body, `href="/archive/issue-2"`))
+		is.True(!strings.Contains(body, `href="/archive/issue-1"`))
+		is.True(strings.Contains(body, `<time datetime="2022-12-10T23:00:00Z">December 10, 2022</time>`))
+		is.True(strings.Contains(body, `This is issue 11.`))
+		is.True(strings.Contains(body, `href="/archive?page=2" rel="next"`))
+		is.True(!strings.Contains(body, `rel="prev"`))
+		is.True(!strings.Contains(body, "Secret draft"))
+	})
+
+	t.Run("shows the last page with a link to the previous", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makeGetRequest(newMux(newArchiveStoreMock(11)), "/archive?page=2")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `href="/archive/issue-1"`))
+		is.True(strings.Contains(body, `href="/archive" rel="prev"`))
+		is.True(!strings.Contains(body, `rel="next"`))
+	})
+
+	t.Run("has no next link when the last page is full", func(t *testing.T) {
+		is := is.New(t)
+
+		_, _, body := makeGetRequest(newMux(newArchiveStoreMock(10)), "/archive")
+		is.True(strings.Contains(body, `href="/archive/issue-1"`))
+		is.True(!strings.Contains(body, `rel="next"`))
+	})
+
+	t.Run("shows an empty first page without issues", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makeGetRequest(newMux(newArchiveStoreMock(0)), "/archive")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "No issues here yet."))
+	})
+
+	t.Run("responds with not found for pages after the last one and invalid pages", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newArchiveStoreMock(10))
+		for _, target := range []string{"/archive?page=2", "/archive?page=0", "/archive?page=-1", "/archive?page=abc"} {
+			code, _, _ := makeGetRequest(mux, target)
+			is.Equal(http.StatusNotFound, code)
+		}
+	})
+
+	t.Run("shows a published issue with canonical and OpenGraph meta", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makeGetRequest(newMux(newArchiveStoreMock(2)), "/archive/issue-2")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `<title>Issue 2</title>`))
+		is.True(strings.Contains(body, `<h1>Issue 2</h1>`))
+		is.True(strings.Contains(body, "<p>This is issue 2.</p>\n<p>Bye.</p>"))
+		is.True(strings.Contains(body, `<link rel="canonical" href="https://example.com/archive/issue-2">`))
+		is.True(strings.Contains(body, `<meta property="og:title" content="Issue 2">`))
+		is.True(strings.Contains(body, `<meta property="og:type" content="article">`))
+		is.True(strings.Contains(body, `<meta property="og:url" content="https://example.com/archive/issue-2">`))
+		is.True(strings.Contains(body, `<meta property="og:description" content="This is issue 2.">`))
+		is.True(strings.Contains(body, `<meta property="article:published_time" content="2022-12-10T14:00:00Z">`))
+		is.True(strings.Contains(body, `<meta name="twitter:card" content="summary">`))
+	})
+
+	t.Run("escapes titles and bodies", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newArchiveStoreMock(1)
+		s.published[0].Title = `<script>alert("title")</script>`
+		s.published[0].Body = "<script>alert(\"body\")</script>\n\n[click](javascript:alert(1)) <img src=x onerror=alert(1)>"
+		mux := newMux(s)
+
+		for _, target := range []string{"/archive", "/archive/issue-1"} {
+			_, _, body := makeGetRequest(mux, target)
+			is.True(!strings.Contains(body, "<script>alert"))
+			is.True(!strings.Contains(body, "javascript:"))
+			is.True(!regexp.MustCompile(`<[^>]*\son\w+=`).MatchString(body))
+			is.True(strings.Contains(body, "&lt;script&gt;alert(&#34;title&#34;)&lt;/script&gt;"))
+		}
+	})
+
+	t.Run("escapes titles in the meta, with an absolute canonical URL from the base URL", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newArchiveStoreMock(1)
+		s.published[0].Title = `"><img src=x onerror=alert(1)> & 'quotes'`
+		_, _, body := makeGetRequest(newMux(s), "/archive/issue-1")
+		is.True(strings.Contains(body, `<meta property="og:title" content="&#34;&gt;&lt;img src=x onerror=alert(1)&gt; &amp; &#39;quotes&#39;">`))
+		is.True(strings.Contains(body, `<link rel="canonical" href="https://example.com/archive/issue-1">`))
+	})
+
+	t.Run("responds with not found for drafts and unknown slugs", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(newArchiveStoreMock(1))
+		for _, target := range []string{"/archive/secret-draft", "/archive/doesnotexist"} {
+			code, _, body := makeGetRequest(mux, target)
+			is.Equal(http.StatusNotFound, code)
+			is.True(!strings.Contains(body, "Not yet."))
+		}
+	})
+}
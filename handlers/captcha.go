@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"canvas/views"
+)
+
+// ErrCaptchaFailed is returned by CaptchaVerifier.Verify for responses that aren't valid, like a missing or expired one.
+var ErrCaptchaFailed = errors.New("captcha failed")
+
+// CaptchaVerifier checks the response of a captcha widget on the server.
+type CaptchaVerifier interface {
+	// Verify the response token from the widget, submitted from remoteIP.
+	// Returns ErrCaptchaFailed if the response isn't valid, and other errors if it couldn't be checked,
+	// for example because the captcha service is down.
+	Verify(ctx context.Context, response, remoteIP string) error
+	// Widget to render in the form the response is submitted from.
+	Widget() views.Captcha
+}
+
+// CaptchaSiteVerifierOptions for NewHCaptchaVerifier and NewTurnstileVerifier.
+type CaptchaSiteVerifierOptions struct {
+	// Client for calling the verification API. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Secret key of the site, for the verification API.
+	Secret string
+	// SiteKey is the public key of the site, for the widget.
+	SiteKey string
+	// Timeout for verifying a response. Defaults to 5 seconds.
+	Timeout time.Duration
+	// VerifyURL of the verification API. Defaults to the one of the provider, and is overridden in tests.
+	VerifyURL string
+}
+
+// CaptchaSiteVerifier is a CaptchaVerifier for providers with a siteverify API, like hCaptcha and Cloudflare Turnstile.
+type CaptchaSiteVerifier struct {
+	opts     CaptchaSiteVerifierOptions
+	provider views.CaptchaProvider
+}
+
+// NewHCaptchaVerifier for hCaptcha, see https://docs.hcaptcha.com/#verify-the-user-response-server-side
+func NewHCaptchaVerifier(opts CaptchaSiteVerifierOptions) *CaptchaSiteVerifier {
+	if opts.VerifyURL == "" {
+		opts.VerifyURL = "https://api.hcaptcha.com/siteverify"
+	}
+	return newCaptchaSiteVerifier(views.CaptchaProviderHCaptcha, opts)
+}
+
+// NewTurnstileVerifier for Cloudflare Turnstile, see https://developers.cloudflare.com/turnstile/get-started/server-side-validation/
+func NewTurnstileVerifier(opts CaptchaSiteVerifierOptions) *CaptchaSiteVerifier {
+	if opts.VerifyURL == "" {
+		opts.VerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	}
+	return newCaptchaSiteVerifier(views.CaptchaProviderTurnstile, opts)
+}
+
+func newCaptchaSiteVerifier(provider views.CaptchaProvider, opts CaptchaSiteVerifierOptions) *CaptchaSiteVerifier {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	return &CaptchaSiteVerifier{opts: opts, provider: provider}
+}
+
+// captchaConfigErrorCodes are the error codes for problems with the setup instead of the response,
+// which are errors and not failures, so they're not blamed on the person signing up.
+var captchaConfigErrorCodes = map[string]bool{
+	"missing-input-secret":    true,
+	"invalid-input-secret":    true,
+	"sitekey-secret-mismatch": true,
+	"internal-error":          true,
+}
+
+type captchaSiteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify satisfies CaptchaVerifier.
+func (v *CaptchaSiteVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return fmt.Errorf("%w: no response", ErrCaptchaFailed)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.opts.Timeout)
+	defer cancel()
+
+	values := url.Values{"secret": {v.opts.Secret}, "response": {response}}
+	if remoteIP != "" {
+		values.Set("remoteip", remoteIP)
+	}
+	if v.provider == views.CaptchaProviderHCaptcha {
+		values.Set("sitekey", v.opts.SiteKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.opts.VerifyURL, strings.NewReader(values.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := v.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %v verification API: %w", v.provider, err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("error calling %v verification API: status %v", v.provider, res.StatusCode)
+	}
+
+	var body captchaSiteVerifyResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 64*1024)).Decode(&body); err != nil {
+		return fmt.Errorf("error decoding %v verification response: %w", v.provider, err)
+	}
+	if body.Success {
+		return nil
+	}
+	for _, code := range body.ErrorCodes {
+		if captchaConfigErrorCodes[code] {
+			return fmt.Errorf("error verifying %v response: %v", v.provider, strings.Join(body.ErrorCodes, ", "))
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrCaptchaFailed, strings.Join(body.ErrorCodes, ", "))
+}
+
+// Widget satisfies CaptchaVerifier.
+func (v *CaptchaSiteVerifier) Widget() views.Captcha {
+	return views.Captcha{Provider: v.provider, SiteKey: v.opts.SiteKey}
+}
+
+// captchaWidget of the verifier, or nil without one.
+func captchaWidget(v CaptchaVerifier) *views.Captcha {
+	if v == nil {
+		return nil
+	}
+	c := v.Widget()
+	return &c
+}
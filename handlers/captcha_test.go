@@ -0,0 +1,256 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/form"
+	"canvas/handlers"
+)
+
+// newCaptchaServer is a fake siteverify API, which accepts the response "valid" and takes the delay to answer.
+func newCaptchaServer(t *testing.T, delay time.Duration) (*httptest.Server, *url.Values) {
+	t.Helper()
+
+	var received url.Values
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		received = r.PostForm
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("secret") != "secret" {
+			_, _ = fmt.Fprint(w, `{"success": false, "error-codes": ["invalid-input-secret"]}`)
+			return
+		}
+		if r.PostForm.Get("response") != "valid" {
+			_, _ = fmt.Fprint(w, `{"success": false, "error-codes": ["invalid-input-response"]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"success": true}`)
+	}))
+	t.Cleanup(s.Close)
+	return s, &received
+}
+
+func TestCaptchaSiteVerifier_Verify(t *testing.T) {
+	t.Run("passes a valid response and sends the secret, response, and remote IP", func(t *testing.T) {
+		is := is.New(t)
+
+		s, received := newCaptchaServer(t, 0)
+		v := handlers.NewTurnstileVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site", VerifyURL: s.URL})
+		err := v.Verify(context.Background(), "valid", "192.0.2.1")
+		is.NoErr(err)
+		is.Equal("secret", received.Get("secret"))
+		is.Equal("valid", received.Get("response"))
+		is.Equal("192.0.2.1", received.Get("remoteip"))
+	})
+
+	t.Run("sends the site key to hCaptcha", func(t *testing.T) {
+		is := is.New(t)
+
+		s, received := newCaptchaServer(t, 0)
+		v := handlers.NewHCaptchaVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site", VerifyURL: s.URL})
+		err := v.Verify(context.Background(), "valid", "192.0.2.1")
+		is.NoErr(err)
+		is.Equal("site", received.Get("sitekey"))
+	})
+
+	t.Run("fails an invalid or missing response", func(t *testing.T) {
+		is := is.New(t)
+
+		s, _ := newCaptchaServer(t, 0)
+		v := handlers.NewHCaptchaVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site", VerifyURL: s.URL})
+		err := v.Verify(context.Background(), "invalid", "192.0.2.1")
+		is.True(errors.Is(err, handlers.ErrCaptchaFailed))
+		err = v.Verify(context.Background(), "", "192.0.2.1")
+		is.True(errors.Is(err, handlers.ErrCaptchaFailed))
+	})
+
+	t.Run("errors instead of failing for a bad secret", func(t *testing.T) {
+		is := is.New(t)
+
+		s, _ := newCaptchaServer(t, 0)
+		v := handlers.NewHCaptchaVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "nope", SiteKey: "site", VerifyURL: s.URL})
+		err := v.Verify(context.Background(), "valid", "192.0.2.1")
+		is.True(err != nil)
+		is.True(!errors.Is(err, handlers.ErrCaptchaFailed))
+	})
+
+	t.Run("errors instead of failing if the service is too slow", func(t *testing.T) {
+		is := is.New(t)
+
+		s, _ := newCaptchaServer(t, time.Second)
+		v := handlers.NewTurnstileVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site",
+			Timeout: 10 * time.Millisecond, VerifyURL: s.URL})
+		err := v.Verify(context.Background(), "valid", "192.0.2.1")
+		is.True(err != nil)
+		is.True(!errors.Is(err, handlers.ErrCaptchaFailed))
+	})
+}
+
+func TestNewsletterSignup_Captcha(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	timestamp := "&rendered_at=" + url.QueryEscape(form.CreateTimestamp(secret, now.Add(-5*time.Second)))
+
+	signup := func(t *testing.T, delay time.Duration, failOpen bool, response string) (int, string, *signupperMock) {
+		t.Helper()
+
+		s, _ := newCaptchaServer(t, delay)
+		captcha := handlers.NewHCaptchaVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site",
+			Timeout: 10 * time.Millisecond, VerifyURL: s.URL})
+
+		mux := chi.NewMux()
+		signupper := &signupperMock{}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(signupper, zap.NewNop(), handlers.SignupServiceOptions{
+			Captcha:         captcha,
+			CaptchaFailOpen: failOpen,
+			FormSecret:      secret,
+			Now:             func() time.Time { return now },
+		}))
+
+		code, _, body := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com&h-captcha-response="+response+timestamp))
+		return code, body, signupper
+	}
+
+	for _, failOpen := range []bool{false, true} {
+		t.Run(fmt.Sprintf("signs up with a valid captcha, fail open %v", failOpen), func(t *testing.T) {
+			is := is.New(t)
+
+			code, _, s := signup(t, 0, failOpen, "valid")
+			is.Equal(http.StatusFound, code)
+			is.Equal(1, len(s.queued))
+		})
+
+		t.Run(fmt.Sprintf("re-renders the form with an error for an invalid captcha, fail open %v", failOpen), func(t *testing.T) {
+			is := is.New(t)
+
+			code, body, s := signup(t, 0, failOpen, "invalid")
+			is.Equal(http.StatusBadRequest, code)
+			is.True(strings.Contains(body, "Please complete the challenge to sign up."))
+			is.True(strings.Contains(body, `class="h-captcha" data-sitekey="site"`))
+			is.True(strings.Contains(body, `value="me@example.com"`))
+			is.Equal(0, len(s.queued))
+		})
+	}
+
+	t.Run("asks to try again if the captcha service times out, failing closed", func(t *testing.T) {
+		is := is.New(t)
+
+		code, body, s := signup(t, time.Second, false, "valid")
+		is.Equal(http.StatusServiceUnavailable, code)
+		is.True(strings.Contains(body, "We couldn&#39;t check the challenge right now."))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("signs up if the captcha service times out, failing open", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, s := signup(t, time.Second, true, "valid")
+		is.Equal(http.StatusFound, code)
+		is.Equal(1, len(s.queued))
+	})
+}
+
+func TestNewsletterSignupAPI_Captcha(t *testing.T) {
+	signup := func(t *testing.T, delay time.Duration, failOpen bool, body string) (int, string, *signupperMock) {
+		t.Helper()
+
+		s, _ := newCaptchaServer(t, delay)
+		captcha := handlers.NewHCaptchaVerifier(handlers.CaptchaSiteVerifierOptions{Secret: "secret", SiteKey: "site",
+			Timeout: 10 * time.Millisecond, VerifyURL: s.URL})
+
+		mux := chi.NewMux()
+		signupper := &signupperMock{}
+		mux.Route("/api", func(r chi.Router) {
+			handlers.NewsletterSignupAPI(r, handlers.NewSignupService(signupper, zap.NewNop(), handlers.SignupServiceOptions{
+				Captcha:         captcha,
+				CaptchaFailOpen: failOpen,
+			}))
+		})
+
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		code, _, res := makePostRequest(mux, "/api/newsletter/signup", header, strings.NewReader(body))
+		return code, res, signupper
+	}
+
+	t.Run("signs up with a valid captcha", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, s := signup(t, 0, false, `{"email": "me@example.com", "captcha": "valid"}`)
+		is.Equal(http.StatusCreated, code)
+		is.Equal(1, len(s.queued))
+	})
+
+	for _, body := range []string{`{"email": "me@example.com", "captcha": "invalid"}`, `{"email": "me@example.com"}`} {
+		t.Run("responds with the error for the captcha field, for "+body, func(t *testing.T) {
+			is := is.New(t)
+
+			code, res, s := signup(t, 0, true, body)
+			is.Equal(http.StatusUnprocessableEntity, code)
+			is.Equal(`{"errors":{"captcha":"Please complete the challenge to sign up."}}`+"\n", res)
+			is.Equal(0, len(s.queued))
+		})
+	}
+
+	t.Run("responds with a 503 if the captcha service times out, failing closed", func(t *testing.T) {
+		is := is.New(t)
+
+		code, res, s := signup(t, time.Second, false, `{"email": "me@example.com", "captcha": "valid"}`)
+		is.Equal(http.StatusServiceUnavailable, code)
+		is.True(strings.Contains(res, "We couldn't check the challenge right now."))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("signs up if the captcha service times out, failing open", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, s := signup(t, time.Second, true, `{"email": "me@example.com", "captcha": "valid"}`)
+		is.Equal(http.StatusCreated, code)
+		is.Equal(1, len(s.queued))
+	})
+}
+
+func TestFrontPage_Captcha(t *testing.T) {
+	t.Run("renders the captcha widget", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.FrontPage(mux, nil, []byte("secret"), "https://example.com",
+			handlers.NewTurnstileVerifier(handlers.CaptchaSiteVerifierOptions{SiteKey: "site"}))
+
+		_, _, body := makeGetRequest(mux, "/")
+		is.True(strings.Contains(body, `<script src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer>`))
+		is.True(strings.Contains(body, `class="cf-turnstile" data-sitekey="site"`))
+	})
+
+	t.Run("doesn't render a captcha widget without a verifier", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.FrontPage(mux, nil, []byte("secret"), "https://example.com", nil)
+
+		_, _, body := makeGetRequest(mux, "/")
+		is.True(!strings.Contains(body, "captcha"))
+		is.True(!strings.Contains(body, "turnstile"))
+	})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPContextKey struct{}
+
+// ClientIPOptions for ClientIP.
+type ClientIPOptions struct {
+	// TrustedProxies are the networks of the load balancers and reverse proxies in front of the app.
+	// Without them, X-Forwarded-For is never used, and the client IP address is the peer's.
+	TrustedProxies []*net.IPNet
+}
+
+// ClientIP is middleware finding the client IP address of the request, for rate limits, throttling, and logs.
+// It's the peer address, unless the peer is a trusted proxy. Then it's the last address in X-Forwarded-For
+// that isn't a trusted proxy too, because each proxy appends the address it got the request from,
+// and anything before the first trusted proxy could have been sent by the client.
+func ClientIP(opts ClientIPOptions) func(next http.Handler) http.Handler {
+	trusted := func(ip net.IP) bool {
+		for _, network := range opts.TrustedProxies {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := peerIP(r)
+			if parsed := net.ParseIP(ip); parsed != nil && trusted(parsed) {
+				ip = forwardedFor(r, ip, trusted)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPContextKey{}, ip)))
+		})
+	}
+}
+
+// forwardedFor is the last address in the X-Forwarded-For headers of the request that isn't trusted,
+// walking back from the peer. If an address can't be parsed, it's the trusted one after it.
+func forwardedFor(r *http.Request, peer string, trusted func(net.IP) bool) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	ip := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		parsed := net.ParseIP(strings.TrimSpace(hops[i]))
+		if parsed == nil {
+			return ip
+		}
+		ip = parsed.String()
+		if !trusted(parsed) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// clientIP address of the request, from the ClientIP middleware, or the peer address without it.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP address of the request, which is the client's, or that of the proxy in front of the app.
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
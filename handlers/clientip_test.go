@@ -0,0 +1,80 @@
+package handlers_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newHandler limiting each client IP address to one request.
+	newHandler := func() http.Handler {
+		h := handlers.RateLimit(0.001, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		return handlers.ClientIP(handlers.ClientIPOptions{TrustedProxies: []*net.IPNet{proxies}})(h)
+	}
+
+	request := func(h http.Handler, remoteAddr string, forwardedFor ...string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for _, v := range forwardedFor {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("uses X-Forwarded-For from a trusted proxy", func(t *testing.T) {
+		is := is.New(t)
+
+		h := newHandler()
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "192.0.2.1"))
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "192.0.2.2"))
+		is.Equal(http.StatusTooManyRequests, request(h, "10.0.0.2:1234", "192.0.2.1"))
+	})
+
+	t.Run("ignores X-Forwarded-For from other peers", func(t *testing.T) {
+		is := is.New(t)
+
+		h := newHandler()
+		is.Equal(http.StatusOK, request(h, "192.0.2.1:1234", "198.51.100.1"))
+		is.Equal(http.StatusTooManyRequests, request(h, "192.0.2.1:1234", "198.51.100.2"))
+		is.Equal(http.StatusOK, request(h, "192.0.2.2:1234", "198.51.100.1"))
+	})
+
+	t.Run("uses the last address that isn't a trusted proxy, so clients can't pick their own", func(t *testing.T) {
+		is := is.New(t)
+
+		h := newHandler()
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "198.51.100.1, 192.0.2.1, 10.0.0.3"))
+		is.Equal(http.StatusTooManyRequests, request(h, "10.0.0.1:1234", "198.51.100.2, 192.0.2.1, 10.0.0.3"))
+		is.Equal(http.StatusTooManyRequests, request(h, "10.0.0.1:1234", "198.51.100.3", "192.0.2.1"))
+	})
+
+	t.Run("uses the trusted address after one that can't be parsed", func(t *testing.T) {
+		is := is.New(t)
+
+		h := newHandler()
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "192.0.2.1, unknown, 10.0.0.3"))
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "192.0.2.2, unknown, 10.0.0.4"))
+		is.Equal(http.StatusTooManyRequests, request(h, "10.0.0.1:1234", "192.0.2.3, unknown, 10.0.0.3"))
+	})
+
+	t.Run("uses the peer address without trusted proxies", func(t *testing.T) {
+		is := is.New(t)
+
+		h := handlers.ClientIP(handlers.ClientIPOptions{})(handlers.RateLimit(0.001, 1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		is.Equal(http.StatusOK, request(h, "10.0.0.1:1234", "192.0.2.1"))
+		is.Equal(http.StatusTooManyRequests, request(h, "10.0.0.1:1234", "192.0.2.2"))
+	})
+}
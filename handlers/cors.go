@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSOptions for CORS.
+type CORSOptions struct {
+	// AllowedOrigins can call the routes cross-origin. "*" allows any origin.
+	AllowedOrigins []string
+	// PathPrefix of the routes CORS applies to, such as "/api/". Empty applies to all routes,
+	// for when the middleware is only used on a group of routes that are all for other origins.
+	PathPrefix string
+}
+
+// CORS is middleware allowing cross-origin requests from the allowed origins to routes under the path prefix,
+// and answering CORS preflight requests for them. Credentials such as cookies are never allowed.
+func CORS(opts CORSOptions) func(next http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, origin := range opts.AllowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !strings.HasPrefix(r.URL.Path, opts.PathPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !allowed["*"] && !allowed[origin] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "3600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
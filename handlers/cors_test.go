@@ -0,0 +1,58 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestCORS(t *testing.T) {
+	mux := chi.NewMux()
+	mux.Use(handlers.CORS(handlers.CORSOptions{AllowedOrigins: []string{"https://widget.example.com"}, PathPrefix: "/api/"}))
+	mux.Post("/api/things", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Post("/things", func(w http.ResponseWriter, r *http.Request) {})
+
+	request := func(method, path, origin string) *http.Response {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Result()
+	}
+
+	t.Run("allows requests from an allowed origin, without credentials", func(t *testing.T) {
+		is := is.New(t)
+
+		res := request(http.MethodPost, "/api/things", "https://widget.example.com")
+		is.Equal(http.StatusOK, res.StatusCode)
+		is.Equal("https://widget.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+		is.Equal("", res.Header.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("answers preflight requests from an allowed origin", func(t *testing.T) {
+		is := is.New(t)
+
+		res := request(http.MethodOptions, "/api/things", "https://widget.example.com")
+		is.Equal(http.StatusNoContent, res.StatusCode)
+		is.Equal("https://widget.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+		is.Equal("Content-Type", res.Header.Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("doesn't allow other origins, or routes outside the prefix", func(t *testing.T) {
+		is := is.New(t)
+
+		res := request(http.MethodPost, "/api/things", "https://evil.example.com")
+		is.Equal("", res.Header.Get("Access-Control-Allow-Origin"))
+
+		res = request(http.MethodPost, "/things", "https://widget.example.com")
+		is.Equal("", res.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"canvas/views"
+)
+
+const (
+	// CSRFCookieName is the cookie holding the CSRF token.
+	CSRFCookieName = "csrf"
+	// CSRFHeaderName is the request header for the CSRF token, for requests not sent from forms.
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+type csrfContextKey struct{}
+
+// CSRFOptions for CSRF.
+type CSRFOptions struct {
+	// Exempt paths don't get CSRF protection. A path ending in a slash exempts everything under it.
+	// Only exempt routes that browsers can't be tricked into sending with cookies,
+	// such as routes authenticated with header tokens, or called by mail providers.
+	Exempt []string
+	// Log for errors rendering the forbidden page. It's optional.
+	Log *zap.Logger
+}
+
+// CSRF is middleware protecting state-changing requests from cross-site request forgery,
+// with the double-submit cookie pattern: the token in the CSRF cookie must also be in the request,
+// either as the views.CSRFFieldName form field or in the CSRFHeaderName header.
+// Requests without a valid cookie get a new token. Use CSRFToken to get it for views.CSRFInput.
+// Requests with a missing or wrong token get a 403 Forbidden.
+// In multipart forms, the token field has to be in front of any file, because the form isn't parsed,
+// so handlers can stream the files.
+func CSRF(opts CSRFOptions) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isCSRFExempt(r.URL.Path, opts.Exempt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := ""
+			if c, err := r.Cookie(CSRFCookieName); err == nil && isValidCSRFToken(c.Value) {
+				token = c.Value
+			}
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			default:
+				submitted := r.Header.Get(CSRFHeaderName)
+				switch {
+				case submitted != "":
+				case isMultipart(r):
+					submitted = peekMultipartField(r, views.CSRFFieldName)
+				default:
+					submitted = r.PostFormValue(views.CSRFFieldName)
+				}
+				if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) != 1 {
+					HandleErrors(opts.Log, func(w http.ResponseWriter, r *http.Request) error {
+						return render(w, http.StatusForbidden, views.ForbiddenPage(r.URL.Path))
+					})(w, r)
+					return
+				}
+			}
+
+			if token == "" {
+				var err error
+				if token, err = setCSRFCookie(w); err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token)))
+		})
+	}
+}
+
+// CSRFToken for the request, set by CSRF. Pass it to views.CSRFInput in forms.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// RotateCSRFToken sets a new CSRF cookie and returns the new token.
+// Call it on login, so a token planted before login can't be used after it.
+func RotateCSRFToken(w http.ResponseWriter) (string, error) {
+	return setCSRFCookie(w)
+}
+
+func setCSRFCookie(w http.ResponseWriter) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+func isValidCSRFToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+func isCSRFExempt(path string, exempt []string) bool {
+	for _, e := range exempt {
+		if path == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(path, e)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestCSRF(t *testing.T) {
+	newMux := func() chi.Router {
+		mux := chi.NewMux()
+		mux.Use(handlers.CSRF(handlers.CSRFOptions{Exempt: []string{"/api/", "/newsletter/unsubscribe/one-click"}}))
+		for _, path := range []string{"/", "/form", "/api/things", "/newsletter/unsubscribe/one-click", "/newsletter/unsubscribe"} {
+			mux.Get(path, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(handlers.CSRFToken(r)))
+			})
+			mux.Post(path, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("ok"))
+			})
+		}
+		return mux
+	}
+
+	// getToken from a GET request, returning the CSRF cookie and the token for forms.
+	getToken := func(mux chi.Router) (*http.Cookie, string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		cookies := res.Result().Cookies()
+		if len(cookies) != 1 {
+			panic("expected one cookie")
+		}
+		return cookies[0], res.Body.String()
+	}
+
+	post := func(mux chi.Router, path string, cookie *http.Cookie, body url.Values) (int, string) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code, res.Body.String()
+	}
+
+	t.Run("sets a Secure, HttpOnly, SameSite=Lax cookie with the token on GET", func(t *testing.T) {
+		is := is.New(t)
+
+		cookie, token := getToken(newMux())
+		is.Equal(handlers.CSRFCookieName, cookie.Name)
+		is.Equal(64, len(token))
+		is.Equal(token, cookie.Value)
+		is.True(cookie.Secure)
+		is.True(cookie.HttpOnly)
+		is.Equal(http.SameSiteLaxMode, cookie.SameSite)
+	})
+
+	t.Run("accepts a POST with the token from the cookie in the form", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		cookie, token := getToken(mux)
+		code, body := post(mux, "/form", cookie, url.Values{"csrf_token": {token}})
+		is.Equal(http.StatusOK, code)
+		is.Equal("ok", body)
+	})
+
+	t.Run("accepts a POST with the token from the cookie in the header", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		cookie, token := getToken(mux)
+		req := httptest.NewRequest(http.MethodPost, "/form", nil)
+		req.Header.Set(handlers.CSRFHeaderName, token)
+		req.AddCookie(cookie)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusOK, res.Code)
+	})
+
+	t.Run("accepts a multipart form with the token in front of the file, which the handler can still stream", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		mux.Post("/upload", func(w http.ResponseWriter, r *http.Request) {
+			mr, err := r.MultipartReader()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for {
+				p, err := mr.NextPart()
+				if err != nil {
+					return
+				}
+				if p.FileName() != "" {
+					_, _ = io.Copy(w, p)
+				}
+			}
+		})
+		cookie, token := getToken(mux)
+
+		upload := func(fields ...string) (int, string) {
+			var body strings.Builder
+			for i := 0; i < len(fields); i += 2 {
+				body.WriteString("--b\r\nContent-Disposition: form-data; name=\"" + fields[i] + "\"")
+				if fields[i] == "file" {
+					body.WriteString("; filename=\"subscribers.csv\"")
+				}
+				body.WriteString("\r\n\r\n" + fields[i+1] + "\r\n")
+			}
+			body.WriteString("--b--\r\n")
+			req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body.String()))
+			req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+			req.AddCookie(cookie)
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+			return res.Code, res.Body.String()
+		}
+
+		file := "email\r\nme@example.com"
+		code, body := upload("csrf_token", token, "file", file)
+		is.Equal(http.StatusOK, code)
+		is.Equal(file, body)
+
+		code, _ = upload("file", file, "csrf_token", token)
+		is.Equal(http.StatusForbidden, code)
+	})
+
+	t.Run("rejects a POST with a missing token with a rendered 403", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		cookie, _ := getToken(mux)
+		code, body := post(mux, "/form", cookie, url.Values{})
+		is.Equal(http.StatusForbidden, code)
+		is.True(strings.Contains(body, "This form has expired"))
+	})
+
+	t.Run("rejects a POST with a wrong token", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		cookie, _ := getToken(mux)
+		_, otherToken := getToken(mux)
+		code, _ := post(mux, "/form", cookie, url.Values{"csrf_token": {otherToken}})
+		is.Equal(http.StatusForbidden, code)
+	})
+
+	t.Run("rejects a POST without the cookie, even with a token", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux()
+		_, token := getToken(mux)
+		code, _ := post(mux, "/form", nil, url.Values{"csrf_token": {token}})
+		is.Equal(http.StatusForbidden, code)
+	})
+
+	t.Run("doesn't set the cookie on exempt paths", func(t *testing.T) {
+		is := is.New(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/things", nil)
+		res := httptest.NewRecorder()
+		newMux().ServeHTTP(res, req)
+		is.Equal(0, len(res.Result().Cookies()))
+	})
+
+	t.Run("doesn't protect the exempt paths, and only those", func(t *testing.T) {
+		tests := []struct {
+			path string
+			code int
+		}{
+			{"/api/things", http.StatusOK},
+			{"/newsletter/unsubscribe/one-click", http.StatusOK},
+			{"/newsletter/unsubscribe", http.StatusForbidden},
+			{"/form", http.StatusForbidden},
+		}
+		for _, test := range tests {
+			t.Run(test.path, func(t *testing.T) {
+				is := is.New(t)
+
+				code, _ := post(newMux(), test.path, nil, url.Values{})
+				is.Equal(test.code, code)
+			})
+		}
+	})
+}
+
+func TestRotateCSRFToken(t *testing.T) {
+	t.Run("sets a new cookie, so the old token stops working", func(t *testing.T) {
+		is := is.New(t)
+
+		res := httptest.NewRecorder()
+		token, err := handlers.RotateCSRFToken(res)
+		is.NoErr(err)
+		cookies := res.Result().Cookies()
+		is.Equal(1, len(cookies))
+		is.Equal(token, cookies[0].Value)
+		is.True(cookies[0].Secure)
+		is.Equal(http.SameSiteLaxMode, cookies[0].SameSite)
+
+		token2, err := handlers.RotateCSRFToken(httptest.NewRecorder())
+		is.NoErr(err)
+		is.True(token != token2)
+	})
+}
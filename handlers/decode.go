@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxJSONBodySize is how big a JSON request body decoded with DecodeJSON can be.
+const maxJSONBodySize = 64 * 1024
+
+// JSONError from DecodeJSON about a request body that isn't the JSON it should be,
+// with the Status code to respond with, and what's wrong with it, by field if it's about fields.
+// HandleErrors responds to it with problem details, always as JSON.
+type JSONError struct {
+	Status int
+	Detail string
+	// Errors by the path of the field in the body, like "body.email". Unknown fields are by their name only,
+	// like "body.zip", even in nested objects, since encoding/json doesn't say where they are.
+	Errors map[string]string
+}
+
+func (e *JSONError) Error() string {
+	if len(e.Errors) == 0 {
+		return e.Detail
+	}
+	var errs []string
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	sort.Strings(errs)
+	return e.Detail + " " + strings.Join(errs, ", ")
+}
+
+// DecodeJSON request body into a T, for JSON handlers. Errors are a *JSONError, for the client to fix, except errors
+// reading the body. The Content-Type must be application/json, or 415 Unsupported Media Type, and the body can be up to
+// 64 KiB, or 413 Payload Too Large. A body that isn't one JSON document, with an empty one or more than one,
+// gets 400 Bad Request. A body with fields T doesn't have, or values of the wrong type, gets 422 Unprocessable Entity,
+// with the error by field.
+func DecodeJSON[T any](w http.ResponseWriter, r *http.Request) (T, error) {
+	var v T
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return v, &JSONError{Status: http.StatusUnsupportedMediaType, Detail: "Content-Type must be application/json."}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return v, &JSONError{Status: http.StatusRequestEntityTooLarge,
+				Detail: fmt.Sprintf("The request body must be at most %v KiB.", maxJSONBodySize/1024)}
+		}
+		return v, fmt.Errorf("error reading request body: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return v, jsonError(body, err)
+	}
+	// Whatever is after the document must only be whitespace.
+	if rest := bytes.TrimLeft(body[dec.InputOffset():], " \t\r\n"); len(rest) > 0 {
+		return v, &JSONError{Status: http.StatusBadRequest, Detail: "The request body must be one JSON document, " +
+			"but there's more after it, at " + position(body, int64(len(body)-len(rest))) + "."}
+	}
+	return v, nil
+}
+
+// jsonError from the error decoding the body.
+func jsonError(body []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return &JSONError{Status: http.StatusBadRequest, Detail: "The request body is empty, but must be a JSON document."}
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &JSONError{Status: http.StatusBadRequest, Detail: "The request body isn't valid JSON: it ends before the document does."}
+
+	case errors.As(err, &syntaxErr):
+		// The offset is after the invalid character.
+		return &JSONError{Status: http.StatusBadRequest, Detail: fmt.Sprintf("The request body isn't valid JSON: %v, at %v.",
+			strings.TrimPrefix(syntaxErr.Error(), "json: "), position(body, syntaxErr.Offset-1))}
+
+	case errors.As(err, &typeErr):
+		field := "body"
+		if typeErr.Field != "" {
+			field += "." + typeErr.Field
+		}
+		return &JSONError{Status: http.StatusUnprocessableEntity, Detail: "The request body has a value of the wrong type.",
+			Errors: map[string]string{field: field + " must be " + jsonTypeName(typeErr.Type)}}
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		name, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if unquoteErr != nil {
+			return err
+		}
+		return &JSONError{Status: http.StatusUnprocessableEntity, Detail: "The request body has a field that isn't known.",
+			Errors: map[string]string{"body." + name: fmt.Sprintf("unknown field '%v'", name)}}
+
+	default:
+		return fmt.Errorf("error decoding request body: %w", err)
+	}
+}
+
+// position of the offset in the body, as the line and column, starting at 1.
+func position(body []byte, offset int64) string {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(body)) {
+		offset = int64(len(body))
+	}
+	before := body[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %v, column %v", line, column)
+}
+
+// jsonTypeName of the JSON values that can be decoded into t, with an article, like "a string".
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	default:
+		return "a " + t.String()
+	}
+}
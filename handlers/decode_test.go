@@ -0,0 +1,199 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+type decodeTarget struct {
+	Email      string   `json:"email"`
+	Age        int      `json:"age"`
+	Count      uint     `json:"count"`
+	Score      float64  `json:"score"`
+	Subscribed *bool    `json:"subscribed"`
+	Tags       []string `json:"tags"`
+	Address    struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(contentType, body string) (decodeTarget, error) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return handlers.DecodeJSON[decodeTarget](httptest.NewRecorder(), req)
+	}
+
+	t.Run("decodes a JSON document", func(t *testing.T) {
+		is := is.New(t)
+
+		v, err := decode("application/json",
+			`{"email":"me@example.com","age":42,"count":1,"score":0.5,"subscribed":true,"tags":["a"],"address":{"city":"Copenhagen"}}`)
+		is.NoErr(err)
+		is.Equal("me@example.com", v.Email)
+		is.Equal(42, v.Age)
+		is.Equal(uint(1), v.Count)
+		is.Equal(0.5, v.Score)
+		is.True(v.Subscribed != nil && *v.Subscribed)
+		is.Equal([]string{"a"}, v.Tags)
+		is.Equal("Copenhagen", v.Address.City)
+	})
+
+	valid := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"with a charset", "application/json; charset=utf-8", `{"email":"me@example.com"}`},
+		{"with an uppercase media type", "Application/JSON", `{"email":"me@example.com"}`},
+		{"with whitespace around it", "application/json", " \n\t{\"email\":\"me@example.com\"}\r\n "},
+		{"with nulls", "application/json", `{"email":null,"subscribed":null,"tags":null}`},
+		{"with only some fields", "application/json", `{}`},
+		{"with escaped strings", "application/json", `{"email":"me\u0040example.com"}`},
+	}
+	for _, test := range valid {
+		t.Run("decodes a JSON document "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := decode(test.contentType, test.body)
+			is.NoErr(err)
+		})
+	}
+
+	invalid := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		detail      string
+		errors      map[string]string
+	}{
+		{"without a content type", "", `{}`, http.StatusUnsupportedMediaType, "Content-Type must be application/json.", nil},
+		{"with a form content type", "application/x-www-form-urlencoded", `email=me%40example.com`, http.StatusUnsupportedMediaType,
+			"Content-Type must be application/json.", nil},
+		{"with a text content type", "text/plain", `{}`, http.StatusUnsupportedMediaType, "Content-Type must be application/json.", nil},
+		{"with another JSON content type", "application/merge-patch+json", `{}`, http.StatusUnsupportedMediaType,
+			"Content-Type must be application/json.", nil},
+		{"with an invalid content type", "application/json; charset", `{}`, http.StatusUnsupportedMediaType,
+			"Content-Type must be application/json.", nil},
+
+		{"that's empty", "application/json", ``, http.StatusBadRequest,
+			"The request body is empty, but must be a JSON document.", nil},
+		{"that's only whitespace", "application/json", " \n ", http.StatusBadRequest,
+			"The request body is empty, but must be a JSON document.", nil},
+		{"that ends early", "application/json", `{"email": "me@`, http.StatusBadRequest,
+			"The request body isn't valid JSON: it ends before the document does.", nil},
+		{"that ends after a field", "application/json", `{"email": "me@example.com",`, http.StatusBadRequest,
+			"The request body isn't valid JSON: it ends before the document does.", nil},
+		{"without a colon", "application/json", `{"email" "me@example.com"}`, http.StatusBadRequest,
+			`The request body isn't valid JSON: invalid character '"' after object key, at line 1, column 10.`, nil},
+		{"with a trailing comma", "application/json", `{"email": "me@example.com",}`, http.StatusBadRequest,
+			`The request body isn't valid JSON: invalid character '}' looking for beginning of object key string, at line 1, column 28.`, nil},
+		{"with single quotes", "application/json", `{'email': 'me@example.com'}`, http.StatusBadRequest,
+			`The request body isn't valid JSON: invalid character '\'' looking for beginning of object key string, at line 1, column 2.`, nil},
+		{"with an error on a later line", "application/json", "{\n  \"email\": \"me@example.com\",\n  \"age\": 4 2\n}", http.StatusBadRequest,
+			`The request body isn't valid JSON: invalid character '2' after object key:value pair, at line 3, column 12.`, nil},
+		{"that's not JSON", "application/json", `email=me@example.com`, http.StatusBadRequest,
+			`The request body isn't valid JSON: invalid character 'e' looking for beginning of value, at line 1, column 1.`, nil},
+		{"with more than one document", "application/json", `{"email":"me@example.com"} {"email":"you@example.com"}`,
+			http.StatusBadRequest, "The request body must be one JSON document, but there's more after it, at line 1, column 28.", nil},
+		{"with something after the document", "application/json", "{}\n]", http.StatusBadRequest,
+			"The request body must be one JSON document, but there's more after it, at line 2, column 1.", nil},
+		{"that's too big", "application/json", `{"email":"` + strings.Repeat("a", 64*1024) + `"}`, http.StatusRequestEntityTooLarge,
+			"The request body must be at most 64 KiB.", nil},
+
+		{"with an unknown field", "application/json", `{"emial":"me@example.com"}`, http.StatusUnprocessableEntity,
+			"The request body has a field that isn't known.", map[string]string{"body.emial": "unknown field 'emial'"}},
+		{"with a field in the wrong case", "application/json", `{"EMAIL":"me@example.com","Emial":"x"}`, http.StatusUnprocessableEntity,
+			"The request body has a field that isn't known.", map[string]string{"body.Emial": "unknown field 'Emial'"}},
+		{"with an unknown field in an object", "application/json", `{"address":{"zip":"2100"}}`, http.StatusUnprocessableEntity,
+			"The request body has a field that isn't known.", map[string]string{"body.zip": "unknown field 'zip'"}},
+
+		{"with a number for a string", "application/json", `{"email":1}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.email": "body.email must be a string"}},
+		{"with an object for a string", "application/json", `{"email":{}}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.email": "body.email must be a string"}},
+		{"with a string for an integer", "application/json", `{"age":"42"}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.age": "body.age must be an integer"}},
+		{"with a fraction for an integer", "application/json", `{"age":4.2}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.age": "body.age must be an integer"}},
+		{"with an integer that's too big", "application/json", `{"age":1e100}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.age": "body.age must be an integer"}},
+		{"with a negative number for an unsigned integer", "application/json", `{"count":-1}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.count": "body.count must be a non-negative integer"}},
+		{"with a string for a number", "application/json", `{"score":"0.5"}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.score": "body.score must be a number"}},
+		{"with a string for a boolean", "application/json", `{"subscribed":"true"}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.subscribed": "body.subscribed must be a boolean"}},
+		{"with a string for an array", "application/json", `{"tags":"a"}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.tags": "body.tags must be an array"}},
+		{"with a number in an array of strings", "application/json", `{"tags":["a",1]}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.tags.1": "body.tags.1 must be a string"}},
+		{"with a string for an object", "application/json", `{"address":"Copenhagen"}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.address": "body.address must be an object"}},
+		{"with a number in an object", "application/json", `{"address":{"city":2100}}`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body.address.city": "body.address.city must be a string"}},
+		{"with an array for the body", "application/json", `[{"email":"me@example.com"}]`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body": "body must be an object"}},
+		{"with a string for the body", "application/json", `"me@example.com"`, http.StatusUnprocessableEntity,
+			"The request body has a value of the wrong type.", map[string]string{"body": "body must be an object"}},
+	}
+	for _, test := range invalid {
+		t.Run("rejects a body "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := decode(test.contentType, test.body)
+			var jsonErr *handlers.JSONError
+			is.True(errors.As(err, &jsonErr))
+			is.Equal(test.status, jsonErr.Status)
+			is.Equal(test.detail, jsonErr.Detail)
+			is.Equal(test.errors, jsonErr.Errors)
+		})
+	}
+
+	t.Run("responds with problem details in HandleErrors, even when HTML is asked for", func(t *testing.T) {
+		is := is.New(t)
+
+		h := handlers.HandleErrors(nil, func(w http.ResponseWriter, r *http.Request) error {
+			_, err := handlers.DecodeJSON[decodeTarget](w, r)
+			return err
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "text/html")
+		res := httptest.NewRecorder()
+		h(res, req)
+
+		is.Equal(http.StatusUnprocessableEntity, res.Code)
+		is.Equal("application/problem+json", res.Header().Get("Content-Type"))
+		var p struct {
+			Status int
+			Detail string
+			Errors map[string]string
+		}
+		is.NoErr(json.Unmarshal(res.Body.Bytes(), &p))
+		is.Equal(http.StatusUnprocessableEntity, p.Status)
+		is.Equal("The request body has a value of the wrong type.", p.Detail)
+		is.Equal(map[string]string{"body.email": "body.email must be a string"}, p.Errors)
+	})
+}
+
+func TestJSONError_Error(t *testing.T) {
+	t.Run("has the detail and the field errors, sorted", func(t *testing.T) {
+		is := is.New(t)
+
+		err := &handlers.JSONError{Status: http.StatusUnprocessableEntity, Detail: "Nope.",
+			Errors: map[string]string{"body.b": "body.b must be a string", "body.a": "body.a must be a string"}}
+		is.Equal("Nope. body.a must be a string, body.b must be a string", err.Error())
+	})
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/i18n"
+	"canvas/views"
+)
+
+// StaleHeaderName is the response header marking a cached copy of a page, served by Degradable
+// while the database is unhealthy.
+const StaleHeaderName = "X-Served-Stale"
+
+type healthChecker interface {
+	Healthy() bool
+}
+
+// DegradableOptions for Degradable.
+type DegradableOptions struct {
+	// Health of the database, like a *storage.HealthMonitor.
+	Health healthChecker
+	Log    *zap.Logger
+	// MaxEntries in the cache, one for each path and locale preference. Defaults to 100.
+	MaxEntries int
+	Metrics    *prometheus.Registry
+	// Now defaults to time.Now.
+	Now func() time.Time
+	// TTL of the cached copies. Defaults to a minute.
+	TTL time.Duration
+}
+
+// Degradable is middleware keeping recent copies of the pages of its routes, so they can still be shown
+// while Health reports the database as unhealthy, with the StaleHeaderName header.
+// Without a copy from the last TTL, the routes get views.UnavailablePage with 503 Service Unavailable instead.
+//
+// The copies are rendered for a request without the cookies of a visitor, apart from the locale,
+// so they don't have anyone's session, flashes, or CSRF token. That also means forms on them fail
+// with 403 Forbidden, which is fine, since they need the database anyway.
+// It must go before the middleware needing the database, like for sessions.
+// Only GET requests are cached and served from the cache. Served copies are counted in app_http_degraded_total.
+func Degradable(opts DegradableOptions) func(next http.Handler) http.Handler {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 100
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+
+	degraded := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_http_degraded_total",
+		Help: "Number of requests answered while the database was unhealthy, by result: stale, or unavailable without a cached copy.",
+	}, []string{"result"})
+	opts.Metrics.MustRegister(degraded)
+
+	c := &pageCache{maxEntries: opts.MaxEntries, ttl: opts.TTL, entries: map[string]cachedPage{}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := pageCacheKey(r)
+			now := opts.Now()
+
+			if opts.Health == nil || opts.Health.Healthy() {
+				if _, ok := c.get(key, now); !ok {
+					c.fill(next, r, key, now)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			p, ok := c.get(key, now)
+			if !ok {
+				degraded.WithLabelValues("unavailable").Inc()
+				if err := render(w, http.StatusServiceUnavailable, views.UnavailablePage(r.URL.Path)); err != nil {
+					abortResponse(opts.Log, r, err)
+				}
+				return
+			}
+
+			degraded.WithLabelValues("stale").Inc()
+			for k, v := range p.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(StaleHeaderName, "true")
+			w.Header().Set("Age", strconv.Itoa(int(now.Sub(p.cached).Seconds())))
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(p.body)
+		})
+	}
+}
+
+// pageCacheKey of the request, by path and locale preference, which are all the cached copies differ by.
+func pageCacheKey(r *http.Request) string {
+	var locale string
+	if c, err := r.Cookie(i18n.CookieName); err == nil {
+		locale = c.Value
+	}
+	return r.URL.Path + "\x00" + locale + "\x00" + r.Header.Get("Accept-Language")
+}
+
+// pageCache of Degradable. It's safe for concurrent use.
+type pageCache struct {
+	maxEntries int
+	ttl        time.Duration
+
+	lock    sync.Mutex
+	entries map[string]cachedPage
+}
+
+type cachedPage struct {
+	body   []byte
+	cached time.Time
+	header http.Header
+}
+
+// get the page under key if it was cached less than the TTL before now.
+func (c *pageCache) get(key string, now time.Time) (cachedPage, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.entries[key]
+	if !ok || now.Sub(p.cached) >= c.ttl {
+		return cachedPage{}, false
+	}
+	return p, true
+}
+
+// fill the cache under key with the page next renders for a copy of r without cookies, except the locale one,
+// if it's an HTML page that's there.
+func (c *pageCache) fill(next http.Handler, r *http.Request, key string, now time.Time) {
+	fr := r.Clone(r.Context())
+	fr.Header = http.Header{}
+	if v := r.Header.Get("Accept-Language"); v != "" {
+		fr.Header.Set("Accept-Language", v)
+	}
+	if cookie, err := r.Cookie(i18n.CookieName); err == nil {
+		fr.AddCookie(cookie)
+	}
+
+	rec := &pageRecorder{code: http.StatusOK, header: http.Header{}}
+	next.ServeHTTP(rec, fr)
+	if rec.code != http.StatusOK || !strings.HasPrefix(rec.header.Get("Content-Type"), "text/html") {
+		return
+	}
+	rec.header.Del("Set-Cookie")
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, p := range c.entries {
+			if now.Sub(p.cached) >= c.ttl {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+	c.entries[key] = cachedPage{body: rec.body.Bytes(), cached: now, header: rec.header}
+}
+
+// pageRecorder is an http.ResponseWriter keeping the response for pageCache.
+type pageRecorder struct {
+	body        bytes.Buffer
+	code        int
+	header      http.Header
+	wroteHeader bool
+}
+
+func (p *pageRecorder) Header() http.Header {
+	return p.header
+}
+
+func (p *pageRecorder) Write(b []byte) (int, error) {
+	p.wroteHeader = true
+	return p.body.Write(b)
+}
+
+func (p *pageRecorder) WriteHeader(code int) {
+	if !p.wroteHeader {
+		p.code = code
+		p.wroteHeader = true
+	}
+}
@@ -0,0 +1,182 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"canvas/handlers"
+	"canvas/i18n"
+)
+
+type healthMock struct {
+	healthy bool
+}
+
+func (h *healthMock) Healthy() bool {
+	return h.healthy
+}
+
+func TestDegradable(t *testing.T) {
+	type setup struct {
+		mux      chi.Router
+		health   *healthMock
+		registry *prometheus.Registry
+		// renders of the page so far.
+		renders *int
+		// advance the clock by d.
+		advance func(d time.Duration)
+	}
+
+	newSetup := func() setup {
+		health := &healthMock{healthy: true}
+		registry := prometheus.NewRegistry()
+		now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+		var renders int
+
+		mux := chi.NewMux()
+		mux.Use(handlers.Degradable(handlers.DegradableOptions{
+			Health:  health,
+			Metrics: registry,
+			Now:     func() time.Time { return now },
+			TTL:     time.Minute,
+		}))
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			renders++
+			var visitor, locale string
+			if c, err := r.Cookie("session"); err == nil {
+				visitor = c.Value
+			}
+			if c, err := r.Cookie(i18n.CookieName); err == nil {
+				locale = c.Value
+			}
+			http.SetCookie(w, &http.Cookie{Name: "csrf", Value: "abc"})
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Security-Policy", "script-src 'nonce-123'")
+			_, _ = w.Write([]byte("<p>Front page " + strconv.Itoa(renders) + " for " + visitor + " in " + locale + "</p>"))
+		})
+		mux.Get("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte("{}"))
+		})
+
+		return setup{mux: mux, health: health, registry: registry, renders: &renders, advance: func(d time.Duration) {
+			now = now.Add(d)
+		}}
+	}
+
+	get := func(mux chi.Router, target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("serves the page as usual while the database is healthy", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		res := get(s.mux, "/", &http.Cookie{Name: "session", Value: "me"})
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("", res.Header().Get(handlers.StaleHeaderName))
+		// Once for the cache, and once for the visitor.
+		is.Equal("<p>Front page 2 for me in </p>", res.Body.String())
+		is.Equal(1, len(res.Result().Cookies()))
+
+		res = get(s.mux, "/", &http.Cookie{Name: "session", Value: "me"})
+		is.Equal("<p>Front page 3 for me in </p>", res.Body.String())
+	})
+
+	t.Run("serves a copy without the visitor's cookies while the database is unhealthy", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		get(s.mux, "/", &http.Cookie{Name: "session", Value: "me"})
+
+		s.health.healthy = false
+		s.advance(30 * time.Second)
+		res := get(s.mux, "/", &http.Cookie{Name: "session", Value: "you"})
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("<p>Front page 1 for  in </p>", res.Body.String())
+		is.Equal("true", res.Header().Get(handlers.StaleHeaderName))
+		is.Equal("30", res.Header().Get("Age"))
+		is.Equal("no-store", res.Header().Get("Cache-Control"))
+		is.Equal("script-src 'nonce-123'", res.Header().Get("Content-Security-Policy"))
+		is.Equal(0, len(res.Result().Cookies()))
+		is.Equal(2, *s.renders)
+	})
+
+	t.Run("keeps a copy for each locale", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		get(s.mux, "/", &http.Cookie{Name: i18n.CookieName, Value: "fr"})
+		get(s.mux, "/")
+
+		s.health.healthy = false
+		is.Equal("<p>Front page 1 for  in fr</p>", get(s.mux, "/", &http.Cookie{Name: i18n.CookieName, Value: "fr"}).Body.String())
+		is.Equal("<p>Front page 3 for  in </p>", get(s.mux, "/").Body.String())
+	})
+
+	t.Run("serves the unavailable page without a copy from the last TTL", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		s.health.healthy = false
+		res := get(s.mux, "/")
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.True(strings.Contains(res.Body.String(), "Back soon"))
+		is.Equal(0, *s.renders)
+
+		s.health.healthy = true
+		get(s.mux, "/")
+		s.health.healthy = false
+		s.advance(59 * time.Second)
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+		s.advance(time.Second)
+		res = get(s.mux, "/")
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.Equal("", res.Header().Get(handlers.StaleHeaderName))
+
+		err := testutil.GatherAndCompare(s.registry, strings.NewReader(`
+# HELP app_http_degraded_total Number of requests answered while the database was unhealthy, by result: stale, or unavailable without a cached copy.
+# TYPE app_http_degraded_total counter
+app_http_degraded_total{result="stale"} 1
+app_http_degraded_total{result="unavailable"} 2
+`), "app_http_degraded_total")
+		is.NoErr(err)
+	})
+
+	t.Run("renews the copy after the TTL", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		get(s.mux, "/")
+		s.advance(time.Minute)
+		get(s.mux, "/")
+		is.Equal(4, *s.renders)
+
+		s.health.healthy = false
+		is.Equal("<p>Front page 3 for  in </p>", get(s.mux, "/").Body.String())
+	})
+
+	t.Run("doesn't keep pages that aren't HTML", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup()
+		get(s.mux, "/feed.json")
+		s.health.healthy = false
+		is.Equal(http.StatusServiceUnavailable, get(s.mux, "/feed.json").Code)
+	})
+}
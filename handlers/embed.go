@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"canvas/i18n"
+	"canvas/views"
+)
+
+// EmbedSignup serves the signup form for partner sites to frame at /signup, on a router mounted at /embed,
+// without the layout.
+// Only the partner origins of the signup service can frame it, which is set with the CSP frame-ancestors directive,
+// in a policy of its own, so it's enforced along with the one from SecurityHeaders.
+// The form signs up through the JSON API, with the partner origin from the Referer header as the source,
+// if it's a partner origin.
+func EmbedSignup(mux chi.Router, svc *SignupService) {
+	ancestors := "'self'"
+	if svc.partners["*"] {
+		ancestors = "*"
+	} else if len(svc.opts.PartnerOrigins) > 0 {
+		ancestors += " " + strings.Join(svc.opts.PartnerOrigins, " ")
+	}
+
+	mux.Get("/signup", HandleErrors(svc.log, func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Add("Content-Security-Policy", "frame-ancestors "+ancestors)
+		return render(w, http.StatusOK, views.EmbedSignupPage(i18n.FromContext(r.Context()), svc.source(refererOrigin(r), ""),
+			captchaWidget(svc.opts.Captcha), CSPNonce(r)))
+	}))
+}
+
+// refererOrigin is the origin of the Referer header, like "https://example.com", or empty without one.
+func refererOrigin(r *http.Request) string {
+	u, err := url.Parse(r.Referer())
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,103 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+)
+
+func TestEmbedSignup(t *testing.T) {
+	newMux := func(origins ...string) chi.Router {
+		mux := chi.NewMux()
+		mux.Route("/embed", func(r chi.Router) {
+			r.Use(handlers.SecurityHeaders(handlers.SecurityHeadersOptions{}))
+			handlers.EmbedSignup(r, handlers.NewSignupService(&signupperMock{}, zap.NewNop(), handlers.SignupServiceOptions{
+				PartnerOrigins: origins,
+			}))
+		})
+		return mux
+	}
+
+	get := func(mux chi.Router, referer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/embed/signup", nil)
+		if referer != "" {
+			req.Header.Set("Referer", referer)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("serves the form posting to the JSON API, without the layout or cookies", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux("https://partner.example.com"), "")
+		is.Equal(http.StatusOK, res.Code)
+		body := res.Body.String()
+		is.True(strings.Contains(body, `<form id="signup" action="/api/newsletter/signup" method="post" data-source=""`))
+		is.True(strings.Contains(body, `credentials: "omit"`))
+		is.True(strings.Contains(body, `<script nonce="`))
+		is.True(!strings.Contains(body, "<nav"))
+		is.True(!strings.Contains(body, "csrf"))
+		is.Equal("", res.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("has the captcha widget and sends its response token, with a captcha", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		mux.Route("/embed", func(r chi.Router) {
+			r.Use(handlers.SecurityHeaders(handlers.SecurityHeadersOptions{}))
+			handlers.EmbedSignup(r, handlers.NewSignupService(&signupperMock{}, zap.NewNop(), handlers.SignupServiceOptions{
+				Captcha: handlers.NewTurnstileVerifier(handlers.CaptchaSiteVerifierOptions{SiteKey: "site"}),
+			}))
+		})
+		body := get(mux, "").Body.String()
+		is.True(strings.Contains(body, `data-captcha="cf-turnstile-response"`))
+		is.True(strings.Contains(body, `class="cf-turnstile" data-sitekey="site"`))
+		is.True(strings.Contains(body, "captcha: captcha ? captcha.value : undefined"))
+	})
+
+	t.Run("can only be framed by the partner origins", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux("https://partner.example.com", "https://other.example.com"), "")
+		is.Equal("frame-ancestors 'self' https://partner.example.com https://other.example.com", res.Header().Values("Content-Security-Policy")[1])
+	})
+
+	t.Run("can only be framed by this site without partner origins", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux(), "")
+		is.Equal("frame-ancestors 'self'", res.Header().Values("Content-Security-Policy")[1])
+	})
+
+	t.Run("can be framed by any origin with the wildcard", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux("*"), "https://anyone.example.com/page")
+		is.Equal("frame-ancestors *", res.Header().Values("Content-Security-Policy")[1])
+		is.True(strings.Contains(res.Body.String(), `data-source="https://anyone.example.com"`))
+	})
+
+	t.Run("has the partner origin it's framed by as the source", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux("https://partner.example.com"), "https://partner.example.com/blog/post?a=1")
+		is.True(strings.Contains(res.Body.String(), `data-source="https://partner.example.com"`))
+	})
+
+	t.Run("has no source if framed by an origin that isn't a partner", func(t *testing.T) {
+		is := is.New(t)
+
+		res := get(newMux("https://partner.example.com"), "https://evil.example.com/")
+		is.True(strings.Contains(res.Body.String(), `data-source=""`))
+	})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"canvas/apperr"
+	"canvas/form"
+	"canvas/views"
+)
+
+// ErrorHandlerFunc is like http.HandlerFunc, but returns errors instead of responding to them itself.
+// Use HandleErrors to make it an http.HandlerFunc. Return errors before writing the body, so they get an error response,
+// and return the errors from rendering views with render too.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// HandleErrors responds to the errors returned by h, so all handlers have the same error responses,
+// by the apperr kind of the error:
+//   - apperr.NotFound, like storage.ErrNotFound, gets the not found page with 404 Not Found.
+//   - A *form.ValidationError gets the field errors with 422 Unprocessable Entity, and so does apperr.Invalid, without them.
+//   - A *JSONError from DecodeJSON gets its status code and field errors, always as JSON, since the request was.
+//   - apperr.Conflict gets the conflict page with 409 Conflict.
+//   - apperr.Forbidden gets the not allowed page with 403 Forbidden.
+//   - apperr.Unavailable gets the error page with 503 Service Unavailable. It's logged with a reference code,
+//     but not reported, since the health checks cover what's unavailable.
+//   - Everything else gets the error page with 500 Internal Server Error.
+//
+// Unexpected errors are logged with the logger of the request and a short reference code that's also shown on the
+// error page, so a reference someone reports can be found in the logs. They're also reported with the reporter from Recover.
+// The route pattern is added to the logger of the request from RequestLog, since routing is done by the time h runs.
+// Responses are HTML or JSON, depending on what the request asks for.
+//
+// The status code from h is held back until the body is written, so errors before that, like a view failing
+// to render right away, still get the error response. Errors after the body has started can't change the response
+// anymore. They're logged at error level, and the connection is aborted, so the client doesn't take the truncated
+// response as complete.
+func HandleErrors(log *zap.Logger, h ErrorHandlerFunc) http.HandlerFunc {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = withRouteLogField(r)
+		rw := &responseWriter{ResponseWriter: w}
+		err := h(rw, r)
+		if err == nil {
+			rw.start()
+			return
+		}
+		if rw.started {
+			abortResponse(log, r, err)
+		}
+
+		var validationErr *form.ValidationError
+		var jsonErr *JSONError
+		switch {
+		case errors.As(err, &jsonErr):
+			requestLog(r.Context(), log).Debug("Invalid JSON request body", zap.Error(err))
+			writeProblem(w, problem{Status: jsonErr.Status, Detail: jsonErr.Detail, Errors: jsonErr.Errors})
+			return
+		case errors.Is(err, apperr.NotFound):
+			requestLog(r.Context(), log).Debug("Not found", zap.Error(err))
+			err = respondError(w, r, http.StatusNotFound, views.NotFoundPage(r.URL.Path), "")
+		case errors.As(err, &validationErr):
+			requestLog(r.Context(), log).Debug("Invalid input", zap.Error(err))
+			err = respondProblem(w, r, views.InvalidInputPage(r.URL.Path, validationErr.Errors), problem{
+				Status: http.StatusUnprocessableEntity,
+				Errors: validationErr.Errors,
+			})
+		case errors.Is(err, apperr.Invalid):
+			requestLog(r.Context(), log).Debug("Invalid input", zap.Error(err))
+			err = respondError(w, r, http.StatusUnprocessableEntity, views.InvalidInputPage(r.URL.Path, nil), "")
+		case errors.Is(err, apperr.Conflict):
+			requestLog(r.Context(), log).Info("Conflict", zap.Error(err))
+			err = respondError(w, r, http.StatusConflict, views.ConflictPage(r.URL.Path), "")
+		case errors.Is(err, apperr.Forbidden):
+			requestLog(r.Context(), log).Info("Forbidden", zap.Error(err))
+			err = respondError(w, r, http.StatusForbidden, views.NotAllowedPage(r.URL.Path), "")
+		case errors.Is(err, apperr.Unavailable):
+			reference := logError(log, r, err)
+			err = respondError(w, r, http.StatusServiceUnavailable, views.ErrorPage(r.URL.Path, reference),
+				"Something is unavailable right now. Please try again in a moment. Reference "+reference+".")
+		default:
+			respondInternalError(w, r, log, err)
+			return
+		}
+		if err != nil {
+			abortResponse(log, r, err)
+		}
+	}
+}
+
+// respondInternalError logs and reports the unexpected error with a reference code,
+// and responds with the error page showing it.
+func respondInternalError(w http.ResponseWriter, r *http.Request, log *zap.Logger, err error) {
+	reference := logError(log, r, err)
+	reporterFrom(r.Context()).ReportRequest(r, reference, err)
+	err = respondError(w, r, http.StatusInternalServerError, views.ErrorPage(r.URL.Path, reference),
+		"Something went wrong. Reference "+reference+".")
+	if err != nil {
+		abortResponse(log, r, err)
+	}
+}
+
+// abortResponse that failed after it started, by logging the error and panicking with http.ErrAbortHandler,
+// which makes the server close the connection without logging a stack trace.
+func abortResponse(log *zap.Logger, r *http.Request, err error) {
+	requestLog(r.Context(), log).Error("Error after response started, aborting", zap.Error(err))
+	panic(http.ErrAbortHandler)
+}
+
+// responseWriter holds back the status code until the body is first written,
+// and remembers if that happened, so HandleErrors knows whether it can still respond with an error.
+type responseWriter struct {
+	http.ResponseWriter
+	code    int
+	started bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.started && w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.start()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the response, starting it first, so streaming handlers can send what they have written so far.
+func (w *responseWriter) Flush() {
+	w.start()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start the response, by writing the held back status code, if any.
+func (w *responseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+}
+
+// logError with the logger of the request, returning a new reference code for it.
+func logError(log *zap.Logger, r *http.Request, err error) string {
+	reference := createErrorReference()
+	requestLog(r.Context(), log).Info("Error handling request", zap.Error(err), zap.String("reference", reference))
+	return reference
+}
+
+// createErrorReference of six characters that are easy to read out, such as "ABC234".
+// It doesn't have to be unique, just unique enough to find the error in the logs around the time it happened.
+func createErrorReference() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "UNKNOWN"
+	}
+	return base32.StdEncoding.EncodeToString(b)[:6]
+}
@@ -0,0 +1,161 @@
+package handlers_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/apperr"
+	"canvas/form"
+	"canvas/handlers"
+	"canvas/storage"
+)
+
+func TestHandleErrors(t *testing.T) {
+	newMux := func(log *zap.Logger, err error) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID)
+		mux.Get("/", handlers.HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			if err != nil {
+				return err
+			}
+			_, _ = w.Write([]byte("OK"))
+			return nil
+		}))
+		return mux
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		phrase string
+	}{
+		{"responds normally without an error", nil, http.StatusOK, "OK"},
+		{"responds with 404 for ErrNotFound", storage.ErrNotFound, http.StatusNotFound, "Page not found"},
+		{"responds with 404 for a wrapped ErrNotFound", fmt.Errorf("error getting thing: %w", storage.ErrNotFound), http.StatusNotFound, "Page not found"},
+		{"responds with 422 for a validation error", &form.ValidationError{Errors: map[string]string{"email": "Please fill this in."}}, http.StatusUnprocessableEntity, "Please fill this in."},
+		{"responds with 422 for an invalid error", fmt.Errorf("error saving: %w", apperr.Wrap(apperr.Invalid, errors.New("check_violation"))), http.StatusUnprocessableEntity, "Please check what you entered"},
+		{"responds with 409 for a conflict", fmt.Errorf("error saving: %w", storage.ErrConflict), http.StatusConflict, "That changed in the meantime"},
+		{"responds with 403 for a forbidden error", apperr.Wrap(apperr.Forbidden, errors.New("insufficient_privilege")), http.StatusForbidden, "Not allowed"},
+		{"responds with 503 for an unavailable error", fmt.Errorf("error saving: %w", apperr.Wrap(apperr.Unavailable, errors.New("connection refused"))), http.StatusServiceUnavailable, "Something went wrong"},
+		{"responds with 500 for other errors", errors.New("oh no"), http.StatusInternalServerError, "Something went wrong"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			code, _, body := makeGetRequest(newMux(zap.NewNop(), test.err), "/")
+			is.Equal(test.code, code)
+			is.True(strings.Contains(body, test.phrase))
+		})
+	}
+
+	t.Run("shows the reference code that's logged with the error and request ID", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zap.InfoLevel)
+		code, _, body := makeGetRequest(newMux(zap.New(core), errors.New("oh no")), "/")
+		is.Equal(http.StatusInternalServerError, code)
+
+		matches := regexp.MustCompile(`reference <strong>([A-Z2-7]{6})</strong>`).FindStringSubmatch(body)
+		is.Equal(2, len(matches))
+
+		entries := logs.FilterMessage("Error handling request").All()
+		is.Equal(1, len(entries))
+		fields := entries[0].ContextMap()
+		is.Equal(matches[1], fields["reference"])
+		is.Equal("oh no", fields["error"])
+		is.True(fields["requestID"] != "")
+	})
+
+	t.Run("responds with problem details for JSON requests", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(zap.NewNop(), &form.ValidationError{Errors: map[string]string{"email": "Please fill this in."}})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "application/json")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusUnprocessableEntity, res.Code)
+		is.Equal("application/problem+json", res.Header().Get("Content-Type"))
+		is.Equal(`{"type":"about:blank","title":"Unprocessable Entity","status":422,"errors":{"email":"Please fill this in."}}`+"\n", res.Body.String())
+
+		mux = newMux(zap.NewNop(), errors.New("oh no"))
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusInternalServerError, res.Code)
+		is.True(regexp.MustCompile(`"detail":"Something went wrong. Reference [A-Z2-7]{6}."`).MatchString(res.Body.String()))
+	})
+
+	t.Run("responds with the error page if a view fails before anything is written", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		mux.Get("/", handlers.HandleErrors(zap.NewNop(), func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusOK)
+			return g.NodeFunc(func(io.Writer) error {
+				return errors.New("oh no")
+			}).Render(w)
+		}))
+
+		code, _, body := makeGetRequest(mux, "/")
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
+	})
+
+	t.Run("aborts the response and logs if a view fails after writing", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zap.InfoLevel)
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID)
+		mux.Get("/", handlers.HandleErrors(zap.New(core), func(w http.ResponseWriter, r *http.Request) error {
+			return Div(P(g.Text("Hello")), P(g.Text("World"))).Render(w)
+		}))
+
+		res := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder(), n: 10}
+		func() {
+			defer func() {
+				is.Equal(http.ErrAbortHandler, recover())
+			}()
+			mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		}()
+
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("<div><p>He", res.Body.String())
+		entries := logs.FilterMessage("Error after response started, aborting").All()
+		is.Equal(1, len(entries))
+		is.Equal(zap.ErrorLevel, entries[0].Level)
+		is.True(entries[0].ContextMap()["requestID"] != "")
+	})
+}
+
+// failingResponseWriter fails writes after n bytes, like a client that went away mid-response.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+	n int
+}
+
+func (w *failingResponseWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		written, _ := w.ResponseRecorder.Write(b[:w.n])
+		w.n = 0
+		return written, errors.New("connection reset")
+	}
+	w.n -= len(b)
+	return w.ResponseRecorder.Write(b)
+}
@@ -0,0 +1,4 @@
+package handlers
+
+// BodyStart is bodyStart, for testing where the preview banner goes in documents the templates don't render.
+var BodyStart = bodyStart
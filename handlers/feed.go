@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+// feedLimit is how many of the latest issues are in the feeds.
+const feedLimit = 20
+
+// FeedOptions for Feeds.
+type FeedOptions struct {
+	// Author of the newsletter, which Atom requires.
+	Author string
+	// BaseURL of the app, for the absolute URLs in the feeds, like "https://example.com".
+	BaseURL     string
+	Description string
+	// Now is for the updated time of a feed without newsletters. Defaults to time.Now.
+	Now   func() time.Time
+	Title string
+}
+
+// Feeds of the latest published newsletter issues, for readers who'd rather not get emails.
+// The RSS 2.0 feed is at /feed.xml and the Atom feed at /feed.atom.
+// Both support conditional requests with Last-Modified and ETag, so feed readers polling them mostly get 304 Not Modified.
+func Feeds(mux chi.Router, n publishedNewsletterLister, log *zap.Logger, opts FeedOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+
+	serve := func(contentType string, marshal func([]model.Newsletter) any) http.HandlerFunc {
+		return HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			newsletters, err := n.ListPublishedNewsletters(r.Context(), storage.ListPublishedNewslettersOptions{Limit: feedLimit})
+			if err != nil {
+				return fmt.Errorf("error listing published newsletters: %w", err)
+			}
+
+			b, err := xml.Marshal(marshal(newsletters))
+			if err != nil {
+				return fmt.Errorf("error marshalling feed: %w", err)
+			}
+			b = append([]byte(xml.Header), b...)
+
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(b)))
+			http.ServeContent(w, r, "", lastModified(newsletters), bytes.NewReader(b))
+			return nil
+		})
+	}
+
+	mux.Get("/feed.xml", serve("application/rss+xml; charset=utf-8", func(newsletters []model.Newsletter) any {
+		return newRSSFeed(opts, newsletters)
+	}))
+	mux.Get("/feed.atom", serve("application/atom+xml; charset=utf-8", func(newsletters []model.Newsletter) any {
+		return newAtomFeed(opts, newsletters)
+	}))
+}
+
+// lastModified of the newsletters, or the zero time if there are none.
+func lastModified(newsletters []model.Newsletter) time.Time {
+	var t time.Time
+	for _, n := range newsletters {
+		if n.LastModified().After(t) {
+			t = n.LastModified()
+		}
+	}
+	return t
+}
+
+// archiveURL of the published newsletter issue.
+func archiveURL(baseURL string, n model.Newsletter) string {
+	return baseURL + "/archive/" + url.PathEscape(n.Slug)
+}
+
+// feedID of the newsletter issue, as a tag URI described in RFC 4151.
+// It's based on the ID and the creation date, so it doesn't change if the issue's slug or title does.
+func feedID(baseURL string, n model.Newsletter) string {
+	host := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	return fmt.Sprintf("tag:%v,%v:newsletters/%v", host, n.Created.UTC().Format("2006-01-02"), n.ID)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Self          rssLink   `xml:"atom:link"`
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description rssHTML `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rssHTML is put in a CDATA section, which is what most feed readers expect for HTML in RSS.
+type rssHTML struct {
+	Value string `xml:",cdata"`
+}
+
+func newRSSFeed(opts FeedOptions, newsletters []model.Newsletter) rssFeed {
+	f := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Self:        rssLink{Href: opts.BaseURL + "/feed.xml", Rel: "self", Type: "application/rss+xml"},
+			Title:       opts.Title,
+			Link:        opts.BaseURL + "/",
+			Description: opts.Description,
+			Language:    "en",
+		},
+	}
+	if len(newsletters) > 0 {
+		f.Channel.LastBuildDate = lastModified(newsletters).UTC().Format(time.RFC1123Z)
+	}
+	for _, n := range newsletters {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       n.Title,
+			Link:        archiveURL(opts.BaseURL, n),
+			GUID:        rssGUID{Value: feedID(opts.BaseURL, n)},
+			PubDate:     n.PublishedAt.UTC().Format(time.RFC1123Z),
+			Description: rssHTML{Value: n.BodyHTML()},
+		})
+	}
+	return f
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomAuthor  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Content   atomContent `xml:"content"`
+}
+
+// atomContent of type html is escaped text, as in RFC 4287.
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newAtomFeed(opts FeedOptions, newsletters []model.Newsletter) atomFeed {
+	updated := lastModified(newsletters)
+	if updated.IsZero() {
+		updated = opts.Now()
+	}
+	f := atomFeed{
+		Title:    opts.Title,
+		Subtitle: opts.Description,
+		ID:       opts.BaseURL + "/",
+		Updated:  updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: opts.BaseURL + "/", Rel: "alternate", Type: "text/html"},
+			{Href: opts.BaseURL + "/feed.atom", Rel: "self", Type: "application/atom+xml"},
+		},
+		Author: atomAuthor{Name: opts.Author},
+	}
+	for _, n := range newsletters {
+		f.Entries = append(f.Entries, atomEntry{
+			Title:     n.Title,
+			ID:        feedID(opts.BaseURL, n),
+			Link:      atomLink{Href: archiveURL(opts.BaseURL, n), Rel: "alternate", Type: "text/html"},
+			Published: n.PublishedAt.UTC().Format(time.RFC3339),
+			Updated:   n.LastModified().UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Value: n.BodyHTML()},
+		})
+	}
+	return f
+}
@@ -0,0 +1,196 @@
+package handlers_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+	"canvas/model"
+)
+
+// rssXML is the shape of an RSS 2.0 feed, as feed readers parse it.
+type rssXML struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string `xml:"title"`
+		Link          string `xml:"link"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		Items         []struct {
+			Title string `xml:"title"`
+			Link  string `xml:"link"`
+			GUID  struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				Value       string `xml:",chardata"`
+			} `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// atomXML is the shape of an Atom feed, as feed readers parse it.
+type atomXML struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Author  struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Entries []struct {
+		Title string `xml:"title"`
+		ID    string `xml:"id"`
+		Link  struct {
+			Href string `xml:"href,attr"`
+		} `xml:"link"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Content   struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"content"`
+	} `xml:"entry"`
+}
+
+func TestFeeds(t *testing.T) {
+	created := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+	published := time.Date(2022, 12, 10, 12, 0, 0, 0, time.FixedZone("CET", 60*60))
+	newsletters := []model.Newsletter{
+		{
+			ID:          2,
+			Title:       "Tips & <tricks>",
+			Slug:        "tips-and-tricks",
+			Body:        "Use **bold** & ]]> carefully.\n\nSecond paragraph.",
+			PublishedAt: &published,
+			Created:     created,
+			Updated:     published.Add(time.Hour),
+		},
+		{
+			ID:          1,
+			Title:       "First",
+			Slug:        "first",
+			Body:        "Hi.",
+			PublishedAt: &published,
+			Created:     created,
+			Updated:     created,
+		},
+	}
+
+	newMux := func(l *publishedNewsletterListerMock) chi.Router {
+		mux := chi.NewMux()
+		handlers.Feeds(mux, l, nil, handlers.FeedOptions{
+			Author:      "Me",
+			BaseURL:     "https://example.com/",
+			Description: "A newsletter.",
+			Title:       "Canvas",
+		})
+		return mux
+	}
+
+	t.Run("serves the RSS feed with RFC 1123 dates, stable GUIDs, and HTML in CDATA", func(t *testing.T) {
+		is := is.New(t)
+
+		l := &publishedNewsletterListerMock{newsletters: newsletters}
+		code, header, body := makeGetRequest(newMux(l), "/feed.xml")
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/rss+xml; charset=utf-8", header.Get("Content-Type"))
+		is.Equal(20, l.opts.Limit)
+		is.True(strings.Contains(body, "<![CDATA[<p>Use <strong>bold</strong> &amp; ]]"))
+		is.True(strings.Contains(body, "<title>Tips &amp; &lt;tricks&gt;</title>"))
+
+		var feed rssXML
+		is.NoErr(xml.Unmarshal([]byte(body), &feed))
+		is.Equal("2.0", feed.Version)
+		is.Equal("Canvas", feed.Channel.Title)
+		is.Equal("https://example.com/", feed.Channel.Link)
+		is.Equal("Sat, 10 Dec 2022 12:00:00 +0000", feed.Channel.LastBuildDate)
+		is.Equal(2, len(feed.Channel.Items))
+
+		item := feed.Channel.Items[0]
+		is.Equal("Tips & <tricks>", item.Title)
+		is.Equal("https://example.com/archive/tips-and-tricks", item.Link)
+		is.Equal("false", item.GUID.IsPermaLink)
+		is.Equal("tag:example.com,2022-12-01:newsletters/2", item.GUID.Value)
+		is.Equal("Sat, 10 Dec 2022 11:00:00 +0000", item.PubDate)
+		_, err := time.Parse(time.RFC1123Z, item.PubDate)
+		is.NoErr(err)
+		is.Equal("<p>Use <strong>bold</strong> &amp; ]]&gt; carefully.</p>\n<p>Second paragraph.</p>\n", item.Description)
+	})
+
+	t.Run("serves the Atom feed with RFC 3339 dates and escaped HTML content", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, body := makeGetRequest(newMux(&publishedNewsletterListerMock{newsletters: newsletters}), "/feed.atom")
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/atom+xml; charset=utf-8", header.Get("Content-Type"))
+		is.True(!strings.Contains(body, "CDATA"))
+
+		var feed atomXML
+		is.NoErr(xml.Unmarshal([]byte(body), &feed))
+		is.Equal("Canvas", feed.Title)
+		is.Equal("https://example.com/", feed.ID)
+		is.Equal("2022-12-10T12:00:00Z", feed.Updated)
+		is.Equal("Me", feed.Author.Name)
+		is.Equal(2, len(feed.Entries))
+
+		entry := feed.Entries[0]
+		is.Equal("Tips & <tricks>", entry.Title)
+		is.Equal("tag:example.com,2022-12-01:newsletters/2", entry.ID)
+		is.Equal("https://example.com/archive/tips-and-tricks", entry.Link.Href)
+		is.Equal("2022-12-10T11:00:00Z", entry.Published)
+		is.Equal("2022-12-10T12:00:00Z", entry.Updated)
+		is.Equal("html", entry.Content.Type)
+		is.Equal("<p>Use <strong>bold</strong> &amp; ]]&gt; carefully.</p>\n<p>Second paragraph.</p>\n", entry.Content.Value)
+	})
+
+	t.Run("responds with not modified to conditional requests", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&publishedNewsletterListerMock{newsletters: newsletters})
+		for _, target := range []string{"/feed.xml", "/feed.atom"} {
+			_, header, _ := makeGetRequest(mux, target)
+			is.Equal("Sat, 10 Dec 2022 12:00:00 GMT", header.Get("Last-Modified"))
+			is.True(header.Get("ETag") != "")
+
+			for name, value := range map[string]string{
+				"If-None-Match":     header.Get("ETag"),
+				"If-Modified-Since": header.Get("Last-Modified"),
+			} {
+				req := httptest.NewRequest(http.MethodGet, target, nil)
+				req.Header.Set(name, value)
+				res := httptest.NewRecorder()
+				mux.ServeHTTP(res, req)
+				is.Equal(http.StatusNotModified, res.Code)
+				is.Equal(0, res.Body.Len())
+			}
+		}
+	})
+
+	t.Run("serves an empty feed without published issues", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, body := makeGetRequest(newMux(&publishedNewsletterListerMock{}), "/feed.xml")
+		is.Equal(http.StatusOK, code)
+		is.Equal("", header.Get("Last-Modified"))
+
+		var feed rssXML
+		is.NoErr(xml.Unmarshal([]byte(body), &feed))
+		is.Equal(0, len(feed.Channel.Items))
+	})
+
+	t.Run("responds with an error if listing newsletters fails", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, _ := makeGetRequest(newMux(&publishedNewsletterListerMock{err: errors.New("oh no")}), "/feed.xml")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/flags"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/views"
+)
+
+// FeatureFlags is middleware putting the provider of feature flags in the request context, for flags.Enabled.
+// Requests have no subject, so features rolled out to a percentage of subscribers are off for them.
+func FeatureFlags(p flags.Provider) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(flags.WithProvider(r.Context(), p)))
+		})
+	}
+}
+
+// RequireFlag is middleware for the routes of a feature that's behind the flag with the name.
+// While the flag is off, the routes are not found, like they don't exist.
+func RequireFlag(name string, log *zap.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		notFound := HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			return fmt.Errorf("feature flag %v is off: %w", name, storage.ErrNotFound)
+		})
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !flags.Enabled(r.Context(), name) {
+				notFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminFlags lists the feature flags and their states at /flags, on a router mounted at /admin.
+func AdminFlags(mux chi.Router, p flags.Provider) {
+	mux.Get("/flags", HandleErrors(nil, func(w http.ResponseWriter, r *http.Request) error {
+		return render(w, http.StatusOK, views.AdminFlags(views.AdminFlagsProps{
+			CSRFToken: CSRFToken(r),
+			Flashes:   sessions.ConsumeFlashes(r.Context()),
+			Flags:     p.Flags(),
+		}))
+	}))
+}
@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/flags"
+	"canvas/handlers"
+)
+
+func newFlags(t *testing.T, environ ...string) *flags.Static {
+	t.Helper()
+	f, err := flags.NewStatic(flags.NewStaticOptions{Environ: environ})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestAdminFlags(t *testing.T) {
+	t.Run("lists the flags with their states and what set them", func(t *testing.T) {
+		is := is.New(t)
+
+		f := newFlags(t, "FEATURE_ARCHIVE=true")
+		mux := chi.NewMux()
+		mux.Route("/admin", func(r chi.Router) {
+			handlers.AdminFlags(r, f)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/admin/flags", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+
+		is.Equal(http.StatusOK, res.Code)
+		body := res.Body.String()
+		is.True(regexp.MustCompile(`<tr id="flag-archive">.*?>archive</td>.*?>on</td>.*?>env</td></tr>`).MatchString(body))
+		is.True(regexp.MustCompile(`<tr id="flag-tracking-pixel">.*?>off</td>.*?>default</td></tr>`).MatchString(body))
+	})
+}
+
+func TestRequireFlag(t *testing.T) {
+	get := func(f flags.Provider) int {
+		mux := chi.NewMux()
+		mux.Use(handlers.FeatureFlags(f))
+		mux.With(handlers.RequireFlag(flags.Archive, zap.NewNop())).Get("/archive", func(w http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest(http.MethodGet, "/archive", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("is not found while the flag is off", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(http.StatusNotFound, get(newFlags(t)))
+		is.Equal(http.StatusNotFound, get(newFlags(t, "FEATURE_ARCHIVE=false")))
+	})
+
+	t.Run("serves the route while the flag is on", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(http.StatusOK, get(newFlags(t, "FEATURE_ARCHIVE=true")))
+	})
+}
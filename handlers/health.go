@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/go-chi/chi"
 )
@@ -19,3 +20,45 @@ func Health(mux chi.Router, p pinger) {
 		}
 	})
 }
+
+// Readiness of the app for traffic, which it isn't until SetReady is called at the end of startup.
+// A nil Readiness is always ready. It's safe for concurrent use.
+type Readiness struct {
+	ready int32
+}
+
+// SetReady marks the app as ready for traffic.
+func (r *Readiness) SetReady() {
+	if r != nil {
+		atomic.StoreInt32(&r.ready, 1)
+	}
+}
+
+// Ready is true after SetReady.
+func (r *Readiness) Ready() bool {
+	return r == nil || atomic.LoadInt32(&r.ready) == 1
+}
+
+// readinessResponse is the JSON body of /ready, with the dependency that failed, if any.
+type readinessResponse struct {
+	Status     string `json:"status"`
+	Dependency string `json:"dependency,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Ready responds at /ready with 503 until rd is ready, and then like Health, so load balancers only send traffic
+// after startup, and while the database can be reached. The JSON body says which dependency failed,
+// for the healthcheck command to print.
+func Ready(mux chi.Router, rd *Readiness, p pinger) {
+	mux.Get("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !rd.Ready() {
+			writeJSON(w, http.StatusServiceUnavailable, readinessResponse{Status: "starting"})
+			return
+		}
+		if err := p.Ping(r.Context()); err != nil {
+			writeJSON(w, http.StatusBadGateway, readinessResponse{Status: "unavailable", Dependency: "database", Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, readinessResponse{Status: "ready"})
+	})
+}
@@ -40,6 +40,46 @@ func TestHealth(t *testing.T) {
 	})
 }
 
+func TestReady(t *testing.T) {
+	t.Run("returns 503 until ready, and then 200", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		rd := &handlers.Readiness{}
+		handlers.Ready(mux, rd, &pingerMock{})
+		code, _, body := makeGetRequest(mux, "/ready")
+		is.Equal(http.StatusServiceUnavailable, code)
+		is.Equal(`{"status":"starting"}`+"\n", body)
+
+		rd.SetReady()
+		code, header, body := makeGetRequest(mux, "/ready")
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/json", header.Get("Content-Type"))
+		is.Equal(`{"status":"ready"}`+"\n", body)
+	})
+
+	t.Run("returns 502 if ready but the database cannot be pinged", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		rd := &handlers.Readiness{}
+		rd.SetReady()
+		handlers.Ready(mux, rd, &pingerMock{err: errors.New("oh no")})
+		code, _, body := makeGetRequest(mux, "/ready")
+		is.Equal(http.StatusBadGateway, code)
+		is.Equal(`{"status":"unavailable","dependency":"database","error":"oh no"}`+"\n", body)
+	})
+
+	t.Run("is always ready without readiness", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.Ready(mux, nil, &pingerMock{})
+		code, _, _ := makeGetRequest(mux, "/ready")
+		is.Equal(http.StatusOK, code)
+	})
+}
+
 // makeGetRequest and returns the status code, response headers, and the body.
 func makeGetRequest(handler http.Handler, target string) (int, http.Header, string) {
 	req := httptest.NewRequest(http.MethodGet, target, nil)
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/assets"
+	"canvas/views"
+)
+
+// DefaultLoadShedKeepPaths and DefaultLoadShedKeepPrefixes of LoadShedOptions are never shed: the probes,
+// so the app isn't restarted for being busy, unsubscribing, which people must always be able to do,
+// and the static assets, which don't use the database.
+var (
+	DefaultLoadShedKeepPaths = []string{
+		"/health", "/ready", "/metrics", "/version", "/robots.txt",
+		"/newsletter/unsubscribe", "/newsletter/unsubscribe/one-click",
+	}
+	DefaultLoadShedKeepPrefixes = []string{assets.PathPrefix}
+)
+
+type poolStatser interface {
+	Stats() sql.DBStats
+}
+
+// LoadShedOptions for LoadShed.
+type LoadShedOptions struct {
+	// After is how long the pool must be saturated before requests are shed, and how long it must be fine again
+	// before they aren't anymore. Defaults to 500 milliseconds.
+	After time.Duration
+	// CheckInterval between looking at the pool stats, at most. Defaults to 100 milliseconds.
+	CheckInterval time.Duration
+	// KeepPaths are never shed. Defaults to DefaultLoadShedKeepPaths. Set it to an empty slice to shed all paths.
+	KeepPaths []string
+	// KeepPrefixes are like KeepPaths, for all paths starting with them. Defaults to DefaultLoadShedKeepPrefixes.
+	KeepPrefixes []string
+	Log          *zap.Logger
+	// MaxWait is the average wait for a connection since the last check that counts as saturated,
+	// even if not all connections are in use. Defaults to 100 milliseconds.
+	MaxWait time.Duration
+	Metrics *prometheus.Registry
+	// Now defaults to time.Now.
+	Now func() time.Time
+	// RetryAfter in seconds, in the header of the responses to shed requests. Defaults to 5.
+	RetryAfter int
+	// Stats of the database connection pool, like from *storage.Database.
+	Stats poolStatser
+}
+
+// LoadShed is middleware rejecting requests with 503 Service Unavailable and a Retry-After header,
+// before they get to wait for a database connection, while the connection pool is saturated.
+// The pool is saturated when all connections are in use, or when waiting for a connection takes longer than MaxWait
+// on average, for longer than After. Requests to the paths to keep are always let through.
+// Starting and stopping to shed are logged, and shed requests counted in app_http_shed_total.
+func LoadShed(opts LoadShedOptions) func(next http.Handler) http.Handler {
+	if opts.After <= 0 {
+		opts.After = 500 * time.Millisecond
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = 100 * time.Millisecond
+	}
+	if opts.KeepPaths == nil {
+		opts.KeepPaths = DefaultLoadShedKeepPaths
+	}
+	if opts.KeepPrefixes == nil {
+		opts.KeepPrefixes = DefaultLoadShedKeepPrefixes
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = 100 * time.Millisecond
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = 5
+	}
+
+	keepPaths := map[string]bool{}
+	for _, p := range opts.KeepPaths {
+		keepPaths[p] = true
+	}
+	keep := func(path string) bool {
+		if keepPaths[path] {
+			return true
+		}
+		for _, prefix := range opts.KeepPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	shed := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "app_http_shed_total",
+		Help: "Number of requests rejected because the database connection pool was saturated.",
+	})
+	shedding := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_http_shedding",
+		Help: "Whether requests are rejected because the database connection pool is saturated.",
+	})
+	opts.Metrics.MustRegister(shed, shedding)
+
+	s := &loadShedder{opts: opts, shed: shed, shedding: shedding}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if keep(r.URL.Path) || !s.check() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s.count()
+			w.Header().Set("Retry-After", strconv.Itoa(opts.RetryAfter))
+			err := respondError(w, r, http.StatusServiceUnavailable, views.BusyPage(r.URL.Path),
+				"We're very busy right now. Please try again in a moment.")
+			if err != nil {
+				abortResponse(opts.Log, r, err)
+			}
+		})
+	}
+}
+
+// loadShedder keeps the state of LoadShed between requests.
+type loadShedder struct {
+	opts     LoadShedOptions
+	shed     prometheus.Counter
+	shedding prometheus.Gauge
+
+	lock    sync.Mutex
+	checked time.Time
+	// last pool stats, for the waits since, if sampled.
+	last    sql.DBStats
+	sampled bool
+	// since when the pool has been saturated, or fine again while shedding.
+	since time.Time
+	// isShedding and shedCount of the requests shed since it started.
+	isShedding bool
+	shedCount  int
+}
+
+// check the pool stats, if it's been the check interval since the last time, and return whether to shed.
+func (s *loadShedder) check() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.opts.Now()
+	if now.Sub(s.checked) < s.opts.CheckInterval {
+		return s.isShedding
+	}
+	s.checked = now
+
+	stats := s.opts.Stats.Stats()
+	saturated := stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections
+	if waits := stats.WaitCount - s.last.WaitCount; waits > 0 && s.sampled {
+		averageWait := (stats.WaitDuration - s.last.WaitDuration) / time.Duration(waits)
+		saturated = saturated || averageWait >= s.opts.MaxWait
+	}
+	s.last, s.sampled = stats, true
+
+	// Changing is for when the pool is saturated while not shedding, or fine while shedding.
+	changing := saturated != s.isShedding
+	if !changing {
+		s.since = time.Time{}
+		return s.isShedding
+	}
+	if s.since.IsZero() {
+		s.since = now
+	}
+	if now.Sub(s.since) < s.opts.After {
+		return s.isShedding
+	}
+
+	s.since = time.Time{}
+	s.isShedding = saturated
+	fields := []zap.Field{
+		zap.Int("inUse", stats.InUse),
+		zap.Int("maxOpen", stats.MaxOpenConnections),
+		zap.Int64("waitCount", stats.WaitCount),
+		zap.Duration("waitDuration", stats.WaitDuration),
+	}
+	if s.isShedding {
+		s.shedding.Set(1)
+		s.opts.Log.Warn("Shedding load, the database connection pool is saturated", fields...)
+	} else {
+		s.shedding.Set(0)
+		s.opts.Log.Info("Stopped shedding load, the database connection pool recovered",
+			append(fields, zap.Int("shed", s.shedCount))...)
+		s.shedCount = 0
+	}
+	return s.isShedding
+}
+
+// count a shed request.
+func (s *loadShedder) count() {
+	s.shed.Inc()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shedCount++
+}
@@ -0,0 +1,200 @@
+package handlers_test
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+)
+
+// poolStatsMock has the stats set with set, like the ones of a connection pool with 10 connections at most.
+type poolStatsMock struct {
+	lock  sync.Mutex
+	stats sql.DBStats
+}
+
+func (p *poolStatsMock) Stats() sql.DBStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+func (p *poolStatsMock) set(inUse int, waitCount int64, waitDuration time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stats = sql.DBStats{MaxOpenConnections: 10, InUse: inUse, WaitCount: waitCount, WaitDuration: waitDuration}
+}
+
+func TestLoadShed(t *testing.T) {
+	type setup struct {
+		mux      chi.Router
+		stats    *poolStatsMock
+		logs     *observer.ObservedLogs
+		registry *prometheus.Registry
+		// advance the clock by d.
+		advance func(d time.Duration)
+	}
+
+	newSetup := func(opts handlers.LoadShedOptions) setup {
+		core, logs := observer.New(zapcore.InfoLevel)
+		registry := prometheus.NewRegistry()
+		stats := &poolStatsMock{}
+		stats.set(1, 0, 0)
+		now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		opts.Log = zap.New(core)
+		opts.Metrics = registry
+		opts.Now = func() time.Time { return now }
+		opts.Stats = stats
+
+		mux := chi.NewMux()
+		mux.Use(handlers.LoadShed(opts))
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		mux.Get("/", ok)
+		mux.Get("/archive", ok)
+		mux.Get("/health", ok)
+		mux.Get("/newsletter/unsubscribe", ok)
+		mux.Get("/static/*", ok)
+
+		return setup{mux: mux, stats: stats, logs: logs, registry: registry, advance: func(d time.Duration) {
+			now = now.Add(d)
+		}}
+	}
+
+	get := func(mux chi.Router, target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		return res
+	}
+
+	// codes of requests to the paths.
+	codes := func(mux chi.Router, paths ...string) map[string]int {
+		codes := map[string]int{}
+		for _, p := range paths {
+			codes[p] = get(mux, p).Code
+		}
+		return codes
+	}
+
+	t.Run("sheds all but the paths to keep while the pool is saturated, and stops after it recovers", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup(handlers.LoadShedOptions{})
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+
+		// Saturated, but not for long enough yet.
+		s.stats.set(10, 0, 0)
+		s.advance(100 * time.Millisecond)
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+		s.advance(300 * time.Millisecond)
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+
+		s.advance(200 * time.Millisecond)
+		res := get(s.mux, "/archive")
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.Equal("5", res.Header().Get("Retry-After"))
+		is.True(strings.Contains(res.Body.String(), "Very busy right now"))
+		is.Equal(map[string]int{
+			"/":                       http.StatusServiceUnavailable,
+			"/health":                 http.StatusOK,
+			"/newsletter/unsubscribe": http.StatusOK,
+			"/static/app.css":         http.StatusOK,
+		}, codes(s.mux, "/", "/health", "/newsletter/unsubscribe", "/static/app.css"))
+
+		// Recovered, but not for long enough yet.
+		s.stats.set(2, 0, 0)
+		s.advance(100 * time.Millisecond)
+		is.Equal(http.StatusServiceUnavailable, get(s.mux, "/").Code)
+		s.advance(300 * time.Millisecond)
+		is.Equal(http.StatusServiceUnavailable, get(s.mux, "/").Code)
+
+		s.advance(200 * time.Millisecond)
+		is.Equal(map[string]int{"/": http.StatusOK, "/archive": http.StatusOK}, codes(s.mux, "/", "/archive"))
+
+		warnings := s.logs.FilterMessage("Shedding load, the database connection pool is saturated").All()
+		is.Equal(1, len(warnings))
+		is.Equal(zapcore.WarnLevel, warnings[0].Level)
+		is.Equal(int64(10), warnings[0].ContextMap()["inUse"])
+		recoveries := s.logs.FilterMessage("Stopped shedding load, the database connection pool recovered").All()
+		is.Equal(1, len(recoveries))
+		is.Equal(int64(4), recoveries[0].ContextMap()["shed"])
+
+		err := testutil.GatherAndCompare(s.registry, strings.NewReader(`
+# HELP app_http_shed_total Number of requests rejected because the database connection pool was saturated.
+# TYPE app_http_shed_total counter
+app_http_shed_total 4
+# HELP app_http_shedding Whether requests are rejected because the database connection pool is saturated.
+# TYPE app_http_shedding gauge
+app_http_shedding 0
+`), "app_http_shed_total", "app_http_shedding")
+		is.NoErr(err)
+	})
+
+	t.Run("sheds while waiting for connections takes too long on average, with connections left", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup(handlers.LoadShedOptions{After: 200 * time.Millisecond})
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+
+		// 10 waits of 50 milliseconds each are fine.
+		s.stats.set(8, 10, 500*time.Millisecond)
+		s.advance(100 * time.Millisecond)
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+
+		// Then 10 more waits of 200 milliseconds each, in every check.
+		for i := 2; i <= 4; i++ {
+			s.stats.set(8, int64(i*10), 500*time.Millisecond+time.Duration(i-1)*2*time.Second)
+			s.advance(100 * time.Millisecond)
+			get(s.mux, "/")
+		}
+		is.Equal(http.StatusServiceUnavailable, get(s.mux, "/").Code)
+
+		// No more waits.
+		s.advance(100 * time.Millisecond)
+		is.Equal(http.StatusServiceUnavailable, get(s.mux, "/").Code)
+		s.advance(200 * time.Millisecond)
+		is.Equal(http.StatusOK, get(s.mux, "/").Code)
+	})
+
+	t.Run("sheds all paths without paths to keep", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup(handlers.LoadShedOptions{KeepPaths: []string{}, KeepPrefixes: []string{}})
+		s.stats.set(10, 0, 0)
+		get(s.mux, "/")
+		s.advance(time.Second)
+
+		is.Equal(map[string]int{"/health": http.StatusServiceUnavailable, "/static/app.css": http.StatusServiceUnavailable},
+			codes(s.mux, "/health", "/static/app.css"))
+	})
+
+	t.Run("responds with a problem to requests for JSON", func(t *testing.T) {
+		is := is.New(t)
+
+		s := newSetup(handlers.LoadShedOptions{})
+		s.stats.set(10, 0, 0)
+		get(s.mux, "/")
+		s.advance(time.Second)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", "application/json")
+		res := httptest.NewRecorder()
+		s.mux.ServeHTTP(res, req)
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.Equal("application/problem+json", res.Header().Get("Content-Type"))
+		is.Equal("5", res.Header().Get("Retry-After"))
+	})
+}
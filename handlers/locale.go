@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/i18n"
+	"canvas/views"
+)
+
+// Localize is middleware resolving the locale of each request from the locale cookie and the Accept-Language header,
+// and putting a translator for it in the request context, for i18n.FromContext.
+// The catalog is loaded for every request, which only parses anything with an i18n.Reloader in development.
+// If loading fails, the error is shown with the file and line of the problem, instead of the page.
+func Localize(l i18n.Loader, log *zap.Logger) func(next http.Handler) http.Handler {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := l.Load()
+			if err != nil {
+				HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+					logError(log, r, fmt.Errorf("error loading translations: %w", err))
+					return render(w, http.StatusInternalServerError, views.DevErrorPage(r.URL.Path, err.Error()))
+				})(w, r)
+				return
+			}
+			t := c.Translator(c.Resolve(r))
+			w.Header().Set("Content-Language", t.Locale())
+			w.Header().Add("Vary", "Accept-Language")
+			next.ServeHTTP(w, r.WithContext(i18n.WithTranslator(r.Context(), t)))
+		})
+	}
+}
+
+// SetLocale at /locale?locale=fr&redirect=/archive stores the picked locale in a cookie, and redirects back.
+// Unknown locales are ignored. Only redirects to paths on this site are allowed, and others go to the front page.
+func SetLocale(mux chi.Router, l i18n.Loader) {
+	mux.Get("/locale", func(w http.ResponseWriter, r *http.Request) {
+		c, err := l.Load()
+		if locale := r.URL.Query().Get("locale"); err == nil && c.Has(locale) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     i18n.CookieName,
+				Value:    locale,
+				Path:     "/",
+				Expires:  time.Now().Add(365 * 24 * time.Hour),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		redirect := r.URL.Query().Get("redirect")
+		if !isLocalPath(redirect) {
+			redirect = "/"
+		}
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+}
+
+// isLocalPath is true for absolute paths on this site, and false for anything that could lead to another site,
+// like "//example.com" or "/\example.com".
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
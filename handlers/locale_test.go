@@ -0,0 +1,156 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+	"canvas/i18n"
+)
+
+func TestLocalize(t *testing.T) {
+	getFront := func(c *i18n.Catalog, header http.Header, cookie *http.Cookie) (http.Header, string) {
+		mux := chi.NewMux()
+		mux.Use(handlers.Localize(c, nil))
+		handlers.FrontPage(mux, nil, []byte("secret"), "https://example.com", nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Header(), res.Body.String()
+	}
+
+	t.Run("renders the front page in French from Accept-Language", func(t *testing.T) {
+		is := is.New(t)
+
+		header, body := getFront(i18n.Default(), http.Header{"Accept-Language": {"fr-CH, fr;q=0.9, en;q=0.8"}}, nil)
+		is.Equal("fr", header.Get("Content-Language"))
+		is.Equal("Accept-Language", header.Get("Vary"))
+		is.True(strings.Contains(body, `<html lang="fr">`))
+		is.True(strings.Contains(body, "Des solutions à vos problèmes."))
+		is.True(strings.Contains(body, "S&#39;inscrire"))
+		is.True(!strings.Contains(body, "Solutions to problems."))
+	})
+
+	t.Run("renders the front page in German from the cookie, over Accept-Language", func(t *testing.T) {
+		is := is.New(t)
+
+		_, body := getFront(i18n.Default(), http.Header{"Accept-Language": {"fr"}}, &http.Cookie{Name: i18n.CookieName, Value: "de"})
+		is.True(strings.Contains(body, `<html lang="de">`))
+		is.True(strings.Contains(body, "Lösungen für Probleme."))
+	})
+
+	t.Run("renders the front page in English by default, with links to the other languages", func(t *testing.T) {
+		is := is.New(t)
+
+		_, body := getFront(i18n.Default(), nil, nil)
+		is.True(strings.Contains(body, `<html lang="en">`))
+		is.True(strings.Contains(body, "Solutions to problems."))
+		is.True(strings.Contains(body, `href="/locale?locale=fr&amp;redirect=%2F"`))
+		is.True(strings.Contains(body, "Français"))
+		is.True(strings.Contains(body, "Deutsch"))
+	})
+
+	t.Run("falls back to English for a missing translation, and logs it once", func(t *testing.T) {
+		is := is.New(t)
+
+		read := func(locale string) map[string]json.RawMessage {
+			b, err := fs.ReadFile(i18n.Embedded(), locale+".json")
+			is.NoErr(err)
+			var messages map[string]json.RawMessage
+			is.NoErr(json.Unmarshal(b, &messages))
+			return messages
+		}
+		french := read("fr")
+		delete(french, "front.heading")
+		frenchJSON, err := json.Marshal(french)
+		is.NoErr(err)
+		englishJSON, err := fs.ReadFile(i18n.Embedded(), "en.json")
+		is.NoErr(err)
+
+		core, logs := observer.New(zap.InfoLevel)
+		c, err := i18n.New(fstest.MapFS{
+			"en.json": {Data: englishJSON},
+			"fr.json": {Data: frenchJSON},
+		}, zap.New(core))
+		is.NoErr(err)
+
+		for i := 0; i < 2; i++ {
+			_, body := getFront(c, http.Header{"Accept-Language": {"fr"}}, nil)
+			is.True(strings.Contains(body, `<html lang="fr">`))
+			is.True(strings.Contains(body, "Solutions to problems."))
+			is.True(strings.Contains(body, "Vous avez des problèmes ?"))
+		}
+
+		entries := logs.FilterMessage("Missing translation").All()
+		is.Equal(1, len(entries))
+		is.Equal("front.heading", entries[0].ContextMap()["key"])
+	})
+
+	t.Run("shows the error with the file and line if translations don't load", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		is.NoErr(os.WriteFile(filepath.Join(dir, "en.json"), []byte("{\n  \"front.heading\": \"Hi\",\n}"), 0644))
+
+		mux := chi.NewMux()
+		mux.Use(handlers.Localize(i18n.NewReloader(os.DirFS(dir), nil), nil))
+		handlers.FrontPage(mux, nil, []byte("secret"), "https://example.com", nil)
+
+		code, _, body := makeGetRequest(mux, "/")
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "error parsing messages in en.json:3:1"))
+	})
+}
+
+func TestSetLocale(t *testing.T) {
+	mux := chi.NewMux()
+	handlers.SetLocale(mux, i18n.Default())
+
+	t.Run("sets the locale cookie and redirects back", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(mux, "/locale?locale=fr&redirect=%2Farchive")
+		is.Equal(http.StatusFound, code)
+		is.Equal("/archive", header.Get("Location"))
+		cookies := (&http.Response{Header: header}).Cookies()
+		is.Equal(1, len(cookies))
+		is.Equal(i18n.CookieName, cookies[0].Name)
+		is.Equal("fr", cookies[0].Value)
+		is.Equal("/", cookies[0].Path)
+	})
+
+	t.Run("does not set the cookie for an unknown locale", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(mux, "/locale?locale=xx&redirect=%2F")
+		is.Equal(http.StatusFound, code)
+		is.Equal(0, len((&http.Response{Header: header}).Cookies()))
+	})
+
+	t.Run("redirects to the front page instead of other sites", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, redirect := range []string{"https://example.com", "//example.com", "/%5Cexample.com", ""} {
+			_, header, _ := makeGetRequest(mux, "/locale?locale=de&redirect="+redirect)
+			is.Equal("/", header.Get("Location"))
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevelOptions for NewLogLevel.
+type LogLevelOptions struct {
+	// RevertAfter is how long a changed level lasts before it's set back to the level the logger started with.
+	// Without it, changes last until the next one.
+	RevertAfter time.Duration
+	// AfterFunc calls f after d, and returns a function that stops it from being called. Defaults to time.AfterFunc.
+	AfterFunc func(d time.Duration, f func()) (stop func() bool)
+	// Now is for the time of reverting. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// LogLevel changes the level of a logger at runtime, for all components sharing it.
+// The level it started with is the base level, which changed levels revert to after RevertAfter,
+// so debug logging isn't left on by mistake.
+type LogLevel struct {
+	level    zap.AtomicLevel
+	base     zapcore.Level
+	log      *zap.Logger
+	opts     LogLevelOptions
+	lock     sync.Mutex
+	stop     func() bool
+	revertAt time.Time
+	// changes counts level changes, so a revert that was stopped too late for a newer change doesn't undo it.
+	changes int
+}
+
+// NewLogLevel for the atomic level of the logger, with its current level as the base level.
+func NewLogLevel(level zap.AtomicLevel, log *zap.Logger, opts LogLevelOptions) *LogLevel {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.AfterFunc == nil {
+		opts.AfterFunc = func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &LogLevel{level: level, base: level.Level(), log: log, opts: opts}
+}
+
+// Level right now, and when it reverts to the base level, or the zero time if it doesn't.
+func (l *LogLevel) Level() (zapcore.Level, time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.level.Level(), l.revertAt
+}
+
+// SetLevel right away, replacing any pending revert. Levels other than the base level revert after RevertAfter.
+func (l *LogLevel) SetLevel(level zapcore.Level) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stop != nil {
+		l.stop()
+		l.stop = nil
+	}
+	l.revertAt = time.Time{}
+
+	from := l.level.Level()
+	l.level.SetLevel(level)
+	l.changes++
+
+	if level != l.base && l.opts.RevertAfter > 0 {
+		l.revertAt = l.opts.Now().Add(l.opts.RevertAfter)
+		changes := l.changes
+		l.stop = l.opts.AfterFunc(l.opts.RevertAfter, func() {
+			l.revert(changes)
+		})
+	}
+
+	// Logged at warn level, so the change shows up at all but the error levels.
+	l.log.Warn("Changed log level", zap.Stringer("from", from), zap.Stringer("to", level),
+		zap.Duration("revertAfter", untilOrZero(l.revertAt, l.opts.Now())))
+}
+
+// ToggleDebug between the debug level and the base level, or info if that's the base level.
+func (l *LogLevel) ToggleDebug() {
+	level, _ := l.Level()
+	if level == zapcore.DebugLevel {
+		if l.base == zapcore.DebugLevel {
+			l.SetLevel(zapcore.InfoLevel)
+			return
+		}
+		l.SetLevel(l.base)
+		return
+	}
+	l.SetLevel(zapcore.DebugLevel)
+}
+
+// revert to the base level, if the level hasn't changed since the given number of changes.
+func (l *LogLevel) revert(changes int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if changes != l.changes {
+		return
+	}
+	l.stop = nil
+	l.revertAt = time.Time{}
+
+	from := l.level.Level()
+	l.level.SetLevel(l.base)
+	l.log.Warn("Reverted log level", zap.Stringer("from", from), zap.Stringer("to", l.base))
+}
+
+func untilOrZero(t, now time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Sub(now)
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level    string     `json:"level,omitempty"`
+	RevertAt *time.Time `json:"revertAt,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// AdminLogLevel gets the log level with GET /log-level, and sets it with PUT /log-level and the JSON body
+// {"level": "debug"}, on a router mounted at /admin. Both respond with the level, and when it reverts.
+// Invalid levels get 422 Unprocessable Entity.
+func AdminLogLevel(mux chi.Router, l *LogLevel) {
+	respondLevel := func(w http.ResponseWriter) {
+		level, revertAt := l.Level()
+		res := logLevelResponse{Level: level.String()}
+		if !revertAt.IsZero() {
+			res.RevertAt = &revertAt
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+
+	mux.Get("/log-level", func(w http.ResponseWriter, r *http.Request) {
+		respondLevel(w)
+	})
+
+	mux.Put("/log-level", func(w http.ResponseWriter, r *http.Request) {
+		var req logLevelRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, logLevelResponse{Error: "The request body isn't valid JSON."})
+			return
+		}
+
+		level, err := zapcore.ParseLevel(strings.ToLower(req.Level))
+		if err != nil || req.Level == "" {
+			writeJSON(w, http.StatusUnprocessableEntity, logLevelResponse{
+				Error: "The level must be debug, info, warn, error, dpanic, panic, or fatal."})
+			return
+		}
+
+		l.SetLevel(level)
+		respondLevel(w)
+	})
+}
@@ -0,0 +1,153 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"canvas/handlers"
+)
+
+// fakeTimer records the function passed to AfterFunc, to call it when the test says so.
+type fakeTimer struct {
+	d       time.Duration
+	f       func()
+	stopped int
+}
+
+func (t *fakeTimer) AfterFunc(d time.Duration, f func()) func() bool {
+	t.d, t.f = d, f
+	return func() bool {
+		t.stopped++
+		return true
+	}
+}
+
+func newLogLevel(level zapcore.Level, revertAfter time.Duration) (*handlers.LogLevel, zap.AtomicLevel, *fakeTimer) {
+	l := zap.NewAtomicLevelAt(level)
+	timer := &fakeTimer{}
+	now := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+	return handlers.NewLogLevel(l, zap.NewNop(), handlers.LogLevelOptions{
+		RevertAfter: revertAfter,
+		AfterFunc:   timer.AfterFunc,
+		Now:         func() time.Time { return now },
+	}), l, timer
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Run("sets the level right away and reverts it to the base level after a while", func(t *testing.T) {
+		is := is.New(t)
+
+		l, level, timer := newLogLevel(zapcore.InfoLevel, time.Minute)
+		l.SetLevel(zapcore.DebugLevel)
+		is.Equal(zapcore.DebugLevel, level.Level())
+		is.Equal(time.Minute, timer.d)
+		_, revertAt := l.Level()
+		is.Equal(time.Date(2022, 12, 24, 8, 1, 0, 0, time.UTC), revertAt)
+
+		timer.f()
+		is.Equal(zapcore.InfoLevel, level.Level())
+		_, revertAt = l.Level()
+		is.True(revertAt.IsZero())
+	})
+
+	t.Run("replaces a pending revert when the level is set again", func(t *testing.T) {
+		is := is.New(t)
+
+		l, level, timer := newLogLevel(zapcore.InfoLevel, time.Minute)
+		l.SetLevel(zapcore.DebugLevel)
+		first := timer.f
+		l.SetLevel(zapcore.WarnLevel)
+		is.Equal(1, timer.stopped)
+
+		// The stopped revert can still fire if it was already running, and mustn't undo the new level.
+		first()
+		timer.f()
+		is.Equal(zapcore.InfoLevel, level.Level())
+		l.SetLevel(zapcore.ErrorLevel)
+		first()
+		is.Equal(zapcore.ErrorLevel, level.Level())
+	})
+
+	t.Run("doesn't revert the base level, or without a revert duration", func(t *testing.T) {
+		is := is.New(t)
+
+		l, _, timer := newLogLevel(zapcore.InfoLevel, time.Minute)
+		l.SetLevel(zapcore.InfoLevel)
+		is.True(timer.f == nil)
+
+		l, level, timer := newLogLevel(zapcore.InfoLevel, 0)
+		l.SetLevel(zapcore.DebugLevel)
+		is.True(timer.f == nil)
+		is.Equal(zapcore.DebugLevel, level.Level())
+	})
+
+	t.Run("toggles between debug and the base level", func(t *testing.T) {
+		is := is.New(t)
+
+		l, level, _ := newLogLevel(zapcore.WarnLevel, 0)
+		l.ToggleDebug()
+		is.Equal(zapcore.DebugLevel, level.Level())
+		l.ToggleDebug()
+		is.Equal(zapcore.WarnLevel, level.Level())
+
+		l, level, _ = newLogLevel(zapcore.DebugLevel, 0)
+		l.ToggleDebug()
+		is.Equal(zapcore.InfoLevel, level.Level())
+	})
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	newMux := func() (chi.Router, zap.AtomicLevel) {
+		l, level, _ := newLogLevel(zapcore.InfoLevel, time.Minute)
+		mux := chi.NewMux()
+		handlers.AdminLogLevel(mux, l)
+		return mux, level
+	}
+
+	put := func(mux chi.Router, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res
+	}
+
+	t.Run("gets the current level", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, _ := newMux()
+		code, _, body := makeGetRequest(mux, "/log-level")
+		is.Equal(http.StatusOK, code)
+		is.Equal(`{"level":"info"}`+"\n", body)
+	})
+
+	t.Run("sets the level, in any case, and says when it reverts", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, level := newMux()
+		res := put(mux, `{"level":"DEBUG"}`)
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal(`{"level":"debug","revertAt":"2022-12-24T08:01:00Z"}`+"\n", res.Body.String())
+		is.Equal(zapcore.DebugLevel, level.Level())
+	})
+
+	t.Run("rejects invalid levels and bodies", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, level := newMux()
+		for _, body := range []string{`{"level":"verbose"}`, `{"level":""}`, `{}`} {
+			res := put(mux, body)
+			is.Equal(http.StatusUnprocessableEntity, res.Code)
+			is.True(strings.Contains(res.Body.String(), "The level must be"))
+		}
+		is.Equal(http.StatusBadRequest, put(mux, `nope`).Code)
+		is.Equal(zapcore.InfoLevel, level.Level())
+	})
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// MaxBytes is middleware limiting request bodies to n bytes. Reading past the limit is an error,
+// and the connection is closed after the response. Requests with a longer Content-Length get a 413 Request Entity Too Large
+// without reading the body. Use it before any middleware reading the body, like MethodOverride.
+func MaxBytes(n int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
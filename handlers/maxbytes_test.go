@@ -0,0 +1,67 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestMaxBytes(t *testing.T) {
+	mux := chi.NewMux()
+	mux.Use(handlers.MaxBytes(8))
+	mux.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		_, _ = w.Write(body)
+	})
+
+	t.Run("passes bodies up to the limit", func(t *testing.T) {
+		is := is.New(t)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("12345678")))
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("12345678", res.Body.String())
+	})
+
+	t.Run("responds with 413 Request Entity Too Large to a longer Content-Length", func(t *testing.T) {
+		is := is.New(t)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("123456789")))
+		is.Equal(http.StatusRequestEntityTooLarge, res.Code)
+	})
+
+	t.Run("errors reading past the limit without a Content-Length", func(t *testing.T) {
+		is := is.New(t)
+		req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("123456789")))
+		req.ContentLength = -1
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusRequestEntityTooLarge, res.Code)
+		is.True(strings.Contains(res.Body.String(), "request body too large"))
+	})
+
+	t.Run("limits the form the method override reads", func(t *testing.T) {
+		is := is.New(t)
+		mux := chi.NewMux()
+		mux.Use(handlers.MaxBytes(8), handlers.MethodOverride)
+		mux.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Method))
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader("_method=DELETE")))
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal("POST", res.Body.String())
+	})
+}
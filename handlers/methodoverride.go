@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"canvas/views"
+)
+
+// MethodOverrideHeaderName is the request header with the method to use instead of POST, like the form field.
+const MethodOverrideHeaderName = "X-HTTP-Method-Override"
+
+// MethodOverride is middleware letting HTML forms use the methods DELETE, PATCH, and PUT, which browsers can't submit.
+// POST requests with a form body and the method in the views.MethodFieldName field or the MethodOverrideHeaderName
+// header are routed with that method instead. Other methods are ignored, and so are requests that aren't forms, like JSON.
+//
+// It reads URL-encoded forms, which stay parsed for the handlers. Multipart forms aren't parsed, so handlers can stream
+// their files, and the method field has to be in front of any file in them. Use it before routing, so the overridden
+// method is matched, either on the mux or on a mounted router, and after MaxBytes, so the form it reads is limited.
+func MethodOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !isForm(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The form is parsed before the method changes, because it's only parsed from the body for some methods.
+		var method string
+		if isMultipart(r) {
+			method = peekMultipartField(r, views.MethodFieldName)
+		} else {
+			method = r.PostFormValue(views.MethodFieldName)
+		}
+		if header := r.Header.Get(MethodOverrideHeaderName); header != "" {
+			method = header
+		}
+		switch method = strings.ToUpper(method); method {
+		case http.MethodDelete, http.MethodPatch, http.MethodPut:
+			r = r.WithContext(r.Context())
+			r.Method = method
+			// A parent mux has already routed with the method of the request, which mounted routers keep using.
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RouteMethod != "" {
+				rctx.RouteMethod = method
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isForm is true for requests with a URL-encoded or multipart form body.
+func isForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data")
+}
@@ -0,0 +1,135 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+	"canvas/views"
+)
+
+func TestMethodOverride(t *testing.T) {
+	mux := chi.NewMux()
+	mux.Use(handlers.MethodOverride)
+	for _, method := range []string{http.MethodPost, http.MethodDelete, http.MethodPatch, http.MethodPut} {
+		mux.MethodFunc(method, "/things/1", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Method + " " + r.PostFormValue("name")))
+		})
+	}
+
+	request := func(contentType, body string, header http.Header) string {
+		req := httptest.NewRequest(http.MethodPost, "/things/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range header {
+			req.Header.Set(k, v[0])
+		}
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Body.String()
+	}
+	formBody := func(method string) string {
+		return url.Values{views.MethodFieldName: {method}, "name": {"Thing"}}.Encode()
+	}
+	const formType = "application/x-www-form-urlencoded"
+
+	t.Run("routes a form with the method field as DELETE, with the form still readable", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("DELETE Thing", request(formType, formBody("DELETE"), nil))
+	})
+
+	t.Run("routes a form as PUT and PATCH, in any case", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("PUT Thing", request(formType, formBody("put"), nil))
+		is.Equal("PATCH Thing", request(formType, formBody("Patch"), nil))
+	})
+
+	t.Run("routes a form with the header, which takes precedence over the field", func(t *testing.T) {
+		is := is.New(t)
+		header := http.Header{handlers.MethodOverrideHeaderName: {"DELETE"}}
+		is.Equal("DELETE Thing", request(formType, formBody("PUT"), header))
+		is.Equal("DELETE Thing", request(formType, "name=Thing", header))
+	})
+
+	t.Run("routes a multipart form", func(t *testing.T) {
+		is := is.New(t)
+		body := "--b\r\nContent-Disposition: form-data; name=\"_method\"\r\n\r\nDELETE\r\n" +
+			"--b\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nThing\r\n--b--\r\n"
+		is.Equal("DELETE Thing", request("multipart/form-data; boundary=b", body, nil))
+	})
+
+	t.Run("routes a multipart form with a file without parsing it, so the handler can stream it", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		mux.Use(handlers.MethodOverride)
+		mux.Put("/things/1", func(w http.ResponseWriter, r *http.Request) {
+			is.True(r.MultipartForm == nil)
+			mr, err := r.MultipartReader()
+			is.NoErr(err)
+			for p, err := mr.NextPart(); err == nil; p, err = mr.NextPart() {
+				if p.FileName() != "" {
+					_, _ = io.Copy(w, p)
+				}
+			}
+		})
+
+		body := "--b\r\nContent-Disposition: form-data; name=\"_method\"\r\n\r\nPUT\r\n" +
+			"--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"things.csv\"\r\n\r\nname\r\nThing\r\n--b--\r\n"
+		req := httptest.NewRequest(http.MethodPost, "/things/1", strings.NewReader(body))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("name\r\nThing", res.Body.String())
+	})
+
+	t.Run("routes with the method in a mounted router", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		mux.Route("/things", func(r chi.Router) {
+			r.Use(handlers.MethodOverride)
+			r.Delete("/1", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(r.Method))
+			})
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/things/1", strings.NewReader(formBody("DELETE")))
+		req.Header.Set("Content-Type", formType)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("DELETE", res.Body.String())
+	})
+
+	t.Run("ignores methods that aren't allowed", func(t *testing.T) {
+		is := is.New(t)
+		for _, method := range []string{"GET", "HEAD", "CONNECT", "OPTIONS", "TRACE", "nope", ""} {
+			is.Equal("POST Thing", request(formType, formBody(method), nil))
+		}
+		is.Equal("POST Thing", request(formType, "name=Thing", http.Header{handlers.MethodOverrideHeaderName: {"GET"}}))
+	})
+
+	t.Run("ignores JSON requests", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("POST ", request("application/json", `{"_method": "DELETE"}`,
+			http.Header{handlers.MethodOverrideHeaderName: {"DELETE"}}))
+	})
+
+	t.Run("ignores methods other than POST", func(t *testing.T) {
+		is := is.New(t)
+		req := httptest.NewRequest(http.MethodPut, "/things/1?_method=DELETE", strings.NewReader("name=Thing"))
+		req.Header.Set("Content-Type", formType)
+		req.Header.Set(handlers.MethodOverrideHeaderName, "DELETE")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal("PUT Thing", res.Body.String())
+	})
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposes the metrics in the registry in the Prometheus text format.
+func Metrics(mux chi.Router, g prometheus.Gatherer) {
+	mux.Get("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP)
+}
@@ -0,0 +1,30 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"canvas/handlers"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("returns metrics from the registry", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		c := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_test_total"})
+		registry.MustRegister(c)
+		c.Inc()
+
+		mux := chi.NewMux()
+		handlers.Metrics(mux, registry)
+		code, _, body := makeGetRequest(mux, "/metrics")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "app_test_total 1"))
+	})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// multipartPeekBytes is how much of a multipart form body peekMultipartField reads at most.
+const multipartPeekBytes = 64 << 10
+
+// multipartBody is the request body after peekMultipartField, with what it read in front of the rest.
+type multipartBody struct {
+	io.Reader
+	io.Closer
+}
+
+// peekMultipartField value of the multipart form in the request body, without parsing the whole form like
+// r.PostFormValue does, so the handler can still stream the files with r.MultipartReader.
+// Only the fields in front of the first file are looked at, like the hidden fields at the start of a form,
+// and the part of the body read for them is put back in front of the rest. Returns empty if the field isn't there.
+func peekMultipartField(r *http.Request, name string) string {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return ""
+	}
+
+	var read bytes.Buffer
+	body := r.Body
+	defer func() {
+		r.Body = multipartBody{Reader: io.MultiReader(&read, body), Closer: body}
+	}()
+
+	mr := multipart.NewReader(io.TeeReader(io.LimitReader(body, multipartPeekBytes), &read), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil || p.FileName() != "" {
+			return ""
+		}
+		if p.FormName() == name {
+			value, err := io.ReadAll(io.LimitReader(p, 1024))
+			if err != nil {
+				return ""
+			}
+			return string(value)
+		}
+	}
+}
+
+// isMultipart is true for requests with a multipart form body.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
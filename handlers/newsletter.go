@@ -2,53 +2,145 @@ package handlers
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi"
+	"go.uber.org/zap"
 
+	"canvas/email"
+	"canvas/i18n"
 	"canvas/model"
 	"canvas/views"
 )
 
-type signupper interface {
-	SignupForNewsletter(ctx context.Context, email model.Email) (string, error)
+// NewsletterThanks page after signing up, in the locale of the request, with a link to resend the confirmation email.
+func NewsletterThanks(mux chi.Router, log *zap.Logger) {
+	mux.Get("/newsletter/thanks", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		return render(w, http.StatusOK, views.NewsletterThanksPage(i18n.FromContext(r.Context()), "/newsletter/thanks", confirmationValidDays))
+	}))
 }
 
-type sender interface {
-	Send(ctx context.Context, m model.Message) error
+type confirmer interface {
+	ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error)
 }
 
-func NewsletterSignup(mux chi.Router, s signupper, q sender) {
-	mux.Post("/newsletter/signup", func(w http.ResponseWriter, r *http.Request) {
-		email := model.Email(r.FormValue("email"))
+// NewsletterConfirmOptions for NewsletterConfirm.
+type NewsletterConfirmOptions struct {
+	// TwoStep makes the link show a page with a confirm button, and the confirmation happen on submit.
+	// This keeps mail scanners that follow links from confirming signups.
+	TwoStep bool
+}
 
-		if !email.IsValid() {
-			http.Error(w, "email is invalid", http.StatusBadRequest)
-			return
+// NewsletterConfirm confirms the signup with the token from the confirmation email link.
+// Bad tokens get a 4xx status code, so they can be told apart from successful confirmations in monitoring.
+// Responses are HTML or JSON, depending on what the request asks for.
+func NewsletterConfirm(mux chi.Router, c confirmer, log *zap.Logger, opts NewsletterConfirmOptions) {
+	invalid := func(w http.ResponseWriter, r *http.Request) error {
+		return respondError(w, r, http.StatusBadRequest, views.NewsletterConfirmFailedPage("/newsletter/confirm", false),
+			"The confirmation token is missing.")
+	}
+
+	confirm := HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		token := r.FormValue("token")
+		if token == "" {
+			return invalid(w, r)
+		}
+
+		result, err := c.ConfirmNewsletterSignup(r.Context(), token)
+		if err != nil {
+			return fmt.Errorf("error confirming newsletter signup: %w", err)
+		}
+
+		switch result {
+		case model.ConfirmationResultConfirmed:
+			return respond(w, r, http.StatusOK, views.NewsletterConfirmedPage("/newsletter/confirm", false), statusResponse{Status: string(result)})
+		case model.ConfirmationResultAlreadyConfirmed:
+			return respond(w, r, http.StatusOK, views.NewsletterConfirmedPage("/newsletter/confirm", true), statusResponse{Status: string(result)})
+		case model.ConfirmationResultExpired:
+			return respondError(w, r, http.StatusGone, views.NewsletterConfirmFailedPage("/newsletter/confirm", true),
+				"The confirmation token has expired.")
+		default:
+			return respondError(w, r, http.StatusNotFound, views.NewsletterConfirmFailedPage("/newsletter/confirm", false),
+				"The confirmation token is not valid.")
+		}
+	})
+
+	if !opts.TwoStep {
+		mux.Get("/newsletter/confirm", confirm)
+		return
+	}
+
+	mux.Get("/newsletter/confirm", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			return invalid(w, r)
+		}
+		return respond(w, r, http.StatusOK, views.NewsletterConfirmPage("/newsletter/confirm", CSRFToken(r), token), statusResponse{Status: "pending"})
+	}))
+	mux.Post("/newsletter/confirm", confirm)
+}
+
+type unsubscriber interface {
+	Unsubscribe(ctx context.Context, email model.Email) error
+}
+
+// NewsletterUnsubscribe unsubscribes with the signed token from the unsubscribe link in newsletter emails.
+// Following the link shows a page with an unsubscribe button, and the unsubscribe happens on submit.
+// Tokens are verified with secret. Invalid tokens get the same response whether or not the address is subscribed.
+// Responses are HTML or JSON, depending on what the request asks for.
+func NewsletterUnsubscribe(mux chi.Router, u unsubscriber, log *zap.Logger, secret []byte) {
+	invalid := func(w http.ResponseWriter, r *http.Request) error {
+		return respondError(w, r, http.StatusBadRequest, views.NewsletterUnsubscribeFailedPage("/newsletter/unsubscribe"),
+			"The unsubscribe token is not valid.")
+	}
+
+	mux.Get("/newsletter/unsubscribe", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		token := r.URL.Query().Get("token")
+		if _, err := email.VerifyUnsubscribeToken(secret, token); err != nil {
+			return invalid(w, r)
 		}
+		return respond(w, r, http.StatusOK, views.NewsletterUnsubscribePage("/newsletter/unsubscribe", CSRFToken(r), token),
+			statusResponse{Status: "pending"})
+	}))
 
-		token, err := s.SignupForNewsletter(r.Context(), email)
+	mux.Post("/newsletter/unsubscribe", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		to, err := email.VerifyUnsubscribeToken(secret, r.FormValue("token"))
 		if err != nil {
-			http.Error(w, "error signing up, refresh to try again", http.StatusBadGateway)
+			return invalid(w, r)
+		}
+
+		if err := u.Unsubscribe(r.Context(), to); err != nil {
+			return fmt.Errorf("error unsubscribing from newsletter: %w", err)
+		}
+
+		return respond(w, r, http.StatusOK, views.NewsletterUnsubscribedPage("/newsletter/unsubscribe"), statusResponse{Status: "unsubscribed"})
+	}))
+}
+
+// NewsletterUnsubscribeOneClick unsubscribes right away with the signed token in the URL, as described in RFC 8058.
+// Mail clients POST to it from the List-Unsubscribe header with the form body List-Unsubscribe=One-Click,
+// so there's no browser session and it must not have CSRF protection. The token signature is what protects it.
+// Responses have an empty body, since nobody sees them.
+func NewsletterUnsubscribeOneClick(mux chi.Router, u unsubscriber, log *zap.Logger, secret []byte) {
+	mux.Post("/newsletter/unsubscribe/one-click", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("List-Unsubscribe") != "One-Click" {
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		err = q.Send(r.Context(), model.Message{
-			"job":   "confirmation_email",
-			"email": email.String(),
-			"token": token,
-		})
+		to, err := email.VerifyUnsubscribeToken(secret, r.URL.Query().Get("token"))
 		if err != nil {
-			http.Error(w, "error signing up, refresh to try again", http.StatusBadGateway)
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		http.Redirect(w, r, "/newsletter/thanks", http.StatusFound)
-	})
-}
+		if err := u.Unsubscribe(r.Context(), to); err != nil {
+			logError(log, r, fmt.Errorf("error unsubscribing from newsletter with one click: %w", err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 
-func NewsletterThanks(mux chi.Router) {
-	mux.Get("/newsletter/thanks", func(w http.ResponseWriter, r *http.Request) {
-		_ = views.NewsletterThanksPage("/newsletter/thanks").Render(w)
+		w.WriteHeader(http.StatusOK)
 	})
 }
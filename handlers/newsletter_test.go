@@ -2,80 +2,384 @@ package handlers_test
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
 	"github.com/matryer/is"
+	"go.uber.org/zap"
 
+	"canvas/email"
 	"canvas/handlers"
 	"canvas/model"
 )
 
-type signupperMock struct {
-	email model.Email
+// makePostRequest and returns the status code, response header, and the body.
+func makePostRequest(handler http.Handler, target string, header http.Header, body io.Reader) (int, http.Header, string) {
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header = header
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	result := res.Result()
+	bodyBytes, err := io.ReadAll(result.Body)
+	if err != nil {
+		panic(err)
+	}
+	return result.StatusCode, result.Header, string(bodyBytes)
 }
 
-func (s *signupperMock) SignupForNewsletter(ctx context.Context, email model.Email) (string, error) {
-	s.email = email
-	return "", nil
+func createFormHeader() http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return header
 }
 
-type senderMock struct {
-	m model.Message
+type confirmerMock struct {
+	results map[string]model.ConfirmationResult
+	err     error
+	tokens  []string
 }
 
-func (s *senderMock) Send(ctx context.Context, m model.Message) error {
-	s.m = m
-	return nil
+func (c *confirmerMock) ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error) {
+	c.tokens = append(c.tokens, token)
+	if c.err != nil {
+		return "", c.err
+	}
+	if result, ok := c.results[token]; ok {
+		return result, nil
+	}
+	return model.ConfirmationResultUnknownToken, nil
 }
 
-func TestNewsletterSignup(t *testing.T) {
-	mux := chi.NewMux()
-	s := &signupperMock{}
-	q := &senderMock{}
-	handlers.NewsletterSignup(mux, s, q)
+func newConfirmerMock() *confirmerMock {
+	return &confirmerMock{results: map[string]model.ConfirmationResult{
+		"new":     model.ConfirmationResultConfirmed,
+		"old":     model.ConfirmationResultAlreadyConfirmed,
+		"expired": model.ConfirmationResultExpired,
+	}}
+}
 
-	t.Run("signs up a valid email address and sends a message", func(t *testing.T) {
+func TestNewsletterThanks(t *testing.T) {
+	t.Run("thanks for signing up and links to resending the confirmation email", func(t *testing.T) {
 		is := is.New(t)
-		code, _, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
-			strings.NewReader("email=me%40example.com"))
-		is.Equal(http.StatusFound, code)
-		is.Equal(model.Email("me@example.com"), s.email)
-		is.Equal(q.m, model.Message{
-			"job":   "confirmation_email",
-			"email": "me@example.com",
-			"token": "123",
+
+		mux := chi.NewMux()
+		handlers.NewsletterThanks(mux, zap.NewNop())
+		code, _, body := makeGetRequest(mux, "/newsletter/thanks")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "<h1>Thanks for signing up!</h1>"))
+		is.True(strings.Contains(body, `<a href="/newsletter/resend">Resend the confirmation email</a>`))
+	})
+}
+
+func TestNewsletterConfirm(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		code  int
+		body  string
+	}{
+		{"confirms a new signup", "new", http.StatusOK, "Signup confirmed!"},
+		{"says so if already confirmed", "old", http.StatusOK, "already on the list"},
+		{"says so if expired", "expired", http.StatusGone, "This link has expired"},
+		{"rejects an unknown token", "unknown", http.StatusNotFound, "This link isn&#39;t valid"},
+		{"rejects a missing token", "", http.StatusBadRequest, "This link isn&#39;t valid"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			mux := chi.NewMux()
+			handlers.NewsletterConfirm(mux, newConfirmerMock(), zap.NewNop(), handlers.NewsletterConfirmOptions{})
+
+			code, _, body := makeGetRequest(mux, "/newsletter/confirm?token="+test.token)
+			is.Equal(test.code, code)
+			is.True(strings.Contains(body, test.body))
 		})
+	}
+
+	t.Run("renders an error page if confirming fails", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.NewsletterConfirm(mux, &confirmerMock{err: errors.New("oh no")}, zap.NewNop(), handlers.NewsletterConfirmOptions{})
+
+		code, _, body := makeGetRequest(mux, "/newsletter/confirm?token=new")
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
 	})
 
-	t.Run("rejects an invalid email address", func(t *testing.T) {
+	t.Run("does not echo the token back", func(t *testing.T) {
 		is := is.New(t)
-		code, _, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
-			strings.NewReader("email=notanemail"))
-		is.Equal(http.StatusBadRequest, code)
+
+		mux := chi.NewMux()
+		handlers.NewsletterConfirm(mux, newConfirmerMock(), zap.NewNop(), handlers.NewsletterConfirmOptions{})
+
+		_, _, body := makeGetRequest(mux, "/newsletter/confirm?token=%3Cscript%3E")
+		is.True(!strings.Contains(body, "<script>"))
+	})
+
+	representations := []struct {
+		name        string
+		target      string
+		accept      string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"responds with JSON for format=json", "/newsletter/confirm?format=json&token=new", "",
+			http.StatusOK, "application/json", `{"status":"confirmed"}` + "\n"},
+		{"responds with JSON when the Accept header prefers it", "/newsletter/confirm?token=old", "application/json, text/plain, */*",
+			http.StatusOK, "application/json", `{"status":"already_confirmed"}` + "\n"},
+		{"responds with problem JSON for errors", "/newsletter/confirm?token=expired", "application/json",
+			http.StatusGone, "application/problem+json",
+			`{"type":"about:blank","title":"Gone","status":410,"detail":"The confirmation token has expired."}` + "\n"},
+		{"responds with problem JSON for errors with format=json", "/newsletter/confirm?format=json&token=unknown", "text/html",
+			http.StatusNotFound, "application/problem+json",
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"The confirmation token is not valid."}` + "\n"},
+		{"responds with HTML when the Accept header prefers it", "/newsletter/confirm?token=new", "text/html, application/json;q=0.9",
+			http.StatusOK, "text/html; charset=utf-8", "Signup confirmed!"},
+		{"responds with HTML for errors when the Accept header prefers it", "/newsletter/confirm?token=unknown", "text/html,*/*;q=0.8",
+			http.StatusNotFound, "text/html; charset=utf-8", "This link isn&#39;t valid"},
+		{"defaults to HTML for unknown Accept values", "/newsletter/confirm?token=new", "application/xml",
+			http.StatusOK, "text/html; charset=utf-8", "Signup confirmed!"},
+		{"defaults to HTML for wildcards", "/newsletter/confirm?token=new", "*/*",
+			http.StatusOK, "text/html; charset=utf-8", "Signup confirmed!"},
+	}
+	for _, test := range representations {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			mux := chi.NewMux()
+			handlers.NewsletterConfirm(mux, newConfirmerMock(), zap.NewNop(), handlers.NewsletterConfirmOptions{})
+
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			req.Header.Set("Accept", test.accept)
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			is.Equal(test.code, res.Code)
+			is.Equal(test.contentType, res.Header().Get("Content-Type"))
+			if strings.HasPrefix(test.contentType, "text/html") {
+				is.True(strings.Contains(res.Body.String(), test.body))
+			} else {
+				is.Equal(test.body, res.Body.String())
+			}
+		})
+	}
+
+	t.Run("in two-step mode, shows a confirm button on GET and confirms on POST", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		c := newConfirmerMock()
+		handlers.NewsletterConfirm(mux, c, zap.NewNop(), handlers.NewsletterConfirmOptions{TwoStep: true})
+
+		code, _, body := makeGetRequest(mux, "/newsletter/confirm?token=%3Cscript%3Enew")
+		is.Equal(http.StatusOK, code)
+		is.Equal(0, len(c.tokens))
+		is.True(strings.Contains(body, `<form action="/newsletter/confirm" method="post">`))
+		is.True(strings.Contains(body, `value="&lt;script&gt;new"`))
+
+		code, _, body = makePostRequest(mux, "/newsletter/confirm", createFormHeader(), strings.NewReader("token=new"))
+		is.Equal(http.StatusOK, code)
+		is.Equal([]string{"new"}, c.tokens)
+		is.True(strings.Contains(body, "Signup confirmed!"))
 	})
 }
 
-// makePostRequest and returns the status code, response header, and the body.
-func makePostRequest(handler http.Handler, target string, header http.Header, body io.Reader) (int, http.Header, string) {
-	req := httptest.NewRequest(http.MethodPost, target, body)
-	req.Header = header
-	res := httptest.NewRecorder()
-	handler.ServeHTTP(res, req)
-	result := res.Result()
-	bodyBytes, err := io.ReadAll(result.Body)
-	if err != nil {
-		panic(err)
+// unsubscriberMock counts unsubscribes per address.
+type unsubscriberMock struct {
+	err          error
+	unsubscribed map[model.Email]int
+}
+
+func (u *unsubscriberMock) Unsubscribe(ctx context.Context, email model.Email) error {
+	if u.err != nil {
+		return u.err
 	}
-	return result.StatusCode, result.Header, string(bodyBytes)
+	if u.unsubscribed == nil {
+		u.unsubscribed = map[model.Email]int{}
+	}
+	u.unsubscribed[email]++
+	return nil
 }
 
-func createFormHeader() http.Header {
-	header := http.Header{}
-	header.Set("Content-Type", "application/x-www-form-urlencoded")
-	return header
+func TestNewsletterUnsubscribe(t *testing.T) {
+	secret := []byte("secret")
+	token := url.QueryEscape(email.CreateUnsubscribeToken(secret, "me@example.com"))
+
+	t.Run("shows an unsubscribe button on GET without unsubscribing", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		u := &unsubscriberMock{}
+		handlers.NewsletterUnsubscribe(mux, u, zap.NewNop(), secret)
+
+		code, _, body := makeGetRequest(mux, "/newsletter/unsubscribe?token="+token)
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `<form action="/newsletter/unsubscribe" method="post">`))
+		is.Equal(0, len(u.unsubscribed))
+	})
+
+	t.Run("unsubscribes on POST and links to signing up again, also the second time", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		u := &unsubscriberMock{}
+		handlers.NewsletterUnsubscribe(mux, u, zap.NewNop(), secret)
+
+		for i := 0; i < 2; i++ {
+			code, _, body := makePostRequest(mux, "/newsletter/unsubscribe", createFormHeader(), strings.NewReader("token="+token))
+			is.Equal(http.StatusOK, code)
+			is.True(strings.Contains(body, "You&#39;re unsubscribed"))
+			is.True(strings.Contains(body, `<a href="/">`))
+		}
+		is.Equal(2, u.unsubscribed["me@example.com"])
+	})
+
+	forged := []struct {
+		name  string
+		token string
+	}{
+		{"signed with another secret", email.CreateUnsubscribeToken([]byte("guess"), "me@example.com")},
+		{"with a made-up signature", "bWVAZXhhbXBsZS5jb20.c2lnbmF0dXJl"},
+		{"missing", ""},
+	}
+	for _, test := range forged {
+		t.Run("rejects a token "+test.name+" with a neutral error", func(t *testing.T) {
+			is := is.New(t)
+
+			mux := chi.NewMux()
+			u := &unsubscriberMock{}
+			handlers.NewsletterUnsubscribe(mux, u, zap.NewNop(), secret)
+
+			code, _, getBody := makeGetRequest(mux, "/newsletter/unsubscribe?token="+url.QueryEscape(test.token))
+			is.Equal(http.StatusBadRequest, code)
+			is.True(strings.Contains(getBody, "This link isn&#39;t valid"))
+
+			code, _, postBody := makePostRequest(mux, "/newsletter/unsubscribe", createFormHeader(),
+				strings.NewReader("token="+url.QueryEscape(test.token)))
+			is.Equal(http.StatusBadRequest, code)
+			is.Equal(getBody, postBody)
+			is.True(!strings.Contains(postBody, "me@example.com"))
+			is.Equal(0, len(u.unsubscribed))
+		})
+	}
+
+	t.Run("responds with JSON for format=json, for success and forged tokens", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		u := &unsubscriberMock{}
+		handlers.NewsletterUnsubscribe(mux, u, zap.NewNop(), secret)
+
+		code, header, body := makeGetRequest(mux, "/newsletter/unsubscribe?format=json&token="+token)
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/json", header.Get("Content-Type"))
+		is.Equal(`{"status":"pending"}`+"\n", body)
+
+		code, header, body = makePostRequest(mux, "/newsletter/unsubscribe?format=json", createFormHeader(), strings.NewReader("token="+token))
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/json", header.Get("Content-Type"))
+		is.Equal(`{"status":"unsubscribed"}`+"\n", body)
+
+		code, header, body = makePostRequest(mux, "/newsletter/unsubscribe?format=json", createFormHeader(), strings.NewReader("token=forged"))
+		is.Equal(http.StatusBadRequest, code)
+		is.Equal("application/problem+json", header.Get("Content-Type"))
+		is.Equal(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"The unsubscribe token is not valid."}`+"\n", body)
+	})
+
+	t.Run("renders an error page if unsubscribing fails", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.NewsletterUnsubscribe(mux, &unsubscriberMock{err: errors.New("oh no")}, zap.NewNop(), secret)
+
+		code, _, body := makePostRequest(mux, "/newsletter/unsubscribe", createFormHeader(), strings.NewReader("token="+token))
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
+	})
+}
+
+func TestNewsletterUnsubscribeOneClick(t *testing.T) {
+	secret := []byte("secret")
+	token := url.QueryEscape(email.CreateUnsubscribeToken(secret, "me@example.com"))
+
+	// This is what Gmail and Yahoo send, with the URL from the List-Unsubscribe header.
+	t.Run("unsubscribes right away with an empty response for the URL-encoded form from mail providers", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		u := &unsubscriberMock{}
+		handlers.NewsletterUnsubscribeOneClick(mux, u, zap.NewNop(), secret)
+
+		code, _, body := makePostRequest(mux, "/newsletter/unsubscribe/one-click?token="+token, createFormHeader(),
+			strings.NewReader("List-Unsubscribe=One-Click"))
+		is.Equal(http.StatusOK, code)
+		is.Equal("", body)
+		is.Equal(1, u.unsubscribed["me@example.com"])
+	})
+
+	// RFC 8058 also allows the body as multipart/form-data.
+	t.Run("accepts the multipart form", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		u := &unsubscriberMock{}
+		handlers.NewsletterUnsubscribeOneClick(mux, u, zap.NewNop(), secret)
+
+		header := http.Header{}
+		header.Set("Content-Type", "multipart/form-data; boundary=boundary")
+		code, _, body := makePostRequest(mux, "/newsletter/unsubscribe/one-click?token="+token, header, strings.NewReader(
+			"--boundary\r\nContent-Disposition: form-data; name=\"List-Unsubscribe\"\r\n\r\nOne-Click\r\n--boundary--\r\n"))
+		is.Equal(http.StatusOK, code)
+		is.Equal("", body)
+		is.Equal(1, u.unsubscribed["me@example.com"])
+	})
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{"rejects a forged token", "/newsletter/unsubscribe/one-click?token=" +
+			url.QueryEscape(email.CreateUnsubscribeToken([]byte("guess"), "me@example.com")), "List-Unsubscribe=One-Click"},
+		{"rejects a missing token", "/newsletter/unsubscribe/one-click", "List-Unsubscribe=One-Click"},
+		{"rejects the token in the body instead of the URL", "/newsletter/unsubscribe/one-click",
+			"List-Unsubscribe=One-Click&token=" + token},
+		{"rejects a missing one-click body", "/newsletter/unsubscribe/one-click?token=" + token, ""},
+		{"rejects a wrong one-click body", "/newsletter/unsubscribe/one-click?token=" + token, "List-Unsubscribe=Yes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			mux := chi.NewMux()
+			u := &unsubscriberMock{}
+			handlers.NewsletterUnsubscribeOneClick(mux, u, zap.NewNop(), secret)
+
+			code, _, body := makePostRequest(mux, test.target, createFormHeader(), strings.NewReader(test.body))
+			is.Equal(http.StatusBadRequest, code)
+			is.Equal("", body)
+			is.Equal(0, len(u.unsubscribed))
+		})
+	}
+
+	t.Run("responds with 500 if unsubscribing fails, so the provider can retry", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.NewsletterUnsubscribeOneClick(mux, &unsubscriberMock{err: errors.New("oh no")}, zap.NewNop(), secret)
+
+		code, _, _ := makePostRequest(mux, "/newsletter/unsubscribe/one-click?token="+token, createFormHeader(),
+			strings.NewReader("List-Unsubscribe=One-Click"))
+		is.Equal(http.StatusInternalServerError, code)
+	})
 }
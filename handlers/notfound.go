@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/views"
+)
+
+// NotFound renders the not found page for URLs without a route, with a 404 status.
+// It goes through the middleware of the router, and of mounted routers for URLs under them.
+// HEAD requests get the headers only.
+// If rendering fails, it falls back to plain text.
+func NotFound(mux chi.Router, log *zap.Logger, registry *prometheus.Registry) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+	notFound := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "app_http_not_found_total",
+		Help: "Number of requests for URLs without a route.",
+	})
+	registry.MustRegister(notFound)
+
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		notFound.Inc()
+		requestLog(r.Context(), log).Debug("Not found")
+
+		var b bytes.Buffer
+		if err := views.NotFoundPage(r.URL.Path).Render(&b); err != nil {
+			requestLog(r.Context(), log).Info("Error rendering not found page", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(b.Bytes())
+	})
+}
@@ -0,0 +1,71 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+)
+
+func TestNotFound(t *testing.T) {
+	t.Run("renders the not found page with a 404 and counts it", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		mux := chi.NewMux()
+		handlers.NotFound(mux, zap.NewNop(), registry)
+
+		code, header, body := makeGetRequest(mux, "/doesnotexist")
+		is.Equal(http.StatusNotFound, code)
+		is.Equal("text/html; charset=utf-8", header.Get("Content-Type"))
+		is.True(strings.Contains(body, "this page doesn&#39;t exist"))
+		is.True(strings.Contains(body, `<a href="/">Back to the front page</a>`))
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_http_not_found_total Number of requests for URLs without a route.
+# TYPE app_http_not_found_total counter
+app_http_not_found_total 1
+`), "app_http_not_found_total")
+		is.NoErr(err)
+	})
+
+	t.Run("writes only the headers for HEAD requests", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.NotFound(mux, zap.NewNop(), nil)
+
+		req := httptest.NewRequest(http.MethodHead, "/doesnotexist", nil)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusNotFound, res.Code)
+		is.Equal("text/html; charset=utf-8", res.Header().Get("Content-Type"))
+		is.Equal(0, res.Body.Len())
+	})
+
+	t.Run("goes through the router middleware", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		var called bool
+		mux.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				next.ServeHTTP(w, r)
+			})
+		})
+		handlers.NotFound(mux, zap.NewNop(), nil)
+
+		code, _, _ := makeGetRequest(mux, "/doesnotexist")
+		is.Equal(http.StatusNotFound, code)
+		is.True(called)
+	})
+}
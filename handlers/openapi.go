@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"canvas/openapi"
+	"canvas/sns"
+)
+
+// OpenAPIDocument of the JSON endpoints: the signup API, the admin API, and the SES webhook.
+// The schemas are derived from the types the handlers decode and encode, so they can't drift from them.
+func OpenAPIDocument() *openapi.Document {
+	d := openapi.New(openapi.Info{
+		Title:       "Canvas",
+		Description: "The signup API for widgets and apps, the admin API for programs with an API token, and the webhooks.",
+		Version:     "1",
+	})
+	d.Components.SecuritySchemes["apiToken"] = openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "An API token created on the API tokens admin page, with the scope the operation needs.",
+	}
+
+	problemJSON := func(description string) *openapi.Response {
+		return &openapi.Response{
+			Description: description + " The problem details are as described in RFC 7807.",
+			Content:     map[string]openapi.MediaType{"application/problem+json": {Schema: d.Schema("problem", problem{})}},
+		}
+	}
+	idParameter := openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "integer", Format: "int64"}}
+
+	// adminAPI operation with the scope, and the error responses every admin API operation can have.
+	adminAPI := func(scope string, op openapi.Operation) openapi.Operation {
+		op.Tags = []string{"admin"}
+		op.Description += " Needs an API token with the " + scope + " scope."
+		op.Security = []map[string][]string{{"apiToken": {}}}
+		op.Responses["401"] = problemJSON("There's no API token, or it's invalid, expired, or revoked.")
+		op.Responses["403"] = problemJSON("The API token doesn't have the scope.")
+		op.Responses["500"] = problemJSON("Something went wrong.")
+		op.Responses["503"] = problemJSON("Something is unavailable right now.")
+		return op
+	}
+
+	signupResponse := d.Schema("signupResponse", signupResponse{})
+	d.Add(http.MethodPost, "/api/newsletter/signup", openapi.Operation{
+		OperationID: "signup",
+		Summary:     "Sign up for the newsletter",
+		Description: "Signs up the email address, which gets an email with a link to confirm the signup. " +
+			"Errors have the signupResponse schema too, not problem details.",
+		Tags:        []string{"signup"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(d.Schema("signupRequest", signupRequest{}))},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The address is subscribed already.", Content: openapi.JSON(signupResponse)},
+			"201": {Description: "Signed up, with a confirmation email on its way.", Content: openapi.JSON(signupResponse)},
+			"400": {Description: "The request body isn't one valid JSON document.", Content: openapi.JSON(signupResponse)},
+			"413": {Description: "The request body is bigger than 64 KiB.", Content: openapi.JSON(signupResponse)},
+			"415": {Description: "The request body isn't JSON.", Content: openapi.JSON(signupResponse)},
+			"422": {Description: "The request is invalid, with the errors of the fields, including unknown fields and values of the wrong type.",
+				Content: openapi.JSON(signupResponse)},
+			"429": {Description: "Too many signups, from the client or for the address.", Content: openapi.JSON(signupResponse)},
+			"500": {Description: "Something went wrong.", Content: openapi.JSON(signupResponse)},
+		},
+	})
+
+	subscriber := d.Schema("subscriber", apiSubscriber{})
+	d.Add(http.MethodGet, "/api/admin/subscribers", adminAPI("read:subscribers", openapi.Operation{
+		OperationID: "listSubscribers",
+		Summary:     "List subscribers",
+		Description: "Lists a page of subscribers, ordered by email address.",
+		Parameters: []openapi.Parameter{
+			{Name: "limit", In: "query", Description: "How many subscribers to list, up to 1000, which is also the default.",
+				Schema: &openapi.Schema{Type: "integer"}},
+			{Name: "status", In: "query", Description: "Only list subscribers with the status.",
+				Schema: &openapi.Schema{Type: "string", Enum: []string{"pending", "confirmed", "unsubscribed", "bounced", "complained"}}},
+			{Name: "after", In: "query", Description: "The cursor of the page, from next of the page before.",
+				Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The page of subscribers.", Content: openapi.JSON(d.Schema("subscribers", apiSubscribersResponse{}))},
+			"422": problemJSON("The limit is invalid."),
+		},
+	}))
+	d.Add(http.MethodGet, "/api/admin/subscribers/{id}", adminAPI("read:subscribers", openapi.Operation{
+		OperationID: "getSubscriber",
+		Summary:     "Get a subscriber",
+		Description: "Gets the subscriber with the ID.",
+		Parameters:  []openapi.Parameter{idParameter},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The subscriber.", Content: openapi.JSON(subscriber)},
+			"404": problemJSON("There's no such subscriber."),
+		},
+	}))
+
+	changeRequest := d.Schema("subscriberChangeRequest", apiSubscriberChangeRequest{})
+	status := d.Schema("status", statusResponse{})
+	for _, change := range []struct{ method, path, id, summary, description string }{
+		{http.MethodDelete, "/api/admin/subscribers/{id}", "deleteSubscriber", "Delete a subscriber",
+			"Deletes the subscriber, like on the admin pages."},
+		{http.MethodPost, "/api/admin/subscribers/{id}/confirm", "confirmSubscriber", "Confirm a subscriber",
+			"Confirms the signup of the subscriber for them."},
+		{http.MethodPost, "/api/admin/subscribers/{id}/unsubscribe", "unsubscribeSubscriber", "Unsubscribe a subscriber",
+			"Unsubscribes the subscriber for them."},
+		{http.MethodPost, "/api/admin/subscribers/{id}/clear-complaint", "clearComplaint", "Clear the complaint of a subscriber",
+			"Clears the complaint of the subscriber, so they can sign up again."},
+	} {
+		d.Add(change.method, change.path, adminAPI("write:subscribers", openapi.Operation{
+			OperationID: change.id,
+			Summary:     change.summary,
+			Description: change.description + " The change is recorded in the audit log with the API token as the actor.",
+			Parameters:  []openapi.Parameter{idParameter},
+			RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(changeRequest)},
+			Responses: map[string]*openapi.Response{
+				"200": {Description: "Changed.", Content: openapi.JSON(status)},
+				"404": problemJSON("There's no such subscriber."),
+				"409": problemJSON("The subscriber changed since the version, or was deleted."),
+				"422": problemJSON("The request body isn't valid JSON."),
+			},
+		}))
+	}
+
+	d.Add(http.MethodPost, "/api/admin/newsletters/{id}/send", adminAPI("send:newsletter", openapi.Operation{
+		OperationID: "sendNewsletter",
+		Summary:     "Send a newsletter issue",
+		Description: "Queues sending the newsletter issue with the ID to every confirmed subscriber, or the ones in the segment.",
+		Parameters:  []openapi.Parameter{idParameter},
+		RequestBody: &openapi.RequestBody{Content: openapi.JSON(d.Schema("newsletterSendRequest", apiNewsletterSendRequest{}))},
+		Responses: map[string]*openapi.Response{
+			"202": {Description: "The send is queued.", Content: openapi.JSON(status)},
+			"404": problemJSON("There's no such newsletter issue."),
+			"409": problemJSON("The issue is being sent already, or was sent already and force isn't set."),
+			"422": problemJSON("The issue has no title or body, the segment isn't valid, or the request body isn't valid JSON."),
+		},
+	}))
+
+	snsMessage := openapi.SchemaOf(sns.Message{})
+	snsMessage.Properties["Message"].Description = "For notifications, the SES notification as JSON, with the sesNotification schema."
+	d.Components.Schemas["snsMessage"] = snsMessage
+	d.Schema("sesNotification", sesNotification{})
+	d.Add(http.MethodPost, "/webhooks/ses", openapi.Operation{
+		OperationID: "sesWebhook",
+		Summary:     "Receive SES notifications",
+		Description: "Receives SES bounce, complaint, and delivery notifications from an SNS topic subscription. " +
+			"Messages must be signed by SNS, and subscription confirmations are confirmed.",
+		Tags: []string{"webhooks"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"text/plain": {Schema: &openapi.Schema{Ref: "#/components/schemas/snsMessage"}},
+		}},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Received."},
+			"400": {Description: "The message isn't valid JSON."},
+			"403": {Description: "The message isn't signed by SNS."},
+			"500": {Description: "The notification couldn't be handled, so SNS should retry it."},
+			"502": {Description: "The subscription couldn't be confirmed, so SNS should retry it."},
+			"503": {Description: "The signature couldn't be verified right now, so SNS should retry it."},
+		},
+	})
+
+	d.Add(http.MethodGet, "/api/openapi.json", openapi.Operation{
+		OperationID: "openAPI",
+		Summary:     "Get this document",
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "This document.", Content: openapi.JSON(&openapi.Schema{Type: "object"})},
+		},
+	})
+
+	return d
+}
+
+// OpenAPI document from OpenAPIDocument at /openapi.json on a router mounted at /api.
+func OpenAPI(mux chi.Router) {
+	document, err := json.Marshal(OpenAPIDocument())
+	if err != nil {
+		panic(err)
+	}
+	mux.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(document)
+	})
+}
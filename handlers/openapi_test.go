@@ -0,0 +1,210 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/openapi"
+	"canvas/storage"
+)
+
+// openAPIExample request to a documented operation, with the request target if it's not the path,
+// and the status code of the response.
+type openAPIExample struct {
+	name        string
+	method      string
+	path        string
+	target      string
+	token       string
+	contentType string
+	body        string
+	// invalid requests must be rejected by the document too.
+	invalid bool
+	code    int
+}
+
+// TestOpenAPIDocument round-trips example requests through both the document and the real handlers,
+// so the document can't drift from what the handlers accept and respond with.
+func TestOpenAPIDocument(t *testing.T) {
+	d := handlers.OpenAPIDocument()
+	updated := time.Date(2022, 12, 10, 12, 0, 0, 1000, time.UTC)
+	version := fmt.Sprint(updated.UnixMicro())
+	sign, verifier := newSNSSigner(t, false)
+
+	newMux := func() chi.Router {
+		tokens := &apiTokenStoreMock{now: time.Now()}
+		_, _, _ = tokens.CreateAPIToken(context.Background(), "all", model.APIScopes, nil, storage.AuditActorAdmin)
+		_, _, _ = tokens.CreateAPIToken(context.Background(), "reader", []model.APIScope{model.APIScopeReadSubscribers}, nil, storage.AuditActorAdmin)
+
+		subscribers := []model.Subscriber{
+			{ID: 1, Email: "a@example.com", Active: true, Confirmed: true, ConfirmedAt: &updated, Locale: "en", Created: updated, Updated: updated},
+			{ID: 2, Email: "b@example.com", Active: true, Source: "https://partner.example.com", Created: updated, Updated: updated},
+		}
+		lister := &apiSubscriberStoreMock{subscriberListerMock{subscribers: subscribers}}
+		changer := &subscriberChangerMock{subscribers: map[int64]model.Subscriber{1: subscribers[0], 2: subscribers[1]}}
+		sender := newNewsletterSendStoreMock(messaging.NewMemoryQueue(time.Millisecond))
+		signupper := &signupperMock{subscribed: map[model.Email]bool{"old@example.com": true}}
+
+		mux := chi.NewMux()
+		mux.Route("/api", func(r chi.Router) {
+			handlers.NewsletterSignupAPI(r, handlers.NewSignupService(signupper, zap.NewNop(), handlers.SignupServiceOptions{}))
+			handlers.OpenAPI(r)
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(handlers.APITokenAuth(tokens, zap.NewNop()))
+				r.With(handlers.RequireAPIScope(model.APIScopeReadSubscribers)).Group(func(r chi.Router) {
+					handlers.AdminAPISubscribers(r, lister, zap.NewNop())
+				})
+				r.With(handlers.RequireAPIScope(model.APIScopeWriteSubscribers)).Group(func(r chi.Router) {
+					handlers.AdminAPISubscriberActions(r, changer, zap.NewNop())
+				})
+				r.With(handlers.RequireAPIScope(model.APIScopeSendNewsletter)).Group(func(r chi.Router) {
+					handlers.AdminAPINewsletterSend(r, sender, zap.NewNop())
+				})
+			})
+		})
+		// Subscription confirmations are confirmed without a network.
+		client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		})}
+		mux.Route("/webhooks", func(r chi.Router) {
+			handlers.SESWebhook(r, newSuppressorMock(), nil, handlers.SESWebhookOptions{Client: client, Verifier: verifier})
+		})
+		return mux
+	}
+
+	const (
+		jsonType = "application/json"
+		textType = "text/plain; charset=UTF-8"
+	)
+	tests := []openAPIExample{
+		{"signs up", http.MethodPost, "/api/newsletter/signup", "", "", jsonType, `{"email":"me@example.com"}`, false, http.StatusCreated},
+		{"signs up from a partner", http.MethodPost, "/api/newsletter/signup", "", "", jsonType,
+			`{"email":"me@example.com","source":"https://partner.example.com"}`, false, http.StatusCreated},
+		{"says the address is subscribed already", http.MethodPost, "/api/newsletter/signup", "", "", jsonType,
+			`{"email":"old@example.com"}`, false, http.StatusOK},
+		{"rejects an invalid address", http.MethodPost, "/api/newsletter/signup", "", "", jsonType, `{"email":"notanemail"}`, true,
+			http.StatusUnprocessableEntity},
+		{"rejects a missing address", http.MethodPost, "/api/newsletter/signup", "", "", jsonType, `{}`, true,
+			http.StatusUnprocessableEntity},
+		{"rejects malformed JSON", http.MethodPost, "/api/newsletter/signup", "", "", jsonType, `{"email":`, true, http.StatusBadRequest},
+
+		{"lists subscribers", http.MethodGet, "/api/admin/subscribers", "/api/admin/subscribers?limit=1", "canvas_secretall", "", "", false,
+			http.StatusOK},
+		{"lists subscribers with a status", http.MethodGet, "/api/admin/subscribers", "/api/admin/subscribers?status=pending",
+			"canvas_secretreader", "", "", false, http.StatusOK},
+		{"rejects an invalid limit", http.MethodGet, "/api/admin/subscribers", "/api/admin/subscribers?limit=0", "canvas_secretall", "", "",
+			false, http.StatusUnprocessableEntity},
+		{"requires a token", http.MethodGet, "/api/admin/subscribers", "", "", "", "", false, http.StatusUnauthorized},
+		{"rejects an invalid token", http.MethodGet, "/api/admin/subscribers", "", "canvas_nope", "", "", false, http.StatusUnauthorized},
+		{"gets a subscriber", http.MethodGet, "/api/admin/subscribers/{id}", "/api/admin/subscribers/1", "canvas_secretall", "", "", false,
+			http.StatusOK},
+		{"doesn't find a subscriber", http.MethodGet, "/api/admin/subscribers/{id}", "/api/admin/subscribers/3", "canvas_secretall", "", "",
+			false, http.StatusNotFound},
+
+		{"confirms a subscriber", http.MethodPost, "/api/admin/subscribers/{id}/confirm", "/api/admin/subscribers/2/confirm",
+			"canvas_secretall", jsonType, `{"version":` + version + `}`, false, http.StatusOK},
+		{"unsubscribes a subscriber", http.MethodPost, "/api/admin/subscribers/{id}/unsubscribe", "/api/admin/subscribers/1/unsubscribe",
+			"canvas_secretall", jsonType, `{"version":` + version + `}`, false, http.StatusOK},
+		{"clears a complaint", http.MethodPost, "/api/admin/subscribers/{id}/clear-complaint", "/api/admin/subscribers/1/clear-complaint",
+			"canvas_secretall", jsonType, `{"version":` + version + `}`, false, http.StatusOK},
+		{"deletes a subscriber", http.MethodDelete, "/api/admin/subscribers/{id}", "/api/admin/subscribers/1",
+			"canvas_secretall", jsonType, `{"version":` + version + `}`, false, http.StatusOK},
+		{"doesn't change a subscriber changed since", http.MethodDelete, "/api/admin/subscribers/{id}", "/api/admin/subscribers/1",
+			"canvas_secretall", jsonType, `{"version":1}`, false, http.StatusConflict},
+		{"rejects a version that isn't a number", http.MethodPost, "/api/admin/subscribers/{id}/confirm", "/api/admin/subscribers/1/confirm",
+			"canvas_secretall", jsonType, `{"version":"` + version + `"}`, true, http.StatusUnprocessableEntity},
+		{"requires the scope for changing subscribers", http.MethodPost, "/api/admin/subscribers/{id}/confirm",
+			"/api/admin/subscribers/1/confirm", "canvas_secretreader", jsonType, `{"version":` + version + `}`, false, http.StatusForbidden},
+
+		{"sends a newsletter issue", http.MethodPost, "/api/admin/newsletters/{id}/send", "/api/admin/newsletters/1/send",
+			"canvas_secretall", jsonType, `{}`, false, http.StatusAccepted},
+		{"sends a newsletter issue again", http.MethodPost, "/api/admin/newsletters/{id}/send", "/api/admin/newsletters/1/send",
+			"canvas_secretall", jsonType, `{"force":true}`, false, http.StatusAccepted},
+		{"doesn't find a newsletter issue", http.MethodPost, "/api/admin/newsletters/{id}/send", "/api/admin/newsletters/2/send",
+			"canvas_secretall", jsonType, `{}`, false, http.StatusNotFound},
+		{"requires the scope for sending", http.MethodPost, "/api/admin/newsletters/{id}/send", "/api/admin/newsletters/1/send",
+			"canvas_secretreader", jsonType, `{}`, false, http.StatusForbidden},
+
+		{"gets the document", http.MethodGet, "/api/openapi.json", "", "", "", "", false, http.StatusOK},
+	}
+	for _, name := range []string{"bounce-permanent", "bounce-transient", "complaint", "delivery", "subscription-confirmation"} {
+		tests = append(tests, openAPIExample{"receives the SNS message " + name, http.MethodPost, "/webhooks/ses", "", "", textType,
+			sign(readSNSFixture(t, name)), false, http.StatusOK})
+	}
+
+	documented := map[string]bool{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			if test.body != "" {
+				err := d.ValidateRequest(test.method, test.path, test.contentType, []byte(test.body))
+				if test.invalid {
+					is.True(err != nil) // the document must reject the invalid request
+				} else {
+					is.NoErr(err)
+				}
+			}
+
+			target := test.target
+			if target == "" {
+				target = test.path
+			}
+			req := httptest.NewRequest(test.method, target, strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			res := httptest.NewRecorder()
+			newMux().ServeHTTP(res, req)
+			is.Equal(test.code, res.Code)
+
+			body, err := io.ReadAll(res.Body)
+			is.NoErr(err)
+			if test.path == "/api/openapi.json" {
+				var served openapi.Document
+				is.NoErr(json.Unmarshal(body, &served))
+				is.Equal(openapi.Version, served.OpenAPI)
+				is.Equal(len(d.Paths), len(served.Paths))
+			} else {
+				is.NoErr(d.ValidateResponse(test.method, test.path, res.Code, res.Header().Get("Content-Type"), body))
+			}
+			documented[test.method+" "+test.path] = true
+		})
+	}
+
+	t.Run("has the SES notifications of the webhook", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, name := range []string{"bounce-permanent", "bounce-transient", "complaint", "delivery"} {
+			m := readSNSFixture(t, name)
+			is.NoErr(d.Validate(&openapi.Schema{Ref: "#/components/schemas/sesNotification"}, []byte(m.Message), openapi.ValidateOptions{}))
+		}
+	})
+
+	t.Run("has an example of every operation", func(t *testing.T) {
+		is := is.New(t)
+
+		for path, operations := range d.Paths {
+			for method := range operations {
+				is.True(documented[strings.ToUpper(method)+" "+path]) // operation without an example
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimitIdle is how long a client's limiter is kept after its last request.
+const rateLimitIdle = 3 * time.Minute
+
+// RateLimit is middleware limiting each client IP address to limit requests per second, with bursts up to burst.
+// Requests over the limit get a 429 Too Many Requests. Use it on public routes that don't need auth, but do work.
+func RateLimit(limit rate.Limit, burst int) func(next http.Handler) http.Handler {
+	type client struct {
+		limiter  *rate.Limiter
+		lastSeen time.Time
+	}
+
+	var lock sync.Mutex
+	clients := map[string]*client{}
+	lastSweep := time.Now()
+
+	allow := func(ip string) bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		now := time.Now()
+		if now.Sub(lastSweep) > rateLimitIdle {
+			for ip, c := range clients {
+				if now.Sub(c.lastSeen) > rateLimitIdle {
+					delete(clients, ip)
+				}
+			}
+			lastSweep = now
+		}
+
+		c, ok := clients[ip]
+		if !ok {
+			c = &client{limiter: rate.NewLimiter(limit, burst)}
+			clients[ip] = c
+		}
+		c.lastSeen = now
+		return c.limiter.Allow()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allow(clientIP(r)) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Run("limits requests per client IP address", func(t *testing.T) {
+		is := is.New(t)
+
+		h := handlers.RateLimit(0.001, 2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		request := func(remoteAddr string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = remoteAddr
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+			return res.Result().StatusCode
+		}
+
+		is.Equal(http.StatusOK, request("192.0.2.1:1234"))
+		is.Equal(http.StatusOK, request("192.0.2.1:1235"))
+		is.Equal(http.StatusTooManyRequests, request("192.0.2.1:1236"))
+		is.Equal(http.StatusOK, request("192.0.2.2:1234"))
+	})
+}
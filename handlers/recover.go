@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+
+	"canvas/errorreport"
+	"canvas/views"
+)
+
+type reporterContextKey struct{}
+
+// Recover from panics in handlers, by logging and reporting them with rep, and responding with the error page.
+// It also gives HandleErrors rep for reporting unexpected errors. rep can be nil, for no reporting.
+// Aborted handlers panic with http.ErrAbortHandler on purpose, so that panic is passed on to the server.
+func Recover(log *zap.Logger, rep *errorreport.Reporter) func(next http.Handler) http.Handler {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Without a reporter, requests aren't changed, so there's nothing to allocate.
+			if rep != nil {
+				r = r.WithContext(context.WithValue(r.Context(), reporterContextKey{}, rep))
+			}
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				stack := debug.Stack()
+				reference := createErrorReference()
+				requestLog(r.Context(), log).Error("Handler panicked",
+					zap.Any("panic", rec),
+					zap.ByteString("stack", stack),
+					zap.String("reference", reference),
+				)
+				rep.ReportRequest(r, reference, &errorreport.PanicError{Value: rec, Stack: stack})
+				_ = respondError(w, r, http.StatusInternalServerError, views.ErrorPage(r.URL.Path, reference),
+					"Something went wrong. Reference "+reference+".")
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// reporterFrom the context of a request through Recover, or nil, which doesn't report.
+func reporterFrom(ctx context.Context) *errorreport.Reporter {
+	rep, _ := ctx.Value(reporterContextKey{}).(*errorreport.Reporter)
+	return rep
+}
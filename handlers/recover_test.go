@@ -0,0 +1,150 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/errorreport"
+	"canvas/handlers"
+	"canvas/storage"
+)
+
+// errorTransportMock records the reported events.
+type errorTransportMock struct {
+	lock   sync.Mutex
+	events []errorreport.Event
+}
+
+func (t *errorTransportMock) SendEvent(ctx context.Context, e errorreport.Event) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, e)
+	return nil
+}
+
+// newErrorReporter with the mock transport, and a function that flushes it and returns the events reported so far.
+func newErrorReporter(t *testing.T) (*errorreport.Reporter, func() []errorreport.Event) {
+	t.Helper()
+	transport := &errorTransportMock{}
+	rep, err := errorreport.NewReporter(errorreport.NewReporterOptions{
+		DSN:       "https://key@sentry.example.com/1",
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rep, func() []errorreport.Event {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := rep.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		transport.lock.Lock()
+		defer transport.lock.Unlock()
+		return transport.events
+	}
+}
+
+func TestRecover(t *testing.T) {
+	newMux := func(log *zap.Logger, rep *errorreport.Reporter) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID, handlers.Recover(log, rep))
+		mux.Get("/panic/{id}", func(w http.ResponseWriter, r *http.Request) {
+			panic("oh no")
+		})
+		mux.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		mux.Get("/error", handlers.HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			return errors.New("oh no")
+		}))
+		mux.Get("/missing", handlers.HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+			return storage.ErrNotFound
+		}))
+		return mux
+	}
+
+	t.Run("logs and reports panics, and responds with the error page", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		rep, events := newErrorReporter(t)
+		code, _, body := makeGetRequest(newMux(zap.New(core), rep), "/panic/1")
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
+
+		entries := logs.FilterMessage("Handler panicked").All()
+		is.Equal(1, len(entries))
+		reference := entries[0].ContextMap()["reference"].(string)
+		is.True(strings.Contains(body, reference))
+
+		reported := events()
+		is.Equal(1, len(reported))
+		e := reported[0]
+		is.Equal("fatal", e.Level)
+		is.Equal("/panic/{id}", e.Tags["route"])
+		is.Equal(reference, e.Tags["reference"])
+		is.True(e.Tags["requestID"] != "")
+		is.Equal("panic: oh no", e.Exception.Values[0].Value)
+		is.True(strings.Contains(e.Extra["stack"], "runtime/debug.Stack"))
+	})
+
+	t.Run("passes on aborted handlers", func(t *testing.T) {
+		is := is.New(t)
+
+		rep, events := newErrorReporter(t)
+		defer func() {
+			is.Equal(http.ErrAbortHandler, recover())
+			is.Equal(0, len(events()))
+		}()
+		makeGetRequest(newMux(zap.NewNop(), rep), "/abort")
+	})
+
+	t.Run("recovers without a reporter", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, _ := makeGetRequest(newMux(zap.NewNop(), nil), "/panic/1")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+
+	t.Run("gives HandleErrors the reporter for unexpected errors only", func(t *testing.T) {
+		is := is.New(t)
+
+		rep, events := newErrorReporter(t)
+		mux := newMux(zap.NewNop(), rep)
+		code, _, body := makeGetRequest(mux, "/error")
+		is.Equal(http.StatusInternalServerError, code)
+		code, _, _ = makeGetRequest(mux, "/missing")
+		is.Equal(http.StatusNotFound, code)
+
+		reported := events()
+		is.Equal(1, len(reported))
+		is.Equal("/error", reported[0].Tags["route"])
+		is.Equal("error", reported[0].Level)
+		is.True(strings.Contains(body, reported[0].Tags["reference"]))
+	})
+
+	t.Run("doesn't allocate without a reporter", func(t *testing.T) {
+		is := is.New(t)
+
+		h := handlers.Recover(zap.NewNop(), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		is.Equal(0.0, testing.AllocsPerRun(100, func() {
+			h.ServeHTTP(w, r)
+		}))
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"canvas/logging"
+)
+
+// RequestLog is middleware storing a logger derived from log in the context of each request, with the request ID,
+// correlation ID, method, path, and trace ID, if the request is traced. Everything logged while handling the request,
+// including in stores and senders with logging.FromContext, has those fields.
+// The correlation ID is the request ID, and it's also stored in the context, so the jobs the request sends have it.
+// HandleErrors adds the route pattern once it's known, and AdminAuth marks requests by the admin.
+// It must come after middleware.RequestID, and after Trace for the trace ID.
+func RequestLog(log *zap.Logger) func(next http.Handler) http.Handler {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := middleware.GetReqID(r.Context())
+			correlationID := requestID
+			if correlationID == "" {
+				correlationID = logging.NewCorrelationID()
+			}
+			ctx := logging.WithCorrelationID(r.Context(), correlationID)
+
+			fields := []zap.Field{
+				zap.String("requestID", requestID),
+				zap.String(logging.CorrelationIDField, correlationID),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+			}
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				fields = append(fields, zap.String("traceID", sc.TraceID().String()))
+			}
+			next.ServeHTTP(w, r.WithContext(logging.NewContext(ctx, log.With(fields...))))
+		})
+	}
+}
+
+// requestLog is the logger of the request in ctx from RequestLog. Requests that didn't go through it,
+// like in tests, log with log and the request ID, if there is one.
+func requestLog(ctx context.Context, log *zap.Logger) *zap.Logger {
+	if l, ok := logging.Lookup(ctx); ok {
+		return l
+	}
+	if requestID := middleware.GetReqID(ctx); requestID != "" {
+		return log.With(zap.String("requestID", requestID))
+	}
+	return log
+}
+
+// withLogFields added to the logger of the request from RequestLog. Without it, r is returned as it is.
+func withLogFields(r *http.Request, fields ...zap.Field) *http.Request {
+	l, ok := logging.Lookup(r.Context())
+	if !ok {
+		return r
+	}
+	return r.WithContext(logging.NewContext(r.Context(), l.With(fields...)))
+}
+
+// withRouteLogField adds the route pattern to the logger of the request, once routing has matched it.
+func withRouteLogField(r *http.Request) *http.Request {
+	rc := chi.RouteContext(r.Context())
+	if rc == nil || rc.RoutePattern() == "" {
+		return r
+	}
+	return withLogFields(r, zap.String("route", rc.RoutePattern()))
+}
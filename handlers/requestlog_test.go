@@ -0,0 +1,98 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/handlers"
+	"canvas/logging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// loggingSuppressionStoreMock logs from deep inside a store call, with the logger in the context, and fails with err.
+type loggingSuppressionStoreMock struct {
+	suppressionStoreMock
+}
+
+func (s *loggingSuppressionStoreMock) ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error) {
+	logging.FromContext(ctx).Info("Listing suppressions")
+	return s.suppressionStoreMock.ListSuppressions(ctx, opts)
+}
+
+func TestRequestLog(t *testing.T) {
+	m := newSessionManager()
+	session := logInAdmin(t, m, 0)
+
+	newMux := func(log *zap.Logger, s *loggingSuppressionStoreMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID, handlers.RequestLog(log), m.Middleware)
+		mux.Route("/admin", func(r chi.Router) {
+			r.Use(handlers.AdminAuth(zap.NewNop()))
+			// Not the request logger, which is what the handler logs with.
+			handlers.AdminSuppressions(r, s, zap.NewNop())
+		})
+		return mux
+	}
+
+	get := func(mux chi.Router, target string) int {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(session)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("logs inside stores with the request ID, method, path, route, and admin", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		code := get(newMux(zap.New(core), &loggingSuppressionStoreMock{}), "/admin/suppressions?email=me%40example.com")
+		is.Equal(http.StatusOK, code)
+
+		entries := logs.FilterMessage("Listing suppressions").All()
+		is.Equal(1, len(entries))
+		fields := entries[0].ContextMap()
+		is.True(fields["requestID"] != "")
+		is.Equal("GET", fields["method"])
+		is.Equal("/admin/suppressions", fields["path"])
+		is.Equal("/admin/suppressions", fields["route"])
+		is.Equal(true, fields["admin"])
+	})
+
+	t.Run("logs the errors of handlers with the same fields, once each", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		s := &loggingSuppressionStoreMock{}
+		s.err = errors.New("oh no")
+		code := get(newMux(zap.New(core), s), "/admin/suppressions")
+		is.Equal(http.StatusInternalServerError, code)
+
+		entries := logs.FilterMessage("Error handling request").All()
+		is.Equal(1, len(entries))
+		is.Equal(entries[0].ContextMap()["requestID"], logs.FilterMessage("Listing suppressions").All()[0].ContextMap()["requestID"])
+		is.Equal("/admin/suppressions", entries[0].ContextMap()["route"])
+
+		// Fields given twice would be there twice in the JSON logs.
+		keys := map[string]int{}
+		for _, f := range entries[0].Context {
+			keys[f.Key]++
+		}
+		for key, n := range keys {
+			if n != 1 {
+				t.Errorf("%v logged %v times", key, n)
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	g "github.com/maragudk/gomponents"
+)
+
+// statusResponse is the JSON representation of a successful action.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// problem details for JSON error responses, as described in RFC 7807.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors for each invalid field, as an extension member.
+	Errors map[string]string `json:"errors,omitempty" doc:"The error of each invalid field, by the name of the field."`
+}
+
+// render the view as HTML with the status code, returning the error from rendering for HandleErrors.
+func render(w http.ResponseWriter, code int, view g.Node) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := view.Render(w); err != nil {
+		return fmt.Errorf("error rendering view: %w", err)
+	}
+	return nil
+}
+
+// respond with the status code and either the rendered view, or v marshalled as JSON, depending on wantsJSON.
+func respond(w http.ResponseWriter, r *http.Request, code int, view g.Node, v any) error {
+	if wantsJSON(r) {
+		writeJSON(w, code, v)
+		return nil
+	}
+	return render(w, code, view)
+}
+
+// respondError with the status code and either the rendered error view, or problem details with the detail as JSON,
+// depending on wantsJSON.
+func respondError(w http.ResponseWriter, r *http.Request, code int, view g.Node, detail string) error {
+	return respondProblem(w, r, view, problem{Status: code, Detail: detail})
+}
+
+// respondProblem with the status code of p, and either the rendered error view or p as JSON, like respondError.
+// The type and title of p default to the ones for a plain HTTP status code.
+func respondProblem(w http.ResponseWriter, r *http.Request, view g.Node, p problem) error {
+	if wantsJSON(r) {
+		writeProblem(w, p)
+		return nil
+	}
+	return render(w, p.Status, view)
+}
+
+// writeProblem p as JSON with its status code, for responses that are always JSON.
+// The type and title of p default to the ones for a plain HTTP status code.
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// wantsJSON is true if the request has the query parameter format=json, or if its Accept header prefers JSON to HTML.
+// Wildcards don't count as preferring JSON, so everything else gets HTML.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case "application/json", "application/problem+json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+	return jsonQ > htmlQ
+}
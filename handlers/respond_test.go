@@ -0,0 +1,71 @@
+package handlers_test
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRenderErrorsAreNotDiscarded like a vet check, because a view that fails to render halfway
+// leaves a truncated page that looks like a successful response. Return the error to HandleErrors instead.
+func TestRenderErrorsAreNotDiscarded(t *testing.T) {
+	fset := token.NewFileSet()
+	err := filepath.WalkDir("..", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name != ".." && (strings.HasPrefix(name, ".") || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if len(n.Rhs) == 1 && isRenderCall(n.Rhs[0]) && isBlank(n.Lhs) {
+					t.Errorf("%v: error from Render assigned to _", fset.Position(n.Pos()))
+				}
+			case *ast.ExprStmt:
+				if isRenderCall(n.X) {
+					t.Errorf("%v: error from Render not checked", fset.Position(n.Pos()))
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func isRenderCall(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "Render"
+}
+
+func isBlank(exprs []ast.Expr) bool {
+	for _, e := range exprs {
+		if ident, ok := e.(*ast.Ident); !ok || ident.Name != "_" {
+			return false
+		}
+	}
+	return true
+}
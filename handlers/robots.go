@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// RobotsOptions for Robots.
+type RobotsOptions struct {
+	// BaseURL of the app, for the sitemap URL, like "https://example.com".
+	BaseURL string
+	// Disallow these path prefixes for all crawlers.
+	Disallow []string
+	// DisallowAll crawling, for staging environments that shouldn't end up in search results.
+	DisallowAll bool
+}
+
+// Robots serves robots.txt for search engine crawlers.
+func Robots(mux chi.Router, opts RobotsOptions) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if opts.DisallowAll {
+		b.WriteString("Disallow: /\n")
+	} else {
+		for _, path := range opts.Disallow {
+			b.WriteString("Disallow: " + path + "\n")
+		}
+		if len(opts.Disallow) == 0 {
+			b.WriteString("Disallow:\n")
+		}
+		b.WriteString("\nSitemap: " + strings.TrimSuffix(opts.BaseURL, "/") + "/sitemap.xml\n")
+	}
+	robots := b.String()
+
+	mux.Get("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(robots))
+	})
+}
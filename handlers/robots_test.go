@@ -0,0 +1,45 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+)
+
+func TestRobots(t *testing.T) {
+	t.Run("disallows the given paths and links to the sitemap", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.Robots(mux, handlers.RobotsOptions{BaseURL: "https://example.com/", Disallow: []string{"/admin/", "/api/"}})
+
+		code, header, body := makeGetRequest(mux, "/robots.txt")
+		is.Equal(http.StatusOK, code)
+		is.Equal("text/plain; charset=utf-8", header.Get("Content-Type"))
+		is.Equal("User-agent: *\nDisallow: /admin/\nDisallow: /api/\n\nSitemap: https://example.com/sitemap.xml\n", body)
+	})
+
+	t.Run("allows everything without disallowed paths", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.Robots(mux, handlers.RobotsOptions{BaseURL: "https://example.com"})
+
+		_, _, body := makeGetRequest(mux, "/robots.txt")
+		is.Equal("User-agent: *\nDisallow:\n\nSitemap: https://example.com/sitemap.xml\n", body)
+	})
+
+	t.Run("disallows everything in staging mode", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.Robots(mux, handlers.RobotsOptions{BaseURL: "https://example.com", Disallow: []string{"/admin/"}, DisallowAll: true})
+
+		_, _, body := makeGetRequest(mux, "/robots.txt")
+		is.Equal("User-agent: *\nDisallow: /\n", body)
+	})
+}
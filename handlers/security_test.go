@@ -0,0 +1,63 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+	"canvas/views"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	policyNonce := regexp.MustCompile(`'nonce-([^']+)'`)
+	attributeNonce := regexp.MustCompile(`<script nonce="([^"]+)">`)
+
+	h := handlers.SecurityHeaders(handlers.SecurityHeadersOptions{ScriptSources: []string{"https://cdn.example.com"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(handlers.CSPNonce(r)))
+			_ = views.InlineScript(handlers.CSPNonce(r), "alert(1)").Render(w)
+		}))
+
+	get := func() (string, *httptest.ResponseRecorder) {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		return res.Header().Get("Content-Security-Policy"), res
+	}
+
+	t.Run("puts the same nonce in the policy, the request context, and inline scripts", func(t *testing.T) {
+		is := is.New(t)
+
+		policy, res := get()
+		header := policyNonce.FindStringSubmatch(policy)
+		is.True(header != nil)
+		attribute := attributeNonce.FindStringSubmatch(res.Body.String())
+		is.True(attribute != nil)
+
+		is.Equal(header[1], attribute[1])
+		is.Equal(`<script nonce="`+header[1]+`">alert(1)</script>`, res.Body.String()[len(header[1]):])
+		is.Equal(header[1], res.Body.String()[:len(header[1])])
+		is.Equal("script-src 'self' https://cdn.example.com 'nonce-"+header[1]+"'; object-src 'none'; base-uri 'self'", policy)
+		is.Equal("nosniff", res.Header().Get("X-Content-Type-Options"))
+	})
+
+	t.Run("has a different nonce for each request", func(t *testing.T) {
+		is := is.New(t)
+
+		first, _ := get()
+		second, _ := get()
+		is.True(first != second)
+		is.True(policyNonce.FindStringSubmatch(first)[1] != policyNonce.FindStringSubmatch(second)[1])
+	})
+}
+
+func TestCSPNonce(t *testing.T) {
+	t.Run("is empty without the middleware", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal("", handlers.CSPNonce(httptest.NewRequest(http.MethodGet, "/", nil)))
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/sns"
+	"canvas/storage"
+)
+
+// Defaults of how many transient bounces within how long suppress an address, if not set in the options.
+const (
+	defaultTransientBounceThreshold = 3
+	defaultTransientBounceWindow    = 7 * 24 * time.Hour
+)
+
+type suppressor interface {
+	RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error
+	RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error)
+	RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error
+}
+
+type snsVerifier interface {
+	Verify(ctx context.Context, m sns.Message) error
+}
+
+// SESWebhookOptions for SESWebhook.
+type SESWebhookOptions struct {
+	// Client for confirming SNS subscriptions. Defaults to a client with a 10 second timeout.
+	Client  *http.Client
+	Metrics *prometheus.Registry
+	// TransientBounceThreshold is how many transient bounces within TransientBounceWindow suppress an address.
+	// Defaults to 3 within 7 days.
+	TransientBounceThreshold int
+	TransientBounceWindow    time.Duration
+	// Verifier of SNS message signatures.
+	Verifier snsVerifier
+}
+
+// sesNotification posted by SES through SNS, about bounces, complaints, and deliveries.
+// Notifications set the NotificationType, and events from configuration sets the EventType.
+// Only the fields read are here, and which are set depends on the type.
+// See https://docs.aws.amazon.com/ses/latest/dg/notification-contents.html
+type sesNotification struct {
+	NotificationType string `json:"notificationType,omitempty" openapi:"enum=Bounce|Complaint|Delivery"`
+	EventType        string `json:"eventType,omitempty" openapi:"enum=Bounce|Complaint|Delivery"`
+	Bounce           struct {
+		BounceType        string         `json:"bounceType" openapi:"enum=Permanent|Transient|Undetermined"`
+		BouncedRecipients []sesRecipient `json:"bouncedRecipients"`
+	} `json:"bounce,omitempty"`
+	Complaint struct {
+		ComplainedRecipients []sesRecipient `json:"complainedRecipients"`
+	} `json:"complaint,omitempty"`
+	Delivery struct {
+		Recipients []string `json:"recipients"`
+	} `json:"delivery,omitempty"`
+	Mail struct {
+		MessageID string `json:"messageId"`
+	} `json:"mail"`
+}
+
+type sesRecipient struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// SESWebhook receives SES bounce, complaint, and delivery notifications from SNS at /ses on a router mounted at /webhooks,
+// so addresses that can't or don't want to get emails are suppressed and skipped in future sends.
+// Permanent bounces and complaints suppress right away, and transient bounces when they reach the threshold
+// within the window. Complaints also block signing up again. Bounces, complaints, and deliveries are recorded
+// on the send in the send log with the message ID of the notification.
+//
+// Messages must be signed by SNS, and are rejected with 403 Forbidden otherwise.
+// If the signing certificate can't be got, messages are rejected with 503 Service Unavailable, so SNS retries them.
+// Both rejections are counted in a metric. Subscription confirmations are confirmed by following the SubscribeURL,
+// which must be an SNS URL. Errors are responded to with a 5xx status code, so SNS retries the message later.
+func SESWebhook(mux chi.Router, s suppressor, log *zap.Logger, opts SESWebhookOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.TransientBounceThreshold <= 0 {
+		opts.TransientBounceThreshold = defaultTransientBounceThreshold
+	}
+	if opts.TransientBounceWindow <= 0 {
+		opts.TransientBounceWindow = defaultTransientBounceWindow
+	}
+	policy := storage.BouncePolicy{TransientThreshold: opts.TransientBounceThreshold, TransientWindow: opts.TransientBounceWindow}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_ses_webhook_rejected_total",
+		Help: "Number of SNS messages to the SES webhook rejected because of invalid signatures or unavailable certificates.",
+	}, []string{"reason"})
+	opts.Metrics.MustRegister(rejected)
+
+	mux.Post("/ses", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 256*1024))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var m sns.Message
+		if err := json.Unmarshal(body, &m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := opts.Verifier.Verify(r.Context(), m); err != nil {
+			if errors.Is(err, sns.ErrInvalidSignature) {
+				rejected.WithLabelValues("signature").Inc()
+				requestLog(r.Context(), log).Info("Invalid SNS message signature", zap.Error(err), zap.String("type", m.Type), zap.String("topicARN", m.TopicArn))
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			rejected.WithLabelValues("certificate").Inc()
+			logError(log, r, fmt.Errorf("error verifying SNS message signature: %w", err))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		switch m.Type {
+		case sns.TypeSubscriptionConfirmation:
+			if err := confirmSNSSubscription(r.Context(), opts.Client, m.SubscribeURL); err != nil {
+				logError(log, r, fmt.Errorf("error confirming SNS subscription to %v: %w", m.TopicArn, err))
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			requestLog(r.Context(), log).Info("Confirmed SNS subscription", zap.String("topicARN", m.TopicArn))
+
+		case sns.TypeUnsubscribeConfirmation:
+			requestLog(r.Context(), log).Info("Unsubscribed from SNS topic", zap.String("topicARN", m.TopicArn))
+
+		case sns.TypeNotification:
+			if err := handleSESNotification(r.Context(), s, log, policy, m.Message); err != nil {
+				logError(log, r, fmt.Errorf("error handling SES notification %v: %w", m.MessageId, err))
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func confirmSNSSubscription(ctx context.Context, client *http.Client, subscribeURL string) error {
+	if !sns.IsSNSURL(subscribeURL) {
+		return fmt.Errorf("subscribe URL %q is not an SNS URL", subscribeURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, subscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %v", res.StatusCode)
+	}
+	return nil
+}
+
+// handleSESNotification by suppressing the recipients of bounces and complaints, and recording deliveries.
+// Other notifications are ignored.
+func handleSESNotification(ctx context.Context, s suppressor, log *zap.Logger, policy storage.BouncePolicy, message string) error {
+	var n sesNotification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		// Retrying won't make the message parseable, so it's logged and acknowledged.
+		requestLog(ctx, log).Info("Error parsing SES notification", zap.Error(err))
+		return nil
+	}
+
+	notificationType := n.NotificationType
+	if notificationType == "" {
+		notificationType = n.EventType
+	}
+
+	switch notificationType {
+	case "Bounce":
+		for _, recipient := range n.Bounce.BouncedRecipients {
+			address, ok := parseSESAddress(recipient.EmailAddress)
+			if !ok {
+				continue
+			}
+			// Undetermined bounces count as transient, so a single one doesn't suppress an address that might work.
+			b := model.Bounce{Email: address, Type: model.BounceTypeTransient, ProviderMessageID: n.Mail.MessageID}
+			if n.Bounce.BounceType == "Permanent" {
+				b.Type = model.BounceTypePermanent
+			}
+			suppressed, err := s.RecordBounce(ctx, b, policy)
+			if err != nil {
+				return err
+			}
+			if suppressed {
+				requestLog(ctx, log).Info("Suppressed bounced address", zap.Stringer("email", address), zap.String("bounceType", string(b.Type)),
+					zap.String("messageID", n.Mail.MessageID))
+			}
+		}
+
+	case "Complaint":
+		for _, recipient := range n.Complaint.ComplainedRecipients {
+			address, ok := parseSESAddress(recipient.EmailAddress)
+			if !ok {
+				continue
+			}
+			if err := s.RecordComplaint(ctx, address, n.Mail.MessageID); err != nil {
+				return err
+			}
+			requestLog(ctx, log).Info("Suppressed address after complaint", zap.Stringer("email", address), zap.String("messageID", n.Mail.MessageID))
+		}
+
+	case "Delivery":
+		for _, recipient := range n.Delivery.Recipients {
+			address, ok := parseSESAddress(recipient)
+			if !ok {
+				continue
+			}
+			if err := s.RecordDelivery(ctx, address, n.Mail.MessageID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseSESAddress from a recipient, which can include a display name, like "Me <me@example.com>".
+func parseSESAddress(v string) (model.Email, bool) {
+	a, err := mail.ParseAddress(v)
+	if err != nil {
+		return "", false
+	}
+	address := model.Email(a.Address)
+	return address, address.IsValid()
+}
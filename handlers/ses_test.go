@@ -0,0 +1,347 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/sns"
+	"canvas/storage"
+)
+
+type suppressorMock struct {
+	err        error
+	suppressed map[model.Email]model.SuppressionReason
+	bounces    []model.Bounce
+	// complaintMessageIDs of the complaints, in the order they were recorded.
+	complaintMessageIDs []string
+	// deliveries of addresses and message IDs, in the order they were recorded.
+	deliveries []string
+	policy     storage.BouncePolicy
+}
+
+func newSuppressorMock() *suppressorMock {
+	return &suppressorMock{
+		suppressed: map[model.Email]model.SuppressionReason{},
+	}
+}
+
+// RecordComplaint and suppress like the database, overriding any earlier reason.
+func (s *suppressorMock) RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.suppressed[email] = model.SuppressionReasonComplained
+	s.complaintMessageIDs = append(s.complaintMessageIDs, providerMessageID)
+	return nil
+}
+
+// RecordBounce and suppress like the database, except that all transient bounces are within the window.
+func (s *suppressorMock) RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	s.bounces = append(s.bounces, b)
+	s.policy = p
+	var transient int
+	for _, bounce := range s.bounces {
+		if bounce.Email == b.Email && bounce.Type == model.BounceTypeTransient {
+			transient++
+		}
+	}
+	if b.Type == model.BounceTypePermanent || transient >= p.TransientThreshold {
+		if _, ok := s.suppressed[b.Email]; !ok {
+			s.suppressed[b.Email] = model.SuppressionReasonBounced
+		}
+	}
+	_, ok := s.suppressed[b.Email]
+	return ok, nil
+}
+
+func (s *suppressorMock) RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.deliveries = append(s.deliveries, email.String()+" "+providerMessageID)
+	return nil
+}
+
+// snsCertURL is the signing certificate URL in the fixtures.
+const snsCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-01d088a6f77103d0fe307c0069e40ed6.pem"
+
+// readSNSFixture from testdata/sns, which are captured SNS payloads without their signatures,
+// since SNS signs with a key only it has. The tests sign them with newSNSSigner instead.
+func readSNSFixture(t *testing.T, name string) sns.Message {
+	t.Helper()
+	b, err := os.ReadFile("testdata/sns/" + name + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m sns.Message
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// newSNSSigner returns a function that signs messages with a new key like SNS does, and returns them as JSON,
+// and a verifier that gets the self-signed certificate of the key from snsCertURL.
+// With certFailing, getting the certificate fails with 503 Service Unavailable.
+func newSNSSigner(t *testing.T, certFailing bool) (func(m sns.Message) string, *sns.Verifier) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if certFailing || r.URL.String() != snsCertURL {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(certPEM))}, nil
+	})}
+
+	sign := func(m sns.Message) string {
+		s, err := sns.StringToSign(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha1.Sum([]byte(s))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Signature = base64.StdEncoding.EncodeToString(signature)
+		b, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	return sign, sns.NewVerifier(sns.NewVerifierOptions{Client: client, RetryDelay: time.Millisecond})
+}
+
+func TestSESWebhook(t *testing.T) {
+	setup := func(t *testing.T, opts handlers.SESWebhookOptions) (chi.Router, *suppressorMock, func(sns.Message) string) {
+		mux := chi.NewMux()
+		s := newSuppressorMock()
+		sign, v := newSNSSigner(t, false)
+		if opts.Verifier == nil {
+			opts.Verifier = v
+		}
+		mux.Route("/webhooks", func(r chi.Router) {
+			handlers.SESWebhook(r, s, nil, opts)
+		})
+		return mux, s, sign
+	}
+
+	post := func(mux chi.Router, body string) int {
+		code, _, _ := makePostRequest(mux, "/webhooks/ses", http.Header{"Content-Type": {"text/plain; charset=UTF-8"}},
+			strings.NewReader(body))
+		return code
+	}
+
+	t.Run("suppresses the recipient of a permanent bounce", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+
+		code := post(mux, sign(readSNSFixture(t, "bounce-permanent")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(map[model.Email]model.SuppressionReason{"gone@example.com": model.SuppressionReasonBounced}, s.suppressed)
+		is.Equal(1, len(s.bounces))
+		is.Equal(model.BounceTypePermanent, s.bounces[0].Type)
+		is.True(s.bounces[0].ProviderMessageID != "")
+	})
+
+	t.Run("suppresses the recipient of a complaint", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+
+		code := post(mux, sign(readSNSFixture(t, "complaint")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(map[model.Email]model.SuppressionReason{"annoyed@example.com": model.SuppressionReasonComplained}, s.suppressed)
+		is.Equal(1, len(s.complaintMessageIDs))
+		is.True(s.complaintMessageIDs[0] != "")
+	})
+
+	t.Run("suppresses the recipient of transient bounces at the threshold", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{TransientBounceThreshold: 2, TransientBounceWindow: time.Hour})
+
+		code := post(mux, sign(readSNSFixture(t, "bounce-transient")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(1, len(s.bounces))
+		is.Equal(model.BounceTypeTransient, s.bounces[0].Type)
+		is.Equal(0, len(s.suppressed))
+		is.Equal(storage.BouncePolicy{TransientThreshold: 2, TransientWindow: time.Hour}, s.policy)
+
+		code = post(mux, sign(readSNSFixture(t, "bounce-transient")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(model.SuppressionReasonBounced, s.suppressed["full@example.com"])
+	})
+
+	t.Run("defaults to 3 transient bounces within 7 days", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+
+		code := post(mux, sign(readSNSFixture(t, "bounce-transient")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(storage.BouncePolicy{TransientThreshold: 3, TransientWindow: 7 * 24 * time.Hour}, s.policy)
+	})
+
+	t.Run("records deliveries by message ID, without suppressing", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+
+		code := post(mux, sign(readSNSFixture(t, "delivery")))
+		is.Equal(http.StatusOK, code)
+		is.Equal([]string{"me@example.com 0100018506b2c3d4-5e6f7a8b-9c0d-1e2f-3a4b-5c6d7e8f9a0b-000000"}, s.deliveries)
+		is.Equal(0, len(s.suppressed))
+		is.Equal(0, len(s.bounces))
+	})
+
+	t.Run("errors so SNS retries if recording a delivery fails", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+		s.err = errors.New("oh no")
+
+		code := post(mux, sign(readSNSFixture(t, "delivery")))
+		is.Equal(http.StatusInternalServerError, code)
+	})
+
+	t.Run("confirms a subscription by getting the subscribe URL", func(t *testing.T) {
+		is := is.New(t)
+		var requested []string
+		client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			requested = append(requested, r.URL.String())
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		})}
+		mux, _, sign := setup(t, handlers.SESWebhookOptions{Client: client})
+
+		code := post(mux, sign(readSNSFixture(t, "subscription-confirmation")))
+		is.Equal(http.StatusOK, code)
+		is.Equal(1, len(requested))
+		is.True(strings.HasPrefix(requested[0], "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&"))
+	})
+
+	t.Run("does not follow a subscribe URL that's not on SNS", func(t *testing.T) {
+		is := is.New(t)
+		var requested int
+		client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			requested++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		})}
+		mux, _, sign := setup(t, handlers.SESWebhookOptions{Client: client})
+
+		m := readSNSFixture(t, "subscription-confirmation")
+		m.SubscribeURL = strings.Replace(m.SubscribeURL, "https://sns.us-east-1.amazonaws.com/", "https://example.com/", 1)
+		code := post(mux, sign(m))
+		is.Equal(http.StatusBadGateway, code)
+		is.Equal(0, requested)
+	})
+
+	t.Run("rejects messages with an invalid signature, and counts them", func(t *testing.T) {
+		is := is.New(t)
+		registry := prometheus.NewRegistry()
+		mux, s, _ := setup(t, handlers.SESWebhookOptions{Metrics: registry})
+
+		// Signed with another key.
+		sign, _ := newSNSSigner(t, false)
+		code := post(mux, sign(readSNSFixture(t, "bounce-permanent")))
+		is.Equal(http.StatusForbidden, code)
+		is.Equal(0, len(s.suppressed))
+		is.Equal(1.0, getRejectedCount(t, registry, "signature"))
+	})
+
+	t.Run("rejects messages so SNS retries if the certificate can't be got, and counts them", func(t *testing.T) {
+		is := is.New(t)
+		registry := prometheus.NewRegistry()
+		sign, v := newSNSSigner(t, true)
+		mux, s, _ := setup(t, handlers.SESWebhookOptions{Metrics: registry, Verifier: v})
+
+		code := post(mux, sign(readSNSFixture(t, "bounce-permanent")))
+		is.Equal(http.StatusServiceUnavailable, code)
+		is.Equal(0, len(s.suppressed))
+		is.Equal(1.0, getRejectedCount(t, registry, "certificate"))
+	})
+
+	t.Run("rejects invalid JSON", func(t *testing.T) {
+		is := is.New(t)
+		mux, _, _ := setup(t, handlers.SESWebhookOptions{})
+
+		code := post(mux, "{")
+		is.Equal(http.StatusBadRequest, code)
+	})
+
+	t.Run("errors so SNS retries if suppressing fails", func(t *testing.T) {
+		is := is.New(t)
+		mux, s, sign := setup(t, handlers.SESWebhookOptions{})
+		s.err = errors.New("oh no")
+
+		code := post(mux, sign(readSNSFixture(t, "complaint")))
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
+
+func getRejectedCount(t *testing.T, registry *prometheus.Registry, reason string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "app_ses_webhook_rejected_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "reason" && l.GetValue() == reason {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// roundTripperFunc serves requests of an http.Client without a network.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
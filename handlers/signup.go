@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/flags"
+	"canvas/form"
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+	"canvas/throttle"
+	"canvas/views"
+)
+
+type signupper interface {
+	IsSubscribed(ctx context.Context, email model.Email) (bool, error)
+	ResendConfirmation(ctx context.Context, email model.Email) (bool, error)
+	SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error)
+}
+
+type throttler interface {
+	Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+// SignupServiceOptions for NewSignupService.
+type SignupServiceOptions struct {
+	// Captcha verifies the captcha in the signup form. Without it, the form has no captcha.
+	Captcha CaptchaVerifier
+	// CaptchaFailOpen lets signups through if the captcha can't be verified, like when the captcha service is down.
+	// Otherwise, they get an error asking to try again.
+	CaptchaFailOpen bool
+	// EmailPolicy for the addresses signed up. With it enabled, addresses are normalized, variants of an address
+	// share its limits and subscription, and disposable addresses get an error. It's off by default.
+	EmailPolicy model.EmailPolicy
+	// FormSecret verifies the signed timestamp in the signup form.
+	FormSecret []byte
+	// MaxConfirmationsPerEmail is how many confirmation emails an address can get per day. Defaults to 3.
+	MaxConfirmationsPerEmail int
+	// MaxFormAge is how long after rendering the form can be submitted, so a signed timestamp can't be reused
+	// by bots forever. Defaults to 24 hours.
+	MaxFormAge time.Duration
+	// MaxSignupsPerIP is how many signups can come from an IP address per hour. Defaults to 10.
+	MaxSignupsPerIP int
+	Metrics         *prometheus.Registry
+	// MinFillTime is how long it takes a person to fill out the form, at the least. Defaults to 2 seconds.
+	MinFillTime time.Duration
+	// Now is for the age of submitted forms and the timestamp of rendered ones. Defaults to time.Now.
+	Now func() time.Time
+	// PartnerOrigins are the origins of partner sites with the embedded signup form, like "https://example.com".
+	// Signups from them through the JSON API are recorded with the origin as their source.
+	PartnerOrigins []string
+	// Throttle counts signups for the limits. Defaults to a throttle.MemoryStore.
+	Throttle throttler
+}
+
+// SignupService has the newsletter signup logic shared by NewsletterSignup and NewsletterSignupAPI,
+// so validation, throttling, and storage work the same for both.
+type SignupService struct {
+	captchas  *prometheus.CounterVec
+	dropped   *prometheus.CounterVec
+	log       *zap.Logger
+	opts      SignupServiceOptions
+	partners  map[string]bool
+	s         signupper
+	throttled *prometheus.CounterVec
+}
+
+// NewSignupService with signups stored by s.
+// The signupper is responsible for getting the confirmation email sent, in the same transaction as the signup.
+func NewSignupService(s signupper, log *zap.Logger, opts SignupServiceOptions) *SignupService {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.MinFillTime <= 0 {
+		opts.MinFillTime = 2 * time.Second
+	}
+	if opts.MaxFormAge <= 0 {
+		opts.MaxFormAge = 24 * time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.MaxConfirmationsPerEmail <= 0 {
+		opts.MaxConfirmationsPerEmail = 3
+	}
+	if opts.MaxSignupsPerIP <= 0 {
+		opts.MaxSignupsPerIP = 10
+	}
+	if opts.Throttle == nil {
+		opts.Throttle = throttle.NewMemoryStore(throttle.NewMemoryStoreOptions{})
+	}
+
+	dropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_signup_spam_dropped_total",
+		Help: "Number of newsletter signups dropped as spam, by reason.",
+	}, []string{"reason"})
+	throttled := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_signup_throttled_total",
+		Help: "Number of newsletter signups throttled, by what they were throttled by.",
+	}, []string{"by"})
+	captchas := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_signup_captcha_total",
+		Help: "Number of newsletter signup captcha verifications, by result.",
+	}, []string{"result"})
+	opts.Metrics.MustRegister(dropped, throttled, captchas)
+
+	partners := map[string]bool{}
+	for _, origin := range opts.PartnerOrigins {
+		partners[origin] = true
+	}
+
+	return &SignupService{
+		captchas:  captchas,
+		dropped:   dropped,
+		log:       log,
+		opts:      opts,
+		partners:  partners,
+		s:         s,
+		throttled: throttled,
+	}
+}
+
+type signupResult int
+
+const (
+	signupResultCreated signupResult = iota
+	signupResultAlreadySubscribed
+	signupResultInvalid
+	signupResultThrottled
+	signupResultError
+)
+
+// signup the email address in the form, from the given client IP address and source, in the locale of the translator in ctx.
+// Too many signups for one address are reported as created, but don't send a confirmation email,
+// so the signup can't be used to flood someone's inbox. Signups of addresses that complained are reported as created too,
+// so the result doesn't tell that they did.
+// With the email policy enabled, disposable addresses are invalid.
+// The error is only set for signupResultError.
+func (s *SignupService) signup(ctx context.Context, ip, source string, f *form.Form) (signupResult, error) {
+	f.Required("email")
+	email := s.opts.EmailPolicy.Normalize(f.Email("email"))
+	if f.Valid() && s.opts.EmailPolicy.IsDisposable(email) {
+		requestLog(ctx, s.log).Info("Rejecting newsletter signup with disposable email address")
+		f.AddError("email", i18n.FromContext(ctx).T("signup.disposable_email"))
+	}
+	if !f.Valid() {
+		return signupResultInvalid, nil
+	}
+
+	if s.isThrottled(ctx, "signup-ip:"+ip, s.opts.MaxSignupsPerIP, time.Hour) {
+		s.throttled.WithLabelValues("ip").Inc()
+		return signupResultThrottled, nil
+	}
+
+	subscribed, err := s.isSubscribed(ctx, email)
+	if err != nil {
+		return signupResultError, fmt.Errorf("error checking newsletter subscription: %w", err)
+	}
+	if subscribed {
+		return signupResultAlreadySubscribed, nil
+	}
+
+	if s.isThrottled(ctx, s.emailThrottleKey(email), s.opts.MaxConfirmationsPerEmail, 24*time.Hour) {
+		requestLog(ctx, s.log).Info("Skipping confirmation email, too many signups for address")
+		s.throttled.WithLabelValues("email").Inc()
+		return signupResultCreated, nil
+	}
+
+	if _, err := s.s.SignupForNewsletter(ctx, email, i18n.FromContext(ctx).Locale(), source); err != nil {
+		if errors.Is(err, storage.ErrComplained) {
+			requestLog(ctx, s.log).Info("Skipping signup, address complained about an email before")
+			return signupResultCreated, nil
+		}
+		return signupResultError, fmt.Errorf("error signing up for newsletter: %w", err)
+	}
+	return signupResultCreated, nil
+}
+
+// resend the confirmation email to the pending subscriber with the email address in the form, from the given client IP address.
+// Addresses that aren't pending, and addresses over the daily confirmation email limit, are reported as created too,
+// so the result doesn't tell whether an address has signed up. The error is only set for signupResultError.
+func (s *SignupService) resend(ctx context.Context, ip string, f *form.Form) (signupResult, error) {
+	f.Required("email")
+	email := s.opts.EmailPolicy.Normalize(f.Email("email"))
+	if !f.Valid() {
+		return signupResultInvalid, nil
+	}
+
+	if s.isThrottled(ctx, "signup-ip:"+ip, s.opts.MaxSignupsPerIP, time.Hour) {
+		s.throttled.WithLabelValues("ip").Inc()
+		return signupResultThrottled, nil
+	}
+
+	// Shares the limit with signups, so alternating between signing up and resending doesn't get more emails through.
+	if s.isThrottled(ctx, s.emailThrottleKey(email), s.opts.MaxConfirmationsPerEmail, 24*time.Hour) {
+		requestLog(ctx, s.log).Info("Skipping confirmation email resend, too many confirmation emails for address")
+		s.throttled.WithLabelValues("email").Inc()
+		return signupResultCreated, nil
+	}
+
+	resent, err := s.s.ResendConfirmation(ctx, email)
+	if err != nil {
+		return signupResultError, fmt.Errorf("error resending confirmation email: %w", err)
+	}
+	if !resent {
+		requestLog(ctx, s.log).Info("Skipping confirmation email resend, address isn't waiting to be confirmed")
+	}
+	return signupResultCreated, nil
+}
+
+// isSubscribed address, or the canonical address of its mailbox under the email policy,
+// so a variant of a subscribed address, like with a plus tag, doesn't sign up the mailbox again.
+func (s *SignupService) isSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	subscribed, err := s.s.IsSubscribed(ctx, email)
+	if err != nil || subscribed {
+		return subscribed, err
+	}
+	if canonical := s.opts.EmailPolicy.Canonical(email); canonical != email {
+		return s.s.IsSubscribed(ctx, canonical)
+	}
+	return false, nil
+}
+
+// emailThrottleKey for the confirmation emails to the mailbox of the address, which its variants share.
+func (s *SignupService) emailThrottleKey(email model.Email) string {
+	return "signup-email:" + s.opts.EmailPolicy.Canonical(email).String()
+}
+
+// source of an API signup, which is the origin of the request if it's from a partner site.
+// The embedded signup form is framed on the partner site, so its requests have this site as the origin.
+// It sends the partner origin it was framed by instead, which is taken if it's a partner origin too.
+func (s *SignupService) source(origin, claimed string) string {
+	switch {
+	case s.isPartner(origin):
+		return origin
+	case s.isPartner(claimed):
+		return claimed
+	default:
+		return ""
+	}
+}
+
+// isPartner origin, which is any origin if the partner origins have "*".
+func (s *SignupService) isPartner(origin string) bool {
+	return origin != "" && (s.partners["*"] || s.partners[origin])
+}
+
+// isThrottled is false if counting fails, so a broken throttle store doesn't stop signups.
+func (s *SignupService) isThrottled(ctx context.Context, key string, limit int, window time.Duration) bool {
+	throttled, err := s.opts.Throttle.Throttle(ctx, key, limit, window)
+	if err != nil {
+		requestLog(ctx, s.log).Info("Error throttling newsletter signup", zap.Error(err))
+		return false
+	}
+	return throttled
+}
+
+// spamReason for dropping the signup form submission, or the empty string if it looks like it's from a person.
+// Dropped submissions are counted.
+func (s *SignupService) spamReason(ctx context.Context, f *form.Form, timestamp string) string {
+	reason := ""
+	if f.String(views.HoneypotFieldName) != "" {
+		reason = "honeypot"
+	} else if renderedAt, err := form.VerifyTimestamp(s.opts.FormSecret, timestamp); err != nil {
+		reason = "invalid_timestamp"
+	} else if age := s.opts.Now().Sub(renderedAt); age < s.opts.MinFillTime {
+		reason = "too_fast"
+	} else if age > s.opts.MaxFormAge {
+		reason = "expired_timestamp"
+	}
+	if reason != "" {
+		requestLog(ctx, s.log).Info("Dropping newsletter signup as spam", zap.String("reason", reason))
+		s.dropped.WithLabelValues(reason).Inc()
+	}
+	return reason
+}
+
+// verifyCaptcha in the form submitted from ip, if there's a captcha. Returns ErrCaptchaFailed for a bad response,
+// and other errors if it couldn't be verified and CaptchaFailOpen isn't set.
+func (s *SignupService) verifyCaptcha(ctx context.Context, ip string, f *form.Form) error {
+	if s.opts.Captcha == nil {
+		return nil
+	}
+	err := s.opts.Captcha.Verify(ctx, f.String(s.opts.Captcha.Widget().ResponseFieldName()), ip)
+	switch {
+	case err == nil:
+		s.captchas.WithLabelValues("passed").Inc()
+	case errors.Is(err, ErrCaptchaFailed):
+		s.captchas.WithLabelValues("failed").Inc()
+		requestLog(ctx, s.log).Info("Newsletter signup captcha failed", zap.Error(err))
+		return err
+	default:
+		s.captchas.WithLabelValues("error").Inc()
+		requestLog(ctx, s.log).Info("Error verifying newsletter signup captcha", zap.Error(err), zap.Bool("failOpen", s.opts.CaptchaFailOpen))
+		if !s.opts.CaptchaFailOpen {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewsletterSignup signs up the email address from the form, and redirects to the front page with a thanks flash message.
+// An invalid form re-renders the front page with the errors next to the fields, and the entered values.
+// Submissions by bots, which fill out the honeypot field, submit faster than the minimum fill time,
+// or don't have a valid timestamp, are dropped. They get the same redirect as a signup, so bots can't tell.
+// Already subscribed addresses get the same redirect too.
+// Too many signups from one IP address get a 429 Too Many Requests.
+// With a captcha, a failed one re-renders the front page with an error and 400 Bad Request,
+// and one that couldn't be verified gets 503 Service Unavailable, unless the service fails open.
+func NewsletterSignup(mux chi.Router, svc *SignupService) {
+	mux.Post("/newsletter/signup", HandleErrors(svc.log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			page := views.PageData{Flags: flags.FromContext(r.Context()), Translator: i18n.FromContext(r.Context())}
+			return render(w, http.StatusBadRequest, views.FrontPage(page, CSRFToken(r),
+				form.CreateTimestamp(svc.opts.FormSecret, svc.opts.Now()), captchaWidget(svc.opts.Captcha), nil))
+		}
+
+		timestamp := f.String(views.TimestampFieldName)
+		if svc.spamReason(r.Context(), f, timestamp) != "" {
+			redirectToThanks(w, r)
+			return nil
+		}
+
+		if err := svc.verifyCaptcha(r.Context(), clientIP(r), f); err != nil {
+			t := i18n.FromContext(r.Context())
+			code, message := http.StatusBadRequest, t.T("signup.captcha_failed")
+			if !errors.Is(err, ErrCaptchaFailed) {
+				code, message = http.StatusServiceUnavailable, t.T("signup.captcha_unavailable")
+			}
+			// So the entered address is kept in the re-rendered form.
+			_ = f.String("email")
+			f.AddError(views.CaptchaFieldName, message)
+			page := views.PageData{Flags: flags.FromContext(r.Context()), Translator: t}
+			return render(w, code, views.FrontPage(page, CSRFToken(r), timestamp,
+				captchaWidget(svc.opts.Captcha), f.State()))
+		}
+
+		result, err := svc.signup(r.Context(), clientIP(r), "", f)
+		switch result {
+		case signupResultCreated, signupResultAlreadySubscribed:
+			redirectToThanks(w, r)
+		case signupResultInvalid:
+			page := views.PageData{Flags: flags.FromContext(r.Context()), Translator: i18n.FromContext(r.Context())}
+			return render(w, http.StatusBadRequest, views.FrontPage(page, CSRFToken(r), timestamp,
+				captchaWidget(svc.opts.Captcha), f.State()))
+		case signupResultThrottled:
+			return render(w, http.StatusTooManyRequests, views.TooManySignupsPage("/newsletter/signup"))
+		default:
+			return err
+		}
+		return nil
+	}))
+}
+
+// confirmationValidDays is how many days the link in the confirmation email is valid, for telling people.
+const confirmationValidDays = int(storage.ConfirmationTokenLifetime / (24 * time.Hour))
+
+// redirectToThanks on the front page with a flash message, or to the thanks page if there's no session for the flash.
+func redirectToThanks(w http.ResponseWriter, r *http.Request) {
+	err := sessions.AddFlash(r.Context(), sessions.FlashSuccess, i18n.FromContext(r.Context()).T("signup.thanks_flash"))
+	if err != nil {
+		http.Redirect(w, r, "/newsletter/thanks", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// NewsletterResend shows a form for sending the confirmation email again at /newsletter/resend, for people who lost it.
+// Submitting it always shows the same page, whether or not the address is waiting to be confirmed,
+// so it can't be used to find out who has signed up. Resends count toward the same limits as signups.
+func NewsletterResend(mux chi.Router, svc *SignupService) {
+	mux.Get("/newsletter/resend", HandleErrors(svc.log, func(w http.ResponseWriter, r *http.Request) error {
+		return render(w, http.StatusOK, views.NewsletterResendPage(i18n.FromContext(r.Context()), "/newsletter/resend", CSRFToken(r), nil))
+	}))
+
+	mux.Post("/newsletter/resend", HandleErrors(svc.log, func(w http.ResponseWriter, r *http.Request) error {
+		f, err := form.Parse(r)
+		if err != nil {
+			return render(w, http.StatusBadRequest,
+				views.NewsletterResendPage(i18n.FromContext(r.Context()), "/newsletter/resend", CSRFToken(r), nil))
+		}
+
+		result, err := svc.resend(r.Context(), clientIP(r), f)
+		switch result {
+		case signupResultCreated:
+			return render(w, http.StatusOK,
+				views.NewsletterResentPage(i18n.FromContext(r.Context()), "/newsletter/resend", confirmationValidDays))
+		case signupResultInvalid:
+			return render(w, http.StatusBadRequest,
+				views.NewsletterResendPage(i18n.FromContext(r.Context()), "/newsletter/resend", CSRFToken(r), f.State()))
+		case signupResultThrottled:
+			return render(w, http.StatusTooManyRequests, views.TooManySignupsPage("/newsletter/resend"))
+		default:
+			return err
+		}
+	}))
+}
+
+type signupRequest struct {
+	Email string `json:"email" openapi:"format=email"`
+	// Source is the partner origin the embedded signup form was framed by, if any.
+	Source string `json:"source,omitempty" doc:"The partner origin the embedded signup form was framed by, if any."`
+	// Captcha is the response token of the captcha widget, which is required if there's a captcha.
+	Captcha string `json:"captcha,omitempty" doc:"The response token of the captcha widget, required if signups have a captcha."`
+}
+
+type signupResponse struct {
+	Status string            `json:"status,omitempty" openapi:"enum=created|already_subscribed"`
+	Error  string            `json:"error,omitempty" doc:"What went wrong, for requests that aren't valid JSON, throttled ones, and errors."`
+	Errors map[string]string `json:"errors,omitempty" doc:"The error of each invalid field, by the name of the field."`
+}
+
+// NewsletterSignupAPI signs up the email address in the JSON body {"email": "..."}, for widgets and apps,
+// at /newsletter/signup on a router mounted at /api.
+// It responds with 201 Created for new signups, 200 OK for addresses already subscribed,
+// and 422 Unprocessable Entity with the errors per field for invalid requests.
+// The body is decoded with DecodeJSON, and its errors are responded to with their status codes and field errors.
+// It never sets cookies, and doesn't have the honeypot and timestamp checks of the HTML form. With a captcha,
+// the response token of its widget must be in the captcha field, or the signup gets 422 Unprocessable Entity
+// with the error for the field, and 503 Service Unavailable if it can't be verified, unless CaptchaFailOpen is set.
+// Signups from partner origins, directly or through the embedded signup form, are recorded with the origin as their source.
+func NewsletterSignupAPI(mux chi.Router, svc *SignupService) {
+	mux.Post("/newsletter/signup", func(w http.ResponseWriter, r *http.Request) {
+		req, err := DecodeJSON[signupRequest](w, r)
+		var jsonErr *JSONError
+		switch {
+		case errors.As(err, &jsonErr):
+			writeJSON(w, jsonErr.Status, signupResponse{Error: jsonErr.Detail, Errors: jsonErr.Errors})
+			return
+		case err != nil:
+			reference := logError(svc.log, r, err)
+			writeJSON(w, http.StatusInternalServerError, signupResponse{Error: "Something went wrong. Reference " + reference + "."})
+			return
+		}
+
+		f := form.New(url.Values{"email": {req.Email}})
+		if svc.opts.Captcha != nil {
+			f = form.New(url.Values{"email": {req.Email}, svc.opts.Captcha.Widget().ResponseFieldName(): {req.Captcha}})
+		}
+		if err := svc.verifyCaptcha(r.Context(), clientIP(r), f); err != nil {
+			t := i18n.FromContext(r.Context())
+			if errors.Is(err, ErrCaptchaFailed) {
+				writeJSON(w, http.StatusUnprocessableEntity,
+					signupResponse{Errors: map[string]string{views.CaptchaFieldName: t.T("signup.captcha_failed")}})
+				return
+			}
+			writeJSON(w, http.StatusServiceUnavailable, signupResponse{Error: t.T("signup.captcha_unavailable")})
+			return
+		}
+
+		result, err := svc.signup(r.Context(), clientIP(r), svc.source(r.Header.Get("Origin"), req.Source), f)
+		switch result {
+		case signupResultCreated:
+			writeJSON(w, http.StatusCreated, signupResponse{Status: "created"})
+		case signupResultAlreadySubscribed:
+			writeJSON(w, http.StatusOK, signupResponse{Status: "already_subscribed"})
+		case signupResultInvalid:
+			writeJSON(w, http.StatusUnprocessableEntity, signupResponse{Errors: f.State().Errors})
+		case signupResultThrottled:
+			writeJSON(w, http.StatusTooManyRequests, signupResponse{Error: "Too many signups. Please try again later."})
+		default:
+			reference := logError(svc.log, r, err)
+			writeJSON(w, http.StatusInternalServerError, signupResponse{Error: "Something went wrong. Reference " + reference + "."})
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
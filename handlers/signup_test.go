@@ -0,0 +1,624 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+
+	"canvas/form"
+	"canvas/handlers"
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/storage"
+)
+
+// signupperMock records signups. Like the database, it enqueues the confirmation email job as part of the signup.
+type signupperMock struct {
+	// complained addresses can't sign up, like in the database.
+	complained map[model.Email]bool
+	email      model.Email
+	err        error
+	locale     string
+	pending    map[model.Email]bool
+	queued     []model.Message
+	source     string
+	subscribed map[model.Email]bool
+}
+
+func (s *signupperMock) IsSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	return s.subscribed[email], nil
+}
+
+// ResendConfirmation enqueues the confirmation email job for pending addresses only, like the database.
+func (s *signupperMock) ResendConfirmation(ctx context.Context, email model.Email) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	if !s.pending[email] {
+		return false, nil
+	}
+	s.queued = append(s.queued, model.Message{"job": "confirmation_email", "email": email.String(), "token": "456"})
+	return true, nil
+}
+
+func (s *signupperMock) SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	if s.complained[email] {
+		return "", storage.ErrComplained
+	}
+	s.email = email
+	s.locale = locale
+	s.source = source
+	s.queued = append(s.queued, model.Message{"job": "confirmation_email", "email": email.String(), "token": "123"})
+	return "123", nil
+}
+
+func TestNewsletterSignup(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	opts := handlers.SignupServiceOptions{FormSecret: secret, Now: func() time.Time { return now }}
+	timestamp := "&rendered_at=" + url.QueryEscape(form.CreateTimestamp(secret, now.Add(-5*time.Second)))
+
+	t.Run("signs up a valid email address, enqueues the confirmation email, and redirects", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/newsletter/thanks", header.Get("Location"))
+		is.Equal(model.Email("me@example.com"), s.email)
+		is.Equal(1, len(s.queued))
+	})
+
+	t.Run("stores the locale of the request with the signup", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		mux.Use(handlers.Localize(i18n.Default(), nil))
+		s := &signupperMock{}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		header := createFormHeader()
+		header.Set("Accept-Language", "de-DE")
+		code, _, _ := makePostRequest(mux, "/newsletter/signup", header, strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusFound, code)
+		is.Equal("de", s.locale)
+	})
+
+	t.Run("shows a thanks flash on the front page after the redirect, once", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: secret,
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+		mux.Use(m.Middleware)
+		handlers.FrontPage(mux, nil, secret, "https://example.com", nil)
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(&signupperMock{}, zap.NewNop(), opts))
+
+		code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/", header.Get("Location"))
+		cookies := (&http.Response{Header: header}).Cookies()
+		is.Equal(1, len(cookies))
+
+		getFront := func() string {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookies[0])
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+			return res.Body.String()
+		}
+
+		body := getFront()
+		is.Equal(1, strings.Count(body, "Thanks for signing up!"))
+		is.True(strings.Contains(body, `data-flash="success"`))
+
+		body = getFront()
+		is.True(!strings.Contains(body, "Thanks for signing up!"))
+	})
+
+	t.Run("re-renders the front page with the error and the entered value for an invalid email address", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, _, body := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=%3Cscript%3Enotanemail"+timestamp))
+		is.Equal(http.StatusBadRequest, code)
+		is.True(strings.Contains(body, "doesn&#39;t look like an email address"))
+		is.True(strings.Contains(body, `value="&lt;script&gt;notanemail"`))
+		is.True(!strings.Contains(body, "<script>notanemail"))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("shows a required error next to the field for an empty email address", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, _, body := makePostRequest(mux, "/newsletter/signup", createFormHeader(), strings.NewReader("email="+timestamp))
+		is.Equal(http.StatusBadRequest, code)
+		is.True(strings.Contains(body, `<p id="email-error" class="text-sm text-red-600">Please fill this in.</p>`))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("redirects an already subscribed address without sending a confirmation email", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{subscribed: map[model.Email]bool{"me@example.com": true}}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/newsletter/thanks", header.Get("Location"))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("redirects an address that complained like a signup, without sending a confirmation email", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{complained: map[model.Email]bool{"me@example.com": true}}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusFound, code)
+		is.Equal("/newsletter/thanks", header.Get("Location"))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("renders an error page if signing up fails", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{err: errors.New("database is down")}
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		code, _, body := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com"+timestamp))
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(strings.Contains(body, "Something went wrong"))
+		is.True(!strings.Contains(body, "database is down"))
+	})
+
+	t.Run("rejects a burst of signups from one IP address after the limit, and counts it", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{}
+		registry := prometheus.NewRegistry()
+		opts := opts
+		opts.MaxSignupsPerIP = 3
+		opts.Metrics = registry
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		for i := 0; i < 5; i++ {
+			code, _, body := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+				strings.NewReader("email=me"+strconv.Itoa(i)+"%40example.com"+timestamp))
+			if i < 3 {
+				is.Equal(http.StatusFound, code)
+				continue
+			}
+			is.Equal(http.StatusTooManyRequests, code)
+			is.True(strings.Contains(body, "Too many signups"))
+		}
+		is.Equal(3, len(s.queued))
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_signup_throttled_total Number of newsletter signups throttled, by what they were throttled by.
+# TYPE app_signup_throttled_total counter
+app_signup_throttled_total{by="ip"} 2
+`), "app_signup_throttled_total")
+		is.NoErr(err)
+	})
+
+	t.Run("stops sending confirmation emails to one address after the limit, without telling", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		s := &signupperMock{}
+		registry := prometheus.NewRegistry()
+		opts := opts
+		opts.MaxConfirmationsPerEmail = 2
+		opts.Metrics = registry
+		handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+		for i := 0; i < 4; i++ {
+			code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+				strings.NewReader("email=me%40example.com"+timestamp))
+			is.Equal(http.StatusFound, code)
+			is.Equal("/newsletter/thanks", header.Get("Location"))
+		}
+		is.Equal(2, len(s.queued))
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_signup_throttled_total Number of newsletter signups throttled, by what they were throttled by.
+# TYPE app_signup_throttled_total counter
+app_signup_throttled_total{by="email"} 2
+`), "app_signup_throttled_total")
+		is.NoErr(err)
+	})
+
+	spam := []struct {
+		name   string
+		body   string
+		reason string
+	}{
+		{"drops a submission with the honeypot filled out", "email=me%40example.com&website=http%3A%2F%2Fspam.example.com" + timestamp, "honeypot"},
+		{"drops a submission faster than the minimum fill time", "email=me%40example.com&rendered_at=" +
+			url.QueryEscape(form.CreateTimestamp(secret, now.Add(-time.Second))), "too_fast"},
+		{"drops a submission with a timestamp older than the maximum form age", "email=me%40example.com&rendered_at=" +
+			url.QueryEscape(form.CreateTimestamp(secret, now.Add(-25*time.Hour))), "expired_timestamp"},
+		{"drops a submission with a forged timestamp signature", "email=me%40example.com&rendered_at=" +
+			url.QueryEscape(form.CreateTimestamp([]byte("guess"), now.Add(-time.Minute))), "invalid_timestamp"},
+		{"drops a submission without a timestamp", "email=me%40example.com", "invalid_timestamp"},
+	}
+	for _, test := range spam {
+		t.Run(test.name+" with the normal redirect, and counts it", func(t *testing.T) {
+			is := is.New(t)
+
+			mux := chi.NewMux()
+			s := &signupperMock{}
+			registry := prometheus.NewRegistry()
+			opts := opts
+			opts.Metrics = registry
+			handlers.NewsletterSignup(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+
+			code, header, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(), strings.NewReader(test.body))
+			is.Equal(http.StatusFound, code)
+			is.Equal("/newsletter/thanks", header.Get("Location"))
+			is.Equal(0, len(s.queued))
+
+			err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_signup_spam_dropped_total Number of newsletter signups dropped as spam, by reason.
+# TYPE app_signup_spam_dropped_total counter
+app_signup_spam_dropped_total{reason="`+test.reason+`"} 1
+`), "app_signup_spam_dropped_total")
+			is.NoErr(err)
+		})
+	}
+}
+
+func TestNewsletterSignupAPI(t *testing.T) {
+	newMux := func(s *signupperMock) chi.Router {
+		mux := chi.NewMux()
+		mux.Route("/api", func(r chi.Router) {
+			handlers.NewsletterSignupAPI(r, handlers.NewSignupService(s, zap.NewNop(), handlers.SignupServiceOptions{MaxSignupsPerIP: 2}))
+		})
+		return mux
+	}
+
+	jsonHeader := func() http.Header {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json; charset=utf-8")
+		return header
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		body       string
+		subscribed bool
+		code       int
+		response   string
+		queued     int
+	}{
+		{"creates a signup", jsonHeader(), `{"email": "me@example.com"}`, false,
+			http.StatusCreated, `{"status":"created"}`, 1},
+		{"says so if already subscribed, without sending a confirmation email", jsonHeader(), `{"email": "me@example.com"}`, true,
+			http.StatusOK, `{"status":"already_subscribed"}`, 0},
+		{"responds with field errors for an invalid email address", jsonHeader(), `{"email": "notanemail"}`, false,
+			http.StatusUnprocessableEntity, `{"errors":{"email":"That doesn't look like an email address. Please check it and try again."}}`, 0},
+		{"responds with field errors for a missing email address", jsonHeader(), `{}`, false,
+			http.StatusUnprocessableEntity, `{"errors":{"email":"Please fill this in."}}`, 0},
+		{"rejects malformed JSON", jsonHeader(), `{"email": `, false,
+			http.StatusBadRequest, `{"error":"The request body isn't valid JSON: it ends before the document does."}`, 0},
+		{"rejects an unknown field", jsonHeader(), `{"emial": "me@example.com"}`, false,
+			http.StatusUnprocessableEntity, `{"error":"The request body has a field that isn't known.","errors":{"body.emial":"unknown field 'emial'"}}`, 0},
+		{"rejects a value of the wrong type", jsonHeader(), `{"email": 1}`, false,
+			http.StatusUnprocessableEntity, `{"error":"The request body has a value of the wrong type.","errors":{"body.email":"body.email must be a string"}}`, 0},
+		{"rejects more than one JSON document", jsonHeader(), `{"email": "me@example.com"} {"email": "you@example.com"}`, false,
+			http.StatusBadRequest, `{"error":"The request body must be one JSON document, but there's more after it, at line 1, column 29."}`, 0},
+		{"rejects a form content type", createFormHeader(), `email=me%40example.com`, false,
+			http.StatusUnsupportedMediaType, `{"error":"Content-Type must be application/json."}`, 0},
+		{"rejects a missing content type", http.Header{}, `{"email": "me@example.com"}`, false,
+			http.StatusUnsupportedMediaType, `{"error":"Content-Type must be application/json."}`, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := &signupperMock{subscribed: map[model.Email]bool{"me@example.com": test.subscribed}}
+			code, header, body := makePostRequest(newMux(s), "/api/newsletter/signup", test.header, strings.NewReader(test.body))
+			is.Equal(test.code, code)
+			is.Equal("application/json", header.Get("Content-Type"))
+			is.Equal(test.response+"\n", body)
+			is.Equal(test.queued, len(s.queued))
+			is.Equal("", header.Get("Set-Cookie"))
+		})
+	}
+
+	t.Run("throttles like the form", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&signupperMock{})
+		for i := 0; i < 2; i++ {
+			code, _, _ := makePostRequest(mux, "/api/newsletter/signup", jsonHeader(),
+				strings.NewReader(`{"email": "me`+strconv.Itoa(i)+`@example.com"}`))
+			is.Equal(http.StatusCreated, code)
+		}
+		code, _, body := makePostRequest(mux, "/api/newsletter/signup", jsonHeader(), strings.NewReader(`{"email": "me@example.com"}`))
+		is.Equal(http.StatusTooManyRequests, code)
+		is.Equal(`{"error":"Too many signups. Please try again later."}`+"\n", body)
+	})
+
+	t.Run("responds with a 500 if signing up fails", func(t *testing.T) {
+		is := is.New(t)
+
+		code, _, body := makePostRequest(newMux(&signupperMock{err: errors.New("oh no")}), "/api/newsletter/signup", jsonHeader(),
+			strings.NewReader(`{"email": "me@example.com"}`))
+		is.Equal(http.StatusInternalServerError, code)
+		is.True(regexp.MustCompile(`^{"error":"Something went wrong. Reference [A-Z2-7]{6}."}\n$`).MatchString(body))
+	})
+
+	t.Run("records the partner origin as the source", func(t *testing.T) {
+		partnerTests := []struct {
+			name   string
+			origin string
+			body   string
+			source string
+		}{
+			{"from the origin header", "https://partner.example.com", `{"email": "me@example.com"}`, "https://partner.example.com"},
+			{"from the embedded form on this site", "https://example.com", `{"email": "me@example.com", "source": "https://partner.example.com"}`,
+				"https://partner.example.com"},
+			{"preferring the origin header", "https://other.example.com", `{"email": "me@example.com", "source": "https://partner.example.com"}`,
+				"https://other.example.com"},
+			{"but not origins that aren't partners", "https://evil.example.com", `{"email": "me@example.com", "source": "https://evil.example.com"}`, ""},
+			{"but not without an origin", "", `{"email": "me@example.com"}`, ""},
+		}
+		for _, test := range partnerTests {
+			t.Run(test.name, func(t *testing.T) {
+				is := is.New(t)
+
+				s := &signupperMock{}
+				mux := chi.NewMux()
+				mux.Route("/api", func(r chi.Router) {
+					handlers.NewsletterSignupAPI(r, handlers.NewSignupService(s, zap.NewNop(), handlers.SignupServiceOptions{
+						PartnerOrigins: []string{"https://partner.example.com", "https://other.example.com"},
+					}))
+				})
+
+				header := jsonHeader()
+				if test.origin != "" {
+					header.Set("Origin", test.origin)
+				}
+				code, _, _ := makePostRequest(mux, "/api/newsletter/signup", header, strings.NewReader(test.body))
+				is.Equal(http.StatusCreated, code)
+				is.Equal(test.source, s.source)
+			})
+		}
+	})
+}
+
+type disposableCheckerMock map[string]bool
+
+func (d disposableCheckerMock) IsDisposable(domain string) bool {
+	return d[domain]
+}
+
+func TestSignupService_EmailPolicy(t *testing.T) {
+	policy := model.EmailPolicy{
+		Enabled:      true,
+		DedupDomains: []string{"gmail.com"},
+		Disposable:   disposableCheckerMock{"mailinator.com": true},
+	}
+	newMux := func(s *signupperMock, policy model.EmailPolicy) chi.Router {
+		mux := chi.NewMux()
+		mux.Route("/api", func(r chi.Router) {
+			handlers.NewsletterSignupAPI(r, handlers.NewSignupService(s, zap.NewNop(), handlers.SignupServiceOptions{
+				EmailPolicy:              policy,
+				MaxConfirmationsPerEmail: 2,
+			}))
+		})
+		return mux
+	}
+	signup := func(mux chi.Router, email string) (int, string) {
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		code, _, body := makePostRequest(mux, "/api/newsletter/signup", header, strings.NewReader(`{"email":"`+email+`"}`))
+		return code, body
+	}
+
+	t.Run("is off by default, signing up disposable addresses as they're entered", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &signupperMock{}
+		code, _ := signup(newMux(s, model.EmailPolicy{Disposable: disposableCheckerMock{"mailinator.com": true}}), "Me@Mailinator.com")
+		is.Equal(http.StatusCreated, code)
+		is.Equal(model.Email("Me@Mailinator.com"), s.email)
+	})
+
+	t.Run("rejects a disposable address with an error for the field", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &signupperMock{}
+		code, body := signup(newMux(s, policy), "me@mailinator.com")
+		is.Equal(http.StatusUnprocessableEntity, code)
+		is.Equal(`{"errors":{"email":"That looks like a throwaway email address. Please sign up with one you'll keep reading."}}`+"\n", body)
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("signs up the normalized address, keeping its dots and plus tag", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &signupperMock{}
+		code, _ := signup(newMux(s, policy), "John.Doe+News@Gmail.com")
+		is.Equal(http.StatusCreated, code)
+		is.Equal(model.Email("john.doe+news@gmail.com"), s.email)
+	})
+
+	t.Run("says a variant of a subscribed address is subscribed already", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &signupperMock{subscribed: map[model.Email]bool{"johndoe@gmail.com": true}}
+		code, body := signup(newMux(s, policy), "john.doe+news@gmail.com")
+		is.Equal(http.StatusOK, code)
+		is.Equal(`{"status":"already_subscribed"}`+"\n", body)
+	})
+
+	t.Run("counts the variants of an address toward its confirmation email limit", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &signupperMock{}
+		mux := newMux(s, policy)
+		for _, email := range []string{"johndoe@gmail.com", "john.doe@gmail.com", "johndoe+news@gmail.com"} {
+			code, _ := signup(mux, email)
+			is.Equal(http.StatusCreated, code)
+		}
+		is.Equal(2, len(s.queued))
+	})
+}
+
+func TestNewsletterResend(t *testing.T) {
+	setup := func(s *signupperMock, opts handlers.SignupServiceOptions) chi.Router {
+		mux := chi.NewMux()
+		handlers.NewsletterResend(mux, handlers.NewSignupService(s, zap.NewNop(), opts))
+		return mux
+	}
+
+	resend := func(mux chi.Router, email string) (int, string) {
+		code, _, body := makePostRequest(mux, "/newsletter/resend", createFormHeader(),
+			strings.NewReader("email="+url.QueryEscape(email)))
+		return code, body
+	}
+
+	t.Run("shows the resend form", func(t *testing.T) {
+		is := is.New(t)
+		mux := setup(&signupperMock{}, handlers.SignupServiceOptions{})
+
+		code, _, body := makeGetRequest(mux, "/newsletter/resend")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, `action="/newsletter/resend"`))
+	})
+
+	t.Run("enqueues a new confirmation email for a pending address", func(t *testing.T) {
+		is := is.New(t)
+		s := &signupperMock{pending: map[model.Email]bool{"me@example.com": true}}
+		mux := setup(s, handlers.SignupServiceOptions{})
+
+		code, body := resend(mux, "me@example.com")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "Check your inbox"))
+		is.Equal(1, len(s.queued))
+		is.Equal("me@example.com", s.queued[0]["email"])
+	})
+
+	t.Run("shows the same page without sending for an unknown address", func(t *testing.T) {
+		is := is.New(t)
+		s := &signupperMock{pending: map[model.Email]bool{"me@example.com": true}}
+		mux := setup(s, handlers.SignupServiceOptions{})
+
+		_, pendingBody := resend(mux, "me@example.com")
+		s.queued = nil
+
+		code, body := resend(mux, "unknown@example.com")
+		is.Equal(http.StatusOK, code)
+		is.Equal(pendingBody, body)
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("shows the same page without sending for an already confirmed address", func(t *testing.T) {
+		is := is.New(t)
+		s := &signupperMock{subscribed: map[model.Email]bool{"me@example.com": true}}
+		mux := setup(s, handlers.SignupServiceOptions{})
+
+		code, body := resend(mux, "me@example.com")
+		is.Equal(http.StatusOK, code)
+		is.True(strings.Contains(body, "Check your inbox"))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("stops sending after the daily limit per address, shared with signups, without telling", func(t *testing.T) {
+		is := is.New(t)
+		s := &signupperMock{pending: map[model.Email]bool{"me@example.com": true}}
+		registry := prometheus.NewRegistry()
+		secret := []byte("secret")
+		now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+		mux := chi.NewMux()
+		svc := handlers.NewSignupService(s, zap.NewNop(), handlers.SignupServiceOptions{
+			FormSecret:               secret,
+			MaxConfirmationsPerEmail: 2,
+			Metrics:                  registry,
+			Now:                      func() time.Time { return now },
+		})
+		handlers.NewsletterSignup(mux, svc)
+		handlers.NewsletterResend(mux, svc)
+
+		code, _, _ := makePostRequest(mux, "/newsletter/signup", createFormHeader(),
+			strings.NewReader("email=me%40example.com&rendered_at="+url.QueryEscape(form.CreateTimestamp(secret, now.Add(-5*time.Second)))))
+		is.Equal(http.StatusFound, code)
+
+		for i := 0; i < 2; i++ {
+			code, body := resend(mux, "me@example.com")
+			is.Equal(http.StatusOK, code)
+			is.True(strings.Contains(body, "Check your inbox"))
+		}
+		is.Equal(2, len(s.queued))
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_signup_throttled_total Number of newsletter signups throttled, by what they were throttled by.
+# TYPE app_signup_throttled_total counter
+app_signup_throttled_total{by="email"} 1
+`), "app_signup_throttled_total")
+		is.NoErr(err)
+	})
+
+	t.Run("rejects an invalid email address", func(t *testing.T) {
+		is := is.New(t)
+		s := &signupperMock{}
+		mux := setup(s, handlers.SignupServiceOptions{})
+
+		code, body := resend(mux, "notanemail")
+		is.Equal(http.StatusBadRequest, code)
+		is.True(strings.Contains(body, `action="/newsletter/resend"`))
+		is.Equal(0, len(s.queued))
+	})
+
+	t.Run("errors if resending fails", func(t *testing.T) {
+		is := is.New(t)
+		mux := setup(&signupperMock{err: errors.New("oh no")}, handlers.SignupServiceOptions{})
+
+		code, _ := resend(mux, "me@example.com")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+type publishedNewsletterLister interface {
+	ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error)
+}
+
+// SitemapOptions for SitemapXML.
+type SitemapOptions struct {
+	// BaseURL of the app, for the absolute URLs in the sitemap, like "https://example.com".
+	BaseURL string
+	// Pages are the paths of the public pages, like "/".
+	Pages []string
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// SitemapXML serves the sitemap of the public pages and published newsletter issues at /sitemap.xml,
+// in the sitemaps.org format. It's generated on every request, so issues show up as soon as they're published,
+// however they're published. It has an ETag, so crawlers polling it mostly get 304 Not Modified.
+func SitemapXML(mux chi.Router, n publishedNewsletterLister, log *zap.Logger, opts SitemapOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+
+	mux.Get("/sitemap.xml", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		newsletters, err := n.ListPublishedNewsletters(r.Context(), storage.ListPublishedNewslettersOptions{})
+		if err != nil {
+			return fmt.Errorf("error listing published newsletters: %w", err)
+		}
+
+		set := sitemapURLSet{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+		for _, path := range opts.Pages {
+			set.URLs = append(set.URLs, sitemapURL{Loc: opts.BaseURL + path})
+		}
+		for _, n := range newsletters {
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:     archiveURL(opts.BaseURL, n),
+				LastMod: n.LastModified().UTC().Format(time.RFC3339),
+			})
+		}
+
+		b, err := xml.Marshal(set)
+		if err != nil {
+			return fmt.Errorf("error marshalling sitemap: %w", err)
+		}
+		b = append([]byte(xml.Header), b...)
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(b)))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+		return nil
+	}))
+}
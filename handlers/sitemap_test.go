@@ -0,0 +1,120 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/storage"
+)
+
+type publishedNewsletterListerMock struct {
+	calls       int
+	err         error
+	newsletters []model.Newsletter
+	opts        storage.ListPublishedNewslettersOptions
+}
+
+func (l *publishedNewsletterListerMock) ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	l.calls++
+	l.opts = opts
+	return l.newsletters, l.err
+}
+
+// sitemapXML is the shape of the sitemaps.org schema.
+type sitemapXML struct {
+	XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+func TestSitemapXML(t *testing.T) {
+	published := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+	newMux := func(l *publishedNewsletterListerMock) chi.Router {
+		mux := chi.NewMux()
+		handlers.SitemapXML(mux, l, zap.NewNop(), handlers.SitemapOptions{
+			BaseURL: "https://example.com/",
+			Pages:   []string{"/", "/archive"},
+		})
+		return mux
+	}
+
+	t.Run("lists the public pages and published newsletters with their last modification time", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&publishedNewsletterListerMock{newsletters: []model.Newsletter{
+			{Slug: "tips-&-tricks", PublishedAt: &published, Updated: published.Add(-time.Hour)},
+			{Slug: "first", PublishedAt: &published, Updated: published.Add(time.Hour)},
+		}})
+
+		code, header, body := makeGetRequest(mux, "/sitemap.xml")
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/xml; charset=utf-8", header.Get("Content-Type"))
+		is.True(strings.HasPrefix(body, `<?xml version="1.0" encoding="UTF-8"?>`))
+		is.True(strings.Contains(body, "<loc>https://example.com/archive/tips-&amp;-tricks</loc>"))
+
+		var sitemap sitemapXML
+		is.NoErr(xml.Unmarshal([]byte(body), &sitemap))
+		is.Equal(4, len(sitemap.URLs))
+		is.Equal("https://example.com/", sitemap.URLs[0].Loc)
+		is.Equal("", sitemap.URLs[0].LastMod)
+		is.Equal("https://example.com/archive", sitemap.URLs[1].Loc)
+		is.Equal("https://example.com/archive/tips-&-tricks", sitemap.URLs[2].Loc)
+		is.Equal("2022-12-10T12:00:00Z", sitemap.URLs[2].LastMod)
+		is.Equal("https://example.com/archive/first", sitemap.URLs[3].Loc)
+		is.Equal("2022-12-10T13:00:00Z", sitemap.URLs[3].LastMod)
+		for _, u := range sitemap.URLs[2:] {
+			_, err := time.Parse(time.RFC3339, u.LastMod)
+			is.NoErr(err)
+		}
+	})
+
+	t.Run("lists newly published newsletters right away, and responds with not modified to conditional requests", func(t *testing.T) {
+		is := is.New(t)
+
+		l := &publishedNewsletterListerMock{}
+		mux := newMux(l)
+
+		_, header, body := makeGetRequest(mux, "/sitemap.xml")
+		is.True(!strings.Contains(body, "/archive/new"))
+		etag := header.Get("ETag")
+		is.True(etag != "")
+
+		req := httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+		req.Header.Set("If-None-Match", etag)
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusNotModified, res.Code)
+
+		l.newsletters = []model.Newsletter{{Slug: "new", PublishedAt: &published, Updated: published}}
+		req = httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil)
+		req.Header.Set("If-None-Match", etag)
+		res = httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusOK, res.Code)
+		is.True(strings.Contains(res.Body.String(), "/archive/new"))
+		is.Equal(3, l.calls)
+	})
+
+	t.Run("responds with an error if listing newsletters fails", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := newMux(&publishedNewsletterListerMock{err: errors.New("oh no")})
+
+		code, _, _ := makeGetRequest(mux, "/sitemap.xml")
+		is.Equal(http.StatusInternalServerError, code)
+	})
+}
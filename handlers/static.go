@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/assets"
+	"canvas/views"
+)
+
+// Static serves the assets under their hashed names, with cache headers that let browsers keep them forever.
+// Names without the hash redirect to the current hashed name, so old links keep working.
+// /favicon.ico also redirects, for browsers asking for it without a link in the page.
+func Static(mux chi.Router, a *assets.Assets, log *zap.Logger) {
+	mux.Get("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		redirectToAsset(w, r, a, "favicon.ico")
+	})
+
+	mux.Get(assets.PathPrefix+"*", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		name := strings.TrimPrefix(r.URL.Path, assets.PathPrefix)
+
+		if content, hash, ok := a.Get(name); ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			w.Header().Set("ETag", `"`+hash+`"`)
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+			return nil
+		}
+
+		if a.Has(name) {
+			redirectToAsset(w, r, a, name)
+			return nil
+		}
+
+		return respondError(w, r, http.StatusNotFound, views.NotFoundPage(r.URL.Path), "")
+	}))
+}
+
+// redirectToAsset with the current hashed name. The redirect itself mustn't be cached, because the hash changes.
+func redirectToAsset(w http.ResponseWriter, r *http.Request, a *assets.Assets, name string) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, a.URL(name), http.StatusFound)
+}
@@ -0,0 +1,63 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/assets"
+	"canvas/handlers"
+)
+
+func TestStatic(t *testing.T) {
+	a, err := assets.New(fstest.MapFS{
+		"app.css":     {Data: []byte("body {}")},
+		"favicon.ico": {Data: []byte("icon")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := chi.NewMux()
+	handlers.Static(mux, a, nil)
+
+	t.Run("serves hashed assets with immutable cache headers", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, body := makeGetRequest(mux, a.URL("app.css"))
+		is.Equal(http.StatusOK, code)
+		is.Equal("body {}", body)
+		is.Equal("text/css; charset=utf-8", header.Get("Content-Type"))
+		is.Equal("public, max-age=31536000, immutable", header.Get("Cache-Control"))
+		is.True(header.Get("ETag") != "")
+	})
+
+	t.Run("redirects unhashed names to the hashed one", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(mux, "/static/app.css")
+		is.Equal(http.StatusFound, code)
+		is.Equal(a.URL("app.css"), header.Get("Location"))
+		is.Equal("no-cache", header.Get("Cache-Control"))
+	})
+
+	t.Run("redirects the favicon at the root", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(mux, "/favicon.ico")
+		is.Equal(http.StatusFound, code)
+		is.Equal(a.URL("favicon.ico"), header.Get("Location"))
+	})
+
+	t.Run("responds with not found for unknown assets and outdated hashes", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, target := range []string{"/static/doesnotexist.css", "/static/app.00000000.css", "/static/"} {
+			code, header, _ := makeGetRequest(mux, target)
+			is.Equal(http.StatusNotFound, code)
+			is.True(header.Get("Cache-Control") == "")
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDKey of the span attribute with the request ID, to find the logs of a trace.
+const requestIDKey = attribute.Key("canvas.request_id")
+
+// Trace each request in a server span from tp, which continues the trace in the traceparent header, if any.
+// The span is named after the method and route pattern, like "GET /newsletters/{id}", so it doesn't have IDs or tokens
+// from the path, and responses with a 5xx status mark it as an error.
+func Trace(tp trace.TracerProvider) func(next http.Handler) http.Handler {
+	tracer := tp.Tracer("canvas/handlers")
+	propagator := propagation.TraceContext{}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method)))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				// The route is known after routing, and handlers that write nothing respond with 200.
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				if rc := chi.RouteContext(r.Context()); rc != nil && rc.RoutePattern() != "" {
+					span.SetName(r.Method + " " + rc.RoutePattern())
+					span.SetAttributes(semconv.HTTPRouteKey.String(rc.RoutePattern()))
+				}
+				span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+				if requestID := middleware.GetReqID(ctx); requestID != "" {
+					span.SetAttributes(requestIDKey.String(requestID))
+				}
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+				span.End()
+			}()
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/handlers"
+	"canvas/integrationtest"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/tracing"
+)
+
+// newTracedMux with the tracing middleware, and a function that flushes the provider and returns the spans so far.
+func newTracedMux(t *testing.T) (*chi.Mux, func() []tracing.SpanData) {
+	t.Helper()
+	exporter := tracing.NewMemoryExporter()
+	p := tracing.NewProvider(tracing.NewProviderOptions{Exporter: exporter})
+	t.Cleanup(func() {
+		_ = p.Shutdown(context.Background())
+	})
+
+	mux := chi.NewMux()
+	mux.Use(middleware.RequestID, handlers.Trace(p))
+	return mux, func() []tracing.SpanData {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := p.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return exporter.Spans()
+	}
+}
+
+func TestTrace(t *testing.T) {
+	t.Run("records a server span named after the route, with the status and request ID", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, spans := newTracedMux(t)
+		mux.Get("/newsletters/{id}", func(w http.ResponseWriter, r *http.Request) {
+			is.True(trace.SpanFromContext(r.Context()).IsRecording())
+		})
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/newsletters/1?token=secret", nil))
+
+		s := spans()
+		is.Equal(1, len(s))
+		is.Equal("GET /newsletters/{id}", s[0].Name)
+		is.Equal(trace.SpanKindServer, s[0].Kind)
+		is.Equal(codes.Unset, s[0].Status)
+		attributes := map[attribute.Key]attribute.Value{}
+		for _, kv := range s[0].Attributes {
+			attributes[kv.Key] = kv.Value
+		}
+		is.Equal("GET", attributes["http.method"].AsString())
+		is.Equal("/newsletters/{id}", attributes["http.route"].AsString())
+		is.Equal(int64(http.StatusOK), attributes["http.status_code"].AsInt64())
+		is.True(attributes["canvas.request_id"].AsString() != "")
+	})
+
+	t.Run("marks the span as an error on a 5xx response", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, spans := newTracedMux(t)
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		s := spans()[0]
+		is.Equal(codes.Error, s.Status)
+		is.Equal("Service Unavailable", s.StatusDescription)
+	})
+
+	t.Run("continues the trace from the traceparent header", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, spans := newTracedMux(t)
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		s := spans()[0]
+		is.Equal("0af7651916cd43dd8448eb211c80319c", s.SpanContext.TraceID().String())
+		is.Equal("b7ad6b7169203331", s.Parent.SpanID().String())
+		is.True(s.Parent.IsRemote())
+	})
+
+	t.Run("doesn't record requests in traces that aren't sampled", func(t *testing.T) {
+		is := is.New(t)
+
+		mux, spans := newTracedMux(t)
+		mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			is.True(!trace.SpanFromContext(r.Context()).IsRecording())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+
+		is.Equal(0, len(spans()))
+	})
+}
+
+func TestTrace_integration(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("records the queries and sent messages of a request as children of its span", func(t *testing.T) {
+		is := is.New(t)
+
+		db, cleanupDB := integrationtest.CreateDatabase()
+		defer cleanupDB()
+		queue, cleanupQueue := integrationtest.CreateQueue()
+		defer cleanupQueue()
+
+		mux, spans := newTracedMux(t)
+		mux.Post("/signup", func(w http.ResponseWriter, r *http.Request) {
+			_, err := db.IsSubscribed(r.Context(), model.Email("me@example.com"))
+			is.NoErr(err)
+			err = queue.Send(r.Context(), model.Message{"job": "confirmation_email", "email": "me@example.com"})
+			is.NoErr(err)
+		})
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/signup", nil))
+
+		s := spans()
+		is.Equal(3, len(s))
+		query, send, server := s[0], s[1], s[2]
+
+		is.Equal("POST /signup", server.Name)
+		is.Equal("SELECT newsletter_subscribers", query.Name)
+		is.Equal(trace.SpanKindClient, query.Kind)
+		is.Equal("canvas/storage", query.Scope)
+		is.Equal("jobs send", send.Name)
+		is.Equal(trace.SpanKindProducer, send.Kind)
+		is.Equal("canvas/messaging", send.Scope)
+
+		for _, child := range []tracing.SpanData{query, send} {
+			is.Equal(server.SpanContext.TraceID(), child.SpanContext.TraceID())
+			is.Equal(server.SpanContext.SpanID(), child.Parent.SpanID())
+			is.Equal(codes.Unset, child.Status)
+		}
+
+		// The job continues the trace from the producer span.
+		rm, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.True(rm != nil)
+		ctx := messaging.ContextWithAttributes(context.Background(), rm.Attributes)
+		is.Equal(send.SpanContext.SpanID(), trace.SpanContextFromContext(ctx).SpanID())
+	})
+}
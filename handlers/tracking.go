@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+
+	"canvas/email"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+type messageSender interface {
+	Send(ctx context.Context, m model.Message) error
+}
+
+// TrackEmailOpenOptions for TrackEmailOpen.
+type TrackEmailOpenOptions struct {
+	// Queue to send the opens to, for the job that records them. Without it, opens aren't recorded.
+	Queue messageSender
+	// Secret verifies the tokens in the pixel URLs.
+	Secret []byte
+	// Now is for the time of the open. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// transparentGIF is the smallest transparent 1x1 GIF.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// TrackEmailOpen serves the open tracking pixel in newsletter issue emails at /t/open/<token>.gif.
+// Opens are sent to the queue and recorded by a job, so the pixel loads without waiting for the database.
+// Every request gets the pixel, even with a bad token, so mail clients never show a broken image.
+// The pixel must not be cached, or repeat opens wouldn't reach the server.
+func TrackEmailOpen(mux chi.Router, log *zap.Logger, opts TrackEmailOpenOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	// The token has a dot in it, which chi would end a {token}.gif parameter at, so the suffix is cut off here.
+	mux.Get("/t/open/{file}", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			w.Header().Set("Content-Type", "image/gif")
+			w.Header().Set("Content-Length", strconv.Itoa(len(transparentGIF)))
+			w.Header().Set("Cache-Control", "no-store, max-age=0")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(transparentGIF)
+		}()
+
+		file := chi.URLParam(r, "file")
+		if !strings.HasSuffix(file, ".gif") {
+			requestLog(r.Context(), log).Debug("Open tracking pixel without .gif suffix")
+			return
+		}
+		newsletterID, subscriberID, err := email.VerifyOpenToken(opts.Secret, strings.TrimSuffix(file, ".gif"))
+		if err != nil {
+			// Links get mangled by mail clients and scanners all the time, so this is nothing to worry about.
+			requestLog(r.Context(), log).Debug("Invalid open token", zap.Error(err))
+			return
+		}
+		if opts.Queue == nil {
+			return
+		}
+
+		m, err := messaging.NewMessage(model.EmailOpened{
+			NewsletterID: strconv.FormatInt(newsletterID, 10),
+			SubscriberID: strconv.FormatInt(subscriberID, 10),
+			OpenedAt:     opts.Now().UTC().Format(time.RFC3339),
+		})
+		if err == nil {
+			err = opts.Queue.Send(r.Context(), m)
+		}
+		if err != nil {
+			requestLog(r.Context(), log).Info("Error sending email open to queue", zap.Error(err))
+		}
+	})
+}
+
+// TrackEmailClickOptions for TrackEmailClick.
+type TrackEmailClickOptions struct {
+	// Queue to send the clicks to, for the job that records them. Without it, clicks aren't recorded.
+	Queue messageSender
+	// Secret verifies the tokens in the tracked links.
+	Secret []byte
+	// Now is for the time of the click. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// TrackEmailClick redirects tracked links in newsletter issue emails at /t/click/<token> to their destination.
+// The destination only ever comes from the signed token, so the redirect can't be pointed anywhere else.
+// Clicks are sent to the queue and recorded by a job, and the redirect happens even if that fails,
+// so a reader always gets where the link goes. Bad tokens get the not found page.
+func TrackEmailClick(mux chi.Router, log *zap.Logger, opts TrackEmailClickOptions) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	mux.Get("/t/click/{token}", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		newsletterID, subscriberID, destination, err := email.VerifyClickToken(opts.Secret, chi.URLParam(r, "token"))
+		if err != nil {
+			return fmt.Errorf("%w: %v", storage.ErrNotFound, err)
+		}
+
+		if opts.Queue != nil {
+			m, err := messaging.NewMessage(model.EmailClicked{
+				NewsletterID: strconv.FormatInt(newsletterID, 10),
+				SubscriberID: strconv.FormatInt(subscriberID, 10),
+				URL:          destination,
+				ClickedAt:    opts.Now().UTC().Format(time.RFC3339),
+			})
+			if err == nil {
+				err = opts.Queue.Send(r.Context(), m)
+			}
+			if err != nil {
+				requestLog(r.Context(), log).Info("Error sending email click to queue", zap.Error(err))
+			}
+		}
+
+		// Not cached, or repeat clicks wouldn't reach the server.
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+		http.Redirect(w, r, destination, http.StatusFound)
+		return nil
+	}))
+}
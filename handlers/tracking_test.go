@@ -0,0 +1,144 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/handlers"
+	"canvas/model"
+)
+
+type messageSenderMock struct {
+	err      error
+	messages []model.Message
+}
+
+func (m *messageSenderMock) Send(ctx context.Context, message model.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestTrackEmailOpen(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+
+	newMux := func(q *messageSenderMock) chi.Router {
+		mux := chi.NewMux()
+		handlers.TrackEmailOpen(mux, nil, handlers.TrackEmailOpenOptions{
+			Queue:  q,
+			Secret: secret,
+			Now:    func() time.Time { return now },
+		})
+		return mux
+	}
+
+	t.Run("serves an uncached pixel and sends the open to the queue for each open", func(t *testing.T) {
+		is := is.New(t)
+
+		q := &messageSenderMock{}
+		mux := newMux(q)
+		target := "/t/open/" + email.CreateOpenToken(secret, 1, 2) + ".gif"
+
+		for i := 0; i < 2; i++ {
+			code, header, body := makeGetRequest(mux, target)
+			is.Equal(http.StatusOK, code)
+			is.Equal("image/gif", header.Get("Content-Type"))
+			is.Equal("no-store, max-age=0", header.Get("Cache-Control"))
+			is.True(bytes.HasPrefix([]byte(body), []byte("GIF89a")))
+		}
+
+		open := model.Message{"job": "email_opened", "newsletterID": "1", "subscriberID": "2", "openedAt": "2022-12-24T08:00:00Z"}
+		is.Equal([]model.Message{open, open}, q.messages)
+	})
+
+	t.Run("serves the pixel but doesn't send an open for a forged token", func(t *testing.T) {
+		is := is.New(t)
+
+		q := &messageSenderMock{}
+		mux := newMux(q)
+
+		for _, token := range []string{email.CreateOpenToken([]byte("other"), 1, 2), "MTo0." + "nope", "nope"} {
+			code, header, body := makeGetRequest(mux, "/t/open/"+token+".gif")
+			is.Equal(http.StatusOK, code)
+			is.Equal("image/gif", header.Get("Content-Type"))
+			is.Equal("no-store, max-age=0", header.Get("Cache-Control"))
+			is.True(len(body) > 0)
+		}
+		is.Equal(0, len(q.messages))
+	})
+}
+
+func TestTrackEmailClick(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+
+	newMux := func(q *messageSenderMock) chi.Router {
+		mux := chi.NewMux()
+		handlers.TrackEmailClick(mux, nil, handlers.TrackEmailClickOptions{
+			Queue:  q,
+			Secret: secret,
+			Now:    func() time.Time { return now },
+		})
+		return mux
+	}
+
+	token, err := email.CreateClickToken(secret, 1, 2, "https://example.org/?a=1&b=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("redirects to the destination in the token and sends the click to the queue", func(t *testing.T) {
+		is := is.New(t)
+
+		q := &messageSenderMock{}
+		code, header, _ := makeGetRequest(newMux(q), "/t/click/"+token)
+		is.Equal(http.StatusFound, code)
+		is.Equal("https://example.org/?a=1&b=2", header.Get("Location"))
+		is.Equal("no-store, max-age=0", header.Get("Cache-Control"))
+		is.Equal([]model.Message{{"job": "email_clicked", "newsletterID": "1", "subscriberID": "2",
+			"url": "https://example.org/?a=1&b=2", "clickedAt": "2022-12-24T08:00:00Z"}}, q.messages)
+	})
+
+	t.Run("redirects even if the click can't be sent to the queue", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(newMux(&messageSenderMock{err: errors.New("oh no")}), "/t/click/"+token)
+		is.Equal(http.StatusFound, code)
+		is.Equal("https://example.org/?a=1&b=2", header.Get("Location"))
+	})
+
+	t.Run("ignores destinations in query parameters", func(t *testing.T) {
+		is := is.New(t)
+
+		code, header, _ := makeGetRequest(newMux(&messageSenderMock{}), "/t/click/"+token+"?url=https://evil.example.com")
+		is.Equal(http.StatusFound, code)
+		is.Equal("https://example.org/?a=1&b=2", header.Get("Location"))
+	})
+
+	t.Run("responds with not found for malformed and forged tokens", func(t *testing.T) {
+		is := is.New(t)
+
+		otherToken, err := email.CreateClickToken([]byte("other"), 1, 2, "https://evil.example.com")
+		is.NoErr(err)
+
+		q := &messageSenderMock{}
+		mux := newMux(q)
+		for _, token := range []string{otherToken, "nope", "bm9wZQ.bm9wZQ"} {
+			code, header, _ := makeGetRequest(mux, "/t/click/"+token)
+			is.Equal(http.StatusNotFound, code)
+			is.Equal("", header.Get("Location"))
+		}
+		is.Equal(0, len(q.messages))
+	})
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+
+	"canvas/build"
+)
+
+// Version responds with the build info as JSON, to see exactly what's deployed.
+// The response is marshalled once up front, since the build info doesn't change.
+func Version(mux chi.Router, info build.Info) {
+	body, err := json.Marshal(info)
+	if err != nil {
+		panic(err)
+	}
+	mux.Get("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(body)
+	})
+}
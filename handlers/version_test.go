@@ -0,0 +1,35 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/build"
+	"canvas/handlers"
+)
+
+func TestVersion(t *testing.T) {
+	t.Run("responds with the build info as JSON", func(t *testing.T) {
+		is := is.New(t)
+
+		mux := chi.NewMux()
+		handlers.Version(mux, build.Info{Version: "v1.2.3", Commit: "abc123", Date: "2022-12-10T12:00:00Z", GoVersion: "go1.18.9"})
+
+		code, header, body := makeGetRequest(mux, "/version")
+		is.Equal(http.StatusOK, code)
+		is.Equal("application/json", header.Get("Content-Type"))
+
+		var v map[string]string
+		is.NoErr(json.Unmarshal([]byte(body), &v))
+		is.Equal(map[string]string{
+			"version":   "v1.2.3",
+			"commit":    "abc123",
+			"date":      "2022-12-10T12:00:00Z",
+			"goVersion": "go1.18.9",
+		}, v)
+	})
+}
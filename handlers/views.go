@@ -2,14 +2,32 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
+	"go.uber.org/zap"
 
+	"canvas/flags"
+	"canvas/form"
+	"canvas/i18n"
+	"canvas/sessions"
 	"canvas/views"
 )
 
-func FrontPage(mux chi.Router) {
-	mux.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		_ = views.FrontPage().Render(w)
-	})
+// FrontPage with the signup form in the locale of the request, with its timestamp signed with formSecret, and any flash messages.
+// The baseURL is for the canonical URL of the page, like "https://example.com".
+// With a captcha verifier, the form has its widget. It's nil otherwise.
+func FrontPage(mux chi.Router, log *zap.Logger, formSecret []byte, baseURL string, captcha CaptchaVerifier) {
+	mux.Get("/", HandleErrors(log, func(w http.ResponseWriter, r *http.Request) error {
+		t := i18n.FromContext(r.Context())
+		return render(w, http.StatusOK, views.FrontPage(views.PageData{
+			Flags:   flags.FromContext(r.Context()),
+			Flashes: sessions.ConsumeFlashes(r.Context()),
+			Meta: views.PageMetaProps{
+				CanonicalURL: baseURL + "/",
+				Description:  t.T("front.description"),
+			},
+			Translator: t,
+		}, CSRFToken(r), form.CreateTimestamp(formSecret, time.Now()), captchaWidget(captcha), nil))
+	}))
 }
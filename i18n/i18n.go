@@ -0,0 +1,333 @@
+// Package i18n translates the text of views and emails, with message catalogs embedded per locale.
+//
+// Each locale has a JSON file in the locales directory, like fr.json, mapping message keys to translations.
+// Translations are format strings for fmt.Sprintf. Messages with a count have a plural form per category instead,
+// like {"one": "%d day", "other": "%d days"}. Keys ending in "_html" are trusted HTML.
+// English is the default, and missing translations fall back to it.
+//
+// In production, the catalog is embedded and parsed once at startup. In development, a Reloader parses
+// the files from disk on every load instead, so copy changes show up without restarting.
+package i18n
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/text/language"
+)
+
+//go:embed locales
+var embedded embed.FS
+
+// DefaultLocale that missing translations fall back to, and that's used when nothing else matches.
+const DefaultLocale = "en"
+
+// CookieName of the cookie with the locale the visitor picked, which takes precedence over Accept-Language.
+const CookieName = "locale"
+
+// message is a translation, with plural forms if it has a count.
+type message struct {
+	One   string
+	Other string
+}
+
+// UnmarshalJSON from either a string, or an object with the plural forms "one" and "other".
+func (m *message) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		m.One, m.Other = s, s
+		return nil
+	}
+	var forms struct {
+		One   string `json:"one"`
+		Other string `json:"other"`
+	}
+	if err := json.Unmarshal(b, &forms); err != nil {
+		return err
+	}
+	if forms.Other == "" {
+		return fmt.Errorf("plural message must have an other form")
+	}
+	m.One, m.Other = forms.One, forms.Other
+	if m.One == "" {
+		m.One = m.Other
+	}
+	return nil
+}
+
+// Catalog of messages for all locales.
+type Catalog struct {
+	locales  []string
+	log      *zap.Logger
+	matcher  language.Matcher
+	messages map[string]map[string]message
+	// missing keys that have been logged, so each is logged once.
+	missing sync.Map
+}
+
+// New Catalog from the JSON files in fsys, one per locale, named after the locale. There must be one for DefaultLocale.
+// Missing translations are logged to log.
+func New(fsys fs.FS, log *zap.Logger) (*Catalog, error) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	c := &Catalog{log: log, messages: map[string]map[string]message{}}
+
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]message
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, newParseError(name, b, err)
+		}
+		c.messages[strings.TrimSuffix(path.Base(name), ".json")] = messages
+	}
+	if _, ok := c.messages[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("no messages for default locale %v", DefaultLocale)
+	}
+
+	// The default locale goes first, so the matcher falls back to it.
+	c.locales = append(c.locales, DefaultLocale)
+	for locale := range c.messages {
+		if locale != DefaultLocale {
+			c.locales = append(c.locales, locale)
+		}
+	}
+	sort.Strings(c.locales[1:])
+	var tags []language.Tag
+	for _, locale := range c.locales {
+		tags = append(tags, language.Make(locale))
+	}
+	c.matcher = language.NewMatcher(tags)
+	return c, nil
+}
+
+// Load the catalog, which is already loaded. It makes a Catalog a Loader that never reloads, for production.
+func (c *Catalog) Load() (*Catalog, error) {
+	return c, nil
+}
+
+// Locales in the catalog, with DefaultLocale first and the rest sorted.
+func (c *Catalog) Locales() []string {
+	return c.locales
+}
+
+// Has the locale in the catalog.
+func (c *Catalog) Has(locale string) bool {
+	_, ok := c.messages[locale]
+	return ok
+}
+
+// Match the best locale in the catalog for the Accept-Language header value, or DefaultLocale if none match.
+func (c *Catalog) Match(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, i, confidence := c.matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return c.locales[i]
+}
+
+// Resolve the locale of the request, from the locale cookie, then the Accept-Language header, then DefaultLocale.
+func (c *Catalog) Resolve(r *http.Request) string {
+	if cookie, err := r.Cookie(CookieName); err == nil && c.Has(cookie.Value) {
+		return cookie.Value
+	}
+	return c.Match(r.Header.Get("Accept-Language"))
+}
+
+// Translator for the locale, or for DefaultLocale if the catalog doesn't have it.
+func (c *Catalog) Translator(locale string) *Translator {
+	if !c.Has(locale) {
+		locale = DefaultLocale
+	}
+	return &Translator{c: c, locale: locale}
+}
+
+// lookup the message for the key in the locale, falling back to DefaultLocale and then the key itself.
+// Missing translations are logged once per locale and key.
+func (c *Catalog) lookup(locale, key string) message {
+	if m, ok := c.messages[locale][key]; ok {
+		return m
+	}
+	if _, logged := c.missing.LoadOrStore(locale+":"+key, true); !logged {
+		c.log.Info("Missing translation", zap.String("locale", locale), zap.String("key", key))
+	}
+	if locale != DefaultLocale {
+		return c.lookup(DefaultLocale, key)
+	}
+	return message{One: key, Other: key}
+}
+
+// Translator of messages into one locale.
+type Translator struct {
+	c      *Catalog
+	locale string
+}
+
+// Locale translated into.
+func (t *Translator) Locale() string {
+	return t.locale
+}
+
+// T translates the message with the key, formatted with the args if there are any.
+func (t *Translator) T(key string, args ...any) string {
+	return format(t.c.lookup(t.locale, key).Other, args)
+}
+
+// N translates the message with the key in the plural form for the count n.
+// The count is the first formatting argument, followed by the args.
+func (t *Translator) N(key string, n int, args ...any) string {
+	m := t.c.lookup(t.locale, key)
+	s := m.Other
+	if isOne(t.locale, n) {
+		s = m.One
+	}
+	return format(s, append([]any{n}, args...))
+}
+
+func format(s string, args []any) string {
+	if len(args) == 0 {
+		return s
+	}
+	return fmt.Sprintf(s, args...)
+}
+
+// isOne is true if n takes the "one" plural form in the locale. French uses it for zero too.
+func isOne(locale string, n int) bool {
+	if locale == "fr" {
+		return n == 0 || n == 1
+	}
+	return n == 1
+}
+
+// ParseError for a message file that isn't valid, with the position of the error in it if known.
+type ParseError struct {
+	File string
+	// Line and Column of the error, starting at 1. They're 0 if the position isn't known.
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("error parsing messages in %v: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("error parsing messages in %v:%v:%v: %v", e.File, e.Line, e.Column, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError for the file named name with the content b, with the line and column from the offset of syntax
+// and type errors.
+func newParseError(name string, b []byte, err error) *ParseError {
+	e := &ParseError{File: name, Err: err}
+
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return e
+	}
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	// The offset is just after the byte with the error.
+	if offset > 0 {
+		offset--
+	}
+	before := b[:offset]
+	e.Line = bytes.Count(before, []byte("\n")) + 1
+	e.Column = len(before) - bytes.LastIndexByte(before, '\n')
+	return e
+}
+
+// Loader of a Catalog. A Catalog loads itself, and a Reloader loads from disk every time.
+type Loader interface {
+	Load() (*Catalog, error)
+}
+
+// Reloader loads the catalog from its file system every time, so translations can be edited without
+// restarting the app. It's for development only, because parsing on every request is slow.
+type Reloader struct {
+	fsys fs.FS
+	log  *zap.Logger
+}
+
+// NewReloader from fsys, like a directory from os.DirFS. Missing translations are logged to log,
+// once per load instead of once per key, because every load is a new Catalog.
+func NewReloader(fsys fs.FS, log *zap.Logger) *Reloader {
+	return &Reloader{fsys: fsys, log: log}
+}
+
+// Load the catalog anew with New. Errors parsing a file are a *ParseError.
+func (r *Reloader) Load() (*Catalog, error) {
+	return New(r.fsys, r.log)
+}
+
+var defaultCatalog = mustNewEmbedded()
+
+func mustNewEmbedded() *Catalog {
+	c, err := New(Embedded(), nil)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Embedded locales directory, for New.
+func Embedded() fs.FS {
+	fsys, err := fs.Sub(embedded, "locales")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// Default Catalog, embedded from the locales directory. It doesn't log missing translations.
+func Default() *Catalog {
+	return defaultCatalog
+}
+
+type contextKey struct{}
+
+// WithTranslator returns a copy of ctx with the Translator, for FromContext.
+func WithTranslator(ctx context.Context, t *Translator) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext gets the Translator set with WithTranslator, or the DefaultLocale one of the Default catalog.
+func FromContext(ctx context.Context) *Translator {
+	if t, ok := ctx.Value(contextKey{}).(*Translator); ok {
+		return t
+	}
+	return defaultCatalog.Translator(DefaultLocale)
+}
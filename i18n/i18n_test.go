@@ -0,0 +1,263 @@
+package i18n_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/i18n"
+)
+
+func newTestCatalog(t *testing.T) (*i18n.Catalog, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zap.InfoLevel)
+	c, err := i18n.New(fstest.MapFS{
+		"en.json": {Data: []byte(`{"hi": "Hi", "hi_name": "Hi %v", "days": {"one": "%d day", "other": "%d days"}}`)},
+		"fr.json": {Data: []byte(`{"hi": "Salut", "days": {"one": "%d jour", "other": "%d jours"}}`)},
+		"de.json": {Data: []byte(`{"hi": "Hallo", "days": {"one": "%d Tag", "other": "%d Tage"}}`)},
+	}, zap.New(core))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, logs
+}
+
+func TestCatalog_Match(t *testing.T) {
+	c, _ := newTestCatalog(t)
+
+	tests := []struct {
+		acceptLanguage string
+		expected       string
+	}{
+		{"fr-FR,fr;q=0.9,en;q=0.8", "fr"},
+		{"de-CH", "de"},
+		{"es, de;q=0.5", "de"},
+		{"en-US", "en"},
+		{"es", "en"},
+		{"", "en"},
+		{"not a language;;", "en"},
+	}
+	for _, test := range tests {
+		t.Run(test.acceptLanguage, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.expected, c.Match(test.acceptLanguage))
+		})
+	}
+}
+
+func TestCatalog_Resolve(t *testing.T) {
+	c, _ := newTestCatalog(t)
+
+	t.Run("prefers the cookie to Accept-Language", func(t *testing.T) {
+		is := is.New(t)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", "fr")
+		r.AddCookie(&http.Cookie{Name: i18n.CookieName, Value: "de"})
+		is.Equal("de", c.Resolve(r))
+	})
+
+	t.Run("ignores a cookie with an unknown locale", func(t *testing.T) {
+		is := is.New(t)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", "fr")
+		r.AddCookie(&http.Cookie{Name: i18n.CookieName, Value: "xx"})
+		is.Equal("fr", c.Resolve(r))
+	})
+
+	t.Run("defaults to English", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("en", c.Resolve(httptest.NewRequest(http.MethodGet, "/", nil)))
+	})
+}
+
+func TestTranslator_T(t *testing.T) {
+	t.Run("translates and formats", func(t *testing.T) {
+		is := is.New(t)
+		c, _ := newTestCatalog(t)
+		is.Equal("Salut", c.Translator("fr").T("hi"))
+		is.Equal("Hi you", c.Translator("en").T("hi_name", "you"))
+	})
+
+	t.Run("falls back to English for missing translations, and logs them once per key", func(t *testing.T) {
+		is := is.New(t)
+		c, logs := newTestCatalog(t)
+
+		fr := c.Translator("fr")
+		is.Equal("Hi you", fr.T("hi_name", "you"))
+		is.Equal("Hi me", fr.T("hi_name", "me"))
+		is.Equal("Hi you", c.Translator("de").T("hi_name", "you"))
+
+		entries := logs.FilterMessage("Missing translation").All()
+		is.Equal(2, len(entries))
+		is.Equal("fr", entries[0].ContextMap()["locale"])
+		is.Equal("hi_name", entries[0].ContextMap()["key"])
+		is.Equal("de", entries[1].ContextMap()["locale"])
+	})
+
+	t.Run("falls back to the key if English is missing it too", func(t *testing.T) {
+		is := is.New(t)
+		c, _ := newTestCatalog(t)
+		is.Equal("nope", c.Translator("fr").T("nope"))
+	})
+
+	t.Run("uses English for an unknown locale", func(t *testing.T) {
+		is := is.New(t)
+		c, _ := newTestCatalog(t)
+		tr := c.Translator("xx")
+		is.Equal("en", tr.Locale())
+		is.Equal("Hi", tr.T("hi"))
+	})
+}
+
+func TestTranslator_N(t *testing.T) {
+	c, _ := newTestCatalog(t)
+
+	tests := []struct {
+		locale   string
+		n        int
+		expected string
+	}{
+		{"en", 0, "0 days"},
+		{"en", 1, "1 day"},
+		{"en", 7, "7 days"},
+		{"fr", 0, "0 jour"},
+		{"fr", 1, "1 jour"},
+		{"fr", 2, "2 jours"},
+		{"de", 0, "0 Tage"},
+		{"de", 1, "1 Tag"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.expected, c.Translator(test.locale).N("days", test.n))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Run("errors without English messages", func(t *testing.T) {
+		is := is.New(t)
+		_, err := i18n.New(fstest.MapFS{"fr.json": {Data: []byte(`{}`)}}, nil)
+		is.True(err != nil)
+	})
+
+	t.Run("errors on a plural message without the other form", func(t *testing.T) {
+		is := is.New(t)
+		_, err := i18n.New(fstest.MapFS{"en.json": {Data: []byte(`{"days": {"one": "%d day"}}`)}}, nil)
+		is.True(err != nil)
+	})
+
+	t.Run("errors with the file, line, and column of invalid JSON", func(t *testing.T) {
+		is := is.New(t)
+		_, err := i18n.New(fstest.MapFS{"en.json": {Data: []byte("{\n  \"hi\": \"Hi\",\n  \"bye\" \"Bye\"\n}")}}, nil)
+		var parseErr *i18n.ParseError
+		is.True(errors.As(err, &parseErr))
+		is.Equal("en.json", parseErr.File)
+		is.Equal(3, parseErr.Line)
+		is.Equal(9, parseErr.Column)
+		is.True(strings.HasPrefix(err.Error(), "error parsing messages in en.json:3:9: "))
+	})
+}
+
+func TestCatalog_Load(t *testing.T) {
+	t.Run("returns the catalog itself, without parsing again", func(t *testing.T) {
+		is := is.New(t)
+		c, _ := newTestCatalog(t)
+		loaded, err := c.Load()
+		is.NoErr(err)
+		is.True(c == loaded)
+	})
+}
+
+func TestReloader_Load(t *testing.T) {
+	t.Run("reloads edited files from disk, and errors on files that don't parse", func(t *testing.T) {
+		is := is.New(t)
+		dir := t.TempDir()
+		write := func(content string) {
+			is.NoErr(os.WriteFile(filepath.Join(dir, "en.json"), []byte(content), 0644))
+		}
+		r := i18n.NewReloader(os.DirFS(dir), nil)
+
+		write(`{"hi": "Hi"}`)
+		c, err := r.Load()
+		is.NoErr(err)
+		is.Equal("Hi", c.Translator("en").T("hi"))
+
+		write(`{"hi": "Hello"}`)
+		c, err = r.Load()
+		is.NoErr(err)
+		is.Equal("Hello", c.Translator("en").T("hi"))
+
+		write("{\n  \"hi\": \"Hello\"\n  \"bye\": \"Bye\"\n}")
+		_, err = r.Load()
+		var parseErr *i18n.ParseError
+		is.True(errors.As(err, &parseErr))
+		is.Equal(3, parseErr.Line)
+	})
+}
+
+func TestEmbedded(t *testing.T) {
+	t.Run("has English, French, and German", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal([]string{"en", "de", "fr"}, i18n.Default().Locales())
+	})
+
+	t.Run("has every English message in every locale, with plural forms for the same messages", func(t *testing.T) {
+		is := is.New(t)
+
+		read := func(locale string) map[string]json.RawMessage {
+			b, err := fs.ReadFile(i18n.Embedded(), locale+".json")
+			is.NoErr(err)
+			var messages map[string]json.RawMessage
+			is.NoErr(json.Unmarshal(b, &messages))
+			return messages
+		}
+
+		english := read("en")
+		for _, locale := range i18n.Default().Locales()[1:] {
+			messages := read(locale)
+			var missing []string
+			for key, m := range english {
+				translated, ok := messages[key]
+				if !ok {
+					missing = append(missing, key)
+					continue
+				}
+				if (m[0] == '{') != (translated[0] == '{') {
+					t.Errorf("%v has the message %v with different plural forms than English", locale, key)
+				}
+			}
+			if len(missing) > 0 {
+				sort.Strings(missing)
+				t.Errorf("%v is missing the messages %v", locale, missing)
+			}
+		}
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	t.Run("gets the translator set in the context", func(t *testing.T) {
+		is := is.New(t)
+		c, _ := newTestCatalog(t)
+		ctx := i18n.WithTranslator(context.Background(), c.Translator("fr"))
+		is.Equal("fr", i18n.FromContext(ctx).Locale())
+	})
+
+	t.Run("defaults to English", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("en", i18n.FromContext(context.Background()).Locale())
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/maragudk/env"
 	"github.com/maragudk/migrate"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"canvas/storage"
 )
@@ -21,11 +22,16 @@ var once sync.Once
 // 	defer cleanup()
 // 	…
 func CreateDatabase() (*storage.Database, func()) {
+	return CreateDatabaseWithMetrics(nil)
+}
+
+// CreateDatabaseWithMetrics for testing, like CreateDatabase, with the query metrics registered in registry.
+func CreateDatabaseWithMetrics(registry *prometheus.Registry) (*storage.Database, func()) {
 	env.MustLoad("../.env-test")
 
 	once.Do(initDatabase)
 
-	db, cleanup := connect("postgres")
+	db, cleanup := connect("postgres", nil)
 	defer cleanup()
 
 	dropConnections(db.DB, "template1")
@@ -35,11 +41,11 @@ func CreateDatabase() (*storage.Database, func()) {
 	db.DB.MustExec(`drop database if exists ` + name)
 	db.DB.MustExec(`create database ` + name)
 
-	return connect(name)
+	return connect(name, registry)
 }
 
 func initDatabase() {
-	db, cleanup := connect("template1")
+	db, cleanup := connect("template1", nil)
 	defer cleanup()
 
 	for err := db.Ping(context.Background()); err != nil; {
@@ -61,7 +67,7 @@ func initDatabase() {
 	}
 }
 
-func connect(name string) (*storage.Database, func()) {
+func connect(name string, registry *prometheus.Registry) (*storage.Database, func()) {
 	db := storage.NewDatabase(storage.NewDatabaseOptions{
 		Host:               env.GetStringOrDefault("DB_HOST", "localhost"),
 		Port:               env.GetIntOrDefault("DB_PORT", 5432),
@@ -70,6 +76,7 @@ func connect(name string) (*storage.Database, func()) {
 		Name:               name,
 		MaxOpenConnections: 10,
 		MaxIdleConnections: 10,
+		Metrics:            registry,
 	})
 	if err := db.Connect(); err != nil {
 		panic(err)
@@ -19,8 +19,9 @@ func CreateQueue() (*messaging.Queue, func()) {
 
 	name := env.GetStringOrDefault("QUEUE_NAME", "jobs")
 	queue := messaging.NewQueue(messaging.NewQueueOptions{
-		Config: getAWSConfig(),
-		Name:   name,
+		Config:      getAWSConfig(),
+		EndpointURL: getSQSEndpointURL(),
+		Name:        name,
 	})
 
 	createQueueOutput, err := queue.Client.CreateQueue(context.Background(), &sqs.CreateQueueInput{
@@ -2,6 +2,7 @@ package integrationtest
 
 import (
 	"canvas/server"
+	"context"
 	"net/http"
 	"testing"
 	"time"
@@ -36,7 +37,7 @@ func CreateServer() func() {
 	}
 
 	return func() {
-		if err := s.Stop(); err != nil {
+		if err := s.Stop(context.Background()); err != nil {
 			panic(err)
 		}
 		cleanupDB()
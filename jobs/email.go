@@ -0,0 +1,177 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/email"
+	"canvas/i18n"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+type emailSender interface {
+	Send(ctx context.Context, m email.Message) (string, error)
+}
+
+type sendLogger interface {
+	RecordEmailSend(ctx context.Context, s model.EmailSend) error
+}
+
+// Defaults of RetryPolicy.
+const (
+	defaultSendRetryAttempts = 3
+	defaultSendRetryDelay    = 500 * time.Millisecond
+	defaultSendRetryBudget   = 10 * time.Second
+)
+
+// RetryPolicy for sending an email again within the job, when the sender fails with a messaging.RetryableError,
+// like when the email provider throttles. It's cheaper than returning the message to the queue,
+// which is left to do the retries that don't fit in the budget.
+type RetryPolicy struct {
+	// Attempts at sending, including the first. Defaults to 3. Set it to 1 to leave all retries to the queue.
+	Attempts int
+	// Delay before the first retry, doubled before each one after it, plus up to half of it again of random jitter,
+	// so jobs that failed together don't retry together. Defaults to 500 milliseconds.
+	Delay time.Duration
+	// Budget for all attempts, counted from the first. A retry that wouldn't start within it isn't done.
+	// It must be well below the visibility timeout of the queue, so the message isn't received again meanwhile.
+	// Defaults to 10 seconds.
+	Budget time.Duration
+	// Now defaults to time.Now, and Sleep to waiting for the duration, or until ctx is done.
+	Now   func() time.Time
+	Sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = defaultSendRetryAttempts
+	}
+	if p.Delay <= 0 {
+		p.Delay = defaultSendRetryDelay
+	}
+	if p.Budget <= 0 {
+		p.Budget = defaultSendRetryBudget
+	}
+	if p.Now == nil {
+		p.Now = time.Now
+	}
+	if p.Sleep == nil {
+		p.Sleep = func(ctx context.Context, d time.Duration) error {
+			sleep(ctx, d)
+			return ctx.Err()
+		}
+	}
+	return p
+}
+
+// jitterRand is seeded, unlike the global source with this module's Go version, so workers don't all wait the same.
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// withJitter adds up to half of d to it.
+func withJitter(d time.Duration) time.Duration {
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+	return d + time.Duration(jitterRand.Int63n(int64(d/2)+1))
+}
+
+// SendConfirmationEmailOptions for SendConfirmationEmail.
+type SendConfirmationEmailOptions struct {
+	// BaseURL of the app, used for the confirmation link.
+	BaseURL string
+	// Catalog of translations for the email, which is in the locale of the message. Defaults to i18n.Default.
+	Catalog *i18n.Catalog
+	From    string
+	Log     *zap.Logger
+	// PhysicalAddress in the footer of the email.
+	PhysicalAddress string
+	// Retry of failed sends within the job. See RetryPolicy for the defaults.
+	Retry RetryPolicy
+	// Sender of the email, which skips suppressed addresses, like email.SuppressionGate.
+	Sender  emailSender
+	SendLog sendLogger
+}
+
+// SendConfirmationEmail registers the job that sends the newsletter confirmation email.
+// Rendering errors are permanent, because retrying won't change the result, but sending errors are retried,
+// within the job with the retry policy, and by the queue after that.
+// Every send attempt is recorded in the send log, as skipped for addresses the sender says are suppressed.
+func SendConfirmationEmail(r registry, opts SendConfirmationEmailOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	opts.Retry = opts.Retry.withDefaults()
+	if opts.Catalog == nil {
+		opts.Catalog = i18n.Default()
+	}
+
+	Register(r, func(ctx context.Context, p model.ConfirmationEmailRequested) error {
+		m, err := email.ConfirmationEmail(opts.Catalog.Translator(p.Locale), opts.From, opts.PhysicalAddress, p.Email, opts.BaseURL, p.Token)
+		if err != nil {
+			return Permanent(fmt.Errorf("error rendering confirmation email: %w", err))
+		}
+
+		send := model.EmailSend{Email: p.Email, Type: p.JobName()}
+		if err := sendEmail(ctx, jobLog(ctx, opts.Log), opts.Sender, opts.SendLog, opts.Retry, send, m); err != nil {
+			return fmt.Errorf("error sending confirmation email: %w", err)
+		}
+		return nil
+	})
+}
+
+// sendEmail m with the sender, and records every send attempt in the send log.
+// Messages to suppressed addresses aren't sent, so they're recorded as skipped, and aren't an error,
+// so they're not retried. Retryable errors are retried with the policy, which must have its defaults,
+// and the last error is returned when the attempts or the budget run out, for the queue to retry.
+func sendEmail(ctx context.Context, log *zap.Logger, sender emailSender, l sendLogger, retry RetryPolicy,
+	send model.EmailSend, m email.Message) error {
+	start := retry.Now()
+	delay := retry.Delay
+	for attempt := 1; ; attempt++ {
+		s := send
+		var err error
+		s.ProviderMessageID, err = sender.Send(ctx, m)
+		switch {
+		case errors.Is(err, email.ErrSuppressed):
+			log.Info("Skipping email, address is suppressed", zap.String("type", s.Type))
+			s.Status = model.EmailSendStatusSkipped
+			err = nil
+		case err != nil:
+			s.Status = model.EmailSendStatusFailed
+			s.Error = err.Error()
+		default:
+			s.Status = model.EmailSendStatusSent
+		}
+		recordEmailSend(ctx, log, l, s)
+
+		if err == nil || !messaging.IsRetryable(err) || attempt >= retry.Attempts {
+			return err
+		}
+		wait := withJitter(delay)
+		if retry.Now().Add(wait).Sub(start) > retry.Budget {
+			log.Info("Leaving email retry to the queue, out of retry budget", zap.String("type", s.Type), zap.Int("attempts", attempt))
+			return err
+		}
+		log.Info("Retrying email", zap.Error(err), zap.String("type", s.Type), zap.Int("attempt", attempt), zap.Duration("wait", wait))
+		if sleepErr := retry.Sleep(ctx, wait); sleepErr != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// recordEmailSend in the send log, only logging errors so a sent email isn't sent again because of them.
+func recordEmailSend(ctx context.Context, log *zap.Logger, l sendLogger, s model.EmailSend) {
+	if err := l.RecordEmailSend(ctx, s); err != nil {
+		log.Info("Error recording email send", zap.Error(err), zap.String("type", s.Type))
+	}
+}
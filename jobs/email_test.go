@@ -0,0 +1,410 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/integrationtest"
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+type registryMock struct {
+	jobs map[string]jobs.Func
+}
+
+func (r *registryMock) Register(name string, fn jobs.Func) {
+	if r.jobs == nil {
+		r.jobs = map[string]jobs.Func{}
+	}
+	r.jobs[name] = fn
+}
+
+type emailSenderMock struct {
+	mutex    sync.Mutex
+	err      error
+	messages []email.Message
+}
+
+func (s *emailSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return "", s.err
+	}
+	s.messages = append(s.messages, m)
+	return "provider-123", nil
+}
+
+type sendLoggerMock struct {
+	sends []model.EmailSend
+}
+
+func (l *sendLoggerMock) RecordEmailSend(ctx context.Context, s model.EmailSend) error {
+	l.sends = append(l.sends, s)
+	return nil
+}
+
+// suppressionsMock has the suppressed addresses, which can be added to while jobs run.
+type suppressionsMock struct {
+	mutex      sync.Mutex
+	suppressed map[model.Email]bool
+}
+
+func (s *suppressionsMock) IsSuppressed(ctx context.Context, email model.Email) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.suppressed[email], nil
+}
+
+func (s *suppressionsMock) suppress(email model.Email) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.suppressed == nil {
+		s.suppressed = map[model.Email]bool{}
+	}
+	s.suppressed[email] = true
+}
+
+func TestSendConfirmationEmail(t *testing.T) {
+	message := model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123"}
+
+	t.Run("sends the confirmation email and records it in the send log", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		s := &emailSenderMock{}
+		l := &sendLoggerMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			SendLog:         l,
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.Equal(model.Email("me@example.com"), s.messages[0].To)
+		is.True(strings.Contains(s.messages[0].Text, "token=123"))
+		is.Equal([]model.EmailSend{{
+			Email:             "me@example.com",
+			Type:              "confirmation_email",
+			ProviderMessageID: "provider-123",
+			Status:            model.EmailSendStatusSent,
+		}}, l.sends)
+	})
+
+	t.Run("sends in the locale of the message, and in English for unknown locales", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		s := &emailSenderMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			SendLog:         &sendLoggerMock{},
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(),
+			model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123", "locale": "de"})
+		is.NoErr(err)
+		err = r.jobs["confirmation_email"](context.Background(),
+			model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123", "locale": "xx"})
+		is.NoErr(err)
+		is.Equal(2, len(s.messages))
+		is.Equal("Bestätige dein Abonnement des canvas-Newsletters", s.messages[0].Subject)
+		is.Equal("Confirm your subscription to the canvas newsletter", s.messages[1].Subject)
+	})
+
+	t.Run("records sends to suppressed addresses as skipped, without retrying", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		s := &emailSenderMock{}
+		l := &sendLoggerMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          email.NewSuppressionGate(s, &suppressionsMock{suppressed: map[model.Email]bool{"me@example.com": true}}),
+			SendLog:         l,
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(0, len(s.messages))
+		is.Equal([]model.EmailSend{{
+			Email:  "me@example.com",
+			Type:   "confirmation_email",
+			Status: model.EmailSendStatusSkipped,
+		}}, l.sends)
+	})
+
+	t.Run("returns a retryable error if sending fails", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		l := &sendLoggerMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          &emailSenderMock{err: errors.New("oh no")},
+			SendLog:         l,
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(), message)
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(1, len(l.sends))
+		is.Equal(model.EmailSendStatusFailed, l.sends[0].Status)
+		is.Equal("oh no", l.sends[0].Error)
+	})
+
+	t.Run("returns a permanent error if rendering fails", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		s := &emailSenderMock{}
+		l := &sendLoggerMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL: "not a url",
+			Sender:  s,
+			SendLog: l,
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(), message)
+		is.True(jobs.IsPermanent(err))
+		is.Equal(0, len(s.messages))
+		is.Equal(0, len(l.sends))
+	})
+
+	t.Run("returns a permanent error for a malformed message", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			Sender: &emailSenderMock{}, SendLog: &sendLoggerMock{},
+		})
+
+		err := r.jobs["confirmation_email"](context.Background(), model.Message{"job": "other"})
+		is.True(jobs.IsPermanent(err))
+	})
+}
+
+// scriptedSenderMock fails with the errors in order, one per send, and sends after they run out.
+type scriptedSenderMock struct {
+	errs  []error
+	sends int
+}
+
+func (s *scriptedSenderMock) Send(ctx context.Context, m email.Message) (string, error) {
+	s.sends++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return "", err
+		}
+	}
+	return "provider-123", nil
+}
+
+// fakeClock is a retry policy clock that sleeping moves forward, recording the sleeps.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) policy(attempts int, delay, budget time.Duration) jobs.RetryPolicy {
+	c.now = time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+	return jobs.RetryPolicy{
+		Attempts: attempts,
+		Delay:    delay,
+		Budget:   budget,
+		Now:      func() time.Time { return c.now },
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			c.sleeps = append(c.sleeps, d)
+			c.now = c.now.Add(d)
+			return nil
+		},
+	}
+}
+
+func TestSendConfirmationEmail_Retry(t *testing.T) {
+	message := model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123"}
+	throttled := messaging.Retryable(errors.New("throttled"), time.Minute)
+
+	setup := func(s *scriptedSenderMock, retry jobs.RetryPolicy) (jobs.Func, *sendLoggerMock) {
+		r := &registryMock{}
+		l := &sendLoggerMock{}
+		jobs.SendConfirmationEmail(r, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Retry:           retry,
+			Sender:          s,
+			SendLog:         l,
+		})
+		return r.jobs["confirmation_email"], l
+	}
+
+	statuses := func(l *sendLoggerMock) []model.EmailSendStatus {
+		var statuses []model.EmailSendStatus
+		for _, s := range l.sends {
+			statuses = append(statuses, s.Status)
+		}
+		return statuses
+	}
+
+	t.Run("retries retryable errors with exponential backoff and jitter, recording every attempt", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &fakeClock{}
+		s := &scriptedSenderMock{errs: []error{throttled, throttled}}
+		job, l := setup(s, c.policy(3, time.Second, 10*time.Second))
+
+		err := job(context.Background(), message)
+		is.NoErr(err)
+		is.Equal(3, s.sends)
+		is.Equal([]model.EmailSendStatus{model.EmailSendStatusFailed, model.EmailSendStatusFailed, model.EmailSendStatusSent}, statuses(l))
+		is.Equal("throttled", l.sends[0].Error)
+		is.Equal("provider-123", l.sends[2].ProviderMessageID)
+
+		is.Equal(2, len(c.sleeps))
+		is.True(c.sleeps[0] >= time.Second && c.sleeps[0] <= 1500*time.Millisecond)
+		is.True(c.sleeps[1] >= 2*time.Second && c.sleeps[1] <= 3*time.Second)
+	})
+
+	t.Run("leaves the error to the queue after the last attempt", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &fakeClock{}
+		s := &scriptedSenderMock{errs: []error{throttled, throttled, throttled}}
+		job, l := setup(s, c.policy(2, time.Second, 10*time.Second))
+
+		err := job(context.Background(), message)
+		is.True(messaging.IsRetryable(err))
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(2, s.sends)
+		is.Equal(2, len(l.sends))
+		is.Equal(1, len(c.sleeps))
+	})
+
+	t.Run("doesn't retry when the wait would go over the budget", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &fakeClock{}
+		s := &scriptedSenderMock{errs: []error{throttled, throttled, throttled}}
+		// The first retry starts within 1.5 seconds, but the second not before 3 seconds.
+		job, l := setup(s, c.policy(5, time.Second, 2900*time.Millisecond))
+
+		err := job(context.Background(), message)
+		is.True(messaging.IsRetryable(err))
+		is.Equal(2, s.sends)
+		is.Equal(2, len(l.sends))
+		is.Equal(1, len(c.sleeps))
+	})
+
+	t.Run("doesn't retry errors that aren't retryable", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &fakeClock{}
+		s := &scriptedSenderMock{errs: []error{errors.New("bad address")}}
+		job, l := setup(s, c.policy(3, time.Second, 10*time.Second))
+
+		err := job(context.Background(), message)
+		is.True(err != nil)
+		is.Equal(1, s.sends)
+		is.Equal([]model.EmailSendStatus{model.EmailSendStatusFailed}, statuses(l))
+		is.Equal(0, len(c.sleeps))
+	})
+
+	t.Run("doesn't retry suppressed addresses", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &fakeClock{}
+		s := &scriptedSenderMock{errs: []error{email.ErrSuppressed}}
+		job, l := setup(s, c.policy(3, time.Second, 10*time.Second))
+
+		err := job(context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, s.sends)
+		is.Equal([]model.EmailSendStatus{model.EmailSendStatusSkipped}, statuses(l))
+		is.Equal(0, len(c.sleeps))
+	})
+
+	t.Run("stops retrying when the context is done", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		s := &scriptedSenderMock{errs: []error{throttled, throttled}}
+		job, _ := setup(s, jobs.RetryPolicy{Delay: time.Minute, Budget: time.Hour})
+
+		err := job(ctx, message)
+		is.True(messaging.IsRetryable(err))
+		is.Equal(1, s.sends)
+	})
+}
+
+func TestSendConfirmationEmail_EndToEnd(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("signs up, relays the job through the queue, and sends the email", func(t *testing.T) {
+		is := is.New(t)
+
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		relay := messaging.NewRelay(messaging.NewRelayOptions{Interval: 10 * time.Millisecond, Outbox: db, Queue: queue})
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue})
+		s := &emailSenderMock{}
+		jobs.SendConfirmationEmail(runner, jobs.SendConfirmationEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          email.NewSuppressionGate(s, db),
+			SendLog:         db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go relay.Start(ctx)
+		go runner.Start(ctx)
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			s.mutex.Lock()
+			sent := len(s.messages)
+			s.mutex.Unlock()
+			if sent > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("confirmation email was not sent")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		is.True(strings.Contains(s.messages[0].Text, "token="+token))
+
+		var status string
+		err = db.DB.Get(&status, `select status from email_sends where email = 'me@example.com'`)
+		is.NoErr(err)
+		is.Equal("sent", status)
+	})
+}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+type subscriberImportStore interface {
+	StartSubscriberImport(ctx context.Context, id int64) error
+	ImportSubscriberRows(ctx context.Context, id int64, limit int) (int, error)
+	CompleteSubscriberImport(ctx context.Context, id int64) error
+	FailSubscriberImport(ctx context.Context, id int64, reason string) error
+}
+
+// ImportSubscribersOptions for ImportSubscribers.
+type ImportSubscribersOptions struct {
+	// BatchSize is the number of rows imported per transaction. Defaults to 500.
+	BatchSize int
+	Log       *zap.Logger
+	Store     subscriberImportStore
+}
+
+// ImportSubscribers registers the job that imports the rows of an uploaded file of subscribers, in batches.
+// Every batch is committed with the results of its rows, so a job that's stopped midway resumes with the rows
+// that don't have a result yet, instead of starting over.
+// The import is marked as importing when the job starts, as completed after the last row,
+// and as failed if the job fails permanently.
+func ImportSubscribers(r registry, opts ImportSubscribersOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 500
+	}
+
+	Register(r, func(ctx context.Context, p model.SubscriberImportRequested) error {
+		id, err := strconv.ParseInt(p.ImportID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid subscriber import ID %q: %w", p.ImportID, err))
+		}
+		log := jobLog(ctx, opts.Log).With(zap.Int64("importID", id))
+
+		err = importSubscribers(ctx, log, opts, id)
+		if IsPermanent(err) {
+			if err := opts.Store.FailSubscriberImport(ctx, id, err.Error()); err != nil {
+				log.Info("Error marking subscriber import as failed", zap.Error(err))
+			}
+		}
+		return err
+	})
+}
+
+func importSubscribers(ctx context.Context, log *zap.Logger, opts ImportSubscribersOptions, id int64) error {
+	if err := opts.Store.StartSubscriberImport(ctx, id); err != nil {
+		return err
+	}
+
+	var imported int
+	for {
+		n, err := opts.Store.ImportSubscriberRows(ctx, id, opts.BatchSize)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return Permanent(fmt.Errorf("no subscriber import with ID %v", id))
+			}
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		imported += n
+	}
+
+	if err := opts.Store.CompleteSubscriberImport(ctx, id); err != nil {
+		return err
+	}
+	log.Info("Imported subscribers", zap.Int("rows", imported))
+	return nil
+}
@@ -0,0 +1,136 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// subscriberImportStoreMock with one import of rows, which imports them in batches like storage.Database does,
+// and fails with err once after the rows in failAfter have been imported.
+type subscriberImportStoreMock struct {
+	id        int64
+	state     model.SubscriberImportState
+	rows      int
+	imported  int
+	batches   []int
+	failAfter int
+	err       error
+	reason    string
+}
+
+func (s *subscriberImportStoreMock) StartSubscriberImport(ctx context.Context, id int64) error {
+	if id == s.id && s.state == model.SubscriberImportStateQueued {
+		s.state = model.SubscriberImportStateImporting
+	}
+	return nil
+}
+
+func (s *subscriberImportStoreMock) ImportSubscriberRows(ctx context.Context, id int64, limit int) (int, error) {
+	if id != s.id {
+		return 0, storage.ErrNotFound
+	}
+	if s.err != nil && s.imported >= s.failAfter {
+		err := s.err
+		s.err = nil
+		return 0, err
+	}
+	if s.state != model.SubscriberImportStateImporting {
+		return 0, nil
+	}
+	n := s.rows - s.imported
+	if n > limit {
+		n = limit
+	}
+	s.imported += n
+	if n > 0 {
+		s.batches = append(s.batches, n)
+	}
+	return n, nil
+}
+
+func (s *subscriberImportStoreMock) CompleteSubscriberImport(ctx context.Context, id int64) error {
+	if id == s.id && s.state == model.SubscriberImportStateImporting && s.imported == s.rows {
+		s.state = model.SubscriberImportStateCompleted
+	}
+	return nil
+}
+
+func (s *subscriberImportStoreMock) FailSubscriberImport(ctx context.Context, id int64, reason string) error {
+	s.state = model.SubscriberImportStateFailed
+	s.reason = reason
+	return nil
+}
+
+func TestImportSubscribers(t *testing.T) {
+	setup := func(s *subscriberImportStoreMock) func(id string) error {
+		r := &registryMock{}
+		jobs.ImportSubscribers(r, jobs.ImportSubscribersOptions{BatchSize: 2, Store: s})
+		return func(id string) error {
+			return r.jobs["subscriber_import"](context.Background(), model.Message{"job": "subscriber_import", "importID": id})
+		}
+	}
+
+	t.Run("imports the rows in batches and completes the import", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImportStoreMock{id: 1, state: model.SubscriberImportStateQueued, rows: 5}
+		run := setup(s)
+		is.NoErr(run("1"))
+		is.Equal(model.SubscriberImportStateCompleted, s.state)
+		is.Equal([]int{2, 2, 1}, s.batches)
+	})
+
+	t.Run("resumes with the rows that weren't imported after an error", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImportStoreMock{id: 1, state: model.SubscriberImportStateQueued, rows: 5, failAfter: 2,
+			err: errors.New("connection reset")}
+		run := setup(s)
+		err := run("1")
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(model.SubscriberImportStateImporting, s.state)
+		is.Equal(2, s.imported)
+
+		is.NoErr(run("1"))
+		is.Equal(model.SubscriberImportStateCompleted, s.state)
+		is.Equal([]int{2, 2, 1}, s.batches)
+	})
+
+	t.Run("doesn't import a completed import again when the message is received again", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImportStoreMock{id: 1, state: model.SubscriberImportStateQueued, rows: 3}
+		run := setup(s)
+		is.NoErr(run("1"))
+		is.NoErr(run("1"))
+		is.Equal(model.SubscriberImportStateCompleted, s.state)
+		is.Equal([]int{2, 1}, s.batches)
+	})
+
+	t.Run("fails the import permanently if it doesn't exist", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImportStoreMock{id: 1, state: model.SubscriberImportStateQueued, rows: 3}
+		run := setup(s)
+		err := run("2")
+		is.True(jobs.IsPermanent(err))
+		is.Equal("no subscriber import with ID 2", s.reason)
+	})
+
+	t.Run("fails permanently with an invalid import ID", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &subscriberImportStoreMock{id: 1, state: model.SubscriberImportStateQueued, rows: 3}
+		run := setup(s)
+		is.True(jobs.IsPermanent(run("nope")))
+		is.Equal(model.SubscriberImportStateQueued, s.state)
+	})
+}
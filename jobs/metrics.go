@@ -0,0 +1,213 @@
+package jobs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// unknownType is the message type in the metrics of messages without a registered job,
+// so the number of series stays bounded, whatever is sent to the queue.
+const unknownType = "unknown"
+
+// Results of running the job for a message, in the metrics.
+const (
+	resultSuccess          = "success"
+	resultRetryableFailure = "retryable_failure"
+	resultPermanentFailure = "permanent_failure"
+	resultQuarantine       = "quarantine"
+)
+
+// jobMetrics of the messages the runner processes, by message type, which is the job name.
+// The runner also keeps counts since the last summary, for logging them where there's no Prometheus.
+type jobMetrics struct {
+	age        *prometheus.HistogramVec
+	duration   *prometheus.HistogramVec
+	processing *prometheus.GaugeVec
+	results    *prometheus.CounterVec
+
+	lock      sync.Mutex
+	summaries jobSummaries
+}
+
+func newJobMetrics(registry *prometheus.Registry) *jobMetrics {
+	age := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_job_message_age_seconds",
+		Help:    "How long messages were in the queue when their job started, since they were first sent, by message type.",
+		Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"type"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_job_duration_seconds",
+		Help:    "How long jobs take, by message type.",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"type"})
+	processing := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "app_jobs_processing",
+		Help: "Number of messages whose job is running, by message type.",
+	}, []string{"type"})
+	results := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_job_results_total",
+		Help: "Number of messages processed, by message type and result: success, retryable_failure, permanent_failure, or quarantine.",
+	}, []string{"type", "result"})
+	registry.MustRegister(age, duration, processing, results)
+	return &jobMetrics{
+		age:        age,
+		duration:   duration,
+		processing: processing,
+		results:    results,
+		summaries:  jobSummaries{},
+	}
+}
+
+// received a message of the type, first sent at sent, if known.
+func (m *jobMetrics) received(typ string, sent time.Time) {
+	if sent.IsZero() {
+		return
+	}
+	age := time.Since(sent)
+	if age < 0 {
+		age = 0
+	}
+	m.age.WithLabelValues(typ).Observe(age.Seconds())
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := m.summaries.get(typ)
+	if age > s.maxAge {
+		s.maxAge = age
+	}
+}
+
+// start running the job for a message of the type.
+func (m *jobMetrics) start(typ string) {
+	m.processing.WithLabelValues(typ).Inc()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.summaries.get(typ).processing++
+}
+
+// finish running the job for a message of the type, with the result, after d.
+func (m *jobMetrics) finish(typ, result string, d time.Duration) {
+	m.processing.WithLabelValues(typ).Dec()
+	m.duration.WithLabelValues(typ).Observe(d.Seconds())
+
+	m.lock.Lock()
+	s := m.summaries.get(typ)
+	s.processing--
+	s.duration += d
+	m.lock.Unlock()
+
+	m.count(typ, result)
+}
+
+// count the result of a message of the type.
+func (m *jobMetrics) count(typ, result string) {
+	m.results.WithLabelValues(typ, result).Inc()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.summaries.get(typ).results[result]++
+}
+
+// logSummary of the messages processed since the last summary and the ones processing now, if there are any.
+func (m *jobMetrics) logSummary(log *zap.Logger) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.summaries.active() {
+		return
+	}
+	// The summaries are logged as they are now, because the fields may be encoded after they've been reset.
+	log.Info("Job summary", zap.Object("types", m.summaries.copy()))
+	m.summaries.reset()
+}
+
+// jobSummaries by message type.
+type jobSummaries map[string]*jobSummary
+
+// jobSummary of the messages of a type since the last summary.
+type jobSummary struct {
+	// duration of all the jobs that finished, for the average.
+	duration   time.Duration
+	maxAge     time.Duration
+	processing int
+	results    map[string]int
+}
+
+func (ss jobSummaries) get(typ string) *jobSummary {
+	s, ok := ss[typ]
+	if !ok {
+		s = &jobSummary{results: map[string]int{}}
+		ss[typ] = s
+	}
+	return s
+}
+
+// active is true if any message was processed since the last summary, or is processing now.
+func (ss jobSummaries) active() bool {
+	for _, s := range ss {
+		if s.processing > 0 || s.maxAge > 0 || len(s.results) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// copy of the summaries.
+func (ss jobSummaries) copy() jobSummaries {
+	c := make(jobSummaries, len(ss))
+	for typ, s := range ss {
+		results := make(map[string]int, len(s.results))
+		for result, n := range s.results {
+			results[result] = n
+		}
+		c[typ] = &jobSummary{duration: s.duration, maxAge: s.maxAge, processing: s.processing, results: results}
+	}
+	return c
+}
+
+// reset the summaries for the next one, keeping the counts of the messages processing now.
+func (ss jobSummaries) reset() {
+	for typ, s := range ss {
+		if s.processing == 0 {
+			delete(ss, typ)
+			continue
+		}
+		ss[typ] = &jobSummary{processing: s.processing, results: map[string]int{}}
+	}
+}
+
+// MarshalLogObject satisfies zapcore.ObjectMarshaler, for logging with zap.Object, with the types sorted by name.
+func (ss jobSummaries) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	types := make([]string, 0, len(ss))
+	for typ := range ss {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		if err := enc.AddObject(typ, ss[typ]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalLogObject satisfies zapcore.ObjectMarshaler, for logging with zap.Object.
+func (s *jobSummary) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	var finished int
+	for _, result := range []string{resultSuccess, resultRetryableFailure, resultPermanentFailure, resultQuarantine} {
+		enc.AddInt(result, s.results[result])
+		finished += s.results[result]
+	}
+	enc.AddInt("processing", s.processing)
+	if finished > 0 {
+		enc.AddDuration("averageDuration", s.duration/time.Duration(finished))
+	}
+	enc.AddDuration("maxAge", s.maxAge)
+	return nil
+}
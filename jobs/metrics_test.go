@@ -0,0 +1,150 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/apperr"
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+func TestRunner_metrics(t *testing.T) {
+	newRunner := func(opts jobs.NewRunnerOptions) (*jobs.Runner, *messaging.MemoryQueue, *prometheus.Registry) {
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		registry := prometheus.NewRegistry()
+		opts.DeadLetterQueue = messaging.NewMemoryQueue(10 * time.Millisecond)
+		opts.Metrics = registry
+		// Retried messages aren't received again during the test.
+		opts.NackDelay = time.Hour
+		opts.Queue = queue
+		r := jobs.NewRunner(opts)
+		r.Register("ok", func(ctx context.Context, m model.Message) error {
+			return nil
+		})
+		r.Register("retry", func(ctx context.Context, m model.Message) error {
+			return apperr.Unavailable
+		})
+		r.Register("fail", func(ctx context.Context, m model.Message) error {
+			return jobs.Permanent(errors.New("oh no"))
+		})
+		r.Register("invalid", func(ctx context.Context, m model.Message) error {
+			return &messaging.ValidationError{Job: "invalid", Err: errors.New("missing email")}
+		})
+		r.Register("panic", func(ctx context.Context, m model.Message) error {
+			panic("oh no")
+		})
+		return r, queue, registry
+	}
+
+	// run the runner until the metric matches expected, or fail after a second.
+	run := func(t *testing.T, r *jobs.Runner, registry *prometheus.Registry, expected, metric string) {
+		t.Helper()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			err := testutil.GatherAndCompare(registry, strings.NewReader(expected), metric)
+			if err == nil {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Run("counts results by message type, with unknown types in one", func(t *testing.T) {
+		is := is.New(t)
+
+		r, queue, registry := newRunner(jobs.NewRunnerOptions{})
+		for _, name := range []string{"ok", "ok", "retry", "fail", "invalid", "doesnotexist", ""} {
+			is.NoErr(queue.Send(context.Background(), model.Message{"job": name}))
+		}
+
+		run(t, r, registry, `
+# HELP app_job_results_total Number of messages processed, by message type and result: success, retryable_failure, permanent_failure, or quarantine.
+# TYPE app_job_results_total counter
+app_job_results_total{result="permanent_failure",type="fail"} 1
+app_job_results_total{result="permanent_failure",type="unknown"} 2
+app_job_results_total{result="quarantine",type="invalid"} 1
+app_job_results_total{result="retryable_failure",type="retry"} 1
+app_job_results_total{result="success",type="ok"} 2
+`, "app_job_results_total")
+
+		is.Equal(4, testutil.CollectAndCount(registry, "app_job_duration_seconds"))
+		is.Equal(5, testutil.CollectAndCount(registry, "app_job_message_age_seconds"))
+	})
+
+	t.Run("counts panics as permanent failures, and not processing anymore", func(t *testing.T) {
+		is := is.New(t)
+
+		r, queue, registry := newRunner(jobs.NewRunnerOptions{})
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "panic"}))
+
+		run(t, r, registry, `
+# HELP app_job_results_total Number of messages processed, by message type and result: success, retryable_failure, permanent_failure, or quarantine.
+# TYPE app_job_results_total counter
+app_job_results_total{result="permanent_failure",type="panic"} 1
+`, "app_job_results_total")
+
+		err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_jobs_processing Number of messages whose job is running, by message type.
+# TYPE app_jobs_processing gauge
+app_jobs_processing{type="panic"} 0
+`), "app_jobs_processing")
+		is.NoErr(err)
+	})
+
+	t.Run("logs a summary of the messages processed by type every summary interval", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		r, queue, registry := newRunner(jobs.NewRunnerOptions{Log: zap.New(core), SummaryInterval: 20 * time.Millisecond})
+		for _, name := range []string{"ok", "ok", "fail"} {
+			is.NoErr(queue.Send(context.Background(), model.Message{"job": name}))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		deadline := time.Now().Add(time.Second)
+		for logs.FilterMessage("Job summary").Len() == 0 || testutil.CollectAndCount(registry, "app_job_results_total") < 2 {
+			if time.Now().After(deadline) {
+				t.Fatal("no summary logged")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+
+		// The messages may be processed across summaries, so they're added up.
+		results := map[string]int{}
+		for _, e := range logs.FilterMessage("Job summary").All() {
+			types := e.ContextMap()["types"].(map[string]interface{})
+			for typ, summary := range types {
+				for _, result := range []string{"success", "permanent_failure"} {
+					results[typ+" "+result] += summary.(map[string]interface{})[result].(int)
+				}
+			}
+		}
+		is.Equal(2, results["ok success"])
+		is.Equal(1, results["fail permanent_failure"])
+		is.Equal(0, results["ok permanent_failure"])
+	})
+}
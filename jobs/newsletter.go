@@ -0,0 +1,330 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"canvas/apperr"
+	"canvas/email"
+	"canvas/flags"
+	"canvas/i18n"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+type batchSender interface {
+	SendBatch(ctx context.Context, ms []model.Message) (messaging.BatchResult, error)
+	RetryFailed(ctx context.Context, result messaging.BatchResult, attempts int) (messaging.BatchResult, error)
+}
+
+type newsletterGetter interface {
+	GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error)
+}
+
+type fanOutStore interface {
+	newsletterGetter
+	sendLogger
+	ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error)
+	GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error)
+	GetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64) (model.Email, error)
+	SetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64, lastEmail model.Email, enqueued, enqueueFailed int) error
+	StartNewsletterSend(ctx context.Context, newsletterID int64) error
+	FinishNewsletterFanOut(ctx context.Context, newsletterID int64) error
+	FailNewsletterSend(ctx context.Context, newsletterID int64, reason string) error
+}
+
+// quotaChecker tells whether there's headroom in the sending quota for bulk sending, like *email.QuotaManager.
+type quotaChecker interface {
+	CheckHeadroom() error
+}
+
+// FanOutNewsletterIssueOptions for FanOutNewsletterIssue.
+type FanOutNewsletterIssueOptions struct {
+	// BatchSize is the number of subscribers enqueued per checkpoint. Defaults to 100.
+	BatchSize int
+	Log       *zap.Logger
+	Queue     batchSender
+	// Quota pauses the fan-out before the next batch while there's no headroom in the daily sending quota.
+	// Without it, the fan-out doesn't pause.
+	Quota quotaChecker
+	// RetryAttempts for enqueueing failed batch entries. Defaults to 3.
+	RetryAttempts int
+	Store         fanOutStore
+}
+
+// FanOutNewsletterIssue registers the job that enqueues a newsletter issue email for every confirmed subscriber
+// in the segment of the send.
+// Progress is checkpointed in the database after every batch, so a job that's stopped midway resumes
+// where it left off instead of starting over. Entries that can't be enqueued even after retrying are recorded
+// as failed in the send log, and don't stop the rest of the issue from going out.
+// The send of the issue is marked as sending when the job starts, and as failed if the job fails permanently.
+// While the quota has no headroom, the job returns its retryable error, so it resumes from the checkpoint later.
+func FanOutNewsletterIssue(r registry, opts FanOutNewsletterIssueOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 100
+	}
+	if opts.RetryAttempts < 1 {
+		opts.RetryAttempts = 3
+	}
+
+	Register(r, func(ctx context.Context, p model.NewsletterIssueSendRequested) error {
+		id, err := strconv.ParseInt(p.NewsletterID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid newsletter ID %q: %w", p.NewsletterID, err))
+		}
+		log := jobLog(ctx, opts.Log).With(zap.Int64("newsletterID", id))
+
+		err = fanOut(ctx, log, opts, id, p.NewsletterID)
+		if IsPermanent(err) {
+			if err := opts.Store.FailNewsletterSend(ctx, id, err.Error()); err != nil {
+				log.Info("Error marking newsletter send as failed", zap.Error(err))
+			}
+		}
+		return err
+	})
+}
+
+// fanOut the newsletter issue with the ID, which is also in newsletterID as it is in the job payload.
+func fanOut(ctx context.Context, log *zap.Logger, opts FanOutNewsletterIssueOptions, id int64, newsletterID string) error {
+	n, err := opts.Store.GetNewsletter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return Permanent(fmt.Errorf("no newsletter with ID %v", id))
+	}
+
+	if err := opts.Store.StartNewsletterSend(ctx, id); err != nil {
+		return err
+	}
+
+	send, err := opts.Store.GetNewsletterSend(ctx, id)
+	if err != nil {
+		return err
+	}
+	var segment model.Segment
+	if send != nil {
+		segment = send.Segment
+	}
+
+	after, err := opts.Store.GetNewsletterSendCheckpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+	if after != "" {
+		log.Info("Resuming newsletter fan-out", zap.Stringer("after", after))
+	}
+
+	for {
+		if opts.Quota != nil {
+			if err := opts.Quota.CheckHeadroom(); err != nil {
+				log.Info("Pausing newsletter fan-out", zap.Stringer("after", after), zap.Error(err))
+				return err
+			}
+		}
+
+		subscribers, err := opts.Store.ListSubscribers(ctx, storage.ListSubscribersOptions{
+			After:   after,
+			Limit:   opts.BatchSize,
+			Segment: segment,
+			Status:  model.SubscriberStatusConfirmed,
+		})
+		if err != nil {
+			if errors.Is(err, apperr.Invalid) {
+				return Permanent(err)
+			}
+			return err
+		}
+		if len(subscribers) == 0 {
+			break
+		}
+
+		var ms []model.Message
+		for _, s := range subscribers {
+			request := model.NewsletterIssueEmailRequested{NewsletterID: newsletterID, Email: s.Email, Locale: s.Locale}
+			if !s.TrackingOptOut {
+				request.SubscriberID = strconv.FormatInt(s.ID, 10)
+			}
+			m, err := messaging.NewMessage(request)
+			if err != nil {
+				return Permanent(err)
+			}
+			ms = append(ms, m)
+		}
+
+		result, err := opts.Queue.SendBatch(ctx, ms)
+		if err != nil {
+			return err
+		}
+		result, err = opts.Queue.RetryFailed(ctx, result, opts.RetryAttempts)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range result.Failed {
+			log.Info("Error enqueueing newsletter issue email", zap.String("email", f.Message["email"]),
+				zap.String("code", f.Code), zap.String("error", f.Err))
+			recordEmailSend(ctx, log, opts.Store, model.EmailSend{
+				Email:        model.Email(f.Message["email"]),
+				Type:         model.NewsletterIssueEmailRequested{}.JobName(),
+				NewsletterID: id,
+				Status:       model.EmailSendStatusFailed,
+				Error:        f.Code + ": " + f.Err,
+			})
+		}
+
+		after = subscribers[len(subscribers)-1].Email
+		if err := opts.Store.SetNewsletterSendCheckpoint(ctx, id, after, len(result.Succeeded), len(result.Failed)); err != nil {
+			return err
+		}
+	}
+
+	if err := opts.Store.FinishNewsletterFanOut(ctx, id); err != nil {
+		return err
+	}
+	log.Info("Finished newsletter fan-out")
+	return nil
+}
+
+type newsletterEmailStore interface {
+	newsletterGetter
+	sendLogger
+	HasSentNewsletter(ctx context.Context, newsletterID int64, email model.Email) (bool, error)
+	CompleteNewsletterSendIfDone(ctx context.Context, newsletterID int64) error
+}
+
+// SendNewsletterIssueEmailOptions for SendNewsletterIssueEmail.
+type SendNewsletterIssueEmailOptions struct {
+	// BaseURL for the unsubscribe links in the email.
+	BaseURL string
+	// Catalog of translations for the text around the newsletter content, in the locale of the message.
+	// Defaults to i18n.Default.
+	Catalog *i18n.Catalog
+	// Flags for the tracking pixel and tracked links, which are behind the tracking-pixel flag,
+	// rolled out by the email address of the subscriber. Without them, tracking isn't behind a flag.
+	Flags flags.Provider
+	From  string
+	// Limiter for the send rate, shared by all runs of the job. Unlimited if nil.
+	Limiter *rate.Limiter
+	Log     *zap.Logger
+	// PhysicalAddress in the footer of the email.
+	PhysicalAddress string
+	// Quota stops sending while there's no headroom in the daily sending quota, returning the message to the queue.
+	// Without it, sending doesn't stop.
+	Quota quotaChecker
+	// Retry of failed sends within the job. See RetryPolicy for the defaults. Retries don't wait for the Limiter.
+	Retry RetryPolicy
+	// Sender of the emails, which skips suppressed addresses, like email.SuppressionGate.
+	Sender emailSender
+	Store  newsletterEmailStore
+	// TrackingSecret signs the open tracking pixel and the tracked links in the email.
+	// Without it, there's no tracking.
+	TrackingSecret []byte
+	// UnsubscribeMailto is the address of the mailbox for unsubscribing by email, added to the List-Unsubscribe header
+	// if it's set. See email.WithUnsubscribeMailto.
+	UnsubscribeMailto string
+	// UnsubscribeSecret signs the unsubscribe links in the email.
+	UnsubscribeSecret []byte
+}
+
+// SendNewsletterIssueEmail registers the job that sends a newsletter issue to a single subscriber.
+// Subscribers that have already been sent the issue according to the send log are skipped,
+// so a fan-out that resumes after a crash doesn't send the issue twice. The sender skips subscribers suppressed
+// since the fan-out, like after bouncing, which are recorded as skipped.
+// The email has an open tracking pixel and tracked links, unless the subscriber opted out of tracking,
+// or the tracking-pixel flag is off for them.
+// Sending errors are retried within the job with the retry policy, and by the queue after that.
+// While the quota has no headroom, the email isn't sent, and the job is tried again later.
+// After sending, the send of the issue is completed if it was the last email of it.
+func SendNewsletterIssueEmail(r registry, opts SendNewsletterIssueEmailOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Catalog == nil {
+		opts.Catalog = i18n.Default()
+	}
+	opts.Retry = opts.Retry.withDefaults()
+
+	Register(r, func(ctx context.Context, p model.NewsletterIssueEmailRequested) error {
+		id, err := strconv.ParseInt(p.NewsletterID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid newsletter ID %q: %w", p.NewsletterID, err))
+		}
+
+		sent, err := opts.Store.HasSentNewsletter(ctx, id, p.Email)
+		if err != nil {
+			return err
+		}
+		if sent {
+			return nil
+		}
+		n, err := opts.Store.GetNewsletter(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n == nil {
+			return Permanent(fmt.Errorf("no newsletter with ID %v", id))
+		}
+
+		m, err := email.NewsletterEmail(opts.Catalog.Translator(p.Locale), opts.From, opts.PhysicalAddress, p.Email, *n, opts.BaseURL, opts.UnsubscribeSecret)
+		if err != nil {
+			return Permanent(fmt.Errorf("error rendering newsletter email: %w", err))
+		}
+		if opts.UnsubscribeMailto != "" {
+			m = email.WithUnsubscribeMailto(m, opts.UnsubscribeMailto, email.CreateUnsubscribeToken(opts.UnsubscribeSecret, p.Email))
+		}
+		tracking := opts.Flags == nil || opts.Flags.Enabled(flags.TrackingPixel, p.Email.String())
+		if tracking && p.SubscriberID != "" && len(opts.TrackingSecret) > 0 {
+			subscriberID, err := strconv.ParseInt(p.SubscriberID, 10, 64)
+			if err != nil {
+				return Permanent(fmt.Errorf("invalid subscriber ID %q: %w", p.SubscriberID, err))
+			}
+			m, err = email.WithOpenTracking(m, opts.BaseURL, email.CreateOpenToken(opts.TrackingSecret, id, subscriberID))
+			if err != nil {
+				return Permanent(fmt.Errorf("error adding open tracking to newsletter email: %w", err))
+			}
+			m, err = email.WithClickTracking(m, opts.BaseURL, opts.TrackingSecret, id, subscriberID)
+			if err != nil {
+				return Permanent(fmt.Errorf("error adding click tracking to newsletter email: %w", err))
+			}
+		}
+
+		if opts.Quota != nil {
+			if err := opts.Quota.CheckHeadroom(); err != nil {
+				return err
+			}
+		}
+		if opts.Limiter != nil {
+			if err := opts.Limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		send := model.EmailSend{Email: p.Email, Type: p.JobName(), NewsletterID: id}
+		log := jobLog(ctx, opts.Log)
+		// Skipped sends are recorded too, so the send of the issue can complete without them.
+		err = sendEmail(ctx, log, opts.Sender, opts.Store, opts.Retry, send, m)
+		completeNewsletterSend(ctx, log, opts.Store, id)
+		if err != nil {
+			return fmt.Errorf("error sending newsletter email: %w", err)
+		}
+		return nil
+	})
+}
+
+// completeNewsletterSend if it's done, logging instead of failing the job if it can't be checked,
+// since the email has been sent or not either way.
+func completeNewsletterSend(ctx context.Context, log *zap.Logger, s newsletterEmailStore, newsletterID int64) {
+	if err := s.CompleteNewsletterSendIfDone(ctx, newsletterID); err != nil {
+		log.Info("Error completing newsletter send", zap.Int64("newsletterID", newsletterID), zap.Error(err))
+	}
+}
@@ -0,0 +1,728 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/email"
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// newsletterStoreMock keeps subscribers, checkpoints, send states, and the send log in memory, like the database would.
+type newsletterStoreMock struct {
+	sendLoggerMock
+	newsletters   map[int64]model.Newsletter
+	subscribers   []model.Subscriber
+	checkpoints   map[int64]model.Email
+	enqueued      map[int64]int
+	enqueueFailed map[int64]int
+	fannedOut     map[int64]bool
+	states        map[int64]model.NewsletterSendState
+	errors        map[int64]string
+	segments      map[int64]model.Segment
+	tags          map[int64][]model.Tag
+}
+
+func newNewsletterStoreMock(subscriberCount int) *newsletterStoreMock {
+	s := &newsletterStoreMock{
+		newsletters:   map[int64]model.Newsletter{1: {ID: 1, Title: "Issue 1", Body: "Hello."}},
+		checkpoints:   map[int64]model.Email{},
+		enqueued:      map[int64]int{},
+		enqueueFailed: map[int64]int{},
+		fannedOut:     map[int64]bool{},
+		states:        map[int64]model.NewsletterSendState{1: model.NewsletterSendStateQueued},
+		errors:        map[int64]string{},
+		segments:      map[int64]model.Segment{},
+		tags:          map[int64][]model.Tag{},
+	}
+	for i := 0; i < subscriberCount; i++ {
+		s.subscribers = append(s.subscribers, model.Subscriber{
+			ID:        int64(i + 1),
+			Email:     model.Email(fmt.Sprintf("me%03d@example.com", i)),
+			Confirmed: true,
+			Active:    true,
+		})
+	}
+	return s
+}
+
+func (s *newsletterStoreMock) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	n, ok := s.newsletters[id]
+	if !ok {
+		return nil, nil
+	}
+	return &n, nil
+}
+
+func (s *newsletterStoreMock) ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error) {
+	e, err := opts.Segment.Parse()
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Invalid, err)
+	}
+	var subscribers []model.Subscriber
+	for _, sub := range s.subscribers {
+		if sub.Email <= opts.After || (opts.Status != "" && sub.Status() != opts.Status) {
+			continue
+		}
+		if e != nil && !e.Matches(s.tags[sub.ID]) {
+			continue
+		}
+		subscribers = append(subscribers, sub)
+		if len(subscribers) == opts.Limit {
+			break
+		}
+	}
+	return subscribers, nil
+}
+
+func (s *newsletterStoreMock) GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error) {
+	state, ok := s.states[newsletterID]
+	if !ok {
+		return nil, nil
+	}
+	return &model.NewsletterSend{NewsletterID: newsletterID, State: state, Segment: s.segments[newsletterID]}, nil
+}
+
+func (s *newsletterStoreMock) GetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64) (model.Email, error) {
+	return s.checkpoints[newsletterID], nil
+}
+
+func (s *newsletterStoreMock) SetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64, lastEmail model.Email, enqueued, enqueueFailed int) error {
+	s.checkpoints[newsletterID] = lastEmail
+	s.enqueued[newsletterID] += enqueued
+	s.enqueueFailed[newsletterID] += enqueueFailed
+	return nil
+}
+
+func (s *newsletterStoreMock) StartNewsletterSend(ctx context.Context, newsletterID int64) error {
+	if s.states[newsletterID] == model.NewsletterSendStateQueued {
+		s.states[newsletterID] = model.NewsletterSendStateSending
+	}
+	return nil
+}
+
+func (s *newsletterStoreMock) FinishNewsletterFanOut(ctx context.Context, newsletterID int64) error {
+	s.fannedOut[newsletterID] = true
+	return s.CompleteNewsletterSendIfDone(ctx, newsletterID)
+}
+
+func (s *newsletterStoreMock) CompleteNewsletterSendIfDone(ctx context.Context, newsletterID int64) error {
+	if s.states[newsletterID] != model.NewsletterSendStateSending || !s.fannedOut[newsletterID] {
+		return nil
+	}
+	emails := map[model.Email]bool{}
+	for _, send := range s.sends {
+		if send.NewsletterID == newsletterID {
+			emails[send.Email] = true
+		}
+	}
+	if len(emails) >= s.enqueued[newsletterID]+s.enqueueFailed[newsletterID] {
+		s.states[newsletterID] = model.NewsletterSendStateCompleted
+	}
+	return nil
+}
+
+func (s *newsletterStoreMock) FailNewsletterSend(ctx context.Context, newsletterID int64, reason string) error {
+	s.states[newsletterID] = model.NewsletterSendStateFailed
+	s.errors[newsletterID] = reason
+	return nil
+}
+
+func (s *newsletterStoreMock) HasSentNewsletter(ctx context.Context, newsletterID int64, email model.Email) (bool, error) {
+	for _, send := range s.sends {
+		if send.NewsletterID == newsletterID && send.Email == email && send.Status == model.EmailSendStatusSent {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sesAccountClientMock returns the quota in GetAccount, for an email.QuotaManager.
+type sesAccountClientMock struct {
+	quota types.SendQuota
+}
+
+func (c *sesAccountClientMock) GetAccount(ctx context.Context, params *sesv2.GetAccountInput, optFns ...func(*sesv2.Options)) (*sesv2.GetAccountOutput, error) {
+	quota := c.quota
+	return &sesv2.GetAccountOutput{SendQuota: &quota}, nil
+}
+
+// batchSenderMock wraps a memory queue, and can crash after a number of batches or fail single entries.
+type batchSenderMock struct {
+	queue *messaging.MemoryQueue
+	// crashAfter batches, if positive.
+	crashAfter int
+	batches    int
+	// fail entries with this email address, with an error that isn't retryable.
+	fail model.Email
+	// afterBatch is called after each batch, if it's set.
+	afterBatch func()
+}
+
+func (b *batchSenderMock) SendBatch(ctx context.Context, ms []model.Message) (messaging.BatchResult, error) {
+	if b.crashAfter > 0 && b.batches == b.crashAfter {
+		return messaging.BatchResult{}, errors.New("crashed")
+	}
+	b.batches++
+
+	var result messaging.BatchResult
+	for i, m := range ms {
+		id := fmt.Sprint(i)
+		if model.Email(m["email"]) == b.fail {
+			result.Failed = append(result.Failed, messaging.BatchFailure{
+				ID: id, Message: m, Code: "InvalidMessageContents", Err: "oh no", SenderFault: true,
+			})
+			continue
+		}
+		if err := b.queue.Send(ctx, m); err != nil {
+			return result, err
+		}
+		result.Succeeded = append(result.Succeeded, id)
+	}
+	if b.afterBatch != nil {
+		b.afterBatch()
+	}
+	return result, nil
+}
+
+func (b *batchSenderMock) RetryFailed(ctx context.Context, result messaging.BatchResult, attempts int) (messaging.BatchResult, error) {
+	return result, nil
+}
+
+// drain the memory queue, returning the email addresses of all queued messages.
+func drain(t *testing.T, q *messaging.MemoryQueue) []string {
+	t.Helper()
+
+	var emails []string
+	for q.Len() > 0 {
+		rm, err := q.Receive(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		emails = append(emails, rm.Message["email"])
+		if err := q.Delete(context.Background(), rm.ReceiptID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+func TestFanOutNewsletterIssue(t *testing.T) {
+	message := model.Message{"job": "newsletter_issue_send", "newsletterID": "1"}
+
+	t.Run("enqueues an email job for every confirmed subscriber", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(25)
+		store.subscribers[3].Confirmed = false
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			BatchSize: 10,
+			Queue:     &batchSenderMock{queue: queue},
+			Store:     store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		emails := drain(t, queue)
+		is.Equal(24, len(emails))
+		is.Equal(24, store.enqueued[1])
+		is.Equal(model.Email("me024@example.com"), store.checkpoints[1])
+		is.True(store.fannedOut[1])
+		is.Equal(model.NewsletterSendStateSending, store.states[1])
+	})
+
+	t.Run("enqueues an email job only for the confirmed subscribers in the segment of the send", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(25)
+		for id := int64(1); id <= 25; id++ {
+			switch {
+			case id%5 == 0:
+				store.tags[id] = []model.Tag{"api", "beta"}
+			case id%2 == 0:
+				store.tags[id] = []model.Tag{"api"}
+			}
+		}
+		store.subscribers[4].Confirmed = false
+		store.segments[1] = "api and beta"
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			BatchSize: 2,
+			Queue:     &batchSenderMock{queue: queue},
+			Store:     store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		emails := drain(t, queue)
+		is.Equal([]string{"me009@example.com", "me014@example.com", "me019@example.com", "me024@example.com"}, emails)
+		is.Equal(4, store.enqueued[1])
+		is.True(store.fannedOut[1])
+	})
+
+	t.Run("marks the send as failed if its segment is invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(2)
+		store.segments[1] = "api and"
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: messaging.NewMemoryQueue(time.Millisecond)},
+			Store: store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.True(jobs.IsPermanent(err))
+		is.Equal(model.NewsletterSendStateFailed, store.states[1])
+	})
+
+	t.Run("includes the subscriber ID for open tracking unless the subscriber opted out", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(2)
+		store.subscribers[1].TrackingOptOut = true
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: queue},
+			Store: store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		subscriberIDs := map[string]string{}
+		for queue.Len() > 0 {
+			rm, err := queue.Receive(context.Background())
+			is.NoErr(err)
+			subscriberIDs[rm.Message["email"]] = rm.Message["subscriberID"]
+			is.NoErr(queue.Delete(context.Background(), rm.ReceiptID))
+		}
+		is.Equal(map[string]string{"me000@example.com": "1", "me001@example.com": ""}, subscriberIDs)
+	})
+
+	t.Run("resumes from the checkpoint after a crash and enqueues each subscriber exactly once", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(25)
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		sender := &batchSenderMock{queue: queue, crashAfter: 2}
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			BatchSize: 10,
+			Queue:     sender,
+			Store:     store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(model.Email("me019@example.com"), store.checkpoints[1])
+		is.Equal(model.NewsletterSendStateSending, store.states[1])
+		is.True(!store.fannedOut[1])
+
+		sender.crashAfter = 0
+		err = r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		emails := drain(t, queue)
+		is.Equal(25, len(emails))
+		for i, e := range emails {
+			is.Equal(fmt.Sprintf("me%03d@example.com", i), e)
+		}
+		is.Equal(25, store.enqueued[1])
+	})
+
+	t.Run("pauses at a checkpoint when the sending quota runs out, and resumes from it", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(25)
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 100, MaxSendRate: 10, SentLast24Hours: 50}}
+		quota := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client})
+		is.NoErr(quota.Refresh(context.Background()))
+		sender := &batchSenderMock{queue: queue, afterBatch: func() {
+			// Like other sends using up the quota while the first batch is enqueued.
+			client.quota.SentLast24Hours = 95
+			is.NoErr(quota.Refresh(context.Background()))
+		}}
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			BatchSize: 10,
+			Queue:     sender,
+			Quota:     quota,
+			Store:     store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.True(errors.Is(err, email.ErrQuotaPaused))
+		is.True(messaging.IsRetryable(err))
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(model.Email("me009@example.com"), store.checkpoints[1])
+		is.Equal(model.NewsletterSendStateSending, store.states[1])
+		is.Equal(1, sender.batches)
+
+		// The queue runs the job again later, still paused, and then after the quota has freed up.
+		err = r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.True(errors.Is(err, email.ErrQuotaPaused))
+		is.Equal(1, sender.batches)
+
+		sender.afterBatch = nil
+		client.quota.SentLast24Hours = 0
+		is.NoErr(quota.Refresh(context.Background()))
+		err = r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		emails := drain(t, queue)
+		is.Equal(25, len(emails))
+		for i, e := range emails {
+			is.Equal(fmt.Sprintf("me%03d@example.com", i), e)
+		}
+		is.Equal(25, store.enqueued[1])
+		is.True(store.fannedOut[1])
+	})
+
+	t.Run("records entries that could not be enqueued as failed and continues", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(5)
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			BatchSize: 2,
+			Queue:     &batchSenderMock{queue: queue, fail: "me002@example.com"},
+			Store:     store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(4, len(drain(t, queue)))
+		is.Equal(1, len(store.sends))
+		is.Equal(model.Email("me002@example.com"), store.sends[0].Email)
+		is.Equal(int64(1), store.sends[0].NewsletterID)
+		is.Equal(model.EmailSendStatusFailed, store.sends[0].Status)
+		is.Equal(1, store.enqueueFailed[1])
+	})
+
+	t.Run("completes the send when every email has been sent", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(3)
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: queue, fail: "me001@example.com"},
+			Store: store,
+		})
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          &emailSenderMock{},
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateSending, store.states[1])
+
+		for _, e := range drain(t, queue) {
+			is.Equal(model.NewsletterSendStateSending, store.states[1])
+			err := r.jobs["newsletter_issue_email"](context.Background(), model.Message{"job": "newsletter_issue_email", "newsletterID": "1", "email": e})
+			is.NoErr(err)
+		}
+		is.Equal(model.NewsletterSendStateCompleted, store.states[1])
+	})
+
+	t.Run("stops sending to addresses suppressed during the send, and still completes it", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(3)
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		s := &emailSenderMock{}
+		suppressions := &suppressionsMock{}
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: queue},
+			Store: store,
+		})
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          email.NewSuppressionGate(s, suppressions),
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), message)
+		is.NoErr(err)
+
+		for i, e := range drain(t, queue) {
+			err := r.jobs["newsletter_issue_email"](context.Background(), model.Message{"job": "newsletter_issue_email", "newsletterID": "1", "email": e})
+			is.NoErr(err)
+			// Like complaints coming in for the rest of the subscribers after the first email went out.
+			if i == 0 {
+				suppressions.suppress("me001@example.com")
+				suppressions.suppress("me002@example.com")
+			}
+		}
+
+		is.Equal(1, len(s.messages))
+		is.Equal(model.Email("me000@example.com"), s.messages[0].To)
+		var statuses []model.EmailSendStatus
+		for _, send := range store.sends {
+			statuses = append(statuses, send.Status)
+		}
+		is.Equal([]model.EmailSendStatus{model.EmailSendStatusSent, model.EmailSendStatusSkipped, model.EmailSendStatusSkipped}, statuses)
+		is.Equal(model.NewsletterSendStateCompleted, store.states[1])
+	})
+
+	t.Run("marks the send as failed if the newsletter does not exist", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		store.states[2] = model.NewsletterSendStateQueued
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: messaging.NewMemoryQueue(time.Millisecond)},
+			Store: store,
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), model.Message{"job": "newsletter_issue_send", "newsletterID": "2"})
+		is.True(jobs.IsPermanent(err))
+		is.Equal(model.NewsletterSendStateFailed, store.states[2])
+		is.Equal("no newsletter with ID 2", store.errors[2])
+	})
+
+	t.Run("returns a permanent error if the newsletter does not exist", func(t *testing.T) {
+		is := is.New(t)
+
+		r := &registryMock{}
+		jobs.FanOutNewsletterIssue(r, jobs.FanOutNewsletterIssueOptions{
+			Queue: &batchSenderMock{queue: messaging.NewMemoryQueue(time.Millisecond)},
+			Store: newNewsletterStoreMock(1),
+		})
+
+		err := r.jobs["newsletter_issue_send"](context.Background(), model.Message{"job": "newsletter_issue_send", "newsletterID": "2"})
+		is.True(jobs.IsPermanent(err))
+
+		err = r.jobs["newsletter_issue_send"](context.Background(), model.Message{"job": "newsletter_issue_send", "newsletterID": "abc"})
+		is.True(jobs.IsPermanent(err))
+	})
+}
+
+func TestSendNewsletterIssueEmail(t *testing.T) {
+	message := model.Message{"job": "newsletter_issue_email", "newsletterID": "1", "email": "me000@example.com"}
+
+	t.Run("sends the newsletter and records it in the send log", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.Equal("Issue 1", s.messages[0].Subject)
+		is.Equal("List-Unsubscribe=One-Click", s.messages[0].Headers["List-Unsubscribe-Post"])
+		is.Equal([]model.EmailSend{{
+			Email:             "me000@example.com",
+			Type:              "newsletter_issue_email",
+			NewsletterID:      1,
+			ProviderMessageID: "provider-123",
+			Status:            model.EmailSendStatusSent,
+		}}, store.sends)
+	})
+
+	t.Run("adds the unsubscribe mailto to the List-Unsubscribe header if it's set", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		secret := []byte("secret")
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:           "https://example.com",
+			PhysicalAddress:   "canvas, 1 Example Street",
+			Sender:            s,
+			Store:             store,
+			UnsubscribeMailto: "unsubscribe@example.com",
+			UnsubscribeSecret: secret,
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		token := email.CreateUnsubscribeToken(secret, "me000@example.com")
+		is.True(strings.HasPrefix(s.messages[0].Headers["List-Unsubscribe"], "<mailto:unsubscribe@example.com?subject=unsubscribe%20"+token+">, <https://"))
+		is.Equal("bulk", s.messages[0].Headers["Precedence"])
+	})
+
+	t.Run("adds an open tracking pixel and tracked links for the subscriber", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		store.newsletters[1] = model.Newsletter{ID: 1, Title: "Issue 1", Body: "Hello, [read more](https://example.org)."}
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			Store:           store,
+			TrackingSecret:  []byte("secret"),
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), model.Message{
+			"job": "newsletter_issue_email", "newsletterID": "1", "email": "me000@example.com", "subscriberID": "1"})
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		token := email.CreateOpenToken([]byte("secret"), 1, 1)
+		is.True(strings.Contains(s.messages[0].HTML, `<img src="https://example.com/t/open/`+token+`.gif" width="1" height="1" alt="" style="display:block;border:0"></body>`))
+		clickToken, err := email.CreateClickToken([]byte("secret"), 1, 1, "https://example.org")
+		is.NoErr(err)
+		is.True(strings.Contains(s.messages[0].HTML, `href="https://example.com/t/click/`+clickToken+`"`))
+		is.True(!strings.Contains(s.messages[0].HTML, `href="https://example.org"`))
+	})
+
+	t.Run("doesn't add tracking if the subscriber opted out", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			Store:           newNewsletterStoreMock(1),
+			TrackingSecret:  []byte("secret"),
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.True(!strings.Contains(s.messages[0].HTML, "/t/open/"))
+		is.True(!strings.Contains(s.messages[0].HTML, "/t/click/"))
+	})
+
+	t.Run("skips subscribers that were already sent the newsletter", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		err = r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+	})
+
+	t.Run("skips subscribers suppressed since the fan-out, and records it so the send can complete", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		store.states[1] = model.NewsletterSendStateSending
+		store.enqueued[1] = 1
+		store.fannedOut[1] = true
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          email.NewSuppressionGate(s, &suppressionsMock{suppressed: map[model.Email]bool{"me000@example.com": true}}),
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(0, len(s.messages))
+		is.Equal(1, len(store.sends))
+		is.Equal(model.EmailSendStatusSkipped, store.sends[0].Status)
+		is.Equal(model.NewsletterSendStateCompleted, store.states[1])
+	})
+
+	t.Run("stops sending when the sends use up the quota, returning the message to the queue", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(0)
+		client := &sesAccountClientMock{quota: types.SendQuota{Max24HourSend: 100, MaxSendRate: 1000, SentLast24Hours: 89}}
+		quota := email.NewQuotaManager(email.NewQuotaManagerOptions{Client: client})
+		is.NoErr(quota.Refresh(context.Background()))
+		s := &emailSenderMock{}
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Quota:           quota,
+			Sender:          quota.Limit(s),
+			Store:           store,
+		})
+
+		send := func(to string) error {
+			return r.jobs["newsletter_issue_email"](context.Background(), model.Message{"job": "newsletter_issue_email", "newsletterID": "1", "email": to})
+		}
+		is.NoErr(send("me000@example.com"))
+		is.NoErr(send("me001@example.com"))
+		err := send("me002@example.com")
+		is.True(errors.Is(err, email.ErrQuotaPaused))
+		is.True(messaging.IsRetryable(err))
+		is.Equal(2, len(s.messages))
+		is.Equal(2, len(store.sends))
+
+		client.quota.SentLast24Hours = 0
+		is.NoErr(quota.Refresh(context.Background()))
+		is.NoErr(send("me002@example.com"))
+		is.Equal(3, len(s.messages))
+	})
+
+	t.Run("returns a retryable error if sending fails", func(t *testing.T) {
+		is := is.New(t)
+
+		store := newNewsletterStoreMock(1)
+		r := &registryMock{}
+		jobs.SendNewsletterIssueEmail(r, jobs.SendNewsletterIssueEmailOptions{
+			BaseURL:         "https://example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          &emailSenderMock{err: errors.New("oh no")},
+			Store:           store,
+		})
+
+		err := r.jobs["newsletter_issue_email"](context.Background(), message)
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+		is.Equal(1, len(store.sends))
+		is.Equal(model.EmailSendStatusFailed, store.sends[0].Status)
+	})
+}
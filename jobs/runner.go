@@ -0,0 +1,543 @@
+// Package jobs has a Runner that receives messages from the queue and runs the registered job for each.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"canvas/apperr"
+	"canvas/errorreport"
+	"canvas/logging"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/tracing"
+)
+
+// Func is the signature for jobs. A returned error means the message is retried later,
+// unless the error is wrapped with Permanent, or is apperr.Invalid or apperr.Forbidden, which retrying won't fix either.
+// A messaging.RetryableError returns the message to the queue to be retried after its delay, without reporting the error,
+// and so does apperr.Unavailable, after the NackDelay.
+type Func = func(ctx context.Context, m model.Message) error
+
+type registry interface {
+	Register(name string, fn Func)
+}
+
+// permanentError is an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as a failure that retrying won't fix, so the message is dead-lettered instead of retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent is true if err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe permanentError
+	return errors.As(err, &pe)
+}
+
+// failedPermanently is true for errors that retrying won't fix, which are the permanent ones,
+// and the invalid and forbidden kinds of apperr.
+func failedPermanently(err error) bool {
+	return IsPermanent(err) || errors.Is(err, apperr.Invalid) || errors.Is(err, apperr.Forbidden)
+}
+
+// Register a job with a typed payload, under the payload's job name.
+// Messages that can't be decoded into the payload, or that fail its validation, are permanent failures.
+func Register[P messaging.Payload](r registry, fn func(ctx context.Context, p P) error) {
+	var zero P
+	r.Register(zero.JobName(), func(ctx context.Context, m model.Message) error {
+		p, err := messaging.DecodeMessage[P](m)
+		if err != nil {
+			return Permanent(err)
+		}
+		return fn(ctx, p)
+	})
+}
+
+type receiver interface {
+	Receive(ctx context.Context) (*messaging.Received, error)
+	Delete(ctx context.Context, receiptID string) error
+	Nack(ctx context.Context, receiptID string, delay time.Duration) error
+}
+
+type healthChecker interface {
+	Healthy() bool
+}
+
+// maxHealthBackoff is the longest the runner waits between health checks while paused.
+const maxHealthBackoff = 30 * time.Second
+
+type sender interface {
+	Send(ctx context.Context, m model.Message) error
+}
+
+// deadLetterSender also sends the bodies of messages that couldn't be decoded, as they were received.
+type deadLetterSender interface {
+	sender
+	SendBody(ctx context.Context, body string) error
+}
+
+// Runner receives messages from a queue and runs the job registered under the message's "job" name.
+type Runner struct {
+	deadLetterQueue deadLetterSender
+	errorReporter   *errorreport.Reporter
+	health          healthChecker
+	healthBackoff   time.Duration
+	jobs            map[string]Func
+	limit           int
+	log             *zap.Logger
+	metrics         *jobMetrics
+	nackDelay       time.Duration
+	panicCount      *prometheus.CounterVec
+	paused          prometheus.Gauge
+	queue           receiver
+	running         sync.WaitGroup
+	// runningCount and pausedState are for State, because running and paused can't be read.
+	runningCount    int64
+	pausedState     int32
+	shutdownTimeout time.Duration
+	summaryInterval time.Duration
+	tracerProvider  trace.TracerProvider
+}
+
+// NewRunnerOptions for NewRunner.
+type NewRunnerOptions struct {
+	// DeadLetterQueue receives messages whose job failed permanently, by panicking or returning a Permanent error.
+	// Messages whose payload failed validation, or whose body couldn't be decoded, are quarantined there too,
+	// with the reason in messaging.ValidationErrorAttribute.
+	// If not set, those messages are left for the queue's own redrive policy.
+	DeadLetterQueue deadLetterSender
+	// ErrorReporter reports jobs that panic or fail, except while the database is unhealthy. Without it, they're only logged.
+	ErrorReporter *errorreport.Reporter
+	// Health of the database. While it's unhealthy, the runner stops receiving messages,
+	// and messages of jobs that fail are returned to the queue after NackDelay instead of counting as failures.
+	Health healthChecker
+	// HealthBackoff is the initial wait between health checks while paused, doubled up to 30 seconds. Defaults to 1 second.
+	HealthBackoff time.Duration
+	// Limit on the number of jobs running at the same time. Defaults to 1.
+	Limit   int
+	Log     *zap.Logger
+	Metrics *prometheus.Registry
+	// NackDelay before a message returned to the queue during a database outage, or because its job failed
+	// with apperr.Unavailable, can be received again. Defaults to 30 seconds.
+	NackDelay time.Duration
+	Queue     receiver
+	// ShutdownTimeout is how long jobs still running when the runner stops get to finish, before they're cancelled.
+	// Without it, they're cancelled right away.
+	ShutdownTimeout time.Duration
+	// SummaryInterval between logs at info level of how many messages of each type were processed and with what result,
+	// with the values of the metrics, for environments without Prometheus. Without it, there's no summary.
+	SummaryInterval time.Duration
+	// Tracing records a span for each job, the root of the spans of its queries and messages.
+	// Without it, jobs are traced with the global tracer provider.
+	Tracing *tracing.Provider
+}
+
+// NewRunner with the given options.
+// If no logger is provided, logs are discarded. If no metrics registry is provided, metrics are not exposed.
+func NewRunner(opts NewRunnerOptions) *Runner {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.Limit < 1 {
+		opts.Limit = 1
+	}
+	if opts.HealthBackoff <= 0 {
+		opts.HealthBackoff = time.Second
+	}
+	if opts.NackDelay <= 0 {
+		opts.NackDelay = 30 * time.Second
+	}
+
+	panicCount := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_job_panics_total",
+		Help: "Number of jobs that panicked, by job name.",
+	}, []string{"name"})
+	paused := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "app_job_runner_paused",
+		Help: "Whether the job runner is paused because the database is unhealthy.",
+	})
+	opts.Metrics.MustRegister(panicCount, paused)
+	metrics := newJobMetrics(opts.Metrics)
+
+	tracerProvider := otel.GetTracerProvider()
+	if opts.Tracing != nil {
+		tracerProvider = opts.Tracing
+	}
+
+	return &Runner{
+		deadLetterQueue: opts.DeadLetterQueue,
+		errorReporter:   opts.ErrorReporter,
+		health:          opts.Health,
+		healthBackoff:   opts.HealthBackoff,
+		jobs:            map[string]Func{},
+		limit:           opts.Limit,
+		log:             opts.Log,
+		metrics:         metrics,
+		nackDelay:       opts.NackDelay,
+		panicCount:      panicCount,
+		paused:          paused,
+		queue:           opts.Queue,
+		shutdownTimeout: opts.ShutdownTimeout,
+		summaryInterval: opts.SummaryInterval,
+		tracerProvider:  tracerProvider,
+	}
+}
+
+// RunnerState of a Runner at one point in time, for diagnostics.
+type RunnerState struct {
+	// Limit on the number of jobs running at the same time, and Running, the number that are.
+	Limit   int
+	Running int
+	// Paused is true while receiving is paused because the database is unhealthy.
+	Paused bool
+}
+
+// State of the runner now.
+func (r *Runner) State() RunnerState {
+	return RunnerState{
+		Limit:   r.limit,
+		Running: int(atomic.LoadInt64(&r.runningCount)),
+		Paused:  atomic.LoadInt32(&r.pausedState) == 1,
+	}
+}
+
+// Register a job under the given name. The name must match the "job" field of messages.
+func (r *Runner) Register(name string, fn Func) {
+	r.jobs[name] = fn
+}
+
+// Start receiving messages and running jobs, blocking until ctx is cancelled.
+// Jobs still running when ctx is cancelled get ShutdownTimeout to finish, and are waited for before returning.
+// While the database is unhealthy, receiving is paused with exponential backoff, and resumed when it's healthy again.
+func (r *Runner) Start(ctx context.Context) {
+	r.log.Info("Starting job runner", zap.Int("limit", r.limit))
+
+	// Jobs aren't cancelled with ctx, but when draining them times out.
+	jobCtx, cancelJobs := context.WithCancel(detachedContext{parent: ctx})
+	defer cancelJobs()
+
+	if r.summaryInterval > 0 {
+		go r.logSummaries(ctx)
+	}
+
+	slots := make(chan struct{}, r.limit)
+	backoff := r.healthBackoff
+	paused := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.log.Info("Stopping job runner")
+			r.drain(cancelJobs)
+			return
+		case slots <- struct{}{}:
+		}
+
+		if r.health != nil && !r.health.Healthy() {
+			<-slots
+			if !paused {
+				paused = true
+				r.paused.Set(1)
+				atomic.StoreInt32(&r.pausedState, 1)
+				r.log.Warn("Pausing job runner while the database is unhealthy")
+			}
+			sleep(ctx, backoff)
+			backoff *= 2
+			if backoff > maxHealthBackoff {
+				backoff = maxHealthBackoff
+			}
+			continue
+		}
+		if paused {
+			paused = false
+			r.paused.Set(0)
+			atomic.StoreInt32(&r.pausedState, 0)
+			backoff = r.healthBackoff
+			r.log.Info("Resuming job runner, the database is healthy again")
+		}
+
+		rm, err := r.queue.Receive(ctx)
+		var decodeErr *messaging.DecodeError
+		if errors.As(err, &decodeErr) && rm != nil {
+			r.quarantineUndecodable(jobCtx, rm, decodeErr)
+			<-slots
+			continue
+		}
+		if err != nil || rm == nil {
+			<-slots
+			if err != nil && !errors.Is(err, context.Canceled) {
+				r.log.Info("Error receiving message", zap.Error(err))
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+
+		r.running.Add(1)
+		atomic.AddInt64(&r.runningCount, 1)
+		go func() {
+			defer func() {
+				atomic.AddInt64(&r.runningCount, -1)
+				<-slots
+				r.running.Done()
+			}()
+			r.run(jobCtx, rm)
+		}()
+	}
+}
+
+// drain the running jobs, cancelling them with cancelJobs if they don't finish within the shutdown timeout.
+func (r *Runner) drain(cancelJobs context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+
+	if r.shutdownTimeout > 0 {
+		t := time.NewTimer(r.shutdownTimeout)
+		defer t.Stop()
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			r.log.Warn("Cancelling jobs still running after the shutdown timeout",
+				zap.Duration("timeout", r.shutdownTimeout))
+		}
+	}
+	cancelJobs()
+	<-done
+}
+
+// logSummaries of the messages processed every summary interval, until ctx is cancelled.
+func (r *Runner) logSummaries(ctx context.Context) {
+	t := time.NewTicker(r.summaryInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			r.metrics.logSummary(r.log)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// detachedContext has the values of its parent, but isn't cancelled with it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// run the job for a received message, deleting the message on success.
+// The job runs in a span that continues the trace from the message attributes, if any,
+// with a logger in its context that has the job name, message ID, and correlation, request, and trace IDs,
+// for logging.FromContext. Messages the job sends carry the correlation ID on.
+// A panicking job is logged and its message sent to the dead-letter queue, so it doesn't take down the runner.
+// The result is counted in the metrics by message type, with panics and messages without a job as permanent failures.
+// Domain events without a job consuming them are deleted and not counted, since not every event has a consumer here.
+func (r *Runner) run(ctx context.Context, rm *messaging.Received) {
+	name := rm.Message["job"]
+
+	ctx = messaging.ContextWithAttributes(ctx, rm.Attributes)
+	ctx, span := r.tracerProvider.Tracer("canvas/jobs").Start(ctx, "job "+name, trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(semconv.MessagingMessageIDKey.String(rm.ID)))
+	defer span.End()
+
+	log := r.log.With(zap.String("name", name), zap.String("messageID", rm.ID),
+		zap.String(logging.CorrelationIDField, logging.CorrelationID(ctx)))
+	if requestID := middleware.GetReqID(ctx); requestID != "" {
+		log = log.With(zap.String("requestID", requestID))
+	}
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		log = log.With(zap.String("traceID", sc.TraceID().String()))
+	}
+	ctx = logging.NewContext(ctx, log)
+
+	fn, ok := r.jobs[name]
+	if !ok && messaging.EventType(rm.Message) != "" {
+		log.Debug("No consumer of this event, deleting message")
+		if err := r.queue.Delete(ctx, rm.ReceiptID); err != nil {
+			log.Info("Error deleting message", zap.Error(err))
+		}
+		return
+	}
+	if !ok {
+		r.metrics.received(unknownType, rm.Sent)
+		r.metrics.count(unknownType, resultPermanentFailure)
+		log.Error("No job with this name")
+		r.deadLetter(ctx, log, rm)
+		return
+	}
+
+	r.metrics.received(name, rm.Sent)
+	r.metrics.start(name)
+	before := time.Now()
+	// result of the job, for the metrics. Jobs that don't return, by panicking, failed permanently.
+	result := resultPermanentFailure
+	defer func() {
+		r.metrics.finish(name, result, time.Since(before))
+	}()
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.panicCount.WithLabelValues(name).Inc()
+			stack := debug.Stack()
+			log.Error("Job panicked", zap.Any("panic", rec), zap.ByteString("stack", stack))
+			span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", rec))
+			r.errorReporter.ReportJob(ctx, name, rm.ID, &errorreport.PanicError{Value: rec, Stack: stack})
+			r.deadLetter(ctx, log, rm)
+		}
+	}()
+
+	if err := fn(ctx, rm.Message); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+
+		var validationErr *messaging.ValidationError
+		if errors.As(err, &validationErr) {
+			log.Error("Job payload failed validation, quarantining message", zap.Error(err))
+			r.errorReporter.ReportJob(ctx, name, rm.ID, err)
+			result = resultQuarantine
+			r.deadLetter(messaging.WithAttribute(ctx, messaging.ValidationErrorAttribute, validationErr.Err.Error()), log, rm)
+			return
+		}
+		if r.health != nil && !r.health.Healthy() {
+			result = resultRetryableFailure
+			log.Info("Job failed while the database is unhealthy, returning message to queue", zap.Error(err))
+			if err := r.queue.Nack(ctx, rm.ReceiptID, r.nackDelay); err != nil {
+				log.Info("Error returning message to queue", zap.Error(err))
+			}
+			return
+		}
+		var retryableErr *messaging.RetryableError
+		isRetryable := errors.As(err, &retryableErr) || errors.Is(err, apperr.Unavailable)
+		if isRetryable && !failedPermanently(err) {
+			delay := r.nackDelay
+			if retryableErr != nil && retryableErr.Delay > 0 {
+				delay = retryableErr.Delay
+			}
+			result = resultRetryableFailure
+			log.Info("Job failed with a retryable error, returning message to queue", zap.Duration("delay", delay), zap.Error(err))
+			if err := r.queue.Nack(ctx, rm.ReceiptID, delay); err != nil {
+				log.Info("Error returning message to queue", zap.Error(err))
+			}
+			return
+		}
+		r.errorReporter.ReportJob(ctx, name, rm.ID, err)
+		if failedPermanently(err) {
+			log.Error("Job failed permanently", zap.Error(err))
+			r.deadLetter(ctx, log, rm)
+			return
+		}
+		// The message is received again after the visibility timeout.
+		result = resultRetryableFailure
+		log.Info("Error running job", zap.Error(err))
+		return
+	}
+	result = resultSuccess
+	log.Info("Ran job", zap.Duration("duration", time.Since(before)))
+
+	if err := r.queue.Delete(ctx, rm.ReceiptID); err != nil {
+		log.Info("Error deleting message, job will be repeated", zap.Error(err))
+	}
+}
+
+// quarantineUndecodable message, whose body couldn't be decoded, with the reason as the validation error,
+// like messages whose payload failed validation. Otherwise it would be received again and again.
+func (r *Runner) quarantineUndecodable(ctx context.Context, rm *messaging.Received, err *messaging.DecodeError) {
+	ctx = messaging.ContextWithAttributes(ctx, rm.Attributes)
+	log := r.log.With(zap.String("messageID", rm.ID))
+	log.Error("Message body couldn't be decoded, quarantining message", zap.Error(err))
+	r.metrics.received(unknownType, rm.Sent)
+	r.metrics.count(unknownType, resultQuarantine)
+	r.errorReporter.ReportJob(ctx, "", rm.ID, err)
+	r.deadLetter(messaging.WithAttribute(ctx, messaging.ValidationErrorAttribute, err.Error()), log, rm)
+}
+
+// deadLetter sends the message to the dead-letter queue and deletes it from the queue, if a dead-letter queue is set.
+// It's used for messages without a job, or whose job panicked or failed permanently,
+// and to quarantine messages that failed validation.
+func (r *Runner) deadLetter(ctx context.Context, log *zap.Logger, rm *messaging.Received) {
+	if r.deadLetterQueue == nil {
+		log.Warn("No dead-letter queue, leaving message for redrive")
+		return
+	}
+
+	var err error
+	if rm.Message == nil {
+		err = r.deadLetterQueue.SendBody(ctx, rm.Body)
+	} else {
+		err = r.deadLetterQueue.Send(ctx, rm.Message)
+	}
+	if err != nil {
+		log.Error("Error sending message to dead-letter queue", zap.Error(err))
+		return
+	}
+
+	if err := r.queue.Delete(ctx, rm.ReceiptID); err != nil {
+		log.Info("Error deleting dead-lettered message", zap.Error(err))
+	}
+}
+
+// sleep for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// jobLog is the logger of the message in ctx from the Runner, for the jobs to log with.
+// Jobs run without the Runner, like in tests, log with log.
+func jobLog(ctx context.Context, log *zap.Logger) *zap.Logger {
+	if l, ok := logging.Lookup(ctx); ok {
+		return l
+	}
+	return log
+}
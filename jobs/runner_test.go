@@ -0,0 +1,839 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/apperr"
+	"canvas/errorreport"
+	"canvas/handlers"
+	"canvas/jobs"
+	"canvas/logging"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+type healthMock struct {
+	healthy int32
+}
+
+func (h *healthMock) Healthy() bool {
+	return atomic.LoadInt32(&h.healthy) == 1
+}
+
+func (h *healthMock) Set(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&h.healthy, v)
+}
+
+func TestRunner_Start(t *testing.T) {
+	t.Run("recovers a panicking job, dead-letters its message, and keeps running jobs", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		registry := prometheus.NewRegistry()
+		r := jobs.NewRunner(jobs.NewRunnerOptions{
+			DeadLetterQueue: deadLetterQueue,
+			Metrics:         registry,
+			Queue:           queue,
+		})
+
+		r.Register("panic", func(ctx context.Context, m model.Message) error {
+			panic("oh no")
+		})
+		ran := make(chan model.Message, 1)
+		r.Register("ok", func(ctx context.Context, m model.Message) error {
+			ran <- m
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "panic"}))
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "ok", "foo": "bar"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case m := <-ran:
+			is.Equal("bar", m["foo"])
+		case <-time.After(time.Second):
+			t.Fatal("second job did not run")
+		}
+		cancel()
+		<-done
+
+		is.Equal(0, queue.Len())
+
+		rm, err := deadLetterQueue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal(model.Message{"job": "panic"}, rm.Message)
+
+		err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP app_job_panics_total Number of jobs that panicked, by job name.
+# TYPE app_job_panics_total counter
+app_job_panics_total{name="panic"} 1
+`), "app_job_panics_total")
+		is.NoErr(err)
+	})
+
+	t.Run("dead-letters the message of a permanently failing job", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, Queue: queue})
+
+		ran := make(chan struct{})
+		r.Register("fail", func(ctx context.Context, m model.Message) error {
+			defer close(ran)
+			return jobs.Permanent(errors.New("oh no"))
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "fail"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		<-ran
+		cancel()
+		<-done
+
+		is.Equal(0, queue.Len())
+		is.Equal(1, deadLetterQueue.Len())
+	})
+
+	t.Run("quarantines a message that fails payload validation, with the reason as an attribute", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, Queue: queue})
+
+		ran := false
+		jobs.Register(r, func(ctx context.Context, p model.ConfirmationEmailRequested) error {
+			ran = true
+			return nil
+		})
+
+		// An older version of the app didn't send the token.
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "confirmation_email", "email": "me@example.com"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		var rm *messaging.Received
+		deadline := time.Now().Add(time.Second)
+		for rm == nil && time.Now().Before(deadline) {
+			var err error
+			rm, err = deadLetterQueue.Receive(context.Background())
+			is.NoErr(err)
+		}
+		cancel()
+		<-done
+
+		is.True(rm != nil)
+		is.True(!ran)
+		is.Equal(0, queue.Len())
+		is.Equal(model.Message{"job": "confirmation_email", "email": "me@example.com"}, rm.Message)
+		is.Equal("token is missing", rm.Attributes[messaging.ValidationErrorAttribute])
+	})
+
+	t.Run("quarantines a message whose body can't be decoded, with the reason as an attribute", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, Queue: queue})
+
+		is.NoErr(queue.SendBody(context.Background(), `{"job":"confirmation_email","retries":3}`))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		var rm *messaging.Received
+		var err error
+		deadline := time.Now().Add(time.Second)
+		for rm == nil && time.Now().Before(deadline) {
+			rm, err = deadLetterQueue.Receive(context.Background())
+		}
+		cancel()
+		<-done
+
+		is.True(rm != nil)
+		var decodeErr *messaging.DecodeError
+		is.True(errors.As(err, &decodeErr))
+		is.Equal(0, queue.Len())
+		is.Equal(`{"job":"confirmation_email","retries":3}`, rm.Body)
+		is.True(strings.HasPrefix(rm.Attributes[messaging.ValidationErrorAttribute], "error decoding message body: "))
+	})
+
+	t.Run("pauses receiving while the database is unhealthy and resumes when it's healthy", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		health := &healthMock{}
+		registry := prometheus.NewRegistry()
+		r := jobs.NewRunner(jobs.NewRunnerOptions{
+			Health:        health,
+			HealthBackoff: time.Millisecond,
+			Metrics:       registry,
+			Queue:         queue,
+		})
+
+		ran := make(chan struct{})
+		r.Register("ok", func(ctx context.Context, m model.Message) error {
+			close(ran)
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "ok"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-ran:
+			t.Fatal("job ran while the database was unhealthy")
+		case <-time.After(50 * time.Millisecond):
+		}
+		err := testutil.GatherAndCompare(registry, strings.NewReader(pausedMetric(1)), "app_job_runner_paused")
+		is.NoErr(err)
+		is.True(r.State().Paused)
+
+		health.Set(true)
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job did not run after the database was healthy again")
+		}
+		cancel()
+		<-done
+
+		err = testutil.GatherAndCompare(registry, strings.NewReader(pausedMetric(0)), "app_job_runner_paused")
+		is.NoErr(err)
+		is.True(!r.State().Paused)
+	})
+
+	t.Run("returns the message of a job failing during a database outage to the queue", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		health := &healthMock{}
+		health.Set(true)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{
+			DeadLetterQueue: deadLetterQueue,
+			Health:          health,
+			HealthBackoff:   time.Millisecond,
+			NackDelay:       10 * time.Millisecond,
+			Queue:           queue,
+		})
+
+		var runs int32
+		ran := make(chan struct{})
+		r.Register("db", func(ctx context.Context, m model.Message) error {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				// The outage starts while the job is running.
+				health.Set(false)
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					health.Set(true)
+				}()
+				return jobs.Permanent(errors.New("connection refused"))
+			}
+			close(ran)
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "db"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job was not run again after the outage")
+		}
+		cancel()
+		<-done
+
+		is.Equal(int32(2), atomic.LoadInt32(&runs))
+		is.Equal(0, deadLetterQueue.Len())
+	})
+
+	t.Run("returns the message of a job failing with a retryable error to the queue after its delay", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{
+			DeadLetterQueue: deadLetterQueue,
+			NackDelay:       time.Hour,
+			Queue:           queue,
+		})
+
+		var runs int32
+		ran := make(chan struct{})
+		r.Register("throttled", func(ctx context.Context, m model.Message) error {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				return messaging.Retryable(errors.New("throttled"), 20*time.Millisecond)
+			}
+			close(ran)
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "throttled"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job was not run again after the delay")
+		}
+		cancel()
+		<-done
+
+		is.Equal(int32(2), atomic.LoadInt32(&runs))
+		is.Equal(0, deadLetterQueue.Len())
+	})
+
+	t.Run("returns the message of a job failing because something is unavailable to the queue after the nack delay", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{
+			DeadLetterQueue: deadLetterQueue,
+			NackDelay:       20 * time.Millisecond,
+			Queue:           queue,
+		})
+
+		var runs int32
+		ran := make(chan struct{})
+		r.Register("unavailable", func(ctx context.Context, m model.Message) error {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				return fmt.Errorf("error getting subscriber: %w", apperr.Wrap(apperr.Unavailable, errors.New("connection refused")))
+			}
+			close(ran)
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "unavailable"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job was not run again after the delay")
+		}
+		cancel()
+		<-done
+
+		is.Equal(int32(2), atomic.LoadInt32(&runs))
+		is.Equal(0, deadLetterQueue.Len())
+	})
+
+	t.Run("dead-letters the message of a job failing on something invalid or forbidden", func(t *testing.T) {
+		for _, kind := range []error{apperr.Invalid, apperr.Forbidden} {
+			t.Run(kind.Error(), func(t *testing.T) {
+				is := is.New(t)
+
+				queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+				deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+				r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, Queue: queue})
+
+				ran := make(chan struct{})
+				r.Register("fail", func(ctx context.Context, m model.Message) error {
+					defer close(ran)
+					return fmt.Errorf("error saving: %w", apperr.Wrap(kind, errors.New("oh no")))
+				})
+
+				is.NoErr(queue.Send(context.Background(), model.Message{"job": "fail"}))
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan struct{})
+				go func() {
+					r.Start(ctx)
+					close(done)
+				}()
+
+				<-ran
+				cancel()
+				<-done
+
+				is.Equal(0, queue.Len())
+				is.Equal(1, deadLetterQueue.Len())
+			})
+		}
+	})
+
+	t.Run("leaves the message of a failing job on the queue", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue})
+
+		ran := make(chan struct{})
+		r.Register("fail", func(ctx context.Context, m model.Message) error {
+			close(ran)
+			return context.DeadlineExceeded
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "fail"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		<-ran
+		cancel()
+		<-done
+
+		is.Equal(1, queue.Len())
+	})
+
+	t.Run("deletes domain events nothing consumes, and dead-letters messages without a job", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.DebugLevel)
+		queue := messaging.NewMemoryQueue(time.Minute)
+		deadLetterQueue := messaging.NewMemoryQueue(time.Minute)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, Log: zap.New(core), Queue: queue})
+
+		event, err := messaging.NewEvent(model.SubscriberSignedUp{Email: "me@example.com"}, time.Now())
+		is.NoErr(err)
+		is.NoErr(queue.Send(context.Background(), event))
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "unknown"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for deadLetterQueue.Len() == 0 || queue.Len() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		is.Equal(1, logs.FilterMessage("No job with this name").Len())
+		is.Equal(1, logs.FilterMessage("No consumer of this event, deleting message").Len())
+		rm, err := deadLetterQueue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal(model.Message{"job": "unknown"}, rm.Message)
+	})
+
+	t.Run("lets a running job finish and delete its message within the shutdown timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue, ShutdownTimeout: time.Second})
+
+		started := make(chan struct{})
+		stopping := make(chan struct{})
+		var jobErr error
+		r.Register("slow", func(ctx context.Context, m model.Message) error {
+			close(started)
+			<-stopping
+			time.Sleep(10 * time.Millisecond)
+			jobErr = ctx.Err()
+			return jobErr
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "slow"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		<-started
+		cancel()
+		close(stopping)
+		<-done
+
+		is.NoErr(jobErr)
+		is.Equal(0, queue.Len())
+	})
+
+	t.Run("cancels a job still running after the shutdown timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue, ShutdownTimeout: 10 * time.Millisecond})
+
+		started := make(chan struct{})
+		r.Register("stuck", func(ctx context.Context, m model.Message) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "stuck"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		<-started
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("runner did not stop")
+		}
+
+		is.Equal(1, queue.Len())
+	})
+
+	t.Run("runs the job with the trace context and request ID from the message", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Queue: queue})
+
+		ran := make(chan context.Context, 1)
+		r.Register("trace", func(ctx context.Context, m model.Message) error {
+			ran <- ctx
+			return nil
+		})
+
+		sc := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+			TraceFlags: trace.FlagsSampled,
+		})
+		sendCtx := trace.ContextWithSpanContext(context.Background(), sc)
+		sendCtx = context.WithValue(sendCtx, middleware.RequestIDKey, "abc-123")
+		is.NoErr(queue.Send(sendCtx, model.Message{"job": "trace"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		select {
+		case jobCtx := <-ran:
+			is.Equal(sc.TraceID(), trace.SpanContextFromContext(jobCtx).TraceID())
+			is.Equal("abc-123", middleware.GetReqID(jobCtx))
+		case <-time.After(time.Second):
+			t.Fatal("job did not run")
+		}
+	})
+
+	t.Run("runs the job with a logger for the message in the context", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Log: zap.New(core), Queue: queue})
+
+		ran := make(chan struct{})
+		r.Register("log", func(ctx context.Context, m model.Message) error {
+			logging.FromContext(ctx).Info("Deep inside the job")
+			close(ran)
+			return nil
+		})
+
+		sendCtx := context.WithValue(context.Background(), middleware.RequestIDKey, "abc-123")
+		is.NoErr(queue.Send(sendCtx, model.Message{"job": "log"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job did not run")
+		}
+		entries := logs.FilterMessage("Deep inside the job").All()
+		is.Equal(1, len(entries))
+		fields := entries[0].ContextMap()
+		is.Equal("log", fields["name"])
+		is.True(fields["messageID"] != "")
+		is.Equal("abc-123", fields["requestID"])
+	})
+}
+
+// errorTransportMock records the reported events.
+func TestRunner_State(t *testing.T) {
+	t.Run("has the number of jobs running, and the limit", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Limit: 2, Queue: queue})
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		r.Register("slow", func(ctx context.Context, m model.Message) error {
+			close(started)
+			<-release
+			return nil
+		})
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "slow"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("job did not start")
+		}
+		is.Equal(jobs.RunnerState{Limit: 2, Running: 1}, r.State())
+
+		close(release)
+		cancel()
+		<-done
+		is.Equal(jobs.RunnerState{Limit: 2}, r.State())
+	})
+}
+
+type errorTransportMock struct {
+	lock   sync.Mutex
+	events []errorreport.Event
+}
+
+func (t *errorTransportMock) SendEvent(ctx context.Context, e errorreport.Event) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, e)
+	return nil
+}
+
+func TestRunner_errorReporting(t *testing.T) {
+	t.Run("reports panicking and failing jobs with the job name, message ID, and request ID", func(t *testing.T) {
+		is := is.New(t)
+
+		transport := &errorTransportMock{}
+		rep, err := errorreport.NewReporter(errorreport.NewReporterOptions{
+			DSN:       "https://key@sentry.example.com/1",
+			Transport: transport,
+		})
+		is.NoErr(err)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{ErrorReporter: rep, Queue: queue})
+
+		var ran sync.WaitGroup
+		ran.Add(2)
+		r.Register("panic", func(ctx context.Context, m model.Message) error {
+			defer ran.Done()
+			panic("oh no")
+		})
+		r.Register("fail", func(ctx context.Context, m model.Message) error {
+			defer ran.Done()
+			return errors.New("oh no")
+		})
+
+		sendCtx := context.WithValue(context.Background(), middleware.RequestIDKey, "abc-123")
+		is.NoErr(queue.Send(sendCtx, model.Message{"job": "panic"}))
+		is.NoErr(queue.Send(sendCtx, model.Message{"job": "fail"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		ran.Wait()
+		cancel()
+		<-done
+
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), time.Second)
+		defer cancelFlush()
+		is.NoErr(rep.Flush(flushCtx))
+
+		transport.lock.Lock()
+		defer transport.lock.Unlock()
+		is.Equal(2, len(transport.events))
+		levels := map[string]string{}
+		for _, e := range transport.events {
+			levels[e.Tags["job"]] = e.Level
+			is.Equal("abc-123", e.Tags["requestID"])
+			is.True(e.Tags["messageID"] != "")
+		}
+		is.Equal(map[string]string{"panic": "fatal", "fail": "error"}, levels)
+	})
+}
+
+func pausedMetric(v int) string {
+	return fmt.Sprintf(`
+# HELP app_job_runner_paused Whether the job runner is paused because the database is unhealthy.
+# TYPE app_job_runner_paused gauge
+app_job_runner_paused %v
+`, v)
+}
+
+func TestRunner_correlationID(t *testing.T) {
+	t.Run("logs the same correlation ID in the web app and in the jobs the request leads to", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+
+		// The web app and the worker log separately, like the two processes they run in.
+		webCore, webLogs := observer.New(zapcore.InfoLevel)
+		mux := chi.NewMux()
+		mux.Use(middleware.RequestID, handlers.RequestLog(zap.New(webCore)))
+		mux.Post("/signup", func(w http.ResponseWriter, r *http.Request) {
+			logging.FromContext(r.Context()).Info("Signing up")
+			if err := queue.Send(r.Context(), model.Message{"job": "confirm"}); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		})
+
+		workerCore, workerLogs := observer.New(zapcore.InfoLevel)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Log: zap.New(workerCore), Queue: queue})
+		done := make(chan struct{})
+		r.Register("confirm", func(ctx context.Context, m model.Message) error {
+			logging.FromContext(ctx).Info("Confirming")
+			return queue.Send(ctx, model.Message{"job": "welcome"})
+		})
+		r.Register("welcome", func(ctx context.Context, m model.Message) error {
+			logging.FromContext(ctx).Info("Welcoming")
+			close(done)
+			return nil
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/signup", nil)
+		req.Header.Set(middleware.RequestIDHeader, "edge-123")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		is.Equal(http.StatusOK, res.Code)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("jobs did not run")
+		}
+
+		var ids []interface{}
+		for _, message := range []string{"Signing up", "Confirming", "Welcoming"} {
+			entries := append(webLogs.FilterMessage(message).All(), workerLogs.FilterMessage(message).All()...)
+			is.Equal(1, len(entries))
+			ids = append(ids, entries[0].ContextMap()[logging.CorrelationIDField])
+		}
+		is.Equal([]interface{}{"edge-123", "edge-123", "edge-123"}, ids)
+	})
+
+	t.Run("logs a fresh correlation ID for messages without one", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{Log: zap.New(core), Queue: queue})
+		ran := make(chan string, 2)
+		r.Register("log", func(ctx context.Context, m model.Message) error {
+			logging.FromContext(ctx).Info("Running")
+			ran <- logging.CorrelationID(ctx)
+			return nil
+		})
+
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "log"}))
+		is.NoErr(queue.Send(context.Background(), model.Message{"job": "log"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		var ids []string
+		for i := 0; i < 2; i++ {
+			select {
+			case id := <-ran:
+				ids = append(ids, id)
+			case <-time.After(time.Second):
+				t.Fatal("job did not run")
+			}
+		}
+		is.True(ids[0] != "")
+		is.True(ids[0] != ids[1])
+
+		entries := logs.FilterMessage("Running").All()
+		is.Equal(2, len(entries))
+		fields := entries[0].ContextMap()
+		is.True(fields[logging.CorrelationIDField] == ids[0] || fields[logging.CorrelationIDField] == ids[1])
+	})
+}
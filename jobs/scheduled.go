@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+// defaultScheduleGrace if not set in the options.
+const defaultScheduleGrace = 6 * time.Hour
+
+type scheduledNewsletterSender interface {
+	SendScheduledNewsletters(ctx context.Context, now time.Time, grace time.Duration) (storage.ScheduledNewsletterSends, error)
+}
+
+// SendScheduledNewslettersOptions for SendScheduledNewsletters.
+type SendScheduledNewslettersOptions struct {
+	// Grace is how long after its schedule was due an issue is still sent, such as after downtime. Defaults to 6 hours.
+	Grace time.Duration
+	Log   *zap.Logger
+	// Now is for the time schedules are due at. Defaults to time.Now.
+	Now   func() time.Time
+	Store scheduledNewsletterSender
+}
+
+// SendScheduledNewsletters registers the job that publishes the newsletter issues whose schedule is due,
+// and queues their sends, which the fan-out job then sends like any other. Issues that are due since longer
+// than the grace are unscheduled instead, and logged. It's meant to be scheduled, like every minute.
+func SendScheduledNewsletters(r registry, opts SendScheduledNewslettersOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = defaultScheduleGrace
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	Register(r, func(ctx context.Context, _ model.ScheduledNewsletterSendsRequested) error {
+		result, err := opts.Store.SendScheduledNewsletters(ctx, opts.Now(), opts.Grace)
+		if err != nil {
+			return err
+		}
+		log := jobLog(ctx, opts.Log)
+		for _, id := range result.Sent {
+			log.Info("Sending scheduled newsletter", zap.Int64("newsletterID", id))
+		}
+		for _, id := range result.Missed {
+			log.Info("Unscheduled newsletter, because its schedule was missed by more than the grace",
+				zap.Int64("newsletterID", id), zap.Duration("grace", opts.Grace))
+		}
+		return nil
+	})
+}
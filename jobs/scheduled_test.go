@@ -0,0 +1,144 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/jobs"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// scheduledNewsletterSenderMock with the schedules of newsletters by ID, which are cleared once they're sent
+// or missed, like storage.Database does.
+type scheduledNewsletterSenderMock struct {
+	err       error
+	schedules map[int64]time.Time
+	grace     []time.Duration
+	sent      []int64
+}
+
+func (s *scheduledNewsletterSenderMock) SendScheduledNewsletters(ctx context.Context, now time.Time,
+	grace time.Duration) (storage.ScheduledNewsletterSends, error) {
+	s.grace = append(s.grace, grace)
+	var result storage.ScheduledNewsletterSends
+	if s.err != nil {
+		return result, s.err
+	}
+	for id, at := range s.schedules {
+		switch {
+		case at.After(now):
+			continue
+		case at.Before(now.Add(-grace)):
+			result.Missed = append(result.Missed, id)
+		default:
+			result.Sent = append(result.Sent, id)
+			s.sent = append(s.sent, id)
+		}
+		delete(s.schedules, id)
+	}
+	sort.Slice(s.sent, func(i, j int) bool { return s.sent[i] < s.sent[j] })
+	return result, nil
+}
+
+func TestSendScheduledNewsletters(t *testing.T) {
+	// Monday 9:00 in Berlin, and an issue scheduled an hour later.
+	at := time.Date(2023, 1, 9, 8, 0, 0, 0, time.UTC)
+
+	setup := func(now *time.Time, grace time.Duration) (*scheduledNewsletterSenderMock, *observer.ObservedLogs, func()) {
+		s := &scheduledNewsletterSenderMock{schedules: map[int64]time.Time{1: at, 2: at.Add(time.Hour)}}
+		core, logs := observer.New(zapcore.InfoLevel)
+		r := &registryMock{}
+		jobs.SendScheduledNewsletters(r, jobs.SendScheduledNewslettersOptions{
+			Grace: grace,
+			Log:   zap.New(core),
+			Now:   func() time.Time { return *now },
+			Store: s,
+		})
+		run := func() {
+			err := r.jobs["scheduled_newsletter_sends"](context.Background(), model.Message{"job": "scheduled_newsletter_sends"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s, logs, run
+	}
+
+	t.Run("sends each issue once the clock passes its schedule, and only once", func(t *testing.T) {
+		is := is.New(t)
+
+		now := at.Add(-time.Second)
+		s, logs, run := setup(&now, time.Hour)
+
+		run()
+		is.Equal(0, len(s.sent))
+
+		now = at
+		run()
+		is.Equal([]int64{1}, s.sent)
+
+		now = at.Add(59 * time.Minute)
+		run()
+		is.Equal([]int64{1}, s.sent)
+
+		now = at.Add(time.Hour)
+		run()
+		run()
+		is.Equal([]int64{1, 2}, s.sent)
+
+		is.Equal(2, logs.FilterMessage("Sending scheduled newsletter").Len())
+	})
+
+	t.Run("sends the issues missed during downtime on recovery, within the grace", func(t *testing.T) {
+		is := is.New(t)
+
+		now := at.Add(90 * time.Minute)
+		s, _, run := setup(&now, time.Hour)
+
+		run()
+		is.Equal([]int64{2}, s.sent)
+		is.Equal([]time.Duration{time.Hour}, s.grace)
+	})
+
+	t.Run("logs the issues missed by more than the grace, which aren't sent", func(t *testing.T) {
+		is := is.New(t)
+
+		now := at.Add(90 * time.Minute)
+		s, logs, run := setup(&now, time.Hour)
+
+		run()
+		missed := logs.FilterMessage("Unscheduled newsletter, because its schedule was missed by more than the grace").All()
+		is.Equal(1, len(missed))
+		is.Equal(int64(1), missed[0].ContextMap()["newsletterID"])
+		is.Equal(0, len(s.schedules))
+	})
+
+	t.Run("defaults to a grace of 6 hours", func(t *testing.T) {
+		is := is.New(t)
+
+		now := at
+		s, _, run := setup(&now, 0)
+		run()
+		is.Equal([]time.Duration{6 * time.Hour}, s.grace)
+	})
+
+	t.Run("returns errors sending, so the job is retried", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &scheduledNewsletterSenderMock{err: errors.New("oh no")}
+		r := &registryMock{}
+		jobs.SendScheduledNewsletters(r, jobs.SendScheduledNewslettersOptions{Store: s})
+
+		err := r.jobs["scheduled_newsletter_sends"](context.Background(), model.Message{"job": "scheduled_newsletter_sends"})
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+	})
+}
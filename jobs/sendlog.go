@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+// defaultSendLogRetention if not set in the options.
+const defaultSendLogRetention = 180 * 24 * time.Hour
+
+type emailSendDeleter interface {
+	DeleteOldEmailSends(ctx context.Context, before time.Time) (int64, error)
+}
+
+// DeleteOldEmailSendsOptions for DeleteOldEmailSends.
+type DeleteOldEmailSendsOptions struct {
+	Log *zap.Logger
+	// Now is for the time the retention is counted back from. Defaults to time.Now.
+	Now func() time.Time
+	// Retention of sends in the send log. Defaults to 180 days.
+	Retention time.Duration
+	Store     emailSendDeleter
+}
+
+// DeleteOldEmailSends registers the job that deletes sends older than the retention from the send log,
+// so it doesn't grow forever. It's meant to be scheduled, like every night.
+func DeleteOldEmailSends(r registry, opts DeleteOldEmailSendsOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = defaultSendLogRetention
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	Register(r, func(ctx context.Context, _ model.EmailSendsCleanupRequested) error {
+		n, err := opts.Store.DeleteOldEmailSends(ctx, opts.Now().Add(-opts.Retention))
+		if err != nil {
+			return err
+		}
+		jobLog(ctx, opts.Log).Info("Deleted old email sends", zap.Int64("count", n), zap.Duration("retention", opts.Retention))
+		return nil
+	})
+}
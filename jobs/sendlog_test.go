@@ -0,0 +1,65 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/model"
+)
+
+type emailSendDeleterMock struct {
+	err    error
+	before []time.Time
+}
+
+func (e *emailSendDeleterMock) DeleteOldEmailSends(ctx context.Context, before time.Time) (int64, error) {
+	e.before = append(e.before, before)
+	return 3, e.err
+}
+
+func TestDeleteOldEmailSends(t *testing.T) {
+	now := func() time.Time {
+		return time.Date(2022, 12, 24, 3, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("deletes sends older than the retention", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailSendDeleterMock{}
+		r := &registryMock{}
+		jobs.DeleteOldEmailSends(r, jobs.DeleteOldEmailSendsOptions{Now: now, Retention: 30 * 24 * time.Hour, Store: s})
+
+		err := r.jobs["email_sends_cleanup"](context.Background(), model.Message{"job": "email_sends_cleanup"})
+		is.NoErr(err)
+		is.Equal([]time.Time{time.Date(2022, 11, 24, 3, 0, 0, 0, time.UTC)}, s.before)
+	})
+
+	t.Run("defaults to a retention of 180 days", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailSendDeleterMock{}
+		r := &registryMock{}
+		jobs.DeleteOldEmailSends(r, jobs.DeleteOldEmailSendsOptions{Now: now, Store: s})
+
+		err := r.jobs["email_sends_cleanup"](context.Background(), model.Message{"job": "email_sends_cleanup"})
+		is.NoErr(err)
+		is.Equal([]time.Time{now().Add(-180 * 24 * time.Hour)}, s.before)
+	})
+
+	t.Run("returns errors deleting, so the job is retried", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailSendDeleterMock{err: errors.New("oh no")}
+		r := &registryMock{}
+		jobs.DeleteOldEmailSends(r, jobs.DeleteOldEmailSendsOptions{Store: s})
+
+		err := r.jobs["email_sends_cleanup"](context.Background(), model.Message{"job": "email_sends_cleanup"})
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+	})
+}
@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+type throttleDeleter interface {
+	DeleteExpiredThrottles(ctx context.Context) (int64, error)
+}
+
+// DeleteExpiredThrottlesOptions for DeleteExpiredThrottles.
+type DeleteExpiredThrottlesOptions struct {
+	Log   *zap.Logger
+	Store throttleDeleter
+}
+
+// DeleteExpiredThrottles registers the job that deletes expired throttle windows from the database,
+// so the table doesn't grow forever. It's meant to be scheduled, like every hour.
+func DeleteExpiredThrottles(r registry, opts DeleteExpiredThrottlesOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+
+	Register(r, func(ctx context.Context, _ model.ThrottlesCleanupRequested) error {
+		n, err := opts.Store.DeleteExpiredThrottles(ctx)
+		if err != nil {
+			return err
+		}
+		jobLog(ctx, opts.Log).Info("Deleted expired throttles", zap.Int64("count", n))
+		return nil
+	})
+}
@@ -0,0 +1,48 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/model"
+)
+
+type throttleDeleterMock struct {
+	err   error
+	calls int
+}
+
+func (t *throttleDeleterMock) DeleteExpiredThrottles(ctx context.Context) (int64, error) {
+	t.calls++
+	return 2, t.err
+}
+
+func TestDeleteExpiredThrottles(t *testing.T) {
+	t.Run("deletes expired throttles", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &throttleDeleterMock{}
+		r := &registryMock{}
+		jobs.DeleteExpiredThrottles(r, jobs.DeleteExpiredThrottlesOptions{Store: s})
+
+		err := r.jobs["throttles_cleanup"](context.Background(), model.Message{"job": "throttles_cleanup"})
+		is.NoErr(err)
+		is.Equal(1, s.calls)
+	})
+
+	t.Run("returns errors deleting, so the job is retried", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &throttleDeleterMock{err: errors.New("oh no")}
+		r := &registryMock{}
+		jobs.DeleteExpiredThrottles(r, jobs.DeleteExpiredThrottlesOptions{Store: s})
+
+		err := r.jobs["throttles_cleanup"](context.Background(), model.Message{"job": "throttles_cleanup"})
+		is.True(err != nil)
+		is.True(!jobs.IsPermanent(err))
+	})
+}
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+type emailOpenRecorder interface {
+	RecordEmailOpen(ctx context.Context, newsletterID, subscriberID int64, at time.Time) error
+}
+
+type emailClickRecorder interface {
+	RecordEmailClick(ctx context.Context, newsletterID, subscriberID int64, url string, at time.Time) error
+}
+
+// RecordEmailOpenOptions for RecordEmailOpen.
+type RecordEmailOpenOptions struct {
+	Log   *zap.Logger
+	Store emailOpenRecorder
+}
+
+// RecordEmailOpen registers the job that records an open of a newsletter issue email from its tracking pixel.
+// Opens are recorded here instead of in the pixel handler, so loading the pixel doesn't wait for the database.
+func RecordEmailOpen(r registry, opts RecordEmailOpenOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+
+	Register(r, func(ctx context.Context, p model.EmailOpened) error {
+		newsletterID, err := strconv.ParseInt(p.NewsletterID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid newsletter ID %q: %w", p.NewsletterID, err))
+		}
+		subscriberID, err := strconv.ParseInt(p.SubscriberID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid subscriber ID %q: %w", p.SubscriberID, err))
+		}
+		at, err := time.Parse(time.RFC3339, p.OpenedAt)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid opened at %q: %w", p.OpenedAt, err))
+		}
+		return opts.Store.RecordEmailOpen(ctx, newsletterID, subscriberID, at)
+	})
+}
+
+// RecordEmailClickOptions for RecordEmailClick.
+type RecordEmailClickOptions struct {
+	Log   *zap.Logger
+	Store emailClickRecorder
+}
+
+// RecordEmailClick registers the job that records a click on a tracked link in a newsletter issue email.
+// Like opens, clicks are recorded here, so the redirect doesn't wait for the database.
+func RecordEmailClick(r registry, opts RecordEmailClickOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+
+	Register(r, func(ctx context.Context, p model.EmailClicked) error {
+		newsletterID, err := strconv.ParseInt(p.NewsletterID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid newsletter ID %q: %w", p.NewsletterID, err))
+		}
+		subscriberID, err := strconv.ParseInt(p.SubscriberID, 10, 64)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid subscriber ID %q: %w", p.SubscriberID, err))
+		}
+		at, err := time.Parse(time.RFC3339, p.ClickedAt)
+		if err != nil {
+			return Permanent(fmt.Errorf("invalid clicked at %q: %w", p.ClickedAt, err))
+		}
+		return opts.Store.RecordEmailClick(ctx, newsletterID, subscriberID, p.URL, at)
+	})
+}
@@ -0,0 +1,87 @@
+package jobs_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/model"
+)
+
+type emailOpenRecorderMock struct {
+	opens []string
+}
+
+func (e *emailOpenRecorderMock) RecordEmailOpen(ctx context.Context, newsletterID, subscriberID int64, at time.Time) error {
+	e.opens = append(e.opens, fmt.Sprintf("%v %v %v", newsletterID, subscriberID, at.Format(time.RFC3339)))
+	return nil
+}
+
+type emailClickRecorderMock struct {
+	clicks []string
+}
+
+func (e *emailClickRecorderMock) RecordEmailClick(ctx context.Context, newsletterID, subscriberID int64, url string, at time.Time) error {
+	e.clicks = append(e.clicks, fmt.Sprintf("%v %v %v %v", newsletterID, subscriberID, url, at.Format(time.RFC3339)))
+	return nil
+}
+
+func TestRecordEmailOpen(t *testing.T) {
+	t.Run("records the open", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailOpenRecorderMock{}
+		r := &registryMock{}
+		jobs.RecordEmailOpen(r, jobs.RecordEmailOpenOptions{Store: s})
+
+		err := r.jobs["email_opened"](context.Background(), model.Message{
+			"job": "email_opened", "newsletterID": "1", "subscriberID": "2", "openedAt": "2022-12-24T08:00:00Z"})
+		is.NoErr(err)
+		is.Equal([]string{"1 2 2022-12-24T08:00:00Z"}, s.opens)
+	})
+
+	t.Run("returns a permanent error for an invalid open", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailOpenRecorderMock{}
+		r := &registryMock{}
+		jobs.RecordEmailOpen(r, jobs.RecordEmailOpenOptions{Store: s})
+
+		err := r.jobs["email_opened"](context.Background(), model.Message{
+			"job": "email_opened", "newsletterID": "1", "subscriberID": "abc", "openedAt": "2022-12-24T08:00:00Z"})
+		is.True(jobs.IsPermanent(err))
+		is.Equal(0, len(s.opens))
+	})
+}
+
+func TestRecordEmailClick(t *testing.T) {
+	t.Run("records the click", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailClickRecorderMock{}
+		r := &registryMock{}
+		jobs.RecordEmailClick(r, jobs.RecordEmailClickOptions{Store: s})
+
+		err := r.jobs["email_clicked"](context.Background(), model.Message{"job": "email_clicked", "newsletterID": "1",
+			"subscriberID": "2", "url": "https://example.org", "clickedAt": "2022-12-24T08:00:00Z"})
+		is.NoErr(err)
+		is.Equal([]string{"1 2 https://example.org 2022-12-24T08:00:00Z"}, s.clicks)
+	})
+
+	t.Run("returns a permanent error for an invalid click", func(t *testing.T) {
+		is := is.New(t)
+
+		s := &emailClickRecorderMock{}
+		r := &registryMock{}
+		jobs.RecordEmailClick(r, jobs.RecordEmailClickOptions{Store: s})
+
+		err := r.jobs["email_clicked"](context.Background(), model.Message{"job": "email_clicked", "newsletterID": "1",
+			"subscriberID": "2", "url": "", "clickedAt": "2022-12-24T08:00:00Z"})
+		is.True(jobs.IsPermanent(err))
+		is.Equal(0, len(s.clicks))
+	})
+}
@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/webhook"
+)
+
+// Defaults for DeliverWebhookOptions.
+const (
+	defaultWebhookAttempts     = 8
+	defaultWebhookBackoff      = 30 * time.Second
+	defaultWebhookMaxBackoff   = time.Hour
+	defaultWebhookDisableAfter = 5
+)
+
+type webhookDeliveryStore interface {
+	GetWebhookDelivery(ctx context.Context, id int64) (*model.WebhookDelivery, error)
+	GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error)
+	RecordWebhookDeliveryAttempt(ctx context.Context, a model.WebhookDeliveryAttempt, state model.WebhookDeliveryState,
+		disableAfter int) (bool, error)
+	SkipWebhookDelivery(ctx context.Context, id int64) error
+}
+
+// DeliverWebhookOptions for DeliverWebhook.
+type DeliverWebhookOptions struct {
+	// Attempts at most for a delivery, after which it fails. Defaults to 8.
+	Attempts int
+	// Backoff before the first retry, doubling for every retry after it, up to MaxBackoff.
+	// Defaults to 30 seconds, and an hour, so the default attempts take about two hours.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Client for the requests. Defaults to webhook.NewClient, which doesn't connect to private networks.
+	Client *http.Client
+	// DisableAfter failed deliveries in a row to an endpoint, it's disabled. Defaults to 5.
+	DisableAfter int
+	Log          *zap.Logger
+	// Now is for the timestamp the requests are signed with. Defaults to time.Now.
+	Now   func() time.Time
+	Store webhookDeliveryStore
+}
+
+// DeliverWebhook registers the job that POSTs a domain event to a webhook endpoint that subscribes to it,
+// signed with the secret of the endpoint, as in package webhook. Every attempt is recorded in the delivery log.
+// A 2xx response delivers it. Server errors, 429 Too Many Requests, and errors without a response, like timeouts,
+// are retried with backoff, up to the attempts, and other responses fail the delivery right away.
+// Redirects aren't followed, so they fail it too. After too many failed deliveries in a row, the endpoint is disabled,
+// and its deliveries are skipped until an admin enables it again.
+func DeliverWebhook(r registry, opts DeliverWebhookOptions) {
+	if opts.Attempts <= 0 {
+		opts.Attempts = defaultWebhookAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultWebhookBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if opts.Client == nil {
+		opts.Client = webhook.NewClient(webhook.NewClientOptions{})
+	}
+	if opts.DisableAfter <= 0 {
+		opts.DisableAfter = defaultWebhookDisableAfter
+	}
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	Register(r, func(ctx context.Context, p model.WebhookDeliveryRequested) error {
+		id, _ := strconv.ParseInt(p.DeliveryID, 10, 64)
+		log := jobLog(ctx, opts.Log).With(zap.Int64("deliveryID", id))
+
+		d, err := opts.Store.GetWebhookDelivery(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Deliveries are deleted with their endpoint, and the message can be received again after it's done.
+		if d == nil || d.State != model.WebhookDeliveryStatePending {
+			log.Info("Skipping webhook delivery, it's deleted or done")
+			return nil
+		}
+		e, err := opts.Store.GetWebhookEndpoint(ctx, d.EndpointID)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			log.Info("Skipping webhook delivery, its endpoint is deleted")
+			return nil
+		}
+		log = log.With(zap.Int64("endpointID", e.ID))
+		if !e.Enabled {
+			log.Info("Skipping webhook delivery, its endpoint is disabled")
+			return opts.Store.SkipWebhookDelivery(ctx, d.ID)
+		}
+
+		a, retryable := deliverWebhook(ctx, opts.Client, *e, *d, opts.Now())
+		state := model.WebhookDeliveryStateFailed
+		switch {
+		case a.StatusCode >= 200 && a.StatusCode < 300:
+			state = model.WebhookDeliveryStateDelivered
+		case retryable && d.Attempts+1 < opts.Attempts:
+			state = model.WebhookDeliveryStatePending
+		}
+
+		disabled, err := opts.Store.RecordWebhookDeliveryAttempt(ctx, a, state, opts.DisableAfter)
+		if err != nil {
+			return err
+		}
+		fields := []zap.Field{zap.Int("statusCode", a.StatusCode), zap.String("error", a.Error), zap.Int("attempt", d.Attempts+1)}
+		if disabled {
+			log.Warn("Disabled webhook endpoint, because too many deliveries in a row failed", zap.Int("failures", opts.DisableAfter))
+		}
+
+		switch state {
+		case model.WebhookDeliveryStateDelivered:
+			log.Info("Delivered webhook", fields...)
+		case model.WebhookDeliveryStatePending:
+			delay := webhookBackoff(opts.Backoff, opts.MaxBackoff, d.Attempts+1)
+			return messaging.Retryable(fmt.Errorf("error delivering webhook, status code %v: %v", a.StatusCode, a.Error), delay)
+		default:
+			log.Info("Webhook delivery failed", fields...)
+		}
+		return nil
+	})
+}
+
+// deliverWebhook to the endpoint at now, returning the attempt, and whether it's worth retrying.
+func deliverWebhook(ctx context.Context, client *http.Client, e model.WebhookEndpoint, d model.WebhookDelivery,
+	now time.Time) (model.WebhookDeliveryAttempt, bool) {
+	a := model.WebhookDeliveryAttempt{DeliveryID: d.ID}
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+		return a, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "canvas-webhooks")
+	req.Header.Set(webhook.EventHeader, d.EventType)
+	req.Header.Set(webhook.DeliveryHeader, strconv.FormatInt(d.ID, 10))
+	webhook.SetHeaders(req.Header, e.Secret, now, body)
+
+	before := time.Now()
+	res, err := client.Do(req)
+	a.Duration = time.Since(before)
+	if err != nil {
+		a.Error = err.Error()
+		return a, !errors.Is(err, webhook.ErrBlockedAddress)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	// Reading some of the body lets the connection be reused, without reading big responses.
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	a.StatusCode = res.StatusCode
+	return a, res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+}
+
+// webhookBackoff after the attempt, starting at 1, doubling from the backoff up to the max.
+func webhookBackoff(backoff, max time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
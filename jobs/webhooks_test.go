@@ -0,0 +1,281 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/webhook"
+)
+
+// webhookDeliveryStoreMock with one endpoint and its deliveries, counting failures and disabling the endpoint
+// like storage.Database does.
+type webhookDeliveryStoreMock struct {
+	mutex      sync.Mutex
+	endpoint   *model.WebhookEndpoint
+	deliveries map[int64]*model.WebhookDelivery
+}
+
+func (s *webhookDeliveryStoreMock) GetWebhookDelivery(ctx context.Context, id int64) (*model.WebhookDelivery, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *d
+	return &c, nil
+}
+
+func (s *webhookDeliveryStoreMock) GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.endpoint == nil || s.endpoint.ID != id {
+		return nil, nil
+	}
+	e := *s.endpoint
+	return &e, nil
+}
+
+func (s *webhookDeliveryStoreMock) RecordWebhookDeliveryAttempt(ctx context.Context, a model.WebhookDeliveryAttempt,
+	state model.WebhookDeliveryState, disableAfter int) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	d := s.deliveries[a.DeliveryID]
+	d.State = state
+	d.Attempts++
+	d.AttemptLog = append(d.AttemptLog, a)
+	switch state {
+	case model.WebhookDeliveryStateDelivered:
+		s.endpoint.ConsecutiveFailures = 0
+	case model.WebhookDeliveryStateFailed:
+		s.endpoint.ConsecutiveFailures++
+		if s.endpoint.Enabled && s.endpoint.ConsecutiveFailures >= disableAfter {
+			s.endpoint.Enabled = false
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *webhookDeliveryStoreMock) SkipWebhookDelivery(ctx context.Context, id int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.deliveries[id].State = model.WebhookDeliveryStateSkipped
+	return nil
+}
+
+const webhookPayload = `{"job":"subscriber.confirmed","eventType":"subscriber.confirmed","email":"me@example.com"}`
+
+// webhookReceiver that verifies the signature of requests with the secret, and responds with the next status code,
+// or the last one once they're used up.
+type webhookReceiver struct {
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+	invalid  int
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.mutex.Lock()
+	defer wr.mutex.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if err := webhook.Verify("whsec_secret", r.Header, body, time.Now(), webhook.DefaultTolerance); err != nil {
+		wr.invalid++
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	wr.requests = append(wr.requests, r)
+	wr.bodies = append(wr.bodies, string(body))
+	status := wr.statuses[0]
+	if len(wr.statuses) > 1 {
+		wr.statuses = wr.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	setup := func(t *testing.T, statuses ...int) (*webhookDeliveryStoreMock, *webhookReceiver, *observer.ObservedLogs,
+		func(id string) error) {
+		receiver := &webhookReceiver{statuses: statuses}
+		server := httptest.NewServer(receiver)
+		t.Cleanup(server.Close)
+
+		s := &webhookDeliveryStoreMock{
+			endpoint: &model.WebhookEndpoint{ID: 1, URL: server.URL + "/webhooks", Secret: "whsec_secret",
+				EventTypes: []string{model.EventSubscriberConfirmed}, Enabled: true},
+			deliveries: map[int64]*model.WebhookDelivery{},
+		}
+		for i := int64(1); i <= 10; i++ {
+			s.deliveries[i] = &model.WebhookDelivery{ID: i, EndpointID: 1, EventID: "abc", EventType: model.EventSubscriberConfirmed,
+				Payload: webhookPayload, State: model.WebhookDeliveryStatePending}
+		}
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		r := &registryMock{}
+		jobs.DeliverWebhook(r, jobs.DeliverWebhookOptions{
+			Attempts:     3,
+			Backoff:      time.Minute,
+			MaxBackoff:   90 * time.Second,
+			Client:       webhook.NewClient(webhook.NewClientOptions{AllowPrivateNetworks: true, Timeout: 100 * time.Millisecond}),
+			DisableAfter: 2,
+			Log:          zap.New(core),
+			Store:        s,
+		})
+		run := func(id string) error {
+			return r.jobs["webhook_delivery"](context.Background(), model.Message{"job": "webhook_delivery", "deliveryID": id})
+		}
+		return s, receiver, logs, run
+	}
+
+	// retryDelay of the error, which must be retryable.
+	retryDelay := func(is *is.I, err error) time.Duration {
+		var retryableErr *messaging.RetryableError
+		is.True(errors.As(err, &retryableErr))
+		return retryableErr.Delay
+	}
+
+	t.Run("posts the event with a valid signature and the event headers, and records the delivery", func(t *testing.T) {
+		is := is.New(t)
+
+		s, receiver, logs, run := setup(t, http.StatusNoContent)
+		is.NoErr(run("1"))
+
+		is.Equal(0, receiver.invalid)
+		is.Equal(1, len(receiver.requests))
+		req := receiver.requests[0]
+		is.Equal(http.MethodPost, req.Method)
+		is.Equal("/webhooks", req.URL.Path)
+		is.Equal("application/json", req.Header.Get("Content-Type"))
+		is.Equal(model.EventSubscriberConfirmed, req.Header.Get(webhook.EventHeader))
+		is.Equal("1", req.Header.Get(webhook.DeliveryHeader))
+		is.Equal(webhookPayload, receiver.bodies[0])
+
+		d := s.deliveries[1]
+		is.Equal(model.WebhookDeliveryStateDelivered, d.State)
+		is.Equal(1, len(d.AttemptLog))
+		is.Equal(http.StatusNoContent, d.AttemptLog[0].StatusCode)
+		is.Equal(1, logs.FilterMessage("Delivered webhook").Len())
+	})
+
+	t.Run("doesn't deliver again when the message is received again", func(t *testing.T) {
+		is := is.New(t)
+
+		_, receiver, _, run := setup(t, http.StatusOK)
+		is.NoErr(run("1"))
+		is.NoErr(run("1"))
+		is.Equal(1, len(receiver.requests))
+	})
+
+	t.Run("retries server errors with backoff up to the attempts, then fails the delivery", func(t *testing.T) {
+		is := is.New(t)
+
+		s, receiver, logs, run := setup(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+		is.Equal(time.Minute, retryDelay(is, run("1")))
+		is.Equal(model.WebhookDeliveryStatePending, s.deliveries[1].State)
+		is.Equal(90*time.Second, retryDelay(is, run("1")))
+		is.NoErr(run("1"))
+
+		is.Equal(3, len(receiver.requests))
+		d := s.deliveries[1]
+		is.Equal(model.WebhookDeliveryStateFailed, d.State)
+		is.Equal(3, d.Attempts)
+		is.Equal(http.StatusServiceUnavailable, d.AttemptLog[2].StatusCode)
+		is.Equal(1, s.endpoint.ConsecutiveFailures)
+		is.Equal(1, logs.FilterMessage("Webhook delivery failed").Len())
+	})
+
+	t.Run("delivers after a retry, and resets the failures of the endpoint", func(t *testing.T) {
+		is := is.New(t)
+
+		s, _, _, run := setup(t, http.StatusTooManyRequests, http.StatusOK)
+		s.endpoint.ConsecutiveFailures = 1
+
+		is.Equal(time.Minute, retryDelay(is, run("1")))
+		is.NoErr(run("1"))
+		is.Equal(model.WebhookDeliveryStateDelivered, s.deliveries[1].State)
+		is.Equal(0, s.endpoint.ConsecutiveFailures)
+	})
+
+	t.Run("retries timeouts", func(t *testing.T) {
+		is := is.New(t)
+
+		s, _, _, run := setup(t, http.StatusOK)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Second)
+		}))
+		defer server.Close()
+		s.endpoint.URL = server.URL
+
+		is.Equal(time.Minute, retryDelay(is, run("1")))
+		d := s.deliveries[1]
+		is.Equal(model.WebhookDeliveryStatePending, d.State)
+		is.Equal(0, d.AttemptLog[0].StatusCode)
+		is.True(d.AttemptLog[0].Error != "")
+	})
+
+	t.Run("fails client errors and redirects right away", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, status := range []int{http.StatusBadRequest, http.StatusGone, http.StatusFound} {
+			s, receiver, _, run := setup(t, status)
+			is.NoErr(run("1"))
+			is.Equal(1, len(receiver.requests))
+			is.Equal(model.WebhookDeliveryStateFailed, s.deliveries[1].State)
+		}
+	})
+
+	t.Run("disables the endpoint after failed deliveries in a row, and skips deliveries to it then", func(t *testing.T) {
+		is := is.New(t)
+
+		s, receiver, logs, run := setup(t, http.StatusBadRequest)
+		is.NoErr(run("1"))
+		is.True(s.endpoint.Enabled)
+		is.NoErr(run("2"))
+		is.True(!s.endpoint.Enabled)
+		is.Equal(1, logs.FilterMessage("Disabled webhook endpoint, because too many deliveries in a row failed").Len())
+
+		is.NoErr(run("3"))
+		is.Equal(2, len(receiver.requests))
+		is.Equal(model.WebhookDeliveryStateSkipped, s.deliveries[3].State)
+	})
+
+	t.Run("doesn't connect to private networks with the default client, and doesn't retry that", func(t *testing.T) {
+		is := is.New(t)
+
+		s, receiver, _, _ := setup(t, http.StatusOK)
+		r := &registryMock{}
+		jobs.DeliverWebhook(r, jobs.DeliverWebhookOptions{Store: s})
+
+		err := r.jobs["webhook_delivery"](context.Background(), model.Message{"job": "webhook_delivery", "deliveryID": "1"})
+		is.NoErr(err)
+		is.Equal(0, len(receiver.requests))
+		d := s.deliveries[1]
+		is.Equal(model.WebhookDeliveryStateFailed, d.State)
+		is.True(strings.Contains(d.AttemptLog[0].Error, webhook.ErrBlockedAddress.Error()))
+	})
+
+	t.Run("skips deliveries that were deleted with their endpoint", func(t *testing.T) {
+		is := is.New(t)
+
+		_, receiver, _, run := setup(t, http.StatusOK)
+		is.NoErr(run("11"))
+		is.Equal(0, len(receiver.requests))
+	})
+}
@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/email"
+	"canvas/i18n"
+	"canvas/model"
+	"canvas/storage"
+)
+
+type welcomeEmailStore interface {
+	sendLogger
+	IsSubscribed(ctx context.Context, email model.Email) (bool, error)
+	ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error)
+}
+
+type throttler interface {
+	Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+// SendWelcomeEmailOptions for SendWelcomeEmail.
+type SendWelcomeEmailOptions struct {
+	// BaseURL of the app, used for the links to the latest issue and to unsubscribe.
+	BaseURL string
+	// Catalog of translations for the email, which is in the locale of the message. Defaults to i18n.Default.
+	Catalog *i18n.Catalog
+	From    string
+	Log     *zap.Logger
+	// MaxPerEmail is how many emails an address can get per day, counted with the key the signup form uses
+	// for confirmation emails, so they share the cap if Throttle is the same store. Defaults to 3.
+	MaxPerEmail int
+	// PhysicalAddress in the footer of the email.
+	PhysicalAddress string
+	// Retry of failed sends within the job. See RetryPolicy for the defaults.
+	Retry  RetryPolicy
+	Sender emailSender
+	Store  welcomeEmailStore
+	// Throttle for the daily cap. Without it, there's no cap.
+	Throttle throttler
+	// UnsubscribeMailto is the address of the mailbox for unsubscribing by email, added to the List-Unsubscribe header
+	// if it's set. See email.WithUnsubscribeMailto.
+	UnsubscribeMailto string
+	// UnsubscribeSecret signs the unsubscribe links in the email.
+	UnsubscribeSecret []byte
+}
+
+// SendWelcomeEmail registers the job that sends the welcome email after a subscriber confirms for the first time,
+// consuming the model.SubscriberConfirmed event. Events for confirming again are skipped.
+// The welcome_email job is still registered for messages enqueued before the event.
+// It links to the latest published issue, if there is one. Subscribers that unsubscribed or were suppressed
+// since confirming are skipped, and so are addresses over the daily cap, since the welcome email is only a courtesy.
+// Every send attempt is recorded in the send log, as skipped for addresses the sender says are suppressed.
+func SendWelcomeEmail(r registry, opts SendWelcomeEmailOptions) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Catalog == nil {
+		opts.Catalog = i18n.Default()
+	}
+	if opts.MaxPerEmail <= 0 {
+		opts.MaxPerEmail = 3
+	}
+	opts.Retry = opts.Retry.withDefaults()
+
+	send := func(ctx context.Context, address model.Email, locale string) error {
+		subscribed, err := opts.Store.IsSubscribed(ctx, address)
+		if err != nil {
+			return err
+		}
+		if !subscribed {
+			jobLog(ctx, opts.Log).Info("Skipping welcome email, address isn't subscribed anymore")
+			return nil
+		}
+
+		if opts.Throttle != nil {
+			throttled, err := opts.Throttle.Throttle(ctx, "signup-email:"+address.String(), opts.MaxPerEmail, 24*time.Hour)
+			if err != nil {
+				return err
+			}
+			if throttled {
+				jobLog(ctx, opts.Log).Info("Skipping welcome email, too many emails for address")
+				return nil
+			}
+		}
+
+		newsletters, err := opts.Store.ListPublishedNewsletters(ctx, storage.ListPublishedNewslettersOptions{Limit: 1})
+		if err != nil {
+			return err
+		}
+		var latest *model.Newsletter
+		if len(newsletters) > 0 {
+			latest = &newsletters[0]
+		}
+
+		m, err := email.WelcomeEmail(opts.Catalog.Translator(locale), opts.From, opts.PhysicalAddress, address, latest,
+			opts.BaseURL, opts.UnsubscribeSecret)
+		if err != nil {
+			return Permanent(fmt.Errorf("error rendering welcome email: %w", err))
+		}
+		if opts.UnsubscribeMailto != "" {
+			m = email.WithUnsubscribeMailto(m, opts.UnsubscribeMailto, email.CreateUnsubscribeToken(opts.UnsubscribeSecret, address))
+		}
+
+		es := model.EmailSend{Email: address, Type: model.WelcomeEmailRequested{}.JobName()}
+		if err := sendEmail(ctx, jobLog(ctx, opts.Log), opts.Sender, opts.Store, opts.Retry, es, m); err != nil {
+			return fmt.Errorf("error sending welcome email: %w", err)
+		}
+		return nil
+	}
+
+	Register(r, func(ctx context.Context, e model.SubscriberConfirmed) error {
+		if e.FirstTime != "true" {
+			return nil
+		}
+		return send(ctx, e.Email, e.Locale)
+	})
+
+	Register(r, func(ctx context.Context, p model.WelcomeEmailRequested) error {
+		return send(ctx, p.Email, p.Locale)
+	})
+}
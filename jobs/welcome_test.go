@@ -0,0 +1,190 @@
+package jobs_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/jobs"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/server/servertest"
+	"canvas/storage"
+	"canvas/storage/faultstore"
+)
+
+type welcomeEmailStoreMock struct {
+	sendLoggerMock
+	newsletters []model.Newsletter
+	subscribed  bool
+}
+
+func (s *welcomeEmailStoreMock) IsSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	return s.subscribed, nil
+}
+
+func (s *welcomeEmailStoreMock) ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	return s.newsletters, nil
+}
+
+// throttlerMock throttles after limit actions per key, like storage.Database.Throttle in a single window.
+type throttlerMock struct {
+	counts map[string]int
+}
+
+func (t *throttlerMock) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	if t.counts == nil {
+		t.counts = map[string]int{}
+	}
+	t.counts[key]++
+	return t.counts[key] > limit, nil
+}
+
+func TestSendWelcomeEmail(t *testing.T) {
+	message := model.Message{"job": "subscriber.confirmed", "email": "me@example.com", "locale": "de", "firstTime": "true"}
+
+	setup := func(store *welcomeEmailStoreMock, throttle *throttlerMock) (*registryMock, *emailSenderMock) {
+		r := &registryMock{}
+		s := &emailSenderMock{}
+		jobs.SendWelcomeEmail(r, jobs.SendWelcomeEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          s,
+			Store:           store,
+			Throttle:        throttle,
+		})
+		return r, s
+	}
+
+	t.Run("sends the welcome email with a link to the latest issue, and records it in the send log", func(t *testing.T) {
+		is := is.New(t)
+
+		store := &welcomeEmailStoreMock{subscribed: true, newsletters: []model.Newsletter{{ID: 2, Slug: "issue-2", Title: "Issue 2"}}}
+		r, s := setup(store, &throttlerMock{})
+
+		err := r.jobs["subscriber.confirmed"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.Equal("Willkommen beim canvas-Newsletter", s.messages[0].Subject)
+		is.True(strings.Contains(s.messages[0].HTML, `href="https://example.com/archive/issue-2"`))
+		is.True(s.messages[0].Headers["List-Unsubscribe"] != "")
+		is.Equal([]model.EmailSend{{
+			Email:             "me@example.com",
+			Type:              "welcome_email",
+			ProviderMessageID: "provider-123",
+			Status:            model.EmailSendStatusSent,
+		}}, store.sends)
+	})
+
+	t.Run("sends without a link if there's no published issue yet", func(t *testing.T) {
+		is := is.New(t)
+
+		r, s := setup(&welcomeEmailStoreMock{subscribed: true}, &throttlerMock{})
+
+		err := r.jobs["subscriber.confirmed"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.True(!strings.Contains(s.messages[0].HTML, "/archive/"))
+	})
+
+	t.Run("skips addresses that unsubscribed or were suppressed since confirming", func(t *testing.T) {
+		is := is.New(t)
+
+		store := &welcomeEmailStoreMock{subscribed: false}
+		r, s := setup(store, &throttlerMock{})
+
+		err := r.jobs["subscriber.confirmed"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(0, len(s.messages))
+		is.Equal(0, len(store.sends))
+	})
+
+	t.Run("skips confirming again", func(t *testing.T) {
+		is := is.New(t)
+
+		store := &welcomeEmailStoreMock{subscribed: true}
+		r, s := setup(store, &throttlerMock{})
+
+		err := r.jobs["subscriber.confirmed"](context.Background(),
+			model.Message{"job": "subscriber.confirmed", "email": "me@example.com", "locale": "de", "firstTime": "false"})
+		is.NoErr(err)
+		is.Equal(0, len(s.messages))
+		is.Equal(0, len(store.sends))
+	})
+
+	t.Run("still sends for welcome email jobs enqueued before the event", func(t *testing.T) {
+		is := is.New(t)
+
+		r, s := setup(&welcomeEmailStoreMock{subscribed: true}, &throttlerMock{})
+
+		err := r.jobs["welcome_email"](context.Background(), model.Message{"job": "welcome_email", "email": "me@example.com", "locale": "de"})
+		is.NoErr(err)
+		is.Equal(1, len(s.messages))
+		is.Equal("Willkommen beim canvas-Newsletter", s.messages[0].Subject)
+	})
+
+	t.Run("skips addresses over the daily cap, which it shares with confirmation emails", func(t *testing.T) {
+		is := is.New(t)
+
+		throttle := &throttlerMock{counts: map[string]int{"signup-email:me@example.com": 3}}
+		r, s := setup(&welcomeEmailStoreMock{subscribed: true}, throttle)
+
+		err := r.jobs["subscriber.confirmed"](context.Background(), message)
+		is.NoErr(err)
+		is.Equal(0, len(s.messages))
+	})
+
+	t.Run("returns the message to the queue after the nack delay when the database times out", func(t *testing.T) {
+		is := is.New(t)
+
+		store := servertest.NewStore(nil)
+		token, err := store.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = store.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		db := faultstore.New(t, store)
+		db.Set("IsSubscribed", faultstore.Fault{Err: faultstore.ErrTimeout})
+
+		queue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		deadLetterQueue := messaging.NewMemoryQueue(10 * time.Millisecond)
+		r := jobs.NewRunner(jobs.NewRunnerOptions{DeadLetterQueue: deadLetterQueue, NackDelay: 100 * time.Millisecond, Queue: queue})
+		sender := &servertest.Sender{}
+		jobs.SendWelcomeEmail(r, jobs.SendWelcomeEmailOptions{
+			BaseURL:         "https://example.com",
+			From:            "canvas@example.com",
+			PhysicalAddress: "canvas, 1 Example Street",
+			Sender:          sender,
+			Store:           db,
+		})
+		is.NoErr(queue.Send(context.Background(), message))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.Start(ctx)
+
+		waitFor := func(condition func() bool) {
+			t.Helper()
+			deadline := time.Now().Add(time.Second)
+			for !condition() {
+				if time.Now().After(deadline) {
+					t.Fatal("timed out waiting")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+
+		waitFor(func() bool { return db.Calls("IsSubscribed") == 1 })
+		failed := time.Now()
+		db.Clear("IsSubscribed")
+
+		waitFor(func() bool { return len(sender.Messages()) == 1 })
+		is.True(time.Since(failed) >= 90*time.Millisecond)
+		is.Equal(2, db.Calls("IsSubscribed"))
+		is.Equal(0, deadLetterQueue.Len())
+		is.Equal(model.Email("me@example.com"), sender.Messages()[0].To)
+	})
+}
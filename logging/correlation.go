@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// CorrelationIDField is the log field with the correlation ID, which is the same for a request and all the jobs
+// it leads to, across the web app and the worker. It's the one field to search the logs of both processes by.
+//
+// The ID is the request ID, adopted from the X-Request-Id header or generated by middleware.RequestID,
+// and handlers.RequestLog puts it in the context. Messages sent with the context carry it in their attributes,
+// and the job runner puts it in the context of each job, so the jobs a job sends carry it on.
+// Messages without one, like from the scheduler, get a fresh one.
+const CorrelationIDField = "correlationID"
+
+// correlationIDContextKey of the correlation ID in a context.
+type correlationIDContextKey struct{}
+
+// WithCorrelationID returns a copy of ctx with the correlation ID. An empty ID leaves ctx as it is.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationIDContextKey{}, id)
+}
+
+// CorrelationID in ctx from WithCorrelationID, or the empty string if there isn't one.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDContextKey{}).(string)
+	return id
+}
+
+// NewCorrelationID that's random, for work that doesn't have one yet.
+func NewCorrelationID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
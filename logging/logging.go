@@ -0,0 +1,67 @@
+// Package logging keeps the logger of a request or job in its context, with the fields that tell it apart,
+// like the request or message ID, so code deep inside a call, like a store or an email sender, logs with them
+// without them being passed along.
+//
+// The web app stores the logger of each request with handlers.RequestLog, and the job runner the logger of
+// each message. Code that runs outside of both, or in tests, gets the global logger from SetGlobal instead.
+package logging
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	globalLock sync.RWMutex
+	global     = zap.NewNop()
+)
+
+// SetGlobal logger, which FromContext returns for contexts without a logger. A nil logger discards logs,
+// which is also what the global logger does before it's set.
+func SetGlobal(log *zap.Logger) {
+	if log == nil {
+		log = zap.NewNop()
+	}
+	globalLock.Lock()
+	defer globalLock.Unlock()
+	global = log
+}
+
+// Global logger from SetGlobal.
+func Global() *zap.Logger {
+	globalLock.RLock()
+	defer globalLock.RUnlock()
+	return global
+}
+
+// contextKey of the logger in a context.
+type contextKey struct{}
+
+// NewContext with log as the logger of everything done with it. A nil logger leaves ctx as it is.
+func NewContext(ctx context.Context, log *zap.Logger) context.Context {
+	if log == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, log)
+}
+
+// Lookup the logger in ctx from NewContext, reporting whether there is one.
+func Lookup(ctx context.Context) (*zap.Logger, bool) {
+	log, ok := ctx.Value(contextKey{}).(*zap.Logger)
+	return log, ok
+}
+
+// FromContext is the logger in ctx from NewContext, or the global logger if there isn't one. It's never nil.
+func FromContext(ctx context.Context) *zap.Logger {
+	if log, ok := Lookup(ctx); ok {
+		return log
+	}
+	return Global()
+}
+
+// With fields added to the logger in ctx, or to the global logger if there isn't one.
+func With(ctx context.Context, fields ...zap.Field) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(fields...))
+}
@@ -0,0 +1,65 @@
+package logging_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"canvas/logging"
+)
+
+func TestFromContext(t *testing.T) {
+	t.Run("returns the logger in the context, with the fields added to it", func(t *testing.T) {
+		is := is.New(t)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		ctx := logging.NewContext(context.Background(), zap.New(core).With(zap.String("requestID", "abc")))
+		ctx = logging.With(ctx, zap.String("route", "/"))
+
+		logging.FromContext(ctx).Info("Hi")
+		entries := logs.FilterMessage("Hi").All()
+		is.Equal(1, len(entries))
+		is.Equal(map[string]interface{}{"requestID": "abc", "route": "/"}, entries[0].ContextMap())
+	})
+
+	t.Run("falls back to the global logger, which is never nil", func(t *testing.T) {
+		is := is.New(t)
+		defer logging.SetGlobal(nil)
+
+		_, ok := logging.Lookup(context.Background())
+		is.True(!ok)
+		is.True(logging.FromContext(context.Background()) != nil)
+		is.True(logging.FromContext(logging.NewContext(context.Background(), nil)) != nil)
+
+		core, logs := observer.New(zapcore.InfoLevel)
+		logging.SetGlobal(zap.New(core))
+		logging.FromContext(context.Background()).Info("Hi")
+		is.Equal(1, logs.FilterMessage("Hi").Len())
+
+		logging.SetGlobal(nil)
+		is.True(logging.FromContext(context.Background()) != nil)
+	})
+}
+
+func TestCorrelationID(t *testing.T) {
+	t.Run("returns the correlation ID in the context", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal("", logging.CorrelationID(context.Background()))
+		ctx := logging.WithCorrelationID(context.Background(), "abc")
+		is.Equal("abc", logging.CorrelationID(ctx))
+		is.Equal("abc", logging.CorrelationID(logging.WithCorrelationID(ctx, "")))
+	})
+
+	t.Run("creates random correlation IDs", func(t *testing.T) {
+		is := is.New(t)
+
+		id := logging.NewCorrelationID()
+		is.Equal(32, len(id))
+		is.True(id != logging.NewCorrelationID())
+	})
+}
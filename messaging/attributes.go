@@ -0,0 +1,76 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel/propagation"
+
+	"canvas/logging"
+)
+
+// CorrelationIDAttribute is the message attribute carrying the correlation ID from logging.CorrelationID.
+const CorrelationIDAttribute = "canvas-correlation-id"
+
+// RequestIDAttribute is the message attribute carrying the ID of the request that sent the message.
+const RequestIDAttribute = "canvas-request-id"
+
+// ValidationErrorAttribute is the message attribute carrying why a quarantined message failed validation.
+const ValidationErrorAttribute = "canvas-validation-error"
+
+type attributesContextKey struct{}
+
+// WithAttribute returns a copy of ctx that adds the attribute to messages sent with it.
+func WithAttribute(ctx context.Context, name, value string) context.Context {
+	attributes := map[string]string{}
+	if existing, ok := ctx.Value(attributesContextKey{}).(map[string]string); ok {
+		for k, v := range existing {
+			attributes[k] = v
+		}
+	}
+	attributes[name] = value
+	return context.WithValue(ctx, attributesContextKey{}, attributes)
+}
+
+// propagator for W3C trace context, carried in the traceparent and tracestate message attributes.
+var propagator = propagation.TraceContext{}
+
+// createAttributes for a message sent with ctx, carrying the trace context, request ID, and correlation ID onwards,
+// along with any attributes added with WithAttribute. Without a correlation ID in ctx, the message gets a fresh one.
+func createAttributes(ctx context.Context) map[string]string {
+	attributes := map[string]string{}
+	if extra, ok := ctx.Value(attributesContextKey{}).(map[string]string); ok {
+		for k, v := range extra {
+			attributes[k] = v
+		}
+	}
+	propagator.Inject(ctx, propagation.MapCarrier(attributes))
+	if requestID := middleware.GetReqID(ctx); requestID != "" {
+		attributes[RequestIDAttribute] = requestID
+	}
+	attributes[CorrelationIDAttribute] = logging.CorrelationID(ctx)
+	if attributes[CorrelationIDAttribute] == "" {
+		attributes[CorrelationIDAttribute] = logging.NewCorrelationID()
+	}
+	return attributes
+}
+
+// ContextWithAttributes returns a copy of ctx with the trace context, request ID, and correlation ID
+// from the message attributes. Missing or malformed attributes are ignored, so a new trace is started for the message.
+// Messages without a correlation ID, sent before there were any, are correlated by the request ID, if they have one,
+// and get a fresh one otherwise.
+func ContextWithAttributes(ctx context.Context, attributes map[string]string) context.Context {
+	ctx = propagator.Extract(ctx, propagation.MapCarrier(attributes))
+	requestID := attributes[RequestIDAttribute]
+	if requestID != "" {
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID)
+	}
+	correlationID := attributes[CorrelationIDAttribute]
+	if correlationID == "" {
+		correlationID = requestID
+	}
+	if correlationID == "" {
+		correlationID = logging.NewCorrelationID()
+	}
+	return logging.WithCorrelationID(ctx, correlationID)
+}
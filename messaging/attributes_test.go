@@ -0,0 +1,107 @@
+package messaging_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/logging"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+func TestContextWithAttributes(t *testing.T) {
+	t.Run("round-trips the trace context and request ID through message attributes", func(t *testing.T) {
+		is := is.New(t)
+
+		sc := createSpanContext()
+		ctx := trace.ContextWithSpanContext(context.Background(), sc)
+		ctx = context.WithValue(ctx, middleware.RequestIDKey, "abc-123")
+
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		err := queue.Send(ctx, model.Message{"job": "foo"})
+		is.NoErr(err)
+
+		rm, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal("00-0102030405060708090a0b0c0d0e0f10-0102030405060708-01", rm.Attributes["traceparent"])
+		is.Equal("abc-123", rm.Attributes[messaging.RequestIDAttribute])
+
+		ctx = messaging.ContextWithAttributes(context.Background(), rm.Attributes)
+		extracted := trace.SpanContextFromContext(ctx)
+		is.Equal(sc.TraceID(), extracted.TraceID())
+		is.Equal(sc.SpanID(), extracted.SpanID())
+		is.True(extracted.IsRemote())
+		is.Equal("abc-123", middleware.GetReqID(ctx))
+	})
+
+	t.Run("sends no trace attributes without a trace, just a fresh correlation ID", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		err := queue.Send(context.Background(), model.Message{"job": "foo"})
+		is.NoErr(err)
+		err = queue.Send(context.Background(), model.Message{"job": "foo"})
+		is.NoErr(err)
+
+		rm1, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		rm2, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal(1, len(rm1.Attributes))
+		is.Equal(32, len(rm1.Attributes[messaging.CorrelationIDAttribute]))
+		is.True(rm1.Attributes[messaging.CorrelationIDAttribute] != rm2.Attributes[messaging.CorrelationIDAttribute])
+	})
+
+	t.Run("round-trips the correlation ID through message attributes", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+		err := queue.Send(logging.WithCorrelationID(context.Background(), "corr-123"), model.Message{"job": "foo"})
+		is.NoErr(err)
+
+		rm, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal("corr-123", rm.Attributes[messaging.CorrelationIDAttribute])
+
+		ctx := messaging.ContextWithAttributes(context.Background(), rm.Attributes)
+		is.Equal("corr-123", logging.CorrelationID(ctx))
+	})
+
+	t.Run("correlates messages without a correlation ID by the request ID, or a fresh one", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx := messaging.ContextWithAttributes(context.Background(), map[string]string{messaging.RequestIDAttribute: "abc-123"})
+		is.Equal("abc-123", logging.CorrelationID(ctx))
+
+		ctx = messaging.ContextWithAttributes(context.Background(), nil)
+		is.Equal(32, len(logging.CorrelationID(ctx)))
+	})
+
+	t.Run("ignores missing and malformed attributes", func(t *testing.T) {
+		tests := []map[string]string{
+			nil,
+			{},
+			{"traceparent": "not-a-traceparent"},
+			{"traceparent": "00-00000000000000000000000000000000-0102030405060708-01"},
+		}
+		for _, attributes := range tests {
+			is := is.New(t)
+			ctx := messaging.ContextWithAttributes(context.Background(), attributes)
+			is.True(!trace.SpanContextFromContext(ctx).IsValid())
+			is.Equal("", middleware.GetReqID(ctx))
+		}
+	})
+}
+
+func createSpanContext() trace.SpanContext {
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+}
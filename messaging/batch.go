@@ -0,0 +1,199 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+// maxBatchSize is the maximum number of entries SQS accepts in one SendMessageBatch call.
+const maxBatchSize = 10
+
+// BatchResult of SendBatch, listing which entries succeeded and which failed.
+// Entry IDs are the indexes of the messages passed to SendBatch, as strings.
+type BatchResult struct {
+	Succeeded []string
+	Failed    []BatchFailure
+}
+
+// BatchFailure for a single entry in a batch.
+type BatchFailure struct {
+	ID      string
+	Message model.Message
+	// Code is the SQS error code, such as "InvalidMessageContents" or "RequestThrottled".
+	Code        string
+	Err         string
+	SenderFault bool
+}
+
+// throttlingCodes are retryable even though SQS reports some of them as sender faults.
+var throttlingCodes = map[string]bool{
+	"KmsThrottled":        true,
+	"RequestThrottled":    true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+}
+
+// Retryable is true if the failure wasn't caused by the entry itself, such as throttling or a server error.
+func (f BatchFailure) Retryable() bool {
+	return !f.SenderFault || throttlingCodes[f.Code]
+}
+
+type batchEntry struct {
+	id      string
+	message model.Message
+}
+
+// SendBatch of messages to the queue as JSON, in as many SendMessageBatch calls as needed.
+// Failed entries are listed in the result instead of being returned as an error, so callers can retry just those.
+// The trace context and request ID from ctx are sent along as message attributes, like in Send.
+func (q *Queue) SendBatch(ctx context.Context, ms []model.Message) (BatchResult, error) {
+	entries := make([]batchEntry, len(ms))
+	for i, m := range ms {
+		entries[i] = batchEntry{id: strconv.Itoa(i), message: m}
+	}
+	return q.sendEntries(ctx, entries)
+}
+
+// RetryFailed entries of result that are retryable, up to attempts times, with exponential backoff between attempts.
+// Sender-fault failures are never retried and stay in the returned result's Failed list.
+func (q *Queue) RetryFailed(ctx context.Context, result BatchResult, attempts int) (BatchResult, error) {
+	delay := q.batchRetryDelay
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		var retry []batchEntry
+		var permanent []BatchFailure
+		for _, f := range result.Failed {
+			if f.Retryable() {
+				retry = append(retry, batchEntry{id: f.ID, message: f.Message})
+			} else {
+				permanent = append(permanent, f)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		q.log.Debug("Retrying failed batch entries", zap.Int("count", len(retry)), zap.Int("attempt", attempt+1))
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return result, ctx.Err()
+		case <-t.C:
+		}
+		delay *= 2
+
+		retried, err := q.sendEntries(ctx, retry)
+		if err != nil {
+			return result, err
+		}
+		result = BatchResult{
+			Succeeded: append(append([]string{}, result.Succeeded...), retried.Succeeded...),
+			Failed:    append(permanent, retried.Failed...),
+		}
+	}
+
+	return result, nil
+}
+
+func (q *Queue) sendEntries(ctx context.Context, entries []batchEntry) (result BatchResult, err error) {
+	ctx, span := q.startSendSpan(ctx, len(entries))
+	defer func() {
+		if err == nil && len(result.Failed) > 0 {
+			span.SetStatus(codes.Error, strconv.Itoa(len(result.Failed))+" messages failed")
+		}
+		recordError(span, err)
+		span.End()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, q.sendTimeout)
+	defer cancel()
+
+	if q.url == nil {
+		if err := q.getQueueURL(ctx); err != nil {
+			return BatchResult{}, err
+		}
+	}
+
+	attributes := createSQSAttributes(ctx)
+
+	for start := 0; start < len(entries); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		chunk := entries[start:end]
+
+		var requestEntries []types.SendMessageBatchRequestEntry
+		messages := map[string]model.Message{}
+		for _, e := range chunk {
+			messageAsBytes, err := json.Marshal(e.message)
+			if err != nil {
+				return result, err
+			}
+			requestEntries = append(requestEntries, types.SendMessageBatchRequestEntry{
+				Id:                aws.String(e.id),
+				MessageAttributes: attributes,
+				MessageBody:       aws.String(string(messageAsBytes)),
+			})
+			messages[e.id] = e.message
+		}
+
+		output, err := q.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			Entries:  requestEntries,
+			QueueUrl: q.url,
+		})
+		if err != nil {
+			// The whole call failed, so every entry in the chunk failed the same way.
+			code, senderFault := errorCodeAndFault(err)
+			for _, e := range chunk {
+				result.Failed = append(result.Failed, BatchFailure{
+					ID:          e.id,
+					Message:     e.message,
+					Code:        code,
+					Err:         err.Error(),
+					SenderFault: senderFault,
+				})
+			}
+			continue
+		}
+
+		for _, s := range output.Successful {
+			result.Succeeded = append(result.Succeeded, aws.ToString(s.Id))
+		}
+		for _, f := range output.Failed {
+			id := aws.ToString(f.Id)
+			result.Failed = append(result.Failed, BatchFailure{
+				ID:          id,
+				Message:     messages[id],
+				Code:        aws.ToString(f.Code),
+				Err:         aws.ToString(f.Message),
+				SenderFault: f.SenderFault,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// errorCodeAndFault of a failed API call, treating errors that aren't API errors (like network errors) as retryable.
+func errorCodeAndFault(err error) (string, bool) {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode(), apiErr.ErrorFault() == smithy.FaultClient
+	}
+	return "RequestError", false
+}
@@ -0,0 +1,201 @@
+package messaging_test
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// sqsClientMock is a fake SQS client. Embedding the interface means unscripted methods panic.
+type sqsClientMock struct {
+	sqsClient
+	mutex sync.Mutex
+	// fail entries by message body field "n", with the given code and sender fault, for the given number of calls.
+	failures map[string]scriptedFailure
+	batches  [][]string
+	sent     []model.Message
+}
+
+type sqsClient interface {
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	DeleteQueue(ctx context.Context, params *sqs.DeleteQueueInput, optFns ...func(*sqs.Options)) (*sqs.DeleteQueueOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+	SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+}
+
+type scriptedFailure struct {
+	code        string
+	senderFault bool
+	times       int
+}
+
+func (c *sqsClientMock) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func (c *sqsClientMock) SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var ids []string
+	output := &sqs.SendMessageBatchOutput{}
+	for _, e := range params.Entries {
+		ids = append(ids, *e.Id)
+
+		var m model.Message
+		if err := json.Unmarshal([]byte(*e.MessageBody), &m); err != nil {
+			panic(err)
+		}
+
+		if f, ok := c.failures[m["n"]]; ok && f.times > 0 {
+			f.times--
+			c.failures[m["n"]] = f
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Code:        aws.String(f.code),
+				Id:          e.Id,
+				Message:     aws.String("scripted failure"),
+				SenderFault: f.senderFault,
+			})
+			continue
+		}
+
+		c.sent = append(c.sent, m)
+		output.Successful = append(output.Successful, types.SendMessageBatchResultEntry{Id: e.Id})
+	}
+	c.batches = append(c.batches, ids)
+	return output, nil
+}
+
+func TestQueue_SendBatch(t *testing.T) {
+	t.Run("sends messages in batches of up to 10 and reports each entry", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sqsClientMock{}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs"})
+
+		result, err := q.SendBatch(context.Background(), createMessages(23))
+		is.NoErr(err)
+		is.Equal(23, len(result.Succeeded))
+		is.Equal(0, len(result.Failed))
+		is.Equal(3, len(client.batches))
+		is.Equal([]int{10, 10, 3}, []int{len(client.batches[0]), len(client.batches[1]), len(client.batches[2])})
+	})
+
+	t.Run("lists failed entries with their error codes", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sqsClientMock{failures: map[string]scriptedFailure{
+			"2": {code: "InvalidMessageContents", senderFault: true, times: 1},
+			"5": {code: "InternalError", times: 1},
+			"7": {code: "RequestThrottled", senderFault: true, times: 1},
+		}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs"})
+
+		result, err := q.SendBatch(context.Background(), createMessages(10))
+		is.NoErr(err)
+		is.Equal(7, len(result.Succeeded))
+		is.Equal(3, len(result.Failed))
+
+		is.Equal("2", result.Failed[0].ID)
+		is.Equal("InvalidMessageContents", result.Failed[0].Code)
+		is.Equal(model.Message{"n": "2"}, result.Failed[0].Message)
+		is.True(!result.Failed[0].Retryable())
+
+		is.Equal("InternalError", result.Failed[1].Code)
+		is.True(result.Failed[1].Retryable())
+
+		is.Equal("RequestThrottled", result.Failed[2].Code)
+		is.True(result.Failed[2].Retryable())
+	})
+}
+
+func TestQueue_RetryFailed(t *testing.T) {
+	t.Run("retries receiver-fault and throttled entries but not sender-fault ones", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sqsClientMock{failures: map[string]scriptedFailure{
+			"2": {code: "InvalidMessageContents", senderFault: true, times: 10},
+			"5": {code: "InternalError", times: 2},
+			"7": {code: "RequestThrottled", senderFault: true, times: 1},
+		}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{BatchRetryDelay: time.Millisecond, Client: client, Name: "jobs"})
+
+		result, err := q.SendBatch(context.Background(), createMessages(10))
+		is.NoErr(err)
+
+		result, err = q.RetryFailed(context.Background(), result, 3)
+		is.NoErr(err)
+
+		sort.Strings(result.Succeeded)
+		is.Equal([]string{"0", "1", "3", "4", "5", "6", "7", "8", "9"}, result.Succeeded)
+		is.Equal(1, len(result.Failed))
+		is.Equal("2", result.Failed[0].ID)
+
+		// The first send, then two retries for entries 5 and 7, and the last retry only for 5.
+		is.Equal(3, len(client.batches))
+		is.Equal([]string{"5", "7"}, client.batches[1])
+		is.Equal([]string{"5"}, client.batches[2])
+	})
+
+	t.Run("gives up after the given number of attempts", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sqsClientMock{failures: map[string]scriptedFailure{
+			"1": {code: "InternalError", times: 10},
+		}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{BatchRetryDelay: time.Millisecond, Client: client, Name: "jobs"})
+
+		result, err := q.SendBatch(context.Background(), createMessages(2))
+		is.NoErr(err)
+
+		result, err = q.RetryFailed(context.Background(), result, 2)
+		is.NoErr(err)
+		is.Equal(1, len(result.Failed))
+		is.Equal(3, len(client.batches))
+	})
+
+	t.Run("does not write to the passed result", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &sqsClientMock{}
+		q := messaging.NewQueue(messaging.NewQueueOptions{BatchRetryDelay: time.Millisecond, Client: client, Name: "jobs"})
+
+		succeeded := make([]string, 1, 2)
+		succeeded[0] = "0"
+		result := messaging.BatchResult{
+			Succeeded: succeeded,
+			Failed:    []messaging.BatchFailure{{ID: "1", Message: model.Message{"n": "1"}, Code: "InternalError"}},
+		}
+
+		retried, err := q.RetryFailed(context.Background(), result, 1)
+		is.NoErr(err)
+		is.Equal([]string{"0", "1"}, retried.Succeeded)
+		is.Equal("", succeeded[:2][1])
+	})
+}
+
+func createMessages(n int) []model.Message {
+	var ms []model.Message
+	for i := 0; i < n; i++ {
+		ms = append(ms, model.Message{"n": strconv.Itoa(i)})
+	}
+	return ms
+}
@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// queueAttributes configured in NewQueueOptions, by SQS attribute name. Unset options are left out.
+func (q *Queue) queueAttributes() map[string]string {
+	attributes := map[string]string{}
+	setSeconds := func(name types.QueueAttributeName, d time.Duration) {
+		if d > 0 {
+			attributes[string(name)] = strconv.Itoa(int(d.Seconds()))
+		}
+	}
+	setSeconds(types.QueueAttributeNameVisibilityTimeout, q.visibilityTimeout)
+	setSeconds(types.QueueAttributeNameMessageRetentionPeriod, q.messageRetentionPeriod)
+	setSeconds(types.QueueAttributeNameReceiveMessageWaitTimeSeconds, q.receiveWaitTime)
+	return attributes
+}
+
+// EnsureQueue creates the queue if it doesn't exist, and sets the queue attributes configured in NewQueueOptions.
+func (q *Queue) EnsureQueue(ctx context.Context) error {
+	output, err := q.Client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: &q.name})
+	if err != nil {
+		return fmt.Errorf("error creating queue %v: %w", q.name, err)
+	}
+
+	q.mutex.Lock()
+	q.url = output.QueueUrl
+	q.mutex.Unlock()
+
+	attributes := q.queueAttributes()
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	if _, err := q.Client.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+		Attributes: attributes,
+		QueueUrl:   q.url,
+	}); err != nil {
+		return fmt.Errorf("error setting attributes of queue %v: %w", q.name, err)
+	}
+	return nil
+}
+
+// AttributeDifference between the configured and live value of a queue attribute.
+type AttributeDifference struct {
+	Name       string
+	Configured string
+	Live       string
+}
+
+// AttributeDrift of a queue, listing the attributes whose live value differs from the configured one.
+type AttributeDrift []AttributeDifference
+
+// String lists the differences, such as "VisibilityTimeout: configured 30, live 60".
+// Attributes without a live value are listed as unset.
+func (d AttributeDrift) String() string {
+	var differences []string
+	for _, a := range d {
+		live := a.Live
+		if live == "" {
+			live = "unset"
+		}
+		differences = append(differences, fmt.Sprintf("%v: configured %v, live %v", a.Name, a.Configured, live))
+	}
+	return strings.Join(differences, "; ")
+}
+
+// CheckAttributeDrift compares the live queue attributes to the ones configured in NewQueueOptions.
+// Attributes that aren't configured aren't checked. The differences are sorted by attribute name.
+// The error is ErrQueueNotFound if the queue doesn't exist.
+func (q *Queue) CheckAttributeDrift(ctx context.Context) (AttributeDrift, error) {
+	configured := q.queueAttributes()
+	if len(configured) == 0 {
+		return nil, nil
+	}
+
+	if q.url == nil {
+		if err := q.getQueueURL(ctx); err != nil {
+			return nil, fmt.Errorf("error getting URL of queue %v: %w", q.name, classifyQueueError(err))
+		}
+	}
+
+	var names []types.QueueAttributeName
+	for name := range configured {
+		names = append(names, types.QueueAttributeName(name))
+	}
+
+	output, err := q.Client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		AttributeNames: names,
+		QueueUrl:       q.url,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error getting attributes of queue %v: %w", q.name, classifyQueueError(err))
+	}
+
+	var drift AttributeDrift
+	for name, value := range configured {
+		if live := output.Attributes[name]; live != value {
+			drift = append(drift, AttributeDifference{Name: name, Configured: value, Live: live})
+		}
+	}
+	sort.Slice(drift, func(i, j int) bool {
+		return drift[i].Name < drift[j].Name
+	})
+	return drift, nil
+}
@@ -0,0 +1,146 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+)
+
+// attributesClientMock is a fake SQS client with a single queue and its live attributes.
+type attributesClientMock struct {
+	sqsClient
+	created []string
+	live    map[string]string
+	// missing makes the queue not exist.
+	missing       bool
+	setAttributes []map[string]string
+}
+
+func (c *attributesClientMock) CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	c.created = append(c.created, *params.QueueName)
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func (c *attributesClientMock) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	if c.missing {
+		return nil, notFound()
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func (c *attributesClientMock) SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error) {
+	c.setAttributes = append(c.setAttributes, params.Attributes)
+	return &sqs.SetQueueAttributesOutput{}, nil
+}
+
+func (c *attributesClientMock) GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	attributes := map[string]string{}
+	for _, name := range params.AttributeNames {
+		if v, ok := c.live[string(name)]; ok {
+			attributes[string(name)] = v
+		}
+	}
+	return &sqs.GetQueueAttributesOutput{Attributes: attributes}, nil
+}
+
+func TestQueue_EnsureQueue(t *testing.T) {
+	t.Run("creates the queue and sets the configured attributes", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{}
+		q := messaging.NewQueue(messaging.NewQueueOptions{
+			Client:                 client,
+			MessageRetentionPeriod: 4 * 24 * time.Hour,
+			Name:                   "jobs",
+			ReceiveWaitTime:        20 * time.Second,
+			VisibilityTimeout:      30 * time.Second,
+		})
+
+		err := q.EnsureQueue(context.Background())
+		is.NoErr(err)
+		is.Equal([]string{"jobs"}, client.created)
+		is.Equal([]map[string]string{{
+			"MessageRetentionPeriod":        "345600",
+			"ReceiveMessageWaitTimeSeconds": "20",
+			"VisibilityTimeout":             "30",
+		}}, client.setAttributes)
+	})
+
+	t.Run("does not set attributes if none are configured", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs"})
+
+		err := q.EnsureQueue(context.Background())
+		is.NoErr(err)
+		is.Equal(0, len(client.setAttributes))
+	})
+}
+
+func TestQueue_CheckAttributeDrift(t *testing.T) {
+	t.Run("reports configured attributes that differ from the live ones", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{live: map[string]string{
+			"MessageRetentionPeriod": "345600",
+			"VisibilityTimeout":      "60",
+			"DelaySeconds":           "5",
+		}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{
+			Client:                 client,
+			MessageRetentionPeriod: 4 * 24 * time.Hour,
+			Name:                   "jobs",
+			ReceiveWaitTime:        20 * time.Second,
+			VisibilityTimeout:      30 * time.Second,
+		})
+
+		drift, err := q.CheckAttributeDrift(context.Background())
+		is.NoErr(err)
+		is.Equal(messaging.AttributeDrift{
+			{Name: "ReceiveMessageWaitTimeSeconds", Configured: "20", Live: ""},
+			{Name: "VisibilityTimeout", Configured: "30", Live: "60"},
+		}, drift)
+		is.Equal("ReceiveMessageWaitTimeSeconds: configured 20, live unset; VisibilityTimeout: configured 30, live 60", drift.String())
+	})
+
+	t.Run("reports no drift if the live attributes match", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{live: map[string]string{"VisibilityTimeout": "30"}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs", VisibilityTimeout: 30 * time.Second})
+
+		drift, err := q.CheckAttributeDrift(context.Background())
+		is.NoErr(err)
+		is.Equal(0, len(drift))
+	})
+	t.Run("returns ErrQueueNotFound if the queue doesn't exist", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{missing: true}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs", VisibilityTimeout: 30 * time.Second})
+
+		_, err := q.CheckAttributeDrift(context.Background())
+		is.True(errors.Is(err, messaging.ErrQueueNotFound))
+	})
+}
+
+func TestQueue_Depth(t *testing.T) {
+	t.Run("gets the approximate number of messages", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &attributesClientMock{live: map[string]string{"ApproximateNumberOfMessages": "42"}}
+		q := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs"})
+
+		depth, err := q.Depth(context.Background())
+		is.NoErr(err)
+		is.Equal(42, depth)
+	})
+}
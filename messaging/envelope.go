@@ -0,0 +1,95 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"canvas/model"
+)
+
+// Payload of a typed message. JobName is put in the "job" field of the message, to route it to the right job.
+type Payload interface {
+	JobName() string
+}
+
+// validator is implemented by payloads that can check their own fields.
+type validator interface {
+	Validate() error
+}
+
+// ValidationError for a payload whose Validate method returned an error.
+type ValidationError struct {
+	Job string
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid payload for job %q: %v", e.Job, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// validate the payload if it has a Validate method.
+func validate(p Payload) error {
+	v, ok := p.(validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return &ValidationError{Job: p.JobName(), Err: err}
+	}
+	return nil
+}
+
+// NewMessage with the fields of the payload and its job name.
+// The payload must marshal to a JSON object with only string values,
+// and pass its Validate method if it has one, so invalid messages are never sent from here.
+func NewMessage(p Payload) (model.Message, error) {
+	if err := validate(p); err != nil {
+		return nil, err
+	}
+
+	payloadAsBytes, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var m model.Message
+	if err := json.Unmarshal(payloadAsBytes, &m); err != nil {
+		return nil, fmt.Errorf("payload %T must marshal to a JSON object with only string values: %w", p, err)
+	}
+	if m == nil {
+		m = model.Message{}
+	}
+	m["job"] = p.JobName()
+	return m, nil
+}
+
+// DecodeMessage into a payload of type P.
+// Returns an error if the message is for another job, and a *ValidationError if the payload fails validation,
+// such as for a message sent by an older version of the app.
+func DecodeMessage[P Payload](m model.Message) (P, error) {
+	var p P
+	if name := m["job"]; name != p.JobName() {
+		return p, fmt.Errorf("message for job %q can't be decoded into payload for job %q", name, p.JobName())
+	}
+	messageAsBytes, err := json.Marshal(m)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(messageAsBytes, &p); err != nil {
+		return p, err
+	}
+	return p, validate(p)
+}
+
+// SendJSON sends the payload as a message created with NewMessage. Invalid payloads are not sent.
+func SendJSON(ctx context.Context, s sender, p Payload) error {
+	m, err := NewMessage(p)
+	if err != nil {
+		return err
+	}
+	return s.Send(ctx, m)
+}
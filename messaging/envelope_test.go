@@ -0,0 +1,91 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+type nonStringPayload struct {
+	Count int `json:"count"`
+}
+
+func (nonStringPayload) JobName() string {
+	return "count"
+}
+
+func TestNewMessage(t *testing.T) {
+	t.Run("creates a message with the payload fields and job name", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := messaging.NewMessage(model.ConfirmationEmailRequested{Email: "me@example.com", Token: "123"})
+		is.NoErr(err)
+		is.Equal(model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123"}, m)
+	})
+
+	t.Run("errors on payloads with non-string fields", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.NewMessage(nonStringPayload{Count: 1})
+		is.True(err != nil)
+	})
+}
+
+func TestDecodeMessage(t *testing.T) {
+	t.Run("decodes a message into the payload", func(t *testing.T) {
+		is := is.New(t)
+
+		p, err := messaging.DecodeMessage[model.ConfirmationEmailRequested](
+			model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123"})
+		is.NoErr(err)
+		is.Equal(model.ConfirmationEmailRequested{Email: "me@example.com", Token: "123"}, p)
+	})
+
+	t.Run("errors on a message for another job", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.DecodeMessage[model.ConfirmationEmailRequested](model.Message{"job": "other"})
+		is.True(err != nil)
+	})
+
+	t.Run("returns a validation error for a payload that fails validation", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.DecodeMessage[model.ConfirmationEmailRequested](
+			model.Message{"job": "confirmation_email", "email": "me@example.com"})
+		var validationErr *messaging.ValidationError
+		is.True(errors.As(err, &validationErr))
+		is.Equal("confirmation_email", validationErr.Job)
+		is.Equal("token is missing", validationErr.Err.Error())
+	})
+}
+
+func TestSendJSON(t *testing.T) {
+	t.Run("sends the payload as a message", func(t *testing.T) {
+		is := is.New(t)
+
+		q := messaging.NewMemoryQueue(time.Millisecond)
+		err := messaging.SendJSON(context.Background(), q, model.ConfirmationEmailRequested{Email: "me@example.com", Token: "123"})
+		is.NoErr(err)
+
+		rm, err := q.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal(model.Message{"job": "confirmation_email", "email": "me@example.com", "token": "123"}, rm.Message)
+	})
+
+	t.Run("does not send a payload that fails validation", func(t *testing.T) {
+		is := is.New(t)
+
+		q := messaging.NewMemoryQueue(time.Millisecond)
+		err := messaging.SendJSON(context.Background(), q, model.ConfirmationEmailRequested{Email: "me@example.com"})
+		var validationErr *messaging.ValidationError
+		is.True(errors.As(err, &validationErr))
+		is.Equal(0, q.Len())
+	})
+}
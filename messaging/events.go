@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"canvas/model"
+)
+
+// EventTypeAttribute is the message attribute with the type of a domain event, for consumers to filter on.
+const EventTypeAttribute = "canvas-event-type"
+
+// Event is the payload of a domain event, like model.SubscriberSignedUp. Its JobName is the event type.
+type Event interface {
+	Payload
+	// Version of the schema of the event, in model.EventSchemas.
+	Version() int
+}
+
+// NewEvent message for the domain event, like NewMessage, with the fields of model.EventMeta:
+// a fresh event ID, the type and version, and when it occurred.
+func NewEvent(e Event, occurredAt time.Time) (model.Message, error) {
+	m, err := NewMessage(e)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	m["eventID"] = hex.EncodeToString(id)
+	m["eventType"] = e.JobName()
+	m["eventVersion"] = strconv.Itoa(e.Version())
+	m["occurredAt"] = occurredAt.UTC().Format(time.RFC3339Nano)
+	return m, nil
+}
+
+// EventType of the message, if it's a domain event, or the empty string otherwise.
+func EventType(m model.Message) string {
+	return m["eventType"]
+}
@@ -0,0 +1,60 @@
+package messaging_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+func TestNewEvent(t *testing.T) {
+	t.Run("creates a message with the event fields, and the event type as the job", func(t *testing.T) {
+		is := is.New(t)
+
+		occurredAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+		m, err := messaging.NewEvent(model.SubscriberSuppressed{Email: "me@example.com", Reason: model.SuppressionReasonBounced}, occurredAt)
+		is.NoErr(err)
+		is.Equal(32, len(m["eventID"]))
+		delete(m, "eventID")
+		is.Equal(model.Message{
+			"job":          "subscriber.suppressed",
+			"eventType":    "subscriber.suppressed",
+			"eventVersion": "1",
+			"occurredAt":   "2022-03-01T11:00:00Z",
+			"email":        "me@example.com",
+			"reason":       "bounced",
+		}, m)
+		is.Equal(model.EventSubscriberSuppressed, messaging.EventType(m))
+
+		e, err := messaging.DecodeMessage[model.SubscriberSuppressed](m)
+		is.NoErr(err)
+		is.Equal("1", e.EventVersion)
+		is.Equal(model.Email("me@example.com"), e.Email)
+	})
+
+	t.Run("gives every event its own ID", func(t *testing.T) {
+		is := is.New(t)
+
+		m1, err := messaging.NewEvent(model.SubscriberSignedUp{Email: "me@example.com"}, time.Now())
+		is.NoErr(err)
+		m2, err := messaging.NewEvent(model.SubscriberSignedUp{Email: "me@example.com"}, time.Now())
+		is.NoErr(err)
+		is.True(m1["eventID"] != m2["eventID"])
+	})
+
+	t.Run("errors on an invalid event", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.NewEvent(model.SubscriberConfirmed{Email: "me@example.com"}, time.Now())
+		is.True(err != nil)
+	})
+
+	t.Run("has no event type for other messages", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal("", messaging.EventType(model.Message{"job": "confirmation_email"}))
+	})
+}
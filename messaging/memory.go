@@ -0,0 +1,189 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"canvas/model"
+)
+
+// MemoryQueue has the same methods as Queue, but keeps messages in memory.
+// It's used in tests and anywhere a real queue isn't needed.
+// Messages that are received but not deleted are not redelivered.
+type MemoryQueue struct {
+	inFlight map[string]memoryMessage
+	messages []memoryMessage
+	mutex    sync.Mutex
+	nextID   int
+	notify   chan struct{}
+	waitTime time.Duration
+}
+
+type memoryMessage struct {
+	attributes map[string]string
+	body       string
+	id         string
+	sent       time.Time
+}
+
+// NewMemoryQueue which waits up to waitTime for a message on Receive.
+func NewMemoryQueue(waitTime time.Duration) *MemoryQueue {
+	return &MemoryQueue{
+		inFlight: map[string]memoryMessage{},
+		notify:   make(chan struct{}, 1),
+		waitTime: waitTime,
+	}
+}
+
+// Send a message to the queue as JSON, with the same message attributes as Queue.Send.
+func (q *MemoryQueue) Send(ctx context.Context, m model.Message) error {
+	messageAsBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return q.SendBody(ctx, string(messageAsBytes))
+}
+
+// SendBody to the queue as it is, like Queue.SendBody.
+func (q *MemoryQueue) SendBody(ctx context.Context, body string) error {
+	q.mutex.Lock()
+	q.nextID++
+	q.messages = append(q.messages, memoryMessage{
+		attributes: createAttributes(ctx),
+		body:       body,
+		id:         strconv.Itoa(q.nextID),
+		sent:       time.Now(),
+	})
+	q.mutex.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// SendBatch of messages to the queue, with the same entry IDs as Queue.SendBatch. All entries succeed.
+func (q *MemoryQueue) SendBatch(ctx context.Context, ms []model.Message) (BatchResult, error) {
+	var result BatchResult
+	for i, m := range ms {
+		if err := q.Send(ctx, m); err != nil {
+			return result, err
+		}
+		result.Succeeded = append(result.Succeeded, strconv.Itoa(i))
+	}
+	return result, nil
+}
+
+// RetryFailed returns the result unchanged, because SendBatch never fails entries.
+func (q *MemoryQueue) RetryFailed(ctx context.Context, result BatchResult, attempts int) (BatchResult, error) {
+	return result, nil
+}
+
+// Receive a message from the queue. Returns nil if no message is available within the wait time.
+// A message that can't be decoded is returned with a *DecodeError, like from Queue.Receive.
+func (q *MemoryQueue) Receive(ctx context.Context) (*Received, error) {
+	timer := time.NewTimer(q.waitTime)
+	defer timer.Stop()
+
+	for {
+		if rm := q.pop(); rm != nil {
+			var m model.Message
+			if err := json.Unmarshal([]byte(rm.Body), &m); err != nil {
+				return rm, &DecodeError{Err: err}
+			}
+			rm.Message = m
+			return rm, nil
+		}
+
+		select {
+		case <-q.notify:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Delete a message by receipt ID.
+func (q *MemoryQueue) Delete(ctx context.Context, receiptID string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.inFlight, receiptID)
+	return nil
+}
+
+// Nack a received message, making it available to receive again after delay.
+func (q *MemoryQueue) Nack(ctx context.Context, receiptID string, delay time.Duration) error {
+	q.mutex.Lock()
+	mm, ok := q.inFlight[receiptID]
+	delete(q.inFlight, receiptID)
+	q.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	time.AfterFunc(delay, func() {
+		q.mutex.Lock()
+		q.messages = append(q.messages, mm)
+		q.mutex.Unlock()
+
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	})
+	return nil
+}
+
+// Len of the queue, including messages that have been received but not deleted.
+func (q *MemoryQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.messages) + len(q.inFlight)
+}
+
+// Depth of the queue, which is the number of messages available to receive, like Queue.Depth.
+func (q *MemoryQueue) Depth(ctx context.Context) (int, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.messages), nil
+}
+
+func (q *MemoryQueue) pop() *Received {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.messages) == 0 {
+		return nil
+	}
+	mm := q.messages[0]
+	q.messages = q.messages[1:]
+
+	receiptID := "receipt-" + mm.id
+	q.inFlight[receiptID] = mm
+
+	return &Received{
+		ID:         mm.id,
+		ReceiptID:  receiptID,
+		Attributes: copyMap(mm.attributes),
+		Body:       mm.body,
+		Sent:       mm.sent,
+	}
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -3,95 +3,290 @@ package messaging
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 	"go.uber.org/zap"
 
 	"canvas/model"
 )
 
+// sqsClient has the sqs.Client methods used by Queue, so it can be faked in tests.
+type sqsClient interface {
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+	CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	DeleteQueue(ctx context.Context, params *sqs.DeleteQueueInput, optFns ...func(*sqs.Options)) (*sqs.DeleteQueueOutput, error)
+	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	SendMessage(ctx context.Context, params *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error)
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+	SetQueueAttributes(ctx context.Context, params *sqs.SetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.SetQueueAttributesOutput, error)
+}
+
 type Queue struct {
-	Client   *sqs.Client
-	log      *zap.Logger
-	mutex    sync.Mutex
-	name     string
-	url      *string
-	waitTime time.Duration
+	Client                 sqsClient
+	batchRetryDelay        time.Duration
+	deleteTimeout          time.Duration
+	log                    *zap.Logger
+	messageRetentionPeriod time.Duration
+	mutex                  sync.Mutex
+	name                   string
+	receiveTimeout         time.Duration
+	receiveWaitTime        time.Duration
+	sendTimeout            time.Duration
+	url                    *string
+	visibilityTimeout      time.Duration
+	waitTime               time.Duration
 }
 
 type NewQueueOptions struct {
-	Config   aws.Config
-	Log      *zap.Logger
-	Name     string
+	// AdaptiveRetry uses the SDK's adaptive retry mode, which also rate limits attempts after throttling errors.
+	AdaptiveRetry bool
+	// BatchRetryDelay before the first retry in RetryFailed, doubled for each attempt. Defaults to 100ms.
+	BatchRetryDelay time.Duration
+	// Client overrides the SQS client created from Config, such as with a fake in tests.
+	Client sqsClient
+	Config aws.Config
+	// DeleteTimeout for a Delete call, including retries. Defaults to 10 seconds.
+	DeleteTimeout time.Duration
+	// EndpointURL overrides the default SQS endpoint of the region, such as for local development, if not empty.
+	EndpointURL string
+	Log         *zap.Logger
+	// MessageRetentionPeriod queue attribute, set by EnsureQueue and checked by CheckAttributeDrift if not zero.
+	MessageRetentionPeriod time.Duration
+	// MaxRetries of a failed call, except for receives, which are never retried. Defaults to 5.
+	MaxRetries int
+	// MaxRetryBackoff between retries of a call. Defaults to 20 seconds.
+	MaxRetryBackoff time.Duration
+	Name            string
+	// ReceiveTimeout for a Receive call. Defaults to the wait time of receives plus 10 seconds.
+	ReceiveTimeout time.Duration
+	// ReceiveWaitTime is the ReceiveMessageWaitTimeSeconds queue attribute, set by EnsureQueue and checked by
+	// CheckAttributeDrift if not zero. If WaitTime is zero, SQS long-polls receives for this long instead.
+	ReceiveWaitTime time.Duration
+	// SendTimeout for a Send or SendBatch call, including retries. Defaults to 10 seconds.
+	SendTimeout time.Duration
+	// VisibilityTimeout queue attribute, set by EnsureQueue and checked by CheckAttributeDrift if not zero.
+	VisibilityTimeout time.Duration
+	// WaitTime of each receive, up to 20 seconds. If zero, receives wait for the ReceiveWaitTime of the queue.
 	WaitTime time.Duration
 }
 
+// NewQueue with the given options.
+// Retries are logged at debug level through the logger in Config.
 func NewQueue(opts NewQueueOptions) *Queue {
 	if opts.Log == nil {
 		opts.Log = zap.NewNop()
 	}
+	if opts.MaxRetries <= 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = 20 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = sqs.NewFromConfig(opts.Config, func(o *sqs.Options) {
+			o.Retryer = createRetryer(opts.MaxRetries, opts.MaxRetryBackoff, opts.AdaptiveRetry)
+			o.ClientLogMode |= aws.LogRetries
+			if opts.EndpointURL != "" {
+				o.BaseEndpoint = aws.String(opts.EndpointURL)
+			}
+		})
+	}
+	if opts.BatchRetryDelay <= 0 {
+		opts.BatchRetryDelay = 100 * time.Millisecond
+	}
+	if opts.DeleteTimeout <= 0 {
+		opts.DeleteTimeout = 10 * time.Second
+	}
+	if opts.ReceiveTimeout <= 0 {
+		waitTime := opts.WaitTime
+		if waitTime == 0 {
+			waitTime = opts.ReceiveWaitTime
+		}
+		opts.ReceiveTimeout = waitTime + 10*time.Second
+	}
+	if opts.SendTimeout <= 0 {
+		opts.SendTimeout = 10 * time.Second
+	}
 	return &Queue{
-		Client:   sqs.NewFromConfig(opts.Config),
-		log:      opts.Log,
-		name:     opts.Name,
-		waitTime: opts.WaitTime,
+		Client:                 opts.Client,
+		batchRetryDelay:        opts.BatchRetryDelay,
+		deleteTimeout:          opts.DeleteTimeout,
+		log:                    opts.Log,
+		messageRetentionPeriod: opts.MessageRetentionPeriod,
+		name:                   opts.Name,
+		receiveTimeout:         opts.ReceiveTimeout,
+		receiveWaitTime:        opts.ReceiveWaitTime,
+		sendTimeout:            opts.SendTimeout,
+		visibilityTimeout:      opts.VisibilityTimeout,
+		waitTime:               opts.WaitTime,
 	}
 }
 
-// Send a message to the queue as JSON.
+// createRetryer for the SQS client, in standard or adaptive mode.
+func createRetryer(maxRetries int, maxBackoff time.Duration, adaptive bool) aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxRetries + 1
+		o.MaxBackoff = maxBackoff
+		o.Backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+	}
+	if adaptive {
+		return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, standard)
+		})
+	}
+	return retry.NewStandard(standard)
+}
+
+// withoutRetries for a single call. Long polls aren't retried, because the next poll is the retry.
+func withoutRetries(o *sqs.Options) {
+	o.Retryer = aws.NopRetryer{}
+}
+
+// Send a message to the queue as JSON, in a producer span if ctx is in a trace.
+// The trace context and request ID from ctx are sent along as message attributes.
 func (q *Queue) Send(ctx context.Context, m model.Message) error {
+	messageAsBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return q.SendBody(ctx, string(messageAsBytes))
+}
+
+// SendBody to the queue as it is, like Send. It's for messages whose body couldn't be decoded on receive.
+func (q *Queue) SendBody(ctx context.Context, body string) (err error) {
+	ctx, span := q.startSendSpan(ctx, 1)
+	defer func() {
+		recordError(span, err)
+		span.End()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, q.sendTimeout)
+	defer cancel()
+
 	if q.url == nil {
 		if err := q.getQueueURL(ctx); err != nil {
 			return err
 		}
 	}
 
-	messageAsBytes, err := json.Marshal(m)
+	output, err := q.Client.SendMessage(ctx, &sqs.SendMessageInput{
+		MessageAttributes: createSQSAttributes(ctx),
+		MessageBody:       &body,
+		QueueUrl:          q.url,
+	})
 	if err != nil {
 		return err
 	}
-	messageAsString := string(messageAsBytes)
+	if output.MessageId != nil && span.IsRecording() {
+		span.SetAttributes(semconv.MessagingMessageIDKey.String(*output.MessageId))
+	}
+	return nil
+}
 
-	_, err = q.Client.SendMessage(ctx, &sqs.SendMessageInput{
-		MessageBody: &messageAsString,
-		QueueUrl:    q.url,
-	})
-	return err
+// Received message from the queue, with the metadata needed to process and delete it.
+type Received struct {
+	ID         string
+	ReceiptID  string
+	Message    model.Message
+	Attributes map[string]string
+	// Body of the message as received. Message is decoded from it.
+	Body string
+	// Sent is when the message was first sent to the queue, if known.
+	Sent time.Time
 }
 
-// Receive a message and its receipt ID from the queue. Returns nil if no message is available.
-func (q *Queue) Receive(ctx context.Context) (*model.Message, string, error) {
+// DecodeError for a received message whose body isn't a JSON object with only string values.
+// Receive returns it together with the message, without Message, so the message can be quarantined
+// instead of being received again and again.
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding message body: %v", e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// sentTimestampAttribute is the SQS system attribute with the time a message was sent, in milliseconds since the epoch.
+const sentTimestampAttribute = "SentTimestamp"
+
+// Receive a message from the queue. Returns nil if no message is available.
+// The receive is not retried on errors. If ctx is cancelled during the long poll, ctx.Err() is returned.
+// A message that can't be decoded is returned with a *DecodeError.
+func (q *Queue) Receive(ctx context.Context) (*Received, error) {
+	receiveCtx, cancel := context.WithTimeout(ctx, q.receiveTimeout)
+	defer cancel()
+
 	if q.url == nil {
-		if err := q.getQueueURL(ctx); err != nil {
-			return nil, "", err
+		if err := q.getQueueURL(receiveCtx); err != nil {
+			return nil, err
 		}
 	}
 
-	output, err := q.Client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
-		QueueUrl:        q.url,
-		WaitTimeSeconds: int32(q.waitTime.Seconds()),
-	})
+	output, err := q.Client.ReceiveMessage(receiveCtx, &sqs.ReceiveMessageInput{
+		AttributeNames:        []types.QueueAttributeName{sentTimestampAttribute},
+		MessageAttributeNames: []string{"All"},
+		QueueUrl:              q.url,
+		WaitTimeSeconds:       int32(q.waitTime.Seconds()),
+	}, withoutRetries)
 	if err != nil {
-		return nil, "", err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
 	}
 
 	if len(output.Messages) == 0 {
-		return nil, "", nil
+		return nil, nil
 	}
 
-	var m model.Message
-	if err := json.Unmarshal([]byte(*output.Messages[0].Body), &m); err != nil {
-		return nil, "", err
+	attributes := map[string]string{}
+	for k, v := range output.Messages[0].MessageAttributes {
+		if v.StringValue != nil {
+			attributes[k] = *v.StringValue
+		}
 	}
 
-	return &m, *output.Messages[0].ReceiptHandle, nil
+	var sent time.Time
+	if ms, err := strconv.ParseInt(output.Messages[0].Attributes[sentTimestampAttribute], 10, 64); err == nil {
+		sent = time.UnixMilli(ms)
+	}
+
+	rm := &Received{
+		ID:         aws.ToString(output.Messages[0].MessageId),
+		ReceiptID:  aws.ToString(output.Messages[0].ReceiptHandle),
+		Attributes: attributes,
+		Body:       aws.ToString(output.Messages[0].Body),
+		Sent:       sent,
+	}
+	// Decoding can fail halfway, so the message is only set if it doesn't.
+	var m model.Message
+	if err := json.Unmarshal([]byte(rm.Body), &m); err != nil {
+		return rm, &DecodeError{Err: err}
+	}
+	rm.Message = m
+	return rm, nil
 }
 
 // Delete a message by receipt ID.
 func (q *Queue) Delete(ctx context.Context, receiptID string) error {
+	ctx, cancel := context.WithTimeout(ctx, q.deleteTimeout)
+	defer cancel()
+
 	if q.url == nil {
 		if err := q.getQueueURL(ctx); err != nil {
 			return err
@@ -105,8 +300,56 @@ func (q *Queue) Delete(ctx context.Context, receiptID string) error {
 	return err
 }
 
-// getQueueURL under a lock.
-func (q *Queue) getQueueURL(ctx context.Context) error {
+// Nack a received message, making it available to receive again after delay instead of after the visibility timeout.
+func (q *Queue) Nack(ctx context.Context, receiptID string, delay time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, q.deleteTimeout)
+	defer cancel()
+
+	if q.url == nil {
+		if err := q.getQueueURL(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err := q.Client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          q.url,
+		ReceiptHandle:     &receiptID,
+		VisibilityTimeout: int32(delay.Seconds()),
+	})
+	return err
+}
+
+// Depth of the queue, which is the approximate number of messages available to receive.
+// Messages that are received but not deleted yet, or delayed, aren't counted.
+func (q *Queue) Depth(ctx context.Context) (int, error) {
+	if q.url == nil {
+		if err := q.getQueueURL(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	output, err := q.Client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
+		QueueUrl:       q.url,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(output.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+}
+
+// URL of the queue, looked up by its name the first time.
+func (q *Queue) URL(ctx context.Context) (string, error) {
+	if q.url == nil {
+		if err := q.getQueueURL(ctx); err != nil {
+			return "", err
+		}
+	}
+	return *q.url, nil
+}
+
+// getQueueURL under a lock, with the options for the call.
+func (q *Queue) getQueueURL(ctx context.Context, optFns ...func(*sqs.Options)) error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
@@ -117,7 +360,7 @@ func (q *Queue) getQueueURL(ctx context.Context) error {
 
 	output, err := q.Client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
 		QueueName: &q.name,
-	})
+	}, optFns...)
 	if err != nil {
 		return err
 	}
@@ -125,3 +368,15 @@ func (q *Queue) getQueueURL(ctx context.Context) error {
 
 	return nil
 }
+
+// createSQSAttributes from createAttributes, in the form the SQS API expects.
+func createSQSAttributes(ctx context.Context) map[string]types.MessageAttributeValue {
+	attributes := map[string]types.MessageAttributeValue{}
+	for k, v := range createAttributes(ctx) {
+		attributes[k] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(v),
+		}
+	}
+	return attributes
+}
@@ -2,11 +2,22 @@ package messaging_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/smithy-go/logging"
 	"github.com/matryer/is"
 
 	"canvas/integrationtest"
+	"canvas/messaging"
 	"canvas/model"
 )
 
@@ -24,16 +35,210 @@ func TestQueue(t *testing.T) {
 		})
 		is.NoErr(err)
 
-		m, receiptID, err := queue.Receive(context.Background())
+		rm, err := queue.Receive(context.Background())
 		is.NoErr(err)
-		is.Equal(model.Message{"foo": "bar"}, *m)
-		is.True(len(receiptID) > 0)
+		is.Equal(model.Message{"foo": "bar"}, rm.Message)
+		is.True(len(rm.ID) > 0)
+		is.True(len(rm.ReceiptID) > 0)
 
-		err = queue.Delete(context.Background(), receiptID)
+		err = queue.Delete(context.Background(), rm.ReceiptID)
 		is.NoErr(err)
 
-		m, _, err = queue.Receive(context.Background())
+		rm, err = queue.Receive(context.Background())
 		is.NoErr(err)
-		is.Equal(nil, m)
+		is.Equal(nil, rm)
+	})
+}
+
+// throttlingHTTPClient answers SQS API calls like SQS would while throttling every call but GetQueueUrl,
+// counting the attempts made for each action.
+type throttlingHTTPClient struct {
+	mutex    sync.Mutex
+	attempts map[string]int
+	// block calls until their context is done instead of answering.
+	block bool
+	// hosts of the requests, in order.
+	hosts []string
+}
+
+func (c *throttlingHTTPClient) Do(r *http.Request) (*http.Response, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	action := r.PostForm.Get("Action")
+
+	c.mutex.Lock()
+	if c.attempts == nil {
+		c.attempts = map[string]int{}
+	}
+	c.attempts[action]++
+	c.hosts = append(c.hosts, r.URL.Host)
+	c.mutex.Unlock()
+
+	if action == "GetQueueUrl" {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body: io.NopCloser(strings.NewReader(`<GetQueueUrlResponse><GetQueueUrlResult>` +
+				`<QueueUrl>http://localhost/queue/jobs</QueueUrl></GetQueueUrlResult>` +
+				`<ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></GetQueueUrlResponse>`)),
+		}, nil
+	}
+
+	if c.block {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     http.Header{},
+		Body: io.NopCloser(strings.NewReader(`<ErrorResponse><Error><Type>Sender</Type>` +
+			`<Code>Throttling</Code><Message>Rate exceeded</Message></Error><RequestId>1</RequestId></ErrorResponse>`)),
+	}, nil
+}
+
+func (c *throttlingHTTPClient) Attempts(action string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.attempts[action]
+}
+
+type awsLogMock struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (l *awsLogMock) Logf(classification logging.Classification, format string, v ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, string(classification)+" "+fmt.Sprintf(format, v...))
+}
+
+func newThrottledQueue(c *throttlingHTTPClient, l *awsLogMock, opts messaging.NewQueueOptions) *messaging.Queue {
+	opts.Config = aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		HTTPClient:  c,
+		Logger:      l,
+	}
+	opts.EndpointURL = "http://localhost"
+	opts.Name = "jobs"
+	opts.MaxRetryBackoff = time.Millisecond
+	return messaging.NewQueue(opts)
+}
+
+func TestNewQueue(t *testing.T) {
+	newQueue := func(c *throttlingHTTPClient, endpointURL string) *messaging.Queue {
+		return messaging.NewQueue(messaging.NewQueueOptions{
+			Config: aws.Config{
+				Region:      "eu-west-1",
+				Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+				HTTPClient:  c,
+			},
+			EndpointURL:     endpointURL,
+			MaxRetryBackoff: time.Millisecond,
+			Name:            "jobs",
+		})
+	}
+
+	t.Run("uses the SQS endpoint of the region without an endpoint URL", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		_, err := newQueue(c, "").Depth(context.Background())
+		is.True(err != nil)
+		is.Equal("sqs.eu-west-1.amazonaws.com", c.hosts[0])
+	})
+
+	t.Run("looks up the queue URL by name once", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		q := newQueue(c, "http://localhost")
+		for i := 0; i < 2; i++ {
+			url, err := q.URL(context.Background())
+			is.NoErr(err)
+			is.Equal("http://localhost/queue/jobs", url)
+		}
+		is.Equal(1, c.Attempts("GetQueueUrl"))
+	})
+
+	t.Run("uses the endpoint URL if set", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		_, err := newQueue(c, "http://localhost:4566").Depth(context.Background())
+		is.True(err != nil)
+		is.Equal("localhost:4566", c.hosts[0])
+	})
+}
+
+func TestQueue_retries(t *testing.T) {
+	t.Run("retries throttled sends the configured number of times and logs retries at debug level", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		l := &awsLogMock{}
+		q := newThrottledQueue(c, l, messaging.NewQueueOptions{MaxRetries: 2})
+
+		err := q.Send(context.Background(), model.Message{"job": "test"})
+		is.True(err != nil)
+		is.Equal(3, c.Attempts("SendMessage"))
+
+		var retryLines int
+		for _, line := range l.lines {
+			if strings.HasPrefix(line, "DEBUG retrying request SQS/SendMessage") {
+				retryLines++
+			}
+		}
+		is.Equal(2, retryLines)
+	})
+
+	t.Run("retries throttled sends in adaptive mode", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		q := newThrottledQueue(c, &awsLogMock{}, messaging.NewQueueOptions{AdaptiveRetry: true, MaxRetries: 1})
+
+		result, err := q.SendBatch(context.Background(), []model.Message{{"job": "test"}})
+		is.NoErr(err)
+		is.Equal(1, len(result.Failed))
+		is.Equal(2, c.Attempts("SendMessageBatch"))
+	})
+
+	t.Run("does not retry receives", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{}
+		q := newThrottledQueue(c, &awsLogMock{}, messaging.NewQueueOptions{MaxRetries: 5})
+
+		_, err := q.Receive(context.Background())
+		is.True(err != nil)
+		is.Equal(1, c.Attempts("ReceiveMessage"))
+	})
+
+	t.Run("returns the context error if cancelled during a receive", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{block: true}
+		q := newThrottledQueue(c, &awsLogMock{}, messaging.NewQueueOptions{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := q.Receive(ctx)
+		is.True(errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("stops sending after the send timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &throttlingHTTPClient{block: true}
+		q := newThrottledQueue(c, &awsLogMock{}, messaging.NewQueueOptions{SendTimeout: 10 * time.Millisecond})
+
+		before := time.Now()
+		err := q.Send(context.Background(), model.Message{"job": "test"})
+		is.True(err != nil)
+		is.True(time.Since(before) < time.Second)
 	})
 }
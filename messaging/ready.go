@@ -0,0 +1,95 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrQueueUnreachable is when the SQS endpoint can't be reached, or can't answer yet, like while it's starting.
+	ErrQueueUnreachable = errors.New("queue endpoint is unreachable")
+	// ErrQueueNotFound is when the SQS endpoint answers, but the queue doesn't exist.
+	ErrQueueNotFound = errors.New("queue doesn't exist")
+)
+
+// WaitReadyOptions for Queue.WaitReady.
+type WaitReadyOptions struct {
+	// Create the queue with EnsureQueue when the endpoint says it doesn't exist, instead of waiting for it.
+	Create bool
+	// Delay before the first retry, doubled for each attempt up to 5 seconds. Defaults to 100ms.
+	Delay time.Duration
+	// Timeout of waiting, after which the last error is returned. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// WaitReady until the URL of the queue can be looked up, retrying with backoff while the endpoint is unreachable
+// or the queue doesn't exist, since a local SQS can come up after the app, and create its queues after that.
+// Other errors, like for missing permissions, aren't retried. It stops waiting when ctx is done.
+//
+// The errors are ErrQueueUnreachable or ErrQueueNotFound, if that's why the queue isn't ready.
+func (q *Queue) WaitReady(ctx context.Context, opts WaitReadyOptions) error {
+	if opts.Delay <= 0 {
+		opts.Delay = 100 * time.Millisecond
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	delay := opts.Delay
+	for attempt := 1; ; attempt++ {
+		// The SDK's own retries would wait up to its maximum backoff between attempts, so this does the waiting instead.
+		err := classifyQueueError(q.getQueueURL(ctx, withoutRetries))
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrQueueNotFound) && opts.Create:
+			q.log.Info("Creating queue, because it doesn't exist", zap.String("name", q.name))
+			return q.EnsureQueue(ctx)
+		case !errors.Is(err, ErrQueueUnreachable) && !errors.Is(err, ErrQueueNotFound):
+			return fmt.Errorf("error getting URL of queue %v: %w", q.name, err)
+		}
+
+		q.log.Info("Queue not ready, retrying", zap.String("name", q.name), zap.Int("attempt", attempt),
+			zap.Duration("delay", delay), zap.Error(err))
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("queue %v not ready after %v attempts: %w", q.name, attempt, err)
+		case <-t.C:
+		}
+		if delay *= 2; delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+	}
+}
+
+// classifyQueueError as ErrQueueUnreachable if the request couldn't be sent, or the endpoint answered with
+// a server error, and as ErrQueueNotFound if the queue doesn't exist. Other errors are returned as they are.
+func classifyQueueError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var notFound *types.QueueDoesNotExist
+	if errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %v", ErrQueueNotFound, err)
+	}
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) {
+		return fmt.Errorf("%w: %v", ErrQueueUnreachable, err)
+	}
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() >= 500 {
+		return fmt.Errorf("%w: %v", ErrQueueUnreachable, err)
+	}
+	return err
+}
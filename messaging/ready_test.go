@@ -0,0 +1,130 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+)
+
+// startingClientMock is a fake SQS client that fails GetQueueUrl with the scripted errors in order,
+// like a local SQS that's still starting, and then succeeds.
+type startingClientMock struct {
+	sqsClient
+	mutex   sync.Mutex
+	errs    []error
+	calls   int
+	created []string
+}
+
+func (c *startingClientMock) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func (c *startingClientMock) CreateQueue(ctx context.Context, params *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.created = append(c.created, *params.QueueName)
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func unreachable() error {
+	return &smithyhttp.RequestSendError{Err: errors.New("dial tcp 127.0.0.1:4566: connect: connection refused")}
+}
+
+func notFound() error {
+	return &types.QueueDoesNotExist{Message: aws.String("The specified queue does not exist.")}
+}
+
+func TestQueue_WaitReady(t *testing.T) {
+	newQueue := func(client *startingClientMock) *messaging.Queue {
+		return messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs"})
+	}
+
+	t.Run("retries until the endpoint is reachable and the queue exists", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &startingClientMock{errs: []error{unreachable(), unreachable(), notFound()}}
+		q := newQueue(client)
+
+		err := q.WaitReady(context.Background(), messaging.WaitReadyOptions{Delay: time.Millisecond})
+		is.NoErr(err)
+		is.Equal(4, client.calls)
+		is.Equal(0, len(client.created))
+
+		url, err := q.URL(context.Background())
+		is.NoErr(err)
+		is.Equal("http://localhost/queue/jobs", url)
+	})
+
+	t.Run("creates the queue if it doesn't exist and creation is enabled", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &startingClientMock{errs: []error{unreachable(), notFound()}}
+		err := newQueue(client).WaitReady(context.Background(), messaging.WaitReadyOptions{Create: true, Delay: time.Millisecond})
+		is.NoErr(err)
+		is.Equal(2, client.calls)
+		is.Equal([]string{"jobs"}, client.created)
+	})
+
+	t.Run("tells an unreachable endpoint from a missing queue after the timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		errs := make([]error, 1000)
+		for i := range errs {
+			errs[i] = unreachable()
+		}
+		err := newQueue(&startingClientMock{errs: errs}).WaitReady(context.Background(),
+			messaging.WaitReadyOptions{Delay: time.Millisecond, Timeout: 20 * time.Millisecond})
+		is.True(errors.Is(err, messaging.ErrQueueUnreachable))
+		is.True(!errors.Is(err, messaging.ErrQueueNotFound))
+
+		for i := range errs {
+			errs[i] = notFound()
+		}
+		err = newQueue(&startingClientMock{errs: errs}).WaitReady(context.Background(),
+			messaging.WaitReadyOptions{Delay: time.Millisecond, Timeout: 20 * time.Millisecond})
+		is.True(errors.Is(err, messaging.ErrQueueNotFound))
+		is.True(!errors.Is(err, messaging.ErrQueueUnreachable))
+	})
+
+	t.Run("doesn't retry other errors", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &startingClientMock{errs: []error{errors.New("access denied")}}
+		err := newQueue(client).WaitReady(context.Background(), messaging.WaitReadyOptions{Delay: time.Millisecond})
+		is.True(err != nil)
+		is.Equal(1, client.calls)
+	})
+
+	t.Run("stops waiting right away when the context is cancelled", func(t *testing.T) {
+		is := is.New(t)
+
+		client := &startingClientMock{errs: []error{unreachable(), unreachable()}}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		start := time.Now()
+		err := newQueue(client).WaitReady(ctx, messaging.WaitReadyOptions{Delay: time.Minute})
+		is.True(errors.Is(err, messaging.ErrQueueUnreachable))
+		is.True(time.Since(start) < time.Second)
+		is.Equal(1, client.calls)
+	})
+}
@@ -0,0 +1,139 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+// outbox is satisfied by *storage.Database, which claims the messages of RelayOutboxMessages,
+// so they're published by only one relay at a time.
+type outbox interface {
+	RelayOutboxMessages(ctx context.Context, limit int, publish func(model.OutboxMessage) error) (int, error)
+	DeleteSentOutboxMessages(ctx context.Context, before time.Time) (int64, error)
+}
+
+type sender interface {
+	Send(ctx context.Context, m model.Message) error
+}
+
+// Relay publishes messages from the outbox to the queue, and domain events to the events queue too, if there is one.
+// Delivery is at-least-once: a message published just before a crash, but not yet marked as sent,
+// is published again on the next run. Relays on several replicas share the outbox, each claiming its own batches.
+type Relay struct {
+	batchSize       int
+	cleanupInterval time.Duration
+	events          sender
+	interval        time.Duration
+	log             *zap.Logger
+	outbox          outbox
+	queue           sender
+	retention       time.Duration
+}
+
+// NewRelayOptions for NewRelay.
+type NewRelayOptions struct {
+	// BatchSize is the maximum number of messages published per run. Defaults to 10.
+	BatchSize int
+	// CleanupInterval is how often sent messages older than Retention are deleted. Defaults to an hour.
+	CleanupInterval time.Duration
+	// Events receives the domain events from the outbox, with the event type in EventTypeAttribute,
+	// for other systems to consume. They still go to Queue too, for the jobs in this app that consume them.
+	// Without it, events only go to Queue.
+	Events sender
+	// Interval between runs when the outbox is empty. Defaults to a second.
+	Interval time.Duration
+	Log      *zap.Logger
+	Outbox   outbox
+	Queue    sender
+	// Retention of sent messages in the outbox. Defaults to a week.
+	Retention time.Duration
+}
+
+// NewRelay with the given options.
+// If no logger is provided, logs are discarded.
+func NewRelay(opts NewRelayOptions) *Relay {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 10
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Hour
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	return &Relay{
+		batchSize:       opts.BatchSize,
+		cleanupInterval: opts.CleanupInterval,
+		events:          opts.Events,
+		interval:        opts.Interval,
+		log:             opts.Log,
+		outbox:          opts.Outbox,
+		queue:           opts.Queue,
+		retention:       opts.Retention,
+	}
+}
+
+// Start relaying messages, blocking until ctx is cancelled.
+func (r *Relay) Start(ctx context.Context) {
+	r.log.Info("Starting outbox relay")
+
+	lastCleanup := time.Now()
+	for {
+		n, err := r.Relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.log.Info("Error relaying outbox messages", zap.Error(err))
+		}
+
+		if time.Since(lastCleanup) >= r.cleanupInterval {
+			r.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+
+		// Keep going right away if the batch was full, there are probably more messages.
+		if err == nil && n == r.batchSize {
+			continue
+		}
+
+		t := time.NewTimer(r.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			r.log.Info("Stopping outbox relay")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Relay one batch of unsent messages from the outbox to the queue, in order.
+// Stops at the first message that can't be published, so it's retried first on the next run.
+// Returns the number of messages published.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	return r.outbox.RelayOutboxMessages(ctx, r.batchSize, func(m model.OutboxMessage) error {
+		if eventType := EventType(m.Message); eventType != "" && r.events != nil {
+			if err := r.events.Send(WithAttribute(ctx, EventTypeAttribute, eventType), m.Message); err != nil {
+				return err
+			}
+		}
+		return r.queue.Send(ctx, m.Message)
+	})
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	n, err := r.outbox.DeleteSentOutboxMessages(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		r.log.Info("Error deleting sent outbox messages", zap.Error(err))
+		return
+	}
+	r.log.Debug("Deleted sent outbox messages", zap.Int64("count", n))
+}
@@ -0,0 +1,264 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// outboxMock claims messages like storage.Database, so a message claimed by one relay is skipped by the others
+// until it's published or released.
+type outboxMock struct {
+	mutex    sync.Mutex
+	messages []model.OutboxMessage
+	claimed  map[int64]bool
+	sent     map[int64]time.Time
+}
+
+func (o *outboxMock) RelayOutboxMessages(ctx context.Context, limit int, publish func(model.OutboxMessage) error) (int, error) {
+	ms := o.claim(limit)
+	defer o.release(ms)
+
+	var n int
+	for _, m := range ms {
+		if err := publish(m); err != nil {
+			return n, err
+		}
+		o.mutex.Lock()
+		o.sent[m.ID] = time.Now()
+		o.mutex.Unlock()
+		n++
+	}
+	return n, nil
+}
+
+func (o *outboxMock) claim(limit int) []model.OutboxMessage {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.claimed == nil {
+		o.claimed = map[int64]bool{}
+	}
+	var ms []model.OutboxMessage
+	for _, m := range o.messages {
+		if _, ok := o.sent[m.ID]; ok || o.claimed[m.ID] {
+			continue
+		}
+		o.claimed[m.ID] = true
+		ms = append(ms, m)
+		if len(ms) == limit {
+			break
+		}
+	}
+	return ms
+}
+
+func (o *outboxMock) release(ms []model.OutboxMessage) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, m := range ms {
+		delete(o.claimed, m.ID)
+	}
+}
+
+func (o *outboxMock) DeleteSentOutboxMessages(ctx context.Context, before time.Time) (int64, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	var n int64
+	var kept []model.OutboxMessage
+	for _, m := range o.messages {
+		if sent, ok := o.sent[m.ID]; ok && sent.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, m)
+	}
+	o.messages = kept
+	return n, nil
+}
+
+// flakySender fails the first failures sends.
+type flakySender struct {
+	mutex    sync.Mutex
+	failures int
+	messages []model.Message
+}
+
+func (s *flakySender) Send(ctx context.Context, m model.Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("queue unavailable")
+	}
+	s.messages = append(s.messages, m)
+	return nil
+}
+
+func TestRelay_Relay(t *testing.T) {
+	t.Run("publishes unsent messages in order and marks them sent", func(t *testing.T) {
+		is := is.New(t)
+
+		o := newOutboxMock(3)
+		s := &flakySender{}
+		r := messaging.NewRelay(messaging.NewRelayOptions{Outbox: o, Queue: s})
+
+		n, err := r.Relay(context.Background())
+		is.NoErr(err)
+		is.Equal(3, n)
+		is.Equal([]model.Message{{"n": "1"}, {"n": "2"}, {"n": "3"}}, s.messages)
+
+		n, err = r.Relay(context.Background())
+		is.NoErr(err)
+		is.Equal(0, n)
+	})
+
+	t.Run("eventually delivers messages that failed to publish after commit", func(t *testing.T) {
+		is := is.New(t)
+
+		o := newOutboxMock(2)
+		s := &flakySender{failures: 2}
+		r := messaging.NewRelay(messaging.NewRelayOptions{Outbox: o, Queue: s})
+
+		_, err := r.Relay(context.Background())
+		is.True(err != nil)
+		_, err = r.Relay(context.Background())
+		is.True(err != nil)
+		is.Equal(0, len(s.messages))
+
+		n, err := r.Relay(context.Background())
+		is.NoErr(err)
+		is.Equal(2, n)
+		is.Equal([]model.Message{{"n": "1"}, {"n": "2"}}, s.messages)
+	})
+
+	t.Run("publishes domain events to the events queue too, with the event type attribute", func(t *testing.T) {
+		is := is.New(t)
+
+		event, err := messaging.NewEvent(model.SubscriberSignedUp{Email: "me@example.com"}, time.Now())
+		is.NoErr(err)
+		o := &outboxMock{sent: map[int64]time.Time{}, messages: []model.OutboxMessage{
+			{ID: 1, Message: model.Message{"job": "confirmation_email"}},
+			{ID: 2, Message: event},
+		}}
+		s := &flakySender{}
+		events := messaging.NewMemoryQueue(0)
+		r := messaging.NewRelay(messaging.NewRelayOptions{Events: events, Outbox: o, Queue: s})
+
+		n, err := r.Relay(context.Background())
+		is.NoErr(err)
+		is.Equal(2, n)
+		is.Equal([]model.Message{{"job": "confirmation_email"}, event}, s.messages)
+
+		received, err := events.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal(event, received.Message)
+		is.Equal(model.EventSubscriberSignedUp, received.Attributes[messaging.EventTypeAttribute])
+		received, err = events.Receive(context.Background())
+		is.NoErr(err)
+		is.True(received == nil)
+	})
+
+	t.Run("publishes each message once with relays sharing the outbox", func(t *testing.T) {
+		is := is.New(t)
+
+		o := newOutboxMock(20)
+		s := &flakySender{}
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			r := messaging.NewRelay(messaging.NewRelayOptions{BatchSize: 2, Outbox: o, Queue: s})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					n, err := r.Relay(context.Background())
+					if err != nil || n == 0 {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		is.Equal(20, len(s.messages))
+		seen := map[string]bool{}
+		for _, m := range s.messages {
+			is.True(!seen[m["n"]])
+			seen[m["n"]] = true
+		}
+	})
+
+	t.Run("respects the batch size", func(t *testing.T) {
+		is := is.New(t)
+
+		o := newOutboxMock(3)
+		s := &flakySender{}
+		r := messaging.NewRelay(messaging.NewRelayOptions{BatchSize: 2, Outbox: o, Queue: s})
+
+		n, err := r.Relay(context.Background())
+		is.NoErr(err)
+		is.Equal(2, n)
+	})
+}
+
+func TestRelay_Start(t *testing.T) {
+	t.Run("keeps relaying until the queue accepts messages, and cleans up sent messages", func(t *testing.T) {
+		is := is.New(t)
+
+		o := newOutboxMock(1)
+		s := &flakySender{failures: 3}
+		r := messaging.NewRelay(messaging.NewRelayOptions{
+			CleanupInterval: time.Millisecond,
+			Interval:        time.Millisecond,
+			Outbox:          o,
+			Queue:           s,
+			Retention:       time.Nanosecond,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Start(ctx)
+			close(done)
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			o.mutex.Lock()
+			empty := len(o.messages) == 0
+			o.mutex.Unlock()
+			if empty {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("outbox was not emptied")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		is.Equal([]model.Message{{"n": "1"}}, s.messages)
+	})
+}
+
+func newOutboxMock(n int) *outboxMock {
+	o := &outboxMock{sent: map[int64]time.Time{}}
+	for i := 1; i <= n; i++ {
+		o.messages = append(o.messages, model.OutboxMessage{
+			ID:      int64(i),
+			Message: model.Message{"n": string(rune('0' + i))},
+		})
+	}
+	return o
+}
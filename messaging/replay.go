@@ -0,0 +1,152 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+// ReplayedAttribute is the message attribute marking a message replayed from the dead-letter queue,
+// with the time it was replayed in RFC 3339 format. Jobs can use it to be extra careful about idempotency.
+const ReplayedAttribute = "canvas-replayed"
+
+// replayNackTimeout for making each kept message visible again, after the scan is done.
+const replayNackTimeout = 10 * time.Second
+
+type replaySource interface {
+	Receive(ctx context.Context) (*Received, error)
+	Delete(ctx context.Context, receiptID string) error
+	Nack(ctx context.Context, receiptID string, delay time.Duration) error
+}
+
+// ReplayDLQOptions for ReplayDLQ.
+type ReplayDLQOptions struct {
+	DeadLetterQueue replaySource
+	// Job replays only messages for the job with this name, if set.
+	Job string
+	// Limit on the number of messages to replay. No limit if zero.
+	Limit int
+	Log   *zap.Logger
+	// Match replays only messages it returns true for, if set. Use DecodeMessage in it to match on the payload.
+	Match func(m model.Message) bool
+	// MaxAge replays only messages sent at most this long ago, if set.
+	MaxAge time.Duration
+	// MinAge replays only messages sent at least this long ago, if set.
+	MinAge time.Duration
+	// Now is for the age of messages and the time they're replayed at. Defaults to time.Now.
+	Now   func() time.Time
+	Queue sender
+}
+
+// ReplayResult counts what ReplayDLQ did with the messages it scanned.
+type ReplayResult struct {
+	Replayed int
+	Skipped  int
+	Failed   int
+}
+
+// ReplayDLQ scans the dead-letter queue and sends the messages matching all the filters in opts back to the queue,
+// marked with ReplayedAttribute and with their original trace context and request ID.
+// A message is deleted from the dead-letter queue only after it's been sent, so failed replays stay dead-lettered.
+// Skipped and failed messages are made visible again in the dead-letter queue when the scan is done.
+// The scan ends when the dead-letter queue has no more messages to receive, or when Limit is reached.
+func ReplayDLQ(ctx context.Context, opts ReplayDLQOptions) (ReplayResult, error) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	var result ReplayResult
+	var keep []string
+	seen := map[string]bool{}
+
+	// Make skipped and failed messages visible again, even if the scan stops early, such as when ctx is cancelled.
+	defer func() {
+		for _, receiptID := range keep {
+			nackCtx, cancel := context.WithTimeout(context.Background(), replayNackTimeout)
+			if err := opts.DeadLetterQueue.Nack(nackCtx, receiptID, 0); err != nil {
+				opts.Log.Info("Error making message visible again in dead-letter queue", zap.Error(err))
+			}
+			cancel()
+		}
+	}()
+
+	for opts.Limit == 0 || result.Replayed < opts.Limit {
+		rm, err := opts.DeadLetterQueue.Receive(ctx)
+		var decodeErr *DecodeError
+		if err != nil && !(errors.As(err, &decodeErr) && rm != nil) {
+			return result, err
+		}
+		if rm == nil {
+			break
+		}
+		// A message received again means the scan went through the whole queue, and the handle must be released too.
+		if seen[rm.ID] {
+			keep = append(keep, rm.ReceiptID)
+			break
+		}
+		seen[rm.ID] = true
+
+		// Messages that can't be decoded would only be quarantined again.
+		if decodeErr != nil {
+			result.Skipped++
+			keep = append(keep, rm.ReceiptID)
+			continue
+		}
+
+		log := opts.Log.With(zap.String("messageID", rm.ID), zap.String("name", rm.Message["job"]))
+
+		if !opts.matches(rm) {
+			result.Skipped++
+			keep = append(keep, rm.ReceiptID)
+			continue
+		}
+
+		replayCtx := ContextWithAttributes(ctx, rm.Attributes)
+		replayCtx = WithAttribute(replayCtx, ReplayedAttribute, opts.Now().UTC().Format(time.RFC3339))
+		if err := opts.Queue.Send(replayCtx, rm.Message); err != nil {
+			log.Info("Error replaying message", zap.Error(err))
+			result.Failed++
+			keep = append(keep, rm.ReceiptID)
+			continue
+		}
+		result.Replayed++
+
+		if err := opts.DeadLetterQueue.Delete(ctx, rm.ReceiptID); err != nil {
+			log.Info("Error deleting replayed message from dead-letter queue, it may be replayed again", zap.Error(err))
+		}
+	}
+
+	opts.Log.Info("Replayed dead-lettered messages",
+		zap.Int("replayed", result.Replayed), zap.Int("skipped", result.Skipped), zap.Int("failed", result.Failed))
+	return result, nil
+}
+
+// matches is true if the received message passes all the filters in opts.
+func (opts ReplayDLQOptions) matches(rm *Received) bool {
+	if opts.Job != "" && rm.Message["job"] != opts.Job {
+		return false
+	}
+	if opts.MinAge > 0 || opts.MaxAge > 0 {
+		if rm.Sent.IsZero() {
+			return false
+		}
+		age := opts.Now().Sub(rm.Sent)
+		if opts.MinAge > 0 && age < opts.MinAge {
+			return false
+		}
+		if opts.MaxAge > 0 && age > opts.MaxAge {
+			return false
+		}
+	}
+	if opts.Match != nil && !opts.Match(rm.Message) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,282 @@
+package messaging_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// dlqClientMock is a fake SQS client for a dead-letter queue seeded with messages.
+// Received messages are invisible until deleted or made visible again.
+// With redeliver, received messages stay visible, like with a visibility timeout that's already over.
+// Receipt handles are different for every receive, like in SQS, and the ones made visible again are in released.
+type dlqClientMock struct {
+	sqsClient
+	messages  []dlqMessage
+	receives  int
+	redeliver bool
+	released  []string
+}
+
+type dlqMessage struct {
+	body model.Message
+	// rawBody is received instead of body, if set.
+	rawBody   string
+	sent      time.Time
+	invisible bool
+	deleted   bool
+}
+
+func (c *dlqClientMock) GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("http://localhost/queue/" + *params.QueueName)}, nil
+}
+
+func (c *dlqClientMock) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, m := range c.messages {
+		if m.invisible || m.deleted {
+			continue
+		}
+		c.messages[i].invisible = !c.redeliver
+		c.receives++
+
+		body, err := json.Marshal(m.body)
+		if err != nil {
+			return nil, err
+		}
+		if m.rawBody != "" {
+			body = []byte(m.rawBody)
+		}
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{{
+			Attributes:    map[string]string{"SentTimestamp": strconv.FormatInt(m.sent.UnixMilli(), 10)},
+			Body:          aws.String(string(body)),
+			MessageId:     aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("%v-%v", i, c.receives)),
+		}}}, nil
+	}
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (c *dlqClientMock) DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	c.messages[messageIndex(*params.ReceiptHandle)].deleted = true
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (c *dlqClientMock) ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.messages[messageIndex(*params.ReceiptHandle)].invisible = params.VisibilityTimeout > 0
+	if params.VisibilityTimeout == 0 {
+		c.released = append(c.released, *params.ReceiptHandle)
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// messageIndex in the messages of the mock, from the receipt handle.
+func messageIndex(receiptHandle string) int {
+	i, _, _ := strings.Cut(receiptHandle, "-")
+	index, _ := strconv.Atoi(i)
+	return index
+}
+
+func (c *dlqClientMock) remaining() []model.Message {
+	var ms []model.Message
+	for _, m := range c.messages {
+		if !m.deleted {
+			ms = append(ms, m.body)
+		}
+	}
+	return ms
+}
+
+// cancellingSender sends messages to the queue, and cancels the context after the first.
+type cancellingSender struct {
+	cancel context.CancelFunc
+	queue  *messaging.MemoryQueue
+}
+
+func (s *cancellingSender) Send(ctx context.Context, m model.Message) error {
+	defer s.cancel()
+	return s.queue.Send(ctx, m)
+}
+
+// failingSender fails sending messages for the given job.
+type failingSender struct {
+	queue *messaging.MemoryQueue
+	job   string
+}
+
+func (s *failingSender) Send(ctx context.Context, m model.Message) error {
+	if m["job"] == s.job {
+		return errors.New("oh no")
+	}
+	return s.queue.Send(ctx, m)
+}
+
+func TestReplayDLQ(t *testing.T) {
+	now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+
+	newDLQ := func() *dlqClientMock {
+		return &dlqClientMock{messages: []dlqMessage{
+			{body: model.Message{"job": "confirmation_email", "email": "a@example.com", "token": "1"}, sent: now.Add(-2 * time.Hour)},
+			{body: model.Message{"job": "newsletter_issue_email", "email": "b@example.com", "newsletterID": "1"}, sent: now.Add(-2 * time.Hour)},
+			{body: model.Message{"job": "confirmation_email", "email": "c@example.com", "token": "2"}, sent: now.Add(-10 * time.Minute)},
+			{body: model.Message{"job": "confirmation_email", "email": "d@example.com", "token": "3"}, sent: now.Add(-72 * time.Hour)},
+		}}
+	}
+
+	t.Run("replays messages for a job within an age range and keeps the rest", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Job:             "confirmation_email",
+			MaxAge:          24 * time.Hour,
+			MinAge:          time.Hour,
+			Now:             func() time.Time { return now },
+			Queue:           queue,
+		})
+		is.NoErr(err)
+		is.Equal(messaging.ReplayResult{Replayed: 1, Skipped: 3}, result)
+
+		rm, err := queue.Receive(context.Background())
+		is.NoErr(err)
+		is.Equal("a@example.com", rm.Message["email"])
+		is.Equal("2022-12-10T12:00:00Z", rm.Attributes[messaging.ReplayedAttribute])
+
+		is.Equal(3, len(client.remaining()))
+		for _, m := range client.messages {
+			is.True(m.deleted || !m.invisible)
+		}
+	})
+
+	t.Run("replays messages matching a predicate on the decoded payload", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Match: func(m model.Message) bool {
+				p, err := messaging.DecodeMessage[model.ConfirmationEmailRequested](m)
+				return err == nil && p.Token != "1"
+			},
+			Queue: queue,
+		})
+		is.NoErr(err)
+		is.Equal(messaging.ReplayResult{Replayed: 2, Skipped: 2}, result)
+		is.Equal(2, queue.Len())
+	})
+
+	t.Run("keeps messages that fail to replay in the dead-letter queue", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Queue:           &failingSender{queue: queue, job: "newsletter_issue_email"},
+		})
+		is.NoErr(err)
+		is.Equal(messaging.ReplayResult{Replayed: 3, Failed: 1}, result)
+		is.Equal([]model.Message{{"job": "newsletter_issue_email", "email": "b@example.com", "newsletterID": "1"}}, client.remaining())
+	})
+
+	t.Run("keeps messages whose body can't be decoded in the dead-letter queue", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		client.messages[1].rawBody = "not json"
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Queue:           queue,
+		})
+		is.NoErr(err)
+		is.Equal(messaging.ReplayResult{Replayed: 3, Skipped: 1}, result)
+		is.Equal(1, len(client.remaining()))
+		is.True(!client.messages[1].invisible)
+	})
+
+	t.Run("stops at the limit", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Limit:           2,
+			Queue:           queue,
+		})
+		is.NoErr(err)
+		is.Equal(2, result.Replayed)
+		is.Equal(2, len(client.remaining()))
+	})
+	t.Run("makes skipped messages visible again when the context is cancelled", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		_, err := messaging.ReplayDLQ(ctx, messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Job:             "newsletter_issue_email",
+			Queue:           &cancellingSender{cancel: cancel, queue: queue},
+		})
+		is.True(errors.Is(err, context.Canceled))
+		is.Equal(1, queue.Len())
+		is.Equal([]string{"0-1"}, client.released)
+		for _, m := range client.messages {
+			is.True(m.deleted || !m.invisible)
+		}
+	})
+
+	t.Run("makes a message received again visible again when the scan ends", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newDLQ()
+		client.redeliver = true
+		dlq := messaging.NewQueue(messaging.NewQueueOptions{Client: client, Name: "jobs-dead-letter"})
+		queue := messaging.NewMemoryQueue(time.Millisecond)
+
+		result, err := messaging.ReplayDLQ(context.Background(), messaging.ReplayDLQOptions{
+			DeadLetterQueue: dlq,
+			Job:             "newsletter_issue_email",
+			Queue:           queue,
+		})
+		is.NoErr(err)
+		is.Equal(messaging.ReplayResult{Skipped: 1}, result)
+		is.Equal([]string{"0-1", "0-2"}, client.released)
+	})
+}
@@ -0,0 +1,33 @@
+package messaging
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryableError for a job that failed because of something that passes, like being throttled by the email provider.
+// The job runner returns its message to the queue to be received again after Delay, instead of reporting the error.
+type RetryableError struct {
+	Err error
+	// Delay before the message can be received again. If it's zero, the runner's default delay is used.
+	Delay time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable wraps err in a RetryableError with the delay.
+func Retryable(err error, delay time.Duration) error {
+	return &RetryableError{Err: err, Delay: delay}
+}
+
+// IsRetryable is true if err is or wraps a RetryableError.
+func IsRetryable(err error) bool {
+	var re *RetryableError
+	return errors.As(err, &re)
+}
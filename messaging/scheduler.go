@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+
+	"canvas/model"
+)
+
+// Schedule for a recurring job. Expression is a standard five-field cron expression, evaluated in UTC.
+type Schedule struct {
+	Name       string
+	Expression string
+	Message    model.Message
+}
+
+type scheduleStore interface {
+	GetScheduleLastRun(ctx context.Context, name string) (time.Time, error)
+	FireSchedule(ctx context.Context, name string, at time.Time, m model.Message) (bool, error)
+}
+
+type parsedSchedule struct {
+	Schedule
+	cron cron.Schedule
+}
+
+// Scheduler enqueues the messages of recurring schedules when they're due.
+// The store records each schedule's last run and makes sure only one replica fires a given run,
+// so restarts and concurrent replicas don't cause double-fires.
+type Scheduler struct {
+	interval    time.Duration
+	log         *zap.Logger
+	now         func() time.Time
+	runMisfired bool
+	schedules   []parsedSchedule
+	startedAt   time.Time
+	store       scheduleStore
+}
+
+// NewSchedulerOptions for NewScheduler.
+type NewSchedulerOptions struct {
+	// Interval between checks for due schedules. Defaults to 10 seconds.
+	Interval time.Duration
+	Log      *zap.Logger
+	// Now is for finding due schedules and their next runs. Defaults to time.Now.
+	Now func() time.Time
+	// RunMisfired runs a schedule once on startup if one or more runs were missed while the app was down.
+	RunMisfired bool
+	Schedules   []Schedule
+	Store       scheduleStore
+}
+
+// NewScheduler with the given options, returning an error if any of the cron expressions are invalid.
+// If no logger is provided, logs are discarded.
+func NewScheduler(opts NewSchedulerOptions) (*Scheduler, error) {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	var schedules []parsedSchedule
+	names := map[string]bool{}
+	for _, s := range opts.Schedules {
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate schedule name %v", s.Name)
+		}
+		names[s.Name] = true
+
+		c, err := cron.ParseStandard(s.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cron expression %q of schedule %v: %w", s.Expression, s.Name, err)
+		}
+		schedules = append(schedules, parsedSchedule{Schedule: s, cron: c})
+	}
+
+	return &Scheduler{
+		interval:    opts.Interval,
+		log:         opts.Log,
+		now:         opts.Now,
+		runMisfired: opts.RunMisfired,
+		schedules:   schedules,
+		startedAt:   opts.Now().UTC(),
+		store:       opts.Store,
+	}, nil
+}
+
+// NextRuns of all schedules after the current time, by schedule name.
+func (s *Scheduler) NextRuns() map[string]time.Time {
+	now := s.now().UTC()
+	next := map[string]time.Time{}
+	for _, ps := range s.schedules {
+		next[ps.Name] = ps.cron.Next(now)
+	}
+	return next
+}
+
+// Start checking for due schedules, blocking until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		s.Tick(ctx)
+
+		t := time.NewTimer(s.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			s.log.Info("Stopping scheduler")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick fires every schedule that is due at the current time.
+// If several runs of the same schedule are due, such as after downtime, it fires only once.
+func (s *Scheduler) Tick(ctx context.Context) {
+	now := s.now().UTC()
+
+	for _, ps := range s.schedules {
+		log := s.log.With(zap.String("name", ps.Name))
+
+		lastRun, err := s.store.GetScheduleLastRun(ctx, ps.Name)
+		if err != nil {
+			log.Info("Error getting schedule last run", zap.Error(err))
+			continue
+		}
+
+		due, ok := s.due(ps, lastRun, now)
+		if !ok {
+			continue
+		}
+
+		fired, err := s.store.FireSchedule(ctx, ps.Name, due, ps.Message)
+		if err != nil {
+			log.Info("Error firing schedule", zap.Error(err))
+			continue
+		}
+		if fired {
+			log.Info("Fired schedule", zap.Time("at", due), zap.Time("next", ps.cron.Next(now)))
+		}
+	}
+}
+
+// due returns the latest run of the schedule after lastRun and at or before now, if any.
+// Runs missed before the scheduler started only count if runMisfired is set.
+func (s *Scheduler) due(ps parsedSchedule, lastRun, now time.Time) (time.Time, bool) {
+	after := lastRun
+	if after.IsZero() || (!s.runMisfired && after.Before(s.startedAt)) {
+		after = s.startedAt
+	}
+
+	due := ps.cron.Next(after)
+	if due.After(now) {
+		return time.Time{}, false
+	}
+	for next := ps.cron.Next(due); !next.After(now); next = ps.cron.Next(next) {
+		due = next
+	}
+	return due, true
+}
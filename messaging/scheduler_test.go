@@ -0,0 +1,238 @@
+package messaging_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// scheduleStoreMock behaves like the database: firing is guarded by a lock that concurrent callers fail to get,
+// and runs at or before the recorded last run are skipped.
+type scheduleStoreMock struct {
+	lock     sync.Mutex
+	mutex    sync.Mutex
+	lastRuns map[string]time.Time
+	fired    []model.Message
+	// firing is called while holding the lock, to hold it open in contention tests.
+	firing func()
+	// contended is marked done for every caller that doesn't get the lock.
+	contended sync.WaitGroup
+}
+
+func newScheduleStoreMock() *scheduleStoreMock {
+	return &scheduleStoreMock{lastRuns: map[string]time.Time{}}
+}
+
+func (s *scheduleStoreMock) GetScheduleLastRun(ctx context.Context, name string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastRuns[name], nil
+}
+
+func (s *scheduleStoreMock) FireSchedule(ctx context.Context, name string, at time.Time, m model.Message) (bool, error) {
+	if !s.lock.TryLock() {
+		s.contended.Done()
+		return false, nil
+	}
+	defer s.lock.Unlock()
+
+	if s.firing != nil {
+		s.firing()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.lastRuns[name].Before(at) {
+		return false, nil
+	}
+	s.lastRuns[name] = at
+	s.fired = append(s.fired, m)
+	return true, nil
+}
+
+type clock struct {
+	mutex sync.Mutex
+	t     time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.t
+}
+
+func (c *clock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.t = t
+}
+
+func TestNewScheduler(t *testing.T) {
+	t.Run("errors on invalid cron expressions", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Schedules: []messaging.Schedule{{Name: "digest", Expression: "every monday"}},
+			Store:     newScheduleStoreMock(),
+		})
+		is.True(err != nil)
+	})
+
+	t.Run("errors on duplicate names", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Schedules: []messaging.Schedule{
+				{Name: "digest", Expression: "0 9 * * 1"},
+				{Name: "digest", Expression: "0 3 * * *"},
+			},
+			Store: newScheduleStoreMock(),
+		})
+		is.True(err != nil)
+	})
+}
+
+func TestScheduler_Tick(t *testing.T) {
+	// Monday 2022-12-05 is used as the starting point.
+	start := time.Date(2022, 12, 5, 8, 0, 0, 0, time.UTC)
+	digest := messaging.Schedule{Name: "digest", Expression: "0 9 * * 1", Message: model.Message{"job": "send_digest"}}
+	cleanup := messaging.Schedule{Name: "cleanup", Expression: "0 3 * * *", Message: model.Message{"job": "cleanup_tokens"}}
+
+	t.Run("fires schedules as the clock passes their run times, once per run", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &clock{t: start}
+		store := newScheduleStoreMock()
+		s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Now:       c.Now,
+			Schedules: []messaging.Schedule{digest, cleanup},
+			Store:     store,
+		})
+		is.NoErr(err)
+
+		s.Tick(context.Background())
+		is.Equal(0, len(store.fired))
+
+		c.Set(start.Add(time.Hour))
+		s.Tick(context.Background())
+		s.Tick(context.Background())
+		is.Equal([]model.Message{{"job": "send_digest"}}, store.fired)
+
+		c.Set(time.Date(2022, 12, 6, 3, 0, 30, 0, time.UTC))
+		s.Tick(context.Background())
+		is.Equal([]model.Message{{"job": "send_digest"}, {"job": "cleanup_tokens"}}, store.fired)
+
+		is.Equal(time.Date(2022, 12, 12, 9, 0, 0, 0, time.UTC), s.NextRuns()["digest"])
+		is.Equal(time.Date(2022, 12, 7, 3, 0, 0, 0, time.UTC), s.NextRuns()["cleanup"])
+	})
+
+	t.Run("does not fire again after a restart", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &clock{t: start.Add(time.Hour)}
+		store := newScheduleStoreMock()
+		store.lastRuns["digest"] = time.Date(2022, 12, 5, 9, 0, 0, 0, time.UTC)
+
+		s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Now:         c.Now,
+			RunMisfired: true,
+			Schedules:   []messaging.Schedule{digest},
+			Store:       store,
+		})
+		is.NoErr(err)
+
+		s.Tick(context.Background())
+		is.Equal(0, len(store.fired))
+	})
+
+	t.Run("runs misfired schedules once on startup if enabled", func(t *testing.T) {
+		is := is.New(t)
+
+		// The app was down for three weeks, missing three digests.
+		c := &clock{t: start.Add(21 * 24 * time.Hour)}
+		store := newScheduleStoreMock()
+		store.lastRuns["digest"] = time.Date(2022, 11, 28, 9, 0, 0, 0, time.UTC)
+
+		s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Now:         c.Now,
+			RunMisfired: true,
+			Schedules:   []messaging.Schedule{digest},
+			Store:       store,
+		})
+		is.NoErr(err)
+
+		s.Tick(context.Background())
+		s.Tick(context.Background())
+		is.Equal(1, len(store.fired))
+		is.Equal(time.Date(2022, 12, 19, 9, 0, 0, 0, time.UTC), store.lastRuns["digest"])
+	})
+
+	t.Run("skips misfired schedules on startup if not enabled", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &clock{t: start.Add(21 * 24 * time.Hour)}
+		store := newScheduleStoreMock()
+		store.lastRuns["digest"] = time.Date(2022, 11, 28, 9, 0, 0, 0, time.UTC)
+
+		s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+			Now:       c.Now,
+			Schedules: []messaging.Schedule{digest},
+			Store:     store,
+		})
+		is.NoErr(err)
+
+		s.Tick(context.Background())
+		is.Equal(0, len(store.fired))
+
+		c.Set(start.Add(28*24*time.Hour + time.Hour))
+		s.Tick(context.Background())
+		is.Equal(1, len(store.fired))
+	})
+
+	t.Run("fires only once across concurrent replicas", func(t *testing.T) {
+		is := is.New(t)
+
+		c := &clock{t: start}
+		store := newScheduleStoreMock()
+
+		var replicas []*messaging.Scheduler
+		for i := 0; i < 5; i++ {
+			s, err := messaging.NewScheduler(messaging.NewSchedulerOptions{
+				Now:       c.Now,
+				Schedules: []messaging.Schedule{digest},
+				Store:     store,
+			})
+			is.NoErr(err)
+			replicas = append(replicas, s)
+		}
+
+		// The replica that gets the lock holds it until every other replica has tried to fire.
+		store.contended.Add(len(replicas) - 1)
+		store.firing = store.contended.Wait
+
+		c.Set(start.Add(time.Hour))
+
+		var wg sync.WaitGroup
+		for _, s := range replicas {
+			wg.Add(1)
+			go func(s *messaging.Scheduler) {
+				defer wg.Done()
+				s.Tick(context.Background())
+			}(s)
+		}
+		wg.Wait()
+		is.Equal([]model.Message{{"job": "send_digest"}}, store.fired)
+
+		store.firing = nil
+		for _, s := range replicas {
+			s.Tick(context.Background())
+		}
+		is.Equal(1, len(store.fired))
+	})
+}
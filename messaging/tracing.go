@@ -0,0 +1,39 @@
+package messaging
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/tracing"
+)
+
+// messageCountKey of the span attribute with the number of messages sent in one call.
+const messageCountKey = attribute.Key("messaging.batch.message_count")
+
+// startSendSpan of a producer span for sending count messages, in the trace of ctx, if any.
+// The messages are sent with the context of the span, so the jobs that run them continue the trace from it.
+func (q *Queue) startSendSpan(ctx context.Context, count int) (context.Context, trace.Span) {
+	// Without a trace, there's nothing to name, so nothing is allocated.
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return tracing.StartChild(ctx, "canvas/messaging", "")
+	}
+	return tracing.StartChild(ctx, "canvas/messaging", q.name+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("aws_sqs"),
+			semconv.MessagingDestinationKey.String(q.name),
+			semconv.MessagingDestinationKindQueue,
+			messageCountKey.Int(count),
+		))
+}
+
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxPutData is the most metric data in a PutMetricData request.
+const maxPutData = 1000
+
+// CloudWatch puts metrics with the PutMetricData API.
+// Requests are retried by the SDK client, with the retryer of the AWS config.
+type CloudWatch struct {
+	client *cloudwatch.Client
+}
+
+// NewCloudWatch with the credentials and region of the AWS config.
+// The endpoint URL overrides the default endpoint of the region, if not empty.
+func NewCloudWatch(config aws.Config, endpointURL string) *CloudWatch {
+	return &CloudWatch{client: cloudwatch.NewFromConfig(config, func(o *cloudwatch.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})}
+}
+
+// put the data in the namespace, in requests of up to maxPutData each.
+func (c *CloudWatch) put(ctx context.Context, namespace string, timestamp time.Time, data []datum) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxPutData {
+			n = maxPutData
+		}
+		if _, err := c.client.PutMetricData(ctx, putMetricDataInput(namespace, timestamp, data[:n])); err != nil {
+			return apiError(err)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// putMetricDataInput of a PutMetricData request with the data, with histograms as their values and counts.
+func putMetricDataInput(namespace string, timestamp time.Time, data []datum) *cloudwatch.PutMetricDataInput {
+	ts := timestamp.UTC()
+	input := &cloudwatch.PutMetricDataInput{Namespace: aws.String(namespace)}
+	for _, d := range data {
+		md := types.MetricDatum{
+			MetricName: aws.String(d.name),
+			Unit:       types.StandardUnit(d.unit),
+			Timestamp:  aws.Time(ts),
+		}
+		for _, dim := range d.dimensions {
+			md.Dimensions = append(md.Dimensions, types.Dimension{Name: aws.String(dim.name), Value: aws.String(dim.value)})
+		}
+		if d.histogram {
+			md.Values, md.Counts = d.values, d.counts
+		} else {
+			md.Value = aws.Float64(d.value)
+		}
+		input.MetricData = append(input.MetricData, md)
+	}
+	return input
+}
+
+// apiError with the status, the code, and the message from CloudWatch, if there are any.
+func apiError(err error) error {
+	var responseErr *awshttp.ResponseError
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &responseErr) && errors.As(err, &apiErr):
+		return fmt.Errorf("CloudWatch responded with status %v, %v: %v", responseErr.HTTPStatusCode(), apiErr.ErrorCode(), apiErr.ErrorMessage())
+	case errors.As(err, &responseErr):
+		return fmt.Errorf("CloudWatch responded with status %v", responseErr.HTTPStatusCode())
+	default:
+		return fmt.Errorf("error calling CloudWatch: %w", err)
+	}
+}
@@ -0,0 +1,139 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"canvas/metrics"
+)
+
+// newCloudWatchServer responding to requests with the handler, and a CloudWatch client for it.
+func newCloudWatchServer(t *testing.T, h http.HandlerFunc) *metrics.CloudWatch {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return metrics.NewCloudWatch(aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		Retryer: func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.Backoff = retry.BackoffDelayerFunc(func(int, error) (time.Duration, error) { return 0, nil })
+			})
+		},
+	}, srv.URL)
+}
+
+func TestEmitter_Flush_putMetricData(t *testing.T) {
+	setup := func(cloudWatch *metrics.CloudWatch) (*metrics.Emitter, *prometheus.CounterVec, *prometheus.HistogramVec) {
+		registry := prometheus.NewRegistry()
+		results := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "app_job_results_total"}, []string{"type", "result"})
+		duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "app_job_duration_seconds",
+			Buckets: []float64{.1, 1},
+		}, []string{"type"})
+		registry.MustRegister(results, duration)
+		e := metrics.NewEmitter(metrics.NewEmitterOptions{
+			CloudWatch: cloudWatch,
+			Dimensions: map[string][]string{"app_job_results_total": {"type", "result"}, "app_job_duration_seconds": nil},
+			Gatherer:   registry,
+		})
+		return e, results, duration
+	}
+
+	t.Run("puts the metrics with a signed request", func(t *testing.T) {
+		is := is.New(t)
+
+		var form url.Values
+		cloudWatch := newCloudWatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+			is.Equal(http.MethodPost, r.Method)
+			is.True(strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/monitoring/aws4_request"))
+			is.NoErr(r.ParseForm())
+			form = r.PostForm
+		})
+		e, results, duration := setup(cloudWatch)
+		results.WithLabelValues("send_email", "success").Add(3)
+		duration.WithLabelValues("send_email").Observe(.5)
+		duration.WithLabelValues("send_newsletter").Observe(.7)
+		duration.WithLabelValues("send_newsletter").Observe(.05)
+
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal("PutMetricData", form.Get("Action"))
+		is.Equal("canvas", form.Get("Namespace"))
+
+		is.Equal("app_job_duration_seconds", form.Get("MetricData.member.1.MetricName"))
+		is.Equal("Seconds", form.Get("MetricData.member.1.Unit"))
+		is.Equal("0.1", form.Get("MetricData.member.1.Values.member.1"))
+		is.Equal("1", form.Get("MetricData.member.1.Counts.member.1"))
+		is.Equal("1", form.Get("MetricData.member.1.Values.member.2"))
+		is.Equal("2", form.Get("MetricData.member.1.Counts.member.2"))
+		is.Equal("", form.Get("MetricData.member.1.Dimensions.member.1.Name"))
+
+		is.Equal("app_job_results_total", form.Get("MetricData.member.2.MetricName"))
+		is.Equal("Count", form.Get("MetricData.member.2.Unit"))
+		is.Equal("3", form.Get("MetricData.member.2.Value"))
+		is.Equal("result", form.Get("MetricData.member.2.Dimensions.member.1.Name"))
+		is.Equal("success", form.Get("MetricData.member.2.Dimensions.member.1.Value"))
+		is.Equal("type", form.Get("MetricData.member.2.Dimensions.member.2.Name"))
+		is.Equal("send_email", form.Get("MetricData.member.2.Dimensions.member.2.Value"))
+		is.True(form.Get("MetricData.member.2.Timestamp") != "")
+	})
+
+	t.Run("returns the error from CloudWatch, and puts what changed the next time", func(t *testing.T) {
+		is := is.New(t)
+
+		var values []string
+		fail := true
+		cloudWatch := newCloudWatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if fail {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>InvalidParameterValue</Code>` +
+					`<Message>The value for parameter MetricData.member.1.Value is not valid.</Message></Error></ErrorResponse>`))
+				return
+			}
+			is.NoErr(r.ParseForm())
+			values = append(values, r.PostForm.Get("MetricData.member.1.Value"))
+		})
+		e, results, _ := setup(cloudWatch)
+		results.WithLabelValues("send_email", "success").Add(3)
+
+		err := e.Flush(context.Background())
+		is.True(err != nil)
+		is.Equal("CloudWatch responded with status 400, InvalidParameterValue: The value for parameter MetricData.member.1.Value is not valid.", err.Error())
+
+		fail = false
+		results.WithLabelValues("send_email", "success").Inc()
+		is.NoErr(e.Flush(context.Background()))
+		results.WithLabelValues("send_email", "success").Inc()
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal([]string{"4", "1"}, values)
+	})
+	t.Run("retries throttled requests", func(t *testing.T) {
+		is := is.New(t)
+
+		var requests int
+		cloudWatch := newCloudWatchServer(t, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code>` +
+					`<Message>Rate exceeded</Message></Error></ErrorResponse>`))
+			}
+		})
+		e, results, _ := setup(cloudWatch)
+		results.WithLabelValues("send_email", "success").Inc()
+
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal(2, requests)
+	})
+}
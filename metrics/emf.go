@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// emfMetadata of an Embedded Metric Format document, in its _aws field.
+// See https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+type emfMetadata struct {
+	// Timestamp in milliseconds since the epoch.
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+// emfHistogram value of a metric, with the values and their counts, like the CloudWatch agent writes them.
+// The minimum and maximum are of the values, since Prometheus doesn't keep them.
+type emfHistogram struct {
+	Values []float64 `json:"Values"`
+	Counts []float64 `json:"Counts"`
+	Count  float64   `json:"Count"`
+	Sum    float64   `json:"Sum"`
+	Min    float64   `json:"Min"`
+	Max    float64   `json:"Max"`
+}
+
+// writeEMF documents of the data to the log, one entry each. The values of the dimensions are fields of a document,
+// next to the values of its metrics, so there's a document for each set of dimension values,
+// with all the metrics that have them.
+func writeEMF(log *zap.Logger, namespace string, timestamp time.Time, data []datum) {
+	var keys []string
+	byKey := map[string][]datum{}
+	for _, d := range data {
+		key := seriesKey("", d.dimensions)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], d)
+	}
+
+	for _, key := range keys {
+		group := byKey[key]
+		names := []string{}
+		var fields []zap.Field
+		for _, d := range group[0].dimensions {
+			names = append(names, d.name)
+			fields = append(fields, zap.String(d.name, d.value))
+		}
+
+		directive := emfDirective{Namespace: namespace, Dimensions: [][]string{names}}
+		for _, d := range group {
+			directive.Metrics = append(directive.Metrics, emfMetric{Name: d.name, Unit: d.unit})
+			if !d.histogram {
+				fields = append(fields, zap.Float64(d.name, d.value))
+				continue
+			}
+			fields = append(fields, zap.Any(d.name, emfHistogram{
+				Values: d.values,
+				Counts: d.counts,
+				Count:  d.count,
+				Sum:    d.sum,
+				Min:    d.values[0],
+				Max:    d.values[len(d.values)-1],
+			}))
+		}
+
+		metadata := emfMetadata{Timestamp: timestamp.UnixMilli(), CloudWatchMetrics: []emfDirective{directive}}
+		log.Info("Metrics", append([]zap.Field{zap.Any("_aws", metadata)}, fields...)...)
+	}
+}
@@ -0,0 +1,402 @@
+// Package metrics emits the metrics of a Prometheus registry to CloudWatch, for where there's no Prometheus
+// to scrape /metrics.
+//
+// Metrics are recorded with the Prometheus client everywhere, so the registry is the one place they're defined,
+// and /metrics works the same either way. An Emitter gathers the registry on an interval, like a scrape would,
+// and emits what changed since the last time: as JSON lines in the CloudWatch Embedded Metric Format in the log,
+// which CloudWatch Logs turns into metrics, or with the PutMetricData API of CloudWatch.
+//
+// CloudWatch bills every combination of dimension values as a metric of its own, so only the metrics in
+// the dimensions option are emitted, only their labels in it are dimensions, and each dimension of a metric
+// has a bounded number of values.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+// DefaultDimensions of the metrics that are emitted by default, by metric name: their labels that are dimensions.
+// The series of a metric that only differ in other labels are added up, like the HTTP requests of all methods,
+// since clients choose the method, and the database queries of all query names, since there are many.
+var DefaultDimensions = map[string][]string{
+	"app_db_connection_wait_seconds_total": nil,
+	"app_db_connection_waits_total":        nil,
+	"app_db_connections":                   {"state"},
+	"app_db_query_duration_seconds":        {"outcome"},
+	"app_email_quota_remaining":            nil,
+	"app_email_sends_total":                {"result"},
+	"app_http_requests_total":              {"code"},
+	"app_http_shed_total":                  nil,
+	"app_job_duration_seconds":             {"type"},
+	"app_job_message_age_seconds":          {"type"},
+	"app_job_results_total":                {"type", "result"},
+	"app_jobs_processing":                  {"type"},
+}
+
+// otherValue of a dimension that has reached its most values.
+const otherValue = "other"
+
+// Emitter of the metrics of a Prometheus registry to CloudWatch.
+// Counters and histograms are emitted as what changed since the last time, and skipped if nothing did,
+// and gauges as they are.
+type Emitter struct {
+	cloudWatch *CloudWatch
+	dimensions map[string][]string
+	emfLog     *zap.Logger
+	gatherer   prometheus.Gatherer
+	interval   time.Duration
+	log        *zap.Logger
+	maxValues  int
+	namespace  string
+
+	lock sync.Mutex
+	// emitted counters and histograms by series key, as they were when they were last emitted.
+	emitted map[string]series
+	// values of the dimensions so far, by metric and dimension name, with a space in between.
+	values map[string]map[string]bool
+}
+
+// NewEmitterOptions for NewEmitter.
+type NewEmitterOptions struct {
+	// CloudWatch puts the metrics with the CloudWatch API if it's set. Otherwise, they're written to EMFLog.
+	CloudWatch *CloudWatch
+	// Dimensions of the metrics that are emitted, by metric name. Defaults to DefaultDimensions.
+	Dimensions map[string][]string
+	// EMFLog that the Embedded Metric Format documents are written to, at info level.
+	// It must write JSON, and not sample entries, or metrics are lost. Defaults to Log.
+	EMFLog   *zap.Logger
+	Gatherer prometheus.Gatherer
+	// Interval between emitting metrics. Defaults to a minute.
+	Interval time.Duration
+	Log      *zap.Logger
+	// MaxDimensionValues of each dimension of a metric. Values after that many are emitted as "other".
+	// Defaults to 20.
+	MaxDimensionValues int
+	// Namespace of the metrics in CloudWatch. Defaults to "canvas".
+	Namespace string
+}
+
+// NewEmitter of the metrics the gatherer has. It needs to be started to emit them on the interval.
+func NewEmitter(opts NewEmitterOptions) *Emitter {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.EMFLog == nil {
+		opts.EMFLog = opts.Log
+	}
+	if opts.Dimensions == nil {
+		opts.Dimensions = DefaultDimensions
+	}
+	if opts.Gatherer == nil {
+		opts.Gatherer = prometheus.NewRegistry()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.MaxDimensionValues <= 0 {
+		opts.MaxDimensionValues = 20
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "canvas"
+	}
+	return &Emitter{
+		cloudWatch: opts.CloudWatch,
+		dimensions: opts.Dimensions,
+		emfLog:     opts.EMFLog,
+		gatherer:   opts.Gatherer,
+		interval:   opts.Interval,
+		log:        opts.Log,
+		maxValues:  opts.MaxDimensionValues,
+		namespace:  opts.Namespace,
+		emitted:    map[string]series{},
+		values:     map[string]map[string]bool{},
+	}
+}
+
+// Start emitting the metrics every interval, until ctx is done.
+// What changed since the last time isn't emitted then, so call Flush after it returns.
+func (e *Emitter) Start(ctx context.Context) {
+	t := time.NewTicker(e.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := e.Flush(ctx); err != nil && ctx.Err() == nil {
+				e.log.Info("Error emitting metrics", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Flush the metrics now, emitting what changed since the last time.
+// If they can't be emitted, what changed is emitted the next time instead.
+func (e *Emitter) Flush(ctx context.Context) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	timestamp := time.Now()
+	current, err := e.gather()
+	if err != nil {
+		return fmt.Errorf("error gathering metrics: %w", err)
+	}
+
+	var data []datum
+	for _, s := range current {
+		if d, ok := s.since(e.emitted[s.key]); ok {
+			data = append(data, d)
+		}
+	}
+	if len(data) > 0 {
+		if e.cloudWatch != nil {
+			if err := e.cloudWatch.put(ctx, e.namespace, timestamp, data); err != nil {
+				return err
+			}
+		} else {
+			writeEMF(e.emfLog, e.namespace, timestamp, data)
+		}
+	}
+
+	for _, s := range current {
+		if s.kind != dto.MetricType_GAUGE {
+			e.emitted[s.key] = s
+		}
+	}
+	return nil
+}
+
+// gather the series of the metrics with dimensions, with the Prometheus series that only differ in labels
+// that aren't dimensions added up, sorted by key.
+func (e *Emitter) gather() ([]series, error) {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := map[string]*series{}
+	for _, f := range families {
+		labels, ok := e.dimensions[f.GetName()]
+		if !ok {
+			continue
+		}
+		kind := f.GetType()
+		switch kind {
+		case dto.MetricType_COUNTER, dto.MetricType_GAUGE, dto.MetricType_HISTOGRAM:
+		case dto.MetricType_UNTYPED:
+			kind = dto.MetricType_GAUGE
+		default:
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			dimensions := e.bound(f.GetName(), labels, m.GetLabel())
+			key := seriesKey(f.GetName(), dimensions)
+			s, ok := byKey[key]
+			if !ok {
+				s = &series{key: key, name: f.GetName(), kind: kind, dimensions: dimensions}
+				byKey[key] = s
+			}
+			s.add(m)
+		}
+	}
+
+	current := make([]series, 0, len(byKey))
+	for _, s := range byKey {
+		current = append(current, *s)
+	}
+	sort.Slice(current, func(i, j int) bool {
+		return current[i].key < current[j].key
+	})
+	return current, nil
+}
+
+// bound the labels of a series of the metric to its dimensions, sorted by name.
+// Each dimension keeps the first values it has, up to the most values, and the rest are "other",
+// so a series always has the same dimensions. Missing labels are "none", since dimensions can't be empty.
+func (e *Emitter) bound(metric string, names []string, labels []*dto.LabelPair) []dimension {
+	dimensions := make([]dimension, 0, len(names))
+	for _, name := range names {
+		value := "none"
+		for _, l := range labels {
+			if l.GetName() == name && l.GetValue() != "" {
+				value = l.GetValue()
+			}
+		}
+
+		key := metric + " " + name
+		values, ok := e.values[key]
+		if !ok {
+			values = map[string]bool{}
+			e.values[key] = values
+		}
+		if !values[value] {
+			if len(values) < e.maxValues {
+				values[value] = true
+			} else {
+				value = otherValue
+			}
+		}
+		dimensions = append(dimensions, dimension{name: name, value: value})
+	}
+	sort.Slice(dimensions, func(i, j int) bool {
+		return dimensions[i].name < dimensions[j].name
+	})
+	return dimensions
+}
+
+// seriesKey of the metric with the dimensions, like app_http_requests_total{code=200}.
+func seriesKey(metric string, dimensions []dimension) string {
+	var b strings.Builder
+	b.WriteString(metric)
+	b.WriteString("{")
+	for i, d := range dimensions {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(d.name + "=" + d.value)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// dimension of a metric, with its value.
+type dimension struct {
+	name  string
+	value string
+}
+
+// series of a metric with dimensions, added up from the Prometheus series it has.
+type series struct {
+	key        string
+	name       string
+	kind       dto.MetricType
+	dimensions []dimension
+	// value of a counter or gauge.
+	value float64
+	// bounds of the buckets of a histogram, with +Inf last, and the counts of each bucket.
+	bounds []float64
+	counts []uint64
+	sum    float64
+}
+
+// add the Prometheus series to the series.
+func (s *series) add(m *dto.Metric) {
+	switch s.kind {
+	case dto.MetricType_COUNTER:
+		s.value += m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		if m.Gauge != nil {
+			s.value += m.GetGauge().GetValue()
+		} else {
+			s.value += m.GetUntyped().GetValue()
+		}
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		// Buckets are cumulative, and the one with the +Inf bound is usually left out, since it has all of them.
+		var bounds []float64
+		var counts []uint64
+		var previous uint64
+		for _, b := range h.GetBucket() {
+			bounds = append(bounds, b.GetUpperBound())
+			counts = append(counts, b.GetCumulativeCount()-previous)
+			previous = b.GetCumulativeCount()
+		}
+		if len(bounds) == 0 || !math.IsInf(bounds[len(bounds)-1], 1) {
+			bounds = append(bounds, math.Inf(1))
+			counts = append(counts, h.GetSampleCount()-previous)
+		}
+
+		if s.bounds == nil {
+			s.bounds = bounds
+			s.counts = make([]uint64, len(counts))
+		}
+		if len(bounds) != len(s.bounds) {
+			return
+		}
+		for i, c := range counts {
+			s.counts[i] += c
+		}
+		s.sum += h.GetSampleSum()
+	}
+}
+
+// since the series was last emitted, as a datum, or false if it's a counter or histogram that didn't change.
+func (s series) since(last series) (datum, bool) {
+	d := datum{name: s.name, unit: unit(s.name, s.kind), dimensions: s.dimensions, value: s.value}
+	switch s.kind {
+	case dto.MetricType_COUNTER:
+		d.value = s.value - last.value
+		return d, d.value > 0
+	case dto.MetricType_HISTOGRAM:
+		d.histogram = true
+		d.sum = s.sum - last.sum
+		for i, c := range s.counts {
+			if i < len(last.counts) {
+				c -= last.counts[i]
+			}
+			if c == 0 {
+				continue
+			}
+			d.count += float64(c)
+			// Observations are at the bounds of their buckets, and those above the highest at the highest.
+			v := s.bounds[i]
+			if math.IsInf(v, 1) {
+				v = 0
+				if i > 0 {
+					v = s.bounds[i-1]
+				}
+			}
+			if n := len(d.values); n > 0 && d.values[n-1] == v {
+				d.counts[n-1] += float64(c)
+				continue
+			}
+			d.values = append(d.values, v)
+			d.counts = append(d.counts, float64(c))
+		}
+		return d, d.count > 0
+	default:
+		return d, true
+	}
+}
+
+// unit in CloudWatch of the metric, from the unit at the end of its name, like app_job_duration_seconds.
+// Counters are counts otherwise.
+func unit(name string, kind dto.MetricType) string {
+	name = strings.TrimSuffix(name, "_total")
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "Seconds"
+	case strings.HasSuffix(name, "_bytes"):
+		return "Bytes"
+	case kind == dto.MetricType_COUNTER:
+		return "Count"
+	default:
+		return "None"
+	}
+}
+
+// datum of a metric for CloudWatch.
+type datum struct {
+	name       string
+	unit       string
+	dimensions []dimension
+	// value of a counter or gauge.
+	value float64
+	// histogram values, with the number of observations of each, and their count and sum.
+	histogram bool
+	values    []float64
+	counts    []float64
+	count     float64
+	sum       float64
+}
@@ -0,0 +1,270 @@
+package metrics_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"canvas/metrics"
+)
+
+// emfLog writes JSON lines like the production logger, for reading the documents back.
+type emfLog struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (l *emfLog) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *emfLog) logger() *zap.Logger {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, zapcore.AddSync(l), zapcore.InfoLevel))
+}
+
+// documents written so far, decoded, which are then cleared.
+func (l *emfLog) documents(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var docs []map[string]interface{}
+	d := json.NewDecoder(&l.buf)
+	for d.More() {
+		var doc map[string]interface{}
+		if err := d.Decode(&doc); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	l.buf.Reset()
+	return docs
+}
+
+// directive of the document, after checking it has exactly one, with the namespace and the timestamp.
+func directive(t *testing.T, doc map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	aws, ok := doc["_aws"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("no _aws metadata in %v", doc)
+	}
+	if ts, ok := aws["Timestamp"].(float64); !ok || ts < float64(time.Now().Add(-time.Minute).UnixMilli()) {
+		t.Fatalf("no timestamp in milliseconds in %v", aws)
+	}
+	directives, ok := aws["CloudWatchMetrics"].([]interface{})
+	if !ok || len(directives) != 1 {
+		t.Fatalf("not one directive in %v", aws)
+	}
+	d := directives[0].(map[string]interface{})
+	if d["Namespace"] != "canvas" {
+		t.Fatalf("namespace is %v", d["Namespace"])
+	}
+	return d
+}
+
+func TestEmitter_Flush(t *testing.T) {
+	setup := func(dimensions map[string][]string, maxValues int) (*prometheus.Registry, *metrics.Emitter, *emfLog) {
+		registry := prometheus.NewRegistry()
+		log := &emfLog{}
+		e := metrics.NewEmitter(metrics.NewEmitterOptions{
+			Dimensions:         dimensions,
+			EMFLog:             log.logger(),
+			Gatherer:           registry,
+			MaxDimensionValues: maxValues,
+		})
+		return registry, e, log
+	}
+
+	t.Run("writes an EMF document for each set of dimension values, with the metrics that have them", func(t *testing.T) {
+		is := is.New(t)
+
+		registry, e, log := setup(map[string][]string{
+			"app_http_requests_total": {"code"},
+			"app_http_shed_total":     nil,
+			"app_http_shedding":       nil,
+		}, 0)
+		requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "app_http_requests_total"}, []string{"method", "code"})
+		shed := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_http_shed_total"})
+		shedding := prometheus.NewGauge(prometheus.GaugeOpts{Name: "app_http_shedding"})
+		registry.MustRegister(requests, shed, shedding)
+
+		requests.WithLabelValues("GET", "200").Add(3)
+		requests.WithLabelValues("POST", "200").Inc()
+		requests.WithLabelValues("GET", "500").Inc()
+		shed.Add(2)
+
+		is.NoErr(e.Flush(context.Background()))
+		docs := log.documents(t)
+		is.Equal(3, len(docs))
+
+		// The methods aren't a dimension, so their requests are added up.
+		d := directive(t, docs[0])
+		is.Equal([]interface{}{[]interface{}{"code"}}, d["Dimensions"])
+		is.Equal([]interface{}{map[string]interface{}{"Name": "app_http_requests_total", "Unit": "Count"}}, d["Metrics"])
+		is.Equal("200", docs[0]["code"])
+		is.Equal(float64(4), docs[0]["app_http_requests_total"])
+		_, ok := docs[0]["method"]
+		is.True(!ok)
+
+		is.Equal("500", docs[1]["code"])
+		is.Equal(float64(1), docs[1]["app_http_requests_total"])
+
+		// Without dimensions, the metrics are together in one document, with an empty dimension set.
+		d = directive(t, docs[2])
+		is.Equal([]interface{}{[]interface{}{}}, d["Dimensions"])
+		is.Equal([]interface{}{
+			map[string]interface{}{"Name": "app_http_shed_total", "Unit": "Count"},
+			map[string]interface{}{"Name": "app_http_shedding", "Unit": "None"},
+		}, d["Metrics"])
+		is.Equal(float64(2), docs[2]["app_http_shed_total"])
+		is.Equal(float64(0), docs[2]["app_http_shedding"])
+	})
+
+	t.Run("writes counters as what changed since the last time, skipping those that didn't, and gauges as they are", func(t *testing.T) {
+		is := is.New(t)
+
+		registry, e, log := setup(map[string][]string{"app_email_sends_total": {"result"}, "app_jobs_processing": nil}, 0)
+		sends := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "app_email_sends_total"}, []string{"result"})
+		processing := prometheus.NewGauge(prometheus.GaugeOpts{Name: "app_jobs_processing"})
+		registry.MustRegister(sends, processing)
+
+		sends.WithLabelValues("sent").Add(5)
+		sends.WithLabelValues("failed").Inc()
+		processing.Set(3)
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal(3, len(log.documents(t)))
+
+		sends.WithLabelValues("sent").Add(2)
+		is.NoErr(e.Flush(context.Background()))
+		docs := log.documents(t)
+		is.Equal(2, len(docs))
+		is.Equal("sent", docs[0]["result"])
+		is.Equal(float64(2), docs[0]["app_email_sends_total"])
+		is.Equal(float64(3), docs[1]["app_jobs_processing"])
+	})
+
+	t.Run("writes histograms as the values at the bounds of their buckets, with counts", func(t *testing.T) {
+		is := is.New(t)
+
+		registry, e, log := setup(map[string][]string{"app_job_duration_seconds": {"type"}}, 0)
+		duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "app_job_duration_seconds",
+			Buckets: []float64{.1, 1, 10},
+		}, []string{"type"})
+		registry.MustRegister(duration)
+
+		for _, v := range []float64{.05, .5, .7, 30} {
+			duration.WithLabelValues("send_email").Observe(v)
+		}
+		is.NoErr(e.Flush(context.Background()))
+		docs := log.documents(t)
+		is.Equal(1, len(docs))
+		d := directive(t, docs[0])
+		is.Equal([]interface{}{map[string]interface{}{"Name": "app_job_duration_seconds", "Unit": "Seconds"}}, d["Metrics"])
+		is.Equal("send_email", docs[0]["type"])
+		// Observations above the highest bound are at it.
+		is.Equal(map[string]interface{}{
+			"Values": []interface{}{.1, float64(1), float64(10)},
+			"Counts": []interface{}{float64(1), float64(2), float64(1)},
+			"Count":  float64(4),
+			"Sum":    31.25,
+			"Min":    .1,
+			"Max":    float64(10),
+		}, docs[0]["app_job_duration_seconds"])
+
+		duration.WithLabelValues("send_email").Observe(.5)
+		is.NoErr(e.Flush(context.Background()))
+		docs = log.documents(t)
+		is.Equal(1, len(docs))
+		h := docs[0]["app_job_duration_seconds"].(map[string]interface{})
+		is.Equal([]interface{}{float64(1)}, h["Values"])
+		is.Equal([]interface{}{float64(1)}, h["Counts"])
+		is.Equal(float64(1), h["Count"])
+	})
+
+	t.Run("bounds the values of each dimension, with the rest as other", func(t *testing.T) {
+		is := is.New(t)
+
+		registry, e, log := setup(map[string][]string{"app_job_results_total": {"type"}}, 2)
+		results := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "app_job_results_total"}, []string{"type"})
+		registry.MustRegister(results)
+
+		results.WithLabelValues("a").Inc()
+		results.WithLabelValues("b").Inc()
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal(2, len(log.documents(t)))
+
+		results.WithLabelValues("a").Inc()
+		results.WithLabelValues("c").Add(2)
+		results.WithLabelValues("d").Add(3)
+		is.NoErr(e.Flush(context.Background()))
+		docs := log.documents(t)
+		is.Equal(2, len(docs))
+		is.Equal("a", docs[0]["type"])
+		is.Equal(float64(1), docs[0]["app_job_results_total"])
+		is.Equal("other", docs[1]["type"])
+		is.Equal(float64(5), docs[1]["app_job_results_total"])
+	})
+
+	t.Run("only writes the metrics with dimensions", func(t *testing.T) {
+		is := is.New(t)
+
+		registry, e, log := setup(map[string][]string{"app_http_shed_total": nil}, 0)
+		notFound := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_http_not_found_total"})
+		registry.MustRegister(notFound)
+		notFound.Inc()
+
+		is.NoErr(e.Flush(context.Background()))
+		is.Equal(0, len(log.documents(t)))
+	})
+}
+
+func TestEmitter_Start(t *testing.T) {
+	t.Run("emits on the interval until ctx is done, and flushes the rest after", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		requests := prometheus.NewCounter(prometheus.CounterOpts{Name: "app_http_requests_total"})
+		registry.MustRegister(requests)
+		log := &emfLog{}
+		e := metrics.NewEmitter(metrics.NewEmitterOptions{
+			Dimensions: map[string][]string{"app_http_requests_total": nil},
+			EMFLog:     log.logger(),
+			Gatherer:   registry,
+			Interval:   10 * time.Millisecond,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			e.Start(ctx)
+			close(done)
+		}()
+
+		requests.Inc()
+		var docs []map[string]interface{}
+		for len(docs) == 0 {
+			time.Sleep(10 * time.Millisecond)
+			docs = log.documents(t)
+		}
+		is.Equal(float64(1), docs[0]["app_http_requests_total"])
+
+		cancel()
+		<-done
+		requests.Add(2)
+		is.NoErr(e.Flush(context.Background()))
+		docs = log.documents(t)
+		is.Equal(1, len(docs))
+		is.Equal(float64(2), docs[0]["app_http_requests_total"])
+	})
+}
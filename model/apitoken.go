@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+)
+
+// APIScope is what an APIToken is allowed to do with the admin API.
+type APIScope string
+
+const (
+	// APIScopeReadSubscribers is for listing and getting subscribers.
+	APIScopeReadSubscribers APIScope = "read:subscribers"
+	// APIScopeWriteSubscribers is for the same changes to subscribers as in the admin pages, like confirming them.
+	APIScopeWriteSubscribers APIScope = "write:subscribers"
+	// APIScopeSendNewsletter is for sending newsletter issues to all confirmed subscribers.
+	APIScopeSendNewsletter APIScope = "send:newsletter"
+)
+
+// APIScopes are all the scopes, in the order they're shown.
+var APIScopes = []APIScope{APIScopeReadSubscribers, APIScopeWriteSubscribers, APIScopeSendNewsletter}
+
+// IsValid if it's one of APIScopes.
+func (s APIScope) IsValid() bool {
+	for _, scope := range APIScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIToken for programmatic access to the admin API, without the secret token itself, which is only shown once.
+type APIToken struct {
+	ID int64
+	// Name of the token, for telling tokens apart, like after what uses it.
+	Name   string
+	Scopes []APIScope
+	// LastUsedAt is when the token was last used, or nil if it never was.
+	LastUsedAt *time.Time
+	// ExpiresAt is when the token stops working, or nil if it doesn't expire.
+	ExpiresAt *time.Time
+	Created   time.Time
+}
+
+// HasScope if the token has the scope.
+func (t APIToken) HasScope(scope APIScope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"regexp"
+	"strings"
+	"time"
 )
 
 // emailAddressMatcher for valid email addresses.
@@ -28,3 +30,164 @@ func (e Email) IsValid() bool {
 func (e Email) String() string {
 	return string(e)
 }
+
+// Local part of the address, before the last @.
+func (e Email) Local() string {
+	i := strings.LastIndexByte(string(e), '@')
+	if i < 0 {
+		return string(e)
+	}
+	return string(e)[:i]
+}
+
+// Domain of the address, after the last @, or empty if there's none.
+func (e Email) Domain() string {
+	i := strings.LastIndexByte(string(e), '@')
+	if i < 0 {
+		return ""
+	}
+	return string(e)[i+1:]
+}
+
+// DisposableChecker tells whether a domain is of a disposable email provider, with throwaway addresses.
+type DisposableChecker interface {
+	IsDisposable(domain string) bool
+}
+
+// EmailPolicy for the addresses people sign up with. The zero value is off, and leaves addresses as they're entered.
+type EmailPolicy struct {
+	// Enabled policy normalizes addresses, and blocks disposable ones if there's a Disposable checker.
+	Enabled bool
+	// DedupDomains are the domains of providers that ignore dots and plus tags in the local part, like gmail.com,
+	// so all the variants of an address are the same mailbox.
+	DedupDomains []string
+	// Disposable checks the domains of addresses. Without it, no address is disposable.
+	Disposable DisposableChecker
+}
+
+// Normalize the address by trimming space around it and lowercasing it, if the policy is enabled.
+func (p EmailPolicy) Normalize(e Email) Email {
+	if !p.Enabled {
+		return e
+	}
+	return Email(strings.ToLower(strings.TrimSpace(string(e))))
+}
+
+// Canonical address of the mailbox that e goes to, for telling variants of the same address apart.
+// For the DedupDomains, the plus tag and the dots of the normalized local part are stripped,
+// so John.Doe+news@gmail.com is johndoe@gmail.com. It's only for deduplicating: emails go to the address as entered.
+func (p EmailPolicy) Canonical(e Email) Email {
+	e = p.Normalize(e)
+	if !p.Enabled || !p.isDedupDomain(e.Domain()) {
+		return e
+	}
+	local := e.Local()
+	if i := strings.IndexByte(local, '+'); i >= 0 {
+		local = local[:i]
+	}
+	return Email(strings.ReplaceAll(local, ".", "") + "@" + e.Domain())
+}
+
+// IsDisposable address, if the policy is enabled and has a Disposable checker.
+func (p EmailPolicy) IsDisposable(e Email) bool {
+	if !p.Enabled || p.Disposable == nil {
+		return false
+	}
+	return p.Disposable.IsDisposable(strings.ToLower(e.Domain()))
+}
+
+func (p EmailPolicy) isDedupDomain(domain string) bool {
+	for _, d := range p.DedupDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// EmailSendStatus of an attempt to send an email.
+type EmailSendStatus string
+
+const (
+	EmailSendStatusSent   EmailSendStatus = "sent"
+	EmailSendStatusFailed EmailSendStatus = "failed"
+	// EmailSendStatusBounced is for bounces reported by the email provider after the email was sent.
+	EmailSendStatusBounced EmailSendStatus = "bounced"
+	// EmailSendStatusComplained is for complaints reported by the email provider, when the recipient marked it as spam.
+	EmailSendStatusComplained EmailSendStatus = "complained"
+	// EmailSendStatusSkipped is for emails that weren't sent, because the address was suppressed after they were enqueued.
+	EmailSendStatusSkipped EmailSendStatus = "skipped"
+)
+
+// EmailDelivery of a sent email, as reported by the email provider after sending it.
+type EmailDelivery string
+
+const (
+	EmailDeliveryDelivered  EmailDelivery = "delivered"
+	EmailDeliveryBounced    EmailDelivery = "bounced"
+	EmailDeliveryComplained EmailDelivery = "complained"
+)
+
+// EmailSend is an entry in the send log, recording an attempt to send an email, or a bounce of one
+// that couldn't be matched to a send. NewsletterID is set for newsletter issue emails, and zero otherwise.
+// ID, SubscriberID, Delivery, Created, and Updated are set by the send log, and ignored when recording a send.
+type EmailSend struct {
+	ID int64
+	// SubscriberID of the subscriber with the email address, or zero if there's none.
+	SubscriberID      int64
+	Email             Email
+	Type              string
+	NewsletterID      int64
+	ProviderMessageID string
+	Status            EmailSendStatus
+	Error             string
+	// Test sends, like of newsletter previews to an admin, are left out of send stats and don't count as sent.
+	Test bool
+	// Delivery reported for the send by the email provider, or empty if nothing was reported yet.
+	Delivery EmailDelivery
+	Created  time.Time
+	Updated  time.Time
+}
+
+// SendStats of the emails sent in the last days, without test sends.
+type SendStats struct {
+	Sent   int
+	Failed int
+	// Bounced and Complained are the subscribers suppressed for bounces and spam complaints in the last days.
+	Bounced    int
+	Complained int
+}
+
+// BounceRate of the sent emails, or zero without sent emails.
+func (s SendStats) BounceRate() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Bounced) / float64(s.Sent)
+}
+
+// ComplaintRate of the sent emails, or zero without sent emails.
+func (s SendStats) ComplaintRate() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Complained) / float64(s.Sent)
+}
+
+// BounceType of a bounce reported by the email provider.
+type BounceType string
+
+const (
+	// BounceTypePermanent is for addresses that will never work, like ones that don't exist.
+	BounceTypePermanent BounceType = "permanent"
+	// BounceTypeTransient is for addresses that might work later, like ones with a full mailbox.
+	BounceTypeTransient BounceType = "transient"
+)
+
+// Bounce of an email to an address, as reported by the email provider.
+type Bounce struct {
+	Email Email
+	Type  BounceType
+	// ProviderMessageID of the email that bounced.
+	ProviderMessageID string
+}
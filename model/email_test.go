@@ -29,3 +29,80 @@ func TestEmail_IsValid(t *testing.T) {
 		}
 	})
 }
+
+func TestEmail_Domain(t *testing.T) {
+	t.Run("splits the address at the last @", func(t *testing.T) {
+		is := is.New(t)
+
+		e := model.Email("me@example.com")
+		is.Equal("me", e.Local())
+		is.Equal("example.com", e.Domain())
+
+		e = model.Email("nope")
+		is.Equal("nope", e.Local())
+		is.Equal("", e.Domain())
+	})
+}
+
+type disposableCheckerMock map[string]bool
+
+func (d disposableCheckerMock) IsDisposable(domain string) bool {
+	return d[domain]
+}
+
+func TestEmailPolicy(t *testing.T) {
+	policy := model.EmailPolicy{
+		Enabled:      true,
+		DedupDomains: []string{"gmail.com", "googlemail.com"},
+		Disposable:   disposableCheckerMock{"mailinator.com": true},
+	}
+
+	t.Run("is off by default, leaving addresses as they are", func(t *testing.T) {
+		is := is.New(t)
+
+		var off model.EmailPolicy
+		is.Equal(model.Email(" John.Doe+News@Gmail.com"), off.Normalize(" John.Doe+News@Gmail.com"))
+		is.Equal(model.Email("John.Doe+News@Gmail.com"), off.Canonical("John.Doe+News@Gmail.com"))
+		off.Disposable = disposableCheckerMock{"mailinator.com": true}
+		is.True(!off.IsDisposable("me@mailinator.com"))
+	})
+
+	t.Run("normalizes addresses by trimming and lowercasing them", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(model.Email("john.doe+news@example.com"), policy.Normalize(" John.Doe+News@Example.com\n"))
+	})
+
+	tests := []struct {
+		email     model.Email
+		canonical model.Email
+	}{
+		{"johndoe@gmail.com", "johndoe@gmail.com"},
+		{"john.doe@gmail.com", "johndoe@gmail.com"},
+		{"j.o.h.n.d.o.e@gmail.com", "johndoe@gmail.com"},
+		{"johndoe+news@gmail.com", "johndoe@gmail.com"},
+		{"John.Doe+news+more@GMail.com", "johndoe@gmail.com"},
+		{"john.doe+n.ews@gmail.com", "johndoe@gmail.com"},
+		{"john.doe@googlemail.com", "johndoe@googlemail.com"},
+		{"john.doe+news@example.com", "john.doe+news@example.com"},
+		{"john.doe@mail.gmail.com", "john.doe@mail.gmail.com"},
+	}
+	for _, test := range tests {
+		t.Run("collapses the dots and plus tag of "+test.email.String()+" for the dedup domains", func(t *testing.T) {
+			is := is.New(t)
+
+			is.Equal(test.canonical, policy.Canonical(test.email))
+		})
+	}
+
+	t.Run("checks disposable domains without case", func(t *testing.T) {
+		is := is.New(t)
+
+		is.True(policy.IsDisposable("me@Mailinator.com"))
+		is.True(!policy.IsDisposable("me@example.com"))
+
+		withoutChecker := policy
+		withoutChecker.Disposable = nil
+		is.True(!withoutChecker.IsDisposable("me@mailinator.com"))
+	})
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"embed"
+	"errors"
+)
+
+// Types of the domain events about subscribers, which other systems can react to, instead of polling the database.
+// A domain event is a message like the ones for jobs, with the type in the "job" field, so jobs in this app can consume it,
+// and the fields of EventMeta.
+const (
+	EventSubscriberSignedUp     = "subscriber.signed_up"
+	EventSubscriberConfirmed    = "subscriber.confirmed"
+	EventSubscriberUnsubscribed = "subscriber.unsubscribed"
+	EventSubscriberSuppressed   = "subscriber.suppressed"
+)
+
+// EventSchemas are the JSON schemas of the domain events, named after the type and the version of the event,
+// like "schemas/subscriber.signed_up.v1.json". A version is only ever added to, so consumers can rely on what they know,
+// and an incompatible change is a new version.
+//
+//go:embed schemas
+var EventSchemas embed.FS
+
+// EventMeta of every domain event, set by messaging.NewEvent.
+type EventMeta struct {
+	// EventID is unique for the event, for consumers to skip events they've seen, since they're delivered at least once.
+	EventID string `json:"eventID,omitempty"`
+	// EventType is one of the Event constants, like EventSubscriberSignedUp.
+	EventType string `json:"eventType,omitempty"`
+	// EventVersion of the schema of the event.
+	EventVersion string `json:"eventVersion,omitempty"`
+	// OccurredAt in RFC 3339 format, when the transaction the event was emitted in happened.
+	OccurredAt string `json:"occurredAt,omitempty"`
+}
+
+// SubscriberSignedUp when someone signs up for the newsletter, also again after unsubscribing.
+type SubscriberSignedUp struct {
+	EventMeta
+	Email  Email  `json:"email"`
+	Locale string `json:"locale,omitempty"`
+	// Source of the signup, like the origin of a partner site with the embedded signup form. It's empty for this site.
+	Source string `json:"source,omitempty"`
+}
+
+func (SubscriberSignedUp) JobName() string {
+	return EventSubscriberSignedUp
+}
+
+func (SubscriberSignedUp) Version() int {
+	return 1
+}
+
+func (e SubscriberSignedUp) Validate() error {
+	if !e.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	return nil
+}
+
+// SubscriberConfirmed when a subscriber confirms their email address with the link from the confirmation email,
+// or an admin confirms it for them.
+type SubscriberConfirmed struct {
+	EventMeta
+	Email  Email  `json:"email"`
+	Locale string `json:"locale,omitempty"`
+	// FirstTime is "true" the first time the subscriber confirms with the link, when they get the welcome email,
+	// and "false" otherwise, like after signing up again. Confirmations by an admin are always "false".
+	FirstTime string `json:"firstTime"`
+}
+
+func (SubscriberConfirmed) JobName() string {
+	return EventSubscriberConfirmed
+}
+
+func (SubscriberConfirmed) Version() int {
+	return 1
+}
+
+func (e SubscriberConfirmed) Validate() error {
+	if !e.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	if e.FirstTime != "true" && e.FirstTime != "false" {
+		return errors.New("first time is not true or false")
+	}
+	return nil
+}
+
+// SubscriberUnsubscribed when a subscriber unsubscribes, or an admin unsubscribes them.
+type SubscriberUnsubscribed struct {
+	EventMeta
+	Email Email `json:"email"`
+	// Actor that unsubscribed the subscriber, like in the audit log.
+	Actor string `json:"actor"`
+}
+
+func (SubscriberUnsubscribed) JobName() string {
+	return EventSubscriberUnsubscribed
+}
+
+func (SubscriberUnsubscribed) Version() int {
+	return 1
+}
+
+func (e SubscriberUnsubscribed) Validate() error {
+	if !e.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	if e.Actor == "" {
+		return errors.New("actor is missing")
+	}
+	return nil
+}
+
+// SubscriberSuppressed when emails to a subscriber stop, like after bounces or a complaint.
+type SubscriberSuppressed struct {
+	EventMeta
+	Email  Email             `json:"email"`
+	Reason SuppressionReason `json:"reason"`
+}
+
+func (SubscriberSuppressed) JobName() string {
+	return EventSubscriberSuppressed
+}
+
+func (SubscriberSuppressed) Version() int {
+	return 1
+}
+
+func (e SubscriberSuppressed) Validate() error {
+	if !e.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	if e.Reason == "" {
+		return errors.New("reason is missing")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package model_test
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+func TestEventSchemas(t *testing.T) {
+	events := []messaging.Event{
+		model.SubscriberSignedUp{Email: "me@example.com", Locale: "de", Source: "https://partner.example.com"},
+		model.SubscriberConfirmed{Email: "me@example.com", Locale: "de", FirstTime: "true"},
+		model.SubscriberUnsubscribed{Email: "me@example.com", Actor: "subscriber"},
+		model.SubscriberSuppressed{Email: "me@example.com", Reason: model.SuppressionReasonComplained},
+	}
+
+	for _, e := range events {
+		t.Run("has a schema matching the fields of "+e.JobName(), func(t *testing.T) {
+			is := is.New(t)
+
+			schemaAsBytes, err := model.EventSchemas.ReadFile("schemas/" + e.JobName() + ".v1.json")
+			is.NoErr(err)
+			var schema struct {
+				Properties map[string]struct {
+					Const string
+					Enum  []string
+				}
+				Required []string
+			}
+			is.NoErr(json.Unmarshal(schemaAsBytes, &schema))
+
+			m, err := messaging.NewEvent(e, time.Now())
+			is.NoErr(err)
+
+			var fields, properties []string
+			for name := range m {
+				fields = append(fields, name)
+			}
+			for name := range schema.Properties {
+				properties = append(properties, name)
+			}
+			sort.Strings(fields)
+			sort.Strings(properties)
+			is.Equal(properties, fields)
+
+			for _, name := range schema.Required {
+				_, ok := m[name]
+				is.True(ok) // required field is missing
+			}
+			for name, p := range schema.Properties {
+				if p.Const != "" {
+					is.Equal(p.Const, m[name])
+				}
+				if len(p.Enum) > 0 {
+					is.True(contains(p.Enum, m[name])) // value isn't one of the enum values
+				}
+			}
+		})
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
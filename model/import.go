@@ -0,0 +1,92 @@
+package model
+
+import (
+	"time"
+)
+
+// SubscriberImportState is where importing subscribers from an uploaded file is.
+type SubscriberImportState string
+
+const (
+	// SubscriberImportStateUploaded is for an import whose file is uploaded, waiting for an admin to confirm the preview.
+	SubscriberImportStateUploaded SubscriberImportState = "uploaded"
+	// SubscriberImportStateQueued is for an import that's waiting for the import job to start.
+	SubscriberImportStateQueued SubscriberImportState = "queued"
+	// SubscriberImportStateImporting is for an import whose rows are being imported.
+	SubscriberImportStateImporting SubscriberImportState = "importing"
+	// SubscriberImportStateCompleted is for an import with a result for every row.
+	SubscriberImportStateCompleted SubscriberImportState = "completed"
+	// SubscriberImportStateFailed is for an import whose job failed permanently. Queueing it again resumes it.
+	SubscriberImportStateFailed SubscriberImportState = "failed"
+)
+
+// SubscriberImportDuplicates is what an import does with the rows of addresses that already have a subscriber.
+type SubscriberImportDuplicates string
+
+const (
+	// SubscriberImportDuplicatesSkip leaves existing subscribers as they are.
+	SubscriberImportDuplicatesSkip SubscriberImportDuplicates = "skip"
+	// SubscriberImportDuplicatesUpdate sets the locale of existing subscribers from the row, if it has one,
+	// and adds the tags of the row. Like skipping, it doesn't change whether they're confirmed or unsubscribed.
+	SubscriberImportDuplicatesUpdate SubscriberImportDuplicates = "update"
+)
+
+// SubscriberImportResult of importing a row.
+type SubscriberImportResult string
+
+const (
+	SubscriberImportResultAdded   SubscriberImportResult = "added"
+	SubscriberImportResultUpdated SubscriberImportResult = "updated"
+	SubscriberImportResultSkipped SubscriberImportResult = "skipped"
+	SubscriberImportResultFailed  SubscriberImportResult = "failed"
+)
+
+// SubscriberImportColumns are the headers of the columns of an uploaded file that the fields of the subscribers
+// are imported from. Locale and Tags are empty if the file doesn't have them.
+type SubscriberImportColumns struct {
+	Email  string
+	Locale string
+	Tags   string
+}
+
+// SubscriberImport of subscribers from an uploaded CSV file, with its progress.
+type SubscriberImport struct {
+	ID         int64
+	Filename   string
+	State      SubscriberImportState
+	Duplicates SubscriberImportDuplicates
+	Columns    SubscriberImportColumns
+	// Total rows in the file, without the header.
+	Total int
+	// Added, Updated, Skipped, and Failed rows so far.
+	Added   int
+	Updated int
+	Skipped int
+	Failed  int
+	// Error of the import job, if the import failed.
+	Error   string
+	Created time.Time
+}
+
+// Done rows, which have a result.
+func (i SubscriberImport) Done() int {
+	return i.Added + i.Updated + i.Skipped + i.Failed
+}
+
+// InProgress is true while the import job is queued or running.
+func (i SubscriberImport) InProgress() bool {
+	return i.State == SubscriberImportStateQueued || i.State == SubscriberImportStateImporting
+}
+
+// SubscriberImportRow of an uploaded file, with the fields of the subscriber from its columns.
+type SubscriberImportRow struct {
+	// Line of the row in the file, where the header is line 1. For a row with line breaks in it, it's the first line.
+	Line   int
+	Email  Email
+	Locale string
+	Tags   []Tag
+	// Result of importing the row, or empty until it's imported.
+	Result SubscriberImportResult
+	// Error is why the row failed. Rows with one when they're uploaded fail without being imported.
+	Error string
+}
@@ -0,0 +1,220 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ConfirmationEmailRequested after signing up for the newsletter, to send an email with a confirmation link.
+type ConfirmationEmailRequested struct {
+	Email Email  `json:"email"`
+	Token string `json:"token"`
+	// Locale of the email. Defaults to English, also for messages from before emails had a locale.
+	Locale string `json:"locale,omitempty"`
+}
+
+func (ConfirmationEmailRequested) JobName() string {
+	return "confirmation_email"
+}
+
+func (p ConfirmationEmailRequested) Validate() error {
+	if !p.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	if p.Token == "" {
+		return errors.New("token is missing")
+	}
+	return nil
+}
+
+// WelcomeEmailRequested after confirming the newsletter signup for the first time, to send a welcome email.
+// Confirming emits SubscriberConfirmed instead now, and the job is only there for messages enqueued before that.
+type WelcomeEmailRequested struct {
+	Email Email `json:"email"`
+	// Locale of the email. Defaults to English.
+	Locale string `json:"locale,omitempty"`
+}
+
+func (WelcomeEmailRequested) JobName() string {
+	return "welcome_email"
+}
+
+func (p WelcomeEmailRequested) Validate() error {
+	if !p.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	return nil
+}
+
+// NewsletterIssueSendRequested to send a newsletter issue to all confirmed subscribers.
+type NewsletterIssueSendRequested struct {
+	NewsletterID string `json:"newsletterID"`
+}
+
+func (NewsletterIssueSendRequested) JobName() string {
+	return "newsletter_issue_send"
+}
+
+func (p NewsletterIssueSendRequested) Validate() error {
+	return validateNewsletterID(p.NewsletterID)
+}
+
+// NewsletterIssueEmailRequested to send a newsletter issue to a single subscriber.
+type NewsletterIssueEmailRequested struct {
+	NewsletterID string `json:"newsletterID"`
+	Email        Email  `json:"email"`
+	// Locale of the email around the newsletter content. Defaults to English.
+	Locale string `json:"locale,omitempty"`
+	// SubscriberID for the open tracking pixel in the email. It's empty if the subscriber opted out of tracking,
+	// and there's no pixel then.
+	SubscriberID string `json:"subscriberID,omitempty"`
+}
+
+func (NewsletterIssueEmailRequested) JobName() string {
+	return "newsletter_issue_email"
+}
+
+func (p NewsletterIssueEmailRequested) Validate() error {
+	if err := validateNewsletterID(p.NewsletterID); err != nil {
+		return err
+	}
+	if !p.Email.IsValid() {
+		return errors.New("email is invalid")
+	}
+	if p.SubscriberID != "" {
+		if _, err := strconv.ParseInt(p.SubscriberID, 10, 64); err != nil {
+			return errors.New("subscriber ID is not a number")
+		}
+	}
+	return nil
+}
+
+// EmailOpened when the open tracking pixel in a newsletter issue email is loaded, to record the open.
+type EmailOpened struct {
+	NewsletterID string `json:"newsletterID"`
+	SubscriberID string `json:"subscriberID"`
+	// OpenedAt in RFC 3339 format.
+	OpenedAt string `json:"openedAt"`
+}
+
+func (EmailOpened) JobName() string {
+	return "email_opened"
+}
+
+func (p EmailOpened) Validate() error {
+	if err := validateNewsletterID(p.NewsletterID); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(p.SubscriberID, 10, 64); err != nil {
+		return errors.New("subscriber ID is not a number")
+	}
+	if _, err := time.Parse(time.RFC3339, p.OpenedAt); err != nil {
+		return errors.New("opened at is not a time")
+	}
+	return nil
+}
+
+// EmailClicked when a tracked link in a newsletter issue email is clicked, to record the click.
+type EmailClicked struct {
+	NewsletterID string `json:"newsletterID"`
+	SubscriberID string `json:"subscriberID"`
+	URL          string `json:"url"`
+	// ClickedAt in RFC 3339 format.
+	ClickedAt string `json:"clickedAt"`
+}
+
+func (EmailClicked) JobName() string {
+	return "email_clicked"
+}
+
+func (p EmailClicked) Validate() error {
+	if err := validateNewsletterID(p.NewsletterID); err != nil {
+		return err
+	}
+	if _, err := strconv.ParseInt(p.SubscriberID, 10, 64); err != nil {
+		return errors.New("subscriber ID is not a number")
+	}
+	if p.URL == "" {
+		return errors.New("URL is missing")
+	}
+	if _, err := time.Parse(time.RFC3339, p.ClickedAt); err != nil {
+		return errors.New("clicked at is not a time")
+	}
+	return nil
+}
+
+// EmailSendsCleanupRequested by a schedule, to delete old sends from the send log.
+type EmailSendsCleanupRequested struct{}
+
+func (EmailSendsCleanupRequested) JobName() string {
+	return "email_sends_cleanup"
+}
+
+func (EmailSendsCleanupRequested) Validate() error {
+	return nil
+}
+
+// ThrottlesCleanupRequested by a schedule, to delete expired throttle windows.
+type ThrottlesCleanupRequested struct{}
+
+func (ThrottlesCleanupRequested) JobName() string {
+	return "throttles_cleanup"
+}
+
+func (ThrottlesCleanupRequested) Validate() error {
+	return nil
+}
+
+// ScheduledNewsletterSendsRequested by a schedule, to publish and send the newsletter issues that are due.
+type ScheduledNewsletterSendsRequested struct{}
+
+func (ScheduledNewsletterSendsRequested) JobName() string {
+	return "scheduled_newsletter_sends"
+}
+
+func (ScheduledNewsletterSendsRequested) Validate() error {
+	return nil
+}
+
+// WebhookDeliveryRequested after a domain event was emitted, to POST it to a webhook endpoint that subscribes to it.
+type WebhookDeliveryRequested struct {
+	DeliveryID string `json:"deliveryID"`
+}
+
+func (WebhookDeliveryRequested) JobName() string {
+	return "webhook_delivery"
+}
+
+func (p WebhookDeliveryRequested) Validate() error {
+	if _, err := strconv.ParseInt(p.DeliveryID, 10, 64); err != nil {
+		return errors.New("delivery ID is not a number")
+	}
+	return nil
+}
+
+func validateNewsletterID(id string) error {
+	if id == "" {
+		return errors.New("newsletter ID is missing")
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return errors.New("newsletter ID is not a number")
+	}
+	return nil
+}
+
+// SubscriberImportRequested after an admin confirmed the preview of an uploaded subscriber import.
+type SubscriberImportRequested struct {
+	ImportID string `json:"importID"`
+}
+
+func (SubscriberImportRequested) JobName() string {
+	return "subscriber_import"
+}
+
+func (p SubscriberImportRequested) Validate() error {
+	if _, err := strconv.ParseInt(p.ImportID, 10, 64); err != nil {
+		return errors.New("import ID is not a number")
+	}
+	return nil
+}
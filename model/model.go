@@ -1,3 +1,14 @@
 package model
 
+import (
+	"time"
+)
+
 type Message = map[string]string
+
+// OutboxMessage is a Message stored in the database, waiting to be published to the queue.
+type OutboxMessage struct {
+	ID      int64
+	Message Message
+	Created time.Time
+}
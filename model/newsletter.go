@@ -0,0 +1,244 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"canvas/content"
+)
+
+// Subscriber to the newsletter.
+type Subscriber struct {
+	ID          int64
+	Email       Email
+	Confirmed   bool
+	Active      bool
+	ConfirmedAt *time.Time
+	// Suppressed is why emails aren't sent to the subscriber anymore, even though they haven't unsubscribed.
+	// It's empty if they're not suppressed.
+	Suppressed SuppressionReason
+	// Locale the subscriber signed up in, which emails to them are in.
+	Locale string
+	// TrackingOptOut is true if the subscriber doesn't want opens of their emails tracked.
+	TrackingOptOut bool
+	// Source of the signup, like the origin of a partner site with the embedded signup form. It's empty for signups on this site.
+	Source  string
+	Created time.Time
+	Updated time.Time
+}
+
+// SuppressionReason is why sending to an email address stopped. Subscribers are only ever suppressed for the reasons
+// the mail provider reports, bounces and complaints, but the suppression list has the other reasons too.
+type SuppressionReason string
+
+const (
+	// SuppressionReasonBounced is for addresses that bounced permanently, or transiently too many times.
+	SuppressionReasonBounced SuppressionReason = "bounced"
+	// SuppressionReasonComplained is for recipients that marked an email as spam.
+	SuppressionReasonComplained SuppressionReason = "complained"
+	// SuppressionReasonUnsubscribed is for subscribers that unsubscribed, until they sign up again.
+	SuppressionReasonUnsubscribed SuppressionReason = "unsubscribed"
+	// SuppressionReasonManual is for addresses an admin blocked.
+	SuppressionReasonManual SuppressionReason = "manual"
+)
+
+// Suppression of an email address on the suppression list, which every email is checked against before it's sent.
+// Only the hash of the address is kept, so the suppression outlives the subscriber.
+type Suppression struct {
+	ID        int64
+	EmailHash string
+	Reason    SuppressionReason
+	// Source is who added the suppression, like the email provider, the subscriber, or an admin.
+	Source  string
+	Created time.Time
+}
+
+// SubscriberStatus is where a subscriber is in the signup lifecycle.
+type SubscriberStatus string
+
+const (
+	SubscriberStatusPending      SubscriberStatus = "pending"
+	SubscriberStatusConfirmed    SubscriberStatus = "confirmed"
+	SubscriberStatusUnsubscribed SubscriberStatus = "unsubscribed"
+	SubscriberStatusBounced      SubscriberStatus = "bounced"
+	SubscriberStatusComplained   SubscriberStatus = "complained"
+)
+
+// Status of the subscriber. Complaints come before unsubscribing, since they block signing up again.
+func (s Subscriber) Status() SubscriberStatus {
+	switch {
+	case s.Suppressed == SuppressionReasonComplained:
+		return SubscriberStatusComplained
+	case !s.Active:
+		return SubscriberStatusUnsubscribed
+	case s.Suppressed == SuppressionReasonBounced:
+		return SubscriberStatusBounced
+	case s.Confirmed:
+		return SubscriberStatusConfirmed
+	default:
+		return SubscriberStatusPending
+	}
+}
+
+// SubscriberStats for the admin dashboard, over the last days.
+type SubscriberStats struct {
+	// ByStatus counts of all subscribers.
+	ByStatus map[SubscriberStatus]int
+	// SignupsPerDay over the last days, oldest first and ending today.
+	SignupsPerDay []int
+	// Signups in the last days, and how many of them have confirmed.
+	Signups       int
+	Confirmations int
+}
+
+// Total of subscribers of all statuses.
+func (s SubscriberStats) Total() int {
+	var total int
+	for _, count := range s.ByStatus {
+		total += count
+	}
+	return total
+}
+
+// ConfirmationRate of the signups in the last days, or zero without signups.
+func (s SubscriberStats) ConfirmationRate() float64 {
+	if s.Signups == 0 {
+		return 0
+	}
+	return float64(s.Confirmations) / float64(s.Signups)
+}
+
+// Newsletter issue.
+type Newsletter struct {
+	ID    int64
+	Title string
+	// Slug for the issue's URL in the public archive. Empty until the issue is published.
+	Slug string
+	// Body in Markdown.
+	Body string
+	// RenderedHTML is the body rendered as HTML and cached, or empty if it's not.
+	RenderedHTML string
+	// PublishedAt is when the issue was published in the archive, or nil if it's a draft.
+	PublishedAt *time.Time
+	// ScheduledAt is when the issue is published and sent to the subscribers in ScheduledSegment,
+	// or nil if it's not scheduled. It's cleared once the issue is sent.
+	ScheduledAt      *time.Time
+	ScheduledSegment Segment
+	Created          time.Time
+	Updated          time.Time
+}
+
+// NewsletterState is where a newsletter issue is, from draft to sent.
+type NewsletterState string
+
+const (
+	// NewsletterStateDraft is for an issue that's neither scheduled nor sent, and can be edited.
+	NewsletterStateDraft NewsletterState = "draft"
+	// NewsletterStateScheduled is for an issue that's sent when its schedule is due, and can be edited until then.
+	NewsletterStateScheduled NewsletterState = "scheduled"
+	// NewsletterStateSending is for an issue with a send that's queued, sending, or failed and waiting to be resumed.
+	NewsletterStateSending NewsletterState = "sending"
+	// NewsletterStateSent is for an issue whose send completed.
+	NewsletterStateSent NewsletterState = "sent"
+)
+
+// State of the issue with its send, which is nil if it hasn't been sent.
+func (n Newsletter) State(send *NewsletterSend) NewsletterState {
+	switch {
+	case send != nil && send.State == NewsletterSendStateCompleted:
+		return NewsletterStateSent
+	case send != nil:
+		return NewsletterStateSending
+	case n.ScheduledAt != nil:
+		return NewsletterStateScheduled
+	default:
+		return NewsletterStateDraft
+	}
+}
+
+// Editable is true if the issue in the state can still be changed, which it can't once a send of it is queued,
+// so every subscriber gets the same issue.
+func (s NewsletterState) Editable() bool {
+	return s == NewsletterStateDraft || s == NewsletterStateScheduled
+}
+
+// LastModified is when the published issue last changed, for feeds and sitemaps.
+func (n Newsletter) LastModified() time.Time {
+	if n.PublishedAt != nil && n.PublishedAt.After(n.Updated) {
+		return *n.PublishedAt
+	}
+	return n.Updated
+}
+
+// BodyHTML is the Markdown body rendered as sanitized HTML, from RenderedHTML if it's cached.
+func (n Newsletter) BodyHTML() string {
+	if n.RenderedHTML != "" {
+		return n.RenderedHTML
+	}
+	return content.MarkdownToHTML(n.Body)
+}
+
+// Excerpt of the body, which is the first paragraph, cut at a word and ended with "…" if it's longer than maxLength runes.
+func (n Newsletter) Excerpt(maxLength int) string {
+	paragraph, _, _ := strings.Cut(strings.TrimSpace(n.Body), "\n\n")
+	paragraph = strings.Join(strings.Fields(paragraph), " ")
+	runes := []rune(paragraph)
+	if len(runes) <= maxLength {
+		return paragraph
+	}
+	cut := string(runes[:maxLength])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// ConfirmationResult of confirming a newsletter signup with a token.
+type ConfirmationResult string
+
+const (
+	ConfirmationResultConfirmed        ConfirmationResult = "confirmed"
+	ConfirmationResultAlreadyConfirmed ConfirmationResult = "already_confirmed"
+	ConfirmationResultExpired          ConfirmationResult = "expired"
+	ConfirmationResultUnknownToken     ConfirmationResult = "unknown_token"
+)
+
+// NewsletterSendState is where sending a newsletter issue to all confirmed subscribers is.
+type NewsletterSendState string
+
+const (
+	// NewsletterSendStateQueued is for a send that's waiting for the fan-out job to start.
+	NewsletterSendStateQueued NewsletterSendState = "queued"
+	// NewsletterSendStateSending is for a send that's enqueueing emails, or waiting for them to be sent.
+	NewsletterSendStateSending NewsletterSendState = "sending"
+	// NewsletterSendStateCompleted is for a send with a result in the send log for every subscriber.
+	NewsletterSendStateCompleted NewsletterSendState = "completed"
+	// NewsletterSendStateFailed is for a send whose fan-out job failed permanently. Queueing it again resumes it.
+	NewsletterSendStateFailed NewsletterSendState = "failed"
+)
+
+// NewsletterSend of a newsletter issue to the confirmed subscribers in a segment, with its progress.
+type NewsletterSend struct {
+	NewsletterID int64
+	State        NewsletterSendState
+	// Segment of the subscribers it's sent to, which is everyone if it's empty.
+	Segment Segment
+	// Total is the number of confirmed subscribers in the segment when the send was queued.
+	Total int
+	// Enqueued emails so far.
+	Enqueued int
+	// Sent and Failed are the subscribers the send log has a result for since the send was queued.
+	// A subscriber whose email failed but was sent when retried only counts as sent.
+	Sent   int
+	Failed int
+	// Error of the fan-out job, if the send failed.
+	Error    string
+	Queued   time.Time
+	Finished *time.Time
+	Updated  time.Time
+}
+
+// InProgress is true if the send is queued or sending, and so can't be queued again.
+func (s NewsletterSend) InProgress() bool {
+	return s.State == NewsletterSendStateQueued || s.State == NewsletterSendStateSending
+}
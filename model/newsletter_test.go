@@ -0,0 +1,93 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+)
+
+func TestSubscriber_Status(t *testing.T) {
+	tests := []struct {
+		name       string
+		subscriber model.Subscriber
+		expected   model.SubscriberStatus
+	}{
+		{"pending", model.Subscriber{Active: true}, model.SubscriberStatusPending},
+		{"confirmed", model.Subscriber{Active: true, Confirmed: true}, model.SubscriberStatusConfirmed},
+		{"unsubscribed", model.Subscriber{Confirmed: true}, model.SubscriberStatusUnsubscribed},
+		{"bounced", model.Subscriber{Active: true, Confirmed: true, Suppressed: model.SuppressionReasonBounced}, model.SubscriberStatusBounced},
+		{"complained", model.Subscriber{Active: true, Confirmed: true, Suppressed: model.SuppressionReasonComplained}, model.SubscriberStatusComplained},
+		{"unsubscribed before suppressed", model.Subscriber{Suppressed: model.SuppressionReasonBounced}, model.SubscriberStatusUnsubscribed},
+		{"complained before unsubscribed", model.Subscriber{Suppressed: model.SuppressionReasonComplained}, model.SubscriberStatusComplained},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.expected, test.subscriber.Status())
+		})
+	}
+}
+
+func TestNewsletter_State(t *testing.T) {
+	at := time.Date(2023, 1, 9, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		send     *model.NewsletterSend
+		at       *time.Time
+		expected model.NewsletterState
+		editable bool
+	}{
+		{"draft", nil, nil, model.NewsletterStateDraft, true},
+		{"scheduled", nil, &at, model.NewsletterStateScheduled, true},
+		{"queued", &model.NewsletterSend{State: model.NewsletterSendStateQueued}, nil, model.NewsletterStateSending, false},
+		{"sending", &model.NewsletterSend{State: model.NewsletterSendStateSending}, nil, model.NewsletterStateSending, false},
+		{"failed", &model.NewsletterSend{State: model.NewsletterSendStateFailed}, nil, model.NewsletterStateSending, false},
+		{"sent", &model.NewsletterSend{State: model.NewsletterSendStateCompleted}, nil, model.NewsletterStateSent, false},
+		{"sent before scheduled", &model.NewsletterSend{State: model.NewsletterSendStateCompleted}, &at, model.NewsletterStateSent, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			state := model.Newsletter{ScheduledAt: test.at}.State(test.send)
+			is.Equal(test.expected, state)
+			is.Equal(test.editable, state.Editable())
+		})
+	}
+}
+
+func TestNewsletter_BodyHTML(t *testing.T) {
+	t.Run("renders the Markdown body", func(t *testing.T) {
+		is := is.New(t)
+
+		n := model.Newsletter{Body: "\nHi **you** & <b>me</b>.\n\nBye.\n"}
+		is.Equal("<p>Hi <strong>you</strong> &amp; me.</p>\n<p>Bye.</p>\n", n.BodyHTML())
+	})
+
+	t.Run("uses the cached rendered HTML", func(t *testing.T) {
+		is := is.New(t)
+
+		n := model.Newsletter{Body: "Hi.", RenderedHTML: "<p>Cached.</p>"}
+		is.Equal("<p>Cached.</p>", n.BodyHTML())
+	})
+}
+
+func TestNewsletter_Excerpt(t *testing.T) {
+	t.Run("is the first paragraph", func(t *testing.T) {
+		is := is.New(t)
+
+		n := model.Newsletter{Body: "First\nparagraph.\n\nSecond paragraph."}
+		is.Equal("First paragraph.", n.Excerpt(100))
+	})
+
+	t.Run("cuts long paragraphs at a word", func(t *testing.T) {
+		is := is.New(t)
+
+		n := model.Newsletter{Body: "Crème brûlée is a nice dessert."}
+		is.Equal("Crème brûlée is…", n.Excerpt(17))
+		is.Equal("Crème brûlée is a nice dessert.", n.Excerpt(31))
+	})
+}
@@ -0,0 +1,269 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tagMatcher for valid tags, which are lowercase, and short enough to show in lists.
+var tagMatcher = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// Tag of a subscriber, like "api" or "beta-2023", for sending newsletter issues to a Segment of the subscribers.
+type Tag string
+
+// IsValid if it's lowercase letters, digits, hyphens, and underscores, starts with a letter or digit,
+// is at most 50 characters, and isn't one of the operators of segments.
+func (t Tag) IsValid() bool {
+	return tagMatcher.MatchString(string(t)) && t != "and" && t != "or"
+}
+
+func (t Tag) String() string {
+	return string(t)
+}
+
+// TagCount is how many subscribers have a tag.
+type TagCount struct {
+	Tag   Tag
+	Count int
+}
+
+// Segment of the subscribers, as an expression of tags with "and", "or", and parentheses, like "api and (beta or early)".
+// Subscribers are in the segment if they have the tags the expression needs. "and" goes before "or",
+// and tags and operators are case-insensitive. The empty Segment is every subscriber.
+type Segment string
+
+func (s Segment) String() string {
+	return string(s)
+}
+
+// SegmentOp is the operator of a SegmentExpr.
+type SegmentOp string
+
+const (
+	SegmentOpTag SegmentOp = "tag"
+	SegmentOpAnd SegmentOp = "and"
+	SegmentOpOr  SegmentOp = "or"
+)
+
+// SegmentExpr is a parsed Segment: a Tag, or "and" or "or" of two or more Operands.
+type SegmentExpr struct {
+	Op       SegmentOp
+	Tag      Tag
+	Operands []SegmentExpr
+}
+
+// String of the expression, with parentheses only where they're needed.
+func (e SegmentExpr) String() string {
+	if e.Op == SegmentOpTag {
+		return string(e.Tag)
+	}
+	var operands []string
+	for _, o := range e.Operands {
+		s := o.String()
+		if e.Op == SegmentOpAnd && o.Op == SegmentOpOr {
+			s = "(" + s + ")"
+		}
+		operands = append(operands, s)
+	}
+	return strings.Join(operands, " "+string(e.Op)+" ")
+}
+
+// Matches is true for a subscriber with the tags. The database evaluates segments itself, so this is for stores in memory.
+func (e SegmentExpr) Matches(tags []Tag) bool {
+	switch e.Op {
+	case SegmentOpTag:
+		for _, t := range tags {
+			if t == e.Tag {
+				return true
+			}
+		}
+		return false
+	case SegmentOpAnd:
+		for _, o := range e.Operands {
+			if !o.Matches(tags) {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, o := range e.Operands {
+			if o.Matches(tags) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SegmentError for a Segment that isn't a valid expression, at the Position of the problem in it, starting at 1.
+type SegmentError struct {
+	Position int
+	Message  string
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("%v, at character %v", e.Message, e.Position)
+}
+
+// Parse the segment. The empty segment is nil. Errors are a *SegmentError.
+func (s Segment) Parse() (*SegmentExpr, error) {
+	p := segmentParser{tokens: tokenizeSegment(string(s))}
+	if len(p.tokens) == 0 {
+		return nil, nil
+	}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.text != "" {
+		return nil, &SegmentError{Position: t.position, Message: fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return &e, nil
+}
+
+// Normalize the segment, like "API AND (beta)" to "api and beta", so the same segments look the same.
+// Segments that aren't valid are returned as they are.
+func (s Segment) Normalize() Segment {
+	e, err := s.Parse()
+	if err != nil || e == nil {
+		return Segment(strings.TrimSpace(string(s)))
+	}
+	return Segment(e.String())
+}
+
+// Tags in the segment, in the order they're in it, each once.
+func (s Segment) Tags() []Tag {
+	var tags []Tag
+	seen := map[Tag]bool{}
+	for _, t := range tokenizeSegment(string(s)) {
+		if tag := Tag(strings.ToLower(t.text)); !t.isOperator() && tag.IsValid() && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+type segmentToken struct {
+	text     string
+	position int
+}
+
+func (t segmentToken) isOperator() bool {
+	switch strings.ToLower(t.text) {
+	case "and", "or", "(", ")":
+		return true
+	default:
+		return false
+	}
+}
+
+// tokenizeSegment into words and parentheses, with their positions starting at 1.
+func tokenizeSegment(s string) []segmentToken {
+	var tokens []segmentToken
+	start := -1
+	for i, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if start >= 0 {
+				tokens = append(tokens, segmentToken{text: s[start:i], position: start + 1})
+				start = -1
+			}
+			if r == '(' || r == ')' {
+				tokens = append(tokens, segmentToken{text: string(r), position: i + 1})
+			}
+		case start < 0:
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, segmentToken{text: s[start:], position: start + 1})
+	}
+	return tokens
+}
+
+// segmentParser parses the tokens of a segment by recursive descent, with this grammar:
+//
+//	or      = and { "or" and }
+//	and     = operand { "and" operand }
+//	operand = tag | "(" or ")"
+type segmentParser struct {
+	tokens []segmentToken
+	next   int
+}
+
+// peek at the next token, which is empty at the end, with the position after the segment.
+func (p *segmentParser) peek() segmentToken {
+	if p.next >= len(p.tokens) {
+		return segmentToken{position: p.endPosition()}
+	}
+	return p.tokens[p.next]
+}
+
+func (p *segmentParser) endPosition() int {
+	if len(p.tokens) == 0 {
+		return 1
+	}
+	last := p.tokens[len(p.tokens)-1]
+	return last.position + len(last.text)
+}
+
+func (p *segmentParser) or() (SegmentExpr, error) {
+	return p.binary(SegmentOpOr, p.and)
+}
+
+func (p *segmentParser) and() (SegmentExpr, error) {
+	return p.binary(SegmentOpAnd, p.operand)
+}
+
+// binary expression of the operator, with the operands parsed by operand, flattened into one SegmentExpr.
+func (p *segmentParser) binary(op SegmentOp, operand func() (SegmentExpr, error)) (SegmentExpr, error) {
+	var operands []SegmentExpr
+	for {
+		e, err := operand()
+		if err != nil {
+			return e, err
+		}
+		if e.Op == op {
+			operands = append(operands, e.Operands...)
+		} else {
+			operands = append(operands, e)
+		}
+		if !strings.EqualFold(p.peek().text, string(op)) {
+			break
+		}
+		p.next++
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return SegmentExpr{Op: op, Operands: operands}, nil
+}
+
+func (p *segmentParser) operand() (SegmentExpr, error) {
+	t := p.peek()
+	switch {
+	case t.text == "":
+		return SegmentExpr{}, &SegmentError{Position: t.position, Message: "expected a tag or (, but the segment ends"}
+	case t.text == "(":
+		p.next++
+		e, err := p.or()
+		if err != nil {
+			return e, err
+		}
+		if closing := p.peek(); closing.text != ")" {
+			return SegmentExpr{}, &SegmentError{Position: closing.position, Message: "expected ) for the ( at character " +
+				fmt.Sprint(t.position)}
+		}
+		p.next++
+		return e, nil
+	case t.isOperator():
+		return SegmentExpr{}, &SegmentError{Position: t.position, Message: fmt.Sprintf("expected a tag or (, not %q", t.text)}
+	case !Tag(strings.ToLower(t.text)).IsValid():
+		return SegmentExpr{}, &SegmentError{Position: t.position, Message: fmt.Sprintf("%q isn't a valid tag", t.text)}
+	default:
+		p.next++
+		return SegmentExpr{Op: SegmentOpTag, Tag: Tag(strings.ToLower(t.text))}, nil
+	}
+}
@@ -0,0 +1,169 @@
+package model_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+)
+
+func TestTag_IsValid(t *testing.T) {
+	tests := []struct {
+		tag   model.Tag
+		valid bool
+	}{
+		{"api", true},
+		{"beta-2023", true},
+		{"early_access", true},
+		{"2023", true},
+		{model.Tag(strings.Repeat("a", 50)), true},
+		{model.Tag(strings.Repeat("a", 51)), false},
+		{"", false},
+		{"API", false},
+		{"-api", false},
+		{"_api", false},
+		{"api test", false},
+		{"api(", false},
+		{"and", false},
+		{"or", false},
+		{"android", true},
+	}
+	t.Run("reports valid tags", func(t *testing.T) {
+		for _, test := range tests {
+			t.Run(string(test.tag), func(t *testing.T) {
+				is := is.New(t)
+				is.Equal(test.valid, test.tag.IsValid())
+			})
+		}
+	})
+}
+
+func TestSegment_Parse(t *testing.T) {
+	tag := func(t model.Tag) model.SegmentExpr {
+		return model.SegmentExpr{Op: model.SegmentOpTag, Tag: t}
+	}
+	and := func(operands ...model.SegmentExpr) model.SegmentExpr {
+		return model.SegmentExpr{Op: model.SegmentOpAnd, Operands: operands}
+	}
+	or := func(operands ...model.SegmentExpr) model.SegmentExpr {
+		return model.SegmentExpr{Op: model.SegmentOpOr, Operands: operands}
+	}
+
+	t.Run("parses the empty segment as nil", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, s := range []model.Segment{"", " ", "\t\n"} {
+			e, err := s.Parse()
+			is.NoErr(err)
+			is.True(e == nil)
+		}
+	})
+
+	valid := []struct {
+		segment model.Segment
+		expr    model.SegmentExpr
+	}{
+		{"api", tag("api")},
+		{"  API ", tag("api")},
+		{"api and beta", and(tag("api"), tag("beta"))},
+		{"api AND beta And early", and(tag("api"), tag("beta"), tag("early"))},
+		{"api or beta", or(tag("api"), tag("beta"))},
+		{"api and beta or early", or(and(tag("api"), tag("beta")), tag("early"))},
+		{"api or beta and early", or(tag("api"), and(tag("beta"), tag("early")))},
+		{"api and (beta or early)", and(tag("api"), or(tag("beta"), tag("early")))},
+		{"(api and beta) and early", and(tag("api"), tag("beta"), tag("early"))},
+		{"((api))", tag("api")},
+		{"(api)and(beta)", and(tag("api"), tag("beta"))},
+		{"android or order", or(tag("android"), tag("order"))},
+	}
+	for _, test := range valid {
+		t.Run("parses "+string(test.segment), func(t *testing.T) {
+			is := is.New(t)
+
+			e, err := test.segment.Parse()
+			is.NoErr(err)
+			is.Equal(test.expr, *e)
+		})
+	}
+
+	invalid := []struct {
+		segment model.Segment
+		err     string
+	}{
+		{"and", `expected a tag or (, not "and", at character 1`},
+		{"api and", "expected a tag or (, but the segment ends, at character 8"},
+		{"api or or beta", `expected a tag or (, not "or", at character 8`},
+		{"api beta", `unexpected "beta", at character 5`},
+		{"(api", "expected ) for the ( at character 1, at character 5"},
+		{"api)", `unexpected ")", at character 4`},
+		{"()", `expected a tag or (, not ")", at character 2`},
+		{"api and Beta!", `"Beta!" isn't a valid tag, at character 9`},
+		{"api & beta", `unexpected "&", at character 5`},
+	}
+	for _, test := range invalid {
+		t.Run("returns an error for "+string(test.segment), func(t *testing.T) {
+			is := is.New(t)
+
+			_, err := test.segment.Parse()
+			var segmentErr *model.SegmentError
+			is.True(errors.As(err, &segmentErr))
+			is.Equal(test.err, err.Error())
+		})
+	}
+}
+
+func TestSegment_Normalize(t *testing.T) {
+	tests := []struct {
+		segment    model.Segment
+		normalized model.Segment
+	}{
+		{"", ""},
+		{"  API  AND (beta) ", "api and beta"},
+		{"(api or beta) and early", "(api or beta) and early"},
+		{"api or (beta and early)", "api or beta and early"},
+		{" api and ", "api and"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.segment), func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.normalized, test.segment.Normalize())
+		})
+	}
+}
+
+func TestSegment_Tags(t *testing.T) {
+	t.Run("returns the valid tags once, in order", func(t *testing.T) {
+		is := is.New(t)
+
+		s := model.Segment("Beta and (api or beta) or early! and early")
+		is.Equal([]model.Tag{"beta", "api", "early"}, s.Tags())
+	})
+}
+
+func TestSegmentExpr_Matches(t *testing.T) {
+	tests := []struct {
+		segment model.Segment
+		tags    []model.Tag
+		matches bool
+	}{
+		{"api", []model.Tag{"api"}, true},
+		{"api", nil, false},
+		{"api and beta", []model.Tag{"beta", "api"}, true},
+		{"api and beta", []model.Tag{"api"}, false},
+		{"api or beta", []model.Tag{"beta"}, true},
+		{"api and (beta or early)", []model.Tag{"api", "early"}, true},
+		{"api and (beta or early)", []model.Tag{"beta", "early"}, false},
+	}
+	for _, test := range tests {
+		t.Run(string(test.segment), func(t *testing.T) {
+			is := is.New(t)
+
+			e, err := test.segment.Parse()
+			is.NoErr(err)
+			is.Equal(test.matches, e.Matches(test.tags))
+		})
+	}
+}
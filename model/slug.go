@@ -0,0 +1,58 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugMaxLength keeps slugs, and so archive URLs, reasonably short.
+const slugMaxLength = 80
+
+// CreateSlug for the newsletter issue URL from the title, such as "tips-and-tricks" from "Tips & Tricks!".
+// Slugs only have lowercase ASCII letters, digits, and single hyphens between them, so they're URL-safe.
+// Accents are removed, and anything else separates words. Titles without any of those get the slug "issue".
+func CreateSlug(title string) string {
+	title = strings.ReplaceAll(title, "&", " and ")
+
+	var b strings.Builder
+	hyphen := false
+	for _, r := range norm.NFKD.String(strings.ToLower(title)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r), r == '\'', r == '’':
+			// Accents and apostrophes don't separate words.
+		default:
+			hyphen = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > slugMaxLength {
+		slug = strings.TrimRight(slug[:slugMaxLength], "-")
+	}
+	if slug == "" {
+		return "issue"
+	}
+	return slug
+}
+
+// UniqueSlug from slug, with the lowest number suffix like "-2" that makes it not be one of taken.
+func UniqueSlug(slug string, taken []string) string {
+	isTaken := map[string]bool{}
+	for _, s := range taken {
+		isTaken[s] = true
+	}
+	unique := slug
+	for i := 2; isTaken[unique]; i++ {
+		unique = slug + "-" + strconv.Itoa(i)
+	}
+	return unique
+}
@@ -0,0 +1,52 @@
+package model_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+)
+
+func TestCreateSlug(t *testing.T) {
+	tests := []struct {
+		title string
+		slug  string
+	}{
+		{"First issue", "first-issue"},
+		{"Tips & Tricks!", "tips-and-tricks"},
+		{"  What's new in Go 1.18?  ", "whats-new-in-go-1-18"},
+		{"Crème brûlée", "creme-brulee"},
+		{"<script>alert(1)</script>", "script-alert-1-script"},
+		{"--a--b--", "a-b"},
+		{"日本語", "issue"},
+		{"", "issue"},
+	}
+	t.Run("creates URL-safe slugs", func(t *testing.T) {
+		for _, test := range tests {
+			t.Run(test.title, func(t *testing.T) {
+				is := is.New(t)
+				is.Equal(test.slug, model.CreateSlug(test.title))
+			})
+		}
+	})
+
+	t.Run("cuts long titles without a trailing hyphen", func(t *testing.T) {
+		is := is.New(t)
+
+		slug := model.CreateSlug(strings.Repeat("a", 79) + " b" + strings.Repeat("c", 100))
+		is.Equal(strings.Repeat("a", 79), slug)
+	})
+}
+
+func TestUniqueSlug(t *testing.T) {
+	t.Run("adds the lowest number suffix that's not taken", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal("news", model.UniqueSlug("news", nil))
+		is.Equal("news", model.UniqueSlug("news", []string{"news-2"}))
+		is.Equal("news-2", model.UniqueSlug("news", []string{"news"}))
+		is.Equal("news-4", model.UniqueSlug("news", []string{"news", "news-2", "news-3", "news-letter"}))
+	})
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"time"
+)
+
+// WebhookEventTypes are the domain events a WebhookEndpoint can subscribe to, in the order they're shown.
+var WebhookEventTypes = []string{EventSubscriberSignedUp, EventSubscriberConfirmed, EventSubscriberUnsubscribed,
+	EventSubscriberSuppressed}
+
+// IsWebhookEventType if it's one of WebhookEventTypes.
+func IsWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if eventType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEndpoint that gets the domain events of its EventTypes POSTed to its URL, signed with its Secret.
+type WebhookEndpoint struct {
+	ID         int64
+	URL        string
+	Secret     string
+	EventTypes []string
+	// Enabled endpoints get deliveries. Endpoints are disabled after too many deliveries failed in a row,
+	// with the reason in DisabledReason, and get none until an admin enables them again.
+	Enabled             bool
+	ConsecutiveFailures int
+	DisabledReason      string
+	Created             time.Time
+	Updated             time.Time
+}
+
+// HasEventType if the endpoint subscribes to the event type.
+func (e WebhookEndpoint) HasEventType(eventType string) bool {
+	for _, t := range e.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDeliveryState string
+
+const (
+	// WebhookDeliveryStatePending is for a delivery that hasn't been attempted yet, or is retried later.
+	WebhookDeliveryStatePending WebhookDeliveryState = "pending"
+	// WebhookDeliveryStateDelivered is for a delivery the endpoint responded to with a 2xx status code.
+	WebhookDeliveryStateDelivered WebhookDeliveryState = "delivered"
+	// WebhookDeliveryStateFailed is for a delivery that's not retried anymore, after a 4xx or too many attempts.
+	WebhookDeliveryStateFailed WebhookDeliveryState = "failed"
+	// WebhookDeliveryStateSkipped is for a delivery that wasn't attempted, because the endpoint was disabled.
+	WebhookDeliveryStateSkipped WebhookDeliveryState = "skipped"
+)
+
+// WebhookDelivery of a domain event to a WebhookEndpoint, with the JSON of the event in Payload.
+type WebhookDelivery struct {
+	ID         int64
+	EndpointID int64
+	EventID    string
+	EventType  string
+	Payload    string
+	State      WebhookDeliveryState
+	// Attempts made so far, and their AttemptLog, which is only listed with the delivery for the delivery log.
+	Attempts   int
+	AttemptLog []WebhookDeliveryAttempt
+	Created    time.Time
+	Updated    time.Time
+}
+
+// WebhookDeliveryAttempt of a WebhookDelivery, with the StatusCode of the response,
+// or 0 and the Error if there was none, like after a timeout.
+type WebhookDeliveryAttempt struct {
+	DeliveryID int64
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+	Created    time.Time
+}
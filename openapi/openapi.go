@@ -0,0 +1,176 @@
+// Package openapi builds OpenAPI 3 documents, with the schemas derived from Go types, and validates JSON against them.
+// Only what the app needs is supported.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Version of the OpenAPI specification of the documents.
+const Version = "3.0.3"
+
+// Document is an OpenAPI document, marshalled to JSON as it is.
+type Document struct {
+	OpenAPI string `json:"openapi"`
+	Info    Info   `json:"info"`
+	// Paths with the operations by their method in lowercase, like "post".
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+// Info about the API of the Document.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Components of the Document, which schemas refer to.
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme for authenticating requests, like "http" with the "bearer" scheme.
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operation on a path.
+type Operation struct {
+	OperationID string   `json:"operationId"`
+	Summary     string   `json:"summary,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Security requirements, by the name of the security scheme and its scopes.
+	Security    []map[string][]string `json:"security,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	// Responses by their status code, like "200".
+	Responses map[string]*Response `json:"responses"`
+}
+
+// Parameter of an Operation, in the path, query, or a header.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody of an Operation, by media type.
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response of an Operation, by media type. Responses without a body have no content.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType of a request or response body.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// New Document with the info, and no paths yet.
+func New(info Info) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas:         map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{},
+		},
+	}
+}
+
+// Add the operation on the path, like "/api/subscribers/{id}", with the HTTP method.
+// It panics if the path already has an operation with the method, since that's a mistake in building the document.
+func (d *Document) Add(method, path string, op Operation) {
+	method = strings.ToLower(method)
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]*Operation{}
+	}
+	if _, ok := d.Paths[path][method]; ok {
+		panic(fmt.Sprintf("openapi: %v %v added twice", method, path))
+	}
+	d.Paths[path][method] = &op
+}
+
+// Operation with the method on the path, or nil if there's none.
+func (d *Document) Operation(method, path string) *Operation {
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// Schema of the type of v, added to the components with the name, and returned as a reference to it.
+// Adding another type with the same name panics, because one would silently replace the other.
+func (d *Document) Schema(name string, v any) *Schema {
+	s := SchemaOf(v)
+	if existing, ok := d.Components.Schemas[name]; ok && !reflect.DeepEqual(existing, s) {
+		panic("openapi: different schemas named " + name)
+	}
+	d.Components.Schemas[name] = s
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// JSON content with the schema, for a RequestBody or Response.
+func JSON(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// ValidateRequest body with the content type against the request body schema of the operation.
+func (d *Document) ValidateRequest(method, path, contentType string, body []byte) error {
+	op := d.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("no operation %v %v", method, path)
+	}
+	if op.RequestBody == nil {
+		return fmt.Errorf("%v %v has no request body", method, path)
+	}
+	mt, ok := op.RequestBody.Content[mediaType(contentType)]
+	if !ok {
+		return fmt.Errorf("%v %v has no request body of type %v", method, path, contentType)
+	}
+	return d.Validate(mt.Schema, body, ValidateOptions{})
+}
+
+// ValidateResponse body with the status code and content type against the response schema of the operation.
+// Properties not in the schema are invalid, so responses can't have more than is documented.
+func (d *Document) ValidateResponse(method, path string, code int, contentType string, body []byte) error {
+	op := d.Operation(method, path)
+	if op == nil {
+		return fmt.Errorf("no operation %v %v", method, path)
+	}
+	res, ok := op.Responses[fmt.Sprint(code)]
+	if !ok {
+		return fmt.Errorf("%v %v has no %v %v response", method, path, code, http.StatusText(code))
+	}
+	if len(res.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("%v %v has no body in the %v response", method, path, code)
+		}
+		return nil
+	}
+	mt, ok := res.Content[mediaType(contentType)]
+	if !ok {
+		return fmt.Errorf("%v %v has no %v response of type %v", method, path, code, contentType)
+	}
+	return d.Validate(mt.Schema, body, ValidateOptions{Strict: true})
+}
+
+// mediaType of the content type, without parameters like the charset.
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(strings.ToLower(mt))
+}
@@ -0,0 +1,147 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/openapi"
+)
+
+type embedded struct {
+	Created time.Time `json:"created"`
+}
+
+type thing struct {
+	embedded
+	ID       int64             `json:"id"`
+	Email    string            `json:"email" openapi:"format=email" doc:"Where to send it."`
+	Status   string            `json:"status,omitempty" openapi:"enum=new|old"`
+	Parent   *int64            `json:"parent"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Untagged bool
+	Skipped  string `json:"-"`
+	private  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	t.Run("describes a struct like encoding/json marshals it", func(t *testing.T) {
+		is := is.New(t)
+
+		s := openapi.SchemaOf(thing{})
+		is.Equal("object", s.Type)
+		is.Equal([]string{"created", "id", "email", "parent", "Untagged"}, s.Required)
+		is.Equal(8, len(s.Properties))
+
+		is.Equal(&openapi.Schema{Type: "string", Format: "date-time"}, s.Properties["created"])
+		is.Equal(&openapi.Schema{Type: "integer", Format: "int64"}, s.Properties["id"])
+		is.Equal(&openapi.Schema{Type: "string", Format: "email", Description: "Where to send it."}, s.Properties["email"])
+		is.Equal(&openapi.Schema{Type: "string", Enum: []string{"new", "old"}}, s.Properties["status"])
+		is.Equal(&openapi.Schema{Type: "integer", Format: "int64", Nullable: true}, s.Properties["parent"])
+		is.Equal(&openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string"}}, s.Properties["tags"])
+		is.Equal(&openapi.Schema{Type: "object", AdditionalProperties: &openapi.Schema{Type: "string"}}, s.Properties["labels"])
+		is.Equal(&openapi.Schema{Type: "boolean"}, s.Properties["Untagged"])
+	})
+
+	t.Run("panics on types marshalling themselves", func(t *testing.T) {
+		is := is.New(t)
+
+		defer func() {
+			is.True(recover() != nil)
+		}()
+		openapi.SchemaOf(json.RawMessage{})
+	})
+}
+
+func TestDocument_Validate(t *testing.T) {
+	d := openapi.New(openapi.Info{Title: "Test", Version: "1"})
+	ref := d.Schema("thing", thing{})
+
+	valid := `{"created":"2022-12-10T12:00:00Z","id":1,"email":"me@example.com","status":"new","parent":null,` +
+		`"tags":["a"],"labels":{"a":"b"},"Untagged":true}`
+
+	t.Run("accepts valid JSON", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(d.Validate(ref, []byte(valid), openapi.ValidateOptions{Strict: true}))
+	})
+
+	tests := []struct {
+		name    string
+		replace [2]string
+		err     string
+	}{
+		{"missing required property", [2]string{`"id":1,`, ""}, "$: id is required"},
+		{"wrong type", [2]string{`"id":1`, `"id":"1"`}, `$.id: 1 isn't an integer`},
+		{"fraction for an integer", [2]string{`"id":1`, `"id":1.5`}, `$.id: 1.5 isn't an integer`},
+		{"invalid format", [2]string{`"me@example.com"`, `"me"`}, `$.email: "me" isn't an email address`},
+		{"invalid date-time", [2]string{`"2022-12-10T12:00:00Z"`, `"yesterday"`}, `$.created: "yesterday" isn't a date-time`},
+		{"value not in enum", [2]string{`"new"`, `"newer"`}, `$.status: "newer" isn't one of new, old`},
+		{"null for non-nullable", [2]string{`"tags":["a"]`, `"tags":null`}, `$.tags: can't be null`},
+		{"invalid array item", [2]string{`["a"]`, `[1]`}, `$.tags[0]: 1 isn't a string`},
+		{"invalid additional property", [2]string{`{"a":"b"}`, `{"a":true}`}, `$.labels.a: true isn't a string`},
+	}
+	for _, test := range tests {
+		t.Run("rejects "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			data := strings.Replace(valid, test.replace[0], test.replace[1], 1)
+			err := d.Validate(ref, []byte(data), openapi.ValidateOptions{})
+			is.True(err != nil)
+			is.Equal(test.err, err.Error())
+		})
+	}
+
+	t.Run("rejects unknown properties only when strict", func(t *testing.T) {
+		is := is.New(t)
+
+		data := strings.Replace(valid, `{"created"`, `{"extra":1,"created"`, 1)
+		is.NoErr(d.Validate(ref, []byte(data), openapi.ValidateOptions{}))
+		err := d.Validate(ref, []byte(data), openapi.ValidateOptions{Strict: true})
+		is.True(err != nil)
+		is.Equal("$: extra isn't in the schema", err.Error())
+	})
+}
+
+func TestDocument_ValidateResponse(t *testing.T) {
+	d := openapi.New(openapi.Info{Title: "Test", Version: "1"})
+	d.Add(http.MethodGet, "/things/{id}", openapi.Operation{
+		OperationID: "getThing",
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "The thing.", Content: openapi.JSON(d.Schema("thing", thing{}))},
+			"404": {Description: "No such thing."},
+		},
+	})
+
+	t.Run("validates against the response with the status code and media type", func(t *testing.T) {
+		is := is.New(t)
+
+		err := d.ValidateResponse(http.MethodGet, "/things/{id}", http.StatusOK, "application/json; charset=utf-8", []byte(`{}`))
+		is.True(err != nil)
+		is.Equal("$: created is required", err.Error())
+
+		is.NoErr(d.ValidateResponse(http.MethodGet, "/things/{id}", http.StatusNotFound, "", nil))
+	})
+
+	t.Run("rejects undocumented responses", func(t *testing.T) {
+		is := is.New(t)
+
+		is.True(d.ValidateResponse(http.MethodGet, "/things/{id}", http.StatusTeapot, "", nil) != nil)
+		is.True(d.ValidateResponse(http.MethodGet, "/things/{id}", http.StatusOK, "text/html", []byte("<p>")) != nil)
+		is.True(d.ValidateResponse(http.MethodPost, "/things/{id}", http.StatusOK, "application/json", []byte("{}")) != nil)
+	})
+
+	t.Run("panics on adding an operation twice", func(t *testing.T) {
+		is := is.New(t)
+
+		defer func() {
+			is.True(recover() != nil)
+		}()
+		d.Add(http.MethodGet, "/things/{id}", openapi.Operation{})
+	})
+}
@@ -0,0 +1,131 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema of a value, the subset of JSON Schema that OpenAPI 3.0 uses.
+type Schema struct {
+	// Ref to a schema in the components, like "#/components/schemas/problem". Nothing else is set with it.
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// SchemaOf the type of v, as encoding/json marshals it, so the schema can't drift from the type.
+//
+// Struct fields are named by their json tag, and are required unless they have omitempty.
+// Pointers are nullable. Times are date-time strings. Maps are objects with their values as additional properties.
+// Fields can have an openapi tag with a comma-separated list of a format and enum values separated by |,
+// like `openapi:"format=email"` or `openapi:"enum=created|already_subscribed"`, and a doc tag with a description.
+//
+// Types marshalling themselves can't be described, and SchemaOf panics on them, and on other types JSON can't have.
+func SchemaOf(v any) *Schema {
+	return schemaOf(reflect.TypeOf(v))
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		s := schemaOf(t.Elem())
+		s.Nullable = true
+		return s
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if t.Implements(marshalerType) {
+		panic("openapi: can't describe " + t.String() + ", which marshals itself")
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			panic("openapi: can't describe " + t.String() + ", which doesn't have string keys")
+		}
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		addFields(s, t)
+		return s
+	default:
+		panic("openapi: can't describe " + t.String())
+	}
+}
+
+// addFields of the struct type t to the object schema s, including the fields of embedded structs.
+func addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(s, f.Type)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		p := schemaOf(f.Type)
+		p.Description = f.Tag.Get("doc")
+		for _, option := range strings.Split(f.Tag.Get("openapi"), ",") {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "format":
+				p.Format = value
+			case "enum":
+				p.Enum = strings.Split(value, "|")
+			}
+		}
+		s.Properties[name] = p
+		if !hasOption(options, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
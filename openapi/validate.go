@@ -0,0 +1,148 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidateOptions for Document.Validate.
+type ValidateOptions struct {
+	// Strict makes properties that aren't in the schema of an object invalid, unless it has additional properties.
+	Strict bool
+}
+
+// Validate the JSON in data against the schema, resolving references to the components of the Document.
+// The error says where in the JSON the first invalid value is, like "$.subscribers[0].email".
+func (d *Document) Validate(s *Schema, data []byte, opts ValidateOptions) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if dec.More() {
+		return fmt.Errorf("invalid JSON: more than one value")
+	}
+	return d.validate(s, v, "$", opts)
+}
+
+func (d *Document) validate(s *Schema, v any, path string, opts ValidateOptions) error {
+	if s.Ref != "" {
+		ref, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%v: unknown reference %v", path, s.Ref)
+		}
+		return d.validate(ref, v, path, opts)
+	}
+
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%v: can't be null", path)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%v: %v isn't a string", path, v)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return fmt.Errorf("%v: %q isn't one of %v", path, str, strings.Join(s.Enum, ", "))
+		}
+		switch s.Format {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%v: %q isn't a date-time", path, str)
+			}
+		case "email":
+			if a, err := mail.ParseAddress(str); err != nil || a.Address != str {
+				return fmt.Errorf("%v: %q isn't an email address", path, str)
+			}
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%v: %v isn't a boolean", path, v)
+		}
+
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%v: %v isn't an integer", path, v)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%v: %v isn't an integer", path, v)
+		}
+
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%v: %v isn't a number", path, v)
+		}
+
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%v: isn't an array", path)
+		}
+		for i, item := range items {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%v[%v]", path, i), opts); err != nil {
+				return err
+			}
+		}
+
+	case "object":
+		object, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%v: isn't an object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%v: %v is required", path, name)
+			}
+		}
+		// Sorted, so the error is always about the same property.
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			switch {
+			case ok:
+			case s.AdditionalProperties != nil:
+				p = s.AdditionalProperties
+			case opts.Strict:
+				return fmt.Errorf("%v: %v isn't in the schema", path, name)
+			default:
+				continue
+			}
+			if err := d.validate(p, object[name], path+"."+name, opts); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("%v: unknown type %v", path, s.Type)
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// Package secrets gets secret values from AWS Secrets Manager and SSM Parameter Store,
+// for settings in the configuration given as references to them.
+//
+// The clients are the ones of the AWS SDK, with the credentials of an aws.Config,
+// and an optional endpoint URL, so local development can point them at something like localstack.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsmanagertypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrNotFound is for secrets and parameters that don't exist.
+var ErrNotFound = errors.New("not found")
+
+// secretsManagerClient has the secretsmanager.Client method used by SecretsManager, so it can be faked in tests.
+type secretsManagerClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SecretsManager gets secrets from AWS Secrets Manager.
+type SecretsManager struct {
+	client secretsManagerClient
+}
+
+// NewSecretsManager with the credentials and region of the AWS config.
+// The endpoint URL overrides the default endpoint of the region, if not empty.
+func NewSecretsManager(config aws.Config, endpointURL string) *SecretsManager {
+	return &SecretsManager{client: secretsmanager.NewFromConfig(config, func(o *secretsmanager.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})}
+}
+
+// GetSecret value of the secret with the name or ARN, which must be a string.
+func (s *SecretsManager) GetSecret(ctx context.Context, name string) (string, error) {
+	output, err := s.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &name})
+	if err != nil {
+		var notFound *secretsmanagertypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return "", ErrNotFound
+		}
+		return "", apiError("Secrets Manager", err)
+	}
+	if output.SecretString == nil {
+		return "", errors.New("secret has no string value")
+	}
+	return *output.SecretString, nil
+}
+
+// ssmClient has the ssm.Client method used by ParameterStore, so it can be faked in tests.
+type ssmClient interface {
+	GetParameter(ctx context.Context, params *ssm.GetParameterInput, optFns ...func(*ssm.Options)) (*ssm.GetParameterOutput, error)
+}
+
+// ParameterStore gets parameters from SSM Parameter Store.
+type ParameterStore struct {
+	client ssmClient
+}
+
+// NewParameterStore with the credentials and region of the AWS config.
+// The endpoint URL overrides the default endpoint of the region, if not empty.
+func NewParameterStore(config aws.Config, endpointURL string) *ParameterStore {
+	return &ParameterStore{client: ssm.NewFromConfig(config, func(o *ssm.Options) {
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})}
+}
+
+// GetSecret value of the parameter with the name, like /canvas/db-password. Secure strings are decrypted.
+func (p *ParameterStore) GetSecret(ctx context.Context, name string) (string, error) {
+	output, err := p.client.GetParameter(ctx, &ssm.GetParameterInput{Name: &name, WithDecryption: aws.Bool(true)})
+	if err != nil {
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", ErrNotFound
+		}
+		return "", apiError("SSM", err)
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", errors.New("parameter has no value")
+	}
+	return *output.Parameter.Value, nil
+}
+
+// apiError of the service, with the status, the code, and the message from the service, if there are any.
+// Responses that can't be decoded are only named, because their body may have a secret value.
+func apiError(service string, err error) error {
+	var deserializationErr *smithy.DeserializationError
+	if errors.As(err, &deserializationErr) {
+		return fmt.Errorf("error decoding %v response", service)
+	}
+	var responseErr *awshttp.ResponseError
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &responseErr) && errors.As(err, &apiErr):
+		return fmt.Errorf("%v responded with status %v, %v: %v", service, responseErr.HTTPStatusCode(), apiErr.ErrorCode(), apiErr.ErrorMessage())
+	case errors.As(err, &responseErr):
+		return fmt.Errorf("%v responded with status %v", service, responseErr.HTTPStatusCode())
+	default:
+		return fmt.Errorf("error calling %v: %w", service, err)
+	}
+}
@@ -0,0 +1,163 @@
+package secrets_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/matryer/is"
+
+	"canvas/secrets"
+)
+
+// newAWSServer responding to requests with the handler, and an AWS config and endpoint URL for it.
+func newAWSServer(t *testing.T, h http.HandlerFunc) (aws.Config, string) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+	}, srv.URL
+}
+
+// roundTripperFunc is an http.RoundTripper from a function.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newRecordingConfig with a fake transport that records the URLs of requests and responds with the body.
+func newRecordingConfig(urls *[]string, body string) aws.Config {
+	return aws.Config{
+		Region:      "eu-west-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			*urls = append(*urls, r.URL.String())
+			res := httptest.NewRecorder()
+			_, _ = res.WriteString(body)
+			return res.Result(), nil
+		})},
+	}
+}
+
+func TestSecretsManager_GetSecret(t *testing.T) {
+	t.Run("gets the secret string with a signed request", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			is.Equal(http.MethodPost, r.Method)
+			is.Equal("secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+			is.True(strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request"))
+			var req map[string]string
+			is.NoErr(json.NewDecoder(r.Body).Decode(&req))
+			is.Equal("prod/db", req["SecretId"])
+			_, _ = w.Write([]byte(`{"Name":"prod/db","SecretString":"hunter2"}`))
+		})
+
+		v, err := secrets.NewSecretsManager(config, url).GetSecret(context.Background(), "prod/db")
+		is.NoErr(err)
+		is.Equal("hunter2", v)
+	})
+
+	t.Run("uses the default endpoint of the region without an endpoint URL, and the endpoint URL with it", func(t *testing.T) {
+		is := is.New(t)
+
+		var urls []string
+		config := newRecordingConfig(&urls, `{"SecretString":"hunter2"}`)
+		_, err := secrets.NewSecretsManager(config, "").GetSecret(context.Background(), "prod/db")
+		is.NoErr(err)
+		_, err = secrets.NewSecretsManager(config, "http://localhost:4566").GetSecret(context.Background(), "prod/db")
+		is.NoErr(err)
+		is.Equal([]string{"https://secretsmanager.eu-west-1.amazonaws.com/", "http://localhost:4566/"}, urls)
+	})
+
+	t.Run("returns ErrNotFound for secrets that don't exist", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`))
+		})
+
+		_, err := secrets.NewSecretsManager(config, url).GetSecret(context.Background(), "nope")
+		is.True(errors.Is(err, secrets.ErrNotFound))
+	})
+
+	t.Run("returns other errors with the type and message", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"AccessDeniedException","message":"Not allowed."}`))
+		})
+
+		_, err := secrets.NewSecretsManager(config, url).GetSecret(context.Background(), "prod/db")
+		is.Equal("Secrets Manager responded with status 400, AccessDeniedException: Not allowed.", err.Error())
+	})
+
+	t.Run("errors on binary secrets", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"Name":"prod/db","SecretBinary":"aHVudGVyMg=="}`))
+		})
+
+		_, err := secrets.NewSecretsManager(config, url).GetSecret(context.Background(), "prod/db")
+		is.True(err != nil)
+	})
+}
+
+func TestParameterStore_GetSecret(t *testing.T) {
+	t.Run("gets the decrypted parameter value", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			is.Equal("AmazonSSM.GetParameter", r.Header.Get("X-Amz-Target"))
+			is.True(strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/ssm/aws4_request"))
+			var req struct {
+				Name           string
+				WithDecryption bool
+			}
+			is.NoErr(json.NewDecoder(r.Body).Decode(&req))
+			is.Equal("/canvas/db-password", req.Name)
+			is.True(req.WithDecryption)
+			_, _ = w.Write([]byte(`{"Parameter":{"Name":"/canvas/db-password","Type":"SecureString","Value":"hunter2"}}`))
+		})
+
+		v, err := secrets.NewParameterStore(config, url).GetSecret(context.Background(), "/canvas/db-password")
+		is.NoErr(err)
+		is.Equal("hunter2", v)
+	})
+
+	t.Run("uses the default endpoint of the region without an endpoint URL, and the endpoint URL with it", func(t *testing.T) {
+		is := is.New(t)
+
+		var urls []string
+		config := newRecordingConfig(&urls, `{"Parameter":{"Value":"hunter2"}}`)
+		_, err := secrets.NewParameterStore(config, "").GetSecret(context.Background(), "/canvas/db-password")
+		is.NoErr(err)
+		_, err = secrets.NewParameterStore(config, "http://localhost:4566").GetSecret(context.Background(), "/canvas/db-password")
+		is.NoErr(err)
+		is.Equal([]string{"https://ssm.eu-west-1.amazonaws.com/", "http://localhost:4566/"}, urls)
+	})
+
+	t.Run("returns ErrNotFound for namespaced not found errors", func(t *testing.T) {
+		is := is.New(t)
+
+		config, url := newAWSServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"com.amazonaws.ssm#ParameterNotFound"}`))
+		})
+
+		_, err := secrets.NewParameterStore(config, url).GetSecret(context.Background(), "/nope")
+		is.True(errors.Is(err, secrets.ErrNotFound))
+	})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEOptions for serving HTTPS with certificates that are requested and renewed automatically over ACME,
+// like from Let's Encrypt.
+type ACMEOptions struct {
+	// Cache of the certificates and the account key, like autocert.DirCache, or storage.Database.ACMECache,
+	// so all instances of the app share them. Without one, certificates are requested again on every start.
+	Cache autocert.Cache
+	// Client for the certificate authority, for tests. Defaults to one for DirectoryURL.
+	Client *acme.Client
+	// DirectoryURL of the certificate authority, like the one of the Let's Encrypt staging environment.
+	// Defaults to Let's Encrypt.
+	DirectoryURL string
+	// Email of the account with the certificate authority, for notices like about expiring certificates.
+	Email string
+	// Hosts that certificates are requested for. Connections for any other host, like for the IP address by scanners,
+	// fail without contacting the certificate authority, so they can't use up its rate limits.
+	Hosts []string
+	// HTTPPort of the listener that answers the HTTP-01 challenges, and redirects everything else to HTTPS.
+	// Defaults to 80.
+	HTTPPort int
+}
+
+// newACMEManager for the options, which only requests certificates for the hosts in them.
+// The certificates are renewed before they expire, as long as the server is running.
+func newACMEManager(opts ACMEOptions) *autocert.Manager {
+	client := opts.Client
+	if client == nil && opts.DirectoryURL != "" {
+		client = &acme.Client{DirectoryURL: opts.DirectoryURL}
+	}
+	return &autocert.Manager{
+		Cache:      opts.Cache,
+		Client:     client,
+		Email:      opts.Email,
+		HostPolicy: autocert.HostWhitelist(opts.Hosts...),
+		Prompt:     autocert.AcceptTOS,
+	}
+}
+
+// acmeTLSConfig of the manager, which logs the hosts it doesn't have certificates for.
+func acmeTLSConfig(m *autocert.Manager, log *zap.Logger) *tls.Config {
+	config := m.TLSConfig()
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := m.GetCertificate(hello)
+		if err != nil {
+			log.Info("Error getting certificate", zap.String("host", hello.ServerName), zap.Error(err))
+		}
+		return cert, err
+	}
+	return config
+}
+
+// newRedirectServer on the HTTP port in opts, which answers the HTTP-01 challenges of the manager,
+// and redirects everything else to HTTPS.
+func newRedirectServer(host string, opts ACMEOptions, m *autocert.Manager) *http.Server {
+	if opts.HTTPPort == 0 {
+		opts.HTTPPort = 80
+	}
+	return &http.Server{
+		Addr:              net.JoinHostPort(host, strconv.Itoa(opts.HTTPPort)),
+		Handler:           m.HTTPHandler(nil),
+		ReadTimeout:       5 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		IdleTimeout:       5 * time.Second,
+	}
+}
@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"canvas/server"
+)
+
+// memoryCache is an autocert.Cache in memory.
+type memoryCache struct {
+	mutex sync.Mutex
+	data  map[string][]byte
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	data, ok := c.data[key]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *memoryCache) Put(ctx context.Context, key string, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.data[key] = data
+	return nil
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.data, key)
+	return nil
+}
+
+// newCertificateAuthority that fails every request, counting them in requests.
+func newCertificateAuthority(t *testing.T, requests *int64) *acme.Client {
+	t.Helper()
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		http.Error(w, "no certificates here", http.StatusInternalServerError)
+	}))
+	t.Cleanup(ca.Close)
+	return &acme.Client{DirectoryURL: ca.URL}
+}
+
+// selfSignedCertificate for the host, in the format autocert caches it in, with the key first.
+func selfSignedCertificate(t *testing.T, host string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+// hello from a client for the host that supports ECDSA certificates.
+func hello(host string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        host,
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		SupportedVersions: []uint16{tls.VersionTLS12},
+	}
+}
+
+func TestServer_ACME(t *testing.T) {
+	newServer := func(t *testing.T, cache autocert.Cache, requests *int64) *server.Server {
+		return server.New(server.Options{ACME: &server.ACMEOptions{
+			Cache:  cache,
+			Client: newCertificateAuthority(t, requests),
+			Hosts:  []string{"example.com"},
+		}})
+	}
+
+	t.Run("doesn't request certificates for hosts that aren't allowed", func(t *testing.T) {
+		is := is.New(t)
+
+		var requests int64
+		cache := &memoryCache{data: map[string][]byte{}}
+		s := newServer(t, cache, &requests)
+
+		for _, host := range []string{"scanner.example.net", "www.example.com", "203.0.113.1"} {
+			_, err := s.TLSConfig().GetCertificate(hello(host))
+			is.True(err != nil)
+		}
+		is.Equal(int64(0), atomic.LoadInt64(&requests))
+		is.Equal(0, len(cache.data))
+	})
+
+	t.Run("serves certificates from the cache without contacting the certificate authority", func(t *testing.T) {
+		is := is.New(t)
+
+		var requests int64
+		cache := &memoryCache{data: map[string][]byte{"example.com": selfSignedCertificate(t, "example.com")}}
+		s := newServer(t, cache, &requests)
+
+		cert, err := s.TLSConfig().GetCertificate(hello("example.com"))
+		is.NoErr(err)
+		is.Equal("example.com", cert.Leaf.Subject.CommonName)
+		is.Equal(int64(0), atomic.LoadInt64(&requests))
+	})
+
+	t.Run("only serves HTTP without ACME", func(t *testing.T) {
+		is := is.New(t)
+
+		is.True(server.New(server.Options{}).TLSConfig() == nil)
+	})
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// GroupMiddleware is groupMiddleware, for replacing the middleware of the route groups in tests.
+type GroupMiddleware = groupMiddleware
+
+// RegisterRoutes with the group middleware m, and returns the router with them.
+func (s *Server) RegisterRoutes(m GroupMiddleware) http.Handler {
+	s.registerRoutes(m)
+	return s.mux
+}
+
+// TLSConfig of the HTTPS server, which is nil without ACME.
+func (s *Server) TLSConfig() *tls.Config {
+	return s.server.TLSConfig
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"canvas/build"
+	"canvas/handlers"
+)
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Internal server with only the health and readiness checks, metrics, and version routes of Server,
+// for processes without the web app, like the job queue worker, so probes and Prometheus still work.
+type Internal struct {
+	address string
+	log     *zap.Logger
+	mux     *chi.Mux
+	server  *http.Server
+}
+
+// InternalOptions for NewInternal.
+type InternalOptions struct {
+	// Database is checked at /health and /ready.
+	Database pinger
+	Host     string
+	Log      *zap.Logger
+	Metrics  *prometheus.Registry
+	Port     int
+}
+
+// NewInternal with the given options.
+// If no logger is provided, logs are discarded. If no metrics registry is provided, /metrics is empty.
+func NewInternal(opts InternalOptions) *Internal {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
+
+	mux := chi.NewMux()
+	handlers.Health(mux, opts.Database)
+	// The worker starts the internal server after startup, so it's ready right away.
+	handlers.Ready(mux, nil, opts.Database)
+	handlers.Metrics(mux, opts.Metrics)
+	handlers.Version(mux, build.Get())
+
+	return &Internal{
+		address: address,
+		log:     opts.Log,
+		mux:     mux,
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadTimeout:       5 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      5 * time.Second,
+			IdleTimeout:       5 * time.Second,
+		},
+	}
+}
+
+// ServeHTTP satisfies http.Handler.
+func (s *Internal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start the internal server, blocking until it's stopped.
+func (s *Internal) Start() error {
+	s.log.Info("Starting internal server", zap.String("address", s.address))
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error starting internal server: %w", err)
+	}
+	return nil
+}
+
+// Stop the internal server, waiting for requests in progress to finish until ctx is done.
+func (s *Internal) Stop(ctx context.Context) error {
+	s.log.Info("Stopping internal server")
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("error stopping internal server: %w", err)
+	}
+	return nil
+}
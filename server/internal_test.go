@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"canvas/server"
+)
+
+type pingerMock struct {
+	err error
+}
+
+func (p *pingerMock) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestInternal(t *testing.T) {
+	get := func(h http.Handler, target string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+		return res
+	}
+
+	t.Run("serves the health check, metrics, and version", func(t *testing.T) {
+		is := is.New(t)
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "app_test_total", Help: "Testing."}))
+		s := server.NewInternal(server.InternalOptions{Database: &pingerMock{}, Metrics: registry})
+
+		is.Equal(http.StatusOK, get(s, "/health").Code)
+
+		res := get(s, "/metrics")
+		is.Equal(http.StatusOK, res.Code)
+		is.True(strings.Contains(res.Body.String(), "app_test_total 0"))
+
+		is.Equal(http.StatusOK, get(s, "/version").Code)
+	})
+
+	t.Run("is unhealthy if the database is", func(t *testing.T) {
+		is := is.New(t)
+
+		s := server.NewInternal(server.InternalOptions{Database: &pingerMock{err: errors.New("connection refused")}})
+		is.Equal(http.StatusBadGateway, get(s, "/health").Code)
+	})
+
+	t.Run("has nothing else", func(t *testing.T) {
+		is := is.New(t)
+
+		s := server.NewInternal(server.InternalOptions{Database: &pingerMock{}})
+		is.Equal(http.StatusNotFound, get(s, "/").Code)
+		is.Equal(http.StatusNotFound, get(s, "/admin").Code)
+	})
+}
@@ -1,16 +1,263 @@
 package server
 
 import (
+	"canvas/assets"
+	"canvas/build"
+	"canvas/flags"
 	"canvas/handlers"
 	"canvas/model"
+	"canvas/sns"
+	"canvas/views"
 	"context"
+	"net/http"
+
+	"github.com/go-chi/chi"
 )
 
+// maxBrowserBodySize of requests to Browser routes, for subscriber imports of up to 20 MB and the rest of their form.
+const maxBrowserBodySize = 21 << 20
+
+// groupMiddleware for each route group, applied in order after the request ID, tracing, recovery, and feature flag middleware
+// every route has.
+// Each group only has the middleware its routes need, so adding middleware to one can't change the others.
+type groupMiddleware struct {
+	// Browser routes are the public HTML pages and the admin pages. Their bodies are limited, and forms can override the method.
+	// They have security headers and sessions, are translated, and state-changing requests must have a CSRF token.
+	Browser []func(next http.Handler) http.Handler
+	// Admin routes under /admin have the Browser middleware, and all but the login page require an admin login in the session.
+	Admin []func(next http.Handler) http.Handler
+	// API routes under /api are JSON for widgets and apps. They can be called cross-origin, and never have sessions,
+	// cookies, or CSRF protection, because nothing about them comes from a browser session.
+	API []func(next http.Handler) http.Handler
+	// AdminAPI routes under /api/admin have the API middleware, and require an API token with the scope of the route.
+	AdminAPI []func(next http.Handler) http.Handler
+	// Webhooks under /webhooks are called by other services, which sign their requests.
+	// They don't have sessions or CSRF protection.
+	Webhooks []func(next http.Handler) http.Handler
+	// Embed routes under /embed are for partner sites to frame or fetch. They have security headers, are translated, and can be called
+	// cross-origin by the partner sites, but never have sessions or cookies, which browsers block in frames anyway.
+	Embed []func(next http.Handler) http.Handler
+}
+
+// groupMiddleware with the middleware for each route group, configured from the server.
+func (s *Server) groupMiddleware() groupMiddleware {
+	var m groupMiddleware
+	// The limit is before the method override, which reads forms. Subscriber imports are the largest bodies.
+	m.Browser = append(m.Browser, handlers.MaxBytes(maxBrowserBodySize), handlers.MethodOverride)
+	if s.sessions != nil {
+		m.Browser = append(m.Browser, s.sessions.Middleware)
+	}
+	securityHeaders := handlers.SecurityHeaders(handlers.SecurityHeadersOptions{
+		ScriptSources: views.ScriptSources(s.captchaWidget()),
+	})
+	m.Browser = append(m.Browser,
+		securityHeaders,
+		handlers.Localize(s.catalog, s.log),
+		handlers.CSRF(handlers.CSRFOptions{Log: s.log}),
+	)
+	m.Admin = append(m.Admin, handlers.AdminAuth(s.log))
+	// Partner sites can call the API too, from the embedded signup form or their own.
+	apiOrigins := append(append([]string{}, s.corsAllowedOrigins...), s.embedPartnerOrigins...)
+	m.API = append(m.API, handlers.CORS(handlers.CORSOptions{AllowedOrigins: apiOrigins}))
+	m.AdminAPI = append(m.AdminAPI, handlers.APITokenAuth(s.database, s.log))
+	m.Embed = append(m.Embed,
+		securityHeaders,
+		handlers.CORS(handlers.CORSOptions{AllowedOrigins: s.embedPartnerOrigins}),
+		handlers.Localize(s.catalog, s.log),
+	)
+	return m
+}
+
+// captchaWidget of the signup captcha, or nil without one.
+func (s *Server) captchaWidget() *views.Captcha {
+	if s.signupCaptcha == nil {
+		return nil
+	}
+	c := s.signupCaptcha.Widget()
+	return &c
+}
+
 func (s *Server) setupRoutes() {
+	s.registerRoutes(s.groupMiddleware())
+}
+
+// registerRoutes in their groups, with the group middleware m.
+// Routes outside the groups, like the health check, static assets, and feeds, are for machines and caches,
+// and have no middleware of their own. Neither does the not found page, except for URLs under a mounted group.
+func (s *Server) registerRoutes(m groupMiddleware) {
+	s.mux.Use(handlers.FeatureFlags(s.flags))
+
+	handlers.NotFound(s.mux, s.log, s.metrics)
+	// Mounted routers get the not found handler before their middleware, because chi would otherwise wrap it
+	// in the middleware a second time.
+	notFound := s.mux.NotFoundHandler()
 	handlers.Health(s.mux, s.database)
-	handlers.FrontPage(s.mux)
-	handlers.NewsletterSignup(s.mux, s.database, s.queue)
-	handlers.NewsletterThanks(s.mux)
+	handlers.Ready(s.mux, s.readiness, s.database)
+	handlers.Metrics(s.mux, s.metrics)
+	handlers.Version(s.mux, build.Get())
+	handlers.Static(s.mux, assets.Default(), s.log)
+	handlers.Robots(s.mux, handlers.RobotsOptions{
+		BaseURL:     s.baseURL,
+		Disallow:    []string{"/admin/", "/api/", "/t/"},
+		DisallowAll: s.robotsDisallowAll,
+	})
+	pages := []string{"/"}
+	if s.flags.Enabled(flags.Archive, "") {
+		pages = append(pages, "/archive")
+	}
+	handlers.SitemapXML(s.mux, s.database, s.log, handlers.SitemapOptions{
+		BaseURL: s.baseURL,
+		Pages:   pages,
+	})
+	handlers.Feeds(s.mux, s.database, s.log, handlers.FeedOptions{
+		Author:      "Canvas",
+		BaseURL:     s.baseURL,
+		Description: "The Canvas newsletter.",
+		Title:       "Canvas",
+	})
+	// Called by mail providers, not from a browser session. The signed token in the URL protects it.
+	// One-click unsubscribes come from a few mail provider IP addresses, so they're not rate-limited per IP.
+	handlers.NewsletterUnsubscribeOneClick(s.mux, s.database, s.log, s.unsubscribeSecret)
+	// Loaded by mail clients from newsletter issue emails, as an image and links. The signed tokens protect them,
+	// so without a secret to sign them with, there's no tracking, and nothing to serve.
+	if len(s.trackingSecret) > 0 {
+		trackOpenOpts := handlers.TrackEmailOpenOptions{Secret: s.trackingSecret}
+		trackClickOpts := handlers.TrackEmailClickOptions{Secret: s.trackingSecret}
+		if s.queue != nil {
+			trackOpenOpts.Queue = s.queue
+			trackClickOpts.Queue = s.queue
+		}
+		handlers.TrackEmailOpen(s.mux, s.log, trackOpenOpts)
+		handlers.TrackEmailClick(s.mux, s.log, trackClickOpts)
+	}
+
+	signupOpts := handlers.SignupServiceOptions{
+		Captcha:         s.signupCaptcha,
+		CaptchaFailOpen: s.signupCaptchaFailOpen,
+		EmailPolicy:     s.signupEmailPolicy,
+		FormSecret:      s.signupFormSecret,
+		MaxFormAge:      s.signupMaxFormAge,
+		Metrics:         s.metrics,
+		MinFillTime:     s.signupMinFillTime,
+		PartnerOrigins:  s.embedPartnerOrigins,
+	}
+	if s.signupThrottleDB {
+		signupOpts.Throttle = s.database
+	}
+	signup := handlers.NewSignupService(s.database, s.log, signupOpts)
+
+	// The front page can be shown from a copy while the database is down. The copy is kept before the Browser middleware,
+	// because sessions need the database.
+	s.mux.Group(func(r chi.Router) {
+		if s.degradable != nil {
+			r.Use(s.degradable)
+		}
+		r.Use(m.Browser...)
+		handlers.FrontPage(r, s.log, s.signupFormSecret, s.baseURL, s.signupCaptcha)
+	})
+
+	s.mux.Group(func(r chi.Router) {
+		r.Use(m.Browser...)
+
+		handlers.SetLocale(r, s.catalog)
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.RequireFlag(flags.Archive, s.log))
+			handlers.Archive(r, s.database, s.log, s.baseURL)
+		})
+		handlers.NewsletterSignup(r, signup)
+		handlers.NewsletterThanks(r, s.log)
+		handlers.NewsletterResend(r, signup)
+		handlers.NewsletterConfirm(r, s.database, s.log, handlers.NewsletterConfirmOptions{TwoStep: s.twoStepConfirm})
+
+		r.Group(func(r chi.Router) {
+			r.Use(handlers.RateLimit(1, 10))
+			handlers.NewsletterUnsubscribe(r, s.database, s.log, s.unsubscribeSecret)
+		})
+	})
+
+	var dashboardOpts handlers.AdminDashboardOptions
+	if s.queue != nil {
+		dashboardOpts.Queue = s.queue
+	}
+	if s.scheduler != nil {
+		dashboardOpts.Scheduler = s.scheduler
+	}
+
+	s.mux.Route("/admin", func(r chi.Router) {
+		r.NotFound(notFound)
+		r.Use(m.Browser...)
+
+		handlers.AdminLogin(r, s.log, handlers.AdminLoginOptions{PasswordHash: s.adminPasswordHash})
+
+		r.Group(func(r chi.Router) {
+			r.Use(m.Admin...)
+			handlers.AdminLogout(r, s.log)
+			handlers.AdminDashboard(r, s.database, s.log, dashboardOpts)
+			handlers.AdminSubscribers(r, s.database, s.log)
+			handlers.AdminSubscriber(r, s.database, s.log)
+			handlers.AdminSubscriberActions(r, s.database, s.log)
+			handlers.AdminSubscriberTags(r, s.database, s.log)
+			handlers.AdminSubscriberExport(r, s.database, s.log, handlers.AdminSubscriberExportOptions{})
+			handlers.AdminSubscriberImport(r, s.database, s.log, handlers.AdminSubscriberImportOptions{
+				Catalog:     s.catalog,
+				EmailPolicy: s.signupEmailPolicy,
+			})
+			handlers.AdminSuppressions(r, s.database, s.log)
+			handlers.AdminAPITokens(r, s.database, s.log)
+			handlers.AdminWebhooks(r, s.database, s.log, handlers.AdminWebhooksOptions{
+				AllowPrivateNetworks: s.webhookAllowPrivateNetworks,
+			})
+			handlers.AdminNewsletterPreview(r, s.database, s.log, handlers.AdminNewsletterPreviewOptions{
+				BaseURL:         s.baseURL,
+				From:            s.emailFrom,
+				PhysicalAddress: s.emailPhysicalAddress,
+				Sender:          s.emailSender,
+			})
+			handlers.AdminNewsletterSend(r, s.database, s.log, handlers.AdminNewsletterSendOptions{Location: s.newsletterLocation})
+			handlers.AdminFlags(r, s.flags)
+			if s.logLevel != nil {
+				handlers.AdminLogLevel(r, s.logLevel)
+			}
+		})
+	})
+
+	s.mux.Route("/api", func(r chi.Router) {
+		r.NotFound(notFound)
+		r.Use(m.API...)
+		handlers.NewsletterSignupAPI(r, signup)
+		handlers.OpenAPI(r)
+
+		r.Route("/admin", func(r chi.Router) {
+			r.NotFound(notFound)
+			r.Use(m.AdminAPI...)
+			r.With(handlers.RequireAPIScope(model.APIScopeReadSubscribers)).Group(func(r chi.Router) {
+				handlers.AdminAPISubscribers(r, s.database, s.log)
+			})
+			r.With(handlers.RequireAPIScope(model.APIScopeWriteSubscribers)).Group(func(r chi.Router) {
+				handlers.AdminAPISubscriberActions(r, s.database, s.log)
+			})
+			r.With(handlers.RequireAPIScope(model.APIScopeSendNewsletter)).Group(func(r chi.Router) {
+				handlers.AdminAPINewsletterSend(r, s.database, s.log)
+			})
+		})
+	})
+
+	s.mux.Route("/embed", func(r chi.Router) {
+		r.NotFound(notFound)
+		r.Use(m.Embed...)
+		handlers.EmbedSignup(r, signup)
+	})
+
+	s.mux.Route("/webhooks", func(r chi.Router) {
+		r.NotFound(notFound)
+		r.Use(m.Webhooks...)
+		handlers.SESWebhook(r, s.database, s.log, handlers.SESWebhookOptions{
+			Metrics:                  s.metrics,
+			TransientBounceThreshold: s.sesTransientBounceThreshold,
+			TransientBounceWindow:    s.sesTransientBounceWindow,
+			Verifier:                 sns.NewVerifier(sns.NewVerifierOptions{}),
+		})
+	})
 }
 
 type signupperMock struct{}
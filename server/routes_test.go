@@ -0,0 +1,255 @@
+package server_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/matryer/is"
+
+	"canvas/email"
+	"canvas/handlers"
+	"canvas/server"
+	"canvas/server/servertest"
+)
+
+func TestServer_Routes(t *testing.T) {
+	// mark is middleware standing in for the real middleware of a group, adding its name to a header.
+	mark := func(name string) func(next http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	// stop stands in for the admin authentication, which doesn't get to the handlers without a session.
+	stop := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	s := server.New(server.Options{Database: servertest.NewStore(nil)})
+	mux := s.RegisterRoutes(server.GroupMiddleware{
+		Browser:  []func(next http.Handler) http.Handler{mark("browser")},
+		Admin:    []func(next http.Handler) http.Handler{mark("admin"), stop},
+		API:      []func(next http.Handler) http.Handler{mark("api")},
+		AdminAPI: []func(next http.Handler) http.Handler{mark("adminapi")},
+		Webhooks: []func(next http.Handler) http.Handler{mark("webhooks")},
+		Embed:    []func(next http.Handler) http.Handler{mark("embed")},
+	})
+
+	tests := []struct {
+		method     string
+		target     string
+		middleware string
+	}{
+		{http.MethodGet, "/", "browser"},
+		{http.MethodGet, "/newsletter/thanks", "browser"},
+		{http.MethodGet, "/admin/login", "browser"},
+		{http.MethodGet, "/admin", "browser,admin"},
+		{http.MethodGet, "/admin/subscribers", "browser,admin"},
+		{http.MethodGet, "/admin/subscribers/export", "browser,admin"},
+		{http.MethodGet, "/admin/newsletters/1/preview", "browser,admin"},
+		{http.MethodGet, "/admin/api-tokens", "browser,admin"},
+		{http.MethodGet, "/admin/nope", "browser"},
+		{http.MethodGet, "/api/nope", "api"},
+		{http.MethodPost, "/api/newsletter/signup", "api"},
+		{http.MethodOptions, "/api/newsletter/signup", "api"},
+		{http.MethodGet, "/api/admin/subscribers", "api,adminapi"},
+		{http.MethodPost, "/api/admin/newsletters/1/send", "api,adminapi"},
+		{http.MethodGet, "/api/admin/nope", "api,adminapi"},
+		{http.MethodPost, "/webhooks/ses", "webhooks"},
+		{http.MethodGet, "/embed/signup", "embed"},
+		{http.MethodGet, "/embed/nope", "embed"},
+		{http.MethodPost, "/newsletter/unsubscribe/one-click", ""},
+		{http.MethodGet, "/t/open/nope.gif", ""},
+		{http.MethodGet, "/t/click/nope", ""},
+		{http.MethodGet, "/version", ""},
+		{http.MethodGet, "/robots.txt", ""},
+		{http.MethodGet, "/nope", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			is := is.New(t)
+
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, httptest.NewRequest(test.method, test.target, strings.NewReader("")))
+			is.Equal(test.middleware, strings.Join(res.Header().Values("X-Middleware"), ","))
+		})
+	}
+}
+
+func TestServer_MethodOverride(t *testing.T) {
+	// reached stands in for the group middleware after routing, showing the route matched the method.
+	reached := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+
+	s := server.New(server.Options{Database: servertest.NewStore(nil)})
+	mux := s.RegisterRoutes(server.GroupMiddleware{
+		Browser:  []func(next http.Handler) http.Handler{handlers.MethodOverride},
+		Admin:    []func(next http.Handler) http.Handler{reached},
+		Webhooks: []func(next http.Handler) http.Handler{reached},
+	})
+	request := func(target string) int {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader("_method=DELETE"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		mux.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("routes admin forms with the overridden method", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(http.StatusNoContent, request("/admin/subscribers/1"))
+	})
+
+	t.Run("routes forms outside the Browser and Admin groups with their own method", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal(http.StatusNoContent, request("/webhooks/ses"))
+	})
+}
+
+func TestServer_Tracking(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token, err := email.CreateClickToken(secret, 1, 2, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("serves the tracking routes with a tracking secret", func(t *testing.T) {
+		is := is.New(t)
+
+		h, _ := servertest.New(t, server.Options{TrackingSecret: secret})
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/t/click/"+token, nil))
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("https://example.com", res.Header().Get("Location"))
+	})
+
+	t.Run("doesn't serve them without one", func(t *testing.T) {
+		is := is.New(t)
+
+		// An empty secret, since servertest.New sets one if it's nil.
+		h, _ := servertest.New(t, server.Options{TrackingSecret: []byte{}})
+		for _, target := range []string{"/t/click/" + token, "/t/open/x.gif"} {
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+			is.Equal(http.StatusNotFound, res.Code)
+		}
+	})
+}
+
+func TestServer_TrustedProxies(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := servertest.New(t, server.Options{TrustedProxies: []*net.IPNet{proxies}})
+
+	// unsubscribe through the rate limit of 10 requests in a burst, returning the status code.
+	unsubscribe := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/newsletter/unsubscribe", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	t.Run("rate-limits clients behind a trusted proxy by their own address", func(t *testing.T) {
+		is := is.New(t)
+
+		for i := 0; i < 10; i++ {
+			is.True(unsubscribe("10.0.0.1:1234", "192.0.2.1") != http.StatusTooManyRequests)
+		}
+		is.Equal(http.StatusTooManyRequests, unsubscribe("10.0.0.1:1234", "192.0.2.1"))
+		is.True(unsubscribe("10.0.0.1:1234", "192.0.2.2") != http.StatusTooManyRequests)
+	})
+
+	t.Run("rate-limits other peers by theirs, whatever they forward", func(t *testing.T) {
+		is := is.New(t)
+
+		for i := 0; i < 10; i++ {
+			is.True(unsubscribe("198.51.100.1:1234", fmt.Sprintf("192.0.2.%v", 100+i)) != http.StatusTooManyRequests)
+		}
+		is.Equal(http.StatusTooManyRequests, unsubscribe("198.51.100.1:1234", "192.0.2.200"))
+	})
+}
+
+func TestServer_PartnerOrigins(t *testing.T) {
+	mux, _ := servertest.New(t, server.Options{
+		CORSAllowedOrigins:  []string{"https://app.example.com"},
+		EmbedPartnerOrigins: []string{"https://partner.example.com"},
+	})
+
+	tests := []struct {
+		method  string
+		target  string
+		origin  string
+		allowed bool
+	}{
+		{http.MethodGet, "/embed/signup", "https://partner.example.com", true},
+		{http.MethodGet, "/embed/signup", "https://app.example.com", false},
+		{http.MethodGet, "/embed/signup", "https://evil.example.com", false},
+		{http.MethodOptions, "/api/newsletter/signup", "https://partner.example.com", true},
+		{http.MethodOptions, "/api/newsletter/signup", "https://app.example.com", true},
+		{http.MethodOptions, "/api/newsletter/signup", "https://evil.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target+" from "+test.origin, func(t *testing.T) {
+			is := is.New(t)
+
+			req := httptest.NewRequest(test.method, test.target, nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			res := httptest.NewRecorder()
+			mux.ServeHTTP(res, req)
+
+			expected := ""
+			if test.allowed {
+				expected = test.origin
+			}
+			is.Equal(expected, res.Header().Get("Access-Control-Allow-Origin"))
+		})
+	}
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	t.Run("documents every API and webhook route, and only those", func(t *testing.T) {
+		is := is.New(t)
+
+		s := server.New(server.Options{Database: servertest.NewStore(nil)})
+		mux := s.RegisterRoutes(server.GroupMiddleware{})
+
+		var routed []string
+		err := chi.Walk(mux.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+			if strings.HasPrefix(route, "/api/") || strings.HasPrefix(route, "/webhooks/") {
+				routed = append(routed, method+" "+route)
+			}
+			return nil
+		})
+		is.NoErr(err)
+
+		var documented []string
+		for path, operations := range handlers.OpenAPIDocument().Paths {
+			for method := range operations {
+				documented = append(documented, strings.ToUpper(method)+" "+path)
+			}
+		}
+		sort.Strings(routed)
+		sort.Strings(documented)
+		is.Equal(documented, routed)
+	})
+}
@@ -2,49 +2,264 @@
 package server
 
 import (
+	"canvas/email"
+	"canvas/errorreport"
+	"canvas/flags"
+	"canvas/handlers"
+	"canvas/i18n"
 	"canvas/messaging"
-	"canvas/storage"
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/tracing"
 	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 type Server struct {
-	address  string
-	mux      chi.Router
-	database *storage.Database
-	queue    *messaging.Queue
-	server   *http.Server
-	log      *zap.Logger
+	address                     string
+	mux                         *chi.Mux
+	database                    store
+	degradable                  func(next http.Handler) http.Handler
+	queue                       jobQueue
+	inFlight                    *int64
+	readiness                   *handlers.Readiness
+	server                      *http.Server
+	redirect                    *http.Server
+	log                         *zap.Logger
+	logLevel                    *handlers.LogLevel
+	metrics                     *prometheus.Registry
+	twoStepConfirm              bool
+	trackingSecret              []byte
+	unsubscribeSecret           []byte
+	signupFormSecret            []byte
+	signupCaptcha               handlers.CaptchaVerifier
+	signupCaptchaFailOpen       bool
+	signupEmailPolicy           model.EmailPolicy
+	signupMaxFormAge            time.Duration
+	signupMinFillTime           time.Duration
+	signupThrottleDB            bool
+	corsAllowedOrigins          []string
+	embedPartnerOrigins         []string
+	adminPasswordHash           []byte
+	sessions                    *sessions.Manager
+	baseURL                     string
+	robotsDisallowAll           bool
+	emailFrom                   string
+	emailPhysicalAddress        string
+	emailSender                 email.Sender
+	sesTransientBounceThreshold int
+	sesTransientBounceWindow    time.Duration
+	catalog                     i18n.Loader
+	flags                       flags.Provider
+	scheduler                   *messaging.Scheduler
+	newsletterLocation          *time.Location
+	webhookAllowPrivateNetworks bool
+	routesOnce                  sync.Once
 }
 
 type Options struct {
-	Database *storage.Database
-	Queue    *messaging.Queue
-	Host     string
-	Port     int
-	Log      *zap.Logger
+	// AccessLogQuietPaths are logged at debug level only in the access log, unless the response is a server error.
+	// Defaults to handlers.DefaultQuietPaths, the probes, metrics, and version. Set it to an empty slice to log them all.
+	AccessLogQuietPaths []string
+	// AccessLogQuietPrefixes are like AccessLogQuietPaths, for all paths starting with them.
+	// Defaults to handlers.DefaultQuietPrefixes, the static assets.
+	AccessLogQuietPrefixes []string
+	// AccessLogQuietSampleEvery logs every nth quiet request at info level anyway. Zero logs them all at debug level.
+	AccessLogQuietSampleEvery int
+	// ACME serves HTTPS with certificates requested and renewed automatically, like from Let's Encrypt,
+	// with a listener for the challenges that redirects to HTTPS. Without it, the server only serves HTTP.
+	ACME *ACMEOptions
+	// AdminPasswordHash is the bcrypt hash of the password for the admin pages. Nobody can log in if it's empty.
+	AdminPasswordHash []byte
+	// BaseURL of the app, like "https://example.com", for absolute URLs such as in the sitemap.
+	BaseURL string
+	// Catalog of translations for the views. Defaults to i18n.Default.
+	// In development, it can be an i18n.Reloader, so translations are reloaded from disk on every request.
+	Catalog i18n.Loader
+	// CORSAllowedOrigins can call the JSON API routes cross-origin.
+	CORSAllowedOrigins []string
+	// Database for the routes. It's a *storage.Database, or a fake in tests.
+	Database store
+	// DegradedCacheTTL is how long a copy of the front page is kept, to show it while Health reports the database
+	// as unhealthy. Without it or Health, the front page fails like all other pages while the database is down.
+	DegradedCacheTTL time.Duration
+	// EmbedPartnerOrigins are the origins of partner sites that can frame the embedded signup form and call the API.
+	EmbedPartnerOrigins []string
+	// EmailFrom is the sender address of emails sent from the web app, like newsletter test emails.
+	EmailFrom string
+	// EmailPhysicalAddress is the postal address in the footer of emails sent from the web app.
+	EmailPhysicalAddress string
+	// EmailSender sends emails from the web app, like newsletter test emails.
+	EmailSender email.Sender
+	// ErrorReporter reports panics and unexpected errors in handlers. Without it, they're only logged.
+	ErrorReporter *errorreport.Reporter
+	// Flags for features that are shipped dark, like the archive. Without them, all flags are off.
+	Flags flags.Provider
+	// Health of the database, from the health monitor, for DegradedCacheTTL.
+	Health healthChecker
+	// Queue of jobs, like for recording opens and clicks of newsletter issue emails. It's a *messaging.Queue, or a fake in tests.
+	Queue jobQueue
+	Host  string
+	Port  int
+	Log   *zap.Logger
+	// LoadShed rejects requests with 503 Service Unavailable while the database connection pool is saturated,
+	// except the probes, unsubscribing, and the static assets by default. Its log and metrics default to the ones here.
+	// Without it, requests wait for a connection however long it takes.
+	LoadShed *handlers.LoadShedOptions
+	// LogLevel of Log, to change at runtime from the admin pages. Without it, the level can't be changed.
+	LogLevel *handlers.LogLevel
+	Metrics  *prometheus.Registry
+	// NewsletterLocation is the time zone of the newsletter, which scheduled issues are entered and shown in
+	// on the admin pages. Defaults to UTC.
+	NewsletterLocation *time.Location
+	// Readiness for traffic, reported at /ready. Without it, the server is ready as soon as it's started.
+	Readiness *handlers.Readiness
+	// RobotsDisallowAll tells all search engines not to crawl the site, for staging environments.
+	RobotsDisallowAll bool
+	// Scheduler of recurring jobs, whose next runs are shown on the admin dashboard.
+	Scheduler *messaging.Scheduler
+	// SESTransientBounceThreshold is how many transient bounces reported by SES within SESTransientBounceWindow
+	// suppress an address.
+	SESTransientBounceThreshold int
+	SESTransientBounceWindow    time.Duration
+	// Sessions loads and saves the session of each request. Without it, there are no sessions.
+	Sessions *sessions.Manager
+	// TwoStepConfirm makes newsletter confirmation links show a confirm button instead of confirming right away.
+	TwoStepConfirm bool
+	// Tracing records a span for each request, the root of the spans of its queries and messages.
+	// Without it, requests aren't traced.
+	Tracing *tracing.Provider
+	// TrustedProxies are the networks of the load balancers and reverse proxies in front of the server.
+	// The client IP address of requests from them, for the rate limits and throttles, is taken from X-Forwarded-For.
+	TrustedProxies []*net.IPNet
+	// TrackingSecret verifies the tokens in the open tracking pixels and tracked links of newsletter issue emails.
+	TrackingSecret []byte
+	// UnsubscribeSecret signs and verifies the tokens in newsletter unsubscribe links.
+	UnsubscribeSecret []byte
+	// WebhookAllowPrivateNetworks allows webhook endpoint URLs in private networks, like localhost, for development.
+	WebhookAllowPrivateNetworks bool
+	// SignupFormSecret signs and verifies the timestamp in the signup form, used to catch bots.
+	SignupFormSecret []byte
+	// SignupCaptcha verifies a captcha in the signup form. Without it, the form has no captcha.
+	SignupCaptcha handlers.CaptchaVerifier
+	// SignupCaptchaFailOpen lets signups through if the captcha service can't verify the captcha.
+	SignupCaptchaFailOpen bool
+	// SignupEmailPolicy for the addresses signed up, which is off by default.
+	SignupEmailPolicy model.EmailPolicy
+	// SignupMaxFormAge is how long after rendering the signup form can be submitted.
+	SignupMaxFormAge time.Duration
+	// SignupMinFillTime is how fast the signup form can be submitted after rendering, before it's taken to be from a bot.
+	SignupMinFillTime time.Duration
+	// SignupThrottleDatabase counts signups for throttling in the database instead of in memory,
+	// so the limits are shared by all instances of the app.
+	SignupThrottleDatabase bool
 }
 
 func New(opts Options) *Server {
 	if opts.Log == nil {
 		opts.Log = zap.NewNop()
 	}
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	if opts.Catalog == nil {
+		opts.Catalog = i18n.Default()
+	}
+	if opts.Flags == nil {
+		// Without a file or environment, there's nothing to read, so there's no error.
+		static, _ := flags.NewStatic(flags.NewStaticOptions{Log: opts.Log})
+		opts.Flags = static
+	}
 	address := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
 	mux := chi.NewMux()
-	return &Server{
-		address:  address,
-		database: opts.Database,
-		queue:    opts.Queue,
-		log:      opts.Log,
-		mux:      mux,
+	inFlight := new(int64)
+	mux.Use(countInFlight(inFlight), middleware.RequestID, handlers.ClientIP(handlers.ClientIPOptions{TrustedProxies: opts.TrustedProxies}))
+	// Tracing is outside recovery, so the span of a request that panicked has the status of the error page.
+	if opts.Tracing != nil {
+		mux.Use(handlers.Trace(opts.Tracing))
+	}
+	// The logger of the request is after tracing, for the trace ID, and outside recovery, so panics are logged with it.
+	// So is the access log, so it has the status of the error page of a panic.
+	mux.Use(
+		handlers.RequestLog(opts.Log),
+		handlers.AccessLog(handlers.AccessLogOptions{
+			Log:              opts.Log,
+			Metrics:          opts.Metrics,
+			QuietPaths:       opts.AccessLogQuietPaths,
+			QuietPrefixes:    opts.AccessLogQuietPrefixes,
+			QuietSampleEvery: opts.AccessLogQuietSampleEvery,
+		}),
+		handlers.Recover(opts.Log, opts.ErrorReporter),
+	)
+	// Shed requests are logged and counted like all others, but never get to the routes, which would use the database.
+	if opts.LoadShed != nil && opts.LoadShed.Stats != nil {
+		loadShed := *opts.LoadShed
+		if loadShed.Log == nil {
+			loadShed.Log = opts.Log
+		}
+		if loadShed.Metrics == nil {
+			loadShed.Metrics = opts.Metrics
+		}
+		mux.Use(handlers.LoadShed(loadShed))
+	}
+	var degradable func(next http.Handler) http.Handler
+	if opts.Health != nil && opts.DegradedCacheTTL > 0 {
+		degradable = handlers.Degradable(handlers.DegradableOptions{
+			Health:  opts.Health,
+			Log:     opts.Log,
+			Metrics: opts.Metrics,
+			TTL:     opts.DegradedCacheTTL,
+		})
+	}
+	s := &Server{
+		address:                     address,
+		database:                    opts.Database,
+		degradable:                  degradable,
+		queue:                       opts.Queue,
+		inFlight:                    inFlight,
+		readiness:                   opts.Readiness,
+		log:                         opts.Log,
+		logLevel:                    opts.LogLevel,
+		metrics:                     opts.Metrics,
+		mux:                         mux,
+		twoStepConfirm:              opts.TwoStepConfirm,
+		trackingSecret:              opts.TrackingSecret,
+		unsubscribeSecret:           opts.UnsubscribeSecret,
+		signupFormSecret:            opts.SignupFormSecret,
+		signupCaptcha:               opts.SignupCaptcha,
+		signupCaptchaFailOpen:       opts.SignupCaptchaFailOpen,
+		signupEmailPolicy:           opts.SignupEmailPolicy,
+		signupMaxFormAge:            opts.SignupMaxFormAge,
+		signupMinFillTime:           opts.SignupMinFillTime,
+		signupThrottleDB:            opts.SignupThrottleDatabase,
+		corsAllowedOrigins:          opts.CORSAllowedOrigins,
+		embedPartnerOrigins:         opts.EmbedPartnerOrigins,
+		adminPasswordHash:           opts.AdminPasswordHash,
+		sessions:                    opts.Sessions,
+		baseURL:                     opts.BaseURL,
+		robotsDisallowAll:           opts.RobotsDisallowAll,
+		emailFrom:                   opts.EmailFrom,
+		emailPhysicalAddress:        opts.EmailPhysicalAddress,
+		emailSender:                 opts.EmailSender,
+		sesTransientBounceThreshold: opts.SESTransientBounceThreshold,
+		sesTransientBounceWindow:    opts.SESTransientBounceWindow,
+		catalog:                     opts.Catalog,
+		flags:                       opts.Flags,
+		scheduler:                   opts.Scheduler,
+		newsletterLocation:          opts.NewsletterLocation,
+		webhookAllowPrivateNetworks: opts.WebhookAllowPrivateNetworks,
 		server: &http.Server{
 			Addr:              address,
 			Handler:           mux,
@@ -54,28 +269,78 @@ func New(opts Options) *Server {
 			IdleTimeout:       5 * time.Second,
 		},
 	}
+	if opts.ACME != nil {
+		m := newACMEManager(*opts.ACME)
+		s.server.TLSConfig = acmeTLSConfig(m, opts.Log)
+		s.redirect = newRedirectServer(opts.Host, *opts.ACME, m)
+	}
+	return s
 }
 
+// Start the server. The database must already be opened, but doesn't have to be reachable yet,
+// because /ready isn't ready until Readiness is.
 func (s *Server) Start() error {
-	if err := s.database.Connect(); err != nil {
-		return fmt.Errorf("error connecting to database: %w", err)
+	s.routesOnce.Do(s.setupRoutes)
+
+	if s.redirect == nil {
+		s.log.Info("Starting server", zap.String("Address: ", s.address))
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error starting server: %w", err)
+		}
+		return nil
 	}
 
-	s.setupRoutes()
+	// Listen for the challenges first, so an error like the port being in use is returned before serving HTTPS.
+	l, err := net.Listen("tcp", s.redirect.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting redirect server: %w", err)
+	}
+	go func() {
+		if err := s.redirect.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Error("Error serving redirects", zap.Error(err))
+		}
+	}()
 
-	s.log.Info("Starting server", zap.String("Address: ", s.address))
-	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	s.log.Info("Starting server", zap.String("Address: ", s.address), zap.String("redirect", s.redirect.Addr))
+	// The certificates come from the TLS config, so there are no files.
+	if err := s.server.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("error starting server: %w", err)
 	}
 	return nil
 }
 
-func (s *Server) Stop() error {
-	s.log.Info("Stopping server")
+// Handler with all the routes and middleware of the server, for serving requests without listening,
+// like with httptest in tests.
+func (s *Server) Handler() http.Handler {
+	s.routesOnce.Do(s.setupRoutes)
+	return s.mux
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// InFlight is the number of requests being handled now, for diagnostics.
+func (s *Server) InFlight() int {
+	return int(atomic.LoadInt64(s.inFlight))
+}
 
+// countInFlight requests in n while they're handled.
+func countInFlight(n *int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(n, 1)
+			defer atomic.AddInt64(n, -1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Stop accepting connections, and wait for requests in progress to finish, until ctx is done.
+func (s *Server) Stop(ctx context.Context) error {
+	s.log.Info("Stopping server")
+
+	if s.redirect != nil {
+		if err := s.redirect.Shutdown(ctx); err != nil {
+			return fmt.Errorf("error stopping redirect server: %w", err)
+		}
+	}
 	if err := s.server.Shutdown(ctx); err != nil {
 		return fmt.Errorf("error stopping server: %w", err)
 	}
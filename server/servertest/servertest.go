@@ -0,0 +1,145 @@
+// Package servertest builds the server with all its routes and middleware for end-to-end handler tests,
+// with fakes for the database, the job queue, and the email sender, and without listening on a port.
+//
+// Usage:
+//
+//	h, fakes := servertest.New(t, server.Options{})
+//	res := httptest.NewRecorder()
+//	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+//	…
+package servertest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+
+	"canvas/email"
+	"canvas/model"
+	"canvas/server"
+)
+
+// Fakes the server was built with, to set up what they return and check what the routes did with them.
+// A fake is nil if the dependency was set in the options instead.
+type Fakes struct {
+	Store  *Store
+	Queue  *Queue
+	Sender *Sender
+}
+
+// New server handler for t, like server.New with opts, with the routes set up.
+// The database, queue, and email sender default to fakes, which are returned, and the log writes to t.
+// The secrets default to test values, so signed tokens and links work. Everything else has the defaults of server.New.
+// Options the server only needs for listening, like ACME, have no effect.
+func New(t *testing.T, opts server.Options) (http.Handler, *Fakes) {
+	t.Helper()
+
+	var fakes Fakes
+	if opts.Queue == nil {
+		fakes.Queue = &Queue{}
+		opts.Queue = fakes.Queue
+	}
+	if opts.Database == nil {
+		fakes.Store = NewStore(fakes.Queue)
+		opts.Database = fakes.Store
+	}
+	if opts.EmailSender == nil {
+		fakes.Sender = &Sender{}
+		opts.EmailSender = fakes.Sender
+	}
+	if opts.Log == nil {
+		opts.Log = zaptest.NewLogger(t)
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "https://example.com"
+	}
+	if opts.SignupFormSecret == nil {
+		opts.SignupFormSecret = []byte("signup-form-secret")
+	}
+	if opts.TrackingSecret == nil {
+		opts.TrackingSecret = []byte("tracking-secret")
+	}
+	if opts.UnsubscribeSecret == nil {
+		opts.UnsubscribeSecret = []byte("unsubscribe-secret")
+	}
+
+	s := server.New(opts)
+	return s.Handler(), &fakes
+}
+
+// Queue is a fake job queue, which keeps the messages sent to it.
+type Queue struct {
+	// Err is returned by Send and Depth if set.
+	Err      error
+	lock     sync.Mutex
+	messages []model.Message
+}
+
+// Send m to the queue, unless Err is set.
+func (q *Queue) Send(ctx context.Context, m model.Message) error {
+	if q.Err != nil {
+		return q.Err
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.messages = append(q.messages, m)
+	return nil
+}
+
+// Depth is the number of messages sent.
+func (q *Queue) Depth(ctx context.Context) (int, error) {
+	if q.Err != nil {
+		return 0, q.Err
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.messages), nil
+}
+
+// Messages sent to the queue, in the order they were sent.
+func (q *Queue) Messages() []model.Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return append([]model.Message{}, q.messages...)
+}
+
+// Jobs with the name sent to the queue, in the order they were sent.
+func (q *Queue) Jobs(name string) []model.Message {
+	var jobs []model.Message
+	for _, m := range q.Messages() {
+		if m["job"] == name {
+			jobs = append(jobs, m)
+		}
+	}
+	return jobs
+}
+
+// Sender is a fake email sender, which keeps the emails sent with it.
+type Sender struct {
+	// Err is returned by Send if set.
+	Err      error
+	lock     sync.Mutex
+	messages []email.Message
+}
+
+// Send m, unless Err is set. The provider message ID is "message-" and the number of the email.
+func (s *Sender) Send(ctx context.Context, m email.Message) (string, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, m)
+	return "message-" + strconv.Itoa(len(s.messages)), nil
+}
+
+// Messages sent, in the order they were sent.
+func (s *Sender) Messages() []email.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]email.Message{}, s.messages...)
+}
@@ -0,0 +1,1129 @@
+package servertest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"canvas/apperr"
+	"canvas/messaging"
+	"canvas/model"
+	"canvas/storage"
+)
+
+// Store is an in-memory fake of the database, with what the routes need to work end to end:
+// subscribers through their whole lifecycle and their tags, admin sessions, API tokens, webhook endpoints,
+// subscriber imports, the suppression list, the send log, and the audit log.
+// There are no newsletter issues, so they're not found, signups are never throttled, the stats are all zero,
+// there are no webhook deliveries, and queued subscriber imports stay queued, since importing them is the job's.
+// Jobs and domain events are enqueued on the queue right away, like the outbox relay would.
+type Store struct {
+	// Err is returned by every method if set, like for checking the error pages.
+	Err           error
+	lock          sync.Mutex
+	queue         *Queue
+	now           func() time.Time
+	subscribers   []*model.Subscriber
+	tags          map[int64][]model.Tag
+	tokens        map[string]int64
+	welcomed      map[int64]bool
+	apiTokens     []apiToken
+	webhooks      []model.WebhookEndpoint
+	imports       []subscriberImport
+	suppressions  []model.Suppression
+	suppressionID int64
+	sends         []model.EmailSend
+	auditEvents   []AuditEvent
+}
+
+// subscriberImport in the Store, with the rows of its file.
+type subscriberImport struct {
+	model.SubscriberImport
+	rows []model.SubscriberImportRow
+}
+
+// apiToken in the Store, with the hash of its secret token.
+type apiToken struct {
+	model.APIToken
+	hash string
+}
+
+// AuditEvent recorded in the Store.
+type AuditEvent struct {
+	Actor   string
+	Action  string
+	Target  string
+	Details map[string]string
+}
+
+// NewStore with jobs enqueued on q. Without a queue, jobs are dropped.
+func NewStore(q *Queue) *Store {
+	return &Store{
+		queue:    q,
+		now:      time.Now,
+		tags:     map[int64][]model.Tag{},
+		tokens:   map[string]int64{},
+		welcomed: map[int64]bool{},
+	}
+}
+
+// Subscriber with the email address, if they signed up.
+func (s *Store) Subscriber(email model.Email) (model.Subscriber, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sub := s.find(email); sub != nil {
+		return *sub, true
+	}
+	return model.Subscriber{}, false
+}
+
+// Token for confirming the signup of the email address, which is in the confirmation email.
+func (s *Store) Token(email model.Email) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sub := s.find(email); sub != nil {
+		for token, id := range s.tokens {
+			if id == sub.ID {
+				return token
+			}
+		}
+	}
+	return ""
+}
+
+// AuditEvents recorded, in the order they were recorded.
+func (s *Store) AuditEvents() []AuditEvent {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]AuditEvent{}, s.auditEvents...)
+}
+
+// Ping the store, which fails with Err.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.Err
+}
+
+// SignupForNewsletter like storage.Database.SignupForNewsletter, enqueueing the confirmation email job
+// and the subscriber.signed_up event.
+// Signing up again makes the subscriber active, and only subscribers that were active stay confirmed,
+// so after unsubscribing they have to confirm again.
+func (s *Store) SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub := s.find(email)
+	switch {
+	case sub != nil && sub.Suppressed == model.SuppressionReasonComplained:
+		return "", storage.ErrComplained
+	case sub == nil:
+		sub = &model.Subscriber{ID: int64(len(s.subscribers) + 1), Email: email, Active: true, Created: s.now()}
+		s.subscribers = append(s.subscribers, sub)
+	case !sub.Active:
+		sub.Confirmed, sub.ConfirmedAt = false, nil
+	}
+	sub.Active = true
+	sub.Locale, sub.Source, sub.Updated = locale, source, s.now()
+	s.unsuppress(email, model.SuppressionReasonUnsubscribed)
+
+	token := s.newToken(sub.ID)
+	if err := s.enqueue(ctx, model.ConfirmationEmailRequested{Email: email, Token: token, Locale: locale}); err != nil {
+		return "", err
+	}
+	return token, s.emit(ctx, model.SubscriberSignedUp{Email: email, Locale: locale, Source: source})
+}
+
+// ConfirmNewsletterSignup of the subscriber with the token, enqueueing the subscriber.confirmed event,
+// which is only the first time once.
+func (s *Store) ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id, ok := s.tokens[token]
+	if !ok {
+		return model.ConfirmationResultUnknownToken, nil
+	}
+	sub := s.subscribers[id-1]
+	if sub.Confirmed {
+		return model.ConfirmationResultAlreadyConfirmed, nil
+	}
+	now := s.now()
+	sub.Confirmed, sub.ConfirmedAt, sub.Updated = true, &now, now
+	firstTime := !s.welcomed[sub.ID]
+	s.welcomed[sub.ID] = true
+	return model.ConfirmationResultConfirmed, s.emit(ctx, model.SubscriberConfirmed{Email: sub.Email, Locale: sub.Locale,
+		FirstTime: strconv.FormatBool(firstTime)})
+}
+
+// ResendConfirmation to the subscriber with the email address, if they're waiting to be confirmed.
+func (s *Store) ResendConfirmation(ctx context.Context, email model.Email) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sub := s.find(email)
+	if sub == nil || sub.Status() != model.SubscriberStatusPending {
+		return false, nil
+	}
+	token := s.newToken(sub.ID)
+	return true, s.enqueue(ctx, model.ConfirmationEmailRequested{Email: email, Token: token, Locale: sub.Locale})
+}
+
+// IsSubscribed is true if the email address is a confirmed, active subscriber that isn't suppressed.
+func (s *Store) IsSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sub := s.find(email)
+	return sub != nil && sub.Status() == model.SubscriberStatusConfirmed, nil
+}
+
+// Unsubscribe the subscriber with the email address, and add them to the suppression list.
+// Unsubscribing an active subscriber enqueues the subscriber.unsubscribed event.
+func (s *Store) Unsubscribe(ctx context.Context, email model.Email) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sub := s.find(email)
+	if sub == nil {
+		return nil
+	}
+	wasActive := sub.Active
+	sub.Active, sub.Updated = false, s.now()
+	s.suppress(email, model.SuppressionReasonUnsubscribed, storage.AuditActorSubscriber)
+	if !wasActive {
+		return nil
+	}
+	return s.emit(ctx, model.SubscriberUnsubscribed{Email: email, Actor: storage.AuditActorSubscriber})
+}
+
+// Throttle never throttles.
+func (s *Store) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	return false, s.Err
+}
+
+// ListPublishedNewsletters, of which there are none.
+func (s *Store) ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	return nil, s.Err
+}
+
+// GetPublishedNewsletter, which is never found.
+func (s *Store) GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return nil, storage.ErrNotFound
+}
+
+// GetNewsletter, which is always nil.
+func (s *Store) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	return nil, s.Err
+}
+
+// GetNewsletterSend, which is always nil.
+func (s *Store) GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error) {
+	return nil, s.Err
+}
+
+// QueueNewsletterSend, which fails with storage.ErrNotFound, since there are no newsletters.
+func (s *Store) QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	return storage.ErrNotFound
+}
+
+// ScheduleNewsletter, which fails with storage.ErrNotFound, since there are no newsletters.
+func (s *Store) ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	return storage.ErrNotFound
+}
+
+// UnscheduleNewsletter, which fails with storage.ErrNotFound, since there are no newsletters.
+func (s *Store) UnscheduleNewsletter(ctx context.Context, id int64) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	return storage.ErrNotFound
+}
+
+// RecordEmailSend in the send log.
+func (s *Store) RecordEmailSend(ctx context.Context, send model.EmailSend) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	send.ID = int64(len(s.sends) + 1)
+	s.sends = append(s.sends, send)
+	return nil
+}
+
+// SubscriberStats with the subscribers by status, and nothing for the days.
+func (s *Store) SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error) {
+	if s.Err != nil {
+		return model.SubscriberStats{}, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := model.SubscriberStats{ByStatus: map[model.SubscriberStatus]int{}, SignupsPerDay: make([]int, days)}
+	for _, sub := range s.list("", "") {
+		stats.ByStatus[sub.Status()]++
+	}
+	return stats, nil
+}
+
+// SendStats, which are zero.
+func (s *Store) SendStats(ctx context.Context, days int) (model.SendStats, error) {
+	return model.SendStats{}, s.Err
+}
+
+// ListSubscribers ordered by email address. Paging is by After only.
+func (s *Store) ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscribers, err := s.listSegment(opts.Segment, opts.Status)
+	if err != nil {
+		return nil, err
+	}
+	var after []model.Subscriber
+	for _, sub := range subscribers {
+		if sub.Email > opts.After {
+			after = append(after, sub)
+		}
+	}
+	return limit(after, opts.Limit), nil
+}
+
+// SearchSubscribers with the query anywhere in their email address, ordered by email address.
+func (s *Store) SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return limit(s.list(opts.Status, opts.Query), opts.Limit), nil
+}
+
+// GetSubscriber by ID, or nil if there's none.
+func (s *Store) GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id < 1 || id > int64(len(s.subscribers)) {
+		return nil, nil
+	}
+	sub := *s.subscribers[id-1]
+	return &sub, nil
+}
+
+// ListEmailSends to the email address, newest first.
+func (s *Store) ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var sends []model.EmailSend
+	for i := len(s.sends) - 1; i >= 0; i-- {
+		if s.sends[i].Email == opts.Email {
+			sends = append(sends, s.sends[i])
+		}
+	}
+	return limit(sends, opts.Limit), nil
+}
+
+// DeleteSubscriber with the id. See changeSubscriber.
+func (s *Store) DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.changeSubscriber(id, version, actor, "subscriber.delete", func(sub *model.Subscriber) bool {
+		s.subscribers[id-1] = &model.Subscriber{ID: id}
+		return true
+	})
+}
+
+// ConfirmSubscriber with the id, if they're pending, enqueueing the subscriber.confirmed event,
+// never as the first time. See changeSubscriber.
+func (s *Store) ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	email, err := s.changeSubscriber(id, version, actor, "subscriber.confirm", func(sub *model.Subscriber) bool {
+		if sub.Status() != model.SubscriberStatusPending {
+			return false
+		}
+		now := s.now()
+		sub.Confirmed, sub.ConfirmedAt = true, &now
+		return true
+	})
+	if err != nil {
+		return email, err
+	}
+	return email, s.emit(ctx, model.SubscriberConfirmed{Email: email, FirstTime: "false"})
+}
+
+// UnsubscribeSubscriber with the id, if they're active, enqueueing the subscriber.unsubscribed event.
+// See changeSubscriber.
+func (s *Store) UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	email, err := s.changeSubscriber(id, version, actor, "subscriber.unsubscribe", func(sub *model.Subscriber) bool {
+		if !sub.Active {
+			return false
+		}
+		sub.Active = false
+		s.suppress(sub.Email, model.SuppressionReasonUnsubscribed, actor)
+		return true
+	})
+	if err != nil {
+		return email, err
+	}
+	return email, s.emit(ctx, model.SubscriberUnsubscribed{Email: email, Actor: actor})
+}
+
+// ClearComplaint of the subscriber with the id, if they complained. See changeSubscriber.
+func (s *Store) ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	return s.changeSubscriber(id, version, actor, "subscriber.clear_complaint", func(sub *model.Subscriber) bool {
+		if sub.Suppressed != model.SuppressionReasonComplained {
+			return false
+		}
+		sub.Suppressed = ""
+		s.unsuppress(sub.Email, model.SuppressionReasonComplained)
+		return true
+	})
+}
+
+// CountSubscribers with the status, or all of them if it's empty.
+func (s *Store) CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error) {
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.list(status, "")), nil
+}
+
+// CountSubscribersInSegment with the status, or all of them if it's empty.
+func (s *Store) CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error) {
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscribers, err := s.listSegment(segment, status)
+	return len(subscribers), err
+}
+
+// ListSubscriberTags of the subscriber with the id, ordered by tag.
+func (s *Store) ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]model.Tag(nil), s.tags[id]...), nil
+}
+
+// ListTags of the subscribers that aren't deleted, with how many of them have each, ordered by tag.
+func (s *Store) ListTags(ctx context.Context) ([]model.TagCount, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := map[model.Tag]int{}
+	for _, sub := range s.subscribers {
+		if sub.Email == "" {
+			continue
+		}
+		for _, tag := range s.tags[sub.ID] {
+			counts[tag]++
+		}
+	}
+	var tags []model.TagCount
+	for tag, count := range counts {
+		tags = append(tags, model.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+// AddSubscriberTag to the subscriber with the id, if they don't have it already. See changeTags.
+func (s *Store) AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	if !tag.IsValid() {
+		return apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid tag %q", tag))
+	}
+	return s.changeTags(id, tag, actor, "subscriber.tag", func(tags []model.Tag) []model.Tag {
+		for _, t := range tags {
+			if t == tag {
+				return tags
+			}
+		}
+		tags = append(tags, tag)
+		sort.Slice(tags, func(i, j int) bool {
+			return tags[i] < tags[j]
+		})
+		return tags
+	})
+}
+
+// RemoveSubscriberTag from the subscriber with the id, if they have it. See changeTags.
+func (s *Store) RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	return s.changeTags(id, tag, actor, "subscriber.untag", func(tags []model.Tag) []model.Tag {
+		var kept []model.Tag
+		for _, t := range tags {
+			if t != tag {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+}
+
+// ExportSubscribers with the status, or all of them if it's empty, calling f with each, up to limit if it's not zero.
+func (s *Store) ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	subscribers := s.list(status, "")
+	s.lock.Unlock()
+	for i, sub := range subscribers {
+		if limit > 0 && i == limit {
+			break
+		}
+		if err := f(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordAuditEvent of the action by the actor on the target.
+func (s *Store) RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: action, Target: target, Details: details})
+	return nil
+}
+
+// AddSuppression of the email address to the suppression list.
+func (s *Store) AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.suppress(email, reason, source)
+	return nil
+}
+
+// ListSuppressions on the suppression list, newest first.
+func (s *Store) ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var suppressions []model.Suppression
+	for i := len(s.suppressions) - 1; i >= 0; i-- {
+		if opts.Email == "" || s.suppressions[i].EmailHash == emailHash(opts.Email) {
+			suppressions = append(suppressions, s.suppressions[i])
+		}
+	}
+	return limit(suppressions, opts.Limit), nil
+}
+
+// RemoveSuppression with the id from the suppression list. Returns storage.ErrNotFound if there's no such suppression.
+func (s *Store) RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error) {
+	if s.Err != nil {
+		return model.Suppression{}, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, suppression := range s.suppressions {
+		if suppression.ID == id {
+			s.suppressions = append(s.suppressions[:i], s.suppressions[i+1:]...)
+			s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "suppression.remove",
+				Target: fmt.Sprintf("suppression/%v", id)})
+			return suppression, nil
+		}
+	}
+	return model.Suppression{}, storage.ErrNotFound
+}
+
+// CreateAPIToken like storage.Database.CreateAPIToken, returning the token and its secret token.
+func (s *Store) CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time,
+	actor string) (model.APIToken, string, error) {
+	if s.Err != nil {
+		return model.APIToken{}, "", s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var id int64 = 1
+	for _, t := range s.apiTokens {
+		if t.ID >= id {
+			id = t.ID + 1
+		}
+	}
+	token := "canvas_" + randomToken()
+	t := model.APIToken{ID: id, Name: name, Scopes: scopes, ExpiresAt: expiresAt, Created: s.now()}
+	s.apiTokens = append(s.apiTokens, apiToken{APIToken: t, hash: tokenHash(token)})
+	s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "api_token.create",
+		Target: fmt.Sprintf("api_token/%v", id)})
+	return t, token, nil
+}
+
+// ListAPITokens, newest first.
+func (s *Store) ListAPITokens(ctx context.Context) ([]model.APIToken, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tokens := []model.APIToken{}
+	for i := len(s.apiTokens) - 1; i >= 0; i-- {
+		tokens = append(tokens, s.apiTokens[i].APIToken)
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken with the id. Returns storage.ErrNotFound if there's no such token.
+func (s *Store) RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error) {
+	if s.Err != nil {
+		return model.APIToken{}, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, t := range s.apiTokens {
+		if t.ID == id {
+			s.apiTokens = append(s.apiTokens[:i], s.apiTokens[i+1:]...)
+			s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "api_token.revoke",
+				Target: fmt.Sprintf("api_token/%v", id)})
+			return t.APIToken, nil
+		}
+	}
+	return model.APIToken{}, storage.ErrNotFound
+}
+
+// AuthenticateAPIToken with the secret token from CreateAPIToken, recording its use.
+// Returns nil if there's no such token, or it expired.
+func (s *Store) AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	hash := tokenHash(token)
+	for i := range s.apiTokens {
+		t := &s.apiTokens[i]
+		if t.hash != hash || (t.ExpiresAt != nil && !t.ExpiresAt.After(s.now())) {
+			continue
+		}
+		now := s.now()
+		t.LastUsedAt = &now
+		authenticated := t.APIToken
+		return &authenticated, nil
+	}
+	return nil, nil
+}
+
+// CreateWebhookEndpoint like storage.Database.CreateWebhookEndpoint.
+func (s *Store) CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error) {
+	if s.Err != nil {
+		return model.WebhookEndpoint{}, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var id int64 = 1
+	for _, e := range s.webhooks {
+		if e.ID >= id {
+			id = e.ID + 1
+		}
+	}
+	e := model.WebhookEndpoint{ID: id, URL: url, Secret: "whsec_" + randomToken(), EventTypes: eventTypes, Enabled: true,
+		Created: s.now(), Updated: s.now()}
+	s.webhooks = append(s.webhooks, e)
+	s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "webhook_endpoint.create",
+		Target: fmt.Sprintf("webhook_endpoint/%v", id)})
+	return e, nil
+}
+
+// ListWebhookEndpoints, oldest first.
+func (s *Store) ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]model.WebhookEndpoint{}, s.webhooks...), nil
+}
+
+// GetWebhookEndpoint by ID. Returns nil if there's no such endpoint.
+func (s *Store) GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, e := range s.webhooks {
+		if e.ID == id {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteWebhookEndpoint with the id. Returns storage.ErrNotFound if there's no such endpoint.
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, e := range s.webhooks {
+		if e.ID == id {
+			s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+			s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "webhook_endpoint.delete",
+				Target: fmt.Sprintf("webhook_endpoint/%v", id)})
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+// EnableWebhookEndpoint with the id again. Returns storage.ErrNotFound if there's no such endpoint.
+func (s *Store) EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.webhooks {
+		if e := &s.webhooks[i]; e.ID == id {
+			e.Enabled, e.ConsecutiveFailures, e.DisabledReason, e.Updated = true, 0, "", s.now()
+			s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "webhook_endpoint.enable",
+				Target: fmt.Sprintf("webhook_endpoint/%v", id)})
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+// ListWebhookDeliveries, of which there are none.
+func (s *Store) ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return []model.WebhookDelivery{}, nil
+}
+
+// CreateSubscriberImport like storage.Database.CreateSubscriberImport.
+func (s *Store) CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+	next func() (model.SubscriberImportRow, error)) (int64, error) {
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	i := subscriberImport{SubscriberImport: model.SubscriberImport{Filename: filename,
+		State: model.SubscriberImportStateUploaded, Duplicates: model.SubscriberImportDuplicatesSkip,
+		Columns: columns, Created: s.now()}}
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if row.Error != "" {
+			row.Result = model.SubscriberImportResultFailed
+			i.Failed++
+		}
+		i.rows = append(i.rows, row)
+	}
+	i.Total = len(i.rows)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i.ID = 1
+	for _, other := range s.imports {
+		if other.ID >= i.ID {
+			i.ID = other.ID + 1
+		}
+	}
+	s.imports = append(s.imports, i)
+	return i.ID, nil
+}
+
+// GetSubscriberImport by ID. Returns nil if there's no such import.
+func (s *Store) GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if i := s.findImport(id); i != nil {
+		si := i.SubscriberImport
+		return &si, nil
+	}
+	return nil, nil
+}
+
+// ListSubscriberImports, newest first, up to limit if it's not zero.
+func (s *Store) ListSubscriberImports(ctx context.Context, n int) ([]model.SubscriberImport, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var imports []model.SubscriberImport
+	for j := len(s.imports) - 1; j >= 0; j-- {
+		imports = append(imports, s.imports[j].SubscriberImport)
+	}
+	return limit(imports, n), nil
+}
+
+// ListSubscriberImportRows of the import with the ID, in the order of the file, up to limit if it's not zero.
+func (s *Store) ListSubscriberImportRows(ctx context.Context, id int64, n int) ([]model.SubscriberImportRow, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if i := s.findImport(id); i != nil {
+		return limit(append([]model.SubscriberImportRow{}, i.rows...), n), nil
+	}
+	return nil, nil
+}
+
+// ExportSubscriberImportErrors of the import with the ID, calling f with each failed row.
+func (s *Store) ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	var rows []model.SubscriberImportRow
+	if i := s.findImport(id); i != nil {
+		for _, row := range i.rows {
+			if row.Result == model.SubscriberImportResultFailed {
+				rows = append(rows, row)
+			}
+		}
+	}
+	s.lock.Unlock()
+	for _, row := range rows {
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueSubscriberImport like storage.Database.QueueSubscriberImport, enqueueing the import job.
+func (s *Store) QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	if duplicates != model.SubscriberImportDuplicatesSkip && duplicates != model.SubscriberImportDuplicatesUpdate {
+		return apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid duplicates %q", duplicates))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	i := s.findImport(id)
+	switch {
+	case i == nil:
+		return storage.ErrNotFound
+	case i.InProgress():
+		return storage.ErrImportInProgress
+	case i.State == model.SubscriberImportStateCompleted:
+		return storage.ErrAlreadyImported
+	}
+	resumed := i.State == model.SubscriberImportStateFailed
+	if !resumed {
+		i.Duplicates = duplicates
+	}
+	i.State, i.Error = model.SubscriberImportStateQueued, ""
+	s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "subscriber.import",
+		Target: fmt.Sprintf("subscriber_import/%v", id),
+		Details: map[string]string{"filename": i.Filename, "duplicates": string(i.Duplicates),
+			"resumed": strconv.FormatBool(resumed)}})
+	return s.enqueue(ctx, model.SubscriberImportRequested{ImportID: strconv.FormatInt(id, 10)})
+}
+
+// DeleteSubscriberImport with the ID, but not the subscribers it imported.
+// Returns storage.ErrNotFound if there's no such import, and storage.ErrImportInProgress if it's in progress.
+func (s *Store) DeleteSubscriberImport(ctx context.Context, id int64, actor string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for j, i := range s.imports {
+		if i.ID != id {
+			continue
+		}
+		if i.InProgress() {
+			return storage.ErrImportInProgress
+		}
+		s.imports = append(s.imports[:j], s.imports[j+1:]...)
+		s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: "subscriber_import.delete",
+			Target: fmt.Sprintf("subscriber_import/%v", id), Details: map[string]string{"filename": i.Filename}})
+		return nil
+	}
+	return storage.ErrNotFound
+}
+
+// findImport with the ID, or nil. The lock must be held.
+func (s *Store) findImport(id int64) *subscriberImport {
+	for j := range s.imports {
+		if s.imports[j].ID == id {
+			return &s.imports[j]
+		}
+	}
+	return nil
+}
+
+// RecordBounce, which suppresses the subscriber for permanent bounces only, enqueueing the subscriber.suppressed event.
+// Returns whether they're suppressed.
+func (s *Store) RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error) {
+	if s.Err != nil {
+		return false, s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sub := s.find(b.Email)
+	if sub == nil {
+		return false, nil
+	}
+	if b.Type == model.BounceTypePermanent && sub.Suppressed == "" {
+		sub.Suppressed, sub.Updated = model.SuppressionReasonBounced, s.now()
+		s.suppress(b.Email, model.SuppressionReasonBounced, storage.AuditActorEmailProvider)
+		if err := s.emit(ctx, model.SubscriberSuppressed{Email: b.Email, Reason: model.SuppressionReasonBounced}); err != nil {
+			return false, err
+		}
+	}
+	return sub.Suppressed != "", nil
+}
+
+// RecordComplaint, which suppresses the subscriber, enqueueing the subscriber.suppressed event if they signed up.
+func (s *Store) RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.suppress(email, model.SuppressionReasonComplained, storage.AuditActorEmailProvider)
+	sub := s.find(email)
+	if sub == nil {
+		return nil
+	}
+	sub.Suppressed, sub.Updated = model.SuppressionReasonComplained, s.now()
+	return s.emit(ctx, model.SubscriberSuppressed{Email: email, Reason: model.SuppressionReasonComplained})
+}
+
+// RecordDelivery, which changes nothing.
+func (s *Store) RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error {
+	return s.Err
+}
+
+// changeSubscriber with the id with change, if the version is the Updated time of the subscriber, and records the action
+// by the actor as an audit event. Returns storage.ErrConflict if there's no such subscriber, it's deleted, changed since,
+// or change returns false.
+func (s *Store) changeSubscriber(id int64, version time.Time, actor, action string, change func(*model.Subscriber) bool) (model.Email, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id < 1 || id > int64(len(s.subscribers)) {
+		return "", storage.ErrConflict
+	}
+	sub := s.subscribers[id-1]
+	email := sub.Email
+	if email == "" || !sub.Updated.Equal(version) || !change(sub) {
+		return "", storage.ErrConflict
+	}
+	sub.Updated = s.now()
+	s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: action, Target: fmt.Sprintf("subscriber/%v", id),
+		Details: map[string]string{"email": email.String()}})
+	return email, nil
+}
+
+// changeTags of the subscriber with the id to what change returns, recording the action on the tag by the actor
+// if they changed. Returns storage.ErrNotFound if there's no such subscriber or they're deleted.
+func (s *Store) changeTags(id int64, tag model.Tag, actor, action string, change func([]model.Tag) []model.Tag) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if id < 1 || id > int64(len(s.subscribers)) || s.subscribers[id-1].Email == "" {
+		return storage.ErrNotFound
+	}
+	before := len(s.tags[id])
+	s.tags[id] = change(s.tags[id])
+	if len(s.tags[id]) != before {
+		s.auditEvents = append(s.auditEvents, AuditEvent{Actor: actor, Action: action, Target: fmt.Sprintf("subscriber/%v", id),
+			Details: map[string]string{"tag": tag.String()}})
+	}
+	return nil
+}
+
+// listSegment of the subscribers in the segment with the status, like list. The lock must be held.
+func (s *Store) listSegment(segment model.Segment, status model.SubscriberStatus) ([]model.Subscriber, error) {
+	e, err := segment.Parse()
+	if err != nil {
+		return nil, apperr.Wrap(apperr.Invalid, err)
+	}
+	var subscribers []model.Subscriber
+	for _, sub := range s.list(status, "") {
+		if e == nil || e.Matches(s.tags[sub.ID]) {
+			subscribers = append(subscribers, sub)
+		}
+	}
+	return subscribers, nil
+}
+
+// find the subscriber with the email address, or nil if there's none. The lock must be held.
+func (s *Store) find(email model.Email) *model.Subscriber {
+	for _, sub := range s.subscribers {
+		if strings.EqualFold(sub.Email.String(), email.String()) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// list the subscribers with the status and the query in their email address, if set, ordered by email address.
+// Deleted subscribers aren't listed. The lock must be held.
+func (s *Store) list(status model.SubscriberStatus, query string) []model.Subscriber {
+	var subscribers []model.Subscriber
+	for _, sub := range s.subscribers {
+		if sub.Email == "" || (status != "" && sub.Status() != status) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(sub.Email.String()), strings.ToLower(query)) {
+			continue
+		}
+		subscribers = append(subscribers, *sub)
+	}
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].Email < subscribers[j].Email
+	})
+	return subscribers
+}
+
+// newToken for confirming the subscriber with the id, replacing the one they had. The lock must be held.
+func (s *Store) newToken(id int64) string {
+	for token, tokenID := range s.tokens {
+		if tokenID == id {
+			delete(s.tokens, token)
+		}
+	}
+	token := randomToken()
+	s.tokens[token] = id
+	return token
+}
+
+// suppress the email address on the suppression list, replacing what was there. The lock must be held.
+func (s *Store) suppress(email model.Email, reason model.SuppressionReason, source string) {
+	hash := emailHash(email)
+	for i, suppression := range s.suppressions {
+		if suppression.EmailHash == hash {
+			s.suppressions = append(s.suppressions[:i], s.suppressions[i+1:]...)
+			break
+		}
+	}
+	s.suppressionID++
+	s.suppressions = append(s.suppressions, model.Suppression{
+		ID: s.suppressionID, EmailHash: hash, Reason: reason, Source: source, Created: s.now()})
+}
+
+// unsuppress the email address, if it's on the suppression list for the reason. The lock must be held.
+func (s *Store) unsuppress(email model.Email, reason model.SuppressionReason) {
+	hash := emailHash(email)
+	for i, suppression := range s.suppressions {
+		if suppression.EmailHash == hash && suppression.Reason == reason {
+			s.suppressions = append(s.suppressions[:i], s.suppressions[i+1:]...)
+			return
+		}
+	}
+}
+
+// enqueue the job with the payload on the queue, if there is one.
+func (s *Store) enqueue(ctx context.Context, p messaging.Payload) error {
+	if s.queue == nil {
+		return nil
+	}
+	m, err := messaging.NewMessage(p)
+	if err != nil {
+		return err
+	}
+	return s.queue.Send(ctx, m)
+}
+
+// emit the domain event on the queue, if there is one.
+func (s *Store) emit(ctx context.Context, e messaging.Event) error {
+	if s.queue == nil {
+		return nil
+	}
+	m, err := messaging.NewEvent(e, s.now())
+	if err != nil {
+		return err
+	}
+	return s.queue.Send(ctx, m)
+}
+
+// limit the slice to n elements, if n isn't zero.
+func limit[T any](s []T, n int) []T {
+	if n > 0 && len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func emailHash(email model.Email) string {
+	h := sha256.Sum256([]byte(strings.ToLower(email.String())))
+	return hex.EncodeToString(h[:])
+}
+
+func tokenHash(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
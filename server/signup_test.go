@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/handlers"
+	"canvas/model"
+	"canvas/server"
+	"canvas/server/servertest"
+	"canvas/storage/faultstore"
+	"canvas/views"
+)
+
+// hiddenInput value in the rendered form.
+func hiddenInput(t *testing.T, body, name string) string {
+	t.Helper()
+
+	m := regexp.MustCompile(`name="` + name + `" value="([^"]*)"`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("no hidden input %v in the page", name)
+	}
+	return m[1]
+}
+
+// postSignup of the email address with the front page form, like a browser would, with the CSRF cookie and token.
+func postSignup(t *testing.T, h http.Handler, email string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("front page has status %v", res.Code)
+	}
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != handlers.CSRFCookieName {
+		t.Fatalf("front page has cookies %v, not just the CSRF cookie", cookies)
+	}
+
+	body := url.Values{
+		"email":                  {email},
+		views.CSRFFieldName:      {hiddenInput(t, res.Body.String(), views.CSRFFieldName)},
+		views.TimestampFieldName: {hiddenInput(t, res.Body.String(), views.TimestampFieldName)},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/newsletter/signup", strings.NewReader(body.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookies[0])
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+type healthMock struct {
+	healthy bool
+}
+
+func (h *healthMock) Healthy() bool {
+	return h.healthy
+}
+
+func TestServer_Signup(t *testing.T) {
+	t.Run("signs up from the front page form, and confirms with the link in the confirmation email", func(t *testing.T) {
+		is := is.New(t)
+
+		h, fakes := servertest.New(t, server.Options{SignupMinFillTime: time.Nanosecond})
+
+		res := postSignup(t, h, "me@example.com")
+		is.Equal(http.StatusFound, res.Code)
+		is.Equal("/newsletter/thanks", res.Header().Get("Location"))
+
+		subscriber, ok := fakes.Store.Subscriber("me@example.com")
+		is.True(ok)
+		is.Equal(model.SubscriberStatusPending, subscriber.Status())
+		is.Equal("en", subscriber.Locale)
+
+		jobs := fakes.Queue.Jobs("confirmation_email")
+		is.Equal(1, len(jobs))
+		is.Equal("me@example.com", jobs[0]["email"])
+		token := jobs[0]["token"]
+		is.Equal(fakes.Store.Token("me@example.com"), token)
+
+		res = httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/newsletter/confirm?token="+token, nil))
+		is.Equal(http.StatusOK, res.Code)
+
+		subscriber, _ = fakes.Store.Subscriber("me@example.com")
+		is.Equal(model.SubscriberStatusConfirmed, subscriber.Status())
+		confirmations := fakes.Queue.Jobs(model.EventSubscriberConfirmed)
+		is.Equal(1, len(confirmations))
+		is.Equal("true", confirmations[0]["firstTime"])
+	})
+
+	t.Run("rejects the signup without the CSRF token, and doesn't store anything", func(t *testing.T) {
+		is := is.New(t)
+
+		h, fakes := servertest.New(t, server.Options{})
+
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/signup", strings.NewReader("email=me%40example.com"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		is.Equal(http.StatusForbidden, res.Code)
+
+		_, ok := fakes.Store.Subscriber("me@example.com")
+		is.True(!ok)
+		is.Equal(0, len(fakes.Queue.Messages()))
+	})
+	t.Run("responds with a friendly 503 if the database is unavailable, and doesn't send a confirmation email", func(t *testing.T) {
+		is := is.New(t)
+
+		queue := &servertest.Queue{}
+		db := faultstore.New(t, servertest.NewStore(queue))
+		db.Set("SignupForNewsletter", faultstore.Fault{Err: faultstore.ErrUnavailable})
+		h, _ := servertest.New(t, server.Options{Database: db, Queue: queue, SignupMinFillTime: time.Nanosecond})
+
+		res := postSignup(t, h, "me@example.com")
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.True(strings.Contains(res.Body.String(), "Please go back and try again in a moment."))
+		is.True(!strings.Contains(res.Body.String(), "connection refused"))
+		is.Equal(1, db.Calls("SignupForNewsletter"))
+		is.Equal(0, len(queue.Jobs("confirmation_email")))
+	})
+
+	t.Run("serves a copy of the front page while the database is unhealthy, but still fails signups", func(t *testing.T) {
+		is := is.New(t)
+
+		health := &healthMock{healthy: true}
+		db := faultstore.New(t, servertest.NewStore(nil))
+		h, _ := servertest.New(t, server.Options{
+			Database:          db,
+			DegradedCacheTTL:  time.Minute,
+			Health:            health,
+			SignupMinFillTime: time.Nanosecond,
+		})
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		is.Equal(http.StatusOK, res.Code)
+		cookies := res.Result().Cookies()
+		body := url.Values{
+			"email":                  {"me@example.com"},
+			views.CSRFFieldName:      {hiddenInput(t, res.Body.String(), views.CSRFFieldName)},
+			views.TimestampFieldName: {hiddenInput(t, res.Body.String(), views.TimestampFieldName)},
+		}
+
+		health.healthy = false
+		db.Set(faultstore.AnyMethod, faultstore.Fault{Err: faultstore.ErrUnavailable})
+
+		res = httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		is.Equal(http.StatusOK, res.Code)
+		is.Equal("true", res.Header().Get(handlers.StaleHeaderName))
+		is.True(strings.Contains(res.Body.String(), `action="/newsletter/signup"`))
+
+		req := httptest.NewRequest(http.MethodPost, "/newsletter/signup", strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		res = httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		is.Equal(http.StatusServiceUnavailable, res.Code)
+		is.Equal("", res.Header().Get(handlers.StaleHeaderName))
+		is.True(strings.Contains(res.Body.String(), "Please go back and try again in a moment."))
+	})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+// store is what the routes need from the database, which is a *storage.Database, or a fake in tests.
+type store interface {
+	pinger
+
+	// Signups and subscriptions.
+	SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error)
+	ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error)
+	ResendConfirmation(ctx context.Context, email model.Email) (bool, error)
+	IsSubscribed(ctx context.Context, email model.Email) (bool, error)
+	Unsubscribe(ctx context.Context, email model.Email) error
+	Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+
+	// Newsletter issues.
+	ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error)
+	GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error)
+	GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error)
+	GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error)
+	QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error
+	ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error
+	UnscheduleNewsletter(ctx context.Context, id int64) error
+	RecordEmailSend(ctx context.Context, s model.EmailSend) error
+
+	// Admin pages.
+	SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error)
+	SendStats(ctx context.Context, days int) (model.SendStats, error)
+	ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error)
+	SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error)
+	GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error)
+	ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error)
+	DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error)
+	CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error)
+	ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error)
+	ListTags(ctx context.Context) ([]model.TagCount, error)
+	AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+	RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+	ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error
+	RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error
+	AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error
+	ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error)
+	RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error)
+
+	// Imports of subscribers from uploaded files.
+	CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+		next func() (model.SubscriberImportRow, error)) (int64, error)
+	GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error)
+	ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error)
+	ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error)
+	ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error
+	QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error
+	DeleteSubscriberImport(ctx context.Context, id int64, actor string) error
+
+	// API tokens for the admin API.
+	CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time, actor string) (model.APIToken, string, error)
+	ListAPITokens(ctx context.Context) ([]model.APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error)
+	AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error)
+
+	// Webhook endpoints, and the log of their deliveries.
+	CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error)
+	GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error)
+
+	// Reports from the mail provider.
+	RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error)
+	RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error
+	RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error
+}
+
+// jobQueue is what the routes need from the queue of jobs, which is a *messaging.Queue, or a fake in tests.
+type jobQueue interface {
+	Send(ctx context.Context, m model.Message) error
+	Depth(ctx context.Context) (int, error)
+}
+
+// healthChecker of the database, which is a *storage.HealthMonitor, or a fake in tests.
+type healthChecker interface {
+	Healthy() bool
+}
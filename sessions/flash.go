@@ -0,0 +1,39 @@
+package sessions
+
+import (
+	"context"
+)
+
+// FlashLevel of a flash message, for how it's shown.
+type FlashLevel string
+
+const (
+	FlashInfo    FlashLevel = "info"
+	FlashSuccess FlashLevel = "success"
+	FlashError   FlashLevel = "error"
+)
+
+// Flash message to show on the next page, such as after a redirect.
+type Flash struct {
+	Level   FlashLevel
+	Message string
+}
+
+const flashesKey = "flashes"
+
+// AddFlash with the level and message to the session in ctx, after any flashes already added.
+func AddFlash(ctx context.Context, level FlashLevel, message string) error {
+	flashes, _ := Get[[]Flash](ctx, flashesKey)
+	return Set(ctx, flashesKey, append(flashes, Flash{Level: level, Message: message}))
+}
+
+// ConsumeFlashes from the session in ctx, in the order they were added, and remove them,
+// so they're shown only once.
+func ConsumeFlashes(ctx context.Context) []Flash {
+	flashes, ok := Get[[]Flash](ctx, flashesKey)
+	if !ok {
+		return nil
+	}
+	_ = Delete(ctx, flashesKey)
+	return flashes
+}
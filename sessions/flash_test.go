@@ -0,0 +1,58 @@
+package sessions_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/sessions"
+)
+
+func TestAddFlash(t *testing.T) {
+	t.Run("keeps flashes until they're consumed, in the order they were added", func(t *testing.T) {
+		is := is.New(t)
+
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Secret: []byte("secret"),
+			Store:  sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{}),
+		})
+
+		var cookie *http.Cookie
+		request := func(h http.HandlerFunc) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			res := httptest.NewRecorder()
+			m.Middleware(h).ServeHTTP(res, req)
+			if cookies := res.Result().Cookies(); len(cookies) > 0 {
+				cookie = cookies[0]
+			}
+		}
+
+		request(func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.AddFlash(r.Context(), sessions.FlashInfo, "One"))
+			is.NoErr(sessions.AddFlash(r.Context(), sessions.FlashSuccess, "Two"))
+		})
+		request(func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.AddFlash(r.Context(), sessions.FlashError, "Three"))
+		})
+
+		var flashes []sessions.Flash
+		request(func(w http.ResponseWriter, r *http.Request) {
+			flashes = sessions.ConsumeFlashes(r.Context())
+		})
+		is.Equal([]sessions.Flash{
+			{Level: sessions.FlashInfo, Message: "One"},
+			{Level: sessions.FlashSuccess, Message: "Two"},
+			{Level: sessions.FlashError, Message: "Three"},
+		}, flashes)
+
+		request(func(w http.ResponseWriter, r *http.Request) {
+			flashes = sessions.ConsumeFlashes(r.Context())
+		})
+		is.Equal(0, len(flashes))
+	})
+}
@@ -0,0 +1,108 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in memory. It's not shared between app instances and is lost on restart,
+// so use storage.Database instead outside of tests and local development.
+type MemoryStore struct {
+	lock     sync.Mutex
+	now      func() time.Time
+	sessions map[string]memorySession
+}
+
+type memorySession struct {
+	values  map[string]json.RawMessage
+	expires time.Time
+}
+
+// NewMemoryStoreOptions for NewMemoryStore.
+type NewMemoryStoreOptions struct {
+	// Now is for when sessions expire. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewMemoryStore without sessions.
+func NewMemoryStore(opts NewMemoryStoreOptions) *MemoryStore {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &MemoryStore{
+		now:      opts.Now,
+		sessions: map[string]memorySession{},
+	}
+}
+
+// GetSession data by session ID, or nil if there's no such session or it has expired.
+func (s *MemoryStore) GetSession(ctx context.Context, id string) (map[string]json.RawMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || !s.now().Before(session.expires) {
+		return nil, nil
+	}
+	values := map[string]json.RawMessage{}
+	for k, v := range session.values {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// SaveSession like storage.Database.SaveSession.
+func (s *MemoryStore) SaveSession(ctx context.Context, id string, changed map[string]json.RawMessage, deleted []string, lifetime time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	session, ok := s.sessions[id]
+	if !ok || !now.Before(session.expires) {
+		session = memorySession{values: map[string]json.RawMessage{}}
+	}
+	for k, v := range changed {
+		session.values[k] = v
+	}
+	for _, k := range deleted {
+		delete(session.values, k)
+	}
+	session.expires = now.Add(lifetime)
+	s.sessions[id] = session
+	return nil
+}
+
+// DeleteSession by ID, if it exists.
+func (s *MemoryStore) DeleteSession(ctx context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// DeleteExpiredSessions and return how many were deleted.
+func (s *MemoryStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var n int64
+	now := s.now()
+	for id, session := range s.sessions {
+		if !now.Before(session.expires) {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Len is the number of sessions in the store, including expired ones not cleaned up yet.
+func (s *MemoryStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.sessions)
+}
@@ -0,0 +1,384 @@
+// Package sessions keeps data for a browser between requests, in a store keyed by a signed session cookie.
+//
+// Sessions are loaded by the Manager middleware and used through the request context with Get, Set, and Delete.
+// A session is only saved, and the cookie only set, when the request changes it.
+package sessions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrNoSession is returned when changing the session of a request that didn't go through the Manager middleware.
+var ErrNoSession = errors.New("no session in context")
+
+// ErrAlreadySaved is returned when changing the session after the response headers have been written,
+// because the session is saved with them.
+var ErrAlreadySaved = errors.New("session already saved")
+
+type store interface {
+	GetSession(ctx context.Context, id string) (map[string]json.RawMessage, error)
+	SaveSession(ctx context.Context, id string, changed map[string]json.RawMessage, deleted []string, lifetime time.Duration) error
+	DeleteSession(ctx context.Context, id string) error
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// Manager loads and saves sessions for requests, and deletes expired sessions from the store.
+type Manager struct {
+	cleanupInterval time.Duration
+	cookieName      string
+	lifetime        time.Duration
+	log             *zap.Logger
+	secret          []byte
+	store           store
+}
+
+// NewManagerOptions for NewManager.
+type NewManagerOptions struct {
+	// CleanupInterval is how often expired sessions are deleted from the store. Defaults to an hour.
+	CleanupInterval time.Duration
+	// CookieName for the session cookie. Defaults to "session".
+	CookieName string
+	// Lifetime of a session after it was last changed. Defaults to 24 hours.
+	Lifetime time.Duration
+	Log      *zap.Logger
+	// Secret for signing session cookies.
+	Secret []byte
+	Store  store
+}
+
+// NewManager with the given options.
+// If no logger is provided, logs are discarded.
+func NewManager(opts NewManagerOptions) *Manager {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = time.Hour
+	}
+	if opts.CookieName == "" {
+		opts.CookieName = "session"
+	}
+	if opts.Lifetime <= 0 {
+		opts.Lifetime = 24 * time.Hour
+	}
+	return &Manager{
+		cleanupInterval: opts.CleanupInterval,
+		cookieName:      opts.CookieName,
+		lifetime:        opts.Lifetime,
+		log:             opts.Log,
+		secret:          opts.Secret,
+		store:           opts.Store,
+	}
+}
+
+// Session data for one browser. It's safe for concurrent use by the goroutines of a request.
+type Session struct {
+	lock       sync.Mutex
+	id         string
+	values     map[string]json.RawMessage
+	changed    map[string]bool
+	modified   bool
+	regenerate bool
+	destroyed  bool
+	saved      bool
+}
+
+type contextKey struct{}
+
+// Middleware loading the session from the cookie into the request context, or starting an empty one
+// if there's no valid cookie or the session has expired. Changes are saved just before the response headers
+// are written, or when the handler returns.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &Session{values: map[string]json.RawMessage{}, changed: map[string]bool{}}
+		if c, err := r.Cookie(m.cookieName); err == nil {
+			if id, ok := m.verify(c.Value); ok {
+				values, err := m.store.GetSession(r.Context(), id)
+				if err != nil {
+					m.log.Info("Error getting session", zap.Error(err))
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if values != nil {
+					s.id = id
+					s.values = values
+				}
+			}
+		}
+
+		sw := &sessionWriter{ResponseWriter: w, save: func() { m.save(r.Context(), w, s) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+		sw.saveOnce()
+	})
+}
+
+// Start deleting expired sessions every cleanup interval, blocking until ctx is cancelled.
+func (m *Manager) Start(ctx context.Context) {
+	m.log.Info("Starting session cleanup")
+
+	for {
+		n, err := m.store.DeleteExpiredSessions(ctx)
+		if err != nil && ctx.Err() == nil {
+			m.log.Info("Error deleting expired sessions", zap.Error(err))
+		} else {
+			m.log.Debug("Deleted expired sessions", zap.Int64("count", n))
+		}
+
+		t := time.NewTimer(m.cleanupInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			m.log.Info("Stopping session cleanup")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// save the session if it's changed, setting or clearing the cookie. Only the first call does anything.
+func (m *Manager) save(ctx context.Context, w http.ResponseWriter, s *Session) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.saved {
+		return
+	}
+	s.saved = true
+
+	if s.destroyed {
+		if s.id == "" {
+			return
+		}
+		if err := m.store.DeleteSession(ctx, s.id); err != nil {
+			m.log.Info("Error deleting session", zap.Error(err))
+		}
+		http.SetCookie(w, m.cookie("", -1))
+		return
+	}
+
+	if !s.modified {
+		return
+	}
+
+	changed := map[string]json.RawMessage{}
+	var deleted []string
+	if s.id == "" || s.regenerate {
+		id, err := createID()
+		if err != nil {
+			m.log.Info("Error creating session ID", zap.Error(err))
+			return
+		}
+		if s.id != "" {
+			if err := m.store.DeleteSession(ctx, s.id); err != nil {
+				m.log.Info("Error deleting session", zap.Error(err))
+			}
+		}
+		s.id = id
+		changed = s.values
+	} else {
+		for k := range s.changed {
+			if v, ok := s.values[k]; ok {
+				changed[k] = v
+			} else {
+				deleted = append(deleted, k)
+			}
+		}
+	}
+
+	if err := m.store.SaveSession(ctx, s.id, changed, deleted, m.lifetime); err != nil {
+		m.log.Info("Error saving session", zap.Error(err))
+		return
+	}
+	http.SetCookie(w, m.cookie(m.sign(s.id), int(m.lifetime.Seconds())))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.cookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sign the session ID for the cookie value, as the ID and its signature separated by a dot.
+func (m *Manager) sign(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(m.mac(id))
+}
+
+// verify the cookie value from sign, returning the session ID.
+func (m *Manager) verify(value string) (string, bool) {
+	id, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	signatureAsBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(signatureAsBytes, m.mac(id)) {
+		return "", false
+	}
+	return id, true
+}
+
+func (m *Manager) mac(id string) []byte {
+	h := hmac.New(sha256.New, m.secret)
+	h.Write([]byte("session:" + id))
+	return h.Sum(nil)
+}
+
+func createID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// sessionWriter saves the session before the response headers are written, so the cookie can be set.
+type sessionWriter struct {
+	http.ResponseWriter
+	once sync.Once
+	save func()
+}
+
+func (w *sessionWriter) saveOnce() {
+	w.once.Do(w.save)
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	w.saveOnce()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.saveOnce()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the underlying http.ResponseWriter if it can, after saving the session, for streaming responses.
+func (w *sessionWriter) Flush() {
+	w.saveOnce()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap the underlying http.ResponseWriter.
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func fromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// Get the value for key from the session in ctx. It's false if there's no such value, or it isn't a T.
+func Get[T any](ctx context.Context, key string) (T, bool) {
+	var v T
+	s := fromContext(ctx)
+	if s == nil {
+		return v, false
+	}
+
+	s.lock.Lock()
+	raw, ok := s.values[key]
+	s.lock.Unlock()
+	if !ok {
+		return v, false
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+// Set the value for key in the session in ctx. The value is stored as JSON.
+// Setting a value after Destroy starts a new session.
+func Set(ctx context.Context, key string, v any) error {
+	s := fromContext(ctx)
+	if s == nil {
+		return ErrNoSession
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.saved {
+		return ErrAlreadySaved
+	}
+	if s.destroyed {
+		s.destroyed = false
+		s.regenerate = true
+	}
+	s.values[key] = raw
+	s.changed[key] = true
+	s.modified = true
+	return nil
+}
+
+// Delete the value for key from the session in ctx.
+func Delete(ctx context.Context, key string) error {
+	return change(ctx, func(s *Session) {
+		if _, ok := s.values[key]; !ok {
+			return
+		}
+		delete(s.values, key)
+		s.changed[key] = true
+		s.modified = true
+	})
+}
+
+// Regenerate the ID of the session in ctx, keeping its values. The old ID stops working.
+// Do this when the privileges of the session change, such as at login, to prevent session fixation.
+func Regenerate(ctx context.Context) error {
+	return change(ctx, func(s *Session) {
+		s.regenerate = true
+		s.modified = true
+	})
+}
+
+// Destroy the session in ctx, deleting it from the store and clearing the cookie.
+func Destroy(ctx context.Context) error {
+	return change(ctx, func(s *Session) {
+		s.values = map[string]json.RawMessage{}
+		s.changed = map[string]bool{}
+		s.modified = false
+		s.regenerate = false
+		s.destroyed = true
+	})
+}
+
+func change(ctx context.Context, f func(s *Session)) error {
+	s := fromContext(ctx)
+	if s == nil {
+		return ErrNoSession
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.saved {
+		return ErrAlreadySaved
+	}
+	f(s)
+	return nil
+}
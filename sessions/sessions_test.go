@@ -0,0 +1,289 @@
+package sessions_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/sessions"
+)
+
+// countingStore counts saves to the underlying MemoryStore.
+type countingStore struct {
+	*sessions.MemoryStore
+	lock  sync.Mutex
+	saves int
+}
+
+func (s *countingStore) SaveSession(ctx context.Context, id string, changed map[string]json.RawMessage, deleted []string, lifetime time.Duration) error {
+	s.lock.Lock()
+	s.saves++
+	s.lock.Unlock()
+	return s.MemoryStore.SaveSession(ctx, id, changed, deleted, lifetime)
+}
+
+func TestManager_Middleware(t *testing.T) {
+	setup := func() (*sessions.Manager, *countingStore, *time.Time) {
+		now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+		store := &countingStore{MemoryStore: sessions.NewMemoryStore(sessions.NewMemoryStoreOptions{
+			Now: func() time.Time { return now },
+		})}
+		m := sessions.NewManager(sessions.NewManagerOptions{
+			Lifetime: time.Hour,
+			Secret:   []byte("secret"),
+			Store:    store,
+		})
+		return m, store, &now
+	}
+
+	// request through the middleware with the cookie, if any, returning the response.
+	request := func(m *sessions.Manager, cookie *http.Cookie, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		res := httptest.NewRecorder()
+		m.Middleware(h).ServeHTTP(res, req)
+		return res
+	}
+
+	sessionCookie := func(res *httptest.ResponseRecorder) *http.Cookie {
+		for _, c := range res.Result().Cookies() {
+			if c.Name == "session" {
+				return c
+			}
+		}
+		return nil
+	}
+
+	set := func(key string, v any) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := sessions.Set(r.Context(), key, v); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	get := func(key string) (http.HandlerFunc, *string) {
+		var v string
+		return func(w http.ResponseWriter, r *http.Request) {
+			v, _ = sessions.Get[string](r.Context(), key)
+		}, &v
+	}
+
+	t.Run("doesn't save or set a cookie if the session isn't modified", func(t *testing.T) {
+		is := is.New(t)
+		m, store, _ := setup()
+
+		h, _ := get("name")
+		res := request(m, nil, h)
+		is.True(sessionCookie(res) == nil)
+		is.Equal(0, store.saves)
+		is.Equal(0, store.Len())
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+		is.Equal(1, store.saves)
+
+		h, name := get("name")
+		res = request(m, cookie, h)
+		is.Equal("me", *name)
+		is.True(sessionCookie(res) == nil)
+		is.Equal(1, store.saves)
+	})
+
+	t.Run("keeps typed values between requests and sets a secure cookie", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		type flash struct {
+			Message string
+		}
+		res := request(m, nil, func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.Set(r.Context(), "count", 3))
+			is.NoErr(sessions.Set(r.Context(), "flash", flash{Message: "Hi"}))
+			w.WriteHeader(http.StatusFound)
+		})
+		is.Equal(http.StatusFound, res.Code)
+
+		cookie := sessionCookie(res)
+		is.True(cookie != nil)
+		is.Equal("/", cookie.Path)
+		is.Equal(60*60, cookie.MaxAge)
+		is.True(cookie.Secure)
+		is.True(cookie.HttpOnly)
+		is.Equal(http.SameSiteLaxMode, cookie.SameSite)
+
+		request(m, cookie, func(w http.ResponseWriter, r *http.Request) {
+			count, ok := sessions.Get[int](r.Context(), "count")
+			is.True(ok)
+			is.Equal(3, count)
+
+			f, ok := sessions.Get[flash](r.Context(), "flash")
+			is.True(ok)
+			is.Equal("Hi", f.Message)
+
+			_, ok = sessions.Get[string](r.Context(), "count")
+			is.True(!ok)
+			_, ok = sessions.Get[int](r.Context(), "doesnotexist")
+			is.True(!ok)
+		})
+	})
+
+	t.Run("deletes values", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+		request(m, cookie, func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.Delete(r.Context(), "name"))
+		})
+
+		h, name := get("name")
+		request(m, cookie, h)
+		is.Equal("", *name)
+	})
+
+	t.Run("starts a new session after the old one expires, and cleans up expired sessions", func(t *testing.T) {
+		is := is.New(t)
+		m, store, now := setup()
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+		*now = now.Add(time.Hour)
+
+		h, name := get("name")
+		request(m, cookie, h)
+		is.Equal("", *name)
+
+		res := request(m, cookie, set("other", "value"))
+		is.True(sessionCookie(res).Value != cookie.Value)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		m.Start(ctx)
+		is.Equal(1, store.Len())
+	})
+
+	t.Run("ignores cookies with an invalid signature", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+		id, _, _ := strings.Cut(cookie.Value, ".")
+
+		for _, value := range []string{id, id + ".invalid", id + "." + strings.Repeat("A", 43), "nope"} {
+			h, name := get("name")
+			request(m, &http.Cookie{Name: "session", Value: value}, h)
+			is.Equal("", *name)
+		}
+	})
+
+	t.Run("regenerates the session ID, keeping the values", func(t *testing.T) {
+		is := is.New(t)
+		m, store, _ := setup()
+
+		oldCookie := sessionCookie(request(m, nil, set("name", "me")))
+		res := request(m, oldCookie, func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.Regenerate(r.Context()))
+		})
+		newCookie := sessionCookie(res)
+		is.True(newCookie != nil)
+		is.True(newCookie.Value != oldCookie.Value)
+		is.Equal(1, store.Len())
+
+		h, name := get("name")
+		request(m, newCookie, h)
+		is.Equal("me", *name)
+
+		h, name = get("name")
+		request(m, oldCookie, h)
+		is.Equal("", *name)
+	})
+
+	t.Run("destroys the session and clears the cookie", func(t *testing.T) {
+		is := is.New(t)
+		m, store, _ := setup()
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+		res := request(m, cookie, func(w http.ResponseWriter, r *http.Request) {
+			is.NoErr(sessions.Destroy(r.Context()))
+		})
+		is.Equal(-1, sessionCookie(res).MaxAge)
+		is.Equal(0, store.Len())
+	})
+
+	t.Run("doesn't lose values from concurrent requests changing different keys", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		cookie := sessionCookie(request(m, nil, set("name", "me")))
+
+		// Both requests load the session before either saves.
+		var loaded sync.WaitGroup
+		loaded.Add(2)
+		var done sync.WaitGroup
+		for _, key := range []string{"a", "b"} {
+			key := key
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				request(m, cookie, func(w http.ResponseWriter, r *http.Request) {
+					loaded.Done()
+					loaded.Wait()
+					_ = sessions.Set(r.Context(), key, key)
+				})
+			}()
+		}
+		done.Wait()
+
+		request(m, cookie, func(w http.ResponseWriter, r *http.Request) {
+			for _, key := range []string{"name", "a", "b"} {
+				_, ok := sessions.Get[string](r.Context(), key)
+				is.True(ok)
+			}
+		})
+	})
+
+	t.Run("can be changed from several goroutines in a request", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		res := request(m, nil, func(w http.ResponseWriter, r *http.Request) {
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_ = sessions.Set(r.Context(), "key", i)
+					_, _ = sessions.Get[int](r.Context(), "key")
+				}(i)
+			}
+			wg.Wait()
+		})
+		is.True(sessionCookie(res) != nil)
+	})
+
+	t.Run("can't be changed after the response headers are written", func(t *testing.T) {
+		is := is.New(t)
+		m, _, _ := setup()
+
+		request(m, nil, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			is.Equal(sessions.ErrAlreadySaved, sessions.Set(r.Context(), "name", "me"))
+		})
+	})
+
+	t.Run("can't be changed without the middleware", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(sessions.ErrNoSession, sessions.Set(context.Background(), "name", "me"))
+		_, ok := sessions.Get[string](context.Background(), "name")
+		is.True(!ok)
+	})
+}
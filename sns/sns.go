@@ -0,0 +1,225 @@
+// Package sns has the messages that AWS SNS posts to HTTP endpoints, and verifies that they come from SNS.
+// See https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html
+package sns
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types.
+const (
+	TypeNotification             = "Notification"
+	TypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	TypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// Message posted by SNS. Which fields are set depends on the Type.
+type Message struct {
+	Type             string `json:"Type" openapi:"enum=Notification|SubscriptionConfirmation|UnsubscribeConfirmation"`
+	MessageId        string `json:"MessageId"`
+	Token            string `json:"Token,omitempty"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject,omitempty"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp" openapi:"format=date-time"`
+	SignatureVersion string `json:"SignatureVersion" openapi:"enum=1|2"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL,omitempty"`
+	UnsubscribeURL   string `json:"UnsubscribeURL,omitempty"`
+}
+
+// awsHostMatcher for the hosts of SNS, like sns.eu-west-1.amazonaws.com.
+var awsHostMatcher = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// IsSNSURL is true if the URL is an https URL on an SNS host.
+// Check it before following URLs in messages, like the SubscribeURL, so they can't point anywhere else.
+func IsSNSURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.User == nil && u.Port() == "" && awsHostMatcher.MatchString(u.Hostname())
+}
+
+// ErrInvalidSignature is returned by Verifier.Verify for messages that aren't signed by SNS.
+// Other errors are from getting the signing certificate, and verifying again later might work.
+var ErrInvalidSignature = errors.New("invalid SNS message signature")
+
+// certAttempts is how many times getting a signing certificate is tried.
+const certAttempts = 3
+
+// Verifier of message signatures, with the signing certificates cached by URL.
+type Verifier struct {
+	certs      map[string]*x509.Certificate
+	certsLock  sync.RWMutex
+	client     *http.Client
+	retryDelay time.Duration
+}
+
+type NewVerifierOptions struct {
+	// Client for getting signing certificates. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+	// RetryDelay before the first retry of getting a signing certificate, doubling for each retry. Defaults to 100ms.
+	RetryDelay time.Duration
+}
+
+func NewVerifier(opts NewVerifierOptions) *Verifier {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 100 * time.Millisecond
+	}
+	return &Verifier{
+		certs:      map[string]*x509.Certificate{},
+		client:     opts.Client,
+		retryDelay: opts.RetryDelay,
+	}
+}
+
+// Verify the signature of the message, with the certificate at its SigningCertURL, which must be an SNS URL.
+// Both signature versions are supported, 1 with SHA1 and 2 with SHA256.
+// Errors for messages that aren't signed by SNS wrap ErrInvalidSignature.
+func (v *Verifier) Verify(ctx context.Context, m Message) error {
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q: %w", m.SignatureVersion, ErrInvalidSignature)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("error decoding signature: %v: %w", err, ErrInvalidSignature)
+	}
+
+	stringToSign, err := StringToSign(m)
+	if err != nil {
+		return fmt.Errorf("%v: %w", err, ErrInvalidSignature)
+	}
+
+	if !IsSNSURL(m.SigningCertURL) {
+		return fmt.Errorf("signing certificate URL %q is not an SNS URL: %w", m.SigningCertURL, ErrInvalidSignature)
+	}
+	cert, err := v.getCert(ctx, m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing certificate doesn't have an RSA public key: %w", ErrInvalidSignature)
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum([]byte(stringToSign))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(stringToSign))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return fmt.Errorf("%v: %w", err, ErrInvalidSignature)
+	}
+	return nil
+}
+
+// StringToSign of the message, which the signature is over.
+// It's the names and values of some of the fields, in order, each on their own line.
+func StringToSign(m Message) (string, error) {
+	var fields []string
+	switch m.Type {
+	case TypeNotification:
+		fields = []string{"Message", m.Message, "MessageId", m.MessageId}
+		if m.Subject != "" {
+			fields = append(fields, "Subject", m.Subject)
+		}
+		fields = append(fields, "Timestamp", m.Timestamp, "TopicArn", m.TopicArn, "Type", m.Type)
+	case TypeSubscriptionConfirmation, TypeUnsubscribeConfirmation:
+		fields = []string{"Message", m.Message, "MessageId", m.MessageId, "SubscribeURL", m.SubscribeURL,
+			"Timestamp", m.Timestamp, "Token", m.Token, "TopicArn", m.TopicArn, "Type", m.Type}
+	default:
+		return "", fmt.Errorf("unknown message type %q", m.Type)
+	}
+	return strings.Join(fields, "\n") + "\n", nil
+}
+
+// getCert at the URL from the cache, or get it with retries and a backoff, since SNS certificates rarely change.
+func (v *Verifier) getCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	v.certsLock.RLock()
+	cert, ok := v.certs[certURL]
+	v.certsLock.RUnlock()
+	if ok {
+		return cert, nil
+	}
+
+	delay := v.retryDelay
+	var err error
+	for attempt := 1; attempt <= certAttempts; attempt++ {
+		cert, err = v.fetchCert(ctx, certURL)
+		if err == nil {
+			break
+		}
+		if attempt == certAttempts {
+			return nil, fmt.Errorf("error getting signing certificate after %v attempts: %w", certAttempts, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error getting signing certificate: %w", err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	v.certsLock.Lock()
+	v.certs[certURL] = cert
+	v.certsLock.Unlock()
+	return cert, nil
+}
+
+func (v *Verifier) fetchCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status %v", res.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
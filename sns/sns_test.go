@@ -0,0 +1,221 @@
+package sns_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/sns"
+)
+
+const certURL = "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-abc123.pem"
+
+// roundTripperFunc serves requests without a network.
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newSigner returns a function that signs messages with a new key, and a verifier with a client
+// serving the self-signed certificate for it at certURL, counting the requests.
+// The first failures requests get a 503 Service Unavailable.
+func newSigner(t *testing.T, failures int) (func(m *sns.Message), *sns.Verifier, *int) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	var requests int
+	client := &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		if requests <= failures {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		}
+		if r.URL.String() != certURL {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(&bytes.Buffer{})}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(certPEM))}, nil
+	})}
+
+	sign := func(m *sns.Message) {
+		s, err := sns.StringToSign(*m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hash crypto.Hash
+		var digest []byte
+		if m.SignatureVersion == "1" {
+			hash = crypto.SHA1
+			sum := sha1.Sum([]byte(s))
+			digest = sum[:]
+		} else {
+			hash = crypto.SHA256
+			sum := sha256.Sum256([]byte(s))
+			digest = sum[:]
+		}
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Signature = base64.StdEncoding.EncodeToString(signature)
+	}
+
+	return sign, sns.NewVerifier(sns.NewVerifierOptions{Client: client, RetryDelay: time.Millisecond}), &requests
+}
+
+func newNotification() sns.Message {
+	return sns.Message{
+		Type:             sns.TypeNotification,
+		MessageId:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         "arn:aws:sns:eu-west-1:123456789012:ses-notifications",
+		Message:          `{"notificationType":"Bounce"}`,
+		Timestamp:        "2022-12-12T12:00:00.000Z",
+		SignatureVersion: "1",
+		SigningCertURL:   certURL,
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	t.Run("verifies signature versions 1 and 2, and fetches the certificate once", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, requests := newSigner(t, 0)
+
+		for _, version := range []string{"1", "2"} {
+			m := newNotification()
+			m.SignatureVersion = version
+			sign(&m)
+			is.NoErr(v.Verify(context.Background(), m))
+		}
+		is.Equal(1, *requests)
+	})
+
+	t.Run("verifies subscription confirmations", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, _ := newSigner(t, 0)
+
+		m := newNotification()
+		m.Type = sns.TypeSubscriptionConfirmation
+		m.Token = "abc"
+		m.SubscribeURL = "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=abc"
+		sign(&m)
+		is.NoErr(v.Verify(context.Background(), m))
+	})
+
+	t.Run("errors if the message was changed after signing", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, _ := newSigner(t, 0)
+
+		m := newNotification()
+		sign(&m)
+		m.Message = `{"notificationType":"Complaint"}`
+		is.True(errors.Is(v.Verify(context.Background(), m), sns.ErrInvalidSignature))
+	})
+
+	t.Run("errors if the message was signed with another key", func(t *testing.T) {
+		is := is.New(t)
+		_, v, _ := newSigner(t, 0)
+		otherSign, _, _ := newSigner(t, 0)
+
+		m := newNotification()
+		otherSign(&m)
+		is.True(errors.Is(v.Verify(context.Background(), m), sns.ErrInvalidSignature))
+	})
+
+	t.Run("retries getting the certificate", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, requests := newSigner(t, 2)
+
+		m := newNotification()
+		sign(&m)
+		is.NoErr(v.Verify(context.Background(), m))
+		is.Equal(3, *requests)
+	})
+
+	t.Run("errors if getting the certificate keeps failing, but not as an invalid signature", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, requests := newSigner(t, 3)
+
+		m := newNotification()
+		sign(&m)
+		err := v.Verify(context.Background(), m)
+		is.True(err != nil)
+		is.True(!errors.Is(err, sns.ErrInvalidSignature))
+		is.Equal(3, *requests)
+
+		// The failure isn't cached, so it works once the certificate can be got.
+		is.NoErr(v.Verify(context.Background(), m))
+	})
+
+	t.Run("errors if the certificate URL is not an SNS URL, without fetching it", func(t *testing.T) {
+		is := is.New(t)
+		sign, v, requests := newSigner(t, 0)
+
+		m := newNotification()
+		m.SigningCertURL = "https://example.com/cert.pem"
+		sign(&m)
+		is.True(errors.Is(v.Verify(context.Background(), m), sns.ErrInvalidSignature))
+		is.Equal(0, *requests)
+	})
+
+	t.Run("errors on an unsupported signature version", func(t *testing.T) {
+		is := is.New(t)
+		_, v, _ := newSigner(t, 0)
+
+		m := newNotification()
+		m.SignatureVersion = "3"
+		is.True(errors.Is(v.Verify(context.Background(), m), sns.ErrInvalidSignature))
+	})
+}
+
+func TestIsSNSURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected bool
+	}{
+		{"https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription", true},
+		{"https://sns.cn-north-1.amazonaws.com.cn/cert.pem", true},
+		{"http://sns.eu-west-1.amazonaws.com/", false},
+		{"https://sns.eu-west-1.amazonaws.com.example.com/", false},
+		{"https://evil.com/sns.eu-west-1.amazonaws.com", false},
+		{"https://sns.eu-west-1.amazonaws.com:8443/", false},
+		{"https://user@sns.eu-west-1.amazonaws.com/", false},
+		{"https://s3.amazonaws.com/", false},
+		{"not a url", false},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.expected, sns.IsSNSURL(test.url))
+		})
+	}
+}
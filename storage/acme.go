@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMECache of the certificates and account key of autocert in the database, so all instances of the app
+// share them, and only one certificate is requested for each host.
+type ACMECache struct {
+	database *Database
+}
+
+// ACMECache in the database.
+func (d *Database) ACMECache() *ACMECache {
+	return &ACMECache{database: d}
+}
+
+// Get satisfies autocert.Cache, with autocert.ErrCacheMiss if there's nothing for the key.
+func (c *ACMECache) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx = withQueryName(ctx, "ACMECache.Get")
+	var data []byte
+	err := c.database.DB.GetContext(ctx, &data, `select data from acme_cache where key = $1`, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put satisfies autocert.Cache.
+func (c *ACMECache) Put(ctx context.Context, key string, data []byte) error {
+	ctx = withQueryName(ctx, "ACMECache.Put")
+	query := `
+		insert into acme_cache (key, data)
+		values ($1, $2)
+		on conflict (key) do update set data = excluded.data, updated = now()`
+	_, err := c.database.DB.ExecContext(ctx, query, key, data)
+	return err
+}
+
+// Delete satisfies autocert.Cache. Deleting a key that isn't there isn't an error.
+func (c *ACMECache) Delete(ctx context.Context, key string) error {
+	ctx = withQueryName(ctx, "ACMECache.Delete")
+	_, err := c.database.DB.ExecContext(ctx, `delete from acme_cache where key = $1`, key)
+	return err
+}
@@ -0,0 +1,47 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+	"golang.org/x/crypto/acme/autocert"
+
+	"canvas/integrationtest"
+)
+
+func TestDatabase_ACMECache(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("puts, replaces, gets, and deletes data by key", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		cache := db.ACMECache()
+		_, err := cache.Get(context.Background(), "example.com")
+		is.Equal(autocert.ErrCacheMiss, err)
+
+		err = cache.Put(context.Background(), "example.com", []byte("old"))
+		is.NoErr(err)
+		err = cache.Put(context.Background(), "example.com", []byte("new"))
+		is.NoErr(err)
+
+		data, err := cache.Get(context.Background(), "example.com")
+		is.NoErr(err)
+		is.Equal("new", string(data))
+
+		// Another instance of the app shares the data.
+		data, err = db.ACMECache().Get(context.Background(), "example.com")
+		is.NoErr(err)
+		is.Equal("new", string(data))
+
+		err = cache.Delete(context.Background(), "example.com")
+		is.NoErr(err)
+		_, err = cache.Get(context.Background(), "example.com")
+		is.Equal(autocert.ErrCacheMiss, err)
+
+		err = cache.Delete(context.Background(), "example.com")
+		is.NoErr(err)
+	})
+}
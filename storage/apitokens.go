@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/model"
+)
+
+// apiTokenPrefix of every API token, so they're recognisable, like by secret scanners.
+const apiTokenPrefix = "canvas_"
+
+// apiTokenLastUsedResolution is how often the last use of an API token is recorded at most,
+// so every request with it doesn't have to write to the database.
+const apiTokenLastUsedResolution = time.Minute
+
+// APITokenActor of audit events for changes made with the API token, like "api_token/1".
+func APITokenActor(t model.APIToken) string {
+	return fmt.Sprintf("api_token/%v", t.ID)
+}
+
+// apiTokenRow of the api_tokens table, with the scopes separated by spaces.
+type apiTokenRow struct {
+	ID         int64
+	Name       string
+	Scopes     string
+	LastUsedAt *time.Time `db:"last_used_at"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	Created    time.Time
+}
+
+func (r apiTokenRow) token() model.APIToken {
+	t := model.APIToken{ID: r.ID, Name: r.Name, LastUsedAt: r.LastUsedAt, ExpiresAt: r.ExpiresAt, Created: r.Created}
+	for _, scope := range strings.Fields(r.Scopes) {
+		t.Scopes = append(t.Scopes, model.APIScope(scope))
+	}
+	return t
+}
+
+// CreateAPIToken with the name and scopes, which expires at expiresAt, or never if it's nil, and records it
+// by the actor in the audit log. Returns the token and the secret token for the Authorization header,
+// which is only ever returned here, since only its SHA-256 hash is stored, like for session IDs.
+// The secret token has a random lookup part, to find the token by, before comparing the hashes in constant time.
+func (d *Database) CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time,
+	actor string) (model.APIToken, string, error) {
+	ctx = withQueryName(ctx, "CreateAPIToken")
+	lookup := make([]byte, 8)
+	if _, err := rand.Read(lookup); err != nil {
+		return model.APIToken{}, "", err
+	}
+	secret, err := createSecret()
+	if err != nil {
+		return model.APIToken{}, "", err
+	}
+	token := fmt.Sprintf("%v%x_%v", apiTokenPrefix, lookup, secret)
+
+	var names []string
+	for _, scope := range scopes {
+		names = append(names, string(scope))
+	}
+
+	var r apiTokenRow
+	err = d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into api_tokens (name, lookup, hash, scopes, expires_at)
+			values ($1, $2, $3, $4, $5)
+			returning id, name, scopes, last_used_at, expires_at, created`
+		if err := tx.GetContext(ctx, &r, query, name, fmt.Sprintf("%x", lookup), hashSessionToken(token),
+			strings.Join(names, " "), expiresAt); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "api_token.create", fmt.Sprintf("api_token/%v", r.ID),
+			map[string]string{"name": name, "scopes": r.Scopes})
+	})
+	if err != nil {
+		return model.APIToken{}, "", err
+	}
+	return r.token(), token, nil
+}
+
+// ListAPITokens, newest first.
+func (d *Database) ListAPITokens(ctx context.Context) ([]model.APIToken, error) {
+	ctx = withQueryName(ctx, "ListAPITokens")
+	var rows []apiTokenRow
+	query := `select id, name, scopes, last_used_at, expires_at, created from api_tokens order by created desc, id desc`
+	if err := d.DB.SelectContext(ctx, &rows, query); err != nil {
+		return nil, err
+	}
+	tokens := make([]model.APIToken, 0, len(rows))
+	for _, r := range rows {
+		tokens = append(tokens, r.token())
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken with the id, so it can't be used anymore, and records it by the actor in the audit log.
+// Returns ErrNotFound if there's no such token.
+func (d *Database) RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error) {
+	ctx = withQueryName(ctx, "RevokeAPIToken")
+	var r apiTokenRow
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `delete from api_tokens where id = $1 returning id, name, scopes, last_used_at, expires_at, created`
+		if err := tx.GetContext(ctx, &r, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no API token %v: %w", id, ErrNotFound)
+			}
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "api_token.revoke", fmt.Sprintf("api_token/%v", id),
+			map[string]string{"name": r.Name})
+	})
+	return r.token(), err
+}
+
+// AuthenticateAPIToken with the secret token from CreateAPIToken, recording its use.
+// Returns nil if there's no such token, or it expired.
+func (d *Database) AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error) {
+	ctx = withQueryName(ctx, "AuthenticateAPIToken")
+	lookup, _, ok := strings.Cut(strings.TrimPrefix(token, apiTokenPrefix), "_")
+	if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, nil
+	}
+
+	var r struct {
+		apiTokenRow
+		Hash string
+	}
+	query := `
+		select id, name, scopes, last_used_at, expires_at, created, hash
+		from api_tokens
+		where lookup = $1 and (expires_at is null or expires_at > now())`
+	if err := d.DB.GetContext(ctx, &r, query, lookup); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Hash), []byte(hashSessionToken(token))) != 1 {
+		return nil, nil
+	}
+
+	query = `
+		update api_tokens
+		set last_used_at = now()
+		where id = $1 and (last_used_at is null or last_used_at < now() - make_interval(secs => $2))`
+	if _, err := d.DB.ExecContext(ctx, query, r.ID, apiTokenLastUsedResolution.Seconds()); err != nil {
+		return nil, err
+	}
+	t := r.token()
+	return &t, nil
+}
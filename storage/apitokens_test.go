@@ -0,0 +1,104 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestDatabase_APITokens(t *testing.T) {
+	scopes := []model.APIScope{model.APIScopeReadSubscribers, model.APIScopeSendNewsletter}
+
+	t.Run("creates a token that authenticates with its scopes, storing only its hash", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		created, token, err := db.CreateAPIToken(context.Background(), "Reports", scopes, nil, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal("Reports", created.Name)
+		is.Equal(scopes, created.Scopes)
+		is.True(strings.HasPrefix(token, "canvas_"))
+
+		var stored int
+		err = db.DB.Get(&stored, `select count(*) from api_tokens where hash = $1 or lookup = $1`, token)
+		is.NoErr(err)
+		is.Equal(0, stored)
+
+		authenticated, err := db.AuthenticateAPIToken(context.Background(), token)
+		is.NoErr(err)
+		is.True(authenticated != nil)
+		is.Equal(created.ID, authenticated.ID)
+		is.Equal(scopes, authenticated.Scopes)
+
+		var action string
+		err = db.DB.Get(&action, `select action from audit_events where actor = 'admin'`)
+		is.NoErr(err)
+		is.Equal("api_token.create", action)
+	})
+
+	t.Run("records the last use", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, token, err := db.CreateAPIToken(context.Background(), "Reports", scopes, nil, storage.AuditActorAdmin)
+		is.NoErr(err)
+		tokens, err := db.ListAPITokens(context.Background())
+		is.NoErr(err)
+		is.Equal(1, len(tokens))
+		is.True(tokens[0].LastUsedAt == nil)
+
+		_, err = db.AuthenticateAPIToken(context.Background(), token)
+		is.NoErr(err)
+		tokens, err = db.ListAPITokens(context.Background())
+		is.NoErr(err)
+		is.True(tokens[0].LastUsedAt != nil)
+	})
+
+	t.Run("doesn't authenticate unknown, tampered, or expired tokens", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, token, err := db.CreateAPIToken(context.Background(), "Reports", scopes, nil, storage.AuditActorAdmin)
+		is.NoErr(err)
+		expiresAt := time.Now().Add(-time.Minute)
+		_, expired, err := db.CreateAPIToken(context.Background(), "Old", scopes, &expiresAt, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		for _, token := range []string{"", "canvas_", "canvas_abc_def", token[:len(token)-1] + "x", token + "x", expired} {
+			authenticated, err := db.AuthenticateAPIToken(context.Background(), token)
+			is.NoErr(err)
+			is.True(authenticated == nil)
+		}
+	})
+
+	t.Run("revokes a token, so it doesn't authenticate anymore", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		created, token, err := db.CreateAPIToken(context.Background(), "Reports", scopes, nil, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		revoked, err := db.RevokeAPIToken(context.Background(), created.ID, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal("Reports", revoked.Name)
+
+		authenticated, err := db.AuthenticateAPIToken(context.Background(), token)
+		is.NoErr(err)
+		is.True(authenticated == nil)
+
+		tokens, err := db.ListAPITokens(context.Background())
+		is.NoErr(err)
+		is.Equal(0, len(tokens))
+
+		_, err = db.RevokeAPIToken(context.Background(), created.ID, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
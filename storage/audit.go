@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditActorAdmin is the actor of audit events for actions in the admin pages.
+// There's only one admin password, so there's no telling admins apart.
+const AuditActorAdmin = "admin"
+
+// AuditActorEmailProvider is the actor of audit events for what the email provider reported, like bounces.
+const AuditActorEmailProvider = "email_provider"
+
+// AuditActorSubscriber is the actor of audit events for what subscribers did themselves, like signing up again.
+const AuditActorSubscriber = "subscriber"
+
+// AuditActorWebhookDelivery is the actor of audit events for what webhook deliveries did, like disabling an endpoint.
+const AuditActorWebhookDelivery = "webhook_delivery"
+
+// RecordAuditEvent of the action by the actor on the target, for actions that don't change anything,
+// like exporting. Changes record their audit event themselves, in the same transaction.
+func (d *Database) RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error {
+	ctx = withQueryName(ctx, "RecordAuditEvent")
+	return insertAuditEvent(ctx, d.DB, actor, action, target, details)
+}
+
+// insertAuditEvent with e, recording the action by the actor on the target, like "subscriber/1".
+// Pass the transaction of a change as e, so there's never a change without its audit event.
+func insertAuditEvent(ctx context.Context, e sqlx.ExecerContext, actor, action, target string, details map[string]string) error {
+	b, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	query := `insert into audit_events (actor, action, target, details) values ($1, $2, $3, $4)`
+	_, err = e.ExecContext(ctx, query, actor, action, target, string(b))
+	return err
+}
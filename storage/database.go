@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"time"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +25,7 @@ type Database struct {
 	connectionMaxLifetime time.Duration
 	connectionMaxIdleTime time.Duration
 	log                   *zap.Logger
+	metrics               *queryMetrics
 }
 
 // NewDatabaseOptions for NewDatabase.
@@ -36,15 +40,20 @@ type NewDatabaseOptions struct {
 	ConnectionMaxLifetime time.Duration
 	ConnectionMaxIdleTime time.Duration
 	Log                   *zap.Logger
+	Metrics               *prometheus.Registry
 }
 
 // NewDatabase with the given options.
-// If no logger is provided, logs are discarded.
+// If no logger is provided, logs are discarded. If no metrics registry is provided, metrics are not exposed.
+// The metrics are of the queries and of the connection pool.
 func NewDatabase(opts NewDatabaseOptions) *Database {
 	if opts.Log == nil {
 		opts.Log = zap.NewNop()
 	}
-	return &Database{
+	if opts.Metrics == nil {
+		opts.Metrics = prometheus.NewRegistry()
+	}
+	d := &Database{
 		host:                  opts.Host,
 		port:                  opts.Port,
 		user:                  opts.User,
@@ -55,21 +64,40 @@ func NewDatabase(opts NewDatabaseOptions) *Database {
 		connectionMaxLifetime: opts.ConnectionMaxLifetime,
 		connectionMaxIdleTime: opts.ConnectionMaxIdleTime,
 		log:                   opts.Log,
+		metrics:               newQueryMetrics(opts.Metrics),
 	}
+	opts.Metrics.MustRegister(newPoolCollector(d))
+	return d
 }
 
-// Connect to the database.
+// Connect to the database, opening the connection pool and checking that the database can be reached.
 func (d *Database) Connect() error {
-	d.log.Info("Connecting to database", zap.String("url", d.createDataSourceName(false)))
+	if err := d.Open(); err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var err error
-	d.DB, err = sqlx.ConnectContext(ctx, "pgx", d.createDataSourceName(true))
+	if err := d.DB.PingContext(ctx); err != nil {
+		_ = d.DB.Close()
+		d.DB = nil
+		return err
+	}
+	return nil
+}
+
+// Open the connection pool without connecting, so the database doesn't have to be reachable yet.
+// Queries fail until it is. Connect is Open and a ping.
+func (d *Database) Open() error {
+	d.log.Info("Connecting to database", zap.String("url", d.createDataSourceName(false)))
+
+	// The connector is wrapped for tracing and metrics, but it's still pgx to sqlx, for the placeholders.
+	connector, err := stdlib.GetDefaultDriver().(driver.DriverContext).OpenConnector(d.createDataSourceName(true))
 	if err != nil {
 		return err
 	}
+	d.DB = sqlx.NewDb(sql.OpenDB(tracedConnector{Connector: connector, metrics: d.metrics, name: d.name}), "pgx")
 
 	d.log.Debug("Setting connection pool options",
 		zap.Int("max open connections", d.maxOpenConnections),
@@ -84,6 +112,16 @@ func (d *Database) Connect() error {
 	return nil
 }
 
+// Close the connection pool, waiting for queries in progress to finish.
+func (d *Database) Close() error {
+	return d.DB.Close()
+}
+
+// Stats of the connection pool.
+func (d *Database) Stats() sql.DBStats {
+	return d.DB.Stats()
+}
+
 func (d *Database) createDataSourceName(withPassword bool) string {
 	password := d.password
 	if !withPassword {
@@ -94,7 +132,7 @@ func (d *Database) createDataSourceName(withPassword bool) string {
 
 // Ping the database.
 func (d *Database) Ping(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	ctx, cancel := context.WithTimeout(withQueryName(ctx, "Ping"), time.Second)
 	defer cancel()
 
 	if err := d.DB.PingContext(ctx); err != nil {
@@ -103,3 +141,23 @@ func (d *Database) Ping(ctx context.Context) error {
 	_, err := d.DB.ExecContext(ctx, `select 1`)
 	return err
 }
+
+// InTransaction runs callback in a transaction, committing if it returns nil and rolling back otherwise.
+func (d *Database) InTransaction(ctx context.Context, callback func(tx *sqlx.Tx) error) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+
+	if err := callback(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("error rolling back transaction after %v: %w", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/model"
+)
+
+// RecordEmailSend in the send log, for the subscriber with the email address, if there is one.
+func (d *Database) RecordEmailSend(ctx context.Context, s model.EmailSend) error {
+	ctx = withQueryName(ctx, "RecordEmailSend")
+	query := `
+		insert into email_sends (email, subscriber_id, type, newsletter_id, provider_message_id, status, error, test)
+		values ($1, (select id from newsletter_subscribers where email = $1), $2, $3, $4, $5, $6, $7)`
+	newsletterID := sql.NullInt64{Int64: s.NewsletterID, Valid: s.NewsletterID != 0}
+	_, err := d.DB.ExecContext(ctx, query, s.Email, s.Type, newsletterID, s.ProviderMessageID, s.Status, s.Error, s.Test)
+	return err
+}
+
+// HasSentNewsletter is true if the send log has a successful send of the newsletter to the email address.
+// Test sends don't count, and neither do sends from before the newsletter was last queued for sending,
+// so a forced send goes to everyone again.
+func (d *Database) HasSentNewsletter(ctx context.Context, newsletterID int64, email model.Email) (bool, error) {
+	ctx = withQueryName(ctx, "HasSentNewsletter")
+	var exists bool
+	query := `
+		select exists (
+			select 1 from email_sends
+			where newsletter_id = $1 and email = $2 and status = $3 and not test and created >= coalesce(
+				(select queued from newsletter_sends where newsletter_id = $1), '-infinity'))`
+	err := d.DB.GetContext(ctx, &exists, query, newsletterID, email, model.EmailSendStatusSent)
+	return exists, err
+}
+
+// emailSendColumns selected into model.EmailSend.
+const emailSendColumns = `
+	id, coalesce(subscriber_id, 0) as subscriberid, email, type, coalesce(newsletter_id, 0) as newsletterid,
+	provider_message_id as providermessageid, status, error, test, delivery, created, updated`
+
+// ListEmailSendsOptions for ListEmailSends.
+type ListEmailSendsOptions struct {
+	Email model.Email
+	Limit int
+}
+
+// ListEmailSends to the email address in the send log, newest first, including test sends
+// and bounces and complaints that couldn't be matched to a send.
+func (d *Database) ListEmailSends(ctx context.Context, opts ListEmailSendsOptions) ([]model.EmailSend, error) {
+	ctx = withQueryName(ctx, "ListEmailSends")
+	var sends []model.EmailSend
+	query := `select ` + emailSendColumns + `
+		from email_sends
+		where email = $1
+		order by created desc, id desc
+		limit $2`
+	err := d.DB.SelectContext(ctx, &sends, query, opts.Email, opts.Limit)
+	return sends, err
+}
+
+// RecordDelivery of the email with the provider message ID to the address, as reported by the email provider.
+// It doesn't replace a bounce or complaint reported before it, since notifications can come in any order.
+// Deliveries of emails that aren't in the send log are ignored.
+func (d *Database) RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error {
+	ctx = withQueryName(ctx, "RecordDelivery")
+	_, err := recordEmailDelivery(ctx, d.DB, email, providerMessageID, model.EmailDeliveryDelivered, "")
+	return err
+}
+
+// deliveryRank of the SQL expression of a delivery, so later notifications don't replace worse ones.
+func deliveryRank(expr string) string {
+	return `array_position(array['', 'delivered', 'bounced', 'complained'], ` + expr + `)`
+}
+
+// recordEmailDelivery on the sends in the send log with the provider message ID to the address, with e,
+// and an error describing it if not empty. A complaint replaces a bounce, and both replace a delivery,
+// but not the other way around. Returns whether any send matched.
+func recordEmailDelivery(ctx context.Context, e sqlx.ExecerContext, email model.Email, providerMessageID string,
+	delivery model.EmailDelivery, description string) (bool, error) {
+	if providerMessageID == "" {
+		return false, nil
+	}
+	worse := deliveryRank("delivery") + " < " + deliveryRank("$3::text")
+	query := `
+		update email_sends
+		set delivery = case when ` + worse + ` then $3 else delivery end,
+			error = case when $4 <> '' and ` + worse + ` then $4 else error end,
+			updated = now()
+		where provider_message_id = $1 and email = $2 and type not in ('bounce', 'complaint')`
+	res, err := e.ExecContext(ctx, query, providerMessageID, email, delivery, description)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteOldEmailSends from the send log created before the time, and return how many were deleted.
+func (d *Database) DeleteOldEmailSends(ctx context.Context, before time.Time) (int64, error) {
+	ctx = withQueryName(ctx, "DeleteOldEmailSends")
+	res, err := d.DB.ExecContext(ctx, `delete from email_sends where created < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,211 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/model"
+	"canvas/storage"
+)
+
+func TestDatabase_HasSentNewsletter(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("is true after a successful send, but not after failed or test sends", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue", "Body")
+		is.NoErr(err)
+
+		sends := []model.EmailSend{
+			{Email: "failed@example.com", Type: "newsletter_issue_email", NewsletterID: n.ID, Status: model.EmailSendStatusFailed},
+			{Email: "test@example.com", Type: "newsletter_issue_test_email", NewsletterID: n.ID, Status: model.EmailSendStatusSent, Test: true},
+			{Email: "sent@example.com", Type: "newsletter_issue_email", NewsletterID: n.ID, Status: model.EmailSendStatusSent},
+		}
+		for _, s := range sends {
+			is.NoErr(db.RecordEmailSend(context.Background(), s))
+		}
+
+		for _, test := range []struct {
+			email model.Email
+			sent  bool
+		}{
+			{"failed@example.com", false},
+			{"test@example.com", false},
+			{"sent@example.com", true},
+			{"other@example.com", false},
+		} {
+			sent, err := db.HasSentNewsletter(context.Background(), n.ID, test.email)
+			is.NoErr(err)
+			is.Equal(test.sent, sent)
+		}
+	})
+}
+
+func TestDatabase_ListEmailSends(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("lists the sends to the address newest first, with the subscriber", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+
+		sends := []model.EmailSend{
+			{Email: "me@example.com", Type: "confirmation_email", ProviderMessageID: "a", Status: model.EmailSendStatusSent},
+			{Email: "you@example.com", Type: "confirmation_email", ProviderMessageID: "b", Status: model.EmailSendStatusSent},
+			{Email: "me@example.com", Type: "welcome_email", Status: model.EmailSendStatusFailed, Error: "oh no"},
+		}
+		for _, s := range sends {
+			is.NoErr(db.RecordEmailSend(context.Background(), s))
+		}
+
+		listed, err := db.ListEmailSends(context.Background(), storage.ListEmailSendsOptions{Email: "me@example.com", Limit: 10})
+		is.NoErr(err)
+		is.Equal(2, len(listed))
+		is.Equal("welcome_email", listed[0].Type)
+		is.Equal("oh no", listed[0].Error)
+		is.Equal("confirmation_email", listed[1].Type)
+		is.Equal("a", listed[1].ProviderMessageID)
+		is.Equal(subscribers[0].ID, listed[1].SubscriberID)
+		is.Equal(model.EmailDelivery(""), listed[1].Delivery)
+
+		listed, err = db.ListEmailSends(context.Background(), storage.ListEmailSendsOptions{Email: "you@example.com", Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(listed))
+		is.Equal(int64(0), listed[0].SubscriberID)
+	})
+}
+
+func TestDatabase_RecordDelivery(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	delivery := func(is *is.I, db *storage.Database, email model.Email) model.EmailSend {
+		sends, err := db.ListEmailSends(context.Background(), storage.ListEmailSendsOptions{Email: email, Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(sends))
+		return sends[0]
+	}
+
+	t.Run("records the delivery on the send with the message ID to the address", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, s := range []model.EmailSend{
+			{Email: "me@example.com", Type: "confirmation_email", ProviderMessageID: "abc", Status: model.EmailSendStatusSent},
+			{Email: "you@example.com", Type: "confirmation_email", ProviderMessageID: "abc", Status: model.EmailSendStatusSent},
+		} {
+			is.NoErr(db.RecordEmailSend(context.Background(), s))
+		}
+
+		err := db.RecordDelivery(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+
+		me := delivery(is, db, "me@example.com")
+		is.Equal(model.EmailDeliveryDelivered, me.Delivery)
+		is.Equal(model.EmailSendStatusSent, me.Status)
+		is.True(!me.Updated.Before(me.Created))
+		is.Equal(model.EmailDelivery(""), delivery(is, db, "you@example.com").Delivery)
+	})
+
+	t.Run("ignores deliveries of emails not in the send log", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.RecordDelivery(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+
+		sends, err := db.ListEmailSends(context.Background(), storage.ListEmailSendsOptions{Email: "me@example.com", Limit: 10})
+		is.NoErr(err)
+		is.Equal(0, len(sends))
+	})
+
+	t.Run("correlates bounces and complaints, which replace a delivery in any order, but not the other way around", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		is.NoErr(db.RecordEmailSend(context.Background(), model.EmailSend{
+			Email: "me@example.com", Type: "confirmation_email", ProviderMessageID: "abc", Status: model.EmailSendStatusSent}))
+
+		policy := storage.BouncePolicy{TransientThreshold: 3, TransientWindow: time.Hour}
+		_, err = db.RecordBounce(context.Background(), model.Bounce{
+			Email: "me@example.com", Type: model.BounceTypeTransient, ProviderMessageID: "abc"}, policy)
+		is.NoErr(err)
+		send := delivery(is, db, "me@example.com")
+		is.Equal(model.EmailDeliveryBounced, send.Delivery)
+		is.Equal("transient bounce", send.Error)
+
+		// A delivery reported after the bounce doesn't replace it.
+		is.NoErr(db.RecordDelivery(context.Background(), "me@example.com", "abc"))
+		is.Equal(model.EmailDeliveryBounced, delivery(is, db, "me@example.com").Delivery)
+
+		is.NoErr(db.RecordComplaint(context.Background(), "me@example.com", "abc"))
+		is.Equal(model.EmailDeliveryComplained, delivery(is, db, "me@example.com").Delivery)
+
+		_, err = db.RecordBounce(context.Background(), model.Bounce{
+			Email: "me@example.com", Type: model.BounceTypePermanent, ProviderMessageID: "abc"}, policy)
+		is.NoErr(err)
+		send = delivery(is, db, "me@example.com")
+		is.Equal(model.EmailDeliveryComplained, send.Delivery)
+		is.Equal("transient bounce", send.Error)
+	})
+
+	t.Run("records bounces of unknown message IDs as sends of their own", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.RecordBounce(context.Background(), model.Bounce{
+			Email: "me@example.com", Type: model.BounceTypePermanent, ProviderMessageID: "abc"},
+			storage.BouncePolicy{TransientThreshold: 3, TransientWindow: time.Hour})
+		is.NoErr(err)
+
+		send := delivery(is, db, "me@example.com")
+		is.Equal("bounce", send.Type)
+		is.Equal(model.EmailSendStatusBounced, send.Status)
+		is.Equal("abc", send.ProviderMessageID)
+	})
+}
+
+func TestDatabase_DeleteOldEmailSends(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("deletes only the sends created before the time", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, email := range []model.Email{"old@example.com", "new@example.com"} {
+			is.NoErr(db.RecordEmailSend(context.Background(), model.EmailSend{
+				Email: email, Type: "confirmation_email", Status: model.EmailSendStatusSent}))
+		}
+		_, err := db.DB.Exec(`update email_sends set created = now() - interval '200 days' where email = 'old@example.com'`)
+		is.NoErr(err)
+
+		n, err := db.DeleteOldEmailSends(context.Background(), time.Now().Add(-180*24*time.Hour))
+		is.NoErr(err)
+		is.Equal(int64(1), n)
+
+		var emails []string
+		err = db.DB.Select(&emails, `select email from email_sends`)
+		is.NoErr(err)
+		is.Equal([]string{"new@example.com"}, emails)
+	})
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"canvas/apperr"
+)
+
+// ErrNotFound is returned by getters when there's no such thing. It's apperr.NotFound.
+var ErrNotFound = apperr.NotFound
+
+// ErrConflict is returned when changing something that doesn't exist anymore, or that changed since it was read.
+// It's apperr.Conflict.
+var ErrConflict = apperr.Conflict
+
+// pgErrorKinds for the SQLSTATE codes of Postgres errors, or their class of the first two characters,
+// for the errors the app can act on. The codes are listed in the PostgreSQL documentation, in appendix A.
+var pgErrorKinds = map[string]error{
+	"23505": apperr.Conflict, // unique_violation
+	"23P01": apperr.Conflict, // exclusion_violation
+
+	// Retrying the transaction is how serialization failures and deadlocks are meant to be handled.
+	"40001": apperr.Unavailable, // serialization_failure
+	"40P01": apperr.Unavailable, // deadlock_detected
+	"55P03": apperr.Unavailable, // lock_not_available
+	"57014": apperr.Unavailable, // query_canceled, like by the statement timeout
+	"57P01": apperr.Unavailable, // admin_shutdown
+	"57P02": apperr.Unavailable, // crash_shutdown
+	"57P03": apperr.Unavailable, // cannot_connect_now
+	"08":    apperr.Unavailable, // connection_exception
+	"53":    apperr.Unavailable, // insufficient_resources, like too_many_connections
+
+	"23502": apperr.Invalid, // not_null_violation
+	"23503": apperr.Invalid, // foreign_key_violation
+	"23514": apperr.Invalid, // check_violation
+	"22":    apperr.Invalid, // data_exception, like invalid_text_representation or string_data_right_truncation
+
+	"42501": apperr.Forbidden, // insufficient_privilege
+}
+
+// classifyError from the database driver as its apperr kind, so callers can tell a duplicate from a database
+// that's down without knowing about Postgres. Postgres errors are classified by their SQLSTATE code,
+// and network errors, like a refused connection, are apperr.Unavailable.
+// Errors of ctx being done, and errors that database/sql itself acts on, like driver.ErrBadConn, are returned as they are.
+func classifyError(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if kind := apperr.KindOf(err); kind != nil {
+		return err
+	}
+
+	// Both pgx and lib/pq errors have the SQLSTATE code.
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		code := pgErr.SQLState()
+		if kind, ok := pgErrorKinds[code]; ok {
+			return apperr.Wrap(kind, err)
+		}
+		if len(code) == 5 {
+			if kind, ok := pgErrorKinds[code[:2]]; ok {
+				return apperr.Wrap(kind, err)
+			}
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return apperr.Wrap(apperr.Unavailable, err)
+	}
+	return err
+}
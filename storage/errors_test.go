@@ -0,0 +1,78 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/storage"
+)
+
+func TestClassifyError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"unique_violation is a conflict", &pgconn.PgError{Code: "23505"}, apperr.Conflict},
+		{"exclusion_violation is a conflict", &pgconn.PgError{Code: "23P01"}, apperr.Conflict},
+		{"serialization_failure is unavailable", &pgconn.PgError{Code: "40001"}, apperr.Unavailable},
+		{"deadlock_detected is unavailable", &pgconn.PgError{Code: "40P01"}, apperr.Unavailable},
+		{"query_canceled is unavailable", &pgconn.PgError{Code: "57014"}, apperr.Unavailable},
+		{"cannot_connect_now is unavailable", &pgconn.PgError{Code: "57P03"}, apperr.Unavailable},
+		{"too_many_connections is unavailable by its class", &pgconn.PgError{Code: "53300"}, apperr.Unavailable},
+		{"connection_failure is unavailable by its class", &pgconn.PgError{Code: "08006"}, apperr.Unavailable},
+		{"not_null_violation is invalid", &pgconn.PgError{Code: "23502"}, apperr.Invalid},
+		{"foreign_key_violation is invalid", &pgconn.PgError{Code: "23503"}, apperr.Invalid},
+		{"check_violation is invalid", &pgconn.PgError{Code: "23514"}, apperr.Invalid},
+		{"invalid_text_representation is invalid by its class", &pgconn.PgError{Code: "22P02"}, apperr.Invalid},
+		{"insufficient_privilege is forbidden", &pgconn.PgError{Code: "42501"}, apperr.Forbidden},
+		{"syntax_error has no kind", &pgconn.PgError{Code: "42601"}, nil},
+		{"a refused connection is unavailable", fmt.Errorf("failed to connect: %w", refused), apperr.Unavailable},
+		{"a dropped connection is unavailable", io.ErrUnexpectedEOF, apperr.Unavailable},
+		{"a passed deadline stays as it is", context.DeadlineExceeded, nil},
+		{"a bad connection stays as it is, for database/sql to retry", driver.ErrBadConn, nil},
+		{"an error without a kind stays as it is", errors.New("oh no"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			err := storage.ClassifyError(test.err)
+			is.Equal(test.kind, apperr.KindOf(err))
+			is.True(errors.Is(err, test.err))
+			if test.kind == nil {
+				is.Equal(test.err, err)
+			}
+		})
+	}
+
+	t.Run("keeps the Postgres error for errors.As", func(t *testing.T) {
+		is := is.New(t)
+
+		err := fmt.Errorf("error signing up: %w", storage.ClassifyError(&pgconn.PgError{Code: "23505", ConstraintName: "email_unique"}))
+		is.True(errors.Is(err, storage.ErrConflict))
+		var pgErr *pgconn.PgError
+		is.True(errors.As(err, &pgErr))
+		is.Equal("email_unique", pgErr.ConstraintName)
+	})
+
+	t.Run("leaves nil and classified errors alone", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(storage.ClassifyError(nil))
+		err := apperr.Wrap(apperr.NotFound, &pgconn.PgError{Code: "23505"})
+		is.Equal(err, storage.ClassifyError(err))
+	})
+}
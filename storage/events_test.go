@@ -0,0 +1,143 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestDatabase_domainEvents(t *testing.T) {
+	t.Run("emits one signed up event for each signup, and none after a complaint", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "de", "https://partner.example.com")
+		is.NoErr(err)
+
+		events := outboxMessages(is, db, model.EventSubscriberSignedUp)
+		is.Equal(1, len(events))
+		is.Equal("me@example.com", events[0]["email"])
+		is.Equal("de", events[0]["locale"])
+		is.Equal("https://partner.example.com", events[0]["source"])
+		is.Equal(model.EventSubscriberSignedUp, events[0]["eventType"])
+		is.Equal("1", events[0]["eventVersion"])
+		is.True(events[0]["eventID"] != "")
+
+		err = db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "de", "")
+		is.True(err != nil)
+		is.Equal(1, len(outboxMessages(is, db, model.EventSubscriberSignedUp)))
+	})
+
+	t.Run("emits one confirmed event, and none for following the link again", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+
+		is.Equal(1, len(outboxMessages(is, db, model.EventSubscriberConfirmed)))
+	})
+
+	t.Run("emits one unsubscribed event, and none for unsubscribing again", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "you@example.com")
+		is.NoErr(err)
+
+		events := outboxMessages(is, db, model.EventSubscriberUnsubscribed)
+		is.Equal(1, len(events))
+		is.Equal("me@example.com", events[0]["email"])
+		is.Equal(storage.AuditActorSubscriber, events[0]["actor"])
+	})
+
+	t.Run("emits one suppressed event for each suppression, with the reason", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		for _, email := range []model.Email{"bounced@example.com", "suppressed@example.com", "complained@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+			is.NoErr(err)
+		}
+
+		policy := storage.BouncePolicy{TransientThreshold: 3, TransientWindow: time.Hour}
+		_, err := db.RecordBounce(context.Background(), model.Bounce{Email: "bounced@example.com", Type: model.BounceTypePermanent}, policy)
+		is.NoErr(err)
+		_, err = db.RecordBounce(context.Background(), model.Bounce{Email: "bounced@example.com", Type: model.BounceTypePermanent}, policy)
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "suppressed@example.com", model.SuppressionReasonBounced)
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "suppressed@example.com", model.SuppressionReasonBounced)
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), "complained@example.com", "abc")
+		is.NoErr(err)
+
+		events := outboxMessages(is, db, model.EventSubscriberSuppressed)
+		is.Equal(3, len(events))
+		reasons := map[string]string{}
+		for _, e := range events {
+			reasons[e["email"]] = e["reason"]
+		}
+		is.Equal(map[string]string{
+			"bounced@example.com":    "bounced",
+			"suppressed@example.com": "bounced",
+			"complained@example.com": "complained",
+		}, reasons)
+	})
+
+	t.Run("emits nothing when the transaction rolls back", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+
+		// Fail the audit event, which is inserted after the event is emitted, so the whole transaction rolls back.
+		_, err = db.DB.Exec(`
+			create function fail() returns trigger as $$ begin raise exception 'oh no'; end; $$ language plpgsql;
+			create trigger fail before insert on audit_events for each row execute procedure fail();`)
+		is.NoErr(err)
+
+		_, err = db.UnsubscribeSubscriber(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.True(err != nil)
+
+		is.Equal(0, len(outboxMessages(is, db, model.EventSubscriberUnsubscribed)))
+		subscribed, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(subscribed)
+	})
+}
+
+// outboxMessages with the given job, which is the type for domain events, oldest first.
+func outboxMessages(is *is.I, db *storage.Database, job string) []model.Message {
+	ms, err := db.GetOutboxMessages(context.Background(), 100)
+	is.NoErr(err)
+	var messages []model.Message
+	for _, m := range ms {
+		if m.Message["job"] == job {
+			messages = append(messages, m.Message)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,7 @@
+package storage
+
+// ClassifyError is classifyError, for testing the classification of driver errors without a database.
+var ClassifyError = classifyError
+
+// SegmentCondition is segmentCondition, for testing the SQL of segments without a database.
+var SegmentCondition = segmentCondition
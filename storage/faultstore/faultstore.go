@@ -0,0 +1,136 @@
+// Package faultstore wraps a database with faults programmed by tests, like errors, latency, or failing every nth call,
+// to test how the handlers and the jobs behave when the database misbehaves.
+//
+// A Store can only be created with a testing.TB, so it can't end up in the production wiring.
+//
+// Usage:
+//
+//	db := faultstore.New(t, servertest.NewStore(nil))
+//	db.Set("SignupForNewsletter", faultstore.Fault{Err: faultstore.ErrUnavailable})
+//	h, _ := servertest.New(t, server.Options{Database: db})
+//	…
+package faultstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"canvas/apperr"
+)
+
+// AnyMethod is the method name for a fault of every method without a fault of its own.
+const AnyMethod = "*"
+
+// Errors like the ones storage.Database returns when the database misbehaves, for Fault.Err.
+var (
+	// ErrUnavailable is like the error of a refused connection.
+	ErrUnavailable = apperr.Wrap(apperr.Unavailable, errors.New("faultstore: connection refused"))
+	// ErrTimeout is like the error of a query cancelled by the statement timeout.
+	ErrTimeout = apperr.Wrap(apperr.Unavailable, errors.New("faultstore: canceling statement due to statement timeout"))
+)
+
+// Fault of a method.
+type Fault struct {
+	// Err returned instead of calling the wrapped database. Without it, the call goes through, after the latency.
+	Err error
+	// Latency before the call fails or goes through. If the context is done before, its error is returned.
+	Latency time.Duration
+	// Every nth call fails with Err, like the 3rd, 6th, and so on for 3, counting from when the fault was set.
+	// Zero or one fails every call.
+	Every int
+}
+
+// Store wraps a Database with the faults set with Set, and is a Database itself.
+// It's safe for concurrent use.
+type Store struct {
+	db     Database
+	lock   sync.Mutex
+	faults map[string]Fault
+	// counts of the calls of the methods since their fault was set, for Fault.Every.
+	counts map[string]int
+	// calls of the methods in all.
+	calls map[string]int
+}
+
+// New Store for t, wrapping db, without faults.
+func New(t testing.TB, db Database) *Store {
+	t.Helper()
+
+	if db == nil {
+		t.Fatal("faultstore: database is nil")
+	}
+	return &Store{
+		db:     db,
+		faults: map[string]Fault{},
+		counts: map[string]int{},
+		calls:  map[string]int{},
+	}
+}
+
+// Set the fault of the method, like "SignupForNewsletter", or of AnyMethod.
+func (s *Store) Set(method string, f Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults[method] = f
+	s.counts[method] = 0
+}
+
+// Clear the fault of the method, or of AnyMethod, so calls go through again.
+func (s *Store) Clear(method string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.faults, method)
+	delete(s.counts, method)
+}
+
+// Reset all faults and call counts.
+func (s *Store) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = map[string]Fault{}
+	s.counts = map[string]int{}
+	s.calls = map[string]int{}
+}
+
+// Calls of the method, whether they failed or not.
+func (s *Store) Calls(method string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls[method]
+}
+
+// inject the fault of the method into a call with ctx, returning the error the call fails with, if any.
+func (s *Store) inject(ctx context.Context, method string) error {
+	s.lock.Lock()
+	s.calls[method]++
+	key := method
+	f, ok := s.faults[key]
+	if !ok {
+		key = AnyMethod
+		f, ok = s.faults[key]
+	}
+	if !ok {
+		s.lock.Unlock()
+		return nil
+	}
+	s.counts[key]++
+	fail := f.Err != nil && (f.Every <= 1 || s.counts[key]%f.Every == 0)
+	s.lock.Unlock()
+
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if fail {
+		return f.Err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package faultstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/server/servertest"
+	"canvas/storage"
+	"canvas/storage/faultstore"
+)
+
+// The faults can be injected into the real database, and into the fake one of the server tests.
+var (
+	_ faultstore.Database = (*storage.Database)(nil)
+	_ faultstore.Database = (*servertest.Store)(nil)
+	_ faultstore.Database = (*faultstore.Store)(nil)
+)
+
+func TestStore(t *testing.T) {
+	t.Run("calls the wrapped database without faults", func(t *testing.T) {
+		is := is.New(t)
+
+		store := servertest.NewStore(nil)
+		db := faultstore.New(t, store)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, ok := store.Subscriber("me@example.com")
+		is.True(ok)
+		is.Equal(1, db.Calls("SignupForNewsletter"))
+	})
+
+	t.Run("fails calls of the method with the error until it's cleared", func(t *testing.T) {
+		is := is.New(t)
+
+		db := faultstore.New(t, servertest.NewStore(nil))
+		db.Set("Ping", faultstore.Fault{Err: faultstore.ErrUnavailable})
+
+		err := db.Ping(context.Background())
+		is.True(errors.Is(err, apperr.Unavailable))
+		_, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+
+		db.Clear("Ping")
+		is.NoErr(db.Ping(context.Background()))
+		is.Equal(2, db.Calls("Ping"))
+	})
+
+	t.Run("fails every nth call", func(t *testing.T) {
+		is := is.New(t)
+
+		db := faultstore.New(t, servertest.NewStore(nil))
+		db.Set("Ping", faultstore.Fault{Err: faultstore.ErrTimeout, Every: 3})
+
+		var failed []int
+		for i := 1; i <= 7; i++ {
+			if err := db.Ping(context.Background()); err != nil {
+				is.True(errors.Is(err, faultstore.ErrTimeout))
+				failed = append(failed, i)
+			}
+		}
+		is.Equal([]int{3, 6}, failed)
+	})
+
+	t.Run("fails every method without a fault of its own with the fault of any method", func(t *testing.T) {
+		is := is.New(t)
+
+		db := faultstore.New(t, servertest.NewStore(nil))
+		db.Set(faultstore.AnyMethod, faultstore.Fault{Err: faultstore.ErrUnavailable})
+		db.Set("Ping", faultstore.Fault{})
+
+		is.NoErr(db.Ping(context.Background()))
+		_, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.True(errors.Is(err, apperr.Unavailable))
+
+		db.Reset()
+		_, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.Equal(1, db.Calls("IsSubscribed"))
+	})
+
+	t.Run("adds latency, and returns the error of the context if it's done before", func(t *testing.T) {
+		is := is.New(t)
+
+		db := faultstore.New(t, servertest.NewStore(nil))
+		db.Set("Ping", faultstore.Fault{Latency: 20 * time.Millisecond})
+
+		start := time.Now()
+		is.NoErr(db.Ping(context.Background()))
+		is.True(time.Since(start) >= 20*time.Millisecond)
+
+		db.Set("Ping", faultstore.Fault{Latency: time.Minute})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := db.Ping(ctx)
+		is.True(errors.Is(err, context.DeadlineExceeded))
+	})
+}
@@ -0,0 +1,480 @@
+package faultstore
+
+import (
+	"context"
+	"time"
+
+	"canvas/model"
+	"canvas/storage"
+)
+
+// Database is what the routes and the jobs need from the database, the same as the store interface of the server,
+// which is a *storage.Database, or a fake in tests.
+type Database interface {
+	Ping(ctx context.Context) error
+
+	// Signups and subscriptions.
+	SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error)
+	ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error)
+	ResendConfirmation(ctx context.Context, email model.Email) (bool, error)
+	IsSubscribed(ctx context.Context, email model.Email) (bool, error)
+	Unsubscribe(ctx context.Context, email model.Email) error
+	Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+
+	// Newsletter issues.
+	ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error)
+	GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error)
+	GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error)
+	GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error)
+	QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error
+	ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error
+	UnscheduleNewsletter(ctx context.Context, id int64) error
+	RecordEmailSend(ctx context.Context, s model.EmailSend) error
+
+	// Admin pages.
+	SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error)
+	SendStats(ctx context.Context, days int) (model.SendStats, error)
+	ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error)
+	SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error)
+	GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error)
+	ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error)
+	DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error)
+	CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error)
+	CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error)
+	ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error)
+	ListTags(ctx context.Context) ([]model.TagCount, error)
+	AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+	RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error
+	ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error
+	RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error
+	AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error
+	ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error)
+	RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error)
+
+	// Imports of subscribers from uploaded files.
+	CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+		next func() (model.SubscriberImportRow, error)) (int64, error)
+	GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error)
+	ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error)
+	ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error)
+	ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error
+	QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error
+	DeleteSubscriberImport(ctx context.Context, id int64, actor string) error
+
+	// API tokens for the admin API.
+	CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time, actor string) (model.APIToken, string, error)
+	ListAPITokens(ctx context.Context) ([]model.APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error)
+	AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error)
+
+	// Webhook endpoints, and the log of their deliveries.
+	CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error)
+	GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error
+	ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error)
+
+	// Reports from the mail provider.
+	RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error)
+	RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error
+	RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error
+}
+
+// The methods of Store inject the fault of their name, or of AnyMethod, before calling the wrapped database.
+
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.inject(ctx, "Ping"); err != nil {
+		return err
+	}
+	return s.db.Ping(ctx)
+}
+
+func (s *Store) SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error) {
+	if err := s.inject(ctx, "SignupForNewsletter"); err != nil {
+		return "", err
+	}
+	return s.db.SignupForNewsletter(ctx, email, locale, source)
+}
+
+func (s *Store) ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error) {
+	if err := s.inject(ctx, "ConfirmNewsletterSignup"); err != nil {
+		return "", err
+	}
+	return s.db.ConfirmNewsletterSignup(ctx, token)
+}
+
+func (s *Store) ResendConfirmation(ctx context.Context, email model.Email) (bool, error) {
+	if err := s.inject(ctx, "ResendConfirmation"); err != nil {
+		return false, err
+	}
+	return s.db.ResendConfirmation(ctx, email)
+}
+
+func (s *Store) IsSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	if err := s.inject(ctx, "IsSubscribed"); err != nil {
+		return false, err
+	}
+	return s.db.IsSubscribed(ctx, email)
+}
+
+func (s *Store) Unsubscribe(ctx context.Context, email model.Email) error {
+	if err := s.inject(ctx, "Unsubscribe"); err != nil {
+		return err
+	}
+	return s.db.Unsubscribe(ctx, email)
+}
+
+func (s *Store) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	if err := s.inject(ctx, "Throttle"); err != nil {
+		return false, err
+	}
+	return s.db.Throttle(ctx, key, limit, window)
+}
+
+func (s *Store) ListPublishedNewsletters(ctx context.Context, opts storage.ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	if err := s.inject(ctx, "ListPublishedNewsletters"); err != nil {
+		return nil, err
+	}
+	return s.db.ListPublishedNewsletters(ctx, opts)
+}
+
+func (s *Store) GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error) {
+	if err := s.inject(ctx, "GetPublishedNewsletter"); err != nil {
+		return nil, err
+	}
+	return s.db.GetPublishedNewsletter(ctx, slug)
+}
+
+func (s *Store) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	if err := s.inject(ctx, "GetNewsletter"); err != nil {
+		return nil, err
+	}
+	return s.db.GetNewsletter(ctx, id)
+}
+
+func (s *Store) GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error) {
+	if err := s.inject(ctx, "GetNewsletterSend"); err != nil {
+		return nil, err
+	}
+	return s.db.GetNewsletterSend(ctx, newsletterID)
+}
+
+func (s *Store) QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error {
+	if err := s.inject(ctx, "QueueNewsletterSend"); err != nil {
+		return err
+	}
+	return s.db.QueueNewsletterSend(ctx, newsletterID, force, segment)
+}
+
+func (s *Store) ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error {
+	if err := s.inject(ctx, "ScheduleNewsletter"); err != nil {
+		return err
+	}
+	return s.db.ScheduleNewsletter(ctx, id, at, segment)
+}
+
+func (s *Store) UnscheduleNewsletter(ctx context.Context, id int64) error {
+	if err := s.inject(ctx, "UnscheduleNewsletter"); err != nil {
+		return err
+	}
+	return s.db.UnscheduleNewsletter(ctx, id)
+}
+
+func (s *Store) RecordEmailSend(ctx context.Context, send model.EmailSend) error {
+	if err := s.inject(ctx, "RecordEmailSend"); err != nil {
+		return err
+	}
+	return s.db.RecordEmailSend(ctx, send)
+}
+
+func (s *Store) SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error) {
+	if err := s.inject(ctx, "SubscriberStats"); err != nil {
+		return model.SubscriberStats{}, err
+	}
+	return s.db.SubscriberStats(ctx, days)
+}
+
+func (s *Store) SendStats(ctx context.Context, days int) (model.SendStats, error) {
+	if err := s.inject(ctx, "SendStats"); err != nil {
+		return model.SendStats{}, err
+	}
+	return s.db.SendStats(ctx, days)
+}
+
+func (s *Store) ListSubscribers(ctx context.Context, opts storage.ListSubscribersOptions) ([]model.Subscriber, error) {
+	if err := s.inject(ctx, "ListSubscribers"); err != nil {
+		return nil, err
+	}
+	return s.db.ListSubscribers(ctx, opts)
+}
+
+func (s *Store) SearchSubscribers(ctx context.Context, opts storage.SearchSubscribersOptions) ([]model.Subscriber, error) {
+	if err := s.inject(ctx, "SearchSubscribers"); err != nil {
+		return nil, err
+	}
+	return s.db.SearchSubscribers(ctx, opts)
+}
+
+func (s *Store) GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error) {
+	if err := s.inject(ctx, "GetSubscriber"); err != nil {
+		return nil, err
+	}
+	return s.db.GetSubscriber(ctx, id)
+}
+
+func (s *Store) ListEmailSends(ctx context.Context, opts storage.ListEmailSendsOptions) ([]model.EmailSend, error) {
+	if err := s.inject(ctx, "ListEmailSends"); err != nil {
+		return nil, err
+	}
+	return s.db.ListEmailSends(ctx, opts)
+}
+
+func (s *Store) DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	if err := s.inject(ctx, "DeleteSubscriber"); err != nil {
+		return "", err
+	}
+	return s.db.DeleteSubscriber(ctx, id, version, actor)
+}
+
+func (s *Store) ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	if err := s.inject(ctx, "ConfirmSubscriber"); err != nil {
+		return "", err
+	}
+	return s.db.ConfirmSubscriber(ctx, id, version, actor)
+}
+
+func (s *Store) UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	if err := s.inject(ctx, "UnsubscribeSubscriber"); err != nil {
+		return "", err
+	}
+	return s.db.UnsubscribeSubscriber(ctx, id, version, actor)
+}
+
+func (s *Store) ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	if err := s.inject(ctx, "ClearComplaint"); err != nil {
+		return "", err
+	}
+	return s.db.ClearComplaint(ctx, id, version, actor)
+}
+
+func (s *Store) CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error) {
+	if err := s.inject(ctx, "CountSubscribers"); err != nil {
+		return 0, err
+	}
+	return s.db.CountSubscribers(ctx, status)
+}
+
+func (s *Store) CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error) {
+	if err := s.inject(ctx, "CountSubscribersInSegment"); err != nil {
+		return 0, err
+	}
+	return s.db.CountSubscribersInSegment(ctx, segment, status)
+}
+
+func (s *Store) ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error) {
+	if err := s.inject(ctx, "ListSubscriberTags"); err != nil {
+		return nil, err
+	}
+	return s.db.ListSubscriberTags(ctx, id)
+}
+
+func (s *Store) ListTags(ctx context.Context) ([]model.TagCount, error) {
+	if err := s.inject(ctx, "ListTags"); err != nil {
+		return nil, err
+	}
+	return s.db.ListTags(ctx)
+}
+
+func (s *Store) AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	if err := s.inject(ctx, "AddSubscriberTag"); err != nil {
+		return err
+	}
+	return s.db.AddSubscriberTag(ctx, id, tag, actor)
+}
+
+func (s *Store) RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	if err := s.inject(ctx, "RemoveSubscriberTag"); err != nil {
+		return err
+	}
+	return s.db.RemoveSubscriberTag(ctx, id, tag, actor)
+}
+
+func (s *Store) ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error {
+	if err := s.inject(ctx, "ExportSubscribers"); err != nil {
+		return err
+	}
+	return s.db.ExportSubscribers(ctx, status, limit, f)
+}
+
+func (s *Store) RecordAuditEvent(ctx context.Context, actor, action, target string, details map[string]string) error {
+	if err := s.inject(ctx, "RecordAuditEvent"); err != nil {
+		return err
+	}
+	return s.db.RecordAuditEvent(ctx, actor, action, target, details)
+}
+
+func (s *Store) AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error {
+	if err := s.inject(ctx, "AddSuppression"); err != nil {
+		return err
+	}
+	return s.db.AddSuppression(ctx, email, reason, source)
+}
+
+func (s *Store) ListSuppressions(ctx context.Context, opts storage.ListSuppressionsOptions) ([]model.Suppression, error) {
+	if err := s.inject(ctx, "ListSuppressions"); err != nil {
+		return nil, err
+	}
+	return s.db.ListSuppressions(ctx, opts)
+}
+
+func (s *Store) RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error) {
+	if err := s.inject(ctx, "RemoveSuppression"); err != nil {
+		return model.Suppression{}, err
+	}
+	return s.db.RemoveSuppression(ctx, id, actor)
+}
+
+func (s *Store) CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+	next func() (model.SubscriberImportRow, error)) (int64, error) {
+	if err := s.inject(ctx, "CreateSubscriberImport"); err != nil {
+		return 0, err
+	}
+	return s.db.CreateSubscriberImport(ctx, filename, columns, next)
+}
+
+func (s *Store) GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error) {
+	if err := s.inject(ctx, "GetSubscriberImport"); err != nil {
+		return nil, err
+	}
+	return s.db.GetSubscriberImport(ctx, id)
+}
+
+func (s *Store) ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error) {
+	if err := s.inject(ctx, "ListSubscriberImports"); err != nil {
+		return nil, err
+	}
+	return s.db.ListSubscriberImports(ctx, limit)
+}
+
+func (s *Store) ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error) {
+	if err := s.inject(ctx, "ListSubscriberImportRows"); err != nil {
+		return nil, err
+	}
+	return s.db.ListSubscriberImportRows(ctx, id, limit)
+}
+
+func (s *Store) ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error {
+	if err := s.inject(ctx, "ExportSubscriberImportErrors"); err != nil {
+		return err
+	}
+	return s.db.ExportSubscriberImportErrors(ctx, id, f)
+}
+
+func (s *Store) QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error {
+	if err := s.inject(ctx, "QueueSubscriberImport"); err != nil {
+		return err
+	}
+	return s.db.QueueSubscriberImport(ctx, id, duplicates, actor)
+}
+
+func (s *Store) DeleteSubscriberImport(ctx context.Context, id int64, actor string) error {
+	if err := s.inject(ctx, "DeleteSubscriberImport"); err != nil {
+		return err
+	}
+	return s.db.DeleteSubscriberImport(ctx, id, actor)
+}
+
+func (s *Store) CreateAPIToken(ctx context.Context, name string, scopes []model.APIScope, expiresAt *time.Time, actor string) (model.APIToken, string, error) {
+	if err := s.inject(ctx, "CreateAPIToken"); err != nil {
+		return model.APIToken{}, "", err
+	}
+	return s.db.CreateAPIToken(ctx, name, scopes, expiresAt, actor)
+}
+
+func (s *Store) ListAPITokens(ctx context.Context) ([]model.APIToken, error) {
+	if err := s.inject(ctx, "ListAPITokens"); err != nil {
+		return nil, err
+	}
+	return s.db.ListAPITokens(ctx)
+}
+
+func (s *Store) RevokeAPIToken(ctx context.Context, id int64, actor string) (model.APIToken, error) {
+	if err := s.inject(ctx, "RevokeAPIToken"); err != nil {
+		return model.APIToken{}, err
+	}
+	return s.db.RevokeAPIToken(ctx, id, actor)
+}
+
+func (s *Store) AuthenticateAPIToken(ctx context.Context, token string) (*model.APIToken, error) {
+	if err := s.inject(ctx, "AuthenticateAPIToken"); err != nil {
+		return nil, err
+	}
+	return s.db.AuthenticateAPIToken(ctx, token)
+}
+
+func (s *Store) CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error) {
+	if err := s.inject(ctx, "CreateWebhookEndpoint"); err != nil {
+		return model.WebhookEndpoint{}, err
+	}
+	return s.db.CreateWebhookEndpoint(ctx, url, eventTypes, actor)
+}
+
+func (s *Store) ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	if err := s.inject(ctx, "ListWebhookEndpoints"); err != nil {
+		return nil, err
+	}
+	return s.db.ListWebhookEndpoints(ctx)
+}
+
+func (s *Store) GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error) {
+	if err := s.inject(ctx, "GetWebhookEndpoint"); err != nil {
+		return nil, err
+	}
+	return s.db.GetWebhookEndpoint(ctx, id)
+}
+
+func (s *Store) DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	if err := s.inject(ctx, "DeleteWebhookEndpoint"); err != nil {
+		return err
+	}
+	return s.db.DeleteWebhookEndpoint(ctx, id, actor)
+}
+
+func (s *Store) EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	if err := s.inject(ctx, "EnableWebhookEndpoint"); err != nil {
+		return err
+	}
+	return s.db.EnableWebhookEndpoint(ctx, id, actor)
+}
+
+func (s *Store) ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error) {
+	if err := s.inject(ctx, "ListWebhookDeliveries"); err != nil {
+		return nil, err
+	}
+	return s.db.ListWebhookDeliveries(ctx, endpointID, limit)
+}
+
+func (s *Store) RecordBounce(ctx context.Context, b model.Bounce, p storage.BouncePolicy) (bool, error) {
+	if err := s.inject(ctx, "RecordBounce"); err != nil {
+		return false, err
+	}
+	return s.db.RecordBounce(ctx, b, p)
+}
+
+func (s *Store) RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error {
+	if err := s.inject(ctx, "RecordComplaint"); err != nil {
+		return err
+	}
+	return s.db.RecordComplaint(ctx, email, providerMessageID)
+}
+
+func (s *Store) RecordDelivery(ctx context.Context, email model.Email, providerMessageID string) error {
+	if err := s.inject(ctx, "RecordDelivery"); err != nil {
+		return err
+	}
+	return s.db.RecordDelivery(ctx, email, providerMessageID)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthSnapshot of the database, from the latest check.
+type HealthSnapshot struct {
+	Healthy bool
+	Checked time.Time
+	Err     error
+}
+
+// HealthMonitor pings the database periodically and keeps a snapshot of the result,
+// so callers can check database health without pinging it themselves.
+type HealthMonitor struct {
+	db       pinger
+	interval time.Duration
+	log      *zap.Logger
+	mutex    sync.RWMutex
+	snapshot HealthSnapshot
+}
+
+// NewHealthMonitorOptions for NewHealthMonitor.
+type NewHealthMonitorOptions struct {
+	DB pinger
+	// Interval between pings. Defaults to 5 seconds.
+	Interval time.Duration
+	Log      *zap.Logger
+}
+
+// NewHealthMonitor with the given options. The database is assumed healthy until the first check.
+// If no logger is provided, logs are discarded.
+func NewHealthMonitor(opts NewHealthMonitorOptions) *HealthMonitor {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	return &HealthMonitor{
+		db:       opts.DB,
+		interval: opts.Interval,
+		log:      opts.Log,
+		snapshot: HealthSnapshot{Healthy: true},
+	}
+}
+
+// Start checking database health, blocking until ctx is cancelled.
+func (h *HealthMonitor) Start(ctx context.Context) {
+	for {
+		h.Check(ctx)
+
+		t := time.NewTimer(h.interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check database health now, updating the snapshot. Changes in health are logged.
+func (h *HealthMonitor) Check(ctx context.Context) {
+	err := h.db.Ping(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err != nil && h.snapshot.Healthy {
+		h.log.Warn("Database is unhealthy", zap.Error(err))
+	}
+	if err == nil && !h.snapshot.Healthy {
+		h.log.Info("Database is healthy again")
+	}
+	h.snapshot = HealthSnapshot{Healthy: err == nil, Checked: time.Now(), Err: err}
+}
+
+// Snapshot of database health from the latest check.
+func (h *HealthMonitor) Snapshot() HealthSnapshot {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.snapshot
+}
+
+// Healthy is true if the database was healthy at the latest check.
+func (h *HealthMonitor) Healthy() bool {
+	return h.Snapshot().Healthy
+}
@@ -0,0 +1,39 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/storage"
+)
+
+type pingerMock struct {
+	err error
+}
+
+func (p *pingerMock) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestHealthMonitor_Check(t *testing.T) {
+	t.Run("is healthy until a check fails, and healthy again after a check succeeds", func(t *testing.T) {
+		is := is.New(t)
+
+		p := &pingerMock{}
+		h := storage.NewHealthMonitor(storage.NewHealthMonitorOptions{DB: p})
+		is.True(h.Healthy())
+
+		p.err = errors.New("connection refused")
+		h.Check(context.Background())
+		is.True(!h.Healthy())
+		is.Equal(p.err, h.Snapshot().Err)
+		is.True(!h.Snapshot().Checked.IsZero())
+
+		p.err = nil
+		h.Check(context.Background())
+		is.True(h.Healthy())
+	})
+}
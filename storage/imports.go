@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/apperr"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// ErrImportInProgress is returned when queueing or deleting a subscriber import that's queued or importing.
+var ErrImportInProgress = errors.New("import in progress")
+
+// ErrAlreadyImported is returned when queueing a subscriber import that's completed.
+var ErrAlreadyImported = errors.New("already imported")
+
+// subscriberImportUploadBatchSize is how many rows of an uploaded file are inserted at a time.
+const subscriberImportUploadBatchSize = 1000
+
+// importRowJSON of a subscriber import row, for passing batches of rows to Postgres with json_to_recordset.
+type importRowJSON struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+	Tags   string `json:"tags"`
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+// importSubscriberJSON of a subscriber added by an import, with the token of their address, for json_to_recordset.
+type importSubscriberJSON struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+	Token  string `json:"token"`
+}
+
+// CreateSubscriberImport of the uploaded file with the filename, with the rows returned by next until it returns io.EOF,
+// and the columns of the file they're from. Rows with an Error fail right away, and aren't imported.
+// Rows are inserted in batches as they're returned, so the file doesn't have to be in memory,
+// and all in the same transaction, so an error from next leaves nothing behind and is returned.
+// The import is uploaded, and waits for QueueSubscriberImport. Returns its ID.
+func (d *Database) CreateSubscriberImport(ctx context.Context, filename string, columns model.SubscriberImportColumns,
+	next func() (model.SubscriberImportRow, error)) (int64, error) {
+	ctx = withQueryName(ctx, "CreateSubscriberImport")
+	var id int64
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into subscriber_imports (filename, email_column, locale_column, tags_column)
+			values ($1, $2, $3, $4)
+			returning id`
+		if err := tx.GetContext(ctx, &id, query, filename, columns.Email, columns.Locale, columns.Tags); err != nil {
+			return err
+		}
+
+		batch := make([]importRowJSON, 0, subscriberImportUploadBatchSize)
+		insert := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			b, err := json.Marshal(batch)
+			if err != nil {
+				return err
+			}
+			query := `
+				insert into subscriber_import_rows (import_id, line, email, locale, tags, result, error)
+				select $1, line, email, locale, tags, nullif(result, ''), error
+				from json_to_recordset($2::json) as r(line int, email text, locale text, tags text, result text, error text)`
+			if _, err := tx.ExecContext(ctx, query, id, string(b)); err != nil {
+				return err
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		for {
+			r, err := next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			row := importRowJSON{Line: r.Line, Email: r.Email.String(), Locale: r.Locale, Tags: joinTags(r.Tags), Error: r.Error}
+			if r.Error != "" {
+				row.Result = string(model.SubscriberImportResultFailed)
+			}
+			batch = append(batch, row)
+			if len(batch) == subscriberImportUploadBatchSize {
+				if err := insert(); err != nil {
+					return err
+				}
+			}
+		}
+		return insert()
+	})
+	return id, err
+}
+
+func joinTags(tags []model.Tag) string {
+	s := make([]string, len(tags))
+	for i, t := range tags {
+		s[i] = t.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func splitTags(s string) []model.Tag {
+	var tags []model.Tag
+	for _, t := range strings.Fields(s) {
+		tags = append(tags, model.Tag(t))
+	}
+	return tags
+}
+
+// subscriberImportRow of the subscriber_imports table, with the counts of the results of its rows.
+type subscriberImportRow struct {
+	ID           int64
+	Filename     string
+	State        model.SubscriberImportState
+	Duplicates   model.SubscriberImportDuplicates
+	EmailColumn  string `db:"email_column"`
+	LocaleColumn string `db:"locale_column"`
+	TagsColumn   string `db:"tags_column"`
+	Error        string
+	Created      time.Time
+	Total        int
+	Added        int
+	Updated      int
+	Skipped      int
+	Failed       int
+}
+
+func (r subscriberImportRow) subscriberImport() model.SubscriberImport {
+	return model.SubscriberImport{ID: r.ID, Filename: r.Filename, State: r.State, Duplicates: r.Duplicates,
+		Columns: model.SubscriberImportColumns{Email: r.EmailColumn, Locale: r.LocaleColumn, Tags: r.TagsColumn},
+		Total:   r.Total, Added: r.Added, Updated: r.Updated, Skipped: r.Skipped, Failed: r.Failed, Error: r.Error,
+		Created: r.Created}
+}
+
+const subscriberImportQuery = `
+	select i.id, i.filename, i.state, i.duplicates, i.email_column, i.locale_column, i.tags_column, i.error, i.created,
+		count(r.line) as total,
+		count(r.line) filter (where r.result = 'added') as added,
+		count(r.line) filter (where r.result = 'updated') as updated,
+		count(r.line) filter (where r.result = 'skipped') as skipped,
+		count(r.line) filter (where r.result = 'failed') as failed
+	from subscriber_imports i
+		left join subscriber_import_rows r on r.import_id = i.id`
+
+// GetSubscriberImport by ID, with the counts of the results of its rows so far, or nil if there's none.
+func (d *Database) GetSubscriberImport(ctx context.Context, id int64) (*model.SubscriberImport, error) {
+	ctx = withQueryName(ctx, "GetSubscriberImport")
+	var r subscriberImportRow
+	query := subscriberImportQuery + `
+		where i.id = $1
+		group by i.id`
+	if err := d.DB.GetContext(ctx, &r, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	i := r.subscriberImport()
+	return &i, nil
+}
+
+// ListSubscriberImports newest first, with the counts of the results of their rows, at most limit of them.
+func (d *Database) ListSubscriberImports(ctx context.Context, limit int) ([]model.SubscriberImport, error) {
+	ctx = withQueryName(ctx, "ListSubscriberImports")
+	var rows []subscriberImportRow
+	query := subscriberImportQuery + `
+		group by i.id
+		order by i.created desc, i.id desc
+		limit $1`
+	if err := d.DB.SelectContext(ctx, &rows, query, limit); err != nil {
+		return nil, err
+	}
+	imports := make([]model.SubscriberImport, len(rows))
+	for i, r := range rows {
+		imports[i] = r.subscriberImport()
+	}
+	return imports, nil
+}
+
+// importRowRow of the subscriber_import_rows table, with the tags separated by spaces.
+type importRowRow struct {
+	Line   int
+	Email  model.Email
+	Locale string
+	Tags   string
+	Result sql.NullString
+	Error  string
+}
+
+func (r importRowRow) row() model.SubscriberImportRow {
+	return model.SubscriberImportRow{Line: r.Line, Email: r.Email, Locale: r.Locale, Tags: splitTags(r.Tags),
+		Result: model.SubscriberImportResult(r.Result.String), Error: r.Error}
+}
+
+// ListSubscriberImportRows of the import in the order of the file, at most limit of them, like for a preview.
+func (d *Database) ListSubscriberImportRows(ctx context.Context, id int64, limit int) ([]model.SubscriberImportRow, error) {
+	ctx = withQueryName(ctx, "ListSubscriberImportRows")
+	var rows []importRowRow
+	query := `
+		select line, email, locale, tags, result, error
+		from subscriber_import_rows
+		where import_id = $1
+		order by line
+		limit $2`
+	if err := d.DB.SelectContext(ctx, &rows, query, id, limit); err != nil {
+		return nil, err
+	}
+	result := make([]model.SubscriberImportRow, len(rows))
+	for i, r := range rows {
+		result[i] = r.row()
+	}
+	return result, nil
+}
+
+// ExportSubscriberImportErrors passes the failed rows of the import to f in the order of the file, one at a time,
+// read from the database with a cursor like ExportSubscribers. An error from f stops it and is returned.
+func (d *Database) ExportSubscriberImportErrors(ctx context.Context, id int64, f func(model.SubscriberImportRow) error) error {
+	ctx = withQueryName(ctx, "ExportSubscriberImportErrors")
+	query := `
+		select line, email, locale, tags, result, error
+		from subscriber_import_rows
+		where import_id = $1 and result = 'failed'
+		order by line`
+	rows, err := d.DB.QueryxContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var r importRowRow
+		if err := rows.StructScan(&r); err != nil {
+			return err
+		}
+		if err := f(r.row()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// QueueSubscriberImport by ID for the import job, which is enqueued through the outbox in the same transaction,
+// doing what the duplicates say with the rows of existing subscribers. It's recorded by the actor in the audit log.
+// Queueing a failed import again resumes it with the duplicates it was queued with first.
+// Returns ErrNotFound if there's no such import, ErrImportInProgress if it's queued or importing,
+// ErrAlreadyImported if it's completed, and an apperr.Invalid error for unknown duplicates.
+func (d *Database) QueueSubscriberImport(ctx context.Context, id int64, duplicates model.SubscriberImportDuplicates, actor string) error {
+	ctx = withQueryName(ctx, "QueueSubscriberImport")
+	if duplicates != model.SubscriberImportDuplicatesSkip && duplicates != model.SubscriberImportDuplicatesUpdate {
+		return apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid duplicates %q", duplicates))
+	}
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var i struct {
+			Filename string
+			State    model.SubscriberImportState
+		}
+		query := `select filename, state from subscriber_imports where id = $1 for update`
+		if err := tx.GetContext(ctx, &i, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		switch i.State {
+		case model.SubscriberImportStateQueued, model.SubscriberImportStateImporting:
+			return ErrImportInProgress
+		case model.SubscriberImportStateCompleted:
+			return ErrAlreadyImported
+		}
+
+		query = `
+			update subscriber_imports
+			set state = 'queued', duplicates = case when state = 'uploaded' then $2 else duplicates end, error = '', updated = now()
+			where id = $1
+			returning duplicates`
+		if err := tx.GetContext(ctx, &duplicates, query, id, duplicates); err != nil {
+			return err
+		}
+
+		m, err := messaging.NewMessage(model.SubscriberImportRequested{ImportID: strconv.FormatInt(id, 10)})
+		if err != nil {
+			return err
+		}
+		if err := EnqueueInTx(ctx, tx, m); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "subscriber.import", fmt.Sprintf("subscriber_import/%v", id),
+			map[string]string{"filename": i.Filename, "duplicates": string(duplicates),
+				"resumed": strconv.FormatBool(i.State == model.SubscriberImportStateFailed)})
+	})
+}
+
+// DeleteSubscriberImport by ID with its rows, and record it by the actor in the audit log.
+// The subscribers it imported stay. Returns ErrNotFound if there's no such import,
+// and ErrImportInProgress if it's queued or importing.
+func (d *Database) DeleteSubscriberImport(ctx context.Context, id int64, actor string) error {
+	ctx = withQueryName(ctx, "DeleteSubscriberImport")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var i struct {
+			Filename string
+			State    model.SubscriberImportState
+		}
+		query := `select filename, state from subscriber_imports where id = $1 for update`
+		if err := tx.GetContext(ctx, &i, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if i.State == model.SubscriberImportStateQueued || i.State == model.SubscriberImportStateImporting {
+			return ErrImportInProgress
+		}
+		if _, err := tx.ExecContext(ctx, `delete from subscriber_imports where id = $1`, id); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "subscriber_import.delete", fmt.Sprintf("subscriber_import/%v", id),
+			map[string]string{"filename": i.Filename})
+	})
+}
+
+// StartSubscriberImport by ID, which marks a queued import as importing.
+func (d *Database) StartSubscriberImport(ctx context.Context, id int64) error {
+	ctx = withQueryName(ctx, "StartSubscriberImport")
+	query := `update subscriber_imports set state = 'importing', updated = now() where id = $1 and state = 'queued'`
+	_, err := d.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// CompleteSubscriberImport by ID, which marks an importing import as completed if every row has a result.
+func (d *Database) CompleteSubscriberImport(ctx context.Context, id int64) error {
+	ctx = withQueryName(ctx, "CompleteSubscriberImport")
+	query := `
+		update subscriber_imports i set state = 'completed', updated = now()
+		where id = $1 and state = 'importing'
+			and not exists (select from subscriber_import_rows r where r.import_id = i.id and r.result is null)`
+	_, err := d.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// FailSubscriberImport by ID with the reason, like after its job failed permanently.
+func (d *Database) FailSubscriberImport(ctx context.Context, id int64, reason string) error {
+	ctx = withQueryName(ctx, "FailSubscriberImport")
+	query := `update subscriber_imports set state = 'failed', error = $2, updated = now() where id = $1`
+	_, err := d.DB.ExecContext(ctx, query, id, reason)
+	return err
+}
+
+// Errors of the rows of a subscriber import that fail when they're imported.
+const (
+	importErrorDeleted    = "The subscriber was deleted in the admin, so they aren't imported again."
+	importErrorSuppressed = "The address is on the suppression list."
+)
+
+// ImportSubscriberRows is the bulk import of the next rows of the importing import with the ID that don't have
+// a result yet, at most limit of them, in the order of the file and in one transaction.
+// Returns how many rows got a result, which is zero when there are none left, or the import isn't importing.
+//
+// New addresses are added as confirmed subscribers with the source "import", and their locale and tags,
+// or the default locale without one. They aren't welcomed, and no subscriber events are emitted for them,
+// so an import doesn't email anyone or flood the webhooks. Addresses of existing subscribers are skipped or updated,
+// as the duplicates of the import say, and an address that's in the file again is a duplicate of its first row.
+// Rows of deleted subscribers, and of new addresses on the suppression list, fail.
+// Returns ErrNotFound if there's no such import.
+func (d *Database) ImportSubscriberRows(ctx context.Context, id int64, limit int) (int, error) {
+	ctx = withQueryName(ctx, "ImportSubscriberRows")
+	var done int
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		// Locking the import keeps a job that runs twice from importing the same rows at the same time.
+		var i struct {
+			State      model.SubscriberImportState
+			Duplicates model.SubscriberImportDuplicates
+		}
+		query := `select state, duplicates from subscriber_imports where id = $1 for update`
+		if err := tx.GetContext(ctx, &i, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		if i.State != model.SubscriberImportStateImporting {
+			return nil
+		}
+
+		var rows []importRowRow
+		query = `
+			select line, email, locale, tags, result, error
+			from subscriber_import_rows
+			where import_id = $1 and result is null
+			order by line
+			limit $2`
+		if err := tx.SelectContext(ctx, &rows, query, id, limit); err != nil {
+			return err
+		}
+		// An address can only be changed once in the statements below, so the batch stops before it's in it again,
+		// and that row is a duplicate in the next batch.
+		seen := map[model.Email]bool{}
+		for j, r := range rows {
+			if seen[r.Email] {
+				rows = rows[:j]
+				break
+			}
+			seen[r.Email] = true
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		emails, err := json.Marshal(rowEmails(rows))
+		if err != nil {
+			return err
+		}
+		var existing []struct {
+			Email   model.Email
+			Deleted bool
+		}
+		query = `
+			select email, deleted is not null as deleted
+			from newsletter_subscribers
+			where email in (select value from json_array_elements_text($1::json))`
+		if err := tx.SelectContext(ctx, &existing, query, string(emails)); err != nil {
+			return err
+		}
+		var suppressed []model.Email
+		query = `
+			select value from json_array_elements_text($1::json)
+			where exists (select from suppressions where email_hash = email_hash(value))`
+		if err := tx.SelectContext(ctx, &suppressed, query, string(emails)); err != nil {
+			return err
+		}
+		deleted := map[model.Email]bool{}
+		for _, e := range existing {
+			deleted[e.Email] = e.Deleted
+		}
+		isSuppressed := map[model.Email]bool{}
+		for _, e := range suppressed {
+			isSuppressed[e] = true
+		}
+
+		var added []importSubscriberJSON
+		var updated, results []importRowJSON
+		for _, r := range rows {
+			result := importRowJSON{Line: r.Line, Email: r.Email.String(), Locale: r.Locale, Tags: r.Tags}
+			isDeleted, exists := deleted[r.Email]
+			switch {
+			case isDeleted:
+				result.Result, result.Error = string(model.SubscriberImportResultFailed), importErrorDeleted
+			case exists && i.Duplicates == model.SubscriberImportDuplicatesUpdate:
+				result.Result = string(model.SubscriberImportResultUpdated)
+				updated = append(updated, result)
+			case exists:
+				result.Result = string(model.SubscriberImportResultSkipped)
+			case isSuppressed[r.Email]:
+				result.Result, result.Error = string(model.SubscriberImportResultFailed), importErrorSuppressed
+			default:
+				result.Result = string(model.SubscriberImportResultAdded)
+				token, err := createSecret()
+				if err != nil {
+					return err
+				}
+				added = append(added, importSubscriberJSON{Email: r.Email.String(), Locale: r.Locale, Token: token})
+			}
+			results = append(results, result)
+		}
+
+		if len(added) > 0 {
+			b, err := json.Marshal(added)
+			if err != nil {
+				return err
+			}
+			// A signup between reading the existing subscribers and this insert has the address already,
+			// and its row is skipped, whatever the duplicates say.
+			var inserted []model.Email
+			query := `
+				insert into newsletter_subscribers (email, token, confirmed, confirmed_at, welcomed_at, locale, source)
+				select email, token, true, now(), now(), coalesce(nullif(locale, ''), 'en'), 'import'
+				from json_to_recordset($1::json) as r(email text, locale text, token text)
+				on conflict (email) do nothing
+				returning email`
+			if err := tx.SelectContext(ctx, &inserted, query, string(b)); err != nil {
+				return err
+			}
+			isInserted := map[model.Email]bool{}
+			for _, e := range inserted {
+				isInserted[e] = true
+			}
+			for j, r := range results {
+				if r.Result == string(model.SubscriberImportResultAdded) && !isInserted[model.Email(r.Email)] {
+					results[j].Result = string(model.SubscriberImportResultSkipped)
+				}
+			}
+		}
+
+		if len(updated) > 0 {
+			b, err := json.Marshal(updated)
+			if err != nil {
+				return err
+			}
+			query := `
+				update newsletter_subscribers s set locale = r.locale, updated = now()
+				from json_to_recordset($1::json) as r(email text, locale text)
+				where s.email = r.email and r.locale <> '' and s.locale <> r.locale`
+			if _, err := tx.ExecContext(ctx, query, string(b)); err != nil {
+				return err
+			}
+		}
+
+		var tagged []importRowJSON
+		for _, r := range results {
+			if r.Result == string(model.SubscriberImportResultAdded) || r.Result == string(model.SubscriberImportResultUpdated) {
+				tagged = append(tagged, r)
+			}
+		}
+		if len(tagged) > 0 {
+			b, err := json.Marshal(tagged)
+			if err != nil {
+				return err
+			}
+			query := `
+				insert into subscriber_tags (subscriber_id, tag)
+				select s.id, t.tag
+				from json_to_recordset($1::json) as r(email text, tags text)
+					cross join unnest(string_to_array(r.tags, ' ')) as t(tag)
+					join newsletter_subscribers s on s.email = r.email
+				where t.tag <> ''
+				on conflict do nothing`
+			if _, err := tx.ExecContext(ctx, query, string(b)); err != nil {
+				return err
+			}
+		}
+
+		b, err := json.Marshal(results)
+		if err != nil {
+			return err
+		}
+		query = `
+			update subscriber_import_rows i set result = r.result, error = r.error
+			from json_to_recordset($2::json) as r(line int, result text, error text)
+			where i.import_id = $1 and i.line = r.line`
+		if _, err := tx.ExecContext(ctx, query, id, string(b)); err != nil {
+			return err
+		}
+		query = `update subscriber_imports set updated = now() where id = $1`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+		done = len(rows)
+		return nil
+	})
+	return done, err
+}
+
+func rowEmails(rows []importRowRow) []model.Email {
+	emails := make([]model.Email, len(rows))
+	for i, r := range rows {
+		emails[i] = r.Email
+	}
+	return emails
+}
@@ -0,0 +1,322 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+// importRows returns a next function for CreateSubscriberImport that returns the rows, then io.EOF.
+func importRows(rows ...model.SubscriberImportRow) func() (model.SubscriberImportRow, error) {
+	return func() (model.SubscriberImportRow, error) {
+		if len(rows) == 0 {
+			return model.SubscriberImportRow{}, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+}
+
+// importAll rows of the queued import with the ID in batches of limit, like the import job,
+// returning how many batches there were.
+func importAll(is *is.I, db *storage.Database, id int64, limit int) int {
+	is.NoErr(db.StartSubscriberImport(context.Background(), id))
+	var batches int
+	for {
+		n, err := db.ImportSubscriberRows(context.Background(), id, limit)
+		is.NoErr(err)
+		if n == 0 {
+			break
+		}
+		batches++
+	}
+	is.NoErr(db.CompleteSubscriberImport(context.Background(), id))
+	return batches
+}
+
+func TestDatabase_SubscriberImports(t *testing.T) {
+	columns := model.SubscriberImportColumns{Email: "Email Address", Locale: "Language", Tags: "TAGS"}
+
+	t.Run("uploads the rows and queues the import, recording it in the audit log and enqueueing the job", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id, err := db.CreateSubscriberImport(context.Background(), "subscribers.csv", columns, importRows(
+			model.SubscriberImportRow{Line: 2, Email: "me@example.com", Locale: "da", Tags: []model.Tag{"api", "beta"}},
+			model.SubscriberImportRow{Line: 3, Email: "nope", Error: "nope isn't a valid email address."},
+		))
+		is.NoErr(err)
+
+		i, err := db.GetSubscriberImport(context.Background(), id)
+		is.NoErr(err)
+		is.Equal("subscribers.csv", i.Filename)
+		is.Equal(model.SubscriberImportStateUploaded, i.State)
+		is.Equal(columns, i.Columns)
+		is.Equal(2, i.Total)
+		is.Equal(1, i.Failed)
+
+		rows, err := db.ListSubscriberImportRows(context.Background(), id, 10)
+		is.NoErr(err)
+		is.Equal([]model.SubscriberImportRow{
+			{Line: 2, Email: "me@example.com", Locale: "da", Tags: []model.Tag{"api", "beta"}},
+			{Line: 3, Email: "nope", Result: model.SubscriberImportResultFailed, Error: "nope isn't a valid email address."},
+		}, rows)
+
+		var failed []model.SubscriberImportRow
+		is.NoErr(db.ExportSubscriberImportErrors(context.Background(), id, func(r model.SubscriberImportRow) error {
+			failed = append(failed, r)
+			return nil
+		}))
+		is.Equal(1, len(failed))
+		is.Equal(3, failed[0].Line)
+
+		is.NoErr(db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesUpdate, storage.AuditActorAdmin))
+		i, err = db.GetSubscriberImport(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.SubscriberImportStateQueued, i.State)
+		is.Equal(model.SubscriberImportDuplicatesUpdate, i.Duplicates)
+
+		messages := outboxMessages(is, db, "subscriber_import")
+		is.Equal(1, len(messages))
+		is.Equal(fmt.Sprint(id), messages[0]["importID"])
+
+		var actions []string
+		is.NoErr(db.DB.Select(&actions, `select action from audit_events order by created, id`))
+		is.Equal([]string{"subscriber.import"}, actions)
+
+		err = db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesSkip, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrImportInProgress))
+		err = db.DeleteSubscriberImport(context.Background(), id, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrImportInProgress))
+	})
+
+	t.Run("leaves nothing behind if reading the rows fails", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		var calls int
+		_, err := db.CreateSubscriberImport(context.Background(), "subscribers.csv", columns,
+			func() (model.SubscriberImportRow, error) {
+				calls++
+				if calls > 1500 {
+					return model.SubscriberImportRow{}, errors.New("not CSV")
+				}
+				return model.SubscriberImportRow{Line: calls + 1, Email: model.Email(fmt.Sprintf("%v@example.com", calls))}, nil
+			})
+		is.Equal("not CSV", err.Error())
+
+		imports, err := db.ListSubscriberImports(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(0, len(imports))
+	})
+
+	t.Run("adds new addresses as confirmed, and skips or updates existing subscribers as the duplicates say", func(t *testing.T) {
+		for _, duplicates := range []model.SubscriberImportDuplicates{model.SubscriberImportDuplicatesSkip,
+			model.SubscriberImportDuplicatesUpdate} {
+			t.Run(string(duplicates), func(t *testing.T) {
+				is := is.New(t)
+				db := storagetest.NewDatabase(t)
+
+				_, err := db.SignupForNewsletter(context.Background(), "existing@example.com", "en", "")
+				is.NoErr(err)
+				_, err = db.SignupForNewsletter(context.Background(), "deleted@example.com", "en", "")
+				is.NoErr(err)
+				subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+				is.NoErr(err)
+				for _, s := range subscribers {
+					if s.Email == "deleted@example.com" {
+						_, err = db.DeleteSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+						is.NoErr(err)
+					}
+				}
+				is.NoErr(db.AddSuppression(context.Background(), "blocked@example.com", model.SuppressionReasonManual, "admin"))
+
+				id, err := db.CreateSubscriberImport(context.Background(), "subscribers.csv", columns, importRows(
+					model.SubscriberImportRow{Line: 2, Email: "new@example.com", Locale: "da", Tags: []model.Tag{"api"}},
+					model.SubscriberImportRow{Line: 3, Email: "existing@example.com", Locale: "da", Tags: []model.Tag{"beta"}},
+					model.SubscriberImportRow{Line: 4, Email: "deleted@example.com"},
+					model.SubscriberImportRow{Line: 5, Email: "blocked@example.com"},
+					model.SubscriberImportRow{Line: 6, Email: "new@example.com", Tags: []model.Tag{"early"}},
+					model.SubscriberImportRow{Line: 7, Email: "other@example.com"},
+				))
+				is.NoErr(err)
+				is.NoErr(db.QueueSubscriberImport(context.Background(), id, duplicates, storage.AuditActorAdmin))
+				importAll(is, db, id, 10)
+
+				i, err := db.GetSubscriberImport(context.Background(), id)
+				is.NoErr(err)
+				is.Equal(model.SubscriberImportStateCompleted, i.State)
+				is.Equal(6, i.Done())
+				is.Equal(2, i.Added)
+				is.Equal(2, i.Failed)
+
+				rows, err := db.ListSubscriberImportRows(context.Background(), id, 10)
+				is.NoErr(err)
+				results := map[int]model.SubscriberImportResult{}
+				for _, r := range rows {
+					results[r.Line] = r.Result
+				}
+				is.Equal(model.SubscriberImportResultAdded, results[2])
+				is.Equal(model.SubscriberImportResultFailed, results[4])
+				is.Equal(model.SubscriberImportResultFailed, results[5])
+				is.Equal(model.SubscriberImportResultAdded, results[7])
+
+				var s struct {
+					Confirmed bool
+					Locale    string
+					Source    string
+				}
+				is.NoErr(db.DB.Get(&s, `select confirmed, locale, source from newsletter_subscribers where email = 'new@example.com'`))
+				is.True(s.Confirmed)
+				is.Equal("da", s.Locale)
+				is.Equal("import", s.Source)
+				is.NoErr(db.DB.Get(&s, `select confirmed, locale, source from newsletter_subscribers where email = 'other@example.com'`))
+				is.Equal("en", s.Locale)
+
+				var existing struct {
+					ID     int64
+					Locale string
+				}
+				is.NoErr(db.DB.Get(&existing, `select id, locale from newsletter_subscribers where email = 'existing@example.com'`))
+				tags, err := db.ListSubscriberTags(context.Background(), existing.ID)
+				is.NoErr(err)
+				switch duplicates {
+				case model.SubscriberImportDuplicatesSkip:
+					is.Equal(model.SubscriberImportResultSkipped, results[3])
+					is.Equal(model.SubscriberImportResultSkipped, results[6])
+					is.Equal(2, i.Skipped)
+					is.Equal("en", existing.Locale)
+					is.Equal(0, len(tags))
+				case model.SubscriberImportDuplicatesUpdate:
+					is.Equal(model.SubscriberImportResultUpdated, results[3])
+					is.Equal(model.SubscriberImportResultUpdated, results[6])
+					is.Equal(2, i.Updated)
+					is.Equal("da", existing.Locale)
+					is.Equal([]model.Tag{"beta"}, tags)
+				}
+
+				var newID int64
+				is.NoErr(db.DB.Get(&newID, `select id from newsletter_subscribers where email = 'new@example.com'`))
+				tags, err = db.ListSubscriberTags(context.Background(), newID)
+				is.NoErr(err)
+				if duplicates == model.SubscriberImportDuplicatesUpdate {
+					is.Equal([]model.Tag{"api", "early"}, tags)
+				} else {
+					is.Equal([]model.Tag{"api"}, tags)
+				}
+
+				// Imported subscribers don't get emails, and don't emit events for the webhooks, unlike the signups.
+				is.Equal(2, len(outboxMessages(is, db, model.EventSubscriberSignedUp)))
+				is.Equal(0, len(outboxMessages(is, db, model.EventSubscriberConfirmed)))
+			})
+		}
+	})
+
+	t.Run("imports 50,000 rows streamed in, in batches, and resumes after failing", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		const total = 50000
+		var line int
+		id, err := db.CreateSubscriberImport(context.Background(), "big.csv", columns,
+			func() (model.SubscriberImportRow, error) {
+				if line == total {
+					return model.SubscriberImportRow{}, io.EOF
+				}
+				line++
+				return model.SubscriberImportRow{Line: line + 1, Email: model.Email(fmt.Sprintf("subscriber-%v@example.com", line)),
+					Tags: []model.Tag{"imported"}}, nil
+			})
+		is.NoErr(err)
+
+		is.NoErr(db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesSkip, storage.AuditActorAdmin))
+		is.NoErr(db.StartSubscriberImport(context.Background(), id))
+		n, err := db.ImportSubscriberRows(context.Background(), id, 1000)
+		is.NoErr(err)
+		is.Equal(1000, n)
+		is.NoErr(db.FailSubscriberImport(context.Background(), id, "oh no"))
+
+		i, err := db.GetSubscriberImport(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.SubscriberImportStateFailed, i.State)
+		is.Equal("oh no", i.Error)
+		is.Equal(1000, i.Added)
+
+		// Nothing is imported while the import isn't importing.
+		n, err = db.ImportSubscriberRows(context.Background(), id, 1000)
+		is.NoErr(err)
+		is.Equal(0, n)
+
+		is.NoErr(db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesUpdate, storage.AuditActorAdmin))
+		is.Equal(49, importAll(is, db, id, 1000))
+
+		i, err = db.GetSubscriberImport(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.SubscriberImportStateCompleted, i.State)
+		is.Equal(model.SubscriberImportDuplicatesSkip, i.Duplicates)
+		is.Equal(total, i.Total)
+		is.Equal(total, i.Added)
+
+		count, err := db.CountSubscribers(context.Background(), model.SubscriberStatusConfirmed)
+		is.NoErr(err)
+		is.Equal(total, count)
+		count, err = db.CountSubscribersInSegment(context.Background(), "imported", model.SubscriberStatusConfirmed)
+		is.NoErr(err)
+		is.Equal(total, count)
+
+		err = db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesSkip, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrAlreadyImported))
+	})
+
+	t.Run("deletes an import with its rows, but not the subscribers it imported", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id, err := db.CreateSubscriberImport(context.Background(), "subscribers.csv", columns, importRows(
+			model.SubscriberImportRow{Line: 2, Email: "me@example.com"},
+		))
+		is.NoErr(err)
+		is.NoErr(db.QueueSubscriberImport(context.Background(), id, model.SubscriberImportDuplicatesSkip, storage.AuditActorAdmin))
+		importAll(is, db, id, 10)
+
+		is.NoErr(db.DeleteSubscriberImport(context.Background(), id, storage.AuditActorAdmin))
+		i, err := db.GetSubscriberImport(context.Background(), id)
+		is.NoErr(err)
+		is.True(i == nil)
+		var rows int
+		is.NoErr(db.DB.Get(&rows, `select count(*) from subscriber_import_rows`))
+		is.Equal(0, rows)
+
+		count, err := db.CountSubscribers(context.Background(), "")
+		is.NoErr(err)
+		is.Equal(1, count)
+
+		var actions []string
+		is.NoErr(db.DB.Select(&actions, `select action from audit_events order by created, id`))
+		is.Equal([]string{"subscriber.import", "subscriber_import.delete"}, actions)
+	})
+
+	t.Run("returns not found for an unknown import, and invalid for unknown duplicates", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		err := db.QueueSubscriberImport(context.Background(), 1, model.SubscriberImportDuplicatesSkip, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrNotFound))
+		err = db.DeleteSubscriberImport(context.Background(), 1, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrNotFound))
+		_, err = db.ImportSubscriberRows(context.Background(), 1, 10)
+		is.True(errors.Is(err, storage.ErrNotFound))
+		err = db.QueueSubscriberImport(context.Background(), 1, "overwrite", storage.AuditActorAdmin)
+		is.True(errors.Is(err, apperr.Invalid))
+	})
+}
@@ -0,0 +1,11 @@
+package storage_test
+
+import (
+	"testing"
+
+	"canvas/storage/storagetest"
+)
+
+func TestMain(m *testing.M) {
+	storagetest.Main(m)
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queryNames that queries are labelled with in the metrics, one for each method that queries the database,
+// named after it. Queries without a name, or with one that isn't here, are labelled "other",
+// so the number of series stays bounded, and no SQL ends up in a label.
+var queryNames = map[string]bool{
+	"ACMECache.Delete":             true,
+	"ACMECache.Get":                true,
+	"ACMECache.Put":                true,
+	"AddSubscriberTag":             true,
+	"AddSuppression":               true,
+	"ClearComplaint":               true,
+	"CompleteNewsletterSendIfDone": true,
+	"CompleteSubscriberImport":     true,
+	"ConfirmNewsletterSignup":      true,
+	"ConfirmSubscriber":            true,
+	"CountSubscribers":             true,
+	"CountSubscribersInSegment":    true,
+	"CreateNewsletter":             true,
+	"CreateSubscriberImport":       true,
+	"CreateWebhookEndpoint":        true,
+	"DeleteExpiredSessions":        true,
+	"DeleteExpiredThrottles":       true,
+	"DeleteOldEmailSends":          true,
+	"DeleteSentOutboxMessages":     true,
+	"DeleteSession":                true,
+	"DeleteSubscriber":             true,
+	"DeleteSubscriberImport":       true,
+	"DeleteWebhookEndpoint":        true,
+	"EnableWebhookEndpoint":        true,
+	"ExportSubscriberImportErrors": true,
+	"ExportSubscribers":            true,
+	"FailNewsletterSend":           true,
+	"FailSubscriberImport":         true,
+	"FinishNewsletterFanOut":       true,
+	"FireSchedule":                 true,
+	"GetNewsletter":                true,
+	"GetNewsletterSend":            true,
+	"GetNewsletterSendCheckpoint":  true,
+	"GetOutboxMessages":            true,
+	"GetPublishedNewsletter":       true,
+	"GetScheduleLastRun":           true,
+	"GetSession":                   true,
+	"GetSubscriber":                true,
+	"GetSubscriberImport":          true,
+	"GetWebhookDelivery":           true,
+	"GetWebhookEndpoint":           true,
+	"HasSentNewsletter":            true,
+	"ImportSubscriberRows":         true,
+	"IsSubscribed":                 true,
+	"IsSuppressed":                 true,
+	"ListEmailSends":               true,
+	"ListPublishedNewsletters":     true,
+	"ListSubscriberImportRows":     true,
+	"ListSubscriberImports":        true,
+	"ListSubscriberTags":           true,
+	"ListSubscribers":              true,
+	"ListSuppressions":             true,
+	"ListTags":                     true,
+	"ListWebhookDeliveries":        true,
+	"ListWebhookEndpoints":         true,
+	"MigrateDown":                  true,
+	"MigrateTo":                    true,
+	"MigrateUp":                    true,
+	"MigrationVersion":             true,
+	"Ping":                         true,
+	"QueueNewsletterSend":          true,
+	"QueueSubscriberImport":        true,
+	"RecordAuditEvent":             true,
+	"RecordBounce":                 true,
+	"RecordComplaint":              true,
+	"RecordDelivery":               true,
+	"RecordEmailClick":             true,
+	"RecordEmailOpen":              true,
+	"RecordEmailSend":              true,
+	"RecordWebhookDeliveryAttempt": true,
+	"RelayOutboxMessages":          true,
+	"RemoveSubscriberTag":          true,
+	"RemoveSuppression":            true,
+	"ResendConfirmation":           true,
+	"SaveSession":                  true,
+	"ScheduleNewsletter":           true,
+	"SearchSubscribers":            true,
+	"SendScheduledNewsletters":     true,
+	"SendStats":                    true,
+	"SetNewsletterSendCheckpoint":  true,
+	"SignupForNewsletter":          true,
+	"SkipWebhookDelivery":          true,
+	"StartNewsletterSend":          true,
+	"StartSubscriberImport":        true,
+	"SubscriberStats":              true,
+	"SuppressSubscriber":           true,
+	"Throttle":                     true,
+	"UnscheduleNewsletter":         true,
+	"Unsubscribe":                  true,
+	"UnsubscribeSubscriber":        true,
+	"UpdateNewsletter":             true,
+	"WithAdvisoryLock":             true,
+}
+
+// queryNameKey is the context key of the query name.
+type queryNameKey struct{}
+
+// withQueryName for the queries made with ctx, which must be one of queryNames.
+func withQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+// queryName of the queries made with ctx, or "other" if it has none from queryNames.
+func queryName(ctx context.Context) string {
+	name, _ := ctx.Value(queryNameKey{}).(string)
+	if !queryNames[name] {
+		return "other"
+	}
+	return name
+}
+
+// queryMetrics of how long queries take and how many rows they return or affect, by query name.
+type queryMetrics struct {
+	duration *prometheus.HistogramVec
+	rows     *prometheus.CounterVec
+}
+
+func newQueryMetrics(registry *prometheus.Registry) *queryMetrics {
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_db_query_duration_seconds",
+		Help:    "How long database queries take, including reading their rows, by query name and outcome.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"query", "outcome"})
+	rows := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "app_db_query_rows_total",
+		Help: "Rows returned or affected by database queries, by query name.",
+	}, []string{"query"})
+	registry.MustRegister(duration, rows)
+	return &queryMetrics{duration: duration, rows: rows}
+}
+
+// observe a query made with ctx that started at start, with its rows and the error it ended with, if any.
+// Errors because the deadline of ctx passed are timeouts.
+func (m *queryMetrics) observe(ctx context.Context, start time.Time, rows int64, err error) {
+	name := queryName(ctx)
+	outcome := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		outcome = "timeout"
+	default:
+		outcome = "error"
+	}
+	m.duration.WithLabelValues(name, outcome).Observe(time.Since(start).Seconds())
+	if rows > 0 {
+		m.rows.WithLabelValues(name).Add(float64(rows))
+	}
+}
+
+// poolCollector of the stats of the connection pool of the database, read from the pool when they're gathered.
+// There are none before the pool is opened.
+type poolCollector struct {
+	db           *Database
+	connections  *prometheus.Desc
+	maxOpen      *prometheus.Desc
+	waits        *prometheus.Desc
+	waitDuration *prometheus.Desc
+}
+
+func newPoolCollector(db *Database) *poolCollector {
+	return &poolCollector{
+		db: db,
+		connections: prometheus.NewDesc("app_db_connections",
+			"Open connections of the connection pool, by state: in_use or idle.", []string{"state"}, nil),
+		maxOpen: prometheus.NewDesc("app_db_max_open_connections",
+			"Most connections the connection pool opens.", nil, nil),
+		waits: prometheus.NewDesc("app_db_connection_waits_total",
+			"Number of times a query waited for a connection of the connection pool.", nil, nil),
+		waitDuration: prometheus.NewDesc("app_db_connection_wait_seconds_total",
+			"How long queries waited for connections of the connection pool.", nil, nil),
+	}
+}
+
+// Describe the pool stats, for prometheus.Collector.
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connections
+	ch <- c.maxOpen
+	ch <- c.waits
+	ch <- c.waitDuration
+}
+
+// Collect the pool stats, for prometheus.Collector.
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.db.DB == nil {
+		return
+	}
+	s := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(s.InUse), "in_use")
+	ch <- prometheus.MustNewConstMetric(c.connections, prometheus.GaugeValue, float64(s.Idle), "idle")
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.waits, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds())
+}
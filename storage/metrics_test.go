@@ -0,0 +1,133 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"canvas/integrationtest"
+	"canvas/storage"
+)
+
+func TestDatabase_Metrics(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("observes queries by name and outcome, with their rows", func(t *testing.T) {
+		is := is.New(t)
+		registry := prometheus.NewRegistry()
+		db, cleanup := integrationtest.CreateDatabaseWithMetrics(registry)
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		count, err := db.CountSubscribers(context.Background(), "")
+		is.NoErr(err)
+		is.Equal(1, count)
+
+		// While the lock is held, taking it again waits until the deadline.
+		locked := make(chan struct{})
+		release := make(chan struct{})
+		go func() {
+			_ = db.WithAdvisoryLock(context.Background(), "test", func(ctx context.Context) error {
+				close(locked)
+				<-release
+				return nil
+			})
+		}()
+		<-locked
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err = db.WithAdvisoryLock(ctx, "test", func(ctx context.Context) error {
+			return nil
+		})
+		is.True(err != nil)
+		close(release)
+
+		durations, rows := gatherQueryMetrics(t, registry)
+		is.True(durations["SignupForNewsletter ok"] > 0)
+		is.Equal(uint64(1), durations["CountSubscribers ok"])
+		is.Equal(uint64(1), durations["WithAdvisoryLock timeout"])
+		is.Equal(float64(1), rows["CountSubscribers"])
+		for name := range durations {
+			is.True(name != "other ok")
+		}
+	})
+}
+
+func TestDatabase_PoolMetrics(t *testing.T) {
+	t.Run("has the stats of the connection pool after it's opened", func(t *testing.T) {
+		is := is.New(t)
+		registry := prometheus.NewRegistry()
+		db := storage.NewDatabase(storage.NewDatabaseOptions{Host: "localhost", Port: 5432, MaxOpenConnections: 3, Metrics: registry})
+
+		is.Equal(0, len(gatherPoolMetrics(t, registry)))
+
+		is.NoErr(db.Open())
+		defer func() {
+			_ = db.Close()
+		}()
+		pool := gatherPoolMetrics(t, registry)
+		is.Equal(float64(3), pool["app_db_max_open_connections"])
+		is.Equal(float64(0), pool["app_db_connections in_use"])
+		is.Equal(float64(0), pool["app_db_connection_waits_total"])
+	})
+}
+
+// gatherPoolMetrics from the registry by "name state", or by name for those without a state.
+func gatherPoolMetrics(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			name := f.GetName()
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "state" {
+					name += " " + l.GetValue()
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				pool[name] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				pool[name] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return pool
+}
+
+// gatherQueryMetrics from the registry, as the number of observed queries by "name outcome",
+// and the rows by name.
+func gatherQueryMetrics(t *testing.T, registry *prometheus.Registry) (map[string]uint64, map[string]float64) {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	durations := map[string]uint64{}
+	rows := map[string]float64{}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			switch f.GetName() {
+			case "app_db_query_duration_seconds":
+				durations[labels["query"]+" "+labels["outcome"]] = m.GetHistogram().GetSampleCount()
+			case "app_db_query_rows_total":
+				rows[labels["query"]] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return durations, rows
+}
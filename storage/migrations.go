@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+
+	"github.com/maragudk/migrate"
+)
+
+//go:embed migrations
+var embeddedMigrations embed.FS
+
+// Migrations of the database schema, embedded in the binary, as the up and down SQL files
+// that github.com/maragudk/migrate reads.
+func Migrations() fs.FS {
+	fsys, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// migrationMatcher matches up migration files like github.com/maragudk/migrate does, with the version in the name.
+var migrationMatcher = regexp.MustCompile(`^([\w-]+)\.up\.sql$`)
+
+// PendingMigrations in fsys after the current version, in the order they're applied.
+func PendingMigrations(fsys fs.FS, current string) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var pending []string
+	for _, e := range entries {
+		m := migrationMatcher.FindStringSubmatch(e.Name())
+		if m == nil || m[1] <= current {
+			continue
+		}
+		pending = append(pending, m[1])
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
+
+// MigrationVersion of the database, which is empty if it hasn't been migrated.
+func (d *Database) MigrationVersion(ctx context.Context) (string, error) {
+	ctx = withQueryName(ctx, "MigrationVersion")
+	var exists bool
+	query := `select exists (select from information_schema.tables where table_schema = current_schema() and table_name = 'migrations')`
+	if err := d.DB.GetContext(ctx, &exists, query); err != nil || !exists {
+		return "", err
+	}
+	var version string
+	if err := d.DB.GetContext(ctx, &version, `select coalesce(max(version), '') from migrations`); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// MigrateUp the database to the latest version in fsys.
+func (d *Database) MigrateUp(ctx context.Context, fsys fs.FS) error {
+	ctx = withQueryName(ctx, "MigrateUp")
+	return migrate.Up(ctx, d.DB.DB, fsys)
+}
+
+// MigrateDown the database all the way with the migrations in fsys.
+func (d *Database) MigrateDown(ctx context.Context, fsys fs.FS) error {
+	ctx = withQueryName(ctx, "MigrateDown")
+	return migrate.Down(ctx, d.DB.DB, fsys)
+}
+
+// MigrateTo the version in fsys, up or down.
+func (d *Database) MigrateTo(ctx context.Context, fsys fs.FS, version string) error {
+	ctx = withQueryName(ctx, "MigrateTo")
+	return migrate.To(ctx, d.DB.DB, fsys, version)
+}
+
+// WithAdvisoryLock with the key held while f runs, on a connection of its own, so other callers with the same key,
+// like other replicas starting at the same time, wait for f to return instead of running at the same time.
+// Waiting for the lock stops when ctx is done.
+func (d *Database) WithAdvisoryLock(ctx context.Context, key string, f func(ctx context.Context) error) error {
+	// Only the locking is named, the queries of f have their own names.
+	lockCtx := withQueryName(ctx, "WithAdvisoryLock")
+	conn, err := d.DB.Connx(lockCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(lockCtx, `select pg_advisory_lock(hashtext($1))`, key); err != nil {
+		return fmt.Errorf("error taking advisory lock %v: %w", key, err)
+	}
+	defer func() {
+		// The lock is held by the session, so if unlocking fails, the connection is discarded instead of
+		// going back to the pool still holding it. Unlocking is tried even if ctx is done.
+		if _, err := conn.ExecContext(withQueryName(context.Background(), "WithAdvisoryLock"), `select pg_advisory_unlock(hashtext($1))`, key); err != nil {
+			_ = conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	return f(ctx)
+}
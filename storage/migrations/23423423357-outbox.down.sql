@@ -0,0 +1 @@
+drop table outbox;
\ No newline at end of file
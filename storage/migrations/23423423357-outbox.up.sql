@@ -0,0 +1,9 @@
+create table outbox (
+    id bigserial primary key,
+    message jsonb not null,
+    sent timestamp,
+    created timestamp not null default now()
+);
+
+create index outbox_unsent_idx on outbox (id) where sent is null;
+create index outbox_sent_idx on outbox (sent) where sent is not null;
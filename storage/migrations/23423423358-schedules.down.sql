@@ -0,0 +1 @@
+drop table schedules;
\ No newline at end of file
@@ -0,0 +1,5 @@
+create table schedules (
+    name text primary key,
+    last_run timestamp not null,
+    updated timestamp not null default now()
+);
@@ -0,0 +1 @@
+drop table email_sends;
\ No newline at end of file
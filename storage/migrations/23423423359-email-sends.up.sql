@@ -0,0 +1,11 @@
+create table email_sends (
+    id bigserial primary key,
+    email text not null,
+    type text not null,
+    provider_message_id text not null default '',
+    status text not null,
+    error text not null default '',
+    created timestamp not null default now()
+);
+
+create index email_sends_email_idx on email_sends (email, created);
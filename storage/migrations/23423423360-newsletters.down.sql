@@ -0,0 +1,3 @@
+alter table email_sends drop column newsletter_id;
+drop table newsletter_sends;
+drop table newsletters;
@@ -0,0 +1,19 @@
+create table newsletters (
+    id bigserial primary key,
+    title text not null,
+    body text not null,
+    created timestamp not null default now(),
+    updated timestamp not null default now()
+);
+
+create table newsletter_sends (
+    newsletter_id bigint primary key references newsletters (id) on delete cascade,
+    last_email text not null default '',
+    enqueued int not null default 0,
+    created timestamp not null default now(),
+    updated timestamp not null default now()
+);
+
+alter table email_sends add column newsletter_id bigint references newsletters (id) on delete set null;
+
+create index email_sends_newsletter_idx on email_sends (newsletter_id, email) where newsletter_id is not null;
@@ -0,0 +1 @@
+alter table newsletter_subscribers add column token_created timestamp not null default now();
@@ -0,0 +1,5 @@
+create table throttles (
+    key text primary key,
+    window_index bigint not null,
+    count int not null
+);
@@ -0,0 +1,3 @@
+alter table newsletter_subscribers add column confirmed_at timestamp;
+
+update newsletter_subscribers set confirmed_at = updated where confirmed;
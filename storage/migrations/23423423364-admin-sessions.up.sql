@@ -0,0 +1,7 @@
+create table admin_sessions (
+    id text primary key,
+    created timestamp not null default now(),
+    expires timestamp not null
+);
+
+create index admin_sessions_expires_idx on admin_sessions (expires);
@@ -0,0 +1,9 @@
+create table sessions (
+    id text primary key,
+    data jsonb not null default '{}',
+    created timestamp not null default now(),
+    updated timestamp not null default now(),
+    expires timestamp not null
+);
+
+create index sessions_expires_idx on sessions (expires);
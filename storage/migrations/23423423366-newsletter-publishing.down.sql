@@ -0,0 +1,3 @@
+alter table newsletters
+    drop column slug,
+    drop column published_at;
@@ -0,0 +1,5 @@
+alter table newsletters
+    add column slug text unique,
+    add column published_at timestamp;
+
+create index newsletters_published_at_idx on newsletters (published_at desc) where published_at is not null;
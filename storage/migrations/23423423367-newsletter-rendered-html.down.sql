@@ -0,0 +1 @@
+alter table newsletters drop column rendered_html;
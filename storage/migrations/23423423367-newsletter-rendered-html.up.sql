@@ -0,0 +1 @@
+alter table newsletters add column rendered_html text;
@@ -0,0 +1 @@
+alter table email_sends drop column test;
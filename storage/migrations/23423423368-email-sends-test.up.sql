@@ -0,0 +1 @@
+alter table email_sends add column test bool not null default false;
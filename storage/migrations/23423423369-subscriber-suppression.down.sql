@@ -0,0 +1,3 @@
+alter table newsletter_subscribers drop column transient_bounces;
+alter table newsletter_subscribers drop column suppressed_at;
+alter table newsletter_subscribers drop column suppressed;
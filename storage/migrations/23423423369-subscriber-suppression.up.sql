@@ -0,0 +1,3 @@
+alter table newsletter_subscribers add column suppressed text;
+alter table newsletter_subscribers add column suppressed_at timestamp;
+alter table newsletter_subscribers add column transient_bounces int not null default 0;
@@ -0,0 +1,3 @@
+drop table audit_events;
+alter table newsletter_subscribers drop column deleted;
+alter table newsletter_subscribers drop column id;
@@ -0,0 +1,13 @@
+alter table newsletter_subscribers add column id bigserial unique;
+alter table newsletter_subscribers add column deleted timestamp;
+
+create table audit_events (
+    id bigserial primary key,
+    actor text not null,
+    action text not null,
+    target text not null,
+    details jsonb not null default '{}',
+    created timestamp not null default now()
+);
+
+create index audit_events_target_idx on audit_events (target, created);
@@ -0,0 +1,2 @@
+drop table email_opens;
+alter table newsletter_subscribers drop column tracking_opt_out;
@@ -0,0 +1,10 @@
+alter table newsletter_subscribers add column tracking_opt_out bool not null default false;
+
+create table email_opens (
+    newsletter_id bigint not null,
+    subscriber_id bigint not null,
+    opens int not null default 1,
+    first_opened timestamp not null,
+    last_opened timestamp not null,
+    primary key (newsletter_id, subscriber_id)
+);
@@ -0,0 +1,9 @@
+create table email_clicks (
+    newsletter_id bigint not null,
+    subscriber_id bigint not null,
+    url text not null,
+    clicks int not null default 1,
+    first_clicked timestamp not null,
+    last_clicked timestamp not null,
+    primary key (newsletter_id, subscriber_id, url)
+);
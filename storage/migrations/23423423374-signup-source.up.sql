@@ -0,0 +1 @@
+alter table newsletter_subscribers add column source text not null default '';
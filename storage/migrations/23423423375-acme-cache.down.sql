@@ -0,0 +1 @@
+drop table acme_cache;
@@ -0,0 +1,5 @@
+create table acme_cache (
+    key text primary key,
+    data bytea not null,
+    updated timestamptz not null default now()
+);
@@ -0,0 +1 @@
+alter table newsletter_subscribers drop column welcomed_at;
@@ -0,0 +1,4 @@
+alter table newsletter_subscribers add column welcomed_at timestamptz;
+
+-- Subscribers that confirmed before there were welcome emails don't get one later.
+update newsletter_subscribers set welcomed_at = coalesce(confirmed_at, now()) where confirmed;
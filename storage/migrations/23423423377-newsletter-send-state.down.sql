@@ -0,0 +1,8 @@
+alter table newsletter_sends
+    drop column state,
+    drop column total,
+    drop column enqueue_failed,
+    drop column error,
+    drop column queued,
+    drop column fanned_out,
+    drop column finished;
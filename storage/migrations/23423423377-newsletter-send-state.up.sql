@@ -0,0 +1,13 @@
+-- Sends from before there was a state were started by hand, and are taken to be done.
+alter table newsletter_sends
+    add column state text not null default 'completed' check (state in ('queued', 'sending', 'completed', 'failed')),
+    add column total int not null default 0,
+    add column enqueue_failed int not null default 0,
+    add column error text not null default '',
+    add column queued timestamp not null default now(),
+    add column fanned_out timestamp,
+    add column finished timestamp;
+
+update newsletter_sends set queued = created, fanned_out = updated, finished = updated, total = enqueued;
+
+alter table newsletter_sends alter column state set default 'queued';
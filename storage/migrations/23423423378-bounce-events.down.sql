@@ -0,0 +1,5 @@
+alter table newsletter_subscribers drop column bounces_reset_at;
+alter table newsletter_subscribers drop column suppressed_bounce;
+alter table newsletter_subscribers add column transient_bounces int not null default 0;
+
+drop table bounce_events;
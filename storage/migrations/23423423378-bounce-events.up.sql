@@ -0,0 +1,20 @@
+create table bounce_events (
+    id bigserial primary key,
+    email text not null,
+    type text not null check (type in ('permanent', 'transient')),
+    provider_message_id text not null default '',
+    created timestamp not null default now()
+);
+
+-- For the rolling count of transient bounces of an address.
+create index bounce_events_transient_idx on bounce_events (email, created) where type = 'transient';
+
+-- The counter had no window, so it's replaced by counting bounce events.
+alter table newsletter_subscribers drop column transient_bounces;
+
+-- Which kind of bounce suppressed the subscriber. Subscribers suppressed for bounces before it was recorded
+-- don't have it, and are treated like permanent bounces, so they stay suppressed when signing up again.
+alter table newsletter_subscribers add column suppressed_bounce text check (suppressed_bounce in ('permanent', 'transient'));
+
+-- Transient bounces before this don't count, because the subscriber was reactivated by signing up again.
+alter table newsletter_subscribers add column bounces_reset_at timestamp;
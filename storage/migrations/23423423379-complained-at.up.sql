@@ -0,0 +1,5 @@
+-- When the subscriber marked an email as spam. Unlike other suppressions, it blocks signing up again,
+-- until an admin clears it.
+alter table newsletter_subscribers add column complained_at timestamp;
+
+update newsletter_subscribers set complained_at = suppressed_at where suppressed = 'complained';
@@ -0,0 +1,3 @@
+drop table suppressions;
+
+drop function email_hash(text);
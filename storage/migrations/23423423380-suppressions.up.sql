@@ -0,0 +1,23 @@
+-- email_hash of an address, so suppressions can be kept without keeping the address, like after deleting a subscriber.
+create function email_hash(email text) returns text as $$
+    select encode(sha256(convert_to(lower(email), 'UTF8')), 'hex')
+$$ language sql immutable;
+
+create table suppressions (
+    id bigserial primary key,
+    email_hash text not null unique,
+    reason text not null check (reason in ('bounced', 'complained', 'unsubscribed', 'manual')),
+    -- source is who added it, like the email provider, the subscriber, or an admin.
+    source text not null,
+    created timestamp not null default now()
+);
+
+insert into suppressions (email_hash, reason, source, created)
+select email_hash(email), suppressed, 'email_provider', coalesce(suppressed_at, now())
+from newsletter_subscribers
+where suppressed is not null;
+
+insert into suppressions (email_hash, reason, source, created)
+select email_hash(email), 'unsubscribed', 'subscriber', updated
+from newsletter_subscribers
+where not active and suppressed is null;
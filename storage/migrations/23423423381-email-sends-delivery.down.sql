@@ -0,0 +1,7 @@
+drop index email_sends_created_idx;
+drop index email_sends_provider_message_id_idx;
+
+alter table email_sends
+    drop column updated,
+    drop column delivery,
+    drop column subscriber_id;
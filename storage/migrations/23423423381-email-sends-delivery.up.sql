@@ -0,0 +1,14 @@
+-- delivery is what the email provider reported about a sent email later, correlated by provider_message_id.
+alter table email_sends
+    add column subscriber_id bigint references newsletter_subscribers (id) on delete set null,
+    add column delivery text not null default '' check (delivery in ('', 'delivered', 'bounced', 'complained')),
+    add column updated timestamp not null default now();
+
+update email_sends set updated = created;
+
+update email_sends e set subscriber_id = s.id
+from newsletter_subscribers s
+where s.email = e.email;
+
+create index email_sends_provider_message_id_idx on email_sends (provider_message_id) where provider_message_id <> '';
+create index email_sends_created_idx on email_sends (created);
@@ -0,0 +1,13 @@
+-- api_tokens for the admin API. Only the SHA-256 hash of a token is stored, and the lookup part of it,
+-- to find the token to compare the hash of.
+-- scopes are separated by spaces, like in OAuth.
+create table api_tokens (
+    id bigserial primary key,
+    name text not null,
+    lookup text not null unique,
+    hash text not null,
+    scopes text not null,
+    last_used_at timestamp,
+    expires_at timestamp,
+    created timestamp not null default now()
+);
@@ -0,0 +1,3 @@
+alter table newsletter_sends drop column segment;
+
+drop table subscriber_tags;
@@ -0,0 +1,13 @@
+-- subscriber_tags for sending newsletter issues to a segment of the subscribers, by an expression of tags.
+-- The index on tag is for finding the subscribers with a tag, which segments are evaluated with.
+create table subscriber_tags (
+    subscriber_id bigint not null references newsletter_subscribers (id) on delete cascade,
+    tag text not null check (tag ~ '^[a-z0-9][a-z0-9_-]{0,49}$'),
+    created timestamp not null default now(),
+    primary key (subscriber_id, tag)
+);
+
+create index subscriber_tags_tag on subscriber_tags (tag, subscriber_id);
+
+-- segment of the send, as a segment expression, or empty for every confirmed subscriber.
+alter table newsletter_sends add column segment text not null default '';
@@ -0,0 +1,3 @@
+alter table newsletters
+    drop column scheduled_segment,
+    drop column scheduled_at;
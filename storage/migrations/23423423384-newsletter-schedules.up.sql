@@ -0,0 +1,7 @@
+-- scheduled_at is when a scheduled issue is published and sent, in UTC, to the subscribers in scheduled_segment.
+-- It's cleared once the issue is sent. The index is for finding the issues that are due.
+alter table newsletters
+    add column scheduled_at timestamp,
+    add column scheduled_segment text not null default '';
+
+create index newsletters_scheduled_at_idx on newsletters (scheduled_at) where scheduled_at is not null;
@@ -0,0 +1,42 @@
+-- webhook_endpoints get the domain events of their event_types, separated by spaces, POSTed and signed with secret,
+-- which is kept as is, since signing needs it. consecutive_failures counts the deliveries that failed in a row,
+-- and disables the endpoint after too many, with the reason in disabled_reason.
+create table webhook_endpoints (
+    id bigserial primary key,
+    url text not null,
+    secret text not null,
+    event_types text not null,
+    enabled boolean not null default true,
+    consecutive_failures int not null default 0,
+    disabled_reason text not null default '',
+    created timestamp not null default now(),
+    updated timestamp not null default now()
+);
+
+-- webhook_deliveries of an event to an endpoint, with the JSON of the event in payload,
+-- which is pending until it's delivered, it failed, or it was skipped, like for a disabled endpoint.
+create table webhook_deliveries (
+    id bigserial primary key,
+    endpoint_id bigint not null references webhook_endpoints (id) on delete cascade,
+    event_id text not null,
+    event_type text not null,
+    payload text not null,
+    state text not null default 'pending',
+    attempts int not null default 0,
+    created timestamp not null default now(),
+    updated timestamp not null default now()
+);
+
+create index webhook_deliveries_endpoint_id_created_idx on webhook_deliveries (endpoint_id, created);
+
+-- webhook_delivery_attempts for the delivery log, with the status code of the response, or 0 and the error without one.
+create table webhook_delivery_attempts (
+    id bigserial primary key,
+    delivery_id bigint not null references webhook_deliveries (id) on delete cascade,
+    status_code int not null,
+    error text not null default '',
+    duration_ms int not null,
+    created timestamp not null default now()
+);
+
+create index webhook_delivery_attempts_delivery_id_idx on webhook_delivery_attempts (delivery_id);
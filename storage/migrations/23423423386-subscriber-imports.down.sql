@@ -0,0 +1,2 @@
+drop table subscriber_import_rows;
+drop table subscriber_imports;
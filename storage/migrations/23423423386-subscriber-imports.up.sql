@@ -0,0 +1,29 @@
+-- subscriber_imports of uploaded CSV files, with the header of the file each field of the subscribers is in,
+-- and what to do with the addresses of existing subscribers in duplicates. They're uploaded until an admin
+-- confirms the preview, and then queued, importing, and completed, or failed with the error of the import job.
+create table subscriber_imports (
+    id bigserial primary key,
+    filename text not null,
+    state text not null default 'uploaded',
+    duplicates text not null default 'skip',
+    email_column text not null,
+    locale_column text not null default '',
+    tags_column text not null default '',
+    error text not null default '',
+    created timestamp not null default now(),
+    updated timestamp not null default now()
+);
+
+-- subscriber_import_rows of the files, by their line in the file, with the tags separated by spaces.
+-- result is null until the row is imported, and then added, updated, skipped, or failed, with the error.
+-- Rows that aren't valid fail when they're uploaded.
+create table subscriber_import_rows (
+    import_id bigint not null references subscriber_imports (id) on delete cascade,
+    line int not null,
+    email text not null,
+    locale text not null default '',
+    tags text not null default '',
+    result text,
+    error text not null default '',
+    primary key (import_id, line)
+);
@@ -0,0 +1 @@
+drop table admin_sessions;
@@ -0,0 +1,3 @@
+drop index throttles_expires_idx;
+
+alter table throttles drop column expires;
@@ -0,0 +1,6 @@
+-- The keys had email addresses and IP addresses in them, and are hashed from now on, so the old windows are dropped.
+delete from throttles;
+
+alter table throttles add column expires timestamp not null;
+
+create index throttles_expires_idx on throttles (expires);
@@ -0,0 +1,145 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/storage"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"2-b.up.sql":   {},
+		"2-b.down.sql": {},
+		"1-a.up.sql":   {},
+		"1-a.down.sql": {},
+		"3-c.up.sql":   {},
+		"3-c.down.sql": {},
+		"README.md":    {},
+	}
+
+	t.Run("returns all migrations in order without a current version", func(t *testing.T) {
+		is := is.New(t)
+
+		pending, err := storage.PendingMigrations(fsys, "")
+		is.NoErr(err)
+		is.Equal([]string{"1-a", "2-b", "3-c"}, pending)
+	})
+
+	t.Run("returns the migrations after the current version", func(t *testing.T) {
+		is := is.New(t)
+
+		pending, err := storage.PendingMigrations(fsys, "2-b")
+		is.NoErr(err)
+		is.Equal([]string{"3-c"}, pending)
+	})
+
+	t.Run("returns nothing at the latest version", func(t *testing.T) {
+		is := is.New(t)
+
+		pending, err := storage.PendingMigrations(fsys, "3-c")
+		is.NoErr(err)
+		is.Equal(0, len(pending))
+	})
+
+	t.Run("has nothing pending for the embedded migrations at the latest version", func(t *testing.T) {
+		is := is.New(t)
+
+		all, err := storage.PendingMigrations(storage.Migrations(), "")
+		is.NoErr(err)
+		is.True(len(all) > 0)
+
+		pending, err := storage.PendingMigrations(storage.Migrations(), all[len(all)-1])
+		is.NoErr(err)
+		is.Equal(0, len(pending))
+	})
+}
+
+func TestDatabase_MigrationVersion(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("is the latest migration after migrating up", func(t *testing.T) {
+		is := is.New(t)
+
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		all, err := storage.PendingMigrations(storage.Migrations(), "")
+		is.NoErr(err)
+
+		version, err := db.MigrationVersion(context.Background())
+		is.NoErr(err)
+		is.Equal(all[len(all)-1], version)
+	})
+}
+
+func TestDatabase_WithAdvisoryLock(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("makes a second caller with the same key wait until the first is done", func(t *testing.T) {
+		is := is.New(t)
+
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		locked := make(chan struct{})
+		release := make(chan struct{})
+		var order []string
+		var lock sync.Mutex
+		record := func(s string) {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, s)
+		}
+
+		errs := make(chan error, 2)
+		go func() {
+			errs <- db.WithAdvisoryLock(context.Background(), "test", func(ctx context.Context) error {
+				close(locked)
+				<-release
+				record("first")
+				return nil
+			})
+		}()
+		<-locked
+		go func() {
+			errs <- db.WithAdvisoryLock(context.Background(), "test", func(ctx context.Context) error {
+				record("second")
+				return nil
+			})
+		}()
+
+		// The second caller can't get the lock while the first holds it.
+		time.Sleep(100 * time.Millisecond)
+		record("released")
+		close(release)
+		is.NoErr(<-errs)
+		is.NoErr(<-errs)
+		is.Equal([]string{"released", "first", "second"}, order)
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		is := is.New(t)
+
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.WithAdvisoryLock(context.Background(), "test", func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			return db.WithAdvisoryLock(ctx, "test", func(ctx context.Context) error {
+				return errors.New("shouldn't get the lock")
+			})
+		})
+		is.True(err != nil)
+		is.True(errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "canceling statement"))
+	})
+}
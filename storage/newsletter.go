@@ -3,25 +3,237 @@ package storage
 import (
 	"context"
 	"crypto/rand"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"time"
 
+	"github.com/jmoiron/sqlx"
+
+	"canvas/messaging"
 	"canvas/model"
 )
 
+// ErrComplained is returned when signing up an address that complained about an email, which is blocked.
+var ErrComplained = errors.New("complained")
+
 // SignupForNewsletter with the given email. Returns a token used for confirming the email address.
-func (d *Database) SignupForNewsletter(ctx context.Context, email model.Email) (string, error) {
+// The confirmation email job is enqueued through the outbox in the same transaction, with the subscriber.signed_up event.
+// Signing up again after unsubscribing makes the subscriber active again, but they have to confirm again.
+// The locale the subscriber signed up in is stored, and emails to them are in it.
+// Signing up again after being deleted in the admin starts over, with confirming again.
+// The source is where the signup came from, like the origin of a partner site with the embedded signup form.
+// It's empty for signups on this site.
+// Subscribers suppressed for transient bounces are reactivated by signing up again, which is recorded in the audit log,
+// and their earlier transient bounces don't count anymore. Subscribers suppressed for permanent bounces or complaints
+// stay suppressed, so the confirmation email isn't sent to them. Subscribers that complained can't sign up again
+// until an admin clears the complaint, and get ErrComplained, without anything being changed or sent.
+// Signing up takes the address off the suppression list if it was there for unsubscribing, or for the transient
+// bounces it's reactivated after. Other suppressions, like ones added in the admin, stay.
+func (d *Database) SignupForNewsletter(ctx context.Context, email model.Email, locale, source string) (string, error) {
+	ctx = withQueryName(ctx, "SignupForNewsletter")
 	token, err := createSecret()
 	if err != nil {
 		return "", err
 	}
 	query := `
-		insert into newsletter_subscribers (email, token)
-		values ($1, $2)
+		insert into newsletter_subscribers (email, token, locale, source)
+		values ($1, $2, $3, $4)
 		on conflict (email) do update set
+			active = true,
+			confirmed = newsletter_subscribers.confirmed and newsletter_subscribers.active and newsletter_subscribers.deleted is null,
+			confirmed_at = case when newsletter_subscribers.active and newsletter_subscribers.deleted is null then newsletter_subscribers.confirmed_at end,
+			welcomed_at = case when newsletter_subscribers.deleted is null then newsletter_subscribers.welcomed_at end,
+			deleted = null,
 			token = excluded.token,
-			updated = now()`
-	_, err = d.DB.ExecContext(ctx, query, email, token)
-	return token, err
+			locale = excluded.locale,
+			source = excluded.source,
+			token_created = now(),
+			suppressed = case when newsletter_subscribers.suppressed_bounce = 'transient' then null else newsletter_subscribers.suppressed end,
+			suppressed_at = case when newsletter_subscribers.suppressed_bounce = 'transient' then null else newsletter_subscribers.suppressed_at end,
+			suppressed_bounce = case when newsletter_subscribers.suppressed_bounce = 'transient' then null else newsletter_subscribers.suppressed_bounce end,
+			bounces_reset_at = case when newsletter_subscribers.suppressed_bounce = 'transient' then now() else newsletter_subscribers.bounces_reset_at end,
+			updated = now()
+		returning id`
+	err = d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var existing struct {
+			Complained  bool
+			Reactivated bool
+		}
+		err := tx.GetContext(ctx, &existing, `
+			select complained_at is not null as complained, coalesce(suppressed_bounce = 'transient', false) as reactivated
+			from newsletter_subscribers
+			where email = $1
+			for update`, email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if existing.Complained {
+			return ErrComplained
+		}
+
+		var id int64
+		if err := tx.GetContext(ctx, &id, query, email, token, locale, source); err != nil {
+			return err
+		}
+		if err := deleteSuppression(ctx, tx, email, model.SuppressionReasonUnsubscribed); err != nil {
+			return err
+		}
+		if existing.Reactivated {
+			if err := deleteSuppression(ctx, tx, email, model.SuppressionReasonBounced); err != nil {
+				return err
+			}
+			err := insertAuditEvent(ctx, tx, AuditActorSubscriber, "subscriber.reactivate", fmt.Sprintf("subscriber/%v", id),
+				map[string]string{"email": email.String(), "reason": "signed up again after transient bounces"})
+			if err != nil {
+				return err
+			}
+		}
+
+		m, err := messaging.NewMessage(model.ConfirmationEmailRequested{Email: email, Token: token, Locale: locale})
+		if err != nil {
+			return err
+		}
+		if err := EnqueueInTx(ctx, tx, m); err != nil {
+			return err
+		}
+		return emitInTx(ctx, tx, model.SubscriberSignedUp{Email: email, Locale: locale, Source: source})
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResendConfirmation to the subscriber with the given email, if they're waiting to be confirmed.
+// The token is replaced, so it's valid for the whole ConfirmationTokenLifetime again, and the confirmation email job
+// is enqueued through the outbox in the same transaction, in the locale the subscriber signed up in. Returns false without resending for addresses that
+// never signed up, are already confirmed, have unsubscribed, or are suppressed.
+func (d *Database) ResendConfirmation(ctx context.Context, email model.Email) (bool, error) {
+	ctx = withQueryName(ctx, "ResendConfirmation")
+	token, err := createSecret()
+	if err != nil {
+		return false, err
+	}
+	var resent bool
+	query := `
+		update newsletter_subscribers
+		set token = $2, token_created = now(), updated = now()
+		where email = $1 and active and not confirmed and suppressed is null and deleted is null
+		returning locale`
+	err = d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var locale string
+		if err := tx.GetContext(ctx, &locale, query, email, token); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		resent = true
+		m, err := messaging.NewMessage(model.ConfirmationEmailRequested{Email: email, Token: token, Locale: locale})
+		if err != nil {
+			return err
+		}
+		return EnqueueInTx(ctx, tx, m)
+	})
+	return resent, err
+}
+
+// IsSubscribed is true if the email address is a confirmed, active subscriber that isn't suppressed.
+func (d *Database) IsSubscribed(ctx context.Context, email model.Email) (bool, error) {
+	ctx = withQueryName(ctx, "IsSubscribed")
+	var subscribed bool
+	query := `
+		select exists (
+			select from newsletter_subscribers where email = $1 and confirmed and active and suppressed is null and deleted is null
+		)`
+	err := d.DB.GetContext(ctx, &subscribed, query, email)
+	return subscribed, err
+}
+
+// ConfirmationTokenLifetime is how long a confirmation token is valid after signing up.
+const ConfirmationTokenLifetime = 7 * 24 * time.Hour
+
+// ConfirmNewsletterSignup of the subscriber with the given token from the confirmation email.
+// The token stays valid after confirming, so following the link again gives ConfirmationResultAlreadyConfirmed.
+// Confirming emits the subscriber.confirmed event through the outbox, in the locale the subscriber signed up in.
+// The first confirmation is recorded in welcomed_at in the same transaction, and only its event has FirstTime,
+// so confirming again, like after unsubscribing and signing up again, doesn't send another welcome email.
+func (d *Database) ConfirmNewsletterSignup(ctx context.Context, token string) (model.ConfirmationResult, error) {
+	ctx = withQueryName(ctx, "ConfirmNewsletterSignup")
+	var result model.ConfirmationResult
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var s struct {
+			Email     model.Email
+			Confirmed bool
+			Expired   bool
+			Locale    string
+			Welcomed  bool
+		}
+		query := `
+			select email, confirmed, token_created < now() - make_interval(secs => $2) as expired, locale,
+				welcomed_at is not null as welcomed
+			from newsletter_subscribers
+			where token = $1 and deleted is null
+			for update`
+		if err := tx.GetContext(ctx, &s, query, token, ConfirmationTokenLifetime.Seconds()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				result = model.ConfirmationResultUnknownToken
+				return nil
+			}
+			return err
+		}
+
+		switch {
+		case s.Confirmed:
+			result = model.ConfirmationResultAlreadyConfirmed
+			return nil
+		case s.Expired:
+			result = model.ConfirmationResultExpired
+			return nil
+		}
+
+		query = `
+			update newsletter_subscribers
+			set confirmed = true, confirmed_at = now(), welcomed_at = coalesce(welcomed_at, now()), updated = now()
+			where email = $1`
+		if _, err := tx.ExecContext(ctx, query, s.Email); err != nil {
+			return err
+		}
+		result = model.ConfirmationResultConfirmed
+		return emitInTx(ctx, tx, model.SubscriberConfirmed{Email: s.Email, Locale: s.Locale, FirstTime: strconv.FormatBool(!s.Welcomed)})
+	})
+	return result, err
+}
+
+// Unsubscribe the subscriber with the given email from the newsletter, and add them to the suppression list
+// until they sign up again. Unsubscribing an active subscriber emits the subscriber.unsubscribed event through the outbox.
+// Unsubscribing an address that's already unsubscribed, or that never signed up, is not an error.
+func (d *Database) Unsubscribe(ctx context.Context, email model.Email) error {
+	ctx = withQueryName(ctx, "Unsubscribe")
+	query := `
+		update newsletter_subscribers s
+		set active = false, updated = now()
+		from newsletter_subscribers before
+		where s.email = $1 and before.id = s.id
+		returning before.active`
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var wasActive bool
+		if err := tx.GetContext(ctx, &wasActive, query, email); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		if err := insertSuppression(ctx, tx, email, model.SuppressionReasonUnsubscribed, AuditActorSubscriber); err != nil {
+			return err
+		}
+		if !wasActive {
+			return nil
+		}
+		return emitInTx(ctx, tx, model.SubscriberUnsubscribed{Email: email, Actor: AuditActorSubscriber})
+	})
 }
 
 func createSecret() (string, error) {
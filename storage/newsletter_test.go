@@ -7,17 +7,19 @@ import (
 	"github.com/matryer/is"
 
 	"canvas/integrationtest"
+	"canvas/model"
+	"canvas/storage"
 )
 
 func TestDatabase_SignupForNewsletter(t *testing.T) {
 	integrationtest.SkipIfShort(t)
 
-	t.Run("signs up", func(t *testing.T) {
+	t.Run("signs up and enqueues the confirmation email in the outbox, in the latest signup locale", func(t *testing.T) {
 		is := is.New(t)
 		db, cleanup := integrationtest.CreateDatabase()
 		defer cleanup()
 
-		expectedToken, err := db.SignupForNewsletter(context.Background(), "me@example.com")
+		expectedToken, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
 		is.NoErr(err)
 		is.Equal(64, len(expectedToken))
 
@@ -27,13 +29,263 @@ func TestDatabase_SignupForNewsletter(t *testing.T) {
 		is.Equal("me@example.com", email)
 		is.Equal(expectedToken, token)
 
-		expectedToken2, err := db.SignupForNewsletter(context.Background(), "me@example.com")
+		expectedToken2, err := db.SignupForNewsletter(context.Background(), "me@example.com", "fr", "")
 		is.NoErr(err)
 		is.True(expectedToken != expectedToken2)
 
-		err = db.DB.QueryRow(`select email, token from newsletter_subscribers`).Scan(&email, &token)
+		var locale string
+		err = db.DB.QueryRow(`select email, token, locale from newsletter_subscribers`).Scan(&email, &token, &locale)
 		is.NoErr(err)
 		is.Equal("me@example.com", email)
 		is.Equal(expectedToken2, token)
+		is.Equal("fr", locale)
+
+		ms := outboxMessages(is, db, "confirmation_email")
+		is.Equal(2, len(ms))
+		is.Equal(model.Message{"job": "confirmation_email", "email": "me@example.com", "token": expectedToken, "locale": "en"}, ms[0])
+		is.Equal(model.Message{"job": "confirmation_email", "email": "me@example.com", "token": expectedToken2, "locale": "fr"}, ms[1])
+	})
+
+	t.Run("makes an unsubscribed subscriber active and unconfirmed again, and keeps active ones confirmed", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		type row struct {
+			Active      bool
+			Confirmed   bool
+			ConfirmedAt bool `db:"confirmed_at"`
+			WelcomedAt  bool `db:"welcomed_at"`
+		}
+		get := func() row {
+			var r row
+			err := db.DB.Get(&r, `select active, confirmed, confirmed_at is not null as confirmed_at,
+				welcomed_at is not null as welcomed_at from newsletter_subscribers where email = 'me@example.com'`)
+			is.NoErr(err)
+			return r
+		}
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		is.Equal(row{Active: true, Confirmed: true, ConfirmedAt: true, WelcomedAt: true}, get())
+
+		is.NoErr(db.Unsubscribe(context.Background(), "me@example.com"))
+		is.Equal(row{Active: false, Confirmed: true, ConfirmedAt: true, WelcomedAt: true}, get())
+
+		token, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		is.Equal(row{Active: true, Confirmed: false, ConfirmedAt: false, WelcomedAt: true}, get())
+
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultConfirmed, result)
+		is.Equal(row{Active: true, Confirmed: true, ConfirmedAt: true, WelcomedAt: true}, get())
+	})
+
+	t.Run("stores the source of the latest signup", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "https://partner.example.com")
+		is.NoErr(err)
+		_, err = db.SignupForNewsletter(context.Background(), "you@example.com", "en", "")
+		is.NoErr(err)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(2, len(subscribers))
+		is.Equal("https://partner.example.com", subscribers[0].Source)
+		is.Equal("", subscribers[1].Source)
+	})
+}
+
+func TestDatabase_ResendConfirmation(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("replaces the token and enqueues the confirmation email for pending subscribers only", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		resent, err := db.ResendConfirmation(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!resent)
+
+		oldToken, err := db.SignupForNewsletter(context.Background(), "me@example.com", "de", "")
+		is.NoErr(err)
+
+		resent, err = db.ResendConfirmation(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(resent)
+
+		var token string
+		err = db.DB.QueryRow(`select token from newsletter_subscribers`).Scan(&token)
+		is.NoErr(err)
+		is.True(token != oldToken)
+
+		ms := outboxMessages(is, db, "confirmation_email")
+		is.Equal(2, len(ms))
+		is.Equal(token, ms[1]["token"])
+		is.Equal("de", ms[1]["locale"])
+
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultConfirmed, result)
+
+		resent, err = db.ResendConfirmation(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!resent)
+	})
+}
+
+func TestDatabase_IsSubscribed(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("is true for confirmed, active subscribers only, and after signing up again after unsubscribing", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		subscribed, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribed, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		subscribed, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(subscribed)
+
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+		subscribed, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		token, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribed, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultConfirmed, result)
+		subscribed, err = db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(subscribed)
+	})
+}
+
+func TestDatabase_ConfirmNewsletterSignup(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("confirms once, then reports already confirmed", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultConfirmed, result)
+
+		result, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultAlreadyConfirmed, result)
+	})
+
+	t.Run("emits the confirmed event as the first time once, even when confirming again after signing up again", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "fr", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+		token, err = db.SignupForNewsletter(context.Background(), "me@example.com", "fr", "")
+		is.NoErr(err)
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultConfirmed, result)
+
+		confirmations := outboxMessages(is, db, model.EventSubscriberConfirmed)
+		is.Equal(2, len(confirmations))
+		is.Equal("true", confirmations[0]["firstTime"])
+		is.Equal("me@example.com", confirmations[0]["email"])
+		is.Equal("fr", confirmations[0]["locale"])
+		is.Equal("false", confirmations[1]["firstTime"])
+	})
+
+	t.Run("reports expired and unknown tokens", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.DB.Exec(`update newsletter_subscribers set token_created = now() - interval '8 days'`)
+		is.NoErr(err)
+
+		result, err := db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultExpired, result)
+
+		result, err = db.ConfirmNewsletterSignup(context.Background(), "doesnotexist")
+		is.NoErr(err)
+		is.Equal(model.ConfirmationResultUnknownToken, result)
+	})
+}
+
+func TestDatabase_Unsubscribe(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("deactivates the subscriber, and does nothing the second time", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+
+		var active bool
+		err = db.DB.QueryRow(`select active from newsletter_subscribers where email = 'me@example.com'`).Scan(&active)
+		is.NoErr(err)
+		is.True(!active)
+
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+	})
+
+	t.Run("does not error for an address that never signed up", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.Unsubscribe(context.Background(), "doesnotexist@example.com")
+		is.NoErr(err)
 	})
 }
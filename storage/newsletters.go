@@ -0,0 +1,459 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/content"
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// GetNewsletter by ID. Returns nil if there is no such newsletter.
+func (d *Database) GetNewsletter(ctx context.Context, id int64) (*model.Newsletter, error) {
+	ctx = withQueryName(ctx, "GetNewsletter")
+	var n model.Newsletter
+	query := `
+		select id, title, body, coalesce(rendered_html, '') as renderedhtml, scheduled_at as scheduledat,
+			scheduled_segment as scheduledsegment, created, updated
+		from newsletters
+		where id = $1`
+	if err := d.DB.GetContext(ctx, &n, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CreateNewsletter draft with the title and Markdown body, caching the body rendered as HTML.
+func (d *Database) CreateNewsletter(ctx context.Context, title, body string) (*model.Newsletter, error) {
+	ctx = withQueryName(ctx, "CreateNewsletter")
+	n := model.Newsletter{Title: title, Body: body, RenderedHTML: content.MarkdownToHTML(body)}
+	query := `
+		insert into newsletters (title, body, rendered_html) values ($1, $2, $3)
+		returning id, created, updated`
+	if err := d.DB.GetContext(ctx, &n, query, n.Title, n.Body, n.RenderedHTML); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// ErrNotEditable is returned when changing a newsletter that's being sent or has been sent,
+// so every subscriber gets the same issue.
+var ErrNotEditable = errors.New("not editable")
+
+// UpdateNewsletter title and Markdown body by ID, rendering the body as HTML again.
+// Returns ErrNotFound if there's no such newsletter, and ErrNotEditable if a send of it has been queued.
+func (d *Database) UpdateNewsletter(ctx context.Context, id int64, title, body string) error {
+	ctx = withQueryName(ctx, "UpdateNewsletter")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		if err := lockEditableNewsletter(ctx, tx, id); err != nil {
+			return err
+		}
+		query := `update newsletters set title = $2, body = $3, rendered_html = $4, updated = now() where id = $1`
+		_, err := tx.ExecContext(ctx, query, id, title, body, content.MarkdownToHTML(body))
+		return err
+	})
+}
+
+// lockEditableNewsletter by ID for the rest of the transaction, so a send can't be queued while it's changed.
+// Returns ErrNotFound if there's no such newsletter, and ErrNotEditable if a send of it has been queued.
+func lockEditableNewsletter(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var sent bool
+	query := `
+		select exists (select from newsletter_sends s where s.newsletter_id = n.id)
+		from newsletters n
+		where id = $1
+		for update of n`
+	if err := tx.GetContext(ctx, &sent, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if sent {
+		return ErrNotEditable
+	}
+	return nil
+}
+
+// ListPublishedNewslettersOptions for ListPublishedNewsletters.
+type ListPublishedNewslettersOptions struct {
+	// Limit of newsletters to list. All published newsletters are listed if zero.
+	Limit  int
+	Offset int
+}
+
+// ListPublishedNewsletters, newest first. Drafts, and newsletters set to be published in the future, aren't listed.
+func (d *Database) ListPublishedNewsletters(ctx context.Context, opts ListPublishedNewslettersOptions) ([]model.Newsletter, error) {
+	ctx = withQueryName(ctx, "ListPublishedNewsletters")
+	var newsletters []model.Newsletter
+	query := `
+		select id, title, slug, body, coalesce(rendered_html, '') as renderedhtml, published_at as publishedat, created, updated
+		from newsletters
+		where published_at <= now() and slug is not null
+		order by published_at desc, id desc
+		limit case when $1 > 0 then $1 end
+		offset $2`
+	err := d.DB.SelectContext(ctx, &newsletters, query, opts.Limit, opts.Offset)
+	return newsletters, err
+}
+
+// GetPublishedNewsletter by slug. Returns ErrNotFound if there's no such newsletter,
+// or if it's a draft or set to be published in the future.
+func (d *Database) GetPublishedNewsletter(ctx context.Context, slug string) (*model.Newsletter, error) {
+	ctx = withQueryName(ctx, "GetPublishedNewsletter")
+	var n model.Newsletter
+	query := `
+		select id, title, slug, body, coalesce(rendered_html, '') as renderedhtml, published_at as publishedat, created, updated
+		from newsletters
+		where slug = $1 and published_at <= now()`
+	if err := d.DB.GetContext(ctx, &n, query, slug); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &n, nil
+}
+
+// publishNewsletterInTx by ID in the archive right away, with a unique slug created from the title.
+// Publishing an already published newsletter keeps its slug and publication time.
+// Returns ErrNotFound if there's no such newsletter.
+func publishNewsletterInTx(ctx context.Context, tx *sqlx.Tx, id int64) (model.Newsletter, error) {
+	var n model.Newsletter
+	query := `
+		select id, title, coalesce(slug, '') as slug, body, coalesce(rendered_html, '') as renderedhtml, published_at as publishedat,
+			created, updated
+		from newsletters
+		where id = $1
+		for update`
+	if err := tx.GetContext(ctx, &n, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return n, ErrNotFound
+		}
+		return n, err
+	}
+
+	if n.Slug == "" {
+		slug := model.CreateSlug(n.Title)
+		var taken []string
+		query = `select slug from newsletters where slug = $1 or slug like $1 || '-%'`
+		if err := tx.SelectContext(ctx, &taken, query, slug); err != nil {
+			return n, err
+		}
+		n.Slug = model.UniqueSlug(slug, taken)
+	}
+
+	query = `
+		update newsletters set slug = $2, published_at = coalesce(published_at, now()), updated = now()
+		where id = $1
+		returning published_at as publishedat, updated`
+	err := tx.GetContext(ctx, &n, query, id, n.Slug)
+	return n, err
+}
+
+// GetNewsletterSendCheckpoint for the newsletter, which is the email address of the last subscriber
+// the newsletter was enqueued for. Returns the empty string if sending hasn't started.
+func (d *Database) GetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64) (model.Email, error) {
+	ctx = withQueryName(ctx, "GetNewsletterSendCheckpoint")
+	var lastEmail model.Email
+	query := `select last_email from newsletter_sends where newsletter_id = $1`
+	err := d.DB.GetContext(ctx, &lastEmail, query, newsletterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return lastEmail, err
+}
+
+// SetNewsletterSendCheckpoint for the newsletter, adding enqueued to the count of enqueued emails,
+// and enqueueFailed to the count of emails that couldn't be enqueued.
+func (d *Database) SetNewsletterSendCheckpoint(ctx context.Context, newsletterID int64, lastEmail model.Email, enqueued, enqueueFailed int) error {
+	ctx = withQueryName(ctx, "SetNewsletterSendCheckpoint")
+	query := `
+		insert into newsletter_sends (newsletter_id, last_email, enqueued, enqueue_failed)
+		values ($1, $2, $3, $4)
+		on conflict (newsletter_id) do update set
+			last_email = excluded.last_email,
+			enqueued = newsletter_sends.enqueued + excluded.enqueued,
+			enqueue_failed = newsletter_sends.enqueue_failed + excluded.enqueue_failed,
+			updated = now()`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID, lastEmail, enqueued, enqueueFailed)
+	return err
+}
+
+// ErrSendInProgress is returned when queueing a send of a newsletter that's already queued or sending.
+var ErrSendInProgress = errors.New("send in progress")
+
+// ErrAlreadySent is returned when queueing a send of a newsletter that's been sent, without forcing it.
+var ErrAlreadySent = errors.New("already sent")
+
+// QueueNewsletterSend of the newsletter to the confirmed subscribers in the segment, or all of them if it's empty,
+// publishing it in the archive and enqueueing the fan-out job through the outbox in the same transaction. Returns ErrSendInProgress if a send of it
+// is queued or sending already, and ErrAlreadySent if it was sent and force is false. Forcing it starts over,
+// and sends it to everyone in the segment again. Queueing a failed send again resumes it where it failed instead,
+// with the segment it was queued with. Returns ErrNotFound if there's no such newsletter,
+// and an apperr.Invalid error for an invalid segment.
+func (d *Database) QueueNewsletterSend(ctx context.Context, newsletterID int64, force bool, segment model.Segment) error {
+	ctx = withQueryName(ctx, "QueueNewsletterSend")
+	if _, _, err := segmentCondition(segment, 3); err != nil {
+		return err
+	}
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		return queueNewsletterSendInTx(ctx, tx, newsletterID, force, segment)
+	})
+}
+
+// queueNewsletterSendInTx is QueueNewsletterSend in the transaction. Queueing the send clears the schedule of the
+// newsletter, so a newsletter sent by hand before its schedule is due isn't sent again,
+// and publishes it in the archive, so it's in the feeds and the sitemap however it's sent.
+func queueNewsletterSendInTx(ctx context.Context, tx *sqlx.Tx, newsletterID int64, force bool, segment model.Segment) error {
+	condition, args, err := segmentCondition(segment, 3)
+	if err != nil {
+		return err
+	}
+
+	// Locking the newsletter keeps two sends from being queued at the same time, even when there's no send yet.
+	var id int64
+	if err := tx.GetContext(ctx, &id, `select id from newsletters where id = $1 for update`, newsletterID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	var state model.NewsletterSendState
+	err = tx.GetContext(ctx, &state, `select state from newsletter_sends where newsletter_id = $1`, newsletterID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	switch state {
+	case model.NewsletterSendStateQueued, model.NewsletterSendStateSending:
+		return ErrSendInProgress
+	case model.NewsletterSendStateCompleted:
+		if !force {
+			return ErrAlreadySent
+		}
+	}
+
+	if state == model.NewsletterSendStateFailed {
+		query := `update newsletter_sends set state = 'queued', error = '', updated = now() where newsletter_id = $1`
+		if _, err := tx.ExecContext(ctx, query, newsletterID); err != nil {
+			return err
+		}
+	} else {
+		query := `
+			insert into newsletter_sends (newsletter_id, state, segment, total)
+			values ($1, 'queued', $2, (
+				select count(*) from newsletter_subscribers
+				where deleted is null and active and suppressed is null and confirmed and ` + condition + `))
+			on conflict (newsletter_id) do update set
+				state = 'queued',
+				segment = excluded.segment,
+				total = excluded.total,
+				last_email = '',
+				enqueued = 0,
+				enqueue_failed = 0,
+				error = '',
+				queued = now(),
+				fanned_out = null,
+				finished = null,
+				updated = now()`
+		if _, err := tx.ExecContext(ctx, query, append([]any{newsletterID, segment.Normalize()}, args...)...); err != nil {
+			return err
+		}
+	}
+
+	query := `update newsletters set scheduled_at = null, scheduled_segment = '' where id = $1 and scheduled_at is not null`
+	if _, err := tx.ExecContext(ctx, query, newsletterID); err != nil {
+		return err
+	}
+
+	if _, err := publishNewsletterInTx(ctx, tx, newsletterID); err != nil {
+		return err
+	}
+
+	m, err := messaging.NewMessage(model.NewsletterIssueSendRequested{NewsletterID: strconv.FormatInt(newsletterID, 10)})
+	if err != nil {
+		return err
+	}
+	return EnqueueInTx(ctx, tx, m)
+}
+
+// ScheduleNewsletter by ID, to be published and sent to the confirmed subscribers in the segment at the given time,
+// by SendScheduledNewsletters. Scheduling it again changes the time and the segment.
+// Returns ErrNotFound if there's no such newsletter, ErrNotEditable if a send of it has been queued,
+// and an apperr.Invalid error for an invalid segment.
+func (d *Database) ScheduleNewsletter(ctx context.Context, id int64, at time.Time, segment model.Segment) error {
+	ctx = withQueryName(ctx, "ScheduleNewsletter")
+	if _, _, err := segmentCondition(segment, 1); err != nil {
+		return err
+	}
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		if err := lockEditableNewsletter(ctx, tx, id); err != nil {
+			return err
+		}
+		query := `update newsletters set scheduled_at = $2, scheduled_segment = $3, updated = now() where id = $1`
+		_, err := tx.ExecContext(ctx, query, id, at.UTC(), segment.Normalize())
+		return err
+	})
+}
+
+// UnscheduleNewsletter by ID, so it stays a draft. Unscheduling a newsletter that isn't scheduled does nothing.
+// Returns ErrNotFound if there's no such newsletter.
+func (d *Database) UnscheduleNewsletter(ctx context.Context, id int64) error {
+	ctx = withQueryName(ctx, "UnscheduleNewsletter")
+	query := `
+		update newsletters set
+			scheduled_at = null,
+			scheduled_segment = '',
+			updated = case when scheduled_at is null then updated else now() end
+		where id = $1`
+	result, err := d.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ScheduledNewsletterSends of SendScheduledNewsletters, by newsletter ID.
+type ScheduledNewsletterSends struct {
+	// Sent are the newsletters that were published and had their send queued.
+	Sent []int64
+	// Missed are the newsletters whose schedule was due before the grace window, and were unscheduled instead.
+	Missed []int64
+}
+
+// newsletterSchedulesLockKey is the key of the advisory lock SendScheduledNewsletters holds.
+const newsletterSchedulesLockKey = "newsletter-schedules"
+
+// SendScheduledNewsletters that are due at now, publishing them in the archive and queueing their sends
+// in the same transaction as clearing their schedules, so each is sent once. A newsletter is still sent
+// up to grace after its schedule was due, such as after downtime. After that it's unscheduled instead,
+// so an issue isn't sent days late. The schedules are advisory-locked while they're handled,
+// and if another caller holds the lock already, nothing is done.
+func (d *Database) SendScheduledNewsletters(ctx context.Context, now time.Time, grace time.Duration) (ScheduledNewsletterSends, error) {
+	ctx = withQueryName(ctx, "SendScheduledNewsletters")
+	var result ScheduledNewsletterSends
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		result = ScheduledNewsletterSends{}
+		acquired, err := TryAdvisoryXactLock(ctx, tx, newsletterSchedulesLockKey)
+		if err != nil || !acquired {
+			return err
+		}
+
+		var due []model.Newsletter
+		query := `
+			select id, scheduled_at as scheduledat, scheduled_segment as scheduledsegment
+			from newsletters n
+			where scheduled_at <= $1 and not exists (select from newsletter_sends s where s.newsletter_id = n.id)
+			order by scheduled_at, id
+			for update`
+		if err := tx.SelectContext(ctx, &due, query, now.UTC()); err != nil {
+			return err
+		}
+
+		for _, n := range due {
+			if n.ScheduledAt.Before(now.UTC().Add(-grace)) {
+				query := `update newsletters set scheduled_at = null, scheduled_segment = '', updated = now() where id = $1`
+				if _, err := tx.ExecContext(ctx, query, n.ID); err != nil {
+					return err
+				}
+				result.Missed = append(result.Missed, n.ID)
+				continue
+			}
+
+			if err := queueNewsletterSendInTx(ctx, tx, n.ID, false, n.ScheduledSegment); err != nil {
+				return err
+			}
+			result.Sent = append(result.Sent, n.ID)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// GetNewsletterSend of the newsletter, with the sent and failed counts from the send log.
+// Returns nil if the newsletter hasn't been sent.
+func (d *Database) GetNewsletterSend(ctx context.Context, newsletterID int64) (*model.NewsletterSend, error) {
+	ctx = withQueryName(ctx, "GetNewsletterSend")
+	var s model.NewsletterSend
+	query := `
+		select newsletter_id as newsletterid, state, segment, total, enqueued, error, queued, finished, updated,
+			(
+				select count(distinct email) from email_sends e
+				where e.newsletter_id = s.newsletter_id and not e.test and e.created >= s.queued and e.status = 'sent'
+			) as sent,
+			(
+				select count(distinct email) from email_sends e
+				where e.newsletter_id = s.newsletter_id and not e.test and e.created >= s.queued and e.status = 'failed'
+					and not exists (
+						select 1 from email_sends e2
+						where e2.newsletter_id = e.newsletter_id and e2.email = e.email and not e2.test and e2.created >= s.queued
+							and e2.status = 'sent')
+			) as failed
+		from newsletter_sends s
+		where newsletter_id = $1`
+	if err := d.DB.GetContext(ctx, &s, query, newsletterID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// StartNewsletterSend of the newsletter, which marks a queued send as sending.
+func (d *Database) StartNewsletterSend(ctx context.Context, newsletterID int64) error {
+	ctx = withQueryName(ctx, "StartNewsletterSend")
+	query := `update newsletter_sends set state = 'sending', updated = now() where newsletter_id = $1 and state = 'queued'`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID)
+	return err
+}
+
+// FinishNewsletterFanOut of the newsletter, after every subscriber has been enqueued or failed to be,
+// and completes the send if every email has a result already.
+func (d *Database) FinishNewsletterFanOut(ctx context.Context, newsletterID int64) error {
+	ctx = withQueryName(ctx, "FinishNewsletterFanOut")
+	query := `update newsletter_sends set fanned_out = now(), updated = now() where newsletter_id = $1`
+	if _, err := d.DB.ExecContext(ctx, query, newsletterID); err != nil {
+		return err
+	}
+	return d.CompleteNewsletterSendIfDone(ctx, newsletterID)
+}
+
+// CompleteNewsletterSendIfDone marks the send of the newsletter as completed, once the fan-out is finished,
+// and the send log has a result for every subscriber it enqueued an email for or failed to.
+// A failed email that's still being retried counts as a result, so the send can complete before the retry.
+func (d *Database) CompleteNewsletterSendIfDone(ctx context.Context, newsletterID int64) error {
+	ctx = withQueryName(ctx, "CompleteNewsletterSendIfDone")
+	query := `
+		update newsletter_sends s set state = 'completed', finished = now(), updated = now()
+		where newsletter_id = $1 and state = 'sending' and fanned_out is not null and s.enqueued + s.enqueue_failed <= (
+			select count(distinct email) from email_sends e
+			where e.newsletter_id = s.newsletter_id and not e.test and e.created >= s.queued)`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID)
+	return err
+}
+
+// FailNewsletterSend of the newsletter, because its fan-out job failed permanently with reason.
+func (d *Database) FailNewsletterSend(ctx context.Context, newsletterID int64, reason string) error {
+	ctx = withQueryName(ctx, "FailNewsletterSend")
+	query := `update newsletter_sends set state = 'failed', error = $2, updated = now() where newsletter_id = $1`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID, reason)
+	return err
+}
@@ -0,0 +1,617 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/integrationtest"
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestDatabase_ListSubscribers(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("pages through confirmed subscribers by email", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, e := range []model.Email{"c@example.com", "a@example.com", "b@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), e, "en", "")
+			is.NoErr(err)
+		}
+		_, err := db.DB.Exec(`update newsletter_subscribers set confirmed = true where email != 'b@example.com'`)
+		is.NoErr(err)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 1, Status: model.SubscriberStatusConfirmed})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+		is.Equal(model.Email("a@example.com"), subscribers[0].Email)
+
+		subscribers, err = db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			After: subscribers[0].Email, Limit: 10, Status: model.SubscriberStatusConfirmed,
+		})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+		is.Equal(model.Email("c@example.com"), subscribers[0].Email)
+	})
+}
+
+func TestDatabase_ListSubscribers_status(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("filters by status, and pages back from before an email", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, e := range []model.Email{"a@example.com", "b@example.com", "c@example.com", "d@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), e, "en", "")
+			is.NoErr(err)
+		}
+		_, err := db.DB.Exec(`update newsletter_subscribers set confirmed = true, confirmed_at = now() where email in ('a@example.com', 'b@example.com')`)
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "b@example.com")
+		is.NoErr(err)
+
+		emails := func(subscribers []model.Subscriber) []model.Email {
+			var es []model.Email
+			for _, s := range subscribers {
+				es = append(es, s.Email)
+			}
+			return es
+		}
+
+		tests := []struct {
+			status model.SubscriberStatus
+			emails []model.Email
+		}{
+			{"", []model.Email{"a@example.com", "b@example.com", "c@example.com", "d@example.com"}},
+			{model.SubscriberStatusPending, []model.Email{"c@example.com", "d@example.com"}},
+			{model.SubscriberStatusConfirmed, []model.Email{"a@example.com"}},
+			{model.SubscriberStatusUnsubscribed, []model.Email{"b@example.com"}},
+		}
+		for _, test := range tests {
+			subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10, Status: test.status})
+			is.NoErr(err)
+			is.Equal(test.emails, emails(subscribers))
+		}
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Before: "d@example.com", Limit: 2})
+		is.NoErr(err)
+		is.Equal([]model.Email{"b@example.com", "c@example.com"}, emails(subscribers))
+		is.True(subscribers[0].ConfirmedAt != nil)
+		is.True(subscribers[1].ConfirmedAt == nil)
+	})
+}
+
+func TestDatabase_SetNewsletterSendCheckpoint(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("stores the last email and adds up the enqueued and failed counts", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		var id int64
+		err := db.DB.Get(&id, `insert into newsletters (title, body) values ('Issue 1', 'Hello.') returning id`)
+		is.NoErr(err)
+
+		lastEmail, err := db.GetNewsletterSendCheckpoint(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.Email(""), lastEmail)
+
+		err = db.SetNewsletterSendCheckpoint(context.Background(), id, "a@example.com", 1, 0)
+		is.NoErr(err)
+		err = db.SetNewsletterSendCheckpoint(context.Background(), id, "b@example.com", 1, 1)
+		is.NoErr(err)
+
+		lastEmail, err = db.GetNewsletterSendCheckpoint(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.Email("b@example.com"), lastEmail)
+
+		var enqueued, enqueueFailed int
+		err = db.DB.QueryRow(`select enqueued, enqueue_failed from newsletter_sends where newsletter_id = $1`, id).
+			Scan(&enqueued, &enqueueFailed)
+		is.NoErr(err)
+		is.Equal(2, enqueued)
+		is.Equal(1, enqueueFailed)
+	})
+}
+
+func TestDatabase_QueueNewsletterSend(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	setup := func(t *testing.T) (*storage.Database, func(), int64) {
+		t.Helper()
+		db, cleanup := integrationtest.CreateDatabase()
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.DB.Exec(`
+			insert into newsletter_subscribers (email, token, confirmed, active)
+			values ('a@example.com', '1', true, true), ('b@example.com', '2', true, true), ('c@example.com', '3', false, true)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db, cleanup, n.ID
+	}
+
+	// send the newsletter to the email address, like the email job does when the fan-out has enqueued it.
+	send := func(t *testing.T, db *storage.Database, id int64, email model.Email, status model.EmailSendStatus) {
+		t.Helper()
+		err := db.RecordEmailSend(context.Background(), model.EmailSend{Email: email, Type: "newsletter_issue_email", NewsletterID: id, Status: status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CompleteNewsletterSendIfDone(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("queues the send with the confirmed subscriber count and enqueues the fan-out job", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		s, err := db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.True(s == nil)
+
+		err = db.QueueNewsletterSend(context.Background(), id, false, "")
+		is.NoErr(err)
+
+		s, err = db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateQueued, s.State)
+		is.Equal(2, s.Total)
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(1, len(ms))
+		is.Equal(model.Message{"job": "newsletter_issue_send", "newsletterID": strconv.FormatInt(id, 10)}, ms[0].Message)
+	})
+
+	t.Run("goes through sending to completed when every enqueued email has a result", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+		is.NoErr(db.StartNewsletterSend(context.Background(), id))
+		is.NoErr(db.SetNewsletterSendCheckpoint(context.Background(), id, "b@example.com", 2, 0))
+		is.NoErr(db.FinishNewsletterFanOut(context.Background(), id))
+
+		send(t, db, id, "a@example.com", model.EmailSendStatusFailed)
+		s, err := db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateSending, s.State)
+		is.Equal(0, s.Sent)
+		is.Equal(1, s.Failed)
+
+		send(t, db, id, "a@example.com", model.EmailSendStatusSent)
+		send(t, db, id, "b@example.com", model.EmailSendStatusSent)
+		s, err = db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateCompleted, s.State)
+		is.Equal(2, s.Sent)
+		is.Equal(0, s.Failed)
+		is.True(s.Finished != nil)
+	})
+
+	t.Run("blocks a second send while one is in progress", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+		err := db.QueueNewsletterSend(context.Background(), id, false, "")
+		is.True(errors.Is(err, storage.ErrSendInProgress))
+
+		is.NoErr(db.StartNewsletterSend(context.Background(), id))
+		err = db.QueueNewsletterSend(context.Background(), id, true, "")
+		is.True(errors.Is(err, storage.ErrSendInProgress))
+	})
+
+	t.Run("requires force to send a completed send again, which starts over", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+		is.NoErr(db.StartNewsletterSend(context.Background(), id))
+		is.NoErr(db.SetNewsletterSendCheckpoint(context.Background(), id, "b@example.com", 2, 0))
+		is.NoErr(db.FinishNewsletterFanOut(context.Background(), id))
+		send(t, db, id, "a@example.com", model.EmailSendStatusSent)
+		send(t, db, id, "b@example.com", model.EmailSendStatusSent)
+
+		err := db.QueueNewsletterSend(context.Background(), id, false, "")
+		is.True(errors.Is(err, storage.ErrAlreadySent))
+
+		err = db.QueueNewsletterSend(context.Background(), id, true, "")
+		is.NoErr(err)
+
+		s, err := db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateQueued, s.State)
+		is.Equal(0, s.Sent)
+		is.Equal(0, s.Enqueued)
+
+		lastEmail, err := db.GetNewsletterSendCheckpoint(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.Email(""), lastEmail)
+
+		sent, err := db.HasSentNewsletter(context.Background(), id, "a@example.com")
+		is.NoErr(err)
+		is.True(!sent)
+	})
+
+	t.Run("resumes a failed send where it failed", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+		is.NoErr(db.StartNewsletterSend(context.Background(), id))
+		is.NoErr(db.SetNewsletterSendCheckpoint(context.Background(), id, "a@example.com", 1, 0))
+		send(t, db, id, "a@example.com", model.EmailSendStatusSent)
+		is.NoErr(db.FailNewsletterSend(context.Background(), id, "oh no"))
+
+		s, err := db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateFailed, s.State)
+		is.Equal("oh no", s.Error)
+
+		is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+
+		s, err = db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateQueued, s.State)
+		is.Equal("", s.Error)
+		is.Equal(1, s.Sent)
+
+		lastEmail, err := db.GetNewsletterSendCheckpoint(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.Email("a@example.com"), lastEmail)
+	})
+
+	t.Run("returns ErrNotFound if there's no such newsletter", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup, id := setup(t)
+		defer cleanup()
+
+		err := db.QueueNewsletterSend(context.Background(), id+1, false, "")
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_ListPublishedNewsletters(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("lists published newsletters newest first, without drafts or future ones", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.DB.Exec(`
+			insert into newsletters (title, body, slug, published_at) values
+				('Old', 'Body', 'old', now() - interval '2 days'),
+				('New', 'Body', 'new', now() - interval '1 day'),
+				('Draft', 'Body', null, null),
+				('Future', 'Body', 'future', now() + interval '1 day')`)
+		is.NoErr(err)
+
+		newsletters, err := db.ListPublishedNewsletters(context.Background(), storage.ListPublishedNewslettersOptions{})
+		is.NoErr(err)
+		is.Equal(2, len(newsletters))
+		is.Equal("new", newsletters[0].Slug)
+		is.Equal("old", newsletters[1].Slug)
+		is.True(newsletters[0].PublishedAt != nil)
+
+		newsletters, err = db.ListPublishedNewsletters(context.Background(), storage.ListPublishedNewslettersOptions{Limit: 1, Offset: 1})
+		is.NoErr(err)
+		is.Equal(1, len(newsletters))
+		is.Equal("old", newsletters[0].Slug)
+	})
+}
+
+func TestDatabase_GetPublishedNewsletter(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("gets published newsletters by slug, but not drafts or future ones", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.DB.Exec(`
+			insert into newsletters (title, body, slug, published_at) values
+				('Published', 'Body', 'published', now() - interval '1 day'),
+				('Draft', 'Body', 'draft', null),
+				('Future', 'Body', 'future', now() + interval '1 day')`)
+		is.NoErr(err)
+
+		n, err := db.GetPublishedNewsletter(context.Background(), "published")
+		is.NoErr(err)
+		is.Equal("Published", n.Title)
+		is.True(n.PublishedAt != nil)
+
+		for _, slug := range []string{"draft", "future", "doesnotexist"} {
+			_, err = db.GetPublishedNewsletter(context.Background(), slug)
+			is.True(errors.Is(err, storage.ErrNotFound))
+		}
+	})
+}
+
+func TestDatabase_QueueNewsletterSend_publish(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("publishes with unique slugs from the title, and keeps the slug when queued again", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		var ids []int64
+		err := db.DB.Select(&ids, `
+			insert into newsletters (title, body) values ('Tips & Tricks', 'Body'), ('Tips & tricks!', 'Body')
+			returning id`)
+		is.NoErr(err)
+
+		for _, id := range ids {
+			is.NoErr(db.QueueNewsletterSend(context.Background(), id, false, ""))
+		}
+
+		n, err := db.GetPublishedNewsletter(context.Background(), "tips-and-tricks")
+		is.NoErr(err)
+		is.Equal(ids[0], n.ID)
+		is.True(n.PublishedAt != nil)
+
+		n2, err := db.GetPublishedNewsletter(context.Background(), "tips-and-tricks-2")
+		is.NoErr(err)
+		is.Equal(ids[1], n2.ID)
+
+		_, err = db.DB.Exec(`update newsletter_sends set state = 'failed' where newsletter_id = $1`, ids[0])
+		is.NoErr(err)
+		is.NoErr(db.QueueNewsletterSend(context.Background(), ids[0], false, ""))
+
+		again, err := db.GetPublishedNewsletter(context.Background(), "tips-and-tricks")
+		is.NoErr(err)
+		is.Equal(ids[0], again.ID)
+		is.True(again.PublishedAt.Equal(*n.PublishedAt))
+	})
+
+	t.Run("doesn't publish when the send can't be queued", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+
+		_, err = db.DB.Exec(`insert into newsletter_sends (newsletter_id, state) values ($1, 'sending')`, n.ID)
+		is.NoErr(err)
+
+		err = db.QueueNewsletterSend(context.Background(), n.ID, false, "")
+		is.True(errors.Is(err, storage.ErrSendInProgress))
+
+		_, err = db.GetPublishedNewsletter(context.Background(), "issue-1")
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_CreateNewsletter(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("caches the rendered body, and renders it again on update", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue", "Hi **you**. <script>alert(1)</script>")
+		is.NoErr(err)
+		is.True(n.ID > 0)
+		is.Equal("<p>Hi <strong>you</strong>. alert(1)</p>\n", n.RenderedHTML)
+
+		got, err := db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal(n.RenderedHTML, got.RenderedHTML)
+
+		err = db.UpdateNewsletter(context.Background(), n.ID, "Issue", "Bye *you*.")
+		is.NoErr(err)
+		got, err = db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal("Bye *you*.", got.Body)
+		is.Equal("<p>Bye <em>you</em>.</p>\n", got.RenderedHTML)
+
+		err = db.UpdateNewsletter(context.Background(), 123, "Issue", "Body")
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_ScheduleNewsletter(t *testing.T) {
+	at := time.Date(2023, 1, 9, 8, 0, 0, 0, time.UTC)
+
+	t.Run("schedules and unschedules the newsletter", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+		is.NoErr(db.ScheduleNewsletter(context.Background(), n.ID, at.In(time.FixedZone("CET", 3600)), "API"))
+
+		got, err := db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal(at, got.ScheduledAt.UTC())
+		is.Equal(model.Segment("api"), got.ScheduledSegment)
+		is.Equal(model.NewsletterStateScheduled, got.State(nil))
+
+		is.NoErr(db.UnscheduleNewsletter(context.Background(), n.ID))
+		is.NoErr(db.UnscheduleNewsletter(context.Background(), n.ID))
+		got, err = db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.True(got.ScheduledAt == nil)
+		is.Equal(model.NewsletterStateDraft, got.State(nil))
+	})
+
+	t.Run("doesn't schedule to an invalid segment", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+		err = db.ScheduleNewsletter(context.Background(), n.ID, at, "api and")
+		is.True(errors.Is(err, apperr.Invalid))
+	})
+
+	t.Run("doesn't schedule or edit a newsletter once a send is queued, and sending it clears its schedule", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+		is.NoErr(db.ScheduleNewsletter(context.Background(), n.ID, at, ""))
+		is.NoErr(db.UpdateNewsletter(context.Background(), n.ID, "Issue 1", "Hello again."))
+		is.NoErr(db.QueueNewsletterSend(context.Background(), n.ID, false, ""))
+
+		got, err := db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.True(got.ScheduledAt == nil)
+
+		err = db.ScheduleNewsletter(context.Background(), n.ID, at, "")
+		is.True(errors.Is(err, storage.ErrNotEditable))
+		err = db.UpdateNewsletter(context.Background(), n.ID, "Issue 1", "Changed.")
+		is.True(errors.Is(err, storage.ErrNotEditable))
+
+		got, err = db.GetNewsletter(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal("Hello again.", got.Body)
+	})
+
+	t.Run("returns not found for unknown newsletters", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		err := db.ScheduleNewsletter(context.Background(), 123, at, "")
+		is.True(errors.Is(err, storage.ErrNotFound))
+		err = db.UnscheduleNewsletter(context.Background(), 123)
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_SendScheduledNewsletters(t *testing.T) {
+	// Monday 9:00 in Berlin.
+	at := time.Date(2023, 1, 9, 8, 0, 0, 0, time.UTC)
+
+	schedule := func(is *is.I, db *storage.Database, title string, at time.Time, segment model.Segment) int64 {
+		n, err := db.CreateNewsletter(context.Background(), title, "Hello.")
+		is.NoErr(err)
+		is.NoErr(db.ScheduleNewsletter(context.Background(), n.ID, at, segment))
+		return n.ID
+	}
+
+	t.Run("publishes and queues the send once the clock passes the schedule, and only once", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.DB.Exec(`
+			insert into newsletter_subscribers (email, token, confirmed, active)
+			values ('a@example.com', '1', true, true), ('b@example.com', '2', true, true)`)
+		is.NoErr(err)
+		var subscriberID int64
+		is.NoErr(db.DB.Get(&subscriberID, `select id from newsletter_subscribers where email = 'a@example.com'`))
+		is.NoErr(db.AddSubscriberTag(context.Background(), subscriberID, "api", storage.AuditActorAdmin))
+
+		id := schedule(is, db, "Issue 1", at, "api")
+
+		result, err := db.SendScheduledNewsletters(context.Background(), at.Add(-time.Second), time.Hour)
+		is.NoErr(err)
+		is.Equal(0, len(result.Sent))
+		s, err := db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.True(s == nil)
+
+		result, err = db.SendScheduledNewsletters(context.Background(), at, time.Hour)
+		is.NoErr(err)
+		is.Equal([]int64{id}, result.Sent)
+
+		s, err = db.GetNewsletterSend(context.Background(), id)
+		is.NoErr(err)
+		is.Equal(model.NewsletterSendStateQueued, s.State)
+		is.Equal(model.Segment("api"), s.Segment)
+		is.Equal(1, s.Total)
+
+		n, err := db.GetNewsletter(context.Background(), id)
+		is.NoErr(err)
+		is.True(n.ScheduledAt == nil)
+		is.Equal(model.NewsletterStateSending, n.State(s))
+		published, err := db.GetPublishedNewsletter(context.Background(), "issue-1")
+		is.NoErr(err)
+		is.Equal(id, published.ID)
+
+		result, err = db.SendScheduledNewsletters(context.Background(), at.Add(time.Minute), time.Hour)
+		is.NoErr(err)
+		is.Equal(0, len(result.Sent))
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(1, len(ms))
+		is.Equal(model.Message{"job": "newsletter_issue_send", "newsletterID": strconv.FormatInt(id, 10)}, ms[0].Message)
+	})
+
+	t.Run("sends on recovery from downtime within the grace, and unschedules the ones missed by more", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		missed := schedule(is, db, "Issue 1", at.Add(-time.Hour-time.Second), "")
+		late := schedule(is, db, "Issue 2", at.Add(-time.Hour), "")
+		onTime := schedule(is, db, "Issue 3", at, "")
+		later := schedule(is, db, "Issue 4", at.Add(time.Second), "")
+
+		result, err := db.SendScheduledNewsletters(context.Background(), at, time.Hour)
+		is.NoErr(err)
+		is.Equal([]int64{late, onTime}, result.Sent)
+		is.Equal([]int64{missed}, result.Missed)
+
+		n, err := db.GetNewsletter(context.Background(), missed)
+		is.NoErr(err)
+		is.True(n.ScheduledAt == nil)
+		s, err := db.GetNewsletterSend(context.Background(), missed)
+		is.NoErr(err)
+		is.True(s == nil)
+
+		n, err = db.GetNewsletter(context.Background(), later)
+		is.NoErr(err)
+		is.True(n.ScheduledAt != nil)
+	})
+
+	t.Run("does nothing while another caller holds the lock", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id := schedule(is, db, "Issue 1", at, "")
+
+		tx, err := db.DB.Beginx()
+		is.NoErr(err)
+		defer func() { _ = tx.Rollback() }()
+		acquired, err := storage.TryAdvisoryXactLock(context.Background(), tx, "newsletter-schedules")
+		is.NoErr(err)
+		is.True(acquired)
+
+		result, err := db.SendScheduledNewsletters(context.Background(), at, time.Hour)
+		is.NoErr(err)
+		is.Equal(0, len(result.Sent))
+		is.NoErr(tx.Rollback())
+
+		result, err = db.SendScheduledNewsletters(context.Background(), at, time.Hour)
+		is.NoErr(err)
+		is.Equal([]int64{id}, result.Sent)
+	})
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/messaging"
+	"canvas/model"
+)
+
+// EnqueueInTx adds the message to the outbox in the given transaction.
+// The message is published to the queue by the outbox relay after the transaction commits.
+func EnqueueInTx(ctx context.Context, tx *sqlx.Tx, m model.Message) error {
+	messageAsBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `insert into outbox (message) values ($1)`, string(messageAsBytes))
+	return err
+}
+
+// emitInTx the domain event through the outbox in the given transaction, like EnqueueInTx,
+// so it's published once if the transaction commits, and never if it rolls back.
+// Its webhook deliveries are enqueued in the same transaction.
+func emitInTx(ctx context.Context, tx *sqlx.Tx, e messaging.Event) error {
+	m, err := messaging.NewEvent(e, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := EnqueueInTx(ctx, tx, m); err != nil {
+		return err
+	}
+	return enqueueWebhookDeliveriesInTx(ctx, tx, m)
+}
+
+// GetOutboxMessages that haven't been sent yet, oldest first, up to limit.
+// It doesn't claim them, so it's for looking at the outbox. The relay uses RelayOutboxMessages.
+func (d *Database) GetOutboxMessages(ctx context.Context, limit int) ([]model.OutboxMessage, error) {
+	ctx = withQueryName(ctx, "GetOutboxMessages")
+	var rows []outboxRow
+	query := `select id, message, created from outbox where sent is null order by id limit $1`
+	if err := d.DB.SelectContext(ctx, &rows, query, limit); err != nil {
+		return nil, err
+	}
+	return outboxMessages(rows)
+}
+
+// RelayOutboxMessages claims up to limit unsent messages, oldest first, and publishes them in order,
+// stopping at the first one that publish returns an error for.
+// The published messages are marked as sent in the transaction that claims them, and rows claimed by another relay
+// are skipped, so relays on several replicas don't publish the same messages.
+// Returns the number of messages published, with the error of the one that wasn't, if any.
+func (d *Database) RelayOutboxMessages(ctx context.Context, limit int, publish func(model.OutboxMessage) error) (int, error) {
+	ctx = withQueryName(ctx, "RelayOutboxMessages")
+	var published int
+	var publishErr error
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var rows []outboxRow
+		query := `select id, message, created from outbox where sent is null order by id limit $1 for update skip locked`
+		if err := tx.SelectContext(ctx, &rows, query, limit); err != nil {
+			return err
+		}
+		ms, err := outboxMessages(rows)
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+		for _, m := range ms {
+			if publishErr = publish(m); publishErr != nil {
+				break
+			}
+			ids = append(ids, m.ID)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		idsAsJSON, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		query = `update outbox set sent = now() where id in (select value::bigint from json_array_elements_text($1::json))`
+		if _, err := tx.ExecContext(ctx, query, string(idsAsJSON)); err != nil {
+			return err
+		}
+		published = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+// outboxRow of the outbox table.
+type outboxRow struct {
+	ID      int64
+	Message string
+	Created time.Time
+}
+
+// outboxMessages from the rows, with their messages decoded.
+func outboxMessages(rows []outboxRow) ([]model.OutboxMessage, error) {
+	ms := make([]model.OutboxMessage, 0, len(rows))
+	for _, row := range rows {
+		var m model.Message
+		if err := json.Unmarshal([]byte(row.Message), &m); err != nil {
+			return nil, err
+		}
+		ms = append(ms, model.OutboxMessage{ID: row.ID, Message: m, Created: row.Created})
+	}
+	return ms, nil
+}
+
+// DeleteSentOutboxMessages sent before the given time. Returns the number of deleted messages.
+func (d *Database) DeleteSentOutboxMessages(ctx context.Context, before time.Time) (int64, error) {
+	ctx = withQueryName(ctx, "DeleteSentOutboxMessages")
+	result, err := d.DB.ExecContext(ctx, `delete from outbox where sent < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
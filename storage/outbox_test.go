@@ -0,0 +1,145 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/model"
+	"canvas/storage"
+)
+
+func TestEnqueueInTx(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("enqueues on commit and not on rollback", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			return storage.EnqueueInTx(context.Background(), tx, model.Message{"job": "committed"})
+		})
+		is.NoErr(err)
+
+		err = db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			if err := storage.EnqueueInTx(context.Background(), tx, model.Message{"job": "rolled_back"}); err != nil {
+				return err
+			}
+			return errors.New("oh no")
+		})
+		is.True(err != nil)
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(1, len(ms))
+		is.Equal(model.Message{"job": "committed"}, ms[0].Message)
+	})
+}
+
+func TestDatabase_RelayOutboxMessages(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	enqueue := func(is *is.I, db *storage.Database, jobs ...string) {
+		for _, job := range jobs {
+			err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+				return storage.EnqueueInTx(context.Background(), tx, model.Message{"job": job})
+			})
+			is.NoErr(err)
+		}
+	}
+
+	t.Run("marks published messages sent, and sent messages are deleted after retention", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		enqueue(is, db, "foo")
+
+		var published []model.Message
+		n, err := db.RelayOutboxMessages(context.Background(), 10, func(m model.OutboxMessage) error {
+			published = append(published, m.Message)
+			return nil
+		})
+		is.NoErr(err)
+		is.Equal(1, n)
+		is.Equal([]model.Message{{"job": "foo"}}, published)
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(0, len(ms))
+
+		deleted, err := db.DeleteSentOutboxMessages(context.Background(), time.Now().Add(-time.Hour))
+		is.NoErr(err)
+		is.Equal(int64(0), deleted)
+
+		deleted, err = db.DeleteSentOutboxMessages(context.Background(), time.Now().Add(time.Hour))
+		is.NoErr(err)
+		is.Equal(int64(1), deleted)
+	})
+
+	t.Run("stops at the first publish error, marking only the messages before it", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		enqueue(is, db, "foo", "bar", "baz")
+
+		n, err := db.RelayOutboxMessages(context.Background(), 10, func(m model.OutboxMessage) error {
+			if m.Message["job"] == "bar" {
+				return errors.New("oh no")
+			}
+			return nil
+		})
+		is.True(err != nil)
+		is.Equal(1, n)
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(2, len(ms))
+		is.Equal("bar", ms[0].Message["job"])
+	})
+
+	t.Run("skips messages claimed by another relay, so each is published once", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		enqueue(is, db, "foo", "bar")
+
+		claimed := make(chan struct{})
+		release := make(chan struct{})
+		done := make(chan int)
+		go func() {
+			n, _ := db.RelayOutboxMessages(context.Background(), 10, func(m model.OutboxMessage) error {
+				if m.Message["job"] == "foo" {
+					close(claimed)
+					<-release
+				}
+				return nil
+			})
+			done <- n
+		}()
+
+		<-claimed
+		n, err := db.RelayOutboxMessages(context.Background(), 10, func(m model.OutboxMessage) error {
+			return nil
+		})
+		is.NoErr(err)
+		is.Equal(0, n)
+
+		close(release)
+		is.Equal(2, <-done)
+
+		n, err = db.RelayOutboxMessages(context.Background(), 10, func(m model.OutboxMessage) error {
+			return nil
+		})
+		is.NoErr(err)
+		is.Equal(0, n)
+	})
+}
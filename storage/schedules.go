@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/model"
+)
+
+// TryAdvisoryXactLock with the given key in the transaction, returning whether the lock was acquired.
+// The lock is released when the transaction ends.
+func TryAdvisoryXactLock(ctx context.Context, tx *sqlx.Tx, key string) (bool, error) {
+	var acquired bool
+	err := tx.GetContext(ctx, &acquired, `select pg_try_advisory_xact_lock(hashtext($1))`, key)
+	return acquired, err
+}
+
+// GetScheduleLastRun for the schedule with the given name. Returns the zero time if it has never run.
+func (d *Database) GetScheduleLastRun(ctx context.Context, name string) (time.Time, error) {
+	ctx = withQueryName(ctx, "GetScheduleLastRun")
+	var lastRun time.Time
+	err := d.DB.GetContext(ctx, &lastRun, `select last_run from schedules where name = $1`, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return lastRun.UTC(), err
+}
+
+// FireSchedule with the given name for the run at the given time, enqueueing the message through the outbox.
+// Only one caller fires a given run: the schedule is advisory-locked while firing, and runs at or before
+// the recorded last run are skipped. Returns whether the schedule was fired.
+func (d *Database) FireSchedule(ctx context.Context, name string, at time.Time, m model.Message) (bool, error) {
+	ctx = withQueryName(ctx, "FireSchedule")
+	var fired bool
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		acquired, err := TryAdvisoryXactLock(ctx, tx, "schedule:"+name)
+		if err != nil || !acquired {
+			return err
+		}
+
+		var lastRun time.Time
+		err = tx.GetContext(ctx, &lastRun, `select last_run from schedules where name = $1`, name)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && !lastRun.Before(at.UTC()) {
+			return nil
+		}
+
+		if err := EnqueueInTx(ctx, tx, m); err != nil {
+			return err
+		}
+
+		query := `
+			insert into schedules (name, last_run)
+			values ($1, $2)
+			on conflict (name) do update set
+				last_run = excluded.last_run,
+				updated = now()`
+		if _, err := tx.ExecContext(ctx, query, name, at.UTC()); err != nil {
+			return err
+		}
+
+		fired = true
+		return nil
+	})
+	return fired, err
+}
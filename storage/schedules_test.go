@@ -0,0 +1,44 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/model"
+)
+
+func TestDatabase_FireSchedule(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("fires each run once, records the last run, and enqueues the message", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		lastRun, err := db.GetScheduleLastRun(context.Background(), "digest")
+		is.NoErr(err)
+		is.True(lastRun.IsZero())
+
+		at := time.Date(2022, 12, 5, 9, 0, 0, 0, time.UTC)
+		fired, err := db.FireSchedule(context.Background(), "digest", at, model.Message{"job": "send_digest"})
+		is.NoErr(err)
+		is.True(fired)
+
+		fired, err = db.FireSchedule(context.Background(), "digest", at, model.Message{"job": "send_digest"})
+		is.NoErr(err)
+		is.True(!fired)
+
+		lastRun, err = db.GetScheduleLastRun(context.Background(), "digest")
+		is.NoErr(err)
+		is.Equal(at, lastRun)
+
+		ms, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(1, len(ms))
+		is.Equal(model.Message{"job": "send_digest"}, ms[0].Message)
+	})
+}
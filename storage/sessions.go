@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetSession data by session ID, or nil if there's no such session or it has expired.
+func (d *Database) GetSession(ctx context.Context, id string) (map[string]json.RawMessage, error) {
+	ctx = withQueryName(ctx, "GetSession")
+	var data string
+	query := `select data from sessions where id = $1 and expires > now()`
+	if err := d.DB.GetContext(ctx, &data, query, hashSessionToken(id)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// SaveSession by merging the changed values into the session and removing the deleted keys,
+// creating the session if it doesn't exist. The session expires after the given lifetime from now.
+// Keys that weren't changed are left alone, so concurrent saves of different keys don't overwrite each other.
+func (d *Database) SaveSession(ctx context.Context, id string, changed map[string]json.RawMessage, deleted []string, lifetime time.Duration) error {
+	ctx = withQueryName(ctx, "SaveSession")
+	changedAsBytes, err := json.Marshal(changed)
+	if err != nil {
+		return err
+	}
+	if deleted == nil {
+		deleted = []string{}
+	}
+	deletedAsBytes, err := json.Marshal(deleted)
+	if err != nil {
+		return err
+	}
+	query := `
+		insert into sessions (id, data, expires)
+		values ($1, $2::jsonb - array(select jsonb_array_elements_text($3::jsonb)), now() + make_interval(secs => $4))
+		on conflict (id) do update set
+			data = (case when sessions.expires > now() then sessions.data else '{}' end || excluded.data)
+				- array(select jsonb_array_elements_text($3::jsonb)),
+			expires = excluded.expires,
+			updated = now()`
+	_, err = d.DB.ExecContext(ctx, query, hashSessionToken(id), string(changedAsBytes), string(deletedAsBytes), lifetime.Seconds())
+	return err
+}
+
+// DeleteSession by ID, if it exists.
+func (d *Database) DeleteSession(ctx context.Context, id string) error {
+	ctx = withQueryName(ctx, "DeleteSession")
+	_, err := d.DB.ExecContext(ctx, `delete from sessions where id = $1`, hashSessionToken(id))
+	return err
+}
+
+// DeleteExpiredSessions and return how many were deleted.
+func (d *Database) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	ctx = withQueryName(ctx, "DeleteExpiredSessions")
+	result, err := d.DB.ExecContext(ctx, `delete from sessions where expires <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// hashSessionToken so the session IDs in cookies aren't stored, and a database leak can't be used to take over sessions.
+// API tokens are hashed the same way.
+func hashSessionToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+)
+
+func TestDatabase_Session(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("saves, merges, and deletes sessions", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		values, err := db.GetSession(context.Background(), "123")
+		is.NoErr(err)
+		is.True(values == nil)
+
+		err = db.SaveSession(context.Background(), "123", map[string]json.RawMessage{"a": json.RawMessage(`1`), "b": json.RawMessage(`"b"`)}, nil, time.Hour)
+		is.NoErr(err)
+
+		err = db.SaveSession(context.Background(), "123", map[string]json.RawMessage{"c": json.RawMessage(`true`)}, []string{"a"}, time.Hour)
+		is.NoErr(err)
+
+		values, err = db.GetSession(context.Background(), "123")
+		is.NoErr(err)
+		is.Equal(2, len(values))
+		is.Equal(`"b"`, string(values["b"]))
+		is.Equal(`true`, string(values["c"]))
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from sessions where id = '123'`)
+		is.NoErr(err)
+		is.Equal(0, count)
+
+		err = db.DeleteSession(context.Background(), "123")
+		is.NoErr(err)
+		values, err = db.GetSession(context.Background(), "123")
+		is.NoErr(err)
+		is.True(values == nil)
+	})
+
+	t.Run("doesn't get expired sessions, starts over when saving them, and cleans them up", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.SaveSession(context.Background(), "123", map[string]json.RawMessage{"a": json.RawMessage(`1`)}, nil, time.Hour)
+		is.NoErr(err)
+		err = db.SaveSession(context.Background(), "456", map[string]json.RawMessage{"a": json.RawMessage(`1`)}, nil, time.Hour)
+		is.NoErr(err)
+		_, err = db.DB.Exec(`update sessions set expires = now() - interval '1 second'`)
+		is.NoErr(err)
+
+		values, err := db.GetSession(context.Background(), "123")
+		is.NoErr(err)
+		is.True(values == nil)
+
+		err = db.SaveSession(context.Background(), "123", map[string]json.RawMessage{"b": json.RawMessage(`2`)}, nil, time.Hour)
+		is.NoErr(err)
+		values, err = db.GetSession(context.Background(), "123")
+		is.NoErr(err)
+		is.Equal(1, len(values))
+		is.Equal(`2`, string(values["b"]))
+
+		n, err := db.DeleteExpiredSessions(context.Background())
+		is.NoErr(err)
+		is.Equal(int64(1), n)
+	})
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+
+	"canvas/model"
+)
+
+// SubscriberStats over the last days, including today. Deleted subscribers aren't counted.
+func (d *Database) SubscriberStats(ctx context.Context, days int) (model.SubscriberStats, error) {
+	ctx = withQueryName(ctx, "SubscriberStats")
+	stats := model.SubscriberStats{ByStatus: map[model.SubscriberStatus]int{}}
+
+	var byStatus []struct {
+		Status model.SubscriberStatus
+		Count  int
+	}
+	query := `
+		select
+			case
+				when suppressed = 'complained' then 'complained'
+				when not active then 'unsubscribed'
+				when suppressed is not null then suppressed
+				when confirmed then 'confirmed'
+				else 'pending'
+			end as status,
+			count(*) as count
+		from newsletter_subscribers
+		where deleted is null
+		group by 1`
+	if err := d.DB.SelectContext(ctx, &byStatus, query); err != nil {
+		return stats, err
+	}
+	for _, s := range byStatus {
+		stats.ByStatus[s.Status] = s.Count
+	}
+
+	query = `
+		select count(s.email)
+		from generate_series(current_date - ($1::int - 1), current_date, '1 day') as day
+			left join newsletter_subscribers s on s.created::date = day and s.deleted is null
+		group by day
+		order by day`
+	if err := d.DB.SelectContext(ctx, &stats.SignupsPerDay, query, days); err != nil {
+		return stats, err
+	}
+
+	query = `
+		select count(*) as signups, count(*) filter (where confirmed) as confirmations
+		from newsletter_subscribers
+		where deleted is null and created >= current_date - ($1::int - 1)`
+	err := d.DB.GetContext(ctx, &stats, query, days)
+	return stats, err
+}
+
+// SendStats over the last days, including today. Test sends aren't counted.
+func (d *Database) SendStats(ctx context.Context, days int) (model.SendStats, error) {
+	ctx = withQueryName(ctx, "SendStats")
+	var stats model.SendStats
+	query := `
+		select
+			(select count(*) from email_sends where status = $2 and not test and created >= current_date - ($1::int - 1)) as sent,
+			(select count(*) from email_sends where status = $3 and not test and created >= current_date - ($1::int - 1)) as failed,
+			count(*) filter (where suppressed = $4) as bounced,
+			count(*) filter (where suppressed = $5) as complained
+		from newsletter_subscribers
+		where suppressed_at >= current_date - ($1::int - 1)`
+	err := d.DB.GetContext(ctx, &stats, query, days, model.EmailSendStatusSent, model.EmailSendStatusFailed,
+		model.SuppressionReasonBounced, model.SuppressionReasonComplained)
+	return stats, err
+}
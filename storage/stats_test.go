@@ -0,0 +1,77 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/model"
+)
+
+func TestDatabase_SubscriberStats(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("counts subscribers by status and signups per day", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, email := range []model.Email{"a@example.com", "b@example.com", "c@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+			is.NoErr(err)
+		}
+		token, err := db.SignupForNewsletter(context.Background(), "d@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "c@example.com")
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "b@example.com", model.SuppressionReasonBounced)
+		is.NoErr(err)
+		_, err = db.DB.Exec(`update newsletter_subscribers set created = now() - interval '2 days' where email = 'a@example.com'`)
+		is.NoErr(err)
+
+		stats, err := db.SubscriberStats(context.Background(), 30)
+		is.NoErr(err)
+		is.Equal(map[model.SubscriberStatus]int{
+			model.SubscriberStatusPending:      1,
+			model.SubscriberStatusConfirmed:    1,
+			model.SubscriberStatusUnsubscribed: 1,
+			model.SubscriberStatusBounced:      1,
+		}, stats.ByStatus)
+		is.Equal(30, len(stats.SignupsPerDay))
+		is.Equal(3, stats.SignupsPerDay[29])
+		is.Equal(1, stats.SignupsPerDay[27])
+		is.Equal(4, stats.Signups)
+		is.Equal(1, stats.Confirmations)
+	})
+}
+
+func TestDatabase_SendStats(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("counts sends without tests, and suppressions", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, s := range []model.EmailSend{
+			{Email: "a@example.com", Type: "newsletter_issue", Status: model.EmailSendStatusSent},
+			{Email: "b@example.com", Type: "newsletter_issue", Status: model.EmailSendStatusSent},
+			{Email: "c@example.com", Type: "newsletter_issue", Status: model.EmailSendStatusFailed},
+			{Email: "d@example.com", Type: "newsletter_issue_test_email", Status: model.EmailSendStatusSent, Test: true},
+		} {
+			is.NoErr(db.RecordEmailSend(context.Background(), s))
+		}
+		_, err := db.SignupForNewsletter(context.Background(), "a@example.com", "en", "")
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "a@example.com", model.SuppressionReasonComplained)
+		is.NoErr(err)
+
+		stats, err := db.SendStats(context.Background(), 30)
+		is.NoErr(err)
+		is.Equal(model.SendStats{Sent: 2, Failed: 1, Complained: 1}, stats)
+	})
+}
@@ -0,0 +1,277 @@
+// Package storagetest has a migrated Postgres database for integration tests that need one.
+//
+// The database server is the one at TEST_DATABASE_URL if it's set, like in CI, and otherwise a disposable
+// Postgres in a Docker container, started for the test binary. Either way, each test binary gets its own database
+// with a unique name, so test packages running in parallel don't share one.
+//
+// Usage, with a TestMain in the test package to remove the database and the container afterwards:
+//
+//	func TestMain(m *testing.M) {
+//		storagetest.Main(m)
+//	}
+//
+//	func TestDatabase_Thing(t *testing.T) {
+//		db := storagetest.NewDatabase(t)
+//		…
+//	}
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maragudk/migrate"
+
+	"canvas/storage"
+)
+
+// image of the Postgres container, the same as in docker-compose.yaml.
+const image = "postgres:12"
+
+// errNoDocker is returned when there's no Docker to start Postgres in, which skips the tests.
+var errNoDocker = errors.New("docker is not available")
+
+var (
+	once sync.Once
+	// server is the URL of the Postgres server, without a database name.
+	server *url.URL
+	// container is the ID of the Docker container with the server, if one was started.
+	container string
+	// name of the database of the test binary.
+	name     string
+	setupErr error
+)
+
+// NewDatabase for t, migrated and connected, with every table truncated when t and its subtests are done.
+// The database is shared by the tests in the test binary, so tests using it must not run in parallel.
+// It skips t with the "-short" flag, and when Docker isn't available and TEST_DATABASE_URL isn't set.
+func NewDatabase(t *testing.T) *storage.Database {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	once.Do(func() {
+		setupErr = setup()
+	})
+	if errors.Is(setupErr, errNoDocker) {
+		t.Skipf("skipping integration test, set TEST_DATABASE_URL or make Docker available: %v", setupErr)
+	}
+	if setupErr != nil {
+		t.Fatalf("error setting up the test database: %v", setupErr)
+	}
+
+	db, err := connect(name)
+	if err != nil {
+		t.Fatalf("error connecting to the test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if err := truncate(db); err != nil {
+			t.Errorf("error truncating the test database: %v", err)
+		}
+		if err := db.Close(); err != nil {
+			t.Errorf("error closing the test database: %v", err)
+		}
+	})
+
+	return db
+}
+
+// Main runs the tests with m, then drops the database and stops the container, if NewDatabase created them.
+// Call it from TestMain.
+func Main(m *testing.M) {
+	code := m.Run()
+	if err := teardown(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error tearing down the test database:", err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// setup the server and the migrated database of the test binary.
+func setup() error {
+	rawURL := os.Getenv("TEST_DATABASE_URL")
+	if rawURL == "" {
+		var err error
+		if rawURL, err = startContainer(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if server, err = url.Parse(rawURL); err != nil {
+		return fmt.Errorf("error parsing TEST_DATABASE_URL: %w", err)
+	}
+
+	admin, err := connect(strings.TrimPrefix(server.Path, "/"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = admin.Close()
+	}()
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name = "test_" + hex.EncodeToString(b)
+	if _, err := admin.DB.Exec(`create database ` + name); err != nil {
+		return fmt.Errorf("error creating database %v: %w", name, err)
+	}
+
+	db, err := connect(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	if err := migrate.Up(context.Background(), db.DB.DB, storage.Migrations()); err != nil {
+		return fmt.Errorf("error migrating database %v: %w", name, err)
+	}
+	return nil
+}
+
+// startContainer with Postgres on a free port, and return the URL of the server in it.
+func startContainer() (string, error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", fmt.Errorf("%w: %v", errNoDocker, err)
+	}
+	if out, err := exec.Command("docker", "info").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("%w: %v: %s", errNoDocker, err, strings.TrimSpace(string(out)))
+	}
+
+	// The container is removed when it's stopped.
+	out, err := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::5432",
+		"--env", "POSTGRES_USER=canvas", "--env", "POSTGRES_PASSWORD=123", image).Output()
+	if err != nil {
+		return "", fmt.Errorf("error starting Postgres container: %w", commandError(err))
+	}
+	container = strings.TrimSpace(string(out))
+
+	out, err = exec.Command("docker", "port", container, "5432/tcp").Output()
+	if err != nil {
+		return "", fmt.Errorf("error getting port of Postgres container: %w", commandError(err))
+	}
+	// There's a line for each address the port is published on, like "127.0.0.1:49153".
+	address := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+
+	return "postgresql://canvas:123@" + address + "/postgres", nil
+}
+
+// connect to the database with the name on the server, waiting for the server to accept connections,
+// which it doesn't right after the container started.
+func connect(name string) (*storage.Database, error) {
+	port := 5432
+	if server.Port() != "" {
+		var err error
+		if port, err = strconv.Atoi(server.Port()); err != nil {
+			return nil, fmt.Errorf("error parsing port of TEST_DATABASE_URL: %w", err)
+		}
+	}
+	password, _ := server.User.Password()
+	if name == "" {
+		name = "postgres"
+	}
+
+	db := storage.NewDatabase(storage.NewDatabaseOptions{
+		Host:               server.Hostname(),
+		Port:               port,
+		User:               server.User.Username(),
+		Password:           password,
+		Name:               name,
+		MaxOpenConnections: 10,
+		MaxIdleConnections: 10,
+	})
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		err := db.Ping(context.Background())
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().After(deadline) {
+			_ = db.Close()
+			return nil, fmt.Errorf("error connecting to database %v: %w", name, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// truncate every table but the migrations, so the next test starts with an empty database.
+func truncate(db *storage.Database) error {
+	var tables []string
+	query := `
+		select quote_ident(tablename) from pg_tables
+		where schemaname = current_schema() and tablename != 'migrations'`
+	if err := db.DB.Select(&tables, query); err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+	_, err := db.DB.Exec(`truncate ` + strings.Join(tables, ", ") + ` restart identity cascade`)
+	return err
+}
+
+// teardown drops the database of the test binary and stops the container, if there are any.
+func teardown() error {
+	if container != "" {
+		// Stopping the container removes it, with the database in it.
+		if err := exec.Command("docker", "stop", container).Run(); err != nil {
+			return fmt.Errorf("error stopping Postgres container %v: %w", container, commandError(err))
+		}
+		return nil
+	}
+	if server == nil || name == "" {
+		return nil
+	}
+
+	admin, err := connect(strings.TrimPrefix(server.Path, "/"))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = admin.Close()
+	}()
+
+	query := `
+		select pg_terminate_backend(pid) from pg_stat_activity
+		where datname = $1 and pid <> pg_backend_pid()`
+	if _, err := admin.DB.Exec(query, name); err != nil {
+		return err
+	}
+	if _, err := admin.DB.Exec(`drop database if exists ` + name); err != nil {
+		return fmt.Errorf("error dropping database %v: %w", name, err)
+	}
+	return nil
+}
+
+// commandError with the standard error output of the command, if there is any.
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/model"
+)
+
+// ListSubscribersOptions for ListSubscribers.
+type ListSubscribersOptions struct {
+	// After lists only subscribers with an email address after this one, for paging forward.
+	After model.Email
+	// Before lists only subscribers with an email address before this one, for paging back.
+	// The subscribers closest to it are listed, still in order. Ignored if After is set.
+	Before model.Email
+	Limit  int
+	// Segment lists only subscribers in this segment, if set.
+	Segment model.Segment
+	// Status lists only subscribers with this status, if set.
+	Status model.SubscriberStatus
+}
+
+// ListSubscribers ordered by email address. Deleted subscribers aren't listed.
+// Returns an apperr.Invalid error for an invalid segment.
+func (d *Database) ListSubscribers(ctx context.Context, opts ListSubscribersOptions) ([]model.Subscriber, error) {
+	ctx = withQueryName(ctx, "ListSubscribers")
+	segment, args, err := segmentCondition(opts.Segment, 6)
+	if err != nil {
+		return nil, err
+	}
+	var subscribers []model.Subscriber
+	backwards := opts.After == "" && opts.Before != ""
+	query := `
+		select id, email, confirmed, active, confirmed_at as confirmedat, coalesce(suppressed, '') as suppressed, locale,
+			tracking_opt_out as trackingoptout, source, created, updated
+		from newsletter_subscribers
+		where deleted is null and email > $1 and ($2 = '' or email < $2) and ` + subscriberStatusCondition("$3") + `
+			and ` + segment + `
+		order by case when $4 then email end desc, email
+		limit $5`
+	args = append([]any{opts.After, opts.Before, opts.Status, backwards, opts.Limit}, args...)
+	err = d.DB.SelectContext(ctx, &subscribers, query, args...)
+	if backwards {
+		for i, j := 0, len(subscribers)-1; i < j; i, j = i+1, j-1 {
+			subscribers[i], subscribers[j] = subscribers[j], subscribers[i]
+		}
+	}
+	return subscribers, err
+}
+
+// GetSubscriber by ID, or nil if there's none or they're deleted.
+func (d *Database) GetSubscriber(ctx context.Context, id int64) (*model.Subscriber, error) {
+	ctx = withQueryName(ctx, "GetSubscriber")
+	var s model.Subscriber
+	query := `
+		select id, email, confirmed, active, confirmed_at as confirmedat, coalesce(suppressed, '') as suppressed, locale,
+			tracking_opt_out as trackingoptout, source, created, updated
+		from newsletter_subscribers
+		where id = $1 and deleted is null`
+	if err := d.DB.GetContext(ctx, &s, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// SearchSubscribersOptions for SearchSubscribers.
+type SearchSubscribersOptions struct {
+	Limit int
+	// Query to find in email addresses, matched literally and case-insensitively.
+	Query string
+	// Status searches only subscribers with this status, if set.
+	Status model.SubscriberStatus
+}
+
+// SearchSubscribers with the query anywhere in their email address, ordered by email address.
+// Deleted subscribers aren't found.
+func (d *Database) SearchSubscribers(ctx context.Context, opts SearchSubscribersOptions) ([]model.Subscriber, error) {
+	ctx = withQueryName(ctx, "SearchSubscribers")
+	var subscribers []model.Subscriber
+	query := `
+		select id, email, confirmed, active, confirmed_at as confirmedat, coalesce(suppressed, '') as suppressed, locale,
+			tracking_opt_out as trackingoptout, source, created, updated
+		from newsletter_subscribers
+		where deleted is null and email ilike '%' || $1 || '%' and ` + subscriberStatusCondition("$2") + `
+		order by email
+		limit $3`
+	err := d.DB.SelectContext(ctx, &subscribers, query, escapeLike(opts.Query), opts.Status, opts.Limit)
+	return subscribers, err
+}
+
+// escapeLike so the wildcards % and _ in s match literally in a like pattern, with the default escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// subscriberStatusCondition for the SQL param with the status, which is true for subscribers with it,
+// or for everyone if it's empty.
+func subscriberStatusCondition(param string) string {
+	return strings.NewReplacer("$status", param).Replace(`(
+			$status = '' or
+			($status = 'pending' and active and suppressed is null and not confirmed) or
+			($status = 'confirmed' and active and suppressed is null and confirmed) or
+			($status = 'unsubscribed' and not active and suppressed is distinct from 'complained') or
+			($status = 'bounced' and active and suppressed = 'bounced') or
+			($status = 'complained' and suppressed = 'complained'))`)
+}
+
+// CountSubscribers with the status, or all if it's empty. Deleted subscribers aren't counted.
+func (d *Database) CountSubscribers(ctx context.Context, status model.SubscriberStatus) (int, error) {
+	ctx = withQueryName(ctx, "CountSubscribers")
+	var count int
+	query := `select count(*) from newsletter_subscribers where deleted is null and ` + subscriberStatusCondition("$1")
+	err := d.DB.GetContext(ctx, &count, query, status)
+	return count, err
+}
+
+// ExportSubscribers with the status, or all if it's empty, ordered by email address and at most limit of them.
+// Deleted subscribers aren't exported. Subscribers are read from the database with a cursor and passed to f
+// one at a time, so exporting doesn't hold them all in memory. An error from f stops the export and is returned,
+// and so does cancelling ctx.
+func (d *Database) ExportSubscribers(ctx context.Context, status model.SubscriberStatus, limit int, f func(model.Subscriber) error) error {
+	ctx = withQueryName(ctx, "ExportSubscribers")
+	query := `
+		select id, email, confirmed, active, confirmed_at as confirmedat, coalesce(suppressed, '') as suppressed, locale,
+			tracking_opt_out as trackingoptout, source, created, updated
+		from newsletter_subscribers
+		where deleted is null and ` + subscriberStatusCondition("$1") + `
+		order by email
+		limit $2`
+	rows, err := d.DB.QueryxContext(ctx, query, status, limit)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var s model.Subscriber
+		if err := rows.StructScan(&s); err != nil {
+			return err
+		}
+		if err := f(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SuppressSubscriber so they're not sent emails anymore, such as after a permanent bounce or a spam complaint.
+// An already suppressed subscriber keeps the first reason. Suppressing an address that never signed up is not an error.
+// Suppressing emits the subscriber.suppressed event through the outbox.
+// Complaints from the email provider should be recorded with RecordComplaint instead, which also overrides earlier reasons.
+func (d *Database) SuppressSubscriber(ctx context.Context, email model.Email, reason model.SuppressionReason) error {
+	ctx = withQueryName(ctx, "SuppressSubscriber")
+	query := `
+		update newsletter_subscribers
+		set suppressed = $2, suppressed_at = now(), complained_at = case when $2 = 'complained' then now() end, updated = now()
+		where email = $1 and suppressed is null`
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, query, email, reason)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		if err := insertSuppression(ctx, tx, email, reason, AuditActorEmailProvider); err != nil {
+			return err
+		}
+		return emitInTx(ctx, tx, model.SubscriberSuppressed{Email: email, Reason: reason})
+	})
+}
+
+// BouncePolicy for suppressing subscribers after bounces.
+type BouncePolicy struct {
+	// TransientThreshold is how many transient bounces within TransientWindow suppress a subscriber.
+	TransientThreshold int
+	TransientWindow    time.Duration
+}
+
+// RecordBounce on the send with the provider message ID in the send log, or as a send of its own if there's none,
+// and in the bounce events of the address, and suppress the subscriber as bounced
+// right away for a permanent bounce, or when they reach the threshold of transient bounces within the window
+// of the policy. Suppressing is recorded in the audit log, and emits the subscriber.suppressed event through the outbox.
+// Returns whether the subscriber is suppressed afterwards.
+// Apart from the send log, bounces for an address that never signed up are ignored.
+func (d *Database) RecordBounce(ctx context.Context, b model.Bounce, p BouncePolicy) (bool, error) {
+	ctx = withQueryName(ctx, "RecordBounce")
+	var suppressed bool
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		matched, err := recordEmailDelivery(ctx, tx, b.Email, b.ProviderMessageID, model.EmailDeliveryBounced, string(b.Type)+" bounce")
+		if err != nil {
+			return err
+		}
+
+		var s struct {
+			ID             int64
+			Suppressed     bool
+			BouncesResetAt *time.Time
+		}
+		query := `
+			select id, suppressed is not null as suppressed, bounces_reset_at as bouncesresetat
+			from newsletter_subscribers
+			where email = $1
+			for update`
+		if err := tx.GetContext(ctx, &s, query, b.Email); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+		suppressed = s.Suppressed
+
+		query = `insert into bounce_events (email, type, provider_message_id) values ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, query, b.Email, b.Type, b.ProviderMessageID); err != nil {
+			return err
+		}
+		if !matched {
+			query = `insert into email_sends (email, type, provider_message_id, status, error) values ($1, 'bounce', $2, $3, $4)`
+			if _, err := tx.ExecContext(ctx, query, b.Email, b.ProviderMessageID, model.EmailSendStatusBounced, string(b.Type)+" bounce"); err != nil {
+				return err
+			}
+		}
+		if suppressed {
+			return nil
+		}
+
+		details := map[string]string{"email": b.Email.String(), "reason": string(model.SuppressionReasonBounced), "bounce": string(b.Type)}
+		if b.Type != model.BounceTypePermanent {
+			var count int
+			query = `
+				select count(*) from bounce_events
+				where email = $1 and type = 'transient' and created > now() - $2 * interval '1 second' and created >= $3`
+			since := time.Time{}
+			if s.BouncesResetAt != nil {
+				since = *s.BouncesResetAt
+			}
+			if err := tx.GetContext(ctx, &count, query, b.Email, p.TransientWindow.Seconds(), since); err != nil {
+				return err
+			}
+			if count < p.TransientThreshold {
+				return nil
+			}
+			details["bounces"] = strconv.Itoa(count)
+		}
+
+		query = `
+			update newsletter_subscribers
+			set suppressed = $2, suppressed_at = now(), suppressed_bounce = $3, updated = now()
+			where id = $1`
+		if _, err := tx.ExecContext(ctx, query, s.ID, model.SuppressionReasonBounced, b.Type); err != nil {
+			return err
+		}
+		if err := insertSuppression(ctx, tx, b.Email, model.SuppressionReasonBounced, AuditActorEmailProvider); err != nil {
+			return err
+		}
+		if err := emitInTx(ctx, tx, model.SubscriberSuppressed{Email: b.Email, Reason: model.SuppressionReasonBounced}); err != nil {
+			return err
+		}
+		suppressed = true
+		return insertAuditEvent(ctx, tx, AuditActorEmailProvider, "subscriber.suppress", fmt.Sprintf("subscriber/%v", s.ID), details)
+	})
+	return suppressed, err
+}
+
+// RecordComplaint on the send with the provider message ID in the send log, or as a send of its own if there's none,
+// and suppress the subscriber as complained right away and for good,
+// even if they were suppressed for bounces before. Suppressing is recorded in the audit log,
+// and emits the subscriber.suppressed event through the outbox.
+// Unlike other suppressions, a complaint blocks signing up again, until an admin clears it with ClearComplaint.
+// Apart from the send log, complaints for an address that never signed up are ignored.
+func (d *Database) RecordComplaint(ctx context.Context, email model.Email, providerMessageID string) error {
+	ctx = withQueryName(ctx, "RecordComplaint")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		matched, err := recordEmailDelivery(ctx, tx, email, providerMessageID, model.EmailDeliveryComplained, "")
+		if err != nil {
+			return err
+		}
+
+		var s struct {
+			ID         int64
+			Complained bool
+		}
+		query := `
+			select id, complained_at is not null as complained
+			from newsletter_subscribers
+			where email = $1
+			for update`
+		if err := tx.GetContext(ctx, &s, query, email); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		if !matched {
+			query = `insert into email_sends (email, type, provider_message_id, status) values ($1, 'complaint', $2, $3)`
+			if _, err := tx.ExecContext(ctx, query, email, providerMessageID, model.EmailSendStatusComplained); err != nil {
+				return err
+			}
+		}
+		if s.Complained {
+			return nil
+		}
+
+		query = `
+			update newsletter_subscribers
+			set suppressed = $2, suppressed_at = now(), suppressed_bounce = null, complained_at = now(), updated = now()
+			where id = $1`
+		if _, err := tx.ExecContext(ctx, query, s.ID, model.SuppressionReasonComplained); err != nil {
+			return err
+		}
+		if err := insertSuppression(ctx, tx, email, model.SuppressionReasonComplained, AuditActorEmailProvider); err != nil {
+			return err
+		}
+		if err := emitInTx(ctx, tx, model.SubscriberSuppressed{Email: email, Reason: model.SuppressionReasonComplained}); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, AuditActorEmailProvider, "subscriber.suppress", fmt.Sprintf("subscriber/%v", s.ID),
+			map[string]string{"email": email.String(), "reason": string(model.SuppressionReasonComplained)})
+	})
+}
+
+// DeleteSubscriber with the id softly, so they're not listed or sent emails anymore, but the row stays for the audit log.
+// Signing up again afterwards starts over as a new signup. See changeSubscriber for the version and the returned email.
+func (d *Database) DeleteSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	ctx = withQueryName(ctx, "DeleteSubscriber")
+	return d.changeSubscriber(ctx, id, version, actor, "subscriber.delete", "deleted = now()", "true", nil)
+}
+
+// ConfirmSubscriber with the id without the confirmation link, for people whose email provider broke it.
+// Only pending subscribers can be confirmed. It emits the subscriber.confirmed event through the outbox,
+// but never as the first time, so there's no welcome email. See changeSubscriber for the version and the returned email.
+func (d *Database) ConfirmSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	ctx = withQueryName(ctx, "ConfirmSubscriber")
+	return d.changeSubscriber(ctx, id, version, actor, "subscriber.confirm",
+		"confirmed = true, confirmed_at = now()", "active and not confirmed",
+		func(tx *sqlx.Tx, email model.Email) error {
+			return emitInTx(ctx, tx, model.SubscriberConfirmed{Email: email, FirstTime: "false"})
+		})
+}
+
+// UnsubscribeSubscriber with the id, for people who asked to be unsubscribed some other way than the link.
+// Only active subscribers can be unsubscribed. Like unsubscribing with the link, it adds them to the suppression list
+// until they sign up again, and emits the subscriber.unsubscribed event through the outbox.
+// See changeSubscriber for the version and the returned email.
+func (d *Database) UnsubscribeSubscriber(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	ctx = withQueryName(ctx, "UnsubscribeSubscriber")
+	return d.changeSubscriber(ctx, id, version, actor, "subscriber.unsubscribe", "active = false", "active",
+		func(tx *sqlx.Tx, email model.Email) error {
+			if err := insertSuppression(ctx, tx, email, model.SuppressionReasonUnsubscribed, actor); err != nil {
+				return err
+			}
+			return emitInTx(ctx, tx, model.SubscriberUnsubscribed{Email: email, Actor: actor})
+		})
+}
+
+// ClearComplaint of the subscriber with the id, so they're not suppressed anymore and can sign up again.
+// It's for admins only, like when the subscriber asks to get the newsletter again after marking it as spam by mistake.
+// Only complained subscribers can be cleared. See changeSubscriber for the version and the returned email.
+func (d *Database) ClearComplaint(ctx context.Context, id int64, version time.Time, actor string) (model.Email, error) {
+	ctx = withQueryName(ctx, "ClearComplaint")
+	return d.changeSubscriber(ctx, id, version, actor, "subscriber.clear_complaint",
+		"suppressed = null, suppressed_at = null, complained_at = null", "complained_at is not null",
+		func(tx *sqlx.Tx, email model.Email) error {
+			return deleteSuppression(ctx, tx, email, model.SuppressionReasonComplained)
+		})
+}
+
+// changeSubscriber with the id with the set clause, if it matches the condition, and records the action by the actor
+// as an audit event in the same transaction. The version is the Updated time of the subscriber that was acted on,
+// so the change doesn't happen if anything changed since. Returns the email address of the subscriber,
+// or ErrConflict if there's no such subscriber, it's deleted, changed since, or doesn't match the condition.
+// If after isn't nil, it's called with the email address after the change, in the same transaction.
+func (d *Database) changeSubscriber(ctx context.Context, id int64, version time.Time, actor, action, set, condition string,
+	after func(tx *sqlx.Tx, email model.Email) error) (model.Email, error) {
+	var email model.Email
+	query := `
+		update newsletter_subscribers
+		set ` + set + `, updated = now()
+		where id = $1 and updated = $2 and deleted is null and (` + condition + `)
+		returning email`
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &email, query, id, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no subscriber %v at version %v to change: %w", id, version, ErrConflict)
+			}
+			return err
+		}
+		if after != nil {
+			if err := after(tx, email); err != nil {
+				return err
+			}
+		}
+		return insertAuditEvent(ctx, tx, actor, action, fmt.Sprintf("subscriber/%v", id), map[string]string{"email": email.String()})
+	})
+	return email, err
+}
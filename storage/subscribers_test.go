@@ -0,0 +1,609 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestDatabase_SuppressSubscriber(t *testing.T) {
+	t.Run("suppresses the subscriber, keeps the first reason, and filters them out of confirmed", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+
+		err = db.SuppressSubscriber(context.Background(), "me@example.com", model.SuppressionReasonBounced)
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "me@example.com", model.SuppressionReasonComplained)
+		is.NoErr(err)
+
+		subscribed, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			Limit: 10, Status: model.SubscriberStatusConfirmed})
+		is.NoErr(err)
+		is.Equal(0, len(subscribers))
+
+		subscribers, err = db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			Limit: 10, Status: model.SubscriberStatusBounced})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+		is.Equal(model.SuppressionReasonBounced, subscribers[0].Suppressed)
+		is.Equal(model.SubscriberStatusBounced, subscribers[0].Status())
+	})
+
+	t.Run("does not error for an address that never signed up", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		err := db.SuppressSubscriber(context.Background(), "me@example.com", model.SuppressionReasonComplained)
+		is.NoErr(err)
+	})
+}
+
+func TestDatabase_RecordBounce(t *testing.T) {
+	policy := storage.BouncePolicy{TransientThreshold: 3, TransientWindow: 7 * 24 * time.Hour}
+
+	bounce := func(is *is.I, db *storage.Database, email model.Email, typ model.BounceType) bool {
+		suppressed, err := db.RecordBounce(context.Background(), model.Bounce{Email: email, Type: typ, ProviderMessageID: "abc"}, policy)
+		is.NoErr(err)
+		return suppressed
+	}
+
+	t.Run("suppresses the subscriber at the first permanent bounce, with the audit event and send log", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		is.True(bounce(is, db, "me@example.com", model.BounceTypePermanent))
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(suppressed)
+
+		var actor string
+		err = db.DB.Get(&actor, `select actor from audit_events where action = 'subscriber.suppress'`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorEmailProvider, actor)
+
+		var status string
+		err = db.DB.Get(&status, `select status from email_sends where email = 'me@example.com' and type = 'bounce'`)
+		is.NoErr(err)
+		is.Equal(string(model.EmailSendStatusBounced), status)
+	})
+
+	t.Run("suppresses the subscriber as bounced at the transient threshold", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		for i := 1; i <= 3; i++ {
+			is.Equal(i == 3, bounce(is, db, "me@example.com", model.BounceTypeTransient))
+		}
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+		is.Equal(model.SuppressionReasonBounced, subscribers[0].Suppressed)
+	})
+
+	t.Run("does not count transient bounces outside the window", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypeTransient))
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypeTransient))
+		_, err = db.DB.Exec(`update bounce_events set created = now() - interval '8 days'`)
+		is.NoErr(err)
+
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypeTransient))
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypeTransient))
+		is.True(bounce(is, db, "me@example.com", model.BounceTypeTransient))
+	})
+
+	t.Run("reactivates a subscriber suppressed for transient bounces when they sign up again", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		for i := 0; i < 3; i++ {
+			bounce(is, db, "me@example.com", model.BounceTypeTransient)
+		}
+
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+
+		var actor string
+		err = db.DB.Get(&actor, `select actor from audit_events where action = 'subscriber.reactivate'`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorSubscriber, actor)
+
+		// The bounces from before signing up again don't count anymore.
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypeTransient))
+	})
+
+	t.Run("keeps a subscriber suppressed for a permanent bounce or a complaint when they sign up again", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "bounced@example.com", "en", "")
+		is.NoErr(err)
+		bounce(is, db, "bounced@example.com", model.BounceTypePermanent)
+		_, err = db.SignupForNewsletter(context.Background(), "complained@example.com", "en", "")
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "complained@example.com", model.SuppressionReasonComplained)
+		is.NoErr(err)
+
+		_, err = db.SignupForNewsletter(context.Background(), "bounced@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.SignupForNewsletter(context.Background(), "complained@example.com", "en", "")
+		is.True(errors.Is(err, storage.ErrComplained))
+
+		for _, email := range []model.Email{"bounced@example.com", "complained@example.com"} {
+			suppressed, err := db.IsSuppressed(context.Background(), email)
+			is.NoErr(err)
+			is.True(suppressed)
+		}
+	})
+
+	t.Run("ignores an address that never signed up", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		is.True(!bounce(is, db, "me@example.com", model.BounceTypePermanent))
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+	})
+}
+
+func TestDatabase_RecordComplaint(t *testing.T) {
+	// complainedSubscriber signs up and confirms the address, and records a complaint about it.
+	complainedSubscriber := func(is *is.I, db *storage.Database, email model.Email) {
+		token, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+		is.NoErr(err)
+		_, err = db.ConfirmNewsletterSignup(context.Background(), token)
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), email, "abc")
+		is.NoErr(err)
+	}
+
+	t.Run("suppresses the subscriber right away, with the audit event and send log", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		complainedSubscriber(is, db, "me@example.com")
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(suppressed)
+
+		var details string
+		err = db.DB.Get(&details, `select details::text from audit_events where action = 'subscriber.suppress' and actor = 'email_provider'`)
+		is.NoErr(err)
+		is.Equal(`{"email": "me@example.com", "reason": "complained"}`, details)
+
+		var status string
+		err = db.DB.Get(&status, `select status from email_sends where email = 'me@example.com' and type = 'complaint'`)
+		is.NoErr(err)
+		is.Equal(string(model.EmailSendStatusComplained), status)
+	})
+
+	t.Run("overrides a suppression for bounces", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		_, err = db.RecordBounce(context.Background(), model.Bounce{Email: "me@example.com", Type: model.BounceTypeTransient},
+			storage.BouncePolicy{TransientThreshold: 1, TransientWindow: time.Hour})
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+
+		// Signing up again would reactivate a subscriber suppressed for transient bounces, but not after a complaint.
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.True(errors.Is(err, storage.ErrComplained))
+	})
+
+	t.Run("blocks signing up again, without changing anything or sending a confirmation email", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		complainedSubscriber(is, db, "me@example.com")
+		before, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+
+		token, err := db.SignupForNewsletter(context.Background(), "me@example.com", "fr", "")
+		is.True(errors.Is(err, storage.ErrComplained))
+		is.Equal("", token)
+
+		after, err := db.GetOutboxMessages(context.Background(), 10)
+		is.NoErr(err)
+		is.Equal(len(before), len(after))
+
+		var locale string
+		err = db.DB.Get(&locale, `select locale from newsletter_subscribers where email = 'me@example.com'`)
+		is.NoErr(err)
+		is.Equal("en", locale)
+	})
+
+	t.Run("does not resend the confirmation to a pending subscriber that complained", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+
+		resent, err := db.ResendConfirmation(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!resent)
+	})
+
+	t.Run("leaves the subscriber out of the confirmed ones the newsletter is sent to, and lists them as complained", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		complainedSubscriber(is, db, "me@example.com")
+		err := db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			Limit: 10, Status: model.SubscriberStatusConfirmed})
+		is.NoErr(err)
+		is.Equal(0, len(subscribers))
+
+		// Complaints come before unsubscribing, so the admin can find and clear them.
+		subscribers, err = db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			Limit: 10, Status: model.SubscriberStatusComplained})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+		is.Equal(model.SubscriberStatusComplained, subscribers[0].Status())
+
+		count, err := db.CountSubscribers(context.Background(), model.SubscriberStatusUnsubscribed)
+		is.NoErr(err)
+		is.Equal(0, count)
+	})
+
+	t.Run("ignores an address that never signed up", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		err := db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+	})
+}
+
+func TestDatabase_ClearComplaint(t *testing.T) {
+	t.Run("lets the subscriber sign up again, and records the audit event", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+
+		email, err := db.ClearComplaint(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal(model.Email("me@example.com"), email)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		var actor string
+		err = db.DB.Get(&actor, `select actor from audit_events where action = 'subscriber.clear_complaint'`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorAdmin, actor)
+	})
+
+	t.Run("is a conflict for a subscriber that didn't complain", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+
+		_, err = db.ClearComplaint(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrConflict))
+	})
+}
+
+func TestDatabase_SubscriberAdminActions(t *testing.T) {
+	// signup and return the listed subscriber, to act on it at its version.
+	signup := func(is *is.I, db *storage.Database, email model.Email) model.Subscriber {
+		_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		for _, s := range subscribers {
+			if s.Email == email {
+				return s
+			}
+		}
+		is.Fail()
+		return model.Subscriber{}
+	}
+
+	t.Run("confirms a pending subscriber and records the audit event", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		s := signup(is, db, "me@example.com")
+		email, err := db.ConfirmSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal(model.Email("me@example.com"), email)
+
+		subscribed, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(subscribed)
+
+		var event struct {
+			Actor   string
+			Action  string
+			Target  string
+			Details string
+		}
+		err = db.DB.Get(&event, `select actor, action, target, details::text from audit_events`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorAdmin, event.Actor)
+		is.Equal("subscriber.confirm", event.Action)
+		is.Equal(fmt.Sprintf("subscriber/%v", s.ID), event.Target)
+		is.Equal(`{"email": "me@example.com"}`, event.Details)
+	})
+
+	t.Run("unsubscribes an active subscriber", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		s := signup(is, db, "me@example.com")
+		_, err := db.UnsubscribeSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+			Limit: 10, Status: model.SubscriberStatusUnsubscribed})
+		is.NoErr(err)
+		is.Equal(1, len(subscribers))
+
+		var action string
+		err = db.DB.Get(&action, `select action from audit_events`)
+		is.NoErr(err)
+		is.Equal("subscriber.unsubscribe", action)
+	})
+
+	t.Run("deletes a subscriber, who isn't listed, and starts over when signing up again", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		s := signup(is, db, "me@example.com")
+		_, err := db.ConfirmSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		s = signup(is, db, "me@example.com")
+		is.True(s.Confirmed)
+
+		_, err = db.DeleteSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(0, len(subscribers))
+		subscribed, err := db.IsSubscribed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!subscribed)
+
+		s = signup(is, db, "me@example.com")
+		is.Equal(model.SubscriberStatusPending, s.Status())
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from audit_events where target = $1`, fmt.Sprintf("subscriber/%v", s.ID))
+		is.NoErr(err)
+		is.Equal(2, count)
+	})
+
+	t.Run("conflicts for a stale version, a deleted or unknown subscriber, or one the action doesn't apply to", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		s := signup(is, db, "me@example.com")
+		_, err := db.ConfirmSubscriber(context.Background(), s.ID, s.Updated.Add(-time.Second), storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrConflict))
+
+		_, err = db.UnsubscribeSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		// The version is stale now, because unsubscribing changed the subscriber.
+		_, err = db.DeleteSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrConflict))
+
+		s = signup(is, db, "you@example.com")
+		_, err = db.DeleteSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		_, err = db.ConfirmSubscriber(context.Background(), s.ID, s.Updated, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrConflict))
+
+		_, err = db.UnsubscribeSubscriber(context.Background(), 123456, s.Updated, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrConflict))
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from audit_events`)
+		is.NoErr(err)
+		is.Equal(2, count)
+	})
+}
+
+func TestDatabase_ExportSubscribers(t *testing.T) {
+	t.Run("counts and exports subscribers with the status in order, up to the limit, without deleted ones", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		for _, email := range []model.Email{"c@example.com", "a@example.com", "b@example.com", "d@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+			is.NoErr(err)
+		}
+		err := db.Unsubscribe(context.Background(), "d@example.com")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 1})
+		is.NoErr(err)
+		_, err = db.DeleteSubscriber(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		count, err := db.CountSubscribers(context.Background(), model.SubscriberStatusPending)
+		is.NoErr(err)
+		is.Equal(2, count)
+		count, err = db.CountSubscribers(context.Background(), "")
+		is.NoErr(err)
+		is.Equal(3, count)
+
+		var exported []model.Email
+		err = db.ExportSubscribers(context.Background(), model.SubscriberStatusPending, 10, func(s model.Subscriber) error {
+			exported = append(exported, s.Email)
+			return nil
+		})
+		is.NoErr(err)
+		is.Equal([]model.Email{"b@example.com", "c@example.com"}, exported)
+
+		exported = nil
+		err = db.ExportSubscribers(context.Background(), "", 1, func(s model.Subscriber) error {
+			exported = append(exported, s.Email)
+			return nil
+		})
+		is.NoErr(err)
+		is.Equal([]model.Email{"b@example.com"}, exported)
+	})
+
+	t.Run("stops at the first error from f, and when the context is cancelled", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		for _, email := range []model.Email{"a@example.com", "b@example.com"} {
+			_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+			is.NoErr(err)
+		}
+
+		var calls int
+		err := db.ExportSubscribers(context.Background(), "", 10, func(s model.Subscriber) error {
+			calls++
+			return errors.New("oh no")
+		})
+		is.Equal("oh no", err.Error())
+		is.Equal(1, calls)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = db.ExportSubscribers(ctx, "", 10, func(s model.Subscriber) error {
+			return nil
+		})
+		is.True(errors.Is(err, context.Canceled))
+	})
+}
+
+func TestDatabase_RecordAuditEvent(t *testing.T) {
+	t.Run("records the event with its details", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		err := db.RecordAuditEvent(context.Background(), storage.AuditActorAdmin, "subscriber.export", "subscribers",
+			map[string]string{"status": "all"})
+		is.NoErr(err)
+
+		var details string
+		err = db.DB.Get(&details, `select details->>'status' from audit_events where action = 'subscriber.export'`)
+		is.NoErr(err)
+		is.Equal("all", details)
+	})
+}
+
+func TestDatabase_SearchSubscribers(t *testing.T) {
+	t.Run("finds subscribers with the query in their email address, with wildcards matched literally", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		for _, email := range []model.Email{"a_b@example.com", "axb@example.com", "100%@example.com", "1000@example.com", `back\slash@example.com`} {
+			_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+			is.NoErr(err)
+		}
+
+		search := func(query string, status model.SubscriberStatus) []model.Email {
+			subscribers, err := db.SearchSubscribers(context.Background(), storage.SearchSubscribersOptions{
+				Limit: 10, Query: query, Status: status})
+			is.NoErr(err)
+			var emails []model.Email
+			for _, s := range subscribers {
+				emails = append(emails, s.Email)
+			}
+			return emails
+		}
+
+		is.Equal([]model.Email{"a_b@example.com"}, search("A_B", ""))
+		is.Equal([]model.Email{"100%@example.com"}, search("0%@", ""))
+		is.Equal([]model.Email{`back\slash@example.com`}, search(`k\s`, ""))
+		is.Equal(5, len(search("example", "")))
+		is.Equal(0, len(search("example", model.SubscriberStatusConfirmed)))
+	})
+}
+
+func TestDatabase_GetSubscriber(t *testing.T) {
+	t.Run("gets the subscriber by ID, but not deleted or unknown ones", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+
+		s, err := db.GetSubscriber(context.Background(), subscribers[0].ID)
+		is.NoErr(err)
+		is.Equal(subscribers[0], *s)
+
+		s, err = db.GetSubscriber(context.Background(), subscribers[0].ID+1)
+		is.NoErr(err)
+		is.True(s == nil)
+
+		_, err = db.DeleteSubscriber(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+		s, err = db.GetSubscriber(context.Background(), subscribers[0].ID)
+		is.NoErr(err)
+		is.True(s == nil)
+	})
+}
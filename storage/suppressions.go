@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/model"
+)
+
+// AddSuppression of the email address to the suppression list for the reason, by the source, which is an audit actor
+// like AuditActorAdmin. It's recorded in the audit log. See insertSuppression for which reason wins
+// if the address is on the list already.
+func (d *Database) AddSuppression(ctx context.Context, email model.Email, reason model.SuppressionReason, source string) error {
+	ctx = withQueryName(ctx, "AddSuppression")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		if err := insertSuppression(ctx, tx, email, reason, source); err != nil {
+			return err
+		}
+		var id int64
+		if err := tx.GetContext(ctx, &id, `select id from suppressions where email_hash = email_hash($1)`, email); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, source, "suppression.add", fmt.Sprintf("suppression/%v", id),
+			map[string]string{"email": email.String(), "reason": string(reason)})
+	})
+}
+
+// insertSuppression of the email address with e, which is the transaction of the change that suppresses it.
+// An address is only on the list once. A complaint replaces any other reason, and any reason replaces unsubscribing,
+// since unsubscribing is undone by signing up again. Otherwise, the first reason stays.
+func insertSuppression(ctx context.Context, e sqlx.ExecerContext, email model.Email, reason model.SuppressionReason, source string) error {
+	query := `
+		insert into suppressions (email_hash, reason, source)
+		values (email_hash($1), $2, $3)
+		on conflict (email_hash) do update set reason = excluded.reason, source = excluded.source, created = now()
+		where suppressions.reason <> 'complained' and (excluded.reason = 'complained' or suppressions.reason = 'unsubscribed')`
+	_, err := e.ExecContext(ctx, query, email, reason, source)
+	return err
+}
+
+// deleteSuppression of the email address with e, if it's for one of the reasons,
+// in the transaction of the change that undoes it.
+func deleteSuppression(ctx context.Context, e sqlx.ExecerContext, email model.Email, reasons ...model.SuppressionReason) error {
+	for _, reason := range reasons {
+		query := `delete from suppressions where email_hash = email_hash($1) and reason = $2`
+		if _, err := e.ExecContext(ctx, query, email, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsSuppressed is true if the email address is on the suppression list, so it mustn't be sent any emails,
+// not even confirmations.
+func (d *Database) IsSuppressed(ctx context.Context, email model.Email) (bool, error) {
+	ctx = withQueryName(ctx, "IsSuppressed")
+	var suppressed bool
+	query := `select exists (select from suppressions where email_hash = email_hash($1))`
+	err := d.DB.GetContext(ctx, &suppressed, query, email)
+	return suppressed, err
+}
+
+// ListSuppressionsOptions for ListSuppressions.
+type ListSuppressionsOptions struct {
+	// Email lists only the suppression of this address, if set.
+	Email model.Email
+	Limit int
+}
+
+// ListSuppressions on the suppression list, newest first.
+func (d *Database) ListSuppressions(ctx context.Context, opts ListSuppressionsOptions) ([]model.Suppression, error) {
+	ctx = withQueryName(ctx, "ListSuppressions")
+	var suppressions []model.Suppression
+	query := `
+		select id, email_hash as emailhash, reason, source, created
+		from suppressions
+		where $1 = '' or email_hash = email_hash($1)
+		order by created desc, id desc
+		limit $2`
+	err := d.DB.SelectContext(ctx, &suppressions, query, opts.Email, opts.Limit)
+	return suppressions, err
+}
+
+// RemoveSuppression with the id from the suppression list, so the address can be sent emails again,
+// and records it by the actor in the audit log. The subscriber with the address isn't suppressed anymore either,
+// and can sign up again if they complained. Returns ErrNotFound if there's no such suppression.
+func (d *Database) RemoveSuppression(ctx context.Context, id int64, actor string) (model.Suppression, error) {
+	ctx = withQueryName(ctx, "RemoveSuppression")
+	var s model.Suppression
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			delete from suppressions
+			where id = $1
+			returning id, email_hash as emailhash, reason, source, created`
+		if err := tx.GetContext(ctx, &s, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no suppression %v: %w", id, ErrNotFound)
+			}
+			return err
+		}
+
+		query = `
+			update newsletter_subscribers
+			set suppressed = null, suppressed_at = null, suppressed_bounce = null, complained_at = null, updated = now()
+			where email_hash(email) = $1 and suppressed is not null`
+		if _, err := tx.ExecContext(ctx, query, s.EmailHash); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "suppression.remove", fmt.Sprintf("suppression/%v", id),
+			map[string]string{"emailHash": s.EmailHash, "reason": string(s.Reason), "source": s.Source})
+	})
+	return s, err
+}
@@ -0,0 +1,218 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+	"canvas/model"
+	"canvas/storage"
+)
+
+func TestDatabase_AddSuppression(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("suppresses the address in any case, and records the audit event", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.AddSuppression(context.Background(), "Me@Example.com", model.SuppressionReasonManual, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		for _, email := range []model.Email{"me@example.com", "ME@EXAMPLE.COM"} {
+			suppressed, err := db.IsSuppressed(context.Background(), email)
+			is.NoErr(err)
+			is.True(suppressed)
+		}
+		suppressed, err := db.IsSuppressed(context.Background(), "you@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+
+		var actor string
+		err = db.DB.Get(&actor, `select actor from audit_events where action = 'suppression.add'`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorAdmin, actor)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from suppressions where email_hash like '%example%'`)
+		is.NoErr(err)
+		is.Equal(0, count)
+	})
+
+	t.Run("keeps the address once, with a complaint over other reasons, and any reason over unsubscribing", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		reason := func() model.SuppressionReason {
+			suppressions, err := db.ListSuppressions(context.Background(), storage.ListSuppressionsOptions{Limit: 10})
+			is.NoErr(err)
+			is.Equal(1, len(suppressions))
+			return suppressions[0].Reason
+		}
+
+		add := func(r model.SuppressionReason) {
+			err := db.AddSuppression(context.Background(), "me@example.com", r, storage.AuditActorAdmin)
+			is.NoErr(err)
+		}
+
+		add(model.SuppressionReasonUnsubscribed)
+		is.Equal(model.SuppressionReasonUnsubscribed, reason())
+		add(model.SuppressionReasonBounced)
+		is.Equal(model.SuppressionReasonBounced, reason())
+		add(model.SuppressionReasonManual)
+		is.Equal(model.SuppressionReasonBounced, reason())
+		add(model.SuppressionReasonComplained)
+		is.Equal(model.SuppressionReasonComplained, reason())
+		add(model.SuppressionReasonUnsubscribed)
+		is.Equal(model.SuppressionReasonComplained, reason())
+	})
+}
+
+func TestDatabase_ListSuppressions(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("lists the newest first, or only the one of an address", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.AddSuppression(context.Background(), "me@example.com", model.SuppressionReasonManual, storage.AuditActorAdmin)
+		is.NoErr(err)
+		err = db.AddSuppression(context.Background(), "you@example.com", model.SuppressionReasonBounced, storage.AuditActorEmailProvider)
+		is.NoErr(err)
+
+		suppressions, err := db.ListSuppressions(context.Background(), storage.ListSuppressionsOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(2, len(suppressions))
+		is.Equal(model.SuppressionReasonBounced, suppressions[0].Reason)
+		is.Equal(storage.AuditActorEmailProvider, suppressions[0].Source)
+		is.Equal(64, len(suppressions[0].EmailHash))
+		is.Equal(model.SuppressionReasonManual, suppressions[1].Reason)
+
+		suppressions, err = db.ListSuppressions(context.Background(), storage.ListSuppressionsOptions{Email: "Me@example.com", Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(suppressions))
+		is.Equal(model.SuppressionReasonManual, suppressions[0].Reason)
+	})
+}
+
+func TestDatabase_RemoveSuppression(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("removes the suppression and the one of the subscriber, and records the audit event", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		err = db.RecordComplaint(context.Background(), "me@example.com", "abc")
+		is.NoErr(err)
+		suppressions, err := db.ListSuppressions(context.Background(), storage.ListSuppressionsOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(suppressions))
+		is.Equal(model.SuppressionReasonComplained, suppressions[0].Reason)
+
+		removed, err := db.RemoveSuppression(context.Background(), suppressions[0].ID, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.Equal(suppressions[0], removed)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(model.SuppressionReason(""), subscribers[0].Suppressed)
+
+		// Without the complaint, they can sign up again.
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		var actor string
+		err = db.DB.Get(&actor, `select actor from audit_events where action = 'suppression.remove'`)
+		is.NoErr(err)
+		is.Equal(storage.AuditActorAdmin, actor)
+
+		_, err = db.RemoveSuppression(context.Background(), suppressions[0].ID, storage.AuditActorAdmin)
+		is.True(errors.Is(err, storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_SuppressionsOfSubscribers(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("suppresses unsubscribed addresses until they sign up again", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		err = db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(suppressed)
+
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		suppressed, err = db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+	})
+
+	t.Run("suppresses addresses unsubscribed by the admin, by the admin", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+		subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{Limit: 10})
+		is.NoErr(err)
+		_, err = db.UnsubscribeSubscriber(context.Background(), subscribers[0].ID, subscribers[0].Updated, storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		suppressions, err := db.ListSuppressions(context.Background(), storage.ListSuppressionsOptions{Limit: 10})
+		is.NoErr(err)
+		is.Equal(1, len(suppressions))
+		is.Equal(model.SuppressionReasonUnsubscribed, suppressions[0].Reason)
+		is.Equal(storage.AuditActorAdmin, suppressions[0].Source)
+	})
+
+	t.Run("keeps a manual suppression when the address signs up again", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.AddSuppression(context.Background(), "me@example.com", model.SuppressionReasonManual, storage.AuditActorAdmin)
+		is.NoErr(err)
+		_, err = db.SignupForNewsletter(context.Background(), "me@example.com", "en", "")
+		is.NoErr(err)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(suppressed)
+	})
+
+	t.Run("doesn't suppress addresses that never signed up when unsubscribing them", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		err := db.Unsubscribe(context.Background(), "me@example.com")
+		is.NoErr(err)
+		err = db.SuppressSubscriber(context.Background(), "me@example.com", model.SuppressionReasonBounced)
+		is.NoErr(err)
+
+		suppressed, err := db.IsSuppressed(context.Background(), "me@example.com")
+		is.NoErr(err)
+		is.True(!suppressed)
+	})
+}
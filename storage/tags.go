@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/apperr"
+	"canvas/model"
+)
+
+// AddSubscriberTag to the subscriber with the id, and record it as an audit event by the actor.
+// Adding a tag the subscriber has already is not an error, and isn't recorded again.
+// Returns ErrNotFound if there's no such subscriber or they're deleted, and an apperr.Invalid error for an invalid tag.
+func (d *Database) AddSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	ctx = withQueryName(ctx, "AddSubscriberTag")
+	if !tag.IsValid() {
+		return apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid tag %q", tag))
+	}
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into subscriber_tags (subscriber_id, tag)
+			select id, $2 from newsletter_subscribers where id = $1 and deleted is null
+			on conflict do nothing`
+		res, err := tx.ExecContext(ctx, query, id, tag)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return subscriberExists(ctx, tx, id)
+		}
+		return insertAuditEvent(ctx, tx, actor, "subscriber.tag", "subscriber/"+strconv.FormatInt(id, 10),
+			map[string]string{"tag": tag.String()})
+	})
+}
+
+// RemoveSubscriberTag from the subscriber with the id, and record it as an audit event by the actor.
+// Removing a tag the subscriber doesn't have is not an error, and isn't recorded.
+// Returns ErrNotFound if there's no such subscriber or they're deleted.
+func (d *Database) RemoveSubscriberTag(ctx context.Context, id int64, tag model.Tag, actor string) error {
+	ctx = withQueryName(ctx, "RemoveSubscriberTag")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			delete from subscriber_tags t using newsletter_subscribers s
+			where t.subscriber_id = s.id and s.id = $1 and s.deleted is null and t.tag = $2`
+		res, err := tx.ExecContext(ctx, query, id, tag)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return subscriberExists(ctx, tx, id)
+		}
+		return insertAuditEvent(ctx, tx, actor, "subscriber.untag", "subscriber/"+strconv.FormatInt(id, 10),
+			map[string]string{"tag": tag.String()})
+	})
+}
+
+// subscriberExists returns ErrNotFound if there's no subscriber with the id, or they're deleted.
+func subscriberExists(ctx context.Context, tx *sqlx.Tx, id int64) error {
+	var exists bool
+	query := `select exists (select from newsletter_subscribers where id = $1 and deleted is null)`
+	if err := tx.GetContext(ctx, &exists, query, id); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListSubscriberTags of the subscriber with the id, ordered by tag.
+func (d *Database) ListSubscriberTags(ctx context.Context, id int64) ([]model.Tag, error) {
+	ctx = withQueryName(ctx, "ListSubscriberTags")
+	var tags []model.Tag
+	query := `select tag from subscriber_tags where subscriber_id = $1 order by tag`
+	err := d.DB.SelectContext(ctx, &tags, query, id)
+	return tags, err
+}
+
+// ListTags of the subscribers that aren't deleted, with how many of them have each, ordered by tag.
+func (d *Database) ListTags(ctx context.Context) ([]model.TagCount, error) {
+	ctx = withQueryName(ctx, "ListTags")
+	var tags []model.TagCount
+	query := `
+		select t.tag, count(*) as count
+		from subscriber_tags t
+			join newsletter_subscribers s on s.id = t.subscriber_id
+		where s.deleted is null
+		group by t.tag
+		order by t.tag`
+	err := d.DB.SelectContext(ctx, &tags, query)
+	return tags, err
+}
+
+// CountSubscribersInSegment with the status, or all if it's empty, like CountSubscribers.
+// The empty segment is every subscriber. Returns an apperr.Invalid error for an invalid segment.
+func (d *Database) CountSubscribersInSegment(ctx context.Context, segment model.Segment, status model.SubscriberStatus) (int, error) {
+	ctx = withQueryName(ctx, "CountSubscribersInSegment")
+	condition, args, err := segmentCondition(segment, 2)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	query := `select count(*) from newsletter_subscribers where deleted is null and ` + subscriberStatusCondition("$1") +
+		` and ` + condition
+	err = d.DB.GetContext(ctx, &count, query, append([]any{status}, args...)...)
+	return count, err
+}
+
+// segmentCondition on newsletter_subscribers for the subscribers in the segment, with the params of the tags
+// numbered from firstParam, and their args. Each tag is an exists subquery on subscriber_tags, combined with
+// the and and or of the segment, so the segment is evaluated by the database, with the index on the tags.
+// The empty segment is true. Returns an apperr.Invalid error for an invalid segment.
+func segmentCondition(segment model.Segment, firstParam int) (string, []any, error) {
+	e, err := segment.Parse()
+	if err != nil {
+		return "", nil, apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid segment %q: %w", segment, err))
+	}
+	if e == nil {
+		return "true", nil, nil
+	}
+	var args []any
+	var condition func(e model.SegmentExpr) string
+	condition = func(e model.SegmentExpr) string {
+		if e.Op == model.SegmentOpTag {
+			args = append(args, e.Tag)
+			return fmt.Sprintf("exists (select from subscriber_tags t where t.subscriber_id = newsletter_subscribers.id "+
+				"and t.tag = $%v)", firstParam+len(args)-1)
+		}
+		var operands []string
+		for _, o := range e.Operands {
+			operands = append(operands, condition(o))
+		}
+		return "(" + strings.Join(operands, " "+string(e.Op)+" ") + ")"
+	}
+	return condition(*e), args, nil
+}
@@ -0,0 +1,263 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestSegmentCondition(t *testing.T) {
+	tag := func(param int) string {
+		return fmt.Sprintf("exists (select from subscriber_tags t where t.subscriber_id = newsletter_subscribers.id and t.tag = $%v)", param)
+	}
+
+	tests := []struct {
+		segment   model.Segment
+		condition string
+		args      []any
+	}{
+		{"", "true", nil},
+		{"  ", "true", nil},
+		{"api", tag(3), []any{model.Tag("api")}},
+		{"API", tag(3), []any{model.Tag("api")}},
+		{"api and beta", "(" + tag(3) + " and " + tag(4) + ")", []any{model.Tag("api"), model.Tag("beta")}},
+		{"api or beta or early", "(" + tag(3) + " or " + tag(4) + " or " + tag(5) + ")",
+			[]any{model.Tag("api"), model.Tag("beta"), model.Tag("early")}},
+		{"api and beta or early", "((" + tag(3) + " and " + tag(4) + ") or " + tag(5) + ")",
+			[]any{model.Tag("api"), model.Tag("beta"), model.Tag("early")}},
+		{"api and (beta or early)", "(" + tag(3) + " and (" + tag(4) + " or " + tag(5) + "))",
+			[]any{model.Tag("api"), model.Tag("beta"), model.Tag("early")}},
+		{"((api))", tag(3), []any{model.Tag("api")}},
+		{"api or api", "(" + tag(3) + " or " + tag(4) + ")", []any{model.Tag("api"), model.Tag("api")}},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("translates %q to SQL with params from $3", test.segment), func(t *testing.T) {
+			is := is.New(t)
+
+			condition, args, err := storage.SegmentCondition(test.segment, 3)
+			is.NoErr(err)
+			is.Equal(test.condition, condition)
+			is.Equal(test.args, args)
+		})
+	}
+
+	for _, segment := range []model.Segment{"api and", "(api", "api beta", "'; drop table subscriber_tags; --"} {
+		t.Run(fmt.Sprintf("returns an invalid error for %q", segment), func(t *testing.T) {
+			is := is.New(t)
+
+			_, _, err := storage.SegmentCondition(segment, 1)
+			is.True(errors.Is(err, apperr.Invalid))
+			var segmentErr *model.SegmentError
+			is.True(errors.As(err, &segmentErr))
+		})
+	}
+}
+
+func TestDatabase_SubscriberTags(t *testing.T) {
+	signup := func(is *is.I, db *storage.Database, email model.Email) int64 {
+		_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+		is.NoErr(err)
+		var id int64
+		is.NoErr(db.DB.Get(&id, `select id from newsletter_subscribers where email = $1`, email))
+		return id
+	}
+
+	t.Run("adds and removes tags once, recording each change in the audit log", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id := signup(is, db, "me@example.com")
+		is.NoErr(db.AddSubscriberTag(context.Background(), id, "beta", storage.AuditActorAdmin))
+		is.NoErr(db.AddSubscriberTag(context.Background(), id, "api", storage.AuditActorAdmin))
+		is.NoErr(db.AddSubscriberTag(context.Background(), id, "beta", storage.AuditActorAdmin))
+
+		tags, err := db.ListSubscriberTags(context.Background(), id)
+		is.NoErr(err)
+		is.Equal([]model.Tag{"api", "beta"}, tags)
+
+		is.NoErr(db.RemoveSubscriberTag(context.Background(), id, "beta", storage.AuditActorAdmin))
+		is.NoErr(db.RemoveSubscriberTag(context.Background(), id, "beta", storage.AuditActorAdmin))
+		tags, err = db.ListSubscriberTags(context.Background(), id)
+		is.NoErr(err)
+		is.Equal([]model.Tag{"api"}, tags)
+
+		var events []string
+		err = db.DB.Select(&events, `select action || ' ' || target || ' ' || (details->>'tag') from audit_events order by created, id`)
+		is.NoErr(err)
+		target := fmt.Sprintf("subscriber/%v", id)
+		is.Equal([]string{"subscriber.tag " + target + " beta", "subscriber.tag " + target + " api",
+			"subscriber.untag " + target + " beta"}, events)
+	})
+
+	t.Run("doesn't add an invalid tag", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id := signup(is, db, "me@example.com")
+		err := db.AddSubscriberTag(context.Background(), id, "Beta Test", storage.AuditActorAdmin)
+		is.True(errors.Is(err, apperr.Invalid))
+	})
+
+	t.Run("returns not found for unknown and deleted subscribers", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		id := signup(is, db, "me@example.com")
+		is.NoErr(db.AddSubscriberTag(context.Background(), id, "api", storage.AuditActorAdmin))
+		_, err := db.DB.Exec(`update newsletter_subscribers set deleted = now() where id = $1`, id)
+		is.NoErr(err)
+
+		for _, id := range []int64{id, id + 1} {
+			err = db.AddSubscriberTag(context.Background(), id, "beta", storage.AuditActorAdmin)
+			is.True(errors.Is(err, storage.ErrNotFound))
+			err = db.RemoveSubscriberTag(context.Background(), id, "api", storage.AuditActorAdmin)
+			is.True(errors.Is(err, storage.ErrNotFound))
+		}
+	})
+
+	t.Run("lists the tags of subscribers that aren't deleted, with their counts", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		a := signup(is, db, "a@example.com")
+		b := signup(is, db, "b@example.com")
+		c := signup(is, db, "c@example.com")
+		is.NoErr(db.AddSubscriberTag(context.Background(), a, "beta", storage.AuditActorAdmin))
+		is.NoErr(db.AddSubscriberTag(context.Background(), a, "api", storage.AuditActorAdmin))
+		is.NoErr(db.AddSubscriberTag(context.Background(), b, "api", storage.AuditActorAdmin))
+		is.NoErr(db.AddSubscriberTag(context.Background(), c, "early", storage.AuditActorAdmin))
+		_, err := db.DeleteSubscriber(context.Background(), c, subscriberVersion(is, db, c), storage.AuditActorAdmin)
+		is.NoErr(err)
+
+		tags, err := db.ListTags(context.Background())
+		is.NoErr(err)
+		is.Equal([]model.TagCount{{Tag: "api", Count: 2}, {Tag: "beta", Count: 1}}, tags)
+	})
+}
+
+func TestDatabase_CountSubscribersInSegment(t *testing.T) {
+	// seed 20 subscribers, where every second is tagged api, every third beta, and every fifth early,
+	// and subscriber 6 is pending and 12 unsubscribed.
+	seed := func(is *is.I, db *storage.Database) {
+		for i := 1; i <= 20; i++ {
+			var id int64
+			err := db.DB.Get(&id, `
+				insert into newsletter_subscribers (email, token, confirmed, active)
+				values ($1, $2, $3, $4) returning id`,
+				fmt.Sprintf("me%02d@example.com", i), fmt.Sprint(i), i != 6, i != 12)
+			is.NoErr(err)
+			for tag, every := range map[model.Tag]int{"api": 2, "beta": 3, "early": 5} {
+				if i%every == 0 {
+					is.NoErr(db.AddSubscriberTag(context.Background(), id, tag, storage.AuditActorAdmin))
+				}
+			}
+		}
+	}
+
+	tests := []struct {
+		segment model.Segment
+		count   int
+	}{
+		{"", 18},
+		{"api", 8},
+		{"beta", 4},
+		{"api and beta", 1},
+		{"api or beta", 11},
+		{"api and (beta or early)", 3},
+		{"api and beta or early", 5},
+		{"unknown", 0},
+		{"unknown or early", 4},
+	}
+
+	t.Run("counts the confirmed subscribers in the segment", func(t *testing.T) {
+		db := storagetest.NewDatabase(t)
+		seed(is.New(t), db)
+
+		for _, test := range tests {
+			t.Run(string(test.segment), func(t *testing.T) {
+				is := is.New(t)
+
+				count, err := db.CountSubscribersInSegment(context.Background(), test.segment, model.SubscriberStatusConfirmed)
+				is.NoErr(err)
+				is.Equal(test.count, count)
+			})
+		}
+	})
+
+	t.Run("lists the same subscribers as it counts, in pages", func(t *testing.T) {
+		db := storagetest.NewDatabase(t)
+		seed(is.New(t), db)
+
+		for _, test := range tests {
+			t.Run(string(test.segment), func(t *testing.T) {
+				is := is.New(t)
+
+				var listed int
+				var after model.Email
+				for {
+					subscribers, err := db.ListSubscribers(context.Background(), storage.ListSubscribersOptions{
+						After: after, Limit: 3, Segment: test.segment, Status: model.SubscriberStatusConfirmed})
+					is.NoErr(err)
+					if len(subscribers) == 0 {
+						break
+					}
+					listed += len(subscribers)
+					after = subscribers[len(subscribers)-1].Email
+				}
+				is.Equal(test.count, listed)
+			})
+		}
+	})
+
+	t.Run("queues a send with the total of the segment, and keeps the segment when resuming it", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+		seed(is, db)
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+		is.NoErr(db.QueueNewsletterSend(context.Background(), n.ID, false, "API AND (beta OR early)"))
+
+		s, err := db.GetNewsletterSend(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal(model.Segment("api and (beta or early)"), s.Segment)
+		is.Equal(3, s.Total)
+
+		is.NoErr(db.FailNewsletterSend(context.Background(), n.ID, "oh no"))
+		is.NoErr(db.QueueNewsletterSend(context.Background(), n.ID, false, ""))
+		s, err = db.GetNewsletterSend(context.Background(), n.ID)
+		is.NoErr(err)
+		is.Equal(model.Segment("api and (beta or early)"), s.Segment)
+		is.Equal(3, s.Total)
+	})
+
+	t.Run("doesn't queue a send to an invalid segment", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		n, err := db.CreateNewsletter(context.Background(), "Issue 1", "Hello.")
+		is.NoErr(err)
+		err = db.QueueNewsletterSend(context.Background(), n.ID, false, "api and")
+		is.True(errors.Is(err, apperr.Invalid))
+
+		s, err := db.GetNewsletterSend(context.Background(), n.ID)
+		is.NoErr(err)
+		is.True(s == nil)
+	})
+}
+
+// subscriberVersion is the Updated time of the subscriber, for changing them.
+func subscriberVersion(is *is.I, db *storage.Database, id int64) time.Time {
+	s, err := db.GetSubscriber(context.Background(), id)
+	is.NoErr(err)
+	return s.Updated
+}
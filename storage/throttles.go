@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Throttle counts an action for key, and returns whether it's throttled because there have been
+// more than limit actions in the current window of the given length, including this one.
+// It works like throttle.MemoryStore, but is shared by all app instances using the database.
+// Keys are stored hashed, because they have email addresses and IP addresses in them.
+// Windows expire one window length after their last action, and are deleted by DeleteExpiredThrottles.
+func (d *Database) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	ctx = withQueryName(ctx, "Throttle")
+	query := `
+		insert into throttles (key, window_index, count, expires)
+		values ($1, floor(extract(epoch from now()) / $2)::bigint, 1, now() + make_interval(secs => $2))
+		on conflict (key) do update set
+			count = case when throttles.window_index = excluded.window_index then throttles.count + 1 else 1 end,
+			window_index = excluded.window_index,
+			expires = excluded.expires
+		returning count`
+	var count int
+	if err := d.DB.GetContext(ctx, &count, query, hashThrottleKey(key), window.Seconds()); err != nil {
+		return false, err
+	}
+	return count > limit, nil
+}
+
+// DeleteExpiredThrottles windows, which don't count anymore, and return how many were deleted.
+func (d *Database) DeleteExpiredThrottles(ctx context.Context) (int64, error) {
+	ctx = withQueryName(ctx, "DeleteExpiredThrottles")
+	res, err := d.DB.ExecContext(ctx, `delete from throttles where expires < now()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// hashThrottleKey like hashSessionToken, so the addresses in keys aren't stored.
+func hashThrottleKey(key string) string {
+	return hashSessionToken(key)
+}
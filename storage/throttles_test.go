@@ -0,0 +1,85 @@
+package storage_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+)
+
+func TestDatabase_Throttle(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("throttles after the limit per key, until the window ends", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for i := 0; i < 3; i++ {
+			throttled, err := db.Throttle(context.Background(), "a", 3, time.Hour)
+			is.NoErr(err)
+			is.True(!throttled)
+		}
+		throttled, err := db.Throttle(context.Background(), "a", 3, time.Hour)
+		is.NoErr(err)
+		is.True(throttled)
+
+		throttled, err = db.Throttle(context.Background(), "b", 3, time.Hour)
+		is.NoErr(err)
+		is.True(!throttled)
+
+		_, err = db.DB.Exec(`update throttles set window_index = window_index - 1 where key = encode(sha256('a'), 'hex')`)
+		is.NoErr(err)
+		throttled, err = db.Throttle(context.Background(), "a", 3, time.Hour)
+		is.NoErr(err)
+		is.True(!throttled)
+	})
+
+	t.Run("stores the keys hashed", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		_, err := db.Throttle(context.Background(), "signup-email:me@example.com", 3, time.Hour)
+		is.NoErr(err)
+
+		var keys []string
+		is.NoErr(db.DB.Select(&keys, `select key from throttles`))
+		is.Equal(1, len(keys))
+		is.Equal(64, len(keys[0]))
+		is.True(!strings.Contains(keys[0], "example.com"))
+	})
+}
+
+func TestDatabase_DeleteExpiredThrottles(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("deletes only the windows that expired", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		for _, key := range []string{"old", "new"} {
+			_, err := db.Throttle(context.Background(), key, 3, time.Hour)
+			is.NoErr(err)
+		}
+		_, err := db.DB.Exec(`update throttles set expires = now() - interval '1 minute' where key = encode(sha256('old'), 'hex')`)
+		is.NoErr(err)
+
+		n, err := db.DeleteExpiredThrottles(context.Background())
+		is.NoErr(err)
+		is.Equal(int64(1), n)
+
+		var keys []string
+		is.NoErr(db.DB.Select(&keys, `select key from throttles`))
+		is.Equal(1, len(keys))
+
+		throttled, err := db.Throttle(context.Background(), "new", 1, time.Hour)
+		is.NoErr(err)
+		is.True(throttled)
+	})
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/tracing"
+)
+
+// tracedConnector connects with the pgx connector, and starts a span for each query made in a trace,
+// with tracing.StartChild. Queries outside of a trace aren't traced. Every query is observed in the metrics,
+// and the errors of the driver are classified with classifyError.
+type tracedConnector struct {
+	driver.Connector
+	metrics *queryMetrics
+	name    string
+}
+
+func (c tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return &tracedConn{conn: conn.(pgxConn), metrics: c.metrics, name: c.name}, nil
+}
+
+// pgxConn is what database/sql uses of the connections of the pgx driver.
+type pgxConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.NamedValueChecker
+	driver.Pinger
+	driver.QueryerContext
+}
+
+// tracedConn passes everything on to the pgx connection, with spans and metrics for queries and prepared statements.
+type tracedConn struct {
+	conn    pgxConn
+	metrics *queryMetrics
+	name    string
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return &tracedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *tracedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *tracedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return classifiedTx{tx: tx}, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := c.startSpan(ctx, query)
+	defer span.End()
+	start := time.Now()
+	res, err := c.conn.ExecContext(ctx, query, args)
+	recordError(span, err)
+	c.metrics.observe(ctx, start, rowsAffected(res), err)
+	return res, classifyError(err)
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := c.startSpan(ctx, query)
+	start := time.Now()
+	rows, err := c.conn.QueryContext(ctx, query, args)
+	if err != nil {
+		recordError(span, err)
+		span.End()
+		c.metrics.observe(ctx, start, 0, err)
+		return nil, classifyError(err)
+	}
+	return &tracedRows{Rows: rows, ctx: ctx, metrics: c.metrics, span: span, start: start}, nil
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	return classifyError(c.conn.Ping(ctx))
+}
+
+// CheckNamedValue with pgx, which takes its own argument types, like arrays.
+func (c *tracedConn) CheckNamedValue(v *driver.NamedValue) error {
+	return c.conn.CheckNamedValue(v)
+}
+
+// startSpan for the query, named by its operation and table, like "SELECT newsletters",
+// so there are no values in it even if the query has literals.
+func (c *tracedConn) startSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	// Without a trace, there's nothing to name, so nothing is allocated.
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return tracing.StartChild(ctx, "canvas/storage", "")
+	}
+	operation, table := statementName(query)
+	name := operation
+	attributes := []attribute.KeyValue{
+		semconv.DBSystemPostgreSQL,
+		semconv.DBNameKey.String(c.name),
+		semconv.DBOperationKey.String(operation),
+	}
+	if table != "" {
+		name += " " + table
+		attributes = append(attributes, semconv.DBSQLTableKey.String(table))
+	}
+	return tracing.StartChild(ctx, "canvas/storage", name,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+}
+
+// tracedStmt is a prepared statement with a span for each time it's run.
+type tracedStmt struct {
+	driver.Stmt
+	conn  *tracedConn
+	query string
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := s.conn.startSpan(ctx, s.query)
+	defer span.End()
+	start := time.Now()
+	res, err := s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+	recordError(span, err)
+	s.conn.metrics.observe(ctx, start, rowsAffected(res), err)
+	return res, classifyError(err)
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := s.conn.startSpan(ctx, s.query)
+	start := time.Now()
+	rows, err := s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+	if err != nil {
+		recordError(span, err)
+		span.End()
+		s.conn.metrics.observe(ctx, start, 0, err)
+		return nil, classifyError(err)
+	}
+	return &tracedRows{Rows: rows, ctx: ctx, metrics: s.conn.metrics, span: span, start: start}, nil
+}
+
+// tracedRows end the span of their query and observe it when they're closed, so it includes reading them.
+// An error reading a row, like the deadline passing, is the outcome of the query.
+type tracedRows struct {
+	driver.Rows
+	ctx     context.Context
+	err     error
+	metrics *queryMetrics
+	rows    int64
+	span    trace.Span
+	start   time.Time
+}
+
+func (r *tracedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.rows++
+	case err != io.EOF:
+		r.err = err
+		return classifyError(err)
+	}
+	return err
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	recordError(r.span, err)
+	r.span.End()
+	if r.err == nil {
+		r.err = err
+	}
+	r.metrics.observe(r.ctx, r.start, r.rows, r.err)
+	return classifyError(err)
+}
+
+// classifiedTx classifies the errors of committing, which is when serialization failures can happen.
+type classifiedTx struct {
+	tx driver.Tx
+}
+
+func (t classifiedTx) Commit() error {
+	return classifyError(t.tx.Commit())
+}
+
+func (t classifiedTx) Rollback() error {
+	return classifyError(t.tx.Rollback())
+}
+
+// rowsAffected by an exec, or zero if it failed or the driver can't tell.
+func rowsAffected(res driver.Result) int64 {
+	if res == nil {
+		return 0
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func recordError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// statementName of the query, which is its operation, like SELECT, and the table it's on, if it's simple to tell.
+func statementName(query string) (operation, table string) {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	operation = strings.ToUpper(fields[0])
+
+	var after string
+	switch operation {
+	case "SELECT", "DELETE":
+		after = "from"
+	case "INSERT":
+		after = "into"
+	case "UPDATE":
+		if len(fields) > 1 {
+			return operation, cleanTableName(fields[1])
+		}
+		return operation, ""
+	default:
+		return operation, ""
+	}
+	for i := 1; i < len(fields)-1; i++ {
+		if strings.EqualFold(fields[i], after) {
+			return operation, cleanTableName(fields[i+1])
+		}
+	}
+	return operation, ""
+}
+
+// cleanTableName from a statement, without quotes, or anything after the name, like parentheses.
+// Subqueries aren't tables, so they're empty.
+func cleanTableName(s string) string {
+	if strings.HasPrefix(s, "(") {
+		return ""
+	}
+	if i := strings.IndexAny(s, "(),;"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, `"`)
+}
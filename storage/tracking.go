@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// RecordEmailOpen of the newsletter issue by the subscriber at the time, counting repeat opens,
+// and keeping the first and last time it was opened.
+func (d *Database) RecordEmailOpen(ctx context.Context, newsletterID, subscriberID int64, at time.Time) error {
+	ctx = withQueryName(ctx, "RecordEmailOpen")
+	query := `
+		insert into email_opens (newsletter_id, subscriber_id, first_opened, last_opened)
+		values ($1, $2, $3, $3)
+		on conflict (newsletter_id, subscriber_id) do update set
+			opens = email_opens.opens + 1,
+			first_opened = least(email_opens.first_opened, excluded.first_opened),
+			last_opened = greatest(email_opens.last_opened, excluded.last_opened)`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID, subscriberID, at.UTC())
+	return err
+}
+
+// RecordEmailClick of the link to the URL in the newsletter issue by the subscriber at the time,
+// counting repeat clicks per link, and keeping the first and last time it was clicked.
+func (d *Database) RecordEmailClick(ctx context.Context, newsletterID, subscriberID int64, url string, at time.Time) error {
+	ctx = withQueryName(ctx, "RecordEmailClick")
+	query := `
+		insert into email_clicks (newsletter_id, subscriber_id, url, first_clicked, last_clicked)
+		values ($1, $2, $3, $4, $4)
+		on conflict (newsletter_id, subscriber_id, url) do update set
+			clicks = email_clicks.clicks + 1,
+			first_clicked = least(email_clicks.first_clicked, excluded.first_clicked),
+			last_clicked = greatest(email_clicks.last_clicked, excluded.last_clicked)`
+	_, err := d.DB.ExecContext(ctx, query, newsletterID, subscriberID, url, at.UTC())
+	return err
+}
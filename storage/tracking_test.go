@@ -0,0 +1,59 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/integrationtest"
+)
+
+func TestDatabase_RecordEmailOpen(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("counts repeat opens and keeps the first and last open", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		first := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+		last := first.Add(time.Hour)
+		is.NoErr(db.RecordEmailOpen(context.Background(), 1, 2, last))
+		// Jobs can run out of order, so an earlier open recorded later is still the first.
+		is.NoErr(db.RecordEmailOpen(context.Background(), 1, 2, first))
+		is.NoErr(db.RecordEmailOpen(context.Background(), 1, 3, first))
+
+		var open struct {
+			Opens       int
+			FirstOpened time.Time `db:"first_opened"`
+			LastOpened  time.Time `db:"last_opened"`
+		}
+		err := db.DB.Get(&open, `select opens, first_opened, last_opened from email_opens where newsletter_id = 1 and subscriber_id = 2`)
+		is.NoErr(err)
+		is.Equal(2, open.Opens)
+		is.True(open.FirstOpened.Equal(first))
+		is.True(open.LastOpened.Equal(last))
+	})
+}
+
+func TestDatabase_RecordEmailClick(t *testing.T) {
+	integrationtest.SkipIfShort(t)
+
+	t.Run("counts repeat clicks per link", func(t *testing.T) {
+		is := is.New(t)
+		db, cleanup := integrationtest.CreateDatabase()
+		defer cleanup()
+
+		at := time.Date(2022, 12, 24, 8, 0, 0, 0, time.UTC)
+		is.NoErr(db.RecordEmailClick(context.Background(), 1, 2, "https://example.org", at))
+		is.NoErr(db.RecordEmailClick(context.Background(), 1, 2, "https://example.org", at.Add(time.Minute)))
+		is.NoErr(db.RecordEmailClick(context.Background(), 1, 2, "https://example.net", at))
+
+		var clicks []int
+		err := db.DB.Select(&clicks, `select clicks from email_clicks where newsletter_id = 1 and subscriber_id = 2 order by url`)
+		is.NoErr(err)
+		is.Equal([]int{1, 2}, clicks)
+	})
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"canvas/apperr"
+	"canvas/model"
+)
+
+// webhookSecretPrefix of every webhook secret, so they're recognisable, like API tokens.
+const webhookSecretPrefix = "whsec_"
+
+// webhookEndpointRow of the webhook_endpoints table, with the event types separated by spaces.
+type webhookEndpointRow struct {
+	ID                  int64
+	URL                 string
+	Secret              string
+	EventTypes          string `db:"event_types"`
+	Enabled             bool
+	ConsecutiveFailures int    `db:"consecutive_failures"`
+	DisabledReason      string `db:"disabled_reason"`
+	Created             time.Time
+	Updated             time.Time
+}
+
+func (r webhookEndpointRow) endpoint() model.WebhookEndpoint {
+	return model.WebhookEndpoint{ID: r.ID, URL: r.URL, Secret: r.Secret, EventTypes: strings.Fields(r.EventTypes),
+		Enabled: r.Enabled, ConsecutiveFailures: r.ConsecutiveFailures, DisabledReason: r.DisabledReason,
+		Created: r.Created, Updated: r.Updated}
+}
+
+const webhookEndpointColumns = `id, url, secret, event_types, enabled, consecutive_failures, disabled_reason, created, updated`
+
+// CreateWebhookEndpoint for the URL and event types, with a new random secret for signing the deliveries,
+// and records it by the actor in the audit log. Returns an apperr.Invalid error for an unknown event type.
+func (d *Database) CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, actor string) (model.WebhookEndpoint, error) {
+	ctx = withQueryName(ctx, "CreateWebhookEndpoint")
+	for _, t := range eventTypes {
+		if !model.IsWebhookEventType(t) {
+			return model.WebhookEndpoint{}, apperr.Wrap(apperr.Invalid, fmt.Errorf("invalid event type %q", t))
+		}
+	}
+	secret, err := createSecret()
+	if err != nil {
+		return model.WebhookEndpoint{}, err
+	}
+
+	var r webhookEndpointRow
+	err = d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into webhook_endpoints (url, secret, event_types) values ($1, $2, $3)
+			returning ` + webhookEndpointColumns
+		if err := tx.GetContext(ctx, &r, query, url, webhookSecretPrefix+secret, strings.Join(eventTypes, " ")); err != nil {
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "webhook_endpoint.create", fmt.Sprintf("webhook_endpoint/%v", r.ID),
+			map[string]string{"url": url, "eventTypes": r.EventTypes})
+	})
+	return r.endpoint(), err
+}
+
+// ListWebhookEndpoints, oldest first.
+func (d *Database) ListWebhookEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	ctx = withQueryName(ctx, "ListWebhookEndpoints")
+	var rows []webhookEndpointRow
+	query := `select ` + webhookEndpointColumns + ` from webhook_endpoints order by created, id`
+	if err := d.DB.SelectContext(ctx, &rows, query); err != nil {
+		return nil, err
+	}
+	endpoints := make([]model.WebhookEndpoint, 0, len(rows))
+	for _, r := range rows {
+		endpoints = append(endpoints, r.endpoint())
+	}
+	return endpoints, nil
+}
+
+// GetWebhookEndpoint by ID. Returns nil if there's no such endpoint.
+func (d *Database) GetWebhookEndpoint(ctx context.Context, id int64) (*model.WebhookEndpoint, error) {
+	ctx = withQueryName(ctx, "GetWebhookEndpoint")
+	var r webhookEndpointRow
+	query := `select ` + webhookEndpointColumns + ` from webhook_endpoints where id = $1`
+	if err := d.DB.GetContext(ctx, &r, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	e := r.endpoint()
+	return &e, nil
+}
+
+// DeleteWebhookEndpoint with the id, and its deliveries, and records it by the actor in the audit log.
+// Returns ErrNotFound if there's no such endpoint.
+func (d *Database) DeleteWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	ctx = withQueryName(ctx, "DeleteWebhookEndpoint")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var url string
+		if err := tx.GetContext(ctx, &url, `delete from webhook_endpoints where id = $1 returning url`, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no webhook endpoint %v: %w", id, ErrNotFound)
+			}
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "webhook_endpoint.delete", fmt.Sprintf("webhook_endpoint/%v", id),
+			map[string]string{"url": url})
+	})
+}
+
+// EnableWebhookEndpoint with the id again after it was disabled, resetting its failures,
+// and records it by the actor in the audit log. Deliveries that were skipped while it was disabled aren't retried.
+// Returns ErrNotFound if there's no such endpoint.
+func (d *Database) EnableWebhookEndpoint(ctx context.Context, id int64, actor string) error {
+	ctx = withQueryName(ctx, "EnableWebhookEndpoint")
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			update webhook_endpoints
+			set enabled = true, consecutive_failures = 0, disabled_reason = '', updated = now()
+			where id = $1
+			returning url`
+		var url string
+		if err := tx.GetContext(ctx, &url, query, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no webhook endpoint %v: %w", id, ErrNotFound)
+			}
+			return err
+		}
+		return insertAuditEvent(ctx, tx, actor, "webhook_endpoint.enable", fmt.Sprintf("webhook_endpoint/%v", id),
+			map[string]string{"url": url})
+	})
+}
+
+// enqueueWebhookDeliveriesInTx of the domain event message to the enabled endpoints that subscribe to its type,
+// in the transaction it's emitted in, with a webhook_delivery job for each.
+func enqueueWebhookDeliveriesInTx(ctx context.Context, tx *sqlx.Tx, m model.Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	query := `
+		insert into webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		select id, $1, $2, $3
+		from webhook_endpoints
+		where enabled and $2 = any(string_to_array(event_types, ' '))
+		returning id`
+	if err := tx.SelectContext(ctx, &ids, query, m["eventID"], m["eventType"], string(payload)); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := EnqueueInTx(ctx, tx, model.Message{
+			"job":        model.WebhookDeliveryRequested{}.JobName(),
+			"deliveryID": strconv.FormatInt(id, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookDeliveryRow of the webhook_deliveries table.
+type webhookDeliveryRow struct {
+	ID         int64
+	EndpointID int64  `db:"endpoint_id"`
+	EventID    string `db:"event_id"`
+	EventType  string `db:"event_type"`
+	Payload    string
+	State      model.WebhookDeliveryState
+	Attempts   int
+	Created    time.Time
+	Updated    time.Time
+}
+
+func (r webhookDeliveryRow) delivery() model.WebhookDelivery {
+	return model.WebhookDelivery{ID: r.ID, EndpointID: r.EndpointID, EventID: r.EventID, EventType: r.EventType,
+		Payload: r.Payload, State: r.State, Attempts: r.Attempts, Created: r.Created, Updated: r.Updated}
+}
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event_type, payload, state, attempts, created, updated`
+
+// GetWebhookDelivery by ID, without its attempt log. Returns nil if there's no such delivery,
+// like after its endpoint was deleted.
+func (d *Database) GetWebhookDelivery(ctx context.Context, id int64) (*model.WebhookDelivery, error) {
+	ctx = withQueryName(ctx, "GetWebhookDelivery")
+	var r webhookDeliveryRow
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where id = $1`
+	if err := d.DB.GetContext(ctx, &r, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	delivery := r.delivery()
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries to the endpoint, newest first, up to limit, with their attempt logs, for the delivery log.
+func (d *Database) ListWebhookDeliveries(ctx context.Context, endpointID int64, limit int) ([]model.WebhookDelivery, error) {
+	ctx = withQueryName(ctx, "ListWebhookDeliveries")
+	var rows []webhookDeliveryRow
+	query := `
+		select ` + webhookDeliveryColumns + `
+		from webhook_deliveries
+		where endpoint_id = $1
+		order by created desc, id desc
+		limit $2`
+	if err := d.DB.SelectContext(ctx, &rows, query, endpointID, limit); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return []model.WebhookDelivery{}, nil
+	}
+
+	var attempts []struct {
+		DeliveryID int64 `db:"delivery_id"`
+		StatusCode int   `db:"status_code"`
+		Error      string
+		DurationMS int64 `db:"duration_ms"`
+		Created    time.Time
+	}
+	query = `
+		select a.delivery_id, a.status_code, a.error, a.duration_ms, a.created
+		from webhook_delivery_attempts a
+			join (
+				select id from webhook_deliveries where endpoint_id = $1 order by created desc, id desc limit $2
+			) d on d.id = a.delivery_id
+		order by a.created, a.id`
+	if err := d.DB.SelectContext(ctx, &attempts, query, endpointID, limit); err != nil {
+		return nil, err
+	}
+	byDelivery := map[int64][]model.WebhookDeliveryAttempt{}
+	for _, a := range attempts {
+		byDelivery[a.DeliveryID] = append(byDelivery[a.DeliveryID], model.WebhookDeliveryAttempt{DeliveryID: a.DeliveryID,
+			StatusCode: a.StatusCode, Error: a.Error, Duration: time.Duration(a.DurationMS) * time.Millisecond, Created: a.Created})
+	}
+
+	deliveries := make([]model.WebhookDelivery, 0, len(rows))
+	for _, r := range rows {
+		delivery := r.delivery()
+		delivery.AttemptLog = byDelivery[r.ID]
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookDeliveryAttempt in the attempt log of its delivery, which is then in the state.
+// A delivered delivery resets the consecutive failures of its endpoint, and a failed one counts one more,
+// disabling the endpoint when there are disableAfter, which is recorded in the audit log.
+// Returns whether the endpoint was disabled by this attempt.
+func (d *Database) RecordWebhookDeliveryAttempt(ctx context.Context, a model.WebhookDeliveryAttempt,
+	state model.WebhookDeliveryState, disableAfter int) (bool, error) {
+	ctx = withQueryName(ctx, "RecordWebhookDeliveryAttempt")
+	var disabled bool
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into webhook_delivery_attempts (delivery_id, status_code, error, duration_ms)
+			values ($1, $2, $3, $4)`
+		if _, err := tx.ExecContext(ctx, query, a.DeliveryID, a.StatusCode, a.Error, a.Duration.Milliseconds()); err != nil {
+			return err
+		}
+
+		var endpointID int64
+		query = `
+			update webhook_deliveries
+			set state = $2, attempts = attempts + 1, updated = now()
+			where id = $1
+			returning endpoint_id`
+		if err := tx.GetContext(ctx, &endpointID, query, a.DeliveryID, state); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no webhook delivery %v: %w", a.DeliveryID, ErrNotFound)
+			}
+			return err
+		}
+
+		switch state {
+		case model.WebhookDeliveryStateDelivered:
+			query = `update webhook_endpoints set consecutive_failures = 0 where id = $1 and consecutive_failures > 0`
+			_, err := tx.ExecContext(ctx, query, endpointID)
+			return err
+		case model.WebhookDeliveryStateFailed:
+			var e struct {
+				Enabled             bool
+				ConsecutiveFailures int `db:"consecutive_failures"`
+			}
+			query = `select enabled, consecutive_failures from webhook_endpoints where id = $1 for update`
+			if err := tx.GetContext(ctx, &e, query, endpointID); err != nil {
+				return err
+			}
+			query = `update webhook_endpoints set consecutive_failures = consecutive_failures + 1 where id = $1`
+			if _, err := tx.ExecContext(ctx, query, endpointID); err != nil {
+				return err
+			}
+			if !e.Enabled || e.ConsecutiveFailures+1 < disableAfter {
+				return nil
+			}
+
+			disabled = true
+			reason := fmt.Sprintf("%v deliveries in a row failed.", e.ConsecutiveFailures+1)
+			query = `update webhook_endpoints set enabled = false, disabled_reason = $2, updated = now() where id = $1`
+			if _, err := tx.ExecContext(ctx, query, endpointID, reason); err != nil {
+				return err
+			}
+			return insertAuditEvent(ctx, tx, AuditActorWebhookDelivery, "webhook_endpoint.disable",
+				fmt.Sprintf("webhook_endpoint/%v", endpointID), map[string]string{"reason": reason})
+		}
+		return nil
+	})
+	return disabled, err
+}
+
+// SkipWebhookDelivery with the id without attempting it, like because its endpoint is disabled.
+func (d *Database) SkipWebhookDelivery(ctx context.Context, id int64) error {
+	ctx = withQueryName(ctx, "SkipWebhookDelivery")
+	query := `update webhook_deliveries set state = $2, updated = now() where id = $1`
+	_, err := d.DB.ExecContext(ctx, query, id, model.WebhookDeliveryStateSkipped)
+	return err
+}
@@ -0,0 +1,187 @@
+package storage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/apperr"
+	"canvas/model"
+	"canvas/storage"
+	"canvas/storage/storagetest"
+)
+
+func TestDatabase_WebhookEndpoints(t *testing.T) {
+	t.Run("creates endpoints with a secret, and lists, enables, and deletes them, recording it in the audit log", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		e, err := db.CreateWebhookEndpoint(context.Background(), "https://example.com/webhooks",
+			[]string{model.EventSubscriberConfirmed, model.EventSubscriberUnsubscribed}, storage.AuditActorAdmin)
+		is.NoErr(err)
+		is.True(strings.HasPrefix(e.Secret, "whsec_"))
+		is.True(e.Enabled)
+		is.Equal([]string{model.EventSubscriberConfirmed, model.EventSubscriberUnsubscribed}, e.EventTypes)
+
+		endpoints, err := db.ListWebhookEndpoints(context.Background())
+		is.NoErr(err)
+		is.Equal(1, len(endpoints))
+		is.Equal(e.Secret, endpoints[0].Secret)
+
+		is.NoErr(db.EnableWebhookEndpoint(context.Background(), e.ID, storage.AuditActorAdmin))
+		is.NoErr(db.DeleteWebhookEndpoint(context.Background(), e.ID, storage.AuditActorAdmin))
+		got, err := db.GetWebhookEndpoint(context.Background(), e.ID)
+		is.NoErr(err)
+		is.True(got == nil)
+
+		var actions []string
+		is.NoErr(db.DB.Select(&actions, `select action from audit_events order by created, id`))
+		is.Equal([]string{"webhook_endpoint.create", "webhook_endpoint.enable", "webhook_endpoint.delete"}, actions)
+	})
+
+	t.Run("doesn't create an endpoint for an unknown event type", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		_, err := db.CreateWebhookEndpoint(context.Background(), "https://example.com/webhooks", []string{"newsletter.sent"},
+			storage.AuditActorAdmin)
+		is.True(errors.Is(err, apperr.Invalid))
+	})
+
+	t.Run("returns not found for enabling and deleting an unknown endpoint", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		is.True(errors.Is(db.EnableWebhookEndpoint(context.Background(), 1, storage.AuditActorAdmin), storage.ErrNotFound))
+		is.True(errors.Is(db.DeleteWebhookEndpoint(context.Background(), 1, storage.AuditActorAdmin), storage.ErrNotFound))
+	})
+}
+
+func TestDatabase_WebhookDeliveries(t *testing.T) {
+	create := func(is *is.I, db *storage.Database, eventTypes ...string) model.WebhookEndpoint {
+		e, err := db.CreateWebhookEndpoint(context.Background(), "https://example.com/webhooks", eventTypes, storage.AuditActorAdmin)
+		is.NoErr(err)
+		return e
+	}
+
+	// signup for the newsletter, emitting the signed up event, and return the deliveries it enqueued.
+	signup := func(is *is.I, db *storage.Database, email model.Email) []model.Message {
+		_, err := db.SignupForNewsletter(context.Background(), email, "en", "")
+		is.NoErr(err)
+		return outboxMessages(is, db, model.WebhookDeliveryRequested{}.JobName())
+	}
+
+	// delivery enqueued in the message.
+	delivery := func(is *is.I, db *storage.Database, m model.Message) model.WebhookDelivery {
+		id, err := strconv.ParseInt(m["deliveryID"], 10, 64)
+		is.NoErr(err)
+		d, err := db.GetWebhookDelivery(context.Background(), id)
+		is.NoErr(err)
+		is.True(d != nil)
+		return *d
+	}
+
+	t.Run("enqueues a delivery of the event to each enabled endpoint subscribing to it, in the same transaction", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		subscribed := create(is, db, model.EventSubscriberSignedUp, model.EventSubscriberConfirmed)
+		create(is, db, model.EventSubscriberConfirmed)
+		disabled := create(is, db, model.EventSubscriberSignedUp)
+		_, err := db.DB.Exec(`update webhook_endpoints set enabled = false where id = $1`, disabled.ID)
+		is.NoErr(err)
+
+		messages := signup(is, db, "me@example.com")
+		is.Equal(1, len(messages))
+		d := delivery(is, db, messages[0])
+		is.Equal(subscribed.ID, d.EndpointID)
+		is.Equal(model.EventSubscriberSignedUp, d.EventType)
+		is.Equal(model.WebhookDeliveryStatePending, d.State)
+
+		var payload model.Message
+		is.NoErr(json.Unmarshal([]byte(d.Payload), &payload))
+		is.Equal("me@example.com", payload["email"])
+		is.Equal(d.EventID, payload["eventID"])
+		is.Equal(outboxMessages(is, db, model.EventSubscriberSignedUp)[0], payload)
+	})
+
+	t.Run("records attempts, and disables the endpoint after failed deliveries in a row", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		e := create(is, db, model.EventSubscriberSignedUp)
+		var deliveries []model.WebhookDelivery
+		for _, email := range []model.Email{"a@example.com", "b@example.com", "c@example.com", "d@example.com"} {
+			messages := signup(is, db, email)
+			deliveries = append(deliveries, delivery(is, db, messages[len(messages)-1]))
+		}
+
+		record := func(d model.WebhookDelivery, statusCode int, state model.WebhookDeliveryState) bool {
+			disabled, err := db.RecordWebhookDeliveryAttempt(context.Background(), model.WebhookDeliveryAttempt{
+				DeliveryID: d.ID, StatusCode: statusCode, Duration: 20 * time.Millisecond}, state, 2)
+			is.NoErr(err)
+			return disabled
+		}
+
+		is.True(!record(deliveries[0], 500, model.WebhookDeliveryStatePending))
+		is.True(!record(deliveries[0], 500, model.WebhookDeliveryStateFailed))
+		is.True(!record(deliveries[1], 200, model.WebhookDeliveryStateDelivered))
+		got, err := db.GetWebhookEndpoint(context.Background(), e.ID)
+		is.NoErr(err)
+		is.Equal(0, got.ConsecutiveFailures)
+
+		is.True(!record(deliveries[2], 400, model.WebhookDeliveryStateFailed))
+		is.True(record(deliveries[3], 400, model.WebhookDeliveryStateFailed))
+		got, err = db.GetWebhookEndpoint(context.Background(), e.ID)
+		is.NoErr(err)
+		is.True(!got.Enabled)
+		is.Equal("2 deliveries in a row failed.", got.DisabledReason)
+
+		// The disabled endpoint gets no more deliveries.
+		is.Equal(len(deliveries), len(signup(is, db, "e@example.com")))
+
+		log, err := db.ListWebhookDeliveries(context.Background(), e.ID, 10)
+		is.NoErr(err)
+		is.Equal(4, len(log))
+		is.Equal(deliveries[3].ID, log[0].ID)
+		first := log[3]
+		is.Equal(model.WebhookDeliveryStateFailed, first.State)
+		is.Equal(2, first.Attempts)
+		is.Equal(2, len(first.AttemptLog))
+		is.Equal(500, first.AttemptLog[0].StatusCode)
+		is.Equal(20*time.Millisecond, first.AttemptLog[0].Duration)
+
+		var disables int
+		is.NoErr(db.DB.Get(&disables, `select count(*) from audit_events where action = 'webhook_endpoint.disable' and actor = $1`,
+			storage.AuditActorWebhookDelivery))
+		is.Equal(1, disables)
+
+		is.NoErr(db.EnableWebhookEndpoint(context.Background(), e.ID, storage.AuditActorAdmin))
+		got, err = db.GetWebhookEndpoint(context.Background(), e.ID)
+		is.NoErr(err)
+		is.True(got.Enabled)
+		is.Equal(0, got.ConsecutiveFailures)
+	})
+
+	t.Run("skips deliveries, and deletes them with their endpoint", func(t *testing.T) {
+		is := is.New(t)
+		db := storagetest.NewDatabase(t)
+
+		e := create(is, db, model.EventSubscriberSignedUp)
+		messages := signup(is, db, "me@example.com")
+		d := delivery(is, db, messages[0])
+		is.NoErr(db.SkipWebhookDelivery(context.Background(), d.ID))
+		is.Equal(model.WebhookDeliveryStateSkipped, delivery(is, db, messages[0]).State)
+
+		is.NoErr(db.DeleteWebhookEndpoint(context.Background(), e.ID, storage.AuditActorAdmin))
+		got, err := db.GetWebhookDelivery(context.Background(), d.ID)
+		is.NoErr(err)
+		is.True(got == nil)
+	})
+}
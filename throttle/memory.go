@@ -0,0 +1,65 @@
+// Package throttle limits how many times something can happen in a time window, such as signups per IP address.
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore counts actions per key in fixed time windows, in memory. It's not shared between app instances,
+// so use storage.Database.Throttle instead when running more than one.
+type MemoryStore struct {
+	lock      sync.Mutex
+	counts    map[string]memoryCount
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryCount struct {
+	count int
+	end   time.Time
+}
+
+// NewMemoryStoreOptions for NewMemoryStore.
+type NewMemoryStoreOptions struct {
+	// Now is for the window an action counts in. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewMemoryStore with no actions counted.
+func NewMemoryStore(opts NewMemoryStoreOptions) *MemoryStore {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &MemoryStore{
+		counts: map[string]memoryCount{},
+		now:    opts.Now,
+	}
+}
+
+// Throttle counts an action for key, and returns whether it's throttled because there have been
+// more than limit actions in the current window of the given length, including this one.
+// Throttled actions count too, so a burst keeps being throttled until the window ends.
+func (s *MemoryStore) Throttle(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, c := range s.counts {
+			if !now.Before(c.end) {
+				delete(s.counts, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	c, ok := s.counts[key]
+	if !ok || !now.Before(c.end) {
+		c = memoryCount{end: now.Truncate(window).Add(window)}
+	}
+	c.count++
+	s.counts[key] = c
+	return c.count > limit, nil
+}
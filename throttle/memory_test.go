@@ -0,0 +1,38 @@
+package throttle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/throttle"
+)
+
+func TestMemoryStore_Throttle(t *testing.T) {
+	t.Run("throttles after the limit per key, until the window ends", func(t *testing.T) {
+		is := is.New(t)
+
+		now := time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC)
+		s := throttle.NewMemoryStore(throttle.NewMemoryStoreOptions{Now: func() time.Time { return now }})
+
+		for i := 0; i < 3; i++ {
+			throttled, err := s.Throttle(context.Background(), "a", 3, time.Hour)
+			is.NoErr(err)
+			is.True(!throttled)
+		}
+		throttled, err := s.Throttle(context.Background(), "a", 3, time.Hour)
+		is.NoErr(err)
+		is.True(throttled)
+
+		throttled, err = s.Throttle(context.Background(), "b", 3, time.Hour)
+		is.NoErr(err)
+		is.True(!throttled)
+
+		now = now.Add(time.Hour)
+		throttled, err = s.Throttle(context.Background(), "a", 3, time.Hour)
+		is.NoErr(err)
+		is.True(!throttled)
+	})
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryExporter keeps the spans it exports, for tests.
+type MemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+// NewMemoryExporter without spans.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+func (e *MemoryExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// Spans exported so far, in the order they ended.
+func (e *MemoryExporter) Spans() []SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
@@ -0,0 +1,257 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// OTLPExporter exports spans to an OTLP collector over HTTP, with the JSON encoding of the protocol.
+type OTLPExporter struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+}
+
+// NewOTLPExporterOptions for NewOTLPExporter.
+type NewOTLPExporterOptions struct {
+	// Client for the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Endpoint for traces, like http://localhost:4318/v1/traces. Required.
+	Endpoint string
+	// Headers of each request, like for authentication with the collector.
+	Headers map[string]string
+	// Timeout of each export. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// NewOTLPExporter with the given options.
+func NewOTLPExporter(opts NewOTLPExporterOptions) *OTLPExporter {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &OTLPExporter{
+		client:   opts.Client,
+		endpoint: opts.Endpoint,
+		headers:  opts.Headers,
+		timeout:  opts.Timeout,
+	}
+}
+
+// ExportSpans in one request, grouped by the resource of the first span and then by scope.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(createOTLPRequest(spans))
+	if err != nil {
+		return fmt.Errorf("error marshalling spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending spans: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("error sending spans, got status " + strconv.Itoa(res.StatusCode))
+	}
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// otlpSpan in the JSON encoding, where IDs are hex, and 64-bit integers are strings.
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	TraceState        string         `json:"traceState,omitempty"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+// OTLP status codes, which are in a different order than in the API.
+const (
+	otlpStatusOk    = 1
+	otlpStatusError = 2
+)
+
+func createOTLPRequest(spans []SpanData) otlpRequest {
+	var scopes []otlpScopeSpans
+	indexes := map[string]int{}
+	for _, s := range spans {
+		i, ok := indexes[s.Scope]
+		if !ok {
+			i = len(scopes)
+			indexes[s.Scope] = i
+			scopes = append(scopes, otlpScopeSpans{Scope: otlpScope{Name: s.Scope}})
+		}
+		scopes[i].Spans = append(scopes[i].Spans, createOTLPSpan(s))
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: createOTLPAttributes(spans[0].Resource)},
+		ScopeSpans: scopes,
+	}}}
+}
+
+func createOTLPSpan(s SpanData) otlpSpan {
+	o := otlpSpan{
+		TraceID:           s.SpanContext.TraceID().String(),
+		SpanID:            s.SpanContext.SpanID().String(),
+		TraceState:        s.SpanContext.TraceState().String(),
+		Name:              s.Name,
+		Kind:              int(s.Kind),
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        createOTLPAttributes(s.Attributes),
+	}
+	if s.Parent.IsValid() {
+		o.ParentSpanID = s.Parent.SpanID().String()
+	}
+	for _, e := range s.Events {
+		o.Events = append(o.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(e.Time.UnixNano(), 10),
+			Name:         e.Name,
+			Attributes:   createOTLPAttributes(e.Attributes),
+		})
+	}
+	switch s.Status {
+	case codes.Ok:
+		o.Status.Code = otlpStatusOk
+	case codes.Error:
+		o.Status = otlpStatus{Code: otlpStatusError, Message: s.StatusDescription}
+	}
+	return o
+}
+
+func createOTLPAttributes(kvs []attribute.KeyValue) []otlpKeyValue {
+	var attributes []otlpKeyValue
+	for _, kv := range kvs {
+		attributes = append(attributes, otlpKeyValue{Key: string(kv.Key), Value: createOTLPValue(kv.Value)})
+	}
+	return attributes
+}
+
+func createOTLPValue(v attribute.Value) otlpAnyValue {
+	switch v.Type() {
+	case attribute.BOOL:
+		b := v.AsBool()
+		return otlpAnyValue{BoolValue: &b}
+	case attribute.INT64:
+		i := strconv.FormatInt(v.AsInt64(), 10)
+		return otlpAnyValue{IntValue: &i}
+	case attribute.FLOAT64:
+		f := v.AsFloat64()
+		return otlpAnyValue{DoubleValue: &f}
+	case attribute.BOOLSLICE:
+		var values []otlpAnyValue
+		for _, b := range v.AsBoolSlice() {
+			values = append(values, createOTLPValue(attribute.BoolValue(b)))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.INT64SLICE:
+		var values []otlpAnyValue
+		for _, i := range v.AsInt64Slice() {
+			values = append(values, createOTLPValue(attribute.Int64Value(i)))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.FLOAT64SLICE:
+		var values []otlpAnyValue
+		for _, f := range v.AsFloat64Slice() {
+			values = append(values, createOTLPValue(attribute.Float64Value(f)))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case attribute.STRINGSLICE:
+		var values []otlpAnyValue
+		for _, s := range v.AsStringSlice() {
+			values = append(values, createOTLPValue(attribute.StringValue(s)))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	default:
+		s := v.Emit()
+		return otlpAnyValue{StringValue: &s}
+	}
+}
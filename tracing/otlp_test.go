@@ -0,0 +1,96 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/tracing"
+)
+
+func TestOTLPExporter_ExportSpans(t *testing.T) {
+	t.Run("posts the spans as OTLP JSON, with the headers", func(t *testing.T) {
+		is := is.New(t)
+
+		var body map[string]interface{}
+		var header http.Header
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header = r.Header
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}))
+		defer s.Close()
+
+		e := tracing.NewOTLPExporter(tracing.NewOTLPExporterOptions{
+			Endpoint: s.URL + "/v1/traces",
+			Headers:  map[string]string{"Authorization": "Bearer secret"},
+		})
+		start := time.Unix(1, 0)
+		err := e.ExportSpans(context.Background(), []tracing.SpanData{{
+			Attributes: []attribute.KeyValue{attribute.Int("http.status_code", 500), attribute.Bool("ok", false)},
+			End:        start.Add(time.Second),
+			Kind:       trace.SpanKindServer,
+			Name:       "GET /",
+			Parent: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: trace.TraceID{1},
+				SpanID:  trace.SpanID{1},
+			}),
+			Resource: []attribute.KeyValue{attribute.String("service.name", "canvas")},
+			Scope:    "canvas/handlers",
+			SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: trace.TraceID{1},
+				SpanID:  trace.SpanID{2},
+			}),
+			Start:             start,
+			Status:            codes.Error,
+			StatusDescription: "Internal Server Error",
+		}})
+		is.NoErr(err)
+
+		is.Equal("application/json", header.Get("Content-Type"))
+		is.Equal("Bearer secret", header.Get("Authorization"))
+
+		resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		is.Equal([]interface{}{map[string]interface{}{
+			"key": "service.name", "value": map[string]interface{}{"stringValue": "canvas"},
+		}}, resourceSpans["resource"].(map[string]interface{})["attributes"])
+		scopeSpans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})
+		is.Equal(map[string]interface{}{"name": "canvas/handlers"}, scopeSpans["scope"])
+		span := scopeSpans["spans"].([]interface{})[0].(map[string]interface{})
+		is.Equal("01000000000000000000000000000000", span["traceId"])
+		is.Equal("0200000000000000", span["spanId"])
+		is.Equal("0100000000000000", span["parentSpanId"])
+		is.Equal("GET /", span["name"])
+		is.Equal(2.0, span["kind"])
+		is.Equal("1000000000", span["startTimeUnixNano"])
+		is.Equal("2000000000", span["endTimeUnixNano"])
+		is.Equal([]interface{}{
+			map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "500"}},
+			map[string]interface{}{"key": "ok", "value": map[string]interface{}{"boolValue": false}},
+		}, span["attributes"])
+		is.Equal(map[string]interface{}{"code": 2.0, "message": "Internal Server Error"}, span["status"])
+	})
+
+	t.Run("returns an error if the collector doesn't accept the spans", func(t *testing.T) {
+		is := is.New(t)
+
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer s.Close()
+
+		e := tracing.NewOTLPExporter(tracing.NewOTLPExporterOptions{Endpoint: s.URL})
+		err := e.ExportSpans(context.Background(), []tracing.SpanData{{Name: "GET /"}})
+		is.True(err != nil)
+		is.Equal("error sending spans, got status 400", err.Error())
+	})
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SpanData of an ended span, for exporters.
+type SpanData struct {
+	Attributes []attribute.KeyValue
+	End        time.Time
+	Events     []Event
+	Kind       trace.SpanKind
+	Name       string
+	// Parent of the span, which isn't valid for root spans.
+	Parent trace.SpanContext
+	// Resource attributes of the provider that recorded the span.
+	Resource []attribute.KeyValue
+	// Scope is the name of the tracer that started the span, like canvas/handlers.
+	Scope             string
+	SpanContext       trace.SpanContext
+	Start             time.Time
+	Status            codes.Code
+	StatusDescription string
+}
+
+// Event in a span, like an error recorded with RecordError.
+type Event struct {
+	Attributes []attribute.KeyValue
+	Name       string
+	Time       time.Time
+}
+
+// maxEvents in a span. Later events are dropped, so a span in a loop can't grow without bounds.
+const maxEvents = 128
+
+// span that's recording until it's ended.
+type span struct {
+	data     SpanData
+	ended    bool
+	lock     sync.Mutex
+	provider *Provider
+}
+
+// End the span, which exports it. Ending it again does nothing.
+func (s *span) End(opts ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(opts...)
+	end := cfg.Timestamp()
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = end
+	data := s.data
+	s.lock.Unlock()
+
+	s.provider.enqueue(data)
+}
+
+func (s *span) AddEvent(name string, opts ...trace.EventOption) {
+	cfg := trace.NewEventConfig(opts...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended || len(s.data.Events) >= maxEvents {
+		return
+	}
+	s.data.Events = append(s.data.Events, Event{
+		Attributes: cfg.Attributes(),
+		Name:       name,
+		Time:       cfg.Timestamp(),
+	})
+}
+
+func (s *span) IsRecording() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.ended
+}
+
+// RecordError as an exception event, like the OpenTelemetry SDK. It doesn't set the status.
+func (s *span) RecordError(err error, opts ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	opts = append(opts, trace.WithAttributes(
+		semconv.ExceptionTypeKey.String(fmt.Sprintf("%T", err)),
+		semconv.ExceptionMessageKey.String(err.Error()),
+	))
+	s.AddEvent("exception", opts...)
+}
+
+func (s *span) SpanContext() trace.SpanContext {
+	return s.data.SpanContext
+}
+
+// SetStatus of the span. Ok is final, and the description is only kept for Error, like the OpenTelemetry SDK.
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended || code == codes.Unset || s.data.Status == codes.Ok {
+		return
+	}
+	s.data.Status = code
+	s.data.StatusDescription = ""
+	if code == codes.Error {
+		s.data.StatusDescription = description
+	}
+}
+
+func (s *span) SetName(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.data.Name = name
+	}
+}
+
+func (s *span) SetAttributes(kvs ...attribute.KeyValue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.ended {
+		s.data.Attributes = setAttributes(s.data.Attributes, kvs...)
+	}
+}
+
+func (s *span) TracerProvider() trace.TracerProvider {
+	return s.provider
+}
+
+// setAttributes on attributes, replacing any with the same key, and skipping invalid ones.
+func setAttributes(attributes []attribute.KeyValue, kvs ...attribute.KeyValue) []attribute.KeyValue {
+next:
+	for _, kv := range kvs {
+		if !kv.Valid() {
+			continue
+		}
+		for i := range attributes {
+			if attributes[i].Key == kv.Key {
+				attributes[i] = kv
+				continue next
+			}
+		}
+		attributes = append(attributes, kv)
+	}
+	return attributes
+}
@@ -0,0 +1,274 @@
+// Package tracing records OpenTelemetry spans and exports them in batches, like to an OTLP collector.
+// It's a small implementation of the OpenTelemetry tracing API, with parent-based sampling:
+// every root span is recorded, and children are recorded if their parent is, so a trace is whole or not there at all.
+//
+// The web app and the job runner start root spans with the Provider in their options.
+// Code that only runs as part of a trace, like database queries and sending messages, uses StartChild,
+// so without a Provider nothing is recorded, and starting those spans doesn't even allocate.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Exporter of ended spans. Tests can use a MemoryExporter to see what's recorded.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// Provider of tracers, which records spans and exports them with its Exporter in the background.
+// Spans are exported in batches of up to a hundred, at least every five seconds, and on Flush and Shutdown.
+type Provider struct {
+	done     chan struct{}
+	dropped  int64
+	exporter Exporter
+	flushes  chan chan struct{}
+	interval time.Duration
+	lock     sync.RWMutex
+	log      *zap.Logger
+	resource []attribute.KeyValue
+	spans    chan SpanData
+	stopped  bool
+}
+
+// NewProviderOptions for NewProvider.
+type NewProviderOptions struct {
+	// Exporter of the spans. Required.
+	Exporter Exporter
+	// Interval between exports of the spans that are ready. Defaults to five seconds.
+	Interval time.Duration
+	Log      *zap.Logger
+	// Resource attributes of all spans, like service.name.
+	Resource []attribute.KeyValue
+}
+
+const (
+	// batchSize of the largest export.
+	batchSize = 100
+	// queueSize of spans waiting to be exported. Spans that end when it's full are dropped.
+	queueSize = 2048
+)
+
+// NewProvider that exports spans until Shutdown, which must be called before the process exits,
+// or the last spans are lost.
+func NewProvider(opts NewProviderOptions) *Provider {
+	if opts.Log == nil {
+		opts.Log = zap.NewNop()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	p := &Provider{
+		done:     make(chan struct{}),
+		exporter: opts.Exporter,
+		flushes:  make(chan chan struct{}),
+		interval: opts.Interval,
+		log:      opts.Log,
+		resource: opts.Resource,
+		spans:    make(chan SpanData, queueSize),
+	}
+	go p.export()
+	return p
+}
+
+// Tracer for the instrumentation scope name, like canvas/handlers. Options are ignored.
+func (p *Provider) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	return &tracer{provider: p, scope: name}
+}
+
+// Flush the spans that have ended, waiting until they're exported or ctx is done.
+func (p *Provider) Flush(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case p.flushes <- reply:
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown the provider, exporting the spans that have ended, until ctx is done.
+// Spans that end after Shutdown are dropped.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.spans)
+	}
+	p.lock.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue the ended span for export, or drop it if the queue is full or the provider is shut down.
+func (p *Provider) enqueue(s SpanData) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.spans <- s:
+	default:
+		atomic.AddInt64(&p.dropped, 1)
+	}
+}
+
+// export spans from the queue in batches, until the queue is closed by Shutdown.
+func (p *Provider) export() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	send := func() {
+		if dropped := atomic.SwapInt64(&p.dropped, 0); dropped > 0 {
+			p.log.Info("Dropped spans, too many waiting to be exported", zap.Int64("count", dropped))
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.exporter.ExportSpans(context.Background(), batch); err != nil {
+			p.log.Info("Error exporting spans", zap.Error(err), zap.Int("count", len(batch)))
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case s, ok := <-p.spans:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case reply := <-p.flushes:
+			closed := false
+		drain:
+			for {
+				select {
+				case s, ok := <-p.spans:
+					if !ok {
+						closed = true
+						break drain
+					}
+					batch = append(batch, s)
+					if len(batch) >= batchSize {
+						send()
+					}
+				default:
+					break drain
+				}
+			}
+			send()
+			close(reply)
+			if closed {
+				return
+			}
+		}
+	}
+}
+
+// tracer of an instrumentation scope.
+type tracer struct {
+	provider *Provider
+	scope    string
+}
+
+// Start a span, as a child of the span in ctx if there is one, unless the options make it a new root.
+func (t *tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+
+	var parent trace.SpanContext
+	if !cfg.NewRoot() {
+		parent = trace.SpanContextFromContext(ctx)
+	}
+
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		traceID = newTraceID()
+	}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     newSpanID(),
+		TraceFlags: trace.FlagsSampled,
+		TraceState: parent.TraceState(),
+	})
+
+	// Children of spans that aren't sampled, like from a traceparent header, carry the trace on without recording.
+	if parent.IsValid() && !parent.IsSampled() {
+		ctx = trace.ContextWithSpanContext(ctx, sc.WithTraceFlags(parent.TraceFlags()))
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	start := cfg.Timestamp()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &span{
+		provider: t.provider,
+		data: SpanData{
+			Attributes:  setAttributes(nil, cfg.Attributes()...),
+			Kind:        cfg.SpanKind(),
+			Name:        name,
+			Parent:      parent,
+			Resource:    t.provider.resource,
+			Scope:       t.scope,
+			SpanContext: sc,
+			Start:       start,
+		},
+	}
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// noopSpan for StartChild, which does nothing.
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// StartChild of the span in ctx, with the tracer provider of that span, for code that only runs as part of a trace.
+// If the span in ctx isn't recording, or there is none, it returns ctx and a span that does nothing, without allocating,
+// so queries and messages outside of a request or job don't each become a trace of their own.
+func StartChild(ctx context.Context, scope, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.IsRecording() {
+		return ctx, noopSpan
+	}
+	return parent.TracerProvider().Tracer(scope).Start(ctx, name, opts...)
+}
+
+func newTraceID() trace.TraceID {
+	var id trace.TraceID
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() trace.SpanID {
+	var id trace.SpanID
+	_, _ = rand.Read(id[:])
+	return id
+}
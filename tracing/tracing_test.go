@@ -0,0 +1,171 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"canvas/tracing"
+)
+
+func newProvider(t *testing.T) (*tracing.Provider, *tracing.MemoryExporter) {
+	t.Helper()
+	exporter := tracing.NewMemoryExporter()
+	p := tracing.NewProvider(tracing.NewProviderOptions{
+		Exporter: exporter,
+		Resource: []attribute.KeyValue{attribute.String("service.name", "canvas")},
+	})
+	t.Cleanup(func() {
+		_ = p.Shutdown(context.Background())
+	})
+	return p, exporter
+}
+
+func flush(t *testing.T, p *tracing.Provider) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProvider(t *testing.T) {
+	t.Run("records children in the trace of their parent, with the resource and scope", func(t *testing.T) {
+		is := is.New(t)
+
+		p, exporter := newProvider(t)
+		ctx, root := p.Tracer("canvas/test").Start(context.Background(), "root", trace.WithSpanKind(trace.SpanKindServer))
+		_, child := tracing.StartChild(ctx, "canvas/child", "child", trace.WithAttributes(attribute.Int("n", 1)))
+		child.SetAttributes(attribute.Int("n", 2))
+		child.End()
+		root.End()
+		root.End()
+		flush(t, p)
+
+		spans := exporter.Spans()
+		is.Equal(2, len(spans))
+		c, r := spans[0], spans[1]
+		is.Equal("child", c.Name)
+		is.Equal("canvas/child", c.Scope)
+		is.Equal([]attribute.KeyValue{attribute.Int("n", 2)}, c.Attributes)
+		is.Equal(r.SpanContext.TraceID(), c.SpanContext.TraceID())
+		is.Equal(r.SpanContext.SpanID(), c.Parent.SpanID())
+		is.Equal("root", r.Name)
+		is.Equal(trace.SpanKindServer, r.Kind)
+		is.True(!r.Parent.IsValid())
+		is.True(r.SpanContext.IsSampled())
+		is.Equal([]attribute.KeyValue{attribute.String("service.name", "canvas")}, r.Resource)
+		is.True(!r.End.Before(c.End))
+	})
+
+	t.Run("records errors as exception events, and keeps the error status", func(t *testing.T) {
+		is := is.New(t)
+
+		p, exporter := newProvider(t)
+		_, span := p.Tracer("canvas/test").Start(context.Background(), "root")
+		span.RecordError(errors.New("oh no"))
+		span.SetStatus(codes.Error, "oh no")
+		span.SetStatus(codes.Unset, "")
+		span.End()
+		flush(t, p)
+
+		s := exporter.Spans()[0]
+		is.Equal(codes.Error, s.Status)
+		is.Equal("oh no", s.StatusDescription)
+		is.Equal(1, len(s.Events))
+		is.Equal("exception", s.Events[0].Name)
+		is.Equal([]attribute.KeyValue{
+			attribute.String("exception.type", "*errors.errorString"),
+			attribute.String("exception.message", "oh no"),
+		}, s.Events[0].Attributes)
+	})
+
+	t.Run("continues remote traces that aren't sampled without recording them", func(t *testing.T) {
+		is := is.New(t)
+
+		p, exporter := newProvider(t)
+		remote := trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{1},
+			Remote:  true,
+		})
+		ctx := trace.ContextWithRemoteSpanContext(context.Background(), remote)
+		ctx, span := p.Tracer("canvas/test").Start(ctx, "root")
+		is.True(!span.IsRecording())
+		is.Equal(remote.TraceID(), span.SpanContext().TraceID())
+		is.True(remote.SpanID() != span.SpanContext().SpanID())
+
+		_, child := tracing.StartChild(ctx, "canvas/child", "child")
+		is.True(!child.IsRecording())
+		child.End()
+		span.End()
+		flush(t, p)
+
+		is.Equal(0, len(exporter.Spans()))
+	})
+
+	t.Run("drops spans that end after shutdown", func(t *testing.T) {
+		is := is.New(t)
+
+		p, exporter := newProvider(t)
+		tracer := p.Tracer("canvas/test")
+		_, before := tracer.Start(context.Background(), "before")
+		_, after := tracer.Start(context.Background(), "after")
+		before.End()
+		is.NoErr(p.Shutdown(context.Background()))
+		after.End()
+
+		spans := exporter.Spans()
+		is.Equal(1, len(spans))
+		is.Equal("before", spans[0].Name)
+		is.NoErr(p.Flush(context.Background()))
+	})
+
+	t.Run("returns the context error if shutdown doesn't finish in time", func(t *testing.T) {
+		is := is.New(t)
+
+		release := make(chan struct{})
+		defer close(release)
+		p := tracing.NewProvider(tracing.NewProviderOptions{
+			Exporter: exporterFunc(func(ctx context.Context, spans []tracing.SpanData) error {
+				<-release
+				return nil
+			}),
+		})
+		_, span := p.Tracer("canvas/test").Start(context.Background(), "root")
+		span.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		is.True(errors.Is(p.Shutdown(ctx), context.DeadlineExceeded))
+	})
+}
+
+func TestStartChild(t *testing.T) {
+	t.Run("does nothing and doesn't allocate outside of a trace", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx := context.Background()
+		allocs := testing.AllocsPerRun(100, func() {
+			childCtx, span := tracing.StartChild(ctx, "canvas/test", "child")
+			span.End()
+			if childCtx != ctx {
+				t.Fatal("context changed")
+			}
+		})
+		is.Equal(0.0, allocs)
+	})
+}
+
+type exporterFunc func(ctx context.Context, spans []tracing.SpanData) error
+
+func (f exporterFunc) ExportSpans(ctx context.Context, spans []tracing.SpanData) error {
+	return f(ctx, spans)
+}
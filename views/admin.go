@@ -0,0 +1,214 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	g "github.com/maragudk/gomponents"
+	c "github.com/maragudk/gomponents/components"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// AdminPage with a title, head, and the admin layout with navigation between admin pages.
+// The navigation has a logout button if logged in, which is when there's a csrfToken for its form.
+// The footer has the build info, to see what's deployed.
+func AdminPage(title, path, csrfToken string, flashes []sessions.Flash, body ...g.Node) g.Node {
+	return adminPage(title, path, csrfToken, flashes, nil, body...)
+}
+
+// adminPage like AdminPage, with extra nodes for the head.
+func adminPage(title, path, csrfToken string, flashes []sessions.Flash, head []g.Node, body ...g.Node) g.Node {
+	return c.HTML5(c.HTML5Props{
+		Title:    title + " · Admin",
+		Language: "en",
+		Head:     PageHead(head...),
+		Body: []g.Node{
+			Nav(Class("bg-gray-800"),
+				Container(false,
+					Div(Class("flex items-center space-x-4 h-16"),
+						Span(Class("text-lg font-bold text-white"), g.Text("Admin")),
+						g.If(csrfToken != "", g.Group([]g.Node{
+							AdminNavbarLink("/admin", "Dashboard", path),
+							AdminNavbarLink("/admin/subscribers", "Subscribers", path),
+							AdminNavbarLink("/admin/suppressions", "Suppressions", path),
+							AdminNavbarLink("/admin/api-tokens", "API tokens", path),
+							AdminNavbarLink("/admin/webhooks", "Webhooks", path),
+							AdminNavbarLink("/admin/flags", "Flags", path),
+							FormEl(Action("/admin/logout"), Method("post"), Class("!ml-auto"),
+								CSRFInput(csrfToken),
+								Button(Type("submit"), Class("text-sm font-medium text-gray-300 hover:text-white"), g.Text("Log out")),
+							),
+						})),
+					),
+				),
+			),
+			Container(true,
+				Flashes(flashes),
+				H1(Class("text-2xl font-bold mb-4"), g.Text(title)),
+				g.Group(body),
+			),
+			PageFooter(),
+		},
+	})
+}
+
+func AdminNavbarLink(path, text, currentPath string) g.Node {
+	active := path == currentPath
+	return A(Href(path), g.Text(text),
+		c.Classes{
+			"text-sm font-medium hover:text-white": true,
+			"text-white":                           active,
+			"text-gray-300":                        !active,
+		},
+	)
+}
+
+// AdminSubscribersProps for AdminSubscribers.
+type AdminSubscribersProps struct {
+	CSRFToken   string
+	Flashes     []sessions.Flash
+	Subscribers []model.Subscriber
+	// Status the list is filtered by, or empty for all.
+	Status model.SubscriberStatus
+	// StatusURL returns the URL of the first page of the list filtered by the status.
+	StatusURL func(status model.SubscriberStatus) string
+	// Pagination between the neighbouring pages.
+	Pagination PaginationProps
+	// CurrentURL of the page, which the actions on subscribers redirect back to.
+	CurrentURL string
+	// ExportURL downloads the subscribers with the Status as CSV.
+	ExportURL string
+	// Search the list is showing the matches of, or empty if it's not a search.
+	Search string
+	// SearchError is shown at the search box instead of results if the search isn't valid.
+	SearchError string
+	// SearchCapped is true if there are more matches of the search than shown.
+	SearchCapped bool
+}
+
+// AdminSubscribers page with a table of subscribers, filter tabs by status, and links to the neighbouring pages.
+// Each email address links to the subscriber's page, with the emails sent to them.
+// Each subscriber has buttons for the actions that apply to them: confirming pending subscribers,
+// unsubscribing active ones, and deleting. Unsubscribing and deleting ask for confirmation first.
+func AdminSubscribers(props AdminSubscribersProps) g.Node {
+	tab := func(status model.SubscriberStatus, text string) g.Node {
+		return A(Href(props.StatusURL(status)), g.Text(text),
+			c.Classes{
+				"px-3 py-2 text-sm font-medium rounded-md": true,
+				"bg-gray-200 text-gray-900":                status == props.Status,
+				"text-gray-500 hover:text-gray-700":        status != props.Status,
+			},
+		)
+	}
+
+	return AdminPage("Subscribers", "/admin/subscribers", props.CSRFToken, props.Flashes,
+		Div(Class("flex space-x-2 mb-4"),
+			tab("", "All"),
+			tab(model.SubscriberStatusPending, "Pending"),
+			tab(model.SubscriberStatusConfirmed, "Confirmed"),
+			tab(model.SubscriberStatusUnsubscribed, "Unsubscribed"),
+			tab(model.SubscriberStatusBounced, "Bounced"),
+			tab(model.SubscriberStatusComplained, "Complained"),
+			A(Href("/admin/subscribers/import"), Class("!ml-auto px-3 py-2 text-sm font-medium text-indigo-600 hover:text-indigo-900"),
+				g.Text("Import CSV")),
+			A(Href(props.ExportURL), Class("px-3 py-2 text-sm font-medium text-indigo-600 hover:text-indigo-900"),
+				g.Text("Export CSV")),
+		),
+
+		FormEl(Action("/admin/subscribers"), Method("get"), Role("search"), Class("mb-4"),
+			g.If(props.Status != "", Input(Type("hidden"), Name("status"), Value(string(props.Status)))),
+			Label(For("search"), Class("sr-only"), g.Text("Search by email")),
+			Div(Class("flex space-x-2"),
+				Input(Type("search"), Name("q"), ID("search"), Value(props.Search), Placeholder("Search by email"),
+					g.If(props.SearchError != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", "search-error")})),
+					Class("block w-full max-w-sm text-sm border-gray-300 rounded-md")),
+				Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Search")),
+			),
+			g.If(props.SearchError != "", P(ID("search-error"), Class("text-sm text-red-600 mt-1"), g.Text(props.SearchError))),
+		),
+
+		g.If(len(props.Subscribers) == 0 && props.Search == "", P(Class("text-gray-500"), g.Text("No subscribers here yet."))),
+		g.If(len(props.Subscribers) == 0 && props.Search != "" && props.SearchError == "",
+			P(Class("text-gray-500"), g.Textf("No subscribers match “%v”.", props.Search))),
+		g.If(props.SearchCapped, P(ID("search-capped"), Class("text-sm text-gray-500 mb-2"),
+			g.Textf("Showing the first %v matches. Please search for more to narrow them down.", len(props.Subscribers)))),
+
+		g.If(len(props.Subscribers) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Email")),
+				Th(Class("text-left py-2"), g.Text("Status")),
+				Th(Class("text-left py-2"), g.Text("Signed up")),
+				Th(Class("text-left py-2"), g.Text("Confirmed")),
+				Th(Class("text-left py-2"), g.Text("Actions")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Subscribers, func(s model.Subscriber) g.Node {
+					confirmed := ""
+					if s.ConfirmedAt != nil {
+						confirmed = s.ConfirmedAt.Format("2006-01-02")
+					}
+					return Tr(
+						Td(Class("py-2"), A(Href(fmt.Sprintf("/admin/subscribers/%v", s.ID)), Class("text-indigo-600 hover:text-indigo-900"),
+							g.Text(s.Email.String()))),
+						Td(Class("py-2"), g.Text(string(s.Status()))),
+						Td(Class("py-2"), g.Text(s.Created.Format("2006-01-02"))),
+						Td(Class("py-2"), g.Text(confirmed)),
+						Td(Class("py-2 space-x-2"),
+							g.If(s.Status() == model.SubscriberStatusPending,
+								adminSubscriberAction(props, s, "/confirm", "", "Confirm", "")),
+							g.If(s.Status() == model.SubscriberStatusComplained,
+								adminSubscriberAction(props, s, "/clear-complaint", "", "Clear complaint",
+									"Clear the spam complaint of "+s.Email.String()+"? Only do this if they asked to get the newsletter again.")),
+							g.If(s.Active,
+								adminSubscriberAction(props, s, "/unsubscribe", "", "Unsubscribe",
+									"Unsubscribe "+s.Email.String()+"? They won't get the newsletter anymore.")),
+							adminSubscriberAction(props, s, "", http.MethodDelete, "Delete",
+								"Delete "+s.Email.String()+"? This can't be undone."),
+						),
+					)
+				})),
+			),
+		)),
+
+		Pagination(props.Pagination),
+	)
+}
+
+// adminSubscriberAction form posting to the path under the subscriber's URL, with the method if it's not POST.
+// The form has the subscriber's version, so the action isn't done if the subscriber changed since the page loaded.
+// With a confirm message, the browser asks before submitting.
+func adminSubscriberAction(props AdminSubscribersProps, s model.Subscriber, path, method, text, confirm string) g.Node {
+	inputs := CSRFInput(props.CSRFToken)
+	if method != "" {
+		inputs = MethodInputs(props.CSRFToken, method)
+	}
+	return FormEl(Action(fmt.Sprintf("/admin/subscribers/%v%v", s.ID, path)), Method("post"), Class("inline"),
+		g.If(confirm != "", g.Attr("data-confirm", confirm)),
+		inputs,
+		Input(Type("hidden"), Name("version"), Value(strconv.FormatInt(s.Updated.UnixMicro(), 10))),
+		Input(Type("hidden"), Name("redirect"), Value(props.CurrentURL)),
+		Button(Type("submit"), Class("text-indigo-600 hover:text-indigo-900"), g.Text(text)),
+	)
+}
+
+// AdminLoginPage with the login form. After logging in, the admin is sent to redirect.
+// An errorMessage is shown above the form if not empty, such as after a wrong password.
+func AdminLoginPage(csrfToken, redirect, errorMessage string, flashes []sessions.Flash) g.Node {
+	return AdminPage("Log in", "/admin/login", "", flashes,
+		g.If(errorMessage != "", P(ID("login-error"), Class("text-sm text-red-600 mb-4"), g.Text(errorMessage))),
+		FormEl(Action("/admin/login"), Method("post"), Class("max-w-sm space-y-4"),
+			CSRFInput(csrfToken),
+			Input(Type("hidden"), Name("redirect"), Value(redirect)),
+			Label(For("password"), Class("block text-sm font-medium text-gray-700"), g.Text("Password")),
+			Input(Type("password"), Name("password"), ID("password"), AutoComplete("current-password"), Required(),
+				g.If(errorMessage != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", "login-error")})),
+				Class("block w-full text-sm border-gray-300 rounded-md")),
+			Button(Type("submit"), g.Text("Log in"),
+				Class("inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500")),
+		),
+	)
+}
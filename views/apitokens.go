@@ -0,0 +1,113 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// AdminAPITokensProps for AdminAPITokens.
+type AdminAPITokensProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	Tokens    []model.APIToken
+	// Created token, if the page is the response to creating it, with its Secret, which is only ever shown then.
+	Created *model.APIToken
+	Secret  string
+}
+
+// AdminAPITokens page with a table of the tokens for the admin API, newest first, and a form for creating one.
+// Each token has a button for revoking it, which asks for confirmation first.
+func AdminAPITokens(props AdminAPITokensProps) g.Node {
+	var created g.Node
+	if props.Created != nil && props.Secret != "" {
+		created = Div(ID("api-token-secret"), Class("rounded-md bg-green-50 p-4 mb-4"),
+			P(Class("text-sm font-medium text-green-800"),
+				g.Textf("Created %v. Copy the token now, it won't be shown again:", props.Created.Name)),
+			Code(Class("block mt-2 font-mono text-sm break-all"), g.Text(props.Secret)),
+		)
+	}
+
+	return AdminPage("API tokens", "/admin/api-tokens", props.CSRFToken, props.Flashes,
+		P(Class("text-sm text-gray-500 mb-4"),
+			g.Text("API tokens give programs access to the admin API under /api/admin, with the scopes they have. "),
+			g.Text("Send them in the Authorization header, as Bearer followed by the token.")),
+
+		created,
+
+		g.If(len(props.Tokens) == 0, P(Class("text-gray-500"), g.Text("No API tokens."))),
+
+		g.If(len(props.Tokens) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Name")),
+				Th(Class("text-left py-2"), g.Text("Scopes")),
+				Th(Class("text-left py-2"), g.Text("Last used")),
+				Th(Class("text-left py-2"), g.Text("Expires")),
+				Th(Class("text-left py-2"), g.Text("Created")),
+				Th(Class("text-left py-2"), g.Text("Actions")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Tokens, func(t model.APIToken) g.Node {
+					lastUsed, expires := "Never", "Never"
+					if t.LastUsedAt != nil {
+						lastUsed = t.LastUsedAt.Format("2006-01-02 15:04")
+					}
+					if t.ExpiresAt != nil {
+						expires = t.ExpiresAt.Format("2006-01-02")
+					}
+					return Tr(ID(fmt.Sprintf("api-token-%v", t.ID)),
+						Td(Class("py-2"), g.Text(t.Name)),
+						Td(Class("py-2 font-mono"), g.Group(g.Map(t.Scopes, func(s model.APIScope) g.Node {
+							return Span(Class("block"), g.Text(string(s)))
+						}))),
+						Td(Class("py-2"), g.Text(lastUsed)),
+						Td(Class("py-2"), g.Text(expires)),
+						Td(Class("py-2"), g.Text(t.Created.Format("2006-01-02"))),
+						Td(Class("py-2"),
+							FormEl(Action(fmt.Sprintf("/admin/api-tokens/%v", t.ID)), Method("post"), Class("inline"),
+								g.Attr("data-confirm", "Revoke this token? Programs using it lose access right away."),
+								MethodInputs(props.CSRFToken, http.MethodDelete),
+								Button(Type("submit"), Class("text-indigo-600 hover:text-indigo-900"), g.Text("Revoke")),
+							),
+						),
+					)
+				})),
+			),
+		)),
+
+		FormEl(Action("/admin/api-tokens"), Method("post"), Class("mt-8 max-w-sm space-y-4"),
+			CSRFInput(props.CSRFToken),
+			H2(Class("text-lg font-medium"), g.Text("Create a token")),
+			Div(
+				Label(For("name"), Class("block text-sm font-medium text-gray-700"), g.Text("Name")),
+				Input(Type("text"), Name("name"), ID("name"), Required(), Placeholder("Reporting script"),
+					Class("block w-full text-sm border-gray-300 rounded-md")),
+			),
+			FieldSet(
+				Legend(Class("block text-sm font-medium text-gray-700"), g.Text("Scopes")),
+				g.Group(g.Map(model.APIScopes, func(s model.APIScope) g.Node {
+					return Label(Class("flex items-center space-x-2 text-sm font-mono"),
+						Input(Type("checkbox"), Name(string(s))),
+						Span(g.Text(string(s))),
+					)
+				})),
+			),
+			Div(
+				Label(For("expires"), Class("block text-sm font-medium text-gray-700"), g.Text("Expires after")),
+				Select(Name("expires"), ID("expires"), Class("block w-full text-sm border-gray-300 rounded-md"),
+					Option(Value("30"), g.Text("30 days")),
+					Option(Value("90"), Selected(), g.Text("90 days")),
+					Option(Value("365"), g.Text("A year")),
+					Option(Value("0"), g.Text("Never")),
+				),
+			),
+			Button(Type("submit"), g.Text("Create token"),
+				Class("inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500")),
+		),
+	)
+}
@@ -0,0 +1,64 @@
+package views
+
+import (
+	"time"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+)
+
+// ArchivePageProps for ArchivePage.
+type ArchivePageProps struct {
+	Newsletters []model.Newsletter
+	// PreviousURL and NextURL of the neighbouring pages, if there are any.
+	PreviousURL string
+	NextURL     string
+}
+
+// ArchivePage with a page of published newsletter issues, newest first, and links to the neighbouring pages.
+func ArchivePage(props ArchivePageProps) g.Node {
+	return Page(
+		"Newsletter archive",
+		"/archive",
+		nil,
+		H1(g.Text(`Newsletter archive`)),
+		g.If(len(props.Newsletters) == 0, P(g.Text(`No issues here yet. Sign up on the front page to get the first one.`))),
+		g.Group(g.Map(props.Newsletters, func(n model.Newsletter) g.Node {
+			return Article(
+				H2(A(Href("/archive/"+n.Slug), g.Text(n.Title))),
+				PublishedTime(*n.PublishedAt),
+				P(g.Text(n.Excerpt(200))),
+			)
+		})),
+		Nav(Class("flex justify-between"),
+			g.If(props.PreviousURL != "", A(Href(props.PreviousURL), Rel("prev"), g.Text("← Newer issues"))),
+			g.If(props.NextURL != "", A(Href(props.NextURL), Rel("next"), g.Text("Older issues →"))),
+		),
+	)
+}
+
+// ArchiveIssuePage with a published newsletter issue, and the meta for link previews of it.
+func ArchiveIssuePage(n model.Newsletter, meta PageMetaProps) g.Node {
+	return Layout(
+		PageData{
+			Meta:  meta,
+			Path:  "/archive/" + n.Slug,
+			Title: n.Title,
+		},
+		Article(
+			H1(g.Text(n.Title)),
+			PublishedTime(*n.PublishedAt),
+			g.Raw(n.BodyHTML()),
+		),
+		P(A(Href("/archive"), g.Text(`← All issues`))),
+	)
+}
+
+// PublishedTime in a time element, shown as a date.
+func PublishedTime(t time.Time) g.Node {
+	return P(Class("text-gray-500"),
+		g.El("time", g.Attr("datetime", t.UTC().Format(time.RFC3339)), g.Text(t.Format("January 2, 2006"))),
+	)
+}
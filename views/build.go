@@ -0,0 +1,17 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/build"
+)
+
+// BuildInfo with the version, short commit, and build date, linking to the full build info.
+func BuildInfo(info build.Info) g.Node {
+	return P(Class("text-xs text-gray-400"),
+		A(Href("/version"), Class("hover:text-gray-600"),
+			g.Textf("Version %v (%v), built %v with %v", info.Version, info.ShortCommit(), info.Date, info.GoVersion),
+		),
+	)
+}
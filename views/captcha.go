@@ -0,0 +1,46 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+)
+
+// CaptchaProvider of a captcha widget.
+type CaptchaProvider string
+
+const (
+	CaptchaProviderHCaptcha  CaptchaProvider = "hcaptcha"
+	CaptchaProviderTurnstile CaptchaProvider = "turnstile"
+)
+
+// CaptchaFieldName is the form field that captcha errors are shown for.
+const CaptchaFieldName = "captcha"
+
+// Captcha widget of the provider, with the public site key.
+type Captcha struct {
+	Provider CaptchaProvider
+	SiteKey  string
+}
+
+// ResponseFieldName is the form field the widget submits its response token in.
+func (c Captcha) ResponseFieldName() string {
+	if c.Provider == CaptchaProviderTurnstile {
+		return "cf-turnstile-response"
+	}
+	return "h-captcha-response"
+}
+
+// CaptchaWidget with the script of the provider, for inside a form. It's nil without a captcha.
+func CaptchaWidget(c *Captcha) g.Node {
+	if c == nil {
+		return nil
+	}
+	script, class := "https://js.hcaptcha.com/1/api.js", "h-captcha"
+	if c.Provider == CaptchaProviderTurnstile {
+		script, class = "https://challenges.cloudflare.com/turnstile/v0/api.js", "cf-turnstile"
+	}
+	return Div(Class("w-full mt-3 order-last"),
+		Script(Src(script), Async(), Defer()),
+		Div(Class(class), DataAttr("sitekey", c.SiteKey)),
+	)
+}
@@ -0,0 +1,39 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+)
+
+// CSRFFieldName is the name of the hidden form field with the CSRF token.
+const CSRFFieldName = "csrf_token"
+
+// CSRFInput is the hidden input with the CSRF token, for every form that posts to the app.
+func CSRFInput(token string) g.Node {
+	return Input(Type("hidden"), Name(CSRFFieldName), Value(token))
+}
+
+// MethodFieldName is the name of the hidden form field with the method to use instead of POST.
+const MethodFieldName = "_method"
+
+// MethodInputs are the hidden inputs with the CSRF token and the method, for forms that delete or update,
+// like with the method http.MethodDelete. Browsers only submit GET and POST, so the form must use POST,
+// and handlers.MethodOverride routes it with the method instead.
+func MethodInputs(token, method string) g.Node {
+	return g.Group([]g.Node{
+		CSRFInput(token),
+		Input(Type("hidden"), Name(MethodFieldName), Value(method)),
+	})
+}
+
+// ForbiddenPage for requests rejected because of a missing or wrong CSRF token.
+func ForbiddenPage(path string) g.Node {
+	return Page(
+		"Form expired",
+		path,
+		nil,
+		H1(g.Text(`This form has expired`)),
+		P(g.Text(`Please go back, reload the page, and try again.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
@@ -0,0 +1,152 @@
+package views
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// AdminDashboardProps for AdminDashboard.
+type AdminDashboardProps struct {
+	CSRFToken string
+	// Days the stats are over.
+	Days    int
+	Flashes []sessions.Flash
+	// Subscribers, Sends, and QueueDepth are nil if they're unavailable, and their section says so.
+	Subscribers *model.SubscriberStats
+	Sends       *model.SendStats
+	QueueDepth  *int
+	// NextRuns of the scheduled jobs by name. If it's nil, there's no schedules section.
+	NextRuns map[string]time.Time
+}
+
+// AdminDashboard page with subscriber, sending, and queue stats.
+func AdminDashboard(props AdminDashboardProps) g.Node {
+	return AdminPage("Dashboard", "/admin", props.CSRFToken, props.Flashes,
+		dashboardSection("subscribers", "Subscribers", props.Subscribers != nil, func() g.Node {
+			s := props.Subscribers
+			return g.Group([]g.Node{
+				Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4"),
+					dashboardStat("Total", fmt.Sprint(s.Total())),
+					dashboardStat("Confirmed", fmt.Sprint(s.ByStatus[model.SubscriberStatusConfirmed])),
+					dashboardStat("Pending", fmt.Sprint(s.ByStatus[model.SubscriberStatusPending])),
+					dashboardStat("Unsubscribed", fmt.Sprint(s.ByStatus[model.SubscriberStatusUnsubscribed])),
+					dashboardStat("Bounced", fmt.Sprint(s.ByStatus[model.SubscriberStatusBounced])),
+					dashboardStat("Complained", fmt.Sprint(s.ByStatus[model.SubscriberStatusComplained])),
+				),
+				P(Class("mt-4 text-sm text-gray-500"), g.Textf("Signups per day, last %v days", props.Days)),
+				Sparkline(s.SignupsPerDay),
+				Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4 mt-4"),
+					dashboardStat("Signups", fmt.Sprint(s.Signups)),
+					dashboardStat("Confirmation rate", percent(s.ConfirmationRate())),
+				),
+			})
+		}),
+
+		dashboardSection("sending", fmt.Sprintf("Sending, last %v days", props.Days), props.Sends != nil, func() g.Node {
+			s := props.Sends
+			return Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4"),
+				dashboardStat("Sent", fmt.Sprint(s.Sent)),
+				dashboardStat("Failed", fmt.Sprint(s.Failed)),
+				dashboardStat("Bounce rate", percent(s.BounceRate())),
+				dashboardStat("Complaint rate", percent(s.ComplaintRate())),
+			)
+		}),
+
+		dashboardSection("queue", "Queue", props.QueueDepth != nil, func() g.Node {
+			return Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4"),
+				dashboardStat("Waiting jobs", fmt.Sprint(*props.QueueDepth)),
+			)
+		}),
+
+		g.If(props.NextRuns != nil, dashboardSchedules(props.NextRuns)),
+	)
+}
+
+// dashboardSchedules with the next run of each scheduled job, sorted by name.
+func dashboardSchedules(nextRuns map[string]time.Time) g.Node {
+	names := make([]string, 0, len(nextRuns))
+	for name := range nextRuns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body g.Node = P(Class("text-sm text-gray-500"), g.Text("No scheduled jobs."))
+	if len(names) > 0 {
+		body = Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Job")),
+				Th(Class("text-left py-2"), g.Text("Next run")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(names, func(name string) g.Node {
+					return Tr(ID("schedule-"+name),
+						Td(Class("py-2 font-mono"), g.Text(name)),
+						Td(Class("py-2"), g.Text(nextRuns[name].UTC().Format("2006-01-02 15:04 MST"))),
+					)
+				})),
+			),
+		)
+	}
+	return Section(ID("schedules"), Class("mb-8"),
+		H2(Class("text-lg font-semibold mb-2"), g.Text("Schedules")),
+		body,
+	)
+}
+
+// dashboardSection with the title, and the content if it's available, or a note that it's unavailable otherwise.
+func dashboardSection(id, title string, available bool, content func() g.Node) g.Node {
+	var body g.Node
+	if available {
+		body = content()
+	} else {
+		body = P(Class("text-sm text-gray-500"), g.Text("Unavailable right now. Please try again later."))
+	}
+	return Section(ID(id), Class("mb-8"),
+		H2(Class("text-lg font-semibold mb-2"), g.Text(title)),
+		body,
+	)
+}
+
+func dashboardStat(label, value string) g.Node {
+	return Div(
+		Dt(Class("text-sm text-gray-500"), g.Text(label)),
+		Dd(Class("text-2xl font-semibold"), g.Text(value)),
+	)
+}
+
+func percent(rate float64) string {
+	return fmt.Sprintf("%.1f%%", rate*100)
+}
+
+// Sparkline of the values as a small SVG line chart, scaled to the largest value.
+func Sparkline(values []int) g.Node {
+	const width, height = 300, 40
+	largest := 1
+	for _, v := range values {
+		if v > largest {
+			largest = v
+		}
+	}
+	var points []string
+	for i, v := range values {
+		x := 0.0
+		if len(values) > 1 {
+			x = float64(i) * width / float64(len(values)-1)
+		}
+		y := height - float64(v)*height/float64(largest)
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return g.El("svg", g.Attr("viewBox", fmt.Sprintf("0 0 %v %v", width, height)), Class("w-full max-w-md h-10 text-indigo-500"),
+		Role("img"), Aria("label", fmt.Sprintf("%v values, up to %v", len(values), largest)),
+		g.El("polyline", g.Attr("points", strings.Join(points, " ")), g.Attr("fill", "none"),
+			g.Attr("stroke", "currentColor"), g.Attr("stroke-width", "2")),
+	)
+}
@@ -0,0 +1,78 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	c "github.com/maragudk/gomponents/components"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/i18n"
+)
+
+// EmbedSignupPage is the newsletter signup form for partner sites to frame, without the layout.
+// It signs up through the JSON API with JavaScript instead of a form post, so it needs no session or cookies,
+// which browsers often block in frames. The source is the partner origin it's framed by, or empty.
+// With a captcha, its widget is in the form, and the script sends its response token with the signup.
+// The inline style and script get the CSP nonce.
+func EmbedSignupPage(t *i18n.Translator, source string, captcha *Captcha, nonce string) g.Node {
+	if t == nil {
+		t = i18n.Default().Translator(i18n.DefaultLocale)
+	}
+	var captchaField string
+	if captcha != nil {
+		captchaField = captcha.ResponseFieldName()
+	}
+	return c.HTML5(c.HTML5Props{
+		Title:    "Canvas",
+		Language: t.Locale(),
+		Head: []g.Node{
+			Meta(Name("viewport"), Content("width=device-width, initial-scale=1")),
+			InlineStyle(nonce, embedSignupStyle),
+		},
+		Body: []g.Node{
+			FormEl(ID("signup"), Action("/api/newsletter/signup"), Method("post"),
+				DataAttr("source", source), DataAttr("success", t.T("signup.thanks_flash")), DataAttr("error", t.T("embed.error")),
+				g.If(captchaField != "", DataAttr("captcha", captchaField)),
+				Label(For("email"), g.Text(t.T("front.email_label"))),
+				Input(Type("email"), Name("email"), ID("email"), AutoComplete("email"), Required(), Placeholder("me@example.com")),
+				Button(Type("submit"), g.Text(t.T("front.signup_button"))),
+				CaptchaWidget(captcha),
+			),
+			P(ID("message"), Role("status")),
+			InlineScript(nonce, embedSignupScript),
+		},
+	})
+}
+
+const embedSignupStyle = `body{margin:0;font-family:system-ui,sans-serif;font-size:14px}
+form{display:flex;flex-wrap:wrap;gap:8px;align-items:center}
+form>div{flex-basis:100%}
+label{position:absolute;width:1px;height:1px;overflow:hidden;clip:rect(0,0,0,0)}
+input{flex-grow:1;padding:8px;border:1px solid #d1d5db;border-radius:6px}
+button{padding:8px 16px;border:1px solid #d1d5db;border-radius:6px;background:#fff;cursor:pointer}`
+
+// embedSignupScript posts the form to the JSON API, with the response token of the captcha widget if there is one,
+// and shows the result or the first error in the status message. Tokens are only good once, so the widget is reset after.
+const embedSignupScript = `document.getElementById("signup").addEventListener("submit", function (e) {
+  e.preventDefault();
+  var form = e.target, message = document.getElementById("message");
+  var captcha = form.dataset.captcha && form.elements[form.dataset.captcha];
+  fetch(form.action, {
+    method: "POST",
+    credentials: "omit",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({email: form.elements.email.value, source: form.dataset.source, captcha: captcha ? captcha.value : undefined})
+  }).then(function (res) {
+    return res.json().then(function (body) {
+      if (window.hcaptcha) window.hcaptcha.reset();
+      if (window.turnstile) window.turnstile.reset();
+      if (res.ok) {
+        form.reset();
+        message.textContent = form.dataset.success;
+        return;
+      }
+      message.textContent = (body.errors && (body.errors.email || body.errors.captcha)) || body.error || form.dataset.error;
+    });
+  }).catch(function () {
+    message.textContent = form.dataset.error;
+  });
+});`
@@ -0,0 +1,129 @@
+package views
+
+import (
+	"sort"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+)
+
+// ErrorPage for when something went wrong on our side.
+// The reference is shown if it's not empty, so someone reporting the problem can tell us which error it was.
+func ErrorPage(path, reference string) g.Node {
+	return Page(
+		"Something went wrong",
+		path,
+		nil,
+		H1(g.Text(`Something went wrong`)),
+		P(g.Text(`Sorry, that didn't work. Please go back and try again in a moment.`)),
+		g.If(reference != "", P(g.Text(`If it keeps happening, let us know and mention reference `), Strong(g.Text(reference)), g.Text(`.`))),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// InvalidInputPage for requests with values we can't use, with the error for each field, sorted by field name.
+// Without field errors, it only asks to check the input.
+func InvalidInputPage(path string, errors map[string]string) g.Node {
+	names := make([]string, 0, len(errors))
+	for name := range errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return Page(
+		"Please check what you entered",
+		path,
+		nil,
+		H1(g.Text(`Please check what you entered`)),
+		g.If(len(names) > 0, g.Group([]g.Node{
+			P(g.Text(`Some of it doesn't look right:`)),
+			Ul(g.Group(g.Map(names, func(name string) g.Node {
+				return Li(Strong(g.Text(name+": ")), g.Text(errors[name]))
+			}))),
+		})),
+		P(g.Text(`Please go back, fix it, and try again.`)),
+	)
+}
+
+// TooManySignupsPage for when too many signups come from the same place.
+func TooManySignupsPage(path string) g.Node {
+	return Page(
+		"Too many signups",
+		path,
+		nil,
+		H1(g.Text(`Too many signups`)),
+		P(g.Text(`There have been a lot of signups from your network lately. Please try again in an hour.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// BusyPage for requests turned away because the app has too much to do.
+func BusyPage(path string) g.Node {
+	return Page(
+		"Very busy right now",
+		path,
+		nil,
+		H1(g.Text(`Very busy right now`)),
+		P(g.Text(`Sorry, there's a lot going on here at the moment. Please try again in a moment.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// UnavailablePage for when the database is down, and there's no copy of the page to show instead.
+func UnavailablePage(path string) g.Node {
+	return Page(
+		"Back soon",
+		path,
+		nil,
+		H1(g.Text(`Back soon`)),
+		P(g.Text(`Sorry, something isn't working on our side right now. We're on it, so please try again in a little while.`)),
+	)
+}
+
+// ConflictPage for changes to something that changed in the meantime, or that clash with something else.
+func ConflictPage(path string) g.Node {
+	return Page(
+		"That changed in the meantime",
+		path,
+		nil,
+		H1(g.Text(`That changed in the meantime`)),
+		P(g.Text(`Something else changed this while you were at it, so nothing was done. Please go back, reload the page, and try again.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// NotAllowedPage for things that aren't allowed.
+func NotAllowedPage(path string) g.Node {
+	return Page(
+		"Not allowed",
+		path,
+		nil,
+		H1(g.Text(`Not allowed`)),
+		P(g.Text(`Sorry, that isn't allowed.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// NotFoundPage for URLs that don't exist.
+func NotFoundPage(path string) g.Node {
+	return Page(
+		"Page not found",
+		path,
+		nil,
+		H1(g.Text(`Page not found`)),
+		P(g.Text(`We looked everywhere, but this page doesn't exist. Maybe the link is old, or there's a typo in the address.`)),
+		P(A(Href("/"), g.Text(`Back to the front page`))),
+	)
+}
+
+// DevErrorPage for errors that developers should see in the browser, like translation files that don't parse.
+// It's only for development, because the message can have details that visitors shouldn't see.
+func DevErrorPage(path, message string) g.Node {
+	return Page(
+		"Development error",
+		path,
+		nil,
+		H1(g.Text(`Development error`)),
+		Pre(Class("whitespace-pre-wrap"), g.Text(message)),
+	)
+}
@@ -0,0 +1,26 @@
+package views_test
+
+import (
+	"testing"
+
+	"canvas/views"
+	"canvas/views/viewstest"
+)
+
+func TestErrorPages(t *testing.T) {
+	t.Run("renders the page for URLs that don't exist", func(t *testing.T) {
+		viewstest.MatchGolden(t, "not-found", views.NotFoundPage("/nope"))
+	})
+
+	t.Run("renders the page for errors, with the reference", func(t *testing.T) {
+		viewstest.MatchGolden(t, "error", views.ErrorPage("/newsletter/signup", "abc123"))
+	})
+
+	t.Run("renders the page for requests turned away when busy", func(t *testing.T) {
+		viewstest.MatchGolden(t, "busy", views.BusyPage("/archive"))
+	})
+
+	t.Run("renders the page for when the database is down", func(t *testing.T) {
+		viewstest.MatchGolden(t, "unavailable", views.UnavailablePage("/"))
+	})
+}
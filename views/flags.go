@@ -0,0 +1,57 @@
+package views
+
+import (
+	"fmt"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/flags"
+	"canvas/sessions"
+)
+
+// AdminFlagsProps for AdminFlags.
+type AdminFlagsProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	Flags     []flags.Flag
+}
+
+// AdminFlags page with a table of the feature flags, whether each is on, off, or on for a percentage of subscribers,
+// and what set it. Flags are set in the environment and the flags file, so the page only shows them.
+func AdminFlags(props AdminFlagsProps) g.Node {
+	return AdminPage("Feature flags", "/admin/flags", props.CSRFToken, props.Flashes,
+		g.If(len(props.Flags) == 0, P(Class("text-gray-500"), g.Text("No feature flags."))),
+		g.If(len(props.Flags) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Flag")),
+				Th(Class("text-left py-2"), g.Text("State")),
+				Th(Class("text-left py-2"), g.Text("Set by")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Flags, func(f flags.Flag) g.Node {
+					return Tr(ID("flag-"+f.Name),
+						Td(Class("py-2 font-mono"), g.Text(f.Name)),
+						Td(Class("py-2"), g.Text(flagState(f))),
+						Td(Class("py-2"), g.Text(string(f.Source))),
+					)
+				})),
+			),
+		)),
+		P(Class("mt-4 text-sm text-gray-500"),
+			g.Text("Turn flags on or off with environment variables like FEATURE_ARCHIVE=true, "+
+				"or roll them out to a percentage of subscribers in the flags file.")),
+	)
+}
+
+// flagState like "on", "off", or "on for 25%".
+func flagState(f flags.Flag) string {
+	switch {
+	case f.On():
+		return "on"
+	case f.Percentage <= 0:
+		return "off"
+	default:
+		return fmt.Sprintf("on for %v%%", f.Percentage)
+	}
+}
@@ -0,0 +1,40 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	c "github.com/maragudk/gomponents/components"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/sessions"
+)
+
+// Flashes from sessions.ConsumeFlashes, in order. It renders nothing without flashes.
+// They're dismissed by the script in app.js.
+func Flashes(flashes []sessions.Flash) g.Node {
+	if len(flashes) == 0 {
+		return nil
+	}
+	return Div(ID("flashes"), Class("space-y-2 mb-4"),
+		g.Group(g.Map(flashes, func(f sessions.Flash) g.Node {
+			return FlashMessage(f)
+		})),
+	)
+}
+
+// FlashMessage with colors for its level. Errors are announced right away by screen readers.
+func FlashMessage(f sessions.Flash) g.Node {
+	role := "status"
+	if f.Level == sessions.FlashError {
+		role = "alert"
+	}
+	return Div(g.Attr("data-flash", string(f.Level)), Role(role),
+		c.Classes{
+			"flex items-center justify-between rounded-md px-4 py-3 text-sm": true,
+			"bg-blue-50 text-blue-800":                                       f.Level == sessions.FlashInfo,
+			"bg-green-50 text-green-800":                                     f.Level == sessions.FlashSuccess,
+			"bg-red-50 text-red-800":                                         f.Level == sessions.FlashError,
+		},
+		Span(g.Text(f.Message)),
+		Button(Type("button"), Class("ml-4 font-bold"), Aria("label", "Dismiss"), g.Text("×")),
+	)
+}
@@ -4,30 +4,60 @@ import (
 	g "github.com/maragudk/gomponents"
 	"github.com/maragudk/gomponents-heroicons/solid"
 	. "github.com/maragudk/gomponents/html"
+
+	"canvas/form"
+	"canvas/i18n"
+)
+
+const (
+	// HoneypotFieldName is the signup form field hidden from people, so only bots fill it out.
+	HoneypotFieldName = "website"
+	// TimestampFieldName is the signup form field with the signed time the form was rendered.
+	TimestampFieldName = "rendered_at"
 )
 
-func FrontPage() g.Node {
-	return Page(
-		"Canvas",
-		"/",
-		H1(g.Text(`Solutions to problems.`)),
-		P(g.Text(`Do you have problems? We also had problems.`)),
-		P(g.Raw(`Then we created the <em>canvas</em> app, and now we don't! 😬`)),
+// FrontPage with the newsletter signup form.
+// The form has a honeypot field and the signed timestamp from form.CreateTimestamp, to catch bots.
+// With a captcha, the form has its widget, which people must complete to sign up.
+// After a failed signup, state has the submitted values and errors to show inline. It's nil otherwise.
+// The page data is from the handler, and the title and path are set here.
+func FrontPage(p PageData, csrfToken, timestamp string, captcha *Captcha, state *form.State) g.Node {
+	if p.Translator == nil {
+		p.Translator = i18n.Default().Translator(i18n.DefaultLocale)
+	}
+	t := p.Translator
+	p.Title = "Canvas"
+	p.Path = "/"
+	return Layout(
+		p,
+		H1(g.Text(t.T("front.heading"))),
+		P(g.Text(t.T("front.problems"))),
+		P(g.Raw(t.T("front.created_html"))),
 
-		H2(g.Text(`Do you want to know more?`)),
-		P(g.Text(`Sign up to our newsletter below.`)),
+		H2(g.Text(t.T("front.more"))),
+		P(g.Text(t.T("front.signup"))),
 
-		FormEl(Action("/newsletter/signup"), Method("post"), Class("flex items-center max-w-md"),
-			Label(For("email"), Class("sr-only"), g.Text("Email")),
+		FormEl(Action("/newsletter/signup"), Method("post"), Class("flex flex-wrap items-center max-w-md"),
+			CSRFInput(csrfToken),
+			Input(Type("hidden"), Name(TimestampFieldName), Value(timestamp)),
+			Div(Class("hidden"), Aria("hidden", "true"),
+				Label(For(HoneypotFieldName), g.Text(t.T("front.honeypot_label"))),
+				Input(Type("text"), Name(HoneypotFieldName), ID(HoneypotFieldName), TabIndex("-1"), AutoComplete("off")),
+			),
+			Label(For("email"), Class("sr-only"), g.Text(t.T("front.email_label"))),
 			Div(Class("relative rounded-md shadow-sm flex-grow"),
 				Div(Class("absolute inset-y-0 left-0 pl-3 flex items-center pointer-events-none"),
 					solid.Mail(Class("h-5 w-5 text-gray-400")),
 				),
 				Input(Type("email"), Name("email"), ID("email"), AutoComplete("email"), Required(), Placeholder("me@example.com"), TabIndex("1"),
+					state.Input("email"),
 					Class("focus:ring-gray-500 focus:border-gray-500 block w-full pl-10 text-sm border-gray-300 rounded-md")),
 			),
-			Button(Type("submit"), g.Text("Sign up"),
+			Button(Type("submit"), g.Text(t.T("front.signup_button")),
 				Class("ml-3 inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500 flex-none")),
+			CaptchaWidget(captcha),
 		),
+		state.FieldError("email"),
+		state.FieldError(CaptchaFieldName),
 	)
 }
@@ -0,0 +1,23 @@
+package views_test
+
+import (
+	"testing"
+
+	"canvas/form"
+	"canvas/views"
+	"canvas/views/viewstest"
+)
+
+func TestFrontPage(t *testing.T) {
+	t.Run("renders the signup form", func(t *testing.T) {
+		viewstest.MatchGolden(t, "front", views.FrontPage(views.PageData{}, "csrf-token", "timestamp", nil, nil))
+	})
+
+	t.Run("renders the signup form with the values and errors of a failed signup", func(t *testing.T) {
+		state := &form.State{
+			Values: map[string]string{"email": `"><script>alert(1)</script>`},
+			Errors: map[string]string{"email": "That doesn't look like an email address."},
+		}
+		viewstest.MatchGolden(t, "front-invalid", views.FrontPage(views.PageData{}, "csrf-token", "timestamp", nil, state))
+	})
+}
@@ -0,0 +1,230 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// importRefreshSeconds is how often the import page reloads while an import is in progress.
+const importRefreshSeconds = 5
+
+// AdminSubscriberImportsProps for AdminSubscriberImports.
+type AdminSubscriberImportsProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	// Imports, newest first.
+	Imports []model.SubscriberImport
+	// MaxBytes and MaxRows of an uploaded file.
+	MaxBytes int64
+	MaxRows  int
+	// UploadError of the uploaded file, if it couldn't be uploaded.
+	UploadError string
+}
+
+// AdminSubscriberImports page with the form for uploading a CSV file of subscribers to import,
+// and a table of the latest imports.
+func AdminSubscriberImports(props AdminSubscriberImportsProps) g.Node {
+	return AdminPage("Import subscribers", "/admin/subscribers", props.CSRFToken, props.Flashes,
+		P(Class("text-sm text-gray-500 mb-4"),
+			g.Text("Import subscribers from a CSV file with a column for the email addresses, like Email Address, "+
+				"and optionally columns for the locales, like Locale or Language, and tags, like Tags. "+
+				"Mailchimp exports work as they are. "),
+			g.Textf("Files can be up to %v, with up to %v rows. ", FileSize(props.MaxBytes), props.MaxRows),
+			g.Text("Imported subscribers are confirmed, and don't get the confirmation or welcome emails.")),
+
+		FormEl(Action("/admin/subscribers/import"), Method("post"), EncType("multipart/form-data"), Class("mb-8 space-y-2"),
+			// The token has to come before the file, so the file can be streamed.
+			CSRFInput(props.CSRFToken),
+			Label(For("file"), Class("block text-sm font-medium text-gray-700"), g.Text("CSV file")),
+			Div(Class("flex items-center space-x-2"),
+				Input(Type("file"), Name("file"), ID("file"), Accept(".csv,text/csv"), Required(),
+					g.If(props.UploadError != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", "upload-error")})),
+					Class("block text-sm")),
+				Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Upload")),
+			),
+			g.If(props.UploadError != "", P(ID("upload-error"), Class("text-sm text-red-600"), g.Text(props.UploadError))),
+		),
+
+		H2(Class("text-lg font-medium mb-2"), g.Text("Latest imports")),
+		g.If(len(props.Imports) == 0, P(Class("text-gray-500"), g.Text("No imports yet."))),
+		g.If(len(props.Imports) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("File")),
+				Th(Class("text-left py-2"), g.Text("State")),
+				Th(Class("text-left py-2"), g.Text("Rows")),
+				Th(Class("text-left py-2"), g.Text("Added")),
+				Th(Class("text-left py-2"), g.Text("Updated")),
+				Th(Class("text-left py-2"), g.Text("Skipped")),
+				Th(Class("text-left py-2"), g.Text("Failed")),
+				Th(Class("text-left py-2"), g.Text("Created")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Imports, func(i model.SubscriberImport) g.Node {
+					return Tr(ID(fmt.Sprintf("import-%v", i.ID)),
+						Td(Class("py-2 break-all"),
+							A(Href(fmt.Sprintf("/admin/subscribers/import/%v", i.ID)), Class("text-indigo-600 hover:underline"),
+								g.Text(i.Filename))),
+						Td(Class("py-2"), g.Text(string(i.State))),
+						Td(Class("py-2"), g.Text(fmt.Sprint(i.Total))),
+						Td(Class("py-2"), g.Text(fmt.Sprint(i.Added))),
+						Td(Class("py-2"), g.Text(fmt.Sprint(i.Updated))),
+						Td(Class("py-2"), g.Text(fmt.Sprint(i.Skipped))),
+						Td(Class("py-2"), g.Text(fmt.Sprint(i.Failed))),
+						Td(Class("py-2"), g.Text(i.Created.Format("2006-01-02 15:04"))),
+					)
+				})),
+			),
+		)),
+	)
+}
+
+// AdminSubscriberImportProps for AdminSubscriberImport.
+type AdminSubscriberImportProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	Import    model.SubscriberImport
+	// Preview of the first rows of an uploaded file, with their errors.
+	Preview []model.SubscriberImportRow
+}
+
+// AdminSubscriberImport page of an import. Before it's imported, the page previews the first rows of the file
+// with the columns they're read from, and has the form for importing it with what to do with the duplicates.
+// After, it shows the progress, reloading itself every few seconds while the import is in progress,
+// and a failed import can be resumed. Rows that can't be imported are downloadable with their errors.
+func AdminSubscriberImport(props AdminSubscriberImportProps) g.Node {
+	i := props.Import
+	path := fmt.Sprintf("/admin/subscribers/import/%v", i.ID)
+	var head []g.Node
+	if i.InProgress() {
+		head = append(head, Meta(g.Attr("http-equiv", "refresh"), Content(fmt.Sprint(importRefreshSeconds))))
+	}
+
+	return adminPage("Import subscribers", "/admin/subscribers", props.CSRFToken, props.Flashes, head,
+		P(Class("mb-4"), Strong(g.Text(i.Filename)), g.Text(" · "),
+			A(Href("/admin/subscribers/import"), Class("text-indigo-600 hover:underline"), g.Text("All imports"))),
+
+		Dl(ID("import-columns"), Class("grid grid-cols-1 sm:grid-cols-3 gap-4 mb-4 text-sm"),
+			importColumn("Email address", i.Columns.Email),
+			importColumn("Locale", i.Columns.Locale),
+			importColumn("Tags", i.Columns.Tags),
+		),
+
+		g.If(i.State == model.SubscriberImportStateUploaded, importPreview(props)),
+		g.If(i.State != model.SubscriberImportStateUploaded, importProgress(props)),
+
+		g.If(i.Failed > 0, P(ID("import-errors"), Class("mb-4 text-sm"),
+			g.Textf("%v of the %v rows can't be imported. ", i.Failed, i.Total),
+			A(Href(path+"/errors.csv"), Class("text-indigo-600 hover:underline"), g.Text("Download them with their errors")),
+			g.Text(", to fix and upload them again."),
+		)),
+
+		g.If(!i.InProgress(), FormEl(Action(path), Method("post"), Class("mt-8"),
+			g.Attr("data-confirm", "Delete this import? The subscribers it imported stay."),
+			MethodInputs(props.CSRFToken, http.MethodDelete),
+			Button(Type("submit"), Class("text-sm font-medium text-red-600 hover:text-red-900"), g.Text("Delete")),
+		)),
+	)
+}
+
+// importColumn with the header of the column the field is read from, if the file has one.
+func importColumn(field, header string) g.Node {
+	value := g.Text("Not in the file")
+	if header != "" {
+		value = Code(g.Text(header))
+	}
+	return Div(Dt(Class("text-gray-500"), g.Text(field)), Dd(value))
+}
+
+// importPreview of the first rows of an uploaded file, and the form for importing it.
+func importPreview(props AdminSubscriberImportProps) g.Node {
+	i := props.Import
+	return Section(ID("import-preview"), Class("mb-4"),
+		H2(Class("text-lg font-medium mb-2"), g.Textf("Preview of the first %v of %v rows", len(props.Preview), i.Total)),
+		Table(Class("min-w-full divide-y divide-gray-200 text-sm mb-4"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Line")),
+				Th(Class("text-left py-2"), g.Text("Email")),
+				Th(Class("text-left py-2"), g.Text("Locale")),
+				Th(Class("text-left py-2"), g.Text("Tags")),
+				Th(Class("text-left py-2"), g.Text("Error")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Preview, func(row model.SubscriberImportRow) g.Node {
+					tags := make([]string, len(row.Tags))
+					for j, t := range row.Tags {
+						tags[j] = t.String()
+					}
+					return Tr(
+						Td(Class("py-2"), g.Text(fmt.Sprint(row.Line))),
+						Td(Class("py-2 break-all"), g.Text(row.Email.String())),
+						Td(Class("py-2"), g.Text(row.Locale)),
+						Td(Class("py-2 font-mono"), g.Text(strings.Join(tags, " "))),
+						Td(Class("py-2 text-red-700"), g.Text(row.Error)),
+					)
+				})),
+			),
+		),
+
+		FormEl(Action(fmt.Sprintf("/admin/subscribers/import/%v", i.ID)), Method("post"), Class("space-y-4"),
+			CSRFInput(props.CSRFToken),
+			FieldSet(
+				Legend(Class("block text-sm font-medium text-gray-700"), g.Text("Addresses that already have a subscriber")),
+				Label(Class("flex items-center space-x-2 text-sm"),
+					Input(Type("radio"), Name("duplicates"), Value(string(model.SubscriberImportDuplicatesSkip)), g.Attr("checked")),
+					Span(g.Text("Skip them, and leave the subscribers as they are")),
+				),
+				Label(Class("flex items-center space-x-2 text-sm"),
+					Input(Type("radio"), Name("duplicates"), Value(string(model.SubscriberImportDuplicatesUpdate))),
+					Span(g.Text("Update the locale of the subscribers, and add the tags")),
+				),
+			),
+			Button(Type("submit"), Class("rounded-md bg-indigo-600 px-4 py-2 text-white font-medium hover:bg-indigo-700"),
+				g.Textf("Import %v rows", i.Total-i.Failed)),
+		),
+	)
+}
+
+// importProgress with the state and the counts of the import, and the form for resuming it if it failed.
+func importProgress(props AdminSubscriberImportProps) g.Node {
+	i := props.Import
+	return Section(ID("import-progress"), Class("mb-4"),
+		Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4"),
+			dashboardStat("State", string(i.State)),
+			dashboardStat("Added", fmt.Sprint(i.Added)),
+			dashboardStat("Updated", fmt.Sprint(i.Updated)),
+			dashboardStat("Skipped", fmt.Sprint(i.Skipped)),
+			dashboardStat("Failed", fmt.Sprint(i.Failed)),
+			dashboardStat("Total", fmt.Sprint(i.Total)),
+		),
+		Progress(Class("w-full mt-4"), Max(fmt.Sprint(i.Total)), Value(fmt.Sprint(i.Done()))),
+		g.If(i.InProgress(), P(Class("mt-2 text-sm text-gray-500"),
+			g.Textf("Importing is in progress. This page reloads every %v seconds.", importRefreshSeconds))),
+		g.If(i.State == model.SubscriberImportStateFailed, g.Group([]g.Node{
+			P(ID("import-error"), Class("mt-2 text-sm text-red-700"), g.Text("Importing failed: "+i.Error)),
+			FormEl(Action(fmt.Sprintf("/admin/subscribers/import/%v", i.ID)), Method("post"), Class("mt-2"),
+				CSRFInput(props.CSRFToken),
+				Button(Type("submit"), Class("rounded-md bg-indigo-600 px-4 py-2 text-white font-medium hover:bg-indigo-700"),
+					g.Text("Resume importing")),
+			),
+		})),
+	)
+}
+
+// FileSize in bytes, like "20 MB", rounded down to whole megabytes or kilobytes.
+func FileSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%v MB", bytes>>20)
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%v KB", bytes>>10)
+	default:
+		return fmt.Sprintf("%v bytes", bytes)
+	}
+}
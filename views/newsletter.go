@@ -3,13 +3,147 @@ package views
 import (
 	g "github.com/maragudk/gomponents"
 	. "github.com/maragudk/gomponents/html"
+
+	"canvas/form"
+	"canvas/i18n"
 )
 
-func NewsletterThanksPage(path string) g.Node {
+// NewsletterThanksPage after signing up, with how many days the confirmation link is valid.
+func NewsletterThanksPage(t *i18n.Translator, path string, validDays int) g.Node {
+	return Layout(
+		PageData{Title: t.T("thanks.title"), Path: path, Translator: t},
+		H1(g.Text(t.T("thanks.title"))),
+		P(g.Raw(t.T("thanks.check_html")), g.Text(" "+t.N("confirmation.valid", validDays))),
+		P(g.Text(t.T("thanks.resend_prompt")), A(Href("/newsletter/resend"), g.Text(t.T("thanks.resend_link")))),
+	)
+}
+
+// NewsletterResendPage with a form for sending the confirmation email again.
+func NewsletterResendPage(t *i18n.Translator, path, csrfToken string, state *form.State) g.Node {
+	return Layout(
+		PageData{Title: t.T("resend.title"), Path: path, Translator: t},
+		H1(g.Text(t.T("resend.heading"))),
+		P(g.Text(t.T("resend.intro"))),
+		FormEl(Action("/newsletter/resend"), Method("post"), Class("flex items-center max-w-md"),
+			CSRFInput(csrfToken),
+			Label(For("email"), Class("sr-only"), g.Text(t.T("resend.email_label"))),
+			Input(Type("email"), Name("email"), ID("email"), AutoComplete("email"), Required(), Placeholder("me@example.com"),
+				state.Input("email"),
+				Class("focus:ring-gray-500 focus:border-gray-500 block w-full text-sm border-gray-300 rounded-md flex-grow")),
+			Button(Type("submit"), g.Text(t.T("resend.button")),
+				Class("ml-3 inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500 flex-none")),
+		),
+		state.FieldError("email"),
+	)
+}
+
+// NewsletterResentPage after asking for the confirmation email again, with how many days the new link is valid.
+// It's the same whether or not an email was sent, so it doesn't tell who has signed up.
+func NewsletterResentPage(t *i18n.Translator, path string, validDays int) g.Node {
+	return Layout(
+		PageData{Title: t.T("resent.title"), Path: path, Translator: t},
+		H1(g.Text(t.T("resent.title"))),
+		P(g.Text(t.T("resent.body")+" "+t.N("confirmation.valid", validDays))),
+		P(A(Href("/"), g.Text(t.T("common.back_to_front")))),
+	)
+}
+
+// NewsletterConfirmPage with a button to confirm the signup, for when confirming takes two steps.
+func NewsletterConfirmPage(path, csrfToken, token string) g.Node {
+	return Page(
+		"Confirm your signup",
+		path,
+		nil,
+		H1(g.Text(`Confirm your signup`)),
+		P(g.Text(`Just one more click to get the newsletter.`)),
+		FormEl(Action("/newsletter/confirm"), Method("post"),
+			CSRFInput(csrfToken),
+			Input(Type("hidden"), Name("token"), Value(token)),
+			Button(Type("submit"), g.Text("Confirm"),
+				Class("inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500")),
+		),
+	)
+}
+
+// NewsletterConfirmedPage after confirming the signup, or following the link again.
+func NewsletterConfirmedPage(path string, alreadyConfirmed bool) g.Node {
+	if alreadyConfirmed {
+		return Page(
+			"Already confirmed",
+			path,
+			nil,
+			H1(g.Text(`You're already on the list`)),
+			P(g.Text(`Your signup was confirmed earlier, so there's nothing more to do.`)),
+		)
+	}
+	return Page(
+		"Signup confirmed!",
+		path,
+		nil,
+		H1(g.Text(`Signup confirmed!`)),
+		P(g.Raw(`You'll get the next newsletter in your inbox. 🎉`)),
+	)
+}
+
+// NewsletterConfirmFailedPage for confirmation links that are expired, or not valid at all.
+func NewsletterConfirmFailedPage(path string, expired bool) g.Node {
+	if expired {
+		return Page(
+			"Link expired",
+			path,
+			nil,
+			H1(g.Text(`This link has expired`)),
+			P(g.Text(`Confirmation links are only valid for a week. Sign up again to get a new one.`)),
+			P(A(Href("/"), g.Text(`Sign up again`))),
+		)
+	}
+	return Page(
+		"Invalid link",
+		path,
+		nil,
+		H1(g.Text(`This link isn't valid`)),
+		P(g.Text(`Check that you copied the whole link from the email, or sign up again to get a new one.`)),
+		P(A(Href("/"), g.Text(`Sign up again`))),
+	)
+}
+
+// NewsletterUnsubscribePage with a button to unsubscribe, so following the link alone doesn't unsubscribe.
+func NewsletterUnsubscribePage(path, csrfToken, token string) g.Node {
+	return Page(
+		"Unsubscribe",
+		path,
+		nil,
+		H1(g.Text(`Unsubscribe from the newsletter?`)),
+		P(g.Text(`You won't get any more newsletters after this.`)),
+		FormEl(Action("/newsletter/unsubscribe"), Method("post"),
+			CSRFInput(csrfToken),
+			Input(Type("hidden"), Name("token"), Value(token)),
+			Button(Type("submit"), g.Text("Unsubscribe"),
+				Class("inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500")),
+		),
+	)
+}
+
+// NewsletterUnsubscribedPage after unsubscribing, with a way back in.
+func NewsletterUnsubscribedPage(path string) g.Node {
+	return Page(
+		"Unsubscribed",
+		path,
+		nil,
+		H1(g.Text(`You're unsubscribed`)),
+		P(g.Text(`Sorry to see you go. You won't get any more newsletters.`)),
+		P(A(Href("/"), g.Text(`Changed your mind? Sign up again`))),
+	)
+}
+
+// NewsletterUnsubscribeFailedPage for unsubscribe links that aren't valid.
+// It deliberately says nothing about whether the address is subscribed.
+func NewsletterUnsubscribeFailedPage(path string) g.Node {
 	return Page(
-		"Thanks for signing up!",
+		"Invalid link",
 		path,
-		H1(g.Text(`Thanks for signing up!`)),
-		P(g.Raw(`Now check your inbox (or spam folder) for a confirmation link. 😊`)),
+		nil,
+		H1(g.Text(`This link isn't valid`)),
+		P(g.Text(`Check that you copied the whole link from the email.`)),
 	)
 }
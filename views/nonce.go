@@ -0,0 +1,56 @@
+package views
+
+import (
+	"errors"
+	"io"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+)
+
+// StrictNonces makes rendering an inline script or style without a CSP nonce fail, instead of leaving it out.
+// It's for development, so a page missing its nonce is noticed right away instead of quietly not working.
+var StrictNonces bool
+
+// ErrMissingNonce is returned from rendering an inline script or style without a nonce, with StrictNonces.
+var ErrMissingNonce = errors.New("inline script or style without a CSP nonce")
+
+// ScriptSources other than this site that pages load scripts from, for the Content-Security-Policy.
+// The sources of the captcha are included if there is one.
+func ScriptSources(captcha *Captcha) []string {
+	sources := []string{"https://cdn.tailwindcss.com"}
+	if captcha == nil {
+		return sources
+	}
+	switch captcha.Provider {
+	case CaptchaProviderHCaptcha:
+		return append(sources, "https://hcaptcha.com", "https://*.hcaptcha.com")
+	case CaptchaProviderTurnstile:
+		return append(sources, "https://challenges.cloudflare.com")
+	default:
+		return sources
+	}
+}
+
+// InlineScript with the CSP nonce of the request, so the Content-Security-Policy lets it run.
+// Without a nonce, the browser would block it, so it's left out, or fails to render with StrictNonces.
+func InlineScript(nonce, js string) g.Node {
+	return inline(Script, nonce, js)
+}
+
+// InlineStyle with the CSP nonce of the request, like InlineScript.
+func InlineStyle(nonce, css string) g.Node {
+	return inline(StyleEl, nonce, css)
+}
+
+func inline(el func(children ...g.Node) g.Node, nonce, content string) g.Node {
+	if nonce == "" {
+		if StrictNonces {
+			return g.NodeFunc(func(io.Writer) error {
+				return ErrMissingNonce
+			})
+		}
+		return nil
+	}
+	return el(g.Attr("nonce", nonce), g.Raw(content))
+}
@@ -0,0 +1,39 @@
+package views_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/views"
+)
+
+func TestInlineScript(t *testing.T) {
+	t.Run("renders the script with the nonce", func(t *testing.T) {
+		is := is.New(t)
+
+		var b strings.Builder
+		is.NoErr(views.InlineScript("abc", "alert(1)").Render(&b))
+		is.Equal(`<script nonce="abc">alert(1)</script>`, b.String())
+	})
+
+	t.Run("leaves out the script without a nonce", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(nil, views.InlineScript("", "alert(1)"))
+	})
+
+	t.Run("fails to render without a nonce with strict nonces", func(t *testing.T) {
+		is := is.New(t)
+
+		views.StrictNonces = true
+		defer func() { views.StrictNonces = false }()
+
+		var b strings.Builder
+		err := views.InlineStyle("", "p {}").Render(&b)
+		is.True(errors.Is(err, views.ErrMissingNonce))
+		is.Equal("", b.String())
+	})
+}
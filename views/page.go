@@ -1,43 +1,215 @@
 package views
 
 import (
+	"net/url"
+	"strings"
+	"time"
+
 	g "github.com/maragudk/gomponents"
 	"github.com/maragudk/gomponents-heroicons/outline"
 	c "github.com/maragudk/gomponents/components"
 	. "github.com/maragudk/gomponents/html"
+
+	"canvas/assets"
+	"canvas/build"
+	"canvas/flags"
+	"canvas/i18n"
+	"canvas/sessions"
 )
 
-// Page with a title, head, and a basic body layout.
-func Page(title, path string, body ...g.Node) g.Node {
+// PageData for Layout, with what's different between pages. Handlers and views set what they need,
+// and the zero values are left out of the page.
+type PageData struct {
+	Flashes []sessions.Flash
+	// Head has extra nodes for the head, like per-page stylesheets.
+	Head []g.Node
+	// Meta for search engines and link previews, on top of SiteMeta.
+	Meta PageMetaProps
+	// Path of the page, which marks the matching navigation link as active.
+	Path  string
+	Title string
+	// Translator for the text of the layout and the language of the page. Defaults to English.
+	Translator *i18n.Translator
+	// Flags for the features linked to from the layout, like the archive, from flags.FromContext.
+	// Without them, the layout links to all features.
+	Flags flags.Checker
+}
+
+// Layout shared by all public pages, with the head, the navigation bar, the flash messages above the body,
+// and the footer with the build version.
+func Layout(p PageData, body ...g.Node) g.Node {
+	if p.Translator == nil {
+		p.Translator = i18n.Default().Translator(i18n.DefaultLocale)
+	}
+
+	meta := p.Meta.withDefaults(SiteMeta)
+	if meta.Title == "" {
+		meta.Title = p.Title
+	}
+	head := append([]g.Node{PageMeta(meta)}, p.Head...)
+
 	return c.HTML5(c.HTML5Props{
-		Title:    title,
-		Language: "en",
-		Head: []g.Node{
-			Script(Src("https://cdn.tailwindcss.com?plugins=forms,typography")),
-		},
+		Title:    p.Title,
+		Language: p.Translator.Locale(),
+		Head:     PageHead(head...),
 		Body: []g.Node{
-			Navbar(path),
+			Navbar(p.Translator, p.Path, p.Flags == nil || p.Flags(flags.Archive)),
 			Container(true,
+				Flashes(p.Flashes),
 				Prose(g.Group(body)),
 			),
+			PageFooter(),
 		},
 	})
 }
 
-func Navbar(path string) g.Node {
+// Page is the Layout with just a title, path, and flash messages, in English.
+func Page(title, path string, flashes []sessions.Flash, body ...g.Node) g.Node {
+	return Layout(PageData{Title: title, Path: path, Flashes: flashes}, body...)
+}
+
+// PageHead nodes shared by all pages, with the favicons, stylesheets, scripts, and newsletter feeds,
+// followed by the extra nodes.
+func PageHead(extra ...g.Node) []g.Node {
+	return append([]g.Node{
+		Link(Rel("icon"), Href(assets.URL("favicon.ico")), g.Attr("sizes", "any")),
+		Link(Rel("icon"), Type("image/svg+xml"), Href(assets.URL("favicon.svg"))),
+		Script(Src("https://cdn.tailwindcss.com?plugins=forms,typography")),
+		Link(Rel("stylesheet"), Href(assets.URL("app.css"))),
+		Link(Rel("alternate"), Type("application/rss+xml"), TitleAttr("Newsletter (RSS)"), Href("/feed.xml")),
+		Link(Rel("alternate"), Type("application/atom+xml"), TitleAttr("Newsletter (Atom)"), Href("/feed.atom")),
+		Script(Src(assets.URL("app.js")), Defer()),
+	}, extra...)
+}
+
+// TwitterCard type for link previews on Twitter.
+type TwitterCard string
+
+const (
+	TwitterCardSummary           = TwitterCard("summary")
+	TwitterCardSummaryLargeImage = TwitterCard("summary_large_image")
+)
+
+// PageMetaProps for PageMeta, with the metadata of a page for search engines and link previews.
+// Empty fields are left out of the page. All URLs must be absolute, so previews work wherever the link is shared.
+type PageMetaProps struct {
+	CanonicalURL string
+	Description  string
+	// Image for link previews.
+	Image string
+	// PublishedTime of an article. Setting it makes the page an article for OpenGraph.
+	PublishedTime time.Time
+	// Title for link previews. Layout defaults it to the title of the page.
+	Title string
+	// TwitterCard type. Defaults to TwitterCardSummaryLargeImage with an image, and TwitterCardSummary without.
+	TwitterCard TwitterCard
+}
+
+// SiteMeta has the defaults for the PageMetaProps of all pages, for what they don't set themselves.
+// It's set from configuration at startup.
+var SiteMeta PageMetaProps
+
+// withDefaults from d for the empty fields of m.
+func (m PageMetaProps) withDefaults(d PageMetaProps) PageMetaProps {
+	if m.CanonicalURL == "" {
+		m.CanonicalURL = d.CanonicalURL
+	}
+	if m.Description == "" {
+		m.Description = d.Description
+	}
+	if m.Image == "" {
+		m.Image = d.Image
+	}
+	if m.PublishedTime.IsZero() {
+		m.PublishedTime = d.PublishedTime
+	}
+	if m.Title == "" {
+		m.Title = d.Title
+	}
+	if m.TwitterCard == "" {
+		m.TwitterCard = d.TwitterCard
+	}
+	return m
+}
+
+// PageMeta for the head, with the canonical URL, and OpenGraph and Twitter properties for link previews.
+// Empty properties are left out, and so is all of it if there's nothing but a title to preview.
+func PageMeta(m PageMetaProps) g.Node {
+	if m.CanonicalURL == "" && m.Description == "" && m.Image == "" {
+		return nil
+	}
+
+	ogType, publishedTime := "website", ""
+	if !m.PublishedTime.IsZero() {
+		ogType, publishedTime = "article", m.PublishedTime.UTC().Format(time.RFC3339)
+	}
+	card := m.TwitterCard
+	if card == "" {
+		card = TwitterCardSummary
+		if m.Image != "" {
+			card = TwitterCardSummaryLargeImage
+		}
+	}
+
+	property := func(name, content string) g.Node {
+		return g.If(content != "", Meta(g.Attr("property", name), Content(content)))
+	}
+	return g.Group([]g.Node{
+		g.If(m.CanonicalURL != "", Link(Rel("canonical"), Href(m.CanonicalURL))),
+		g.If(m.Description != "", Meta(Name("description"), Content(m.Description))),
+		property("og:title", m.Title),
+		property("og:type", ogType),
+		property("og:url", m.CanonicalURL),
+		property("og:description", m.Description),
+		property("og:image", m.Image),
+		property("og:site_name", "Canvas"),
+		property("article:published_time", publishedTime),
+		Meta(Name("twitter:card"), Content(string(card))),
+	})
+}
+
+// Navbar with the main links, and links to switch to the other languages.
+// The archive link is only there with archive.
+func Navbar(t *i18n.Translator, path string, archive bool) g.Node {
 	return Nav(Class("bg-white shadow"),
 		Container(false,
 			Div(Class("flex items-center space-x-4 h-16"),
 				Div(Class("flex-shrink-0"), outline.Globe(Class("h-6 w-6"))),
-				NavbarLink("/", "Home", path),
+				NavbarLink("/", t.T("nav.home"), path),
+				g.If(archive, NavbarLink("/archive", t.T("nav.archive"), path)),
+				Div(Class("flex-grow")),
+				LanguageLinks(t, path),
 			),
 		),
 	)
 }
 
+// LanguageLinks to switch to each of the other locales of the default catalog, named in their own language.
+// They come back to path afterwards.
+func LanguageLinks(t *i18n.Translator, path string) g.Node {
+	var links []g.Node
+	for _, locale := range i18n.Default().Locales() {
+		if locale == t.Locale() {
+			continue
+		}
+		href := "/locale?" + url.Values{"locale": {locale}, "redirect": {path}}.Encode()
+		links = append(links, A(Href(href), Lang(locale), Class("text-sm text-indigo-500 hover:text-indigo-900"),
+			g.Text(i18n.Default().Translator(locale).T("locale.name"))))
+	}
+	return Div(Class("flex space-x-2"), Aria("label", t.T("nav.language")), g.Group(links))
+}
+
+// PageFooter with the build info, to see what's deployed.
+func PageFooter() g.Node {
+	return Footer(Class("border-t border-gray-200 mt-8"),
+		Container(true, BuildInfo(build.Get())),
+	)
+}
+
+// NavbarLink to path, which is active on the page at path and the pages under it, like "/archive/hello" for "/archive".
 func NavbarLink(path, text, currentPath string) g.Node {
-	active := path == currentPath
-	return A(Href(path), g.Text(text),
+	active := path == currentPath || (path != "/" && strings.HasPrefix(currentPath, path+"/"))
+	return A(Href(path), g.Text(text), g.If(active, Aria("current", "page")),
 		c.Classes{
 			"text-lg font-medium hover:text-indigo-900": true,
 			"text-indigo-700": active,
@@ -0,0 +1,140 @@
+package views_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+	"github.com/matryer/is"
+	"golang.org/x/net/html"
+
+	"canvas/i18n"
+	"canvas/sessions"
+	"canvas/views"
+	"canvas/views/viewstest"
+)
+
+func TestLayout(t *testing.T) {
+	t.Run("renders with just a title and path", func(t *testing.T) {
+		viewstest.MatchGolden(t, "layout-minimal", views.Layout(views.PageData{Title: "Archive", Path: "/archive"},
+			H1(g.Text("Archive")),
+		))
+	})
+
+	t.Run("renders with all optional slots", func(t *testing.T) {
+		viewstest.MatchGolden(t, "layout-full", views.Layout(views.PageData{
+			Flashes: []sessions.Flash{{Level: sessions.FlashSuccess, Message: "Saved!"}},
+			Head:    []g.Node{Link(Rel("stylesheet"), Href("/extra.css"))},
+			Meta: views.PageMetaProps{
+				CanonicalURL:  "https://example.com/archive/hello",
+				Description:   "Hello, world.",
+				Image:         "https://example.com/hello.png",
+				PublishedTime: time.Date(2022, 12, 10, 12, 0, 0, 0, time.UTC),
+			},
+			Path:       "/archive/hello",
+			Title:      "Hello",
+			Translator: i18n.Default().Translator("fr"),
+		},
+			H1(g.Text("Hello")),
+		))
+	})
+}
+
+// headMeta of the rendered page, by the name or property of the meta tags, and the link rels.
+// It also returns the element names in the head, to see that nothing broke out of an attribute.
+func headMeta(t *testing.T, n g.Node) (map[string]string, []string) {
+	t.Helper()
+
+	var b strings.Builder
+	if err := n.Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := html.Parse(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta := map[string]string{}
+	var elements []string
+	var walk func(n *html.Node, inHead bool)
+	walk = func(n *html.Node, inHead bool) {
+		if n.Type == html.ElementNode {
+			attrs := map[string]string{}
+			for _, a := range n.Attr {
+				attrs[a.Key] = a.Val
+			}
+			switch {
+			case n.Data == "meta" && attrs["property"] != "":
+				meta[attrs["property"]] = attrs["content"]
+			case n.Data == "meta" && attrs["name"] != "":
+				meta[attrs["name"]] = attrs["content"]
+			case n.Data == "link" && attrs["rel"] == "canonical":
+				meta["canonical"] = attrs["href"]
+			}
+			if inHead {
+				elements = append(elements, n.Data)
+			}
+			inHead = inHead || n.Data == "head"
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, inHead)
+		}
+	}
+	walk(doc, false)
+	return meta, elements
+}
+
+func TestPageMeta(t *testing.T) {
+	t.Run("escapes adversarial titles and descriptions into well-formed meta tags", func(t *testing.T) {
+		is := is.New(t)
+
+		title := `"><script>alert('title')</script> & <b>bold</b>`
+		meta, elements := headMeta(t, views.Layout(views.PageData{
+			Meta: views.PageMetaProps{
+				CanonicalURL: "https://example.com/archive/hello",
+				Description:  `It's "quoted" </title><script>alert(1)</script>`,
+			},
+			Title: title,
+		}))
+
+		is.Equal(title, meta["og:title"])
+		is.Equal(`It's "quoted" </title><script>alert(1)</script>`, meta["og:description"])
+		is.Equal(meta["og:description"], meta["description"])
+		is.Equal("https://example.com/archive/hello", meta["canonical"])
+		is.Equal("https://example.com/archive/hello", meta["og:url"])
+		is.Equal("summary", meta["twitter:card"])
+		for _, e := range elements {
+			is.True(e != "b")
+		}
+		is.Equal(2, strings.Count(strings.Join(elements, " "), "script"))
+	})
+
+	t.Run("uses the site meta for what the page doesn't set", func(t *testing.T) {
+		is := is.New(t)
+
+		views.SiteMeta = views.PageMetaProps{Description: "A newsletter.", Image: "https://example.com/og.png"}
+		defer func() { views.SiteMeta = views.PageMetaProps{} }()
+
+		meta, _ := headMeta(t, views.Layout(views.PageData{
+			Meta:  views.PageMetaProps{CanonicalURL: "https://example.com/"},
+			Title: "Home",
+		}))
+		is.Equal("A newsletter.", meta["og:description"])
+		is.Equal("https://example.com/og.png", meta["og:image"])
+		is.Equal("summary_large_image", meta["twitter:card"])
+		is.Equal("Home", meta["og:title"])
+		is.Equal("website", meta["og:type"])
+	})
+
+	t.Run("leaves out the meta with nothing to preview", func(t *testing.T) {
+		is := is.New(t)
+
+		meta, _ := headMeta(t, views.Layout(views.PageData{Title: "Home"}))
+		_, ok := meta["og:title"]
+		is.True(!ok)
+		_, ok = meta["twitter:card"]
+		is.True(!ok)
+	})
+}
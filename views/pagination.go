@@ -0,0 +1,116 @@
+package views
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+)
+
+const (
+	// PageAfterParam is the query parameter with the cursor of the last item before the page.
+	PageAfterParam = "after"
+	// PageBeforeParam is the query parameter with the cursor of the first item after the page.
+	PageBeforeParam = "before"
+	// PageSizeParam is the query parameter with the number of items per page.
+	PageSizeParam = "size"
+)
+
+// PaginationProps for Pagination.
+type PaginationProps struct {
+	// Path of the paginated page, like "/admin/subscribers".
+	Path string
+	// Query of the current page. All parameters other than the cursors are kept in the links, like filters.
+	Query url.Values
+	// PreviousCursor for the PageBeforeParam of the previous page, or empty if this is the first page.
+	PreviousCursor string
+	// NextCursor for the PageAfterParam of the next page, or empty if this is the last page.
+	NextCursor string
+	// Total number of items, if known. It's shown if it's more than zero.
+	Total int
+	// PageSize of the current page, one of PageSizes. Without PageSizes, there's no page size selector.
+	PageSize  int
+	PageSizes []int
+}
+
+// Pagination navigation with links to the previous and next pages, and a page size selector.
+// It's nil if there's only one page, so it renders nothing in a parent node.
+func Pagination(props PaginationProps) g.Node {
+	if props.PreviousCursor == "" && props.NextCursor == "" {
+		return nil
+	}
+
+	return Nav(Aria("label", "Pagination"), Class("flex items-center justify-between mt-4 text-sm"),
+		Div(
+			g.If(props.PreviousCursor != "",
+				A(Href(props.pageURL(PageBeforeParam, props.PreviousCursor)), Rel("prev"), g.Text("← Previous"))),
+		),
+		g.If(props.Total > 0, P(Class("text-gray-500"), g.Textf("%v in total", props.Total))),
+		g.If(len(props.PageSizes) > 0, props.pageSizeForm()),
+		Div(
+			g.If(props.NextCursor != "",
+				A(Href(props.pageURL(PageAfterParam, props.NextCursor)), Rel("next"), g.Text("Next →"))),
+		),
+	)
+}
+
+// pageURL with the cursor in the query parameter, and the other parameters of the current page except the cursors.
+func (p PaginationProps) pageURL(param, cursor string) string {
+	v := p.query(PageAfterParam, PageBeforeParam)
+	v.Set(param, cursor)
+	return p.Path + "?" + v.Encode()
+}
+
+// pageSizeForm goes to the first page with the picked page size, keeping the other parameters of the current page.
+func (p PaginationProps) pageSizeForm() g.Node {
+	v := p.query(PageAfterParam, PageBeforeParam, PageSizeParam)
+	var names []string
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var hidden []g.Node
+	for _, name := range names {
+		for _, value := range v[name] {
+			hidden = append(hidden, Input(Type("hidden"), Name(name), Value(value)))
+		}
+	}
+
+	return FormEl(Action(p.Path), Method("get"), Class("flex items-center space-x-2"),
+		g.Group(hidden),
+		Label(For("page-size"), Class("text-gray-500"), g.Text("Per page")),
+		Select(ID("page-size"), Name(PageSizeParam), Class("text-sm border-gray-300 rounded-md"),
+			g.Group(g.Map(p.PageSizes, func(size int) g.Node {
+				return Option(Value(strconv.Itoa(size)), g.If(size == p.PageSize, Selected()), g.Text(strconv.Itoa(size)))
+			})),
+		),
+		Button(Type("submit"), Class("text-gray-700 hover:text-gray-900"), g.Text("Show")),
+	)
+}
+
+// query of the current page without the parameters with the names.
+func (p PaginationProps) query(without ...string) url.Values {
+	v := url.Values{}
+	for name, values := range p.Query {
+		v[name] = append([]string(nil), values...)
+	}
+	for _, name := range without {
+		v.Del(name)
+	}
+	return v
+}
+
+// ParsePageSize from the PageSizeParam query parameter if it's one of the sizes, or the first of the sizes otherwise.
+func ParsePageSize(query url.Values, sizes []int) int {
+	size, err := strconv.Atoi(query.Get(PageSizeParam))
+	if err == nil {
+		for _, s := range sizes {
+			if s == size {
+				return size
+			}
+		}
+	}
+	return sizes[0]
+}
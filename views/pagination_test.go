@@ -0,0 +1,94 @@
+package views_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/maragudk/gomponents/html"
+	"github.com/matryer/is"
+
+	"canvas/views"
+)
+
+// renderPagination in a div, because it's nil for a single page.
+func renderPagination(t *testing.T, props views.PaginationProps) string {
+	t.Helper()
+	var b strings.Builder
+	if err := Div(views.Pagination(props)).Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestPagination(t *testing.T) {
+	t.Run("renders nothing when there's only one page", func(t *testing.T) {
+		is := is.New(t)
+		is.Equal("<div></div>", renderPagination(t, views.PaginationProps{Path: "/things", Total: 3, PageSize: 50, PageSizes: []int{50, 100}}))
+	})
+
+	t.Run("links to the neighbouring pages, keeping other query parameters with special characters", func(t *testing.T) {
+		is := is.New(t)
+
+		body := renderPagination(t, views.PaginationProps{
+			Path:           "/things",
+			Query:          url.Values{"q": {"a&b=c ü"}, "tag": {"x", "y"}, "after": {"old"}, "before": {"old"}},
+			PreviousCursor: "abc",
+			NextCursor:     "d+e/f",
+		})
+		is.True(strings.Contains(body, `<nav aria-label="Pagination"`))
+		is.True(strings.Contains(body, `<a href="/things?before=abc&amp;q=a%26b%3Dc+%C3%BC&amp;tag=x&amp;tag=y" rel="prev">`))
+		is.True(strings.Contains(body, `<a href="/things?after=d%2Be%2Ff&amp;q=a%26b%3Dc+%C3%BC&amp;tag=x&amp;tag=y" rel="next">`))
+		is.True(!strings.Contains(body, "old"))
+		is.True(!strings.Contains(body, "<select"))
+	})
+
+	t.Run("only links to the next page on the first page, and shows the total", func(t *testing.T) {
+		is := is.New(t)
+
+		body := renderPagination(t, views.PaginationProps{Path: "/things", NextCursor: "abc", Total: 120})
+		is.True(!strings.Contains(body, `rel="prev"`))
+		is.True(strings.Contains(body, `<a href="/things?after=abc" rel="next">`))
+		is.True(strings.Contains(body, "120 in total"))
+	})
+
+	t.Run("has a page size selector going to the first page, keeping other query parameters", func(t *testing.T) {
+		is := is.New(t)
+
+		body := renderPagination(t, views.PaginationProps{
+			Path:       "/things",
+			Query:      url.Values{"status": {"pending"}, "size": {"100"}, "after": {"abc"}},
+			NextCursor: "def",
+			PageSize:   100,
+			PageSizes:  []int{50, 100, 200},
+		})
+		is.True(strings.Contains(body, `<form action="/things" method="get"`))
+		is.True(strings.Contains(body, `<input type="hidden" name="status" value="pending">`))
+		is.True(!strings.Contains(body, `name="after"`))
+		is.True(strings.Contains(body, `<option value="50">50</option><option value="100" selected>100</option><option value="200">200</option>`))
+		is.True(strings.Contains(body, `<a href="/things?after=def&amp;size=100&amp;status=pending" rel="next">`))
+	})
+}
+
+func TestParsePageSize(t *testing.T) {
+	sizes := []int{50, 100, 200}
+
+	tests := []struct {
+		size     string
+		expected int
+	}{
+		{"100", 100},
+		{"200", 200},
+		{"", 50},
+		{"75", 50},
+		{"100000", 50},
+		{"-1", 50},
+		{"nope", 50},
+	}
+	for _, test := range tests {
+		t.Run(test.size, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(test.expected, views.ParsePageSize(url.Values{"size": {test.size}}, sizes))
+		})
+	}
+}
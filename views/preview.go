@@ -0,0 +1,54 @@
+package views
+
+import (
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/sessions"
+)
+
+// EmailPreviewBannerProps for EmailPreviewBanner.
+type EmailPreviewBannerProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	From      string
+	// HTMLURL and TextURL of the preview in each format.
+	HTMLURL string
+	// IssueSendURL of the page for sending the issue to all subscribers.
+	IssueSendURL string
+	// SendURL to post the form for sending a test email to.
+	SendURL string
+	Subject string
+	TextURL string
+	To      string
+}
+
+// EmailPreviewBanner shown above a newsletter email preview, and never in a sent email.
+// It's injected into the email HTML, so it's styled inline instead of with the page stylesheets.
+// It has the headers of the email, and a form for sending a test email.
+func EmailPreviewBanner(props EmailPreviewBannerProps) g.Node {
+	return Div(ID("email-preview-banner"), Role("alert"),
+		StyleAttr("font-family: sans-serif; font-size: 14px; background: #fef3c7; color: #78350f; border: 2px solid #f59e0b; padding: 12px; margin-bottom: 16px;"),
+		P(StyleAttr("margin: 0 0 8px;"), Strong(g.Text("Preview. ")),
+			g.Text("This is what subscribers get, but the unsubscribe links have a dummy token and this banner isn't in the email.")),
+		g.Group(g.Map(props.Flashes, func(f sessions.Flash) g.Node {
+			return P(StyleAttr("margin: 0 0 8px; font-weight: bold;"), g.Attr("data-flash", string(f.Level)), g.Text(f.Message))
+		})),
+		P(StyleAttr("margin: 0 0 8px;"),
+			g.Text("From: "+props.From), Br(),
+			g.Text("To: "+props.To), Br(),
+			g.Text("Subject: "+props.Subject),
+		),
+		P(StyleAttr("margin: 0 0 8px;"),
+			A(Href(props.HTMLURL), g.Text("HTML")), g.Text(" · "), A(Href(props.TextURL), g.Text("Text")),
+			g.If(props.IssueSendURL != "", g.Group([]g.Node{g.Text(" · "), A(Href(props.IssueSendURL), g.Text("Send to subscribers"))})),
+		),
+		FormEl(Action(props.SendURL), Method("post"), StyleAttr("margin: 0;"),
+			CSRFInput(props.CSRFToken),
+			Label(For("test-email"), g.Text("Send a test email to ")),
+			Input(Type("email"), Name("email"), ID("test-email"), Required(), AutoComplete("email")),
+			g.Text(" "),
+			Button(Type("submit"), g.Text("Send test")),
+		),
+	)
+}
@@ -0,0 +1,211 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// sendRefreshSeconds is how often the send page reloads while a send is in progress.
+const sendRefreshSeconds = 5
+
+// AdminNewsletterSendProps for AdminNewsletterSend.
+type AdminNewsletterSendProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	// Location is the time zone of the newsletter, which the schedule is entered and shown in.
+	Location   *time.Location
+	Newsletter model.Newsletter
+	// PreviewURL of the email preview of the issue.
+	PreviewURL string
+	// Segment to send the issue to, with SegmentCount confirmed subscribers in it, or SegmentError if it isn't valid.
+	Segment      model.Segment
+	SegmentCount int
+	SegmentError string
+	// ScheduleURL to post the form for scheduling the issue to, and to delete the schedule at.
+	ScheduleURL string
+	// Send of the issue, or nil if it hasn't been sent.
+	Send *model.NewsletterSend
+	// SendURL to post the form for sending the issue to.
+	SendURL string
+	// Tags of the subscribers, to pick the segment from.
+	Tags []model.TagCount
+}
+
+// AdminNewsletterSend page with the progress of sending a newsletter issue to the confirmed subscribers,
+// and a form for sending it. While the send is in progress, there's no form, and the page reloads itself
+// every few seconds to show the progress. Sending a sent issue again needs the force checkbox ticked.
+// Before sending, the segment is picked with a form that reloads the page with it, and shows how many
+// subscribers are in it. A failed send is resumed with the segment it was queued with.
+// Until it's sent, the issue can also be scheduled to be published and sent to the segment later.
+func AdminNewsletterSend(props AdminNewsletterSendProps) g.Node {
+	s := props.Send
+	failed := s != nil && s.State == model.NewsletterSendStateFailed
+	var head []g.Node
+	progress := P(ID("send-state"), Class("text-gray-500"), g.Text("This issue hasn't been sent."))
+	if s == nil && props.Newsletter.ScheduledAt != nil {
+		progress = P(ID("send-state"), Class("text-gray-500"), g.Text("This issue is scheduled, and hasn't been sent."))
+	}
+	if s != nil {
+		progress = sendProgress(*s)
+		if s.InProgress() {
+			head = append(head, Meta(g.Attr("http-equiv", "refresh"), Content(fmt.Sprint(sendRefreshSeconds))))
+		}
+	}
+
+	return adminPage("Send newsletter issue", "/admin", props.CSRFToken, props.Flashes, head,
+		P(Class("mb-4"), Strong(g.Text(props.Newsletter.Title)), g.Text(" · "),
+			A(Href(props.PreviewURL), Class("text-indigo-600 hover:underline"), g.Text("Preview"))),
+
+		progress,
+		g.If(s == nil || !s.InProgress(), g.Group([]g.Node{
+			g.If(!failed, segmentForm(props)),
+			g.If(failed || props.SegmentError == "", sendForm(props)),
+		})),
+		g.If(s == nil && props.SegmentError == "", scheduleForm(props)),
+	)
+}
+
+// scheduleLayout of the times of schedules on the admin pages.
+const scheduleLayout = "Mon 2 Jan 2006 15:04 MST"
+
+// ScheduleTime formatted in the time zone of the newsletter, like "Mon 9 Jan 2023 09:00 CET".
+func ScheduleTime(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format(scheduleLayout)
+}
+
+// segmentForm for picking the segment to send the issue to, with the tags there are,
+// and how many confirmed subscribers are in it.
+func segmentForm(props AdminNewsletterSendProps) g.Node {
+	count := fmt.Sprintf("Confirmed subscribers in the segment: %v.", props.SegmentCount)
+	if props.Segment == "" {
+		count = fmt.Sprintf("Without a segment, the issue goes to all %v confirmed subscribers.", props.SegmentCount)
+	}
+
+	return Section(ID("segment"), Class("mb-8"),
+		FormEl(Action(props.SendURL), Method("get"), Class("space-y-2"),
+			Label(For("segment-input"), Class("block text-sm font-medium text-gray-700"), g.Text("Segment")),
+			Div(Class("flex items-center space-x-2"),
+				Input(Type("text"), Name("segment"), ID("segment-input"), Value(props.Segment.String()),
+					Placeholder("api and (beta or early)"),
+					g.If(props.SegmentError != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", "segment-error")})),
+					Class("block w-full max-w-sm text-sm font-mono border-gray-300 rounded-md")),
+				Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Count subscribers")),
+			),
+		),
+		g.If(props.SegmentError != "", P(ID("segment-error"), Class("text-sm text-red-600 mt-1"), g.Text(props.SegmentError))),
+		g.If(props.SegmentError == "", P(ID("segment-count"), Class("text-sm text-gray-500 mt-1"), g.Text(count))),
+		g.If(len(props.Tags) > 0, P(ID("segment-tags"), Class("text-sm text-gray-500 mt-1"),
+			g.Text("Tags: "),
+			g.Group(g.Map(props.Tags, func(t model.TagCount) g.Node {
+				return Span(Class("mr-2"), Code(g.Text(t.Tag.String())), g.Textf(" (%v)", t.Count))
+			})),
+		)),
+		g.If(len(props.Tags) == 0, P(ID("segment-tags"), Class("text-sm text-gray-500 mt-1"),
+			g.Text("No subscribers have tags yet. Add them on the page of each subscriber."))),
+	)
+}
+
+// sendProgress with the state and the counts of the send.
+func sendProgress(s model.NewsletterSend) g.Node {
+	return Section(ID("send-progress"), Class("mb-8"),
+		Dl(Class("grid grid-cols-2 sm:grid-cols-6 gap-4"),
+			dashboardStat("State", string(s.State)),
+			dashboardStat("Sent", fmt.Sprint(s.Sent)),
+			dashboardStat("Failed", fmt.Sprint(s.Failed)),
+			dashboardStat("Total", fmt.Sprint(s.Total)),
+		),
+		g.If(s.Segment != "", P(ID("send-segment"), Class("mt-2 text-sm text-gray-500"),
+			g.Text("To the subscribers in the segment "), Code(g.Text(s.Segment.String())), g.Text("."))),
+		Progress(Class("w-full mt-4"), Max(fmt.Sprint(s.Total)), Value(fmt.Sprint(s.Sent+s.Failed))),
+		g.If(s.InProgress(), P(Class("mt-2 text-sm text-gray-500"),
+			g.Textf("Sending is in progress. This page reloads every %v seconds.", sendRefreshSeconds))),
+		g.If(s.State == model.NewsletterSendStateFailed, P(ID("send-error"), Class("mt-2 text-sm text-red-700"),
+			g.Text("Sending failed: "+s.Error))),
+	)
+}
+
+// sendForm for sending the issue, resuming a failed send, and sending a completed one again.
+func sendForm(props AdminNewsletterSendProps) g.Node {
+	s := props.Send
+	completed := s != nil && s.State == model.NewsletterSendStateCompleted
+	failed := s != nil && s.State == model.NewsletterSendStateFailed
+
+	text := "Send to all confirmed subscribers"
+	switch {
+	case completed:
+		text = "Send again"
+	case failed:
+		text = "Resume sending"
+	case props.Segment != "":
+		text = "Send to the confirmed subscribers in the segment"
+	}
+
+	return FormEl(Action(props.SendURL), Method("post"), Class("space-y-4"),
+		CSRFInput(props.CSRFToken),
+		g.If(!failed, Input(Type("hidden"), Name("segment"), Value(props.Segment.String()))),
+		g.If(completed, Div(
+			Input(Type("checkbox"), Name("force"), ID("force"), Value("true"), Required()),
+			Label(For("force"), Class("ml-2"),
+				g.Text("This issue has been sent. Send it to every confirmed subscriber in the segment again, including the ones who got it.")),
+		)),
+		Button(Type("submit"), Class("rounded-md bg-indigo-600 px-4 py-2 text-white font-medium hover:bg-indigo-700"),
+			g.Text(text)),
+	)
+}
+
+// scheduleForm for scheduling the issue to be published and sent to the segment later, or changing the schedule,
+// with a form for unscheduling it if it's scheduled.
+func scheduleForm(props AdminNewsletterSendProps) g.Node {
+	loc := props.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	n := props.Newsletter
+	text := "Schedule for all confirmed subscribers"
+	if props.Segment != "" {
+		text = "Schedule for the confirmed subscribers in the segment"
+	}
+	var value string
+	var state g.Node
+	if n.ScheduledAt != nil {
+		value = n.ScheduledAt.In(loc).Format("2006-01-02T15:04")
+		to := g.Text("all confirmed subscribers")
+		if n.ScheduledSegment != "" {
+			to = g.Group([]g.Node{g.Text("the subscribers in the segment "), Code(g.Text(n.ScheduledSegment.String()))})
+		}
+		state = P(ID("schedule-state"), Class("text-sm text-gray-500 mb-2"),
+			g.Text("Scheduled to be published and sent to "), to,
+			g.Text(" on "+ScheduleTime(*n.ScheduledAt, loc)+". It can be edited until then."),
+		)
+	}
+
+	return Section(ID("schedule"), Class("mt-8"),
+		H2(Class("text-lg font-medium mb-2"), g.Text("Schedule")),
+		state,
+		FormEl(Action(props.ScheduleURL), Method("post"), Class("flex items-end space-x-2"),
+			CSRFInput(props.CSRFToken),
+			Input(Type("hidden"), Name("segment"), Value(props.Segment.String())),
+			Div(
+				Label(For("at"), Class("block text-sm font-medium text-gray-700"), g.Text("Time, in "+loc.String())),
+				Input(Type("datetime-local"), Name("at"), ID("at"), Value(value), Required(),
+					Class("block text-sm border-gray-300 rounded-md")),
+			),
+			Button(Type("submit"), Class("text-sm font-medium text-indigo-600 hover:text-indigo-900"),
+				g.Text(text)),
+		),
+		g.If(n.ScheduledAt != nil, FormEl(ID("unschedule"), Action(props.ScheduleURL), Method("post"), Class("mt-2"),
+			MethodInputs(props.CSRFToken, http.MethodDelete),
+			Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Unschedule")),
+		)),
+	)
+}
@@ -0,0 +1,123 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// AdminSubscriberProps for AdminSubscriber.
+type AdminSubscriberProps struct {
+	CSRFToken  string
+	Flashes    []sessions.Flash
+	Subscriber model.Subscriber
+	// Sends to the subscriber's address in the send log, newest first.
+	Sends []model.EmailSend
+	// SendsCapped is true if there are more sends than shown.
+	SendsCapped bool
+	// Tags of the subscriber, ordered by tag.
+	Tags []model.Tag
+}
+
+// AdminSubscriber page with the details of a subscriber, their tags with forms for adding and removing them,
+// and a table of the emails sent to their address, with what the email provider reported about delivering them.
+// It's for finding out why someone didn't get an email.
+func AdminSubscriber(props AdminSubscriberProps) g.Node {
+	s := props.Subscriber
+	confirmed := ""
+	if s.ConfirmedAt != nil {
+		confirmed = s.ConfirmedAt.Format("2006-01-02 15:04")
+	}
+	source := s.Source
+	if source == "" {
+		source = "this site"
+	}
+
+	detail := func(term, description string) g.Node {
+		return Div(Class("flex space-x-2"),
+			Dt(Class("w-32 text-gray-500"), g.Text(term)),
+			Dd(g.Text(description)),
+		)
+	}
+
+	return AdminPage(s.Email.String(), "/admin/subscribers", props.CSRFToken, props.Flashes,
+		Dl(Class("text-sm space-y-1 mb-8"),
+			detail("Status", string(s.Status())),
+			detail("Signed up", s.Created.Format("2006-01-02 15:04")),
+			detail("Confirmed", confirmed),
+			detail("Locale", s.Locale),
+			detail("Source", source),
+		),
+
+		subscriberTags(props),
+
+		H2(Class("text-lg font-medium mb-2"), g.Text("Emails")),
+		g.If(len(props.Sends) == 0, P(Class("text-gray-500"), g.Text("No emails sent to this address."))),
+		g.If(props.SendsCapped, P(ID("sends-capped"), Class("text-sm text-gray-500 mb-2"),
+			g.Textf("Showing the newest %v.", len(props.Sends)))),
+
+		g.If(len(props.Sends) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Time")),
+				Th(Class("text-left py-2"), g.Text("Type")),
+				Th(Class("text-left py-2"), g.Text("Status")),
+				Th(Class("text-left py-2"), g.Text("Delivery")),
+				Th(Class("text-left py-2"), g.Text("Message ID")),
+				Th(Class("text-left py-2"), g.Text("Error")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Sends, func(e model.EmailSend) g.Node {
+					return Tr(ID(fmt.Sprintf("send-%v", e.ID)),
+						Td(Class("py-2"), g.Text(e.Created.Format("2006-01-02 15:04"))),
+						Td(Class("py-2"),
+							g.Text(e.Type),
+							g.If(e.NewsletterID != 0, g.Group([]g.Node{
+								g.Text(" "),
+								A(Href(fmt.Sprintf("/admin/newsletters/%v/preview", e.NewsletterID)),
+									Class("text-indigo-600 hover:text-indigo-900"), g.Textf("#%v", e.NewsletterID)),
+							})),
+							g.If(e.Test, Span(Class("ml-1 text-gray-500"), g.Text("(test)"))),
+						),
+						Td(Class("py-2"), g.Text(string(e.Status))),
+						Td(Class("py-2"), g.Text(string(e.Delivery))),
+						Td(Class("py-2 font-mono"), TitleAttr(e.ProviderMessageID), g.Text(shortHash(e.ProviderMessageID))),
+						Td(Class("py-2"), g.Text(e.Error)),
+					)
+				})),
+			),
+		)),
+	)
+}
+
+// subscriberTags with a form for removing each, and one for adding a tag.
+func subscriberTags(props AdminSubscriberProps) g.Node {
+	tagsURL := fmt.Sprintf("/admin/subscribers/%v/tags", props.Subscriber.ID)
+	return Section(ID("tags"), Class("mb-8"),
+		H2(Class("text-lg font-medium mb-2"), g.Text("Tags")),
+		g.If(len(props.Tags) == 0, P(Class("text-sm text-gray-500 mb-2"), g.Text("No tags."))),
+		g.If(len(props.Tags) > 0, Ul(Class("flex flex-wrap gap-2 mb-2"),
+			g.Group(g.Map(props.Tags, func(t model.Tag) g.Node {
+				return Li(ID("tag-"+t.String()), Class("inline-flex items-center rounded-full bg-gray-100 px-3 py-1 text-sm"),
+					Code(g.Text(t.String())),
+					FormEl(Action(tagsURL+"/"+t.String()), Method("post"), Class("inline ml-2"),
+						MethodInputs(props.CSRFToken, http.MethodDelete),
+						Button(Type("submit"), Class("text-gray-500 hover:text-gray-900"), Aria("label", "Remove "+t.String()),
+							g.Text("×")),
+					),
+				)
+			})),
+		)),
+		FormEl(Action(tagsURL), Method("post"), Class("flex items-center space-x-2"),
+			CSRFInput(props.CSRFToken),
+			Label(For("tag"), Class("sr-only"), g.Text("Tag")),
+			Input(Type("text"), Name("tag"), ID("tag"), Required(), Placeholder("beta"), MaxLength("50"),
+				Class("block w-48 text-sm font-mono border-gray-300 rounded-md")),
+			Button(Type("submit"), Class("text-sm font-medium text-indigo-600 hover:text-indigo-900"), g.Text("Add tag")),
+		),
+	)
+}
@@ -0,0 +1,98 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+)
+
+// AdminSuppressionsProps for AdminSuppressions.
+type AdminSuppressionsProps struct {
+	CSRFToken    string
+	Flashes      []sessions.Flash
+	Suppressions []model.Suppression
+	// Email the list is showing the suppression of, or empty for the newest ones.
+	Email string
+	// EmailError is shown at the search box instead of results if the address isn't valid.
+	EmailError string
+	// Capped is true if there are more suppressions than shown.
+	Capped bool
+}
+
+// AdminSuppressions page with a table of the suppression list, newest first, and a search by email address.
+// Only hashes of the addresses are stored, so the search is for a whole address.
+// Each suppression has a button for removing it, which asks for confirmation first,
+// and there's a form for adding an address by hand.
+func AdminSuppressions(props AdminSuppressionsProps) g.Node {
+	return AdminPage("Suppressions", "/admin/suppressions", props.CSRFToken, props.Flashes,
+		P(Class("text-sm text-gray-500 mb-4"),
+			g.Text("No emails are sent to addresses on the suppression list, not even confirmations or test emails.")),
+
+		FormEl(Action("/admin/suppressions"), Method("get"), Role("search"), Class("mb-4"),
+			Label(For("email"), Class("sr-only"), g.Text("Find by email")),
+			Div(Class("flex space-x-2"),
+				Input(Type("search"), Name("email"), ID("email"), Value(props.Email), Placeholder("Find by email"),
+					g.If(props.EmailError != "", g.Group([]g.Node{Aria("invalid", "true"), Aria("describedby", "email-error")})),
+					Class("block w-full max-w-sm text-sm border-gray-300 rounded-md")),
+				Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Find")),
+			),
+			g.If(props.EmailError != "", P(ID("email-error"), Class("text-sm text-red-600 mt-1"), g.Text(props.EmailError))),
+		),
+
+		g.If(len(props.Suppressions) == 0 && props.Email == "", P(Class("text-gray-500"), g.Text("No suppressions."))),
+		g.If(len(props.Suppressions) == 0 && props.Email != "" && props.EmailError == "",
+			P(Class("text-gray-500"), g.Textf("%v isn't suppressed.", props.Email))),
+		g.If(props.Capped, P(ID("suppressions-capped"), Class("text-sm text-gray-500 mb-2"),
+			g.Textf("Showing the newest %v. Find an address to see whether it's suppressed.", len(props.Suppressions)))),
+
+		g.If(len(props.Suppressions) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Email hash")),
+				Th(Class("text-left py-2"), g.Text("Reason")),
+				Th(Class("text-left py-2"), g.Text("Source")),
+				Th(Class("text-left py-2"), g.Text("Added")),
+				Th(Class("text-left py-2"), g.Text("Actions")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Suppressions, func(s model.Suppression) g.Node {
+					return Tr(ID(fmt.Sprintf("suppression-%v", s.ID)),
+						Td(Class("py-2 font-mono"), TitleAttr(s.EmailHash), g.Text(shortHash(s.EmailHash))),
+						Td(Class("py-2"), g.Text(string(s.Reason))),
+						Td(Class("py-2"), g.Text(s.Source)),
+						Td(Class("py-2"), g.Text(s.Created.Format("2006-01-02"))),
+						Td(Class("py-2"),
+							FormEl(Action(fmt.Sprintf("/admin/suppressions/%v", s.ID)), Method("post"), Class("inline"),
+								g.Attr("data-confirm", "Remove this suppression? The address will be sent emails again."),
+								MethodInputs(props.CSRFToken, http.MethodDelete),
+								Button(Type("submit"), Class("text-indigo-600 hover:text-indigo-900"), g.Text("Remove")),
+							),
+						),
+					)
+				})),
+			),
+		)),
+
+		FormEl(Action("/admin/suppressions"), Method("post"), Class("mt-8 max-w-sm space-y-2"),
+			CSRFInput(props.CSRFToken),
+			Label(For("add-email"), Class("block text-sm font-medium text-gray-700"), g.Text("Suppress an address")),
+			Div(Class("flex space-x-2"),
+				Input(Type("email"), Name("email"), ID("add-email"), Required(), Placeholder("me@example.com"),
+					Class("block w-full text-sm border-gray-300 rounded-md")),
+				Button(Type("submit"), Class("text-sm font-medium text-gray-700 hover:text-gray-900"), g.Text("Suppress")),
+			),
+		),
+	)
+}
+
+// shortHash is the start of a hash, which is enough to tell them apart in the list.
+func shortHash(h string) string {
+	if len(h) <= 12 {
+		return h
+	}
+	return h[:12] + "…"
+}
@@ -0,0 +1,255 @@
+// Package viewstest has golden file testing for rendered HTML, like the views and the emails.
+//
+// The golden files are in testdata of the package under test, and compared in a normalized form, with one element
+// or text per line, so a mismatch shows as a diff of just the lines that changed.
+// Run the tests with the "-update" flag to rewrite the golden files after an intended change:
+//
+//	go test ./views -update
+package viewstest
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	g "github.com/maragudk/gomponents"
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"canvas/build"
+	"canvas/views"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// verbatimElements have their content kept as it is when normalizing, because whitespace matters in them,
+// or because it isn't HTML.
+var verbatimElements = map[string]bool{"pre": true, "textarea": true, "script": true, "style": true}
+
+// textEscaper and attributeEscaper escape just what's needed, so the golden files stay readable.
+var (
+	textEscaper      = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attributeEscaper = strings.NewReplacer("&", "&amp;", `"`, "&quot;", "<", "&lt;", ">", "&gt;")
+)
+
+// MatchGolden renders n, checks that it's valid HTML, and compares it normalized with testdata/name.html.
+// The build info differs between builds, so it's replaced with a placeholder.
+func MatchGolden(t *testing.T, name string, n g.Node) {
+	t.Helper()
+
+	MatchGoldenHTML(t, name, Render(t, n))
+}
+
+// MatchGoldenHTML is MatchGolden for HTML that's already rendered, like the emails.
+func MatchGoldenHTML(t *testing.T, name, s string) {
+	t.Helper()
+
+	if err := Validate(s); err != nil {
+		t.Fatalf("%v is not valid HTML: %v", name, err)
+	}
+	normalized, err := Normalize(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	MatchFile(t, filepath.Join("testdata", name+".html"), normalized)
+}
+
+// MatchFile compares actual with the golden file at path, or rewrites it with the "-update" flag.
+// On a mismatch, the test fails with a diff from the golden file to actual.
+func MatchFile(t *testing.T, path, actual string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading golden file, run the tests with -update to create it: %v", err)
+	}
+	if actual == string(expected) {
+		return
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(actual),
+		FromFile: path,
+		ToFile:   "actual",
+		Context:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("%v doesn't match, run the tests with -update if the change is intended:\n%v", path, diff)
+}
+
+// Render n to a string, with the build info replaced by a placeholder.
+func Render(t *testing.T, n g.Node) string {
+	t.Helper()
+
+	var b, info strings.Builder
+	if err := n.Render(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := views.BuildInfo(build.Get()).Render(&info); err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(b.String(), info.String(), "<!-- build info -->")
+}
+
+// Validate that s is HTML that parses, with every element closed, in the order they were opened.
+// Browsers make do with less, so this catches markup that only works by accident.
+func Validate(s string) error {
+	if _, err := parse(s); err != nil {
+		return err
+	}
+
+	var open []string
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				if len(open) > 0 {
+					return fmt.Errorf("unclosed <%v>", open[len(open)-1])
+				}
+				return nil
+			}
+			return z.Err()
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if !voidElements[string(name)] {
+				open = append(open, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if voidElements[string(name)] {
+				return fmt.Errorf("end tag </%v> of a void element", name)
+			}
+			if len(open) == 0 {
+				return fmt.Errorf("end tag </%v> without a start tag", name)
+			}
+			if last := open[len(open)-1]; last != string(name) {
+				return fmt.Errorf("end tag </%v> where </%v> was expected", name, last)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+}
+
+// Normalize s for comparing, by parsing and printing it again with one element, text, or comment per line,
+// indented by depth. Attributes are sorted by name, and whitespace in text is trimmed and collapsed,
+// except in pre, textarea, script, and style elements, which are kept as they are.
+func Normalize(s string) (string, error) {
+	nodes, err := parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, n := range nodes {
+		if err := write(&b, n, 0); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// parse s as a document if it is one, and as the body otherwise, so fragments don't get a document around them.
+func parse(s string) ([]*html.Node, error) {
+	start := strings.ToLower(strings.TrimSpace(s))
+	if strings.HasPrefix(start, "<!doctype") || strings.HasPrefix(start, "<html") {
+		doc, err := html.Parse(strings.NewReader(s))
+		if err != nil {
+			return nil, err
+		}
+		var nodes []*html.Node
+		for c := doc.FirstChild; c != nil; c = c.NextSibling {
+			nodes = append(nodes, c)
+		}
+		return nodes, nil
+	}
+	return html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+}
+
+// write n and its children to b, normalized, indented by depth.
+func write(b *strings.Builder, n *html.Node, depth int) error {
+	indent := strings.Repeat("  ", depth)
+
+	switch n.Type {
+	case html.DoctypeNode:
+		b.WriteString(indent + "<!doctype " + n.Data + ">\n")
+
+	case html.CommentNode:
+		b.WriteString(indent + "<!--" + n.Data + "-->\n")
+
+	case html.TextNode:
+		if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+			b.WriteString(indent + textEscaper.Replace(text) + "\n")
+		}
+
+	case html.ElementNode:
+		b.WriteString(indent + startTag(n))
+		if voidElements[n.Data] {
+			b.WriteString("\n")
+			return nil
+		}
+		if verbatimElements[n.Data] {
+			var content bytes.Buffer
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				// Script and style are raw text, which html.Render would escape on its own.
+				if c.Type == html.TextNode && (n.Data == "script" || n.Data == "style") {
+					content.WriteString(c.Data)
+					continue
+				}
+				if err := html.Render(&content, c); err != nil {
+					return err
+				}
+			}
+			b.WriteString(content.String() + "</" + n.Data + ">\n")
+			return nil
+		}
+		b.WriteString("\n")
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if err := write(b, c, depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteString(indent + "</" + n.Data + ">\n")
+	}
+	return nil
+}
+
+// startTag of n, with the attributes sorted by name.
+func startTag(n *html.Node) string {
+	attrs := make([]string, 0, len(n.Attr))
+	for _, a := range n.Attr {
+		key := a.Key
+		if a.Namespace != "" {
+			key = a.Namespace + ":" + key
+		}
+		attrs = append(attrs, key+`="`+attributeEscaper.Replace(a.Val)+`"`)
+	}
+	sort.Strings(attrs)
+	if len(attrs) == 0 {
+		return "<" + n.Data + ">"
+	}
+	return "<" + n.Data + " " + strings.Join(attrs, " ") + ">"
+}
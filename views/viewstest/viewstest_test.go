@@ -0,0 +1,59 @@
+package viewstest_test
+
+import (
+	"testing"
+
+	"github.com/matryer/is"
+
+	"canvas/views/viewstest"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		html  string
+		valid bool
+	}{
+		{"a fragment", `<p>Hi <b>you</b>.<br></p>`, true},
+		{"a document", `<!doctype html><html><head><title>a < b</title></head><body><img src="a.png"></body></html>`, true},
+		{"self-closing elements", `<svg><path d="M0 0"/></svg>`, true},
+		{"an unclosed element", `<div><p>Hi</div>`, false},
+		{"an unclosed element at the end", `<div><p>Hi</p>`, false},
+		{"an end tag without a start tag", `<p>Hi</p></div>`, false},
+		{"an end tag of a void element", `<br></br>`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			is := is.New(t)
+			err := viewstest.Validate(test.html)
+			is.Equal(test.valid, err == nil)
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	t.Run("sorts attributes and puts each node on its own line", func(t *testing.T) {
+		is := is.New(t)
+
+		s, err := viewstest.Normalize(`<div id="a" class="b">  Hello,
+			<a title="&quot;you&quot;" href="/?a=1&amp;b=2">you</a> &amp; me<!-- hi --></div>`)
+		is.NoErr(err)
+		is.Equal(`<div class="b" id="a">
+  Hello,
+  <a href="/?a=1&amp;b=2" title="&quot;you&quot;">
+    you
+  </a>
+  &amp; me
+  <!-- hi -->
+</div>
+`, s)
+	})
+
+	t.Run("keeps the content of pre and script as it is", func(t *testing.T) {
+		is := is.New(t)
+
+		s, err := viewstest.Normalize("<pre>  a\n  b &lt;</pre><script>if (a < b) {}</script>")
+		is.NoErr(err)
+		is.Equal("<pre>  a\n  b &lt;</pre>\n<script>if (a < b) {}</script>\n", s)
+	})
+}
@@ -0,0 +1,178 @@
+package views
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	g "github.com/maragudk/gomponents"
+	. "github.com/maragudk/gomponents/html"
+
+	"canvas/model"
+	"canvas/sessions"
+	"canvas/webhook"
+)
+
+// AdminWebhooksProps for AdminWebhooks.
+type AdminWebhooksProps struct {
+	CSRFToken string
+	Flashes   []sessions.Flash
+	Endpoints []model.WebhookEndpoint
+	// Created endpoint, if the page is the response to creating it, which is the only time its secret is shown.
+	Created *model.WebhookEndpoint
+}
+
+// AdminWebhooks page with a table of the webhook endpoints, oldest first, and a form for creating one.
+func AdminWebhooks(props AdminWebhooksProps) g.Node {
+	var created g.Node
+	if props.Created != nil {
+		created = Div(ID("webhook-secret"), Class("rounded-md bg-green-50 p-4 mb-4"),
+			P(Class("text-sm font-medium text-green-800"),
+				g.Textf("Created the endpoint for %v. Copy the secret for verifying the signatures now, it won't be shown again:",
+					props.Created.URL)),
+			Code(Class("block mt-2 font-mono text-sm break-all"), g.Text(props.Created.Secret)),
+		)
+	}
+
+	return AdminPage("Webhooks", "/admin/webhooks", props.CSRFToken, props.Flashes,
+		P(Class("text-sm text-gray-500 mb-4"),
+			g.Text("Webhook endpoints get the events they subscribe to POSTed to them as JSON. "),
+			g.Textf("The requests are signed with the secret of the endpoint, in the %v header, ", webhook.SignatureHeader),
+			g.Textf("as sha256= and the hex HMAC-SHA256 of the %v header, a dot, and the body.", webhook.TimestampHeader)),
+
+		created,
+
+		g.If(len(props.Endpoints) == 0, P(Class("text-gray-500"), g.Text("No webhook endpoints."))),
+
+		g.If(len(props.Endpoints) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("URL")),
+				Th(Class("text-left py-2"), g.Text("Events")),
+				Th(Class("text-left py-2"), g.Text("State")),
+				Th(Class("text-left py-2"), g.Text("Created")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Endpoints, func(e model.WebhookEndpoint) g.Node {
+					return Tr(ID(fmt.Sprintf("webhook-%v", e.ID)),
+						Td(Class("py-2 break-all"),
+							A(Href(fmt.Sprintf("/admin/webhooks/%v", e.ID)), Class("text-indigo-600 hover:underline"), g.Text(e.URL))),
+						Td(Class("py-2 font-mono"), webhookEventTypes(e)),
+						Td(Class("py-2"), g.Text(webhookEndpointState(e))),
+						Td(Class("py-2"), g.Text(e.Created.Format("2006-01-02"))),
+					)
+				})),
+			),
+		)),
+
+		FormEl(Action("/admin/webhooks"), Method("post"), Class("mt-8 max-w-sm space-y-4"),
+			CSRFInput(props.CSRFToken),
+			H2(Class("text-lg font-medium"), g.Text("Create an endpoint")),
+			Div(
+				Label(For("url"), Class("block text-sm font-medium text-gray-700"), g.Text("URL")),
+				Input(Type("url"), Name("url"), ID("url"), Required(), Placeholder("https://example.com/webhooks"),
+					Class("block w-full text-sm border-gray-300 rounded-md")),
+			),
+			FieldSet(
+				Legend(Class("block text-sm font-medium text-gray-700"), g.Text("Events")),
+				g.Group(g.Map(model.WebhookEventTypes, func(t string) g.Node {
+					return Label(Class("flex items-center space-x-2 text-sm font-mono"),
+						Input(Type("checkbox"), Name(t)),
+						Span(g.Text(t)),
+					)
+				})),
+			),
+			Button(Type("submit"), g.Text("Create endpoint"),
+				Class("inline-flex items-center px-4 py-2 border border-gray-300 shadow-sm text-sm font-medium rounded-md text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-indigo-500")),
+		),
+	)
+}
+
+// AdminWebhookProps for AdminWebhook.
+type AdminWebhookProps struct {
+	CSRFToken string
+	// Deliveries to the endpoint, newest first, with their attempts.
+	Deliveries []model.WebhookDelivery
+	Endpoint   model.WebhookEndpoint
+	Flashes    []sessions.Flash
+}
+
+// AdminWebhook page of a webhook endpoint, with buttons for enabling it again if it's disabled and for deleting it,
+// and the delivery log with every attempt of its latest deliveries.
+func AdminWebhook(props AdminWebhookProps) g.Node {
+	e := props.Endpoint
+	path := fmt.Sprintf("/admin/webhooks/%v", e.ID)
+
+	return AdminPage("Webhook endpoint", "/admin/webhooks", props.CSRFToken, props.Flashes,
+		Dl(Class("grid grid-cols-1 sm:grid-cols-4 gap-4 mb-4 text-sm"),
+			Div(Class("sm:col-span-2"), Dt(Class("text-gray-500"), g.Text("URL")), Dd(Class("break-all"), g.Text(e.URL))),
+			Div(Dt(Class("text-gray-500"), g.Text("Events")), Dd(Class("font-mono"), webhookEventTypes(e))),
+			Div(Dt(Class("text-gray-500"), g.Text("State")), Dd(ID("webhook-state"), g.Text(webhookEndpointState(e)))),
+		),
+		g.If(!e.Enabled, Div(Class("mb-4"),
+			P(ID("webhook-disabled"), Class("text-sm text-red-700 mb-2"),
+				g.Text("This endpoint is disabled, and its deliveries are skipped. "+e.DisabledReason)),
+			FormEl(Action(path+"/enable"), Method("post"),
+				CSRFInput(props.CSRFToken),
+				Button(Type("submit"), Class("text-sm font-medium text-indigo-600 hover:text-indigo-900"), g.Text("Enable again")),
+			),
+		)),
+		FormEl(Action(path), Method("post"), Class("mb-8"),
+			g.Attr("data-confirm", "Delete this endpoint? It doesn't get events anymore, and its delivery log is deleted too."),
+			MethodInputs(props.CSRFToken, http.MethodDelete),
+			Button(Type("submit"), Class("text-sm font-medium text-red-600 hover:text-red-900"), g.Text("Delete")),
+		),
+
+		H2(Class("text-lg font-medium mb-2"), g.Text("Delivery log")),
+		g.If(len(props.Deliveries) == 0, P(Class("text-gray-500"), g.Text("No deliveries yet."))),
+		g.If(len(props.Deliveries) > 0, Table(Class("min-w-full divide-y divide-gray-200 text-sm"),
+			THead(Tr(
+				Th(Class("text-left py-2"), g.Text("Created")),
+				Th(Class("text-left py-2"), g.Text("Event")),
+				Th(Class("text-left py-2"), g.Text("State")),
+				Th(Class("text-left py-2"), g.Text("Attempts")),
+			)),
+			TBody(Class("divide-y divide-gray-100"),
+				g.Group(g.Map(props.Deliveries, func(d model.WebhookDelivery) g.Node {
+					return Tr(ID(fmt.Sprintf("webhook-delivery-%v", d.ID)),
+						Td(Class("py-2 align-top"), g.Text(d.Created.Format("2006-01-02 15:04:05"))),
+						Td(Class("py-2 align-top font-mono"), g.Text(d.EventType)),
+						Td(Class("py-2 align-top"), g.Text(string(d.State))),
+						Td(Class("py-2 align-top"), g.Group(g.Map(d.AttemptLog, func(a model.WebhookDeliveryAttempt) g.Node {
+							return Span(Class("block"), g.Text(webhookAttempt(a)))
+						}))),
+					)
+				})),
+			),
+		)),
+	)
+}
+
+// webhookEventTypes of the endpoint, one per line.
+func webhookEventTypes(e model.WebhookEndpoint) g.Node {
+	return g.Group(g.Map(e.EventTypes, func(t string) g.Node {
+		return Span(Class("block"), g.Text(t))
+	}))
+}
+
+// webhookEndpointState of the endpoint, with the deliveries that failed in a row, if any did.
+func webhookEndpointState(e model.WebhookEndpoint) string {
+	switch {
+	case !e.Enabled:
+		return "Disabled"
+	case e.ConsecutiveFailures == 1:
+		return "Enabled, the last delivery failed"
+	case e.ConsecutiveFailures > 1:
+		return fmt.Sprintf("Enabled, the last %v deliveries failed", e.ConsecutiveFailures)
+	default:
+		return "Enabled"
+	}
+}
+
+// webhookAttempt with the time, and the status code, or the error if there was no response.
+func webhookAttempt(a model.WebhookDeliveryAttempt) string {
+	result := fmt.Sprint(a.StatusCode)
+	if a.StatusCode == 0 {
+		result = a.Error
+	}
+	return fmt.Sprintf("%v: %v in %v", a.Created.Format("15:04:05"), result, a.Duration.Round(time.Millisecond))
+}
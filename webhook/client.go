@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for requests to addresses in private networks, like 10.0.0.1 or localhost,
+// so webhook endpoints can't be used to reach the services next to the app, or the cloud metadata endpoint.
+var ErrBlockedAddress = errors.New("address is in a private network")
+
+// blockedNetworks besides the loopback, private, link-local, multicast, and unspecified addresses,
+// which net.IP reports itself.
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"192.88.99.0/24",  // 6to4 relays
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, and the broadcast address
+	"64:ff9b::/96",    // NAT64, which can map to any IPv4 address
+	"100::/64",        // discard
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4, which can map to any IPv4 address
+	"fec0::/10",       // deprecated site-local
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// IsBlockedIP if the IP address is in a private network, or otherwise not a public internet address.
+func IsBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL of a webhook endpoint, which must be an absolute http or https URL without credentials.
+// Unless allowPrivateNetworks, its host mustn't be localhost or a blocked IP address. Host names are only
+// checked when connecting, by the client from NewClient, since what they resolve to can change.
+// The errors are meant to be shown to admins.
+func ValidateURL(rawURL string, allowPrivateNetworks bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Hostname() == "" {
+		return errors.New("must be an http or https URL, like https://example.com/webhooks")
+	}
+	if u.User != nil {
+		return errors.New("must not have a username or password, since the requests are signed")
+	}
+	if u.Fragment != "" {
+		return errors.New("must not have a fragment")
+	}
+	if allowPrivateNetworks {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("must not be localhost")
+	}
+	if ip := net.ParseIP(host); ip != nil && IsBlockedIP(ip) {
+		return errors.New("must not be an address in a private network")
+	}
+	return nil
+}
+
+type NewClientOptions struct {
+	// AllowPrivateNetworks turns off blocking addresses in private networks, like for development.
+	AllowPrivateNetworks bool
+	// Timeout of requests, including reading the response. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// NewClient for webhook requests, which doesn't connect to addresses in private networks,
+// checked after resolving the host name, so a public name that resolves to a private address is blocked too.
+// It doesn't follow redirects, which could point anywhere, and doesn't use a proxy from the environment,
+// which would connect for it.
+func NewClient(opts NewClientOptions) *http.Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsBlockedIP(ip) {
+				return fmt.Errorf("%v: %w", host, ErrBlockedAddress)
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          100,
+			TLSHandshakeTimeout:   opts.Timeout,
+			ResponseHeaderTimeout: opts.Timeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
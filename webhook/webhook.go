@@ -0,0 +1,79 @@
+// Package webhook signs the domain events POSTed to webhook endpoints, and verifies the signatures, like receivers do.
+// Requests have the time they were signed in TimestampHeader, in Unix seconds, and the hex-encoded HMAC-SHA256
+// of the timestamp, a dot, and the body, with the secret of the endpoint, in SignatureHeader, like "sha256=…".
+// Signing the timestamp too means receivers can reject old requests that are replayed.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of webhook requests.
+const (
+	SignatureHeader = "X-Canvas-Signature"
+	TimestampHeader = "X-Canvas-Timestamp"
+	// EventHeader has the event type, like subscriber.confirmed, and DeliveryHeader the ID of the delivery,
+	// which is the same for every attempt, so receivers can skip deliveries they've seen.
+	EventHeader    = "X-Canvas-Event"
+	DeliveryHeader = "X-Canvas-Delivery"
+)
+
+// signaturePrefix of the signature, naming the hash, so another one can be added later.
+const signaturePrefix = "sha256="
+
+// DefaultTolerance for the age of a timestamp in Verify.
+const DefaultTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned by Verify for requests that aren't signed with the secret,
+// or whose timestamp is outside the tolerance.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign the body with the secret and the timestamp, returning the value of SignatureHeader.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+// SetHeaders of the request for the body, signed with the secret at the timestamp.
+func SetHeaders(h http.Header, secret string, timestamp time.Time, body []byte) {
+	h.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	h.Set(SignatureHeader, Sign(secret, timestamp, body))
+}
+
+// Verify the signature of the request with the headers and body, which must be signed with the secret
+// less than the tolerance before or after now. Errors wrap ErrInvalidSignature.
+func Verify(secret string, h http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	timestamp := h.Get(TimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp %q is not a number: %w", timestamp, ErrInvalidSignature)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("timestamp is %v off: %w", age.Round(time.Second), ErrInvalidSignature)
+	}
+
+	signature := h.Get(SignatureHeader)
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("signature is not %v: %w", strings.TrimSuffix(signaturePrefix, "="), ErrInvalidSignature)
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !hmac.Equal(decoded, mac(secret, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
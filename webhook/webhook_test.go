@@ -0,0 +1,62 @@
+package webhook_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"canvas/webhook"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2023, 1, 6, 15, 0, 0, 0, time.UTC)
+	body := []byte(`{"eventType":"subscriber.confirmed"}`)
+
+	signed := func(secret string, at time.Time, body []byte) http.Header {
+		h := http.Header{}
+		webhook.SetHeaders(h, secret, at, body)
+		return h
+	}
+
+	t.Run("verifies a request signed with the secret", func(t *testing.T) {
+		is := is.New(t)
+
+		h := signed("secret", now, body)
+		is.Equal("1673017200", h.Get(webhook.TimestampHeader))
+		is.Equal("sha256=", h.Get(webhook.SignatureHeader)[:7])
+		is.NoErr(webhook.Verify("secret", h, body, now.Add(time.Minute), webhook.DefaultTolerance))
+	})
+
+	t.Run("signs the same as receivers compute it, over the timestamp, a dot, and the body", func(t *testing.T) {
+		is := is.New(t)
+
+		// echo -n '1673017200.{"eventType":"subscriber.confirmed"}' | openssl dgst -sha256 -hmac secret
+		is.Equal("sha256=020499dbc04820c058b796394a6cd68b09cb0df9548e509aef395cb8a73bfd62",
+			webhook.Sign("secret", now, body))
+	})
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+	}{
+		{"another secret", signed("other", now, body), body},
+		{"a changed body", signed("secret", now, body), []byte(`{"eventType":"subscriber.unsubscribed"}`)},
+		{"an old timestamp", signed("secret", now.Add(-6*time.Minute), body), body},
+		{"a timestamp in the future", signed("secret", now.Add(6*time.Minute), body), body},
+		{"no signature", http.Header{webhook.TimestampHeader: {"1673017200"}}, body},
+		{"no timestamp", http.Header{webhook.SignatureHeader: {webhook.Sign("secret", now, body)}}, body},
+		{"another hash", http.Header{webhook.TimestampHeader: {"1673017200"}, webhook.SignatureHeader: {"sha1=abc"}}, body},
+	}
+	for _, test := range tests {
+		t.Run("rejects a request with "+test.name, func(t *testing.T) {
+			is := is.New(t)
+
+			err := webhook.Verify("secret", test.header, test.body, now, webhook.DefaultTolerance)
+			is.True(errors.Is(err, webhook.ErrInvalidSignature))
+		})
+	}
+}